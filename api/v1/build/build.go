@@ -0,0 +1,175 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package build builds the modules of a repository.
+//
+// Modules are built in topological order using the build command of the
+// host platform declared in their specs. Modules without a build command
+// for the host platform are skipped.
+package build
+
+import (
+	"io"
+
+	"github.com/mbtproject/mbt/api/v1/discovery"
+	"github.com/mbtproject/mbt/api/v1/internal/core"
+	"github.com/mbtproject/mbt/api/v1/manifest"
+	"github.com/mbtproject/mbt/lib"
+)
+
+// Stage is a stage in the build of a module.
+type Stage int
+
+const (
+	// StageBefore is the stage before the build command of a module
+	// is executed.
+	StageBefore Stage = iota
+	// StageAfter is the stage after the build command of a module
+	// is executed successfully.
+	StageAfter
+	// StageSkipped is when a module is skipped because it does not
+	// have a build command for the host platform.
+	StageSkipped
+	// StageFailed is when the build command of a module fails.
+	StageFailed
+)
+
+// Options are the options for building modules.
+type Options struct {
+	// Stdin, Stdout and Stderr are the standard streams of the build
+	// commands. Nil streams are not connected.
+	Stdin          io.Reader
+	Stdout, Stderr io.Writer
+	// OnStage is called with each stage of the build of a module
+	// (optional). err is set in StageFailed.
+	OnStage func(mod *manifest.Module, stage Stage, err error)
+	// FailFast stops the build after the first failure.
+	FailFast bool
+	// Env contains additional environment variables (in key=value form)
+	// for the build commands.
+	Env []string
+	// Environment is the name of the environment whose overlays are
+	// merged over the module specs.
+	Environment string
+	// Jobs is the maximum number of modules built concurrently.
+	// Modules are built sequentially when this is less than 2.
+	Jobs int
+	// Sandbox builds each module in a temporary directory containing
+	// just the module and its file dependencies.
+	Sandbox bool
+	// IgnoreProbes builds the modules even if their probes find the
+	// artifacts of their versions.
+	IgnoreProbes bool
+}
+
+// Result is the result of building a module.
+type Result struct {
+	// Module built.
+	Module *manifest.Module
+	// Resumed is set when the build was skipped because the module
+	// was already built at the same version.
+	Resumed bool
+	// Satisfied is set when the build was skipped because the probe of
+	// the module found the artifact of its version.
+	Satisfied bool
+}
+
+// Summary is the summary of a build.
+type Summary struct {
+	// Manifest of the modules selected for the build.
+	Manifest *manifest.Manifest
+	// Completed are the modules built.
+	Completed []*Result
+	// Skipped are the modules without a build command for the host
+	// platform.
+	Skipped []*manifest.Module
+}
+
+// Branch builds the modules in the tip of the specified branch.
+func Branch(repo *discovery.Repository, name string, filter *manifest.Filter, options *Options) (*Summary, error) {
+	return summary(core.SystemOf(repo).BuildBranch(name, core.Filter(filter), cmdOptions(options)))
+}
+
+// Commit builds the modules in the specified commit.
+func Commit(repo *discovery.Repository, sha string, filter *manifest.Filter, options *Options) (*Summary, error) {
+	return summary(core.SystemOf(repo).BuildCommit(sha, core.Filter(filter), cmdOptions(options)))
+}
+
+// Head builds the modules in the commit checked out in the repository.
+func Head(repo *discovery.Repository, filter *manifest.Filter, options *Options) (*Summary, error) {
+	return summary(core.SystemOf(repo).BuildCurrentBranch(core.Filter(filter), cmdOptions(options)))
+}
+
+// Workspace builds the modules in the working directory of the
+// repository.
+func Workspace(repo *discovery.Repository, filter *manifest.Filter, options *Options) (*Summary, error) {
+	return summary(core.SystemOf(repo).BuildWorkspace(core.Filter(filter), cmdOptions(options)))
+}
+
+// Diff builds the modules impacted by the changes between from and to.
+func Diff(repo *discovery.Repository, from, to string, options *Options) (*Summary, error) {
+	return summary(core.SystemOf(repo).BuildDiff(from, to, cmdOptions(options)))
+}
+
+// PR builds the modules impacted by merging branch src into branch dst.
+func PR(repo *discovery.Repository, src, dst string, options *Options) (*Summary, error) {
+	return summary(core.SystemOf(repo).BuildPr(src, dst, cmdOptions(options)))
+}
+
+func cmdOptions(options *Options) *lib.CmdOptions {
+	if options == nil {
+		options = &Options{}
+	}
+	o := &lib.CmdOptions{
+		Stdin:        options.Stdin,
+		Stdout:       options.Stdout,
+		Stderr:       options.Stderr,
+		FailFast:     options.FailFast,
+		Env:          options.Env,
+		Environment:  options.Environment,
+		Jobs:         options.Jobs,
+		Sandbox:      options.Sandbox,
+		IgnoreProbes: options.IgnoreProbes,
+		Callback:     func(*lib.Module, lib.CmdStage, error) {},
+	}
+	if options.OnStage != nil {
+		o.Callback = func(mod *lib.Module, s lib.CmdStage, err error) {
+			options.OnStage(core.Module(mod), stages[s], err)
+		}
+	}
+	return o
+}
+
+var stages = map[lib.CmdStage]Stage{
+	lib.CmdStageBeforeBuild: StageBefore,
+	lib.CmdStageAfterBuild:  StageAfter,
+	lib.CmdStageSkipBuild:   StageSkipped,
+	lib.CmdStageFailedBuild: StageFailed,
+}
+
+func summary(s *lib.BuildSummary, err error) (*Summary, error) {
+	if err != nil {
+		return nil, err
+	}
+	r := &Summary{
+		Manifest:  core.Manifest(s.Manifest),
+		Completed: make([]*Result, 0, len(s.Completed)),
+		Skipped:   core.Modules(s.Skipped),
+	}
+	for _, c := range s.Completed {
+		r.Completed = append(r.Completed, &Result{Module: core.Module(c.Module), Resumed: c.Resumed, Satisfied: c.Satisfied})
+	}
+	return r, nil
+}
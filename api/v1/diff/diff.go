@@ -0,0 +1,57 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package diff selects the modules impacted by the changes between two
+// revisions of a repository.
+//
+// A module is impacted if its content, a file dependency or one of its
+// dependencies changed. Manifests returned by this package contain the
+// impacted modules and their dependents.
+package diff
+
+import (
+	"github.com/mbtproject/mbt/api/v1/discovery"
+	"github.com/mbtproject/mbt/api/v1/internal/core"
+	"github.com/mbtproject/mbt/api/v1/manifest"
+)
+
+// Between returns the modules impacted by the changes between from
+// and to. Both arguments are commit shas.
+func Between(repo *discovery.Repository, from, to string) (*manifest.Manifest, error) {
+	m, err := core.SystemOf(repo).ManifestByDiff(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return core.Manifest(m), nil
+}
+
+// PR returns the modules impacted by merging branch src into branch dst.
+func PR(repo *discovery.Repository, src, dst string) (*manifest.Manifest, error) {
+	m, err := core.SystemOf(repo).ManifestByPr(src, dst)
+	if err != nil {
+		return nil, err
+	}
+	return core.Manifest(m), nil
+}
+
+// Intersection returns the modules impacted by the changes in both
+// commits since their merge base.
+func Intersection(repo *discovery.Repository, first, second string) ([]*manifest.Module, error) {
+	mods, err := core.SystemOf(repo).IntersectionByCommit(first, second)
+	if err != nil {
+		return nil, err
+	}
+	return core.Modules(mods), nil
+}
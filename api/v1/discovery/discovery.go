@@ -0,0 +1,121 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package discovery opens repositories and discovers the modules in
+// them.
+//
+//	repo, err := discovery.Open("/path/to/repo", nil)
+//	if err != nil {
+//	  return err
+//	}
+//	m, err := discovery.Head(repo, nil)
+//
+// Manifests returned by this package contain the modules in a revision
+// optionally narrowed by a manifest.Filter. Use package diff to select
+// the modules changed between revisions.
+package discovery
+
+import (
+	"github.com/mbtproject/mbt/api/v1/internal/core"
+	"github.com/mbtproject/mbt/api/v1/manifest"
+	"github.com/mbtproject/mbt/lib"
+)
+
+// Repository is a git repository opened for discovery. It is not safe
+// for concurrent use.
+type Repository = core.Repository
+
+// Log levels accepted by OpenOptions.
+const (
+	// LogLevelNormal logs errors and warnings.
+	LogLevelNormal = lib.LogLevelNormal
+	// LogLevelDebug logs everything.
+	LogLevelDebug = lib.LogLevelDebug
+)
+
+// OpenOptions are the options for opening a repository.
+type OpenOptions struct {
+	// LogLevel is either LogLevelNormal (default) or LogLevelDebug.
+	LogLevel int
+}
+
+// Open opens the git repository in the specified directory.
+// Options can be nil.
+func Open(dir string, options *OpenOptions) (*Repository, error) {
+	if options == nil {
+		options = &OpenOptions{}
+	}
+	system, err := lib.NewSystem(dir, options.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	return core.NewRepository(dir, system), nil
+}
+
+// Branch returns the modules in the tip of the specified branch.
+func Branch(repo *Repository, name string, filter *manifest.Filter) (*manifest.Manifest, error) {
+	m, err := core.SystemOf(repo).ManifestByBranch(name)
+	if err != nil {
+		return nil, err
+	}
+	return applyFilter(m, filter)
+}
+
+// Commit returns the modules in the specified commit. Commit can be
+// a full or short sha.
+func Commit(repo *Repository, sha string, filter *manifest.Filter) (*manifest.Manifest, error) {
+	m, err := core.SystemOf(repo).ManifestByCommit(sha)
+	if err != nil {
+		return nil, err
+	}
+	return applyFilter(m, filter)
+}
+
+// Head returns the modules in the commit checked out in the repository.
+func Head(repo *Repository, filter *manifest.Filter) (*manifest.Manifest, error) {
+	m, err := core.SystemOf(repo).ManifestByCurrentBranch()
+	if err != nil {
+		return nil, err
+	}
+	return applyFilter(m, filter)
+}
+
+// Workspace returns the modules in the working directory of the
+// repository, including the changes not committed yet.
+func Workspace(repo *Repository, filter *manifest.Filter) (*manifest.Manifest, error) {
+	m, err := core.SystemOf(repo).ManifestByWorkspace()
+	if err != nil {
+		return nil, err
+	}
+	return applyFilter(m, filter)
+}
+
+// WorkspaceChanges returns the modules impacted by the changes in the
+// working directory that are not committed yet.
+func WorkspaceChanges(repo *Repository) (*manifest.Manifest, error) {
+	m, err := core.SystemOf(repo).ManifestByWorkspaceChanges()
+	if err != nil {
+		return nil, err
+	}
+	return core.Manifest(m), nil
+}
+
+func applyFilter(m *lib.Manifest, filter *manifest.Filter) (*manifest.Manifest, error) {
+	m, err := m.ApplyFilters(core.Filter(filter))
+	if err != nil {
+		return nil, err
+	}
+	return core.Manifest(m), nil
+}
@@ -0,0 +1,34 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package v1 is the root of the stable Go API of mbt.
+//
+// Programs embedding mbt should import the packages below this one
+// instead of lib, which is shaped around the needs of the command line
+// interface and may change between releases:
+//
+//	discovery  opens a repository and discovers its modules
+//	manifest   describes the modules and their dependencies
+//	diff       selects the modules changed between two revisions
+//	build      builds the modules in a manifest
+//	render     renders templates over a manifest
+//	mbttest    asserts the rules of the module graph in tests
+//
+// Packages of this version only change in backwards compatible ways.
+// Breaking changes are introduced in a new version (api/v2).
+package v1
+
+// Version is the version of the api implemented by this package tree.
+const Version = "v1"
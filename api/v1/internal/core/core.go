@@ -0,0 +1,105 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package core holds the state shared by the packages of api/v1 and the
+// conversions between their types and the types in lib.
+package core
+
+import (
+	"github.com/mbtproject/mbt/api/v1/manifest"
+	"github.com/mbtproject/mbt/lib"
+)
+
+// Repository is a repository opened with discovery.Open.
+type Repository struct {
+	dir    string
+	system lib.System
+}
+
+// NewRepository creates a Repository backed by the specified system.
+func NewRepository(dir string, system lib.System) *Repository {
+	return &Repository{dir: dir, system: system}
+}
+
+// Dir returns the directory the repository was opened in.
+func (r *Repository) Dir() string {
+	return r.dir
+}
+
+// SystemOf returns the system backing the repository.
+func SystemOf(r *Repository) lib.System {
+	return r.system
+}
+
+// Filter converts a manifest.Filter to lib.FilterOptions.
+// A nil filter selects all modules.
+func Filter(f *manifest.Filter) *lib.FilterOptions {
+	if f == nil {
+		return lib.NoFilter
+	}
+	return &lib.FilterOptions{
+		Name:       f.Name,
+		Fuzzy:      f.Fuzzy,
+		Dependents: f.Dependents,
+		Query:      f.Query,
+	}
+}
+
+// Manifest converts a lib.Manifest to a manifest.Manifest.
+func Manifest(m *lib.Manifest) *manifest.Manifest {
+	if m == nil {
+		return nil
+	}
+	return &manifest.Manifest{
+		Dir:     m.Dir,
+		Sha:     m.Sha,
+		Modules: Modules(m.Modules),
+	}
+}
+
+// Modules converts lib.Modules to a list of manifest.Module.
+func Modules(mods lib.Modules) []*manifest.Module {
+	r := make([]*manifest.Module, 0, len(mods))
+	for _, m := range mods {
+		r = append(r, Module(m))
+	}
+	return r
+}
+
+// Module converts a lib.Module to a manifest.Module.
+func Module(m *lib.Module) *manifest.Module {
+	if m == nil {
+		return nil
+	}
+	return &manifest.Module{
+		Name:             m.Name(),
+		Path:             m.Path(),
+		Version:          m.Version(),
+		Hash:             m.Hash(),
+		Dependencies:     names(m.Requires()),
+		Dependents:       names(m.RequiredBy()),
+		FileDependencies: append([]string{}, m.FileDependencies()...),
+		Properties:       m.Properties(),
+		Owners:           append([]string{}, m.Owners()...),
+	}
+}
+
+func names(mods lib.Modules) []string {
+	r := make([]string, 0, len(mods))
+	for _, m := range mods {
+		r = append(r, m.Name())
+	}
+	return r
+}
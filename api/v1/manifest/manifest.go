@@ -0,0 +1,93 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package manifest describes the modules of a repository at a point in
+// time and the dependencies between them.
+//
+// Values in this package are plain data, detached from the repository
+// they were discovered in. They are safe to retain and share.
+package manifest
+
+// Module is a module in a manifest.
+type Module struct {
+	// Name of the module.
+	Name string
+	// Path of the module directory relative to the repository root.
+	Path string
+	// Version of the module. It changes whenever the content of the
+	// module, its file dependencies or its dependencies change.
+	Version string
+	// Hash of the module content in git.
+	Hash string
+	// Dependencies are the names of the modules this module depends on.
+	Dependencies []string
+	// Dependents are the names of the modules depending on this module.
+	Dependents []string
+	// FileDependencies are the paths, outside the module directory,
+	// the module depends on.
+	FileDependencies []string
+	// Properties are the user defined properties in the module spec.
+	Properties map[string]interface{}
+	// Owners are the owners (e.g. teams) of the module.
+	Owners []string
+}
+
+// Manifest is the set of modules selected for a revision of a
+// repository, in topological order (dependencies before dependents).
+type Manifest struct {
+	// Dir is the root directory of the repository.
+	Dir string
+	// Sha is the commit the manifest was created for. It is empty for
+	// the manifests of the workspace.
+	Sha string
+	// Modules in the manifest.
+	Modules []*Module
+}
+
+// Module returns the module with the specified name or nil if the
+// manifest does not contain it.
+func (m *Manifest) Module(name string) *Module {
+	for _, mod := range m.Modules {
+		if mod.Name == name {
+			return mod
+		}
+	}
+	return nil
+}
+
+// Names returns the names of the modules in the manifest.
+func (m *Manifest) Names() []string {
+	names := make([]string, 0, len(m.Modules))
+	for _, mod := range m.Modules {
+		names = append(names, mod.Name)
+	}
+	return names
+}
+
+// Filter selects a subset of the modules in a manifest.
+type Filter struct {
+	// Name of the module to select. Comma separated list of names
+	// selects multiple modules.
+	Name string
+	// Fuzzy matches Name against the module names as a subsequence
+	// instead of an exact match.
+	Fuzzy bool
+	// Dependents includes the modules depending on the selected modules.
+	Dependents bool
+	// Query is an expression selecting modules
+	// (e.g. name =~ "^svc-" && "backend" in tags).
+	// It is applied after the name filter.
+	Query string
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mbttest asserts the rules of the module graph of a repository
+// in ordinary Go tests, so that architecture rules are enforced with
+// the rest of the test suite.
+//
+//	func TestArchitecture(t *testing.T) {
+//		mbttest.AssertNoCycles(t, ".")
+//		mbttest.AssertNoDependency(t, "frontend/*", "db/*")
+//		mbttest.AssertAllModulesHaveOwners(t)
+//	}
+//
+// Assertions are evaluated over the modules in the workspace, including
+// the changes not committed yet. Package level assertions other than
+// AssertNoCycles use the repository containing the working directory of
+// the test. Use In to assert the rules of another repository.
+package mbttest
+
+import (
+	"path"
+	"strings"
+
+	"github.com/mbtproject/mbt/api/v1/discovery"
+	"github.com/mbtproject/mbt/api/v1/manifest"
+	"github.com/mbtproject/mbt/lib"
+)
+
+// T is the subset of testing.TB used by the assertions.
+type T interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Repo is a repository whose module graph is asserted.
+type Repo struct {
+	dir string
+}
+
+// In returns the repository containing the specified directory.
+func In(dir string) *Repo {
+	return &Repo{dir: dir}
+}
+
+// AssertNoCycles asserts that the modules in the repository containing
+// dir do not have cyclic dependencies.
+func AssertNoCycles(t T, dir string) bool {
+	t.Helper()
+	return In(dir).AssertNoCycles(t)
+}
+
+// AssertNoDependency asserts that the modules matching from do not
+// depend on the modules matching to in the repository containing the
+// working directory.
+func AssertNoDependency(t T, from, to string) bool {
+	t.Helper()
+	return In(".").AssertNoDependency(t, from, to)
+}
+
+// AssertAllModulesHaveOwners asserts that the modules in the repository
+// containing the working directory have owners, except the ones
+// matching the patterns in except.
+func AssertAllModulesHaveOwners(t T, except ...string) bool {
+	t.Helper()
+	return In(".").AssertAllModulesHaveOwners(t, except...)
+}
+
+// AssertNoCycles asserts that the modules in the repository do not have
+// cyclic dependencies.
+func (r *Repo) AssertNoCycles(t T) bool {
+	t.Helper()
+	_, ok := r.manifest(t)
+	return ok
+}
+
+// AssertNoDependency asserts that the modules matching from do not
+// depend on the modules matching to, either directly or through other
+// modules. Patterns are matched against the path and the name of a
+// module with the syntax of path.Match (e.g. frontend/*).
+func (r *Repo) AssertNoDependency(t T, from, to string) bool {
+	t.Helper()
+	m, ok := r.manifest(t)
+	if !ok {
+		return false
+	}
+
+	ok = true
+	for _, mod := range m.Modules {
+		if !matches(mod, from) {
+			continue
+		}
+
+		if chain := dependencyChain(m, mod, to); chain != nil {
+			t.Errorf("mbttest: %v must not depend on %v: %v", from, to, strings.Join(chain, " -> "))
+			ok = false
+		}
+	}
+	return ok
+}
+
+// AssertAllModulesHaveOwners asserts that the modules in the repository
+// have owners, except the ones matching the patterns in except.
+func (r *Repo) AssertAllModulesHaveOwners(t T, except ...string) bool {
+	t.Helper()
+	m, ok := r.manifest(t)
+	if !ok {
+		return false
+	}
+
+	missing := make([]string, 0)
+	for _, mod := range m.Modules {
+		if len(mod.Owners) == 0 && !matchesAny(mod, except) {
+			missing = append(missing, mod.Name)
+		}
+	}
+
+	if len(missing) > 0 {
+		t.Errorf("mbttest: modules without owners: %v", strings.Join(missing, ", "))
+		return false
+	}
+	return true
+}
+
+// manifest discovers the modules in the workspace of the repository.
+// Discovery fails when the modules have cyclic dependencies.
+func (r *Repo) manifest(t T) (*manifest.Manifest, bool) {
+	t.Helper()
+	root, err := lib.GitRepoRoot(r.dir)
+	if err != nil {
+		t.Errorf("mbttest: %v", err)
+		return nil, false
+	}
+
+	repo, err := discovery.Open(root, nil)
+	if err != nil {
+		t.Errorf("mbttest: %v", err)
+		return nil, false
+	}
+
+	m, err := discovery.Workspace(repo, nil)
+	if err != nil {
+		t.Errorf("mbttest: %v", err)
+		return nil, false
+	}
+	return m, true
+}
+
+// dependencyChain returns the names of the modules from mod to the
+// first dependency matching pattern or nil if there is not one.
+func dependencyChain(m *manifest.Manifest, mod *manifest.Module, pattern string) []string {
+	parents := map[string]string{mod.Name: ""}
+	queue := []*manifest.Module{mod}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, name := range current.Dependencies {
+			if _, ok := parents[name]; ok {
+				continue
+			}
+			parents[name] = current.Name
+
+			dep := m.Module(name)
+			if dep == nil {
+				continue
+			}
+
+			if matches(dep, pattern) {
+				chain := []string{name}
+				for p := parents[name]; p != ""; p = parents[p] {
+					chain = append([]string{p}, chain...)
+				}
+				return chain
+			}
+			queue = append(queue, dep)
+		}
+	}
+	return nil
+}
+
+func matches(mod *manifest.Module, pattern string) bool {
+	for _, s := range []string{mod.Path, mod.Name} {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(mod *manifest.Module, patterns []string) bool {
+	for _, p := range patterns {
+		if matches(mod, p) {
+			return true
+		}
+	}
+	return false
+}
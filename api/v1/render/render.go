@@ -0,0 +1,124 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package render renders templates over the modules of a repository.
+//
+// Templates have access to the manifest of the revision they are
+// rendered for. See the documentation of mbt apply for the data and
+// functions available to templates.
+package render
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/mbtproject/mbt/api/v1/discovery"
+	"github.com/mbtproject/mbt/api/v1/internal/core"
+	"github.com/mbtproject/mbt/api/v1/manifest"
+	"github.com/mbtproject/mbt/lib"
+)
+
+// Options are the options for rendering a template.
+type Options struct {
+	// Output is the writer receiving the rendered template.
+	// It is ignored when OutputFor is specified.
+	Output io.Writer
+	// OutputFor creates the writer for the output of the template.
+	// mod is the module the template is rendered for or nil if
+	// SplitPerModule is not set.
+	OutputFor func(mod *manifest.Module) (io.WriteCloser, error)
+	// SplitPerModule renders the template once for each module
+	// selected by Filter.
+	SplitPerModule bool
+	// Filter selects the modules the template is rendered for when
+	// SplitPerModule is set. All modules are selected if it is nil.
+	Filter *manifest.Filter
+	// Engine used to render the template (go, jsonnet or cue).
+	// Defaults to the engine associated with the extension of the
+	// template or go.
+	Engine string
+	// Template is read from this reader instead of the template path
+	// when specified.
+	Template io.Reader
+	// Environment is the name of the environment whose overlays are
+	// merged over the module specs.
+	Environment string
+	// Strict fails rendering of go templates referencing missing keys,
+	// modules or module properties.
+	Strict bool
+}
+
+// Branch renders the template at templatePath in the tip of the
+// specified branch.
+func Branch(repo *discovery.Repository, templatePath, name string, options *Options) error {
+	return core.SystemOf(repo).ApplyBranchWithOptions(templatePath, name, applyOptions(options))
+}
+
+// Commit renders the template at templatePath in the specified commit.
+func Commit(repo *discovery.Repository, templatePath, sha string, options *Options) error {
+	return core.SystemOf(repo).ApplyCommitWithOptions(sha, templatePath, applyOptions(options))
+}
+
+// Head renders the template at templatePath in the commit checked out
+// in the repository.
+func Head(repo *discovery.Repository, templatePath string, options *Options) error {
+	return core.SystemOf(repo).ApplyHeadWithOptions(templatePath, applyOptions(options))
+}
+
+// Workspace renders the template at templatePath in the working
+// directory of the repository.
+func Workspace(repo *discovery.Repository, templatePath string, options *Options) error {
+	return core.SystemOf(repo).ApplyLocalWithOptions(templatePath, applyOptions(options))
+}
+
+func applyOptions(options *Options) *lib.ApplyOptions {
+	if options == nil {
+		options = &Options{}
+	}
+	o := &lib.ApplyOptions{
+		SplitPerModule: options.SplitPerModule,
+		Engine:         options.Engine,
+		Template:       options.Template,
+		Environment:    options.Environment,
+		Strict:         options.Strict,
+	}
+	if options.Filter != nil {
+		o.Filter = core.Filter(options.Filter)
+	}
+	switch {
+	case options.OutputFor != nil:
+		o.Output = func(mod *lib.Module) (io.WriteCloser, error) {
+			return options.OutputFor(core.Module(mod))
+		}
+	case options.Output != nil:
+		w := options.Output
+		o.Output = func(*lib.Module) (io.WriteCloser, error) {
+			return nopCloser{w}, nil
+		}
+	default:
+		o.Output = func(*lib.Module) (io.WriteCloser, error) {
+			return nopCloser{ioutil.Discard}, nil
+		}
+	}
+	return o
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
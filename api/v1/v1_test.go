@@ -0,0 +1,272 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	git "github.com/libgit2/git2go"
+	"github.com/mbtproject/mbt/api/v1/build"
+	"github.com/mbtproject/mbt/api/v1/diff"
+	"github.com/mbtproject/mbt/api/v1/discovery"
+	"github.com/mbtproject/mbt/api/v1/manifest"
+	"github.com/mbtproject/mbt/api/v1/mbttest"
+	"github.com/mbtproject/mbt/api/v1/render"
+	"github.com/stretchr/testify/assert"
+)
+
+const testDir = ".tmp/repo"
+
+type testRepo struct {
+	t    *testing.T
+	repo *git.Repository
+	last *git.Oid
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	os.RemoveAll(".tmp")
+	repo, err := git.InitRepository(testDir, false)
+	check(t, err)
+	return &testRepo{t: t, repo: repo}
+}
+
+func (r *testRepo) write(p, content string) {
+	p = filepath.Join(testDir, p)
+	check(r.t, os.MkdirAll(filepath.Dir(p), 0755))
+	check(r.t, ioutil.WriteFile(p, []byte(content), 0744))
+}
+
+func (r *testRepo) module(name string, deps ...string) {
+	spec := "name: " + name + "\nbuild:\n  linux:\n    cmd: ./build.sh\n  darwin:\n    cmd: ./build.sh\nproperties:\n  owner: " + name + "-team\n"
+	if len(deps) > 0 {
+		spec += "dependencies:\n"
+		for _, d := range deps {
+			spec += "  - " + d + "\n"
+		}
+	}
+	r.write(name+"/.mbt.yml", spec)
+	r.write(name+"/build.sh", "#!/bin/sh\necho building "+name+"\n")
+}
+
+func (r *testRepo) commit(message string) string {
+	idx, err := r.repo.Index()
+	check(r.t, err)
+	check(r.t, idx.AddAll([]string{"."}, git.IndexAddCheckPathspec, nil))
+	check(r.t, idx.Write())
+	oid, err := idx.WriteTree()
+	check(r.t, err)
+	tree, err := r.repo.LookupTree(oid)
+	check(r.t, err)
+
+	sig := &git.Signature{Email: "alice@wonderland.com", Name: "alice", When: time.Now()}
+	parents := []*git.Commit{}
+	if r.last != nil {
+		c, err := r.repo.LookupCommit(r.last)
+		check(r.t, err)
+		parents = append(parents, c)
+	}
+	r.last, err = r.repo.CreateCommit("HEAD", sig, sig, message, tree, parents...)
+	check(r.t, err)
+	return r.last.String()
+}
+
+func check(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	defer os.RemoveAll(".tmp")
+	r := newTestRepo(t)
+	r.module("app-a")
+	r.module("app-b", "app-a")
+	sha := r.commit("first")
+
+	repo, err := discovery.Open(testDir, nil)
+	check(t, err)
+
+	m, err := discovery.Head(repo, nil)
+	check(t, err)
+	assert.Equal(t, sha, m.Sha)
+	assert.Equal(t, []string{"app-a", "app-b"}, m.Names())
+
+	b := m.Module("app-b")
+	assert.Equal(t, "app-b", b.Path)
+	assert.Equal(t, []string{"app-a"}, b.Dependencies)
+	assert.Equal(t, []string{"app-b"}, m.Module("app-a").Dependents)
+	assert.Equal(t, "app-b-team", b.Properties["owner"])
+	assert.NotEmpty(t, b.Version)
+
+	m, err = discovery.Commit(repo, sha, &manifest.Filter{Name: "app-a", Dependents: true})
+	check(t, err)
+	assert.Equal(t, []string{"app-a", "app-b"}, m.Names())
+
+	m, err = discovery.Commit(repo, sha, &manifest.Filter{Name: "app-b"})
+	check(t, err)
+	assert.Equal(t, []string{"app-b"}, m.Names())
+
+	r.module("app-c")
+	m, err = discovery.Workspace(repo, nil)
+	check(t, err)
+	assert.Equal(t, []string{"app-a", "app-b", "app-c"}, m.Names())
+	assert.Nil(t, m.Module("app-d"))
+}
+
+func TestDiff(t *testing.T) {
+	defer os.RemoveAll(".tmp")
+	r := newTestRepo(t)
+	r.module("app-a")
+	r.module("app-b", "app-a")
+	r.module("app-c")
+	from := r.commit("first")
+	r.write("app-a/main.go", "package main")
+	to := r.commit("second")
+
+	repo, err := discovery.Open(testDir, nil)
+	check(t, err)
+
+	m, err := diff.Between(repo, from, to)
+	check(t, err)
+	assert.Equal(t, to, m.Sha)
+	assert.Equal(t, []string{"app-a", "app-b"}, m.Names())
+}
+
+func TestBuild(t *testing.T) {
+	defer os.RemoveAll(".tmp")
+	r := newTestRepo(t)
+	r.module("app-a")
+	r.module("app-b", "app-a")
+	r.commit("first")
+
+	repo, err := discovery.Open(testDir, nil)
+	check(t, err)
+
+	stdout := new(bytes.Buffer)
+	stages := []string{}
+	summary, err := build.Head(repo, nil, &build.Options{
+		Stdout: stdout,
+		OnStage: func(mod *manifest.Module, stage build.Stage, err error) {
+			if stage == build.StageAfter {
+				stages = append(stages, mod.Name)
+			}
+		},
+	})
+	check(t, err)
+
+	assert.Equal(t, "building app-a\nbuilding app-b\n", stdout.String())
+	assert.Equal(t, []string{"app-a", "app-b"}, stages)
+	assert.Len(t, summary.Completed, 2)
+	assert.Equal(t, "app-a", summary.Completed[0].Module.Name)
+	assert.Equal(t, []string{"app-a", "app-b"}, summary.Manifest.Names())
+}
+
+func TestRender(t *testing.T) {
+	defer os.RemoveAll(".tmp")
+	r := newTestRepo(t)
+	r.module("app-a")
+	r.module("app-b", "app-a")
+	r.write("template.tmpl", "{{range $name, $mod := .Modules}}{{$name}}={{$mod.Properties.owner}}\n{{end}}")
+	r.commit("first")
+
+	repo, err := discovery.Open(testDir, nil)
+	check(t, err)
+
+	output := new(bytes.Buffer)
+	err = render.Head(repo, "template.tmpl", &render.Options{Output: output})
+	check(t, err)
+	assert.Equal(t, "app-a=app-a-team\napp-b=app-b-team\n", output.String())
+
+	outputs := map[string]*bytes.Buffer{}
+	err = render.Workspace(repo, "template.tmpl", &render.Options{
+		SplitPerModule: true,
+		Template:       bytes.NewBufferString("{{.Module.Name}}"),
+		OutputFor: func(mod *manifest.Module) (io.WriteCloser, error) {
+			outputs[mod.Name] = new(bytes.Buffer)
+			return nopCloser{outputs[mod.Name]}, nil
+		},
+	})
+	check(t, err)
+	assert.Equal(t, "app-a", outputs["app-a"].String())
+	assert.Equal(t, "app-b", outputs["app-b"].String())
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// recordingT records the failures of the assertions in mbttest.
+type recordingT struct {
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertions(t *testing.T) {
+	defer os.RemoveAll(".tmp")
+	r := newTestRepo(t)
+	spec := func(dir, name string, owners []string, deps ...string) {
+		s := "name: " + name + "\n"
+		if len(owners) > 0 {
+			s += "owners: [" + strings.Join(owners, ", ") + "]\n"
+		}
+		if len(deps) > 0 {
+			s += "dependencies: [" + strings.Join(deps, ", ") + "]\n"
+		}
+		r.write(dir+"/.mbt.yml", s)
+	}
+	spec("frontend/web", "web", []string{"web-team"}, "api")
+	spec("frontend/admin", "admin", []string{"web-team"})
+	spec("api", "api", []string{"api-team"}, "users")
+	spec("db/users", "users", []string{"db-team"})
+	spec("tools", "tools", nil)
+	r.commit("first")
+
+	rt := &recordingT{}
+	assert.True(t, mbttest.AssertNoCycles(rt, testDir))
+	assert.True(t, mbttest.In(testDir).AssertNoDependency(rt, "db/*", "frontend/*"))
+	assert.True(t, mbttest.In(testDir+"/frontend").AssertAllModulesHaveOwners(rt, "tools"))
+	assert.Empty(t, rt.errors)
+
+	assert.False(t, mbttest.In(testDir).AssertNoDependency(rt, "frontend/*", "db/*"))
+	assert.False(t, mbttest.In(testDir).AssertAllModulesHaveOwners(rt))
+	assert.Equal(t, []string{
+		"mbttest: frontend/* must not depend on db/*: web -> api -> users",
+		"mbttest: modules without owners: tools",
+	}, rt.errors)
+
+	spec("db/users", "users", []string{"db-team"}, "web")
+	rt = &recordingT{}
+	assert.False(t, mbttest.AssertNoCycles(rt, testDir))
+	assert.Len(t, rt.errors, 1)
+	assert.Contains(t, rt.errors[0], "cyclic dependency")
+}
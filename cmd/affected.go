@@ -0,0 +1,89 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	base           string
+	head           string
+	purpose        string
+	affectedFormat string
+	shard          string
+	shardBy        string
+)
+
+func init() {
+	affectedCmd.Flags().StringVar(&base, "base", "", "Base revision (branch, tag or commit) of the changes e.g. origin/main")
+	affectedCmd.Flags().StringVar(&head, "head", "HEAD", "Head revision (branch, tag or commit) of the changes")
+	affectedCmd.Flags().StringVar(&purpose, "select", lib.AffectedBuilds, "Purpose of the modules (builds, tests, deploys or a purpose in the repository configuration)")
+	affectedCmd.Flags().StringVar(&affectedFormat, "format", formatText, "Output format (text, json or yaml). text lists the module names one per line")
+	affectedCmd.Flags().StringVar(&shard, "shard", "", "Select the modules in a shard of the affected modules, in the form of <index>/<count> (e.g. 3/8)")
+	affectedCmd.Flags().StringVar(&shardBy, "shard-by", "build", "Balance the shards by the durations of this command in the history (build or a user defined command)")
+	RootCmd.AddCommand(affectedCmd)
+}
+
+var affectedCmd = &cobra.Command{
+	Use:   "affected --base <rev> [--head <rev>] [--select builds|tests|deploys] [--shard <index>/<count>]",
+	Short: docText("affected-summary"),
+	Long:  docText("affected"),
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		if base == "" {
+			return errors.New("requires base revision")
+		}
+
+		m, err := system.Affected(base, head, purpose)
+		if err != nil {
+			return err
+		}
+
+		if shard != "" {
+			s, err := lib.ParseShard(shard)
+			if err != nil {
+				return err
+			}
+
+			stats, err := system.DurationStats(&lib.DurationStatsOptions{Command: shardBy})
+			if err != nil {
+				return err
+			}
+
+			durations := make(map[string]float64, len(stats))
+			for _, st := range stats {
+				if st.Runs > 0 {
+					durations[st.Name] = st.Mean
+				}
+			}
+			m = m.ApplyShard(s, durations)
+		}
+
+		if affectedFormat != formatText {
+			return m.Modules.Describe().Write(affectedFormat, os.Stdout)
+		}
+
+		for _, a := range m.Modules {
+			fmt.Println(a.Name())
+		}
+		return nil
+	}),
+}
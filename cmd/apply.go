@@ -16,24 +16,78 @@ limitations under the License.
 package cmd
 
 import (
+	"bytes"
 	"errors"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
 
+	"github.com/mbtproject/mbt/e"
+	"github.com/mbtproject/mbt/lib"
 	"github.com/spf13/cobra"
 )
 
+// stdinTemplate is the value of --to used to read the template from stdin.
+const stdinTemplate = "-"
+
 var (
-	out string
+	out            string
+	outDir         string
+	splitPerModule bool
+	filePattern    string
+	engine         string
+	strict         bool
+	valuesFiles    []string
+	setValues      []string
+	diffTo         string
+	kubectl        string
+	kubeContext    string
+	prune          string
+	dryRun         bool
+	generator      string
+	envFile        string
+	allEnvs        bool
 )
 
 func init() {
-	applyCmd.PersistentFlags().StringVar(&to, "to", "", "Template to apply")
 	applyCmd.PersistentFlags().StringVar(&out, "out", "", "Output path")
+	applyCmd.PersistentFlags().StringVar(&engine, "engine", "", "Template engine (go, jsonnet or cue). Defaults to the engine associated with the extension of the template")
+	applyCmd.PersistentFlags().BoolVar(&strict, "strict", false, "Fail if the template references a missing key, module or module property")
+	applyCmd.PersistentFlags().StringVar(&environment, "environment", "", "Merge the properties of this environment in module specs over the base properties")
+	applyCmd.PersistentFlags().StringArrayVar(&valuesFiles, "values", nil, "Merge the values in this yaml file into the properties of each module. Later files override earlier ones")
+	applyCmd.PersistentFlags().StringVar(&envFile, "env-file", "", "Merge the values of --environment in this yaml file, keyed by the name of the environment, over --values")
+	applyCmd.PersistentFlags().BoolVar(&allEnvs, "all-envs", false, "Render the template for each environment in --env-file into a directory named after the environment in --out-dir")
+	applyCmd.PersistentFlags().StringArrayVar(&setValues, "set", nil, "Set a property (KEY=VALUE) of each module, overriding --values. Dot notation can be used for nested properties")
+	applyCmd.PersistentFlags().StringVar(&outDir, "out-dir", "", "Output directory used with --split-per-module")
+	applyCmd.PersistentFlags().BoolVar(&splitPerModule, "split-per-module", false, "Render the template once for each module into a separate file in --out-dir")
+	applyCmd.PersistentFlags().StringVar(&generator, "generate", "", "Generate a helm chart (helm) or a kustomize overlay (kustomize) for each module in --out-dir")
+	applyCmd.PersistentFlags().StringVar(&filePattern, "file-pattern", "", "Template of the file names used with --split-per-module (defaults to the module name with the extension of the template)")
+	applyCmd.PersistentFlags().StringVarP(&name, "name", "n", "", "Render the template for modules with a name that matches this value when used with --split-per-module. Multiple names can be specified as a comma separated string.")
+	applyCmd.PersistentFlags().BoolVarP(&fuzzy, "fuzzy", "f", false, "Use fuzzy match when filtering")
+	applyCmd.PersistentFlags().StringVar(&planFile, "plan-file", "", "Release plan (written by mbt release plan) available in .Release. Renders just the modules in the plan with --split-per-module")
+	applyCmd.PersistentFlags().StringVar(&query, "query", "", "Select modules matching this expression (e.g. 'name =~ \"^svc-\" && \"backend\" in tags')")
+	// --to of diff is a commit, therefore the template flag is not
+	// declared as a persistent flag of apply.
+	for _, c := range []*cobra.Command{applyBranchCmd, applyCommitCmd, applyHeadCmd, applyLocal} {
+		c.Flags().StringVar(&to, "to", "", "Template to apply. Use - to read the template from stdin")
+	}
+	applyDiffCmd.Flags().StringVar(&from, "from", "", "From commit")
+	applyDiffCmd.Flags().StringVar(&diffTo, "to", "", "To commit")
+	applyKubernetesCmd.Flags().StringVar(&from, "from", "", "From commit")
+	applyKubernetesCmd.Flags().StringVar(&diffTo, "to", "", "To commit")
+	applyKubernetesCmd.Flags().StringVar(&kubectl, "kubectl", "kubectl", "kubectl executable used to apply the output")
+	applyKubernetesCmd.Flags().StringVar(&kubeContext, "context", "", "kubeconfig context of the cluster (defaults to the current context)")
+	applyKubernetesCmd.Flags().StringVar(&prune, "prune", "", "Delete the resources labelled with <label>=<module name> that are not in the output of the module")
+	applyKubernetesCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Submit the output to the cluster without persisting it (--dry-run=server)")
+	applyBranchCmd.Flags().StringVar(&atCommit, "commit", "", "Use this commit instead of a branch (e.g. in a detached head checkout)")
 	applyCmd.AddCommand(applyBranchCmd)
 	applyCmd.AddCommand(applyCommitCmd)
 	applyCmd.AddCommand(applyHeadCmd)
 	applyCmd.AddCommand(applyLocal)
+	applyCmd.AddCommand(applyDiffCmd)
+	applyCmd.AddCommand(applyKubernetesCmd)
 	RootCmd.AddCommand(applyCmd)
 }
 
@@ -46,13 +100,16 @@ var applyCmd = &cobra.Command{
 var applyBranchCmd = &cobra.Command{
 	Use: "branch <branch>",
 	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
-		branch := "master"
-		if len(args) > 0 {
-			branch = args[0]
+		branch, err := branchArg(args)
+		if err != nil {
+			return err
 		}
 
-		return applyCore(func(to string, output io.Writer) error {
-			return system.ApplyBranch(to, branch, output)
+		return applyCore(func(to string, options *lib.ApplyOptions) error {
+			if atCommit != "" {
+				return system.ApplyCommitWithOptions(atCommit, to, options)
+			}
+			return system.ApplyBranchWithOptions(to, branch, options)
 		})
 	}),
 }
@@ -66,8 +123,8 @@ var applyCommitCmd = &cobra.Command{
 
 		commit := args[0]
 
-		return applyCore(func(to string, output io.Writer) error {
-			return system.ApplyCommit(commit, to, output)
+		return applyCore(func(to string, options *lib.ApplyOptions) error {
+			return system.ApplyCommitWithOptions(commit, to, options)
 		})
 	}),
 }
@@ -75,8 +132,8 @@ var applyCommitCmd = &cobra.Command{
 var applyHeadCmd = &cobra.Command{
 	Use: "head",
 	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
-		return applyCore(func(to string, output io.Writer) error {
-			return system.ApplyHead(to, output)
+		return applyCore(func(to string, options *lib.ApplyOptions) error {
+			return system.ApplyHeadWithOptions(to, options)
 		})
 	}),
 }
@@ -84,30 +141,334 @@ var applyHeadCmd = &cobra.Command{
 var applyLocal = &cobra.Command{
 	Use: "local",
 	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
-		return applyCore(func(to string, output io.Writer) error {
-			return system.ApplyLocal(to, output)
+		return applyCore(func(to string, options *lib.ApplyOptions) error {
+			return system.ApplyLocalWithOptions(to, options)
+		})
+	}),
+}
+
+var applyDiffCmd = &cobra.Command{
+	Use: "diff --from <sha> --to <sha> <template>",
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		if from == "" {
+			return errors.New("requires from commit")
+		}
+
+		if diffTo == "" {
+			return errors.New("requires to commit")
+		}
+
+		if len(args) == 0 {
+			return errors.New("requires the path to template")
+		}
+
+		if splitPerModule || outDir != "" || generator != "" || allEnvs {
+			return errors.New("--split-per-module, --out-dir, --generate and --all-envs cannot be used with diff")
+		}
+
+		output, err := getOutput(out)
+		if err != nil {
+			return err
+		}
+		defer output.Close()
+
+		return system.ApplyDiff(from, diffTo, args[0], &lib.ApplyOptions{
+			Engine:           engine,
+			Strict:           strict,
+			Environment:      environment,
+			EnvironmentsFile: envFile,
+			Values:           valuesFiles,
+			Set:              setValues,
+		}, output)
+	}),
+}
+
+var applyKubernetesCmd = &cobra.Command{
+	Use:     "kubernetes --from <sha> --to <sha> <template>",
+	Aliases: []string{"k8s"},
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		if from == "" {
+			return errors.New("requires from commit")
+		}
+
+		if diffTo == "" {
+			return errors.New("requires to commit")
+		}
+
+		if len(args) == 0 {
+			return errors.New("requires the path to template")
+		}
+
+		if splitPerModule || outDir != "" || out != "" || generator != "" || allEnvs {
+			return errors.New("--split-per-module, --out-dir, --out, --generate and --all-envs cannot be used with kubernetes")
+		}
+
+		return system.ApplyKubernetes(from, diffTo, args[0], &lib.ApplyOptions{
+			Engine:           engine,
+			Strict:           strict,
+			Environment:      environment,
+			EnvironmentsFile: envFile,
+			Values:           valuesFiles,
+			Set:              setValues,
+		}, &lib.KubernetesOptions{
+			Kubectl: kubectl,
+			Context: kubeContext,
+			Prune:   prune,
+			DryRun:  dryRun,
+			Stdout:  os.Stdout,
+			Stderr:  os.Stderr,
 		})
 	}),
 }
 
-type applyFunc func(to string, output io.Writer) error
+type applyFunc func(to string, options *lib.ApplyOptions) error
 
 func applyCore(f applyFunc) error {
 	if to == "" {
 		return errors.New("requires the path to template, specify --to argument")
 	}
 
-	output, err := getOutput(out)
+	options, err := applyOptions()
 	if err != nil {
 		return err
 	}
+	options.Release = releasePlan
+
+	templatePath := to
+	if to == stdinTemplate {
+		// Name of the template is only used to select the engine and
+		// in error messages.
+		templatePath = "stdin"
+		options.Template = os.Stdin
+	}
+
+	return f(templatePath, options)
+}
+
+func applyOptions() (*lib.ApplyOptions, error) {
+	if allEnvs {
+		return allEnvironmentsOptions()
+	}
+
+	options, err := outputOptions()
+	if err != nil {
+		return nil, err
+	}
 
-	return f(to, output)
+	options.EnvironmentsFile = envFile
+	return options, nil
 }
 
-func getOutput(out string) (io.Writer, error) {
+// outputOptions creates the options to write the output to --out or to
+// the files for each module in --out-dir.
+func outputOptions() (*lib.ApplyOptions, error) {
+	if generator != "" {
+		return generatorOptions(outDir)
+	}
+
+	if splitPerModule {
+		if outDir == "" {
+			return nil, errors.New("--split-per-module requires the output directory, specify --out-dir argument")
+		}
+
+		return splitOutput(outDir, filePattern)
+	}
+
+	if outDir != "" {
+		return nil, errors.New("--out-dir can only be specified with --split-per-module")
+	}
+
+	output, err := getOutput(out)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lib.ApplyOptions{
+		Engine:      engine,
+		Strict:      strict,
+		Environment: environment,
+		Values:      valuesFiles,
+		Set:         setValues,
+		Output: func(*lib.Module) (io.WriteCloser, error) {
+			return output, nil
+		},
+	}, nil
+}
+
+// allEnvironmentsOptions creates the options to render the template for
+// each environment in the environment values file into a directory
+// named after the environment in the output directory.
+func allEnvironmentsOptions() (*lib.ApplyOptions, error) {
+	if envFile == "" {
+		return nil, errors.New("--all-envs requires the environment values file, specify --env-file argument")
+	}
+
+	if environment != "" {
+		return nil, errors.New("--all-envs cannot be used with --environment")
+	}
+
+	if outDir == "" {
+		return nil, errors.New("--all-envs requires the output directory, specify --out-dir argument")
+	}
+
+	// Without --split-per-module or --generate, output of each environment
+	// is written to a file named after --out or the template.
+	file := filepath.Base(out)
 	if out == "" {
-		return os.Stdout, nil
+		if to == stdinTemplate {
+			return nil, errors.New("--all-envs requires the name of the output file to read the template from stdin, specify --out argument")
+		}
+		file = strings.TrimSuffix(filepath.Base(to), ".tmpl")
+	}
+
+	options := &lib.ApplyOptions{
+		Engine: engine,
+		Strict: strict,
+		Values: valuesFiles,
+		Set:    setValues,
+	}
+	if generator != "" || splitPerModule {
+		var err error
+		if options, err = outputOptions(); err != nil {
+			return nil, err
+		}
+	}
+
+	outputs := make(map[string]func(*lib.Module) (io.WriteCloser, error))
+	options.EnvironmentsFile = envFile
+	options.AllEnvironments = true
+	options.EnvironmentOutput = func(env string, mod *lib.Module) (io.WriteCloser, error) {
+		output, ok := outputs[env]
+		if !ok {
+			var err error
+			if output, err = environmentOutput(env, file); err != nil {
+				return nil, err
+			}
+			outputs[env] = output
+		}
+
+		return output(mod)
+	}
+
+	return options, nil
+}
+
+// environmentOutput creates the output of the environment in its
+// directory in --out-dir.
+func environmentOutput(env, file string) (func(*lib.Module) (io.WriteCloser, error), error) {
+	if env == "" || env == "." || env == ".." || strings.ContainsAny(env, `/\`) {
+		return nil, e.NewErrorf(lib.ErrClassUser, "environment '%s' cannot be used as the name of a directory", env)
+	}
+
+	dir := filepath.Join(outDir, env)
+	switch {
+	case generator != "":
+		options, err := generatorOptions(dir)
+		if err != nil {
+			return nil, err
+		}
+		return options.Output, nil
+	case splitPerModule:
+		options, err := splitOutput(dir, filePattern)
+		if err != nil {
+			return nil, err
+		}
+		return options.Output, nil
+	}
+
+	return func(*lib.Module) (io.WriteCloser, error) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		return os.Create(filepath.Join(dir, file))
+	}, nil
+}
+
+// generatorOptions creates the options to generate a helm chart or a
+// kustomize overlay for each module in dir.
+func generatorOptions(dir string) (*lib.ApplyOptions, error) {
+	if dir == "" {
+		return nil, errors.New("--generate requires the output directory, specify --out-dir argument")
+	}
+
+	if filePattern != "" {
+		return nil, errors.New("--file-pattern cannot be used with --generate")
+	}
+
+	output, err := lib.GeneratorOutput(generator, dir, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lib.ApplyOptions{
+		SplitPerModule: true,
+		Engine:         engine,
+		Strict:         strict,
+		Environment:    environment,
+		Values:         valuesFiles,
+		Set:            setValues,
+		Filter:         &lib.FilterOptions{Name: name, Fuzzy: fuzzy, Query: query},
+		Output:         output,
+	}, nil
+}
+
+func getOutput(out string) (io.WriteCloser, error) {
+	if out == "" {
+		return nopCloser{os.Stdout}, nil
 	}
 	return os.Create(out)
 }
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// splitOutput creates the options to write the output of each module
+// to a file in dir named by pattern.
+func splitOutput(dir, pattern string) (*lib.ApplyOptions, error) {
+	if pattern == "" {
+		pattern = "{{.Name}}" + filepath.Ext(strings.TrimSuffix(to, ".tmpl"))
+	}
+
+	t, err := template.New("file-pattern").Option("missingkey=error").Parse(pattern)
+	if err != nil {
+		return nil, e.Wrapf(lib.ErrClassUser, err, "invalid file pattern '%s'", pattern)
+	}
+
+	files := make(map[string]string)
+	return &lib.ApplyOptions{
+		SplitPerModule: true,
+		Engine:         engine,
+		Strict:         strict,
+		Environment:    environment,
+		Values:         valuesFiles,
+		Set:            setValues,
+		Filter:         &lib.FilterOptions{Name: name, Fuzzy: fuzzy, Query: query},
+		Output: func(mod *lib.Module) (io.WriteCloser, error) {
+			buff := new(bytes.Buffer)
+			if err := t.Execute(buff, mod); err != nil {
+				return nil, e.Wrapf(lib.ErrClassUser, err, "failed to create the file name for module %s", mod.Name())
+			}
+
+			rel := filepath.Clean(filepath.FromSlash(buff.String()))
+			if rel == "." || filepath.IsAbs(rel) || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || rel == ".." {
+				return nil, e.NewErrorf(lib.ErrClassUser, "file name '%s' of module %s is outside the output directory", buff.String(), mod.Name())
+			}
+
+			if other, ok := files[rel]; ok {
+				return nil, e.NewErrorf(lib.ErrClassUser, "modules %s and %s are rendered to the same file '%s'", other, mod.Name(), rel)
+			}
+			files[rel] = mod.Name()
+
+			p := filepath.Join(dir, rel)
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				return nil, err
+			}
+			return os.Create(p)
+		},
+	}, nil
+}
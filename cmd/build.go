@@ -17,6 +17,7 @@ package cmd
 
 import (
 	"errors"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
@@ -24,7 +25,62 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var (
+	sandbox            bool
+	plan               bool
+	resume             bool
+	ignoreProbes       bool
+	ignoreFingerprints bool
+	flakyRetries       int
+	cacheDir           string
+	cacheRemote        string
+	cacheReadOnly      bool
+	envVars            []string
+	containerRuntime   string
+	jobs               int
+	keepGoing          bool
+	cpuLimit           int
+	memoryLimit        string
+	progressMode       string
+	profile            string
+	reportFile         string
+	environment        string
+	interactive        bool
+	watch              bool
+	publish            bool
+	provenanceDir      string
+	builderID          string
+	signProvenance     bool
+)
+
 func init() {
+	buildCommand.PersistentFlags().StringVar(&containerRuntime, "container-runtime", "docker", "Container runtime used to run commands of modules specifying an image")
+	buildCommand.PersistentFlags().IntVarP(&jobs, "jobs", "j", 1, "Maximum number of modules to build concurrently")
+	buildCommand.PersistentFlags().BoolVar(&keepGoing, "keep-going", false, "Keep building the modules not depending on a failed module after a failure")
+	buildCommand.PersistentFlags().IntVar(&cpuLimit, "cpu", 0, "Number of cores available for concurrent builds (defaults to the number of cores in this machine)")
+	buildCommand.PersistentFlags().StringVar(&memoryLimit, "memory", "", "Memory available for concurrent builds e.g. 16Gi (defaults to the memory of this machine)")
+	buildCommand.PersistentFlags().BoolVar(&resume, "resume", false, "Skip the modules built at the same version and environment in the previous build")
+	buildCommand.PersistentFlags().BoolVar(&ignoreProbes, "ignore-probes", false, "Build the modules even if their probes find the artifacts of their versions")
+	buildCommand.PersistentFlags().BoolVar(&ignoreFingerprints, "ignore-fingerprints", false, "Build the modules even if the fingerprints of their dependencies did not change")
+	buildCommand.PersistentFlags().StringVar(&cacheDir, "cache-dir", "", "Restore the outputs of the modules from the build cache in this directory and store the outputs of the modules built")
+	buildCommand.PersistentFlags().StringVar(&cacheRemote, "cache-remote", "", "Url of the remote build cache (http, https or s3://bucket/prefix)")
+	buildCommand.PersistentFlags().BoolVar(&cacheReadOnly, "cache-readonly", false, "Do not store the outputs of the modules built in the build cache")
+	buildCommand.PersistentFlags().IntVar(&flakyRetries, "retry-flaky", 0, "Number of times to retry a failed build of a flaky module")
+	buildCommand.PersistentFlags().StringArrayVarP(&envVars, "env", "e", nil, "Set an environment variable (KEY=VALUE) for the build commands")
+	buildCommand.PersistentFlags().BoolVar(&plan, "plan", false, "Print the build plan without executing any command")
+	buildCommand.PersistentFlags().BoolVar(&toJSON, "json", false, "Format the build plan as json")
+	buildCommand.PersistentFlags().StringVar(&out, "out", "", "Write the build plan to this archive to be executed with mbt execute (implies --plan)")
+	buildCommand.PersistentFlags().StringVar(&progressMode, "progress", progressAuto, "Progress display (auto, tty or plain). auto displays the progress when attached to a terminal")
+	buildCommand.PersistentFlags().StringVar(&profile, "profile", "", "Write the timings of the build to this file in chrome trace event format")
+	buildCommand.PersistentFlags().StringVar(&reportFile, "report", "", "Merge the test reports produced by the modules into this file")
+	buildCommand.PersistentFlags().StringVar(&environment, "environment", "", "Merge the properties of this environment in module specs over the base properties")
+	buildCommand.PersistentFlags().BoolVar(&publish, "publish", false, "Publish the outputs of each module after it is built")
+	buildCommand.PersistentFlags().StringVar(&planFile, "plan-file", "", "Build just the modules in this release plan (written by mbt release plan)")
+	buildCommand.PersistentFlags().StringVar(&provenanceDir, "provenance-dir", "", "Write the SLSA provenance of each module built to this directory")
+	buildCommand.PersistentFlags().StringVar(&builderID, "builder-id", "", "Builder id in the provenance (defaults to the CI system or the host)")
+	buildCommand.PersistentFlags().BoolVar(&signProvenance, "sign-provenance", false, "Sign the provenance with cosign (sigstore keyless)")
+	buildCommand.PersistentFlags().BoolVar(&sandbox, "sandbox", false, "Build each module in an isolated copy of the repository containing only the module and its file dependencies")
+
 	buildPr.Flags().StringVar(&src, "src", "", "Source branch")
 	buildPr.Flags().StringVar(&dst, "dst", "", "Destination branch")
 
@@ -34,16 +90,28 @@ func init() {
 	buildLocal.Flags().BoolVarP(&all, "all", "a", false, "All modules")
 	buildLocal.Flags().StringVarP(&name, "name", "n", "", "Build modules with a name that matches this value. Multiple names can be specified as a comma separated string.")
 	buildLocal.Flags().BoolVarP(&fuzzy, "fuzzy", "f", false, "Use fuzzy match when filtering")
+	buildLocal.Flags().StringVar(&query, "query", "", "Select modules matching this expression (e.g. 'name =~ \"^svc-\" && \"backend\" in tags')")
+	addScopeFlags(buildLocal.Flags(), true)
+	buildLocal.Flags().BoolVarP(&interactive, "interactive", "i", false, "Select the modules to build from a list (narrowed by --name and --query if specified)")
+	buildLocal.Flags().BoolVarP(&watch, "watch", "w", false, "Keep watching the workspace and rebuild the modules impacted by each change")
+	buildLocal.Flags().DurationVar(&debounce, "debounce", 300*time.Millisecond, "Time to wait for further changes before rebuilding (with --watch)")
 
 	buildCommit.Flags().BoolVarP(&content, "content", "c", false, "Build the modules impacted by the content of the commit")
 	buildCommit.Flags().StringVarP(&name, "name", "n", "", "Build modules with a name that matches this value. Multiple names can be specified as a comma separated string.")
 	buildCommit.Flags().BoolVarP(&fuzzy, "fuzzy", "f", false, "Use fuzzy match when filtering")
+	buildCommit.Flags().StringVar(&query, "query", "", "Select modules matching this expression (e.g. 'name =~ \"^svc-\" && \"backend\" in tags')")
+	addScopeFlags(buildCommit.Flags(), true)
 
 	buildBranch.Flags().StringVarP(&name, "name", "n", "", "Build modules with a name that matches this value. Multiple names can be specified as a comma separated string.")
 	buildBranch.Flags().BoolVarP(&fuzzy, "fuzzy", "f", false, "Use fuzzy match when filtering")
+	buildBranch.Flags().StringVar(&atCommit, "commit", "", "Use this commit instead of a branch (e.g. in a detached head checkout)")
+	buildBranch.Flags().StringVar(&query, "query", "", "Select modules matching this expression (e.g. 'name =~ \"^svc-\" && \"backend\" in tags')")
+	addScopeFlags(buildBranch.Flags(), true)
 
 	buildHead.Flags().StringVarP(&name, "name", "n", "", "Build modules with a name that matches this value. Multiple names can be specified as a comma separated string.")
 	buildHead.Flags().BoolVarP(&fuzzy, "fuzzy", "f", false, "Use fuzzy match when filtering")
+	buildHead.Flags().StringVar(&query, "query", "", "Select modules matching this expression (e.g. 'name =~ \"^svc-\" && \"backend\" in tags')")
+	addScopeFlags(buildHead.Flags(), true)
 
 	buildCommand.AddCommand(buildBranch)
 	buildCommand.AddCommand(buildPr)
@@ -57,19 +125,23 @@ func init() {
 var buildHead = &cobra.Command{
 	Use: "head",
 	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
-		return summarise(system.BuildCurrentBranch(&lib.FilterOptions{Name: name, Fuzzy: fuzzy}, lib.CmdOptionsWithStdIO(buildStageCB)))
+		return summarise(system.BuildCurrentBranch(filterOptions(), buildCmdOptions()))
 	}),
 }
 
 var buildBranch = &cobra.Command{
 	Use: "branch <branch>",
 	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
-		branch := "master"
-		if len(args) > 0 {
-			branch = args[0]
+		branch, err := branchArg(args)
+		if err != nil {
+			return err
+		}
+
+		if atCommit != "" {
+			return summarise(system.BuildCommit(atCommit, filterOptions(), buildCmdOptions()))
 		}
 
-		return summarise(system.BuildBranch(branch, &lib.FilterOptions{Name: name, Fuzzy: fuzzy}, lib.CmdOptionsWithStdIO(buildStageCB)))
+		return summarise(system.BuildBranch(branch, filterOptions(), buildCmdOptions()))
 	}),
 }
 
@@ -84,7 +156,7 @@ var buildPr = &cobra.Command{
 			return errors.New("requires dest")
 		}
 
-		return summarise(system.BuildPr(src, dst, lib.CmdOptionsWithStdIO(buildStageCB)))
+		return summarise(system.BuildPr(src, dst, buildCmdOptions()))
 	}),
 }
 
@@ -99,7 +171,7 @@ var buildDiff = &cobra.Command{
 			return errors.New("requires to commit")
 		}
 
-		return summarise(system.BuildDiff(from, to, lib.CmdOptionsWithStdIO(buildStageCB)))
+		return summarise(system.BuildDiff(from, to, buildCmdOptions()))
 	}),
 }
 
@@ -113,23 +185,58 @@ var buildCommit = &cobra.Command{
 		commit := args[0]
 
 		if content {
-			return summarise(system.BuildCommitContent(commit, lib.CmdOptionsWithStdIO(buildStageCB)))
+			return summarise(system.BuildCommitContent(commit, buildCmdOptions()))
 		}
-		return summarise(system.BuildCommit(commit, &lib.FilterOptions{Name: name, Fuzzy: fuzzy}, lib.CmdOptionsWithStdIO(buildStageCB)))
+		return summarise(system.BuildCommit(commit, filterOptions(), buildCmdOptions()))
 	}),
 }
 
 var buildLocal = &cobra.Command{
-	Use: "local [--all]",
+	Use: "local [--all | --interactive] [--watch]",
 	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
-		if all || name != "" {
-			return summarise(system.BuildWorkspace(&lib.FilterOptions{Name: name, Fuzzy: fuzzy}, lib.CmdOptionsWithStdIO(buildStageCB)))
+		if watch && (plan || out != "") {
+			return errors.New("--watch cannot be used with --plan or --out")
+		}
+
+		filter, err := localFilter()
+		if err != nil {
+			return err
+		}
+
+		if filter != nil {
+			err = summarise(system.BuildWorkspace(filter, buildCmdOptions()))
+		} else {
+			err = summarise(system.BuildWorkspaceChanges(buildCmdOptions()))
+		}
+		if err != nil || !watch {
+			return err
 		}
 
-		return summarise(system.BuildWorkspaceChanges(lib.CmdOptionsWithStdIO(buildStageCB)))
+		// Rebuilds are restricted to the modules selected for the
+		// first build.
+		return system.Watch(&lib.WatchOptions{
+			Debounce: debounce,
+			Filter:   filter,
+			Stop:     interrupt.Done(),
+			Summary:  summariseWatch,
+		}, watchBuildCmdOptions())
 	}),
 }
 
+// localFilter returns the filter of the modules selected for build
+// local, nil if the changes in the workspace are built.
+func localFilter() (*lib.FilterOptions, error) {
+	if interactive {
+		return interactiveFilter()
+	}
+
+	if all || name != "" || query != "" || scope != "" || label != "" {
+		return filterOptions(), nil
+	}
+
+	return nil, nil
+}
+
 func buildStageCB(a *lib.Module, s lib.CmdStage, err error) {
 	switch s {
 	case lib.CmdStageBeforeBuild:
@@ -140,17 +247,86 @@ func buildStageCB(a *lib.Module, s lib.CmdStage, err error) {
 }
 
 func summarise(summary *lib.BuildSummary, err error) error {
+	stopProgress()
+	stopQuiet()
+
+	if err == nil && summary.Plan != nil {
+		if out != "" {
+			return writePlanArchive(summary, out)
+		}
+		return outputPlan(summary)
+	}
+
+	// Profile is written for failed builds as well, since the timings
+	// of the modules processed are most useful in that case.
+	if profile != "" && summary != nil {
+		if perr := writeProfile(profile, summary); perr != nil {
+			if err == nil {
+				return perr
+			}
+			logrus.Warn(perr)
+		}
+	}
+
 	if err == nil {
-		logrus.Infof("Modules: %v Built: %v Skipped: %v",
+		cached := 0
+		for _, r := range summary.Completed {
+			if r.Cached {
+				cached++
+			}
+		}
+
+		logrus.Infof("Modules: %v Built: %v Cached: %v Skipped: %v",
 			len(summary.Manifest.Modules),
-			len(summary.Completed),
+			len(summary.Completed)-cached,
+			cached,
 			len(summary.Skipped))
 
 		logrus.Infof("Build finished for commit %v", summary.Manifest.Sha)
+
+		if len(summary.Manifest.Modules) == 0 {
+			resultCode = lib.ExitCodeNothingToBuild
+		}
 	}
 	return err
 }
 
+// buildCmdOptions creates the options for a build and starts the
+// progress display if enabled. summarise stops the progress display.
+func buildCmdOptions() *lib.CmdOptions {
+	return startProgress(watchBuildCmdOptions())
+}
+
+// watchBuildCmdOptions creates the options for the builds triggered by
+// watch. Progress display is not used with watch.
+func watchBuildCmdOptions() *lib.CmdOptions {
+	options := lib.CmdOptionsWithStdIO(buildStageCB)
+	options.Sandbox = sandbox
+	// Writing the plan to an archive implies planning.
+	options.Plan = plan || out != ""
+	options.Resume = resume
+	options.IgnoreProbes = ignoreProbes
+	options.IgnoreFingerprints = ignoreFingerprints
+	options.FlakyRetries = flakyRetries
+	options.CacheDir = cacheDir
+	options.CacheRemote = cacheRemote
+	options.CacheReadOnly = cacheReadOnly
+	options.Env = envVars
+	options.Jobs = jobs
+	options.KeepGoing = keepGoing
+	options.CPULimit = cpuLimit
+	options.MemoryLimit = memoryLimit
+	options.ContainerRuntime = containerRuntime
+	options.ReportFile = reportFile
+	options.Publish = publish
+	options.Release = releasePlan
+	options.ProvenanceDir = provenanceDir
+	options.BuilderID = builderID
+	options.SignProvenance = signProvenance
+	options.Environment = environment
+	return withLogFormat(options)
+}
+
 var buildCommand = &cobra.Command{
 	Use:   "build",
 	Short: docText("build-summary"),
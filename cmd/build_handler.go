@@ -16,10 +16,9 @@ limitations under the License.
 package cmd
 
 import (
-	"fmt"
-
 	"github.com/mbtproject/mbt/e"
 	"github.com/mbtproject/mbt/lib"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -28,21 +27,33 @@ type handlerFunc func(command *cobra.Command, args []string) error
 func buildHandler(handler handlerFunc) handlerFunc {
 	return func(command *cobra.Command, args []string) error {
 		err := handler(command, args)
-		if err == nil {
-			return nil
+		if cerr := system.Close(); cerr != nil {
+			logrus.Warn(cerr)
 		}
 
-		if ee, ok := err.(*e.E); ok {
-			if ee.Class() == lib.ErrClassInternal {
-				return fmt.Errorf(`An unexpected error occurred. See below for more details.
+		return handlerError(err)
+	}
+}
+
+// handlerError formats the errors of internal class with the details
+// for a bug report.
+func handlerError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if ee, ok := err.(*e.E); ok {
+		if ee.Class() == lib.ErrClassInternal {
+			// Wrapped error retains the code and location of the
+			// original error for its diagnostic.
+			return e.Wrapf(lib.ErrClassInternal, ee, `An unexpected error occurred. See below for more details.
 For support, create a new issue at https://github.com/mbtproject/mbt/issues
 
-%v`, ee.WithExtendedInfo())
-			} else if debug {
-				return ee.WithExtendedInfo()
-			}
+%v`, ee.WithExtendedInfo()).WithCode(ee.Code())
+		} else if debug {
+			return ee.WithExtendedInfo()
 		}
-
-		return err
 	}
+
+	return err
 }
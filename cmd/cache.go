@@ -0,0 +1,148 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cacheMaxSize  string
+	cacheMaxAge   string
+	cacheKeepLast int
+)
+
+func init() {
+	cacheCmd.PersistentFlags().BoolVar(&toJSON, "json", false, "Format output as json")
+	cacheGCCmd.Flags().StringVar(&cacheMaxSize, "max-size", "", "Maximum size of each cache (e.g. 512Mi or 10G)")
+	cacheGCCmd.Flags().StringVar(&cacheMaxAge, "max-age", "", "Remove the entries not modified in this period (e.g. 30d, 12h)")
+	cacheGCCmd.Flags().IntVar(&cacheKeepLast, "keep-last", 0, "Number of versions of each module kept in the fingerprints cache")
+	cacheGCCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report the entries to remove without removing them")
+	cacheCmd.AddCommand(cacheStatsCmd)
+	cacheCmd.AddCommand(cacheGCCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+	RootCmd.AddCommand(cacheCmd)
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: docText("cache-summary"),
+	Long:  docText("cache"),
+}
+
+var cacheStatsCmd = &cobra.Command{
+	Use: "stats",
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		stats, err := system.CacheStats()
+		if err != nil {
+			return err
+		}
+
+		return outputCacheStats(stats, false)
+	}),
+}
+
+var cacheGCCmd = &cobra.Command{
+	Use: "gc [--max-size <size>] [--max-age <period>] [--keep-last <n>] [--dry-run]",
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		options := &lib.CacheGCOptions{MaxSize: cacheMaxSize, KeepLast: cacheKeepLast, DryRun: dryRun}
+		if cacheMaxAge != "" {
+			age, err := lib.ParseAge(cacheMaxAge)
+			if err != nil {
+				return err
+			}
+			options.MaxAge = age
+		}
+
+		stats, err := system.CacheGC(options)
+		if err != nil {
+			return err
+		}
+
+		return outputCacheStats(stats, true)
+	}),
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use: "clear [<cache>...]",
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		stats, err := system.CacheClear(args)
+		if err != nil {
+			return err
+		}
+
+		return outputCacheStats(stats, true)
+	}),
+}
+
+func outputCacheStats(stats []*lib.CacheStats, gc bool) error {
+	if toJSON {
+		buff, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(buff))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+	if gc {
+		fmt.Fprintf(w, "CACHE\tENTRIES\tSIZE\tREMOVED\tFREED\n")
+		for _, s := range stats {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%s\n", s.Name, s.Entries, formatSize(s.Size), s.Removed, formatSize(s.Freed))
+		}
+		return w.Flush()
+	}
+
+	fmt.Fprintf(w, "CACHE\tENTRIES\tSIZE\tOLDEST\tNEWEST\tDIR\n")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", s.Name, s.Entries, formatSize(s.Size), formatCacheTime(s.Oldest), formatCacheTime(s.Newest), s.Dir)
+	}
+	return w.Flush()
+}
+
+// formatSize formats a number of bytes with a binary unit (e.g. 1.5Mi).
+func formatSize(size int64) string {
+	units := []string{"Ki", "Mi", "Gi", "Ti"}
+	if size < 1024 {
+		return fmt.Sprintf("%d", size)
+	}
+
+	v := float64(size)
+	unit := ""
+	for _, u := range units {
+		if v < 1024 {
+			break
+		}
+		v /= 1024
+		unit = u
+	}
+	return fmt.Sprintf("%.1f%s", v, unit)
+}
+
+func formatCacheTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
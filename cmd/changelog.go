@@ -0,0 +1,51 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+)
+
+var changelogFormat string
+
+func init() {
+	changelogCmd.Flags().StringVar(&from, "from", "", "List the changes since this revision (branch, tag or commit)")
+	changelogCmd.Flags().StringVar(&to, "to", "", "List the changes up to this revision (defaults to the current branch)")
+	changelogCmd.Flags().StringVar(&changelogFormat, "format", lib.ChangelogFormatMarkdown, "Output format (markdown or json)")
+	RootCmd.AddCommand(changelogCmd)
+}
+
+var changelogCmd = &cobra.Command{
+	Use:   "changelog <module> [--from <rev>] [--to <rev>] [--format markdown|json]",
+	Short: docText("changelog-summary"),
+	Long:  docText("changelog"),
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return errors.New("requires the module name")
+		}
+
+		changelog, err := system.Changelog(args[0], from, to)
+		if err != nil {
+			return err
+		}
+
+		return changelog.Write(changelogFormat, os.Stdout)
+	}),
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ciFrom       string
+	ciTo         string
+	githubOutput bool
+	outputName   string
+	ciImage      string
+	ciStage      string
+	ciProvider   string
+	buildSummary string
+	ciRepository string
+	pullRequest  int
+	statusSha    string
+)
+
+func init() {
+	githubMatrixCmd.Flags().StringVar(&ciFrom, "from", "", "Base revision (branch, tag or commit) of the changes e.g. $BASE_SHA")
+	githubMatrixCmd.Flags().StringVar(&ciTo, "to", "HEAD", "Head revision (branch, tag or commit) of the changes")
+	githubMatrixCmd.Flags().StringVar(&purpose, "select", lib.AffectedBuilds, "Purpose of the modules (builds, tests, deploys or a purpose in the repository configuration)")
+	githubMatrixCmd.Flags().BoolVar(&githubOutput, "github-output", false, "Write the matrix to the step outputs file in $GITHUB_OUTPUT instead of stdout")
+	githubMatrixCmd.Flags().StringVar(&outputName, "output-name", "matrix", "Name of the step output used with --github-output")
+
+	for _, c := range []*cobra.Command{gitlabPipelineCmd, pipelineCmd} {
+		c.Flags().StringVar(&ciFrom, "from", "", "Base revision (branch, tag or commit) of the changes e.g. $CI_MERGE_REQUEST_DIFF_BASE_SHA")
+		c.Flags().StringVar(&ciTo, "to", "HEAD", "Head revision (branch, tag or commit) of the changes")
+		c.Flags().StringVar(&purpose, "select", lib.AffectedBuilds, "Purpose of the modules (builds, tests, deploys or a purpose in the repository configuration)")
+		c.Flags().StringVar(&ciImage, "image", "", "Image of the jobs of modules not specifying an image")
+		c.Flags().StringVar(&ciStage, "stage", lib.DefaultGitLabStage, "Stage of the jobs in a GitLab pipeline")
+		c.Flags().StringVar(&out, "out", "", "Write the pipeline to this file instead of stdout")
+	}
+	pipelineCmd.Flags().StringVar(&ciProvider, "provider", "", "CI system of the pipeline (azure, buildkite, circleci, github, github-actions, gitlab or jenkins)")
+
+	githubCommentCmd.Flags().StringVar(&ciFrom, "from", "", "Base revision (branch, tag or commit) of the changes e.g. origin/$GITHUB_BASE_REF")
+	githubCommentCmd.Flags().StringVar(&ciTo, "to", "HEAD", "Head revision (branch, tag or commit) of the changes")
+	githubCommentCmd.Flags().StringVar(&buildSummary, "build-summary", "", "Include the build results in this summary (written with --summary-file)")
+	githubCommentCmd.Flags().StringVar(&ciRepository, "repository", "", "Repository of the pull request in owner/name format (defaults to $GITHUB_REPOSITORY)")
+	githubCommentCmd.Flags().IntVar(&pullRequest, "pr", 0, "Number of the pull request (defaults to the pull request in $GITHUB_EVENT_PATH)")
+	githubCommentCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the comment instead of posting it")
+
+	publishStatusCmd.Flags().StringVar(&ciProvider, "provider", "", "CI system to publish the statuses to (github or gitlab)")
+	publishStatusCmd.Flags().StringVar(&buildSummary, "build-summary", "", "Summary of the build (written with --summary-file)")
+	publishStatusCmd.Flags().StringVar(&ciRepository, "repository", "", "Repository in owner/name format or GitLab project id (defaults to $GITHUB_REPOSITORY or $CI_PROJECT_ID)")
+	publishStatusCmd.Flags().StringVar(&statusSha, "sha", "", "Commit of the statuses (defaults to the commit in the summary)")
+
+	ciCmd.AddCommand(githubMatrixCmd)
+	ciCmd.AddCommand(githubCommentCmd)
+	ciCmd.AddCommand(gitlabPipelineCmd)
+	ciCmd.AddCommand(pipelineCmd)
+	ciCmd.AddCommand(publishStatusCmd)
+	RootCmd.AddCommand(ciCmd)
+}
+
+var ciCmd = &cobra.Command{
+	Use:   "ci",
+	Short: docText("ci-summary"),
+	Long:  docText("ci"),
+}
+
+var githubMatrixCmd = &cobra.Command{
+	Use: "github-matrix --from <rev> [--to <rev>] [--github-output]",
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		if ciFrom == "" {
+			return errors.New("requires base revision")
+		}
+
+		m, err := system.Affected(ciFrom, ciTo, purpose)
+		if err != nil {
+			return err
+		}
+
+		matrix, err := m.GitHubMatrix()
+		if err != nil {
+			return err
+		}
+
+		if !githubOutput {
+			return matrix.Write(os.Stdout)
+		}
+
+		file := os.Getenv("GITHUB_OUTPUT")
+		if file == "" {
+			return errors.New("GITHUB_OUTPUT is not set, --github-output can only be used in GitHub Actions")
+		}
+
+		return matrix.WriteGitHubOutput(file, outputName)
+	}),
+}
+
+var githubCommentCmd = &cobra.Command{
+	Use: "github-comment --from <rev> [--to <rev>] [--build-summary <file>] [--pr <number>] [--dry-run]",
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		if ciFrom == "" {
+			return errors.New("requires base revision")
+		}
+
+		var summary *lib.InvocationSummary
+		if buildSummary != "" {
+			s, err := lib.ReadInvocationSummary(buildSummary)
+			if err != nil {
+				return err
+			}
+			summary = s
+		}
+
+		report, err := system.PRReport(ciFrom, ciTo, summary)
+		if err != nil {
+			return err
+		}
+
+		if dryRun {
+			_, err = os.Stdout.WriteString(report.Markdown())
+			return err
+		}
+
+		u, err := report.PostGitHubComment(&lib.GitHubCommentOptions{Repository: ciRepository, PullRequest: pullRequest})
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(u)
+		return nil
+	}),
+}
+
+var gitlabPipelineCmd = &cobra.Command{
+	Use: "gitlab-pipeline --from <rev> [--to <rev>] [--out <file>]",
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		return writePipeline(lib.PipelineGitLab)
+	}),
+}
+
+var pipelineCmd = &cobra.Command{
+	Use: "pipeline --provider <ci> --from <rev> [--to <rev>] [--out <file>]",
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		if ciProvider == "" {
+			return errors.New("requires provider")
+		}
+
+		return writePipeline(ciProvider)
+	}),
+}
+
+var publishStatusCmd = &cobra.Command{
+	Use: "publish-status --provider <ci> --build-summary <file> [--log-dir <dir>]",
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		if ciProvider == "" {
+			return errors.New("requires provider")
+		}
+
+		if buildSummary == "" {
+			return errors.New("requires build summary")
+		}
+
+		summary, err := lib.ReadInvocationSummary(buildSummary)
+		if err != nil {
+			return err
+		}
+
+		statuses, err := lib.PublishCommitStatuses(summary, &lib.CommitStatusOptions{
+			Provider:   ciProvider,
+			Repository: ciRepository,
+			Sha:        statusSha,
+			LogDir:     logDir,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, s := range statuses {
+			fmt.Printf("%s: %s\n", s.Name, s.Title)
+		}
+		return nil
+	}),
+}
+
+// writePipeline writes the pipeline of the CI system building
+// the modules affected by the changes.
+func writePipeline(provider string) error {
+	if ciFrom == "" {
+		return errors.New("requires base revision")
+	}
+
+	m, err := system.Affected(ciFrom, ciTo, purpose)
+	if err != nil {
+		return err
+	}
+
+	options := &lib.PipelineOptions{Image: ciImage, Stage: ciStage}
+	if out == "" {
+		return m.WritePipeline(provider, options, os.Stdout)
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return m.WritePipeline(provider, options, f)
+}
@@ -0,0 +1,337 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// Kinds of values completed dynamically.
+const (
+	completeModules = "modules"
+	completeRefs    = "refs"
+	completeTags    = "tags"
+)
+
+// completionAnnotation is the annotation of commands specifying the
+// kind of their positional arguments.
+const completionAnnotation = "mbt-completion"
+
+// completionLine is the flag of the hidden completion command used by
+// shells that cannot pass the word under the cursor when it is empty.
+var completionLine string
+
+// flagCompletions specifies the kind of the values of flags.
+var flagCompletions = map[string]string{
+	"name":   completeModules,
+	"module": completeModules,
+	"branch": completeRefs,
+	"commit": completeRefs,
+	"src":    completeRefs,
+	"dst":    completeRefs,
+	"from":   completeRefs,
+	"to":     completeRefs,
+	"base":   completeRefs,
+	"head":   completeRefs,
+	"first":  completeRefs,
+	"second": completeRefs,
+	"query":  completeTags,
+}
+
+var completionScripts = map[string]string{
+	"bash":       bashCompletion,
+	"zsh":        zshCompletion,
+	"fish":       fishCompletion,
+	"powershell": powershellCompletion,
+}
+
+func init() {
+	for _, c := range []*cobra.Command{buildBranch, buildCommit, describeBranchCmd, describeCommitCmd, runInBranch, runInCommit, applyBranchCmd, applyCommitCmd} {
+		c.Annotations = map[string]string{completionAnnotation: completeRefs}
+	}
+	changelogCmd.Annotations = map[string]string{completionAnnotation: completeModules}
+
+	completeCmd.Flags().StringVar(&completionLine, "line", "", "Command line up to the cursor")
+
+	RootCmd.AddCommand(completionCmd)
+	RootCmd.AddCommand(completeCmd)
+}
+
+var completionCmd = &cobra.Command{
+	Use:   "completion <bash|zsh|fish|powershell>",
+	Short: docText("completion-summary"),
+	Long:  docText("completion"),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return e.NewError(lib.ErrClassUser, "requires the shell")
+		}
+
+		script, ok := completionScripts[args[0]]
+		if !ok {
+			return e.NewErrorf(lib.ErrClassUser, "unsupported shell '%s'", args[0])
+		}
+
+		_, err := fmt.Fprint(os.Stdout, script)
+		return err
+	},
+}
+
+// completeCmd prints the candidates for the last word in its arguments.
+// It is invoked by the scripts printed with the completion command.
+var completeCmd = &cobra.Command{
+	Use:    "__complete [--line <line>] -- <words>",
+	Hidden: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		words := args
+		if completionLine != "" {
+			words = strings.Fields(completionLine)
+			if len(words) > 0 {
+				// First word is the name of the program.
+				words = words[1:]
+			}
+			if strings.TrimRight(completionLine, " \t") != completionLine || len(words) == 0 {
+				words = append(words, "")
+			}
+		}
+
+		if len(words) == 0 {
+			words = []string{""}
+		}
+
+		for _, c := range complete(words, &completionSource{}) {
+			fmt.Println(c)
+		}
+
+		// Errors are not reported since they would be displayed in
+		// the middle of the command line.
+		return nil
+	},
+}
+
+// completionSource loads the completions of the repository on demand.
+type completionSource struct {
+	completions *lib.Completions
+	loaded      bool
+}
+
+func (s *completionSource) get() *lib.Completions {
+	if s.loaded {
+		return s.completions
+	}
+	s.loaded = true
+
+	if system == nil {
+		path, err := repoPath()
+		if err != nil {
+			return nil
+		}
+
+		system, err = lib.NewSystemWithContext(lib.WithRepoBackend(context.Background(), gitBackend), path, lib.LogLevelNormal)
+		if err != nil {
+			return nil
+		}
+		if importManifest() != nil {
+			return nil
+		}
+	}
+
+	s.completions, _ = system.Completions()
+	return s.completions
+}
+
+func (s *completionSource) values(kind string) []string {
+	c := s.get()
+	if c == nil {
+		return nil
+	}
+
+	switch kind {
+	case completeModules:
+		return c.Modules
+	case completeRefs:
+		return c.Refs
+	case completeTags:
+		// Tags are completed as query expressions selecting them.
+		r := make([]string, 0, len(c.Tags))
+		for _, t := range c.Tags {
+			r = append(r, fmt.Sprintf("\"%s\" in tags", t))
+		}
+		return r
+	default:
+		return nil
+	}
+}
+
+// complete returns the candidates for the last word in words.
+func complete(words []string, source *completionSource) []string {
+	current := words[len(words)-1]
+	preceding := words[:len(words)-1]
+	cmd, _, _ := RootCmd.Find(preceding)
+	if cmd == nil {
+		cmd = RootCmd
+	}
+
+	// Value of a flag in --flag=value form.
+	if strings.HasPrefix(current, "--") && strings.Contains(current, "=") {
+		i := strings.Index(current, "=")
+		return flagValues(cmd, current[2:i], current[:i+1], current[i+1:], source)
+	}
+
+	if n := len(preceding); n > 0 {
+		prev := preceding[n-1]
+		// Bash splits --flag=value into three words.
+		if prev == "=" && n > 1 && strings.HasPrefix(preceding[n-2], "-") {
+			return flagValues(cmd, strings.TrimLeft(preceding[n-2], "-"), "", current, source)
+		}
+
+		if f := lookupFlag(cmd, prev); f != nil && f.NoOptDefVal == "" {
+			return flagValues(cmd, f.Name, "", current, source)
+		}
+	}
+
+	if strings.HasPrefix(current, "-") {
+		return flagNames(cmd, current)
+	}
+
+	if cmd.HasAvailableSubCommands() {
+		candidates := make([]string, 0)
+		for _, c := range cmd.Commands() {
+			if c.IsAvailableCommand() && strings.HasPrefix(c.Name(), current) {
+				candidates = append(candidates, c.Name())
+			}
+		}
+		return candidates
+	}
+
+	return withPrefix(source.values(cmd.Annotations[completionAnnotation]), "", current)
+}
+
+func flagValues(cmd *cobra.Command, flag, prefix, current string, source *completionSource) []string {
+	kind := flagCompletions[flag]
+	if kind == "" || lookupFlag(cmd, "--"+flag) == nil {
+		return nil
+	}
+
+	// Name flags accept a comma separated list of modules.
+	if kind == completeModules {
+		if i := strings.LastIndex(current, ","); i >= 0 {
+			prefix, current = prefix+current[:i+1], current[i+1:]
+		}
+	}
+
+	return withPrefix(source.values(kind), prefix, current)
+}
+
+func flagNames(cmd *cobra.Command, current string) []string {
+	candidates := make([]string, 0)
+	visit := func(f *pflag.Flag) {
+		if n := "--" + f.Name; !f.Hidden && strings.HasPrefix(n, current) {
+			candidates = append(candidates, n)
+		}
+	}
+	cmd.Flags().VisitAll(visit)
+	cmd.InheritedFlags().VisitAll(visit)
+	return candidates
+}
+
+// lookupFlag finds the flag specified in a word (e.g. --name or -n)
+// of a command.
+func lookupFlag(cmd *cobra.Command, word string) *pflag.Flag {
+	var f *pflag.Flag
+	switch {
+	case strings.HasPrefix(word, "--"):
+		f = cmd.Flags().Lookup(word[2:])
+		if f == nil {
+			f = cmd.InheritedFlags().Lookup(word[2:])
+		}
+	case strings.HasPrefix(word, "-") && len(word) == 2:
+		f = cmd.Flags().ShorthandLookup(word[1:])
+		if f == nil {
+			f = cmd.InheritedFlags().ShorthandLookup(word[1:])
+		}
+	}
+	return f
+}
+
+func withPrefix(values []string, prefix, current string) []string {
+	candidates := make([]string, 0, len(values))
+	for _, v := range values {
+		if strings.HasPrefix(v, current) {
+			candidates = append(candidates, prefix+v)
+		}
+	}
+	return candidates
+}
+
+const bashCompletion = `# bash completion for mbt
+_mbt_completion()
+{
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    local IFS=$'\n'
+    local candidate
+    COMPREPLY=()
+    for candidate in $(mbt __complete -- "${COMP_WORDS[@]:1:$COMP_CWORD}" 2>/dev/null); do
+        if [[ "$candidate" == *" "* ]]; then
+            candidate=$(printf '%q' "$candidate")
+        fi
+        COMPREPLY+=("$candidate")
+    done
+}
+complete -o default -F _mbt_completion mbt
+`
+
+const zshCompletion = `#compdef mbt
+# zsh completion for mbt
+_mbt() {
+    local -a candidates
+    candidates=(${(f)"$(mbt __complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+    compadd -- $candidates
+}
+compdef _mbt mbt
+`
+
+const fishCompletion = `# fish completion for mbt
+function __mbt_complete
+    set -l words (commandline -opc)
+    set -e words[1]
+    set -l current (commandline -ct)
+    mbt __complete -- $words "$current" 2>/dev/null
+end
+complete -c mbt -f -a '(__mbt_complete)'
+`
+
+const powershellCompletion = `# powershell completion for mbt
+Register-ArgumentCompleter -Native -CommandName mbt -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $line = $commandAst.Extent.Text
+    $length = $cursorPosition - $commandAst.Extent.StartOffset
+    if ($length -lt $line.Length) { $line = $line.Substring(0, $length) }
+    if ($wordToComplete -eq '' -and -not $line.EndsWith(' ')) { $line += ' ' }
+    mbt __complete --line "$line" 2>$null | ForEach-Object {
+        $text = $_
+        if ($text.Contains(' ')) { $text = "'" + $text + "'" }
+        [System.Management.Automation.CompletionResult]::new($text, $_, 'ParameterValue', $_)
+    }
+}
+`
@@ -0,0 +1,61 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+)
+
+// configProfileEnv is the environment variable selecting the profile
+// when --config-profile is not specified.
+const configProfileEnv = "MBT_CONFIG_PROFILE"
+
+// applyFlagDefaults sets the flags of a command that are not specified
+// in the command line to the defaults in the repository and user
+// configuration.
+// Defaults for flags that are not available in the command are ignored
+// since a profile is shared by all commands.
+func applyFlagDefaults(cmd *cobra.Command) error {
+	profile := configProfile
+	if profile == "" {
+		profile = os.Getenv(configProfileEnv)
+	}
+
+	defaults, err := lib.FlagDefaults(in, lib.UserConfigPath(), profile)
+	if err != nil {
+		return err
+	}
+
+	flags := cmd.Flags()
+	for name, values := range defaults {
+		f := flags.Lookup(name)
+		if f == nil || f.Changed {
+			continue
+		}
+
+		for _, v := range values {
+			if err := flags.Set(name, v); err != nil {
+				return e.NewErrorf(lib.ErrClassUser, "invalid default value '%s' for flag --%s: %v", v, name, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -16,22 +16,42 @@ limitations under the License.
 package cmd
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/mbtproject/mbt/lib"
 	"github.com/spf13/cobra"
 )
 
 var (
-	toJSON     bool
-	toGraph    bool
-	dependents bool
+	toJSON       bool
+	toGraph      bool
+	dependents   bool
+	format       string
+	cluster      string
+	neighbour    string
+	depth        int
+	formatTmpl   string
+	columns      string
+	sortBy       string
+	exportBranch string
+	exportCommit string
+	anonymize    bool
+	salt         string
+	atConfig     string
 )
 
+// formatText is the default format of describe, a table of modules.
+const formatText = "text"
+
 func init() {
 	describePrCmd.Flags().StringVar(&src, "src", "", "Source branch")
 	describePrCmd.Flags().StringVar(&dst, "dst", "", "Destination branch")
@@ -43,16 +63,42 @@ func init() {
 	describeDiffCmd.Flags().StringVar(&from, "from", "", "From commit")
 	describeDiffCmd.Flags().StringVar(&to, "to", "", "To commit")
 
+	describeGraphCmd.Flags().StringVar(&from, "from", "", "Highlight the modules changed between this commit and --to")
+	describeGraphCmd.Flags().StringVar(&to, "to", "", "Highlight the modules changed between --from and this commit")
+	describeGraphCmd.Flags().StringVar(&cluster, "cluster", "", "Group modules by directory (dir) or the first value of their tags property (tag)")
+	describeGraphCmd.Flags().StringVar(&neighbour, "module", "", "Restrict the graph to the neighbourhood of this module")
+	describeGraphCmd.Flags().IntVar(&depth, "depth", 1, "Number of dependencies or dependents followed from --module (0 to follow all)")
+	describeGraphCmd.Flags().BoolVar(&anonymize, "anonymize", false, "Replace the names and paths of modules with their hashes")
+	describeGraphCmd.Flags().StringVar(&salt, "salt", "", "Salt of the hashes used with --anonymize (random by default)")
+
+	describeExportCmd.Flags().StringVar(&out, "out", "", "Write the manifest to this file instead of stdout")
+	describeExportCmd.Flags().StringVar(&exportBranch, "branch", "", "Export the manifest of this branch")
+	describeExportCmd.Flags().StringVar(&exportCommit, "commit", "", "Export the manifest of this commit")
+	describeExportCmd.Flags().StringVar(&src, "src", "", "Export the manifest of the changes in this branch (with --dst)")
+	describeExportCmd.Flags().StringVar(&dst, "dst", "", "Export the manifest of the changes in --src since it diverged from this branch")
+	describeExportCmd.Flags().StringVar(&from, "from", "", "Export the manifest of the changes between this commit and --to")
+	describeExportCmd.Flags().StringVar(&to, "to", "", "Export the manifest of the changes between --from and this commit")
+	describeExportCmd.Flags().BoolVar(&anonymize, "anonymize", false, "Replace the names, paths and hashes of modules with their hashes and remove the other attributes of specs")
+	describeExportCmd.Flags().StringVar(&salt, "salt", "", "Salt of the hashes used with --anonymize (random by default)")
+
 	describeLocalCmd.Flags().BoolVarP(&all, "all", "a", false, "Describe all")
 
+	describeBranchCmd.Flags().StringVar(&atCommit, "commit", "", "Use this commit instead of a branch (e.g. in a detached head checkout)")
 	describeCommitCmd.Flags().BoolVarP(&content, "content", "c", false, "Describe the modules impacted by the changes in commit")
+	describeCommitCmd.Flags().StringVar(&atConfig, "at-config", "", "Interpret the commit with the module specs and configuration of this commit")
 
 	describeCmd.PersistentFlags().BoolVarP(&fuzzy, "fuzzy", "f", false, "Use fuzzy match when filtering")
+	describeCmd.PersistentFlags().StringVar(&query, "query", "", "Select modules matching this expression (e.g. 'name =~ \"^svc-\" && \"backend\" in tags')")
 	describeCmd.PersistentFlags().StringVarP(&name, "name", "n", "", "Describe modules with a name that matches this value. Multiple names can be specified as a comma separated string.")
 
-	describeCmd.PersistentFlags().BoolVar(&toJSON, "json", false, "Format output as json")
+	describeCmd.PersistentFlags().StringVar(&format, "format", formatText, "Output format (text, json, yaml, dot or template). json and yaml use a versioned schema")
+	describeCmd.PersistentFlags().StringVar(&formatTmpl, "template", "", "Go template used to format the output with --format template")
+	describeCmd.PersistentFlags().BoolVar(&toJSON, "json", false, "Format output as json keyed by module name (use --format json for the versioned schema)")
+	describeCmd.PersistentFlags().StringVar(&columns, "columns", defaultColumns, "Comma separated list of columns in the table (name, path, version, tags, owners or built)")
+	describeCmd.PersistentFlags().StringVar(&sortBy, "sort", "", "Sort the table by this column")
 	describeCmd.PersistentFlags().BoolVar(&toGraph, "graph", false, "Format output as dot graph")
 	describeCmd.PersistentFlags().BoolVar(&dependents, "dependents", false, "Output dependents on potential change")
+	addScopeFlags(describeCmd.PersistentFlags(), false)
 
 	describeCmd.AddCommand(describeCommitCmd)
 	describeCmd.AddCommand(describeBranchCmd)
@@ -61,6 +107,8 @@ func init() {
 	describeCmd.AddCommand(describePrCmd)
 	describeCmd.AddCommand(describeIntersectionCmd)
 	describeCmd.AddCommand(describeDiffCmd)
+	describeCmd.AddCommand(describeGraphCmd)
+	describeCmd.AddCommand(describeExportCmd)
 
 	RootCmd.AddCommand(describeCmd)
 }
@@ -74,16 +122,22 @@ var describeCmd = &cobra.Command{
 var describeBranchCmd = &cobra.Command{
 	Use: "branch <branch>",
 	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
-		branch := "master"
-		if len(args) > 0 {
-			branch = args[0]
+		branch, err := branchArg(args)
+		if err != nil {
+			return err
+		}
+
+		var m *lib.Manifest
+		if atCommit != "" {
+			m, err = system.ManifestByCommit(atCommit)
+		} else {
+			m, err = system.ManifestByBranch(branch)
 		}
-		m, err := system.ManifestByBranch(branch)
 		if err != nil {
 			return err
 		}
 
-		m, err = m.ApplyFilters(&lib.FilterOptions{Name: name, Fuzzy: fuzzy, Dependents: dependents})
+		m, err = m.ApplyFilters(filterOptions())
 
 		if err != nil {
 			return err
@@ -101,7 +155,7 @@ var describeHeadCmd = &cobra.Command{
 			return err
 		}
 
-		m, err = m.ApplyFilters(&lib.FilterOptions{Name: name, Fuzzy: fuzzy, Dependents: dependents})
+		m, err = m.ApplyFilters(filterOptions())
 
 		if err != nil {
 			return err
@@ -119,14 +173,14 @@ var describeLocalCmd = &cobra.Command{
 			err error
 		)
 
-		if all {
+		if all || scope != "" || label != "" {
 			m, err = system.ManifestByWorkspace()
 
 			if err != nil {
 				return err
 			}
 
-			m, err = m.ApplyFilters(&lib.FilterOptions{Name: name, Fuzzy: fuzzy, Dependents: dependents})
+			m, err = m.ApplyFilters(filterOptions())
 		} else {
 			m, err = system.ManifestByWorkspaceChanges()
 		}
@@ -155,13 +209,13 @@ var describePrCmd = &cobra.Command{
 			return err
 		}
 
-		m, err = m.ApplyFilters(&lib.FilterOptions{Name: name, Fuzzy: fuzzy, Dependents: dependents})
+		m, err = m.ApplyFilters(filterOptions())
 
 		if err != nil {
 			return err
 		}
 
-		return output(m.Modules)
+		return outputRange(m.Modules, dst, src)
 	}),
 }
 
@@ -179,7 +233,12 @@ var describeCommitCmd = &cobra.Command{
 			err error
 		)
 
-		if content {
+		if atConfig != "" {
+			if content {
+				return errors.New("--at-config cannot be used with --content")
+			}
+			m, err = system.ManifestByCommitAtConfig(commit, atConfig)
+		} else if content {
 			m, err = system.ManifestByCommitContent(commit)
 		} else {
 			m, err = system.ManifestByCommit(commit)
@@ -189,7 +248,7 @@ var describeCommitCmd = &cobra.Command{
 			return err
 		}
 
-		m, err = m.ApplyFilters(&lib.FilterOptions{Name: name, Fuzzy: fuzzy, Dependents: dependents})
+		m, err = m.ApplyFilters(filterOptions())
 
 		if err != nil {
 			return err
@@ -250,20 +309,185 @@ var describeDiffCmd = &cobra.Command{
 			return err
 		}
 
-		m, err = m.ApplyFilters(&lib.FilterOptions{Name: name, Fuzzy: fuzzy, Dependents: dependents})
+		m, err = m.ApplyFilters(filterOptions())
 
 		if err != nil {
 			return err
 		}
 
-		return output(m.Modules)
+		return outputRange(m.Modules, from, to)
 	}),
 }
 
+var describeGraphCmd = &cobra.Command{
+	Use: "graph [--format dot|mermaid] [--from <commit> --to <commit>] [--cluster dir|tag] [--module <name>]",
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		if (from == "") != (to == "") {
+			return errors.New("requires both from and to commits to highlight changes")
+		}
+
+		m, err := system.ManifestByCurrentBranch()
+		if err != nil {
+			return err
+		}
+
+		mods := m.Modules
+		if neighbour != "" {
+			mods, err = mods.Neighborhood(neighbour, depth)
+			if err != nil {
+				return err
+			}
+		}
+
+		options := &lib.GraphOptions{Format: format, Cluster: cluster}
+		if format == formatText {
+			options.Format = lib.GraphFormatDot
+		}
+
+		if from != "" {
+			changed, err := system.ManifestByDiff(from, to)
+			if err != nil {
+				return err
+			}
+			options.Highlight = changed.Modules
+		}
+
+		if anonymize {
+			a, err := newAnonymizer()
+			if err != nil {
+				return err
+			}
+
+			if mods, err = a.Modules(mods); err != nil {
+				return err
+			}
+			if options.Highlight, err = a.Modules(options.Highlight); err != nil {
+				return err
+			}
+		}
+
+		s, err := mods.SerializeGraph(options)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(s)
+		return nil
+	}),
+}
+
+var describeExportCmd = &cobra.Command{
+	Use: "export [--out <file>] [--branch <branch> | --commit <sha> | --src <branch> --dst <branch> | --from <commit> --to <commit>]",
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		m, err := selectedManifest()
+		if err != nil {
+			return err
+		}
+
+		m, err = m.ApplyFilters(filterOptions())
+		if err != nil {
+			return err
+		}
+
+		if anonymize {
+			a, err := newAnonymizer()
+			if err != nil {
+				return err
+			}
+
+			if m, err = a.Manifest(m); err != nil {
+				return err
+			}
+		}
+
+		if out == "" {
+			return m.Export(os.Stdout)
+		}
+
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+
+		err = m.Export(f)
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		return err
+	}),
+}
+
+// newAnonymizer creates the anonymizer used with --anonymize. A random
+// salt is used unless --salt is specified.
+func newAnonymizer() (*lib.Anonymizer, error) {
+	if salt != "" {
+		return lib.NewAnonymizer(salt), nil
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return lib.NewAnonymizer(hex.EncodeToString(b)), nil
+}
+
+// selectedManifest builds the manifest selected by the flags of
+// describe export and sbom. Defaults to the manifest of the current
+// branch.
+func selectedManifest() (*lib.Manifest, error) {
+	switch {
+	case (src == "") != (dst == ""):
+		return nil, errors.New("requires both src and dst branches")
+	case (from == "") != (to == ""):
+		return nil, errors.New("requires both from and to commits")
+	case src != "":
+		return system.ManifestByPr(src, dst)
+	case from != "":
+		return system.ManifestByDiff(from, to)
+	case exportCommit != "":
+		return system.ManifestByCommit(exportCommit)
+	case exportBranch != "":
+		return system.ManifestByBranch(exportBranch)
+	default:
+		return system.ManifestByCurrentBranch()
+	}
+}
+
 const columnWidth = 30
 
 func output(mods lib.Modules) error {
-	if toJSON {
+	return outputDescription(mods, nil)
+}
+
+// outputRange outputs the modules changed between two revisions.
+// Descriptions include the issues referenced by the commits in
+// between.
+func outputRange(mods lib.Modules, from, to string) error {
+	if format == formatText || format == lib.GraphFormatDot {
+		return output(mods)
+	}
+
+	issues, err := system.Issues(from, to)
+	if err != nil {
+		return err
+	}
+
+	return outputDescription(mods, issues)
+}
+
+func outputDescription(mods lib.Modules, issues map[string][]string) error {
+	if format == lib.DescriptionFormatTemplate {
+		if formatTmpl == "" {
+			return errors.New("--format template requires the template, specify --template argument")
+		}
+		return mods.Describe().WithIssues(issues).WriteTemplate(formatTmpl, os.Stdout)
+	} else if formatTmpl != "" {
+		return errors.New("--template can only be specified with --format template")
+	} else if format == lib.GraphFormatDot {
+		outputDot(mods)
+	} else if format != formatText {
+		return mods.Describe().WithIssues(issues).Write(format, os.Stdout)
+	} else if toJSON {
 		m := make(map[string]map[string]interface{})
 		for _, a := range mods {
 			v := make(map[string]interface{})
@@ -279,22 +503,107 @@ func output(mods lib.Modules) error {
 		}
 		fmt.Println(string(buff))
 	} else if toGraph {
-		if dependents {
-			fmt.Println(mods.GroupedSerializeAsDot())
-		} else {
-			fmt.Println(mods.SerializeAsDot())
-		}
+		outputDot(mods)
 	} else {
-		w := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
-		fmt.Fprintf(w, "Name\tPATH\tVERSION\n")
-		for _, a := range mods {
-			fmt.Fprintf(w, "%s\t%s\t%s\n", a.Name(), a.Path(), a.Version())
+		return outputTable(mods)
+	}
+
+	return nil
+}
+
+// outputDot prints the dependency graph of the modules in graphviz
+// dot format.
+func outputDot(mods lib.Modules) {
+	if dependents {
+		fmt.Println(mods.GroupedSerializeAsDot())
+	} else {
+		fmt.Println(mods.SerializeAsDot())
+	}
+}
+
+const defaultColumns = "name,path,version"
+
+// describeColumn is a column of the table output of describe.
+type describeColumn struct {
+	title string
+	value func(m *lib.Module, built map[string]time.Time) string
+}
+
+var describeColumns = map[string]*describeColumn{
+	// Title of name column is retained for compatibility.
+	"name":    {"Name", func(m *lib.Module, _ map[string]time.Time) string { return m.Name() }},
+	"path":    {"PATH", func(m *lib.Module, _ map[string]time.Time) string { return m.Path() }},
+	"version": {"VERSION", func(m *lib.Module, _ map[string]time.Time) string { return m.Version() }},
+	"tags": {"TAGS", func(m *lib.Module, _ map[string]time.Time) string {
+		tags, _ := m.Properties()["tags"].([]interface{})
+		values := make([]string, 0, len(tags))
+		for _, t := range tags {
+			values = append(values, fmt.Sprint(t))
+		}
+		return strings.Join(values, ",")
+	}},
+	"owners": {"OWNERS", func(m *lib.Module, _ map[string]time.Time) string { return strings.Join(m.Owners(), ",") }},
+	// Built is formatted in UTC so that sorting by the text is chronological.
+	"built": {"BUILT", func(m *lib.Module, built map[string]time.Time) string {
+		t, ok := built[m.Name()]
+		if !ok {
+			return "-"
 		}
+		return t.UTC().Format(time.RFC3339)
+	}},
+}
 
-		if err := w.Flush(); err != nil {
-			panic(err)
+func outputTable(mods lib.Modules) error {
+	var selected []*describeColumn
+	needsBuilt := false
+	for _, c := range strings.Split(columns, ",") {
+		c = strings.TrimSpace(c)
+		column, ok := describeColumns[c]
+		if !ok {
+			return fmt.Errorf("unknown column '%s'", c)
 		}
+		selected = append(selected, column)
+		needsBuilt = needsBuilt || c == "built"
 	}
 
-	return nil
+	var sortColumn *describeColumn
+	if sortBy != "" {
+		var ok bool
+		if sortColumn, ok = describeColumns[sortBy]; !ok {
+			return fmt.Errorf("unknown sort column '%s'", sortBy)
+		}
+		needsBuilt = needsBuilt || sortBy == "built"
+	}
+
+	var built map[string]time.Time
+	if needsBuilt {
+		var err error
+		if built, err = system.LastBuilt(); err != nil {
+			return err
+		}
+	}
+
+	if sortColumn != nil {
+		mods = append(lib.Modules{}, mods...)
+		sort.SliceStable(mods, func(i, j int) bool {
+			return sortColumn.value(mods[i], built) < sortColumn.value(mods[j], built)
+		})
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+	titles := make([]string, 0, len(selected))
+	for _, c := range selected {
+		titles = append(titles, c.title)
+	}
+	fmt.Fprintf(w, "%s\n", strings.Join(titles, "\t"))
+
+	for _, a := range mods {
+		values := make([]string, 0, len(selected))
+		for _, c := range selected {
+			values = append(values, c.value(a, built))
+		}
+		fmt.Fprintf(w, "%s\n", strings.Join(values, "\t"))
+	}
+
+	return w.Flush()
 }
@@ -0,0 +1,60 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// diagnosticsFormat is the format of the diagnostics written to stderr.
+var diagnosticsFormat string
+
+// writeDiagnostic writes the diagnostic of the error a command failed
+// with to stderr.
+// Text diagnostics are translated with the catalog of the language of
+// the locale and colored if stderr is a terminal (and NO_COLOR is not
+// set). JSON diagnostics are never translated so that tools can rely on
+// the messages.
+func writeDiagnostic(err error) {
+	format := diagnosticsFormat
+	if lib.ValidateDiagnosticsFormat(format) != nil {
+		format = lib.DiagnosticsFormatText
+	}
+
+	var catalog lib.DiagnosticCatalog
+	if format == lib.DiagnosticsFormatText {
+		var cerr error
+		catalog, cerr = lib.LoadDiagnosticCatalog(lib.DiagnosticLanguage())
+		if cerr != nil {
+			logrus.Warn(cerr)
+		}
+	}
+
+	color := format == lib.DiagnosticsFormatText &&
+		runtime.GOOS != "windows" &&
+		os.Getenv("NO_COLOR") == "" &&
+		terminal.IsTerminal(int(os.Stderr.Fd()))
+
+	if werr := lib.Diagnose(err, catalog).Write(os.Stderr, format, color); werr != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
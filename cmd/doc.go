@@ -38,17 +38,59 @@ build: Dictionary of build commands specific to a platform (optional)
   default: (optional)
     cmd: Default command to run when os specific command is not found (required)
     args: Array of arguments to default build command (optional)
+    dir: Working directory relative to the module directory (optional)
+    shell: Shell used to interpret cmd (sh, bash, powershell, pwsh or cmd) (optional)
   linux|darwin|windows:
     cmd: Operating system specific command name (required)
     args: Array of arguments (optional)
+    dir: Working directory relative to the module directory (optional)
+    shell: Shell used to interpret cmd (optional)
 dependencies: An array of modules that this module's build depend on (optional)
-fileDependencies: An array of file names that this module's build depend on (optional)
+fileDependencies: An array of files or directories (relative to the repository root) that this module's build depend on (optional)
+ignore: An array of patterns (gitignore syntax) of the paths excluded from this module (optional)
 commands: Optional dictionary of custom commands (optional)
   name: Custom command name (required)
   cmd: Command name (required)
   args: Array of arguments (optional)
 	os: Array of os identifiers where this command should run (optional)
+  dir: Working directory relative to the module directory (optional)
+  shell: Shell used to interpret cmd (optional)
 properties: Custom dictionary to hold any module specific information (optional)
+image: Container image used to run the commands of this module (optional)
+docker: Image built with docker buildx when there is no build command for the platform (optional)
+  image: Repository of the image, tagged with the module version (required)
+  dockerfile, context: Dockerfile and build context relative to the module directory (optional)
+  platforms: Array of platforms of a multi platform image e.g. linux/arm64 (optional)
+  args, target: Build arguments and the stage to build (optional)
+  cacheFrom, cacheTo: Array of buildx cache sources and destinations (optional)
+terraform: Terraform root module of this module (optional)
+  dir: Root module relative to the module directory (optional)
+  workspace: Terraform workspace of the stack (optional)
+  vars: Dictionary of variables and the paths of their properties e.g. cluster.size (optional)
+hooks: Lifecycle hooks of the module build (optional)
+  preBuild|postBuild|onFailure: Array of commands (cmd and args) (optional)
+matrix: Dictionary of variables and their values to build the module with (optional)
+resources: Resources required to build the module (optional)
+  cpu: Number of cores (optional)
+  memory: Amount of memory e.g. 8Gi (optional)
+  locks: Array of names of resources used exclusively (optional)
+serializeOn: Array of keys, modules sharing a key are never built concurrently (optional)
+fingerprint: Command (cmd and args) writing the interface of the module to stdout (optional)
+flaky: Set to true if the module build is known to fail intermittently (optional)
+env: Dictionary of environment variables for the commands of this module (optional)
+secrets: Array of names of environment variables with sensitive values (optional)
+requires: Array of names of environment variables required by the commands of this module (optional)
+reports: Array of patterns of test report files (junit xml) produced by the build (optional)
+owners: Array of owners (e.g. teams) of the module (optional)
+labels: Dictionary of labels (e.g. team: payments) selecting the module with --label (optional)
+outputs: Array of patterns of files produced by the build to publish with --publish (optional)
+publish: Array of targets of the outputs, defaults to publish in .mbt/config.yml (optional)
+  oci|s3|http: Repository prefix, s3://bucket/prefix or base url of the artifacts
+  username, password, headers: Credentials of oci and http targets (optional)
+externalDependencies: Array of packages outside the repository used by the module (optional)
+  name, version, purl, license: Name, version, package url and SPDX license of the package
+environments: Dictionary of overlays of the module keyed by environment name (optional)
+  properties: Properties merged over the module properties in this environment (optional)
 {{c ""}}
 
 {{h2 "Build Command"}}
@@ -61,6 +103,141 @@ found {{link "here" "https://golang.org/doc/install/source#environment"}}
 When the command is applicable for multiple operating systems, you could list it as
 the default command. Operating system specific commands take precedence.
 
+By default, {{c "cmd"}} is executed directly with {{c "args"}} in the module
+directory. Set {{c "dir"}} to run it in a sub directory of the module.
+When {{c "shell"}} is specified, {{c "cmd"}} is a script interpreted by that
+shell and {{c "args"}} are passed to it as positional parameters
+(e.g. {{c "$1"}} in {{c "sh"}} and {{c "bash"}}). This avoids the need for wrapper
+scripts and platform specific quoting.
+
+PowerShell scripts ({{c ".ps1"}}) and, on Windows, batch files ({{c ".cmd"}} and {{c ".bat"}})
+are executed with {{c "powershell -File"}} and {{c "cmd /C"}} respectively when {{c "shell"}}
+is not specified. {{c "powershell"}} is substituted with {{c "pwsh"}} on other operating
+systems so that the same command works everywhere.
+
+{{c ""}}
+build:
+  default:
+    cmd: go build -o "$1" ./...
+    args: [../bin/app]
+    dir: src
+    shell: bash
+{{c ""}}
+
+{{h2 "Command Templates"}}
+{{c "cmd"}} and {{c "args"}} of build commands, user defined commands and hooks
+of a module can reference the manifest using {{link "go templates" "https://golang.org/pkg/text/template/"}}
+when the spec sets {{c "templates: true"}}. Templates are expanded before the command is executed.
+Commands are executed as they are otherwise, so that arguments such as
+{{c "go list -f '{{.ImportPath}}'"}} do not have to be escaped. Commands specified after
+{{c "--"}} with {{c "mbt run"}} or {{c "mbt run-in ... -- exec"}} are never expanded.
+
+{{c ""}}
+templates: true
+build:
+  default:
+    cmd: docker
+    args: [build, -t, "myapp:{{"{{.Module.Version}}"}}", "{{"{{.Module.Path}}"}}"]
+{{c ""}}
+
+Following data is available to the templates:
+
+{{c ".Sha"}} - Commit being built
+{{br}}
+{{c ".Module"}} - Current module (e.g. {{c "{{.Module.Name}}"}}, {{c "{{.Module.Path}}"}}, {{c "{{.Module.Version}}"}})
+{{br}}
+{{c ".Modules"}} - Dictionary of all modules in the manifest keyed by name
+{{br}}
+{{c ".Env"}} - Dictionary of environment variables of the command
+{{br}}
+
+{{c "property"}} and {{c "propertyOr"}} functions can be used to read module properties
+(e.g. {{c "{{property .Module \"tag\"}}"}}). Use {{c "{{\"{{\"}}"}} to pass a literal {{c "{{"}}
+to the command.
+
+{{h2 "Container Builds"}}
+When {{c "image"}} is specified, build and user defined commands of the module
+are executed in a container created from that image. Repository is mounted at
+{{c "/mbt/repo"}} and the working directory is set to the module directory within
+it. Build environment variables are passed into the container and the exit
+status of the command is propagated. Container runtime defaults to {{c "docker"}}
+and can be changed with {{c "--container-runtime"}} option (e.g. {{c "podman"}}).
+
+{{h2 "Docker Builds"}}
+Modules producing a container image can declare the image instead of a build command.
+mbt builds such modules with {{c "docker buildx build"}} using the Dockerfile and the context
+in the spec and tags the image with the version of the module. Since the version only changes
+when the content of the module (or its dependencies) changes, mbt checks the registry first and
+skips the build if the tag is already there. Registry credentials are read from the docker
+configuration. A build command for the platform takes precedence over {{c "docker"}}.
+
+{{c ""}}
+docker:
+  image: ghcr.io/acme/app-a
+  platforms: [linux/amd64, linux/arm64]
+  cacheFrom: [type=registry,ref=ghcr.io/acme/app-a:cache]
+  cacheTo: [type=registry,ref=ghcr.io/acme/app-a:cache,mode=max]
+{{c ""}}
+
+Images are loaded into the local image store, except multi platform images which
+docker cannot load. Use {{c "--publish"}} to push the images instead, in which case the pushed
+image and its digest are recorded in the invocation summary. Values of the matrix variables of
+a variant are passed as build arguments and the variant is tagged with the version of the variant.
+Pipelines generated with {{c "mbt ci"}} always push the images.
+
+{{h2 "Build Matrix"}}
+A module can be built multiple times with different parameters by declaring
+a build matrix. Build command is executed once for each combination of values
+in the matrix.
+
+{{c ""}}
+matrix:
+  arch: [amd64, arm64]
+  env: [dev, prod]
+{{c ""}}
+
+Values of the current combination are available to the build command in
+{{c "MBT_MATRIX_<NAME>"}} environment variables (e.g. {{c "MBT_MATRIX_ARCH"}}).
+{{c "MBT_VARIANT"}} contains the name of the combination (e.g. {{c "arch=amd64,env=dev"}})
+and {{c "MBT_VARIANT_VERSION"}} contains a version derived from the module
+version and the combination, suitable for tagging the artifacts of each variant.
+
+{{h2 "Build Hooks"}}
+Hooks are commands executed at various stages of a module build.
+{{c "preBuild"}} hooks run before the build command, {{c "postBuild"}} hooks
+run after a successful build and {{c "onFailure"}} hooks run when the build
+command or any preceding hook fails.
+
+Hooks applicable to all modules can be declared in {{c ".mbt/config.yml"}}
+file at the root of the repository.
+
+{{c ""}}
+hooks:
+  preBuild:
+    - cmd: ./scripts/start-emulator.sh
+{{c ""}}
+
+Repository hooks wrap the hooks declared in module spec. Hooks are executed
+in the module directory with the same environment as the build command.
+A JSON document describing the module being built is written to their
+standard input.
+
+{{c "setup"}} and {{c "teardown"}} hooks declared in {{c ".mbt/config.yml"}} run once per
+build in the repository root, before the first module is built and after the
+last one. Teardown hooks run even if the setup or the build failed, the error
+is included in the JSON document written to their standard input. Failure of a
+setup hook fails the build without building any module.
+
+{{c ""}}
+hooks:
+  setup:
+    - cmd: docker
+      args: [compose, up, -d]
+  teardown:
+    - cmd: docker
+      args: [compose, down]
+{{c ""}}
+
 {{h2 "Dependencies"}}
 {{ c "mbt"}} comes with a set of primitives to manage build dependencies. Current build
 tools do a good job in managing dependencies between source files/projects.
@@ -73,6 +250,9 @@ One advantage of a single repo is that you can share code between multiple modul
 more effectively. Building this type of dependencies requires some thought. mbt provides
 an easy way to define dependencies between modules and automatically builds the impacted modules
 in topological order.
+Modules that do not depend on each other are ordered by their directories, so the same
+repository content always produces the same order in manifests, builds and their summaries
+(including concurrent builds), regardless of how the modules were discovered.
 
 Dependencies are defined in {{c ".mbt.yml" }} file under 'dependencies' property.
 It accepts an array of module names.
@@ -90,7 +270,9 @@ a build script that is used to build multiple modules could define a file
 dependency in order to trigger the build whenever there's a change in build.
 
 File dependencies should specify the path of the file relative to the root
-of the repository.
+of the repository. A file dependency can also be a directory (e.g. shared proto
+definitions), in which case a change to any file in it impacts the module.
+Modules depending on the impacted module are impacted as well.
 
 {{h2 "Module Version"}}
 For each module stored within a repository, {{c "mbt"}} generates a unique
@@ -105,6 +287,189 @@ As a result, version of a module is changed only when any of those attributes
 are changed making it a safe attribute to use for tagging the 
 build artifacts (i.e. tar balls, container images).
 
+{{h2 "Queries"}}
+describe, build, apply and run-in commands accepting {{c "--name"}} also accept {{c "--query"}}
+to select modules with an expression (e.g. {{c "--query 'name =~ \"^svc-\" && \"backend\" in tags'"}}).
+Queries are applied after the {{c "--name"}} filter.
+
+- Attributes: {{c "name"}}, {{c "path"}}, {{c "version"}}, {{c "tags"}}, {{c "owners"}}, {{c "commands"}}, {{c "labels.<name>"}} and {{c "properties.<name>"}} (dot notation for nested properties)
+- Literals: strings in double quotes, numbers, {{c "true"}} and {{c "false"}}
+- Operators: {{c "=="}}, {{c "!="}}, {{c "=~"}} (regular expression match), {{c "!~"}}, {{c "in"}} (membership of a list), {{c "&&"}}, {{c "||"}}, {{c "!"}} and parentheses
+- Functions: {{c "depends_on(\"<module>\")"}} and {{c "required_by(\"<module>\")"}} (direct or indirect dependencies)
+
+Attributes without an operator are true unless they are missing, false, empty strings or empty lists
+(e.g. {{c "properties.public && !(\"deprecated\" in tags)"}}).
+
+{{h2 "Scoped Commands"}}
+describe, build and run-in commands accepting {{c "--name"}} also accept {{c "--scope here"}} to
+select the module containing the current directory, i.e. the module in the closest parent
+directory (e.g. {{c "cd apps/app-a/src && mbt build local --scope here"}}).
+Add {{c "--dependencies"}} to include the modules it depends on and {{c "--dependents"}} to
+include the modules depending on it. The scope is applied after the {{c "--name"}} filter and
+before {{c "--query"}}. Commands fail if the current directory is not in a module.
+
+{{h2 "Labels"}}
+Modules can declare {{c "labels"}} in {{c ".mbt.yml"}} to be selected by commands accepting
+{{c "--scope"}} with {{c "--label"}} (e.g. {{c "mbt build local --label team=payments,tier=svc"}}).
+Modules are selected if they have all of the labels specified. Like other selections, it can
+be expanded with {{c "--dependencies"}} and {{c "--dependents"}}.
+
+{{c ""}}
+name: payments-api
+labels:
+  team: payments
+  tier: svc
+{{c ""}}
+
+{{h2 "Shallow and Partial Clones"}}
+Shallow clones (e.g. {{c "git clone --depth <n>"}}) are supported as long as the commits used by
+a command are within the history fetched. For example, {{c "diff"}} and {{c "pr"}} commands require
+the merge base of the commits and {{c "commit --content"}} requires the parent of the commit.
+An error describing how to fetch more history is reported otherwise. History used by
+{{c "changelog"}} is truncated at the shallow boundary.
+
+Partial clones (i.e. {{c "git clone --filter <filter>"}}) are not supported by {{c "libgit2"}} backend.
+Use a clone without a filter instead.
+
+{{h2 "Paths on Windows"}}
+Paths in module specs (i.e. {{c "fileDependencies"}}, {{c "reports"}} and {{c "outputs"}}) can be
+written with backslashes. They are converted to forward slashes on every operating system,
+therefore a spec resolves the same files and modules have the same versions on Windows,
+Linux and macOS.
+
+Paths of changed files are matched with the module directories and file dependencies
+ignoring the case, so that a change affects the same modules on case insensitive file
+systems. Enable {{c "caseSensitive"}} in {{c "paths"}} section of {{c ".mbt/config.yml"}} to
+match them in a case sensitive manner.
+
+{{c "paths:"}}
+{{c "  caseSensitive: true"}}
+
+{{h2 "Excluding Files"}}
+Paths excluded from a module do not change its version and are not considered changes of
+the module. Specs in excluded directories are not discovered as modules.
+Exclusions are declared with {{c "ignore"}} in the module spec or in {{c ".mbtignore"}} files
+in the module directory and its subdirectories. Both use gitignore syntax with the patterns
+relative to the directory of the spec or the file.
+
+{{c "*.md"}}
+{{c "!CHANGELOG.md"}}
+{{c "testdata/"}}
+
+Patterns in {{c ".mbtignore"}} files are applied after the ones in the spec, the files in deeper
+directories taking precedence. A {{c ".mbtignore"}} file applies to all modules containing it.
+
+{{h2 "Submodules"}}
+Changing the commit a submodule points to is a change of the module containing the
+submodule. Module specs in submodules are discovered as modules of the repository when
+{{c "recurse"}} is enabled in {{c "submodules"}} section of {{c ".mbt/config.yml"}}.
+
+{{c "submodules:"}}
+{{c "  recurse: true"}}
+
+Paths of these modules and their file dependencies are relative to the repository root
+and their versions are derived from the content of the submodule. Any change of the commit
+a submodule points to is a change of all modules in the submodule. Submodules must be
+initialised (i.e. {{c "git submodule update --init --recursive"}}) to discover the modules in them.
+
+{{h2 "Git LFS"}}
+Files tracked with Git LFS are never smudged during the module discovery. Versions of modules
+are derived from the pointer files in the commit tree, therefore they are the same in smudged
+and unsmudged checkouts. A smudged object in the workspace is not a change unless it differs
+from the object referenced by the pointer file in the index.
+
+Versions can be derived from the ids of LFS objects instead of the pointer files by enabling
+{{c "objects"}} in {{c "lfs"}} section of {{c ".mbt/config.yml"}}. Changes to pointer files
+referring to the same objects (e.g. addition of extensions) do not change the versions in that case.
+
+{{c "lfs:"}}
+{{c "  objects: true"}}
+
+Commits checked out by mbt contain the pointer files. Fetch the objects in build commands
+requiring them (e.g. {{c "git lfs pull"}}).
+
+{{h2 "Sparse Checkout"}}
+Repositories using cone mode sparse checkout (i.e. {{c "git sparse-checkout set --cone"}}) are supported.
+Modules are discovered from the tree objects of commits, therefore commands operating on commits
+consider all modules regardless of the directories materialized in the workspace.
+
+Files excluded from the sparse checkout are not changes of the workspace. Commands operating on the
+workspace (e.g. {{c "build local"}}) read the specs of the modules outside the materialized directories
+from the head commit, so that the dependencies of the materialized modules are resolved.
+
+Modules outside the materialized directories are skipped when building or running commands.
+Only the commit checked out in the workspace can be built or run. Use a full checkout to
+build other commits.
+
+{{h2 "Bare and Remote Repositories"}}
+Commands reading commits (e.g. {{c "describe"}}, {{c "affected"}} and {{c "apply"}}) can be used
+with bare repositories, so that the modules impacted by a change can be queried without a working tree.
+Specify the path to the repository with {{c "--in"}} or run mbt in the repository directory.
+
+A url of a remote repository can be specified with {{c "--in"}} as well
+(e.g. {{c "mbt describe branch master --in https://github.com/org/repo.git"}}).
+Branches and tags of the repository are fetched into a bare repository in the cache directory
+({{c "$MBT_CACHE_DIR"}} or the cache directory of the user) each time mbt is run.
+
+Commands requiring a working tree (e.g. {{c "build"}}, {{c "run-in"}} and {{c "describe local"}})
+report an error in bare repositories.
+
+{{h2 "Remote Authentication"}}
+Remote repositories (e.g. git template sources and repositories specified with {{c "--in"}})
+are fetched with the credentials configured in the environment.
+
+Https remotes use {{c "MBT_GIT_USERNAME"}} and {{c "MBT_GIT_PASSWORD"}} (e.g. a personal access token)
+if they are set, otherwise the credentials of the host in the netrc file ({{c "$NETRC"}} or {{c "~/.netrc"}}).
+Ssh remotes use the private key in {{c "MBT_GIT_SSH_KEY"}} (protected with {{c "MBT_GIT_SSH_PASSPHRASE"}})
+if it is set, otherwise the keys in the SSH agent.
+
+Each source of credentials is offered once and mbt reports an error when the remote rejects them.
+
+{{h2 "Revisions"}}
+Branches and commits can be specified with any revision accepted by {{c "git rev-parse"}}.
+For example, remote branches ({{c "origin/feature/x"}}), tags (including annotated tags),
+abbreviated shas, {{c "HEAD~3"}}, {{c ":/message"}} and {{c "@{upstream}"}}.
+
+{{h2 "Detached Head"}}
+CI systems often check out a commit or a tag rather than a branch.
+Commands using the current branch (e.g. {{c "mbt build head"}}) use the commit checked out
+when the head is detached.
+Commands accepting a branch default to {{c "master"}} or, if the repository does not have one,
+the commit checked out.
+{{c "--commit"}} can be used with these commands to specify a commit instead of a branch
+e.g. {{c "mbt build branch --commit $CI_COMMIT_SHA"}}.
+
+{{h2 "Discovery Cache"}}
+Modules discovered in a commit are cached in {{c ".git/mbt/discovery"}} along with the versions
+of the modules and their file dependencies.
+Modules in a commit that is not in the cache are discovered by examining the specs changed since
+the most recently cached commit, rather than walking the entire tree of the commit.
+Cache entries are keyed on the commit sha and the repository configuration, therefore, they are not
+used once {{c ".mbt/config.yml"}} is changed.
+It is safe to delete the cache directory at any time.
+
+{{h2 "Git Backends"}}
+Repositories are read with the backend specified with {{c "--git-backend"}} or in {{c "MBT_GIT_BACKEND"}}
+environment variable. If neither is specified, the backend is selected based on the metadata directory
+in the repository ({{c ".git"}}, {{c ".sl"}} or {{c ".hg"}}). {{c "libgit2"}} is the default
+unless mbt is built with {{c "nolibgit2"}} tag, which excludes {{c "libgit2"}} backend and uses
+{{c "go-git"}} instead.
+
+Following backends are built into mbt.
+
+- {{c "libgit2"}} reads git repositories.
+- {{c "git"}} reads git repositories with {{c "git"}} executable, e.g. when mbt is linked with a version
+  of libgit2 that cannot read the repository. Versions of modules are the same as with {{c "libgit2"}}.
+- {{c "go-git"}} reads git repositories with go-git, a pure go implementation of git that neither
+  requires libgit2 nor {{c "git"}} executable. Versions of modules are the same as with {{c "libgit2"}}.
+- {{c "sapling"}} reads Sapling repositories with {{c "sl"}} executable.
+- {{c "hg"}} reads Mercurial repositories with {{c "hg"}} executable.
+
+Versions of modules in Sapling and Mercurial repositories are derived from the file hashes
+in the manifest of the commit. Commands based on the workspace (e.g. {{c "build local"}}),
+the history (e.g. {{c "changelog"}}) and hooks are supported only with {{c "libgit2"}} backend.
+Submodules are supported with {{c "libgit2"}}, {{c "git"}} and {{c "go-git"}} backends.
+
 {{h2 "Document Generation"}}
 {{ c "mbt" }} has a powerful feature that exposes the module state inferred from
 the repository to a template engine. This could be quite useful for generating
@@ -115,13 +480,105 @@ sha.
 
 See {{c "apply"}} command for more details.
 
+{{h2 "Configuration Profiles"}}
+Default values of command line flags can be declared in {{c "defaults"}} and named
+{{c "profiles"}} of {{c ".mbt/config.yml"}}. Select a profile with {{c "--config-profile <name>"}},
+{{c "MBT_CONFIG_PROFILE"}} environment variable or {{c "profile"}} key of the configuration.
+Keys are the flag names without dashes. Lists are used for flags accepted multiple times.
+
+{{c ""}}
+defaults:
+  progress: plain
+profiles:
+  ci:
+    jobs: 8
+    log-format: json
+    environment: staging
+    env: [CI=true]
+  local:
+    jobs: 2
+{{c ""}}
+
+The same keys can be declared in the user configuration file ({{c "~/.config/mbt/config.yml"}}
+or {{c "$XDG_CONFIG_HOME/mbt/config.yml"}}) to override the repository configuration for a user.
+Flags specified in the command line take precedence over defaults and defaults of flags not
+available in a command are ignored.
+
+{{h2 "Exit Codes"}}
+Exit code of {{c "mbt"}} tells the reason of a failure without parsing the output.
+
+- {{c "0"}} Command succeeded
+- {{c "1"}} Command failed for a reason not listed below (e.g. invalid arguments)
+- {{c "2"}} There were no modules to build or run the command in ({{c "build"}} and {{c "run-in"}})
+- {{c "3"}} Build or command of one or more modules failed
+- {{c "4"}} Module spec or repository configuration is invalid (e.g. a parse error or a cyclic dependency)
+- {{c "130"}} Command was interrupted (SIGINT or SIGTERM) before completion
+
+{{h2 "Interrupts"}}
+On SIGINT (Ctrl-C) or SIGTERM, {{c "mbt"}} stops discovering and diffing modules and does not start
+the commands of any more modules. Commands already running are asked to terminate (SIGTERM
+to their process group, the process tree is killed in windows) and they are killed if they do
+not exit within 10 seconds. The summary file is still written and modules not started are
+listed as {{c "notStarted"}}. A second interrupt exits immediately.
+
+Commands are started in their own process group unless the standard input is a terminal, in
+which case they receive Ctrl-C from the terminal directly.
+
+Use {{c "--summary-file <file>"}} with any command to write a json summary including
+the exit code. Summaries of {{c "build"}} and {{c "run-in"}} also list the modules (see
+{{c "mbt build --help"}}), summaries of other commands are in following format.
+
+{{c ""}}
+{"command": "describe head", "commit": "", "success": false, "error": "...", "exitCode": 4, "modules": []}
+{{c ""}}
+
+{{h2 "Diagnostics"}}
+Errors are reported with a stable code (e.g. {{c "MBT1003"}} for a file dependency that is not found),
+the location of the error in specs and configuration files where it is known, the errors that caused it
+and a suggested fix.
+
+{{c ""}}
+Error [MBT1003]: Failed to find the file dependency shared/foo.txt in module app-a in app-a
+  --> app-a/.mbt.yml:3
+  help: Specify the path of the file dependency relative to the repository root
+{{c ""}}
+
+Use {{c "--diagnostics json"}} to write the diagnostic as a json object on a line of stderr for tools
+(e.g. editors and CI annotations). Summaries written with {{c "--summary-file"}} and the report of
+{{c "mbt doctor --format json"}} contain the same diagnostics.
+
+- {{c "MBT0xxx"}} Errors without a specific code (e.g. invalid arguments or unexpected errors)
+- {{c "MBT1xxx"}} Module specs and repository configuration
+- {{c "MBT2xxx"}} Repository (e.g. commits, branches and shallow clones)
+- {{c "MBT3xxx"}} Builds, commands and policies
+- {{c "MBT4xxx"}} Templates and queries
+- {{c "MBT5xxx"}} Plugins
+
+Text diagnostics are colored when stderr is a terminal unless {{c "NO_COLOR"}} is set. They are
+translated with the catalog of the language in {{c "MBT_LANG"}} (or the locale, i.e. {{c "LC_ALL"}},
+{{c "LC_MESSAGES"}} or {{c "LANG"}}) found in {{c "~/.config/mbt/locales/<language>.yml"}} (e.g. {{c "de_DE.yml"}} or {{c "de.yml"}}).
+Catalogs contain the messages and suggestions keyed by code. Messages receive the same arguments as
+the original messages. JSON diagnostics are never translated.
+
+{{c ""}}
+MBT4006:
+  message: Modul %v wurde nicht gefunden
+  suggestion: Namen der Module mit mbt describe prüfen
+{{c ""}}
+
+{{h2 "Profiling"}}
+Use {{c "--cpuprofile <file>"}} and {{c "--memprofile <file>"}} with any command to write
+the cpu profile of the command and the memory in use at the end of it. Use
+{{c "--pprof-addr <host:port>"}} to inspect mbt with {{c "go tool pprof"}} while the command
+is running (e.g. {{c "go tool pprof http://localhost:6060/debug/pprof/heap"}}).
 `,
 	"apply-summary": `Apply repository manifest over a go template`,
 	"apply": `{{cli "Apply repository manifest over a go template\n" }}
-{{c "mbt apply branch [name] --to <path>"}}{{br}}
+{{c "mbt apply branch [name | --commit <sha>] --to <path>"}}{{br}}
 Apply the manifest of the tip of the branch to a template.
 Template path should be relative to the repository root and must be available
-in the commit tree. Assume master if name is not specified.
+in the commit tree. Assume master (or the commit checked out if there is no master branch) if name is not specified.
+Use --commit to specify a commit instead.
 
 {{c "mbt apply commit <commit> --to <path>"}}{{br}}
 Apply the manifest of a commit to a template.
@@ -135,8 +592,162 @@ in the commit tree.
 
 {{c "mbt apply local --to <path>"}}{{br}}
 Apply the manifest of local workspace to a template.
-Template path should be relative to the repository root and must be available
-in the workspace.
+Template is read from the workspace, therefore changes to it can be tried without
+committing them. Template path should be relative to the repository root or an
+absolute path, which can be outside the repository.
+
+Use {{c "--to -"}} to read the template from stdin (e.g. {{c "mbt apply local --to - < deploy.tmpl"}}).
+Templates read from stdin are go templates unless {{c "--engine"}} is specified.
+
+{{c "mbt apply diff --from <sha> --to <sha> <path>"}}{{br}}
+Render the template at both commits for each module changed between them and print
+a unified diff of the output. Template is rendered once for each module as in
+{{c "--split-per-module"}}, therefore the module being rendered is available in {{c ".Module"}}.
+Changes to the template alone do not change the set of modules compared.
+
+{{c "mbt apply kubernetes --from <sha> --to <sha> <path>"}}{{br}}
+Render the template at {{c "--to"}} commit for each module changed between the commits
+and apply the output of each module to a kubernetes cluster with server-side apply
+({{c "kubectl apply --server-side"}}). Module being rendered is available in {{c ".Module"}}.
+Use {{c "--context"}} to select the kubeconfig context and {{c "--dry-run"}} to validate the
+output on the server without persisting it.
+
+Use {{c "--prune <label>"}} to delete the resources of a module that are no longer in its
+output. Resources of each module must be labelled with {{c "<label>=<module name>"}}
+(e.g. {{c "--prune app.kubernetes.io/name"}}). Modules without any output are skipped,
+therefore their resources are not pruned.
+
+{{h2 "File Per Module"}}
+Use {{c "--split-per-module --out-dir <dir>"}} to render the template once for each
+module into a separate file in {{c "<dir>"}}. Module being rendered is available in
+{{c ".Module"}}. Use {{c "--name"}} (and {{c "--fuzzy"}}) to render the template for
+selected modules only.
+
+Files are named by {{c "--file-pattern"}}, a go template executed with the module
+(e.g. {{c "{{.Path}}/deployment.yaml"}}). By default, files are named by the module
+name and the extension of the template excluding {{c ".tmpl"}} (e.g. {{c "app-a.yaml"}}
+for {{c "deploy.yaml.tmpl"}}).
+
+{{h2 "Helm Charts and Kustomize Overlays"}}
+Use {{c "--generate helm --out-dir <dir>"}} to generate a minimal helm chart for each module
+in {{c "<dir>/<module name>"}}. Output of the template is written to {{c "templates"}} of
+the chart and the properties of the module are written to {{c "values.yaml"}}. Version of the
+chart is {{c "0.0.0-<module version>"}}.
+
+Use {{c "--generate kustomize --out-dir <dir>"}} to generate a kustomize overlay for each module
+instead. Resources in the output are labelled with {{c "app.kubernetes.io/name=<module name>"}}
+and the scalar properties of the module are available in the {{c "<module name>-properties"}}
+config map.
+
+Output is named by the template excluding {{c ".tmpl"}} if it is a yaml file or {{c "resources.yaml"}}
+otherwise. Use {{c "--name"}} (and {{c "--fuzzy"}}) to generate for selected modules only.
+
+{{h2 "Values"}}
+Use {{c "--values <file>"}} to merge the values in a yaml file into the properties of
+each module for the duration of rendering. Nested dictionaries are merged and other
+values replace the properties of the same name. {{c "--values"}} can be specified
+multiple times and later files override earlier ones.
+
+Use {{c "--set <key>=<value>"}} to override a single value (e.g. {{c "--set image.name=app"}}).
+Values specified with {{c "--set"}} are parsed as yaml and take precedence over values files.
+Quote the values that should be strings (e.g. {{c "--set 'image.tag=\"1.0\"'"}}).
+
+{{c ""}}
+mbt apply head --to deploy.tmpl --values prod.yaml --set replicas=3
+{{c ""}}
+
+{{h2 "Environments"}}
+Use {{c "--environment <name>"}} to merge the properties declared for that environment
+in {{c "environments"}} section of module specs over the base properties (see {{c "mbt build --help"}}).
+Name of the environment is available in {{c ".Environment"}}.
+Values specified with {{c "--values"}} and {{c "--set"}} are merged over the properties of
+the environment.
+
+Values of each environment can also be kept in a single yaml file keyed by the name of
+the environment. Use {{c "--env-file <file>"}} with {{c "--environment"}} to merge the values
+of that environment over {{c "--values"}} ({{c "--set"}} still takes precedence).
+
+{{c ""}}
+staging:
+  replicas: 1
+prod:
+  replicas: 3
+  region: eu
+{{c ""}}
+
+Use {{c "--all-envs"}} (with {{c "--env-file"}} and {{c "--out-dir"}}) to render the template
+once for each environment in the file. Output of each environment is written to a directory
+named after the environment in {{c "--out-dir"}}. The file is named after {{c "--out"}} or the
+template excluding {{c ".tmpl"}}, or is split per module with {{c "--split-per-module"}} and
+{{c "--generate"}}.
+
+{{c ""}}
+mbt apply head --to deploy.yaml.tmpl --env-file envs.yaml --all-envs --out-dir manifests
+# manifests/prod/deploy.yaml
+# manifests/staging/deploy.yaml
+{{c ""}}
+
+{{h2 "Strict Mode"}}
+By default, missing keys and module properties are rendered as {{c "<no value>"}}.
+Use {{c "--strict"}} to fail instead. Errors include the line and column of the
+expression in the template (e.g. {{c "template:3:8: ... Property replicas is not found in module app-a"}}).
+
+Properties of modules can be described with a schema in {{c "propertiesSchema"}} of
+{{c ".mbt/config.yml"}}. Schema is a subset of json schema ({{c "type"}}, {{c "enum"}},
+{{c "pattern"}}, {{c "properties"}}, {{c "required"}}, {{c "additionalProperties"}} and {{c "items"}}).
+When specified, properties of all modules are validated before applying a template.
+
+{{c ""}}
+propertiesSchema:
+  type: object
+  required: [replicas]
+  properties:
+    replicas:
+      type: integer
+    tags:
+      type: array
+      items:
+        type: string
+{{c ""}}
+
+{{h2 "Validators"}}
+Commands listed in {{c "validators"}} of {{c ".mbt/config.yml"}} validate the output of
+templates (e.g. with {{c "kubeconform"}} or {{c "opa eval"}}). Output is written to the stdin of
+each validator and a non zero exit status fails apply. Output is validated for each module
+with {{c "--split-per-module"}} and is not written if it is rejected by any validator.
+
+Validators are executed in the repository directory with {{c "MBT_TEMPLATE"}} set to the
+path of the template and the {{c "MBT_MODULE_XXX"}} variables describing the module being
+rendered. {{c "templates"}} restricts a validator to the templates matching the patterns.
+
+{{c ""}}
+validators:
+  - cmd: kubeconform
+    args: [-strict, -summary, "-"]
+    templates: ["deploy/*.yaml.tmpl"]
+  - cmd: opa eval --fail-defined -I -d policy 'data.deny[_]'
+    shell: sh
+{{c ""}}
+
+{{h2 "Template Engines"}}
+Templates are {{link "go templates" "https://golang.org/pkg/text/template/"}} by default.
+Templates with {{c ".jsonnet"}} extension are evaluated with {{link "jsonnet" "https://jsonnet.org"}}
+and templates with {{c ".cue"}} extension are exported with {{link "cue" "https://cuelang.org"}}.
+Use {{c "--engine <go|jsonnet|cue>"}} to select the engine explicitly.
+{{c "jsonnet"}} and {{c "cue"}} executables must be available in {{c "PATH"}}.
+
+Both engines receive the same data as go templates ({{c "Sha"}}, {{c "Environment"}}, {{c "Env"}}, {{c "Modules"}},
+{{c "ModulesList"}} and {{c "Module"}}) with modules represented by their {{c "Name"}},
+{{c "Path"}}, {{c "Version"}}, {{c "Hash"}}, {{c "Properties"}}, {{c "Requires"}},
+{{c "RequiredBy"}} and {{c "Owners"}}.
+In jsonnet, data is available via {{c "std.extVar(\"mbt\")"}} and partials can be imported
+by their names. In cue, data is available in the hidden field {{c "_mbt"}} and partials with
+{{c ".cue"}} extension are unified with the template. Output of both engines is json.
+
+{{c ""}}
+local mbt = std.extVar("mbt");
+{ name: mbt.Module.Name, image: "app:" + mbt.Module.Version }
+{{c ""}}
 
 {{h2 "Template Helpers"}}
 Following helper functions are available when writing templates.
@@ -150,6 +761,23 @@ Find the specified property in the given module. Standard dot notation can be us
 {{ c "propertyOr <module> <name> <default>" }}{{br}}
 Find specified property in the given module or return the designated default value.
 
+{{ c "dependenciesOf <name>" }}{{br}}
+Return the modules the specified module depends on, directly or transitively.
+
+{{ c "dependentsOf <name>" }}{{br}}
+Return the modules depending on the specified module, directly or transitively.
+
+{{ c "topSorted" }}{{br}}
+Return all modules in the order they are built (i.e. a module appears after its dependencies).
+Modules returned by {{ c "dependenciesOf" }} and {{ c "dependentsOf" }} are in the same order.
+
+{{ c "withTag <tag> <modules>" }}{{br}}
+Select the modules with the specified tag in their {{ c "tags" }} property
+(e.g. {{ c "{{range withTag \"frontend\" topSorted}}" }}).
+
+{{ c "withProperty <name> <value> <modules>" }}{{br}}
+Select the modules with the specified value in the property. Dot notation can be used to access nested properties.
+
 {{ c "contains <array> <item>" }}{{br}}
 Return true if the given item is present in the array.
 
@@ -159,14 +787,14 @@ Format each item in the array according the format specified and then join them
 {{ c "kvplist <map>" }}{{br}}
 Take a map of {{ c "map[string]interface{}" }} and return the items in the map as a list of key/value pairs sorted by the key. They can be accessed via {{ c ".Key" }} and {{ c ".Value" }} properties.
 
-{{ c "add <int> <int>" }}{{br}}
-Add two integers.
+{{ c "add <int>..." }}{{br}}
+Add integers.
 
 {{ c "sub <int> <int>" }}{{br}}
 Subtract two integers.
 
-{{ c "mul <int> <int>" }}{br}
-Multiply two integers.
+{{ c "mul <int>..." }}{{br}}
+Multiply integers.
 
 {{ c "div <int> <int>" }}{{br}}
 Divide two integers.
@@ -185,11 +813,122 @@ Return true if the specified value is the tail of the array/slice.
 
 These functions can be pipelined to simplify complex template expressions. Below is an example of emitting the word "foo"
 when module "app-a" has the value "a" in it's tags property.
+
+{{h2 "Partials"}}
+Templates in {{c ".mbt/partials"}} directory (or the directory specified in {{c "partials"}}
+of {{c ".mbt/config.yml"}}) are available to all templates by their path relative to that
+directory. Templates defined in them with {{c "define"}} are available as well.
+Partials are read from the same commit (or the workspace for {{c "mbt apply local"}}) as
+the template.
+
+{{ c "template <name> <data>" }}{{br}}
+Execute the partial with the specified data.
+
+{{ c "include <name> <data>" }}{{br}}
+Execute the partial and return the output as a string so that it can be pipelined
+(e.g. {{c "{{include \"labels.tmpl\" . | indent 4}}"}}).
+
+{{h2 "Custom Functions"}}
+Commands listed in {{c "templateFuncs"}} of {{c ".mbt/config.yml"}} are available to go
+templates as functions named by their keys. Arguments of the function are written to
+the stdin of the command as a json array (e.g. {{c "[\"db/password\"]"}} for
+{{c "{{vault \"db/password\"}}"}}). Output of the command is parsed as json and is
+used as a string if it is not valid json. A non zero exit status fails apply.
+
+Commands are executed in the repository directory. Each function is executed once for
+the same arguments when applying a template.
+
+{{c ""}}
+templateFuncs:
+  vault:
+    cmd: jq -r '.[0]' | xargs vault kv get -field=value
+    shell: sh
+{{c ""}}
+
+Programs using mbt as a library can add functions with {{c "ApplyOptions.Funcs"}}.
+
+{{h2 "Template Sources"}}
+Templates can be shared across repositories by publishing them in a git repository
+or an OCI registry and declaring it in {{c "templateSources"}} of {{c ".mbt/config.yml"}}.
+Templates in a source are referenced as {{c "<source>:<path>"}}
+(e.g. {{c "mbt apply head --to platform:deploy/service.yaml.tmpl"}}).
+
+{{c ""}}
+templateSources:
+  platform:
+    git: https://github.com/org/templates.git
+    ref: v1.2.0
+    partials: partials
+  charts:
+    oci: ghcr.io/org/templates:1.0
+    username: ${REGISTRY_USER}
+    password: ${REGISTRY_TOKEN}
+    digest: sha256:5d0f...
+{{c ""}}
+
+Content of a source is cached in {{c ".git/mbt/templates"}}. Use {{c "digest"}} (commit sha
+for git, manifest digest for OCI) to pin the content of a source. Pinned sources are
+verified when they are fetched and are not fetched again once they are in the cache.
+Credentials can reference the host environment variables listed in {{c "hostEnv"}}.
+Partials in the {{c "partials"}} directory of a source are available to its templates.
+Partials of the repository take precedence over them.
+
+{{h2 "Builtin Templates"}}
+Default templates and partials are embedded in the mbt binary, so no auxiliary files are
+required to use them. Builtin templates are referenced as {{c "mbt:<path>"}}
+(e.g. {{c "mbt apply head --to mbt:versions.json.tmpl"}}).
+
+{{c "versions.json.tmpl"}}{{br}}
+A json object of module names and versions.
+
+{{c "modules.md.tmpl"}}{{br}}
+A markdown table of modules with their versions and paths.
+
+The builtin partial {{c "mbt.versions"}} is available to all templates. Declaring a template
+source named {{c "mbt"}} in {{c "templateSources"}} overrides the builtin templates, and
+partials of the repository or a template source take precedence over the builtin partials.
+
+{{h2 "Sprig Functions"}}
+In addition, templates can use the following functions compatible with
+{{link "sprig" "https://masterminds.github.io/sprig"}} (and {{c "toYaml"}}/{{c "fromYaml"}} of helm).
+{{c "contains"}}, {{c "join"}}, {{c "head"}} and {{c "tail"}} retain the behavior described above.
+Use {{c "has"}}, {{c "first"}} and {{c "last"}} for the sprig behavior of list functions.
+
+Strings: {{c "trim trimAll trimPrefix trimSuffix upper lower title repeat replace hasPrefix hasSuffix substr trunc nospace snakecase kebabcase camelcase quote squote cat indent nindent splitList toString toStrings"}}
+{{br}}
+Defaults: {{c "default empty coalesce ternary fail"}}
+{{br}}
+Numbers: {{c "atoi int int64 float64 add add1 sub mul div mod max min until"}}
+{{br}}
+Lists: {{c "list first last rest initial append prepend concat uniq has without compact reverse sortAlpha"}}
+{{br}}
+Dictionaries: {{c "dict get set unset hasKey keys values pick omit merge"}}
+{{br}}
+Regular expressions: {{c "regexMatch regexFind regexFindAll regexReplaceAll regexSplit"}}
+{{br}}
+Encoding: {{c "b64enc b64dec sha1sum sha256sum toJson toPrettyJson fromJson toYaml fromYaml"}}
+{{br}}
+Environment: {{c "env expandenv"}}
+{{br}}
+Semantic versions: {{c "semver semverCompare"}}
+
+{{c "semver"}} parses a version (e.g. {{c "v1.2.3-rc.1"}}) into {{c ".Major"}}, {{c ".Minor"}}, {{c ".Patch"}},
+{{c ".Prerelease"}} and {{c ".Metadata"}}. {{c "semverCompare <constraint> <version>"}} supports
+{{c "= != > < >= <="}}, {{c "~"}} (patch updates) and {{c "^"}} (updates not changing the left-most
+non-zero component). Comparisons separated by commas or spaces must all match and
+{{c "||"}} separates alternatives (e.g. {{c "semverCompare \">= 1.2, < 2 || ^3.1\" .Version"}}).
+
+{{c ""}}
+metadata:
+  labels:
+    {{"{{- property (module \"app-a\") \"labels\" | toYaml | nindent 4}}"}}
+{{c ""}}
 `,
 	"build-summary": `Run build command`,
 	"build": `{{cli "Run build command \n"}}
-{{c "mbt build branch [name] [--content] [--name <name>] [--fuzzy]"}}{{br}}
-Build modules in a branch. Assume master if branch name is not specified.
+{{c "mbt build branch [name | --commit <sha>] [--content] [--name <name>] [--fuzzy]"}}{{br}}
+Build modules in a branch. Assume master (or the commit checked out if there is no master branch) if branch name is not specified.
+Use --commit to specify a commit instead.
 Build just the modules matching the {{c "--name"}} filter if specified.
 Default {{c "--name"}} filter is a prefix match. You can change this to a subsequence
 match by using {{c "--fuzzy"}} option.
@@ -216,6 +955,7 @@ match by using {{c "--fuzzy"}} option.
 Build modules changed between {{c "--src"}} and {{c "--dst"}} branches.
 In this mode, mbt works out the merge base between {{c "--src"}} and {{c "--dst"}} and
 evaluates the modules changed between the merge base and {{c "--src"}}.
+Issues are listed like in {{c "describe diff"}}.
 
 {{c "mbt build local [--all] [--content] [--name <name>] [--fuzzy]"}}{{br}}
 Build modules modified in current workspace. All modules in the workspace are
@@ -224,6 +964,18 @@ Build just the modules matching the {{c "--name"}} filter if specified.
 Default {{c "--name"}} filter is a prefix match. You can change this to a subsequence
 match by using {{c "--fuzzy"}} option.
 
+{{c "mbt build local --interactive [--name <name>] [--query <query>]"}}{{br}}
+Select the modules in current workspace to build from a list. Typing text narrows
+the list down to the modules matching it fuzzily, typing the numbers of modules
+(e.g. {{c "1 3-5"}}) toggles their selection and an empty line builds the selection.
+
+{{c "mbt build local --watch [--debounce <duration>]"}}{{br}}
+Build the modules as above and then keep watching the workspace, rebuilding the
+modules impacted by each change and the modules depending on them (see {{c "mbt watch --help"}}).
+Rebuilds are restricted to the modules selected with {{c "--interactive"}}, {{c "--name"}},
+{{c "--query"}}, {{c "--scope"}} or {{c "--label"}} if any of them is specified.
+A summary of the modules rebuilt is printed after each rebuild.
+
 {{h2 "Build Environment"}}
 
 When executing build, following environment variables are initialised and can be
@@ -234,29 +986,477 @@ used by the command being executed.
 - {{c "MBT_MODULE_VERSION"}} Module version
 - {{c "MBT_BUILD_COMMIT"}} Git commit SHA of the commit being built
 - {{c "MBT_REPO_PATH"}} Absolute path to the repository directory
+- {{c "MBT_ENVIRONMENT"}} Name of the environment specified with {{c "--environment"}}
 
 In addition to the variables listed above, module properties are also populated 
 in the form of {{c "MBT_MODULE_PROPERTY_XXX"}} where {{c "XXX"}} denotes the key.
+
+{{h2 "Custom Environment"}}
+Additional environment variables can be declared in three layers, each one
+overriding the previous.
+
+- {{c "env"}} in {{c ".mbt/config.yml"}} applicable to all modules
+- {{c "env"}} in module spec
+- {{c "--env KEY=VALUE"}} ({{c "-e"}}) option specified in the command line
+
+Values declared in configuration files can reference host environment
+variables in the form of {{c "${VAR}"}}. To prevent builds from depending on
+arbitrary host state, only the variables listed in {{c "hostEnv"}} of
+{{c ".mbt/config.yml"}} can be referenced.
+
+Variables listed in {{c "secrets"}} (in {{c ".mbt/config.yml"}} or module spec)
+are considered sensitive. Their values are replaced with {{c "***"}} in the
+output of commands, logs and build plans.
+
+{{c ""}}
+env:
+  REGISTRY: registry.example.com
+  REGISTRY_TOKEN: ${CI_REGISTRY_TOKEN}
+hostEnv: [CI_REGISTRY_TOKEN]
+secrets: [REGISTRY_TOKEN]
+{{c ""}}
+
+Variables listed in {{c "requires"}} of a module spec must be set, either in the host
+environment or in the environment described above (including {{c "--env"}} values set
+in a profile). They are verified for all modules before a build or {{c "run-in"}} executes
+anything and the error lists the missing variables of each module.
+
+{{c ""}}
+requires: [ARTIFACTORY_URL, ARTIFACTORY_TOKEN]
+{{c ""}}
+
+{{h2 "Environments"}}
+Properties specific to an environment can be declared in {{c "environments"}} section
+of the module spec. When {{c "--environment <name>"}} is specified, properties of that
+environment are merged over the base properties of each module. Nested dictionaries
+are merged and other values replace the base properties of the same name.
+Modules without an overlay for the environment retain their base properties.
+
+{{c ""}}
+properties:
+  replicas: 1
+  db:
+    host: localhost
+environments:
+  prod:
+    properties:
+      replicas: 3
+      db:
+        host: db.prod.example.com
+{{c ""}}
+
+Name of the environment is available in {{c "MBT_ENVIRONMENT"}} and in {{c ".Environment"}}
+of command templates. Environments can be used with {{c "mbt apply"}} as well.
+
+{{h2 "Sandboxed Builds"}}
+Use {{c "--sandbox"}} option to build each module in a temporary directory containing
+only the module directory and its file dependencies. Files excluded by {{c ".gitignore"}}
+are not copied. A build that reads files from undeclared locations fails in this mode,
+which makes it a convenient way to verify that module dependencies are complete.
+{{c "MBT_REPO_PATH"}} points to the root of the sandbox during such builds.
+
+{{h2 "Parallel Builds"}}
+Use {{c "--jobs"}} ({{c "-j"}}) option to build up to the specified number of modules
+concurrently. A module is built only after its dependencies are built.
+Output of each module is prefixed with its name when modules are built concurrently.
+
+By default, no new builds are started after a failure and the builds in progress are
+allowed to complete. With {{c "--keep-going"}}, the modules not depending on a failed
+module are built as well, so that a single build reports all the failures. Modules
+depending on a failed module are not built and they are reported as {{c "notStarted"}}
+in the summary.
+
+Modules can declare the resources they require in {{c "resources"}} section of
+the spec. mbt does not start a build unless the sum of {{c "cpu"}} and {{c "memory"}}
+of running builds fits the limits of the machine. Limits can be changed with
+{{c "--cpu"}} and {{c "--memory"}} options. Modules listing the same name in
+{{c "locks"}} (e.g. {{c "dockerd"}}) are never built at the same time.
+
+{{h2 "Resuming Builds"}}
+mbt keeps a journal of the modules built in the last build in {{c ".git/mbt"}}
+directory. When a build is interrupted or fails, use {{c "--resume"}} option to
+build the same set of modules again skipping the ones already built at the
+same version, in the same environment and with the same environment variables.
+Modules with local changes are always built.
+
+{{h2 "Build Avoidance"}}
+Modules can declare a {{c "probe"}} in the spec to check whether the artifact of their
+version already exists (e.g. built from another branch). The module is not built and
+it is marked {{c "satisfied"}} if the probe finds the artifact.
+{{c ""}}
+name: app-a
+build:
+  default:
+    cmd: ./build.sh
+probe:
+  url: https://registry.example.com/v2/app-a/manifests/{{"{{.Module.Version}}"}}
+  headers:
+    Authorization: Bearer {{"{{.Env.REGISTRY_TOKEN}}"}}
+{{c ""}}
+{{c "url"}} is requested with {{c "method"}} ({{c "HEAD"}} by default) and the artifact exists if
+the response is successful. A module is built if the response is {{c "404"}} or the request
+fails. Alternatively, {{c "cmd"}} and {{c "args"}} (as in build commands) are executed and the
+artifact exists if the command succeeds (e.g. {{c "docker manifest inspect"}}).
+Url and headers are always expanded as templates (see Command Templates), command and arguments
+are expanded like the build commands when the spec sets {{c "templates: true"}}.
+Use {{c "--ignore-probes"}} option to build the modules regardless of their probes.
+
+{{h2 "Interface Fingerprints"}}
+Any change to a module changes the versions of the modules depending on it, hence
+they are built again. Modules can declare a {{c "fingerprint"}} command writing their
+interface (e.g. the hashes of their exported API) to stdout, so that the modules
+depending on them are built again only when the interface changes.
+{{c ""}}
+name: lib-a
+build:
+  default:
+    cmd: ./build.sh
+fingerprint:
+  cmd: ./api-hash.sh
+{{c ""}}
+The command is executed in the module directory (or {{c "dir"}}) after each successful
+build and the hash of its output is recorded as the fingerprint of the module version
+in {{c ".git/mbt"}} directory. A module is not built and it is marked {{c "unaffected"}}
+if its content did not change since its last successful build of a different version and
+the fingerprints of its dependencies are the same as in that build. Dependencies without
+a fingerprint take part with their content and the fingerprints of their own dependencies,
+therefore an unaffected module does not cause its dependents to be built either. Dependents
+are built when the fingerprint of a dependency is not recorded for its version (e.g. the
+command failed).
+
+Skipped modules keep their new versions, although their artifacts are the ones of their
+last build. Use {{c "--ignore-fingerprints"}} option to build the modules regardless of the
+fingerprints.
+
+{{h2 "Build Cache"}}
+Use {{c "--cache-dir <dir>"}} and/or {{c "--cache-remote <url>"}} to store the outputs of each
+module built in a build cache, keyed by the version of the module (which is derived from its
+content and the versions of its dependencies), the platform and {{c "--environment"}}.
+A module is not built and it is marked {{c "cached"}} when the cache has an entry for its
+version, even in a different branch or a clean checkout. Its {{c "outputs"}} (see Publishing
+Outputs) are restored to the module directory instead. Modules without outputs are only
+recorded as built. Modules built with {{c "docker"}} are not cached.
+
+{{c "--cache-remote"}} is an {{c "http(s)://"}} url, where entries are read and written with
+GET and PUT requests to {{c "<url>/<key>.tar.gz"}} (credentials can be specified in the url),
+or an {{c "s3://bucket/prefix"}} url, with the credentials in the standard AWS environment
+variables. Local cache is read first and it receives a copy of the entries read from the
+remote cache. Use {{c "--cache-readonly"}} to restore the outputs without storing the outputs
+of the modules built (e.g. in the builds of pull requests).
+Failing to store an entry, or to read an entry from the remote cache, is reported as a
+warning and does not fail the build.
+Entries of the remote cache are signed and verified with {{c "cache"}} in {{c ".mbt/config.yml"}}
+(see {{c "hmacKeyEnv"}}, {{c "sigstore"}} and {{c "sign"}} of the {{c "cache"}} hook in
+{{c "mbt plugin --help"}}). Signatures are stored in {{c "<key>.sig.json"}} next to each entry and
+entries without a valid signature are rejected with a warning, as if they were not in the cache.
+{{c "mbt cache"}} collects the local cache of the last build.
+
+{{h2 "Flaky Builds"}}
+mbt records the outcome of each module build in {{c ".git/mbt"}} directory.
+A module is considered flaky if it is marked with {{c "flaky: true"}} in the spec
+or if it has failed and then succeeded to build at the same version.
+Use {{c "--retry-flaky <n>"}} option to retry failed builds of flaky modules
+up to {{c "n"}} times. See {{c "mbt stats flaky"}} for the recorded history.
+
+{{h2 "Build Plan"}}
+Use {{c "--plan"}} option with any of the build commands above to print the modules
+that would be built, their commands (with the templates expanded) and environment,
+without executing the build commands. Modules are listed in groups in the order of execution.
+Modules within a group do not depend on each other. Use {{c "--json"}} to format the plan as json.
+
+Outcome of each module is predicted as {{c "build"}}, or {{c "resumed"}} (with {{c "--resume"}}),
+{{c "satisfied"}} (probe found the artifact), {{c "unaffected"}} (fingerprints did not change) or
+{{c "cached"}} (outputs are in the build cache) if it would not be built. Probes are the only
+commands executed while planning and they are executed in the current workspace.
+
+Use {{c "--out <file>"}} to write the plan to a tar archive instead. Archive contains the
+manifest, the commands in the order of execution and the digests of the files of the
+modules planned. It can be reviewed and executed with {{c "mbt execute"}} in an environment
+without access to git (see {{c "mbt execute --help"}}).
+
+{{h2 "Test Reports"}}
+Modules can declare the test reports (in junit xml format) produced by their builds
+using patterns relative to the module directory. {{c "**"}} matches any number of
+directories.
+
+{{c ""}}
+reports: ["**/junit.xml"]
+{{c ""}}
+
+Reports written during the build of each module are aggregated and a summary of
+the tests is printed at the end of the build. Use {{c "--report <file>"}} to merge them
+into a single report. Suites in the merged report are prefixed with the module name.
+Merged report is written even if the build fails.
+
+{{h2 "Publishing Outputs"}}
+Modules can declare the files produced by their builds as {{c "outputs"}} using patterns
+relative to the module directory. Use {{c "--publish"}} to publish the outputs of each module
+after it is built (and after its {{c "postBuild"}} hooks). Outputs are archived in a
+reproducible gzipped tarball named {{c "<module>-<version>.tar.gz"}} and published to each
+target in {{c "publish"}} of the module, or in {{c "publish"}} of {{c ".mbt/config.yml"}} when the
+module does not specify any.
+
+- oci: Pushed to {{c "<oci>/<module>"}} tagged with the version of the module as an artifact
+  in the layout of {{link "oras" "https://oras.land"}}. Published artifacts can be pulled with oras
+  or used as template sources.
+- s3: Uploaded to {{c "<prefix>/<module>/<version>/<module>-<version>.tar.gz"}} in the bucket using
+  the credentials in {{c "AWS_ACCESS_KEY_ID"}}, {{c "AWS_SECRET_ACCESS_KEY"}} and {{c "AWS_SESSION_TOKEN"}},
+  in the region in {{c "AWS_REGION"}}. Set {{c "AWS_ENDPOINT_URL_S3"}} to use S3 compatible storage.
+- http: Uploaded with a PUT request to {{c "<http>/<module>/<version>/<module>-<version>.tar.gz"}}.
+
+{{c "username"}}, {{c "password"}} and {{c "headers"}} can reference the host environment variables
+listed in {{c "hostEnv"}} (e.g. {{c "${REGISTRY_TOKEN}"}}). Variants of modules with a build matrix
+are published with the name of the variant appended to the version. A failure to publish fails the
+build of the module. References and digests of the published artifacts are recorded in
+{{c "artifacts"}} of each module in the invocation summary.
+
+{{c ""}}
+outputs: ["dist/**"]
+publish:
+  - oci: ghcr.io/acme
+    username: ${GITHUB_ACTOR}
+    password: ${GITHUB_TOKEN}
+{{c ""}}
+
+{{h2 "Provenance"}}
+Use {{c "--provenance-dir <dir>"}} to write an in-toto statement with the {{link "SLSA provenance" "https://slsa.dev/provenance/v1"}}
+of each module built to {{c "<dir>/<module>.intoto.json"}}. Subjects of the statement are the artifacts
+published with {{c "--publish"}}, or the {{c "outputs"}} of the module when they are not published, or
+the module itself (identified by its git tree) when it does not declare outputs.
+
+Provenance records the builder ({{c "--builder-id"}}, which defaults to the workflow in GitHub Actions,
+the runner in GitLab or the host), the commit, the version of the module and the build command,
+and the materials of the build: the repository, the digests of the files of the module and its
+file dependencies, the modules it depends on with their versions and its external dependencies.
+
+{{c "--sign-provenance"}} signs each statement with {{link "cosign" "https://github.com/sigstore/cosign"}}
+in the PATH using sigstore keyless signing, and writes the bundle to {{c "<module>.intoto.json.sigstore.json"}}.
+In CI, cosign uses the OIDC token of the job (e.g. {{c "id-token: write"}} permission in GitHub Actions).
+Verify the statement with {{c "cosign verify-blob --bundle <module>.intoto.json.sigstore.json"}}.
+A failure to write or sign the provenance fails the build of the module.
+
+{{h2 "Invocation Summary"}}
+Use {{c "--summary-file <file>"}} to write a summary of the build in json format.
+Summary is written even if the build fails, so that automation does not need to
+parse the logs.
+
+{{c ""}}
+{
+  "command": "build",
+  "commit": "<sha>",
+  "success": false,
+  "error": "Failed to build module 'app-b'",
+  "exitCode": 3,
+  "modules": [
+    {"name": "app-a", "path": "app-a", "version": "<version>", "status": "succeeded", "duration": 12.5, "exitCode": 0},
+    {"name": "app-b", "path": "app-b", "version": "<version>", "status": "failed", "duration": 3.1, "exitCode": 2, "error": "..."},
+    {"name": "app-c", "path": "app-c", "version": "<version>", "status": "notStarted", "duration": 0, "exitCode": 0}
+  ],
+  "testReport": {"files": ["app-a/out/junit.xml"], "suites": 1, "tests": 10, "failures": 0, "errors": 0, "skipped": 0}
+}
+{{c ""}}
+
+Status of a module is one of {{c "succeeded"}}, {{c "failed"}}, {{c "skipped"}}, {{c "resumed"}}
+(built in the previous build, see {{c "--resume"}}), {{c "satisfied"}} (artifact found by the
+probe of the module, see Build Avoidance), {{c "unaffected"}} (fingerprints of the dependencies
+did not change, see Interface Fingerprints), {{c "cached"}} (outputs restored from the build cache,
+see Build Cache) or {{c "notStarted"}} (build was stopped due to a failure, or a dependency failed
+with {{c "--keep-going"}}).
+{{c "exitCode"}} of the summary is the exit code of mbt (see {{c "mbt --help"}}), while the
+{{c "exitCode"}} of a module is the exit code of its build command.
+
+{{h2 "Build Profile"}}
+Use {{c "--profile <file>"}} to record when each module was started and finished.
+Timings are written in chrome trace event format, which can be viewed in
+{{c "chrome://tracing"}} or {{link "Perfetto" "https://ui.perfetto.dev"}}. Modules built concurrently
+are shown in separate lanes. A summary of the timings is printed as well.
+Profile of a failed build contains the modules processed until it stopped.
+Wait time of a module is the time spent waiting for resources after its
+dependencies were built.
+
+{{h2 "Progress Display"}}
+When attached to a terminal, build commands display a row for each module in
+progress with the elapsed time, followed by the number of modules built, failed,
+running and queued. Completed modules are collapsed into a single line and the
+output of a module is printed only if its build fails.
+Whilst files are hashed to discover the modules (e.g. Git LFS objects), the
+number of files hashed and the total are displayed as well.
+Use {{c "--progress plain"}} to print the output of all modules instead, or
+{{c "--progress tty"}} to force the progress display.
+
+{{h2 "Module Logs"}}
+Use {{c "--log-dir <dir>"}} to write the output of each module to its own file
+(e.g. {{c "<dir>/app-a.log"}}) in addition to the console.
+When modules are built concurrently, each line of the output is prefixed with
+the name of the module so that the output of different modules can be told apart.
+Use {{c "--prefix"}} to prefix the output of sequential builds as well.
+
+{{h2 "Quiet Output"}}
+Use {{c "--quiet"}} ({{c "-q"}}) to suppress the output of the modules built successfully.
+Output of a module is printed only if it fails, followed by the summary of the build.
+Warnings and errors are always printed. {{c "--log-dir"}} can be used along with this
+option to retain the output of all modules.
+
+{{h2 "Structured Logs"}}
+Use {{c "--log-format json"}} to write a stream of events, one json object per line,
+to stdout, suitable for log aggregation systems. Logs of mbt are written to stderr
+in json format as well.
+
+{{c ""}}
+{"time":"...","type":"moduleStart","module":"app-a","version":"..."}
+{"time":"...","type":"output","module":"app-a","version":"...","stream":"stdout","data":"..."}
+{"time":"...","type":"moduleFinish","module":"app-a","version":"...","elapsed":1.25}
+{{c ""}}
+
+Event types are {{c "moduleQueue"}}, {{c "moduleStart"}}, {{c "moduleFinish"}}, {{c "moduleSkip"}}
+and {{c "output"}}.
+Output of the commands is delivered in {{c "output"}} events tagged with the module
+and the stream ({{c "stdout"}} or {{c "stderr"}}). {{c "moduleFinish"}} events contain the
+duration of the command in seconds and the error if it failed.
+
+{{h2 "Tracing"}}
+mbt exports traces of manifest construction, diffing and module builds to an
+{{link "OpenTelemetry" "https://opentelemetry.io"}} collector when
+{{c "OTEL_EXPORTER_OTLP_ENDPOINT"}} (or {{c "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"}}) is set.
+Spans of modules have {{c "mbt.module.name"}}, {{c "mbt.module.version"}},
+{{c "mbt.cache_hit"}} (module was resumed or satisfied) and {{c "mbt.wait_ms"}} (time spent waiting
+for resources after dependencies were built) attributes.
+
+Traces are sent using OTLP over http with json encoding. Headers can be specified in
+{{c "OTEL_EXPORTER_OTLP_HEADERS"}} (e.g. {{c "api-key=xxx"}}) and the service name in
+{{c "OTEL_SERVICE_NAME"}}. When {{c "TRACEPARENT"}} is set, mbt continues the trace of the
+parent process (e.g. a CI pipeline).
+
+{{h2 "Metrics"}}
+At the end of a build or a run of a user defined command, mbt can push the
+duration, status and cache hit (module was resumed or satisfied) of each module to a
+{{link "Prometheus pushgateway" "https://github.com/prometheus/pushgateway"}} or a
+StatsD server. Endpoints are specified in {{c ".mbt/config.yml"}}.
+
+{{c ""}}
+metrics:
+  pushgateway: http://pushgateway:9091
+  job: ci
+  statsd: localhost:8125
+  prefix: mbt
+{{c ""}}
+
+Metrics are grouped by {{c "job"}} (defaults to {{c "mbt"}}) and the command in the pushgateway
+and the metrics of the previous invocation of the same command are replaced.
+Prometheus metrics are {{c "<prefix>_module_duration_seconds"}}, {{c "<prefix>_module_success"}},
+{{c "<prefix>_module_cache_hit"}}, {{c "<prefix>_module_status"}} and {{c "<prefix>_success"}}.
+StatsD metrics are named {{c "<prefix>.<command>.module.<name>.<metric>"}}.
+Failure to push the metrics is reported as a warning and does not fail the build.
+Set {{c "history: true"}} to record the metrics locally as well (see {{c "mbt stats --help"}}).
+
+{{h2 "Notifications"}}
+Webhooks declared in {{c ".mbt/config.yml"}} are called at the end of a build or a
+run of a user defined command. By default, the request body is the
+invocation summary in json format (see {{c "--summary-file"}}).
+Use {{c "body"}} to specify a {{link "go template" "https://golang.org/pkg/text/template/"}}
+of the body instead (e.g. for Slack). The template is executed with the summary and
+{{c "json"}} function can be used to encode values.
+
+{{c ""}}
+hostEnv: [SLACK_WEBHOOK]
+notifications:
+  - url: ${SLACK_WEBHOOK}
+    on: [failure]
+    owners: [team-a]
+    body: '{"text": "{{"{{.Command}}"}} failed for {{"{{range .Modules}}"}}{{"{{.Name}}"}} {{"{{end}}"}}"}'
+{{c ""}}
+
+{{c "on"}} lists the outcomes ({{c "success"}}, {{c "failure"}}) the webhook is called for
+and defaults to both.
+When {{c "owners"}} is specified, the summary only contains the modules with any of
+those {{c "owners"}} and the outcome is the outcome of those modules. Webhook is not
+called if none of them were built.
+{{c "${VAR}"}} references in {{c "url"}} and {{c "headers"}} are expanded from the host
+environment provided {{c "VAR"}} is listed in {{c "hostEnv"}}.
+Failure to call a webhook is reported as a warning and does not fail the build.
+
+{{h2 "Event Streams"}}
+Lifecycle events of a build or a run of a user defined command
+({{c "runStart"}}, {{c "moduleStart"}}, {{c "moduleFinish"}} and {{c "runFinish"}})
+can be delivered as they happen to dashboards and bots as newline delimited json.
+Use {{c "--events-url <url>"}} (repeatable) to post them to an HTTP endpoint or
+{{c "--events-fd <n>"}} to write them to a file descriptor inherited from the parent process.
+Endpoints can also be declared in {{c ".mbt/config.yml"}}.
+
+{{c ""}}
+hostEnv: [DASHBOARD_TOKEN]
+eventStreams:
+  - url: https://dashboard.example.com/events
+    headers:
+      Authorization: Bearer ${DASHBOARD_TOKEN}
+{{c ""}}
+
+Events are posted in batches in the background so that a slow endpoint does not
+slow down the build. Events are dropped if an endpoint does not keep up and an
+endpoint is not called again after a failure. Both are reported as warnings
+and do not fail the build.
+
+{{h2 "Policies"}}
+Rego policies in {{c ".mbt/policies"}} are evaluated with {{link "opa" "https://www.openpolicyagent.org"}}
+before a build or a run of a user defined command starts. {{c "opa"}} must be in {{c "PATH"}},
+commands fail without executing anything when the policy directory exists and {{c "opa"}} is not found.
+Policies receive the modules selected ({{c "input.modules"}}, including their owners and properties)
+and the commands to be executed ({{c "input.plan"}}) along with {{c "input.command"}},
+{{c "input.userCommand"}}, {{c "input.environment"}}, {{c "input.commit"}},
+{{c "input.time"}} and {{c "input.weekday"}}.
+Each message in {{c "data.mbt.deny"}} is a violation and any violation fails the command.
+
+{{c ""}}
+package mbt
+
+deny[msg] {
+  m := input.modules[_]
+  count(m.owners) == 0
+  msg := sprintf("%s does not have owners", [m.name])
+}
+
+deny["deploys are not allowed on Fridays"] {
+  input.userCommand == "deploy"
+  input.weekday == "Friday"
+}
+{{c ""}}
+
+The directory of the policies and the query can be changed in {{c ".mbt/config.yml"}}.
+
+{{c ""}}
+policies:
+  dir: governance/policies
+  query: data.acme.deny
+{{c ""}}
 `,
 	"describe-summary": `Describe repository manifest`,
 	"describe": `{{cli "Describe repository manifest \n"}}
-{{c "mbt describe branch [name] [--content] [--name <name>] [--fuzzy] [--graph] [--json]"}}{{br}}
-Describe modules in a branch. Assume master if branch name is not specified.
+{{c "mbt describe branch [name | --commit <sha>] [--content] [--name <name>] [--fuzzy] [--graph] [--json]"}}{{br}}
+Describe modules in a branch. Assume master (or the commit checked out if there is no master branch) if branch name is not specified.
+Use --commit to specify a commit instead.
 Describe just the modules matching the {{c "--name"}} filter if specified.
 Default {{c "--name"}} filter is a prefix match. You can change this to a subsequence
 match by using {{c "--fuzzy"}} option.
 
-{{c "mbt describe commit <commit> [--content] [--name <name>] [--fuzzy] [--graph] [--json]"}}{{br}}
+{{c "mbt describe commit <commit> [--content | --at-config <commit>] [--name <name>] [--fuzzy] [--graph] [--json]"}}{{br}}
 Describe modules in a commit. Full commit sha is required.
 Describe just the modules modified in the commit when {{c "--content"}} flag is used.
 Describe just the modules matching the {{c "--name"}} filter if specified.
 Default {{c "--name"}} filter is a prefix match. You can change this to a subsequence
 match by using {{c "--fuzzy"}} option.
+Use {{c "--at-config"}} to describe the tree of the commit with the module specs, {{c ".mbtignore"}}
+files, pins and {{c ".mbt/config.yml"}} of another commit (e.g. what the current layout of modules
+would have said about a past release). Versions are derived from the content of the commit.
+Modules whose directories or file dependencies do not exist in the commit are reported and left
+out along with the modules depending on them. Modules discovered by plugins and in submodules are
+not included. Results are stored in the discovery cache.
 
 {{c "mbt describe diff --from <commit> --to <commit> [--graph] [--json]"}}{{br}}
 Describe modules changed between {{c "from"}} and {{c "to"}} commits.
 In this mode, mbt works out the merge base between {{c "from"}} and {{c "to"}} and
 evaluates the modules changed between the merge base and {{c "to"}}.
+With {{c "--format json|yaml|template"}}, each module lists the issues referenced by the
+commits changing it (see {{c "mbt changelog --help"}}).
 
 {{c "mbt describe head [--content] [--name <name>] [--fuzzy] [--graph] [--json]"}}{{br}}
 Describe modules in current head.
@@ -276,17 +1476,925 @@ Describe just the modules matching the {{c "--name"}} filter if specified.
 Default {{c "--name"}} filter is a prefix match. You can change this to a subsequence
 match by using {{c "--fuzzy"}} option.
 
+{{c "mbt describe graph [--format dot|mermaid] [--from <commit> --to <commit>] [--cluster dir|tag] [--module <name> [--depth <n>]]"}}{{br}}
+Output the dependency graph of modules in current head in graphviz dot (default) or
+mermaid format. Modules changed between {{c "--from"}} and {{c "--to"}} commits are highlighted.
+{{c "--cluster dir"}} groups modules by the directory containing them and {{c "--cluster tag"}}
+groups them by the first value in their {{c "tags"}} property.
+Use {{c "--module"}} to restrict the graph to the modules reachable from a module by following
+at most {{c "--depth"}} dependencies or dependents (default 1, 0 follows all).
+
+{{c "mbt describe export [--out <file>] [--branch <name> | --commit <commit> | --src <name> --dst <name> | --from <commit> --to <commit>]"}}{{br}}
+Export the manifest of current head (or the branch, commit or changes specified) as json
+to {{c "--out"}} file or stdout. Modules can be narrowed down with {{c "--name"}} and {{c "--query"}} filters.
+
+{{h2 "Anonymized Graphs"}}
+Use {{c "--anonymize"}} with {{c "mbt describe export"}} or {{c "mbt describe graph"}} to share
+the structure of a repository (e.g. in a bug report) without disclosing its contents.
+Names of modules, the segments of their paths and file dependencies, versions and the commit
+are replaced with keyed hashes, while dependencies between modules are preserved.
+Build commands are replaced with a placeholder and properties are removed, therefore
+{{c "--cluster tag"}} does not group anonymized modules.
+Hashes are keyed by a random salt unless {{c "--salt <value>"}} is specified. Use the same salt
+to produce comparable outputs from several runs.
+
+{{h2 "Exported Manifests"}}
+Exported manifests can be consumed by other commands with {{c "--manifest <file>"}} option.
+The modules and the commit in the manifest are used instead of discovering the modules
+in the repository, therefore the manifest can be computed once and shared with many jobs
+in a pipeline (e.g. {{c "mbt build head --manifest manifest.json --name app-a"}}).
+Branch, commit and diff arguments of the consuming command do not change the modules in
+the manifest. Builds still check out the commit of the manifest, hence the repository
+must contain that commit.
+
 {{h2 "Output Formats"}}
-Use {{c "--graph"}} option to output the manifest in graphviz dot format. This can
-be useful to visualise build dependencies.
+Modules are described in a table by default. Use {{c "--columns"}} to select and order the
+columns (e.g. {{c "--columns name,version,tags,owners"}}) and {{c "--sort <column>"}} to sort the
+rows. Available columns are {{c "name"}}, {{c "path"}}, {{c "version"}}, {{c "tags"}} (values of the
+{{c "tags"}} property), {{c "owners"}} and {{c "built"}} (time of the last successful build of the
+module in this clone).
+
+Use {{c "--format dot"}} (or {{c "--graph"}}) to output the manifest in graphviz dot format.
+This can be useful to visualise build dependencies (e.g. {{c "mbt describe local --all --format dot | dot -Tsvg > deps.svg"}}).
+
+Use {{c "--format json"}} or {{c "--format yaml"}} to output the manifest in a versioned
+schema suitable for scripts. Dependencies and dependents are the names of the modules
+directly required by and requiring each module. {{c "schemaVersion"}} is incremented
+only for changes that are not backwards compatible.
+
+{{c ""}}
+{
+  "schemaVersion": 1,
+  "modules": [
+    {
+      "name": "app-a",
+      "path": "app-a",
+      "version": "7a3c...",
+      "properties": {},
+      "dependencies": ["lib-a"],
+      "dependents": [],
+      "fileDependencies": ["common"]
+    }
+  ]
+}
+{{c ""}}
+
+Use {{c "--format template --template <template>"}} to shape the output with a go template
+executed with the same data as {{c "--format json"}}. Fields are named after the schema with an
+upper case first letter (e.g. {{c "{{range .Modules}}{{.Name}}:{{.Version}}{{\"\\n\"}}{{end}}"}}).
+Sprig functions are available as in {{c "mbt apply"}}.
+
+Use {{c "--json"}} option to output the manifest in json format keyed by module name.
+
+`,
+	"watch-summary": `Build modules as they change in the workspace`,
+	"watch": `{{cli "Build modules as they change in the workspace \n"}}
+{{c "mbt watch [--command <command>] [--debounce <duration>] [--jobs <n>]"}}{{br}}
+Monitor the workspace and build the modules impacted by each change, including
+the modules depending on them. Changes to files ignored by git are not considered.
+Changes occurring in quick succession are processed together once nothing has
+changed for the {{c "--debounce"}} period (default 300ms).
+
+Modules are discovered once and the graph of modules is kept in memory. When module
+specs change, only the changed modules are added, removed or linked again in the graph.
+Modules are discovered again when the repository configuration, ignore files or
+directories change, and in repositories discovering modules in submodules or with plugins.
+
+Run a user defined command in the impacted modules instead of building them
+by specifying {{c "--command"}} ({{c "-m"}}).
+
+Build failures are reported and watching continues. Press Ctrl+C to stop.
+`,
+	"workspace-summary": `Compose the modules of multiple repositories`,
+	"workspace": `{{cli "Compose the modules of multiple repositories \n"}}
+{{c "mbt workspace describe [--file <file>] [--format text|json|yaml]"}}{{br}}
+Describe the modules in all repositories of the workspace.
+
+{{c "mbt workspace affected [--base <repo>=<rev>...] [--format text|json|yaml]"}}{{br}}
+List the modules affected by the changes in the repositories of the workspace and
+the modules depending on them in any repository.
+
+{{c "mbt workspace build [--affected [--base <repo>=<rev>...]] [--env KEY=VALUE...] [--jobs <n>]"}}{{br}}
+Build the modules (or just the affected modules) in the repositories of the workspace
+in the order of their dependencies.
+
+{{h2 "Workspace File"}}
+A workspace file ({{c "mbt.workspace.yml"}} in the current directory by default) lists the
+repositories composed into a single module graph. Each repository is either a {{c "path"}}
+relative to the workspace file or a {{c "url"}} of a remote repository.
+
+{{c ""}}
+repos:
+  - name: libs
+    path: libs
+    base: origin/main
+  - name: services
+    path: services
+    ref: release-2
+  - name: tools
+    url: https://github.com/acme/tools.git
+    ref: v1.4.0
+{{c ""}}
 
-Use {{c "--json"}} option to output the manifest in json format.
+Modules are named {{c "<repo>/<module>"}} in the workspace and their paths are prefixed
+with the name of the repository too. Modules depend on the modules in other repositories
+with {{c "workspaceDependencies"}} in their specs, which are ignored outside of workspaces.
 
+{{c ""}}
+name: app-a
+dependencies: [app-b]
+workspaceDependencies: [libs/lib-a]
+{{c ""}}
+
+{{c "ref"}} is the revision of the repository composed into the workspace. It defaults to the
+working directory of local repositories and the default branch of remote repositories.
+Modules affected by the changes in a repository are the ones changed between {{c "base"}} and
+{{c "ref"}} (including the uncommitted changes when {{c "ref"}} is not specified).
+A repository without a base does not have any changes. Use {{c "--base <repo>=<rev>"}}
+to override the base of a repository (e.g. in a pull request build).
+
+Modules of a repository are built with the configuration of that repository, in its working
+directory or in {{c "ref"}} checked out. Modules of remote repositories cannot be built.
+`,
+	"pin-summary": `Pin modules to fixed versions`,
+	"pin": `{{cli "Pin modules to fixed versions \n"}}
+{{c "mbt pin"}}{{br}}
+List the modules pinned in {{c ".mbt/pins.lock"}}.
+
+{{c "mbt pin <module> [--version <version>] [--reason <reason>]"}}{{br}}
+Pin the module to its version in the current branch or to {{c "--version"}}.
+
+{{c "mbt unpin <module>"}}{{br}}
+Remove the pin of the module.
+
+Version of a pinned module does not change when its files or dependencies change, therefore
+the versions of its dependents do not change either. It is useful to hold a module during an
+incident freeze or a staged migration. Pins are effective once the lock file is committed and
+they apply to the workspace as well.
+`,
+	"plugin-summary": `Pin the plugins of the repository`,
+	"plugin": `{{cli "Pin the plugins of the repository \n"}}
+{{c "mbt plugin lock"}}{{br}}
+Find the plugins listed in {{c ".mbt/config.yml"}} and pin them in {{c ".mbt/plugins.lock"}}.
+
+{{c ""}}
+plugins: [bazel, datadog]
+{{c ""}}
+
+A plugin is an executable named {{c "mbt-<name>"}} in {{c ".mbt/plugins"}} of the repository or
+in {{c "PATH"}}. It is executed in the root of the repository with a json object on stdin
+containing {{c "protocol"}} (currently 1), {{c "hook"}} and the input of the hook, and it writes
+the output of the hook as json to stdout.
+Lock file records the version and the hooks of each plugin along with the sha256 digest of
+its executable. mbt refuses to execute a plugin not matching the lock file, so commit the lock
+file and run {{c "mbt plugin lock"}} again after upgrading a plugin.
+
+{{c "describe"}}{{br}}
+Executed by {{c "mbt plugin lock"}}. Output is
+{{c "{\"version\": \"1.0.0\", \"hooks\": [...], \"templateFuncs\": [...]}"}}.
+
+{{c "discover"}}{{br}}
+Discover the modules not declared with {{c ".mbt.yml"}} (e.g. the packages of another build
+system) in {{c "commit"}}, or in the workspace if {{c "commit"}} is empty. Output is
+{{c "{\"modules\": [{\"dir\": \"app-a\", \"spec\": {\"name\": \"app-a\", ...}}]}"}}
+where spec is the same as {{c ".mbt.yml"}}. Modules in directories with a {{c ".mbt.yml"}} are ignored.
+
+{{c "report"}}{{br}}
+Receive the {{c "summary"}} of each build and run of a user defined command
+(see {{c "--summary-file"}}). Failures are reported as warnings.
+
+{{c "cache"}}{{br}}
+Share the discovery cache across machines. With {{c "op"}} {{c "get"}}, output
+{{c "{\"entry\": ...}"}} the entry stored for {{c "commit"}} and {{c "config"}} or nothing.
+With {{c "op"}} {{c "put"}}, store {{c "entry"}}. Failures are ignored.
+Entries can be signed so that a compromised cache cannot inject modules, with
+{{c "cache"}} in {{c ".mbt/config.yml"}}:
+
+{{c "hmacKeyEnv"}}: Environment variable containing the key of an HMAC-SHA256 signature{{br}}
+{{c "sigstore"}}: {{c "identity"}} and {{c "issuer"}} of the certificate signing the entries with {{c "cosign"}}{{br}}
+{{c "sign"}}: Sign the entries stored (e.g. only in the builds of the main branch)
+
+When either method is specified, entries are verified when they are read and those without a
+valid {{c "signature"}} are rejected with a warning. Entries are stored with their
+{{c "signature"}} ({{c "{\"hmac\": ..., \"bundle\": ...}"}}) only when {{c "sign"}} is set.
+
+{{c "templateFunc"}}{{br}}
+Implement the template functions listed in {{c "templateFuncs"}} of describe.
+Input has the name ({{c "func"}}) and the {{c "args"}} of the function and the output is the result.
+Functions in {{c "templateFuncs"}} of {{c ".mbt/config.yml"}} take precedence.
+`,
+	"serve-summary": `Answer queries about the modules over http, gRPC or stdio`,
+	"serve": `{{cli "Answer queries about the modules over http, gRPC or stdio \n"}}
+{{c "mbt serve [--addr <host:port>]"}}{{br}}
+Load the repository once and answer queries about its modules over a REST api
+(default address {{c "127.0.0.1:7077"}}), instead of starting mbt for each query.
+Manifests are kept in memory by commit and revisions are resolved on each request,
+so that moving a branch is reflected immediately. Manifest of the workspace is
+discarded when a file in the workspace changes and is created again from the graph of
+modules updated with the changed specs, in the same way as {{c "mbt watch"}}. Queries are answered concurrently and
+share the manifests in memory. Files of modules are read on the first query requiring them.
+
+{{c "GET /v1/modules?rev=<rev>"}}{{br}}
+Describe the modules in a revision (default current branch) or in the workspace
+({{c "rev=local"}}) in the format of {{c "mbt describe --format json"}}.
+
+{{c "GET /v1/versions?rev=<rev>"}}{{br}}
+Version of each module keyed by module name.
+
+{{c "GET /v1/dependents?module=<name>&rev=<rev>"}}{{br}}
+Describe the modules depending on a module directly or indirectly.
+
+{{c "GET /v1/affected?base=<rev>&head=<rev>&purpose=<purpose>"}}{{br}}
+Describe the modules affected by the changes between the merge base of {{c "base"}}
+and {{c "head"}} (default current branch), and {{c "head"}} (see {{c "mbt affected --help"}}).
+{{c "purpose"}} defaults to {{c "builds"}}.
+
+{{c "GET /v1/graph?rev=<rev>&format=dot|mermaid&cluster=dir|tag"}}{{br}}
+Dependency graph of the modules (see {{c "mbt describe graph --help"}}).
+
+{{c "GET /v1/impacted?path=<path>&path=<path>"}}{{br}}
+Describe the modules in the workspace impacted by changes to the paths (relative to the root of
+the repository), including the modules depending on them. Changes do not have to be saved.
+
+{{c "GET /v1/spec?module=<name>&rev=<rev>"}}{{br}}
+Content of the spec file ({{c ".mbt.yml"}}) of a module.
+
+{{c "GET /v1/files?module=<name>&rev=<rev>"}}{{br}}
+Files of a module and its file dependencies with their git blob hashes, sorted by path.
+
+Errors are reported as {{c "{\"error\": \"...\"}"}} with status 400 for invalid queries.
+Press Ctrl+C to stop.
+
+The same queries are answered over gRPC on the same address (HTTP/2 without TLS), with the
+{{c "mbt.v1.Query"}} service defined in {{c "api/proto/mbt/v1/query.proto"}} of the mbt repository.
+Each method answers the query of the same name (e.g. {{c "Modules"}}) with the parameters in
+{{c "QueryRequest"}}, and the result is in the {{c "json"}} field of {{c "QueryResponse"}}
+({{c "text"}} for graph and spec). Invalid queries fail with status {{c "INVALID_ARGUMENT"}}.
+
+{{c "grpcurl -plaintext -proto query.proto -d '{\"module\": \"lib-a\"}' 127.0.0.1:7077 mbt.v1.Query/Dependents"}}{{br}}
+
+{{c "mbt serve --stdio"}}{{br}}
+Answer the same queries as {{link "JSON-RPC 2.0" "https://www.jsonrpc.org/specification"}} requests
+on stdin and stdout, for editor and tooling integrations. Messages are framed with a
+{{c "Content-Length"}} header like in the {{link "language server protocol" "https://microsoft.github.io/language-server-protocol/"}}.
+Method is the name of the query and params is an object of strings or lists of strings.
+
+{{c ""}}
+Content-Length: 85
+
+{"jsonrpc": "2.0", "id": 1, "method": "impacted", "params": {"path": ["lib-a/a.go"]}}
+{{c ""}}
+
+Invalid queries are answered with error code {{c "-32602"}}. mbt exits when stdin is closed or
+the {{c "exit"}} notification is received.
+`,
+	"ui-summary": `Browse the module graph in a web page`,
+	"ui": `{{cli "Browse the module graph in a web page \n"}}
+{{c "mbt ui [--addr <host:port>] [--open]"}}{{br}}
+Serve a web page rendering the dependency graph of the modules (default address
+{{c "127.0.0.1:7077"}}), along with the queries of {{c "mbt serve"}} the page is built on.
+Use {{c "--open"}} to open the page in the default browser.
+
+Modules are placed in columns after the modules they depend on. Drag to pan and scroll to zoom.
+Type in the search box to highlight the modules with a matching name or path. Click a module
+to show its dependencies, dependents and spec file, and to highlight the modules connected to it.
+
+Specify a revision (default current branch, {{c "local"}} for the workspace) and load the graph
+again to browse another revision. Specify a base (and optionally a head) revision to highlight
+the modules affected by the changes between them (see {{c "mbt affected --help"}}).
+Press Ctrl+C to stop.
+`,
+	"install-hooks-summary": `Install git hooks validating the modules`,
+	"install-hooks": `{{cli "Install git hooks validating the modules \n"}}
+{{c "mbt install-hooks [--hook pre-commit|pre-push] [--command <command>] [--force]"}}{{br}}
+Install git hooks validating the modules before a commit is created ({{c "pre-commit"}}, default)
+or the commits are pushed ({{c "pre-push"}}). Specify {{c "--hook"}} multiple times to install both.
+Hooks fail when a spec cannot be parsed, a file dependency is not found or the dependencies of
+modules form a cycle.
+
+{{c "pre-commit"}} validates the modules in the workspace and {{c "pre-push"}} validates the modules
+in each commit being pushed.
+
+Specify {{c "--command"}} ({{c "-m"}}) to run a user defined command (e.g. a quick lint) in the
+modules affected by the change as well. {{c "pre-commit"}} runs the command in the modules changed
+in the workspace and {{c "pre-push"}} in the modules changed by the commits being pushed.
+Hooks fail if the command fails in any module.
+
+Hooks are installed in the directory specified in {{c "core.hooksPath"}} or {{c ".git/hooks"}}
+and invoke {{c "mbt"}} in the PATH. Existing hooks not installed by mbt are replaced only
+when {{c "--force"}} is specified.
+`,
+	"import-summary": `Create modules from a Bazel workspace or a Gradle build`,
+	"import": `{{cli "Create modules from a Bazel workspace or a Gradle build \n"}}
+{{c "mbt import bazel --query <file> [--dir <dir>] [--force] [--dry-run]"}}{{br}}
+{{c "mbt import gradle [--dir <dir>] [--force] [--dry-run]"}}{{br}}
+Write a module spec for each package of an existing build, so that mbt can work out the
+packages affected by the changes in git while the build system builds them. {{c "--dir"}} is the
+directory of the Bazel workspace or the Gradle build relative to the repository root.
+
+Module is named after the directory of the package relative to {{c "--dir"}} e.g.
+{{c "services-api"}} for {{c "services/api"}}. Each module is built with the build system
+({{c "bazel build //services/api:all"}} or {{c "gradle :services:api:build"}}, with the wrapper
+if the build has one).
+
+- bazel: A module is created for each package with rules in {{c "--query"}}, the output of
+  {{c "bazel query '//...' --output=proto > query.pb"}}. Module depends on the packages of the
+  inputs of its rules. Rules in the root package and inputs from external repositories are ignored.
+- gradle: A module is created for each project included in {{c "settings.gradle"}} (or
+  {{c "settings.gradle.kts"}}), in its {{c "projectDir"}} if it is changed in the settings.
+  Module depends on the projects referenced with {{c "project(':path')"}} in its build script.
+  Type-safe project accessors and the root project are not imported.
+
+Existing specs are replaced only when {{c "--force"}} is specified. {{c "--dry-run"}} lists the
+modules (name, path and dependencies) without writing the specs.
+`,
+	"init-summary": `Set up an existing repository for mbt`,
+	"init": `{{cli "Set up an existing repository for mbt \n"}}
+{{c "mbt init [--detect] [--force] [--dry-run]"}}{{br}}
+Write a draft repository config ({{c ".mbt/config.yml"}}). With {{c "--detect"}}, modules are
+proposed for the directories of the workspace and a draft spec is written for each of them,
+followed by a report of the areas to review.
+
+A directory is proposed as a module when it contains a build file. Precedence of the build files
+determines the build command of the module:
+
+- go ({{c "go.mod"}}): {{c "go build ./..."}}
+- cargo ({{c "Cargo.toml"}}): {{c "cargo build"}}
+- npm ({{c "package.json"}}): {{c "npm run build"}}
+- maven ({{c "pom.xml"}}): {{c "mvn package"}}
+- gradle ({{c "build.gradle"}}, {{c "build.gradle.kts"}}): {{c "gradle build"}}
+- dotnet ({{c "*.csproj"}}, {{c "*.fsproj"}}): {{c "dotnet build"}}
+- python ({{c "pyproject.toml"}}, {{c "setup.py"}}): {{c "python -m build"}}
+- make ({{c "Makefile"}}): {{c "make"}}
+- docker ({{c "Dockerfile"}}): {{c "docker build ."}}
+
+Each subdirectory of {{c "apps"}}, {{c "components"}}, {{c "libs"}}, {{c "modules"}}, {{c "packages"}},
+{{c "projects"}} and {{c "services"}} at the repository root is also proposed as a module by convention.
+Hidden directories, ignored files, {{c "node_modules"}} and {{c "vendor"}} directories are not
+considered. Module is named after its directory e.g. {{c "services-api"}} for {{c "services/api"}}.
+
+The report lists the areas where a module could not be proposed with confidence:
+
+- Directories matching more than one build system (make and docker are not considered when
+  another build system matches).
+- Directories containing other proposed modules (e.g. the root of a workspace). Modules are
+  created for the nested directories only.
+- Modules proposed by convention without a build file. Their specs do not have a build command.
+- Directories named after the same module as another directory (e.g. {{c "a-b"}} and {{c "a/b"}}).
+
+Existing specs and the repository config are replaced only when {{c "--force"}} is specified.
+{{c "--dry-run"}} prints the report (name, path and detector of each module) without writing any files.
+`,
+	"changelog-summary": `Generate the changelog of a module`,
+	"changelog": `{{cli "Generate the changelog of a module \n"}}
+{{c "mbt changelog <module> [--from <rev>] [--to <rev>] [--format markdown|json]"}}{{br}}
+List the commits changing the module or its file dependencies since {{c "--from"}} up to
+{{c "--to"}} revision (default current branch). Revisions can be branches, tags or commits.
+The entire history is considered if {{c "--from"}} is not specified, except for the first
+commit of the repository. Merge commits are excluded.
+
+Commits are grouped by their {{link "conventional commit" "https://www.conventionalcommits.org"}} type
+(e.g. {{c "feat(api): add users endpoint"}}). Commits not following the format are listed under
+Other Changes. Commits marked with {{c "!"}} or a {{c "BREAKING CHANGE:"}} footer are also listed
+under Breaking Changes in markdown output.
+
+Issue keys referenced in commit messages (Jira style keys such as {{c "PROJ-123"}} by default)
+are listed with each commit and for the changelog as a whole. The pattern of the keys and the
+url of an issue (used to link them in markdown output) can be specified in {{c ".mbt/config.yml"}}.
+
+{{c ""}}
+issues:
+  pattern: '\b(?:PAY|OPS)-[0-9]+\b'
+  url: https://example.atlassian.net/browse/{key}
+{{c ""}}
+`,
+	"history-summary": `Analyse how the modules changed over the history`,
+	"history": `{{cli "Analyse how the modules changed over the history \n"}}
+{{c "mbt history [--from <rev>] [--to <rev>] [--min-co-changes <n>] [--format text|json]"}}{{br}}
+Examine the commits since {{c "--from"}} up to {{c "--to"}} revision (default current branch) and report,
+for each module, the number of commits changing the module or its file dependencies and the authors
+of those commits. The entire history is considered if {{c "--from"}} is not specified. Merge commits
+and the first commit of the repository are excluded.
+
+Pairs of modules changed together in at least {{c "--min-co-changes"}} commits (default 3), where
+neither module depends on the other directly or indirectly, are reported as hidden coupling.
+Ratio is the fraction of the commits changing the less frequently changed module that also
+change the other module. Commits changing more than 50 modules are not considered for coupling.
+
+Modules are identified by their definitions in {{c "--to"}} revision.
+
+Use {{c "mbt history ownership"}} to compare the owners of modules with the authors of the commits.
+`,
+	"history-ownership-summary": `Compare the owners of modules with the authors of their commits`,
+	"history-ownership": `{{cli "Compare the owners of modules with the authors of their commits \n"}}
+{{c "mbt history ownership [--from <rev>] [--to <rev>] [--min-share <fraction>]"}}{{br}}
+Report the primary contributors of each module in the commits since {{c "--from"}} up to {{c "--to"}}
+revision (default current branch) as json. Primary contributors are the authors of at least
+{{c "--min-share"}} (default 0.25) of the commits changing the module or its file dependencies
+(see {{c "mbt history --help"}}). Modules with primary contributors not recognised as any of their
+{{c "owners"}} are marked as {{c "drifted"}}.
+
+An author is recognised as an owner which is the name or the email of the author (ignoring the case
+and a leading {{c "@"}}), or a team listing the author in {{c "teams"}} section of {{c ".mbt/config.yml"}}.
+
+{{c "teams:"}}{{br}}
+{{c "  team-a: [alice@example.com, bob]"}}
+`,
+	"release-summary": `Plan a release train of the changed modules`,
+	"release": `{{cli "Plan a release train of the changed modules \n"}}
+{{c "mbt release plan [--from <tag>] [--to <rev>] [--tag-prefix v] [--version <version>] [--select deploys] [--format json|markdown] [--out <file>]"}}{{br}}
+Group the deployable modules (see {{c "mbt affected --help"}} for {{c "--select"}}) changed since the
+previous release into a release train, and write the plan of the release. Previous release is the tag
+with the highest {{c "<tag-prefix><major>.<minor>.<patch>"}} version unless {{c "--from"}} is specified.
+All deployable modules are released when there is no previous release.
+
+Each module is assigned a semantic version derived from the changelog of the module (see
+{{c "mbt changelog --help"}}) since the previous release: breaking changes bump the major version,
+features ({{c "feat"}}) bump the minor version and other changes bump the patch version of its previous
+release, the highest {{c "<module>/<tag-prefix><version>"}} tag. Modules released for the first time
+and the first release of the train are {{c "1.0.0"}}. Version of the train is its previous version
+bumped by the largest bump of its modules, unless {{c "--version"}} is specified.
+
+{{c "--format json"}} (default) writes the plan with the versions and the changelog of each module, to be
+consumed with {{c "--plan-file"}}. {{c "--format markdown"}} writes the release notes. mbt does not create the
+tags, tag the released commit with {{c "tag"}} of the plan and of each module once it is released.
+
+{{c "mbt build commit <sha> --plan-file plan.json"}}{{br}}
+Build just the modules in the plan. Build fails if a module in the plan is not found or its content
+changed since it was planned. {{c "MBT_RELEASE_TRAIN"}}, {{c "MBT_RELEASE_VERSION"}} and {{c "MBT_RELEASE_TAG"}}
+are set in the environment of the build commands.
+
+{{c "mbt apply commit <sha> --to <template> --plan-file plan.json"}}{{br}}
+Plan is available in {{c ".Release"}} of templates (e.g. {{c "{{ (.Release.Module .Module.Name).Release }}"}}).
+Template is rendered just for the modules in the plan with {{c "--split-per-module"}}.
+`,
+	"merge-queue-summary": `Suggest the changes that can be merged together`,
+	"merge-queue": `{{cli "Suggest the changes that can be merged together \n"}}
+{{c "mbt merge-queue --base <rev> <head>... [--format text|json]"}}{{br}}
+Work out the modules affected by each change (e.g. the head commit of a pull request) waiting to
+be merged into {{c "--base"}}, and suggest batches of changes that can be merged together because
+they do not affect a common module. Changes are given in the order of the queue and each change
+joins the first batch it does not overlap with, therefore, the first batch contains the first change.
+Pairs of changes affecting common modules are listed as overlaps.
+
+{{c "batch 1	pr-1 pr-3"}}{{br}}
+{{c "batch 2	pr-2"}}{{br}}
+{{c "overlap	pr-1 pr-2	lib-a, svc-a"}}{{br}}
+
+Affected modules of a change are worked out like {{c "mbt affected"}}, from the merge base of
+{{c "--base"}} and the head. Changes to files outside the modules are not considered.
+`,
+	"terraform-summary": `Plan the Terraform stacks affected by changes`,
+	"terraform": `{{cli "Plan the Terraform stacks affected by changes \n"}}
+{{c "mbt terraform plan --from <rev> [--to <rev>] [--environment <name>] [--vars-dir <dir>] [--format text|json]"}}{{br}}
+List the Terraform root modules (stacks) declared in {{c "terraform"}} of module specs that
+are affected by the changes between the merge base of {{c "--from"}} and {{c "--to"}} (default
+{{c "HEAD"}}) revisions, and {{c "--to"}}. A stack is affected when the content of its module
+changed or a module it depends on changed. Stacks are listed in the order they should be
+applied, with the stacks in the plan each one depends on. Modules with a stack that are
+removed in {{c "--to"}} revision are listed as removed, as their resources may have to be destroyed.
+
+{{c "name: network"}}{{br}}
+{{c "terraform:"}}{{br}}
+{{c "  dir: terraform"}}{{br}}
+{{c "  workspace: prod"}}{{br}}
+{{c "  vars:"}}{{br}}
+{{c "    cidr_block: network.cidr"}}{{br}}
+
+Variables of each stack are taken from the properties of the module (with the overlay of
+{{c "--environment"}} merged). All properties are used as variables when {{c "vars"}} is not
+specified. {{c "--vars-dir"}} writes the variables of each stack to {{c "<module>.tfvars.json"}}
+in the specified directory, to be used with {{c "terraform plan -var-file"}}.
+
+mbt does not run terraform. Use {{c "--format json"}} to drive the plan and apply of each stack.
+`,
+	"ci-summary": `Integrate with CI systems`,
+	"ci": `{{cli "Integrate with CI systems \n"}}
+{{c "mbt ci github-matrix --from <rev> [--to <rev>] [--select builds|tests|deploys] [--github-output] [--output-name <name>]"}}{{br}}
+Print the modules affected by the changes between the merge base of {{c "--from"}} and {{c "--to"}}
+(default {{c "HEAD"}}) revisions, and {{c "--to"}}, as a GitHub Actions matrix (see {{c "mbt affected --help"}}
+for the selection of modules). Each entry of the matrix contains the name, path, version, tags, owners
+and the dependencies of a module.
+
+{{c "{\"include\":[{\"name\":\"app-a\",\"path\":\"app-a\",\"version\":\"...\",\"tags\":[],\"owners\":[],\"dependencies\":[]}]}"}}
+
+{{c "--github-output"}} appends the matrix to the step outputs file in {{c "GITHUB_OUTPUT"}} as {{c "<name>"}}
+(default {{c "matrix"}}) and the number of modules as {{c "<name>-count"}}. GitHub Actions rejects an empty
+matrix, therefore, use the count to skip the job when no modules are affected.
+
+{{c "jobs:"}}{{br}}
+{{c "  plan:"}}{{br}}
+{{c "    runs-on: ubuntu-latest"}}{{br}}
+{{c "    outputs:"}}{{br}}
+{{c "      matrix: ${{ steps.mbt.outputs.matrix }}"}}{{br}}
+{{c "      count: ${{ steps.mbt.outputs.matrix-count }}"}}{{br}}
+{{c "    steps:"}}{{br}}
+{{c "      - uses: actions/checkout@v4"}}{{br}}
+{{c "        with:"}}{{br}}
+{{c "          fetch-depth: 0"}}{{br}}
+{{c "      - id: mbt"}}{{br}}
+{{c "        run: mbt ci github-matrix --from origin/${{ github.base_ref }} --github-output"}}{{br}}
+{{c "  build:"}}{{br}}
+{{c "    needs: plan"}}{{br}}
+{{c "    if: needs.plan.outputs.count != '0'"}}{{br}}
+{{c "    strategy:"}}{{br}}
+{{c "      matrix: ${{ fromJSON(needs.plan.outputs.matrix) }}"}}{{br}}
+{{c "    runs-on: ubuntu-latest"}}{{br}}
+{{c "    steps:"}}{{br}}
+{{c "      - uses: actions/checkout@v4"}}{{br}}
+{{c "      - run: mbt build commit $GITHUB_SHA --query 'name == \"${{ matrix.name }}\"'"}}{{br}}
+
+{{c "mbt ci github-comment --from <rev> [--to <rev>] [--build-summary <file>] [--repository <owner/name>] [--pr <number>] [--dry-run]"}}{{br}}
+Post a comment summarising the impact of a pull request. The comment lists the modules affected by the
+changes between the merge base of {{c "--from"}} and {{c "--to"}}, and {{c "--to"}}, whether each module
+changed or it is affected through a dependency, the change of its version and the number of modules
+depending on it. Modules removed in the pull request are listed as well. When {{c "--build-summary"}}
+is a summary written by {{c "mbt build --summary-file"}}, the outcome and the duration of the build of each
+module are included. Issues referenced by the commits affecting each module are listed if there
+are any (see {{c "mbt changelog --help"}}).
+
+Comment is posted with the token in {{c "GITHUB_TOKEN"}} (it requires the {{c "pull-requests: write"}}
+permission) to the pull request which triggered the workflow, unless {{c "--repository"}}
+and {{c "--pr"}} are specified. mbt updates the comment it posted earlier instead of adding a new
+one. {{c "--dry-run"}} prints the comment in markdown without posting it.
+
+{{c "      - run: mbt build commit $GITHUB_SHA --summary-file summary.json"}}{{br}}
+{{c "      - if: always()"}}{{br}}
+{{c "        run: mbt ci github-comment --from origin/${{ github.base_ref }} --build-summary summary.json"}}{{br}}
+{{c "        env:"}}{{br}}
+{{c "          GITHUB_TOKEN: ${{ secrets.GITHUB_TOKEN }}"}}{{br}}
+
+{{c "mbt ci publish-status --provider github|gitlab --build-summary <file> [--log-dir <dir>] [--repository <repo>] [--sha <commit>]"}}{{br}}
+Publish the outcome of each module in a summary written by {{c "--summary-file"}}, so that the modules
+that failed are visible in the commit (and the pull request) instead of a single status of the whole
+build. When {{c "--log-dir"}} is the directory specified with the build, the last 50 lines of the output
+of each module are included.
+
+- github: A check run named {{c "mbt/<command>/<module>"}} for each module with the output in its details.
+  Published with the token in {{c "GITHUB_TOKEN"}} (it requires the {{c "checks: write"}} permission)
+  to the repository in {{c "GITHUB_REPOSITORY"}}.
+- gitlab: A commit status named {{c "mbt/<command>/<module>"}} for each module linked to the job
+  in {{c "CI_JOB_URL"}}. Commit statuses only have a short description, therefore, just the last line
+  of the output of failed modules is included. Published with the token in {{c "GITLAB_TOKEN"}} (a token
+  with the {{c "api"}} scope) to the project in {{c "CI_PROJECT_ID"}}.
+
+{{c "      - run: mbt build commit $GITHUB_SHA --summary-file summary.json --log-dir logs"}}{{br}}
+{{c "      - if: always()"}}{{br}}
+{{c "        run: mbt ci publish-status --provider github --build-summary summary.json --log-dir logs"}}{{br}}
+{{c "        env:"}}{{br}}
+{{c "          GITHUB_TOKEN: ${{ secrets.GITHUB_TOKEN }}"}}{{br}}
+
+{{c "mbt ci gitlab-pipeline --from <rev> [--to <rev>] [--select builds|tests|deploys] [--image <image>] [--stage <stage>] [--out <file>]"}}{{br}}
+Generate a GitLab CI pipeline building the affected modules, to be used as a dynamic child pipeline.
+Each module is built in a job running its build command for linux (or the default build command)
+in the image of the module (or {{c "--image"}}). Jobs {{c "needs"}} the jobs of the dependencies of
+the module, so that modules are built in the dependency order while independent modules are built
+concurrently. Variables mbt initialises for build commands (e.g. {{c "MBT_MODULE_VERSION"}}) and the
+{{c "env"}} of the module are available in the jobs. Pipeline contains a job that does nothing
+if there are no modules to build.
+
+{{c "generate:"}}{{br}}
+{{c "  script: mbt ci gitlab-pipeline --from $CI_MERGE_REQUEST_DIFF_BASE_SHA --out modules.yml"}}{{br}}
+{{c "  artifacts:"}}{{br}}
+{{c "    paths: [modules.yml]"}}{{br}}
+{{c "modules:"}}{{br}}
+{{c "  trigger:"}}{{br}}
+{{c "    include:"}}{{br}}
+{{c "      - artifact: modules.yml"}}{{br}}
+{{c "        job: generate"}}{{br}}
+{{c "    strategy: depend"}}{{br}}
+
+{{c "mbt ci pipeline --provider azure|buildkite|circleci|github|github-actions|gitlab|jenkins --from <rev> [--to <rev>] [--select builds|tests|deploys] [--image <image>] [--out <file>]"}}{{br}}
+Generate the pipeline of a CI system building the affected modules. All pipelines are generated from the
+same plan of the build, so that modules are built with the same commands, environment variables and
+ordering regardless of the CI system.
+
+- azure: Template of Azure Pipelines jobs to include in a stage (or a pipeline) with {{c "- template: <file>"}}.
+  Jobs depend on the jobs of the dependencies of the module and the jobs of modules with an image run in
+  a container.
+- buildkite: Pipeline in json for {{c "buildkite-agent pipeline upload"}}. Steps depend on the steps of the
+  dependencies of the module and the steps of modules with an image run in the docker plugin.
+  Environment variables in the commands are escaped, so that they are interpolated when the steps run.
+- circleci: Config continued from a setup workflow (dynamic config) in a workflow named {{c "mbt"}}.
+  Jobs require the jobs of the dependencies of the module and run in the image of the module
+  (or {{c "cimg/base:stable"}}).
+- github: GitHub Actions matrix (same as {{c "github-matrix"}})
+- github-actions: Reusable GitHub Actions workflow ({{c "on: workflow_call"}}) with a job for each module.
+  Jobs need the jobs of the dependencies of the module, so that independent modules are built in parallel,
+  and the jobs of modules with an image run in a container. Expressions in the environment variables are
+  escaped, so that they are not evaluated by GitHub Actions.
+- gitlab: GitLab CI child pipeline (same as {{c "gitlab-pipeline"}})
+- jenkins: Stages to include in the {{c "stages"}} section of a declarative Jenkinsfile. Declarative pipelines
+  do not support dependencies between stages, therefore, modules are built in parallel stages grouped such
+  that the modules in a group only depend on the modules in preceding groups.
+
+{{c "buildkite-agent pipeline upload <(mbt ci pipeline --provider buildkite --from origin/main)"}}{{br}}
+
+For example, setup workflow of a CircleCI dynamic config:
+
+{{c "version: 2.1"}}{{br}}
+{{c "setup: true"}}{{br}}
+{{c "orbs:"}}{{br}}
+{{c "  continuation: circleci/continuation@1"}}{{br}}
+{{c "jobs:"}}{{br}}
+{{c "  setup:"}}{{br}}
+{{c "    executor: continuation/default"}}{{br}}
+{{c "    steps:"}}{{br}}
+{{c "      - checkout"}}{{br}}
+{{c "      - run: mbt ci pipeline --provider circleci --from origin/main --out modules.yml"}}{{br}}
+{{c "      - continuation/continue:"}}{{br}}
+{{c "          configuration_path: modules.yml"}}{{br}}
+{{c "workflows:"}}{{br}}
+{{c "  setup:"}}{{br}}
+{{c "    jobs: [setup]"}}{{br}}
+
+Pipeline of a CI system can be replaced with a go template in the repository, with {{c "pipelines"}}
+in {{c ".mbt/config.yml"}} (e.g. {{c "pipelines: {github-actions: .mbt/ci/workflow.yml.tmpl}"}}). Templates can
+also be specified for CI systems not listed above and they are rendered with the same plan:
+
+{{c ".Manifest"}}: Manifest of the modules (see {{c "mbt apply --help"}}){{br}}
+{{c ".Steps"}}: Step of each module in the dependency order, with {{c ".Module"}}, {{c ".Image"}}, {{c ".Dir"}} (relative to the repository root), {{c ".Command"}}, {{c ".Variables"}}, {{c ".Needs"}} (names of the modules built before) and {{c ".Buildable"}} (false if the module does not have a build command for linux){{br}}
+{{c ".Levels"}}: Buildable steps grouped such that the steps in a group only need the steps in preceding groups{{br}}
+{{c ".Options"}}: {{c ".Image"}} and {{c ".Stage"}} specified with {{c "--image"}} and {{c "--stage"}}
+
+Functions compatible with sprig (see {{c "mbt apply --help"}}) are available in the templates.
+`,
+	"affected-summary": `List the modules affected by a change`,
+	"affected": `{{cli "List the modules affected by a change \n"}}
+{{c "mbt affected --base <rev> [--head <rev>] [--select builds|tests|deploys] [--shard <index>/<count> [--shard-by <command>]] [--format text|json|yaml]"}}{{br}}
+List the modules affected by the changes between the merge base of {{c "--base"}} and {{c "--head"}}
+(default {{c "HEAD"}}) revisions, and {{c "--head"}}, for the purpose specified with {{c "--select"}}
+(default builds). Revisions can be branches, tags or commits.
+Modules are listed in the dependency order, one per line unless {{c "--format"}} is specified.
+
+- builds: Modules changed and the modules depending on them
+- tests: Affected modules declaring a {{c "test"}} command or tagged with {{c "test"}}
+- deploys: Affected modules declaring a {{c "deploy"}} command or tagged with {{c "deploy"}}
+
+Selection of each purpose can be changed, and new purposes can be added, in {{c ".mbt/config.yml"}}
+of the head revision. {{c "query"}} is an expression selecting the modules (see Queries in {{c "mbt help"}})
+and {{c "dependents"}} specifies whether the modules depending on the changed modules are affected
+(default true).
+
+{{c "affected:"}}{{br}}
+{{c "  tests:"}}{{br}}
+{{c "    query: '\"test\" in commands && !(\"slow\" in tags)'"}}{{br}}
+{{c "  docs:"}}{{br}}
+{{c "    query: 'properties.public'"}}{{br}}
+{{c "    dependents: false"}}{{br}}
+
+For example, to list the modules to test in a pull request:
+
+{{c "mbt affected --base origin/main --select tests"}}{{br}}
+
+{{h2 "Sharding"}}
+Use {{c "--shard <index>/<count>"}} to split the affected modules across several CI workers
+(e.g. {{c "--shard 3/8"}} on the third of eight workers). Each module is assigned to exactly one
+shard and every worker computes the same shards for the same modules.
+Shards are balanced by the mean durations of the modules in the history of the command
+specified with {{c "--shard-by"}} (default {{c "build"}}, see {{c "mbt stats --help"}}). Modules without
+a history are given the mean duration of the other modules, therefore the modules are balanced
+by count when the history is not recorded. Since workers must use the same durations, share the
+history between them (e.g. restore {{c ".git/mbt/history.jsonl"}} from a cache) or none of them.
+
+{{c "mbt run-in commit $(git rev-parse HEAD) -m test --name $(mbt affected --base origin/main --select tests --shard 3/8 | paste -sd, -)"}}{{br}}
+`,
+	"execute-summary": `Execute a build plan archive`,
+	"execute": `{{cli "Execute a build plan archive \n"}}
+{{c "mbt execute <plan.tar> [--in <dir>]"}}{{br}}
+Execute the plan written with {{c "mbt build <command> --plan --out <plan.tar>"}} in the
+checkout in the current directory or {{c "--in"}}.
+
+Repository is not opened, therefore the checkout does not need to contain {{c ".git"}}. Files
+of the modules planned are verified against the digests in the plan before executing any
+command and the plan is not executed if any of them is different or missing.
+Steps are executed in order and the execution stops at the first failure.
+Environment of the commands (including secrets) is created again from the manifest in
+the plan and {{c ".mbt/config.yml"}} of the checkout.
+`,
+	"fmt-summary": `Format module specs`,
+	"fmt": `{{cli "Format module specs \n"}}
+{{c "mbt fmt [--check]"}}{{br}}
+Rewrite the {{c ".mbt.yml"}} files in the workspace in the canonical form and list the files changed.
+Use {{c "--check"}} in CI to list the specs not in the canonical form without changing them.
+It fails if there are any.
+
+In the canonical form keys are in the order they are documented in {{c "mbt --help"}} and keys of
+dictionaries (e.g. {{c "build"}} and {{c "properties"}}) are sorted. Paths in {{c "fileDependencies"}},
+{{c "reports"}} and {{c "outputs"}} are normalised, {{c "dependencies"}}, {{c "fileDependencies"}} and
+{{c "workspaceDependencies"}} are sorted and the content is indented with two spaces.
+Keys unknown to mbt are kept after the others.
+
+Specs containing comments are not formatted since the comments cannot be preserved.
+Version of a module is derived from its dependencies in the order they are declared, so
+sorting the dependencies of a module changes its version once.
+`,
+	"doctor-summary": `Check the environment and the repository for issues`,
+	"doctor": `{{cli "Check the environment and the repository for issues \n"}}
+{{c "mbt doctor [--format text|json]"}}{{br}}
+Check the environment and the repository for the issues preventing mbt from working as
+expected and print how to fix them. Checks are:
+
+- git: libgit2 mbt is linked with supports threads, https and ssh
+- repository: Repository can be opened and has commits
+- history: Repository is not a shallow clone (merge bases may be missing otherwise)
+- head: Head is on a branch
+- workspace: Workspace does not have uncommitted changes
+- config: {{c ".mbt/config.yml"}} is valid
+- modules: Module specs in the workspace are valid and the dependency graph does not have cycles
+- properties: Properties of modules match {{c "propertiesSchema"}} (when it's specified in {{c ".mbt/config.yml"}})
+- container runtime: {{c "docker"}} is available (when there are modules specifying an image)
+- state: State directory in the git directory (build journal, statistics and cached manifests) is writable
+
+Warnings affect some commands only. mbt exits with a non zero code if any check finds an error.
+`,
+	"report-summary": `Report the statistics of modules`,
+	"report": `{{cli "Report the statistics of modules \n"}}
+{{c "mbt report [--format json|html] [--window <period>] [--out <file>]"}}{{br}}
+Report the statistics of each module in current head as json (default) or a html page.
+Statistics are:
+
+- Number of files and lines in the module directory (excluding nested modules)
+- Number of modules the module depends on and the modules depending on it
+- Depth of the module in the dependency graph (0 for modules without dependencies)
+- Number of commits changing the version of the module and the rate of changes per week
+  in the {{c "--window"}} period before the last commit (default 30d)
+
+Merge commits are not counted as changes.
+`,
+	"completion-summary": `Print the shell completion script`,
+	"completion": `{{cli "Print the shell completion script \n"}}
+{{c "mbt completion <bash|zsh|fish|powershell>"}}{{br}}
+Print the script completing the commands and flags of mbt in the specified shell.
+Module names, tags (in {{c "--query"}}) and branches are completed as well, using a
+manifest of current head cached in {{c ".git/mbt"}}. The cache is refreshed when head
+moves to a different commit.
+
+{{c "source <(mbt completion bash)"}}{{br}}
+{{c "mbt completion zsh > \"${fpath[1]}/_mbt\""}}{{br}}
+{{c "mbt completion fish > ~/.config/fish/completions/mbt.fish"}}{{br}}
+{{c "mbt completion powershell | Out-String | Invoke-Expression"}}
+`,
+	"schema-summary": `Print the JSON Schema of the structured outputs and the files of mbt`,
+	"schema": `{{cli "Print the JSON Schema of the structured outputs and the files of mbt \n"}}
+{{c "mbt schema [<name>]"}}{{br}}
+Print the JSON Schema of an output or a file, or list the schemas embedded in the binary when no
+name is specified. Schemas can be used to generate the types of consumers, to check the
+compatibility of the outputs when upgrading mbt and to validate the files in editors.
+
+- config: Repository configuration in {{c ".mbt/config.yml"}}
+- describe: Output of {{c "mbt describe"}} with {{c "--format json"}} (or yaml)
+- export: Manifest written by {{c "mbt describe export"}}
+- plan: Build plan written by {{c "mbt build"}} with {{c "--plan --json"}}
+- spec: Module spec in {{c ".mbt.yml"}}
+- summary: Summary written with {{c "--summary-file"}}
+
+Each schema has a version in its {{c "$id"}} (e.g. {{c "https://github.com/mbtproject/mbt/schemas/summary.v1.json"}}),
+which is incremented for changes that are not backwards compatible such as removing or renaming a field.
+Adding a field does not change the version. describe and export outputs contain the version in {{c "schemaVersion"}}.
+`,
+	"sbom-summary": `Generate the software bill of materials of modules`,
+	"sbom": `{{cli "Generate the software bill of materials of modules \n"}}
+{{c "mbt sbom [--format spdx|cyclonedx] [--out-dir <dir>] [--branch <name> | --commit <commit> | --src <name> --dst <name> | --from <commit> --to <commit> | --local]"}}{{br}}
+Generate an SBOM for each module in current head (or the branch, commit, changes or
+workspace specified) in SPDX 2.3 (default) or CycloneDX 1.5 json format.
+SBOMs are written to stdout or to {{c "<module>.spdx.json"}} ({{c "<module>.cdx.json"}}) files in
+{{c "--out-dir"}}. Modules can be narrowed down with {{c "--name"}} and {{c "--query"}} filters.
+
+SBOM of a module describes the module and the modules it depends on, the digests of their
+files (including file dependencies) and their external dependencies.
+External dependencies are declared in {{c "externalDependencies"}} of {{c ".mbt.yml"}} or listed by
+plugins specified in {{c ".mbt/config.yml"}}. A plugin is a command executed in each module
+directory writing a json array of external dependencies to stdout (e.g. by reading the
+package manifests of the module). Build environment variables such as {{c "MBT_MODULE_NAME"}}
+are available to plugins.
+
+{{c ""}}
+sbomPlugins:
+  - cmd: ./tools/go-deps.sh
+    shell: sh
+{{c ""}}
+
+Output of a plugin:
+
+{{c ""}}
+[
+  {
+    "name": "yaml",
+    "version": "2.1.0",
+    "purl": "pkg:golang/gopkg.in/yaml.v2@2.1.0",
+    "license": "Apache-2.0"
+  }
+]
+{{c ""}}
+
+Commit of the modules is checked out while generating the SBOMs (unless {{c "--local"}}
+is specified), therefore the workspace must be clean.
+`,
+	"cache-summary": `Describe and clean up the caches of mbt`,
+	"cache": `{{cli "Describe and clean up the caches of mbt \n"}}
+{{c "mbt cache stats [--json]"}}{{br}}
+{{c "mbt cache gc [--max-size <size>] [--max-age <period>] [--keep-last <n>] [--dry-run] [--json]"}}{{br}}
+{{c "mbt cache clear [discovery|templates|repos|build...] [--json]"}}{{br}}
+mbt caches the following to speed up subsequent invocations:
+
+- discovery: Modules discovered in each commit, including the ones discovered with the specs of
+  another commit ({{c ".git/mbt/discovery"}}).
+- templates: Content of the template sources for each digest ({{c ".git/mbt/templates"}}).
+- repos: Repositories specified by a url ({{c "$MBT_CACHE_DIR/repos"}} or the cache directory of the user).
+- build: Outputs of the modules built, in the {{c "--cache-dir"}} of the last build (see Build Cache in
+  {{c "mbt build --help"}}).
+- fingerprints: Fingerprints of the versions of each module ({{c ".git/mbt/fingerprints.json"}}).
+
+{{c "stats"}} lists the number of entries, the size and the modification times of the oldest and
+the newest entries of each cache.
+
+{{c "gc"}} removes the entries not retained by the policies specified. Policies are applied to
+each cache separately and entries are kept when no policy is specified.
+
+- {{c "--max-age"}}: Entries not modified in the period (e.g. {{c "30d"}} or {{c "12h"}}) are removed.
+- {{c "--max-size"}}: Least recently modified entries are removed until the cache fits in the size
+  (e.g. {{c "512Mi"}} or {{c "10G"}}).
+- {{c "--keep-last"}}: Only the last versions of each module are kept in the fingerprints cache.
+  Modules depending on a module whose fingerprint is removed are built again when it changes.
+  Other policies do not apply to the fingerprints cache.
+
+Removing an entry never changes the outcome of a build, the cache is populated again on demand.
+{{c "--dry-run"}} reports the entries that would be removed without removing them.
+
+{{c "clear"}} removes all the entries of the caches specified, or of the discovery, templates, repos
+and build caches if none is specified. Fingerprints are only removed by {{c "gc --keep-last"}}.
+
+Modules of a commit not in the discovery cache are derived from the most recent entry, so only
+the specs changed between the two commits are read again. Use {{c "--no-cache"}} with any
+command to discover the modules without reading or writing the discovery cache (e.g. to rule
+out the cache when investigating an unexpected manifest).
+`,
+	"stats-summary": `Show build statistics`,
+	"stats": `{{cli "Show build statistics \n"}}
+{{c "mbt stats flaky [--json]"}}{{br}}
+List the modules that have failed to build in this repository along with
+the number of builds, failures, retries and flakes (failures of a version
+that subsequently built successfully). Modules with most flakes are listed first.
+Statistics are stored locally in {{c ".git/mbt"}} directory.
+
+{{c "mbt stats durations [--since <age>] [--top <n>] [--command <command>] [--sort mean|trend] [--json]"}}{{br}}
+Summarise the durations of modules recorded in the history of builds and runs of user defined
+commands (e.g. {{c "mbt stats durations --since 30d --top 20"}}). For each module, the number of
+runs, failures and cache hits (modules resumed or satisfied by their probes), and the mean, 90th
+percentile, maximum and last duration of the runs are listed. Trend is the change in the mean
+duration of the later half of the runs over the earlier half, which reveals the modules becoming
+slower. Modules are sorted by the mean duration or by the trend with {{c "--sort trend"}}.
+Use {{c "--command"}} to consider just the builds ({{c "build"}}) or a user defined command.
+
+History is recorded when {{c "history"}} is enabled in the metrics section of {{c ".mbt/config.yml"}}
+and it is stored in {{c ".git/mbt/history.jsonl"}} as a json document per line.
+{{c "historyRetention"}} specifies the age of the oldest record kept (e.g. {{c "90d"}}).
+
+{{c ""}}
+metrics:
+  history: true
+  historyRetention: 90d
+{{c ""}}
+`,
+	"migrate-summary": `Run a migration command in the dependents of a module`,
+	"migrate": `{{cli "Run a migration command in the dependents of a module \n"}}
+{{c "mbt migrate <module> [--command <command> | -- exec <command>] [--resume] [--json]"}}{{br}}
+Run a command in each module depending on {{c "<module>"}} directly or indirectly, for example
+after changing the API of the module. Dependents are visited one at a time in the dependency order
+so that a module is migrated after the modules it depends on. The command is a user defined command
+({{c "--command"}}) or any command specified with {{c "exec"}} after {{c "--"}} as in {{c "mbt run-in"}}
+(e.g. {{c "mbt migrate app-a -- exec 'npm run codemod && npm test'"}}). Dependents not defining the
+user defined command are skipped.
+
+The command runs in the modules of the workspace, so the changes it makes can be reviewed and
+committed afterwards. Migration stops at the first failure, reporting the dependents migrated,
+the one that failed and the ones pending. Fix the failure and run the same migration with
+{{c "--resume"}} to continue without running the command again in the migrated dependents.
+Progress is stored in {{c ".git/mbt/migration.json"}}.
+`,
+	"run-summary": `Run a command in modules`,
+	"run": `{{cli "Run a command in modules \n"}}
+{{c "mbt run <branch|commit|diff|head|local|pr> [options] -- <command> [<args>...]"}}{{br}}
+Run a command that is not declared in {{c ".mbt.yml"}} (e.g. a linter) in each of the
+selected modules, in the dependency order. This is the same as {{c "mbt run-in"}} with
+{{c "exec"}} and accepts the same selectors and options.
+A single argument is interpreted by the shell ({{c "sh"}} or {{c "cmd"}} in windows, override
+with {{c "--shell"}}). Otherwise the first argument is executed with the remaining arguments.
+
+The command is executed in the module directory with {{c "MBT_MODULE_NAME"}},
+{{c "MBT_MODULE_VERSION"}}, {{c "MBT_MODULE_PATH"}} and the properties of the module
+({{c "MBT_MODULE_PROPERTY_<NAME>"}}) in the environment.
+
+{{c "mbt run pr --src feature --dst master -- 'npm test'"}}{{br}}
+{{c "mbt run local --all -j 4 -- go vet ./..."}}{{br}}
+{{c "mbt run head --query '\"publish\" in tags' -- ./publish.sh"}}
 `,
 	"run-in-summary": `Run user defined command`,
 	"run-in": `{{cli "Run user defined command \n"}}
-{{c "mbt run-in branch [name] [--content] [--name <name>] [--fuzzy]"}}{{br}}
-Run user defined command in modules in a branch. Assume master if branch name is not specified.
+{{c "mbt run-in branch [name | --commit <sha>] [--content] [--name <name>] [--fuzzy]"}}{{br}}
+Run user defined command in modules in a branch. Assume master (or the commit checked out if there is no master branch) if branch name is not specified.
+Use --commit to specify a commit instead.
 Consider just the modules matching the {{c "--name"}} filter if specified.
 Default {{c "--name"}} filter is a prefix match. You can change this to a subsequence
 match by using {{c "--fuzzy"}} option.
@@ -321,6 +2429,25 @@ Consider just the modules matching the {{c "--name"}} filter if specified.
 Default {{c "--name"}} filter is a prefix match. You can change this to a subsequence
 match by using {{c "--fuzzy"}} option.
 
+{{h2 "Arbitrary Commands"}}
+
+Instead of a command declared in {{c ".mbt.yml"}}, any command can be run in the selected
+modules by specifying it with {{c "exec"}} after {{c "--"}}.
+A single argument is interpreted by the shell ({{c "sh"}} or {{c "cmd"}} in windows, override
+with {{c "--shell"}}). Otherwise the first argument is executed with the remaining arguments.
+
+{{c "mbt run-in diff --from <commit> --to <commit> -- exec 'npm test'"}}{{br}}
+{{c "mbt run-in local --all -- exec go vet ./..."}}{{br}}
+
+{{h2 "Concurrency"}}
+
+By default, the command is executed in one module at a time in the dependency order.
+Use {{c "--jobs (-j)"}} to run the command in up to the specified number of modules
+concurrently. Each module still waits for its dependencies to complete.
+{{c "--parallel"}} ignores the dependencies and defaults {{c "--jobs"}} to the number of cores.
+Unless {{c "--fail-fast"}} is specified, a failure does not stop the command being
+executed in the remaining modules.
+
 {{h2 "Execution Environment"}}
 
 When executing a command, following environment variables are initialised and can be
@@ -332,6 +2459,15 @@ used by the command being executed.
 
 In addition to the variables listed above, module properties are also populated 
 in the form of {{c "MBT_MODULE_PROPERTY_XXX"}} where {{c "XXX"}} denotes the key.
+
+{{h2 "Invocation Summary"}}
+Use {{c "--summary-file <file>"}} to write a json summary of the run, in the same
+format as {{c "mbt build"}}.
+
+{{h2 "Policies"}}
+Rego policies of the repository are evaluated before the command is executed
+in any module, in the same way as {{c "mbt build"}}. The name of the command is
+available to the policies in {{c "input.userCommand"}}.
 `,
 }
 
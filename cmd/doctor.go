@@ -0,0 +1,76 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+)
+
+var doctorFormat string
+
+func init() {
+	doctorCmd.Flags().StringVar(&doctorFormat, "format", lib.DoctorFormatText, "Output format (text or json)")
+	RootCmd.AddCommand(doctorCmd)
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor [--format text|json]",
+	Short: docText("doctor-summary"),
+	Long:  docText("doctor"),
+	// Repository is opened by doctor (see RootCmd), so that the failure
+	// is reported along with the other checks.
+	RunE: func(cmd *cobra.Command, args []string) error {
+		report := doctorReport()
+		if err := report.Write(doctorFormat, os.Stdout); err != nil {
+			return err
+		}
+
+		errors := 0
+		for _, c := range report.Checks {
+			if c.Status == lib.DoctorStatusError {
+				errors++
+			}
+		}
+		if errors > 0 {
+			return fmt.Errorf("%v of %v checks failed", errors, len(report.Checks))
+		}
+		return nil
+	},
+}
+
+func doctorReport() *lib.DoctorReport {
+	path, err := repoPath()
+	if err != nil {
+		return lib.RepositoryUnavailableReport(in, err)
+	}
+
+	level := lib.LogLevelNormal
+	if debug {
+		level = lib.LogLevelDebug
+	}
+
+	s, err := lib.NewSystemWithContext(lib.WithRepoBackend(context.Background(), gitBackend), path, level)
+	if err != nil {
+		return lib.RepositoryUnavailableReport(path, err)
+	}
+
+	return s.Doctor()
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/mbtproject/mbt/lib"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	executeCmd.Flags().StringArrayVarP(&envVars, "env", "e", nil, "Set an environment variable (KEY=VALUE) for the build commands")
+	executeCmd.Flags().StringVar(&containerRuntime, "container-runtime", "docker", "Container runtime used to run commands of modules specifying an image")
+	RootCmd.AddCommand(executeCmd)
+}
+
+var executeCmd = &cobra.Command{
+	Use:   "execute <plan.tar>",
+	Short: docText("execute-summary"),
+	Long:  docText("execute"),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handlerError(executePlan(args))
+	},
+}
+
+// executePlan executes the plan archive in args without a system since
+// the repository is not opened.
+func executePlan(args []string) error {
+	if len(args) == 0 {
+		return e.NewError(lib.ErrClassUser, "requires the plan archive")
+	}
+
+	dir := in
+	if dir == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		dir = cwd
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	options := lib.CmdOptionsWithStdIO(buildStageCB)
+	options.Env = envVars
+	options.ContainerRuntime = containerRuntime
+	options.Context = interrupt
+
+	level := lib.LogLevelNormal
+	if debug {
+		level = lib.LogLevelDebug
+	}
+
+	summary, err := lib.ExecutePlanArchive(f, dir, options, lib.NewStdLog(level))
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Built: %v", len(summary.Completed))
+	logrus.Infof("Plan executed for commit %v", summary.Manifest.Sha)
+	return nil
+}
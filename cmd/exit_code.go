@@ -0,0 +1,96 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+	"strings"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// resultCode is the exit code of a command that completed without an
+// error, for example when there was nothing to build.
+var resultCode = lib.ExitCodeSuccess
+
+// Execute executes the command specified in the arguments and returns
+// the exit code of mbt.
+func Execute() int {
+	c, err := RootCmd.ExecuteC()
+	if perr := stopProfiling(); perr != nil {
+		logrus.Warn(perr)
+	}
+
+	code := resultCode
+	if err != nil {
+		code = lib.ExitCode(err)
+		if code == lib.ExitCodeSuccess {
+			code = lib.ExitCodeError
+		}
+	}
+
+	if serr := writeCommandSummary(c, code, err); serr != nil {
+		logrus.Warn(serr)
+	}
+
+	if err != nil {
+		writeDiagnostic(err)
+	}
+
+	return code
+}
+
+// removeStaleSummary removes the summary written by a previous invocation
+// so that writeCommandSummary can tell if the command wrote its own
+// summary.
+func removeStaleSummary() error {
+	if summaryFile == "" {
+		return nil
+	}
+
+	err := os.Remove(summaryFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// writeCommandSummary writes the summary of commands that do not write
+// a detailed summary (or failed before writing one) to --summary-file.
+func writeCommandSummary(c *cobra.Command, code int, err error) error {
+	if summaryFile == "" || c == nil {
+		return nil
+	}
+
+	if _, serr := os.Stat(summaryFile); serr == nil {
+		return nil
+	}
+
+	summary := &lib.InvocationSummary{
+		Command:  strings.TrimPrefix(c.CommandPath(), RootCmd.Name()+" "),
+		Success:  err == nil,
+		ExitCode: code,
+		Modules:  []*lib.ModuleSummary{},
+	}
+	if err != nil {
+		summary.Error = err.Error()
+		summary.Diagnostic = lib.Diagnose(err, nil)
+	}
+
+	return lib.WriteInvocationSummary(summaryFile, summary)
+}
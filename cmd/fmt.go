@@ -0,0 +1,56 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+)
+
+var fmtCheck bool
+
+func init() {
+	fmtCmd.Flags().BoolVar(&fmtCheck, "check", false, "List the specs not in the canonical form without changing them and fail if there are any")
+	RootCmd.AddCommand(fmtCmd)
+}
+
+var fmtCmd = &cobra.Command{
+	Use:   "fmt [--check]",
+	Short: docText("fmt-summary"),
+	Long:  docText("fmt"),
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		results, err := system.FormatSpecs(fmtCheck)
+		if err != nil {
+			return err
+		}
+
+		changed := 0
+		for _, r := range results {
+			if r.Changed {
+				changed++
+				fmt.Println(r.Path)
+			}
+		}
+
+		if fmtCheck && changed > 0 {
+			return e.NewErrorf(lib.ErrClassUser, "%v specs are not in the canonical form, run mbt fmt to format them", changed)
+		}
+		return nil
+	}),
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyFormat string
+	minCoChanges  int
+	minShare      float64
+)
+
+func init() {
+	historyCmd.Flags().StringVar(&from, "from", "", "Analyse the history since this revision (branch, tag or commit)")
+	historyCmd.Flags().StringVar(&to, "to", "", "Analyse the history up to this revision (defaults to the current branch)")
+	historyCmd.Flags().IntVar(&minCoChanges, "min-co-changes", lib.DefaultMinCoChanges, "Number of commits two modules must change together in to be reported as coupled")
+	historyCmd.Flags().StringVar(&historyFormat, "format", lib.HistoryFormatText, "Output format (text or json)")
+	historyOwnershipCmd.Flags().StringVar(&from, "from", "", "Analyse the history since this revision (branch, tag or commit)")
+	historyOwnershipCmd.Flags().StringVar(&to, "to", "", "Analyse the history up to this revision (defaults to the current branch)")
+	historyOwnershipCmd.Flags().Float64Var(&minShare, "min-share", lib.DefaultMinOwnershipShare, "Fraction of the commits changing a module an author must have to be a primary contributor")
+	historyCmd.AddCommand(historyOwnershipCmd)
+	RootCmd.AddCommand(historyCmd)
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history [--from <rev>] [--to <rev>] [--min-co-changes <n>] [--format text|json]",
+	Short: docText("history-summary"),
+	Long:  docText("history"),
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		analysis, err := system.AnalyseHistory(&lib.HistoryOptions{From: from, To: to, MinCoChanges: minCoChanges})
+		if err != nil {
+			return err
+		}
+
+		return analysis.Write(historyFormat, os.Stdout)
+	}),
+}
+
+var historyOwnershipCmd = &cobra.Command{
+	Use:   "ownership [--from <rev>] [--to <rev>] [--min-share <fraction>]",
+	Short: docText("history-ownership-summary"),
+	Long:  docText("history-ownership"),
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		report, err := system.Ownership(&lib.OwnershipOptions{From: from, To: to, MinShare: minShare})
+		if err != nil {
+			return err
+		}
+
+		return report.Write(os.Stdout)
+	}),
+}
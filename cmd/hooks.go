@@ -0,0 +1,105 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	hooks      []string
+	forceHooks bool
+)
+
+func init() {
+	installHooksCmd.Flags().StringSliceVar(&hooks, "hook", []string{lib.HookPreCommit}, "Hooks to install (pre-commit and/or pre-push)")
+	installHooksCmd.Flags().StringVarP(&command, "command", "m", "", "Command to run in the modules affected by the change")
+	installHooksCmd.Flags().BoolVar(&forceHooks, "force", false, "Replace the existing hooks")
+	verifyHookCmd.Flags().StringVarP(&command, "command", "m", "", "Command to run in the modules affected by the change")
+	RootCmd.AddCommand(installHooksCmd)
+	RootCmd.AddCommand(verifyHookCmd)
+}
+
+var installHooksCmd = &cobra.Command{
+	Use:   "install-hooks [--hook pre-commit|pre-push] [--command <command>] [--force]",
+	Short: docText("install-hooks-summary"),
+	Long:  docText("install-hooks"),
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		installed, err := system.InstallHooks(&lib.HookOptions{Hooks: hooks, Command: command, Force: forceHooks})
+		for _, p := range installed {
+			logrus.Infof("Installed %v", p)
+		}
+		return err
+	}),
+}
+
+// verifyHookCmd is invoked by the hooks installed with install-hooks.
+var verifyHookCmd = &cobra.Command{
+	Use:    "verify-hook <pre-commit|pre-push> [--command <command>]",
+	Hidden: true,
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return errors.New("requires the hook name")
+		}
+
+		// Hook must fail when the command fails in any module.
+		failFast = true
+
+		switch args[0] {
+		case lib.HookPreCommit:
+			if _, err := system.VerifyPreCommit(); err != nil {
+				return err
+			}
+
+			if command != "" {
+				return summariseRun(system.RunInWorkspaceChanges(command, runInCmdOptions()))
+			}
+		case lib.HookPrePush:
+			updates, err := system.VerifyPrePush(os.Stdin)
+			if err != nil {
+				return err
+			}
+
+			if command == "" {
+				return nil
+			}
+
+			for _, u := range updates {
+				if u.IsDelete() {
+					continue
+				}
+
+				if u.IsNew() {
+					err = summariseRun(system.RunInCommit(command, u.LocalSha, lib.NoFilter, runInCmdOptions()))
+				} else {
+					err = summariseRun(system.RunInDiff(command, u.RemoteSha, u.LocalSha, runInCmdOptions()))
+				}
+				if err != nil {
+					return err
+				}
+			}
+		default:
+			return errors.New("unsupported hook " + args[0])
+		}
+
+		return nil
+	}),
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	bazelQuery  string
+	importDir   string
+	forceImport bool
+)
+
+func init() {
+	importBazelCmd.Flags().StringVar(&bazelQuery, "query", "", "File with the output of bazel query --output=proto")
+
+	for _, c := range []*cobra.Command{importBazelCmd, importGradleCmd} {
+		c.Flags().StringVar(&importDir, "dir", "", "Directory of the build relative to the repository root")
+		c.Flags().BoolVar(&forceImport, "force", false, "Replace the existing module specs")
+		c.Flags().BoolVar(&dryRun, "dry-run", false, "List the modules without writing their specs")
+	}
+
+	importCmd.AddCommand(importBazelCmd)
+	importCmd.AddCommand(importGradleCmd)
+	RootCmd.AddCommand(importCmd)
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: docText("import-summary"),
+	Long:  docText("import"),
+}
+
+var importBazelCmd = &cobra.Command{
+	Use: "bazel --query <file> [--dir <dir>] [--force] [--dry-run]",
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		return importModules(lib.ImportBazel)
+	}),
+}
+
+var importGradleCmd = &cobra.Command{
+	Use: "gradle [--dir <dir>] [--force] [--dry-run]",
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		return importModules(lib.ImportGradle)
+	}),
+}
+
+func importModules(source string) error {
+	modules, err := system.Import(&lib.ImportOptions{
+		Source: source,
+		Query:  bazelQuery,
+		Dir:    importDir,
+		Force:  forceImport,
+		DryRun: dryRun,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, m := range modules {
+		fmt.Printf("%s\t%s\t%s\n", m.Name, m.Path, strings.Join(m.Dependencies, ","))
+	}
+
+	return nil
+}
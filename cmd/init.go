@@ -0,0 +1,68 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	detectModules bool
+	forceInit     bool
+)
+
+func init() {
+	initCmd.Flags().BoolVar(&detectModules, "detect", false, "Propose the modules from build files and directory conventions")
+	initCmd.Flags().BoolVar(&forceInit, "force", false, "Replace the existing module specs and repository config")
+	initCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the report without writing any files")
+	RootCmd.AddCommand(initCmd)
+}
+
+var initCmd = &cobra.Command{
+	Use:   "init [--detect] [--force] [--dry-run]",
+	Short: docText("init-summary"),
+	Long:  docText("init"),
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		report, err := system.Init(&lib.InitOptions{Detect: detectModules, Force: forceInit, DryRun: dryRun})
+		if err != nil {
+			return err
+		}
+
+		for _, m := range report.Modules {
+			fmt.Printf("%s\t%s\t%s\n", m.Name, m.Path, m.Detector)
+		}
+
+		if report.Config != "" {
+			fmt.Printf("Repository config: %s\n", report.Config)
+		}
+
+		if len(report.Ambiguities) > 0 {
+			fmt.Println("Review the following:")
+			for _, a := range report.Ambiguities {
+				p := a.Path
+				if p == "" {
+					p = "."
+				}
+				fmt.Printf("  %s: %s\n", p, a.Reason)
+			}
+		}
+
+		return nil
+	}),
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/sirupsen/logrus"
+)
+
+// interrupt is done when mbt receives SIGINT or SIGTERM.
+var interrupt = context.Background()
+
+// handleInterrupts sets up interrupt to be done on the first SIGINT or
+// SIGTERM so that the running command stops gracefully.
+// mbt exits immediately on a subsequent signal.
+func handleInterrupts() {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		logrus.Warnf("Received %v, stopping (interrupt again to exit immediately)", sig)
+		cancel()
+		<-signals
+		os.Exit(lib.ExitCodeCancelled)
+	}()
+	interrupt = ctx
+}
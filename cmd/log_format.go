@@ -0,0 +1,81 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/mbtproject/mbt/lib"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+func setupLogFormat() error {
+	switch logFormat {
+	case logFormatText:
+	case logFormatJSON:
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return e.NewErrorf(lib.ErrClassUser, "unsupported log format '%s'", logFormat)
+	}
+	return nil
+}
+
+// withLogFormat configures the output of options as specified by
+// --log-format, --quiet, --prefix, --log-dir, --summary-file,
+// --events-url and --events-fd.
+// Output of modules is prefixed by default when they are built
+// concurrently.
+func withLogFormat(options *lib.CmdOptions) *lib.CmdOptions {
+	options.LogDir = logDir
+	options.SummaryFile = summaryFile
+	for _, u := range eventsURLs {
+		options.EventStreams = append(options.EventStreams, &lib.EventStream{URL: u})
+	}
+	if eventsFd > 0 {
+		options.Lifecycle = jsonEvents(os.NewFile(uintptr(eventsFd), "events"))
+	}
+	if logFormat == logFormatJSON {
+		options.Events = jsonEvents(os.Stdout)
+	} else if useQuiet() {
+		options = withQuietOutput(options)
+	} else if prefixOutput || options.Jobs > 1 {
+		options.Events = prefixedEvents(os.Stdout, os.Stderr)
+	}
+	return options
+}
+
+// jsonEvents creates an event handler writing each event in a line
+// as json.
+func jsonEvents(w io.Writer) lib.EventHandler {
+	mu := &sync.Mutex{}
+	encoder := json.NewEncoder(w)
+	return func(event *lib.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := encoder.Encode(event); err != nil {
+			logrus.Warnf("failed to write the event: %v", err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+)
+
+var mergeQueueFormat string
+
+func init() {
+	mergeQueueCmd.Flags().StringVar(&ciFrom, "base", "", "Target branch of the changes e.g. origin/main")
+	mergeQueueCmd.Flags().StringVar(&mergeQueueFormat, "format", lib.MergeQueueFormatText, "Output format (text or json)")
+	RootCmd.AddCommand(mergeQueueCmd)
+}
+
+var mergeQueueCmd = &cobra.Command{
+	Use:   "merge-queue --base <rev> <head>... [--format text|json]",
+	Short: docText("merge-queue-summary"),
+	Long:  docText("merge-queue"),
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		if ciFrom == "" {
+			return errors.New("requires base revision")
+		}
+
+		if len(args) == 0 {
+			return errors.New("requires the head revisions of the changes")
+		}
+
+		advice, err := system.MergeQueue(ciFrom, args)
+		if err != nil {
+			return err
+		}
+
+		return advice.Write(mergeQueueFormat, os.Stdout)
+	}),
+}
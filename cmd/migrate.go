@@ -0,0 +1,124 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/mbtproject/mbt/lib"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var migrateResume bool
+
+func init() {
+	migrateCmd.Flags().StringVarP(&command, "command", "m", "", "User defined command to execute in the dependents")
+	migrateCmd.Flags().StringVar(&execShell, "shell", "", "Shell used to interpret the command specified with exec (defaults to sh or cmd in windows)")
+	migrateCmd.Flags().BoolVar(&migrateResume, "resume", false, "Skip the dependents migrated in the previous run of the migration")
+	migrateCmd.Flags().StringArrayVarP(&envVars, "env", "e", nil, "Set an environment variable (KEY=VALUE) for the command")
+	migrateCmd.Flags().StringVar(&containerRuntime, "container-runtime", "docker", "Container runtime used to run commands of modules specifying an image")
+	migrateCmd.Flags().BoolVar(&toJSON, "json", false, "Format output as json")
+	RootCmd.AddCommand(migrateCmd)
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate <module> [--command <command> | -- exec <command>]",
+	Short: docText("migrate-summary"),
+	Long:  docText("migrate"),
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		if err := parseExec(cmd, args, false); err != nil {
+			return err
+		}
+
+		args = argsBeforeDash(cmd, args)
+		if len(args) == 0 {
+			return e.NewError(lib.ErrClassUser, "requires the name of the module")
+		}
+
+		if command == "" {
+			return e.NewError(lib.ErrClassUser, "--command (-m) or exec is not specified")
+		}
+
+		options := lib.CmdOptionsWithStdIO(migrateCmdStageCB)
+		options.Exec = execCmd
+		options.ContainerRuntime = containerRuntime
+		options.Env = envVars
+
+		report, err := system.Migrate(&lib.MigrateOptions{Module: args[0], Command: command, Resume: migrateResume}, withLogFormat(options))
+		if report == nil {
+			return err
+		}
+
+		if report.Failed() != nil {
+			resultCode = lib.ExitCodeBuildFailure
+		}
+
+		if oerr := outputMigrationReport(report); oerr != nil {
+			return oerr
+		}
+		return err
+	}),
+}
+
+func migrateCmdStageCB(a *lib.Module, s lib.CmdStage, err error) {
+	switch s {
+	case lib.CmdStageBeforeBuild:
+		logrus.Infof("MIGRATE %s in %s", a.Name(), a.Path())
+	case lib.CmdStageFailedBuild:
+		logrus.Infof("Failed %s in %s: %v", a.Name(), a.Path(), err)
+	}
+}
+
+func outputMigrationReport(report *lib.MigrationReport) error {
+	if toJSON {
+		buff, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(buff))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+	fmt.Fprintf(w, "NAME\tPATH\tSTATUS\tDURATION\n")
+	for _, step := range report.Steps {
+		status := step.Status
+		if step.Resumed {
+			status += " (resumed)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.1fs\n", step.Name, step.Path, status, step.Duration)
+	}
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Migrated: %v Failed: %v Skipped: %v Pending: %v\n",
+		report.Count(lib.MigrationStatusMigrated),
+		report.Count(lib.MigrationStatusFailed),
+		report.Count(lib.MigrationStatusSkipped),
+		report.Count(lib.MigrationStatusPending))
+
+	if failed := report.Failed(); failed != nil {
+		fmt.Printf("Migration stopped at %s: %s\nFix the failure and run again with --resume to continue\n", failed.Name, failed.Error)
+	}
+	return nil
+}
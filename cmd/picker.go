@@ -0,0 +1,194 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/mbtproject/mbt/lib"
+	"github.com/mbtproject/mbt/utils"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// picker is a line based interactive selector of modules.
+// Typing text narrows the list to the modules matching it fuzzily,
+// typing numbers (e.g. 1 3-5) toggles the selection of the listed
+// modules and an empty line confirms the selection.
+type picker struct {
+	in       *bufio.Scanner
+	out      io.Writer
+	names    []string
+	filter   string
+	selected map[string]bool
+}
+
+// pickModules prompts the user to select some of the specified
+// modules and returns their names in the original order.
+func pickModules(in io.Reader, out io.Writer, names []string) ([]string, error) {
+	p := &picker{in: bufio.NewScanner(in), out: out, names: names, selected: make(map[string]bool)}
+	for {
+		listed := p.listed()
+		p.print(listed)
+
+		if !p.in.Scan() {
+			if err := p.in.Err(); err != nil {
+				return nil, err
+			}
+			return nil, e.NewError(lib.ErrClassUser, "module selection was cancelled")
+		}
+
+		input := strings.TrimSpace(p.in.Text())
+		switch {
+		case input == "":
+			if len(p.selected) == 0 {
+				fmt.Fprintln(p.out, "Select at least one module")
+				continue
+			}
+			return p.selection(), nil
+		case input == "q":
+			return nil, e.NewError(lib.ErrClassUser, "module selection was cancelled")
+		case input == "*":
+			for _, n := range listed {
+				p.selected[n] = true
+			}
+		case input == "/":
+			p.filter = ""
+		default:
+			indices, ok := parseIndices(input, len(listed))
+			if !ok {
+				p.filter = input
+				continue
+			}
+
+			for _, i := range indices {
+				n := listed[i]
+				p.selected[n] = !p.selected[n]
+			}
+		}
+	}
+}
+
+func (p *picker) listed() []string {
+	listed := make([]string, 0, len(p.names))
+	for _, n := range p.names {
+		if p.filter == "" || utils.IsSubsequence(n, p.filter, true) {
+			listed = append(listed, n)
+		}
+	}
+	return listed
+}
+
+func (p *picker) print(listed []string) {
+	fmt.Fprintln(p.out)
+	for i, n := range listed {
+		mark := " "
+		if p.selected[n] {
+			mark = "x"
+		}
+		fmt.Fprintf(p.out, "%3d [%s] %s\n", i+1, mark, n)
+	}
+
+	if len(listed) == 0 {
+		fmt.Fprintf(p.out, "No modules match '%s'\n", p.filter)
+	}
+
+	fmt.Fprintf(p.out, "%v of %v modules selected. Type to filter, numbers to toggle, * for all listed, / to clear the filter, q to quit or enter to confirm.\n", len(p.selected), len(p.names))
+	fmt.Fprint(p.out, "> ")
+}
+
+func (p *picker) selection() []string {
+	r := make([]string, 0, len(p.selected))
+	for _, n := range p.names {
+		if p.selected[n] {
+			r = append(r, n)
+		}
+	}
+	return r
+}
+
+// parseIndices parses a list of 1 based numbers and ranges
+// (e.g. "1 3-5,7") into 0 based indices less than max.
+func parseIndices(input string, max int) ([]int, bool) {
+	fields := strings.FieldsFunc(input, func(r rune) bool { return r == ' ' || r == ',' })
+	indices := make([]int, 0, len(fields))
+	for _, f := range fields {
+		first, last := f, f
+		if i := strings.Index(f, "-"); i > 0 {
+			first, last = f[:i], f[i+1:]
+		}
+
+		a, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, false
+		}
+
+		b, err := strconv.Atoi(last)
+		if err != nil {
+			return nil, false
+		}
+
+		if a < 1 || b > max || a > b {
+			return nil, false
+		}
+
+		for i := a; i <= b; i++ {
+			indices = append(indices, i-1)
+		}
+	}
+	return indices, len(indices) > 0
+}
+
+// interactiveFilter prompts for the modules in the workspace to build
+// and returns a filter selecting them.
+func interactiveFilter() (*lib.FilterOptions, error) {
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, e.NewError(lib.ErrClassUser, "--interactive requires a terminal")
+	}
+
+	m, err := system.ManifestByWorkspace()
+	if err != nil {
+		return nil, err
+	}
+
+	m, err = m.ApplyFilters(&lib.FilterOptions{Name: name, Fuzzy: fuzzy, Query: query})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(m.Modules))
+	for _, mod := range m.Modules {
+		names = append(names, mod.Name())
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		return nil, e.NewError(lib.ErrClassUser, "there are no modules to select")
+	}
+
+	selected, err := pickModules(os.Stdin, os.Stderr, names)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lib.FilterOptions{Name: strings.Join(selected, ",")}, nil
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pinVersion string
+	pinReason  string
+)
+
+func init() {
+	pinCmd.Flags().StringVar(&pinVersion, "version", "", "Version to pin the module to (defaults to the version in the current branch)")
+	pinCmd.Flags().StringVar(&pinReason, "reason", "", "Reason for pinning the module")
+	RootCmd.AddCommand(pinCmd)
+	RootCmd.AddCommand(unpinCmd)
+}
+
+var pinCmd = &cobra.Command{
+	Use:   "pin [<module>] [--version <version>] [--reason <reason>]",
+	Short: docText("pin-summary"),
+	Long:  docText("pin"),
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		if len(args) > 0 {
+			_, err := system.Pin(args[0], &lib.PinOptions{Version: pinVersion, Reason: pinReason})
+			if err != nil {
+				return err
+			}
+		}
+
+		lock, err := system.Pins()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+		fmt.Fprintln(w, "NAME\tVERSION\tREASON")
+		for _, p := range lock.Modules {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", p.Name, p.Version, p.Reason)
+		}
+		return w.Flush()
+	}),
+}
+
+var unpinCmd = &cobra.Command{
+	Use:   "unpin <module>",
+	Short: docText("pin-summary"),
+	Long:  docText("pin"),
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return e.NewError(lib.ErrClassUser, "requires the name of the module")
+		}
+		return system.Unpin(args[0])
+	}),
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mbtproject/mbt/lib"
+)
+
+// writePlanArchive writes the plan in the summary to the archive at
+// path.
+func writePlanArchive(summary *lib.BuildSummary, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := summary.WritePlanArchive(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// outputPlan prints the build plan. json format is described by the plan
+// schema in lib/schemas, which is versioned with lib.PlanSchemaVersion.
+func outputPlan(summary *lib.BuildSummary) error {
+	if toJSON {
+		groups := make([][]map[string]interface{}, 0, len(summary.Plan.Groups))
+		for _, g := range summary.Plan.Groups {
+			steps := make([]map[string]interface{}, 0, len(g))
+			for _, step := range g {
+				v := make(map[string]interface{})
+				v["Name"] = step.Module.Name()
+				v["Path"] = step.Module.Path()
+				v["Version"] = step.Module.Version()
+				v["Image"] = step.Module.Image()
+				v["Cmd"] = step.Expanded.Cmd
+				v["Args"] = step.Expanded.Args
+				v["Env"] = step.Env
+				v["Outcome"] = stepOutcome(step)
+				if step.Variant != nil {
+					v["Variant"] = step.Variant.Name
+					v["VariantVersion"] = step.Variant.Version
+				}
+				steps = append(steps, v)
+			}
+			groups = append(groups, steps)
+		}
+
+		skipped := make([]string, 0, len(summary.Skipped))
+		for _, a := range summary.Skipped {
+			skipped = append(skipped, a.Name())
+		}
+
+		buff, err := json.MarshalIndent(map[string]interface{}{
+			"Commit":  summary.Manifest.Sha,
+			"Groups":  groups,
+			"Skipped": skipped,
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(buff))
+		return nil
+	}
+
+	fmt.Printf("Build plan for commit %s\n", summary.Manifest.Sha)
+	for i, g := range summary.Plan.Groups {
+		fmt.Printf("\nGroup %d\n", i+1)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+		fmt.Fprintf(w, "NAME\tVARIANT\tVERSION\tOUTCOME\tCOMMAND\n")
+		for _, step := range g {
+			variant, version := "-", step.Module.Version()
+			if step.Variant != nil {
+				variant, version = step.Variant.Name, step.Variant.Version
+			}
+			command := strings.Join(append([]string{step.Expanded.Cmd}, step.Expanded.Args...), " ")
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", step.Module.Name(), variant, version, stepOutcome(step), command)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		for _, step := range g {
+			id := step.Module.Name()
+			if step.Variant != nil {
+				id = fmt.Sprintf("%s (%s)", id, step.Variant.Name)
+			}
+			fmt.Printf("  %s environment: %s\n", id, strings.Join(step.Env, " "))
+		}
+	}
+
+	for _, a := range summary.Skipped {
+		fmt.Printf("\nSkip %s (no build command for this platform)\n", a.Name())
+	}
+
+	return nil
+}
+
+// stepOutcome is the predicted outcome of a step, "build" if the module
+// is built.
+func stepOutcome(step *lib.BuildStep) string {
+	if step.Outcome == "" {
+		return "build"
+	}
+	return step.Outcome
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	pluginCommand.AddCommand(pluginLockCommand)
+	RootCmd.AddCommand(pluginCommand)
+}
+
+var pluginCommand = &cobra.Command{
+	Use:   "plugin",
+	Short: docText("plugin-summary"),
+	Long:  docText("plugin"),
+}
+
+var pluginLockCommand = &cobra.Command{
+	Use:   "lock",
+	Short: docText("plugin-summary"),
+	Long:  docText("plugin"),
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		lock, err := system.LockPlugins()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+		fmt.Fprintln(w, "NAME\tVERSION\tHOOKS")
+		for _, p := range lock.Plugins {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", p.Name, p.Version, strings.Join(p.Hooks, ","))
+		}
+		return w.Flush()
+	}),
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/mbtproject/mbt/lib"
+	"github.com/sirupsen/logrus"
+)
+
+// Profiling flags available to all commands.
+var (
+	pprofAddr  string
+	cpuProfile string
+	memProfile string
+)
+
+// cpuProfileFile is the file receiving the cpu profile while the
+// command is running.
+var cpuProfileFile *os.File
+
+// startProfiling starts the pprof server and the cpu profile requested
+// with --pprof-addr and --cpuprofile.
+func startProfiling() error {
+	if pprofAddr != "" {
+		l, err := net.Listen("tcp", pprofAddr)
+		if err != nil {
+			return e.Wrapf(lib.ErrClassUser, err, "failed to listen on pprof address %v", pprofAddr)
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+		logrus.Infof("serving pprof on http://%v/debug/pprof/", l.Addr())
+		go func() {
+			if err := http.Serve(l, mux); err != nil {
+				logrus.Warnf("pprof server stopped: %v", err)
+			}
+		}()
+	}
+
+	if cpuProfile != "" {
+		f, err := os.Create(cpuProfile)
+		if err != nil {
+			return e.Wrapf(lib.ErrClassUser, err, "failed to create cpu profile %v", cpuProfile)
+		}
+		if err := rpprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return e.Wrapf(lib.ErrClassInternal, err, "failed to start cpu profile")
+		}
+		cpuProfileFile = f
+	}
+
+	return nil
+}
+
+// stopProfiling stops the cpu profile and writes the heap profile
+// requested with --memprofile.
+// It is called once the command completes, whether it succeeded or not.
+func stopProfiling() error {
+	if cpuProfileFile != nil {
+		rpprof.StopCPUProfile()
+		err := cpuProfileFile.Close()
+		cpuProfileFile = nil
+		if err != nil {
+			return e.Wrapf(lib.ErrClassInternal, err, "failed to write cpu profile %v", cpuProfile)
+		}
+	}
+
+	if memProfile != "" {
+		f, err := os.Create(memProfile)
+		if err != nil {
+			return e.Wrapf(lib.ErrClassUser, err, "failed to create memory profile %v", memProfile)
+		}
+		defer f.Close()
+
+		// Collect garbage to report the memory in use at the end of
+		// the command rather than the garbage accumulated so far.
+		runtime.GC()
+		if err := rpprof.WriteHeapProfile(f); err != nil {
+			return e.Wrapf(lib.ErrClassInternal, err, "failed to write memory profile %v", memProfile)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/mbtproject/mbt/lib"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var prefixColors = []int{32, 33, 34, 35, 36, 92, 93, 94, 95, 96}
+
+// prefixedOutput writes the output of commands with each line prefixed
+// by the name of the module.
+// Partial lines are buffered so that the lines of concurrent builds are
+// not mixed.
+type prefixedOutput struct {
+	mu      sync.Mutex
+	stdout  io.Writer
+	stderr  io.Writer
+	color   bool
+	pending map[string]*bytes.Buffer
+}
+
+// prefixedEvents creates an event handler writing the output of each module
+// prefixed with its name. Prefixes are colored if stdout is a terminal.
+func prefixedEvents(stdout, stderr io.Writer) lib.EventHandler {
+	p := &prefixedOutput{
+		stdout:  stdout,
+		stderr:  stderr,
+		color:   runtime.GOOS != "windows" && terminal.IsTerminal(int(os.Stdout.Fd())),
+		pending: make(map[string]*bytes.Buffer),
+	}
+	return p.handle
+}
+
+func (p *prefixedOutput) handle(event *lib.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch event.Type {
+	case lib.EventOutput:
+		key := event.Module + "/" + event.Stream
+		buff, ok := p.pending[key]
+		if !ok {
+			buff = new(bytes.Buffer)
+			p.pending[key] = buff
+		}
+		buff.WriteString(event.Data)
+		p.flush(event.Module, event.Stream, buff, false)
+	case lib.EventModuleFinish:
+		for _, stream := range []string{"stdout", "stderr"} {
+			key := event.Module + "/" + stream
+			if buff, ok := p.pending[key]; ok {
+				p.flush(event.Module, stream, buff, true)
+				delete(p.pending, key)
+			}
+		}
+	}
+}
+
+// flush writes the complete lines in the buffer. Remaining partial line
+// is written as well if all is true.
+func (p *prefixedOutput) flush(module, stream string, buff *bytes.Buffer, all bool) {
+	w := p.stdout
+	if stream == "stderr" {
+		w = p.stderr
+	}
+
+	for {
+		line, err := buff.ReadBytes('\n')
+		if err != nil {
+			// Incomplete line is retained until the rest of it is written.
+			if len(line) > 0 {
+				if all {
+					fmt.Fprintf(w, "%s%s\n", p.prefix(module), line)
+				} else {
+					buff.Write(line)
+				}
+			}
+			return
+		}
+		fmt.Fprintf(w, "%s%s", p.prefix(module), line)
+	}
+}
+
+func (p *prefixedOutput) prefix(module string) string {
+	if !p.color {
+		return module + " | "
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(module))
+	return fmt.Sprintf("\x1b[%dm%s |\x1b[0m ", prefixColors[h.Sum32()%uint32(len(prefixColors))], module)
+}
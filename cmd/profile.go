@@ -0,0 +1,136 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mbtproject/mbt/lib"
+)
+
+// traceEvent is an event in chrome trace event format.
+// See https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU
+type traceEvent struct {
+	Name      string                 `json:"name"`
+	Category  string                 `json:"cat"`
+	Phase     string                 `json:"ph"`
+	Timestamp int64                  `json:"ts"`
+	Duration  int64                  `json:"dur"`
+	Pid       int                    `json:"pid"`
+	Tid       int                    `json:"tid"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+}
+
+// writeProfile writes the timings of a build in chrome trace event
+// format and prints a summary.
+// Concurrent builds are placed in separate lanes (threads in the trace
+// viewer).
+func writeProfile(path string, summary *lib.BuildSummary) error {
+	if len(summary.Timings) == 0 {
+		return nil
+	}
+
+	origin := summary.Timings[0].Queued
+	lanes := make([]time.Time, 0)
+	events := make([]*traceEvent, 0, len(summary.Timings)*2)
+	for _, t := range summary.Timings {
+		lane := -1
+		for i, free := range lanes {
+			if !free.After(t.Started) {
+				lane = i
+				break
+			}
+		}
+		if lane < 0 {
+			lane = len(lanes)
+			lanes = append(lanes, time.Time{})
+		}
+		lanes[lane] = t.Finished
+
+		args := map[string]interface{}{
+			"version": t.Module.Version(),
+			"path":    t.Module.Path(),
+			"waitUs":  micros(t.Started.Sub(t.Ready)),
+		}
+		events = append(events, &traceEvent{
+			Name:      t.Module.Name(),
+			Category:  "build",
+			Phase:     "X",
+			Timestamp: micros(t.Started.Sub(origin)),
+			Duration:  micros(t.Finished.Sub(t.Started)),
+			Pid:       1,
+			Tid:       lane + 1,
+			Args:      args,
+		})
+	}
+
+	buff, err := json.MarshalIndent(map[string]interface{}{
+		"traceEvents":     events,
+		"displayTimeUnit": "ms",
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(path, buff, 0644)
+	if err != nil {
+		return err
+	}
+
+	return printProfile(summary)
+}
+
+func printProfile(summary *lib.BuildSummary) error {
+	var start, end time.Time
+	var busy time.Duration
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+	fmt.Fprintf(w, "NAME\tSTART\tWAIT\tDURATION\n")
+	for _, t := range summary.Timings {
+		if start.IsZero() {
+			start = t.Queued
+		}
+		if t.Finished.After(end) {
+			end = t.Finished
+		}
+		d := t.Finished.Sub(t.Started)
+		busy += d
+		fmt.Fprintf(w, "%s\t%v\t%v\t%v\n", t.Module.Name(), round(t.Started.Sub(start)), round(t.Started.Sub(t.Ready)), round(d))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	wall := end.Sub(start)
+	parallelism := 0.0
+	if wall > 0 {
+		parallelism = float64(busy) / float64(wall)
+	}
+	fmt.Printf("Total: %v Build time: %v Parallelism: %.2f\n", round(wall), round(busy), parallelism)
+	return nil
+}
+
+func micros(d time.Duration) int64 {
+	return int64(d / time.Microsecond)
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Millisecond)
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/mbtproject/mbt/lib"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	progressAuto  = "auto"
+	progressTTY   = "tty"
+	progressPlain = "plain"
+
+	progressInterval = 100 * time.Millisecond
+)
+
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// progress renders a live display of the modules being built.
+// Output of a module is buffered and printed only if it fails.
+type progress struct {
+	mu      sync.Mutex
+	out     io.Writer
+	running []*progressModule
+	queued  int
+	done    int
+	failed  int
+	// hashed and hashTotal are the progress of hashing files whilst
+	// discovering modules.
+	hashed    int
+	hashTotal int
+	lines     int
+	frame     int
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+type progressModule struct {
+	name    string
+	started time.Time
+	output  bytes.Buffer
+}
+
+// activeProgress is the progress display of the current build if any.
+var activeProgress *progress
+
+func validateProgressMode() error {
+	switch progressMode {
+	case progressAuto, progressTTY, progressPlain:
+		return nil
+	default:
+		return e.NewErrorf(lib.ErrClassUser, "unsupported progress mode '%s'", progressMode)
+	}
+}
+
+func useProgress() bool {
+	if logFormat != logFormatText || plan || quiet {
+		return false
+	}
+
+	switch progressMode {
+	case progressAuto:
+		// Escape sequences used by the display are not enabled in windows
+		// consoles by default.
+		return !debug && runtime.GOOS != "windows" &&
+			terminal.IsTerminal(int(os.Stdout.Fd())) &&
+			terminal.IsTerminal(int(os.Stderr.Fd()))
+	case progressTTY:
+		return true
+	default:
+		return false
+	}
+}
+
+// startProgress starts the progress display for a build if it is
+// enabled.
+func startProgress(options *lib.CmdOptions) *lib.CmdOptions {
+	if !useProgress() {
+		return options
+	}
+
+	p := &progress{
+		out:     os.Stdout,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	// Logs are printed above the live display.
+	logrus.SetOutput(p)
+	logrus.SetFormatter(&logrus.TextFormatter{ForceColors: true})
+
+	options.Callback = func(*lib.Module, lib.CmdStage, error) {}
+	options.Events = p.handle
+	activeProgress = p
+	go p.run()
+	return options
+}
+
+// stopProgress stops the progress display if one is active.
+func stopProgress() {
+	p := activeProgress
+	if p == nil {
+		return
+	}
+
+	activeProgress = nil
+	close(p.stop)
+	<-p.stopped
+
+	p.mu.Lock()
+	p.clear()
+	p.mu.Unlock()
+
+	logrus.SetOutput(os.Stderr)
+	logrus.SetFormatter(&logrus.TextFormatter{})
+}
+
+func (p *progress) run() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			p.frame++
+			p.redraw()
+			p.mu.Unlock()
+		}
+	}
+}
+
+func (p *progress) handle(event *lib.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch event.Type {
+	case lib.EventModuleQueue:
+		p.queued++
+	case lib.EventModuleSkip:
+		p.queued--
+	case lib.EventModuleStart:
+		p.queued--
+		p.running = append(p.running, &progressModule{name: event.Module, started: event.Time})
+	case lib.EventOutput:
+		if m := p.find(event.Module); m != nil {
+			m.output.WriteString(event.Data)
+		}
+	case lib.EventModuleFinish:
+		m := p.find(event.Module)
+		if m == nil {
+			return
+		}
+		p.remove(m)
+		p.clear()
+		elapsed := time.Duration(event.Elapsed * float64(time.Second)).Round(time.Millisecond)
+		if event.Error == "" {
+			p.done++
+			fmt.Fprintf(p.out, "\x1b[32m✔\x1b[0m %s (%v)\n", m.name, elapsed)
+		} else {
+			p.failed++
+			fmt.Fprintf(p.out, "\x1b[31m✖\x1b[0m %s (%v): %s\n", m.name, elapsed, event.Error)
+			p.out.Write(m.output.Bytes())
+		}
+		p.draw()
+	}
+}
+
+// hashing records the progress of hashing files. Progress is reported
+// concurrently, therefore it may arrive out of order.
+func (p *progress) hashing(hashed, total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if total != p.hashTotal {
+		p.hashTotal = total
+		p.hashed = hashed
+	} else if hashed > p.hashed {
+		p.hashed = hashed
+	}
+}
+
+// reportHashProgress forwards the progress of hashing files to the
+// progress display if one is active.
+func reportHashProgress(hashed, total int) {
+	if p := activeProgress; p != nil {
+		p.hashing(hashed, total)
+	}
+}
+
+// Write prints the logs above the live display.
+func (p *progress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	n, err := p.out.Write(b)
+	p.draw()
+	return n, err
+}
+
+func (p *progress) find(name string) *progressModule {
+	for _, m := range p.running {
+		if m.name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+func (p *progress) remove(m *progressModule) {
+	for i, r := range p.running {
+		if r == m {
+			p.running = append(p.running[:i], p.running[i+1:]...)
+			return
+		}
+	}
+}
+
+// clear erases the live display.
+func (p *progress) clear() {
+	for ; p.lines > 0; p.lines-- {
+		fmt.Fprint(p.out, "\x1b[1A\x1b[2K")
+	}
+}
+
+// draw prints the live display.
+func (p *progress) draw() {
+	spinner := spinnerFrames[p.frame%len(spinnerFrames)]
+	for _, m := range p.running {
+		fmt.Fprintf(p.out, "%s %s %v\n", spinner, m.name, time.Since(m.started).Round(time.Second/10))
+	}
+	fmt.Fprintf(p.out, "Built: %v Failed: %v Running: %v Queued: %v\n", p.done, p.failed, len(p.running), p.queued)
+	p.lines = len(p.running) + 1
+	if p.hashed < p.hashTotal {
+		fmt.Fprintf(p.out, "%s Hashing files: %v/%v\n", spinner, p.hashed, p.hashTotal)
+		p.lines++
+	}
+}
+
+func (p *progress) redraw() {
+	p.clear()
+	p.draw()
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/sirupsen/logrus"
+)
+
+// quietOutput buffers the output of each module and writes it only if
+// the module fails.
+type quietOutput struct {
+	mu      sync.Mutex
+	w       io.Writer
+	pending map[string]*bytes.Buffer
+}
+
+// quietEvents creates an event handler writing the output of failed
+// modules to w.
+func quietEvents(w io.Writer) lib.EventHandler {
+	q := &quietOutput{w: w, pending: make(map[string]*bytes.Buffer)}
+	return q.handle
+}
+
+func (q *quietOutput) handle(event *lib.Event) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	switch event.Type {
+	case lib.EventOutput:
+		buff, ok := q.pending[event.Module]
+		if !ok {
+			buff = new(bytes.Buffer)
+			q.pending[event.Module] = buff
+		}
+		buff.WriteString(event.Data)
+	case lib.EventModuleFinish:
+		buff := q.pending[event.Module]
+		delete(q.pending, event.Module)
+		if event.Error == "" {
+			return
+		}
+
+		fmt.Fprintf(q.w, "--- FAIL %s (%.2fs)\n", event.Module, event.Elapsed)
+		if buff != nil {
+			buff.WriteTo(q.w)
+			if buff.Len() > 0 && buff.Bytes()[buff.Len()-1] != '\n' {
+				fmt.Fprintln(q.w)
+			}
+		}
+	}
+}
+
+// useQuiet returns true if --quiet is applicable to the log format.
+func useQuiet() bool {
+	return quiet && logFormat == logFormatText
+}
+
+// startQuiet suppresses the informational logs until the end of the
+// command.
+func startQuiet() {
+	if useQuiet() && !debug {
+		logrus.SetLevel(logrus.WarnLevel)
+	}
+}
+
+// stopQuiet restores the logs so that the summary is printed.
+func stopQuiet() {
+	if useQuiet() && !debug {
+		logrus.SetLevel(logrus.InfoLevel)
+	}
+}
+
+// withQuietOutput configures options to write only the output of the
+// failed modules.
+func withQuietOutput(options *lib.CmdOptions) *lib.CmdOptions {
+	options.Callback = func(*lib.Module, lib.CmdStage, error) {}
+	options.Events = quietEvents(os.Stderr)
+	return options
+}
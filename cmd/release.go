@@ -0,0 +1,71 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	planFile         string
+	releasePlan      *lib.ReleasePlan
+	releaseTagPrefix string
+	releaseVersion   string
+	releaseFormat    string
+)
+
+func init() {
+	releasePlanCmd.Flags().StringVar(&from, "from", "", "Tag of the previous release (defaults to the tag with the highest version)")
+	releasePlanCmd.Flags().StringVar(&ciTo, "to", "HEAD", "Revision (branch, tag or commit) to release")
+	releasePlanCmd.Flags().StringVar(&releaseTagPrefix, "tag-prefix", "v", "Prefix of the versions in release tags")
+	releasePlanCmd.Flags().StringVar(&releaseVersion, "version", "", "Version of the release (derived from the changes by default)")
+	releasePlanCmd.Flags().StringVar(&purpose, "select", lib.AffectedDeploys, "Purpose of the modules released (builds, tests, deploys or a purpose in the repository configuration)")
+	releasePlanCmd.Flags().StringVar(&releaseFormat, "format", lib.ReleaseFormatJSON, "Output format (json or markdown)")
+	releasePlanCmd.Flags().StringVar(&out, "out", "", "Write the plan to this file instead of stdout")
+	releaseCmd.AddCommand(releasePlanCmd)
+	RootCmd.AddCommand(releaseCmd)
+}
+
+var releaseCmd = &cobra.Command{
+	Use:   "release",
+	Short: docText("release-summary"),
+	Long:  docText("release"),
+}
+
+var releasePlanCmd = &cobra.Command{
+	Use: "plan [--from <tag>] [--to <rev>] [--version <version>] [--format json|markdown] [--out <file>]",
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		plan, err := system.ReleasePlan(&lib.ReleaseOptions{
+			From:      from,
+			To:        ciTo,
+			TagPrefix: releaseTagPrefix,
+			Version:   releaseVersion,
+			Select:    purpose,
+		})
+		if err != nil {
+			return err
+		}
+
+		output, err := getOutput(out)
+		if err != nil {
+			return err
+		}
+		defer output.Close()
+
+		return plan.Write(releaseFormat, output)
+	}),
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reportFormat string
+	reportWindow string
+)
+
+func init() {
+	reportCmd.Flags().StringVar(&reportFormat, "format", lib.HealthReportFormatJSON, "Output format (json or html)")
+	reportCmd.Flags().StringVar(&reportWindow, "window", "30d", "Period before the last commit used to compute the version churn (e.g. 30d or 72h)")
+	reportCmd.Flags().StringVar(&out, "out", "", "Write the report to this file instead of stdout")
+	RootCmd.AddCommand(reportCmd)
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report [--format json|html] [--window <period>] [--out <file>]",
+	Short: docText("report-summary"),
+	Long:  docText("report"),
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		window, err := parseWindow(reportWindow)
+		if err != nil {
+			return err
+		}
+
+		report, err := system.HealthReport(&lib.HealthReportOptions{Window: window})
+		if err != nil {
+			return err
+		}
+
+		var w io.Writer = os.Stdout
+		if out != "" {
+			f, err := os.Create(out)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+
+		return report.Write(reportFormat, w)
+	}),
+}
+
+// parseWindow parses a duration with support for days (e.g. 30d) in
+// addition to the units supported by time.ParseDuration.
+func parseWindow(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err == nil && days > 0 {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid window '%s'", s)
+	}
+	return d, nil
+}
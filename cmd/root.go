@@ -16,6 +16,7 @@ limitations under the License.
 package cmd
 
 import (
+	"errors"
 	"os"
 
 	"github.com/mbtproject/mbt/e"
@@ -26,27 +27,70 @@ import (
 
 // Flags available to all commands.
 var (
-	in       string
-	src      string
-	dst      string
-	from     string
-	to       string
-	first    string
-	second   string
-	kind     string
-	name     string
-	command  string
-	all      bool
-	debug    bool
-	content  bool
-	fuzzy    bool
-	failFast bool
-	system   lib.System
+	in            string
+	src           string
+	dst           string
+	from          string
+	to            string
+	first         string
+	second        string
+	kind          string
+	name          string
+	command       string
+	all           bool
+	debug         bool
+	content       bool
+	fuzzy         bool
+	atCommit      string
+	query         string
+	failFast      bool
+	logFormat     string
+	logDir        string
+	summaryFile   string
+	prefixOutput  bool
+	quiet         bool
+	manifestFile  string
+	configProfile string
+	gitBackend    string
+	noCache       bool
+	eventsURLs    []string
+	eventsFd      int
+	system        lib.System
 )
 
+// branchArg returns the branch specified in args of a branch command.
+// An empty string selects the default branch. --commit can be used
+// instead of a branch in checkouts without one (e.g. a detached head
+// in CI).
+func branchArg(args []string) (string, error) {
+	if len(args) > 0 {
+		if atCommit != "" {
+			return "", errors.New("specify either a branch or --commit")
+		}
+		return args[0], nil
+	}
+
+	return "", nil
+}
+
 func init() {
-	RootCmd.PersistentFlags().StringVar(&in, "in", "", "Path to repo")
+	RootCmd.PersistentFlags().StringVar(&in, "in", "", "Path to repo or url of a remote repository (fetched into the cache directory)")
 	RootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Enable debug output")
+	RootCmd.PersistentFlags().StringVar(&gitBackend, "git-backend", "", "Backend used to read the repository (libgit2, git, go-git, hg or sapling). Defaults to $MBT_GIT_BACKEND or the backend detected from the repository")
+	RootCmd.PersistentFlags().BoolVar(&noCache, "no-cache", false, "Discover the modules without reading or writing the discovery cache")
+	RootCmd.PersistentFlags().StringVar(&summaryFile, "summary-file", "", "Write a json summary of the command (including the exit code) to this file")
+	RootCmd.PersistentFlags().StringVar(&logDir, "log-dir", "", "Write the output of each module to a file in this directory")
+	RootCmd.PersistentFlags().BoolVar(&prefixOutput, "prefix", false, "Prefix each line of output with the module name (default when building modules concurrently)")
+	RootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Print only the output of failed modules and the summary")
+	RootCmd.PersistentFlags().StringVar(&manifestFile, "manifest", "", "Use the manifest exported with describe export instead of discovering the modules in the repository")
+	RootCmd.PersistentFlags().StringVar(&configProfile, "config-profile", "", "Use the defaults of flags in this profile of the repository or user configuration (defaults to $MBT_CONFIG_PROFILE)")
+	RootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logFormatText, "Log format (text or json)")
+	RootCmd.PersistentFlags().StringArrayVar(&eventsURLs, "events-url", nil, "Post the lifecycle events of builds and runs to this url as newline delimited json")
+	RootCmd.PersistentFlags().IntVar(&eventsFd, "events-fd", 0, "Write the lifecycle events of builds and runs to this file descriptor as newline delimited json")
+	RootCmd.PersistentFlags().StringVar(&diagnosticsFormat, "diagnostics", lib.DiagnosticsFormatText, "Format of the diagnostics of errors written to stderr (text or json)")
+	RootCmd.PersistentFlags().StringVar(&pprofAddr, "pprof-addr", "", "Serve the pprof endpoints of mbt on this address (e.g. localhost:6060) while the command is running")
+	RootCmd.PersistentFlags().StringVar(&cpuProfile, "cpuprofile", "", "Write the cpu profile of the command to this file")
+	RootCmd.PersistentFlags().StringVar(&memProfile, "memprofile", "", "Write the memory profile at the end of the command to this file")
 }
 
 // RootCmd is the main command.
@@ -55,28 +99,89 @@ var RootCmd = &cobra.Command{
 	Short:        docText("main-summary"),
 	Long:         docText("main"),
 	SilenceUsage: true,
+	// Errors are reported as diagnostics by Execute.
+	SilenceErrors: true,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-		if cmd.Use == "version" {
+		if err := lib.ValidateDiagnosticsFormat(diagnosticsFormat); err != nil {
+			return err
+		}
+
+		if err := startProfiling(); err != nil {
+			return err
+		}
+
+		// Completion commands do not require a repository or report
+		// errors on their own. doctor reports the issues of the
+		// repository instead of failing. Schemas are embedded in the
+		// binary.
+		if cmd.Use == "version" || cmd.Name() == "completion" || cmd.Name() == "__complete" || cmd.Name() == "doctor" || cmd.Name() == "schema" {
+			return nil
+		}
+
+		// execute runs a plan in a checkout without opening the
+		// repository.
+		if cmd.Name() == "execute" {
+			if debug {
+				logrus.SetLevel(logrus.DebugLevel)
+			}
+			handleInterrupts()
+			return setupLogFormat()
+		}
+
+		// Workspace commands open the repositories in the workspace file
+		// instead of the repository containing the current directory.
+		if parent := cmd.Parent(); parent != nil && parent.Name() == "workspace" {
+			if debug {
+				logrus.SetLevel(logrus.DebugLevel)
+			}
 			return nil
 		}
 
-		if in == "" {
-			cwd, err := os.Getwd()
-			if err != nil {
+		var err error
+		in, err = repoPath()
+		if err != nil {
+			return err
+		}
+
+		if err := applyFlagDefaults(cmd); err != nil {
+			return err
+		}
+
+		parent := cmd.Parent()
+		if parent != nil && (parent.Name() == "run-in" || parent.Name() == "run") {
+			if err := parseExec(cmd, args, parent.Name() == "run"); err != nil {
 				return err
 			}
-			in, err = lib.GitRepoRoot(cwd)
-			if err != nil {
+			if command == "" {
+				return e.NewError(lib.ErrClassUser, "--command (-m) or exec is not specified")
+			}
+		}
+		if err := resolveScope(in); err != nil {
+			return err
+		}
+		if err := resolveLabels(); err != nil {
+			return err
+		}
+		if parent != nil && parent.Name() == "describe" && dependents && name == "" && scope == "" && label == "" {
+			return e.NewError(lib.ErrClassUser, "--dependents flag can only be specified with the --name (-n), --scope or --label flag")
+		}
+
+		if err := removeStaleSummary(); err != nil {
+			return err
+		}
+
+		if planFile != "" {
+			if releasePlan, err = lib.ReadReleasePlan(planFile); err != nil {
 				return err
 			}
 		}
 
-		parent := cmd.Parent()
-		if parent != nil && parent.Name() == "run-in" && command == "" {
-			return e.NewError(lib.ErrClassUser, "--command (-m) is not specified")
+		if err := setupLogFormat(); err != nil {
+			return err
 		}
-		if parent != nil && parent.Name() == "describe" && dependents && name == "" {
-			return e.NewError(lib.ErrClassUser, "--dependents flag can only be specified with the --name (-n) flag")
+
+		if err := validateProgressMode(); err != nil {
+			return err
 		}
 
 		level := lib.LogLevelNormal
@@ -84,9 +189,47 @@ var RootCmd = &cobra.Command{
 			logrus.SetLevel(logrus.DebugLevel)
 			level = lib.LogLevelDebug
 		}
+		startQuiet()
 
-		var err error
-		system, err = lib.NewSystem(in, level)
-		return err
+		handleInterrupts()
+		ctx := lib.WithRepoBackend(lib.WithHashProgress(interrupt, reportHashProgress), gitBackend)
+		if noCache {
+			ctx = lib.WithoutDiscoveryCache(ctx)
+		}
+		system, err = lib.NewSystemWithContext(ctx, in, level)
+		if err != nil {
+			return err
+		}
+
+		return importManifest()
 	},
 }
+
+// repoPath returns the path to the repository specified with --in or
+// the repository containing the current directory.
+func repoPath() (string, error) {
+	if in != "" {
+		return in, nil
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return lib.GitRepoRoot(cwd)
+}
+
+// importManifest imports the manifest specified with --manifest.
+func importManifest() error {
+	if manifestFile == "" {
+		return nil
+	}
+
+	f, err := os.Open(manifestFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return system.ImportManifest(f)
+}
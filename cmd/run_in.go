@@ -17,17 +17,30 @@ package cmd
 
 import (
 	"errors"
+	"runtime"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/mbtproject/mbt/e"
 	"github.com/mbtproject/mbt/lib"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	parallel  bool
+	execShell string
+	execCmd   *lib.UserCmd
 )
 
 func init() {
 	runIn.PersistentFlags().StringVarP(&command, "command", "m", "", "Command to execute")
+	runIn.PersistentFlags().IntVarP(&jobs, "jobs", "j", 1, "Maximum number of modules to run the command in concurrently")
+	runIn.PersistentFlags().BoolVar(&parallel, "parallel", false, "Run the command without waiting for the dependencies of modules (defaults --jobs to the number of cores)")
+	runIn.PersistentFlags().StringVar(&execShell, "shell", "", "Shell used to interpret the command specified with exec (defaults to sh or cmd in windows)")
 	runIn.PersistentFlags().BoolVarP(&failFast, "fail-fast", "", false, "Fail fast on command failure")
+	runIn.PersistentFlags().StringArrayVarP(&envVars, "env", "e", nil, "Set an environment variable (KEY=VALUE) for the command")
+	runIn.PersistentFlags().StringVar(&containerRuntime, "container-runtime", "docker", "Container runtime used to run commands of modules specifying an image")
 
 	runInPr.Flags().StringVar(&src, "src", "", "Source branch")
 	runInPr.Flags().StringVar(&dst, "dst", "", "Destination branch")
@@ -38,16 +51,25 @@ func init() {
 	runInLocal.Flags().BoolVarP(&all, "all", "a", false, "All modules")
 	runInLocal.Flags().StringVarP(&name, "name", "n", "", "Build modules with a name that matches this value. Multiple names can be specified as a comma separated string.")
 	runInLocal.Flags().BoolVarP(&fuzzy, "fuzzy", "f", false, "Use fuzzy match when filtering")
+	runInLocal.Flags().StringVar(&query, "query", "", "Select modules matching this expression (e.g. 'name =~ \"^svc-\" && \"backend\" in tags')")
+	addScopeFlags(runInLocal.Flags(), true)
 
 	runInCommit.Flags().BoolVarP(&content, "content", "c", false, "Build the modules impacted by the content of the commit")
 	runInCommit.Flags().StringVarP(&name, "name", "n", "", "Build modules with a name that matches this value. Multiple names can be specified as a comma separated string.")
 	runInCommit.Flags().BoolVarP(&fuzzy, "fuzzy", "f", false, "Use fuzzy match when filtering")
+	runInCommit.Flags().StringVar(&query, "query", "", "Select modules matching this expression (e.g. 'name =~ \"^svc-\" && \"backend\" in tags')")
+	addScopeFlags(runInCommit.Flags(), true)
 
 	runInBranch.Flags().StringVarP(&name, "name", "n", "", "Build modules with a name that matches this value. Multiple names can be specified as a comma separated string.")
 	runInBranch.Flags().BoolVarP(&fuzzy, "fuzzy", "f", false, "Use fuzzy match when filtering")
+	runInBranch.Flags().StringVar(&atCommit, "commit", "", "Use this commit instead of a branch (e.g. in a detached head checkout)")
+	runInBranch.Flags().StringVar(&query, "query", "", "Select modules matching this expression (e.g. 'name =~ \"^svc-\" && \"backend\" in tags')")
+	addScopeFlags(runInBranch.Flags(), true)
 
 	runInHead.Flags().StringVarP(&name, "name", "n", "", "Build modules with a name that matches this value. Multiple names can be specified as a comma separated string.")
 	runInHead.Flags().BoolVarP(&fuzzy, "fuzzy", "f", false, "Use fuzzy match when filtering")
+	runInHead.Flags().StringVar(&query, "query", "", "Select modules matching this expression (e.g. 'name =~ \"^svc-\" && \"backend\" in tags')")
+	addScopeFlags(runInHead.Flags(), true)
 
 	runIn.AddCommand(runInBranch)
 	runIn.AddCommand(runInPr)
@@ -56,24 +78,49 @@ func init() {
 	runIn.AddCommand(runInCommit)
 	runIn.AddCommand(runInLocal)
 	RootCmd.AddCommand(runIn)
+
+	// run has the same selectors and options as run-in, with the
+	// command after -- instead of --command.
+	runIn.PersistentFlags().VisitAll(func(f *pflag.Flag) {
+		if f.Name != "command" {
+			runCmd.PersistentFlags().AddFlag(f)
+		}
+	})
+	for _, c := range runIn.Commands() {
+		r := &cobra.Command{
+			Use:         c.Use + " -- <command> [<args>...]",
+			Short:       docText("run-summary"),
+			Long:        docText("run"),
+			RunE:        c.RunE,
+			Annotations: c.Annotations,
+		}
+		r.Flags().AddFlagSet(c.Flags())
+		runCmd.AddCommand(r)
+	}
+	RootCmd.AddCommand(runCmd)
 }
 
 var runInHead = &cobra.Command{
 	Use: "head",
 	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
-		return summariseRun(system.RunInCurrentBranch(command, &lib.FilterOptions{Name: name, Fuzzy: fuzzy}, runInCmdOptions()))
+		return summariseRun(system.RunInCurrentBranch(command, filterOptions(), runInCmdOptions()))
 	}),
 }
 
 var runInBranch = &cobra.Command{
 	Use: "branch <branch>",
 	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
-		branch := "master"
-		if len(args) > 0 {
-			branch = args[0]
+		args = argsBeforeDash(cmd, args)
+		branch, err := branchArg(args)
+		if err != nil {
+			return err
+		}
+
+		if atCommit != "" {
+			return summariseRun(system.RunInCommit(command, atCommit, filterOptions(), runInCmdOptions()))
 		}
 
-		return summariseRun(system.RunInBranch(command, branch, &lib.FilterOptions{Name: name, Fuzzy: fuzzy}, runInCmdOptions()))
+		return summariseRun(system.RunInBranch(command, branch, filterOptions(), runInCmdOptions()))
 	}),
 }
 
@@ -110,6 +157,7 @@ var runInDiff = &cobra.Command{
 var runInCommit = &cobra.Command{
 	Use: "commit <sha>",
 	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		args = argsBeforeDash(cmd, args)
 		if len(args) == 0 {
 			return errors.New("requires the commit sha")
 		}
@@ -119,15 +167,15 @@ var runInCommit = &cobra.Command{
 		if content {
 			return summariseRun(system.RunInCommitContent(command, commit, runInCmdOptions()))
 		}
-		return summariseRun(system.RunInCommit(command, commit, &lib.FilterOptions{Name: name, Fuzzy: fuzzy}, runInCmdOptions()))
+		return summariseRun(system.RunInCommit(command, commit, filterOptions(), runInCmdOptions()))
 	}),
 }
 
 var runInLocal = &cobra.Command{
 	Use: "local [--all]",
 	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
-		if all || name != "" {
-			return summariseRun(system.RunInWorkspace(command, &lib.FilterOptions{Name: name, Fuzzy: fuzzy}, runInCmdOptions()))
+		if all || name != "" || query != "" || scope != "" || label != "" {
+			return summariseRun(system.RunInWorkspace(command, filterOptions(), runInCmdOptions()))
 		}
 
 		return summariseRun(system.RunInWorkspaceChanges(command, runInCmdOptions()))
@@ -146,6 +194,7 @@ func runCmdStageCB(a *lib.Module, s lib.CmdStage, err error) {
 }
 
 func summariseRun(summary *lib.RunResult, err error) error {
+	stopQuiet()
 	if err == nil {
 		logrus.Infof("Modules: %v Success: %v Failed: %v Skipped: %v",
 			len(summary.Manifest.Modules),
@@ -156,16 +205,81 @@ func summariseRun(summary *lib.RunResult, err error) error {
 		logrus.Infof("Build finished for commit %v", summary.Manifest.Sha)
 
 		if len(summary.Failures) > 0 && failFast {
-			return e.NewError(lib.ErrClassUser, "One or more commands failed to run")
+			return e.NewError(lib.ErrClassUser, "One or more commands failed to run").WithCode(lib.ExitCodeBuildFailure)
+		}
+
+		switch {
+		case len(summary.Failures) > 0:
+			resultCode = lib.ExitCodeBuildFailure
+		case len(summary.Manifest.Modules) == 0:
+			resultCode = lib.ExitCodeNothingToBuild
 		}
 	}
 	return err
 }
 
+// parseExec parses the command specified after -- (e.g. -- exec 'npm test').
+// A single argument is interpreted by the shell, otherwise the first
+// argument is executed with the remaining arguments.
+// exec is implied for run, which requires the command.
+func parseExec(cmd *cobra.Command, args []string, implied bool) error {
+	dash := cmd.ArgsLenAtDash()
+	if dash < 0 {
+		if implied {
+			return e.NewError(lib.ErrClassUser, "expected <command> after --")
+		}
+		return nil
+	}
+
+	words := args[dash:]
+	if implied {
+		if len(words) == 0 {
+			return e.NewError(lib.ErrClassUser, "expected <command> after --")
+		}
+		words = append([]string{"exec"}, words...)
+	}
+	if len(words) < 2 || words[0] != "exec" {
+		return e.NewError(lib.ErrClassUser, "expected exec <command> after --")
+	}
+
+	if command != "" {
+		return e.NewError(lib.ErrClassUser, "--command (-m) cannot be used with exec")
+	}
+
+	if len(words) == 2 {
+		shell := execShell
+		if shell == "" {
+			shell = "sh"
+			if runtime.GOOS == "windows" {
+				shell = "cmd"
+			}
+		}
+		execCmd = &lib.UserCmd{Cmd: words[1], Shell: shell}
+	} else {
+		execCmd = &lib.UserCmd{Cmd: words[1], Args: words[2:], Shell: execShell}
+	}
+
+	command = "exec"
+	return nil
+}
+
+// argsBeforeDash returns the arguments specified before --.
+func argsBeforeDash(cmd *cobra.Command, args []string) []string {
+	if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+		return args[:dash]
+	}
+	return args
+}
+
 func runInCmdOptions() *lib.CmdOptions {
 	options := lib.CmdOptionsWithStdIO(runCmdStageCB)
+	options.Exec = execCmd
+	options.Jobs = jobs
+	options.Parallel = parallel
 	options.FailFast = failFast
-	return options
+	options.ContainerRuntime = containerRuntime
+	options.Env = envVars
+	return withLogFormat(options)
 }
 
 var runIn = &cobra.Command{
@@ -173,3 +287,9 @@ var runIn = &cobra.Command{
 	Short: docText("run-in-summary"),
 	Long:  docText("run-in"),
 }
+
+var runCmd = &cobra.Command{
+	Use:   "run",
+	Short: docText("run-summary"),
+	Long:  docText("run"),
+}
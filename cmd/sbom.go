@@ -0,0 +1,93 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sbomFormat string
+	local      bool
+)
+
+func init() {
+	sbomCmd.Flags().StringVar(&sbomFormat, "format", lib.SBOMFormatSPDX, "SBOM format (spdx or cyclonedx)")
+	sbomCmd.Flags().StringVar(&outDir, "out-dir", "", "Write the SBOM of each module to a file in this directory instead of stdout")
+	sbomCmd.Flags().StringVar(&exportBranch, "branch", "", "Generate the SBOMs of the modules in this branch")
+	sbomCmd.Flags().StringVar(&exportCommit, "commit", "", "Generate the SBOMs of the modules in this commit")
+	sbomCmd.Flags().StringVar(&src, "src", "", "Generate the SBOMs of the modules changed in this branch (with --dst)")
+	sbomCmd.Flags().StringVar(&dst, "dst", "", "Generate the SBOMs of the modules changed in --src since it diverged from this branch")
+	sbomCmd.Flags().StringVar(&from, "from", "", "Generate the SBOMs of the modules changed between this commit and --to")
+	sbomCmd.Flags().StringVar(&to, "to", "", "Generate the SBOMs of the modules changed between --from and this commit")
+	sbomCmd.Flags().BoolVar(&local, "local", false, "Generate the SBOMs of the modules in the workspace")
+	sbomCmd.Flags().StringVarP(&name, "name", "n", "", "Generate the SBOMs of modules with a name that matches this value. Multiple names can be specified as a comma separated string.")
+	sbomCmd.Flags().BoolVarP(&fuzzy, "fuzzy", "f", false, "Use fuzzy match when filtering")
+	sbomCmd.Flags().StringVar(&query, "query", "", "Select modules matching this expression (e.g. 'name =~ \"^svc-\" && \"backend\" in tags')")
+	RootCmd.AddCommand(sbomCmd)
+}
+
+var sbomCmd = &cobra.Command{
+	Use:   "sbom [--format spdx|cyclonedx] [--out-dir <dir>] [--branch <branch> | --commit <sha> | --src <branch> --dst <branch> | --from <commit> --to <commit> | --local]",
+	Short: docText("sbom-summary"),
+	Long:  docText("sbom"),
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		var (
+			m   *lib.Manifest
+			err error
+		)
+
+		if local {
+			m, err = system.ManifestByWorkspace()
+		} else {
+			m, err = selectedManifest()
+		}
+		if err != nil {
+			return err
+		}
+
+		m, err = m.ApplyFilters(&lib.FilterOptions{Name: name, Fuzzy: fuzzy, Query: query})
+		if err != nil {
+			return err
+		}
+
+		return system.GenerateSBOM(m, &lib.SBOMOptions{Format: sbomFormat, Output: sbomOutput})
+	}),
+}
+
+// sbomOutput writes the SBOM of each module to a file named after the
+// module in --out-dir or stdout if it is not specified.
+func sbomOutput(mod *lib.Module) (io.WriteCloser, error) {
+	if outDir == "" {
+		return nopCloser{os.Stdout}, nil
+	}
+
+	ext := ".spdx.json"
+	if sbomFormat == lib.SBOMFormatCycloneDX {
+		ext = ".cdx.json"
+	}
+
+	p := filepath.Join(outDir, mod.Name()+ext)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(p)
+}
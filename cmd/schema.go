@@ -0,0 +1,53 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(schemaCmd)
+}
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema [<name>]",
+	Short: docText("schema-summary"),
+	Long:  docText("schema"),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+			fmt.Fprintf(w, "NAME\tVERSION\tOUTPUT\n")
+			for _, s := range lib.OutputSchemas() {
+				fmt.Fprintf(w, "%s\t%d\t%s\n", s.Name, s.Version, s.Output)
+			}
+			return w.Flush()
+		}
+
+		content, err := lib.OutputSchemaContent(args[0])
+		if err != nil {
+			return err
+		}
+
+		_, err = os.Stdout.Write(content)
+		return err
+	},
+}
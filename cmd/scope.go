@@ -0,0 +1,114 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/pflag"
+)
+
+const scopeHere = "here"
+
+var (
+	scope        string
+	dependencies bool
+	// scopePath is the path of the current directory relative to the
+	// root of the repository when --scope here is specified.
+	scopePath string
+	label     string
+	// labels are the labels parsed from --label.
+	labels map[string]string
+)
+
+// addScopeFlags adds the flags selecting the module containing the
+// current directory and the modules related to it.
+// Commands with their own --dependents flag set withDependents to false.
+func addScopeFlags(flags *pflag.FlagSet, withDependents bool) {
+	flags.StringVar(&scope, "scope", "", "Select the module containing the current directory (here)")
+	flags.StringVar(&label, "label", "", "Select the modules with all of these labels (e.g. team=payments,tier=svc)")
+	flags.BoolVar(&dependencies, "dependencies", false, "Include the modules the selected modules depend on")
+	if withDependents {
+		flags.BoolVar(&dependents, "dependents", false, "Include the modules depending on the selected modules")
+	}
+}
+
+// filterOptions returns the filter specified with --name, --fuzzy,
+// --query, --scope, --label, --dependents and --dependencies flags.
+func filterOptions() *lib.FilterOptions {
+	return &lib.FilterOptions{
+		Name:         name,
+		Fuzzy:        fuzzy,
+		Query:        query,
+		Path:         scopePath,
+		Labels:       labels,
+		Dependents:   dependents,
+		Dependencies: dependencies,
+	}
+}
+
+// resolveLabels parses the labels specified with --label.
+func resolveLabels() error {
+	if label == "" {
+		return nil
+	}
+
+	var err error
+	labels, err = lib.ParseLabelSelector(label)
+	return err
+}
+
+// resolveScope sets scopePath to the current directory relative to the
+// repository in repoDir if --scope here is specified.
+func resolveScope(repoDir string) error {
+	switch scope {
+	case "":
+		return nil
+	case scopeHere:
+	default:
+		return e.NewErrorf(lib.ErrClassUser, "unsupported scope '%v' (supported scopes: %v)", scope, scopeHere)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	// Symlinks are resolved since the current directory is usually
+	// reported without them (e.g. /private/tmp in macOS).
+	root, err := filepath.EvalSymlinks(repoDir)
+	if err != nil {
+		return err
+	}
+	if root, err = filepath.Abs(root); err != nil {
+		return err
+	}
+	if cwd, err = filepath.EvalSymlinks(cwd); err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(root, cwd)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return e.NewErrorf(lib.ErrClassUser, "current directory is not in the repository %v", repoDir)
+	}
+
+	scopePath = filepath.ToSlash(rel)
+	return nil
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveAddr  string
+	serveStdio bool
+)
+
+func init() {
+	serveCommand.Flags().StringVar(&serveAddr, "addr", lib.DefaultServeAddr, "Address to listen on")
+	serveCommand.Flags().BoolVar(&serveStdio, "stdio", false, "Answer JSON-RPC requests on stdin and stdout instead of listening on --addr")
+	RootCmd.AddCommand(serveCommand)
+}
+
+var serveCommand = &cobra.Command{
+	Use:   "serve [--addr <host:port> | --stdio]",
+	Short: docText("serve-summary"),
+	Long:  docText("serve"),
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		if serveStdio {
+			return system.ServeStdio(os.Stdin, os.Stdout)
+		}
+
+		return system.Serve(&lib.ServeOptions{Addr: serveAddr, Stop: interrupt.Done()})
+	}),
+}
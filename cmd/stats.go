@@ -0,0 +1,121 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statsSince   string
+	statsTop     int
+	statsCommand string
+	statsSort    string
+)
+
+func init() {
+	statsCmd.PersistentFlags().BoolVar(&toJSON, "json", false, "Format output as json")
+	statsDurationsCmd.Flags().StringVar(&statsSince, "since", "", "Consider the records in this period (e.g. 30d, 12h)")
+	statsDurationsCmd.Flags().IntVar(&statsTop, "top", 0, "Number of modules listed (0 lists all)")
+	statsDurationsCmd.Flags().StringVar(&statsCommand, "command", "", "Consider the records of this command (build or a user defined command)")
+	statsDurationsCmd.Flags().StringVar(&statsSort, "sort", lib.DurationSortMean, "Sort modules by the mean duration (mean) or its change (trend)")
+	statsCmd.AddCommand(statsFlakyCmd)
+	statsCmd.AddCommand(statsDurationsCmd)
+	RootCmd.AddCommand(statsCmd)
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: docText("stats-summary"),
+	Long:  docText("stats"),
+}
+
+var statsFlakyCmd = &cobra.Command{
+	Use: "flaky",
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		stats, err := system.FlakyModules()
+		if err != nil {
+			return err
+		}
+
+		return outputStats(stats)
+	}),
+}
+
+var statsDurationsCmd = &cobra.Command{
+	Use: "durations",
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		options := &lib.DurationStatsOptions{Top: statsTop, Command: statsCommand, Sort: statsSort}
+		if statsSince != "" {
+			since, err := lib.ParseAge(statsSince)
+			if err != nil {
+				return err
+			}
+			options.Since = since
+		}
+
+		stats, err := system.DurationStats(options)
+		if err != nil {
+			return err
+		}
+
+		return outputDurationStats(stats)
+	}),
+}
+
+func outputDurationStats(stats []*lib.DurationStats) error {
+	if toJSON {
+		buff, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(buff))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+	fmt.Fprintf(w, "NAME\tRUNS\tFAILURES\tCACHE HITS\tMEAN\tP90\tMAX\tLAST\tTREND\n")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.1fs\t%.1fs\t%.1fs\t%.1fs\t%+.1f%%\n", s.Name, s.Runs, s.Failures, s.CacheHits, s.Mean, s.P90, s.Max, s.Last, s.Trend*100)
+	}
+
+	return w.Flush()
+}
+
+func outputStats(stats []*lib.ModuleStats) error {
+	if toJSON {
+		buff, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(buff))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+	fmt.Fprintf(w, "NAME\tBUILDS\tFAILURES\tRETRIES\tFLAKES\tFAILURE RATE\n")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%.1f%%\n", s.Name, s.Builds, s.Failures, s.Retries, s.Flakes, float64(s.Failures*100)/float64(s.Builds))
+	}
+
+	return w.Flush()
+}
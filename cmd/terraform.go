@@ -0,0 +1,64 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	terraformVarsDir string
+	terraformFormat  string
+)
+
+func init() {
+	terraformPlanCmd.Flags().StringVar(&ciFrom, "from", "", "Base revision (branch, tag or commit) of the changes")
+	terraformPlanCmd.Flags().StringVar(&ciTo, "to", "HEAD", "Head revision (branch, tag or commit) of the changes")
+	terraformPlanCmd.Flags().StringVar(&environment, "environment", "", "Merge the properties of this environment in module specs over the base properties")
+	terraformPlanCmd.Flags().StringVar(&terraformVarsDir, "vars-dir", "", "Write the variables of each stack to a .tfvars.json file in this directory")
+	terraformPlanCmd.Flags().StringVar(&terraformFormat, "format", lib.TerraformFormatText, "Output format (text or json)")
+	terraformCmd.AddCommand(terraformPlanCmd)
+	RootCmd.AddCommand(terraformCmd)
+}
+
+var terraformCmd = &cobra.Command{
+	Use:   "terraform",
+	Short: docText("terraform-summary"),
+	Long:  docText("terraform"),
+}
+
+var terraformPlanCmd = &cobra.Command{
+	Use: "plan --from <rev> [--to <rev>] [--environment <name>] [--vars-dir <dir>] [--format text|json]",
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		if ciFrom == "" {
+			return errors.New("requires base revision")
+		}
+
+		plan, err := system.TerraformPlan(ciFrom, ciTo, &lib.TerraformOptions{
+			Environment: environment,
+			VarsDir:     terraformVarsDir,
+		})
+		if err != nil {
+			return err
+		}
+
+		return plan.Write(terraformFormat, os.Stdout)
+	}),
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os/exec"
+	"runtime"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	uiAddr string
+	uiOpen bool
+)
+
+func init() {
+	uiCommand.Flags().StringVar(&uiAddr, "addr", lib.DefaultServeAddr, "Address to listen on")
+	uiCommand.Flags().BoolVar(&uiOpen, "open", false, "Open the page in the default browser")
+	RootCmd.AddCommand(uiCommand)
+}
+
+var uiCommand = &cobra.Command{
+	Use:   "ui [--addr <host:port>] [--open]",
+	Short: docText("ui-summary"),
+	Long:  docText("ui"),
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		return system.Serve(&lib.ServeOptions{
+			Addr: uiAddr,
+			Stop: interrupt.Done(),
+			UI:   true,
+			Ready: func(addr string) {
+				u := "http://" + addr + "/"
+				logrus.Infof("Module graph is available at %v", u)
+				if uiOpen {
+					if err := openBrowser(u); err != nil {
+						logrus.Warnf("Failed to open %v in the browser: %v", u, err)
+					}
+				}
+			},
+		})
+	}),
+}
+
+// openBrowser opens a url in the default browser of the platform.
+func openBrowser(u string) error {
+	switch runtime.GOOS {
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", u).Start()
+	case "darwin":
+		return exec.Command("open", u).Start()
+	default:
+		return exec.Command("xdg-open", u).Start()
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mbtproject/mbt/lib"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var debounce time.Duration
+
+func init() {
+	watchCommand.Flags().StringVarP(&command, "command", "m", "", "Command to execute instead of building the impacted modules")
+	watchCommand.Flags().DurationVar(&debounce, "debounce", 300*time.Millisecond, "Time to wait for further changes before building")
+	watchCommand.Flags().IntVarP(&jobs, "jobs", "j", 1, "Maximum number of modules to build concurrently")
+	watchCommand.Flags().StringArrayVarP(&envVars, "env", "e", nil, "Set an environment variable (KEY=VALUE) for the commands")
+	watchCommand.Flags().StringVar(&containerRuntime, "container-runtime", "docker", "Container runtime used to run commands of modules specifying an image")
+	RootCmd.AddCommand(watchCommand)
+}
+
+var watchCommand = &cobra.Command{
+	Use:   "watch [--command <command>]",
+	Short: docText("watch-summary"),
+	Long:  docText("watch"),
+	RunE: buildHandler(func(cmd *cobra.Command, args []string) error {
+		options := watchBuildCmdOptions()
+		if command != "" {
+			options = runInCmdOptions()
+		}
+
+		return system.Watch(&lib.WatchOptions{
+			Command:  command,
+			Debounce: debounce,
+			Stop:     interrupt.Done(),
+			Summary:  summariseWatch,
+		}, options)
+	}),
+}
+
+// summariseWatch prints a one line summary of a batch of changes
+// processed by watch.
+func summariseWatch(s *lib.WatchSummary) {
+	if len(s.Modules) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(s.Modules))
+	for _, m := range s.Modules {
+		names = append(names, m.Name())
+	}
+	elapsed := s.Elapsed.Round(time.Millisecond)
+
+	switch {
+	case s.Err != nil:
+		logrus.Errorf("FAILED %s after %v (%v changed file(s)), watching for more changes", strings.Join(names, ", "), elapsed, len(s.Paths))
+	case s.Build != nil:
+		logrus.Infof("REBUILT %s in %v (%v changed file(s), built: %v skipped: %v), watching for more changes",
+			strings.Join(names, ", "), elapsed, len(s.Paths), len(s.Build.Completed), len(s.Build.Skipped))
+	default:
+		logrus.Infof("PROCESSED %s in %v (%v changed file(s)), watching for more changes", strings.Join(names, ", "), elapsed, len(s.Paths))
+	}
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/mbtproject/mbt/lib"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	workspaceFile     string
+	workspaceFormat   string
+	workspaceBases    []string
+	workspaceAffected bool
+)
+
+func init() {
+	workspaceCmd.PersistentFlags().StringVar(&workspaceFile, "file", lib.CompositionFile, "Workspace file listing the repositories")
+	workspaceDescribeCmd.Flags().StringVar(&workspaceFormat, "format", formatText, "Output format (text, json or yaml)")
+	workspaceAffectedCmd.Flags().StringVar(&workspaceFormat, "format", formatText, "Output format (text, json or yaml). text lists the module names one per line")
+	workspaceAffectedCmd.Flags().StringArrayVar(&workspaceBases, "base", nil, "Base revision of a repository in the form of <repo>=<rev> overriding the base in the workspace file")
+	workspaceBuildCmd.Flags().BoolVar(&workspaceAffected, "affected", false, "Build just the affected modules")
+	workspaceBuildCmd.Flags().StringArrayVar(&workspaceBases, "base", nil, "Base revision of a repository in the form of <repo>=<rev> overriding the base in the workspace file")
+	workspaceBuildCmd.Flags().StringArrayVarP(&envVars, "env", "e", nil, "Set an environment variable (KEY=VALUE) for the build commands")
+	workspaceBuildCmd.Flags().IntVarP(&jobs, "jobs", "j", 1, "Maximum number of modules to build concurrently")
+
+	workspaceCmd.AddCommand(workspaceDescribeCmd)
+	workspaceCmd.AddCommand(workspaceAffectedCmd)
+	workspaceCmd.AddCommand(workspaceBuildCmd)
+	RootCmd.AddCommand(workspaceCmd)
+}
+
+type compositionHandlerFunc func(c *lib.Composition, command *cobra.Command, args []string) error
+
+// compositionHandler opens the repositories in the workspace file for
+// the handler instead of the repository opened by the root command.
+func compositionHandler(handler compositionHandlerFunc) handlerFunc {
+	return func(command *cobra.Command, args []string) error {
+		level := lib.LogLevelNormal
+		if debug {
+			level = lib.LogLevelDebug
+		}
+
+		c, err := lib.OpenComposition(workspaceFile, level)
+		if err != nil {
+			return handlerError(err)
+		}
+
+		err = handler(c, command, args)
+		if cerr := c.Close(); cerr != nil {
+			logrus.Warn(cerr)
+		}
+
+		return handlerError(err)
+	}
+}
+
+func parseBases() (map[string]string, error) {
+	bases := make(map[string]string)
+	for _, b := range workspaceBases {
+		parts := strings.SplitN(b, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, e.NewErrorf(lib.ErrClassUser, "invalid base '%s', expected <repo>=<rev>", b)
+		}
+		bases[parts[0]] = parts[1]
+	}
+	return bases, nil
+}
+
+func outputComposed(m *lib.Manifest, list bool) error {
+	if workspaceFormat != formatText {
+		return m.Modules.Describe().Write(workspaceFormat, os.Stdout)
+	}
+
+	if list {
+		for _, a := range m.Modules {
+			fmt.Println(a.Name())
+		}
+		return nil
+	}
+
+	return outputTable(m.Modules)
+}
+
+var workspaceCmd = &cobra.Command{
+	Use:   "workspace",
+	Short: docText("workspace-summary"),
+	Long:  docText("workspace"),
+}
+
+var workspaceDescribeCmd = &cobra.Command{
+	Use:   "describe [--format text|json|yaml]",
+	Short: docText("workspace-summary"),
+	Long:  docText("workspace"),
+	RunE: compositionHandler(func(c *lib.Composition, cmd *cobra.Command, args []string) error {
+		m, err := c.Manifest()
+		if err != nil {
+			return err
+		}
+
+		return outputComposed(m, false)
+	}),
+}
+
+var workspaceAffectedCmd = &cobra.Command{
+	Use:   "affected [--base <repo>=<rev>...] [--format text|json|yaml]",
+	Short: docText("workspace-summary"),
+	Long:  docText("workspace"),
+	RunE: compositionHandler(func(c *lib.Composition, cmd *cobra.Command, args []string) error {
+		bases, err := parseBases()
+		if err != nil {
+			return err
+		}
+
+		m, err := c.Affected(bases)
+		if err != nil {
+			return err
+		}
+
+		return outputComposed(m, true)
+	}),
+}
+
+var workspaceBuildCmd = &cobra.Command{
+	Use:   "build [--affected [--base <repo>=<rev>...]]",
+	Short: docText("workspace-summary"),
+	Long:  docText("workspace"),
+	RunE: compositionHandler(func(c *lib.Composition, cmd *cobra.Command, args []string) error {
+		var (
+			m   *lib.Manifest
+			err error
+		)
+
+		if workspaceAffected {
+			bases, berr := parseBases()
+			if berr != nil {
+				return berr
+			}
+			m, err = c.Affected(bases)
+		} else {
+			m, err = c.Manifest()
+		}
+		if err != nil {
+			return err
+		}
+
+		options := lib.CmdOptionsWithStdIO(buildStageCB)
+		options.Env = envVars
+		options.Jobs = jobs
+
+		summaries, err := c.Build(m, options)
+		for _, s := range summaries {
+			logrus.Infof("%v: Modules: %v Built: %v Skipped: %v",
+				s.Repo,
+				len(s.Summary.Manifest.Modules),
+				len(s.Summary.Completed),
+				len(s.Summary.Skipped))
+		}
+
+		if err == nil && len(m.Modules) == 0 {
+			resultCode = lib.ExitCodeNothingToBuild
+		}
+		return err
+	}),
+}
@@ -28,8 +28,21 @@ type E struct {
 	message          string
 	innerError       error
 	class            int
+	code             int
 	stack            []runtime.Frame
 	showExtendedInfo bool
+	format           string
+	args             []interface{}
+	location         *Location
+}
+
+// Location is a position in a file an error is attributed to (e.g. the
+// line of a configuration file containing an invalid value).
+// Line and Column are 1 based, 0 indicates that they are not known.
+type Location struct {
+	File   string
+	Line   int
+	Column int
 }
 
 func (e *E) Error() string {
@@ -68,6 +81,55 @@ func (e *E) Class() int {
 	return e.class
 }
 
+// Code returns the code of this error. If the code is not set, code
+// of the wrapped error is returned.
+// Codes let the consumers distinguish between the errors of a class
+// (e.g. to choose the exit code of a process).
+func (e *E) Code() int {
+	if e.code == 0 {
+		if inner, ok := e.innerError.(*E); ok {
+			return inner.Code()
+		}
+	}
+	return e.code
+}
+
+// WithCode sets the code of this error and returns it.
+func (e *E) WithCode(code int) *E {
+	e.code = code
+	return e
+}
+
+// Format returns the format of the message of this error before the
+// arguments were interpolated.
+// Formats let the consumers identify the errors with the same message
+// regardless of their arguments.
+func (e *E) Format() string {
+	return e.format
+}
+
+// Args returns the arguments interpolated in the message of this error.
+func (e *E) Args() []interface{} {
+	return e.args
+}
+
+// Location returns the location this error is attributed to. If the
+// location is not set, location of the wrapped error is returned.
+func (e *E) Location() *Location {
+	if e.location == nil {
+		if inner, ok := e.innerError.(*E); ok {
+			return inner.Location()
+		}
+	}
+	return e.location
+}
+
+// WithLocation sets the location of this error and returns it.
+func (e *E) WithLocation(file string, line, column int) *E {
+	e.location = &Location{File: file, Line: line, Column: column}
+	return e
+}
+
 // Stack returns the callstack (up to 32 frames) indicating where the
 // error occurred
 func (e *E) Stack() []runtime.Frame {
@@ -79,10 +141,14 @@ func (e *E) Stack() []runtime.Frame {
 func (e *E) WithExtendedInfo() *E {
 	return &E{
 		class:            e.class,
+		code:             e.code,
 		innerError:       e.innerError,
 		message:          e.message,
 		showExtendedInfo: true,
 		stack:            e.stack,
+		format:           e.format,
+		args:             e.args,
+		location:         e.location,
 	}
 }
 
@@ -128,5 +194,5 @@ func newE(klass int, innerError error, message string, args ...interface{}) *E {
 			break
 		}
 	}
-	return &E{class: klass, message: m, innerError: innerError, stack: frames}
+	return &E{class: klass, message: m, innerError: innerError, stack: frames, format: message, args: args}
 }
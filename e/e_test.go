@@ -65,6 +65,40 @@ func TestExtendedInfo(t *testing.T) {
 	assert.Contains(t, err.WithExtendedInfo().Error(), "call stack")
 }
 
+func TestCode(t *testing.T) {
+	err := NewError(ErrClassUser, "a").WithCode(4)
+	assert.Equal(t, 4, err.Code())
+	assert.Equal(t, 4, err.WithExtendedInfo().Code())
+}
+
+func TestCodeOfWrappedError(t *testing.T) {
+	inner := NewError(ErrClassUser, "a").WithCode(4)
+	assert.Equal(t, 4, Wrapf(ErrClassUser, inner, "b").Code())
+	assert.Equal(t, 5, Wrapf(ErrClassUser, inner, "b").WithCode(5).Code())
+	assert.Equal(t, 0, Wrapf(ErrClassUser, errors.New("a"), "b").Code())
+}
+
+func TestFormat(t *testing.T) {
+	err := NewErrorf(ErrClassUser, "a %v %v", "b", 1)
+	assert.Equal(t, "a %v %v", err.Format())
+	assert.Equal(t, []interface{}{"b", 1}, err.Args())
+	assert.Equal(t, "a %v %v", err.WithExtendedInfo().Format())
+}
+
+func TestLocation(t *testing.T) {
+	assert.Nil(t, NewError(ErrClassUser, "a").Location())
+
+	err := NewError(ErrClassUser, "a").WithLocation("a.yml", 2, 3)
+	assert.Equal(t, &Location{File: "a.yml", Line: 2, Column: 3}, err.Location())
+	assert.Equal(t, err.Location(), err.WithExtendedInfo().Location())
+}
+
+func TestLocationOfWrappedError(t *testing.T) {
+	inner := NewError(ErrClassUser, "a").WithLocation("a.yml", 2, 0)
+	assert.Equal(t, "a.yml", Wrapf(ErrClassUser, inner, "b").Location().File)
+	assert.Equal(t, "b.yml", Wrapf(ErrClassUser, inner, "b").WithLocation("b.yml", 0, 0).Location().File)
+}
+
 func WrappingAnE(t *testing.T) {
 	a := Wrap(ErrClassInternal, errors.New("a"))
 	assert.Equal(t, a, Wrap(ErrClassInternal, a))
@@ -0,0 +1,281 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graph
+
+import (
+	"errors"
+	"sort"
+)
+
+// Order maintains the topological order of a directed acyclic graph as
+// vertices are added, removed or their children change, without
+// sorting the entire graph again. Like TopSort, children are ordered
+// before the vertices referring to them.
+// Only the vertices between the endpoints of an edge violating the
+// order are reordered (Pearce and Kelly, A Dynamic Topological Sort
+// Algorithm for Directed Acyclic Graphs).
+type Order struct {
+	nodeProvider NodeProvider
+	vertices     map[interface{}]interface{}
+	rank         map[interface{}]int
+	children     map[interface{}][]interface{}
+	parents      map[interface{}]map[interface{}]bool
+	next         int
+}
+
+// NewOrder creates the order of the provided graph.
+// Returns an error if the provided graph is not a directed acyclic
+// graph (DAG).
+func NewOrder(nodeProvider NodeProvider, graph ...interface{}) (*Order, error) {
+	sorted, err := TopSort(nodeProvider, graph...)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Order{
+		nodeProvider: nodeProvider,
+		vertices:     make(map[interface{}]interface{}),
+		rank:         make(map[interface{}]int),
+		children:     make(map[interface{}][]interface{}),
+		parents:      make(map[interface{}]map[interface{}]bool),
+	}
+
+	for _, v := range sorted {
+		id := nodeProvider.ID(v)
+		children, err := o.childIDs(v)
+		if err != nil {
+			return nil, err
+		}
+
+		o.vertices[id] = v
+		o.rank[id] = o.next
+		o.next++
+		o.link(id, children)
+	}
+
+	return o, nil
+}
+
+// Len returns the number of vertices in the order.
+func (o *Order) Len() int {
+	return len(o.vertices)
+}
+
+// Sorted returns the vertices in topological order.
+func (o *Order) Sorted() []interface{} {
+	ids := make([]interface{}, 0, len(o.vertices))
+	for id := range o.vertices {
+		ids = append(ids, id)
+	}
+	o.sortByRank(ids)
+
+	sorted := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		sorted = append(sorted, o.vertices[id])
+	}
+	return sorted
+}
+
+// Set adds the vertex or replaces the vertex with the same identifier,
+// linking it to its current children. Children must be in the order
+// already. The order is not changed if an error is returned (e.g. a
+// CycleError if the children would introduce a cycle).
+func (o *Order) Set(vertex interface{}) error {
+	id := o.nodeProvider.ID(vertex)
+	children, err := o.childIDs(vertex)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range children {
+		if _, ok := o.vertices[c]; !ok {
+			return errors.New("child is not in the order")
+		}
+	}
+
+	r, exists := o.rank[id]
+	if !exists {
+		// New vertices are not referred to by any vertex, therefore no
+		// edge is violated when they are added to the end.
+		o.vertices[id] = vertex
+		o.rank[id] = o.next
+		o.next++
+		o.link(id, children)
+		return nil
+	}
+
+	// Children form a cycle if the vertex can be reached from any of
+	// them. Since the order is valid, such a path only goes through
+	// the vertices ranked below the child.
+	for _, c := range children {
+		if path := o.pathToParent(id, c); path != nil {
+			cycle := []interface{}{vertex}
+			for i := len(path) - 1; i > 0; i-- {
+				cycle = append(cycle, o.vertices[path[i]])
+			}
+			return &CycleError{Path: append(cycle, vertex)}
+		}
+	}
+
+	o.unlink(id)
+	o.vertices[id] = vertex
+	o.link(id, children)
+
+	for _, c := range children {
+		if o.rank[c] > r {
+			o.reorder(c, id)
+			r = o.rank[id]
+		}
+	}
+
+	return nil
+}
+
+// Remove removes the vertex with the same identifier as vertex.
+// Returns an error if it is a child of another vertex in the order.
+func (o *Order) Remove(vertex interface{}) error {
+	id := o.nodeProvider.ID(vertex)
+	if _, ok := o.vertices[id]; !ok {
+		return nil
+	}
+
+	if len(o.parents[id]) > 0 {
+		return errors.New("vertex is a child of other vertices")
+	}
+
+	o.unlink(id)
+	delete(o.vertices, id)
+	delete(o.rank, id)
+	delete(o.parents, id)
+	return nil
+}
+
+func (o *Order) childIDs(vertex interface{}) ([]interface{}, error) {
+	ids := make([]interface{}, 0, o.nodeProvider.ChildCount(vertex))
+	for i := 0; i < o.nodeProvider.ChildCount(vertex); i++ {
+		c, err := o.nodeProvider.Child(vertex, i)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, o.nodeProvider.ID(c))
+	}
+	return ids, nil
+}
+
+func (o *Order) link(id interface{}, children []interface{}) {
+	o.children[id] = children
+	for _, c := range children {
+		if o.parents[c] == nil {
+			o.parents[c] = make(map[interface{}]bool)
+		}
+		o.parents[c][id] = true
+	}
+}
+
+func (o *Order) unlink(id interface{}) {
+	for _, c := range o.children[id] {
+		delete(o.parents[c], id)
+	}
+	delete(o.children, id)
+}
+
+// pathToParent returns the path from the vertex from to the vertex to,
+// following the edges from children to parents, or nil if there is no
+// such path.
+func (o *Order) pathToParent(from, to interface{}) []interface{} {
+	bound := o.rank[to]
+	visited := make(map[interface{}]bool)
+	var visit func(id interface{}, path []interface{}) []interface{}
+	visit = func(id interface{}, path []interface{}) []interface{} {
+		path = append(path, id)
+		if id == to {
+			return path
+		}
+		visited[id] = true
+		for p := range o.parents[id] {
+			if !visited[p] && o.rank[p] <= bound {
+				if r := visit(p, path); r != nil {
+					return r
+				}
+			}
+		}
+		return nil
+	}
+	return visit(from, nil)
+}
+
+// reorder restores the order violated by the edge from the child with
+// identifier child to its parent with identifier parent. Parent and
+// the vertices depending on it ranked below child are moved after child
+// and its descendants ranked above parent.
+func (o *Order) reorder(child, parent interface{}) {
+	lower, upper := o.rank[parent], o.rank[child]
+
+	forward := o.collect(parent, func(id interface{}) []interface{} {
+		ids := make([]interface{}, 0, len(o.parents[id]))
+		for p := range o.parents[id] {
+			ids = append(ids, p)
+		}
+		return ids
+	}, func(r int) bool { return r < upper })
+
+	backward := o.collect(child, func(id interface{}) []interface{} {
+		return o.children[id]
+	}, func(r int) bool { return r > lower })
+
+	o.sortByRank(forward)
+	o.sortByRank(backward)
+	moved := append(backward, forward...)
+
+	ranks := make([]int, 0, len(moved))
+	for _, id := range moved {
+		ranks = append(ranks, o.rank[id])
+	}
+	sort.Ints(ranks)
+
+	for i, id := range moved {
+		o.rank[id] = ranks[i]
+	}
+}
+
+// collect returns the vertices reachable from start through next
+// with ranks satisfying within.
+func (o *Order) collect(start interface{}, next func(interface{}) []interface{}, within func(int) bool) []interface{} {
+	visited := map[interface{}]bool{start: true}
+	stack := []interface{}{start}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, n := range next(id) {
+			if !visited[n] && within(o.rank[n]) {
+				visited[n] = true
+				stack = append(stack, n)
+			}
+		}
+	}
+
+	ids := make([]interface{}, 0, len(visited))
+	for id := range visited {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (o *Order) sortByRank(ids []interface{}) {
+	sort.Slice(ids, func(i, j int) bool {
+		return o.rank[ids[i]] < o.rank[ids[j]]
+	})
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graph
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func names(vertices []interface{}) []string {
+	r := make([]string, 0, len(vertices))
+	for _, v := range vertices {
+		r = append(r, v.(*node).name)
+	}
+	return r
+}
+
+// assertTopologicalOrder verifies that the children of each vertex are
+// ordered before it.
+func assertTopologicalOrder(t *testing.T, o *Order) {
+	position := make(map[string]int)
+	for i, v := range o.Sorted() {
+		position[v.(*node).name] = i
+	}
+
+	for _, v := range o.Sorted() {
+		for _, c := range v.(*node).children {
+			assert.True(t, position[c.name] < position[v.(*node).name], "%s must be ordered before %s", c.name, v.(*node).name)
+		}
+	}
+}
+
+func TestNewOrder(t *testing.T) {
+	a := newNode("a")
+	b := newNode("b")
+	c := newNode("c")
+	a.children = []*node{b}
+	b.children = []*node{c}
+
+	o, err := NewOrder(&testNodeProvider{}, a, b, c)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, o.Len())
+	assert.Equal(t, []string{"c", "b", "a"}, names(o.Sorted()))
+}
+
+func TestNewOrderForCycle(t *testing.T) {
+	a := newNode("a")
+	b := newNode("b")
+	a.children = []*node{b}
+	b.children = []*node{a}
+
+	_, err := NewOrder(&testNodeProvider{}, a, b)
+
+	assert.IsType(t, &CycleError{}, err)
+}
+
+func TestSetNewVertex(t *testing.T) {
+	a := newNode("a")
+	b := newNode("b")
+	o, err := NewOrder(&testNodeProvider{}, a, b)
+	assert.NoError(t, err)
+
+	c := newNode("c")
+	c.children = []*node{a}
+	assert.NoError(t, o.Set(c))
+
+	assert.Equal(t, []string{"a", "b", "c"}, names(o.Sorted()))
+}
+
+func TestSetVertexWithMissingChild(t *testing.T) {
+	a := newNode("a")
+	o, err := NewOrder(&testNodeProvider{}, a)
+	assert.NoError(t, err)
+
+	b := newNode("b")
+	b.children = []*node{newNode("c")}
+
+	assert.EqualError(t, o.Set(b), "child is not in the order")
+	assert.Equal(t, []string{"a"}, names(o.Sorted()))
+}
+
+func TestSetReordersAffectedVertices(t *testing.T) {
+	a := newNode("a")
+	b := newNode("b")
+	c := newNode("c")
+	d := newNode("d")
+	b.children = []*node{a}
+	o, err := NewOrder(&testNodeProvider{}, a, b, c, d)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, names(o.Sorted()))
+
+	// a now depends on d, therefore d is moved before a and b.
+	// c is not affected.
+	a2 := newNode("a")
+	a2.children = []*node{d}
+	b.children = []*node{a2}
+	assert.NoError(t, o.Set(a2))
+
+	assert.Equal(t, []string{"d", "a", "c", "b"}, names(o.Sorted()))
+	assertTopologicalOrder(t, o)
+}
+
+func TestSetVertexIntroducingCycle(t *testing.T) {
+	a := newNode("a")
+	b := newNode("b")
+	c := newNode("c")
+	b.children = []*node{a}
+	c.children = []*node{b}
+	o, err := NewOrder(&testNodeProvider{}, a, b, c)
+	assert.NoError(t, err)
+
+	a2 := newNode("a")
+	a2.children = []*node{c}
+	err = o.Set(a2)
+
+	assert.IsType(t, &CycleError{}, err)
+	assert.Equal(t, []string{"a", "c", "b", "a"}, names(err.(*CycleError).Path))
+	assert.Equal(t, []string{"a", "b", "c"}, names(o.Sorted()))
+	assert.Equal(t, a, o.Sorted()[0])
+}
+
+func TestSetChildError(t *testing.T) {
+	a := newNode("a")
+	o, err := NewOrder(&testNodeProvider{}, a)
+	assert.NoError(t, err)
+
+	b := newNode("b")
+	b.children = []*node{a}
+	err = o.Set(b)
+
+	assert.NoError(t, err)
+
+	o.nodeProvider = &testNodeProvider{childError: fmt.Errorf("doh")}
+	assert.EqualError(t, o.Set(b), "doh")
+}
+
+func TestRemove(t *testing.T) {
+	a := newNode("a")
+	b := newNode("b")
+	b.children = []*node{a}
+	o, err := NewOrder(&testNodeProvider{}, a, b)
+	assert.NoError(t, err)
+
+	assert.EqualError(t, o.Remove(a), "vertex is a child of other vertices")
+	assert.NoError(t, o.Remove(b))
+	assert.NoError(t, o.Remove(a))
+	assert.NoError(t, o.Remove(newNode("c")))
+
+	assert.Equal(t, 0, o.Len())
+}
+
+func TestRandomUpdates(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	vertices := make([]*node, 0)
+	for i := 0; i < 50; i++ {
+		vertices = append(vertices, newNode(fmt.Sprintf("n%d", i)))
+	}
+
+	graph := make([]interface{}, 0, len(vertices))
+	for _, v := range vertices {
+		graph = append(graph, v)
+	}
+	o, err := NewOrder(&testNodeProvider{}, graph...)
+	assert.NoError(t, err)
+
+	for i := 0; i < 500; i++ {
+		v := vertices[r.Intn(len(vertices))]
+		c := vertices[r.Intn(len(vertices))]
+		n := &node{name: v.name, children: append(append([]*node{}, v.children...), c)}
+
+		err := o.Set(n)
+		if _, ok := err.(*CycleError); ok {
+			continue
+		}
+		assert.NoError(t, err)
+
+		// Vertices referring to v are linked to the new vertex by name.
+		*v = *n
+		assertTopologicalOrder(t, o)
+	}
+}
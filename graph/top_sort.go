@@ -58,6 +58,8 @@ func (e *CycleError) Error() string {
 // TopSort performs a topological sort of the provided graph.
 // Returns an array containing the sorted graph or an
 // error if the provided graph is not a directed acyclic graph (DAG).
+// Graph is traversed with an explicit stack, therefore the length of
+// the dependency chains is only bounded by the available memory.
 func TopSort(nodeProvider NodeProvider, graph ...interface{}) ([]interface{}, error) {
 	if nodeProvider == nil {
 		return nil, errors.New("nodeProvider should be a valid reference")
@@ -67,7 +69,7 @@ func TopSort(nodeProvider NodeProvider, graph ...interface{}) ([]interface{}, er
 	results := make([]interface{}, 0)
 
 	for _, node := range graph {
-		err := dfsVisit(nodeProvider, node, traversalState, &results, make([]interface{}, 0))
+		err := dfsVisit(nodeProvider, node, traversalState, &results)
 		if err != nil {
 			return nil, err
 		}
@@ -76,31 +78,91 @@ func TopSort(nodeProvider NodeProvider, graph ...interface{}) ([]interface{}, er
 	return results, nil
 }
 
-func dfsVisit(nodeProvider NodeProvider, node interface{}, traversalState map[interface{}]tState, sorted *[]interface{}, path []interface{}) error {
-	id := nodeProvider.ID(node)
-	if traversalState[id] == stateOpen {
-		return &CycleError{Path: append(path, node)}
-	}
+// dfsFrame is a vertex being visited and the index of its next child.
+type dfsFrame struct {
+	node  interface{}
+	id    interface{}
+	child int
+}
 
+func dfsVisit(nodeProvider NodeProvider, node interface{}, traversalState map[interface{}]tState, sorted *[]interface{}) error {
+	id := nodeProvider.ID(node)
 	if traversalState[id] == stateClosed {
 		return nil
 	}
 
 	traversalState[id] = stateOpen
-	path = append(path, node)
+	stack := []*dfsFrame{{node: node, id: id}}
+
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		if top.child == nodeProvider.ChildCount(top.node) {
+			traversalState[top.id] = stateClosed
+			*sorted = append(*sorted, top.node)
+			stack = stack[:len(stack)-1]
+			continue
+		}
 
-	for i := 0; i < nodeProvider.ChildCount(node); i++ {
-		c, err := nodeProvider.Child(node, i)
+		c, err := nodeProvider.Child(top.node, top.child)
 		if err != nil {
 			return err
 		}
-		err = dfsVisit(nodeProvider, c, traversalState, sorted, path)
-		if err != nil {
-			return err
+		top.child++
+
+		cid := nodeProvider.ID(c)
+		switch traversalState[cid] {
+		case stateOpen:
+			// Path of the cycle is the vertices being visited followed
+			// by the child referring to one of them.
+			path := make([]interface{}, 0, len(stack)+1)
+			for _, f := range stack {
+				path = append(path, f.node)
+			}
+			return &CycleError{Path: append(path, c)}
+		case stateNew:
+			traversalState[cid] = stateOpen
+			stack = append(stack, &dfsFrame{node: c, id: cid})
 		}
 	}
 
-	traversalState[id] = stateClosed
-	*sorted = append(*sorted, node)
 	return nil
 }
+
+// TopSortGrouped performs a topological sort of the provided graph and
+// groups the vertices in batches. Children of each vertex are in the
+// batches before the batch of the vertex, so that the vertices in a
+// batch can be processed in parallel once the previous batches are
+// processed. Vertices in a batch are in the order returned by TopSort.
+// Returns an error if the provided graph is not a directed acyclic
+// graph (DAG).
+func TopSortGrouped(nodeProvider NodeProvider, graph ...interface{}) ([][]interface{}, error) {
+	sorted, err := TopSort(nodeProvider, graph...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Children are sorted before their parents, therefore the level of
+	// each child is known when its parent is reached.
+	level := make(map[interface{}]int)
+	groups := make([][]interface{}, 0)
+	for _, node := range sorted {
+		l := 0
+		for i := 0; i < nodeProvider.ChildCount(node); i++ {
+			c, err := nodeProvider.Child(node, i)
+			if err != nil {
+				return nil, err
+			}
+			if cl := level[nodeProvider.ID(c)] + 1; cl > l {
+				l = cl
+			}
+		}
+
+		level[nodeProvider.ID(node)] = l
+		if l == len(groups) {
+			groups = append(groups, make([]interface{}, 0))
+		}
+		groups[l] = append(groups[l], node)
+	}
+
+	return groups, nil
+}
@@ -17,6 +17,7 @@ package graph
 
 import (
 	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -262,3 +263,114 @@ func TestComplexCycle(t *testing.T) {
 	assert.Equal(t, f, cErr.Path[4])
 	assert.Equal(t, c, cErr.Path[5])
 }
+
+// chain creates n vertices where each vertex depends on the next one.
+func chain(n int) []*node {
+	nodes := make([]*node, n)
+	for i := n - 1; i >= 0; i-- {
+		nodes[i] = newNode(fmt.Sprintf("n%d", i))
+		if i < n-1 {
+			nodes[i].children = []*node{nodes[i+1]}
+		}
+	}
+	return nodes
+}
+
+func TestDeepChain(t *testing.T) {
+	nodes := chain(100000)
+
+	s, err := TopSort(&testNodeProvider{}, nodes[0])
+
+	assert.NoError(t, err)
+	assert.Len(t, s, 100000)
+	assert.Equal(t, nodes[99999], s[0])
+	assert.Equal(t, nodes[0], s[99999])
+}
+
+func TestCycleInDeepChain(t *testing.T) {
+	nodes := chain(100000)
+	nodes[99999].children = []*node{nodes[99998]}
+
+	s, err := TopSort(&testNodeProvider{}, nodes[0])
+	cErr := err.(*CycleError)
+
+	assert.Nil(t, s)
+	assert.Len(t, cErr.Path, 100001)
+	assert.Equal(t, nodes[0], cErr.Path[0])
+	assert.Equal(t, nodes[99999], cErr.Path[99999])
+	assert.Equal(t, nodes[99998], cErr.Path[100000])
+}
+
+func BenchmarkTopSortDeepChain(b *testing.B) {
+	nodes := chain(100000)
+	provider := &testNodeProvider{}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := TopSort(provider, nodes[0]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func groupNames(groups [][]interface{}) [][]string {
+	r := make([][]string, 0, len(groups))
+	for _, g := range groups {
+		r = append(r, names(g))
+	}
+	return r
+}
+
+func TestTopSortGrouped(t *testing.T) {
+	/*
+		a -> [b, c]
+		b -> [d]
+		c -> [d, e]
+		f -> [e]
+	*/
+	a := newNode("a")
+	b := newNode("b")
+	c := newNode("c")
+	d := newNode("d")
+	e := newNode("e")
+	f := newNode("f")
+	a.children = []*node{b, c}
+	b.children = []*node{d}
+	c.children = []*node{d, e}
+	f.children = []*node{e}
+
+	g, err := TopSortGrouped(&testNodeProvider{}, a, b, c, d, e, f)
+
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"d", "e"}, {"b", "c", "f"}, {"a"}}, groupNames(g))
+}
+
+func TestTopSortGroupedForDeepChain(t *testing.T) {
+	nodes := chain(1000)
+
+	g, err := TopSortGrouped(&testNodeProvider{}, nodes[0])
+
+	assert.NoError(t, err)
+	assert.Len(t, g, 1000)
+	assert.Equal(t, []interface{}{nodes[999]}, g[0])
+	assert.Equal(t, []interface{}{nodes[0]}, g[999])
+}
+
+func TestTopSortGroupedForCycle(t *testing.T) {
+	a := newNode("a")
+	b := newNode("b")
+	a.children = []*node{b}
+	b.children = []*node{a}
+
+	g, err := TopSortGrouped(&testNodeProvider{}, a)
+
+	assert.Nil(t, g)
+	assert.IsType(t, &CycleError{}, err)
+}
+
+func TestTopSortGroupedForEmptyInput(t *testing.T) {
+	g, err := TopSortGrouped(&testNodeProvider{})
+
+	assert.NoError(t, err)
+	assert.Len(t, g, 0)
+}
@@ -98,7 +98,14 @@ func resolveMethod(name string, target interface{}) reflect.Value {
 
 func invokeTarget(target interface{}, method reflect.Value, name string, args ...interface{}) []interface{} {
 	in := make([]reflect.Value, 0, len(args))
-	for _, v := range args {
+	t := method.Type()
+	for i, v := range args {
+		// Untyped nil arguments (e.g. a nil interface) are passed as the
+		// zero value of the parameter type.
+		if v == nil && i < t.NumIn() && !(t.IsVariadic() && i == t.NumIn()-1) {
+			in = append(in, reflect.Zero(t.In(i)))
+			continue
+		}
 		in = append(in, reflect.ValueOf(v))
 	}
 
@@ -51,6 +51,10 @@ func (t *TestTarget) F5() (*TestTarget, error) {
 	return nil, errors.New("doh")
 }
 
+func (t *TestTarget) F6(e error) bool {
+	return e == nil
+}
+
 func TestSingleReturn(t *testing.T) {
 	target := &TestTarget{}
 	i := NewInterceptor(target)
@@ -126,3 +130,9 @@ func TestErrors(t *testing.T) {
 	i := NewInterceptor(target)
 	assert.Nil(t, i.Call("F5")[0].(*TestTarget))
 }
+
+func TestNilArgument(t *testing.T) {
+	target := &TestTarget{}
+	i := NewInterceptor(target)
+	assert.True(t, i.Call("F6", nil)[0].(bool))
+}
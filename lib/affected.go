@@ -0,0 +1,178 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"path/filepath"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// AffectedBuilds selects the modules to build.
+	AffectedBuilds = "builds"
+	// AffectedTests selects the modules to test.
+	AffectedTests = "tests"
+	// AffectedDeploys selects the modules to deploy.
+	AffectedDeploys = "deploys"
+)
+
+// defaultAffectedSelectors are the selectors of the well known purposes
+// used unless they are overridden in the repository configuration.
+// Modules are tested (or deployed) if they declare a test (or deploy)
+// command or they are tagged with it.
+var defaultAffectedSelectors = map[string]*AffectedSelector{
+	AffectedBuilds:  {},
+	AffectedTests:   {Query: `"test" in commands || "test" in tags`},
+	AffectedDeploys: {Query: `"deploy" in commands || "deploy" in tags`},
+}
+
+func (s *stdSystem) Affected(base, head, purpose string) (*Manifest, error) {
+	baseCommit, err := s.Repo.ResolveCommit(base)
+	if err != nil {
+		return nil, err
+	}
+
+	headCommit, err := s.Repo.ResolveCommit(head)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.affected(baseCommit, headCommit, purpose)
+}
+
+// affected selects the modules for a purpose among the modules affected
+// by the changes between the merge base of base and head, and head.
+// All modules in head are considered if base is nil.
+func (s *stdSystem) affected(baseCommit, headCommit Commit, purpose string) (*Manifest, error) {
+	// Selectors are read from head, so that a change can adjust them.
+	config, _, err := s.partialsInCommit(headCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	selector, ok := config.Affected[purpose]
+	if !ok {
+		selector, ok = defaultAffectedSelectors[purpose]
+	}
+	if !ok {
+		return nil, e.NewErrorf(ErrClassUser, msgUnknownAffectedPurpose, purpose)
+	}
+
+	mods, err := s.Discover.ModulesInCommit(headCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	if baseCommit != nil {
+		deltas, err := s.Repo.DiffMergeBase(baseCommit, headCommit)
+		if err != nil {
+			return nil, err
+		}
+
+		mods, err = s.Reducer.Reduce(mods, deltas)
+		if err != nil {
+			return nil, err
+		}
+
+		if selector.Dependents == nil || *selector.Dependents {
+			mods, err = mods.expandRequiredByDependencies()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	dir, err := filepath.Abs(s.Repo.Path())
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	m := &Manifest{Dir: dir, Sha: headCommit.ID(), Modules: mods}
+	if selector.Query == "" {
+		return m, nil
+	}
+
+	m, err = m.FilterByQuery(selector.Query)
+	if err != nil {
+		return nil, configError(e.Wrapf(ErrClassUser, err, msgFailedAffectedQuery, purpose))
+	}
+
+	return m, nil
+}
+
+// changeImpact is the impact of the changes between the merge base of
+// two commits and the second commit.
+type changeImpact struct {
+	from, to Commit
+	// all is the modules in to and previous is the modules in the
+	// merge base.
+	all, previous Modules
+	// changed is the modules changed directly and affected is the
+	// changed modules along with the modules depending on them.
+	changed, affected Modules
+}
+
+func (s *stdSystem) changeImpact(base, head string) (*changeImpact, error) {
+	baseCommit, err := s.Repo.ResolveCommit(base)
+	if err != nil {
+		return nil, err
+	}
+
+	headCommit, err := s.Repo.ResolveCommit(head)
+	if err != nil {
+		return nil, err
+	}
+
+	mergeBase, err := s.Repo.MergeBase(baseCommit, headCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := s.Discover.ModulesInCommit(headCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := s.Discover.ModulesInCommit(mergeBase)
+	if err != nil {
+		return nil, err
+	}
+
+	deltas, err := s.Repo.DiffMergeBase(baseCommit, headCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	changed, err := s.Reducer.Reduce(all, deltas)
+	if err != nil {
+		return nil, err
+	}
+
+	affected, err := changed.expandRequiredByDependencies()
+	if err != nil {
+		return nil, err
+	}
+
+	return &changeImpact{
+		from:     baseCommit,
+		to:       headCommit,
+		all:      all,
+		previous: previous,
+		changed:  changed,
+		affected: affected,
+	}, nil
+}
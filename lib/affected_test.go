@@ -0,0 +1,140 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func initAffectedRepo(t *testing.T) (*TestRepository, string) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("lib-a", &Spec{Name: "lib-a"}))
+	check(t, repo.InitModuleWithOptions("svc-a", &Spec{
+		Name:         "svc-a",
+		Dependencies: []string{"lib-a"},
+		Commands:     map[string]*UserCmd{"test": {Cmd: "echo"}},
+		Properties:   map[string]interface{}{"tags": []interface{}{"deploy"}},
+	}))
+	check(t, repo.InitModuleWithOptions("svc-b", &Spec{
+		Name:       "svc-b",
+		Commands:   map[string]*UserCmd{"deploy": {Cmd: "echo"}},
+		Properties: map[string]interface{}{"tags": []interface{}{"test"}},
+	}))
+	check(t, repo.Commit("first"))
+	base := repo.LastCommit.String()
+
+	check(t, repo.SwitchToBranch("feature"))
+	check(t, repo.WriteContent("lib-a/foo", "a"))
+	check(t, repo.Commit("second"))
+
+	return repo, base
+}
+
+func TestAffected(t *testing.T) {
+	repo, base := initAffectedRepo(t)
+	check(t, repo.WriteContent("svc-b/foo", "b"))
+	check(t, repo.Commit("third"))
+
+	system := NewWorld(t, ".tmp/repo").System
+	cases := map[string][]string{
+		AffectedBuilds:  {"lib-a", "svc-a", "svc-b"},
+		AffectedTests:   {"svc-a", "svc-b"},
+		AffectedDeploys: {"svc-a", "svc-b"},
+	}
+
+	for purpose, expected := range cases {
+		m, err := system.Affected(base, "HEAD", purpose)
+		check(t, err)
+		assert.ElementsMatch(t, expected, moduleNames(m.Modules), purpose)
+		assert.Equal(t, repo.LastCommit.String(), m.Sha)
+	}
+}
+
+func TestAffectedIncludesDependents(t *testing.T) {
+	_, base := initAffectedRepo(t)
+
+	m, err := NewWorld(t, ".tmp/repo").System.Affected(base, "feature", AffectedTests)
+	check(t, err)
+
+	assert.Equal(t, []string{"svc-a"}, moduleNames(m.Modules))
+}
+
+func TestAffectedOfBranchSinceItDiverged(t *testing.T) {
+	repo, _ := initAffectedRepo(t)
+	check(t, repo.SwitchToBranch("master"))
+	check(t, repo.WriteContent("svc-b/foo", "b"))
+	check(t, repo.Commit("third"))
+
+	m, err := NewWorld(t, ".tmp/repo").System.Affected("master", "feature", AffectedBuilds)
+	check(t, err)
+
+	assert.Equal(t, []string{"lib-a", "svc-a"}, moduleNames(m.Modules))
+}
+
+func TestAffectedWithSelectorInConfig(t *testing.T) {
+	repo, base := initAffectedRepo(t)
+	dependents := false
+	check(t, repo.WriteConfig(&RepoConfig{Affected: map[string]*AffectedSelector{
+		AffectedTests: {Dependents: &dependents},
+		"docs":        {Query: `name =~ "^svc-"`},
+	}}))
+	check(t, repo.Commit("third"))
+
+	system := NewWorld(t, ".tmp/repo").System
+	m, err := system.Affected(base, "HEAD", AffectedTests)
+	check(t, err)
+	assert.Equal(t, []string{"lib-a"}, moduleNames(m.Modules))
+
+	m, err = system.Affected(base, "HEAD", "docs")
+	check(t, err)
+	assert.Equal(t, []string{"svc-a"}, moduleNames(m.Modules))
+}
+
+func TestAffectedWithUnknownPurpose(t *testing.T) {
+	_, base := initAffectedRepo(t)
+
+	_, err := NewWorld(t, ".tmp/repo").System.Affected(base, "HEAD", "docs")
+
+	assert.EqualError(t, err, fmt.Sprintf(msgUnknownAffectedPurpose, "docs"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestAffectedWithInvalidQueryInConfig(t *testing.T) {
+	repo, base := initAffectedRepo(t)
+	check(t, repo.WriteConfig(&RepoConfig{Affected: map[string]*AffectedSelector{
+		AffectedTests: {Query: `foo ==`},
+	}}))
+	check(t, repo.Commit("third"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.Affected(base, "HEAD", AffectedTests)
+
+	assert.Error(t, err)
+	assert.Equal(t, ExitCodeConfigError, ExitCode(err))
+}
+
+func TestAffectedWithUnknownRevision(t *testing.T) {
+	_, base := initAffectedRepo(t)
+
+	_, err := NewWorld(t, ".tmp/repo").System.Affected(base, "missing", AffectedBuilds)
+
+	assert.EqualError(t, err, fmt.Sprintf(msgRevisionNotFound, "missing"))
+}
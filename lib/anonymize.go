@@ -0,0 +1,162 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Anonymizer replaces the names, paths and hashes of modules with
+// values derived from their keyed hashes, so that a module graph can be
+// shared (e.g. in a bug report or a benchmark) without revealing the
+// names of projects in the repository.
+// Structure of the graph is preserved: dependencies, file dependencies,
+// nesting of module directories and the platforms modules are built on.
+// Properties, commands and other attributes of specs are removed.
+// Same value is always replaced with the same value by an Anonymizer,
+// therefore anonymized manifests of a repository can be compared.
+type Anonymizer struct {
+	salt []byte
+}
+
+// NewAnonymizer creates an Anonymizer using salt as the key of the
+// hashes.
+// Anonymized values can be reproduced with the same salt. Without a
+// salt, original values could be found by hashing guessed names,
+// therefore a random salt should be used unless the values must be
+// reproduced.
+func NewAnonymizer(salt string) *Anonymizer {
+	return &Anonymizer{salt: []byte(salt)}
+}
+
+// Manifest returns an anonymized copy of the manifest. Dependencies of
+// the modules that are not in the manifest are anonymized as well.
+func (a *Anonymizer) Manifest(m *Manifest) (*Manifest, error) {
+	mods, err := a.Modules(m.Modules)
+	if err != nil {
+		return nil, err
+	}
+
+	sha := m.Sha
+	if sha != "" && sha != "local" {
+		sha = a.hash("sha", sha, 40)
+	}
+
+	return &Manifest{Sha: sha, Modules: mods}, nil
+}
+
+// Modules returns the anonymized copies of modules in the same order.
+func (a *Anonymizer) Modules(mods Modules) (Modules, error) {
+	graph, err := mods.expandRequiresDependencies()
+	if err != nil {
+		return nil, err
+	}
+
+	// Graph is in topological order, therefore dependencies of a
+	// module are always anonymized before the module.
+	index := make(map[string]*Module, len(graph))
+	for _, m := range graph {
+		requires := make(Modules, 0, len(m.Requires()))
+		for _, r := range m.Requires() {
+			requires = append(requires, index[r.Name()])
+		}
+
+		anonymized := newModule(a.metadata(m.metadata), requires)
+		anonymized.version = a.version(m.Version())
+		index[m.Name()] = anonymized
+	}
+
+	r := make(Modules, 0, len(mods))
+	for _, m := range mods {
+		r = append(r, index[m.Name()])
+	}
+	return r, nil
+}
+
+func (a *Anonymizer) metadata(m *moduleMetadata) *moduleMetadata {
+	spec := &Spec{
+		Name:             a.Name(m.spec.Name),
+		Build:            make(map[string]*Cmd, len(m.spec.Build)),
+		Properties:       make(map[string]interface{}),
+		Dependencies:     make([]string, 0, len(m.spec.Dependencies)),
+		FileDependencies: make([]string, 0, len(m.spec.FileDependencies)),
+	}
+
+	// Platforms are retained since they decide the modules built on
+	// each platform.
+	for platform := range m.spec.Build {
+		spec.Build[platform] = &Cmd{Cmd: "build"}
+	}
+
+	for _, d := range m.spec.Dependencies {
+		spec.Dependencies = append(spec.Dependencies, a.Name(d))
+	}
+
+	fileHashes := make(map[string]string, len(m.dependentFileHashes))
+	for _, f := range m.spec.FileDependencies {
+		p := a.Path(f)
+		spec.FileDependencies = append(spec.FileDependencies, p)
+		if h, ok := m.dependentFileHashes[f]; ok {
+			fileHashes[p] = a.hash("hash", h, 40)
+		}
+	}
+
+	return newModuleMetadata(a.Path(m.dir), a.version(m.hash), spec, fileHashes)
+}
+
+// Name returns the anonymized name of a module.
+func (a *Anonymizer) Name(name string) string {
+	return "module-" + a.hash("name", name, 12)
+}
+
+// Path returns the anonymized path of a module directory or a file.
+// Each directory in the path is anonymized separately, so that the
+// modules in the same directory are still in the same directory.
+func (a *Anonymizer) Path(p string) string {
+	if p == "" {
+		return p
+	}
+
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = a.hash("path", s, 8)
+	}
+	return strings.Join(segments, "/")
+}
+
+// version returns the anonymized version (or hash) of a module.
+// Versions of local modules are not hashed since they do not reveal
+// anything.
+func (a *Anonymizer) version(v string) string {
+	if v == "local" {
+		return v
+	}
+	return a.hash("hash", v, 40)
+}
+
+// hash returns the first n hex digits of the keyed hash of a value.
+// Hashes of different kinds of values are distinct even if the values
+// are the same.
+func (a *Anonymizer) hash(kind, value string, n int) string {
+	h := hmac.New(sha256.New, a.salt)
+	h.Write([]byte(kind))
+	h.Write([]byte{0})
+	h.Write([]byte(value))
+	return hex.EncodeToString(h.Sum(nil))[:n]
+}
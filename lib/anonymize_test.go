@@ -0,0 +1,102 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnonymizeManifest(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("apps/app-a", &Spec{
+		Name:             "app-a",
+		Properties:       map[string]interface{}{"owner": "secret-team"},
+		FileDependencies: []string{"libs/shared"},
+	}))
+	check(t, repo.InitModuleWithOptions("apps/app-b", &Spec{Name: "app-b", Dependencies: []string{"app-a"}}))
+	check(t, repo.WriteContent("libs/shared/a.go", "a"))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	m, err := world.System.ManifestByCurrentBranch()
+	check(t, err)
+
+	a := NewAnonymizer("salt")
+	anonymized, err := a.Manifest(m)
+	check(t, err)
+
+	assert.NotEqual(t, m.Sha, anonymized.Sha)
+	assert.Len(t, anonymized.Modules, 2)
+
+	appA := anonymized.Modules.indexByName()[a.Name("app-a")]
+	appB := anonymized.Modules.indexByName()[a.Name("app-b")]
+	assert.NotNil(t, appA)
+	assert.NotNil(t, appB)
+
+	assert.Equal(t, a.Path("apps/app-a"), appA.Path())
+	assert.Equal(t, strings.Split(appA.Path(), "/")[0], strings.Split(appB.Path(), "/")[0])
+	assert.Equal(t, []string{a.Path("libs/shared")}, appA.FileDependencies())
+	assert.Empty(t, appA.Properties())
+	assert.Equal(t, Modules{appA}, appB.Requires())
+	assert.Equal(t, Modules{appB}, appA.RequiredBy())
+	assert.NotEqual(t, m.Modules[0].Version(), anonymized.Modules[0].Version())
+
+	buff := new(bytes.Buffer)
+	check(t, anonymized.Export(buff))
+	for _, s := range []string{"app-a", "app-b", "apps", "libs", "shared", "secret-team", "./build.sh", m.Sha} {
+		assert.NotContains(t, buff.String(), s)
+	}
+
+	imported, err := importManifest(buff, "/repo")
+	check(t, err)
+	assert.Equal(t, moduleNames(anonymized.Modules), moduleNames(imported.Modules))
+	assert.Equal(t, imported.Modules[0], imported.Modules[1].Requires()[0])
+}
+
+func TestAnonymizeWithSalt(t *testing.T) {
+	a := NewAnonymizer("a")
+
+	assert.Equal(t, a.Name("app-a"), NewAnonymizer("a").Name("app-a"))
+	assert.NotEqual(t, a.Name("app-a"), NewAnonymizer("b").Name("app-a"))
+	assert.NotEqual(t, a.Name("app-a"), a.Name("app-b"))
+	assert.NotEqual(t, a.Path("app-a"), a.Name("app-a"))
+	assert.Equal(t, "", a.Path(""))
+	assert.Equal(t, "local", a.version("local"))
+}
+
+func TestAnonymizeLocalModules(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	m, err := world.System.ManifestByWorkspace()
+	check(t, err)
+
+	anonymized, err := NewAnonymizer("salt").Manifest(m)
+	check(t, err)
+
+	assert.Equal(t, "local", anonymized.Sha)
+	assert.Equal(t, "local", anonymized.Modules[0].Version())
+}
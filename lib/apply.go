@@ -16,6 +16,7 @@ limitations under the License.
 package lib
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -31,11 +32,70 @@ import (
 
 // TemplateData is the data passed into template.
 type TemplateData struct {
+	// Module is the module the template is rendered for when rendering
+	// the template for each module.
+	Module      *Module
+	Environment string
 	Args        map[string]interface{}
 	Sha         string
 	Env         map[string]string
 	Modules     map[string]*Module
 	ModulesList []*Module
+	// Release is the release plan being applied (see ApplyOptions.Release).
+	Release *ReleasePlan
+}
+
+// ApplyOptions specifies how a template is applied.
+type ApplyOptions struct {
+	// SplitPerModule renders the template once for each module matching
+	// the Filter. Current module is available in .Module.
+	SplitPerModule bool
+	// Filter selects the modules the template is rendered for when
+	// SplitPerModule is set. All modules are selected if it is nil.
+	Filter *FilterOptions
+	// Engine used to render the template (go, jsonnet or cue).
+	// Defaults to the engine associated with the extension of the
+	// template or go.
+	Engine string
+	// Template is read from this reader instead of the template path
+	// when specified (e.g. to apply a template from stdin). Template path
+	// is still used to select the template engine.
+	Template io.Reader
+	// Environment is the name of the environment whose overlays are
+	// merged over the module specs. It is available in .Environment.
+	Environment string
+	// Values is the list of yaml files merged over the properties of
+	// each module for the duration of rendering. Later files override
+	// the values in earlier files.
+	Values []string
+	// EnvironmentsFile is a yaml file with the values of each environment
+	// under the name of the environment. Values of the Environment are
+	// merged over the values in Values and Set is merged over them.
+	EnvironmentsFile string
+	// AllEnvironments renders the template once for each environment in
+	// EnvironmentsFile, in the order of their names, instead of just
+	// for the Environment.
+	AllEnvironments bool
+	// Set is the list of key=value pairs merged over the values.
+	Set []string
+	// Strict fails rendering of go templates referencing missing keys,
+	// modules or module properties.
+	Strict bool
+	// Funcs are the additional functions available to go templates.
+	// They take precedence over the built-in functions and the functions
+	// in templateFuncs of the repository config.
+	Funcs template.FuncMap
+	// Release is the release plan available in .Release. Template is
+	// rendered just for the modules in the plan when SplitPerModule is set.
+	Release *ReleasePlan
+	// Output creates the writer for the output of the template.
+	// mod is the module the template is rendered for or nil if
+	// SplitPerModule is not set.
+	Output func(mod *Module) (io.WriteCloser, error)
+	// EnvironmentOutput creates the writer for the output of the template
+	// rendered for the environment when AllEnvironments is set.
+	// Output is used if it is nil.
+	EnvironmentOutput func(environment string, mod *Module) (io.WriteCloser, error)
 }
 
 // KVP is a key value pair.
@@ -73,41 +133,71 @@ func (m modulesByNameSorter) Less(i, j int) bool {
 }
 
 func (s *stdSystem) ApplyBranch(templatePath, branch string, output io.Writer) error {
+	return s.ApplyBranchWithOptions(templatePath, branch, writerApplyOptions(output))
+}
+
+func (s *stdSystem) ApplyCommit(commit string, templatePath string, output io.Writer) error {
+	return s.ApplyCommitWithOptions(commit, templatePath, writerApplyOptions(output))
+}
+
+// ApplyHead applies the repository manifest to specified template.
+func (s *stdSystem) ApplyHead(templatePath string, output io.Writer) error {
+	return s.ApplyHeadWithOptions(templatePath, writerApplyOptions(output))
+}
+
+func (s *stdSystem) ApplyLocal(templatePath string, output io.Writer) error {
+	return s.ApplyLocalWithOptions(templatePath, writerApplyOptions(output))
+}
+
+func (s *stdSystem) ApplyBranchWithOptions(templatePath, branch string, options *ApplyOptions) error {
 	commit, err := s.Repo.BranchCommit(branch)
 	if err != nil {
 		return err
 	}
-	return s.applyCore(commit, templatePath, output)
+	return s.applyCore(commit, templatePath, options)
 }
 
-func (s *stdSystem) ApplyCommit(commit string, templatePath string, output io.Writer) error {
+func (s *stdSystem) ApplyCommitWithOptions(commit string, templatePath string, options *ApplyOptions) error {
 	c, err := s.Repo.GetCommit(commit)
 	if err != nil {
 		return err
 	}
-	return s.applyCore(c, templatePath, output)
+	return s.applyCore(c, templatePath, options)
 }
 
-// ApplyHead applies the repository manifest to specified template.
-func (s *stdSystem) ApplyHead(templatePath string, output io.Writer) error {
+func (s *stdSystem) ApplyHeadWithOptions(templatePath string, options *ApplyOptions) error {
+	if detached, err := s.Repo.IsHeadDetached(); err == nil && detached {
+		c, err := s.Repo.CurrentBranchCommit()
+		if err != nil {
+			return err
+		}
+		return s.applyCore(c, templatePath, options)
+	}
+
 	branch, err := s.Repo.CurrentBranch()
 	if err != nil {
 		return err
 	}
 
-	return s.ApplyBranch(templatePath, branch, output)
+	return s.ApplyBranchWithOptions(templatePath, branch, options)
 }
 
-func (s *stdSystem) ApplyLocal(templatePath string, output io.Writer) error {
+func (s *stdSystem) ApplyLocalWithOptions(templatePath string, options *ApplyOptions) error {
 	absDir, err := filepath.Abs(s.Repo.Path())
 	if err != nil {
 		return e.Wrapf(ErrClassUser, err, msgFailedLocalPath, s.Repo.Path())
 	}
 
-	absTemplatePath := filepath.Join(absDir, templatePath)
-	c, err := ioutil.ReadFile(absTemplatePath)
+	config, partials, err := partialsInWorkspace(absDir)
 	if err != nil {
-		return e.Wrapf(ErrClassUser, err, msgFailedReadFile, absTemplatePath)
+		return err
+	}
+
+	t, err := s.loadTemplate(config, partials, templatePath, options, func() ([]byte, error) {
+		return readLocalTemplate(absDir, templatePath)
+	})
+	if err != nil {
+		return err
 	}
 
 	m, err := s.ManifestBuilder().ByWorkspace()
@@ -115,13 +205,90 @@ func (s *stdSystem) ApplyLocal(templatePath string, output io.Writer) error {
 		return err
 	}
 
-	return processTemplate(c, m, output)
+	return applyTemplate(t.path, t.content, m, config, t.partials, options)
+}
+
+// templateFile is a template and the partials available to it.
+type templateFile struct {
+	path     string
+	content  []byte
+	partials []*partial
+}
+
+// loadTemplate reads the template from options.Template if specified.
+// Otherwise, it is read from the template source templatePath refers to
+// or by calling read. Partials of a template source are available to
+// its templates along with the partials of the repository, which take
+// precedence. Partials embedded in the binary are available to all
+// templates.
+func (s *stdSystem) loadTemplate(config *RepoConfig, partials []*partial, templatePath string, options *ApplyOptions, read func() ([]byte, error)) (*templateFile, error) {
+	if options.Template != nil {
+		c, err := ioutil.ReadAll(options.Template)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgFailedReadTemplate, templatePath)
+		}
+
+		return &templateFile{path: templatePath, content: c, partials: append(defaultPartials(), partials...)}, nil
+	}
+
+	t, err := s.templateFromSource(config, templatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if t != nil {
+		t.partials = append(append(defaultPartials(), t.partials...), partials...)
+		return t, nil
+	}
+
+	c, err := read()
+	if err != nil {
+		return nil, err
+	}
+
+	return &templateFile{path: templatePath, content: c, partials: append(defaultPartials(), partials...)}, nil
 }
 
-func (s *stdSystem) applyCore(commit Commit, templatePath string, output io.Writer) error {
-	b, err := s.Repo.BlobContentsFromTree(commit, templatePath)
+// readLocalTemplate reads the template from the workspace unless the
+// path is absolute, in which case it can be outside the repository.
+func readLocalTemplate(dir, templatePath string) ([]byte, error) {
+	if !filepath.IsAbs(templatePath) {
+		templatePath = filepath.Join(dir, templatePath)
+	}
+
+	c, err := ioutil.ReadFile(templatePath)
 	if err != nil {
-		return e.Wrapf(ErrClassUser, err, msgTemplateNotFound, templatePath, commit)
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedReadFile, templatePath)
+	}
+
+	return c, nil
+}
+
+// templateInCommit reads the template in a commit.
+func (s *stdSystem) templateInCommit(commit Commit, templatePath string, options *ApplyOptions) (*RepoConfig, *templateFile, error) {
+	config, partials, err := s.partialsInCommit(commit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t, err := s.loadTemplate(config, partials, templatePath, options, func() ([]byte, error) {
+		b, err := s.Repo.BlobContentsFromTree(commit, templatePath)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgTemplateNotFound, templatePath, commit)
+		}
+		return b, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return config, t, nil
+}
+
+func (s *stdSystem) applyCore(commit Commit, templatePath string, options *ApplyOptions) error {
+	config, t, err := s.templateInCommit(commit, templatePath, options)
+	if err != nil {
+		return err
 	}
 
 	m, err := s.MB.ByCommit(commit)
@@ -129,25 +296,211 @@ func (s *stdSystem) applyCore(commit Commit, templatePath string, output io.Writ
 		return err
 	}
 
-	return processTemplate(b, m, output)
+	return applyTemplate(t.path, t.content, m, config, t.partials, options)
 }
 
-func processTemplate(buffer []byte, m *Manifest, output io.Writer) error {
+// writerApplyOptions creates the options to write the output of a
+// template to w.
+func writerApplyOptions(w io.Writer) *ApplyOptions {
+	return &ApplyOptions{
+		Output: func(*Module) (io.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		},
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// applyTemplate renders the template once, or once for each module
+// matching the filter if options.SplitPerModule is set.
+func applyTemplate(templatePath string, buffer []byte, m *Manifest, config *RepoConfig, partials []*partial, options *ApplyOptions) error {
+	if options.AllEnvironments {
+		return applyAllEnvironments(templatePath, buffer, m, config, partials, options)
+	}
+
+	engine, err := templateEngineFor(templatePath, options.Engine)
+	if err != nil {
+		return err
+	}
+
+	m, err = m.withEnvironment(options.Environment)
+	if err != nil {
+		return err
+	}
+
+	envValues, err := environmentValues(options)
+	if err != nil {
+		return err
+	}
+
+	values, err := loadValues(options.Values, envValues, options.Set)
+	if err != nil {
+		return err
+	}
+
+	modules, err := m.Modules.withProperties(values)
+	if err != nil {
+		return err
+	}
+	m = &Manifest{Dir: m.Dir, Sha: m.Sha, Modules: modules}
+
+	if err := validateProperties(config.PropertiesSchema, m.Modules); err != nil {
+		return err
+	}
+
+	validators, err := validatorsFor(config, templatePath)
+	if err != nil {
+		return err
+	}
+
+	options, err = withTemplateFuncs(options, config, m.Dir)
+	if err != nil {
+		return err
+	}
+
+	if !options.SplitPerModule {
+		return renderTemplate(engine, templatePath, buffer, m, nil, partials, validators, options)
+	}
+
+	filter := options.Filter
+	if filter == nil {
+		filter = NoFilter
+	}
+
+	filtered, err := m.ApplyFilters(filter)
+	if err != nil {
+		return err
+	}
+
+	for _, mod := range filtered.Modules {
+		if options.Release != nil && options.Release.Module(mod.Name()) == nil {
+			continue
+		}
+
+		err = renderTemplate(engine, templatePath, buffer, m, mod, partials, validators, options)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyAllEnvironments applies the template for each environment in
+// options.EnvironmentsFile.
+func applyAllEnvironments(templatePath string, buffer []byte, m *Manifest, config *RepoConfig, partials []*partial, options *ApplyOptions) error {
+	if options.EnvironmentsFile == "" {
+		return e.NewError(ErrClassUser, msgAllEnvironmentsWithoutValues)
+	}
+
+	envs, err := loadEnvironmentValues(options.EnvironmentsFile)
+	if err != nil {
+		return err
+	}
+
+	if len(envs) == 0 {
+		return e.NewErrorf(ErrClassUser, msgNoEnvironmentsInValues, options.EnvironmentsFile)
+	}
+
+	names := make([]string, 0, len(envs))
+	for env := range envs {
+		names = append(names, env)
+	}
+	sort.Strings(names)
+
+	for _, env := range names {
+		envOptions := *options
+		envOptions.Environment = env
+		envOptions.AllEnvironments = false
+		if options.EnvironmentOutput != nil {
+			env := env
+			envOptions.Output = func(mod *Module) (io.WriteCloser, error) {
+				return options.EnvironmentOutput(env, mod)
+			}
+		}
+
+		if err := applyTemplate(templatePath, buffer, m, config, partials, &envOptions); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// renderTemplate renders the template and validates the output before
+// writing it, so that invalid output is never written.
+func renderTemplate(engine templateEngine, templatePath string, buffer []byte, m *Manifest, mod *Module, partials []*partial, validators []*Validator, options *ApplyOptions) error {
+	var rendered bytes.Buffer
+	if err := engine(templatePath, buffer, m, mod, partials, options, &rendered); err != nil {
+		return err
+	}
+
+	if err := validateOutput(validators, templatePath, m, mod, rendered.Bytes()); err != nil {
+		return err
+	}
+
+	w, err := options.Output(mod)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(rendered.Bytes())
+	if err != nil {
+		err = e.Wrap(ErrClassInternal, err)
+	}
+	if cerr := w.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func processTemplate(templatePath string, buffer []byte, m *Manifest, mod *Module, partials []*partial, options *ApplyOptions, output io.Writer) error {
 	modulesIndex := m.Modules.indexByName()
 	sortedModules := make(modulesByNameSorter, len(m.Modules))
 	copy(sortedModules, m.Modules)
 	sort.Sort(sortedModules)
 
-	temp, err := template.New("template").Funcs(template.FuncMap{
-		"module": func(n string) *Module {
-			return modulesIndex[n]
+	temp := template.New("template")
+	if options.Strict {
+		temp = temp.Option("missingkey=error")
+	}
+	temp, err := temp.Funcs(sprigFuncs()).Funcs(graphFuncs(m.Modules, options.Strict)).Funcs(template.FuncMap{
+		"include": func(name string, data interface{}) (string, error) {
+			buff := new(bytes.Buffer)
+			err := temp.ExecuteTemplate(buff, name, data)
+			return buff.String(), err
+		},
+		"module": func(n string) (*Module, error) {
+			mod, ok := modulesIndex[n]
+			if !ok && options.Strict {
+				return nil, e.NewErrorf(ErrClassUser, msgModuleNotFound, n)
+			}
+
+			return mod, nil
 		},
-		"property": func(m *Module, n string) interface{} {
+		"property": func(m *Module, n string) (interface{}, error) {
 			if m == nil {
-				return nil
+				if options.Strict {
+					return nil, e.NewErrorf(ErrClassUser, msgPropertyOfNilModule, n)
+				}
+				return nil, nil
+			}
+
+			v := resolveProperty(m.Properties(), strings.Split(n, "."), missingProperty{})
+			if _, missing := v.(missingProperty); missing {
+				if options.Strict {
+					return nil, e.NewErrorf(ErrClassUser, msgPropertyNotFound, n, m.Name())
+				}
+				return nil, nil
 			}
 
-			return resolveProperty(m.Properties(), strings.Split(n, "."), nil)
+			return v, nil
 		},
 		"propertyOr": func(m *Module, n string, def interface{}) interface{} {
 			if m == nil {
@@ -200,18 +553,6 @@ func processTemplate(buffer []byte, m *Manifest, output io.Writer) error {
 			sort.Sort(kvpSoter(l))
 			return l
 		},
-		"add": func(a, b int) int {
-			return a + b
-		},
-		"sub": func(a, b int) int {
-			return a - b
-		},
-		"mul": func(a, b int) int {
-			return a * b
-		},
-		"div": func(a, b int) int {
-			return a / b
-		},
 		"istail": func(array, value interface{}) bool {
 			if array == nil {
 				return false
@@ -282,21 +623,34 @@ func processTemplate(buffer []byte, m *Manifest, output io.Writer) error {
 
 			return arrayVal.Index(arrayVal.Len() - 1).Interface()
 		},
-	}).Parse(string(buffer))
+	}).Funcs(options.Funcs).Parse(string(buffer))
 	if err != nil {
 		return e.Wrapf(ErrClassUser, err, msgFailedTemplateParse)
 	}
 
+	for _, p := range partials {
+		if _, err := temp.New(p.name).Parse(string(p.content)); err != nil {
+			return e.Wrapf(ErrClassUser, err, msgFailedPartialParse, p.name)
+		}
+	}
+
 	data := &TemplateData{
+		Module:      mod,
+		Environment: options.Environment,
 		Sha:         m.Sha,
 		Env:         getEnvMap(),
 		Modules:     m.Modules.indexByName(),
 		ModulesList: sortedModules,
+		Release:     options.Release,
 	}
 
 	return temp.Execute(output, data)
 }
 
+// missingProperty is the default value used to distinguish missing
+// properties from properties with a nil value.
+type missingProperty struct{}
+
 func resolveProperty(in interface{}, path []string, def interface{}) interface{} {
 	if in == nil || len(path) == 0 {
 		return def
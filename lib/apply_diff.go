@@ -0,0 +1,164 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+func (s *stdSystem) ApplyDiff(from, to, templatePath string, options *ApplyOptions, output io.Writer) error {
+	changed, err := s.ManifestByDiff(from, to)
+	if err != nil {
+		return err
+	}
+
+	fromCommit, err := s.Repo.GetCommit(from)
+	if err != nil {
+		return err
+	}
+
+	toCommit, err := s.Repo.GetCommit(to)
+	if err != nil {
+		return err
+	}
+
+	fromManifest, err := s.MB.ByCommit(fromCommit)
+	if err != nil {
+		return err
+	}
+
+	toManifest, err := s.MB.ByCommit(toCommit)
+	if err != nil {
+		return err
+	}
+
+	// Modules removed between the commits are not in the diff manifest
+	// but their output is removed as well.
+	names := make(map[string]bool)
+	for _, m := range changed.Modules {
+		names[m.Name()] = true
+	}
+
+	current := toManifest.Modules.indexByName()
+	for _, m := range fromManifest.Modules {
+		if _, ok := current[m.Name()]; !ok {
+			names[m.Name()] = true
+		}
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	sorted := make([]string, 0, len(names))
+	for n := range names {
+		sorted = append(sorted, n)
+	}
+	sort.Strings(sorted)
+
+	// Template specified in options is rendered at both commits,
+	// therefore it is read once.
+	var content []byte
+	if options.Template != nil {
+		content, err = ioutil.ReadAll(options.Template)
+		if err != nil {
+			return e.Wrapf(ErrClassUser, err, msgFailedReadTemplate, templatePath)
+		}
+	}
+
+	before, err := s.renderModules(fromCommit, fromManifest, templatePath, content, sorted, options)
+	if err != nil {
+		return err
+	}
+
+	after, err := s.renderModules(toCommit, toManifest, templatePath, content, sorted, options)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range sorted {
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        splitLines(before[n]),
+			B:        splitLines(after[n]),
+			FromFile: "a/" + n,
+			ToFile:   "b/" + n,
+			Context:  3,
+		})
+		if err != nil {
+			return e.Wrapf(ErrClassInternal, err, msgFailedRenderedDiff, n)
+		}
+
+		if _, err := io.WriteString(output, diff); err != nil {
+			return e.Wrap(ErrClassInternal, err)
+		}
+	}
+
+	return nil
+}
+
+// renderModules renders the template in the commit (or content if it
+// is not nil) for each of the specified modules and returns the output
+// keyed by module name.
+func (s *stdSystem) renderModules(commit Commit, m *Manifest, templatePath string, content []byte, names []string, options *ApplyOptions) (map[string]string, error) {
+	renderOptions := *options
+	if content != nil {
+		renderOptions.Template = bytes.NewReader(content)
+	}
+
+	config, t, err := s.templateInCommit(commit, templatePath, &renderOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make(map[string]*bytes.Buffer)
+	renderOptions.SplitPerModule = true
+	renderOptions.Filter = &FilterOptions{Name: strings.Join(names, ",")}
+	renderOptions.Output = func(mod *Module) (io.WriteCloser, error) {
+		buff := new(bytes.Buffer)
+		outputs[mod.Name()] = buff
+		return nopWriteCloser{buff}, nil
+	}
+
+	if err := applyTemplate(t.path, t.content, m, config, t.partials, &renderOptions); err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedRenderAtCommit, commit)
+	}
+
+	r := make(map[string]string, len(outputs))
+	for n, buff := range outputs {
+		r[n] = buff.String()
+	}
+
+	return r, nil
+}
+
+// splitLines splits s into lines retaining the line endings expected
+// by difflib. Unlike difflib.SplitLines, it does not add an empty line
+// when s ends with a line ending.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+
+	lines[len(lines)-1] += "\n"
+	return lines
+}
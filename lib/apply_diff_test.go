@@ -0,0 +1,73 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyDiff(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Properties: map[string]interface{}{"replicas": 1}}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Properties: map[string]interface{}{"replicas": 1}}))
+	check(t, repo.InitModuleWithOptions("app-c", &Spec{Name: "app-c"}))
+	check(t, repo.WriteContent("template.tmpl", "name: {{.Module.Name}}\nreplicas: {{property .Module \"replicas\"}}\n"))
+	check(t, repo.Commit("first"))
+	from := repo.LastCommit.String()
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Properties: map[string]interface{}{"replicas": 3}}))
+	check(t, repo.WriteContent("app-b/README.md", "changed"))
+	check(t, repo.Remove("app-c"))
+	check(t, repo.Commit("second"))
+
+	output := new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyDiff(from, repo.LastCommit.String(), "template.tmpl", &ApplyOptions{}, output))
+
+	assert.Equal(t, `--- a/app-a
++++ b/app-a
+@@ -1,2 +1,2 @@
+ name: app-a
+-replicas: 1
++replicas: 3
+--- a/app-c
++++ b/app-c
+@@ -1,2 +0,0 @@
+-name: app-c
+-replicas: <no value>
+`, output.String())
+}
+
+func TestApplyDiffWithoutChanges(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent("template.tmpl", "{{.Module.Name}}"))
+	check(t, repo.Commit("first"))
+	from := repo.LastCommit.String()
+
+	check(t, repo.WriteContent("template.tmpl", "{{.Module.Name}} changed"))
+	check(t, repo.Commit("second"))
+
+	output := new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyDiff(from, repo.LastCommit.String(), "template.tmpl", &ApplyOptions{}, output))
+
+	assert.Equal(t, "", output.String())
+}
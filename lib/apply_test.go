@@ -19,8 +19,12 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/mbtproject/mbt/e"
@@ -388,3 +392,601 @@ func TestTheOrderOfModulesList(t *testing.T) {
 
 	assert.Equal(t, "app-a,app-b,app-c,\n", output.String())
 }
+
+func TestSprigTemplateFuncs(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name: "app-a",
+		Properties: map[string]interface{}{
+			"tags": []string{"b", "a", "b"},
+			"labels": map[string]interface{}{
+				"team": "core",
+				"tier": "web",
+			},
+			"name": "HelloWorld app",
+		},
+	}))
+	check(t, repo.Commit("first"))
+
+	cases := []TC{
+		{Template: `{{- upper "abc" | repeat 2}}`, Expected: "ABCABC"},
+		{Template: `{{- trim "  abc " | quote}}`, Expected: `"abc"`},
+		{Template: `{{- "abc" | trimPrefix "a" | trimSuffix "c" | squote}}`, Expected: "'b'"},
+		{Template: `{{- replace "-" "_" "a-b-c" | trunc 3}}`, Expected: "a_b"},
+		{Template: `{{- substr 1 3 "abcd"}}`, Expected: "bc"},
+		{Template: `{{- property (module "app-a") "name" | snakecase}}`, Expected: "hello_world_app"},
+		{Template: `{{- property (module "app-a") "name" | kebabcase}}`, Expected: "hello-world-app"},
+		{Template: `{{- "hello_world" | camelcase}}`, Expected: "HelloWorld"},
+		{Template: `{{- cat "a" 1 nil "b"}}`, Expected: "a 1 b"},
+		{Template: `{{- "a\nb" | indent 2}}`, Expected: "  a\n  b"},
+		{Template: `x:{{- "a: 1" | nindent 2}}`, Expected: "x:\n  a: 1"},
+		{Template: `{{- splitList "," "a,b" | toJson}}`, Expected: `["a","b"]`},
+		{Template: `{{- default "x" ""}}/{{default "x" "y"}}`, Expected: "x/y"},
+		{Template: `{{- coalesce "" nil "z"}} {{empty list}} {{ternary "a" "b" false}}`, Expected: "z true b"},
+		{Template: `{{- add 1 2 3}} {{add1 1}} {{mod 7 3}} {{max 1 5 3}} {{min 4 2}} {{atoi "5" | mul 2}}`, Expected: "6 2 1 5 2 10"},
+		{Template: `{{- range until 3}}{{.}}{{end}}`, Expected: "012"},
+		{Template: `{{- list 1 2 3 | rest | toJson}} {{list 1 2 3 | initial | toJson}} {{list 1 2 3 | first}}{{list 1 2 3 | last}}`, Expected: "[2,3] [1,2] 13"},
+		{Template: `{{- property (module "app-a") "tags" | uniq | sortAlpha | toJson}}`, Expected: `["a","b"]`},
+		{Template: `{{- if has "a" (property (module "app-a") "tags")}}yes{{end}}`, Expected: "yes"},
+		{Template: `{{- without (list 1 2 3) 2 | reverse | toJson}} {{compact (list "a" "" "b") | toJson}}`, Expected: `[3,1] ["a","b"]`},
+		{Template: `{{- prepend (append (list 1) 2) 0 | toJson}} {{concat (list 1) (list 2 3) | toJson}}`, Expected: "[0,1,2] [1,2,3]"},
+		{Template: `{{- $d := dict "a" 1 "b" 2}}{{set $d "c" 3 | keys | toJson}} {{get $d "a"}} {{hasKey $d "z"}} {{unset $d "c" | values | toJson}}`, Expected: `["a","b","c"] 1 false [1,2]`},
+		{Template: `{{- pick (dict "a" 1 "b" 2) "a" | toJson}} {{omit (dict "a" 1 "b" 2) "a" | toJson}}`, Expected: `{"a":1} {"b":2}`},
+		{Template: `{{- merge (dict "a" 1 "n" (dict "x" 1)) (dict "a" 2 "b" 3 "n" (dict "y" 2)) | toJson}}`, Expected: `{"a":1,"b":3,"n":{"x":1,"y":2}}`},
+		{Template: `{{- regexMatch "^a.c$" "abc"}} {{regexFind "[0-9]+" "ab12cd34"}} {{regexFindAll "[0-9]+" "ab12cd34" -1 | toJson}}`, Expected: `true 12 ["12","34"]`},
+		{Template: `{{- regexReplaceAll "[0-9]" "a1b2" "_"}} {{regexSplit "[,;]" "a,b;c" -1 | toJson}}`, Expected: `a_b_ ["a","b","c"]`},
+		{Template: `{{- b64enc "mbt"}} {{b64enc "mbt" | b64dec}} {{sha256sum "mbt" | trunc 8}}`, Expected: "bWJ0 mbt dbf8aa57"},
+		{Template: `{{- property (module "app-a") "labels" | toYaml}}`, Expected: "team: core\ntier: web"},
+		{Template: `{{- (fromYaml "a: [1, 2]").a | toJson}} {{(fromJson "{\"a\": \"b\"}").a}}`, Expected: "[1,2] b"},
+		{Template: `{{- property (module "app-a") "labels" | toPrettyJson}}`, Expected: "{\n  \"team\": \"core\",\n  \"tier\": \"web\"\n}"},
+		{Template: `{{- sha1sum "mbt" | trunc 8}}`, Expected: "152ba11b"},
+		{Template: `{{- env "MBT_TEMPLATE_FUNC"}} {{expandenv "cluster-${MBT_TEMPLATE_FUNC}"}} {{env "MBT_TEMPLATE_FUNC_UNSET" | default "none"}}`, Expected: "eu cluster-eu none"},
+		{Template: `{{- $v := semver "v1.2.3-beta.1+abc"}}{{$v.Major}} {{$v.Minor}} {{$v.Patch}} {{$v.Prerelease}} {{$v.Metadata}} {{$v}}`, Expected: "1 2 3 beta.1 abc 1.2.3-beta.1+abc"},
+		{Template: `{{- semverCompare ">= 1.2, < 2" "1.10.0"}} {{semverCompare "^1.2" "2.0.0"}} {{semverCompare "~1.2.3 || >=3" "1.2.9"}}`, Expected: "true false true"},
+		{Template: `{{- semverCompare "<1.0.0" "1.0.0-rc.1"}} {{semverCompare ">1.0.0-rc.2" "1.0.0-rc.10"}} {{semverCompare "^0.2.3" "0.3.0"}} {{semverCompare "!=1.2" "v1.2.0"}}`, Expected: "true true false false"},
+	}
+
+	os.Setenv("MBT_TEMPLATE_FUNC", "eu")
+	defer os.Unsetenv("MBT_TEMPLATE_FUNC")
+
+	for _, c := range cases {
+		check(t, repo.WriteContent("template.tmpl", c.Template))
+
+		output := new(bytes.Buffer)
+		err := NewWorld(t, ".tmp/repo").System.ApplyLocal("template.tmpl", output)
+		check(t, err)
+
+		assert.Equal(t, c.Expected, output.String(), "Failed test case %s", c.Template)
+	}
+}
+
+func TestSemverCompareWithInvalidConstraint(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent("template.tmpl", `{{semverCompare ">= one" "1.0.0"}}`))
+	check(t, repo.Commit("first"))
+
+	output := new(bytes.Buffer)
+	err := NewWorld(t, ".tmp/repo").System.ApplyLocal("template.tmpl", output)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid semantic version constraint ">= one"`)
+}
+
+func TestApplyWithPartials(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent(".mbt/partials/labels.tmpl", `app: {{.Name}}`))
+	check(t, repo.WriteContent(".mbt/partials/k8s/_helpers.tmpl", `{{define "tier"}}web{{end}}`))
+	check(t, repo.WriteContent("template.tmpl", `{{template "labels.tmpl" (module "app-a")}} {{template "tier"}} {{include "tier" . | upper}} {{include "labels.tmpl" (module "app-a") | quote}}`))
+	check(t, repo.Commit("first"))
+
+	output := new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyCommit(repo.LastCommit.String(), "template.tmpl", output))
+	assert.Equal(t, `app: app-a web WEB "app: app-a"`, output.String())
+
+	output = new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyLocal("template.tmpl", output))
+	assert.Equal(t, `app: app-a web WEB "app: app-a"`, output.String())
+
+	check(t, repo.WriteContent(".mbt/partials/k8s/_helpers.tmpl", `{{define "tier"}}db{{end}}`))
+	output = new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyLocal("template.tmpl", output))
+	assert.Equal(t, `app: app-a db DB "app: app-a"`, output.String())
+}
+
+func TestApplyWithPartialsInConfiguredDir(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteConfig(&RepoConfig{Partials: "deploy/partials/"}))
+	check(t, repo.WriteContent("deploy/partials/name.tmpl", `{{.Name}}`))
+	check(t, repo.WriteContent(".mbt/partials/ignored.tmpl", `ignored`))
+	check(t, repo.WriteContent("template.tmpl", `{{template "name.tmpl" (module "app-a")}}`))
+	check(t, repo.Commit("first"))
+
+	output := new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyCommit(repo.LastCommit.String(), "template.tmpl", output))
+	assert.Equal(t, "app-a", output.String())
+
+	check(t, repo.WriteContent("template.tmpl", `{{template "ignored.tmpl"}}`))
+	check(t, repo.Commit("second"))
+
+	err := NewWorld(t, ".tmp/repo").System.ApplyCommit(repo.LastCommit.String(), "template.tmpl", new(bytes.Buffer))
+	assert.Error(t, err)
+}
+
+func TestBadPartialTemplate(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent(".mbt/partials/bad.tmpl", `{{if}}`))
+	check(t, repo.WriteContent("template.tmpl", `foo`))
+	check(t, repo.Commit("first"))
+
+	err := NewWorld(t, ".tmp/repo").System.ApplyCommit(repo.LastCommit.String(), "template.tmpl", new(bytes.Buffer))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgFailedPartialParse, "bad.tmpl"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+type applyOutputs map[string]*bytes.Buffer
+
+func (o applyOutputs) options() *ApplyOptions {
+	return &ApplyOptions{
+		SplitPerModule: true,
+		Output: func(mod *Module) (io.WriteCloser, error) {
+			buff := new(bytes.Buffer)
+			o[mod.Name()] = buff
+			return nopWriteCloser{buff}, nil
+		},
+	}
+}
+
+func TestApplySplitPerModule(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.InitModule("lib-c"))
+	check(t, repo.WriteContent("template.tmpl", `{{.Module.Name}} of {{len .ModulesList}}`))
+	check(t, repo.Commit("first"))
+
+	outputs := applyOutputs{}
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyCommitWithOptions(repo.LastCommit.String(), "template.tmpl", outputs.options()))
+
+	assert.Len(t, outputs, 3)
+	assert.Equal(t, "app-a of 3", outputs["app-a"].String())
+	assert.Equal(t, "lib-c of 3", outputs["lib-c"].String())
+
+	outputs = applyOutputs{}
+	options := outputs.options()
+	options.Filter = &FilterOptions{Name: "app-a,app-b"}
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyLocalWithOptions("template.tmpl", options))
+
+	assert.Len(t, outputs, 2)
+	assert.Equal(t, "app-b of 3", outputs["app-b"].String())
+}
+
+func TestApplyWithoutSplitHasNoCurrentModule(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent("template.tmpl", `{{if .Module}}{{.Module.Name}}{{else}}none{{end}}`))
+	check(t, repo.Commit("first"))
+
+	output := new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHead("template.tmpl", output))
+
+	assert.Equal(t, "none", output.String())
+}
+
+func TestApplyStrict(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:       "app-a",
+		Properties: map[string]interface{}{"replicas": 2, "empty": nil},
+	}))
+	check(t, repo.Commit("first"))
+
+	strict := func(content string) (string, error) {
+		check(t, repo.WriteContent("template.tmpl", content))
+		output := new(bytes.Buffer)
+		options := writerApplyOptions(output)
+		options.Strict = true
+		err := NewWorld(t, ".tmp/repo").System.ApplyLocalWithOptions("template.tmpl", options)
+		return output.String(), err
+	}
+
+	out, err := strict(`{{property (module "app-a") "replicas"}}{{property (module "app-a") "empty"}}{{propertyOr (module "app-a") "missing" 1}}`)
+	check(t, err)
+	assert.Equal(t, "2<no value>1", out)
+
+	_, err = strict("\n{{property (module \"app-a\") \"replicas.count\"}}")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "template:2:2")
+	assert.Contains(t, err.Error(), fmt.Sprintf(msgPropertyNotFound, "replicas.count", "app-a"))
+
+	_, err = strict(`{{module "app-b"}}`)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), fmt.Sprintf(msgModuleNotFound, "app-b"))
+
+	_, err = strict(`{{.Modules.app_b}}`)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `map has no entry for key "app_b"`)
+
+	check(t, repo.WriteContent("template.tmpl", `{{property (module "app-b") "replicas"}}{{.Modules.app_b}}`))
+	output := new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyLocal("template.tmpl", output))
+	assert.Equal(t, "<no value><no value>", output.String())
+}
+
+func TestApplyValidatesPropertiesSchema(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	no := false
+	check(t, repo.WriteConfig(&RepoConfig{PropertiesSchema: &PropertySchema{
+		Type:                 "object",
+		Required:             []string{"replicas"},
+		AdditionalProperties: &no,
+		Properties: map[string]*PropertySchema{
+			"replicas": {Type: "integer"},
+			"tier":     {Type: "string", Enum: []interface{}{"web", "db"}},
+			"tags":     {Type: "array", Items: &PropertySchema{Type: "string", Pattern: "^[a-z]+$"}},
+		},
+	}}))
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:       "app-a",
+		Properties: map[string]interface{}{"replicas": 2, "tier": "web", "tags": []interface{}{"a"}},
+	}))
+	check(t, repo.WriteContent("template.tmpl", `{{property (module "app-a") "replicas"}}`))
+	check(t, repo.Commit("first"))
+
+	output := new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHead("template.tmpl", output))
+	assert.Equal(t, "2", output.String())
+
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:       "app-b",
+		Properties: map[string]interface{}{"tier": "cache", "tags": []interface{}{"a", 1, "B"}, "port": 80},
+	}))
+	check(t, repo.Commit("second"))
+
+	err := NewWorld(t, ".tmp/repo").System.ApplyHead("template.tmpl", new(bytes.Buffer))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgInvalidProperties, "app-b", strings.Join([]string{
+		"properties: replicas is required",
+		"properties: port is not allowed",
+		"properties.tags[1]: expected string but found integer",
+		`properties.tags[2]: "B" does not match ^[a-z]+$`,
+		"properties.tier: cache is not one of [web db]",
+	}, "\n")))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestApplyWithUnsupportedSchemaType(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{PropertiesSchema: &PropertySchema{Type: "map"}}))
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent("template.tmpl", `foo`))
+	check(t, repo.Commit("first"))
+
+	err := NewWorld(t, ".tmp/repo").System.ApplyHead("template.tmpl", new(bytes.Buffer))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgInvalidPropertySchema, "properties", `unsupported type "map"`))
+}
+
+func TestApplyWithGraphQueries(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("lib-a", &Spec{Name: "lib-a"}))
+	check(t, repo.InitModuleWithOptions("lib-b", &Spec{Name: "lib-b", Dependencies: []string{"lib-a"}}))
+	check(t, repo.InitModuleWithOptions("web-a", &Spec{
+		Name:         "web-a",
+		Dependencies: []string{"lib-b"},
+		Properties:   map[string]interface{}{"tags": []interface{}{"frontend"}, "ingress": map[string]interface{}{"path": "/a"}},
+	}))
+	check(t, repo.InitModuleWithOptions("web-b", &Spec{
+		Name:         "web-b",
+		Dependencies: []string{"lib-a"},
+		Properties:   map[string]interface{}{"tags": []interface{}{"frontend", "public"}, "ingress": map[string]interface{}{"path": "/b"}},
+	}))
+	check(t, repo.WriteContent("template.tmpl", `{{range dependenciesOf "web-a"}}{{.Name}} {{end}}|`+
+		`{{range dependentsOf "lib-b"}}{{.Name}} {{end}}|`+
+		`{{range topSorted}}{{.Name}} {{end}}|`+
+		`{{range withTag "frontend" topSorted}}{{property . "ingress.path"}} {{end}}|`+
+		`{{range .ModulesList | withTag "public"}}{{.Name}} {{end}}|`+
+		`{{range withProperty "ingress.path" "/a" .ModulesList}}{{.Name}} {{end}}|`+
+		`{{len (dependenciesOf "lib-a")}} {{len (dependentsOf "web-b")}} {{len (dependentsOf "missing")}}`))
+	check(t, repo.Commit("first"))
+
+	output := new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHead("template.tmpl", output))
+
+	parts := strings.Split(output.String(), "|")
+	assert.Equal(t, "lib-a lib-b ", parts[0])
+	assert.Equal(t, "web-a ", parts[1])
+	assert.Equal(t, []string{"lib-a", "lib-b", "web-a", "web-b"}, sortedWords(parts[2]))
+	assert.Equal(t, "lib-a", strings.Fields(parts[2])[0])
+	assert.True(t, strings.Index(parts[2], "lib-b") < strings.Index(parts[2], "web-a"))
+	assert.Equal(t, []string{"/a", "/b"}, sortedWords(parts[3]))
+	assert.Equal(t, "web-b ", parts[4])
+	assert.Equal(t, "web-a ", parts[5])
+	assert.Equal(t, "0 0 0", parts[6])
+
+	check(t, repo.WriteContent("template.tmpl", `{{dependentsOf "missing"}}`))
+	options := writerApplyOptions(new(bytes.Buffer))
+	options.Strict = true
+	err := NewWorld(t, ".tmp/repo").System.ApplyLocalWithOptions("template.tmpl", options)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), fmt.Sprintf(msgModuleNotFound, "missing"))
+}
+
+func sortedWords(s string) []string {
+	w := strings.Fields(s)
+	sort.Strings(w)
+	return w
+}
+
+func TestApplyLocalWithUncommittedTemplate(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+	check(t, repo.WriteContent("template.tmpl", `{{range .ModulesList}}{{.Name}}{{end}}`))
+
+	output := new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyLocal("template.tmpl", output))
+
+	assert.Equal(t, "app-a", output.String())
+}
+
+func TestApplyLocalWithAbsoluteTemplatePath(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	dir, err := ioutil.TempDir("", "mbt-template")
+	check(t, err)
+	defer os.RemoveAll(dir)
+
+	templatePath := filepath.Join(dir, "template.tmpl")
+	check(t, ioutil.WriteFile(templatePath, []byte(`{{range .ModulesList}}{{.Name}}{{end}}`), 0644))
+
+	output := new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyLocal(templatePath, output))
+
+	assert.Equal(t, "app-a", output.String())
+}
+
+func TestApplyWithTemplateReader(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	output := new(bytes.Buffer)
+	options := writerApplyOptions(output)
+	options.Template = strings.NewReader(`{{range .ModulesList}}{{.Name}}{{end}}`)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyLocalWithOptions("stdin", options))
+	assert.Equal(t, "app-a", output.String())
+
+	output = new(bytes.Buffer)
+	options = writerApplyOptions(output)
+	options.Template = strings.NewReader(`{{.Sha}}`)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("stdin", options))
+	assert.Equal(t, repo.LastCommit.String(), output.String())
+}
+
+func TestApplyWithValues(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:       "app-a",
+		Properties: map[string]interface{}{"replicas": 1, "image": map[string]interface{}{"name": "app-a", "tag": "dev"}},
+	}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Dependencies: []string{"app-a"}}))
+	check(t, repo.WriteContent("template.tmpl", `{{with module "app-a"}}{{property . "replicas"}} {{property . "image.name"}}:{{property . "image.tag"}} {{property . "region"}}{{end}} `+
+		`{{with module "app-b"}}{{property . "region"}} {{(index .Requires 0).Name}}:{{property (index .Requires 0) "image.tag"}}{{end}}`))
+	check(t, repo.Commit("first"))
+
+	check(t, ioutil.WriteFile(".tmp/base.yaml", []byte("region: us\nimage:\n  tag: stable\n"), 0644))
+	check(t, ioutil.WriteFile(".tmp/prod.yaml", []byte("replicas: 3\nimage:\n  tag: \"1.0\"\n"), 0644))
+
+	output := new(bytes.Buffer)
+	options := writerApplyOptions(output)
+	options.Values = []string{".tmp/base.yaml", ".tmp/prod.yaml"}
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("template.tmpl", options))
+	assert.Equal(t, "3 app-a:1.0 us us app-a:1.0", output.String())
+
+	output = new(bytes.Buffer)
+	options = writerApplyOptions(output)
+	options.Values = []string{".tmp/base.yaml"}
+	options.Set = []string{`image.tag="2.0"`, "replicas=5", "region=eu=1"}
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("template.tmpl", options))
+	assert.Equal(t, "5 app-a:2.0 eu=1 eu=1 app-a:2.0", output.String())
+
+	output = new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHead("template.tmpl", output))
+	assert.Equal(t, "1 app-a:dev <no value> <no value> app-a:dev", output.String())
+}
+
+func TestApplyWithInvalidValues(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent("template.tmpl", `foo`))
+	check(t, repo.Commit("first"))
+
+	options := writerApplyOptions(new(bytes.Buffer))
+	options.Set = []string{"replicas"}
+	err := NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("template.tmpl", options)
+	assert.EqualError(t, err, fmt.Sprintf(msgInvalidSetValue, "replicas"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+
+	check(t, ioutil.WriteFile(".tmp/bad.yaml", []byte("- a\n- b\n"), 0644))
+	options = writerApplyOptions(new(bytes.Buffer))
+	options.Values = []string{".tmp/bad.yaml"}
+	err = NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("template.tmpl", options)
+	assert.EqualError(t, err, fmt.Sprintf(msgFailedValuesParse, ".tmp/bad.yaml"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestApplyWithEnvironment(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:       "app-a",
+		Properties: map[string]interface{}{"replicas": 1, "db": map[string]interface{}{"host": "localhost", "port": 5432}},
+		Environments: map[string]*Environment{
+			"prod": {Properties: map[string]interface{}{"replicas": 3, "db": map[string]interface{}{"host": "db.prod"}}},
+		},
+	}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Properties: map[string]interface{}{"replicas": 2}}))
+	check(t, repo.WriteContent("template.tmpl", `{{.Environment}}{{range .ModulesList}} {{.Name}}:{{property . "replicas"}}{{end}} {{with module "app-a"}}{{property . "db.host"}}:{{property . "db.port"}}{{end}}`))
+	check(t, repo.Commit("first"))
+
+	output := new(bytes.Buffer)
+	options := writerApplyOptions(output)
+	options.Environment = "prod"
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("template.tmpl", options))
+	assert.Equal(t, "prod app-a:3 app-b:2 db.prod:5432", output.String())
+
+	output = new(bytes.Buffer)
+	options = writerApplyOptions(output)
+	options.Environment = "prod"
+	options.Set = []string{"replicas=5"}
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("template.tmpl", options))
+	assert.Equal(t, "prod app-a:5 app-b:5 db.prod:5432", output.String())
+
+	output = new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHead("template.tmpl", output))
+	assert.Equal(t, " app-a:1 app-b:2 localhost:5432", output.String())
+}
+
+func TestApplyWithEnvironmentsFile(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:       "app-a",
+		Properties: map[string]interface{}{"replicas": 1, "region": "us"},
+	}))
+	check(t, repo.WriteContent("template.tmpl", `{{.Environment}} {{with module "app-a"}}{{property . "replicas"}} {{property . "region"}}{{end}}`))
+	check(t, repo.Commit("first"))
+
+	check(t, ioutil.WriteFile(".tmp/base.yaml", []byte("region: eu\n"), 0644))
+	check(t, ioutil.WriteFile(".tmp/envs.yaml", []byte("staging:\n  replicas: 2\nprod:\n  replicas: 3\n  region: ap\n"), 0644))
+
+	output := new(bytes.Buffer)
+	options := writerApplyOptions(output)
+	options.Environment = "staging"
+	options.EnvironmentsFile = ".tmp/envs.yaml"
+	options.Values = []string{".tmp/base.yaml"}
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("template.tmpl", options))
+	assert.Equal(t, "staging 2 eu", output.String())
+
+	output = new(bytes.Buffer)
+	options = writerApplyOptions(output)
+	options.Environment = "prod"
+	options.EnvironmentsFile = ".tmp/envs.yaml"
+	options.Values = []string{".tmp/base.yaml"}
+	options.Set = []string{"replicas=5"}
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("template.tmpl", options))
+	assert.Equal(t, "prod 5 ap", output.String())
+
+	options = writerApplyOptions(new(bytes.Buffer))
+	options.Environment = "dev"
+	options.EnvironmentsFile = ".tmp/envs.yaml"
+	err := NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("template.tmpl", options)
+	assert.EqualError(t, err, fmt.Sprintf(msgEnvironmentNotInValues, "dev", ".tmp/envs.yaml"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+
+	options = writerApplyOptions(new(bytes.Buffer))
+	options.EnvironmentsFile = ".tmp/envs.yaml"
+	err = NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("template.tmpl", options)
+	assert.EqualError(t, err, fmt.Sprintf(msgEnvironmentValuesWithoutEnvironment, ".tmp/envs.yaml"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestApplyAllEnvironments(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:       "app-a",
+		Properties: map[string]interface{}{"replicas": 1},
+		Environments: map[string]*Environment{
+			"prod": {Properties: map[string]interface{}{"tier": "gold"}},
+		},
+	}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Properties: map[string]interface{}{"replicas": 1}}))
+	check(t, repo.WriteContent("template.tmpl", `{{.Environment}}/{{.Module.Name}}:{{property .Module "replicas"}}{{with property .Module "tier"}}:{{.}}{{end}}`))
+	check(t, repo.Commit("first"))
+
+	check(t, ioutil.WriteFile(".tmp/envs.yaml", []byte("staging:\n  replicas: 2\nprod:\n  replicas: 3\n"), 0644))
+
+	outputs := make(map[string]*bytes.Buffer)
+	var order []string
+	options := &ApplyOptions{
+		SplitPerModule:   true,
+		EnvironmentsFile: ".tmp/envs.yaml",
+		AllEnvironments:  true,
+		EnvironmentOutput: func(env string, mod *Module) (io.WriteCloser, error) {
+			key := env + "/" + mod.Name()
+			order = append(order, key)
+			outputs[key] = new(bytes.Buffer)
+			return nopWriteCloser{outputs[key]}, nil
+		},
+	}
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("template.tmpl", options))
+
+	assert.Equal(t, []string{"prod/app-a", "prod/app-b", "staging/app-a", "staging/app-b"}, order)
+	assert.Equal(t, "prod/app-a:3:gold", outputs["prod/app-a"].String())
+	assert.Equal(t, "prod/app-b:3", outputs["prod/app-b"].String())
+	assert.Equal(t, "staging/app-a:2", outputs["staging/app-a"].String())
+	assert.Equal(t, "staging/app-b:2", outputs["staging/app-b"].String())
+}
+
+func TestApplyAllEnvironmentsWithoutEnvironmentsFile(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent("template.tmpl", `foo`))
+	check(t, repo.Commit("first"))
+
+	options := writerApplyOptions(new(bytes.Buffer))
+	options.AllEnvironments = true
+	err := NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("template.tmpl", options)
+	assert.EqualError(t, err, msgAllEnvironmentsWithoutValues)
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+
+	check(t, ioutil.WriteFile(".tmp/envs.yaml", []byte("prod: 3\n"), 0644))
+	options.EnvironmentsFile = ".tmp/envs.yaml"
+	err = NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("template.tmpl", options)
+	assert.EqualError(t, err, fmt.Sprintf(msgInvalidEnvironmentValues, "prod", ".tmp/envs.yaml"))
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io"
+	"strings"
+
+	yaml "github.com/go-yaml/yaml"
+	"github.com/mbtproject/mbt/e"
+)
+
+// azureNoopJob is the name of the job in a template without modules.
+const azureNoopJob = "mbt_no_changes"
+
+type azureTemplate struct {
+	Jobs []*azureJob `yaml:"jobs"`
+}
+
+type azureJob struct {
+	Job         string        `yaml:"job"`
+	DisplayName string        `yaml:"displayName"`
+	DependsOn   []string      `yaml:"dependsOn,omitempty"`
+	Container   string        `yaml:"container,omitempty"`
+	Variables   yaml.MapSlice `yaml:"variables,omitempty"`
+	Steps       []*azureStep  `yaml:"steps"`
+}
+
+type azureStep struct {
+	Script           string `yaml:"script"`
+	DisplayName      string `yaml:"displayName"`
+	WorkingDirectory string `yaml:"workingDirectory,omitempty"`
+}
+
+// azureJobName returns the name of the job of a module. Names can only
+// contain alphanumeric characters and _ and, cannot start with a
+// number.
+func azureJobName(module string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, module)
+
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// renderAzurePipeline writes a template of Azure Pipelines jobs to be
+// included in the jobs of a stage (or a pipeline).
+func renderAzurePipeline(plan *pipelinePlan, options *PipelineOptions, w io.Writer) error {
+	t := &azureTemplate{Jobs: []*azureJob{}}
+	ids := plan.ids(azureJobName)
+	for _, s := range plan.buildableSteps() {
+		variables := yaml.MapSlice{{Key: "MBT_REPO_PATH", Value: "$(Build.SourcesDirectory)"}}
+		for _, k := range sortedKeys(s.Variables) {
+			variables = append(variables, yaml.MapItem{Key: k, Value: s.Variables[k]})
+		}
+
+		dependsOn := make([]string, 0, len(s.Needs))
+		for _, n := range s.Needs {
+			dependsOn = append(dependsOn, ids[n])
+		}
+
+		t.Jobs = append(t.Jobs, &azureJob{
+			Job:         ids[s.Module.Name()],
+			DisplayName: s.Module.Name(),
+			DependsOn:   dependsOn,
+			Container:   s.Image,
+			Variables:   variables,
+			Steps: []*azureStep{{
+				Script:           s.Command,
+				DisplayName:      "Build " + s.Module.Name(),
+				WorkingDirectory: "$(Build.SourcesDirectory)/" + s.Dir,
+			}},
+		})
+	}
+
+	if len(t.Jobs) == 0 {
+		t.Jobs = append(t.Jobs, &azureJob{
+			Job:         azureNoopJob,
+			DisplayName: "No modules to build",
+			Steps:       []*azureStep{{Script: "echo No modules to build", DisplayName: "No modules to build"}},
+		})
+	}
+
+	buff, err := yaml.Marshal(t)
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	_, err = w.Write(buff)
+	return err
+}
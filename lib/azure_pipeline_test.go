@@ -0,0 +1,80 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"testing"
+
+	yaml "github.com/go-yaml/yaml"
+	"github.com/stretchr/testify/assert"
+)
+
+type testAzureTemplate struct {
+	Jobs []*struct {
+		Job         string            `yaml:"job"`
+		DisplayName string            `yaml:"displayName"`
+		DependsOn   []string          `yaml:"dependsOn"`
+		Container   string            `yaml:"container"`
+		Variables   map[string]string `yaml:"variables"`
+		Steps       []*azureStep      `yaml:"steps"`
+	} `yaml:"jobs"`
+}
+
+func TestAzurePipeline(t *testing.T) {
+	initPipelineRepo(t)
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	check(t, m.WritePipeline(PipelineAzure, &PipelineOptions{}, buff))
+
+	p := &testAzureTemplate{}
+	check(t, yaml.Unmarshal(buff.Bytes(), p))
+
+	assert.Len(t, p.Jobs, 2)
+	lib, svc := p.Jobs[0], p.Jobs[1]
+	assert.Equal(t, "lib_a", lib.Job)
+	assert.Equal(t, "lib-a", lib.DisplayName)
+	assert.Empty(t, lib.DependsOn)
+	assert.Empty(t, lib.Container)
+	assert.Equal(t, "$(Build.SourcesDirectory)", lib.Variables["MBT_REPO_PATH"])
+	assert.Equal(t, "lib-a", lib.Variables["MBT_MODULE_NAME"])
+	assert.Equal(t, "make build lib-a", lib.Steps[0].Script)
+	assert.Equal(t, "$(Build.SourcesDirectory)/lib-a", lib.Steps[0].WorkingDirectory)
+
+	assert.Equal(t, "svc_a", svc.Job)
+	assert.Equal(t, []string{"lib_a"}, svc.DependsOn)
+	assert.Equal(t, "golang:1.21", svc.Container)
+	assert.Equal(t, "$(Build.SourcesDirectory)/svc-a/src", svc.Steps[0].WorkingDirectory)
+}
+
+func TestEmptyAzurePipeline(t *testing.T) {
+	buff := new(bytes.Buffer)
+	check(t, (&Manifest{Modules: Modules{}}).WritePipeline(PipelineAzure, &PipelineOptions{}, buff))
+
+	p := &testAzureTemplate{}
+	check(t, yaml.Unmarshal(buff.Bytes(), p))
+
+	assert.Len(t, p.Jobs, 1)
+	assert.Equal(t, azureNoopJob, p.Jobs[0].Job)
+}
+
+func TestAzureJobName(t *testing.T) {
+	assert.Equal(t, "app_a_b", azureJobName("app-a.b"))
+	assert.Equal(t, "_1app", azureJobName("1app"))
+}
@@ -20,6 +20,69 @@ import (
 	"testing"
 )
 
+// benchmarkLayerWidth is the number of modules in each layer of the
+// synthetic dependency graph.
+const benchmarkLayerWidth = 100
+
+// syntheticModuleSpec returns the spec of the i-th module of a synthetic
+// repository.
+// Modules are arranged in layers of benchmarkLayerWidth modules and
+// each module depends on two modules of the previous layer, which
+// produces a graph as deep and as connected as the one of a large
+// monorepo.
+func syntheticModuleSpec(i int) *Spec {
+	spec := &Spec{
+		Name:       fmt.Sprintf("app-%v", i),
+		Build:      map[string]*Cmd{"linux": {Cmd: "./build.sh"}},
+		Properties: map[string]interface{}{"owner": fmt.Sprintf("team-%v", i%10)},
+	}
+
+	if i >= benchmarkLayerWidth {
+		layer := i / benchmarkLayerWidth
+		for _, d := range []int{i - benchmarkLayerWidth, (layer-1)*benchmarkLayerWidth + (i+1)%benchmarkLayerWidth} {
+			spec.Dependencies = append(spec.Dependencies, fmt.Sprintf("app-%v", d))
+		}
+	}
+
+	return spec
+}
+
+// syntheticMetadata returns the metadata of a synthetic repository
+// with the specified number of modules without creating the repository.
+func syntheticMetadata(modulesCount int) moduleMetadataSet {
+	set := make(moduleMetadataSet, modulesCount)
+	for i := range set {
+		set[i] = newModuleMetadata(fmt.Sprintf("apps/app-%v", i), fmt.Sprintf("%040x", i+1), syntheticModuleSpec(i), nil)
+	}
+	return set
+}
+
+// newSyntheticRepo creates a repository in .tmp/repo with the specified
+// number of modules in a single commit.
+func newSyntheticRepo(modulesCount int, b *testing.B) *TestRepository {
+	repo := NewTestRepoForBench(b, ".tmp/repo")
+
+	for i := 0; i < modulesCount; i++ {
+		p := fmt.Sprintf("apps/app-%v", i)
+		err := repo.InitModuleWithOptions(p, syntheticModuleSpec(i))
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		err = repo.WriteContent(p+"/src/main.go", fmt.Sprintf("package main // %v", i))
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+	}
+
+	err := repo.Commit("first")
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	return repo
+}
+
 func benchmarkReduceToDiff(modulesCount, deltaCount int, b *testing.B) {
 	clean()
 	defer clean()
@@ -78,3 +141,159 @@ func BenchmarkReduceToDiff1000(b *testing.B) {
 func BenchmarkReduceToDiff10000(b *testing.B) {
 	benchmarkReduceToDiff(10000, 10000, b)
 }
+
+func benchmarkDiscoverCommit(modulesCount int, b *testing.B) {
+	clean()
+	defer clean()
+
+	repo := newSyntheticRepo(modulesCount, b)
+	world := NewBenchmarkWorld(b, ".tmp/repo")
+	commit, err := world.Repo.GetCommit(repo.LastCommit.String())
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+	b.ResetTimer()
+
+	// Walks the commit tree without the discovery cache.
+	for i := 0; i < b.N; i++ {
+		_, err = metadataInCommit(world.Repo, commit)
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+	}
+
+	b.StopTimer()
+}
+
+func BenchmarkDiscoverCommit1000(b *testing.B) {
+	benchmarkDiscoverCommit(1000, b)
+}
+
+func BenchmarkDiscoverCommit10000(b *testing.B) {
+	benchmarkDiscoverCommit(10000, b)
+}
+
+func benchmarkManifestByCommit(modulesCount int, b *testing.B) {
+	clean()
+	defer clean()
+
+	repo := newSyntheticRepo(modulesCount, b)
+	world := NewBenchmarkWorld(b, ".tmp/repo")
+	b.ResetTimer()
+
+	// First iteration populates the discovery cache used by the rest.
+	for i := 0; i < b.N; i++ {
+		_, err := world.System.ManifestByCommit(repo.LastCommit.String())
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+	}
+
+	b.StopTimer()
+}
+
+func BenchmarkManifestByCommit1000(b *testing.B) {
+	benchmarkManifestByCommit(1000, b)
+}
+
+func BenchmarkManifestByCommit10000(b *testing.B) {
+	benchmarkManifestByCommit(10000, b)
+}
+
+func benchmarkManifestByWorkspace(modulesCount int, b *testing.B) {
+	clean()
+	defer clean()
+
+	newSyntheticRepo(modulesCount, b)
+	world := NewBenchmarkWorld(b, ".tmp/repo")
+	b.ResetTimer()
+
+	// Hashes the content of every module in the workspace.
+	for i := 0; i < b.N; i++ {
+		_, err := world.System.ManifestByWorkspace()
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+	}
+
+	b.StopTimer()
+}
+
+func BenchmarkManifestByWorkspace1000(b *testing.B) {
+	benchmarkManifestByWorkspace(1000, b)
+}
+
+func BenchmarkManifestByWorkspace10000(b *testing.B) {
+	benchmarkManifestByWorkspace(10000, b)
+}
+
+func benchmarkToModules(modulesCount int, b *testing.B) {
+	set := syntheticMetadata(modulesCount)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := toModules(set)
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+	}
+}
+
+func BenchmarkToModules1000(b *testing.B) {
+	benchmarkToModules(1000, b)
+}
+
+func BenchmarkToModules10000(b *testing.B) {
+	benchmarkToModules(10000, b)
+}
+
+func benchmarkCalculateVersion(modulesCount int, b *testing.B) {
+	modules, err := toModules(syntheticMetadata(modulesCount))
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		calculateVersion(modules)
+	}
+}
+
+func BenchmarkCalculateVersion1000(b *testing.B) {
+	benchmarkCalculateVersion(1000, b)
+}
+
+func BenchmarkCalculateVersion10000(b *testing.B) {
+	benchmarkCalculateVersion(10000, b)
+}
+
+func benchmarkExpandRequiredByDependencies(modulesCount int, b *testing.B) {
+	modules, err := toModules(syntheticMetadata(modulesCount))
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	// Changes in the first layer affect every module in the graph.
+	changed := Modules{}
+	for _, m := range modules {
+		if len(m.Requires()) == 0 {
+			changed = append(changed, m)
+		}
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err = changed.expandRequiredByDependencies()
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+	}
+}
+
+func BenchmarkExpandRequiredByDependencies1000(b *testing.B) {
+	benchmarkExpandRequiredByDependencies(1000, b)
+}
+
+func BenchmarkExpandRequiredByDependencies10000(b *testing.B) {
+	benchmarkExpandRequiredByDependencies(10000, b)
+}
@@ -0,0 +1,322 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// InitOptions specifies how a repository is set up for mbt.
+type InitOptions struct {
+	// Detect proposes the modules of the repository from the build
+	// files and the directory conventions and writes their specs.
+	Detect bool
+	// Force replaces the existing module specs and repository config.
+	Force bool
+	// DryRun returns the report without writing any files.
+	DryRun bool
+}
+
+// InitReport describes how a repository is set up for mbt.
+type InitReport struct {
+	// Config is the path of the repository config written relative to
+	// the repository root. It is empty if the existing config is kept.
+	Config string
+	// Modules are the modules detected in the repository.
+	Modules []*DetectedModule
+	// Ambiguities are the areas of the repository where modules could
+	// not be proposed with confidence.
+	Ambiguities []*InitAmbiguity
+}
+
+// DetectedModule is a module proposed from a build file or a
+// directory convention.
+type DetectedModule struct {
+	Name string
+	// Path of the module relative to the repository root.
+	Path string
+	// Detector is the build system (e.g. npm) or convention the module
+	// is detected with.
+	Detector string
+	// Build is nil when the module is detected from a directory
+	// convention only.
+	Build *Cmd
+}
+
+// InitAmbiguity is an area of the repository to review after init.
+type InitAmbiguity struct {
+	// Path of the area relative to the repository root.
+	Path   string
+	Reason string
+}
+
+// moduleDetector proposes a module for each directory with one of
+// its build files.
+type moduleDetector struct {
+	name string
+	// files are the patterns of the names of the build files.
+	files []string
+	build *Cmd
+	// secondary detectors are used only when no other detector
+	// matches the directory.
+	secondary bool
+}
+
+// moduleDetectors are listed in the order of their precedence.
+// Secondary detectors are listed last.
+var moduleDetectors = []*moduleDetector{
+	{name: "go", files: []string{"go.mod"}, build: &Cmd{Cmd: "go", Args: []string{"build", "./..."}}},
+	{name: "cargo", files: []string{"Cargo.toml"}, build: &Cmd{Cmd: "cargo", Args: []string{"build"}}},
+	{name: "npm", files: []string{"package.json"}, build: &Cmd{Cmd: "npm", Args: []string{"run", "build"}}},
+	{name: "maven", files: []string{"pom.xml"}, build: &Cmd{Cmd: "mvn", Args: []string{"package"}}},
+	{name: "gradle", files: []string{"build.gradle", "build.gradle.kts"}, build: &Cmd{Cmd: "gradle", Args: []string{"build"}}},
+	{name: "dotnet", files: []string{"*.csproj", "*.fsproj"}, build: &Cmd{Cmd: "dotnet", Args: []string{"build"}}},
+	{name: "python", files: []string{"pyproject.toml", "setup.py"}, build: &Cmd{Cmd: "python", Args: []string{"-m", "build"}}},
+	{name: "make", files: []string{"Makefile"}, build: &Cmd{Cmd: "make"}, secondary: true},
+	{name: "docker", files: []string{"Dockerfile"}, build: &Cmd{Cmd: "docker", Args: []string{"build", "."}}, secondary: true},
+}
+
+// conventionDetector is the detector of the modules proposed from the
+// directory conventions.
+const conventionDetector = "convention"
+
+// moduleConventionDirs are the directories at the repository root
+// conventionally containing a module in each subdirectory.
+var moduleConventionDirs = []string{"apps", "components", "libs", "modules", "packages", "projects", "services"}
+
+const draftRepoConfig = `# Configuration of mbt for this repository (see mbt help).
+# Environment variables of all modules.
+env: {}
+# Host environment variables that can be referenced in env e.g. ${HOME}.
+hostEnv: []
+`
+
+func (s *stdSystem) Init(options *InitOptions) (*InitReport, error) {
+	report := &InitReport{Modules: make([]*DetectedModule, 0), Ambiguities: make([]*InitAmbiguity, 0)}
+	if options.Detect {
+		err := detectModules(s.Repo, report)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	configPath := filepath.Join(s.Repo.Path(), configDir, configFile)
+	if _, err := os.Stat(configPath); err != nil || options.Force {
+		report.Config = path.Join(configDir, configFile)
+	}
+
+	if options.DryRun {
+		return report, nil
+	}
+
+	// Check all specs before writing any, so that a failed init does
+	// not leave the repository half converted.
+	if !options.Force {
+		for _, m := range report.Modules {
+			p := filepath.Join(s.Repo.Path(), m.Path, configFileName)
+			if _, err := os.Stat(p); err == nil {
+				return nil, e.NewErrorf(ErrClassUser, msgSpecExists, p)
+			}
+		}
+	}
+
+	for _, m := range report.Modules {
+		err := writeImportedSpec(filepath.Join(s.Repo.Path(), m.Path), &ImportedModule{Name: m.Name, Path: m.Path, Build: m.Build})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if report.Config != "" {
+		err := os.MkdirAll(filepath.Dir(configPath), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(configPath, []byte(draftRepoConfig), 0644)
+		}
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgFailedWriteRepoConfig, configPath)
+		}
+	}
+
+	return report, nil
+}
+
+// detectModules adds the modules proposed for the directories of the
+// workspace to the report. Directories containing other proposed
+// modules and the ones matching more than one build system are
+// reported as ambiguous.
+func detectModules(repo Repo, report *InitReport) error {
+	pathSpec := make([]string, 0)
+	for _, d := range moduleDetectors {
+		for _, f := range d.files {
+			pathSpec = append(pathSpec, f, "/**/"+f)
+		}
+	}
+
+	files, err := repo.FindAllFilesInWorkspace(pathSpec)
+	if err != nil {
+		return err
+	}
+
+	matches := make(map[string]map[*moduleDetector]bool)
+	for _, f := range files {
+		dir, name := path.Split(f)
+		dir = strings.TrimSuffix(dir, "/")
+		if isVendoredDir(dir) {
+			continue
+		}
+
+		for _, d := range moduleDetectors {
+			if d.matches(name) {
+				if matches[dir] == nil {
+					matches[dir] = make(map[*moduleDetector]bool)
+				}
+				matches[dir][d] = true
+			}
+		}
+	}
+
+	candidates := make(map[string]*DetectedModule)
+	for dir, matched := range matches {
+		detectors := make([]*moduleDetector, 0, len(matched))
+		for _, d := range moduleDetectors {
+			if matched[d] {
+				detectors = append(detectors, d)
+			}
+		}
+
+		chosen := detectors[0]
+		candidates[dir] = &DetectedModule{Path: dir, Detector: chosen.name, Build: chosen.build}
+
+		others := make([]string, 0)
+		for _, d := range detectors[1:] {
+			if !d.secondary && !chosen.secondary {
+				others = append(others, d.name)
+			}
+		}
+		if len(others) > 0 {
+			report.Ambiguities = append(report.Ambiguities, &InitAmbiguity{
+				Path:   dir,
+				Reason: "detected as " + chosen.name + ", also matches " + strings.Join(others, ", "),
+			})
+		}
+	}
+
+	root, err := filepath.Abs(repo.Path())
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	for _, c := range moduleConventionDirs {
+		entries, err := ioutil.ReadDir(filepath.Join(root, c))
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			dir := path.Join(c, entry.Name())
+			if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || isVendoredDir(dir) {
+				continue
+			}
+			if _, ok := candidates[dir]; !ok {
+				candidates[dir] = &DetectedModule{Path: dir, Detector: conventionDetector}
+			}
+		}
+	}
+
+	dirs := make([]string, 0, len(candidates))
+	for dir := range candidates {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	names := make(map[string]string)
+	for i, dir := range dirs {
+		// Sorted directories are followed by the ones they contain.
+		nested := make([]string, 0)
+		for _, other := range dirs[i+1:] {
+			if dir == "" || strings.HasPrefix(other, dir+"/") {
+				nested = append(nested, other)
+			}
+		}
+
+		if len(nested) > 0 {
+			report.Ambiguities = append(report.Ambiguities, &InitAmbiguity{
+				Path:   dir,
+				Reason: "contains other modules (" + strings.Join(nested, ", ") + "), module is not created",
+			})
+			continue
+		}
+
+		m := candidates[dir]
+		m.Name = importedModuleName(dir)
+		if dir == "" {
+			m.Name = filepath.Base(root)
+		}
+
+		if other, ok := names[m.Name]; ok {
+			report.Ambiguities = append(report.Ambiguities, &InitAmbiguity{
+				Path:   dir,
+				Reason: "name " + m.Name + " is already used by " + other + ", module is not created",
+			})
+			continue
+		}
+		names[m.Name] = dir
+
+		if m.Build == nil {
+			report.Ambiguities = append(report.Ambiguities, &InitAmbiguity{
+				Path:   dir,
+				Reason: "no build file detected, build command is not set",
+			})
+		}
+		report.Modules = append(report.Modules, m)
+	}
+
+	sort.SliceStable(report.Ambiguities, func(i, j int) bool {
+		return report.Ambiguities[i].Path < report.Ambiguities[j].Path
+	})
+	return nil
+}
+
+func (d *moduleDetector) matches(name string) bool {
+	for _, f := range d.files {
+		if ok, _ := path.Match(f, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isVendoredDir informs if a directory is or is in a directory of
+// third party code or a hidden directory.
+func isVendoredDir(dir string) bool {
+	if dir == "" {
+		return false
+	}
+
+	for _, p := range strings.Split(dir, "/") {
+		if p == "node_modules" || p == "vendor" || strings.HasPrefix(p, ".") {
+			return true
+		}
+	}
+	return false
+}
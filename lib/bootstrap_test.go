@@ -0,0 +1,120 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitDetectsModules(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteContent("package.json", `{"workspaces": ["packages/*"]}`))
+	check(t, repo.WriteContent("packages/web/package.json", "{}"))
+	check(t, repo.WriteContent("packages/web/Dockerfile", "FROM node\n"))
+	check(t, repo.WriteContent("services/api/go.mod", "module api\n"))
+	check(t, repo.WriteContent("services/api/Makefile", "all:\n"))
+	check(t, repo.WriteContent("services/worker/pom.xml", "<project/>\n"))
+	check(t, repo.WriteContent("services/worker/build.gradle", "\n"))
+	check(t, repo.WriteContent("libs/docs/README.md", "docs\n"))
+	check(t, repo.WriteContent("tools/cli/Cargo.toml", "[package]\n"))
+	check(t, repo.WriteContent("tools/cli/node_modules/dep/package.json", "{}"))
+	check(t, repo.Commit("first"))
+
+	report, err := NewWorld(t, ".tmp/repo").System.Init(&InitOptions{Detect: true})
+	check(t, err)
+
+	assert.Equal(t, ".mbt/config.yml", report.Config)
+	assert.Equal(t, []*DetectedModule{
+		{Name: "libs-docs", Path: "libs/docs", Detector: conventionDetector},
+		{Name: "packages-web", Path: "packages/web", Detector: "npm", Build: &Cmd{Cmd: "npm", Args: []string{"run", "build"}}},
+		{Name: "services-api", Path: "services/api", Detector: "go", Build: &Cmd{Cmd: "go", Args: []string{"build", "./..."}}},
+		{Name: "services-worker", Path: "services/worker", Detector: "maven", Build: &Cmd{Cmd: "mvn", Args: []string{"package"}}},
+		{Name: "tools-cli", Path: "tools/cli", Detector: "cargo", Build: &Cmd{Cmd: "cargo", Args: []string{"build"}}},
+	}, report.Modules)
+
+	assert.Equal(t, []*InitAmbiguity{
+		{Path: "", Reason: "contains other modules (libs/docs, packages/web, services/api, services/worker, tools/cli), module is not created"},
+		{Path: "libs/docs", Reason: "no build file detected, build command is not set"},
+		{Path: "services/worker", Reason: "detected as maven, also matches gradle"},
+	}, report.Ambiguities)
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByWorkspace()
+	check(t, err)
+	assert.Equal(t, []string{"libs-docs", "packages-web", "services-api", "services-worker", "tools-cli"}, moduleNames(m.Modules))
+
+	api, _ := m.Module("services-api")
+	assert.Equal(t, &Cmd{Cmd: "go", Args: []string{"build", "./..."}}, api.Build()["default"])
+	docs, _ := m.Module("libs-docs")
+	assert.Empty(t, docs.Build())
+
+	config, err := loadRepoConfig(".tmp/repo")
+	check(t, err)
+	assert.NotNil(t, config)
+}
+
+func TestInitRootModule(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteContent("go.mod", "module app\n"))
+	check(t, repo.Commit("first"))
+
+	report, err := NewWorld(t, ".tmp/repo").System.Init(&InitOptions{Detect: true, DryRun: true})
+	check(t, err)
+
+	assert.Equal(t, []*DetectedModule{{Name: "repo", Path: "", Detector: "go", Build: &Cmd{Cmd: "go", Args: []string{"build", "./..."}}}}, report.Modules)
+	assert.Empty(t, report.Ambiguities)
+
+	_, err = os.Stat(filepath.Join(".tmp/repo", configFileName))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(".tmp/repo", configDir, configFile))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestInitKeepsExistingFiles(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent("app-a/package.json", "{}"))
+	check(t, repo.WriteConfig(&RepoConfig{Env: map[string]string{"A": "a"}}))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	report, err := world.System.Init(&InitOptions{})
+	check(t, err)
+	assert.Empty(t, report.Config)
+	assert.Empty(t, report.Modules)
+
+	_, err = world.System.Init(&InitOptions{Detect: true})
+	assert.EqualError(t, err, "Module spec "+filepath.Join(".tmp/repo", "app-a", configFileName)+" already exists, use --force to replace it")
+
+	report, err = world.System.Init(&InitOptions{Detect: true, Force: true})
+	check(t, err)
+	assert.Equal(t, ".mbt/config.yml", report.Config)
+
+	m, err := world.System.ManifestByWorkspace()
+	check(t, err)
+	mod, _ := m.Module("app-a")
+	assert.Equal(t, &Cmd{Cmd: "npm", Args: []string{"run", "build"}}, mod.Build()["default"])
+
+	config, err := loadRepoConfig(".tmp/repo")
+	check(t, err)
+	assert.Empty(t, config.Env)
+}
@@ -17,15 +17,11 @@ package lib
 
 import (
 	"runtime"
+	"time"
 
-	git "github.com/libgit2/git2go"
 	"github.com/mbtproject/mbt/e"
 )
 
-var defaultCheckoutOptions = &git.CheckoutOpts{
-	Strategy: git.CheckoutSafe,
-}
-
 func (s *stdSystem) BuildBranch(name string, filterOptions *FilterOptions, options *CmdOptions) (*BuildSummary, error) {
 	m, err := s.ManifestByBranch(name)
 	if err != nil {
@@ -119,50 +115,383 @@ func (s *stdSystem) BuildWorkspaceChanges(options *CmdOptions) (*BuildSummary, e
 }
 
 func (s *stdSystem) checkoutAndBuildManifest(m *Manifest, options *CmdOptions) (*BuildSummary, error) {
+	if options.Plan {
+		// Planning does not require the content of the workspace.
+		return s.buildManifest(m, options)
+	}
+
 	r, err := s.WorkspaceManager.CheckoutAndRun(m.Sha, func() (interface{}, error) {
 		return s.buildManifest(m, options)
 	})
 
+	// Result is nil if the checkout failed.
+	summary, _ := r.(*BuildSummary)
+	return summary, err
+}
+
+func (s *stdSystem) buildManifest(m *Manifest, options *CmdOptions) (*BuildSummary, error) {
+	options = s.withContext(options)
+	m, err := m.withEnvironment(options.Environment)
+	if err != nil {
+		return nil, err
+	}
+	options = withEnvironmentOptions(options)
+
+	m, err = m.withRelease(options.Release)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := loadRepoConfig(m.Dir)
 	if err != nil {
 		return nil, err
 	}
 
-	return r.(*BuildSummary), nil
+	err = s.checkPolicies(config, m, func() (*PolicyInput, error) {
+		return s.buildPolicyInput(m, options)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Plan {
+		summary, err := s.planManifest(m, options)
+		if err == nil {
+			err = s.predictPlan(config, m, summary.Plan, options)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return summary, nil
+	}
+
+	err = checkRequiredEnv(config, m.Modules, options, func(a *Module) bool {
+		_, ok := s.canBuildHere(a)
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	options, closeStreams, err := s.withEventStreams(config, options)
+	if err != nil {
+		return nil, err
+	}
+	defer closeStreams()
+
+	started := time.Now()
+	emitRunStart(options, "build", m)
+
+	j, err := s.openJournal(m, options.Resume)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := s.openStats()
+	if err != nil {
+		return nil, err
+	}
+
+	fp, err := s.openFingerprints()
+	if err != nil {
+		return nil, err
+	}
+
+	bc, err := s.openBuildCache(config, options)
+	if err != nil {
+		return nil, err
+	}
+
+	sp := s.tracer.start("build", map[string]interface{}{"mbt.commit": m.Sha, "mbt.manifest.modules": len(m.Modules)})
+	reports := &reportCollector{}
+	var summary *BuildSummary
+	err = s.execBuildHooks(hookSetup, config, m, options, nil)
+	if err == nil {
+		summary, err = s.schedule(m, options, func(cmd *Cmd, a *Module, options *CmdOptions) ([]*BuildResult, error) {
+			return s.buildTracked(cmd, config, m, a, options, j, st, fp, bc, reports)
+		})
+	}
+	// Failure of a teardown hook after a failure should not mask the
+	// original error.
+	if herr := s.execBuildHooks(hookTeardown, config, m, options, err); herr != nil {
+		if err == nil {
+			err = herr
+		} else {
+			s.Log.Warn(herr)
+		}
+	}
+	if summary != nil {
+		resumed := make(map[string]bool)
+		for _, r := range summary.Completed {
+			resumed[r.Module.Name()] = r.Resumed || r.Satisfied || r.Unaffected || r.Cached
+		}
+		s.tracer.traceModules(sp, summary.Timings, resumed)
+	}
+	sp.finish(err)
+
+	if summary == nil {
+		summary = &BuildSummary{}
+	}
+	summary.Manifest = m
+	summary.TestReport = reports.report()
+
+	// Reports are written even if the build failed, since they are
+	// most useful in that case.
+	if rerr := s.writeReports(reports, options); rerr != nil && err == nil {
+		err = rerr
+	}
+
+	invocation := summary.InvocationSummary(err)
+	s.pushMetrics(config.Metrics, invocation)
+	s.notify(config, invocation)
+	s.reportToPlugins(m.Dir, config, invocation)
+	if serr := writeInvocationSummary(options, invocation); serr != nil && err == nil {
+		err = serr
+	}
+	emitRunFinish(options, invocation, started)
+
+	// Summary of the modules processed is returned on error as well
+	// so that it can be reported.
+	return summary, err
 }
 
-func (s *stdSystem) buildManifest(m *Manifest, options *CmdOptions) (*BuildSummary, error) {
-	completed := make([]*BuildResult, 0)
-	skipped := make([]*Module, 0)
+func (s *stdSystem) writeReports(reports *reportCollector, options *CmdOptions) error {
+	r := reports.report()
+	if r == nil {
+		return nil
+	}
+
+	s.Log.Infof(msgTestReportSummary, r.Tests, r.Failures, r.Errors, r.Skipped, len(r.Files))
+	if options.ReportFile == "" {
+		return nil
+	}
+
+	return reports.write(options.ReportFile)
+}
+
+// buildTracked builds a module while recording the progress in the
+// journal and the outcome in the build stats.
+// Failed builds of flaky modules are retried up to options.FlakyRetries
+// times. Fingerprint of the module is recorded after a successful build.
+// Outputs of the module are restored from the build cache bc (if any)
+// instead of building it when the cache has its version.
+func (s *stdSystem) buildTracked(cmd *Cmd, config *RepoConfig, m *Manifest, a *Module, options *CmdOptions, j *journal, st *stats, fp *fingerprints, bc *buildCache, reports *reportCollector) ([]*BuildResult, error) {
+	key, err := journalKey(config, a, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Resume && j.completed(a, key) {
+		s.Log.Infof(msgResumedModule, a.Name(), a.Version())
+		return []*BuildResult{{Module: a, Resumed: true}}, nil
+	}
 
-	for _, a := range m.Modules {
-		cmd, ok := s.canBuildHere(a)
-		if !ok {
-			skipped = append(skipped, a)
-			options.Callback(a, CmdStageSkipBuild, nil)
-			continue
+	satisfied, err := s.probe(config, m, a, options)
+	if err != nil {
+		return nil, err
+	}
+	if satisfied {
+		s.Log.Infof(msgSatisfiedModule, a.Name(), a.Version())
+		if jerr := j.record(a, key, journalStatusCompleted); jerr != nil {
+			return nil, jerr
+		}
+		return []*BuildResult{{Module: a, Satisfied: true}}, nil
+	}
+
+	if !options.IgnoreFingerprints && fp.unaffected(a) {
+		s.Log.Infof(msgUnaffectedModule, a.Name(), a.Version())
+		if jerr := j.record(a, key, journalStatusCompleted); jerr != nil {
+			return nil, jerr
 		}
+		return []*BuildResult{{Module: a, Unaffected: true}}, nil
+	}
 
-		options.Callback(a, CmdStageBeforeBuild, nil)
-		err := s.execBuild(cmd, m, a, options)
+	if bc != nil && !usesDockerBuild(a) {
+		cached, err := s.restoreFromBuildCache(bc, config, m, a, options)
 		if err != nil {
 			return nil, err
 		}
-		options.Callback(a, CmdStageAfterBuild, nil)
-		completed = append(completed, &BuildResult{Module: a})
+		if cached {
+			s.Log.Infof(msgCachedModule, a.Name(), a.Version())
+			if jerr := j.record(a, key, journalStatusCompleted); jerr != nil {
+				return nil, jerr
+			}
+			return []*BuildResult{{Module: a, Cached: true}}, nil
+		}
+	}
+
+	// Modules resumed or restored from the cache are not reported as
+	// being built.
+	options.Callback(a, CmdStageBeforeBuild, nil)
+	err = j.record(a, key, journalStatusStarted)
+	if err != nil {
+		return nil, err
 	}
 
-	return &BuildSummary{Manifest: m, Completed: completed, Skipped: skipped}, nil
+	attempts := 1
+	if options.FlakyRetries > 0 && st.flaky(a) {
+		attempts += options.FlakyRetries
+	}
+
+	var results []*BuildResult
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			s.Log.Warnf(msgRetryingFlakyModule, a.Name(), i, options.FlakyRetries, err)
+		}
+
+		results, err = s.buildModule(cmd, config, m, a, options, bc, reports)
+		if serr := st.record(a, i > 0, err); serr != nil {
+			return nil, serr
+		}
+
+		if err == nil {
+			break
+		}
+	}
+
+	if err == nil {
+		var fingerprint string
+		fingerprint, err = s.fingerprint(config, m, a, options)
+		if err == nil {
+			err = fp.record(a, fingerprint)
+		}
+	}
+
+	status := journalStatusCompleted
+	if err != nil {
+		status = journalStatusFailed
+	}
+
+	if jerr := j.record(a, key, status); jerr != nil && err == nil {
+		err = jerr
+	}
+
+	return results, err
 }
 
-func (s *stdSystem) execBuild(buildCmd *Cmd, manifest *Manifest, module *Module, options *CmdOptions) error {
-	err := s.ProcessManager.Exec(manifest, module, options, buildCmd.Cmd, buildCmd.Args...)
+// buildModule builds all variants of a module.
+func (s *stdSystem) buildModule(cmd *Cmd, config *RepoConfig, m *Manifest, a *Module, options *CmdOptions, bc *buildCache, reports *reportCollector) ([]*BuildResult, error) {
+	options, err := withModuleEnvironment(config, a, options)
 	if err != nil {
-		return e.Wrapf(ErrClassUser, err, msgFailedBuild, module.Name())
+		return nil, err
+	}
+	options = withReleaseOptions(options, a)
+
+	variants := a.Variants()
+	if len(variants) == 0 {
+		artifacts, err := s.execBuild(cmd, config, m, a, nil, options, bc, reports)
+		if err != nil {
+			return nil, err
+		}
+		return []*BuildResult{{Module: a, Artifacts: artifacts}}, nil
+	}
+
+	results := make([]*BuildResult, 0, len(variants))
+	for _, v := range variants {
+		s.Log.Infof(msgBuildingVariant, v.Name, a.Name())
+		variantOptions := *options
+		variantOptions.Env = append(append([]string{}, options.Env...), v.environment()...)
+		artifacts, err := s.execBuild(cmd, config, m, a, v, &variantOptions, bc, reports)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgFailedBuildVariant, v.Name, a.Name())
+		}
+		results = append(results, &BuildResult{Module: a, Variant: v, Artifacts: artifacts})
+	}
+
+	return results, nil
+}
+
+func (s *stdSystem) execBuild(buildCmd *Cmd, config *RepoConfig, manifest *Manifest, module *Module, variant *Variant, options *CmdOptions, bc *buildCache, reports *reportCollector) ([]*Artifact, error) {
+	docker := usesDockerBuild(module)
+	if docker && s.dockerImagePushed(module, variant) {
+		return nil, nil
+	}
+
+	if options.Sandbox {
+		sb, err := s.createSandbox(manifest, module)
+		if err != nil {
+			return nil, err
+		}
+		defer s.disposeSandbox(sb)
+		manifest = sb.manifest(manifest)
+	}
+
+	var artifacts []*Artifact
+	began := time.Now().Truncate(time.Second)
+	err := s.execHooks(hookPreBuild, config, manifest, module, options, nil)
+	if err == nil {
+		// Timestamps of some file systems have a resolution of a second.
+		started := time.Now().Truncate(time.Second)
+		if docker {
+			artifacts, err = s.execDockerBuild(manifest, module, variant, options)
+		} else {
+			err = s.execSpecCmd(manifest, module, options, buildCmd.Shell, buildCmd.Dir, buildCmd.Cmd, buildCmd.Args)
+		}
+		if err != nil {
+			err = e.Wrapf(ErrClassUser, err, msgFailedBuild, module.Name())
+		}
+
+		// Reports are collected before the sandbox is disposed.
+		if rerr := reports.collect(manifest, module, variant, started); rerr != nil {
+			if err == nil {
+				err = rerr
+			} else {
+				s.Log.Warn(rerr)
+			}
+		}
+	}
+
+	if err == nil {
+		err = s.execHooks(hookPostBuild, config, manifest, module, options, nil)
+	}
+
+	// Outputs are published after the post build hooks, so that the
+	// hooks can package (or sign) them.
+	if err == nil && options.Publish {
+		var published []*Artifact
+		published, err = s.publishOutputs(config, manifest, module, variant)
+		artifacts = append(artifacts, published...)
+	}
+
+	if err == nil && options.ProvenanceDir != "" {
+		var statement *Statement
+		statement, err = s.provenance(config, manifest, module, variant, buildCmd, artifacts, options, began)
+		if err == nil {
+			err = s.writeProvenance(statement, module, variant, options)
+		}
+	}
+
+	if err == nil && !docker {
+		// Outputs are stored before the sandbox is disposed.
+		s.storeInBuildCache(bc, manifest, module, variant, options.Env)
+	}
+
+	if err != nil {
+		// Failure of an onFailure hook should not mask the original error.
+		if herr := s.execHooks(hookOnFailure, config, manifest, module, options, err); herr != nil {
+			s.Log.Warn(herr)
+		}
+		return nil, err
+	}
+
+	return artifacts, nil
+}
+
+func (s *stdSystem) disposeSandbox(sb *sandbox) {
+	if err := sb.dispose(); err != nil {
+		s.Log.Warnf(msgFailedSandboxCleanup, sb.dir, err)
 	}
-	return nil
 }
 
 func (s *stdSystem) canBuildHere(mod *Module) (*Cmd, bool) {
+	if !s.isMaterialized(mod) {
+		return nil, false
+	}
+
+	if usesDockerBuild(mod) {
+		return dockerBuildCmd(mod, nil, false, ""), true
+	}
+
 	c, ok := mod.Build()[runtime.GOOS]
 
 	if !ok {
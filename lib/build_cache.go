@@ -0,0 +1,522 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// buildCacheBackend stores the files of the build cache by name.
+// get returns nil if the file is not in the backend.
+type buildCacheBackend interface {
+	get(name string) ([]byte, error)
+	put(name string, content []byte) error
+	String() string
+}
+
+// buildCacheState is the state document recording the local directory
+// of the build cache, so that it can be collected by the cache commands.
+const buildCacheState = "build-cache.json"
+
+type buildCacheLocation struct {
+	Dir string `json:"dir"`
+}
+
+// buildCache contains the outputs of the modules built, keyed by the
+// version of the module (which is derived from its content and the
+// versions of its dependencies), so that a module built at the same
+// version is not built again in another branch or checkout.
+// Entries are read from the local directory first and then the remote
+// backend. Local entries are created for the entries read from the
+// remote backend.
+// Entries of the remote backend are signed and verified as specified in
+// trust, since they may be written by other machines.
+type buildCache struct {
+	local    buildCacheBackend
+	remote   buildCacheBackend
+	readOnly bool
+	env      string
+	trust    *CacheConfig
+}
+
+// openBuildCache returns the build cache specified in options, nil if
+// none is specified. Local directory is recorded in the state.
+func (s *stdSystem) openBuildCache(config *RepoConfig, options *CmdOptions) (*buildCache, error) {
+	if options.CacheDir == "" && options.CacheRemote == "" {
+		return nil, nil
+	}
+
+	c := &buildCache{readOnly: options.CacheReadOnly, env: options.Environment, trust: config.Cache}
+	if options.CacheDir != "" {
+		dir, err := filepath.Abs(options.CacheDir)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgInvalidBuildCache, options.CacheDir)
+		}
+		c.local = dirBuildCache(dir)
+
+		if err := s.writeState(buildCacheState, &buildCacheLocation{Dir: dir}); err != nil {
+			s.Log.Warn(err)
+		}
+	}
+
+	if options.CacheRemote != "" {
+		remote, err := newRemoteBuildCache(options.CacheRemote)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgInvalidBuildCache, options.CacheRemote)
+		}
+		c.remote = remote
+	}
+
+	return c, nil
+}
+
+// newRemoteBuildCache creates the backend of a remote cache url
+// (http://, https:// or s3://).
+func newRemoteBuildCache(u string) (buildCacheBackend, error) {
+	switch {
+	case strings.HasPrefix(u, "http://"), strings.HasPrefix(u, "https://"):
+		return httpBuildCache(strings.TrimSuffix(u, "/")), nil
+	case strings.HasPrefix(u, "s3://"):
+		return s3BuildCache(u), nil
+	default:
+		return nil, fmt.Errorf("unsupported scheme, expected http, https or s3")
+	}
+}
+
+// key is the key of the entry of a module (or a variant of it) built
+// with the environment variables env (key=value).
+// Platform, the environment and the variables are included since they
+// may change the build command and the outputs.
+func (c *buildCache) key(mod *Module, variant *Variant, env []string) string {
+	return buildInputsKey(mod, variant, c.env, env)
+}
+
+// buildInputsKey is a hash of the inputs of a build of a module (or a
+// variant of it) in an environment with the environment variables env
+// (key=value).
+func buildInputsKey(mod *Module, variant *Variant, environment string, env []string) string {
+	v := ""
+	if variant != nil {
+		v = variant.Name
+	}
+	vars := append([]string{}, env...)
+	sort.Strings(vars)
+	parts := append([]string{mod.Name(), mod.Version(), v, runtime.GOOS, environment}, vars...)
+	h := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(h[:])
+}
+
+// cacheEnvironment returns the environment variables a module (or a
+// variant of it) is built with, excluding the ones set by mbt for every
+// build (such as MBT_MODULE_VERSION).
+func cacheEnvironment(config *RepoConfig, mod *Module, variant *Variant, options *CmdOptions) ([]string, error) {
+	options, err := withModuleEnvironment(config, mod, options)
+	if err != nil {
+		return nil, err
+	}
+	env := withReleaseOptions(options, mod).Env
+	if variant != nil {
+		env = append(append([]string{}, env...), variant.environment()...)
+	}
+	return env, nil
+}
+
+func entryFile(key string) string {
+	return key + ".tar.gz"
+}
+
+func signatureFile(key string) string {
+	return key + ".sig.json"
+}
+
+// signedPayload is the payload signed for an entry. It includes the key
+// so that an entry cannot be replaced with the entry of another module.
+func signedPayload(key string, content []byte) []byte {
+	h := sha256.Sum256(content)
+	return []byte(key + " " + hex.EncodeToString(h[:]))
+}
+
+// get returns the content of an entry and the backend it was read from,
+// nil if the entry is not found. Entries read from the remote backend
+// are copied to the local directory if copyLocal is true.
+func (c *buildCache) get(key string, copyLocal bool) ([]byte, string, error) {
+	if c.local != nil {
+		content, err := c.local.get(entryFile(key))
+		if err != nil || content != nil {
+			return content, c.local.String(), err
+		}
+	}
+
+	if c.remote == nil {
+		return nil, "", nil
+	}
+
+	content, err := c.remote.get(entryFile(key))
+	if err == nil && content != nil && c.trust.verifies() {
+		err = c.verify(key, content)
+	}
+	if err != nil || content == nil {
+		return nil, c.remote.String(), err
+	}
+
+	if copyLocal && c.local != nil {
+		// Failing to copy the entry does not prevent using it.
+		_ = c.local.put(entryFile(key), content)
+	}
+	return content, c.remote.String(), nil
+}
+
+// verify verifies the signature of an entry read from the remote backend.
+func (c *buildCache) verify(key string, content []byte) error {
+	buff, err := c.remote.get(signatureFile(key))
+	if err != nil {
+		return err
+	}
+
+	var sig *cacheSignature
+	if buff != nil {
+		sig = &cacheSignature{}
+		if err := json.Unmarshal(buff, sig); err != nil {
+			return e.NewErrorf(ErrClassUser, msgInvalidCacheSignature, key)
+		}
+	}
+	return c.trust.verify(key, signedPayload(key, content), sig)
+}
+
+// contains returns true if the cache has the entries of the module (and
+// each of its variants). Entries are not copied from the remote backend.
+func (c *buildCache) contains(config *RepoConfig, a *Module, options *CmdOptions) (bool, error) {
+	variants := []*Variant{nil}
+	if len(a.Variants()) > 0 {
+		variants = a.Variants()
+	}
+
+	for _, v := range variants {
+		env, err := cacheEnvironment(config, a, v, options)
+		if err != nil {
+			return false, err
+		}
+
+		content, _, err := c.get(c.key(a, v, env), false)
+		if err != nil || content == nil {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// restoreFromBuildCache extracts the outputs of the module (and each of its variants)
+// from the cache. Returns false without changing the module directory if
+// an entry is missing or if it fails the verification.
+func (s *stdSystem) restoreFromBuildCache(c *buildCache, config *RepoConfig, m *Manifest, a *Module, options *CmdOptions) (bool, error) {
+	variants := []*Variant{nil}
+	if len(a.Variants()) > 0 {
+		variants = a.Variants()
+	}
+
+	entries := make([][]byte, 0, len(variants))
+	for _, v := range variants {
+		env, err := cacheEnvironment(config, a, v, options)
+		if err != nil {
+			return false, err
+		}
+		content, from, err := c.get(c.key(a, v, env), true)
+		if err != nil {
+			s.Log.Warnf(msgFailedReadBuildCache, a.Name(), from, err)
+			return false, nil
+		}
+		if content == nil {
+			return false, nil
+		}
+		entries = append(entries, content)
+	}
+
+	dir := filepath.Join(m.Dir, a.Path())
+	for _, content := range entries {
+		if err := extractOutputs(dir, content); err != nil {
+			return false, e.Wrapf(ErrClassUser, err, msgFailedRestoreBuildCache, a.Name())
+		}
+	}
+	return true, nil
+}
+
+// storeInBuildCache stores the outputs of a module (or a variant of it)
+// just built with the environment variables env. Failures are reported as warnings since the build
+// succeeded.
+// When the remote entries are verified, only the signed entries are
+// stored in the remote backend since others would not be used.
+func (s *stdSystem) storeInBuildCache(c *buildCache, m *Manifest, a *Module, variant *Variant, env []string) {
+	if c == nil || c.readOnly {
+		return
+	}
+
+	content, err := emptyOutputArchive()
+	if len(a.Outputs()) > 0 {
+		var archive *outputArchive
+		archive, err = archiveOutputs(m, a, variant)
+		if archive != nil {
+			content = archive.content
+		}
+	}
+	if err != nil {
+		s.Log.Warn(err)
+		return
+	}
+
+	key := c.key(a, variant, env)
+	if c.local != nil {
+		if err := c.local.put(entryFile(key), content); err != nil {
+			s.Log.Warnf(msgFailedWriteBuildCache, a.Name(), c.local.String(), err)
+		}
+	}
+
+	if c.remote == nil {
+		return
+	}
+
+	if c.trust.verifies() {
+		if !c.trust.signs() {
+			return
+		}
+
+		sig, err := c.trust.sign(key, signedPayload(key, content))
+		if err == nil {
+			var buff []byte
+			buff, err = json.Marshal(sig)
+			if err == nil {
+				// Signature is written first so that the entry is never
+				// read without it.
+				err = c.remote.put(signatureFile(key), buff)
+			}
+		}
+		if err != nil {
+			s.Log.Warnf(msgFailedWriteBuildCache, a.Name(), c.remote.String(), err)
+			return
+		}
+	}
+
+	if err := c.remote.put(entryFile(key), content); err != nil {
+		s.Log.Warnf(msgFailedWriteBuildCache, a.Name(), c.remote.String(), err)
+	}
+}
+
+func emptyOutputArchive() ([]byte, error) {
+	buff := new(bytes.Buffer)
+	gz := gzip.NewWriter(buff)
+	if err := tar.NewWriter(gz).Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+// extractOutputs writes the files in an archive created by
+// archiveOutputs to dir.
+func extractOutputs(dir string, content []byte) error {
+	gz, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(h.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid path %s", h.Name)
+		}
+
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(h.Mode).Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// dirBuildCache stores the entries in a local directory.
+type dirBuildCache string
+
+func (d dirBuildCache) path(name string) string {
+	return filepath.Join(string(d), name[:2], name)
+}
+
+func (d dirBuildCache) get(name string) ([]byte, error) {
+	content, err := ioutil.ReadFile(d.path(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return content, err
+}
+
+// put writes the entry to a temporary file first, so that concurrent
+// builds never read a partial entry.
+func (d dirBuildCache) put(name string, content []byte) error {
+	p := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(p), name)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(content)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (d dirBuildCache) String() string {
+	return string(d)
+}
+
+// httpBuildCache stores the entries with GET and PUT requests to
+// <url>/<key>.tar.gz (and their signatures to <url>/<key>.sig.json).
+// Credentials can be specified in the url.
+type httpBuildCache string
+
+func (u httpBuildCache) get(name string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, string(u)+"/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	return sendBuildCacheRequest(req)
+}
+
+func (u httpBuildCache) put(name string, content []byte) error {
+	req, err := http.NewRequest(http.MethodPut, string(u)+"/"+name, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", buildCacheContentType(name))
+	_, err = sendBuildCacheRequest(req)
+	return err
+}
+
+func (u httpBuildCache) String() string {
+	// Credentials are not included.
+	if parsed, err := url.Parse(string(u)); err == nil {
+		parsed.User = nil
+		return parsed.String()
+	}
+	return "<invalid url>"
+}
+
+// s3BuildCache stores the entries in an S3 bucket as
+// <prefix>/<key>.tar.gz (and their signatures as <prefix>/<key>.sig.json), with the credentials in the standard AWS
+// environment variables.
+type s3BuildCache string
+
+func (u s3BuildCache) get(name string) ([]byte, error) {
+	req, _, err := newS3Request(http.MethodGet, string(u), name, nil)
+	if err != nil {
+		return nil, err
+	}
+	return sendBuildCacheRequest(req)
+}
+
+func (u s3BuildCache) put(name string, content []byte) error {
+	req, _, err := newS3Request(http.MethodPut, string(u), name, content)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", buildCacheContentType(name))
+	_, err = sendBuildCacheRequest(req)
+	return err
+}
+
+func (u s3BuildCache) String() string {
+	return string(u)
+}
+
+func buildCacheContentType(name string) string {
+	if strings.HasSuffix(name, ".json") {
+		return "application/json"
+	}
+	return "application/gzip"
+}
+
+// sendBuildCacheRequest sends a request to a remote cache and returns
+// the body of the response, nil if the entry is not found.
+func sendBuildCacheRequest(req *http.Request) ([]byte, error) {
+	res, err := (&http.Client{Timeout: publishTimeout}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	// S3 responds with 403 for missing objects unless the credentials
+	// allow listing the bucket.
+	if req.Method == http.MethodGet && (res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusForbidden) {
+		return nil, nil
+	}
+	if res.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("unexpected response %s", res.Status)
+	}
+
+	return ioutil.ReadAll(res.Body)
+}
@@ -0,0 +1,407 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func initBuildCacheRepo(t *testing.T) *TestRepository {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:    "app-a",
+		Build:   map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Outputs: []string{"dist/**"},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo building app-a && mkdir -p dist/bin && echo a > dist/app.txt && echo b > dist/bin/b.txt"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:         "app-b",
+		Build:        map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Dependencies: []string{"app-a"},
+	}))
+	check(t, repo.WriteShellScript("app-b/build.sh", "echo building app-b"))
+	check(t, repo.WriteContent(".gitignore", "dist\n"))
+	check(t, repo.Commit("first"))
+
+	return repo
+}
+
+// buildWithCache builds the repository with the specified cache options
+// and returns the output and the statuses of the modules.
+func buildWithCache(t *testing.T, configure func(*CmdOptions)) (string, map[string]string) {
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.SummaryFile = ".tmp/summary.json"
+	configure(options)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	statuses := make(map[string]string)
+	for _, m := range readInvocationSummary(t, ".tmp/summary.json").Modules {
+		statuses[m.Name] = m.Status
+	}
+	return buff.String(), statuses
+}
+
+func TestBuildCacheInDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initBuildCacheRepo(t)
+	withDir := func(o *CmdOptions) { o.CacheDir = ".tmp/cache" }
+
+	out, statuses := buildWithCache(t, withDir)
+	assert.Equal(t, "building app-a\nbuilding app-b\n", out)
+	assert.Equal(t, map[string]string{"app-a": ModuleStatusSucceeded, "app-b": ModuleStatusSucceeded}, statuses)
+
+	// Outputs are restored in a clean checkout.
+	check(t, os.RemoveAll(".tmp/repo/app-a/dist"))
+	out, statuses = buildWithCache(t, withDir)
+	assert.Equal(t, "", out)
+	assert.Equal(t, map[string]string{"app-a": ModuleStatusCached, "app-b": ModuleStatusCached}, statuses)
+
+	content, err := ioutil.ReadFile(".tmp/repo/app-a/dist/bin/b.txt")
+	check(t, err)
+	assert.Equal(t, "b\n", string(content))
+}
+
+func TestBuildCacheOfChangedModule(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	repo := initBuildCacheRepo(t)
+	withDir := func(o *CmdOptions) { o.CacheDir = ".tmp/cache" }
+	buildWithCache(t, withDir)
+
+	// Dependents are built again since their versions change as well.
+	check(t, repo.WriteContent("app-a/foo", "bar"))
+	check(t, repo.Commit("second"))
+	out, statuses := buildWithCache(t, withDir)
+	assert.Equal(t, "building app-a\nbuilding app-b\n", out)
+	assert.Equal(t, map[string]string{"app-a": ModuleStatusSucceeded, "app-b": ModuleStatusSucceeded}, statuses)
+}
+
+func TestBuildCacheOfChangedEnvironment(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initBuildCacheRepo(t)
+	withEnv := func(env ...string) func(*CmdOptions) {
+		return func(o *CmdOptions) {
+			o.CacheDir = ".tmp/cache"
+			o.Env = env
+		}
+	}
+	buildWithCache(t, withEnv("FOO=bar", "BAZ=qux"))
+
+	// Order of the variables does not matter.
+	out, statuses := buildWithCache(t, withEnv("BAZ=qux", "FOO=bar"))
+	assert.Equal(t, "", out)
+	assert.Equal(t, map[string]string{"app-a": ModuleStatusCached, "app-b": ModuleStatusCached}, statuses)
+
+	out, statuses = buildWithCache(t, withEnv("FOO=baz", "BAZ=qux"))
+	assert.Equal(t, "building app-a\nbuilding app-b\n", out)
+	assert.Equal(t, map[string]string{"app-a": ModuleStatusSucceeded, "app-b": ModuleStatusSucceeded}, statuses)
+}
+
+func TestBuildCacheKeyOfModuleEnvironment(t *testing.T) {
+	initBuildCacheRepo(t)
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+	a := m.Modules.indexByName()["app-a"]
+	c := &buildCache{}
+	key := func(config *RepoConfig, options *CmdOptions) string {
+		env, err := cacheEnvironment(config, a, nil, options)
+		check(t, err)
+		return c.key(a, nil, env)
+	}
+
+	base := key(&RepoConfig{}, &CmdOptions{})
+	assert.Equal(t, base, key(&RepoConfig{}, &CmdOptions{}))
+	assert.NotEqual(t, base, key(&RepoConfig{Env: map[string]string{"FOO": "bar"}}, &CmdOptions{}))
+	assert.NotEqual(t, base, key(&RepoConfig{}, &CmdOptions{Env: []string{"FOO=bar"}}))
+	assert.Equal(t,
+		key(&RepoConfig{Env: map[string]string{"FOO": "bar"}}, &CmdOptions{}),
+		key(&RepoConfig{}, &CmdOptions{Env: []string{"FOO=bar"}}))
+}
+
+func TestBuildPlanOfCachedModules(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initBuildCacheRepo(t)
+	options := stdTestCmdOptions(nil)
+	options.Plan = true
+	options.CacheDir = ".tmp/cache"
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+	assert.Equal(t, "", summary.Plan.Groups[0][0].Outcome)
+
+	buildWithCache(t, func(o *CmdOptions) { o.CacheDir = ".tmp/cache" })
+
+	summary, err = NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+	assert.Equal(t, ModuleStatusCached, summary.Plan.Groups[0][0].Outcome)
+	assert.Equal(t, ModuleStatusCached, summary.Plan.Groups[1][0].Outcome)
+}
+
+func TestReadOnlyBuildCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initBuildCacheRepo(t)
+
+	buildWithCache(t, func(o *CmdOptions) {
+		o.CacheDir = ".tmp/cache"
+		o.CacheReadOnly = true
+	})
+
+	_, err := os.Stat(".tmp/cache")
+	assert.True(t, os.IsNotExist(err))
+}
+
+type cacheServer struct {
+	sync.Mutex
+	server  *httptest.Server
+	entries map[string][]byte
+}
+
+func startCacheServer(t *testing.T) *cacheServer {
+	s := &cacheServer{entries: make(map[string][]byte)}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Lock()
+		defer s.Unlock()
+
+		switch r.Method {
+		case http.MethodGet:
+			content, ok := s.entries[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(content)
+		case http.MethodPut:
+			buff, err := ioutil.ReadAll(r.Body)
+			check(t, err)
+			s.entries[r.URL.Path] = buff
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	return s
+}
+
+func TestRemoteBuildCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	server := startCacheServer(t)
+	defer server.server.Close()
+
+	initBuildCacheRepo(t)
+	withRemote := func(o *CmdOptions) { o.CacheRemote = server.server.URL + "/cache" }
+
+	buildWithCache(t, withRemote)
+	assert.Len(t, server.entries, 2)
+
+	check(t, os.RemoveAll(".tmp/repo/app-a/dist"))
+	out, statuses := buildWithCache(t, func(o *CmdOptions) {
+		withRemote(o)
+		o.CacheDir = ".tmp/cache"
+	})
+	assert.Equal(t, "", out)
+	assert.Equal(t, map[string]string{"app-a": ModuleStatusCached, "app-b": ModuleStatusCached}, statuses)
+	assert.FileExists(t, ".tmp/repo/app-a/dist/app.txt")
+
+	// Entries read from the remote cache are copied to the local cache.
+	files, err := filepath.Glob(".tmp/cache/*/*.tar.gz")
+	check(t, err)
+	assert.Len(t, files, 2)
+}
+
+func TestS3BuildCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	server := startCacheServer(t)
+	defer server.server.Close()
+
+	for k, v := range map[string]string{
+		"AWS_ENDPOINT_URL_S3":   server.server.URL,
+		"AWS_ACCESS_KEY_ID":     "AKID",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+	} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	initBuildCacheRepo(t)
+	withRemote := func(o *CmdOptions) { o.CacheRemote = "s3://builds/cache" }
+
+	buildWithCache(t, withRemote)
+	assert.Len(t, server.entries, 2)
+	for p := range server.entries {
+		assert.Regexp(t, "^/builds/cache/[0-9a-f]{64}.tar.gz$", p)
+	}
+
+	out, statuses := buildWithCache(t, withRemote)
+	assert.Equal(t, "", out)
+	assert.Equal(t, map[string]string{"app-a": ModuleStatusCached, "app-b": ModuleStatusCached}, statuses)
+}
+
+// writeBuildCacheConfig commits the cache configuration of the
+// repository created by initBuildCacheRepo.
+func writeBuildCacheConfig(t *testing.T, repo *TestRepository, cache *CacheConfig) {
+	check(t, repo.WriteConfig(&RepoConfig{Cache: cache}))
+	check(t, repo.Commit("cache config"))
+}
+
+func TestSignedRemoteBuildCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	os.Setenv("MBT_TEST_CACHE_KEY", "secret")
+	defer os.Unsetenv("MBT_TEST_CACHE_KEY")
+
+	server := startCacheServer(t)
+	defer server.server.Close()
+
+	repo := initBuildCacheRepo(t)
+	writeBuildCacheConfig(t, repo, &CacheConfig{HMACKeyEnv: "MBT_TEST_CACHE_KEY", Sign: true})
+	withRemote := func(o *CmdOptions) { o.CacheRemote = server.server.URL + "/cache" }
+
+	buildWithCache(t, withRemote)
+	assert.Len(t, server.entries, 4)
+	for p, content := range server.entries {
+		if strings.HasSuffix(p, ".sig.json") {
+			assert.Contains(t, string(content), `{"hmac":"`)
+		}
+	}
+
+	_, statuses := buildWithCache(t, withRemote)
+	assert.Equal(t, map[string]string{"app-a": ModuleStatusCached, "app-b": ModuleStatusCached}, statuses)
+
+	// Entries replaced in the remote cache are rejected and the modules
+	// are built again.
+	for p := range server.entries {
+		if strings.HasSuffix(p, ".tar.gz") {
+			content, err := emptyOutputArchive()
+			check(t, err)
+			server.entries[p] = append(content, 0)
+		}
+	}
+	out, statuses := buildWithCache(t, withRemote)
+	assert.Equal(t, "building app-a\nbuilding app-b\n", out)
+	assert.Equal(t, map[string]string{"app-a": ModuleStatusSucceeded, "app-b": ModuleStatusSucceeded}, statuses)
+
+	// Entries signed with another key are rejected.
+	os.Setenv("MBT_TEST_CACHE_KEY", "other")
+	out, _ = buildWithCache(t, withRemote)
+	assert.Equal(t, "building app-a\nbuilding app-b\n", out)
+}
+
+func TestUnsignedRemoteBuildCacheEntriesAreRejected(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	os.Setenv("MBT_TEST_CACHE_KEY", "secret")
+	defer os.Unsetenv("MBT_TEST_CACHE_KEY")
+
+	server := startCacheServer(t)
+	defer server.server.Close()
+
+	repo := initBuildCacheRepo(t)
+	withRemote := func(o *CmdOptions) { o.CacheRemote = server.server.URL + "/cache" }
+	buildWithCache(t, withRemote)
+	assert.Len(t, server.entries, 2)
+
+	// Module versions do not depend on the configuration, so the
+	// entries stored without signatures match.
+	writeBuildCacheConfig(t, repo, &CacheConfig{HMACKeyEnv: "MBT_TEST_CACHE_KEY"})
+	out, _ := buildWithCache(t, withRemote)
+	assert.Equal(t, "building app-a\nbuilding app-b\n", out)
+
+	// Entries are not stored unless they are signed.
+	assert.Len(t, server.entries, 2)
+}
+
+func TestUnavailableRemoteBuildCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	initBuildCacheRepo(t)
+
+	out, _ := buildWithCache(t, func(o *CmdOptions) { o.CacheRemote = server.URL })
+	assert.Equal(t, "building app-a\nbuilding app-b\n", out)
+}
+
+func TestInvalidRemoteBuildCache(t *testing.T) {
+	initBuildCacheRepo(t)
+
+	options := stdTestCmdOptions(nil)
+	options.CacheRemote = "ftp://cache"
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid build cache ftp://cache")
+}
+
+func TestExtractOutputsOutsideDirectory(t *testing.T) {
+	clean()
+
+	buff := new(bytes.Buffer)
+	gz := gzip.NewWriter(buff)
+	tw := tar.NewWriter(gz)
+	check(t, tw.WriteHeader(&tar.Header{Name: "../evil.txt", Mode: 0644, Size: 4, Typeflag: tar.TypeReg}))
+	_, err := tw.Write([]byte("evil"))
+	check(t, err)
+	check(t, tw.Close())
+	check(t, gz.Close())
+
+	assert.Error(t, extractOutputs(".tmp/out", buff.Bytes()))
+	_, err = os.Stat(".tmp/evil.txt")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -20,7 +20,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
 	"testing"
@@ -107,13 +109,13 @@ func TestBuildSkip(t *testing.T) {
 	case "linux", "darwin":
 		check(t, repo.InitModuleWithOptions("app-a", &Spec{
 			Name:  "app-a",
-			Build: map[string]*Cmd{"windows": {"powershell", []string{"-ExecutionPolicy", "Bypass", "-File", ".\\build.ps1"}}},
+			Build: map[string]*Cmd{"windows": {Cmd: "powershell", Args: []string{"-ExecutionPolicy", "Bypass", "-File", ".\\build.ps1"}}},
 		}))
 		check(t, repo.WritePowershellScript("app-a/build.ps1", "write-host built app-a"))
 	case "windows":
 		check(t, repo.InitModuleWithOptions("app-a", &Spec{
 			Name:  "app-a",
-			Build: map[string]*Cmd{"darwin": {"./build.sh", []string{}}},
+			Build: map[string]*Cmd{"darwin": {Cmd: "./build.sh", Args: []string{}}},
 		}))
 		check(t, repo.WriteShellScript("app-a/build.sh", "echo built app-a"))
 	}
@@ -485,6 +487,47 @@ func TestBuildEnvironment(t *testing.T) {
 	assert.Equal(t, fmt.Sprintf("%s-%s-%s-%s-%s-%s\n", m.Sha, m.Modules[0].Version(), m.Modules[0].Name(), m.Modules[0].Path(), expectedRepoPath, m.Modules[0].Properties()["foo"]), out)
 }
 
+func TestBuildWithEnvironment(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on windows")
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:       "app-a",
+		Templates:  true,
+		Build:      map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{"{{.Environment}}", `{{property .Module "db.port"}}`}}},
+		Properties: map[string]interface{}{"foo": "bar", "db": map[string]interface{}{"host": "localhost", "port": 5432}},
+		Environments: map[string]*Environment{
+			"prod": {Properties: map[string]interface{}{"foo": "baz", "db": map[string]interface{}{"host": "db.prod"}}},
+		},
+	}))
+
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo $MBT_ENVIRONMENT-$MBT_MODULE_PROPERTY_FOO-$1-$2"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Environment = "prod"
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+	assert.Equal(t, "prod-baz-prod-5432\n", buff.String())
+
+	buff = new(bytes.Buffer)
+	options = stdTestCmdOptions(buff)
+	options.Environment = "dev"
+	_, err = NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+	assert.Equal(t, "dev-bar-dev-5432\n", buff.String())
+
+	buff = new(bytes.Buffer)
+	_, err = NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+	check(t, err)
+	assert.Equal(t, "-bar--5432\n", buff.String())
+}
+
 func TestDefaultBuild(t *testing.T) {
 	clean()
 	repo := NewTestRepo(t, ".tmp/repo")
@@ -749,3 +792,611 @@ func TestRestorationOnBuildFailure(t *testing.T) {
 	check(t, err)
 	assert.Equal(t, 0, numDeltas)
 }
+
+func TestBuildInSandbox(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name: "app-a",
+		Build: map[string]*Cmd{
+			"darwin":  {Cmd: "./build.sh", Args: []string{}},
+			"linux":   {Cmd: "./build.sh", Args: []string{}},
+			"windows": {Cmd: "powershell", Args: []string{"-ExecutionPolicy", "Bypass", "-File", ".\\build.ps1"}},
+		},
+		FileDependencies: []string{"shared/lib.txt"},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "cat ../shared/lib.txt\nls ../app-b 2>/dev/null || echo isolated"))
+	check(t, repo.WritePowershellScript("app-a/build.ps1", "write-host (get-content ..\\shared\\lib.txt)\nif (-not (test-path ..\\app-b)) { write-host isolated }"))
+	check(t, repo.WriteContent("shared/lib.txt", "shared\n"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.WriteShellScript("app-b/build.sh", "echo built app-b"))
+	check(t, repo.WritePowershellScript("app-b/build.ps1", "write-host built app-b"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Sandbox = true
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(ExactMatchFilter("app-a"), options)
+	check(t, err)
+
+	assert.Equal(t, "shared\nisolated\n", buff.String())
+}
+
+func TestBuildInSandboxDoesNotModifyWorkspace(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo output > out.txt\necho built app-a"))
+	check(t, repo.WritePowershellScript("app-a/build.ps1", "set-content -path out.txt -value output\nwrite-host built app-a"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Sandbox = true
+	_, err := NewWorld(t, ".tmp/repo").System.BuildWorkspace(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "built app-a\n", buff.String())
+	_, err = os.Stat(filepath.Join(repo.Dir, "app-a", "out.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestBuildInContainer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Image: "golang:1.10",
+		Build: map[string]*Cmd{
+			"default": {Cmd: "go", Args: []string{"build"}},
+		},
+	}))
+	check(t, repo.WriteShellScript("runtime.sh", "for a in \"$@\"; do echo \"$a\"; done | grep -v MBT_"))
+	check(t, repo.Commit("first"))
+
+	absRepoDir, err := filepath.Abs(repo.Dir)
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.ContainerRuntime = filepath.Join(absRepoDir, "runtime.sh")
+	_, err = NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, fmt.Sprintf(`run
+--rm
+-i
+-v
+%s:/mbt/repo
+-w
+/mbt/repo/app-a
+-e
+-e
+-e
+-e
+-e
+golang:1.10
+go
+build
+`, filepath.ToSlash(absRepoDir)), buff.String())
+}
+
+func TestBuildInContainerEnvironment(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Image: "alpine",
+		Build: map[string]*Cmd{
+			"default": {Cmd: "sh", Args: []string{}},
+		},
+	}))
+	check(t, repo.WriteShellScript("runtime.sh", "for a in \"$@\"; do echo \"$a\"; done | grep MBT_REPO_PATH\necho MBT_REPO_PATH=$MBT_REPO_PATH"))
+	check(t, repo.Commit("first"))
+
+	absRepoDir, err := filepath.Abs(repo.Dir)
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.ContainerRuntime = filepath.Join(absRepoDir, "runtime.sh")
+	_, err = NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "MBT_REPO_PATH\nMBT_REPO_PATH=/mbt/repo\n", buff.String())
+}
+
+func TestBuildInContainerSecretsAreNotInArguments(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:    "app-a",
+		Image:   "alpine",
+		Build:   map[string]*Cmd{"default": {Cmd: "sh", Args: []string{}}},
+		Env:     map[string]string{"PASSWORD": "hunter2"},
+		Secrets: []string{"PASSWORD"},
+	}))
+	check(t, repo.WriteShellScript("runtime.sh", "echo \"$@\" > args.txt\ntest \"$PASSWORD\" = hunter2"))
+	check(t, repo.Commit("first"))
+
+	absRepoDir, err := filepath.Abs(repo.Dir)
+	check(t, err)
+
+	options := stdTestCmdOptions(nil)
+	options.ContainerRuntime = filepath.Join(absRepoDir, "runtime.sh")
+	_, err = NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	args, err := ioutil.ReadFile(filepath.Join(repo.Dir, "app-a", "args.txt"))
+	check(t, err)
+	assert.Contains(t, string(args), "-e PASSWORD ")
+	assert.NotContains(t, string(args), "hunter2")
+}
+
+func TestBuildInContainerFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Image: "alpine",
+		Build: map[string]*Cmd{
+			"default": {Cmd: "false", Args: []string{}},
+		},
+	}))
+	check(t, repo.WriteShellScript("runtime.sh", "exit 3"))
+	check(t, repo.Commit("first"))
+
+	absRepoDir, err := filepath.Abs(repo.Dir)
+	check(t, err)
+
+	options := stdTestCmdOptions(nil)
+	options.ContainerRuntime = filepath.Join(absRepoDir, "runtime.sh")
+	_, err = NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+
+	assert.EqualError(t, err, fmt.Sprintf(msgFailedBuild, "app-a"))
+	assert.Equal(t, 3, err.(*e.E).InnerError().(*exec.ExitError).ExitCode())
+}
+
+func TestBuildHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{
+		Hooks: &Hooks{
+			PreBuild:  []*Cmd{{Cmd: "sh", Args: []string{"-c", "echo repo pre $MBT_MODULE_NAME"}}},
+			PostBuild: []*Cmd{{Cmd: "sh", Args: []string{"-c", "echo repo post $MBT_MODULE_NAME"}}},
+		},
+	}))
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Hooks: &Hooks{
+			PreBuild:  []*Cmd{{Cmd: "sh", Args: []string{"-c", "echo module pre"}}},
+			PostBuild: []*Cmd{{Cmd: "sh", Args: []string{"-c", "echo module post"}}},
+			OnFailure: []*Cmd{{Cmd: "sh", Args: []string{"-c", "echo module failed"}}},
+		},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo built app-a"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+	check(t, err)
+
+	assert.Equal(t, "repo pre app-a\nmodule pre\nbuilt app-a\nmodule post\nrepo post app-a\n", buff.String())
+}
+
+func TestBuildHookContext(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Hooks: &Hooks{
+			PreBuild: []*Cmd{{Cmd: "cat", Args: []string{}}},
+		},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", ""))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+	check(t, err)
+
+	m := summary.Manifest
+	assert.JSONEq(t, fmt.Sprintf(`{
+		"hook": "preBuild",
+		"commit": "%s",
+		"repoPath": "%s",
+		"module": {"name": "app-a", "path": "app-a", "version": "%s"}
+	}`, m.Sha, m.Dir, m.Modules[0].Version()), buff.String())
+}
+
+func TestBuildFailureHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{
+		Hooks: &Hooks{
+			OnFailure: []*Cmd{{Cmd: "sh", Args: []string{"-c", "echo repo failed"}}},
+		},
+	}))
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Hooks: &Hooks{
+			PostBuild: []*Cmd{{Cmd: "sh", Args: []string{"-c", "echo module post"}}},
+			OnFailure: []*Cmd{{Cmd: "sh", Args: []string{"-c", "grep -o 'Failed to build[^\"]*'"}}},
+		},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "exit 1"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgFailedBuild, "app-a"))
+	assert.Equal(t, "Failed to build module 'app-a'\nrepo failed\n", buff.String())
+}
+
+func TestBuildPreBuildHookFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Hooks: &Hooks{
+			PreBuild: []*Cmd{{Cmd: "false", Args: []string{}}},
+		},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo built app-a"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgFailedHook, "preBuild", "false", "app-a"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+	assert.Equal(t, "", buff.String())
+}
+
+func TestBuildSetupAndTeardownHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{
+		Hooks: &Hooks{
+			Setup:    []*Cmd{{Cmd: "sh", Args: []string{"-c", "echo setup $(pwd | xargs basename) $MBT_MODULE_NAME"}}},
+			Teardown: []*Cmd{{Cmd: "sh", Args: []string{"-c", "echo teardown; grep -o '\"hook\":\"[a-z]*\"'"}}},
+			PreBuild: []*Cmd{{Cmd: "sh", Args: []string{"-c", "echo pre $MBT_MODULE_NAME"}}},
+		},
+	}))
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo built app-a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.WriteShellScript("app-b/build.sh", "echo built app-b"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+	check(t, err)
+
+	assert.Equal(t, "setup repo\npre app-a\nbuilt app-a\npre app-b\nbuilt app-b\nteardown\n\"hook\":\"teardown\"\n", buff.String())
+}
+
+func TestBuildTeardownHookAfterFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{
+		Hooks: &Hooks{
+			Teardown: []*Cmd{{Cmd: "sh", Args: []string{"-c", "grep -o 'Failed to build[^\"]*'"}}},
+		},
+	}))
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "exit 1"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgFailedBuild, "app-a"))
+	assert.Equal(t, "Failed to build module 'app-a'\n", buff.String())
+}
+
+func TestBuildSetupHookFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{
+		Hooks: &Hooks{
+			Setup:    []*Cmd{{Cmd: "false", Args: []string{}}},
+			Teardown: []*Cmd{{Cmd: "sh", Args: []string{"-c", "echo teardown"}}},
+		},
+	}))
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo built app-a"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgFailedBuildHook, "setup", "false"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+	assert.Equal(t, "teardown\n", buff.String())
+}
+
+func TestBuildWithInvalidRepoConfig(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent(".mbt/config.yml", "hooks: [invalid"))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(nil))
+
+	assert.Error(t, err)
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestBuildMatrix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:   "app-a",
+		Build:  map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Matrix: map[string][]string{"arch": {"amd64", "arm64"}, "env": {"prod"}},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo $MBT_VARIANT $MBT_MATRIX_ARCH $MBT_MATRIX_ENV"))
+	check(t, repo.Commit("first"))
+
+	stages := make([]CmdStage, 0)
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Callback = func(a *Module, s CmdStage, err error) {
+		stages = append(stages, s)
+	}
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "arch=amd64,env=prod amd64 prod\narch=arm64,env=prod arm64 prod\n", buff.String())
+	assert.EqualValues(t, []CmdStage{CmdStageBeforeBuild, CmdStageAfterBuild}, stages)
+	assert.Len(t, summary.Completed, 2)
+	assert.Equal(t, "arch=amd64,env=prod", summary.Completed[0].Variant.Name)
+	assert.Equal(t, map[string]string{"arch": "arm64", "env": "prod"}, summary.Completed[1].Variant.Values)
+}
+
+func TestBuildMatrixVariantVersion(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:   "app-a",
+		Build:  map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Matrix: map[string][]string{"arch": {"amd64", "arm64"}},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo $MBT_VARIANT_VERSION"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+	check(t, err)
+
+	a := summary.Completed[0].Variant.Version
+	b := summary.Completed[1].Variant.Version
+	assert.Equal(t, fmt.Sprintf("%s\n%s\n", a, b), buff.String())
+	assert.NotEqual(t, a, b)
+	assert.NotEqual(t, summary.Manifest.Modules[0].Version(), a)
+}
+
+func TestBuildMatrixFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:   "app-a",
+		Build:  map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Matrix: map[string][]string{"arch": {"amd64", "arm64"}},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo $MBT_MATRIX_ARCH\ntest $MBT_MATRIX_ARCH = amd64"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgFailedBuildVariant, "arch=arm64", "app-a"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+	assert.Equal(t, "amd64\narm64\n", buff.String())
+}
+
+func TestBuildPlan(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo built app-a"))
+	check(t, repo.WritePowershellScript("app-a/build.ps1", "write-host built app-a"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:         "app-b",
+		Build:        map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{"--release"}}},
+		Dependencies: []string{"app-a"},
+	}))
+	check(t, repo.InitModule("app-c"))
+	check(t, repo.WriteShellScript("app-c/build.sh", "echo built app-c"))
+	check(t, repo.WritePowershellScript("app-c/build.ps1", "write-host built app-c"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Plan = true
+	options.Env = []string{"FOO=bar"}
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	plan := summary.Plan
+	assert.Equal(t, "", buff.String())
+	assert.Len(t, summary.Completed, 0)
+	assert.Len(t, plan.Groups, 2)
+	assert.Len(t, plan.Groups[0], 2)
+	assert.Equal(t, "app-a", plan.Groups[0][0].Module.Name())
+	assert.Equal(t, "app-c", plan.Groups[0][1].Module.Name())
+	assert.Equal(t, "app-b", plan.Groups[1][0].Module.Name())
+	assert.Equal(t, &Cmd{Cmd: "./build.sh", Args: []string{"--release"}}, plan.Groups[1][0].Cmd)
+
+	env := plan.Groups[1][0].Env
+	assert.Contains(t, env, "MBT_MODULE_NAME=app-b")
+	assert.Contains(t, env, fmt.Sprintf("MBT_MODULE_VERSION=%s", plan.Groups[1][0].Module.Version()))
+	assert.Contains(t, env, "FOO=bar")
+}
+
+func TestParallelGroupsIgnoreDependenciesNotInTheList(t *testing.T) {
+	mod := func(name string, requires ...*Module) *Module {
+		return newModule(newModuleMetadata(name, name, &Spec{Name: name}, nil), requires)
+	}
+	a := mod("app-a")
+	b := mod("app-b", a)
+	c := mod("app-c", b)
+	d := mod("app-d", a, c)
+
+	groups, err := Modules{b, c, d}.parallelGroups()
+	check(t, err)
+
+	assert.Equal(t, []Modules{{b}, {c}, {d}}, groups)
+
+	groups, err = Modules{a, b, d}.parallelGroups()
+	check(t, err)
+
+	assert.Equal(t, []Modules{{a}, {b, d}}, groups)
+}
+
+func TestBuildPlanWithMatrixAndSkippedModules(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:   "app-a",
+		Build:  map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Matrix: map[string][]string{"arch": {"amd64", "arm64"}},
+	}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b"}))
+	check(t, repo.Commit("first"))
+
+	options := stdTestCmdOptions(nil)
+	options.Plan = true
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildBranch("master", NoFilter, options)
+	check(t, err)
+
+	plan := summary.Plan
+	assert.Len(t, plan.Groups, 1)
+	assert.Len(t, plan.Groups[0], 2)
+	assert.Equal(t, "arch=amd64", plan.Groups[0][0].Variant.Name)
+	assert.Contains(t, plan.Groups[0][1].Env, "MBT_MATRIX_ARCH=arm64")
+	assert.Len(t, summary.Skipped, 1)
+	assert.Equal(t, "app-b", summary.Skipped[0].Name())
+}
+
+func TestBuildPlanExpandsCommands(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:      "app-a",
+		Templates: true,
+		Build:     map[string]*Cmd{"default": {Cmd: "echo", Args: []string{"{{.Module.Name}}", "{{.Env.FOO}}"}}},
+	}))
+	check(t, repo.Commit("first"))
+
+	options := stdTestCmdOptions(nil)
+	options.Plan = true
+	options.Env = []string{"FOO=bar"}
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	step := summary.Plan.Groups[0][0]
+	assert.Equal(t, []string{"{{.Module.Name}}", "{{.Env.FOO}}"}, step.Cmd.Args)
+	assert.Equal(t, []string{"app-a", "bar"}, step.Expanded.Args)
+	assert.Contains(t, step.Env, "FOO=bar")
+	assert.Equal(t, "", step.Outcome)
+}
+
+func TestBuildPlanDoesNotCheckout(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+	check(t, repo.WriteContent("app-a/dirty.txt", "dirty"))
+
+	options := stdTestCmdOptions(nil)
+	options.Plan = true
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildBranch("master", NoFilter, options)
+	check(t, err)
+
+	assert.Len(t, summary.Plan.Groups, 1)
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// buildkiteDockerPlugin is the plugin running the steps of modules with
+// an image.
+const buildkiteDockerPlugin = "docker#v5.11.0"
+
+// buildkitePipeline is a Buildkite pipeline in the format accepted by
+// buildkite-agent pipeline upload.
+type buildkitePipeline struct {
+	Steps []*buildkiteStep `json:"steps"`
+}
+
+type buildkiteStep struct {
+	Key       string                   `json:"key,omitempty"`
+	Label     string                   `json:"label"`
+	Command   string                   `json:"command"`
+	Env       map[string]string        `json:"env,omitempty"`
+	DependsOn []string                 `json:"depends_on,omitempty"`
+	Plugins   []map[string]interface{} `json:"plugins,omitempty"`
+}
+
+// buildkiteKey returns the key of the step of a module. Keys can only
+// contain alphanumeric characters, -, _ and :.
+func buildkiteKey(module string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == ':' {
+			return r
+		}
+		return '-'
+	}, module)
+}
+
+// buildkiteEscape escapes the environment variables in s, which are
+// otherwise interpolated when the pipeline is uploaded rather than
+// when the command is executed.
+func buildkiteEscape(s string) string {
+	return strings.Replace(s, "$", "$$", -1)
+}
+
+func renderBuildkitePipeline(plan *pipelinePlan, options *PipelineOptions, w io.Writer) error {
+	p := &buildkitePipeline{Steps: []*buildkiteStep{}}
+	ids := plan.ids(buildkiteKey)
+	for _, s := range plan.buildableSteps() {
+		env := make(map[string]string, len(s.Variables))
+		for k, v := range s.Variables {
+			env[k] = buildkiteEscape(v)
+		}
+
+		dependsOn := make([]string, 0, len(s.Needs))
+		for _, n := range s.Needs {
+			dependsOn = append(dependsOn, ids[n])
+		}
+
+		step := &buildkiteStep{
+			Key:   ids[s.Module.Name()],
+			Label: s.Module.Name(),
+			Command: strings.Join([]string{
+				// Steps start in the directory of the checkout.
+				`export MBT_REPO_PATH="$$PWD"`,
+				"cd " + buildkiteEscape(shellWord(s.Dir)),
+				buildkiteEscape(s.Command),
+			}, "\n"),
+			Env:       env,
+			DependsOn: dependsOn,
+		}
+
+		if s.Image != "" {
+			step.Plugins = []map[string]interface{}{
+				{buildkiteDockerPlugin: map[string]string{"image": s.Image}},
+			}
+		}
+
+		p.Steps = append(p.Steps, step)
+	}
+
+	if len(p.Steps) == 0 {
+		p.Steps = append(p.Steps, &buildkiteStep{Label: "No modules to build", Command: "echo No modules to build"})
+	}
+
+	buff, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	buff = append(buff, '\n')
+	_, err = w.Write(buff)
+	return err
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildkitePipeline(t *testing.T) {
+	initPipelineRepo(t)
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	check(t, m.WritePipeline(PipelineBuildkite, &PipelineOptions{}, buff))
+
+	p := &buildkitePipeline{}
+	check(t, json.Unmarshal(buff.Bytes(), p))
+
+	assert.Len(t, p.Steps, 2)
+	lib, svc := p.Steps[0], p.Steps[1]
+	assert.Equal(t, "lib-a", lib.Key)
+	assert.Equal(t, "export MBT_REPO_PATH=\"$$PWD\"\ncd lib-a\nmake build lib-a", lib.Command)
+	assert.Empty(t, lib.DependsOn)
+	assert.Empty(t, lib.Plugins)
+	assert.Equal(t, m.Modules[0].Version(), lib.Env["MBT_MODULE_VERSION"])
+
+	assert.Equal(t, "svc-a", svc.Key)
+	assert.Equal(t, []string{"lib-a"}, svc.DependsOn)
+	assert.Equal(t, map[string]interface{}{"image": "golang:1.21"}, svc.Plugins[0][buildkiteDockerPlugin])
+}
+
+func TestBuildkitePipelineEscapesInterpolation(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app.a", &Spec{
+		Name:  "app.a",
+		Env:   map[string]string{"TARGET": "$HOME/out"},
+		Build: map[string]*Cmd{"linux": {Cmd: "echo $BUILDKITE_COMMIT", Shell: "sh"}},
+	}))
+	check(t, repo.Commit("first"))
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	check(t, m.WritePipeline(PipelineBuildkite, &PipelineOptions{}, buff))
+
+	p := &buildkitePipeline{}
+	check(t, json.Unmarshal(buff.Bytes(), p))
+
+	assert.Equal(t, "app-a", p.Steps[0].Key)
+	assert.Contains(t, p.Steps[0].Command, "sh -c 'echo $$BUILDKITE_COMMIT' sh")
+	assert.Equal(t, "$$HOME/out", p.Steps[0].Env["TARGET"])
+}
+
+func TestEmptyBuildkitePipeline(t *testing.T) {
+	buff := new(bytes.Buffer)
+	check(t, (&Manifest{Modules: Modules{}}).WritePipeline(PipelineBuildkite, &PipelineOptions{}, buff))
+
+	p := &buildkitePipeline{}
+	check(t, json.Unmarshal(buff.Bytes(), p))
+
+	assert.Len(t, p.Steps, 1)
+	assert.Equal(t, "echo No modules to build", p.Steps[0].Command)
+}
@@ -0,0 +1,471 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// CacheDiscovery is the cache of the modules discovered in commits.
+	CacheDiscovery = "discovery"
+	// CacheTemplates is the cache of the content of template sources.
+	CacheTemplates = "templates"
+	// CacheRepos is the cache of the repositories specified by a url.
+	CacheRepos = "repos"
+	// CacheBuild is the local directory of the build cache used by the
+	// last build.
+	CacheBuild = "build"
+	// CacheFingerprints is the record of the fingerprints of the
+	// versions of each module.
+	CacheFingerprints = "fingerprints"
+)
+
+// CacheGCOptions are the retention policies applied to the caches.
+// Policies with zero values are not applied.
+type CacheGCOptions struct {
+	// MaxSize is the maximum size of each cache (e.g. 512Mi or 10G).
+	// Least recently modified entries are removed first.
+	MaxSize string
+	// MaxAge removes the entries not modified for longer.
+	MaxAge time.Duration
+	// KeepLast is the number of versions kept for each module in the
+	// fingerprints cache.
+	KeepLast int
+	// DryRun reports the entries to remove without removing them.
+	DryRun bool
+}
+
+// CacheStats describes the entries of a cache.
+type CacheStats struct {
+	// Name of the cache (discovery, templates, repos, build or
+	// fingerprints).
+	Name string `json:"name"`
+	// Dir is the directory (or the file) of the cache.
+	Dir string `json:"dir"`
+	// Entries is the number of entries in the cache. Entries of the
+	// fingerprints cache are the versions of modules.
+	Entries int `json:"entries"`
+	// Size is the size of the cache in bytes.
+	Size int64 `json:"size"`
+	// Oldest and Newest are the modification times of the least and the
+	// most recently modified entries. They are zero for an empty cache.
+	Oldest time.Time `json:"oldest"`
+	Newest time.Time `json:"newest"`
+	// Removed and Freed are the number of entries and bytes removed by
+	// a garbage collection.
+	Removed int   `json:"removed"`
+	Freed   int64 `json:"freed"`
+}
+
+// cacheEntry is an entry of a cache stored in a file or a directory.
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// cacheStore lists the entries of a cache.
+type cacheStore struct {
+	name    string
+	dir     string
+	entries func(dir string) ([]*cacheEntry, error)
+}
+
+func (s *stdSystem) CacheStats() ([]*CacheStats, error) {
+	return s.collectCaches(nil)
+}
+
+func (s *stdSystem) CacheGC(options *CacheGCOptions) ([]*CacheStats, error) {
+	return s.collectCaches(options)
+}
+
+func (s *stdSystem) CacheClear(names []string) ([]*CacheStats, error) {
+	stores, err := s.cacheStores()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) > 0 {
+		index := make(map[string]*cacheStore, len(stores))
+		valid := make([]string, 0, len(stores))
+		for _, store := range stores {
+			index[store.name] = store
+			valid = append(valid, store.name)
+		}
+
+		selected := make([]*cacheStore, 0, len(names))
+		for _, n := range names {
+			store, ok := index[n]
+			if !ok {
+				return nil, e.NewErrorf(ErrClassUser, msgUnknownCache, n, strings.Join(valid, ", "))
+			}
+			selected = append(selected, store)
+		}
+		stores = selected
+	}
+
+	r := make([]*CacheStats, 0, len(stores))
+	for _, store := range stores {
+		entries, err := store.entries(store.dir)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassInternal, err, msgFailedReadCache, store.dir)
+		}
+
+		stats := &CacheStats{Name: store.name, Dir: store.dir}
+		for _, entry := range entries {
+			stats.Removed++
+			stats.Freed += entry.size
+		}
+
+		if err := os.RemoveAll(store.dir); err != nil {
+			return nil, e.Wrapf(ErrClassInternal, err, msgFailedRemoveCacheEntry, store.dir)
+		}
+		r = append(r, stats)
+	}
+
+	return r, nil
+}
+
+// collectCaches reports the stats of the caches after removing the
+// entries not retained by options. Nothing is removed if options is
+// nil.
+func (s *stdSystem) collectCaches(options *CacheGCOptions) ([]*CacheStats, error) {
+	var maxSize int64
+	if options != nil && options.MaxSize != "" {
+		size, err := parseMemory(options.MaxSize)
+		if err != nil || size <= 0 {
+			return nil, e.NewErrorf(ErrClassUser, msgInvalidCacheSize, options.MaxSize)
+		}
+		maxSize = size
+	}
+
+	stores, err := s.cacheStores()
+	if err != nil {
+		return nil, err
+	}
+
+	r := make([]*CacheStats, 0, len(stores)+1)
+	for _, store := range stores {
+		entries, err := store.entries(store.dir)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassInternal, err, msgFailedReadCache, store.dir)
+		}
+
+		stats := &CacheStats{Name: store.name, Dir: store.dir}
+		if options != nil {
+			entries, err = stats.collect(entries, options, maxSize)
+			if err != nil {
+				return nil, err
+			}
+		}
+		stats.add(entries)
+		r = append(r, stats)
+	}
+
+	stats, err := s.collectFingerprints(options)
+	if err != nil {
+		return nil, err
+	}
+	return append(r, stats), nil
+}
+
+func (s *stdSystem) cacheStores() ([]*cacheStore, error) {
+	stateDir, err := s.stateDir()
+	if err != nil {
+		return nil, err
+	}
+
+	reposDir, err := cacheRootDir()
+	if err != nil {
+		return nil, err
+	}
+
+	stores := []*cacheStore{
+		{name: CacheDiscovery, dir: filepath.Join(stateDir, discoveryCacheDirName), entries: discoveryCacheEntries},
+		{name: CacheTemplates, dir: filepath.Join(stateDir, templateSourcesDir), entries: templateCacheEntries},
+		{name: CacheRepos, dir: filepath.Join(reposDir, repoCacheDir), entries: dirCacheEntries},
+	}
+
+	// Build cache is only known once a build used a local directory.
+	location := &buildCacheLocation{}
+	if err := s.readState(buildCacheState, location); err != nil {
+		return nil, err
+	}
+	if location.Dir != "" {
+		stores = append(stores, &cacheStore{name: CacheBuild, dir: location.Dir, entries: buildCacheEntries})
+	}
+	return stores, nil
+}
+
+// collect removes the entries older than the maximum age followed by
+// the least recently modified entries until the cache fits in
+// maxSize. Entries retained are returned.
+func (c *CacheStats) collect(entries []*cacheEntry, options *CacheGCOptions, maxSize int64) ([]*cacheEntry, error) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].modTime.After(entries[j].modTime)
+	})
+
+	var size int64
+	retained := make([]*cacheEntry, 0, len(entries))
+	for _, entry := range entries {
+		expired := options.MaxAge > 0 && time.Since(entry.modTime) > options.MaxAge
+		if !expired && (maxSize == 0 || size+entry.size <= maxSize) {
+			size += entry.size
+			retained = append(retained, entry)
+			continue
+		}
+
+		if !options.DryRun {
+			err := os.RemoveAll(entry.path)
+			if err != nil {
+				return nil, e.Wrapf(ErrClassInternal, err, msgFailedRemoveCacheEntry, entry.path)
+			}
+			removeEmptyParent(entry.path, c.Dir)
+		}
+		c.Removed++
+		c.Freed += entry.size
+	}
+	return retained, nil
+}
+
+func (c *CacheStats) add(entries []*cacheEntry) {
+	for _, entry := range entries {
+		c.Entries++
+		c.Size += entry.size
+		if c.Oldest.IsZero() || entry.modTime.Before(c.Oldest) {
+			c.Oldest = entry.modTime
+		}
+		if entry.modTime.After(c.Newest) {
+			c.Newest = entry.modTime
+		}
+	}
+}
+
+// collectFingerprints keeps the last versions of each module in the
+// fingerprints cache.
+func (s *stdSystem) collectFingerprints(options *CacheGCOptions) (*CacheStats, error) {
+	dir, err := s.stateDir()
+	if err != nil {
+		return nil, err
+	}
+
+	p := filepath.Join(dir, fingerprintsFile)
+	stats := &CacheStats{Name: CacheFingerprints, Dir: p}
+	fi, err := os.Stat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return stats, nil
+		}
+		return nil, e.Wrapf(ErrClassInternal, err, msgFailedReadCache, p)
+	}
+
+	f := &fingerprints{}
+	err = s.readState(fingerprintsFile, f)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := 0
+	for _, m := range f.Modules {
+		if options != nil && options.KeepLast > 0 && len(m.Versions) > options.KeepLast {
+			removed += len(m.Versions) - options.KeepLast
+			m.Versions = m.Versions[len(m.Versions)-options.KeepLast:]
+		}
+		stats.Entries += len(m.Versions)
+	}
+
+	stats.Size, stats.Oldest, stats.Newest = fi.Size(), fi.ModTime(), fi.ModTime()
+	if removed == 0 {
+		return stats, nil
+	}
+
+	stats.Removed = removed
+	if options.DryRun {
+		return stats, nil
+	}
+
+	err = s.writeState(fingerprintsFile, f)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err = os.Stat(p)
+	if err != nil {
+		return nil, e.Wrapf(ErrClassInternal, err, msgFailedReadCache, p)
+	}
+	stats.Freed = stats.Size - fi.Size()
+	stats.Size, stats.Newest = fi.Size(), fi.ModTime()
+	return stats, nil
+}
+
+// discoveryCacheEntries lists the entries of the discovery cache
+// including the ones discovered with the specs of other commits.
+func discoveryCacheEntries(dir string) ([]*cacheEntry, error) {
+	entries, err := fileCacheEntries(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	configs, err := readCacheDir(filepath.Join(dir, discoveryAtConfigDirName))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range configs {
+		if !c.IsDir() {
+			continue
+		}
+		at, err := fileCacheEntries(filepath.Join(dir, discoveryAtConfigDirName, c.Name()))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, at...)
+	}
+	return entries, nil
+}
+
+func fileCacheEntries(dir string) ([]*cacheEntry, error) {
+	files, err := readCacheDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*cacheEntry, 0, len(files))
+	for _, f := range files {
+		if !f.IsDir() && filepath.Ext(f.Name()) == ".json" {
+			entries = append(entries, &cacheEntry{path: filepath.Join(dir, f.Name()), size: f.Size(), modTime: f.ModTime()})
+		}
+	}
+	return entries, nil
+}
+
+// buildCacheEntries lists the entries of the build cache, which are
+// stored in a directory for each prefix of the keys.
+func buildCacheEntries(dir string) ([]*cacheEntry, error) {
+	prefixes, err := readCacheDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*cacheEntry, 0)
+	for _, prefix := range prefixes {
+		if !prefix.IsDir() {
+			continue
+		}
+		files, err := readCacheDir(filepath.Join(dir, prefix.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if !f.IsDir() && strings.HasSuffix(f.Name(), ".tar.gz") {
+				entries = append(entries, &cacheEntry{path: filepath.Join(dir, prefix.Name(), f.Name()), size: f.Size(), modTime: f.ModTime()})
+			}
+		}
+	}
+	return entries, nil
+}
+
+// templateCacheEntries lists the content of each digest of the
+// template sources.
+func templateCacheEntries(dir string) ([]*cacheEntry, error) {
+	sources, err := readCacheDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*cacheEntry, 0)
+	for _, src := range sources {
+		if !src.IsDir() {
+			continue
+		}
+		digests, err := dirCacheEntries(filepath.Join(dir, src.Name()))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, digests...)
+	}
+	return entries, nil
+}
+
+// dirCacheEntries lists the directories in dir. Modification time of
+// an entry is the latest modification time of the files in it or the
+// modification time of the directory if it is empty.
+func dirCacheEntries(dir string) ([]*cacheEntry, error) {
+	dirs, err := readCacheDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*cacheEntry, 0, len(dirs))
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+
+		entry := &cacheEntry{path: filepath.Join(dir, d.Name())}
+		err := filepath.Walk(entry.path, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !fi.IsDir() {
+				entry.size += fi.Size()
+				if fi.ModTime().After(entry.modTime) {
+					entry.modTime = fi.ModTime()
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if entry.modTime.IsZero() {
+			entry.modTime = d.ModTime()
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// readCacheDir lists the files in dir. A missing directory is an empty
+// cache.
+func readCacheDir(dir string) ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return files, err
+}
+
+// removeEmptyParent removes the directory containing p if it is empty
+// and it is not the root directory of the cache.
+func removeEmptyParent(p, root string) {
+	parent := filepath.Dir(p)
+	if parent == root {
+		return
+	}
+	if files, err := ioutil.ReadDir(parent); err == nil && len(files) == 0 {
+		os.Remove(parent)
+	}
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCacheFile writes a file of the specified size modified age ago.
+func writeCacheFile(t *testing.T, p string, size int, age time.Duration) {
+	check(t, os.MkdirAll(filepath.Dir(p), 0755))
+	check(t, ioutil.WriteFile(p, make([]byte, size), 0644))
+	at := time.Now().Add(-age)
+	check(t, os.Chtimes(p, at, at))
+}
+
+func cacheStatsByName(stats []*CacheStats) map[string]*CacheStats {
+	r := make(map[string]*CacheStats)
+	for _, s := range stats {
+		r[s.Name] = s
+	}
+	return r
+}
+
+func TestCacheStats(t *testing.T) {
+	clean()
+	os.Setenv(cacheDirEnv, ".tmp/cache")
+	defer os.Unsetenv(cacheDirEnv)
+
+	NewTestRepo(t, ".tmp/repo")
+	state := ".tmp/repo/.git/mbt"
+	writeCacheFile(t, filepath.Join(state, "discovery", "a.json"), 10, time.Hour)
+	writeCacheFile(t, filepath.Join(state, "discovery", "at", "c", "b.json"), 20, 2*time.Hour)
+	writeCacheFile(t, filepath.Join(state, "templates", "platform", "sha256-abc", "a.tmpl"), 30, time.Hour)
+	writeCacheFile(t, ".tmp/cache/repos/abc/HEAD", 40, time.Hour)
+
+	stats, err := NewWorld(t, ".tmp/repo").System.CacheStats()
+	check(t, err)
+
+	assert.Equal(t, []string{CacheDiscovery, CacheTemplates, CacheRepos, CacheFingerprints}, []string{stats[0].Name, stats[1].Name, stats[2].Name, stats[3].Name})
+	s := cacheStatsByName(stats)
+	assert.Equal(t, 2, s[CacheDiscovery].Entries)
+	assert.Equal(t, int64(30), s[CacheDiscovery].Size)
+	assert.True(t, s[CacheDiscovery].Oldest.Before(s[CacheDiscovery].Newest))
+	assert.Equal(t, 1, s[CacheTemplates].Entries)
+	assert.Equal(t, int64(30), s[CacheTemplates].Size)
+	assert.Equal(t, 1, s[CacheRepos].Entries)
+	assert.Equal(t, int64(40), s[CacheRepos].Size)
+	assert.Equal(t, 0, s[CacheFingerprints].Entries)
+	assert.True(t, s[CacheFingerprints].Oldest.IsZero())
+}
+
+func TestCacheGC(t *testing.T) {
+	clean()
+	os.Setenv(cacheDirEnv, ".tmp/cache")
+	defer os.Unsetenv(cacheDirEnv)
+
+	NewTestRepo(t, ".tmp/repo")
+	state := ".tmp/repo/.git/mbt"
+	writeCacheFile(t, filepath.Join(state, "discovery", "new.json"), 10, time.Hour)
+	writeCacheFile(t, filepath.Join(state, "discovery", "older.json"), 10, 2*time.Hour)
+	writeCacheFile(t, filepath.Join(state, "discovery", "oldest.json"), 10, 3*time.Hour)
+	writeCacheFile(t, filepath.Join(state, "discovery", "at", "c", "expired.json"), 10, 48*time.Hour)
+	writeCacheFile(t, ".tmp/cache/repos/expired/HEAD", 10, 48*time.Hour)
+	writeCacheFile(t, ".tmp/cache/repos/fresh/HEAD", 10, time.Hour)
+
+	w := NewWorld(t, ".tmp/repo")
+	s := initSystem(w.Log, w.Repo, w.ManifestBuilder, w.Discover, w.Reducer, w.WorkspaceManager, w.ProcessManager).(*stdSystem)
+	check(t, s.writeState(fingerprintsFile, &fingerprints{Modules: map[string]*moduleFingerprints{
+		"app-a": {Versions: []*versionFingerprint{{Version: "1", Fingerprint: "a"}, {Version: "2", Fingerprint: "b"}, {Version: "3", Fingerprint: "c"}}},
+		"app-b": {Versions: []*versionFingerprint{{Version: "1", Fingerprint: "a"}}},
+	}}))
+
+	options := &CacheGCOptions{MaxSize: "20", MaxAge: 24 * time.Hour, KeepLast: 2, DryRun: true}
+	stats, err := s.CacheGC(options)
+	check(t, err)
+	dry := cacheStatsByName(stats)
+	assert.Equal(t, 2, dry[CacheDiscovery].Removed)
+	assert.Equal(t, int64(20), dry[CacheDiscovery].Freed)
+	assert.Equal(t, 1, dry[CacheRepos].Removed)
+	assert.Equal(t, 1, dry[CacheFingerprints].Removed)
+	assert.FileExists(t, filepath.Join(state, "discovery", "oldest.json"))
+
+	options.DryRun = false
+	stats, err = s.CacheGC(options)
+	check(t, err)
+	assert.Equal(t, dry[CacheDiscovery], cacheStatsByName(stats)[CacheDiscovery])
+
+	files, err := ioutil.ReadDir(filepath.Join(state, "discovery"))
+	check(t, err)
+	assert.Len(t, files, 3)
+	assert.Equal(t, []string{"at", "new.json", "older.json"}, []string{files[0].Name(), files[1].Name(), files[2].Name()})
+	_, err = os.Stat(".tmp/cache/repos/expired")
+	assert.True(t, os.IsNotExist(err))
+	assert.DirExists(t, ".tmp/cache/repos/fresh")
+
+	f := &fingerprints{}
+	check(t, s.readState(fingerprintsFile, f))
+	assert.Equal(t, []*versionFingerprint{{Version: "2", Fingerprint: "b"}, {Version: "3", Fingerprint: "c"}}, f.Modules["app-a"].Versions)
+	assert.Len(t, f.Modules["app-b"].Versions, 1)
+	assert.Equal(t, 3, cacheStatsByName(stats)[CacheFingerprints].Entries)
+	assert.True(t, cacheStatsByName(stats)[CacheFingerprints].Freed > 0)
+
+	stats, err = s.CacheStats()
+	check(t, err)
+	assert.Zero(t, cacheStatsByName(stats)[CacheDiscovery].Removed)
+	assert.Equal(t, 2, cacheStatsByName(stats)[CacheDiscovery].Entries)
+}
+
+func TestCacheGCWithInvalidSize(t *testing.T) {
+	clean()
+	NewTestRepo(t, ".tmp/repo")
+
+	_, err := NewWorld(t, ".tmp/repo").System.CacheGC(&CacheGCOptions{MaxSize: "lots"})
+	assert.EqualError(t, err, "Invalid cache size 'lots', use a quantity such as 512Mi or 10G")
+}
+
+func TestCacheClear(t *testing.T) {
+	clean()
+	os.Setenv(cacheDirEnv, ".tmp/cache")
+	defer os.Unsetenv(cacheDirEnv)
+
+	NewTestRepo(t, ".tmp/repo")
+	state := ".tmp/repo/.git/mbt"
+	writeCacheFile(t, filepath.Join(state, "discovery", "a.json"), 10, time.Hour)
+	writeCacheFile(t, filepath.Join(state, "discovery", "at", "c", "b.json"), 20, time.Hour)
+	writeCacheFile(t, filepath.Join(state, "templates", "platform", "sha256-abc", "a.tmpl"), 30, time.Hour)
+	writeCacheFile(t, ".tmp/cache/repos/abc/HEAD", 40, time.Hour)
+
+	s := NewWorld(t, ".tmp/repo").System
+	stats, err := s.CacheClear([]string{CacheDiscovery})
+	check(t, err)
+	assert.Len(t, stats, 1)
+	assert.Equal(t, 2, stats[0].Removed)
+	assert.Equal(t, int64(30), stats[0].Freed)
+	_, err = os.Stat(filepath.Join(state, "discovery"))
+	assert.True(t, os.IsNotExist(err))
+	assert.FileExists(t, filepath.Join(state, "templates", "platform", "sha256-abc", "a.tmpl"))
+
+	stats, err = s.CacheClear(nil)
+	check(t, err)
+	cleared := cacheStatsByName(stats)
+	assert.Len(t, stats, 3)
+	assert.Zero(t, cleared[CacheDiscovery].Removed)
+	assert.Equal(t, 1, cleared[CacheTemplates].Removed)
+	assert.Equal(t, 1, cleared[CacheRepos].Removed)
+	_, err = os.Stat(".tmp/cache/repos/abc")
+	assert.True(t, os.IsNotExist(err))
+
+	stats, err = s.CacheStats()
+	check(t, err)
+	for _, c := range stats {
+		assert.Zero(t, c.Entries, c.Name)
+	}
+}
+
+func TestCacheOfBuilds(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initBuildCacheRepo(t)
+	buildWithCache(t, func(o *CmdOptions) { o.CacheDir = ".tmp/cache" })
+	dir, err := filepath.Abs(".tmp/cache")
+	check(t, err)
+
+	s := NewWorld(t, ".tmp/repo").System
+	stats, err := s.CacheStats()
+	check(t, err)
+	build := cacheStatsByName(stats)[CacheBuild]
+	assert.Equal(t, dir, build.Dir)
+	assert.Equal(t, 2, build.Entries)
+
+	stats, err = s.CacheGC(&CacheGCOptions{MaxAge: time.Nanosecond})
+	check(t, err)
+	assert.Equal(t, 2, cacheStatsByName(stats)[CacheBuild].Removed)
+	files, err := filepath.Glob(".tmp/cache/*/*.tar.gz")
+	check(t, err)
+	assert.Empty(t, files)
+}
+
+func TestCacheClearWithUnknownCache(t *testing.T) {
+	clean()
+	NewTestRepo(t, ".tmp/repo")
+
+	_, err := NewWorld(t, ".tmp/repo").System.CacheClear([]string{"builds"})
+	assert.EqualError(t, err, "Unknown cache builds, expected one of discovery, templates, repos")
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// cacheSignature is the signature of a discovery cache entry shared
+// through the cache plugins, or of a remote build cache entry.
+type cacheSignature struct {
+	// HMAC is the hex encoded HMAC-SHA256 of the entry.
+	HMAC string `json:"hmac,omitempty"`
+	// Bundle is the sigstore bundle of the entry written by cosign.
+	Bundle json.RawMessage `json:"bundle,omitempty"`
+}
+
+// verifies informs if the entries received from the cache plugins
+// must be verified.
+func (c *CacheConfig) verifies() bool {
+	return c != nil && (c.HMACKeyEnv != "" || c.Sigstore != nil)
+}
+
+// signs informs if the entries stored in the cache plugins must be
+// signed.
+func (c *CacheConfig) signs() bool {
+	return c.verifies() && c.Sign
+}
+
+// sign signs the payload of an entry with each of the methods in the
+// configuration. name identifies the entry in the errors.
+func (c *CacheConfig) sign(name string, payload []byte) (*cacheSignature, error) {
+	var err error
+	sig := &cacheSignature{}
+	if c.HMACKeyEnv != "" {
+		mac, err := c.hmac(payload)
+		if err != nil {
+			return nil, err
+		}
+		sig.HMAC = hex.EncodeToString(mac)
+	}
+
+	if c.Sigstore != nil {
+		sig.Bundle, err = cosignBlob(payload, nil, func(payloadFile, bundleFile string) []string {
+			return []string{"sign-blob", "--yes", "--bundle", bundleFile, payloadFile}
+		})
+		if err != nil {
+			return nil, e.NewErrorf(ErrClassUser, msgFailedSignCacheEntry, name, err)
+		}
+	}
+
+	return sig, nil
+}
+
+// verify verifies the signature of the payload of an entry with each
+// of the methods in the configuration. A missing signature fails the
+// verification.
+func (c *CacheConfig) verify(name string, payload []byte, sig *cacheSignature) error {
+	if sig == nil {
+		return e.NewErrorf(ErrClassUser, msgUnsignedCacheEntry, name)
+	}
+
+	if c.HMACKeyEnv != "" {
+		mac, err := c.hmac(payload)
+		if err != nil {
+			return err
+		}
+
+		actual, err := hex.DecodeString(sig.HMAC)
+		if err != nil || !hmac.Equal(mac, actual) {
+			return e.NewErrorf(ErrClassUser, msgInvalidCacheSignature, name)
+		}
+	}
+
+	if c.Sigstore != nil {
+		if len(sig.Bundle) == 0 {
+			return e.NewErrorf(ErrClassUser, msgUnsignedCacheEntry, name)
+		}
+
+		_, err := cosignBlob(payload, sig.Bundle, func(payloadFile, bundleFile string) []string {
+			return []string{"verify-blob", "--bundle", bundleFile, "--certificate-identity", c.Sigstore.Identity, "--certificate-oidc-issuer", c.Sigstore.Issuer, payloadFile}
+		})
+		if err != nil {
+			return e.NewErrorf(ErrClassUser, msgFailedVerifyCacheEntry, name, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *CacheConfig) hmac(payload []byte) ([]byte, error) {
+	key := os.Getenv(c.HMACKeyEnv)
+	if key == "" {
+		return nil, e.NewErrorf(ErrClassUser, msgCacheKeyNotSet, c.HMACKeyEnv)
+	}
+
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(payload)
+	return h.Sum(nil), nil
+}
+
+// cosignBlob runs cosign with the arguments for the files containing
+// the payload and the bundle, and returns the contents of the bundle
+// file afterwards. Bundle file is written first if bundle is not nil.
+func cosignBlob(payload, bundle []byte, args func(payloadFile, bundleFile string) []string) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "mbt-cache")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	payloadFile := filepath.Join(dir, "entry.json")
+	bundleFile := filepath.Join(dir, "entry.sigstore.json")
+	if err := ioutil.WriteFile(payloadFile, payload, 0644); err != nil {
+		return nil, err
+	}
+	if bundle != nil {
+		if err := ioutil.WriteFile(bundleFile, bundle, 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	var stderr bytes.Buffer
+	c := exec.Command("cosign", args(payloadFile, bundleFile)...)
+	c.Stdout = ioutil.Discard
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %v", err, msg)
+		}
+		return nil, err
+	}
+
+	return ioutil.ReadFile(bundleFile)
+}
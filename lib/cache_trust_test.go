@@ -0,0 +1,168 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testCachePlugin = `input=$(cat)
+case "$input" in
+  *'"hook":"describe"'*) echo '{"version": "1.0.0", "hooks": ["cache"]}' ;;
+  *'"op":"put"'*) printf '%s' "$input" > ../cache.json ;;
+  *'"op":"get"'*) test -f ../cache.json && cat ../cache.json ;;
+esac
+`
+
+func initCachePluginRepo(t *testing.T, cache *CacheConfig) *TestRepository {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteConfig(&RepoConfig{Plugins: []string{"cache"}, Cache: cache}))
+	check(t, repo.WriteShellScript(".mbt/plugins/mbt-cache", testCachePlugin))
+	check(t, repo.InitModule("app-a"))
+	_, err := NewWorld(t, ".tmp/repo").System.LockPlugins()
+	check(t, err)
+	check(t, repo.Commit("first"))
+	return repo
+}
+
+// discoverFromPlugin discovers the modules in head with an empty local
+// discovery cache and returns the version of app-a.
+func discoverFromPlugin(t *testing.T) string {
+	check(t, os.RemoveAll(filepath.Join(".tmp/repo/.git", stateDirName, discoveryCacheDirName)))
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+	assert.Equal(t, []string{"app-a"}, moduleNames(m.Modules))
+	return m.Modules[0].Version()
+}
+
+// tamperCachedEntry replaces the hash of the modules in the entry
+// stored by the plugin.
+func tamperCachedEntry(t *testing.T, hash string) {
+	buff, err := ioutil.ReadFile(".tmp/cache.json")
+	check(t, err)
+	tampered := strings.Replace(string(buff), `"hash":"`+hash+`"`, `"hash":"0000000000000000000000000000000000000000"`, -1)
+	assert.NotEqual(t, string(buff), tampered)
+	check(t, ioutil.WriteFile(".tmp/cache.json", []byte(tampered), 0644))
+}
+
+func TestCacheEntriesSignedWithHMAC(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	os.Setenv("MBT_TEST_CACHE_KEY", "secret")
+	defer os.Unsetenv("MBT_TEST_CACHE_KEY")
+	initCachePluginRepo(t, &CacheConfig{HMACKeyEnv: "MBT_TEST_CACHE_KEY", Sign: true})
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+	version, hash := m.Modules[0].Version(), m.Modules[0].Hash()
+
+	stored, err := ioutil.ReadFile(".tmp/cache.json")
+	check(t, err)
+	assert.Contains(t, string(stored), `"signature":{"hmac":"`)
+	assert.Equal(t, version, discoverFromPlugin(t))
+
+	// Tampered entries are rejected and the modules are discovered
+	// from the commit.
+	tamperCachedEntry(t, hash)
+	assert.Equal(t, version, discoverFromPlugin(t))
+
+	// Entries signed with another key are rejected.
+	initCachePluginRepo(t, &CacheConfig{HMACKeyEnv: "MBT_TEST_CACHE_KEY", Sign: true})
+	version = discoverFromPlugin(t)
+	os.Setenv("MBT_TEST_CACHE_KEY", "other")
+	tamperCachedEntry(t, hash)
+	assert.Equal(t, version, discoverFromPlugin(t))
+}
+
+func TestUnsignedCacheEntriesAreRejected(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	os.Setenv("MBT_TEST_CACHE_KEY", "secret")
+	defer os.Unsetenv("MBT_TEST_CACHE_KEY")
+
+	// Entries are not stored unless they are signed.
+	initCachePluginRepo(t, &CacheConfig{HMACKeyEnv: "MBT_TEST_CACHE_KEY"})
+	discoverFromPlugin(t)
+	_, err := os.Stat(".tmp/cache.json")
+	assert.True(t, os.IsNotExist(err))
+
+	repo := initCachePluginRepo(t, nil)
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+	version, hash := m.Modules[0].Version(), m.Modules[0].Hash()
+	stored, err := ioutil.ReadFile(".tmp/cache.json")
+	check(t, err)
+	assert.NotContains(t, string(stored), "signature")
+
+	err = repo.WriteConfig(&RepoConfig{Plugins: []string{"cache"}, Cache: &CacheConfig{HMACKeyEnv: "MBT_TEST_CACHE_KEY"}})
+	check(t, err)
+	check(t, repo.Commit("verify"))
+	m, err = NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+	assert.Equal(t, version, m.Modules[0].Version())
+
+	// Unsigned entry of the new commit is written by a build that does
+	// not verify the entries.
+	entry, err := ioutil.ReadFile(filepath.Join(".tmp/repo/.git", stateDirName, discoveryCacheDirName, repo.LastCommit.String()+".json"))
+	check(t, err)
+	check(t, ioutil.WriteFile(".tmp/cache.json", []byte(`{"entry": `+string(entry)+`}`), 0644))
+	tamperCachedEntry(t, hash)
+	assert.Equal(t, version, discoverFromPlugin(t))
+}
+
+func TestCacheEntriesSignedWithSigstore(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	repo := initCachePluginRepo(t, &CacheConfig{Sigstore: &SigstoreIdentity{Identity: "ci@acme.com", Issuer: "https://issuer"}, Sign: true})
+	check(t, repo.WriteShellScript("bin/cosign", `case "$1" in
+  sign-blob) echo '{"digest":"'$(sha256sum < "$5" | cut -d' ' -f1)'"}' > "$4" ;;
+  verify-blob) test "$5 $7" = "ci@acme.com https://issuer" && grep -q $(sha256sum < "$8" | cut -d' ' -f1) "$3" ;;
+esac
+`))
+	check(t, repo.Commit("cosign"))
+
+	bin, err := filepath.Abs(".tmp/repo/bin")
+	check(t, err)
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+	version, hash := m.Modules[0].Version(), m.Modules[0].Hash()
+
+	stored, err := ioutil.ReadFile(".tmp/cache.json")
+	check(t, err)
+	assert.Contains(t, string(stored), `"signature":{"bundle":{"digest":`)
+	assert.Equal(t, version, discoverFromPlugin(t))
+
+	tamperCachedEntry(t, hash)
+	assert.Equal(t, version, discoverFromPlugin(t))
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"context"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// cancellableRepo stops the long running operations of a repo (i.e.
+// walking trees and diffing) when the context is done.
+type cancellableRepo struct {
+	Repo
+	ctx context.Context
+}
+
+func (r *cancellableRepo) Diff(a, b Commit) ([]*DiffDelta, error) {
+	if err := cancelled(r.ctx); err != nil {
+		return nil, err
+	}
+	return r.Repo.Diff(a, b)
+}
+
+func (r *cancellableRepo) DiffMergeBase(from, to Commit) ([]*DiffDelta, error) {
+	if err := cancelled(r.ctx); err != nil {
+		return nil, err
+	}
+	return r.Repo.DiffMergeBase(from, to)
+}
+
+func (r *cancellableRepo) DiffWorkspace() ([]*DiffDelta, error) {
+	if err := cancelled(r.ctx); err != nil {
+		return nil, err
+	}
+	return r.Repo.DiffWorkspace()
+}
+
+func (r *cancellableRepo) Changes(c Commit) ([]*DiffDelta, error) {
+	if err := cancelled(r.ctx); err != nil {
+		return nil, err
+	}
+	return r.Repo.Changes(c)
+}
+
+func (r *cancellableRepo) WalkBlobs(a Commit, callback BlobWalkCallback) error {
+	return r.Repo.WalkBlobs(a, func(b Blob) error {
+		if err := cancelled(r.ctx); err != nil {
+			return err
+		}
+		return callback(b)
+	})
+}
+
+func (r *cancellableRepo) WalkBlobsInDir(a Commit, dir string, callback BlobWalkCallback) error {
+	return r.Repo.WalkBlobsInDir(a, dir, func(b Blob) error {
+		if err := cancelled(r.ctx); err != nil {
+			return err
+		}
+		return callback(b)
+	})
+}
+
+func (r *cancellableRepo) FindAllFilesInWorkspace(pathSpec []string) ([]string, error) {
+	if err := cancelled(r.ctx); err != nil {
+		return nil, err
+	}
+	return r.Repo.FindAllFilesInWorkspace(pathSpec)
+}
+
+func (r *cancellableRepo) History(from, to Commit) ([]*LogEntry, error) {
+	if err := cancelled(r.ctx); err != nil {
+		return nil, err
+	}
+	return r.Repo.History(from, to)
+}
+
+// cancelled returns an error if the context is done.
+func cancelled(ctx context.Context) error {
+	if ctx == nil || ctx.Err() == nil {
+		return nil
+	}
+	return e.NewError(ErrClassUser, msgCancelled).WithCode(ExitCodeCancelled)
+}
+
+// withContext returns the options with the context of the system unless
+// a context is specified.
+func (s *stdSystem) withContext(options *CmdOptions) *CmdOptions {
+	if options.Context != nil {
+		return options
+	}
+	o := *options
+	o.Context = s.ctx
+	return &o
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCancelBuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	// Process spawned in the background must not outlive the build.
+	check(t, repo.WriteShellScript("app-a/build.sh", "(sleep 1 && touch ../leaked) & touch ../started && sleep 30"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:         "app-b",
+		Build:        map[string]*Cmd{"default": {Cmd: "true"}},
+		Dependencies: []string{"app-a"},
+	}))
+	check(t, repo.WriteContent(".gitignore", "started\nleaked\n"))
+	check(t, repo.Commit("first"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for {
+			if _, err := os.Stat(filepath.Join(repo.Dir, "started")); err == nil {
+				cancel()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	file := filepath.Join(".tmp", "summary.json")
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.SummaryFile = file
+	options.Context = ctx
+	started := time.Now()
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+
+	assert.True(t, time.Since(started) < terminationGracePeriod)
+	assert.EqualError(t, err, msgCancelled)
+	assert.Equal(t, ExitCodeCancelled, ExitCode(err))
+
+	summary := readInvocationSummary(t, file)
+	assert.False(t, summary.Success)
+	assert.Equal(t, ExitCodeCancelled, summary.ExitCode)
+	assert.Equal(t, ModuleStatusFailed, summary.Modules[0].Status)
+	assert.Equal(t, ModuleStatusNotStarted, summary.Modules[1].Status)
+
+	time.Sleep(1500 * time.Millisecond)
+	_, err = os.Stat(filepath.Join(repo.Dir, "leaked"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCancelRunIn(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:     "app-a",
+		Commands: map[string]*UserCmd{"echo": {Cmd: "echo", Args: []string{"hello"}}},
+	}))
+	check(t, repo.Commit("first"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Context = ctx
+	_, err := NewWorld(t, ".tmp/repo").System.RunInWorkspace("echo", NoFilter, options)
+
+	assert.EqualError(t, err, msgCancelled)
+	assert.Equal(t, ExitCodeCancelled, ExitCode(err))
+	assert.Empty(t, buff.String())
+}
+
+func TestCancelDiscovery(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s, err := NewSystemWithContext(ctx, ".tmp/repo", LogLevelNormal)
+	check(t, err)
+
+	_, err = s.ManifestByCurrentBranch()
+
+	assert.Equal(t, ExitCodeCancelled, ExitCode(err))
+}
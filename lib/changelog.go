@@ -0,0 +1,341 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// ChangelogFormatMarkdown formats a changelog as markdown.
+	ChangelogFormatMarkdown = "markdown"
+	// ChangelogFormatJSON formats a changelog as json.
+	ChangelogFormatJSON = "json"
+	// changelogTypeOther is the type of commits not following the
+	// conventional commit format.
+	changelogTypeOther = "other"
+)
+
+// Changelog is the list of commits changing a module grouped by their
+// conventional commit type (https://www.conventionalcommits.org).
+type Changelog struct {
+	Module string            `json:"module"`
+	From   string            `json:"from,omitempty"`
+	To     string            `json:"to"`
+	Groups []*ChangelogGroup `json:"groups"`
+	// Issues are the keys of the issues referenced by the commits in
+	// alphabetical order.
+	Issues []string `json:"issues,omitempty"`
+	// issueURL is the url of the issues in markdown output.
+	issueURL string
+}
+
+// ChangelogGroup contains the commits of a type newest first.
+type ChangelogGroup struct {
+	Type    string            `json:"type"`
+	Title   string            `json:"title"`
+	Entries []*ChangelogEntry `json:"entries"`
+}
+
+// ChangelogEntry is a commit in a changelog.
+type ChangelogEntry struct {
+	Sha      string    `json:"sha"`
+	Type     string    `json:"type"`
+	Scope    string    `json:"scope,omitempty"`
+	Subject  string    `json:"subject"`
+	Body     string    `json:"body,omitempty"`
+	Breaking bool      `json:"breaking"`
+	Issues   []string  `json:"issues,omitempty"`
+	Author   string    `json:"author"`
+	Email    string    `json:"email"`
+	Time     time.Time `json:"time"`
+}
+
+// changelogTitles are the titles of the well known commit types in the
+// order they are listed. Other types are listed after them in
+// alphabetical order followed by the commits without a type.
+var changelogTitles = []struct{ kind, title string }{
+	{"feat", "Features"},
+	{"fix", "Bug Fixes"},
+	{"perf", "Performance Improvements"},
+	{"revert", "Reverts"},
+	{"refactor", "Code Refactoring"},
+	{"docs", "Documentation"},
+	{"style", "Styles"},
+	{"test", "Tests"},
+	{"build", "Build System"},
+	{"ci", "Continuous Integration"},
+	{"chore", "Chores"},
+}
+
+var (
+	conventionalSubject = regexp.MustCompile(`^([A-Za-z]+)(?:\(([^)]*)\))?(!)?: (.+)$`)
+	breakingFooter      = regexp.MustCompile(`(?m)^BREAKING[ -]CHANGE: `)
+)
+
+func (s *stdSystem) Changelog(module, from, to string) (*Changelog, error) {
+	var (
+		toCommit, fromCommit Commit
+		err                  error
+	)
+
+	if to == "" {
+		toCommit, err = s.Repo.CurrentBranchCommit()
+	} else {
+		toCommit, err = s.Repo.ResolveCommit(to)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if from != "" {
+		fromCommit, err = s.Repo.ResolveCommit(from)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Module is located with its definition in to commit.
+	mods, err := s.Discover.ModulesInCommit(toCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	mod, ok := mods.indexByName()[module]
+	if !ok {
+		return nil, e.NewErrorf(ErrClassUser, msgModuleNotFound, module)
+	}
+
+	matcher, err := s.issueMatcher(toCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := s.Repo.History(fromCommit, toCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*ChangelogEntry, 0)
+	for _, c := range history {
+		// Merge commits are excluded since the commits merged are
+		// already in the log.
+		if c.Parents > 1 {
+			continue
+		}
+
+		deltas, err := s.Repo.Changes(c.Commit)
+		if err != nil {
+			return nil, err
+		}
+
+		impacted, err := s.Reducer.Reduce(Modules{mod}, deltas)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(impacted) > 0 {
+			entry := newChangelogEntry(c)
+			if keys := matcher.keys(c.Message); len(keys) > 0 {
+				entry.Issues = keys
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	issues := make([][]string, 0, len(entries))
+	for _, entry := range entries {
+		issues = append(issues, entry.Issues)
+	}
+
+	changelog := &Changelog{
+		Module:   module,
+		To:       toCommit.ID(),
+		Groups:   groupChangelogEntries(entries),
+		Issues:   sortedIssues(issues...),
+		issueURL: matcher.url,
+	}
+	if fromCommit != nil {
+		changelog.From = fromCommit.ID()
+	}
+
+	return changelog, nil
+}
+
+// newChangelogEntry parses the message of a commit in conventional
+// commit format.
+func newChangelogEntry(c *LogEntry) *ChangelogEntry {
+	message := strings.TrimSpace(c.Message)
+	subject, body := message, ""
+	if i := strings.Index(message, "\n"); i >= 0 {
+		subject, body = strings.TrimSpace(message[:i]), strings.TrimSpace(message[i+1:])
+	}
+
+	entry := &ChangelogEntry{
+		Sha:     c.Commit.ID(),
+		Type:    changelogTypeOther,
+		Subject: subject,
+		Body:    body,
+		Author:  c.Author,
+		Email:   c.Email,
+		Time:    c.Time,
+	}
+
+	if m := conventionalSubject.FindStringSubmatch(subject); m != nil {
+		entry.Type = strings.ToLower(m[1])
+		entry.Scope = m[2]
+		entry.Breaking = m[3] == "!"
+		entry.Subject = m[4]
+	}
+
+	if breakingFooter.MatchString(body) {
+		entry.Breaking = true
+	}
+
+	return entry
+}
+
+func groupChangelogEntries(entries []*ChangelogEntry) []*ChangelogGroup {
+	index := make(map[string]*ChangelogGroup)
+	for _, entry := range entries {
+		g, ok := index[entry.Type]
+		if !ok {
+			g = &ChangelogGroup{Type: entry.Type}
+			index[entry.Type] = g
+		}
+		g.Entries = append(g.Entries, entry)
+	}
+
+	groups := make([]*ChangelogGroup, 0, len(index))
+	for _, t := range changelogTitles {
+		if g, ok := index[t.kind]; ok {
+			g.Title = t.title
+			groups = append(groups, g)
+			delete(index, t.kind)
+		}
+	}
+
+	other := index[changelogTypeOther]
+	delete(index, changelogTypeOther)
+
+	kinds := make([]string, 0, len(index))
+	for k := range index {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	for _, k := range kinds {
+		g := index[k]
+		g.Title = strings.Title(k)
+		groups = append(groups, g)
+	}
+
+	if other != nil {
+		other.Title = "Other Changes"
+		groups = append(groups, other)
+	}
+
+	return groups
+}
+
+// Write writes the changelog to w in the specified format.
+func (c *Changelog) Write(format string, w io.Writer) error {
+	var (
+		buff []byte
+		err  error
+	)
+
+	switch format {
+	case ChangelogFormatMarkdown:
+		buff = c.markdown()
+	case ChangelogFormatJSON:
+		buff, err = json.MarshalIndent(c, "", "  ")
+		buff = append(buff, '\n')
+	default:
+		return e.NewErrorf(ErrClassUser, msgUnsupportedChangelogFormat, format)
+	}
+
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	if _, err := w.Write(buff); err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	return nil
+}
+
+func (c *Changelog) markdown() []byte {
+	buff := new(bytes.Buffer)
+	fmt.Fprintf(buff, "# %s\n", c.Module)
+
+	breaking := make([]*ChangelogEntry, 0)
+	for _, g := range c.Groups {
+		for _, entry := range g.Entries {
+			if entry.Breaking {
+				breaking = append(breaking, entry)
+			}
+		}
+	}
+
+	if len(breaking) > 0 {
+		c.writeMarkdownSection(buff, "Breaking Changes", breaking)
+	}
+
+	for _, g := range c.Groups {
+		c.writeMarkdownSection(buff, g.Title, g.Entries)
+	}
+
+	if len(c.Issues) > 0 {
+		buff.WriteString("\n## Issues\n\n")
+		for _, k := range c.Issues {
+			fmt.Fprintf(buff, "- %s\n", markdownIssues(c.issueURL, []string{k}))
+		}
+	}
+
+	return buff.Bytes()
+}
+
+func (c *Changelog) writeMarkdownSection(buff *bytes.Buffer, title string, entries []*ChangelogEntry) {
+	fmt.Fprintf(buff, "\n## %s\n\n", title)
+	for _, entry := range entries {
+		buff.WriteString("- ")
+		if entry.Scope != "" {
+			fmt.Fprintf(buff, "**%s:** ", entry.Scope)
+		}
+		fmt.Fprintf(buff, "%s (%s)", entry.Subject, shortSha(entry.Sha))
+		if len(entry.Issues) > 0 {
+			fmt.Fprintf(buff, " %s", markdownIssues(c.issueURL, entry.Issues))
+		}
+		buff.WriteString("\n")
+	}
+}
+
+func shortSha(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
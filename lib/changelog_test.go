@@ -0,0 +1,186 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func changelogSubjects(c *Changelog) map[string][]string {
+	r := make(map[string][]string)
+	for _, g := range c.Groups {
+		for _, entry := range g.Entries {
+			r[g.Title] = append(r[g.Title], entry.Subject)
+		}
+	}
+	return r
+}
+
+func TestChangelog(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", FileDependencies: []string{"lib-a"}}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b"}))
+	check(t, repo.Commit("chore: initial commit"))
+	from := repo.LastCommit.String()
+
+	check(t, repo.WriteContent("app-a/foo", "a"))
+	check(t, repo.Commit("feat(api): add foo"))
+
+	check(t, repo.WriteContent("app-b/foo", "b"))
+	check(t, repo.Commit("feat: add foo to app-b"))
+
+	check(t, repo.WriteContent("lib-a/foo", "a"))
+	check(t, repo.Commit("fix: handle nil in lib-a\n\nBREAKING CHANGE: lib-a returns an error"))
+
+	check(t, repo.WriteContent("app-a/bar", "a"))
+	check(t, repo.Commit("Update bar"))
+
+	check(t, repo.WriteContent("app-a/baz", "a"))
+	check(t, repo.Commit("security: patch baz"))
+	to := repo.LastCommit.String()
+
+	check(t, repo.WriteContent("app-a/qux", "a"))
+	check(t, repo.Commit("feat: add qux"))
+
+	changelog, err := NewWorld(t, ".tmp/repo").System.Changelog("app-a", from, to)
+	check(t, err)
+
+	assert.Equal(t, from, changelog.From)
+	assert.Equal(t, to, changelog.To)
+	assert.Equal(t, map[string][]string{
+		"Features":      {"add foo"},
+		"Bug Fixes":     {"handle nil in lib-a"},
+		"Security":      {"patch baz"},
+		"Other Changes": {"Update bar"},
+	}, changelogSubjects(changelog))
+
+	titles := make([]string, 0)
+	for _, g := range changelog.Groups {
+		titles = append(titles, g.Title)
+	}
+	assert.Equal(t, []string{"Features", "Bug Fixes", "Security", "Other Changes"}, titles)
+
+	feature := changelog.Groups[0].Entries[0]
+	assert.Equal(t, "feat", feature.Type)
+	assert.Equal(t, "api", feature.Scope)
+	assert.False(t, feature.Breaking)
+	assert.Equal(t, "alice", feature.Author)
+
+	fix := changelog.Groups[1].Entries[0]
+	assert.True(t, fix.Breaking)
+	assert.Equal(t, "BREAKING CHANGE: lib-a returns an error", fix.Body)
+
+	buff := new(bytes.Buffer)
+	check(t, changelog.Write(ChangelogFormatMarkdown, buff))
+	assert.Equal(t, fmt.Sprintf(`# app-a
+
+## Breaking Changes
+
+- handle nil in lib-a (%s)
+
+## Features
+
+- **api:** add foo (%s)
+
+## Bug Fixes
+
+- handle nil in lib-a (%s)
+
+## Security
+
+- patch baz (%s)
+
+## Other Changes
+
+- Update bar (%s)
+`, fix.Sha[:7], feature.Sha[:7], fix.Sha[:7], changelog.Groups[2].Entries[0].Sha[:7], changelog.Groups[3].Entries[0].Sha[:7]), buff.String())
+}
+
+func TestChangelogOfCurrentBranch(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a"}))
+	check(t, repo.Commit("first"))
+	check(t, repo.WriteContent("app-a/foo", "a"))
+	check(t, repo.Commit("feat!: replace the api"))
+	check(t, repo.WriteContent("app-a/bar", "a"))
+	check(t, repo.Commit("fix: bar"))
+
+	check(t, repo.SwitchToBranch("feature"))
+	check(t, repo.WriteContent("app-a/baz", "a"))
+	check(t, repo.Commit("feat: baz"))
+
+	changelog, err := NewWorld(t, ".tmp/repo").System.Changelog("app-a", "master", "")
+	check(t, err)
+
+	assert.Equal(t, map[string][]string{"Features": {"baz"}}, changelogSubjects(changelog))
+
+	// Changes in the first commit of the repository are not known,
+	// hence it is not included.
+	changelog, err = NewWorld(t, ".tmp/repo").System.Changelog("app-a", "", "master")
+	check(t, err)
+
+	assert.Equal(t, "", changelog.From)
+	assert.Equal(t, map[string][]string{"Features": {"replace the api"}, "Bug Fixes": {"bar"}}, changelogSubjects(changelog))
+	assert.True(t, changelog.Groups[0].Entries[0].Breaking)
+
+	buff := new(bytes.Buffer)
+	check(t, changelog.Write(ChangelogFormatJSON, buff))
+	assert.Contains(t, buff.String(), `"subject": "replace the api"`)
+}
+
+func TestChangelogOfUnknownModule(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a"}))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.Changelog("app-b", "", "")
+
+	assert.Error(t, err)
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+	assert.EqualError(t, err, fmt.Sprintf(msgModuleNotFound, "app-b"))
+}
+
+func TestChangelogOfUnknownRevision(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a"}))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.Changelog("app-a", "v1.0.0", "")
+
+	assert.Error(t, err)
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+	assert.EqualError(t, err, fmt.Sprintf(msgRevisionNotFound, "v1.0.0"))
+}
+
+func TestUnsupportedChangelogFormat(t *testing.T) {
+	err := (&Changelog{}).Write("html", new(bytes.Buffer))
+
+	assert.Error(t, err)
+	assert.EqualError(t, err, "Unsupported changelog format 'html'")
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io"
+	"strings"
+
+	yaml "github.com/go-yaml/yaml"
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// circleCIImage is the image of the jobs of modules without an image.
+	// Jobs using the docker executor require an image.
+	circleCIImage = "cimg/base:stable"
+	// circleCIWorkflow is the name of the workflow in the continuation
+	// config.
+	circleCIWorkflow = "mbt"
+	// circleCINoopJob is the name of the job in a config without modules.
+	circleCINoopJob = "mbt-no-changes"
+)
+
+type circleCIConfig struct {
+	Version   string        `yaml:"version"`
+	Jobs      yaml.MapSlice `yaml:"jobs"`
+	Workflows yaml.MapSlice `yaml:"workflows"`
+}
+
+type circleCIJob struct {
+	Docker      []map[string]string `yaml:"docker"`
+	Environment yaml.MapSlice       `yaml:"environment,omitempty"`
+	Steps       []interface{}       `yaml:"steps"`
+}
+
+type circleCIWorkflowSpec struct {
+	Jobs []interface{} `yaml:"jobs"`
+}
+
+// circleCIJobName returns the name of the job of a module. Names can
+// only contain alphanumeric characters, - and _.
+func circleCIJobName(module string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, module)
+}
+
+// renderCircleCIPipeline writes a CircleCI config to be continued
+// from a setup workflow (dynamic config).
+func renderCircleCIPipeline(plan *pipelinePlan, options *PipelineOptions, w io.Writer) error {
+	c := &circleCIConfig{Version: "2.1", Jobs: yaml.MapSlice{}}
+	workflow := &circleCIWorkflowSpec{Jobs: []interface{}{}}
+	ids := plan.ids(circleCIJobName)
+	for _, s := range plan.buildableSteps() {
+		image := s.Image
+		if image == "" {
+			image = circleCIImage
+		}
+
+		environment := yaml.MapSlice{}
+		for _, k := range sortedKeys(s.Variables) {
+			environment = append(environment, yaml.MapItem{Key: k, Value: s.Variables[k]})
+		}
+
+		name := ids[s.Module.Name()]
+		c.Jobs = append(c.Jobs, yaml.MapItem{Key: name, Value: &circleCIJob{
+			Docker:      []map[string]string{{"image": image}},
+			Environment: environment,
+			Steps: []interface{}{
+				"checkout",
+				map[string]interface{}{"run": yaml.MapSlice{
+					{Key: "name", Value: "Build " + s.Module.Name()},
+					// Steps start in the directory of the checkout.
+					{Key: "command", Value: strings.Join([]string{
+						`export MBT_REPO_PATH="$PWD"`,
+						"cd " + shellWord(s.Dir),
+						s.Command,
+					}, "\n")},
+				}},
+			},
+		}})
+
+		if len(s.Needs) == 0 {
+			workflow.Jobs = append(workflow.Jobs, name)
+			continue
+		}
+
+		requires := make([]string, 0, len(s.Needs))
+		for _, n := range s.Needs {
+			requires = append(requires, ids[n])
+		}
+		workflow.Jobs = append(workflow.Jobs, map[string]interface{}{
+			name: map[string][]string{"requires": requires},
+		})
+	}
+
+	if len(c.Jobs) == 0 {
+		c.Jobs = append(c.Jobs, yaml.MapItem{Key: circleCINoopJob, Value: &circleCIJob{
+			Docker: []map[string]string{{"image": circleCIImage}},
+			Steps:  []interface{}{map[string]interface{}{"run": "echo No modules to build"}},
+		}})
+		workflow.Jobs = append(workflow.Jobs, circleCINoopJob)
+	}
+
+	c.Workflows = yaml.MapSlice{{Key: circleCIWorkflow, Value: workflow}}
+	buff, err := yaml.Marshal(c)
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	_, err = w.Write(buff)
+	return err
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"testing"
+
+	yaml "github.com/go-yaml/yaml"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCircleCIConfig struct {
+	Version string `yaml:"version"`
+	Jobs    map[string]*struct {
+		Docker      []map[string]string `yaml:"docker"`
+		Environment map[string]string   `yaml:"environment"`
+		Steps       []interface{}       `yaml:"steps"`
+	} `yaml:"jobs"`
+	Workflows map[string]*struct {
+		Jobs []interface{} `yaml:"jobs"`
+	} `yaml:"workflows"`
+}
+
+func TestCircleCIPipeline(t *testing.T) {
+	initPipelineRepo(t)
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	check(t, m.WritePipeline(PipelineCircleCI, &PipelineOptions{Image: "alpine"}, buff))
+
+	c := &testCircleCIConfig{}
+	check(t, yaml.Unmarshal(buff.Bytes(), c))
+
+	assert.Equal(t, "2.1", c.Version)
+	assert.Len(t, c.Jobs, 2)
+
+	lib := c.Jobs["lib-a"]
+	assert.Equal(t, "alpine", lib.Docker[0]["image"])
+	assert.Equal(t, "lib-a", lib.Environment["MBT_MODULE_NAME"])
+	assert.Equal(t, "checkout", lib.Steps[0])
+	run := lib.Steps[1].(map[interface{}]interface{})["run"].(map[interface{}]interface{})
+	assert.Equal(t, "export MBT_REPO_PATH=\"$PWD\"\ncd lib-a\nmake build lib-a", run["command"])
+
+	assert.Equal(t, "golang:1.21", c.Jobs["svc-a"].Docker[0]["image"])
+
+	jobs := c.Workflows[circleCIWorkflow].Jobs
+	assert.Equal(t, []interface{}{
+		"lib-a",
+		map[interface{}]interface{}{"svc-a": map[interface{}]interface{}{"requires": []interface{}{"lib-a"}}},
+	}, jobs)
+}
+
+func TestEmptyCircleCIPipeline(t *testing.T) {
+	buff := new(bytes.Buffer)
+	check(t, (&Manifest{Modules: Modules{}}).WritePipeline(PipelineCircleCI, &PipelineOptions{}, buff))
+
+	c := &testCircleCIConfig{}
+	check(t, yaml.Unmarshal(buff.Bytes(), c))
+
+	assert.Contains(t, c.Jobs, circleCINoopJob)
+	assert.Equal(t, circleCIImage, c.Jobs[circleCINoopJob].Docker[0]["image"])
+	assert.Equal(t, []interface{}{circleCINoopJob}, c.Workflows[circleCIWorkflow].Jobs)
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// CmdTemplateData is the data passed into the templates in commands of
+// a module.
+type CmdTemplateData struct {
+	Sha         string
+	Environment string
+	Module      *Module
+	Modules     map[string]*Module
+	Env         map[string]string
+}
+
+// expandCommand expands the templates in a command and its arguments.
+func expandCommand(manifest *Manifest, module *Module, options *CmdOptions, command string, args []string) (string, []string, error) {
+	if !strings.Contains(command, "{{") && !anyContains(args, "{{") {
+		return command, args, nil
+	}
+
+	env := getEnvMap()
+	for _, v := range options.Env {
+		p := strings.SplitN(v, "=", 2)
+		if len(p) == 2 {
+			env[p[0]] = p[1]
+		}
+	}
+
+	data := &CmdTemplateData{
+		Sha:         manifest.Sha,
+		Environment: options.Environment,
+		Module:      module,
+		Modules:     manifest.Modules.indexByName(),
+		Env:         env,
+	}
+
+	command, err := expandCommandTemplate(command, data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	expandedArgs := make([]string, 0, len(args))
+	for _, a := range args {
+		a, err = expandCommandTemplate(a, data)
+		if err != nil {
+			return "", nil, err
+		}
+		expandedArgs = append(expandedArgs, a)
+	}
+
+	return command, expandedArgs, nil
+}
+
+func expandCommandTemplate(text string, data *CmdTemplateData) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	temp, err := template.New("cmd").Option("missingkey=error").Funcs(template.FuncMap{
+		"property": func(m *Module, n string) interface{} {
+			if m == nil {
+				return nil
+			}
+
+			return resolveProperty(m.Properties(), strings.Split(n, "."), nil)
+		},
+		"propertyOr": func(m *Module, n string, def interface{}) interface{} {
+			if m == nil {
+				return def
+			}
+
+			return resolveProperty(m.Properties(), strings.Split(n, "."), def)
+		},
+	}).Parse(text)
+	if err != nil {
+		return "", e.Wrapf(ErrClassUser, err, msgFailedCommandTemplate, text)
+	}
+
+	buff := new(bytes.Buffer)
+	err = temp.Execute(buff, data)
+	if err != nil {
+		return "", e.Wrapf(ErrClassUser, err, msgFailedCommandTemplate, text)
+	}
+
+	return buff.String(), nil
+}
+
+func anyContains(l []string, s string) bool {
+	for _, i := range l {
+		if strings.Contains(i, s) {
+			return true
+		}
+	}
+	return false
+}
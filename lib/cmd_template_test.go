@@ -0,0 +1,134 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildCommandTemplate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:       "app-a",
+		Templates:  true,
+		Build:      map[string]*Cmd{"default": {Cmd: "echo", Args: []string{"{{.Module.Name}}", "{{.Module.Path}}", "{{.Module.Version}}", "{{.Sha}}", `{{property .Module "tag"}}`}}},
+		Properties: map[string]interface{}{"tag": "latest"},
+	}))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+	check(t, err)
+
+	mod := summary.Completed[0].Module
+	assert.Equal(t, fmt.Sprintf("app-a app-a %s %s latest\n", mod.Version(), summary.Manifest.Sha), buff.String())
+}
+
+func TestBuildCommandTemplateWithShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:      "app-a",
+		Templates: true,
+		Build:     map[string]*Cmd{"default": {Cmd: `echo {{.Env.MBT_MATRIX_ARCH}}-{{(index .Modules "app-a").Name}}`, Shell: "sh"}},
+		Matrix:    map[string][]string{"arch": {"amd64", "arm64"}},
+	}))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+	check(t, err)
+
+	assert.Equal(t, "amd64-app-a\narm64-app-a\n", buff.String())
+}
+
+func TestBuildCommandWithEscapedTemplate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:      "app-a",
+		Templates: true,
+		Build:     map[string]*Cmd{"default": {Cmd: "echo", Args: []string{`{{"{{.ID}}"}}`}}},
+	}))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+	check(t, err)
+
+	assert.Equal(t, "{{.ID}}\n", buff.String())
+}
+
+func TestBuildCommandWithoutTemplates(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"default": {Cmd: "echo", Args: []string{"{{.ImportPath}}", "{{.Module.Name}}"}}},
+	}))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+	check(t, err)
+
+	assert.Equal(t, "{{.ImportPath}} {{.Module.Name}}\n", buff.String())
+}
+
+func TestBuildCommandWithInvalidTemplate(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:      "app-a",
+		Templates: true,
+		Build:     map[string]*Cmd{"default": {Cmd: "echo", Args: []string{"{{.Module.Unknown}}"}}},
+	}))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+
+	assert.EqualError(t, (err.(*e.E)).InnerError(), "Failed to expand the template in command '{{.Module.Unknown}}'")
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
@@ -0,0 +1,314 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// StatusGitHub publishes a check run for each module.
+	StatusGitHub = "github"
+	// StatusGitLab publishes a commit status for each module.
+	StatusGitLab = "gitlab"
+
+	defaultGitLabAPIURL = "https://gitlab.com/api/v4"
+	// logExcerptLines is the number of lines at the end of the log of
+	// a module included in its status.
+	logExcerptLines = 50
+	// gitLabDescriptionLimit is the maximum length of the description
+	// of a GitLab commit status.
+	gitLabDescriptionLimit = 255
+)
+
+// StatusProviders is the list of systems statuses can be published to.
+var StatusProviders = []string{StatusGitHub, StatusGitLab}
+
+type statusProvider struct {
+	publish       func(*CommitStatusOptions, *CommitStatus) error
+	defaultAPIURL string
+	apiURLEnv     string
+	repositoryEnv string
+	tokenEnv      string
+}
+
+var statusProviders = map[string]*statusProvider{
+	StatusGitHub: {
+		publish:       publishGitHubCheckRun,
+		defaultAPIURL: defaultGitHubAPIURL,
+		apiURLEnv:     "GITHUB_API_URL",
+		repositoryEnv: "GITHUB_REPOSITORY",
+		tokenEnv:      "GITHUB_TOKEN",
+	},
+	StatusGitLab: {
+		publish:       publishGitLabStatus,
+		defaultAPIURL: defaultGitLabAPIURL,
+		apiURLEnv:     "CI_API_V4_URL",
+		repositoryEnv: "CI_PROJECT_ID",
+		tokenEnv:      "GITLAB_TOKEN",
+	},
+}
+
+// CommitStatusOptions specifies where the statuses of modules are
+// published. Unspecified options are read from the environment
+// of GitHub Actions or GitLab CI.
+type CommitStatusOptions struct {
+	// Provider is github or gitlab.
+	Provider string
+	// APIURL defaults to $GITHUB_API_URL (or $CI_API_V4_URL).
+	APIURL string
+	// Repository is owner/name in GitHub ($GITHUB_REPOSITORY) or the
+	// id or the path of the project in GitLab ($CI_PROJECT_ID).
+	Repository string
+	// Sha of the commit. Defaults to the commit in the summary.
+	Sha string
+	// Token defaults to $GITHUB_TOKEN (or $GITLAB_TOKEN).
+	Token string
+	// TargetURL is the link of the statuses, GitLab only.
+	// Defaults to $CI_JOB_URL.
+	TargetURL string
+	// LogDir is the directory with the log file of each module (see
+	// CmdOptions.LogDir). Statuses include the end of the logs when
+	// it is specified.
+	LogDir string
+}
+
+// CommitStatus is the status of a module published to a CI system.
+type CommitStatus struct {
+	Name   string `json:"name"`
+	Module string `json:"module"`
+	// Status is the status of the module in the summary.
+	Status string `json:"status"`
+	// Title is a one line description of the outcome.
+	Title string `json:"title"`
+	// Log is the end of the output of the module if it is available.
+	Log string `json:"log,omitempty"`
+}
+
+// PublishCommitStatuses publishes a status for each module in the
+// summary, so that the outcome of each module is visible in the
+// commit (and the pull request) instead of a single status of the
+// whole build. Published statuses are returned.
+func PublishCommitStatuses(summary *InvocationSummary, options *CommitStatusOptions) ([]*CommitStatus, error) {
+	provider, ok := statusProviders[options.Provider]
+	if !ok {
+		return nil, e.NewErrorf(ErrClassUser, msgUnsupportedStatusProvider, options.Provider, strings.Join(StatusProviders, ", "))
+	}
+
+	options, err := options.withDefaults(provider, summary)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses, err := commitStatuses(summary, options.LogDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range statuses {
+		err = provider.publish(options, s)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgFailedPublishStatus, s.Module)
+		}
+	}
+
+	return statuses, nil
+}
+
+func (o *CommitStatusOptions) withDefaults(p *statusProvider, summary *InvocationSummary) (*CommitStatusOptions, error) {
+	r := *o
+	if r.APIURL == "" {
+		r.APIURL = os.Getenv(p.apiURLEnv)
+	}
+	if r.APIURL == "" {
+		r.APIURL = p.defaultAPIURL
+	}
+	r.APIURL = strings.TrimSuffix(r.APIURL, "/")
+
+	if r.Repository == "" {
+		r.Repository = os.Getenv(p.repositoryEnv)
+	}
+	if r.Repository == "" {
+		return nil, e.NewErrorf(ErrClassUser, msgCommitStatusOptionMissing, "repository", p.repositoryEnv)
+	}
+
+	if r.Token == "" {
+		r.Token = os.Getenv(p.tokenEnv)
+	}
+	if r.Token == "" {
+		return nil, e.NewErrorf(ErrClassUser, msgCommitStatusOptionMissing, "token", p.tokenEnv)
+	}
+
+	if r.Sha == "" {
+		r.Sha = summary.Commit
+	}
+	if r.Sha == "" {
+		return nil, e.NewErrorf(ErrClassUser, msgCommitStatusShaMissing)
+	}
+
+	if r.TargetURL == "" && r.Provider == StatusGitLab {
+		r.TargetURL = os.Getenv("CI_JOB_URL")
+	}
+
+	return &r, nil
+}
+
+// commitStatuses creates the statuses of the modules in a summary.
+func commitStatuses(summary *InvocationSummary, logDir string) ([]*CommitStatus, error) {
+	statuses := make([]*CommitStatus, 0, len(summary.Modules))
+	for _, m := range summary.Modules {
+		s := &CommitStatus{
+			Name:   fmt.Sprintf("mbt/%v/%v", summary.Command, m.Name),
+			Module: m.Name,
+			Status: m.Status,
+			Title:  statusTitle(m),
+		}
+
+		if logDir != "" && moduleBuilt(m) && !moduleReused(m) {
+			log, err := logExcerpt(moduleLogPath(logDir, m.Name), logExcerptLines)
+			if err != nil {
+				return nil, err
+			}
+			s.Log = log
+		}
+
+		statuses = append(statuses, s)
+	}
+
+	return statuses, nil
+}
+
+func statusTitle(m *ModuleSummary) string {
+	switch m.Status {
+	case ModuleStatusSucceeded:
+		return fmt.Sprintf("%v succeeded in %.1fs", m.Name, m.Duration)
+	case ModuleStatusFailed:
+		if m.Error != "" {
+			return fmt.Sprintf("%v failed in %.1fs: %v", m.Name, m.Duration, m.Error)
+		}
+		return fmt.Sprintf("%v failed in %.1fs", m.Name, m.Duration)
+	case ModuleStatusResumed:
+		return fmt.Sprintf("%v was built in a previous run", m.Name)
+	case ModuleStatusSatisfied:
+		return fmt.Sprintf("%v was already built", m.Name)
+	case ModuleStatusUnaffected:
+		return fmt.Sprintf("%v was not affected by its dependencies", m.Name)
+	case ModuleStatusCached:
+		return fmt.Sprintf("%v was restored from the build cache", m.Name)
+	case ModuleStatusNotStarted:
+		return fmt.Sprintf("%v was not built due to a failure", m.Name)
+	default:
+		return fmt.Sprintf("%v was skipped", m.Name)
+	}
+}
+
+// logExcerpt returns the last n lines of a log file.
+// Log of a module is not created if it did not produce any output,
+// therefore a missing file is not an error.
+func logExcerpt(path string, n int) (string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", e.Wrapf(ErrClassUser, err, msgFailedReadModuleLog, path)
+	}
+	defer f.Close()
+
+	lines := make([]string, 0, n)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(lines) == n {
+			lines = lines[1:]
+		}
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return "", e.Wrapf(ErrClassUser, err, msgFailedReadModuleLog, path)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func publishGitHubCheckRun(options *CommitStatusOptions, s *CommitStatus) error {
+	conclusion := map[string]string{
+		ModuleStatusSucceeded:  "success",
+		ModuleStatusFailed:     "failure",
+		ModuleStatusResumed:    "success",
+		ModuleStatusSatisfied:  "success",
+		ModuleStatusUnaffected: "success",
+		ModuleStatusCached:     "success",
+		ModuleStatusNotStarted: "cancelled",
+	}[s.Status]
+	if conclusion == "" {
+		conclusion = "skipped"
+	}
+
+	output := map[string]string{"title": s.Title, "summary": s.Title}
+	if s.Log != "" {
+		output["text"] = "```\n" + s.Log + "\n```"
+	}
+
+	u := fmt.Sprintf("%v/repos/%v/check-runs", options.APIURL, options.Repository)
+	return callJSONAPI(http.MethodPost, u, githubHeaders(options.Token), map[string]interface{}{
+		"name":       s.Name,
+		"head_sha":   options.Sha,
+		"status":     "completed",
+		"conclusion": conclusion,
+		"output":     output,
+	}, nil)
+}
+
+func publishGitLabStatus(options *CommitStatusOptions, s *CommitStatus) error {
+	state := map[string]string{
+		ModuleStatusSucceeded:  "success",
+		ModuleStatusFailed:     "failed",
+		ModuleStatusResumed:    "success",
+		ModuleStatusSatisfied:  "success",
+		ModuleStatusUnaffected: "success",
+		ModuleStatusCached:     "success",
+		ModuleStatusNotStarted: "canceled",
+	}[s.Status]
+	if state == "" {
+		state = "skipped"
+	}
+
+	// GitLab statuses only have a short description, therefore the
+	// last line of the log is used as the excerpt.
+	description := s.Title
+	if s.Log != "" && s.Status == ModuleStatusFailed {
+		description += " | " + s.Log[strings.LastIndex(s.Log, "\n")+1:]
+	}
+	if r := []rune(description); len(r) > gitLabDescriptionLimit {
+		description = string(r[:gitLabDescriptionLimit-3]) + "..."
+	}
+
+	body := map[string]string{"state": state, "name": s.Name, "description": description}
+	if options.TargetURL != "" {
+		body["target_url"] = options.TargetURL
+	}
+
+	u := fmt.Sprintf("%v/projects/%v/statuses/%v", options.APIURL, url.PathEscape(options.Repository), options.Sha)
+	return callJSONAPI(http.MethodPost, u, map[string]string{"PRIVATE-TOKEN": options.Token}, body, nil)
+}
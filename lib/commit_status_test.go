@@ -0,0 +1,241 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type statusRequest struct {
+	path   string
+	header http.Header
+	body   map[string]interface{}
+}
+
+func startStatusServer(t *testing.T) (*httptest.Server, *[]*statusRequest) {
+	requests := make([]*statusRequest, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := make(map[string]interface{})
+		check(t, json.NewDecoder(r.Body).Decode(&body))
+		requests = append(requests, &statusRequest{path: r.URL.EscapedPath(), header: r.Header, body: body})
+		if strings.Contains(r.URL.Path, "broken") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+	}))
+
+	return server, &requests
+}
+
+func buildStatusRepo(t *testing.T) (*InvocationSummary, string) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo a"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:  "app-b",
+		Build: map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+	}))
+	check(t, repo.WriteShellScript("app-b/build.sh", "echo compiling\necho 'error: b is broken'\nexit 1"))
+	check(t, repo.Commit("first"))
+
+	logDir := filepath.Join(".tmp", "logs")
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.LogDir = logDir
+	options.SummaryFile = filepath.Join(".tmp", "summary.json")
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	assert.Error(t, err)
+
+	summary, err := ReadInvocationSummary(options.SummaryFile)
+	check(t, err)
+
+	return summary, logDir
+}
+
+func TestCommitStatuses(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	summary, logDir := buildStatusRepo(t)
+
+	statuses, err := commitStatuses(summary, logDir)
+	check(t, err)
+
+	assert.Len(t, statuses, 2)
+	a, b := statuses[0], statuses[1]
+	assert.Equal(t, "mbt/build/app-a", a.Name)
+	assert.Equal(t, ModuleStatusSucceeded, a.Status)
+	assert.Contains(t, a.Title, "app-a succeeded in")
+	assert.Equal(t, "a", a.Log)
+
+	assert.Equal(t, "mbt/build/app-b", b.Name)
+	assert.Equal(t, ModuleStatusFailed, b.Status)
+	assert.Contains(t, b.Title, "app-b failed in")
+	assert.Equal(t, "compiling\nerror: b is broken", b.Log)
+}
+
+func TestCommitStatusesWithoutLogs(t *testing.T) {
+	summary := &InvocationSummary{Command: "test", Modules: []*ModuleSummary{
+		{Name: "app-a", Status: ModuleStatusSkipped},
+		{Name: "app-b", Status: ModuleStatusNotStarted},
+	}}
+
+	statuses, err := commitStatuses(summary, filepath.Join(".tmp", "missing"))
+	check(t, err)
+
+	assert.Equal(t, "mbt/test/app-a", statuses[0].Name)
+	assert.Equal(t, "app-a was skipped", statuses[0].Title)
+	assert.Equal(t, "app-b was not built due to a failure", statuses[1].Title)
+	assert.Empty(t, statuses[1].Log)
+}
+
+func TestLogExcerpt(t *testing.T) {
+	clean()
+	check(t, os.MkdirAll(".tmp", 0755))
+	p := filepath.Join(".tmp", "app.log")
+	check(t, ioutil.WriteFile(p, []byte("1\n2\n3\n4\n"), 0644))
+
+	log, err := logExcerpt(p, 2)
+	check(t, err)
+	assert.Equal(t, "3\n4", log)
+
+	log, err = logExcerpt(p, 10)
+	check(t, err)
+	assert.Equal(t, "1\n2\n3\n4", log)
+}
+
+func TestPublishGitHubCheckRuns(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	server, requests := startStatusServer(t)
+	defer server.Close()
+
+	summary, logDir := buildStatusRepo(t)
+	_, err := PublishCommitStatuses(summary, &CommitStatusOptions{
+		Provider:   StatusGitHub,
+		APIURL:     server.URL,
+		Repository: "acme/mono",
+		Token:      "t0ken",
+		LogDir:     logDir,
+	})
+	check(t, err)
+
+	assert.Len(t, *requests, 2)
+	a, b := (*requests)[0], (*requests)[1]
+	assert.Equal(t, "/repos/acme/mono/check-runs", a.path)
+	assert.Equal(t, "Bearer t0ken", a.header.Get("Authorization"))
+	assert.Equal(t, "mbt/build/app-a", a.body["name"])
+	assert.Equal(t, summary.Commit, a.body["head_sha"])
+	assert.Equal(t, "completed", a.body["status"])
+	assert.Equal(t, "success", a.body["conclusion"])
+
+	assert.Equal(t, "failure", b.body["conclusion"])
+	output := b.body["output"].(map[string]interface{})
+	assert.Contains(t, output["title"], "app-b failed")
+	assert.Equal(t, "```\ncompiling\nerror: b is broken\n```", output["text"])
+}
+
+func TestPublishGitLabStatuses(t *testing.T) {
+	server, requests := startStatusServer(t)
+	defer server.Close()
+
+	os.Setenv("CI_JOB_URL", "https://gitlab.example.com/jobs/1")
+	defer os.Unsetenv("CI_JOB_URL")
+
+	summary := &InvocationSummary{Command: "build", Commit: "abc", Modules: []*ModuleSummary{
+		{Name: "app-a", Status: ModuleStatusSucceeded, Duration: 1},
+		{Name: "app-b", Status: ModuleStatusResumed},
+		{Name: "app-c", Status: ModuleStatusSkipped},
+	}}
+	_, err := PublishCommitStatuses(summary, &CommitStatusOptions{
+		Provider:   StatusGitLab,
+		APIURL:     server.URL + "/api/v4",
+		Repository: "group/mono",
+		Token:      "t0ken",
+	})
+	check(t, err)
+
+	assert.Len(t, *requests, 3)
+	a := (*requests)[0]
+	assert.Equal(t, "/api/v4/projects/group%2Fmono/statuses/abc", a.path)
+	assert.Equal(t, "t0ken", a.header.Get("PRIVATE-TOKEN"))
+	assert.Equal(t, "mbt/build/app-a", a.body["name"])
+	assert.Equal(t, "success", a.body["state"])
+	assert.Equal(t, "app-a succeeded in 1.0s", a.body["description"])
+	assert.Equal(t, "https://gitlab.example.com/jobs/1", a.body["target_url"])
+	assert.Equal(t, "success", (*requests)[1].body["state"])
+	assert.Equal(t, "skipped", (*requests)[2].body["state"])
+}
+
+func TestPublishGitLabStatusOfFailure(t *testing.T) {
+	server, requests := startStatusServer(t)
+	defer server.Close()
+
+	s := &CommitStatus{
+		Name:   "mbt/build/app-a",
+		Status: ModuleStatusFailed,
+		Title:  "app-a failed in 1.0s",
+		Log:    "compiling\n" + strings.Repeat("x", 300),
+	}
+	check(t, publishGitLabStatus(&CommitStatusOptions{APIURL: server.URL, Repository: "1", Sha: "abc"}, s))
+
+	description := (*requests)[0].body["description"].(string)
+	assert.Equal(t, "failed", (*requests)[0].body["state"])
+	assert.Len(t, description, gitLabDescriptionLimit)
+	assert.True(t, strings.HasPrefix(description, "app-a failed in 1.0s | xxx"))
+	assert.True(t, strings.HasSuffix(description, "..."))
+}
+
+func TestPublishCommitStatusFailure(t *testing.T) {
+	server, _ := startStatusServer(t)
+	defer server.Close()
+
+	summary := &InvocationSummary{Command: "build", Commit: "abc", Modules: []*ModuleSummary{{Name: "app-a"}}}
+	_, err := PublishCommitStatuses(summary, &CommitStatusOptions{Provider: StatusGitHub, APIURL: server.URL, Repository: "acme/broken", Token: "t0ken"})
+	assert.EqualError(t, err, "Failed to publish the status of app-a")
+}
+
+func TestPublishCommitStatusesWithUnsupportedProvider(t *testing.T) {
+	_, err := PublishCommitStatuses(&InvocationSummary{}, &CommitStatusOptions{Provider: "jenkins"})
+	assert.EqualError(t, err, "Unsupported status provider jenkins, supported providers are github, gitlab")
+}
+
+func TestPublishCommitStatusesWithoutToken(t *testing.T) {
+	os.Unsetenv("GITLAB_TOKEN")
+
+	_, err := PublishCommitStatuses(&InvocationSummary{Commit: "abc"}, &CommitStatusOptions{Provider: StatusGitLab, Repository: "1"})
+	assert.EqualError(t, err, "Could not determine the token of the statuses, specify it or set GITLAB_TOKEN")
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// completionManifestState is the name of the state document caching
+// the manifest used to complete module names and tags.
+const completionManifestState = "completion-manifest.json"
+
+// Completions are the candidates for completing the arguments of
+// mbt commands in a shell.
+type Completions struct {
+	// Modules are the names of the modules in the current branch.
+	Modules []string
+	// Tags are the values in the tags property of those modules.
+	Tags []string
+	// Refs are the branches and tags in the repository.
+	Refs []string
+}
+
+func (s *stdSystem) Completions() (*Completions, error) {
+	m, err := s.cachedManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := s.Repo.References()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Completions{Modules: make([]string, 0, len(m.Modules)), Tags: make([]string, 0), Refs: refs}
+	tags := make(map[string]bool)
+	for _, mod := range m.Modules {
+		c.Modules = append(c.Modules, mod.Name())
+		values, _ := mod.Properties()[tagsProperty].([]interface{})
+		for _, v := range values {
+			if tag, ok := v.(string); ok && !tags[tag] {
+				tags[tag] = true
+				c.Tags = append(c.Tags, tag)
+			}
+		}
+	}
+
+	sort.Strings(c.Modules)
+	sort.Strings(c.Tags)
+	return c, nil
+}
+
+// cachedManifest returns the manifest of the current branch.
+// Completions are requested on every key press, therefore the manifest
+// is cached in the state directory and discovered again only when the
+// current branch moves to a different commit.
+func (s *stdSystem) cachedManifest() (*Manifest, error) {
+	head, err := s.Repo.CurrentBranchCommit()
+	if err != nil {
+		return nil, err
+	}
+
+	var cached json.RawMessage
+	if err := s.readState(completionManifestState, &cached); err != nil {
+		return nil, err
+	}
+
+	if cached != nil {
+		m, err := importManifest(bytes.NewReader(cached), s.Repo.Path())
+		// Cache is discarded if it cannot be read, for example when it was
+		// written by a version of mbt with a different manifest schema.
+		if err == nil && m.Sha == head.ID() {
+			return m, nil
+		}
+	}
+
+	m, err := s.ManifestByCurrentBranch()
+	if err != nil {
+		return nil, err
+	}
+
+	buff := new(bytes.Buffer)
+	if err := m.Export(buff); err != nil {
+		return nil, err
+	}
+
+	if err := s.writeState(completionManifestState, json.RawMessage(buff.Bytes())); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompletions(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("svc-b", &Spec{Name: "svc-b", Properties: map[string]interface{}{"tags": []interface{}{"frontend", "backend"}}}))
+	check(t, repo.InitModuleWithOptions("svc-a", &Spec{Name: "svc-a", Properties: map[string]interface{}{"tags": []interface{}{"backend"}}}))
+	check(t, repo.InitModuleWithOptions("lib-a", &Spec{Name: "lib-a"}))
+	check(t, repo.Commit("first"))
+	check(t, repo.SwitchToBranch("feature"))
+	check(t, repo.SwitchToBranch("master"))
+
+	c, err := NewWorld(t, ".tmp/repo").System.Completions()
+	check(t, err)
+
+	assert.Equal(t, []string{"lib-a", "svc-a", "svc-b"}, c.Modules)
+	assert.Equal(t, []string{"backend", "frontend"}, c.Tags)
+	assert.Equal(t, []string{"feature", "master"}, c.Refs)
+}
+
+func TestCompletionsCacheManifest(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	c, err := NewWorld(t, ".tmp/repo").System.Completions()
+	check(t, err)
+	assert.Equal(t, []string{"app-a"}, c.Modules)
+
+	buff, err := ioutil.ReadFile(filepath.Join(repo.Dir, ".git", stateDirName, completionManifestState))
+	check(t, err)
+	assert.Contains(t, string(buff), repo.LastCommit.String())
+
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.Commit("second"))
+
+	c, err = NewWorld(t, ".tmp/repo").System.Completions()
+	check(t, err)
+	assert.Equal(t, []string{"app-a", "app-b"}, c.Modules)
+}
+
+func TestCompletionsUseCachedManifest(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.Completions()
+	check(t, err)
+
+	world := NewWorld(t, ".tmp/repo")
+	world.ManifestBuilder.Interceptor.Config("ByCurrentBranch").Return(nil, errors.New("doh"))
+
+	c, err := world.System.Completions()
+	check(t, err)
+	assert.Equal(t, []string{"app-a"}, c.Modules)
+}
+
+func TestCompletionsDiscardInvalidCache(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+	check(t, repo.WriteContent(filepath.Join(".git", stateDirName, completionManifestState), `{"schemaVersion": 100}`))
+
+	c, err := NewWorld(t, ".tmp/repo").System.Completions()
+	check(t, err)
+	assert.Equal(t, []string{"app-a"}, c.Modules)
+}
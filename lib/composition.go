@@ -0,0 +1,338 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	yaml "github.com/go-yaml/yaml"
+	"github.com/mbtproject/mbt/e"
+)
+
+// CompositionFile is the default name of the file describing a
+// multi-repo workspace.
+const CompositionFile = "mbt.workspace.yml"
+
+// Composition is a multi-repo workspace, a set of repositories whose
+// modules are composed into a single graph.
+// Modules are named <repo>/<module> in the composed graph and modules
+// can depend on the modules in other repositories with
+// workspaceDependencies in their specs.
+type Composition struct {
+	// Dir is the directory of the workspace file. Paths of the
+	// repositories are relative to this directory.
+	Dir   string          `yaml:"-"`
+	Repos []*ComposedRepo `yaml:"repos"`
+}
+
+// ComposedRepo is a repository in a Composition.
+type ComposedRepo struct {
+	// Name of the repository used as the prefix of the names of its
+	// modules.
+	Name string `yaml:"name"`
+	// Path of the repository relative to the workspace file.
+	Path string `yaml:"path,omitempty"`
+	// URL of a remote repository. Remote repositories are fetched into
+	// the cache directory (see --in).
+	URL string `yaml:"url,omitempty"`
+	// Ref is the revision (branch, tag or commit) of the repository.
+	// Defaults to the working directory of local repositories and the
+	// default branch of remote repositories.
+	Ref string `yaml:"ref,omitempty"`
+	// Base is the revision the changes in Ref are compared with to
+	// find the affected modules. Modules of the repository are not
+	// affected by its own changes if this is not specified.
+	Base string `yaml:"base,omitempty"`
+
+	system System
+}
+
+// ComposedBuildSummary is the summary of building the modules of a
+// repository in a Composition.
+type ComposedBuildSummary struct {
+	Repo    string
+	Summary *BuildSummary
+}
+
+// OpenComposition reads the workspace file and opens the repositories
+// in it.
+func OpenComposition(file string, logLevel int) (*Composition, error) {
+	buff, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedReadFile, file)
+	}
+
+	c, err := parseComposition(buff, file)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range c.Repos {
+		location := r.URL
+		if location == "" {
+			location = filepath.Join(c.Dir, r.Path)
+		}
+
+		r.system, err = NewSystem(location, logLevel)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func parseComposition(buff []byte, file string) (*Composition, error) {
+	c := &Composition{Dir: filepath.Dir(file)}
+	if err := yaml.Unmarshal(buff, c); err != nil {
+		return nil, configError(e.Wrapf(ErrClassUser, err, msgFailedConfigParse, file))
+	}
+
+	names := make(map[string]bool)
+	for _, r := range c.Repos {
+		if r.Name == "" || strings.Contains(r.Name, "/") {
+			return nil, configError(e.NewErrorf(ErrClassUser, msgInvalidComposedRepoName, r.Name, file))
+		}
+		if names[r.Name] {
+			return nil, configError(e.NewErrorf(ErrClassUser, msgDuplicateComposedRepo, r.Name, file))
+		}
+		if (r.Path == "") == (r.URL == "") {
+			return nil, configError(e.NewErrorf(ErrClassUser, msgInvalidComposedRepo, r.Name, file))
+		}
+		names[r.Name] = true
+	}
+
+	return c, nil
+}
+
+// Close closes the repositories in the composition.
+func (c *Composition) Close() error {
+	var err error
+	for _, r := range c.Repos {
+		if r.system == nil {
+			continue
+		}
+		if cerr := r.system.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Manifest returns the composed manifest of all modules in the
+// repositories.
+func (c *Composition) Manifest() (*Manifest, error) {
+	set := make(moduleMetadataSet, 0)
+	for _, r := range c.Repos {
+		m, err := r.manifest()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, a := range m.Modules {
+			set = append(set, r.composedMetadata(a))
+		}
+	}
+
+	mods, err := toModules(set)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manifest{Dir: c.Dir, Modules: mods}, nil
+}
+
+// Affected returns the composed manifest of the modules affected by
+// the changes between the base and ref of each repository and the
+// modules depending on them in any repository.
+// bases override the base revisions in the workspace file keyed by the
+// name of the repository.
+func (c *Composition) Affected(bases map[string]string) (*Manifest, error) {
+	for name := range bases {
+		if c.repo(name) == nil {
+			return nil, e.NewErrorf(ErrClassUser, msgComposedRepoNotFound, name)
+		}
+	}
+
+	m, err := c.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	changed := make(map[string]bool)
+	for _, r := range c.Repos {
+		base := r.Base
+		if b, ok := bases[r.Name]; ok {
+			base = b
+		}
+		if base == "" {
+			continue
+		}
+
+		mods, err := r.changes(base)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range mods {
+			changed[r.qualify(a.Name())] = true
+		}
+	}
+
+	affected := make(Modules, 0)
+	for _, a := range m.Modules {
+		if changed[a.Name()] {
+			affected = append(affected, a)
+		}
+	}
+
+	affected, err = affected.expandRequiredByDependencies()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manifest{Dir: c.Dir, Modules: affected}, nil
+}
+
+// Build builds the modules in a composed manifest in topological order.
+// Consecutive modules of a repository are built together with the
+// options of a build of that repository. Build stops at the first
+// repository failing to build.
+func (c *Composition) Build(m *Manifest, options *CmdOptions) ([]*ComposedBuildSummary, error) {
+	summaries := make([]*ComposedBuildSummary, 0)
+	var (
+		current *ComposedRepo
+		names   []string
+	)
+
+	build := func() error {
+		if current == nil {
+			return nil
+		}
+
+		var (
+			summary *BuildSummary
+			err     error
+		)
+		filter := &FilterOptions{Name: strings.Join(names, ",")}
+		switch {
+		case current.URL != "":
+			return e.NewErrorf(ErrClassUser, msgComposedRepoNotBuildable, current.Name)
+		case current.Ref == "":
+			summary, err = current.system.BuildWorkspace(filter, options)
+		default:
+			summary, err = current.system.BuildCommit(current.Ref, filter, options)
+		}
+		if summary != nil {
+			summaries = append(summaries, &ComposedBuildSummary{Repo: current.Name, Summary: summary})
+		}
+		return err
+	}
+
+	for _, a := range m.Modules {
+		parts := strings.SplitN(a.Name(), "/", 2)
+		r := c.repo(parts[0])
+		if r == nil || len(parts) < 2 {
+			return summaries, e.NewErrorf(ErrClassUser, msgComposedRepoNotFound, parts[0])
+		}
+
+		if r != current {
+			if err := build(); err != nil {
+				return summaries, err
+			}
+			current, names = r, nil
+		}
+		names = append(names, parts[1])
+	}
+
+	return summaries, build()
+}
+
+func (c *Composition) repo(name string) *ComposedRepo {
+	for _, r := range c.Repos {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+func (r *ComposedRepo) qualify(name string) string {
+	return r.Name + "/" + name
+}
+
+// manifest returns the manifest of all modules in the ref of the
+// repository.
+func (r *ComposedRepo) manifest() (*Manifest, error) {
+	switch {
+	case r.Ref != "":
+		return r.system.ManifestByCommit(r.Ref)
+	case r.URL != "":
+		return r.system.ManifestByCurrentBranch()
+	default:
+		return r.system.ManifestByWorkspace()
+	}
+}
+
+// changes returns the modules changed between base and the ref of the
+// repository including the changes in the working directory of local
+// repositories without a ref.
+func (r *ComposedRepo) changes(base string) (Modules, error) {
+	head := r.Ref
+	if head == "" {
+		head = "HEAD"
+	}
+
+	m, err := r.system.ManifestByDiff(base, head)
+	if err != nil {
+		return nil, err
+	}
+
+	mods := m.Modules
+	if r.Ref == "" && r.URL == "" {
+		w, err := r.system.ManifestByWorkspaceChanges()
+		if err != nil {
+			return nil, err
+		}
+		mods = append(mods, w.Modules...)
+	}
+
+	return mods, nil
+}
+
+// composedMetadata creates the metadata of a module in the composed
+// graph, in which names of the module and its dependencies are
+// qualified with the names of their repositories.
+func (r *ComposedRepo) composedMetadata(a *Module) *moduleMetadata {
+	spec := *a.metadata.spec
+	spec.Name = r.qualify(a.Name())
+	spec.Dependencies = make([]string, 0, len(a.Requires())+len(spec.WorkspaceDependencies))
+	for _, d := range a.Requires() {
+		spec.Dependencies = append(spec.Dependencies, r.qualify(d.Name()))
+	}
+	spec.Dependencies = append(spec.Dependencies, spec.WorkspaceDependencies...)
+
+	return &moduleMetadata{
+		dir:                 path.Join(r.Name, a.Path()),
+		hash:                a.Hash(),
+		spec:                &spec,
+		dependentFileHashes: a.metadata.dependentFileHashes,
+		specContent:         a.metadata.specContent,
+	}
+}
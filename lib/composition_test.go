@@ -0,0 +1,233 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+// initComposition creates a workspace in .tmp/ws with libs and services
+// repositories. services/app-a depends on libs/lib-a.
+func initComposition(t *testing.T, workspace string) (libs, services *TestRepository) {
+	clean()
+	libs, err := createTestRepository(".tmp/ws/libs")
+	check(t, err)
+	services, err = createTestRepository(".tmp/ws/services")
+	check(t, err)
+
+	build := map[string]*Cmd{"linux": {Cmd: "./build.sh"}, "darwin": {Cmd: "./build.sh"}}
+	check(t, libs.InitModuleWithOptions("lib-a", &Spec{Name: "lib-a", Build: build}))
+	check(t, libs.WriteShellScript("lib-a/build.sh", "echo built lib-a"))
+	check(t, libs.InitModuleWithOptions("lib-b", &Spec{Name: "lib-b", Build: build}))
+	check(t, libs.WriteShellScript("lib-b/build.sh", "echo built lib-b"))
+	check(t, libs.Commit("first"))
+
+	check(t, services.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Build: build, WorkspaceDependencies: []string{"libs/lib-a"}}))
+	check(t, services.WriteShellScript("app-a/build.sh", "echo built app-a"))
+	check(t, services.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Build: build, Dependencies: []string{"app-a"}}))
+	check(t, services.WriteShellScript("app-b/build.sh", "echo built app-b"))
+	check(t, services.InitModuleWithOptions("app-c", &Spec{Name: "app-c", Build: build}))
+	check(t, services.WriteShellScript("app-c/build.sh", "echo built app-c"))
+	check(t, services.Commit("first"))
+
+	check(t, ioutil.WriteFile(filepath.Join(".tmp/ws", CompositionFile), []byte(workspace), 0644))
+	return libs, services
+}
+
+const testComposition = `
+repos:
+  - name: libs
+    path: libs
+  - name: services
+    path: services
+`
+
+func openTestComposition(t *testing.T) *Composition {
+	c, err := OpenComposition(filepath.Join(".tmp/ws", CompositionFile), LogLevelNormal)
+	check(t, err)
+	return c
+}
+
+func TestComposedManifest(t *testing.T) {
+	initComposition(t, testComposition)
+	c := openTestComposition(t)
+	defer c.Close()
+
+	m, err := c.Manifest()
+	check(t, err)
+
+	assert.Equal(t, ".tmp/ws", m.Dir)
+	names := moduleNames(m.Modules)
+	sort.Strings(names)
+	assert.Equal(t, []string{"libs/lib-a", "libs/lib-b", "services/app-a", "services/app-b", "services/app-c"}, names)
+
+	mods := m.Modules.indexByName()
+	assert.Equal(t, "services/app-a", mods["services/app-a"].Path())
+	assert.Equal(t, Modules{mods["libs/lib-a"]}, mods["services/app-a"].Requires())
+	assert.Equal(t, Modules{mods["services/app-a"]}, mods["libs/lib-a"].RequiredBy())
+	assert.Equal(t, Modules{mods["services/app-a"]}, mods["services/app-b"].Requires())
+	assert.Equal(t, "local", mods["services/app-b"].Version())
+}
+
+func TestComposedManifestOfRefs(t *testing.T) {
+	libs, services := initComposition(t, "")
+	check(t, ioutil.WriteFile(filepath.Join(".tmp/ws", CompositionFile), []byte(`
+repos:
+  - name: libs
+    path: libs
+    ref: `+libs.LastCommit.String()+`
+  - name: services
+    path: services
+    ref: master
+`), 0644))
+
+	c := openTestComposition(t)
+	defer c.Close()
+
+	m, err := c.Manifest()
+	check(t, err)
+
+	mods := m.Modules.indexByName()
+	libA, err := NewWorld(t, ".tmp/ws/libs").System.ManifestByCommit(libs.LastCommit.String())
+	check(t, err)
+	assert.Equal(t, libA.Modules.indexByName()["lib-a"].Version(), mods["libs/lib-a"].Version())
+
+	appC, err := NewWorld(t, ".tmp/ws/services").System.ManifestByCommit(services.LastCommit.String())
+	check(t, err)
+	assert.Equal(t, appC.Modules.indexByName()["app-c"].Version(), mods["services/app-c"].Version())
+	assert.NotEqual(t, "local", mods["services/app-a"].Version())
+}
+
+func TestMissingWorkspaceDependency(t *testing.T) {
+	initComposition(t, `
+repos:
+  - name: services
+    path: services
+`)
+	c := openTestComposition(t)
+	defer c.Close()
+
+	_, err := c.Manifest()
+	assert.EqualError(t, err, "dependency not found services/app-a -> libs/lib-a")
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestComposedAffected(t *testing.T) {
+	libs, _ := initComposition(t, testComposition)
+	base := libs.LastCommit.String()
+	check(t, libs.WriteContent("lib-a/main.go", "package main"))
+	check(t, libs.Commit("second"))
+
+	c := openTestComposition(t)
+	defer c.Close()
+
+	m, err := c.Affected(nil)
+	check(t, err)
+	assert.Empty(t, m.Modules)
+
+	m, err = c.Affected(map[string]string{"libs": base})
+	check(t, err)
+	assert.Equal(t, []string{"libs/lib-a", "services/app-a", "services/app-b"}, moduleNames(m.Modules))
+
+	check(t, libs.WriteContent("lib-b/main.go", "package main"))
+	m, err = c.Affected(map[string]string{"libs": "HEAD"})
+	check(t, err)
+	assert.Equal(t, []string{"libs/lib-b"}, moduleNames(m.Modules))
+
+	_, err = c.Affected(map[string]string{"ui": "HEAD"})
+	assert.EqualError(t, err, "Repository ui is not in the workspace")
+}
+
+func TestComposedAffectedWithBaseInWorkspaceFile(t *testing.T) {
+	_, services := initComposition(t, "")
+	check(t, ioutil.WriteFile(filepath.Join(".tmp/ws", CompositionFile), []byte(`
+repos:
+  - name: libs
+    path: libs
+  - name: services
+    path: services
+    base: `+services.LastCommit.String()+`
+`), 0644))
+	check(t, services.WriteContent("app-c/main.go", "package main"))
+	check(t, services.Commit("second"))
+
+	c := openTestComposition(t)
+	defer c.Close()
+
+	m, err := c.Affected(nil)
+	check(t, err)
+	assert.Equal(t, []string{"services/app-c"}, moduleNames(m.Modules))
+}
+
+func TestComposedBuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initComposition(t, testComposition)
+	c := openTestComposition(t)
+	defer c.Close()
+
+	m, err := c.Manifest()
+	check(t, err)
+
+	stdout := new(bytes.Buffer)
+	summaries, err := c.Build(m, stdTestCmdOptions(stdout))
+	check(t, err)
+
+	assert.Len(t, summaries, 2)
+	assert.Equal(t, "libs", summaries[0].Repo)
+	assert.Equal(t, []string{"lib-a", "lib-b"}, moduleNames(summaries[0].Summary.Manifest.Modules))
+	assert.Equal(t, "services", summaries[1].Repo)
+	assert.Equal(t, []string{"app-a", "app-b", "app-c"}, moduleNames(summaries[1].Summary.Manifest.Modules))
+	assert.Equal(t, "built lib-a\nbuilt lib-b\nbuilt app-a\nbuilt app-b\nbuilt app-c\n", stdout.String())
+}
+
+func TestComposedBuildOfRemoteRepo(t *testing.T) {
+	initComposition(t, testComposition)
+	c := openTestComposition(t)
+	defer c.Close()
+	c.Repos[0].URL = "https://github.com/acme/libs.git"
+
+	m, err := c.Manifest()
+	check(t, err)
+
+	_, err = c.Build(m, stdTestCmdOptions(new(bytes.Buffer)))
+	assert.EqualError(t, err, "Modules of repository libs cannot be built since it is not checked out locally")
+}
+
+func TestInvalidComposition(t *testing.T) {
+	cases := map[string]string{
+		"repos: [{path: libs}]":                                   "Invalid repository name '' in ws.yml, names must not be empty or contain /",
+		"repos: [{name: a/b, path: libs}]":                        "Invalid repository name 'a/b' in ws.yml, names must not be empty or contain /",
+		"repos: [{name: libs, path: a}, {name: libs, path: b}]":   "Repository libs is listed more than once in ws.yml",
+		"repos: [{name: libs}]":                                   "Repository libs in ws.yml must specify either a path or a url",
+		"repos: [{name: libs, path: a, url: https://acme.com/a}]": "Repository libs in ws.yml must specify either a path or a url",
+	}
+
+	for content, msg := range cases {
+		_, err := parseComposition([]byte(content), "ws.yml")
+		assert.EqualError(t, err, msg, content)
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	yaml "github.com/go-yaml/yaml"
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	configDir  = ".mbt"
+	configFile = "config.yml"
+	// defaultPartialsDir is the directory of partial templates used
+	// when it is not specified in the configuration.
+	defaultPartialsDir = ".mbt/partials"
+)
+
+// loadRepoConfig reads the repository configuration from the
+// specified workspace directory.
+// Configuration file is optional, therefore an empty configuration
+// is returned if it does not exist.
+func loadRepoConfig(dir string) (*RepoConfig, error) {
+	return loadConfigFile(filepath.Join(dir, configDir, configFile))
+}
+
+// loadConfigFile reads the configuration in the specified file.
+// An empty configuration is returned if the file does not exist.
+func loadConfigFile(p string) (*RepoConfig, error) {
+	buff, err := ioutil.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &RepoConfig{}, nil
+		}
+		return nil, e.Wrapf(ErrClassInternal, err, msgFailedReadFile, p)
+	}
+
+	return parseRepoConfig(buff, p)
+}
+
+func parseRepoConfig(buff []byte, path string) (*RepoConfig, error) {
+	config := &RepoConfig{}
+	err := yaml.Unmarshal(buff, config)
+	if err != nil {
+		return nil, configError(yamlError(e.Wrapf(ErrClassUser, err, msgFailedConfigParse, path), path, err))
+	}
+
+	return config, nil
+}
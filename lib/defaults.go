@@ -0,0 +1,80 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"embed"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// builtinTemplateSource is the name of the template source embedded
+// in the binary. Declaring a template source with the same name in
+// templateSources of the repository configuration overrides it.
+const builtinTemplateSource = "mbt"
+
+//go:embed defaults
+var embeddedDefaults embed.FS
+
+// builtinTemplate reads a template embedded in the binary if ref refers
+// to the builtin template source.
+func builtinTemplate(ref string) (*templateFile, error) {
+	if !strings.HasPrefix(ref, builtinTemplateSource+":") {
+		return nil, nil
+	}
+
+	templatePath := strings.TrimPrefix(ref, builtinTemplateSource+":")
+	content, err := embeddedDefaults.ReadFile(path.Join("defaults/templates", path.Clean("/"+templatePath)))
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgTemplateNotFoundInSource, templatePath, builtinTemplateSource)
+	}
+
+	return &templateFile{path: templatePath, content: content}, nil
+}
+
+// defaultPartials returns the partials embedded in the binary. They are
+// available to all templates and partials of the repository or a
+// template source take precedence over them.
+func defaultPartials() []*partial {
+	const root = "defaults/partials"
+	partials := make([]*partial, 0)
+	err := fs.WalkDir(embeddedDefaults, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		content, err := embeddedDefaults.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		partials = append(partials, &partial{name: strings.TrimPrefix(p, root+"/"), content: content})
+		return nil
+	})
+	if err != nil {
+		// Embedded content is verified at compile time.
+		panic(err)
+	}
+
+	sort.Slice(partials, func(i, j int) bool {
+		return partials[i].name < partials[j].name
+	})
+
+	return partials
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyBuiltinTemplate(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.Commit("first"))
+
+	w := NewWorld(t, ".tmp/repo")
+	m, err := w.System.ManifestByCurrentBranch()
+	check(t, err)
+
+	output := new(bytes.Buffer)
+	check(t, w.System.ApplyHead("mbt:versions.json.tmpl", output))
+
+	versions := make(map[string]string)
+	check(t, json.Unmarshal(output.Bytes(), &versions))
+	assert.Equal(t, map[string]string{
+		"app-a": m.Modules.indexByName()["app-a"].Version(),
+		"app-b": m.Modules.indexByName()["app-b"].Version(),
+	}, versions)
+}
+
+func TestDefaultPartialsInRepoTemplate(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent("template.tmpl", `{{template "mbt.versions" .ModulesList}}`))
+	check(t, repo.Commit("first"))
+
+	output := new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyLocal("template.tmpl", output))
+	assert.Contains(t, output.String(), `"app-a": `)
+
+	// Partials of the repository take precedence.
+	check(t, repo.WriteContent(".mbt/partials/versions.tmpl", `{{define "mbt.versions"}}local{{end}}`))
+	output = new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyLocal("template.tmpl", output))
+	assert.Equal(t, "local", output.String())
+}
+
+func TestBuiltinTemplateOverriddenByRepoConfig(t *testing.T) {
+	clean()
+	templates, url := initTemplateSourceRepo(t)
+	check(t, templates.WriteContent("versions.json.tmpl", `overridden`))
+	check(t, templates.Commit("second"))
+
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteConfig(&RepoConfig{TemplateSources: map[string]*TemplateSource{
+		"mbt": {Git: url},
+	}}))
+	check(t, repo.Commit("first"))
+
+	output := new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHead("mbt:versions.json.tmpl", output))
+	assert.Equal(t, "overridden", output.String())
+}
+
+func TestBuiltinTemplateNotFound(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	err := NewWorld(t, ".tmp/repo").System.ApplyHead("mbt:missing.tmpl", new(bytes.Buffer))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgTemplateNotFoundInSource, "missing.tmpl", "mbt"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
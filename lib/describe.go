@@ -0,0 +1,146 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"text/template"
+
+	yaml "github.com/go-yaml/yaml"
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// DescriptionSchemaVersion is the version of the schema of
+	// Description. It is incremented for changes that are not
+	// backwards compatible.
+	DescriptionSchemaVersion = 1
+	// DescriptionFormatJSON formats a description as json.
+	DescriptionFormatJSON = "json"
+	// DescriptionFormatYAML formats a description as yaml.
+	DescriptionFormatYAML = "yaml"
+	// DescriptionFormatTemplate formats a description with a go
+	// template (see WriteTemplate).
+	DescriptionFormatTemplate = "template"
+)
+
+// Description is the machine readable description of a set of modules.
+type Description struct {
+	SchemaVersion int                  `json:"schemaVersion" yaml:"schemaVersion"`
+	Modules       []*ModuleDescription `json:"modules" yaml:"modules"`
+}
+
+// ModuleDescription describes a module.
+// Dependencies and Dependents are the names of the modules directly
+// required by and requiring the module.
+type ModuleDescription struct {
+	Name             string                 `json:"name" yaml:"name"`
+	Path             string                 `json:"path" yaml:"path"`
+	Version          string                 `json:"version" yaml:"version"`
+	Properties       map[string]interface{} `json:"properties" yaml:"properties"`
+	Dependencies     []string               `json:"dependencies" yaml:"dependencies"`
+	Dependents       []string               `json:"dependents" yaml:"dependents"`
+	FileDependencies []string               `json:"fileDependencies" yaml:"fileDependencies"`
+	// Issues are the keys of the issues referenced by the commits
+	// changing the module when a range of commits is described.
+	Issues []string `json:"issues,omitempty" yaml:"issues,omitempty"`
+}
+
+// Describe creates the description of the modules in the same order.
+func (l Modules) Describe() *Description {
+	d := &Description{SchemaVersion: DescriptionSchemaVersion, Modules: make([]*ModuleDescription, 0, len(l))}
+	for _, a := range l {
+		properties := a.Properties()
+		if properties == nil {
+			properties = make(map[string]interface{})
+		}
+
+		fileDependencies := append([]string{}, a.FileDependencies()...)
+		sort.Strings(fileDependencies)
+
+		d.Modules = append(d.Modules, &ModuleDescription{
+			Name:             a.Name(),
+			Path:             a.Path(),
+			Version:          a.Version(),
+			Properties:       properties,
+			Dependencies:     sortedNames(a.Requires()),
+			Dependents:       sortedNames(a.RequiredBy()),
+			FileDependencies: fileDependencies,
+		})
+	}
+
+	return d
+}
+
+// WithIssues sets the issues of the modules described from the keys
+// of the issues of each module (see System.Issues).
+func (d *Description) WithIssues(issues map[string][]string) *Description {
+	for _, m := range d.Modules {
+		m.Issues = issues[m.Name]
+	}
+	return d
+}
+
+// Write writes the description to w in the specified format.
+func (d *Description) Write(format string, w io.Writer) error {
+	var (
+		buff []byte
+		err  error
+	)
+
+	switch format {
+	case DescriptionFormatJSON:
+		buff, err = json.MarshalIndent(d, "", "  ")
+		buff = append(buff, '\n')
+	case DescriptionFormatYAML:
+		buff, err = yaml.Marshal(d)
+	default:
+		return e.NewErrorf(ErrClassUser, msgUnsupportedDescriptionFormat, format)
+	}
+
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	if _, err := w.Write(buff); err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	return nil
+}
+
+// WriteTemplate executes the go template with the description and
+// writes the output to w (e.g. {{range .Modules}}{{.Name}}{{"\n"}}{{end}}).
+// Sprig compatible functions are available to the template.
+func (d *Description) WriteTemplate(text string, w io.Writer) error {
+	t, err := template.New("format").Funcs(sprigFuncs()).Parse(text)
+	if err != nil {
+		return e.Wrapf(ErrClassUser, err, msgInvalidFormatTemplate)
+	}
+
+	if err := t.Execute(w, d); err != nil {
+		return e.Wrapf(ErrClassUser, err, msgFailedFormatTemplate)
+	}
+
+	return nil
+}
+
+func sortedNames(l Modules) []string {
+	names := moduleNames(l)
+	sort.Strings(names)
+	return names
+}
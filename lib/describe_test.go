@@ -0,0 +1,133 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDescribeAsJSON(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Properties: map[string]interface{}{"replicas": 2}, FileDependencies: []string{"lib-b", "lib-a"}}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Dependencies: []string{"app-a"}}))
+	check(t, repo.WriteContent("lib-a/a.go", "a"))
+	check(t, repo.WriteContent("lib-b/b.go", "b"))
+	check(t, repo.Commit("first"))
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	check(t, m.Modules.Describe().Write(DescriptionFormatJSON, buff))
+
+	assert.Equal(t, fmt.Sprintf(`{
+  "schemaVersion": 1,
+  "modules": [
+    {
+      "name": "app-a",
+      "path": "app-a",
+      "version": "%s",
+      "properties": {
+        "replicas": 2
+      },
+      "dependencies": [],
+      "dependents": [
+        "app-b"
+      ],
+      "fileDependencies": [
+        "lib-a",
+        "lib-b"
+      ]
+    },
+    {
+      "name": "app-b",
+      "path": "app-b",
+      "version": "%s",
+      "properties": {},
+      "dependencies": [
+        "app-a"
+      ],
+      "dependents": [],
+      "fileDependencies": []
+    }
+  ]
+}
+`, m.Modules[0].Version(), m.Modules[1].Version()), buff.String())
+}
+
+func TestDescribeAsYAML(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Properties: map[string]interface{}{"replicas": 2}}))
+	check(t, repo.Commit("first"))
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	check(t, m.Modules.Describe().Write(DescriptionFormatYAML, buff))
+
+	assert.Equal(t, fmt.Sprintf(`schemaVersion: 1
+modules:
+- name: app-a
+  path: app-a
+  version: %s
+  properties:
+    replicas: 2
+  dependencies: []
+  dependents: []
+  fileDependencies: []
+`, m.Modules[0].Version()), buff.String())
+}
+
+func TestDescribeInUnsupportedFormat(t *testing.T) {
+	err := Modules{}.Describe().Write("xml", new(bytes.Buffer))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgUnsupportedDescriptionFormat, "xml"))
+}
+
+func TestDescribeWithTemplate(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Properties: map[string]interface{}{"tags": []interface{}{"web"}}}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Dependencies: []string{"app-a"}}))
+	check(t, repo.Commit("first"))
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	check(t, m.Modules.Describe().WriteTemplate(`{{range .Modules}}{{.Name}}:{{.Version | trunc 7}}:{{toJson .Dependencies}}:{{index .Properties "tags"}}{{"\n"}}{{end}}`, buff))
+
+	assert.Equal(t, fmt.Sprintf("app-a:%s:[]:[web]\napp-b:%s:[\"app-a\"]:<no value>\n", m.Modules[0].Version()[:7], m.Modules[1].Version()[:7]), buff.String())
+}
+
+func TestDescribeWithInvalidTemplate(t *testing.T) {
+	err := Modules{}.Describe().WriteTemplate(`{{range .Modules}}`, new(bytes.Buffer))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), msgInvalidFormatTemplate)
+
+	err = Modules{}.Describe().WriteTemplate(`{{.Name}}`, new(bytes.Buffer))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), msgFailedFormatTemplate)
+}
@@ -0,0 +1,398 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	yaml "github.com/go-yaml/yaml"
+	"github.com/mbtproject/mbt/e"
+)
+
+// Severities of diagnostics.
+const (
+	// SeverityError indicates an issue that failed the command.
+	SeverityError = "error"
+	// SeverityWarning indicates an issue that did not fail the command
+	// (e.g. in the report of doctor).
+	SeverityWarning = "warning"
+)
+
+// Formats of diagnostics.
+const (
+	// DiagnosticsFormatText formats diagnostics for humans.
+	DiagnosticsFormatText = "text"
+	// DiagnosticsFormatJSON formats each diagnostic as a json object
+	// on a line.
+	DiagnosticsFormatJSON = "json"
+)
+
+// Generic diagnostic codes used for the errors without a specific code.
+const (
+	// DiagnosticCodeUnknown is the code of errors the user can correct
+	// (e.g. invalid arguments) that do not have a specific code.
+	DiagnosticCodeUnknown = "MBT0000"
+	// DiagnosticCodeInternal is the code of unexpected errors.
+	DiagnosticCodeInternal = "MBT0001"
+	// DiagnosticCodeCommandFailed is the code of errors caused by a
+	// failed command (e.g. a build command exiting with a non zero
+	// status).
+	DiagnosticCodeCommandFailed = "MBT0002"
+)
+
+// Diagnostic describes an error reported by mbt with a stable code, so
+// that tools can recognise it regardless of the language or wording of
+// the message.
+type Diagnostic struct {
+	// Code identifies the kind of the error (e.g. MBT1003). Codes are
+	// never reused for a different kind of error.
+	Code     string `json:"code"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	// Causes are the messages of the errors that led to this error,
+	// from the outermost to the innermost.
+	Causes []string `json:"causes,omitempty"`
+	// File is the path of the file the error is attributed to (e.g. the
+	// spec of a module), relative to the repository root where possible.
+	File string `json:"file,omitempty"`
+	// Line and Column are 1 based positions in File, 0 when unknown.
+	Line   int `json:"line,omitempty"`
+	Column int `json:"column,omitempty"`
+	// Suggestion describes how to fix the error.
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+type diagnosticInfo struct {
+	code       string
+	suggestion string
+}
+
+// diagnosticCodes are the codes of errors keyed by the format of their
+// message.
+// Codes are grouped by area: 1xxx for specs and configuration, 2xxx
+// for the repository, 3xxx for builds and commands, 4xxx for templates
+// and queries and 5xxx for plugins. Once released, a code must not be
+// changed or reused.
+var diagnosticCodes = map[string]diagnosticInfo{
+	msgFailedSpecParseAt:         {"MBT1001", "Correct the yaml syntax of the spec"},
+	msgFailedConfigParse:         {"MBT1002", "Correct the yaml syntax of the configuration"},
+	msgFileDependencyNotFound:    {"MBT1003", "Specify the path of the file dependency relative to the repository root"},
+	msgDependencyNotFound:        {"MBT1004", "Add a module with this name or remove it from the dependencies"},
+	msgCyclicDependency:          {"MBT1005", "Remove one of the dependencies in the path"},
+	msgModuleNameConflict:        {"MBT1006", "Rename one of the modules, names of modules must be unique"},
+	msgInvalidProperties:         {"MBT1007", "Correct the properties of the module or propertiesSchema in .mbt/config.yml"},
+	msgInvalidPropertySchema:     {"MBT1008", "Correct propertiesSchema in .mbt/config.yml"},
+	msgHostEnvNotAllowed:         {"MBT1009", "Add the variable to hostEnv in .mbt/config.yml"},
+	msgInvalidModuleMemory:       {"MBT1010", "Specify the memory in bytes or with a unit (e.g. 512Mi or 8Gi)"},
+	msgUnsupportedShell:          {"MBT1011", "Use sh, bash, powershell, pwsh or cmd"},
+	msgProfileNotFound:           {"MBT1012", "Add the profile to profiles in .mbt/config.yml or select another profile"},
+	msgInvalidFlagDefault:        {"MBT1013", ""},
+	msgInvalidManifest:           {"MBT1014", "Export the manifest again with mbt describe export"},
+	msgUnsupportedManifestSchema: {"MBT1015", "Export the manifest again with this version of mbt"},
+	msgInvalidPublishTarget:      {"MBT1016", ""},
+
+	msgInvalidSha:                       {"MBT2001", "Specify the full or abbreviated sha of a commit"},
+	msgCommitShaNotFound:                {"MBT2002", "Fetch the commit or check the sha"},
+	msgFailedOpenRepo:                   {"MBT2003", "Run mbt inside a git repository or specify its path with --in"},
+	msgFailedBranchLookup:               {"MBT2004", "Specify an existing local branch or fetch it"},
+	msgFailedBranchLookupInDetachedHead: {"MBT2005", ""},
+	msgDetachedHead:                     {"MBT2006", ""},
+	msgDirtyWorkingDir:                  {"MBT2007", "Commit or stash the changes, or use the local variants of commands (e.g. build local)"},
+	msgCommitNotFoundInShallowClone:     {"MBT2008", ""},
+	msgMergeBaseNotFoundInShallowClone:  {"MBT2009", ""},
+	msgRevisionNotFoundInShallowClone:   {"MBT2010", ""},
+	msgParentNotFoundInShallowClone:     {"MBT2011", ""},
+	msgPartialCloneNotSupported:         {"MBT2012", ""},
+	msgSubmoduleNotInitialised:          {"MBT2013", ""},
+	msgNonConeSparseCheckout:            {"MBT2014", ""},
+	msgCheckoutInSparseCheckout:         {"MBT2015", ""},
+	msgNoWorkingTree:                    {"MBT2016", ""},
+	msgRemoteAuthFailed:                 {"MBT2017", ""},
+	msgRevisionNotFound:                 {"MBT2018", "Specify an existing branch, tag or commit"},
+	msgFailedMergeBase:                  {"MBT2019", "Make sure the commits share history"},
+
+	msgFailedBuild:           {"MBT3001", "See the output of the module for the cause of the failure"},
+	msgFailedBuildVariant:    {"MBT3002", "See the output of the variant for the cause of the failure"},
+	msgFailedHook:            {"MBT3003", "See the output of the hook for the cause of the failure"},
+	msgFailedCommandTemplate: {"MBT3004", "Correct the template in cmd or args of the command"},
+	msgPolicyViolation:       {"MBT3005", "Change the command or the modules to satisfy the policies in .mbt/policies"},
+	msgPolicyOpaNotFound:     {"MBT3006", "Install opa or remove the policies"},
+	msgPolicyEvalFailed:      {"MBT3007", "Correct the policies in .mbt/policies"},
+	msgCancelled:             {"MBT3008", "Run the build again with --resume to skip the modules already built"},
+	msgMissingRequiredEnv:    {"MBT3009", "Set the variables in the environment, env of the module or .mbt/config.yml, or with --env"},
+
+	msgTemplateNotFound:          {"MBT4001", "Specify the path of a template committed in the repository, relative to the repository root"},
+	msgFailedTemplateParse:       {"MBT4002", "Correct the syntax of the template"},
+	msgUnsupportedTemplateEngine: {"MBT4003", ""},
+	msgTemplateEngineNotFound:    {"MBT4004", "Install the template engine or use the default engine"},
+	msgPropertyNotFound:          {"MBT4005", "Add the property to the module or use a default value"},
+	msgModuleNotFound:            {"MBT4006", "Check the name of the module with mbt describe"},
+	msgFailedValidation:          {"MBT4007", "Correct the template or the validator"},
+	msgInvalidQuery:              {"MBT4008", "See mbt describe --help for the syntax of queries"},
+	msgFailedQuery:               {"MBT4009", "See mbt describe --help for the syntax of queries"},
+	msgNoModuleInPath:            {"MBT4010", "Change to the directory of a module or one of its subdirectories"},
+
+	msgPluginNotFound:         {"MBT5001", ""},
+	msgPluginNotLocked:        {"MBT5002", ""},
+	msgPluginChecksumMismatch: {"MBT5003", ""},
+	msgFailedPlugin:           {"MBT5004", "See the output of the plugin for the cause of the failure"},
+	msgInvalidPluginLock:      {"MBT5005", "Run mbt plugin lock to update the lock file"},
+}
+
+// DiagnosticCatalog contains the translations of diagnostics keyed by
+// their codes.
+type DiagnosticCatalog map[string]*DiagnosticTranslation
+
+// DiagnosticTranslation is the translation of a diagnostic.
+// Message is a format string receiving the same arguments (in the same
+// order) as the original message.
+type DiagnosticTranslation struct {
+	Message    string `yaml:"message"`
+	Suggestion string `yaml:"suggestion"`
+}
+
+// DiagnosticLanguage returns the language diagnostics are reported in.
+// It is read from MBT_LANG or the locale of the process (i.e. LC_ALL,
+// LC_MESSAGES or LANG). An empty string indicates that the messages
+// are not translated.
+func DiagnosticLanguage() string {
+	for _, v := range []string{"MBT_LANG", "LC_ALL", "LC_MESSAGES", "LANG"} {
+		lang := os.Getenv(v)
+		if lang == "" {
+			continue
+		}
+
+		// Encoding and modifier (e.g. en_AU.UTF-8@euro) do not affect
+		// the catalog.
+		lang = strings.SplitN(strings.SplitN(lang, ".", 2)[0], "@", 2)[0]
+		if lang == "C" || lang == "POSIX" {
+			return ""
+		}
+		return lang
+	}
+	return ""
+}
+
+// DiagnosticCatalogPath returns the path of the catalog for a language
+// in the user configuration directory (e.g. ~/.config/mbt/locales/de.yml).
+func DiagnosticCatalogPath(lang string) string {
+	config := UserConfigPath()
+	if config == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(config), "locales", lang+".yml")
+}
+
+// LoadDiagnosticCatalog reads the catalog for a language (e.g. de_DE).
+// The catalog of the language without the territory (e.g. de) is used
+// when there is no catalog for the territory. A nil catalog is returned
+// when there is no catalog for the language.
+func LoadDiagnosticCatalog(lang string) (DiagnosticCatalog, error) {
+	if lang == "" {
+		return nil, nil
+	}
+
+	candidates := []string{lang}
+	if i := strings.IndexAny(lang, "_-"); i > 0 {
+		candidates = append(candidates, lang[:i])
+	}
+
+	for _, c := range candidates {
+		p := DiagnosticCatalogPath(c)
+		if p == "" {
+			return nil, nil
+		}
+
+		buff, err := ioutil.ReadFile(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgFailedReadDiagnosticCatalog, p)
+		}
+
+		catalog := make(DiagnosticCatalog)
+		if err := yaml.Unmarshal(buff, &catalog); err != nil {
+			return nil, yamlError(e.Wrapf(ErrClassUser, err, msgFailedReadDiagnosticCatalog, p), p, err)
+		}
+		return catalog, nil
+	}
+
+	return nil, nil
+}
+
+// Diagnose returns the diagnostic of an error returned by mbt.
+// Message and suggestion are translated with the catalog if it contains
+// the code of the error. catalog can be nil.
+func Diagnose(err error, catalog DiagnosticCatalog) *Diagnostic {
+	if err == nil {
+		return nil
+	}
+
+	d := &Diagnostic{Severity: SeverityError, Message: err.Error()}
+
+	// Code is the code of the outermost error with a known message,
+	// since it describes the failure in the most general terms.
+	var coded *e.E
+	for x := err; x != nil; {
+		ee, ok := x.(*e.E)
+		if !ok {
+			break
+		}
+		if info, ok := diagnosticCodes[ee.Format()]; ok {
+			d.Code, d.Suggestion = info.code, info.suggestion
+			coded = ee
+			break
+		}
+		x = ee.InnerError()
+	}
+
+	if d.Code == "" {
+		d.Code = DiagnosticCodeUnknown
+		if ee, ok := err.(*e.E); ok && ee.Class() == ErrClassInternal {
+			d.Code = DiagnosticCodeInternal
+		} else if ExitCode(err) == ExitCodeBuildFailure {
+			d.Code = DiagnosticCodeCommandFailed
+		}
+	}
+
+	if t, ok := catalog[d.Code]; ok && t != nil {
+		if coded == err && t.Message != "" {
+			d.Message = fmt.Sprintf(t.Message, coded.Args()...)
+		}
+		if t.Suggestion != "" {
+			d.Suggestion = t.Suggestion
+		}
+	}
+
+	if ee, ok := err.(*e.E); ok {
+		if l := ee.Location(); l != nil {
+			d.File, d.Line, d.Column = l.File, l.Line, l.Column
+		}
+
+		for x := ee.InnerError(); x != nil; {
+			m := x.Error()
+			if m != "" && !strings.Contains(d.Message, m) && (len(d.Causes) == 0 || !strings.Contains(d.Causes[len(d.Causes)-1], m)) {
+				d.Causes = append(d.Causes, m)
+			}
+
+			inner, ok := x.(*e.E)
+			if !ok {
+				break
+			}
+			x = inner.InnerError()
+		}
+	}
+
+	return d
+}
+
+// Write writes the diagnostic in the specified format. Text is colored
+// with ANSI escape sequences if color is true.
+func (d *Diagnostic) Write(w io.Writer, format string, color bool) error {
+	switch format {
+	case DiagnosticsFormatText:
+		paint := func(code, s string) string {
+			if !color {
+				return s
+			}
+			return fmt.Sprintf("\x1b[%sm%s\x1b[0m", code, s)
+		}
+
+		title, titleColor := "Error", "1;31"
+		if d.Severity == SeverityWarning {
+			title, titleColor = "Warning", "1;33"
+		}
+
+		fmt.Fprintf(w, "%s: %s\n", paint(titleColor, fmt.Sprintf("%s [%s]", title, d.Code)), d.Message)
+		if d.File != "" {
+			fmt.Fprintf(w, "  %s %s\n", paint("34", "-->"), d.location())
+		}
+		for _, c := range d.Causes {
+			fmt.Fprintf(w, "  %s %s\n", paint("2", "caused by:"), c)
+		}
+		if d.Suggestion != "" {
+			fmt.Fprintf(w, "  %s %s\n", paint("36", "help:"), d.Suggestion)
+		}
+		return nil
+	case DiagnosticsFormatJSON:
+		buff, err := json.Marshal(d)
+		if err != nil {
+			return e.Wrap(ErrClassInternal, err)
+		}
+		_, err = fmt.Fprintln(w, string(buff))
+		return err
+	default:
+		return e.NewErrorf(ErrClassUser, msgUnsupportedDiagnosticsFormat, format)
+	}
+}
+
+// location returns the location of the diagnostic in file:line:column
+// format.
+func (d *Diagnostic) location() string {
+	l := d.File
+	if d.Line > 0 {
+		l = fmt.Sprintf("%s:%v", l, d.Line)
+		if d.Column > 0 {
+			l = fmt.Sprintf("%s:%v", l, d.Column)
+		}
+	}
+	return l
+}
+
+// ValidateDiagnosticsFormat returns an error if format is not a format
+// of diagnostics.
+func ValidateDiagnosticsFormat(format string) error {
+	if format != DiagnosticsFormatText && format != DiagnosticsFormatJSON {
+		return e.NewErrorf(ErrClassUser, msgUnsupportedDiagnosticsFormat, format)
+	}
+	return nil
+}
+
+var yamlErrorLine = regexp.MustCompile(`line (\d+)`)
+
+// yamlError attributes an error to the line of a yaml file reported in
+// cause (i.e. the error returned by the yaml parser).
+func yamlError(err *e.E, file string, cause error) *e.E {
+	line := 0
+	if m := yamlErrorLine.FindStringSubmatch(cause.Error()); m != nil {
+		line, _ = strconv.Atoi(m[1])
+	}
+	return err.WithLocation(file, line, 0)
+}
+
+// specError attributes an error to the line containing text in the spec
+// of the module in dir. Only the spec file is known if text is not
+// found in the contents of the spec.
+func specError(err *e.E, dir string, contents []byte, text string) *e.E {
+	line := 0
+	for i, l := range strings.Split(string(contents), "\n") {
+		if text != "" && strings.Contains(l, text) {
+			line = i + 1
+			break
+		}
+	}
+	return err.WithLocation(path.Join(dir, configFileName), line, 0)
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiagnosticOfMalformedSpec(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent("app-a/.mbt.yml", "name: app-a\nblah::\n  - a\n b"))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	d := Diagnose(err, nil)
+
+	assert.Equal(t, "MBT1001", d.Code)
+	assert.Equal(t, SeverityError, d.Severity)
+	assert.Equal(t, "error while parsing the spec at app-a/.mbt.yml", d.Message)
+	assert.Equal(t, "app-a/.mbt.yml", d.File)
+	assert.Equal(t, 3, d.Line)
+	assert.Equal(t, []string{"yaml: line 3: did not find expected key"}, d.Causes)
+	assert.NotEmpty(t, d.Suggestion)
+}
+
+func TestDiagnosticOfMalformedSpecInWorkspace(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteContent("app-a/.mbt.yml", "name: app-a\nblah:blah\nblah::"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.ManifestByWorkspace()
+	d := Diagnose(err, nil)
+
+	assert.Equal(t, "MBT1001", d.Code)
+	assert.Equal(t, "app-a/.mbt.yml", d.File)
+	assert.Equal(t, 2, d.Line)
+}
+
+func TestDiagnosticOfMissingFileDependency(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteContent("app-a/.mbt.yml", "name: app-a\nfileDependencies:\n  - shared/foo.txt\n"))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	d := Diagnose(err, nil)
+
+	assert.Equal(t, "MBT1003", d.Code)
+	assert.Equal(t, "app-a/.mbt.yml", d.File)
+	assert.Equal(t, 3, d.Line)
+	assert.Equal(t, diagnosticCodes[msgFileDependencyNotFound].suggestion, d.Suggestion)
+}
+
+func TestDiagnosticOfMissingDependency(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteContent("app-a/.mbt.yml", "name: app-a\ndependencies:\n  - app-c\n  - app-b\n"))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	d := Diagnose(err, nil)
+
+	assert.Equal(t, "MBT1004", d.Code)
+	assert.Equal(t, "dependency not found app-a -> app-c", d.Message)
+	assert.Equal(t, "app-a/.mbt.yml", d.File)
+	assert.Equal(t, 3, d.Line)
+}
+
+func TestDiagnosticOfCyclicDependency(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Dependencies: []string{"app-b"}}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Dependencies: []string{"app-a"}}))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	d := Diagnose(err, nil)
+
+	assert.Equal(t, "MBT1005", d.Code)
+	assert.Equal(t, "app-a/.mbt.yml", d.File)
+	assert.NotZero(t, d.Line)
+}
+
+func TestDiagnosticOfMalformedConfig(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteContent(".mbt/config.yml", "env:\n  - a\nfoo: ["))
+
+	_, err := loadRepoConfig(".tmp/repo")
+	d := Diagnose(err, nil)
+
+	assert.Equal(t, "MBT1002", d.Code)
+	assert.Equal(t, filepath.Join(".tmp/repo", ".mbt", "config.yml"), d.File)
+	assert.NotZero(t, d.Line)
+}
+
+func TestDiagnosticOfBuildFailure(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"default": {Cmd: "echo", Shell: "fish"}},
+	}))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+	d := Diagnose(err, nil)
+
+	assert.Equal(t, "MBT3001", d.Code)
+	assert.Equal(t, "Failed to build module 'app-a'", d.Message)
+	assert.Equal(t, []string{"Unsupported shell 'fish'"}, d.Causes)
+}
+
+func TestDiagnosticOfErrorsWithoutCode(t *testing.T) {
+	assert.Nil(t, Diagnose(nil, nil))
+
+	d := Diagnose(errors.New("a"), nil)
+	assert.Equal(t, DiagnosticCodeUnknown, d.Code)
+	assert.Equal(t, "a", d.Message)
+	assert.Empty(t, d.Causes)
+
+	d = Diagnose(e.Wrapf(ErrClassInternal, errors.New("b"), "a"), nil)
+	assert.Equal(t, DiagnosticCodeInternal, d.Code)
+	assert.Equal(t, []string{"b"}, d.Causes)
+
+	d = Diagnose(e.Wrapf(ErrClassUser, &exec.ExitError{}, "a"), nil)
+	assert.Equal(t, DiagnosticCodeCommandFailed, d.Code)
+}
+
+func TestDiagnosticOfWrappedError(t *testing.T) {
+	inner := e.NewErrorf(ErrClassUser, msgModuleNotFound, "app-a")
+	d := Diagnose(e.Wrapf(ErrClassUser, inner, "Failed to do something"), nil)
+
+	assert.Equal(t, "MBT4006", d.Code)
+	assert.Equal(t, "Failed to do something", d.Message)
+	assert.Equal(t, []string{"Module app-a is not found"}, d.Causes)
+}
+
+func TestDiagnosticCodesAreUnique(t *testing.T) {
+	codes := make(map[string]string)
+	for format, info := range diagnosticCodes {
+		if other, ok := codes[info.code]; ok {
+			t.Errorf("%v is the code of '%v' and '%v'", info.code, format, other)
+		}
+		codes[info.code] = format
+	}
+}
+
+func TestTranslatedDiagnostic(t *testing.T) {
+	clean()
+	old := os.Getenv("XDG_CONFIG_HOME")
+	defer os.Setenv("XDG_CONFIG_HOME", old)
+	check(t, os.Setenv("XDG_CONFIG_HOME", filepath.Join(".tmp", "config")))
+
+	check(t, os.MkdirAll(filepath.Join(".tmp", "config", "mbt", "locales"), 0755))
+	check(t, ioutil.WriteFile(filepath.Join(".tmp", "config", "mbt", "locales", "de.yml"), []byte(`
+MBT4006:
+  message: Modul %v wurde nicht gefunden
+  suggestion: Namen der Module mit mbt describe prüfen
+`), 0644))
+
+	catalog, err := LoadDiagnosticCatalog("de_DE")
+	check(t, err)
+
+	d := Diagnose(e.NewErrorf(ErrClassUser, msgModuleNotFound, "app-a"), catalog)
+	assert.Equal(t, "MBT4006", d.Code)
+	assert.Equal(t, "Modul app-a wurde nicht gefunden", d.Message)
+	assert.Equal(t, "Namen der Module mit mbt describe prüfen", d.Suggestion)
+
+	// Messages of other errors are not translated.
+	d = Diagnose(e.NewErrorf(ErrClassUser, msgInvalidSha, "a"), catalog)
+	assert.Equal(t, "Invalid commit sha 'a'", d.Message)
+
+	catalog, err = LoadDiagnosticCatalog("fr")
+	check(t, err)
+	assert.Nil(t, catalog)
+}
+
+func TestDiagnosticLanguage(t *testing.T) {
+	for _, v := range []string{"MBT_LANG", "LC_ALL", "LC_MESSAGES", "LANG"} {
+		old, ok := os.LookupEnv(v)
+		if ok {
+			defer os.Setenv(v, old)
+		} else {
+			defer os.Unsetenv(v)
+		}
+		os.Unsetenv(v)
+	}
+
+	assert.Equal(t, "", DiagnosticLanguage())
+
+	check(t, os.Setenv("LANG", "de_DE.UTF-8"))
+	assert.Equal(t, "de_DE", DiagnosticLanguage())
+
+	check(t, os.Setenv("LC_ALL", "C"))
+	assert.Equal(t, "", DiagnosticLanguage())
+
+	check(t, os.Setenv("MBT_LANG", "fr@euro"))
+	assert.Equal(t, "fr", DiagnosticLanguage())
+}
+
+func TestWriteDiagnostic(t *testing.T) {
+	d := &Diagnostic{
+		Code:       "MBT1003",
+		Severity:   SeverityError,
+		Message:    "a",
+		Causes:     []string{"b"},
+		File:       "app-a/.mbt.yml",
+		Line:       3,
+		Suggestion: "c",
+	}
+
+	buff := new(bytes.Buffer)
+	check(t, d.Write(buff, DiagnosticsFormatText, false))
+	assert.Equal(t, `Error [MBT1003]: a
+  --> app-a/.mbt.yml:3
+  caused by: b
+  help: c
+`, buff.String())
+
+	buff.Reset()
+	check(t, d.Write(buff, DiagnosticsFormatText, true))
+	assert.Contains(t, buff.String(), "\x1b[1;31mError [MBT1003]\x1b[0m: a\n")
+
+	buff.Reset()
+	check(t, d.Write(buff, DiagnosticsFormatJSON, false))
+	decoded := &Diagnostic{}
+	check(t, json.Unmarshal(buff.Bytes(), decoded))
+	assert.Equal(t, d, decoded)
+
+	err := d.Write(buff, "xml", false)
+	assert.EqualError(t, err, "Unsupported diagnostics format 'xml', supported formats are text and json")
+}
+
+func TestInvocationSummaryContainsDiagnostic(t *testing.T) {
+	r := &RunResult{
+		Manifest: &Manifest{Modules: Modules{}},
+		Failures: []*CmdFailure{{Err: e.NewErrorf(ErrClassUser, msgFailedBuild, "app-a")}},
+	}
+
+	summary := r.InvocationSummary("lint")
+	assert.Equal(t, "MBT3001", summary.Diagnostic.Code)
+}
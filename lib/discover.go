@@ -20,7 +20,9 @@ import (
 	"encoding/hex"
 	"io"
 	"io/ioutil"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	yaml "github.com/go-yaml/yaml"
@@ -35,6 +37,18 @@ type moduleMetadata struct {
 	hash                string
 	spec                *Spec
 	dependentFileHashes map[string]string
+	specContent         []byte
+	// content loads the files of the module (see Module.Files).
+	content *moduleContent
+	// pinnedVersion is the version of the module fixed in the pin
+	// lock file. It is empty when the module is not pinned.
+	pinnedVersion string
+	// ignore are the rules excluding paths from the module (see
+	// .mbtignore).
+	ignore ignoreRules
+	// caseSensitive specifies whether the changes are matched with the
+	// module path and file dependencies in a case sensitive manner.
+	caseSensitive bool
 }
 
 // moduleMetadataSet is an array of ModuleMetadata extracted from the repository.
@@ -43,6 +57,11 @@ type moduleMetadataSet []*moduleMetadata
 type stdDiscover struct {
 	Repo Repo
 	Log  Log
+	// progress receives the progress of hashing files if it is not nil.
+	progress HashProgress
+	// noCache discovers the modules in commits without the discovery
+	// cache.
+	noCache bool
 }
 
 const configFileName = ".mbt.yml"
@@ -53,75 +72,374 @@ func NewDiscover(repo Repo, l Log) Discover {
 }
 
 func (d *stdDiscover) ModulesInCommit(commit Commit) (Modules, error) {
-	repo := d.Repo
-	metadataSet := moduleMetadataSet{}
+	configPath := path.Join(configDir, configFile)
+	configID, err := d.Repo.EntryID(commit, configPath)
+	if err != nil {
+		// Repository configuration is optional.
+		configID = ""
+	}
 
-	err := repo.WalkBlobs(commit, func(b Blob) error {
-		if b.Name() == configFileName {
-			var (
-				hash string
-				err  error
-			)
-			p := strings.TrimRight(b.Path(), "/")
-			if p != "" {
-				// We are not on the root, take the git sha for parent tree object.
-				hash, err = repo.EntryID(commit, p)
-				if err != nil {
-					return err
-				}
-			} else {
-				// We are on the root, take the commit sha.
-				hash = commit.ID()
-			}
+	config := &RepoConfig{}
+	if configID != "" {
+		buff, err := d.Repo.BlobContentsFromTree(commit, configPath)
+		if err != nil {
+			return nil, err
+		}
 
-			contents, err := repo.BlobContents(b)
-			if err != nil {
-				return err
-			}
+		config = d.discoveryConfig(parseRepoConfig(buff, configPath))
+	}
 
-			spec, err := newSpec(contents)
-			if err != nil {
-				return e.Wrapf(ErrClassUser, err, "error while parsing the spec at %v", b)
-			}
+	plugins, err := d.pluginsInCommit(commit, config)
+	if err != nil {
+		return nil, err
+	}
 
-			// Discover the hashes for file dependencies of this module
-			dependentFileHashes := make(map[string]string)
-			for _, f := range spec.FileDependencies {
-				fh, err := repo.EntryID(commit, f)
-				if err != nil {
-					return e.Wrapf(ErrClassUser, err, msgFileDependencyNotFound, f, spec.Name, p)
-				}
+	metadataSet, err := d.cachedMetadataInCommit(commit, configID, plugins, config.Cache)
+	if err != nil {
+		return nil, err
+	}
 
-				dependentFileHashes[f] = fh
-			}
+	lfsObjects := config.LFS != nil && config.LFS.Objects
+	if lfsObjects {
+		err = withLFSObjectHashes(d.Repo, commit, metadataSet, d.progress)
+		if err != nil {
+			return nil, err
+		}
+	}
+	metadataSet = metadataSet.withCommitContent(d.Repo, commit)
 
-			metadataSet = append(metadataSet, newModuleMetadata(p, hash, spec, dependentFileHashes))
+	if config.Submodules != nil && config.Submodules.Recurse {
+		s, err := submoduleMetadataInCommit(d.Repo, commit, lfsObjects, d.progress)
+		if err != nil {
+			return nil, err
+		}
+		metadataSet = append(metadataSet, s...)
+	}
+
+	if len(plugins) > 0 {
+		p, err := discoverWithPlugins(d.Repo, commit, plugins, metadataSet)
+		if err != nil {
+			return nil, err
 		}
+		metadataSet = append(metadataSet, p.withCommitContent(d.Repo, commit)...)
+	}
+
+	pins, err := pinsInCommit(d.Repo, commit)
+	if err != nil {
+		return nil, err
+	}
+
+	return toModules(metadataSet.withPathConfig(config.Paths).withPins(pins))
+}
+
+// pluginsInCommit loads the plugins in the configuration of a commit
+// verified against the lock file in the same commit.
+func (d *stdDiscover) pluginsInCommit(commit Commit, config *RepoConfig) ([]*plugin, error) {
+	if len(config.Plugins) == 0 {
+		return nil, nil
+	}
+
+	dir, err := filepath.Abs(d.Repo.Path())
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	// Lock file is optional here so that the error reported is about
+	// the plugin not locked.
+	lock, err := d.Repo.BlobContentsFromTree(commit, path.Join(configDir, pluginLockFile))
+	if err != nil {
+		lock = nil
+	}
+
+	return loadPlugins(dir, config, lock)
+}
+
+// discoveryConfig returns the repository configuration applicable to
+// the module discovery.
+// Invalid configuration does not prevent the discovery of modules,
+// it is reported by the commands using the configuration.
+func (d *stdDiscover) discoveryConfig(config *RepoConfig, err error) *RepoConfig {
+	if err != nil {
+		d.Log.Warnf(msgDiscoveryConfigIgnored, err)
+		return &RepoConfig{}
+	}
+
+	return config
+}
+
+// metadataInCommit discovers the modules in a commit tree.
+func metadataInCommit(repo Repo, commit Commit) (moduleMetadataSet, error) {
+	set, _, err := allMetadataInCommit(repo, commit)
+	if err != nil {
+		return nil, err
+	}
+
+	return set.included(), nil
+}
+
+// allMetadataInCommit discovers the modules in a commit tree including
+// the ones excluded by the modules containing them. Contents of the
+// .mbtignore files are returned keyed by their directories.
+func allMetadataInCommit(repo Repo, commit Commit) (moduleMetadataSet, map[string][]byte, error) {
+	set, ignoreFiles, err := specsInCommit(repo, commit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = set.withIgnoreRules(ignoreFiles).withExcludedHashes(repo, commit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return set, ignoreFiles, nil
+}
+
+// specsInCommit creates the metadata of the module specs in a commit
+// tree and reads the .mbtignore files in it.
+func specsInCommit(repo Repo, commit Commit) (moduleMetadataSet, map[string][]byte, error) {
+	specs, ignoreFiles, err := specBlobsInCommit(repo, commit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Specs are processed concurrently since resolving the hashes of
+	// modules and their file dependencies dominates the discovery in
+	// large trees. Metadata is assembled in the order of the walk.
+	metadataSet := make(moduleMetadataSet, len(specs))
+	err = forEach(len(specs), func(i int) error {
+		b := specs[i]
+		contents, err := repo.BlobContents(b)
+		if err != nil {
+			return err
+		}
+
+		m, err := newCommitModuleMetadata(repo, commit, strings.TrimRight(b.Path(), "/"), contents)
+		if err != nil {
+			return err
+		}
+
+		metadataSet[i] = m
 		return nil
 	})
 
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return metadataSet, ignoreFiles, nil
+}
+
+// specBlobsInCommit returns the module specs in a commit tree in the
+// order of the walk and the contents of the .mbtignore files keyed by
+// their directories.
+func specBlobsInCommit(repo Repo, commit Commit) ([]Blob, map[string][]byte, error) {
+	specs := make([]Blob, 0)
+	ignores := make([]Blob, 0)
+	err := repo.WalkBlobs(commit, func(b Blob) error {
+		switch b.Name() {
+		case configFileName:
+			specs = append(specs, b)
+		case ignoreFileName:
+			ignores = append(ignores, b)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ignoreFiles := make(map[string][]byte)
+	for _, b := range ignores {
+		contents, err := repo.BlobContents(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		ignoreFiles[strings.TrimRight(b.Path(), "/")] = contents
+	}
+
+	return specs, ignoreFiles, nil
+}
+
+// newCommitModuleMetadata creates the metadata of the module in dir
+// of a commit tree from the contents of its spec.
+func newCommitModuleMetadata(repo Repo, commit Commit, dir string, contents []byte) (*moduleMetadata, error) {
+	var (
+		hash string
+		err  error
+	)
+	if dir != "" {
+		// We are not on the root, take the git sha for parent tree object.
+		hash, err = repo.EntryID(commit, dir)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// We are on the root, take the commit sha.
+		hash = commit.ID()
+	}
+
+	spec, err := newSpec(contents)
+	if err != nil {
+		return nil, configError(yamlError(e.Wrapf(ErrClassUser, err, msgFailedSpecParseAt, path.Join(dir, configFileName)), path.Join(dir, configFileName), err))
+	}
+
+	// Discover the hashes for file dependencies of this module
+	dependentFileHashes := make(map[string]string)
+	for _, f := range spec.FileDependencies {
+		fh, err := repo.EntryID(commit, f)
+		if err != nil {
+			return nil, configError(specError(e.Wrapf(ErrClassUser, err, msgFileDependencyNotFound, f, spec.Name, dir), dir, contents, f))
+		}
+
+		dependentFileHashes[f] = fh
+	}
+
+	m := newModuleMetadata(dir, hash, spec, dependentFileHashes)
+	m.specContent = contents
+	return m, nil
+}
+
+// submoduleMetadataInCommit discovers the modules in the submodules of
+// a commit tree (including the nested submodules) at the commits they
+// point to.
+// Version of a module in a submodule is derived from the submodule
+// tree, therefore it changes when the change of the submodule commit
+// modifies the content of the module.
+func submoduleMetadataInCommit(repo Repo, commit Commit, lfsObjects bool, progress HashProgress) (moduleMetadataSet, error) {
+	submodules, err := repo.Submodules(commit)
 	if err != nil {
 		return nil, err
 	}
 
-	return toModules(metadataSet)
+	metadataSet := moduleMetadataSet{}
+	for _, s := range submodules {
+		subRepo, err := repo.OpenSubmodule(s.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		subCommit, err := subRepo.GetCommit(s.Commit)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgSubmoduleCommitNotFound, s.Commit, s.Path)
+		}
+
+		// Configuration of the containing repository applies to the
+		// submodules.
+		set, err := metadataInCommit(subRepo, subCommit)
+		if err != nil {
+			return nil, err
+		}
+
+		if lfsObjects {
+			err = withLFSObjectHashes(subRepo, subCommit, set, progress)
+			if err != nil {
+				return nil, err
+			}
+		}
+		set = set.withCommitContent(subRepo, subCommit)
+
+		nested, err := submoduleMetadataInCommit(subRepo, subCommit, lfsObjects, progress)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range append(set, nested...) {
+			metadataSet = append(metadataSet, m.inSubmodule(s.Path))
+		}
+	}
+
+	return metadataSet, nil
 }
 
 func (d *stdDiscover) ModulesInWorkspace() (Modules, error) {
+	metadataSet, err := metadataInWorkspace(d.Repo)
+	if err != nil {
+		return nil, err
+	}
+	metadataSet = metadataSet.withWorkspaceContent(d.Repo)
+
+	sparse, err := d.Repo.SparseCheckout()
+	if err != nil {
+		return nil, err
+	}
+
+	if sparse != nil {
+		s, err := unmaterializedMetadata(d.Repo, sparse)
+		if err != nil {
+			return nil, err
+		}
+		metadataSet = append(metadataSet, s...)
+	}
+
+	config := d.discoveryConfig(loadRepoConfig(d.Repo.Path()))
+	if config.Submodules != nil && config.Submodules.Recurse {
+		s, err := submoduleMetadataInWorkspace(d.Repo)
+		if err != nil {
+			return nil, err
+		}
+		metadataSet = append(metadataSet, s...)
+	}
+
+	if len(config.Plugins) > 0 {
+		dir, err := filepath.Abs(d.Repo.Path())
+		if err != nil {
+			return nil, e.Wrap(ErrClassInternal, err)
+		}
+
+		plugins, err := loadWorkspacePlugins(dir, config)
+		if err != nil {
+			return nil, err
+		}
+
+		p, err := discoverWithPlugins(d.Repo, nil, plugins, metadataSet)
+		if err != nil {
+			return nil, err
+		}
+		metadataSet = append(metadataSet, p.withWorkspaceContent(d.Repo)...)
+	}
+
+	dir, err := filepath.Abs(d.Repo.Path())
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	pins, err := pinsInWorkspace(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return toModules(metadataSet.withPathConfig(config.Paths).withPins(pins))
+}
+
+// withPathConfig applies the path configuration of the repository to
+// the metadata in the set.
+func (a moduleMetadataSet) withPathConfig(config *PathConfig) moduleMetadataSet {
+	if config != nil {
+		for _, m := range a {
+			m.caseSensitive = config.CaseSensitive
+		}
+	}
+	return a
+}
+
+func metadataInWorkspace(repo Repo) (moduleMetadataSet, error) {
 	metadataSet := moduleMetadataSet{}
-	absRepoPath, err := filepath.Abs(d.Repo.Path())
+	absRepoPath, err := filepath.Abs(repo.Path())
 	if err != nil {
 		return nil, e.Wrap(ErrClassInternal, err)
 	}
 
-	configFiles, err := d.Repo.FindAllFilesInWorkspace([]string{configFileName, "/**/" + configFileName})
+	configFiles, err := repo.FindAllFilesInWorkspace([]string{configFileName, "/**/" + configFileName, ignoreFileName, "/**/" + ignoreFileName})
 
 	if err != nil {
 		return nil, err
 	}
 
+	ignoreFiles := make(map[string][]byte)
 	for _, entry := range configFiles {
-		if filepath.Base(entry) != configFileName {
+		name := filepath.Base(entry)
+		if name != configFileName && name != ignoreFileName {
 			// Fast path directories that matched path spec
 			// e.g. .mbt.yml/abc/foo
 			continue
@@ -134,11 +452,6 @@ func (d *stdDiscover) ModulesInWorkspace() (Modules, error) {
 			return nil, e.Wrapf(ErrClassInternal, err, "error whilst reading file contents at path %s", path)
 		}
 
-		spec, err := newSpec(contents)
-		if err != nil {
-			return nil, e.Wrapf(ErrClassUser, err, "error whilst parsing spec at %s", path)
-		}
-
 		// Sanitize the module path
 		dir := filepath.ToSlash(filepath.Dir(entry))
 		if dir == "." {
@@ -147,15 +460,89 @@ func (d *stdDiscover) ModulesInWorkspace() (Modules, error) {
 			dir = strings.TrimRight(dir, "/")
 		}
 
+		if name == ignoreFileName {
+			ignoreFiles[dir] = contents
+			continue
+		}
+
+		spec, err := newSpec(contents)
+		if err != nil {
+			return nil, configError(yamlError(e.Wrapf(ErrClassUser, err, msgFailedSpecParseAt, path), filepath.ToSlash(entry), err))
+		}
+
 		hash := "local"
-		metadataSet = append(metadataSet, newModuleMetadata(dir, hash, spec, nil))
+		m := newModuleMetadata(dir, hash, spec, nil)
+		m.specContent = contents
+		metadataSet = append(metadataSet, m)
+	}
+
+	return metadataSet.withIgnoreRules(ignoreFiles).included(), nil
+}
+
+// unmaterializedMetadata discovers the modules excluded from a sparse
+// checkout. Their specs are read from the head commit since they
+// cannot be changed in the workspace.
+func unmaterializedMetadata(repo Repo, sparse *SparseCheckout) (moduleMetadataSet, error) {
+	empty, err := repo.IsEmpty()
+	if err != nil || empty {
+		return nil, err
 	}
 
+	head, err := repo.ResolveCommit("HEAD")
 	if err != nil {
-		return nil, e.Wrap(ErrClassInternal, err)
+		return nil, err
+	}
+
+	set, err := metadataInCommit(repo, head)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataSet := moduleMetadataSet{}
+	for _, m := range set {
+		if !sparse.Includes(path.Join(m.dir, configFileName)) {
+			u := newModuleMetadata(m.dir, "local", m.spec, nil)
+			u.ignore = m.ignore
+			u.content = commitContent(repo, head, u)
+			metadataSet = append(metadataSet, u)
+		}
 	}
 
-	return toModules(metadataSet)
+	return metadataSet, nil
+}
+
+// submoduleMetadataInWorkspace discovers the modules in the submodules
+// checked out in the workspace (including the nested submodules).
+func submoduleMetadataInWorkspace(repo Repo) (moduleMetadataSet, error) {
+	submodules, err := repo.Submodules(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataSet := moduleMetadataSet{}
+	for _, s := range submodules {
+		subRepo, err := repo.OpenSubmodule(s.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		set, err := metadataInWorkspace(subRepo)
+		if err != nil {
+			return nil, err
+		}
+		set = set.withWorkspaceContent(subRepo)
+
+		nested, err := submoduleMetadataInWorkspace(subRepo)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range append(set, nested...) {
+			metadataSet = append(metadataSet, m.inSubmodule(s.Path))
+		}
+	}
+
+	return metadataSet, nil
 }
 
 func newModuleMetadata(dir string, hash string, spec *Spec, dependentFileHashes map[string]string) *moduleMetadata {
@@ -180,6 +567,24 @@ func newModuleMetadata(dir string, hash string, spec *Spec, dependentFileHashes
 	}
 }
 
+// inSubmodule returns the metadata of a module discovered in the
+// submodule at dir relative to the root of the containing repository.
+func (m *moduleMetadata) inSubmodule(dir string) *moduleMetadata {
+	spec := *m.spec
+	spec.FileDependencies = make([]string, 0, len(m.spec.FileDependencies))
+	hashes := make(map[string]string)
+	for _, f := range m.spec.FileDependencies {
+		p := path.Join(dir, f)
+		spec.FileDependencies = append(spec.FileDependencies, p)
+		hashes[p] = m.dependentFileHashes[f]
+	}
+
+	r := newModuleMetadata(path.Join(dir, m.dir), m.hash, &spec, hashes)
+	r.content = inSubmoduleContent(m.content, dir)
+	r.ignore = m.ignore.inSubmodule(dir)
+	return r
+}
+
 func newSpec(content []byte) (*Spec, error) {
 	a := &Spec{
 		Properties: make(map[string]interface{}),
@@ -196,20 +601,59 @@ func newSpec(content []byte) (*Spec, error) {
 		return nil, err
 	}
 
+	for _, env := range a.Environments {
+		if env == nil {
+			continue
+		}
+
+		env.Properties, err = transformProps(env.Properties)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, paths := range [][]string{a.FileDependencies, a.Reports, a.Outputs} {
+		for i, p := range paths {
+			paths[i] = normalizeSpecPath(p)
+		}
+	}
+
 	return a, nil
 }
 
+// normalizeSpecPath returns a path (or a pattern of paths) declared in
+// a spec in the form used in the repository tree (i.e. relative to the
+// repository root, or the module directory, with forward slashes).
+// Specs written on Windows often use backslashes. They are normalised
+// regardless of the current OS so that a spec resolves the same files
+// and produces the same versions on every OS.
+func normalizeSpecPath(p string) string {
+	if p == "" {
+		return p
+	}
+	return strings.TrimPrefix(path.Clean(strings.Replace(p, `\`, "/", -1)), "/")
+}
+
 // toModules transforms an moduleMetadataSet to Modules structure
 // while establishing the dependency links.
 func toModules(a moduleMetadataSet) (Modules, error) {
 	// Step 1
+	// Order moduleMetadata by the module directory so that the
+	// topological order breaks ties the same way regardless of the
+	// order modules were discovered in (e.g. walking the tree of a
+	// commit, the workspace or the discovery cache).
+	a = append(moduleMetadataSet{}, a...)
+	sort.SliceStable(a, func(i, j int) bool {
+		return a[i].dir < a[j].dir
+	})
+
 	// Index moduleMetadata by the module name and use it to
 	// create a ModuleMetadataProvider that we can use with TopSort fn.
 	m := make(map[string]*moduleMetadata)
 	nodes := make([]interface{}, 0, len(a))
 	for _, meta := range a {
 		if conflict, ok := m[meta.spec.Name]; ok {
-			return nil, e.NewErrorf(ErrClassUser, "Module name '%s' in directory '%s' conflicts with the module in '%s' directory", meta.spec.Name, meta.dir, conflict.dir)
+			return nil, configError(specError(e.NewErrorf(ErrClassUser, msgModuleNameConflict, meta.spec.Name, meta.dir, conflict.dir), meta.dir, meta.specContent, "name:"))
 		}
 		m[meta.spec.Name] = meta
 		nodes = append(nodes, meta)
@@ -228,7 +672,12 @@ func toModules(a moduleMetadataSet) (Modules, error) {
 				}
 				pathStr = pathStr + v.(*moduleMetadata).spec.Name
 			}
-			return nil, e.NewErrorf(ErrClassUser, "Could not produce the module graph due to a cyclic dependency in path: %s", pathStr)
+			err := e.NewErrorf(ErrClassUser, msgCyclicDependency, pathStr)
+			if len(cycleErr.Path) > 0 {
+				first := cycleErr.Path[0].(*moduleMetadata)
+				err = specError(err, first.dir, first.specContent, "dependencies:")
+			}
+			return nil, configError(err)
 		}
 		return nil, e.Wrap(ErrClassInternal, err)
 	}
@@ -236,6 +685,12 @@ func toModules(a moduleMetadataSet) (Modules, error) {
 	// Step 3
 	// Now that we have the topologically sorted moduleMetadataNodes
 	// create Module instances with dependency links.
+	return linkModules(sortedNodes), nil
+}
+
+// linkModules creates the modules of the topologically sorted
+// moduleMetadata nodes with the dependency links and versions.
+func linkModules(sortedNodes []interface{}) Modules {
 	mModules := make(map[string]*Module)
 	modules := make(Modules, len(sortedNodes))
 	i := 0
@@ -258,14 +713,16 @@ func toModules(a moduleMetadataSet) (Modules, error) {
 		mModules[mod.Name()] = mod
 	}
 
-	return calculateVersion(modules), nil
+	return calculateVersion(modules)
 }
 
 // calculateVersion takes the topologically sorted Modules and
 // initialises their version field.
 func calculateVersion(topSorted Modules) Modules {
 	for _, a := range topSorted {
-		if a.Hash() == "local" {
+		if a.Pinned() {
+			a.version = a.metadata.pinnedVersion
+		} else if a.Hash() == "local" {
 			a.version = "local"
 		} else {
 			if len(a.Requires()) == 0 && len(a.FileDependencies()) == 0 {
@@ -320,11 +777,11 @@ func (n *moduleMetadataNodeProvider) ChildCount(vertex interface{}) int {
 }
 
 func (n *moduleMetadataNodeProvider) Child(vertex interface{}, index int) (interface{}, error) {
-	spec := vertex.(*moduleMetadata).spec
-	d := spec.Dependencies[index]
+	m := vertex.(*moduleMetadata)
+	d := m.spec.Dependencies[index]
 	if s, ok := n.set[d]; ok {
 		return s, nil
 	}
 
-	return nil, e.NewErrorf(ErrClassUser, "dependency not found %s -> %s", spec.Name, d)
+	return nil, configError(specError(e.NewErrorf(ErrClassUser, msgDependencyNotFound, m.spec.Name, d), m.dir, m.specContent, d))
 }
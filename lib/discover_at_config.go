@@ -0,0 +1,216 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// discoveryAtConfigDirName is the directory of the discovery cache
+// where the modules discovered with the specs of another commit are
+// stored, separately for each commit of the specs.
+const discoveryAtConfigDirName = "at"
+
+// ModulesInCommitAtConfig discovers the modules in the tree of commit
+// interpreted with the module specs, .mbtignore files, pins and the
+// repository configuration of configCommit.
+// Modules in configCommit whose directory or file dependencies do not
+// exist in commit are not discovered, along with the modules depending
+// on them. Modules discovered by plugins and in submodules are not
+// considered.
+func (d *stdDiscover) ModulesInCommitAtConfig(commit, configCommit Commit) (Modules, error) {
+	configPath := path.Join(configDir, configFile)
+	config := &RepoConfig{}
+	if _, err := d.Repo.EntryID(configCommit, configPath); err == nil {
+		buff, err := d.Repo.BlobContentsFromTree(configCommit, configPath)
+		if err != nil {
+			return nil, err
+		}
+
+		config = d.discoveryConfig(parseRepoConfig(buff, configPath))
+	}
+
+	metadataSet, err := d.cachedMetadataAtConfig(commit, configCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.LFS != nil && config.LFS.Objects {
+		err = withLFSObjectHashes(d.Repo, commit, metadataSet, d.progress)
+		if err != nil {
+			return nil, err
+		}
+	}
+	metadataSet = metadataSet.withCommitContent(d.Repo, commit)
+
+	pins, err := pinsInCommit(d.Repo, configCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	return toModules(metadataSet.withPathConfig(config.Paths).withPins(pins))
+}
+
+// cachedMetadataAtConfig discovers the modules in the tree of commit
+// with the specs of configCommit using the discovery cache.
+// Cache is an optimisation, failing to use it never fails the
+// discovery.
+func (d *stdDiscover) cachedMetadataAtConfig(commit, configCommit Commit) (moduleMetadataSet, error) {
+	dir, err := d.cacheDir()
+	if err != nil {
+		d.Log.Debug("Discovery cache is not available: %v", err)
+		set, _, err := metadataAtConfig(d.Repo, d.Log, commit, configCommit)
+		if err != nil {
+			return nil, err
+		}
+		return set.included(), nil
+	}
+
+	dir = filepath.Join(dir, discoveryAtConfigDirName, configCommit.ID())
+	entry := readDiscoveryCacheEntry(filepath.Join(dir, commit.ID()+".json"))
+	if entry != nil && entry.Config == configCommit.ID() {
+		set, err := entry.metadata()
+		if err == nil {
+			return set.included(), nil
+		}
+		d.Log.Debug("Ignoring discovery cache entry %v at %v: %v", commit.ID(), configCommit.ID(), err)
+	}
+
+	set, ignoreFiles, err := metadataAtConfig(d.Repo, d.Log, commit, configCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeDiscoveryCacheEntry(dir, newDiscoveryCacheEntry(commit, configCommit.ID(), set, ignoreFiles))
+	if err != nil {
+		d.Log.Debug("Failed to write discovery cache entry %v at %v: %v", commit.ID(), configCommit.ID(), err)
+	}
+
+	return set.included(), nil
+}
+
+// metadataAtConfig creates the metadata of the specs in configCommit
+// from the tree of commit, including the modules excluded by the
+// modules containing them. Contents of the .mbtignore files in
+// configCommit are returned keyed by their directories.
+func metadataAtConfig(repo Repo, log Log, commit, configCommit Commit) (moduleMetadataSet, map[string][]byte, error) {
+	specs, ignoreFiles, err := specBlobsInCommit(repo, configCommit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	all := make(moduleMetadataSet, len(specs))
+	names := make([]string, len(specs))
+	missing := make([]string, len(specs))
+	err = forEach(len(specs), func(i int) error {
+		b := specs[i]
+		contents, err := repo.BlobContents(b)
+		if err != nil {
+			return err
+		}
+
+		dir := strings.TrimRight(b.Path(), "/")
+		names[i], missing[i] = missingInCommit(repo, commit, dir, contents)
+		if missing[i] != "" {
+			return nil
+		}
+
+		m, err := newCommitModuleMetadata(repo, commit, dir, contents)
+		if err != nil {
+			return err
+		}
+
+		all[i] = m
+		return nil
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Modules depending on the modules not in commit are not discovered
+	// either, since their dependencies cannot be resolved.
+	absent := make(map[string]bool)
+	for i, m := range all {
+		if m == nil {
+			log.Warnf(msgModuleNotInCommit, names[i], missing[i], commit.ID())
+			absent[names[i]] = true
+		}
+	}
+
+	for {
+		changed := false
+		for _, m := range all {
+			if m == nil || absent[m.spec.Name] {
+				continue
+			}
+
+			for _, dep := range m.spec.Dependencies {
+				if absent[dep] {
+					log.Warnf(msgModuleNotInCommit, m.spec.Name, dep, commit.ID())
+					absent[m.spec.Name] = true
+					changed = true
+					break
+				}
+			}
+		}
+
+		if !changed {
+			break
+		}
+	}
+
+	set := make(moduleMetadataSet, 0, len(all))
+	for _, m := range all {
+		if m != nil && !absent[m.spec.Name] {
+			set = append(set, m)
+		}
+	}
+
+	err = set.withIgnoreRules(ignoreFiles).withExcludedHashes(repo, commit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return set, ignoreFiles, nil
+}
+
+// missingInCommit returns the name of the module in dir and its
+// directory or the first file dependency that does not exist in commit.
+// Returns an empty string as the missing path if all of them exist.
+func missingInCommit(repo Repo, commit Commit, dir string, contents []byte) (string, string) {
+	// Invalid specs are reported when the metadata is created.
+	spec, err := newSpec(contents)
+	if err != nil {
+		return "", ""
+	}
+
+	if dir != "" {
+		if _, err := repo.EntryID(commit, dir); err != nil {
+			return spec.Name, dir
+		}
+	}
+
+	for _, f := range spec.FileDependencies {
+		if _, err := repo.EntryID(commit, f); err != nil {
+			return spec.Name, f
+		}
+	}
+
+	return spec.Name, ""
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// atConfigTestRepo creates a repository where the second commit adds
+// the specs of app-b (existing in the first commit), lib-c (new) and
+// app-e (existing, depending on lib-c).
+func atConfigTestRepo(t *testing.T) (*World, Commit, Commit) {
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent("app-b/foo", "b"))
+	check(t, repo.WriteContent("app-e/foo", "e"))
+	check(t, repo.Commit("first"))
+	first := repo.LastCommit.String()
+
+	check(t, repo.WriteContent("app-a/foo", "a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.InitModule("lib-c"))
+	check(t, repo.InitModuleWithOptions("app-e", &Spec{Name: "app-e", Dependencies: []string{"lib-c"}}))
+	check(t, repo.Commit("second"))
+
+	world := NewWorld(t, ".tmp/repo")
+	c1, err := world.Repo.GetCommit(first)
+	check(t, err)
+	c2, err := world.Repo.GetCommit(repo.LastCommit.String())
+	check(t, err)
+	return world, c1, c2
+}
+
+func TestModulesInCommitAtConfig(t *testing.T) {
+	clean()
+	world, c1, c2 := atConfigTestRepo(t)
+
+	mods, err := world.Discover.ModulesInCommitAtConfig(c1, c2)
+	check(t, err)
+	assert.Equal(t, []string{"app-a", "app-b"}, moduleNames(mods))
+
+	expected, err := world.Discover.ModulesInCommit(c1)
+	check(t, err)
+	assert.Equal(t, expected.indexByName()["app-a"].Version(), mods.indexByName()["app-a"].Version())
+
+	hash, err := world.Repo.EntryID(c1, "app-b")
+	check(t, err)
+	assert.Equal(t, hash, mods.indexByName()["app-b"].Version())
+}
+
+func TestModulesInCommitAtConfigOfSameCommit(t *testing.T) {
+	clean()
+	world, _, c2 := atConfigTestRepo(t)
+
+	expected, err := world.Discover.ModulesInCommit(c2)
+	check(t, err)
+
+	mods, err := world.Discover.ModulesInCommitAtConfig(c2, c2)
+	check(t, err)
+
+	assert.Equal(t, moduleNames(expected), moduleNames(mods))
+	for _, m := range mods {
+		assert.Equal(t, expected.indexByName()[m.Name()].Version(), m.Version())
+	}
+}
+
+func TestModulesInCommitAtConfigCache(t *testing.T) {
+	clean()
+	world, c1, c2 := atConfigTestRepo(t)
+
+	expected, err := world.Discover.ModulesInCommitAtConfig(c1, c2)
+	check(t, err)
+
+	dir, err := filepath.Abs(world.Repo.GitDir())
+	check(t, err)
+	assert.FileExists(t, filepath.Join(dir, stateDirName, discoveryCacheDirName, discoveryAtConfigDirName, c2.ID(), c1.ID()+".json"))
+
+	world.Repo.Interceptor.Config("WalkBlobs").Return(errors.New("doh"))
+	mods, err := world.Discover.ModulesInCommitAtConfig(c1, c2)
+	check(t, err)
+
+	assert.Equal(t, moduleNames(expected), moduleNames(mods))
+	assert.Equal(t, expected.indexByName()["app-b"].Version(), mods.indexByName()["app-b"].Version())
+}
+
+func TestManifestByCommitAtConfig(t *testing.T) {
+	clean()
+	world, c1, c2 := atConfigTestRepo(t)
+
+	m, err := world.System.ManifestByCommitAtConfig(c1.ID(), c2.ID())
+	check(t, err)
+
+	assert.Equal(t, c1.ID(), m.Sha)
+	assert.Equal(t, []string{"app-a", "app-b"}, moduleNames(m.Modules))
+}
+
+func TestManifestByCommitAtUnknownConfig(t *testing.T) {
+	clean()
+	world, c1, _ := atConfigTestRepo(t)
+
+	_, err := world.System.ManifestByCommitAtConfig(c1.ID(), "0000000000000000000000000000000000000000")
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,446 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	discoveryCacheDirName = "discovery"
+	discoveryCacheFormat  = 2
+	discoveryCacheSize    = 100
+)
+
+// discoveryCacheEntry is the persisted form of the modules discovered
+// in a commit.
+// Entries are keyed on the commit id and carry the id of the repository
+// configuration blob they were discovered with. An entry is not used
+// when the configuration changes.
+type discoveryCacheEntry struct {
+	Format  int                     `json:"format"`
+	Commit  string                  `json:"commit"`
+	Config  string                  `json:"config"`
+	Modules []*discoveryCacheModule `json:"modules"`
+	// IgnoreFiles are the contents of the .mbtignore files keyed by
+	// their directories.
+	IgnoreFiles map[string]string `json:"ignoreFiles,omitempty"`
+}
+
+type discoveryCacheModule struct {
+	Dir        string            `json:"dir"`
+	Hash       string            `json:"hash"`
+	Spec       string            `json:"spec"`
+	FileHashes map[string]string `json:"fileHashes,omitempty"`
+}
+
+// cachedMetadataInCommit discovers the modules in a commit tree using
+// the discovery cache.
+// Entries include the modules excluded by .mbtignore files so that
+// they can be restored when the exclusions change.
+// Metadata of an uncached commit is derived from the most recent cache
+// entry by examining the specs modified between the two commits, so
+// only a full walk of the tree is required when the cache is empty.
+// Cache is an optimisation, failing to use it never fails the
+// discovery.
+// Plugins implementing the cache hook are consulted when the local
+// cache does not have the commit, and they receive the entries created.
+// Entries shared through the plugins are signed and verified as
+// specified in cache.
+func (d *stdDiscover) cachedMetadataInCommit(commit Commit, configID string, plugins []*plugin, cache *CacheConfig) (moduleMetadataSet, error) {
+	if d.noCache {
+		return metadataInCommit(d.Repo, commit)
+	}
+
+	dir, err := d.cacheDir()
+	if err != nil {
+		d.Log.Debug("Discovery cache is not available: %v", err)
+		return metadataInCommit(d.Repo, commit)
+	}
+
+	entry := readDiscoveryCacheEntry(filepath.Join(dir, commit.ID()+".json"))
+	if entry != nil && entry.Config == configID {
+		set, err := entry.metadata()
+		if err == nil {
+			return set.included(), nil
+		}
+		d.Log.Debug("Ignoring discovery cache entry %v: %v", commit.ID(), err)
+	}
+
+	cachePlugins := pluginsWithHook(plugins, PluginHookCache)
+	if pluginEntry, set := d.pluginCacheEntry(cachePlugins, commit, configID, cache); set != nil {
+		err = writeDiscoveryCacheEntry(dir, pluginEntry)
+		if err != nil {
+			d.Log.Debug("Failed to write discovery cache entry %v: %v", commit.ID(), err)
+		}
+		return set.included(), nil
+	}
+
+	set, ignoreFiles, err := d.incrementalMetadataInCommit(dir, commit, configID)
+	if err != nil {
+		d.Log.Debug("Discovery cache could not be updated incrementally: %v", err)
+		set, ignoreFiles, err = allMetadataInCommit(d.Repo, commit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entry = newDiscoveryCacheEntry(commit, configID, set, ignoreFiles)
+	err = writeDiscoveryCacheEntry(dir, entry)
+	if err != nil {
+		d.Log.Debug("Failed to write discovery cache entry %v: %v", commit.ID(), err)
+	}
+
+	if len(cachePlugins) > 0 {
+		d.putPluginCacheEntry(cachePlugins, entry, cache)
+	}
+
+	return set.included(), nil
+}
+
+// putPluginCacheEntry stores an entry in the cache plugins. When the
+// entries are verified, only the signed entries are stored since
+// others would not be used.
+func (d *stdDiscover) putPluginCacheEntry(plugins []*plugin, entry *discoveryCacheEntry, cache *CacheConfig) {
+	input := map[string]interface{}{"op": "put", "commit": entry.Commit, "config": entry.Config, "entry": entry}
+	if cache.verifies() {
+		if !cache.signs() {
+			return
+		}
+
+		payload, err := json.Marshal(entry)
+		if err != nil {
+			d.Log.Warnf("%v", err)
+			return
+		}
+
+		sig, err := cache.sign(entry.Commit, payload)
+		if err != nil {
+			d.Log.Warnf("%v", err)
+			return
+		}
+		input["signature"] = sig
+	}
+
+	for _, p := range plugins {
+		err := p.call(PluginHookCache, input, nil)
+		if err != nil {
+			d.Log.Debug("Failed to store discovery cache entry %v in plugin %v: %v", entry.Commit, p.name, err)
+		}
+	}
+}
+
+type pluginCacheResponse struct {
+	Entry     *discoveryCacheEntry `json:"entry"`
+	Signature *cacheSignature      `json:"signature"`
+}
+
+// pluginCacheEntry returns the first entry of the commit found in the
+// cache plugins and its metadata, nil if there is none. Entries failing
+// the verification specified in cache are skipped.
+func (d *stdDiscover) pluginCacheEntry(plugins []*plugin, commit Commit, configID string, cache *CacheConfig) (*discoveryCacheEntry, moduleMetadataSet) {
+	for _, p := range plugins {
+		res := &pluginCacheResponse{}
+		err := p.call(PluginHookCache, map[string]interface{}{"op": "get", "commit": commit.ID(), "config": configID}, res)
+		if err != nil {
+			d.Log.Debug("Failed to read discovery cache entry %v from plugin %v: %v", commit.ID(), p.name, err)
+			continue
+		}
+
+		entry := res.Entry
+		if entry == nil || entry.Format != discoveryCacheFormat || entry.Commit != commit.ID() || entry.Config != configID {
+			continue
+		}
+
+		if cache.verifies() {
+			payload, err := json.Marshal(entry)
+			if err == nil {
+				err = cache.verify(entry.Commit, payload, res.Signature)
+			}
+			if err != nil {
+				d.Log.Warnf(msgRejectedCacheEntry, p.name, err)
+				continue
+			}
+		}
+
+		set, err := entry.metadata()
+		if err != nil {
+			d.Log.Debug("Ignoring discovery cache entry %v from plugin %v: %v", commit.ID(), p.name, err)
+			continue
+		}
+		return entry, set
+	}
+
+	return nil, nil
+}
+
+// incrementalMetadataInCommit discovers the modules in a commit from
+// the latest cache entry discovered with the same configuration.
+func (d *stdDiscover) incrementalMetadataInCommit(dir string, commit Commit, configID string) (moduleMetadataSet, map[string][]byte, error) {
+	base, err := latestDiscoveryCacheEntry(dir, configID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	baseCommit, err := d.Repo.GetCommit(base.Commit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	specs := make(map[string][]byte)
+	for _, m := range base.Modules {
+		specs[m.Dir] = []byte(m.Spec)
+	}
+
+	ignoreFiles := make(map[string][]byte)
+	for fileDir, c := range base.IgnoreFiles {
+		ignoreFiles[fileDir] = []byte(c)
+	}
+
+	deltas, err := d.Repo.Diff(baseCommit, commit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, delta := range deltas {
+		for _, p := range []string{delta.OldFile, delta.NewFile} {
+			files := specs
+			switch path.Base(p) {
+			case configFileName:
+			case ignoreFileName:
+				files = ignoreFiles
+			default:
+				continue
+			}
+
+			fileDir := strings.TrimSuffix(strings.TrimSuffix(p, path.Base(p)), "/")
+			delete(files, fileDir)
+			if contents, err := d.Repo.BlobContentsFromTree(commit, p); err == nil {
+				files[fileDir] = contents
+			}
+		}
+	}
+
+	dirs := make([]string, 0, len(specs))
+	for moduleDir := range specs {
+		dirs = append(dirs, moduleDir)
+	}
+	sortSpecDirs(dirs)
+
+	set := make(moduleMetadataSet, len(dirs))
+	err = forEach(len(dirs), func(i int) error {
+		m, err := newCommitModuleMetadata(d.Repo, commit, dirs[i], specs[dirs[i]])
+		if err != nil {
+			return err
+		}
+		set[i] = m
+		return nil
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = set.withIgnoreRules(ignoreFiles).withExcludedHashes(d.Repo, commit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return set, ignoreFiles, nil
+}
+
+func (d *stdDiscover) cacheDir() (string, error) {
+	dir, err := filepath.Abs(d.Repo.GitDir())
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, stateDirName, discoveryCacheDirName), nil
+}
+
+type noDiscoveryCacheKey struct{}
+
+// WithoutDiscoveryCache returns a context discovering the modules
+// without reading or writing the discovery cache when used to create a
+// system (see NewSystemWithContext).
+func WithoutDiscoveryCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noDiscoveryCacheKey{}, true)
+}
+
+func noDiscoveryCacheFrom(ctx context.Context) bool {
+	noCache, _ := ctx.Value(noDiscoveryCacheKey{}).(bool)
+	return noCache
+}
+
+// metadata creates the module metadata stored in a cache entry
+// including the modules excluded by .mbtignore files.
+func (entry *discoveryCacheEntry) metadata() (moduleMetadataSet, error) {
+	set := moduleMetadataSet{}
+	for _, m := range entry.Modules {
+		spec, err := newSpec([]byte(m.Spec))
+		if err != nil {
+			return nil, err
+		}
+
+		fileHashes := m.FileHashes
+		if fileHashes == nil {
+			fileHashes = make(map[string]string)
+		}
+
+		metadata := newModuleMetadata(m.Dir, m.Hash, spec, fileHashes)
+		metadata.specContent = []byte(m.Spec)
+		set = append(set, metadata)
+	}
+
+	ignoreFiles := make(map[string][]byte)
+	for d, c := range entry.IgnoreFiles {
+		ignoreFiles[d] = []byte(c)
+	}
+	return set.withIgnoreRules(ignoreFiles), nil
+}
+
+// sortSpecDirs sorts module directories in the order their specs
+// appear in a git tree walk, so that cached and uncached discoveries
+// produce the same result.
+func sortSpecDirs(dirs []string) {
+	sort.Slice(dirs, func(i, j int) bool {
+		return path.Join(dirs[i], configFileName) < path.Join(dirs[j], configFileName)
+	})
+}
+
+func readDiscoveryCacheEntry(p string) *discoveryCacheEntry {
+	buff, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil
+	}
+
+	entry := &discoveryCacheEntry{}
+	err = json.Unmarshal(buff, entry)
+	if err != nil || entry.Format != discoveryCacheFormat {
+		return nil
+	}
+	return entry
+}
+
+// latestDiscoveryCacheEntry returns the most recently written cache
+// entry discovered with the specified configuration.
+func latestDiscoveryCacheEntry(dir string, configID string) (*discoveryCacheEntry, error) {
+	files, err := discoveryCacheFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		entry := readDiscoveryCacheEntry(filepath.Join(dir, f.Name()))
+		if entry != nil && entry.Config == configID {
+			return entry, nil
+		}
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// discoveryCacheFiles returns the cache entries in dir, latest first.
+func discoveryCacheFiles(dir string) ([]os.FileInfo, error) {
+	all, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]os.FileInfo, 0, len(all))
+	for _, f := range all {
+		if !f.IsDir() && filepath.Ext(f.Name()) == ".json" {
+			files = append(files, f)
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+	return files, nil
+}
+
+// newDiscoveryCacheEntry creates the cache entry of the modules
+// discovered in a commit.
+func newDiscoveryCacheEntry(commit Commit, configID string, set moduleMetadataSet, ignoreFiles map[string][]byte) *discoveryCacheEntry {
+	entry := &discoveryCacheEntry{
+		Format:  discoveryCacheFormat,
+		Commit:  commit.ID(),
+		Config:  configID,
+		Modules: make([]*discoveryCacheModule, 0, len(set)),
+	}
+
+	if len(ignoreFiles) > 0 {
+		entry.IgnoreFiles = make(map[string]string)
+		for d, c := range ignoreFiles {
+			entry.IgnoreFiles[d] = string(c)
+		}
+	}
+
+	for _, m := range set {
+		entry.Modules = append(entry.Modules, &discoveryCacheModule{
+			Dir:        m.dir,
+			Hash:       m.hash,
+			Spec:       string(m.specContent),
+			FileHashes: m.dependentFileHashes,
+		})
+	}
+
+	return entry
+}
+
+// writeDiscoveryCacheEntry stores an entry in the cache in dir.
+// Entries are replaced atomically and the oldest entries are removed
+// once the cache grows beyond discoveryCacheSize.
+func writeDiscoveryCacheEntry(dir string, entry *discoveryCacheEntry) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	buff, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	p := filepath.Join(dir, entry.Commit+".json")
+	tmp := p + ".tmp"
+	err = ioutil.WriteFile(tmp, buff, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, p)
+	if err != nil {
+		return err
+	}
+
+	files, err := discoveryCacheFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	for i := discoveryCacheSize; i < len(files); i++ {
+		os.Remove(filepath.Join(dir, files[i].Name()))
+	}
+	return nil
+}
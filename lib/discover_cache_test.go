@@ -0,0 +1,171 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func discoveryCacheEntryPath(t *testing.T, w *World, commit string) string {
+	dir, err := filepath.Abs(w.Repo.GitDir())
+	check(t, err)
+	return filepath.Join(dir, stateDirName, discoveryCacheDirName, commit+".json")
+}
+
+func TestDiscoveryCacheHit(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Dependencies: []string{"app-a"}}))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	c, err := world.Repo.GetCommit(repo.LastCommit.String())
+	check(t, err)
+
+	expected, err := world.Discover.ModulesInCommit(c)
+	check(t, err)
+	assert.FileExists(t, discoveryCacheEntryPath(t, world, c.ID()))
+
+	world.Repo.Interceptor.Config("WalkBlobs").Return(errors.New("doh"))
+	mods, err := world.Discover.ModulesInCommit(c)
+	check(t, err)
+
+	assert.Equal(t, expected.indexByName()["app-a"].Version(), mods.indexByName()["app-a"].Version())
+	assert.Equal(t, expected.indexByName()["app-b"].Version(), mods.indexByName()["app-b"].Version())
+	assert.Equal(t, []string{"app-a", "app-b"}, moduleNames(mods))
+}
+
+func TestIncrementalDiscovery(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.InitModule("app-c"))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	c1, err := world.Repo.GetCommit(repo.LastCommit.String())
+	check(t, err)
+	_, err = world.Discover.ModulesInCommit(c1)
+	check(t, err)
+
+	check(t, repo.WriteContent("app-a/foo", "a"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Dependencies: []string{"app-d"}}))
+	check(t, repo.Remove("app-c"))
+	check(t, repo.InitModule("app-d"))
+	check(t, repo.Commit("second"))
+
+	c2, err := world.Repo.GetCommit(repo.LastCommit.String())
+	check(t, err)
+	set, err := metadataInCommit(world.Repo, c2)
+	check(t, err)
+	expected, err := toModules(set)
+	check(t, err)
+
+	world.Repo.Interceptor.Config("WalkBlobs").Return(errors.New("doh"))
+	mods, err := world.Discover.ModulesInCommit(c2)
+	check(t, err)
+
+	assert.Equal(t, []string{"app-a", "app-d", "app-b"}, moduleNames(mods))
+	for _, m := range expected {
+		assert.Equal(t, m.Version(), mods.indexByName()[m.Name()].Version())
+	}
+	assert.FileExists(t, discoveryCacheEntryPath(t, world, c2.ID()))
+}
+
+func TestDiscoveryCacheIsInvalidatedByConfigChange(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteConfig(&RepoConfig{}))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	c1, err := world.Repo.GetCommit(repo.LastCommit.String())
+	check(t, err)
+	_, err = world.Discover.ModulesInCommit(c1)
+	check(t, err)
+
+	check(t, repo.WriteConfig(&RepoConfig{LFS: &LFSConfig{Objects: true}}))
+	check(t, repo.Commit("second"))
+
+	c2, err := world.Repo.GetCommit(repo.LastCommit.String())
+	check(t, err)
+
+	world.Repo.Interceptor.Config("WalkBlobs").Return(errors.New("doh"))
+	_, err = world.Discover.ModulesInCommit(c2)
+
+	assert.EqualError(t, err, "doh")
+}
+
+func TestCorruptDiscoveryCache(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	c, err := world.Repo.GetCommit(repo.LastCommit.String())
+	check(t, err)
+	expected, err := world.Discover.ModulesInCommit(c)
+	check(t, err)
+
+	p := discoveryCacheEntryPath(t, world, c.ID())
+	check(t, ioutil.WriteFile(p, []byte("{blah"), 0644))
+
+	mods, err := world.Discover.ModulesInCommit(c)
+	check(t, err)
+
+	assert.Equal(t, []string{"app-a"}, moduleNames(mods))
+	assert.Equal(t, expected[0].Version(), mods[0].Version())
+	assert.NotNil(t, readDiscoveryCacheEntry(p))
+}
+
+func TestDiscoveryWithoutCache(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	s, err := NewSystemWithContext(WithoutDiscoveryCache(context.Background()), ".tmp/repo", LogLevelNormal)
+	check(t, err)
+
+	m, err := s.ManifestByCurrentBranch()
+	check(t, err)
+	assert.Equal(t, []string{"app-a"}, moduleNames(m.Modules))
+
+	_, err = os.Stat(filepath.Join(".tmp/repo/.git", stateDirName, discoveryCacheDirName, repo.LastCommit.String()+".json"))
+	assert.True(t, os.IsNotExist(err))
+
+	s, err = NewSystemWithContext(context.Background(), ".tmp/repo", LogLevelNormal)
+	check(t, err)
+	_, err = s.ManifestByCurrentBranch()
+	check(t, err)
+	assert.FileExists(t, filepath.Join(".tmp/repo/.git", stateDirName, discoveryCacheDirName, repo.LastCommit.String()+".json"))
+}
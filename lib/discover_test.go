@@ -42,6 +42,21 @@ func TestDependencyLinks(t *testing.T) {
 	assert.Equal(t, m["app-a"], m["app-b"].RequiredBy()[0])
 }
 
+func TestTopologicalOrderDoesNotDependOnDiscoveryOrder(t *testing.T) {
+	a := newModuleMetadata("app-a", "a", &Spec{Name: "app-a", Dependencies: []string{"lib-a"}}, nil)
+	b := newModuleMetadata("app-b", "b", &Spec{Name: "app-b"}, nil)
+	c := newModuleMetadata("app-c", "c", &Spec{Name: "app-c"}, nil)
+	l := newModuleMetadata("lib-a", "l", &Spec{Name: "lib-a"}, nil)
+
+	mods, err := toModules(moduleMetadataSet{a, b, c, l})
+	check(t, err)
+	reversed, err := toModules(moduleMetadataSet{l, c, b, a})
+	check(t, err)
+
+	assert.Equal(t, []string{"lib-a", "app-a", "app-b", "app-c"}, moduleNames(mods))
+	assert.Equal(t, moduleNames(mods), moduleNames(reversed))
+}
+
 func TestVersionCalculation(t *testing.T) {
 	a := newModuleMetadata("app-a", "a", &Spec{Name: "app-a", Dependencies: []string{"app-b"}}, nil)
 	b := newModuleMetadata("app-b", "b", &Spec{Name: "app-b"}, nil)
@@ -199,3 +214,163 @@ func TestVersionChangeOnFileDependencyChange(t *testing.T) {
 
 	assert.NotEqual(t, m2[0].Version(), m1[0].Version())
 }
+
+func TestFileDependenciesWithBackslashes(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:             "app-a",
+		FileDependencies: []string{"shared/foo.txt"},
+	}))
+	check(t, repo.WriteContent("shared/foo.txt", "hello"))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	c1, err := world.Repo.GetCommit(repo.LastCommit.String())
+	check(t, err)
+	m1, err := world.Discover.ModulesInCommit(c1)
+	check(t, err)
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:             "app-a",
+		FileDependencies: []string{`.\shared\foo.txt`},
+	}))
+	check(t, repo.Commit("second"))
+	c2, err := world.Repo.GetCommit(repo.LastCommit.String())
+	check(t, err)
+	m2, err := world.Discover.ModulesInCommit(c2)
+	check(t, err)
+
+	assert.Equal(t, []string{"shared/foo.txt"}, m2[0].FileDependencies())
+	// Version is derived from the content of the module directory,
+	// therefore compare the hashes of the file dependencies.
+	assert.Equal(t, m1[0].metadata.dependentFileHashes["shared/foo.txt"], m2[0].metadata.dependentFileHashes["shared/foo.txt"])
+	assert.NotEmpty(t, m2[0].metadata.dependentFileHashes["shared/foo.txt"])
+}
+
+func TestNormalizeSpecPath(t *testing.T) {
+	assert.Equal(t, "shared/foo.txt", normalizeSpecPath(`shared\foo.txt`))
+	assert.Equal(t, "shared/foo.txt", normalizeSpecPath("./shared//foo.txt"))
+	assert.Equal(t, "out/**/*.xml", normalizeSpecPath(`out\**\*.xml`))
+	assert.Equal(t, "foo.txt", normalizeSpecPath("/foo.txt"))
+	assert.Equal(t, "", normalizeSpecPath(""))
+}
+
+func TestVersionChangeOnSubmoduleChange(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModule("app-b"))
+
+	sub := NewTestRepo(t, ".tmp/repo/app-a/vendor")
+	check(t, sub.WriteContent("foo", "a"))
+	check(t, sub.Commit("first"))
+	check(t, repo.AddSubmodule("app-a/vendor", sub))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	c1, err := world.Repo.GetCommit(repo.LastCommit.String())
+	check(t, err)
+	m1, err := world.Discover.ModulesInCommit(c1)
+	check(t, err)
+
+	check(t, sub.AppendContent("foo", "b"))
+	check(t, sub.Commit("second"))
+	check(t, repo.Commit("second"))
+	c2, err := world.Repo.GetCommit(repo.LastCommit.String())
+	check(t, err)
+	m2, err := world.Discover.ModulesInCommit(c2)
+	check(t, err)
+
+	assert.NotEqual(t, m1.indexByName()["app-a"].Version(), m2.indexByName()["app-a"].Version())
+	assert.Equal(t, m1.indexByName()["app-b"].Version(), m2.indexByName()["app-b"].Version())
+}
+
+func TestModulesInSubmodules(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteConfig(&RepoConfig{Submodules: &SubmoduleConfig{Recurse: true}}))
+
+	sub := NewTestRepo(t, ".tmp/repo/vendor/lib")
+	check(t, sub.InitModule("lib-a"))
+	check(t, sub.InitModuleWithOptions("lib-b", &Spec{
+		Name:             "lib-b",
+		Dependencies:     []string{"lib-a"},
+		FileDependencies: []string{"shared/foo"},
+	}))
+	check(t, sub.WriteContent("shared/foo", "a"))
+	check(t, sub.Commit("first"))
+	check(t, repo.AddSubmodule("vendor/lib", sub))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	c1, err := world.Repo.GetCommit(repo.LastCommit.String())
+	check(t, err)
+	m1, err := world.Discover.ModulesInCommit(c1)
+	check(t, err)
+
+	assert.ElementsMatch(t, []string{"app-a", "lib-a", "lib-b"}, moduleNames(m1))
+	libB := m1.indexByName()["lib-b"]
+	assert.Equal(t, "vendor/lib/lib-b", libB.Path())
+	assert.Equal(t, []string{"vendor/lib/shared/foo"}, libB.FileDependencies())
+	assert.Equal(t, "lib-a", libB.Requires()[0].Name())
+
+	check(t, sub.AppendContent("shared/foo", "b"))
+	check(t, sub.Commit("second"))
+	check(t, repo.Commit("second"))
+	c2, err := world.Repo.GetCommit(repo.LastCommit.String())
+	check(t, err)
+	m2, err := world.Discover.ModulesInCommit(c2)
+	check(t, err)
+
+	assert.Equal(t, m1.indexByName()["lib-a"].Version(), m2.indexByName()["lib-a"].Version())
+	assert.NotEqual(t, libB.Version(), m2.indexByName()["lib-b"].Version())
+
+	w, err := world.Discover.ModulesInWorkspace()
+	check(t, err)
+	assert.ElementsMatch(t, []string{"app-a", "lib-a", "lib-b"}, moduleNames(w))
+}
+
+func TestModulesInSubmodulesAreNotDiscoveredByDefault(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+
+	sub := NewTestRepo(t, ".tmp/repo/vendor/lib")
+	check(t, sub.InitModule("lib-a"))
+	check(t, sub.Commit("first"))
+	check(t, repo.AddSubmodule("vendor/lib", sub))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	c, err := world.Repo.GetCommit(repo.LastCommit.String())
+	check(t, err)
+	m, err := world.Discover.ModulesInCommit(c)
+	check(t, err)
+
+	assert.Equal(t, []string{"app-a"}, moduleNames(m))
+}
+
+func TestUninitialisedSubmodule(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteConfig(&RepoConfig{Submodules: &SubmoduleConfig{Recurse: true}}))
+
+	sub := NewTestRepo(t, ".tmp/repo/vendor/lib")
+	check(t, sub.InitModule("lib-a"))
+	check(t, sub.Commit("first"))
+	check(t, repo.AddSubmodule("vendor/lib", sub))
+	check(t, repo.Commit("first"))
+	check(t, os.RemoveAll(".tmp/repo/vendor/lib/.git"))
+
+	world := NewWorld(t, ".tmp/repo")
+	c, err := world.Repo.GetCommit(repo.LastCommit.String())
+	check(t, err)
+	_, err = world.Discover.ModulesInCommit(c)
+
+	assert.EqualError(t, err, fmt.Sprintf(msgSubmoduleNotInitialised, "vendor/lib"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
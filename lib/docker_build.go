@@ -0,0 +1,292 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	dockerHubRegistry = "registry-1.docker.io"
+	// ociIndexMediaTypes are the media types of multi platform images
+	// in addition to ociManifestMediaTypes.
+	ociIndexMediaTypes = "application/vnd.oci.image.index.v1+json, application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// DockerBuild describes the image built from a Dockerfile of a module.
+// Modules declaring it without a build command for the platform are
+// built with docker buildx.
+type DockerBuild struct {
+	// Image is the repository of the image (e.g. ghcr.io/acme/app-a).
+	// Image is tagged with the version of the module.
+	Image string `yaml:"image"`
+	// Dockerfile relative to the module directory. Defaults to Dockerfile.
+	Dockerfile string `yaml:"dockerfile,omitempty"`
+	// Context of the build relative to the module directory. Defaults
+	// to the module directory.
+	Context string `yaml:"context,omitempty"`
+	// Platforms of a multi platform image (e.g. linux/amd64, linux/arm64).
+	Platforms []string `yaml:"platforms,omitempty"`
+	// Args are the build arguments.
+	Args map[string]string `yaml:"args,omitempty"`
+	// Target is the stage to build in a multi stage Dockerfile.
+	Target string `yaml:"target,omitempty"`
+	// CacheFrom and CacheTo are the external cache sources and
+	// destinations of buildx (e.g. type=registry,ref=ghcr.io/acme/app-a:cache).
+	CacheFrom []string `yaml:"cacheFrom,omitempty"`
+	CacheTo   []string `yaml:"cacheTo,omitempty"`
+}
+
+// dockerTag returns the tag of the image of a module.
+// Variants are tagged with the version of the variant.
+func dockerTag(mod *Module, variant *Variant) string {
+	if variant != nil {
+		return variant.Version
+	}
+	return mod.Version()
+}
+
+// usesDockerBuild reports whether a module is built with docker buildx,
+// that is, it declares an image to build and no build command for
+// this platform.
+func usesDockerBuild(mod *Module) bool {
+	if mod.Docker() == nil {
+		return false
+	}
+	_, ok := mod.Build()[runtime.GOOS]
+	if !ok {
+		_, ok = mod.Build()["default"]
+	}
+	return !ok
+}
+
+// dockerBuildCmd creates the buildx command building the image of a
+// module. Image is pushed if push is set. Otherwise, it is loaded into
+// the local image store, which is only possible for single platform
+// images.
+func dockerBuildCmd(mod *Module, variant *Variant, push bool, metadataFile string) *Cmd {
+	d := mod.Docker()
+	dockerfile := d.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+
+	context := d.Context
+	if context == "" {
+		context = "."
+	}
+
+	args := []string{"buildx", "build", "--file", dockerfile, "--tag", d.Image + ":" + dockerTag(mod, variant)}
+	if len(d.Platforms) > 0 {
+		args = append(args, "--platform", strings.Join(d.Platforms, ","))
+	}
+	if d.Target != "" {
+		args = append(args, "--target", d.Target)
+	}
+
+	for _, k := range sortedKeys(d.Args) {
+		args = append(args, "--build-arg", k+"="+d.Args[k])
+	}
+
+	// Values of the matrix variables are passed from the environment
+	// of the variant.
+	if variant != nil {
+		for _, k := range sortedKeys(variant.Values) {
+			args = append(args, "--build-arg", k)
+		}
+	}
+
+	for _, c := range d.CacheFrom {
+		args = append(args, "--cache-from", c)
+	}
+	for _, c := range d.CacheTo {
+		args = append(args, "--cache-to", c)
+	}
+
+	args = append(args,
+		"--label", "dev.mbt.module.name="+mod.Name(),
+		"--label", "dev.mbt.module.version="+mod.Version())
+
+	if push {
+		args = append(args, "--push")
+	} else if len(d.Platforms) < 2 {
+		args = append(args, "--load")
+	}
+
+	if metadataFile != "" {
+		args = append(args, "--metadata-file", metadataFile)
+	}
+
+	return &Cmd{Cmd: defaultContainerRuntime, Args: append(args, context)}
+}
+
+// dockerImagePushed reports whether the image of a module is already
+// in the registry, in which case the module is not built again.
+// Module is built if the registry cannot be checked.
+func (s *stdSystem) dockerImagePushed(module *Module, variant *Variant) bool {
+	ref := module.Docker().Image + ":" + dockerTag(module, variant)
+	exists, err := dockerImageExists(ref)
+	if err != nil {
+		s.Log.Warnf(msgFailedCheckDockerImage, ref, err)
+		return false
+	}
+
+	if exists {
+		s.Log.Infof(msgDockerImageExists, ref, module.Name())
+	}
+	return exists
+}
+
+// execDockerBuild builds the image of a module. Image is pushed when
+// the outputs of modules are published and the pushed image is
+// returned as an artifact.
+func (s *stdSystem) execDockerBuild(manifest *Manifest, module *Module, variant *Variant, options *CmdOptions) ([]*Artifact, error) {
+	var metadataFile string
+	if options.Publish {
+		f, err := ioutil.TempFile("", "mbt-buildx-")
+		if err != nil {
+			return nil, e.Wrap(ErrClassInternal, err)
+		}
+		f.Close()
+		metadataFile = f.Name()
+		defer os.Remove(metadataFile)
+	}
+
+	cmd := dockerBuildCmd(module, variant, options.Publish, metadataFile)
+	if options.ContainerRuntime != "" {
+		cmd.Cmd = options.ContainerRuntime
+	}
+
+	err := s.execSpecCmd(manifest, module, options, "", "", cmd.Cmd, cmd.Args)
+	if err != nil || metadataFile == "" {
+		return nil, err
+	}
+
+	metadata := make(map[string]interface{})
+	buff, err := ioutil.ReadFile(metadataFile)
+	if err == nil {
+		err = json.Unmarshal(buff, &metadata)
+	}
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedReadBuildxMetadata, module.Name())
+	}
+
+	digest, _ := metadata["containerimage.digest"].(string)
+	ref := module.Docker().Image + ":" + dockerTag(module, variant)
+	return []*Artifact{{Target: ArtifactOCI, Ref: ref, Digest: digest}}, nil
+}
+
+// dockerReference parses an image reference in the form docker
+// accepts, where the registry defaults to Docker Hub.
+func dockerReference(image string) (*ociReference, error) {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 1 {
+		image = dockerHubRegistry + "/library/" + image
+	} else if !strings.ContainsAny(parts[0], ".:") && parts[0] != "localhost" {
+		image = dockerHubRegistry + "/" + image
+	}
+	return parseOCIReference(image)
+}
+
+// dockerImageExists checks whether an image is in the registry.
+// Credentials are read from the docker configuration if available.
+func dockerImageExists(image string) (bool, error) {
+	ref, err := dockerReference(image)
+	if err != nil {
+		return false, err
+	}
+
+	registry := newOCIRegistry(ref)
+	registry.username, registry.password = dockerCredentials(ref.registry)
+
+	u := registry.url("manifests/" + ref.reference)
+	res, err := registry.send(http.MethodHead, u, nil, map[string]string{"Accept": ociManifestMediaTypes + ", " + ociIndexMediaTypes})
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("HEAD %s returned %s", u, res.Status)
+	}
+}
+
+// dockerCredentials returns the basic credentials of a registry stored
+// in the docker configuration ($DOCKER_CONFIG/config.json or
+// ~/.docker/config.json). Credential helpers are not supported.
+func dockerCredentials(registry string) (string, string) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", ""
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+
+	buff, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return "", ""
+	}
+
+	config := &struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}{}
+	if err := json.Unmarshal(buff, config); err != nil {
+		return "", ""
+	}
+
+	keys := []string{registry, "https://" + registry}
+	if registry == dockerHubRegistry {
+		keys = append(keys, "https://index.docker.io/v1/", "index.docker.io", "docker.io")
+	}
+
+	for _, k := range keys {
+		a, ok := config.Auths[k]
+		if !ok || a.Auth == "" {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+		if err != nil {
+			continue
+		}
+
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) == 2 {
+			return parts[0], parts[1]
+		}
+	}
+
+	return "", ""
+}
@@ -0,0 +1,277 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBuildx is a container runtime recording the arguments of buildx
+// in the module directory and writing the metadata of the image.
+const fakeBuildx = `#!/bin/sh
+echo "$@" > buildx.txt
+while [ $# -gt 0 ]; do
+  if [ "$1" = "--metadata-file" ]; then
+    echo '{"containerimage.digest": "sha256:feed"}' > "$2"
+  fi
+  shift
+done
+`
+
+type imageRegistry struct {
+	server   *httptest.Server
+	images   map[string]bool
+	requests []string
+}
+
+func startImageRegistry(t *testing.T) *imageRegistry {
+	r := &imageRegistry{images: make(map[string]bool)}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.requests = append(r.requests, req.Method+" "+req.URL.Path)
+		if req.Method != http.MethodHead || !strings.Contains(req.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if r.images[req.URL.Path] {
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	return r
+}
+
+func (r *imageRegistry) host() string {
+	return strings.TrimPrefix(r.server.URL, "http://")
+}
+
+func initDockerRepo(t *testing.T, docker *DockerBuild) (*TestRepository, *CmdOptions) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Docker: docker}))
+	check(t, repo.WriteContent("app-a/Dockerfile", "FROM scratch"))
+	check(t, repo.Commit("first"))
+
+	runtimePath, err := filepath.Abs(filepath.Join(".tmp", "buildx.sh"))
+	check(t, err)
+	check(t, ioutil.WriteFile(runtimePath, []byte(fakeBuildx), 0755))
+
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.ContainerRuntime = runtimePath
+	return repo, options
+}
+
+func TestDockerBuildCmd(t *testing.T) {
+	mod := newModule(newModuleMetadata("app-a", "abc", &Spec{Name: "app-a", Docker: &DockerBuild{
+		Image:      "ghcr.io/acme/app-a",
+		Dockerfile: "docker/Dockerfile",
+		Context:    "..",
+		Platforms:  []string{"linux/amd64", "linux/arm64"},
+		Args:       map[string]string{"B": "2", "A": "1"},
+		Target:     "release",
+		CacheFrom:  []string{"type=registry,ref=ghcr.io/acme/app-a:cache"},
+		CacheTo:    []string{"type=registry,ref=ghcr.io/acme/app-a:cache,mode=max"},
+	}}, nil), nil)
+
+	c := dockerBuildCmd(mod, nil, true, "meta.json")
+	assert.Equal(t, "docker", c.Cmd)
+	assert.Equal(t, []string{
+		"buildx", "build", "--file", "docker/Dockerfile", "--tag", "ghcr.io/acme/app-a:" + mod.Version(),
+		"--platform", "linux/amd64,linux/arm64",
+		"--target", "release",
+		"--build-arg", "A=1", "--build-arg", "B=2",
+		"--cache-from", "type=registry,ref=ghcr.io/acme/app-a:cache",
+		"--cache-to", "type=registry,ref=ghcr.io/acme/app-a:cache,mode=max",
+		"--label", "dev.mbt.module.name=app-a", "--label", "dev.mbt.module.version=" + mod.Version(),
+		"--push", "--metadata-file", "meta.json", "..",
+	}, c.Args)
+
+	// Multi platform images cannot be loaded.
+	c = dockerBuildCmd(mod, nil, false, "")
+	assert.NotContains(t, c.Args, "--load")
+	assert.NotContains(t, c.Args, "--push")
+}
+
+func TestDockerBuildCmdOfVariant(t *testing.T) {
+	mod := newModule(newModuleMetadata("app-a", "abc", &Spec{Name: "app-a", Docker: &DockerBuild{Image: "acme/app-a"}}, nil), nil)
+	variant := &Variant{Name: "go=1.21", Values: map[string]string{"go": "1.21"}, Version: "v1"}
+
+	c := dockerBuildCmd(mod, variant, false, "")
+	assert.Equal(t, []string{
+		"buildx", "build", "--file", "Dockerfile", "--tag", "acme/app-a:v1",
+		"--build-arg", "go",
+		"--label", "dev.mbt.module.name=app-a", "--label", "dev.mbt.module.version=" + mod.Version(),
+		"--load", ".",
+	}, c.Args)
+}
+
+func TestBuildWithDocker(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	registry := startImageRegistry(t)
+	defer registry.server.Close()
+
+	repo, options := initDockerRepo(t, &DockerBuild{Image: registry.host() + "/acme/app-a"})
+
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	mod := summary.Completed[0].Module
+	assert.Empty(t, summary.Skipped)
+	assert.Equal(t, []string{"HEAD /v2/acme/app-a/manifests/" + mod.Version()}, registry.requests)
+
+	args, err := ioutil.ReadFile(filepath.Join(repo.Dir, "app-a", "buildx.txt"))
+	check(t, err)
+	assert.Equal(t, fmt.Sprintf("buildx build --file Dockerfile --tag %s/acme/app-a:%s --label dev.mbt.module.name=app-a --label dev.mbt.module.version=%s --load .\n", registry.host(), mod.Version(), mod.Version()), string(args))
+	assert.Empty(t, summary.Completed[0].Artifacts)
+}
+
+func TestBuildWithDockerSkipsImagesInRegistry(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	registry := startImageRegistry(t)
+	defer registry.server.Close()
+
+	repo, options := initDockerRepo(t, &DockerBuild{Image: registry.host() + "/acme/app-a"})
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+	registry.images["/v2/acme/app-a/manifests/"+m.Modules[0].Version()] = true
+
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Len(t, summary.Completed, 1)
+	_, err = os.Stat(filepath.Join(repo.Dir, "app-a", "buildx.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestBuildWithDockerPush(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	registry := startImageRegistry(t)
+	defer registry.server.Close()
+
+	repo, options := initDockerRepo(t, &DockerBuild{Image: registry.host() + "/acme/app-a"})
+	options.Publish = true
+
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	mod := summary.Completed[0].Module
+	ref := fmt.Sprintf("%s/acme/app-a:%s", registry.host(), mod.Version())
+	assert.Equal(t, []*Artifact{{Target: ArtifactOCI, Ref: ref, Digest: "sha256:feed"}}, summary.Completed[0].Artifacts)
+
+	args, err := ioutil.ReadFile(filepath.Join(repo.Dir, "app-a", "buildx.txt"))
+	check(t, err)
+	assert.Contains(t, string(args), " --push --metadata-file ")
+}
+
+func TestBuildWithDockerWhenRegistryIsUnavailable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	repo, options := initDockerRepo(t, &DockerBuild{Image: "127.0.0.1:1/acme/app-a"})
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	_, err = os.Stat(filepath.Join(repo.Dir, "app-a", "buildx.txt"))
+	check(t, err)
+}
+
+func TestBuildCommandTakesPrecedenceOverDocker(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:   "app-a",
+		Build:  map[string]*Cmd{"default": {Cmd: "make"}},
+		Docker: &DockerBuild{Image: "acme/app-a"},
+	}))
+	check(t, repo.Commit("first"))
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+	assert.False(t, usesDockerBuild(m.Modules[0]))
+}
+
+func TestPipelinePlanWithDocker(t *testing.T) {
+	initDockerRepo(t, &DockerBuild{Image: "ghcr.io/acme/app-a"})
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	plan, err := newPipelinePlan(m, &PipelineOptions{})
+	check(t, err)
+	assert.True(t, plan.Steps[0].Buildable())
+	assert.True(t, strings.HasPrefix(plan.Steps[0].Command, "docker buildx build --file Dockerfile --tag ghcr.io/acme/app-a:"))
+	assert.True(t, strings.HasSuffix(plan.Steps[0].Command, " --push ."))
+}
+
+func TestDockerReference(t *testing.T) {
+	for image, expected := range map[string]*ociReference{
+		"alpine:3":                 {registry: dockerHubRegistry, repository: "library/alpine", reference: "3"},
+		"acme/app":                 {registry: dockerHubRegistry, repository: "acme/app", reference: "latest"},
+		"ghcr.io/acme/app:v1":      {registry: "ghcr.io", repository: "acme/app", reference: "v1"},
+		"localhost/app:v1":         {registry: "localhost", repository: "app", reference: "v1"},
+		"registry:5000/acme/app:1": {registry: "registry:5000", repository: "acme/app", reference: "1"},
+	} {
+		ref, err := dockerReference(image)
+		check(t, err)
+		assert.Equal(t, expected, ref, image)
+	}
+}
+
+func TestDockerCredentials(t *testing.T) {
+	clean()
+	dir := filepath.Join(".tmp", "docker")
+	check(t, os.MkdirAll(dir, 0755))
+	auth := base64.StdEncoding.EncodeToString([]byte("user:pa:ss"))
+	check(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(fmt.Sprintf(`{"auths": {"ghcr.io": {"auth": "%s"}, "https://index.docker.io/v1/": {"auth": "%s"}}}`, auth, auth)), 0644))
+
+	os.Setenv("DOCKER_CONFIG", dir)
+	defer os.Unsetenv("DOCKER_CONFIG")
+
+	u, p := dockerCredentials("ghcr.io")
+	assert.Equal(t, "user", u)
+	assert.Equal(t, "pa:ss", p)
+
+	u, _ = dockerCredentials(dockerHubRegistry)
+	assert.Equal(t, "user", u)
+
+	u, p = dockerCredentials("quay.io")
+	assert.Empty(t, u)
+	assert.Empty(t, p)
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// DoctorStatusOK is the status of a check that passed.
+	DoctorStatusOK = "ok"
+	// DoctorStatusWarning is the status of a check finding an issue
+	// affecting some commands.
+	DoctorStatusWarning = "warning"
+	// DoctorStatusError is the status of a check finding an issue
+	// preventing mbt from working.
+	DoctorStatusError = "error"
+	// DoctorFormatText formats a doctor report as text.
+	DoctorFormatText = "text"
+	// DoctorFormatJSON formats a doctor report as json.
+	DoctorFormatJSON = "json"
+)
+
+// DoctorReport is the outcome of the checks performed by doctor.
+type DoctorReport struct {
+	Checks []*DoctorCheck `json:"checks"`
+}
+
+// DoctorCheck is the outcome of a check.
+type DoctorCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	// Fix describes how to resolve the issue found by the check.
+	Fix string `json:"fix,omitempty"`
+	// Diagnostic describes the error found by the check if there is one.
+	Diagnostic *Diagnostic `json:"diagnostic,omitempty"`
+}
+
+// Healthy informs if none of the checks found an error.
+func (r *DoctorReport) Healthy() bool {
+	for _, c := range r.Checks {
+		if c.Status == DoctorStatusError {
+			return false
+		}
+	}
+	return true
+}
+
+// Write writes the report in the specified format.
+func (r *DoctorReport) Write(format string, w io.Writer) error {
+	switch format {
+	case DoctorFormatText:
+		for _, c := range r.Checks {
+			code := ""
+			if c.Diagnostic != nil {
+				code = fmt.Sprintf(" [%s]", c.Diagnostic.Code)
+			}
+			fmt.Fprintf(w, "%-8s %s: %s%s\n", strings.ToUpper(c.Status), c.Name, c.Message, code)
+			if c.Fix != "" {
+				fmt.Fprintf(w, "%-8s fix: %s\n", "", c.Fix)
+			}
+		}
+		return nil
+	case DoctorFormatJSON:
+		buff, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return e.Wrap(ErrClassInternal, err)
+		}
+		_, err = fmt.Fprintln(w, string(buff))
+		return err
+	default:
+		return e.NewErrorf(ErrClassUser, msgUnsupportedDoctorFormat, format)
+	}
+}
+
+func (r *DoctorReport) add(name, status, message, fix string) {
+	r.Checks = append(r.Checks, &DoctorCheck{Name: name, Status: status, Message: message, Fix: fix})
+}
+
+// addError adds a check that found an error. Message of the error and
+// suggestion of its diagnostic are used when message and fix are not
+// specified.
+func (r *DoctorReport) addError(name, status, message string, err error, fix string) {
+	d := Diagnose(err, nil)
+	if status == DoctorStatusWarning {
+		d.Severity = SeverityWarning
+	}
+	if message == "" {
+		message = err.Error()
+	}
+	if fix == "" {
+		fix = d.Suggestion
+	}
+	r.Checks = append(r.Checks, &DoctorCheck{Name: name, Status: status, Message: message, Fix: fix, Diagnostic: d})
+}
+
+// RepositoryUnavailableReport creates the report of doctor when the
+// repository at path cannot be opened.
+func RepositoryUnavailableReport(path string, err error) *DoctorReport {
+	r := &DoctorReport{}
+	checkGitBackend(r)
+	r.add("repository", DoctorStatusError, fmt.Sprintf("Failed to open the repository at %v: %v", path, err),
+		"Run mbt inside a git repository or specify its path with --in")
+	return r
+}
+
+func (s *stdSystem) Doctor() *DoctorReport {
+	r := &DoctorReport{}
+	checkGitBackend(r)
+
+	empty, err := s.Repo.IsEmpty()
+	if err != nil {
+		r.addError("repository", DoctorStatusError, "", err, "")
+		return r
+	}
+	if empty {
+		r.add("repository", DoctorStatusWarning, "Repository does not have any commits",
+			"Commit the modules, commands other than build local and describe local require a commit")
+	} else {
+		r.add("repository", DoctorStatusOK, fmt.Sprintf("Repository at %v", s.Repo.Path()), "")
+		s.checkHistory(r)
+	}
+
+	s.checkWorkspace(r)
+	config := s.checkConfig(r)
+	mods := s.checkModules(r, config)
+	checkContainerRuntime(r, mods)
+	s.checkState(r)
+	return r
+}
+
+func (s *stdSystem) checkHistory(r *DoctorReport) {
+	shallow, err := s.Repo.IsShallow()
+	if err != nil {
+		r.addError("history", DoctorStatusError, "", err, "")
+	} else if shallow {
+		r.add("history", DoctorStatusWarning, "Repository is a shallow clone, merge bases used by diff and pr commands may not be found",
+			"Run git fetch --unshallow (or clone with fetch-depth: 0 in CI)")
+	} else {
+		r.add("history", DoctorStatusOK, "Repository contains the full history", "")
+	}
+
+	detached, err := s.Repo.IsHeadDetached()
+	if err != nil {
+		r.addError("head", DoctorStatusError, "", err, "")
+	} else if detached {
+		r.add("head", DoctorStatusWarning, "Head is detached, commands using the current branch (e.g. build head) use the commit checked out",
+			"Check out a branch with git checkout <branch> or use the commit variants of commands (e.g. build commit <sha>)")
+	} else {
+		branch, err := s.Repo.CurrentBranch()
+		if err != nil {
+			r.addError("head", DoctorStatusError, "", err, "")
+		} else {
+			r.add("head", DoctorStatusOK, fmt.Sprintf("Head is on branch %v", branch), "")
+		}
+	}
+}
+
+func (s *stdSystem) checkWorkspace(r *DoctorReport) {
+	err := s.Repo.EnsureSafeWorkspace()
+	if err == nil {
+		r.add("workspace", DoctorStatusOK, "Workspace does not have uncommitted changes", "")
+	} else if ee, ok := err.(*e.E); ok && ee.Class() == ErrClassUser {
+		r.addError("workspace", DoctorStatusWarning, "Workspace has uncommitted changes, commands checking out a commit (e.g. build branch) fail", err,
+			"Commit or stash the changes, or use the local variants of commands (e.g. build local)")
+	} else {
+		r.addError("workspace", DoctorStatusError, "", err, "")
+	}
+}
+
+func (s *stdSystem) checkConfig(r *DoctorReport) *RepoConfig {
+	config, err := loadRepoConfig(s.Repo.Path())
+	if err != nil {
+		r.addError("config", DoctorStatusError, "", err, "Correct the syntax of .mbt/config.yml")
+		return nil
+	}
+
+	r.add("config", DoctorStatusOK, "Repository configuration is valid", "")
+	return config
+}
+
+func (s *stdSystem) checkModules(r *DoctorReport, config *RepoConfig) Modules {
+	mods, err := s.Discover.ModulesInWorkspace()
+	if err != nil {
+		r.addError("modules", DoctorStatusError, "", err,
+			"Correct the spec (.mbt.yml) or the dependencies of the module in the error")
+		return nil
+	}
+	r.add("modules", DoctorStatusOK, fmt.Sprintf("%v modules with valid specs and an acyclic dependency graph", len(mods)), "")
+
+	if config != nil && config.PropertiesSchema != nil {
+		if err := validateProperties(config.PropertiesSchema, mods); err != nil {
+			r.addError("properties", DoctorStatusError, "", err,
+				"Correct the properties of the module or propertiesSchema in .mbt/config.yml")
+		} else {
+			r.add("properties", DoctorStatusOK, "Properties of modules match propertiesSchema", "")
+		}
+	}
+
+	return mods
+}
+
+// checkContainerRuntime checks if the default container runtime is
+// available when there are modules built in a container (or built
+// with docker buildx).
+func checkContainerRuntime(r *DoctorReport, mods Modules) {
+	images := make([]string, 0)
+	for _, m := range mods {
+		if m.Image() != "" || usesDockerBuild(m) {
+			images = append(images, m.Name())
+		}
+	}
+	if len(images) == 0 {
+		return
+	}
+
+	if _, err := exec.LookPath(defaultContainerRuntime); err != nil {
+		r.add("container runtime", DoctorStatusWarning, fmt.Sprintf("%v is not found in PATH, modules specifying an image (%v) cannot be built", defaultContainerRuntime, strings.Join(images, ", ")),
+			fmt.Sprintf("Install %v or specify the runtime with --container-runtime", defaultContainerRuntime))
+		return
+	}
+
+	r.add("container runtime", DoctorStatusOK, fmt.Sprintf("%v is available for modules specifying an image", defaultContainerRuntime), "")
+}
+
+// checkState checks if the state (e.g. build journal and cached
+// manifests) can be persisted.
+func (s *stdSystem) checkState(r *DoctorReport) {
+	dir, err := s.stateDir()
+	if err == nil {
+		err = os.MkdirAll(dir, 0755)
+	}
+	if err == nil {
+		var f *os.File
+		f, err = ioutil.TempFile(dir, "doctor")
+		if err == nil {
+			f.Close()
+			err = os.Remove(f.Name())
+		}
+	}
+
+	if err != nil {
+		r.add("state", DoctorStatusError, fmt.Sprintf("State directory %v is not writable: %v", dir, err),
+			"Ensure the user running mbt can write to the .git directory")
+		return
+	}
+
+	r.add("state", DoctorStatusOK, fmt.Sprintf("State directory %v is writable", dir), "")
+}
@@ -0,0 +1,50 @@
+//go:build !nolibgit2
+
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"strings"
+
+	git "github.com/libgit2/git2go"
+)
+
+// checkGitBackend checks the features of libgit2 mbt is linked with.
+func checkGitBackend(r *DoctorReport) {
+	features := git.Features()
+	if features&git.FeatureThreads == 0 {
+		r.add("git", DoctorStatusError, "libgit2 is built without thread support",
+			"Install a build of mbt linked with libgit2 built with threads enabled")
+		return
+	}
+
+	missing := make([]string, 0)
+	if features&git.FeatureHttps == 0 {
+		missing = append(missing, "https")
+	}
+	if features&git.FeatureSsh == 0 {
+		missing = append(missing, "ssh")
+	}
+	if len(missing) > 0 {
+		r.add("git", DoctorStatusWarning, fmt.Sprintf("libgit2 is built without %v support, git template sources using it cannot be fetched", strings.Join(missing, " and ")),
+			"Install a build of mbt linked with libgit2 built with https and ssh enabled or use a local template source")
+		return
+	}
+
+	r.add("git", DoctorStatusOK, "libgit2 supports threads, https and ssh", "")
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func doctorStatuses(r *DoctorReport) map[string]string {
+	statuses := make(map[string]string)
+	for _, c := range r.Checks {
+		statuses[c.Name] = c.Status
+	}
+	return statuses
+}
+
+func TestDoctorOfHealthyRepo(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	r := NewWorld(t, ".tmp/repo").System.Doctor()
+
+	assert.True(t, r.Healthy())
+	statuses := doctorStatuses(r)
+	assert.Equal(t, DoctorStatusOK, statuses["repository"])
+	assert.Equal(t, DoctorStatusOK, statuses["history"])
+	assert.Equal(t, DoctorStatusOK, statuses["head"])
+	assert.Equal(t, DoctorStatusOK, statuses["workspace"])
+	assert.Equal(t, DoctorStatusOK, statuses["config"])
+	assert.Equal(t, DoctorStatusOK, statuses["modules"])
+	assert.Equal(t, DoctorStatusOK, statuses["state"])
+	assert.Contains(t, statuses, "git")
+	assert.NotContains(t, statuses, "properties")
+	assert.NotContains(t, statuses, "container runtime")
+}
+
+func TestDoctorOfEmptyRepo(t *testing.T) {
+	clean()
+	NewTestRepo(t, ".tmp/repo")
+
+	r := NewWorld(t, ".tmp/repo").System.Doctor()
+
+	assert.True(t, r.Healthy())
+	statuses := doctorStatuses(r)
+	assert.Equal(t, DoctorStatusWarning, statuses["repository"])
+	assert.NotContains(t, statuses, "head")
+}
+
+func TestDoctorOfDirtyWorkspace(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+	check(t, repo.WriteContent("app-a/foo", "a"))
+
+	r := NewWorld(t, ".tmp/repo").System.Doctor()
+
+	assert.True(t, r.Healthy())
+	assert.Equal(t, DoctorStatusWarning, doctorStatuses(r)["workspace"])
+}
+
+func TestDoctorOfDetachedHead(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+	check(t, repo.CheckoutAndDetach(repo.LastCommit.String()))
+
+	r := NewWorld(t, ".tmp/repo").System.Doctor()
+
+	assert.True(t, r.Healthy())
+	assert.Equal(t, DoctorStatusWarning, doctorStatuses(r)["head"])
+}
+
+func TestDoctorOfCyclicDependency(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Dependencies: []string{"app-b"}}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Dependencies: []string{"app-a"}}))
+	check(t, repo.Commit("first"))
+
+	r := NewWorld(t, ".tmp/repo").System.Doctor()
+
+	assert.False(t, r.Healthy())
+	for _, c := range r.Checks {
+		if c.Name == "modules" {
+			assert.Equal(t, DoctorStatusError, c.Status)
+			assert.Equal(t, "Could not produce the module graph due to a cyclic dependency in path: app-a -> app-b -> app-a", c.Message)
+			assert.NotEmpty(t, c.Fix)
+		}
+	}
+}
+
+func TestDoctorOfInvalidConfig(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent(".mbt/config.yml", "hooks: [a"))
+	check(t, repo.Commit("first"))
+
+	r := NewWorld(t, ".tmp/repo").System.Doctor()
+
+	assert.False(t, r.Healthy())
+	assert.Equal(t, DoctorStatusError, doctorStatuses(r)["config"])
+	assert.Equal(t, DoctorStatusOK, doctorStatuses(r)["modules"])
+}
+
+func TestDoctorOfInvalidProperties(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteConfig(&RepoConfig{PropertiesSchema: &PropertySchema{
+		Type:     "object",
+		Required: []string{"replicas"},
+	}}))
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	r := NewWorld(t, ".tmp/repo").System.Doctor()
+
+	assert.False(t, r.Healthy())
+	assert.Equal(t, DoctorStatusError, doctorStatuses(r)["properties"])
+}
+
+func TestDoctorOfModuleWithImage(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Image: "alpine"}))
+	check(t, repo.Commit("first"))
+
+	r := NewWorld(t, ".tmp/repo").System.Doctor()
+
+	assert.True(t, r.Healthy())
+	assert.Contains(t, doctorStatuses(r), "container runtime")
+}
+
+func TestRepositoryUnavailableReport(t *testing.T) {
+	r := RepositoryUnavailableReport("foo", errors.New("not a repository"))
+
+	assert.False(t, r.Healthy())
+	assert.Equal(t, DoctorStatusError, doctorStatuses(r)["repository"])
+	assert.Contains(t, doctorStatuses(r), "git")
+}
+
+func TestWriteDoctorReport(t *testing.T) {
+	r := &DoctorReport{Checks: []*DoctorCheck{
+		{Name: "head", Status: DoctorStatusWarning, Message: "Head is detached", Fix: "Check out a branch"},
+		{Name: "state", Status: DoctorStatusOK, Message: "State directory is writable"},
+	}}
+
+	buff := new(bytes.Buffer)
+	check(t, r.Write(DoctorFormatText, buff))
+	assert.Equal(t, `WARNING  head: Head is detached
+         fix: Check out a branch
+OK       state: State directory is writable
+`, buff.String())
+
+	buff.Reset()
+	check(t, r.Write(DoctorFormatJSON, buff))
+	decoded := &DoctorReport{}
+	check(t, json.Unmarshal(buff.Bytes(), decoded))
+	assert.Equal(t, r, decoded)
+
+	err := r.Write("xml", buff)
+	assert.EqualError(t, err, "Unsupported doctor report format 'xml'")
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
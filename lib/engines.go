@@ -0,0 +1,279 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// TemplateEngineGo renders go templates.
+	TemplateEngineGo = "go"
+	// TemplateEngineJsonnet renders jsonnet programs using the jsonnet
+	// executable.
+	TemplateEngineJsonnet = "jsonnet"
+	// TemplateEngineCue exports cue configurations using the cue
+	// executable.
+	TemplateEngineCue = "cue"
+)
+
+var cuePackageClause = regexp.MustCompile(`(?m)^package\s+([A-Za-z_][A-Za-z0-9_]*)`)
+
+// templateEngine renders a template with the manifest context.
+// mod is the module the template is rendered for or nil.
+type templateEngine func(templatePath string, buffer []byte, m *Manifest, mod *Module, partials []*partial, options *ApplyOptions, output io.Writer) error
+
+// templateEngineFor returns the engine specified by name or the engine
+// associated with the extension of the template if name is empty.
+func templateEngineFor(templatePath, name string) (templateEngine, error) {
+	if name == "" {
+		switch filepath.Ext(templatePath) {
+		case ".jsonnet":
+			name = TemplateEngineJsonnet
+		case ".cue":
+			name = TemplateEngineCue
+		default:
+			name = TemplateEngineGo
+		}
+	}
+
+	switch name {
+	case TemplateEngineGo:
+		return processTemplate, nil
+	case TemplateEngineJsonnet:
+		return jsonnetTemplate, nil
+	case TemplateEngineCue:
+		return cueTemplate, nil
+	default:
+		return nil, e.NewErrorf(ErrClassUser, msgUnsupportedTemplateEngine, name)
+	}
+}
+
+// templateContextModule is the representation of a module in the
+// context of jsonnet and cue templates. Fields are named after the
+// methods of Module so that the context resembles the data available
+// to go templates.
+type templateContextModule struct {
+	Name       string                 `json:"Name"`
+	Path       string                 `json:"Path"`
+	Version    string                 `json:"Version"`
+	Hash       string                 `json:"Hash"`
+	Properties map[string]interface{} `json:"Properties"`
+	Requires   []string               `json:"Requires"`
+	RequiredBy []string               `json:"RequiredBy"`
+	Owners     []string               `json:"Owners"`
+}
+
+type templateContext struct {
+	Sha         string                            `json:"Sha"`
+	Environment string                            `json:"Environment"`
+	Env         map[string]string                 `json:"Env"`
+	Modules     map[string]*templateContextModule `json:"Modules"`
+	ModulesList []*templateContextModule          `json:"ModulesList"`
+	Module      *templateContextModule            `json:"Module"`
+	Release     *ReleasePlan                      `json:"Release"`
+}
+
+func moduleNames(mods Modules) []string {
+	names := make([]string, 0, len(mods))
+	for _, a := range mods {
+		names = append(names, a.Name())
+	}
+	return names
+}
+
+func newTemplateContextModule(a *Module) *templateContextModule {
+	properties := a.Properties()
+	if properties == nil {
+		properties = make(map[string]interface{})
+	}
+
+	owners := a.Owners()
+	if owners == nil {
+		owners = []string{}
+	}
+
+	return &templateContextModule{
+		Name:       a.Name(),
+		Path:       a.Path(),
+		Version:    a.Version(),
+		Hash:       a.Hash(),
+		Properties: properties,
+		Requires:   moduleNames(a.Requires()),
+		RequiredBy: moduleNames(a.RequiredBy()),
+		Owners:     owners,
+	}
+}
+
+func newTemplateContext(m *Manifest, mod *Module, options *ApplyOptions) ([]byte, error) {
+	c := &templateContext{
+		Sha:         m.Sha,
+		Environment: options.Environment,
+		Release:     options.Release,
+		Env:         getEnvMap(),
+		Modules:     make(map[string]*templateContextModule),
+		ModulesList: make([]*templateContextModule, 0, len(m.Modules)),
+	}
+
+	sorted := make(modulesByNameSorter, len(m.Modules))
+	copy(sorted, m.Modules)
+	sort.Sort(sorted)
+	for _, a := range sorted {
+		v := newTemplateContextModule(a)
+		c.Modules[a.Name()] = v
+		c.ModulesList = append(c.ModulesList, v)
+		if a == mod {
+			c.Module = v
+		}
+	}
+
+	buff, err := json.Marshal(c)
+	if err != nil {
+		return nil, e.Wrapf(ErrClassInternal, err, msgFailedTemplateContext)
+	}
+	return buff, nil
+}
+
+// stageTemplate writes the template and the partials to a temporary
+// directory since the template engines read them from files.
+// Partials are written to the lib sub directory.
+func stageTemplate(templatePath string, buffer []byte, partials []*partial, libDir string) (string, string, error) {
+	dir, err := ioutil.TempDir("", "mbt-apply")
+	if err != nil {
+		return "", "", e.Wrapf(ErrClassInternal, err, msgFailedStageTemplate)
+	}
+
+	write := func(p string, content []byte) error {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(p, content, 0644)
+	}
+
+	for _, p := range partials {
+		if err := write(filepath.Join(dir, libDir, filepath.FromSlash(p.name)), p.content); err != nil {
+			os.RemoveAll(dir)
+			return "", "", e.Wrapf(ErrClassInternal, err, msgFailedStageTemplate)
+		}
+	}
+
+	file := filepath.Join(dir, libDir, filepath.Base(templatePath))
+	if err := write(file, buffer); err != nil {
+		os.RemoveAll(dir)
+		return "", "", e.Wrapf(ErrClassInternal, err, msgFailedStageTemplate)
+	}
+
+	return dir, file, nil
+}
+
+// execTemplateEngine runs the executable of a template engine writing
+// its output to output.
+func execTemplateEngine(engine, dir string, output io.Writer, args ...string) error {
+	path, err := exec.LookPath(engine)
+	if err != nil {
+		return e.Wrapf(ErrClassUser, err, msgTemplateEngineNotFound, engine)
+	}
+
+	stderr := new(bytes.Buffer)
+	cmd := exec.Command(path, args...)
+	cmd.Dir = dir
+	cmd.Stdout = output
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return e.Wrapf(ErrClassUser, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String())), msgFailedTemplateEngine, engine)
+	}
+	return nil
+}
+
+// jsonnetTemplate evaluates a jsonnet program. Context is available
+// via std.extVar("mbt") and the partials can be imported by their
+// names.
+func jsonnetTemplate(templatePath string, buffer []byte, m *Manifest, mod *Module, partials []*partial, options *ApplyOptions, output io.Writer) error {
+	ctx, err := newTemplateContext(m, mod, options)
+	if err != nil {
+		return err
+	}
+
+	dir, file, err := stageTemplate(templatePath, buffer, partials, "lib")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	ctxFile := filepath.Join(dir, "context.json")
+	if err := ioutil.WriteFile(ctxFile, ctx, 0644); err != nil {
+		return e.Wrapf(ErrClassInternal, err, msgFailedStageTemplate)
+	}
+
+	return execTemplateEngine(TemplateEngineJsonnet, dir, output,
+		"--ext-code-file", "mbt="+ctxFile,
+		"--jpath", filepath.Join(dir, "lib"),
+		file)
+}
+
+// cueTemplate exports a cue configuration as json. Context is available
+// in the hidden field _mbt and the partials with .cue extension are
+// unified with the template.
+func cueTemplate(templatePath string, buffer []byte, m *Manifest, mod *Module, partials []*partial, options *ApplyOptions, output io.Writer) error {
+	ctx, err := newTemplateContext(m, mod, options)
+	if err != nil {
+		return err
+	}
+
+	dir, file, err := stageTemplate(templatePath, buffer, nil, "src")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// All files in a cue instance must belong to the same package.
+	var pkg string
+	if match := cuePackageClause.FindSubmatch(buffer); match != nil {
+		pkg = fmt.Sprintf("package %s\n\n", match[1])
+	}
+
+	files := []string{file}
+	ctxFile := filepath.Join(dir, "src", "mbt_context.cue")
+	if err := ioutil.WriteFile(ctxFile, []byte(pkg+"_mbt: "+string(ctx)+"\n"), 0644); err != nil {
+		return e.Wrapf(ErrClassInternal, err, msgFailedStageTemplate)
+	}
+	files = append(files, ctxFile)
+
+	for i, p := range partials {
+		if filepath.Ext(p.name) != ".cue" {
+			continue
+		}
+		f := filepath.Join(dir, "src", fmt.Sprintf("mbt_partial_%d_%s", i, filepath.Base(p.name)))
+		if err := ioutil.WriteFile(f, p.content, 0644); err != nil {
+			return e.Wrapf(ErrClassInternal, err, msgFailedStageTemplate)
+		}
+		files = append(files, f)
+	}
+
+	return execTemplateEngine(TemplateEngineCue, filepath.Join(dir, "src"), output, append([]string{"export", "--out", "json"}, files...)...)
+}
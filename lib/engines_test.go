@@ -0,0 +1,186 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+// withFakeEngine puts an executable with the specified script in
+// PATH in place of a template engine.
+func withFakeEngine(t *testing.T, name, script string) func() {
+	dir, err := filepath.Abs(".tmp/bin")
+	check(t, err)
+	check(t, os.MkdirAll(dir, 0755))
+	check(t, ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0755))
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return func() {
+		os.Setenv("PATH", path)
+	}
+}
+
+func initEngineRepo(t *testing.T) *TestRepository {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:       "app-a",
+		Properties: map[string]interface{}{"port": 80},
+	}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:         "app-b",
+		Dependencies: []string{"app-a"},
+		Owners:       []string{"team-b"},
+	}))
+	return repo
+}
+
+func TestJsonnetTemplate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	repo := initEngineRepo(t)
+	check(t, repo.WriteContent("deploy.jsonnet", `local lib = import "lib.libsonnet"; std.extVar("mbt")`))
+	check(t, repo.WriteContent(".mbt/partials/lib.libsonnet", `{}`))
+	check(t, repo.Commit("first"))
+
+	// Fake jsonnet prints the context, the library path and the program.
+	defer withFakeEngine(t, "jsonnet", `
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --ext-code-file) cat "${2#mbt=}"; echo; shift ;;
+    --jpath) cat "$2/lib.libsonnet"; echo; shift ;;
+    *) cat "$1" ;;
+  esac
+  shift
+done
+`)()
+
+	outputs := applyOutputs{}
+	options := outputs.options()
+	options.Filter = &FilterOptions{Name: "app-b"}
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyCommitWithOptions(repo.LastCommit.String(), "deploy.jsonnet", options))
+
+	lines := strings.Split(outputs["app-b"].String(), "\n")
+	assert.Len(t, lines, 3)
+	assert.Equal(t, "{}", lines[1])
+	assert.Equal(t, `local lib = import "lib.libsonnet"; std.extVar("mbt")`, lines[2])
+
+	ctx := &templateContext{}
+	check(t, json.Unmarshal([]byte(lines[0]), ctx))
+	assert.Equal(t, repo.LastCommit.String(), ctx.Sha)
+	assert.Equal(t, "app-b", ctx.Module.Name)
+	assert.Equal(t, []string{"app-a"}, ctx.Module.Requires)
+	assert.Equal(t, []string{"team-b"}, ctx.Module.Owners)
+	assert.Equal(t, []string{"app-b"}, ctx.Modules["app-a"].RequiredBy)
+	assert.Equal(t, float64(80), ctx.Modules["app-a"].Properties["port"])
+	assert.Equal(t, "app-a", ctx.ModulesList[0].Name)
+}
+
+func TestCueTemplate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	repo := initEngineRepo(t)
+	check(t, repo.WriteContent("deploy.cue", "package deploy\n\nname: _mbt.Module.Name\n"))
+	check(t, repo.WriteContent(".mbt/partials/defaults.cue", "package deploy\n\nreplicas: 1\n"))
+	check(t, repo.WriteContent(".mbt/partials/ignored.tmpl", "ignored"))
+	check(t, repo.WriteContent("local.tmpl", "{{.Module.Name}}"))
+	check(t, repo.Commit("first"))
+
+	// Fake cue prints its arguments and the files.
+	defer withFakeEngine(t, "cue", `
+echo "$1 $2 $3"
+shift 3
+for f in "$@"; do cat "$f"; done
+`)()
+
+	outputs := applyOutputs{}
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyLocalWithOptions("deploy.cue", outputs.options()))
+
+	output := outputs["app-a"].String()
+	assert.True(t, strings.HasPrefix(output, "export --out json\npackage deploy\n\nname: _mbt.Module.Name\npackage deploy\n\n_mbt: {"), output)
+	assert.Contains(t, output, `"Module":{"Name":"app-a"`)
+	assert.True(t, strings.HasSuffix(output, "}\npackage deploy\n\nreplicas: 1\n"), output)
+	assert.NotContains(t, output, "ignored")
+
+	// Engine specified in the options takes precedence.
+	outputs = applyOutputs{}
+	options := outputs.options()
+	options.Engine = TemplateEngineGo
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyLocalWithOptions("local.tmpl", options))
+	assert.Equal(t, "app-b", outputs["app-b"].String())
+}
+
+func TestTemplateEngineFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	repo := initEngineRepo(t)
+	check(t, repo.WriteContent("deploy.jsonnet", "{"))
+	check(t, repo.Commit("first"))
+
+	defer withFakeEngine(t, "jsonnet", "echo 'syntax error' >&2; exit 1")()
+
+	err := NewWorld(t, ".tmp/repo").System.ApplyHead("deploy.jsonnet", new(bytes.Buffer))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgFailedTemplateEngine, "jsonnet"))
+	assert.EqualError(t, err.(*e.E).InnerError(), "exit status 1: syntax error")
+	assert.Equal(t, ErrClassUser, err.(*e.E).Class())
+}
+
+func TestUnsupportedTemplateEngine(t *testing.T) {
+	repo := initEngineRepo(t)
+	check(t, repo.WriteContent("deploy.tmpl", ""))
+	check(t, repo.Commit("first"))
+
+	options := writerApplyOptions(new(bytes.Buffer))
+	options.Engine = "dhall"
+	err := NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("deploy.tmpl", options)
+
+	assert.EqualError(t, err, fmt.Sprintf(msgUnsupportedTemplateEngine, "dhall"))
+	assert.Equal(t, ErrClassUser, err.(*e.E).Class())
+}
+
+func TestTemplateEngineNotFound(t *testing.T) {
+	repo := initEngineRepo(t)
+	check(t, repo.WriteContent("deploy.cue", ""))
+	check(t, repo.Commit("first"))
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", "")
+	defer os.Setenv("PATH", path)
+
+	err := NewWorld(t, ".tmp/repo").System.ApplyHead("deploy.cue", new(bytes.Buffer))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgTemplateEngineNotFound, "cue"))
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const redacted = "***"
+
+// moduleEnvironment returns the environment variables configured for
+// a module along with the values of the ones marked as secret.
+// Variables are layered in the order of repository configuration,
+// module spec and the invocation (options.Env), the latter taking
+// precedence. ${VAR} references in repository and module variables are
+// expanded from the host environment, provided VAR is listed in the
+// hostEnv allowlist of the repository configuration.
+func moduleEnvironment(config *RepoConfig, mod *Module, options *CmdOptions) ([]string, []string, error) {
+	allowed := config.allowedHostEnv()
+
+	values := make(map[string]string)
+	layer := func(env map[string]string) error {
+		keys := make([]string, 0, len(env))
+		for k := range env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			v, notAllowed := expandHostEnv(allowed, env[k])
+			if notAllowed != "" {
+				return e.NewErrorf(ErrClassUser, msgHostEnvNotAllowed, notAllowed, k, mod.Name())
+			}
+			values[k] = v
+		}
+		return nil
+	}
+
+	if err := layer(config.Env); err != nil {
+		return nil, nil, err
+	}
+
+	if err := layer(mod.Env()); err != nil {
+		return nil, nil, err
+	}
+
+	for _, kv := range options.Env {
+		p := strings.SplitN(kv, "=", 2)
+		if len(p) == 2 {
+			values[p[0]] = p[1]
+		} else {
+			values[p[0]] = ""
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	env := make([]string, 0, len(keys))
+	for _, k := range keys {
+		env = append(env, k+"="+values[k])
+	}
+
+	secrets := make([]string, 0)
+	for _, k := range append(append([]string{}, config.Secrets...), mod.Secrets()...) {
+		if v, ok := values[k]; ok && v != "" {
+			secrets = append(secrets, v)
+		}
+	}
+
+	return env, append(secrets, options.Secrets...), nil
+}
+
+// allowedHostEnv returns the set of host environment variables that
+// can be referenced in the configuration.
+func (config *RepoConfig) allowedHostEnv() map[string]bool {
+	allowed := make(map[string]bool, len(config.HostEnv))
+	for _, k := range config.HostEnv {
+		allowed[k] = true
+	}
+	return allowed
+}
+
+// expandHostEnv expands the ${VAR} references in s from the host
+// environment. The name of the first variable not in the allowed set is
+// returned if there is one.
+func expandHostEnv(allowed map[string]bool, s string) (string, string) {
+	var notAllowed string
+	v := os.Expand(s, func(name string) string {
+		if !allowed[name] {
+			if notAllowed == "" {
+				notAllowed = name
+			}
+			return ""
+		}
+		return os.Getenv(name)
+	})
+	return v, notAllowed
+}
+
+// withModuleEnvironment returns a copy of options with the environment
+// configured for the module.
+func withModuleEnvironment(config *RepoConfig, mod *Module, options *CmdOptions) (*CmdOptions, error) {
+	env, secrets, err := moduleEnvironment(config, mod, options)
+	if err != nil {
+		return nil, err
+	}
+
+	o := *options
+	o.Env = env
+	o.Secrets = secrets
+	return &o, nil
+}
+
+// redact replaces the secrets in s.
+func redact(s string, secrets []string) string {
+	for _, secret := range secrets {
+		s = strings.Replace(s, secret, redacted, -1)
+	}
+	return s
+}
+
+// redactEnv replaces the values of environment variables (key=value)
+// containing any of the secrets.
+func redactEnv(env []string, secrets []string) []string {
+	r := make([]string, 0, len(env))
+	for _, kv := range env {
+		p := strings.SplitN(kv, "=", 2)
+		if len(p) == 2 && redact(p[1], secrets) != p[1] {
+			kv = p[0] + "=" + redacted
+		}
+		r = append(r, kv)
+	}
+	return r
+}
+
+// redactingWriter replaces the secrets in the content written to
+// the underlying writer.
+// Content is buffered until a line is complete so that secrets split
+// across multiple writes are detected. Remaining content is written
+// when the writer is closed.
+type redactingWriter struct {
+	w       io.Writer
+	secrets []string
+	pending []byte
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	i := bytes.LastIndexByte(w.pending, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+
+	lines := string(w.pending[:i+1])
+	w.pending = append(w.pending[:0], w.pending[i+1:]...)
+	if _, err := io.WriteString(w.w, redact(lines, w.secrets)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes the content of the incomplete line.
+func (w *redactingWriter) Close() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+
+	rest := string(w.pending)
+	w.pending = nil
+	_, err := io.WriteString(w.w, redact(rest, w.secrets))
+	return err
+}
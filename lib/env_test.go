@@ -0,0 +1,203 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvironmentLayering(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{
+		Env: map[string]string{"A": "repo", "B": "repo"},
+	}))
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Env:   map[string]string{"B": "module", "C": "module"},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo $A $B $C"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Env = []string{"C=invocation"}
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "repo module invocation\n", buff.String())
+}
+
+func TestEnvironmentInRunIn(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{
+		Env: map[string]string{"A": "repo"},
+	}))
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:     "app-a",
+		Commands: map[string]*UserCmd{"echo": {Cmd: "./echo.sh"}},
+	}))
+	check(t, repo.WriteShellScript("app-a/echo.sh", "echo $A"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.RunInCurrentBranch("echo", NoFilter, stdTestCmdOptions(buff))
+	check(t, err)
+
+	assert.Equal(t, "repo\n", buff.String())
+}
+
+func TestHostEnvironmentExpansion(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{
+		HostEnv: []string{"MBT_TEST_HOST_VAR"},
+	}))
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Env:   map[string]string{"A": "${MBT_TEST_HOST_VAR}-a"},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo $A"))
+	check(t, repo.Commit("first"))
+
+	os.Setenv("MBT_TEST_HOST_VAR", "host")
+	defer os.Unsetenv("MBT_TEST_HOST_VAR")
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+	check(t, err)
+
+	assert.Equal(t, "host-a\n", buff.String())
+}
+
+func TestHostEnvironmentNotInAllowlist(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Env:   map[string]string{"A": "${HOME}"},
+	}))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(nil))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgHostEnvNotAllowed, "HOME", "A", "app-a"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestSecretsAreRedactedFromOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{
+		Secrets: []string{"TOKEN"},
+	}))
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:    "app-a",
+		Build:   map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Env:     map[string]string{"PASSWORD": "hunter2"},
+		Secrets: []string{"PASSWORD"},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo token $TOKEN\necho password $PASSWORD >&2"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Env = []string{"TOKEN=s3cr3t"}
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "token ***\npassword ***\n", buff.String())
+}
+
+func TestSecretsAreRedactedFromPlan(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{
+		Env:     map[string]string{"TOKEN": "s3cr3t", "USER": "mbt"},
+		Secrets: []string{"TOKEN"},
+	}))
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	options := stdTestCmdOptions(nil)
+	options.Plan = true
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	env := summary.Plan.Groups[0][0].Env
+	assert.Contains(t, env, "TOKEN=***")
+	assert.Contains(t, env, "USER=mbt")
+}
+
+func TestSecretsSplitAcrossWritesAreRedacted(t *testing.T) {
+	buff := new(bytes.Buffer)
+	w := &redactingWriter{w: buff, secrets: []string{"s3cr3t"}}
+
+	_, err := w.Write([]byte("token s3c"))
+	check(t, err)
+	_, err = w.Write([]byte("r3t\nnext s3"))
+	check(t, err)
+	assert.Equal(t, "token ***\n", buff.String())
+
+	_, err = w.Write([]byte("cr3t"))
+	check(t, err)
+	check(t, w.Close())
+	assert.Equal(t, "token ***\nnext ***", buff.String())
+}
+
+func TestBuildEnvironmentIsSortedByProperty(t *testing.T) {
+	properties := map[string]interface{}{"zeta": "z", "alpha": "a", "mid": "m", "count": 1}
+	mod := newModule(newModuleMetadata("app-a", "a", &Spec{Name: "app-a", Properties: properties}, nil), nil)
+	m := &Manifest{Dir: "/repo", Sha: "sha", Modules: Modules{mod}}
+
+	for i := 0; i < 10; i++ {
+		env := buildEnvironment(m, mod)
+		assert.Equal(t, []string{"MBT_MODULE_PROPERTY_ALPHA=a", "MBT_MODULE_PROPERTY_MID=m", "MBT_MODULE_PROPERTY_ZETA=z"}, env[len(env)-3:])
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import "fmt"
+
+// environmentVar is the environment variable containing the name of
+// the active environment.
+const environmentVar = "MBT_ENVIRONMENT"
+
+// withEnvironment returns a copy of the manifest with the properties of
+// the specified environment merged over the properties of each module.
+// Modules without an overlay for the environment retain their properties.
+func (m *Manifest) withEnvironment(env string) (*Manifest, error) {
+	if env == "" {
+		return m, nil
+	}
+
+	modules, err := m.Modules.mapProperties(func(mod *Module) map[string]interface{} {
+		overlay, ok := mod.metadata.spec.Environments[env]
+		if !ok || overlay == nil {
+			return mod.Properties()
+		}
+
+		return mergeValues(mod.Properties(), overlay.Properties)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manifest{Dir: m.Dir, Sha: m.Sha, Modules: modules}, nil
+}
+
+// withEnvironmentOptions returns a copy of the options exposing the
+// active environment to the commands in MBT_ENVIRONMENT.
+func withEnvironmentOptions(options *CmdOptions) *CmdOptions {
+	if options.Environment == "" {
+		return options
+	}
+
+	c := *options
+	c.Env = append([]string{fmt.Sprintf("%s=%s", environmentVar, options.Environment)}, options.Env...)
+	return &c
+}
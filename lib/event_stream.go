@@ -0,0 +1,226 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	eventStreamTimeout = 10 * time.Second
+	// eventStreamBuffer is the number of events queued for an endpoint
+	// before new events are dropped, so that a slow endpoint does not
+	// slow down the build.
+	eventStreamBuffer = 1024
+	// eventStreamDrainTimeout is the time waited for the queued
+	// events to be delivered at the end of a run.
+	eventStreamDrainTimeout = 10 * time.Second
+)
+
+// EventStream is an HTTP endpoint receiving the lifecycle events of
+// builds and runs (i.e. run and module start and finish) as they happen.
+// Events are posted in batches as newline delimited json.
+type EventStream struct {
+	// URL of the endpoint. ${VAR} references are expanded from the host
+	// environment, provided VAR is listed in hostEnv.
+	URL string `yaml:"url"`
+	// Headers of the requests. Values are expanded like URL.
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+// eventPoster delivers the events to an endpoint in the background.
+type eventPoster struct {
+	log     Log
+	url     string
+	host    string
+	headers map[string]string
+	queue   chan *Event
+	done    chan struct{}
+	dropped int
+	mu      sync.Mutex
+}
+
+// withEventStreams returns a copy of the options delivering the
+// lifecycle events to the event streams in the repository config and
+// the options as well. Returned function must be invoked at the end of
+// the run to deliver the queued events.
+func (s *stdSystem) withEventStreams(config *RepoConfig, options *CmdOptions) (*CmdOptions, func(), error) {
+	streams := append(append([]*EventStream{}, config.EventStreams...), options.EventStreams...)
+	if len(streams) == 0 {
+		return options, func() {}, nil
+	}
+
+	allowed := config.allowedHostEnv()
+	posters := make([]*eventPoster, 0, len(streams))
+	for _, stream := range streams {
+		p, err := newEventPoster(s.Log, allowed, stream)
+		if err != nil {
+			return nil, nil, err
+		}
+		posters = append(posters, p)
+	}
+
+	for _, p := range posters {
+		go p.run()
+	}
+
+	next := options.Lifecycle
+	c := *options
+	c.Lifecycle = func(event *Event) {
+		if next != nil {
+			next(event)
+		}
+		for _, p := range posters {
+			p.enqueue(event)
+		}
+	}
+
+	return &c, func() {
+		deadline := time.After(eventStreamDrainTimeout)
+		for _, p := range posters {
+			close(p.queue)
+		}
+		for _, p := range posters {
+			select {
+			case <-p.done:
+			case <-deadline:
+				s.Log.Warnf(msgEventStreamTimeout, p.host)
+				return
+			}
+		}
+	}, nil
+}
+
+func newEventPoster(log Log, allowed map[string]bool, stream *EventStream) (*eventPoster, error) {
+	u, notAllowed := expandHostEnv(allowed, stream.URL)
+	if notAllowed != "" {
+		return nil, e.NewErrorf(ErrClassUser, msgHostEnvNotAllowedInEventStream, notAllowed)
+	}
+
+	// Only the host is logged since the urls often contain tokens.
+	pu, err := url.Parse(u)
+	if err != nil || pu.Host == "" {
+		return nil, e.NewErrorf(ErrClassUser, msgInvalidEventStreamURL)
+	}
+
+	headers := make(map[string]string, len(stream.Headers))
+	for k, v := range stream.Headers {
+		v, notAllowed := expandHostEnv(allowed, v)
+		if notAllowed != "" {
+			return nil, e.NewErrorf(ErrClassUser, msgHostEnvNotAllowedInEventStream, notAllowed)
+		}
+		headers[k] = v
+	}
+
+	return &eventPoster{
+		log:     log,
+		url:     u,
+		host:    pu.Host,
+		headers: headers,
+		queue:   make(chan *Event, eventStreamBuffer),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+func (p *eventPoster) enqueue(event *Event) {
+	select {
+	case p.queue <- event:
+	default:
+		p.mu.Lock()
+		p.dropped++
+		p.mu.Unlock()
+	}
+}
+
+// run posts the events queued while the previous request was in
+// flight in a single request.
+func (p *eventPoster) run() {
+	defer close(p.done)
+
+	failed := false
+	for event := range p.queue {
+		batch := []*Event{event}
+	drain:
+		for {
+			select {
+			case next, ok := <-p.queue:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+
+		// Endpoint is not retried after a failure, so that an
+		// unavailable dashboard does not delay the build.
+		if failed {
+			continue
+		}
+
+		if err := p.post(batch); err != nil {
+			p.log.Warnf(msgFailedPostEvents, p.host, err)
+			failed = true
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.dropped > 0 {
+		p.log.Warnf(msgDroppedEvents, p.dropped, p.host)
+	}
+}
+
+func (p *eventPoster) post(events []*Event) error {
+	body := new(bytes.Buffer)
+	encoder := json.NewEncoder(body)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.url, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for k, v := range p.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := (&http.Client{Timeout: eventStreamTimeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected response %s", res.Status)
+	}
+
+	return nil
+}
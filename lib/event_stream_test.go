@@ -0,0 +1,173 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type eventCollector struct {
+	mu     sync.Mutex
+	events []*Event
+	header http.Header
+}
+
+func (c *eventCollector) types() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	types := make([]string, 0, len(c.events))
+	for _, event := range c.events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func startEventStream(t *testing.T) (*httptest.Server, *eventCollector) {
+	c := &eventCollector{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.header = r.Header
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			event := &Event{}
+			check(t, json.Unmarshal(scanner.Bytes(), event))
+			c.events = append(c.events, event)
+		}
+	}))
+	return server, c
+}
+
+func initEventStreamRepo(t *testing.T, config *RepoConfig) *TestRepository {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(config))
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo building app-a"))
+	check(t, repo.Commit("first"))
+	return repo
+}
+
+func TestEventStreamOfBuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	server, c := startEventStream(t)
+	defer server.Close()
+
+	repo := initEventStreamRepo(t, &RepoConfig{
+		EventStreams: []*EventStream{{URL: server.URL, Headers: map[string]string{"X-Token": "t0ken"}}},
+	})
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+	check(t, err)
+
+	assert.Equal(t, []string{EventRunStart, EventModuleQueue, EventModuleStart, EventModuleFinish, EventRunFinish}, c.types())
+	assert.Equal(t, "application/x-ndjson", c.header.Get("Content-Type"))
+	assert.Equal(t, "t0ken", c.header.Get("X-Token"))
+
+	head, err := repo.Repo.Head()
+	check(t, err)
+	assert.Equal(t, "build", c.events[0].Command)
+	assert.Equal(t, head.Target().String(), c.events[0].Commit)
+	assert.Equal(t, "app-a", c.events[2].Module)
+	assert.Equal(t, "build", c.events[4].Command)
+	assert.Empty(t, c.events[4].Error)
+}
+
+func TestEventStreamOfFailedRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	server, c := startEventStream(t)
+	defer server.Close()
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:     "app-a",
+		Commands: map[string]*UserCmd{"lint": {Cmd: "exit 1", Shell: "sh"}},
+	}))
+	check(t, repo.Commit("first"))
+
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.EventStreams = []*EventStream{{URL: server.URL}}
+	result, err := NewWorld(t, ".tmp/repo").System.RunInCurrentBranch("lint", NoFilter, options)
+	check(t, err)
+	assert.Len(t, result.Failures, 1)
+
+	types := c.types()
+	assert.Equal(t, EventRunStart, types[0])
+	assert.Equal(t, EventRunFinish, types[len(types)-1])
+	assert.Equal(t, "lint", c.events[len(types)-1].Command)
+	assert.NotEmpty(t, c.events[len(types)-1].Error)
+}
+
+func TestLifecycleEventsExcludeOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initEventStreamRepo(t, &RepoConfig{})
+
+	var types []string
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Lifecycle = func(event *Event) {
+		types = append(types, event.Type)
+	}
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, []string{EventRunStart, EventModuleQueue, EventModuleStart, EventModuleFinish, EventRunFinish}, types)
+	assert.Contains(t, buff.String(), "building app-a")
+}
+
+func TestEventStreamFailuresAreIgnored(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	initEventStreamRepo(t, &RepoConfig{EventStreams: []*EventStream{{URL: server.URL}}})
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+	check(t, err)
+}
+
+func TestEventStreamWithHostEnvNotAllowed(t *testing.T) {
+	initEventStreamRepo(t, &RepoConfig{EventStreams: []*EventStream{{URL: "http://localhost/${HOME}"}}})
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+	assert.EqualError(t, err, "Event stream references host environment variable HOME which is not listed in hostEnv")
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"time"
+)
+
+const (
+	// EventRunStart is emitted before the modules of a build or a run of
+	// a user defined command are queued.
+	EventRunStart = "runStart"
+	// EventRunFinish is emitted after all modules are built or run.
+	EventRunFinish = "runFinish"
+	// EventModuleQueue is emitted when a module is queued for execution.
+	EventModuleQueue = "moduleQueue"
+	// EventModuleStart is emitted before executing the command of a module.
+	EventModuleStart = "moduleStart"
+	// EventModuleFinish is emitted after executing the command of a module.
+	EventModuleFinish = "moduleFinish"
+	// EventModuleSkip is emitted when a module is skipped.
+	EventModuleSkip = "moduleSkip"
+	// EventOutput is emitted for each chunk of output written by a command.
+	EventOutput = "output"
+)
+
+// Event is a structured record of an activity in a build or a run of
+// a user defined command.
+type Event struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	// Command and Commit are set for run events. Command is either
+	// build or the name of the user defined command.
+	Command string `json:"command,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+	Module  string `json:"module,omitempty"`
+	Version string `json:"version,omitempty"`
+	// Stream is either stdout or stderr for output events.
+	Stream string `json:"stream,omitempty"`
+	Data   string `json:"data,omitempty"`
+	// Elapsed is the duration of the command in seconds for finish events.
+	Elapsed float64 `json:"elapsed,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// EventHandler receives the events of a build.
+// Handlers are invoked concurrently when modules are built in parallel.
+type EventHandler func(event *Event)
+
+// emitEvent sends an event about a module to the handlers in options.
+func emitEvent(options *CmdOptions, kind string, mod *Module, started time.Time, err error) {
+	if options.Events == nil && options.Lifecycle == nil && options.Progress == nil {
+		return
+	}
+
+	event := &Event{Time: time.Now(), Type: kind, Module: mod.Name(), Version: mod.Version()}
+	if !started.IsZero() {
+		event.Elapsed = event.Time.Sub(started).Seconds()
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	deliverEvent(options, event)
+	if options.Progress != nil {
+		options.Progress(moduleProgress(event, mod, err))
+	}
+}
+
+// emitRunStart sends the event about the start of a run of command
+// in the modules of a manifest. Run events are only sent to
+// options.Lifecycle since Events receive the output of a run.
+func emitRunStart(options *CmdOptions, command string, m *Manifest) {
+	now := time.Now()
+	if options.Progress != nil {
+		options.Progress(&RunStarted{Time: now, Command: command, Manifest: m})
+	}
+	if options.Lifecycle == nil {
+		return
+	}
+	options.Lifecycle(&Event{Time: now, Type: EventRunStart, Command: command, Commit: m.Sha})
+}
+
+// emitRunFinish sends the event about the outcome of a run started at
+// started.
+func emitRunFinish(options *CmdOptions, summary *InvocationSummary, started time.Time) {
+	now := time.Now()
+	if options.Progress != nil {
+		options.Progress(&RunFinished{Time: now, Summary: summary, Elapsed: now.Sub(started)})
+	}
+	if options.Lifecycle == nil {
+		return
+	}
+	options.Lifecycle(&Event{
+		Time:    now,
+		Type:    EventRunFinish,
+		Command: summary.Command,
+		Commit:  summary.Commit,
+		Elapsed: now.Sub(started).Seconds(),
+		Error:   summary.Error,
+	})
+}
+
+func deliverEvent(options *CmdOptions, event *Event) {
+	if options.Events != nil {
+		options.Events(event)
+	}
+	if options.Lifecycle != nil {
+		options.Lifecycle(event)
+	}
+}
+
+// eventWriter delivers the output written to a stream of a module
+// as events.
+type eventWriter struct {
+	handler EventHandler
+	module  *Module
+	stream  string
+}
+
+func (w *eventWriter) Write(p []byte) (int, error) {
+	w.handler(&Event{
+		Time:    time.Now(),
+		Type:    EventOutput,
+		Module:  w.module.Name(),
+		Version: w.module.Version(),
+		Stream:  w.stream,
+		Data:    string(p),
+	})
+	return len(p), nil
+}
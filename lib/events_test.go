@@ -0,0 +1,158 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []*Event
+}
+
+func (r *eventRecorder) handle(event *Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *eventRecorder) kinds() []string {
+	l := make([]string, 0, len(r.events))
+	for _, e := range r.events {
+		l = append(l, e.Type+":"+e.Module)
+	}
+	return l
+}
+
+func (r *eventRecorder) output(stream string) string {
+	buff := new(bytes.Buffer)
+	for _, e := range r.events {
+		if e.Type == EventOutput && e.Stream == stream {
+			buff.WriteString(e.Data)
+		}
+	}
+	return buff.String()
+}
+
+func TestBuildEvents(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo out\necho err >&2"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:  "app-b",
+		Build: map[string]*Cmd{"unknown-os": {Cmd: "echo", Args: []string{}}},
+	}))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	recorder := &eventRecorder{}
+	options := stdTestCmdOptions(buff)
+	options.Events = recorder.handle
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "", buff.String())
+	assert.Equal(t, []string{
+		"moduleQueue:app-a",
+		"moduleQueue:app-b",
+		"moduleStart:app-a",
+		"output:app-a",
+		"output:app-a",
+		"moduleFinish:app-a",
+		"moduleSkip:app-b",
+	}, recorder.kinds())
+
+	// Streams are read independently, therefore their relative order
+	// is not deterministic.
+	assert.Equal(t, "out\n", recorder.output("stdout"))
+	assert.Equal(t, "err\n", recorder.output("stderr"))
+	assert.NotEmpty(t, recorder.events[5].Version)
+	assert.True(t, recorder.events[5].Elapsed > 0)
+	assert.Empty(t, recorder.events[5].Error)
+}
+
+func TestBuildEventsOfFailedBuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "exit 1"))
+	check(t, repo.Commit("first"))
+
+	recorder := &eventRecorder{}
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.Events = recorder.handle
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+
+	assert.Error(t, err)
+	assert.Equal(t, []string{"moduleQueue:app-a", "moduleStart:app-a", "moduleFinish:app-a"}, recorder.kinds())
+	assert.Equal(t, "Failed to build module 'app-a'", recorder.events[2].Error)
+}
+
+func TestRunInEvents(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:     "app-a",
+		Commands: map[string]*UserCmd{"echo": {Cmd: "./echo.sh"}},
+	}))
+	check(t, repo.WriteShellScript("app-a/echo.sh", "echo hello"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.Commit("first"))
+
+	recorder := &eventRecorder{}
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.Events = recorder.handle
+	_, err := NewWorld(t, ".tmp/repo").System.RunInCurrentBranch("echo", NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, []string{
+		"moduleQueue:app-a",
+		"moduleQueue:app-b",
+		"moduleStart:app-a",
+		"output:app-a",
+		"moduleFinish:app-a",
+		"moduleSkip:app-b",
+	}, recorder.kinds())
+	assert.Equal(t, "hello\n", recorder.events[3].Data)
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"os/exec"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// Exit codes of mbt.
+// They allow the callers (e.g. CI pipelines) to tell the reason of a
+// failure without inspecting the output.
+const (
+	// ExitCodeSuccess indicates that the command succeeded.
+	ExitCodeSuccess = 0
+	// ExitCodeError indicates a failure not covered by the codes below
+	// (e.g. invalid arguments or an unexpected error).
+	ExitCodeError = 1
+	// ExitCodeNothingToBuild indicates that the command succeeded but
+	// there were no modules to build or run the command in.
+	ExitCodeNothingToBuild = 2
+	// ExitCodeBuildFailure indicates that the command of one or more
+	// modules failed.
+	ExitCodeBuildFailure = 3
+	// ExitCodeConfigError indicates an invalid module spec or repository
+	// configuration (e.g. a parse error or a cyclic dependency).
+	ExitCodeConfigError = 4
+	// ExitCodeCancelled indicates that the command was stopped by a signal
+	// (e.g. SIGINT or SIGTERM) before completion.
+	ExitCodeCancelled = 130
+)
+
+// ExitCode returns the exit code for an error returned by mbt.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitCodeSuccess
+	}
+
+	if ee, ok := err.(*e.E); ok && ee.Code() != 0 {
+		return ee.Code()
+	}
+
+	for err != nil {
+		switch x := err.(type) {
+		case *exec.ExitError, *exec.Error:
+			return ExitCodeBuildFailure
+		case *e.E:
+			if x.Code() != 0 {
+				return x.Code()
+			}
+			err = x.InnerError()
+		default:
+			return ExitCodeError
+		}
+	}
+
+	return ExitCodeError
+}
+
+// configError marks an error as an error in the configuration of
+// modules or the repository.
+func configError(err *e.E) *e.E {
+	return err.WithCode(ExitCodeConfigError)
+}
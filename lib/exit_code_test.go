@@ -0,0 +1,136 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCodeOfSuccess(t *testing.T) {
+	assert.Equal(t, ExitCodeSuccess, ExitCode(nil))
+}
+
+func TestExitCodeOfUnclassifiedError(t *testing.T) {
+	assert.Equal(t, ExitCodeError, ExitCode(errors.New("doh")))
+	assert.Equal(t, ExitCodeError, ExitCode(e.NewError(ErrClassUser, "doh")))
+	assert.Equal(t, ExitCodeError, ExitCode(e.Wrap(ErrClassInternal, errors.New("doh"))))
+}
+
+func TestExitCodeOfBuildFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "exit 1"))
+	check(t, repo.Commit("first"))
+
+	file := filepath.Join(".tmp", "summary.json")
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.SummaryFile = file
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+
+	assert.Equal(t, ExitCodeBuildFailure, ExitCode(err))
+	assert.Equal(t, ExitCodeBuildFailure, readInvocationSummary(t, file).ExitCode)
+}
+
+func TestExitCodeOfFailedRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:     "app-a",
+		Commands: map[string]*UserCmd{"test": {Cmd: "./test.sh"}},
+	}))
+	check(t, repo.WriteShellScript("app-a/test.sh", "exit 1"))
+	check(t, repo.Commit("first"))
+
+	file := filepath.Join(".tmp", "summary.json")
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.SummaryFile = file
+	result, err := NewWorld(t, ".tmp/repo").System.RunInCurrentBranch("test", NoFilter, options)
+	check(t, err)
+
+	assert.Len(t, result.Failures, 1)
+	assert.Equal(t, ExitCodeBuildFailure, readInvocationSummary(t, file).ExitCode)
+}
+
+func TestExitCodeOfNothingToBuild(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	file := filepath.Join(".tmp", "summary.json")
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.SummaryFile = file
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(ExactMatchFilter("app-b"), options)
+	check(t, err)
+
+	summary := readInvocationSummary(t, file)
+	assert.True(t, summary.Success)
+	assert.Equal(t, ExitCodeNothingToBuild, summary.ExitCode)
+}
+
+func TestExitCodeOfCyclicDependency(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Dependencies: []string{"app-b"}}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Dependencies: []string{"app-a"}}))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+
+	assert.Equal(t, ExitCodeConfigError, ExitCode(err))
+	assert.Equal(t, ErrClassUser, err.(*e.E).Class())
+}
+
+func TestExitCodeOfInvalidSpec(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteContent("app-a/.mbt.yml", "blah:blah\nblah::"))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+
+	assert.Equal(t, ExitCodeConfigError, ExitCode(err))
+}
+
+func TestExitCodeOfMissingDependency(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Dependencies: []string{"app-b"}}))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+
+	assert.Equal(t, ExitCodeConfigError, ExitCode(err))
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+const (
+	fingerprintsFile = "fingerprints.json"
+	// fingerprintHistory is the number of fingerprints recorded for
+	// each module. Older versions are forgotten.
+	fingerprintHistory = 100
+)
+
+// Fingerprint is a command writing the interface of a module (e.g. the
+// hashes of its exported API) to stdout. Fingerprint of a module is the
+// hash of the output, recorded for each version after a successful
+// build.
+// Modules depending on the module are not built again if the
+// fingerprint did not change since they were last built.
+type Fingerprint struct {
+	Cmd  string   `yaml:"cmd"`
+	Args []string `yaml:"args,omitempty,flow"`
+	// Dir is the working directory of the command relative to the
+	// module directory. Defaults to the module directory.
+	Dir string `yaml:"dir,omitempty"`
+	// Shell used to interpret Cmd (see Cmd.Shell).
+	Shell string `yaml:"shell,omitempty"`
+}
+
+// fingerprints is a persistent record of the fingerprints of module
+// versions and the interfaces of the dependencies each module was last
+// built with.
+type fingerprints struct {
+	Modules map[string]*moduleFingerprints `json:"modules"`
+
+	mu     sync.Mutex
+	system *stdSystem
+	// current are the fingerprints of the modules built or reused in
+	// this build.
+	current map[string]string
+	// keys are the interface keys of the modules in this build.
+	keys map[string]*interfaceKey
+}
+
+type moduleFingerprints struct {
+	// Versions are the fingerprints of the versions of the module,
+	// most recent last.
+	Versions []*versionFingerprint `json:"versions,omitempty"`
+	// Version and Key are the version and the interface key of the
+	// last successful build of the module.
+	Version string `json:"version,omitempty"`
+	Key     string `json:"key,omitempty"`
+}
+
+type versionFingerprint struct {
+	Version     string `json:"version"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// interfaceKey identifies the content of a module and the interfaces of
+// its dependencies. It is like the version of the module, except it is
+// derived from the fingerprints of the dependencies (when available)
+// rather than their versions.
+// Cutoff is set when the key depends on any fingerprint.
+type interfaceKey struct {
+	key    string
+	cutoff bool
+}
+
+func (s *stdSystem) openFingerprints() (*fingerprints, error) {
+	f := &fingerprints{system: s, current: make(map[string]string), keys: make(map[string]*interfaceKey)}
+	err := s.readState(fingerprintsFile, f)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Modules == nil {
+		f.Modules = make(map[string]*moduleFingerprints)
+	}
+
+	return f, nil
+}
+
+// unaffected informs if a module can be skipped because neither its
+// content nor the interfaces of its dependencies changed since its last
+// successful build.
+// Modules built at the same version are never skipped, so that
+// building the same version again behaves as it would without
+// fingerprints.
+func (f *fingerprints) unaffected(mod *Module) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	k := f.key(mod)
+	m, ok := f.Modules[mod.Name()]
+	if !ok || !k.cutoff || m.Key != k.key || m.Version == mod.Version() {
+		return false
+	}
+
+	// Interface of a skipped module is the same as its last build.
+	if fp := m.fingerprint(m.Version); fp != "" && mod.Fingerprint() != nil {
+		f.current[mod.Name()] = fp
+	}
+
+	return true
+}
+
+// record stores the fingerprint of a successful build of a module
+// along with its interface key and persists the fingerprints.
+// fingerprint is empty if the module does not declare a fingerprint or
+// it could not be computed.
+func (f *fingerprints) record(mod *Module, fingerprint string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	m, ok := f.Modules[mod.Name()]
+	if !ok {
+		m = &moduleFingerprints{}
+		f.Modules[mod.Name()] = m
+	}
+
+	k := f.key(mod)
+	m.Version, m.Key = mod.Version(), k.key
+
+	if fingerprint != "" {
+		f.current[mod.Name()] = fingerprint
+		// Local versions do not reflect the content of modules, therefore
+		// their fingerprints are not reusable.
+		if mod.Version() != "local" {
+			m.add(mod.Version(), fingerprint)
+		}
+	}
+
+	return f.system.writeState(fingerprintsFile, f)
+}
+
+// key returns the interface key of a module. Dependencies of the
+// module must be processed before calling this.
+// Caller must hold the lock.
+func (f *fingerprints) key(mod *Module) *interfaceKey {
+	if k, ok := f.keys[mod.Name()]; ok {
+		return k
+	}
+
+	k := &interfaceKey{}
+	if mod.Hash() != "local" {
+		cutoff := false
+		h := sha1.New()
+		io.WriteString(h, mod.Hash())
+		for _, r := range mod.Requires() {
+			if fp := f.fingerprint(r); fp != "" {
+				io.WriteString(h, "fingerprint:"+fp)
+				cutoff = true
+				continue
+			}
+
+			rk := f.key(r)
+			if rk.key == "" {
+				cutoff = false
+				break
+			}
+			io.WriteString(h, "key:"+rk.key)
+			cutoff = cutoff || rk.cutoff
+		}
+
+		for _, p := range mod.FileDependencies() {
+			io.WriteString(h, mod.metadata.dependentFileHashes[p])
+		}
+
+		if cutoff {
+			k.key, k.cutoff = hex.EncodeToString(h.Sum(nil)), true
+		} else {
+			// Without fingerprints, key changes along with the version.
+			k.key = mod.Version()
+		}
+	}
+
+	f.keys[mod.Name()] = k
+	return k
+}
+
+// fingerprint returns the fingerprint of the current version of a
+// module or an empty string if it is not known.
+// Caller must hold the lock.
+func (f *fingerprints) fingerprint(mod *Module) string {
+	if mod.Fingerprint() == nil {
+		return ""
+	}
+
+	if fp, ok := f.current[mod.Name()]; ok {
+		return fp
+	}
+
+	if m, ok := f.Modules[mod.Name()]; ok && mod.Version() != "local" {
+		return m.fingerprint(mod.Version())
+	}
+
+	return ""
+}
+
+func (m *moduleFingerprints) fingerprint(version string) string {
+	for i := len(m.Versions) - 1; i >= 0; i-- {
+		if m.Versions[i].Version == version {
+			return m.Versions[i].Fingerprint
+		}
+	}
+	return ""
+}
+
+func (m *moduleFingerprints) add(version, fingerprint string) {
+	versions := make([]*versionFingerprint, 0, len(m.Versions)+1)
+	for _, v := range m.Versions {
+		if v.Version != version {
+			versions = append(versions, v)
+		}
+	}
+
+	versions = append(versions, &versionFingerprint{Version: version, Fingerprint: fingerprint})
+	if len(versions) > fingerprintHistory {
+		versions = versions[len(versions)-fingerprintHistory:]
+	}
+	m.Versions = versions
+}
+
+// fingerprint executes the fingerprint command of a module and returns
+// the hash of its output.
+// Dependents of the module are built if the fingerprint cannot be
+// computed, therefore failures are logged rather than failing the build.
+func (s *stdSystem) fingerprint(config *RepoConfig, m *Manifest, a *Module, options *CmdOptions) (string, error) {
+	fp := a.Fingerprint()
+	if fp == nil {
+		return "", nil
+	}
+
+	options, err := withModuleEnvironment(config, a, options)
+	if err != nil {
+		return "", err
+	}
+	options = withReleaseOptions(options, a)
+
+	buff := new(bytes.Buffer)
+	o := *options
+	o.Stdout = buff
+	o.Stderr = ioutil.Discard
+	o.Stdin = nil
+	err = s.execSpecCmd(m, a, &o, fp.Shell, fp.Dir, fp.Cmd, fp.Args)
+	if err != nil {
+		s.Log.Warnf(msgFailedFingerprint, a.Name(), err)
+		return "", nil
+	}
+
+	h := sha1.Sum(buff.Bytes())
+	return hex.EncodeToString(h[:]), nil
+}
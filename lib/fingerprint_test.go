@@ -0,0 +1,179 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// initFingerprintedRepo creates a repository where app-b depends on
+// lib-a and app-c depends on app-b. Fingerprint of lib-a is the content
+// of its api file.
+func initFingerprintedRepo(t *testing.T) *TestRepository {
+	repo := NewTestRepo(t, ".tmp/repo")
+	build := map[string]*Cmd{
+		"darwin": {Cmd: "./build.sh"},
+		"linux":  {Cmd: "./build.sh"},
+	}
+
+	check(t, repo.InitModuleWithOptions("lib-a", &Spec{
+		Name:        "lib-a",
+		Build:       build,
+		Fingerprint: &Fingerprint{Cmd: "cat", Args: []string{"api"}},
+	}))
+	check(t, repo.WriteContent("lib-a/api", "v1"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Build: build, Dependencies: []string{"lib-a"}}))
+	check(t, repo.InitModuleWithOptions("app-c", &Spec{Name: "app-c", Build: build, Dependencies: []string{"app-b"}}))
+	for _, n := range []string{"lib-a", "app-b", "app-c"} {
+		check(t, repo.WriteShellScript(n+"/build.sh", "echo built "+n))
+	}
+	check(t, repo.Commit("first"))
+	return repo
+}
+
+func buildFingerprintedRepo(t *testing.T, options *CmdOptions) (*BuildSummary, map[string]string) {
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	status := make(map[string]string)
+	for _, m := range summary.InvocationSummary(nil).Modules {
+		status[m.Name] = m.Status
+	}
+	return summary, status
+}
+
+func TestBuildSkipsModulesWithUnchangedDependencyFingerprints(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := initFingerprintedRepo(t)
+
+	buff := new(bytes.Buffer)
+	_, status := buildFingerprintedRepo(t, stdTestCmdOptions(buff))
+	assert.Equal(t, map[string]string{"lib-a": ModuleStatusSucceeded, "app-b": ModuleStatusSucceeded, "app-c": ModuleStatusSucceeded}, status)
+
+	// Internal change of lib-a.
+	check(t, repo.WriteContent("lib-a/impl", "a"))
+	check(t, repo.Commit("second"))
+
+	buff.Reset()
+	summary, status := buildFingerprintedRepo(t, stdTestCmdOptions(buff))
+	assert.Equal(t, "built lib-a\n", buff.String())
+	assert.Equal(t, map[string]string{"lib-a": ModuleStatusSucceeded, "app-b": ModuleStatusUnaffected, "app-c": ModuleStatusUnaffected}, status)
+	for _, r := range summary.Completed {
+		assert.Equal(t, r.Module.Name() != "lib-a", r.Unaffected)
+	}
+
+	// Change of the interface of lib-a.
+	check(t, repo.WriteContent("lib-a/api", "v2"))
+	check(t, repo.Commit("third"))
+
+	buff.Reset()
+	_, status = buildFingerprintedRepo(t, stdTestCmdOptions(buff))
+	assert.Equal(t, "built lib-a\nbuilt app-b\nbuilt app-c\n", buff.String())
+	assert.Equal(t, ModuleStatusSucceeded, status["app-c"])
+}
+
+func TestBuildOfChangedDependentWithUnchangedFingerprints(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := initFingerprintedRepo(t)
+	buildFingerprintedRepo(t, stdTestCmdOptions(nil))
+
+	check(t, repo.WriteContent("lib-a/impl", "a"))
+	check(t, repo.WriteContent("app-b/impl", "a"))
+	check(t, repo.Commit("second"))
+
+	buff := new(bytes.Buffer)
+	_, status := buildFingerprintedRepo(t, stdTestCmdOptions(buff))
+	assert.Equal(t, "built lib-a\nbuilt app-b\nbuilt app-c\n", buff.String())
+	assert.Equal(t, ModuleStatusSucceeded, status["app-b"])
+}
+
+func TestBuildOfSameVersionWithFingerprints(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	initFingerprintedRepo(t)
+	buildFingerprintedRepo(t, stdTestCmdOptions(nil))
+
+	buff := new(bytes.Buffer)
+	buildFingerprintedRepo(t, stdTestCmdOptions(buff))
+	assert.Equal(t, "built lib-a\nbuilt app-b\nbuilt app-c\n", buff.String())
+}
+
+func TestBuildIgnoringFingerprints(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := initFingerprintedRepo(t)
+	buildFingerprintedRepo(t, stdTestCmdOptions(nil))
+
+	check(t, repo.WriteContent("lib-a/impl", "a"))
+	check(t, repo.Commit("second"))
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.IgnoreFingerprints = true
+	_, status := buildFingerprintedRepo(t, options)
+	assert.Equal(t, "built lib-a\nbuilt app-b\nbuilt app-c\n", buff.String())
+	assert.Equal(t, ModuleStatusSucceeded, status["app-b"])
+}
+
+func TestBuildWhenFingerprintFails(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := initFingerprintedRepo(t)
+	buildFingerprintedRepo(t, stdTestCmdOptions(nil))
+
+	check(t, repo.WriteContent("lib-a/impl", "a"))
+	check(t, repo.Remove("lib-a/api"))
+	check(t, repo.Commit("second"))
+
+	buff := new(bytes.Buffer)
+	_, status := buildFingerprintedRepo(t, stdTestCmdOptions(buff))
+	assert.Equal(t, "built lib-a\nbuilt app-b\nbuilt app-c\n", buff.String())
+	assert.Equal(t, ModuleStatusSucceeded, status["app-b"])
+}
+
+func TestFingerprintHistory(t *testing.T) {
+	m := &moduleFingerprints{}
+	for i := 0; i < fingerprintHistory+1; i++ {
+		m.add(string(rune('a'+i%26))+string(rune('0'+i/26)), "fp")
+	}
+	m.add("b0", "updated")
+
+	assert.Len(t, m.Versions, fingerprintHistory)
+	assert.Equal(t, "updated", m.fingerprint("b0"))
+	assert.Equal(t, "b0", m.Versions[len(m.Versions)-1].Version)
+	assert.Equal(t, "", m.fingerprint("a0"))
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yaml "github.com/go-yaml/yaml"
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// GeneratorHelm generates a helm chart for each module.
+	GeneratorHelm = "helm"
+	// GeneratorKustomize generates a kustomize overlay for each module.
+	GeneratorKustomize = "kustomize"
+)
+
+// generatedResourcesFile is the name of the file containing the output
+// of templates without a yaml extension.
+const generatedResourcesFile = "resources.yaml"
+
+type helmChart struct {
+	APIVersion  string `yaml:"apiVersion"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Type        string `yaml:"type"`
+	Version     string `yaml:"version"`
+	AppVersion  string `yaml:"appVersion"`
+}
+
+type kustomization struct {
+	APIVersion         string                `yaml:"apiVersion"`
+	Kind               string                `yaml:"kind"`
+	Resources          []string              `yaml:"resources"`
+	Labels             []*kustomizeLabels    `yaml:"labels"`
+	ConfigMapGenerator []*kustomizeConfigMap `yaml:"configMapGenerator,omitempty"`
+}
+
+type kustomizeLabels struct {
+	Pairs map[string]string `yaml:"pairs"`
+}
+
+type kustomizeConfigMap struct {
+	Name     string   `yaml:"name"`
+	Literals []string `yaml:"literals"`
+}
+
+// GeneratorOutput creates the output function for ApplyOptions which
+// writes a helm chart or a kustomize overlay for each module into a
+// directory in dir named by the module. Output of the template is the
+// resources of the chart or the overlay.
+func GeneratorOutput(generator, dir, templatePath string) (func(mod *Module) (io.WriteCloser, error), error) {
+	var generate func(mod *Module, file string, resources []byte) (map[string][]byte, error)
+	switch generator {
+	case GeneratorHelm:
+		generate = generateHelmChart
+	case GeneratorKustomize:
+		generate = generateKustomization
+	default:
+		return nil, e.NewErrorf(ErrClassUser, msgUnsupportedGenerator, generator)
+	}
+
+	file := generatedFileName(templatePath)
+	return func(mod *Module) (io.WriteCloser, error) {
+		if mod == nil {
+			return nil, e.NewErrorf(ErrClassUser, msgGeneratorRequiresModule, generator)
+		}
+
+		return &generatorWriter{dir: filepath.Join(dir, mod.Name()), file: file, mod: mod, generate: generate}, nil
+	}, nil
+}
+
+// generatedFileName is the name of the template without .tmpl if it is
+// a yaml file or generatedResourcesFile otherwise.
+func generatedFileName(templatePath string) string {
+	name := strings.TrimSuffix(filepath.Base(templatePath), ".tmpl")
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml":
+		return name
+	default:
+		return generatedResourcesFile
+	}
+}
+
+// generatorWriter buffers the output of a module and generates the
+// files when it is closed.
+type generatorWriter struct {
+	bytes.Buffer
+	dir      string
+	file     string
+	mod      *Module
+	generate func(mod *Module, file string, resources []byte) (map[string][]byte, error)
+}
+
+func (w *generatorWriter) Close() error {
+	files, err := w.generate(w.mod, w.file, w.Bytes())
+	if err != nil {
+		return err
+	}
+
+	for name, content := range files {
+		p := filepath.Join(w.dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return e.Wrap(ErrClassInternal, err)
+		}
+
+		if err := ioutil.WriteFile(p, content, 0644); err != nil {
+			return e.Wrap(ErrClassInternal, err)
+		}
+	}
+
+	return nil
+}
+
+// generateHelmChart creates a chart with the properties of the module
+// as its values. Chart version is a pre-release semantic version so
+// that each version of a module has a distinct chart version.
+func generateHelmChart(mod *Module, file string, resources []byte) (map[string][]byte, error) {
+	chart, err := yaml.Marshal(&helmChart{
+		APIVersion:  "v2",
+		Name:        mod.Name(),
+		Description: fmt.Sprintf("Generated by mbt for module %s", mod.Name()),
+		Type:        "application",
+		Version:     fmt.Sprintf("0.0.0-%s", mod.Version()),
+		AppVersion:  mod.Version(),
+	})
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	values, err := yaml.Marshal(mod.Properties())
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedGenerateValues, mod.Name())
+	}
+
+	return map[string][]byte{
+		"Chart.yaml":        chart,
+		"values.yaml":       values,
+		"templates/" + file: resources,
+	}, nil
+}
+
+// generateKustomization creates an overlay labelling the resources with
+// the name of the module. Scalar properties of the module are available
+// in a config map named <module>-properties.
+func generateKustomization(mod *Module, file string, resources []byte) (map[string][]byte, error) {
+	k := &kustomization{
+		APIVersion: "kustomize.config.k8s.io/v1beta1",
+		Kind:       "Kustomization",
+		Resources:  []string{file},
+		Labels:     []*kustomizeLabels{{Pairs: map[string]string{"app.kubernetes.io/name": mod.Name()}}},
+	}
+
+	var literals []string
+	for key, v := range mod.Properties() {
+		switch v.(type) {
+		case string, bool, int, int64, float64:
+			literals = append(literals, fmt.Sprintf("%s=%v", key, v))
+		}
+	}
+
+	if len(literals) > 0 {
+		sort.Strings(literals)
+		k.ConfigMapGenerator = []*kustomizeConfigMap{{Name: mod.Name() + "-properties", Literals: literals}}
+	}
+
+	overlay, err := yaml.Marshal(k)
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	return map[string][]byte{
+		"kustomization.yaml": overlay,
+		file:                 resources,
+	}, nil
+}
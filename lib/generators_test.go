@@ -0,0 +1,107 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readGenerated(t *testing.T, p string) string {
+	b, err := ioutil.ReadFile(p)
+	check(t, err)
+	return string(b)
+}
+
+func TestGenerateHelmChart(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Properties: map[string]interface{}{"replicas": 2, "image": map[string]interface{}{"tag": "1.0"}}}))
+	check(t, repo.WriteContent("deploy.yaml.tmpl", `name: {{.Module.Name}}`))
+	check(t, repo.Commit("first"))
+
+	output, err := GeneratorOutput(GeneratorHelm, ".tmp/out", "deploy.yaml.tmpl")
+	check(t, err)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("deploy.yaml.tmpl", &ApplyOptions{SplitPerModule: true, Output: output}))
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+	version := m.Modules[0].Version()
+
+	assert.Equal(t, fmt.Sprintf(`apiVersion: v2
+name: app-a
+description: Generated by mbt for module app-a
+type: application
+version: 0.0.0-%s
+appVersion: %s
+`, version, version), readGenerated(t, ".tmp/out/app-a/Chart.yaml"))
+	assert.Equal(t, "image:\n  tag: \"1.0\"\nreplicas: 2\n", readGenerated(t, ".tmp/out/app-a/values.yaml"))
+	assert.Equal(t, "name: app-a", readGenerated(t, ".tmp/out/app-a/templates/deploy.yaml"))
+}
+
+func TestGenerateKustomization(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Properties: map[string]interface{}{"replicas": 2, "region": "us", "tags": []interface{}{"a"}}}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b"}))
+	check(t, repo.WriteContent("deploy.tmpl", `name: {{.Module.Name}}`))
+	check(t, repo.Commit("first"))
+
+	output, err := GeneratorOutput(GeneratorKustomize, ".tmp/out", "deploy.tmpl")
+	check(t, err)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("deploy.tmpl", &ApplyOptions{SplitPerModule: true, Output: output}))
+
+	assert.Equal(t, `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+- resources.yaml
+labels:
+- pairs:
+    app.kubernetes.io/name: app-a
+configMapGenerator:
+- name: app-a-properties
+  literals:
+  - region=us
+  - replicas=2
+`, readGenerated(t, ".tmp/out/app-a/kustomization.yaml"))
+	assert.Equal(t, "name: app-a", readGenerated(t, ".tmp/out/app-a/resources.yaml"))
+	assert.NotContains(t, readGenerated(t, ".tmp/out/app-b/kustomization.yaml"), "configMapGenerator")
+}
+
+func TestGeneratorRequiresModule(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent("deploy.tmpl", `name`))
+	check(t, repo.Commit("first"))
+
+	output, err := GeneratorOutput(GeneratorHelm, ".tmp/out", "deploy.tmpl")
+	check(t, err)
+	err = NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("deploy.tmpl", &ApplyOptions{Output: output})
+
+	assert.EqualError(t, err, fmt.Sprintf(msgGeneratorRequiresModule, GeneratorHelm))
+}
+
+func TestUnsupportedGenerator(t *testing.T) {
+	_, err := GeneratorOutput("jsonnet", ".tmp/out", "deploy.tmpl")
+
+	assert.EqualError(t, err, fmt.Sprintf(msgUnsupportedGenerator, "jsonnet"))
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// HookPreCommit is the git hook run before a commit is created.
+	HookPreCommit = "pre-commit"
+	// HookPrePush is the git hook run before the refs are pushed.
+	HookPrePush = "pre-push"
+)
+
+// hookMarker identifies the hooks installed by mbt, so that they can be
+// reinstalled without --force.
+const hookMarker = "# Installed by mbt install-hooks."
+
+// zeroSha is the object id git uses for a ref that does not exist.
+const zeroSha = "0000000000000000000000000000000000000000"
+
+// HookOptions specifies the git hooks to install.
+type HookOptions struct {
+	// Hooks to install (pre-commit and/or pre-push).
+	Hooks []string
+	// Command to run in the modules affected by the change
+	// (e.g. a quick lint). Modules are only validated if it is empty.
+	Command string
+	// Force replaces the existing hooks not installed by mbt.
+	Force bool
+}
+
+// PushUpdate is a ref being pushed as reported to the pre-push hook.
+type PushUpdate struct {
+	LocalRef  string
+	LocalSha  string
+	RemoteRef string
+	RemoteSha string
+}
+
+// IsDelete informs if the update deletes the remote ref.
+func (u *PushUpdate) IsDelete() bool {
+	return u.LocalSha == zeroSha
+}
+
+// IsNew informs if the update creates the remote ref.
+func (u *PushUpdate) IsNew() bool {
+	return u.RemoteSha == zeroSha
+}
+
+func (s *stdSystem) InstallHooks(options *HookOptions) ([]string, error) {
+	hooks := options.Hooks
+	if len(hooks) == 0 {
+		hooks = []string{HookPreCommit}
+	}
+
+	for _, h := range hooks {
+		if h != HookPreCommit && h != HookPrePush {
+			return nil, e.NewErrorf(ErrClassUser, msgUnsupportedHook, h)
+		}
+	}
+
+	dir, err := s.Repo.HooksDir()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	installed := make([]string, 0, len(hooks))
+	for _, h := range hooks {
+		p := filepath.Join(dir, h)
+		if buff, err := ioutil.ReadFile(p); err == nil && !options.Force && !strings.Contains(string(buff), hookMarker) {
+			return installed, e.NewErrorf(ErrClassUser, msgHookExists, p)
+		}
+
+		err = ioutil.WriteFile(p, []byte(hookScript(h, options.Command)), 0755)
+		if err != nil {
+			return installed, e.Wrapf(ErrClassInternal, err, msgFailedWriteHook, p)
+		}
+
+		// Mode of an existing file is not changed by WriteFile.
+		err = os.Chmod(p, 0755)
+		if err != nil {
+			return installed, e.Wrapf(ErrClassInternal, err, msgFailedWriteHook, p)
+		}
+
+		installed = append(installed, p)
+	}
+
+	return installed, nil
+}
+
+// hookScript returns the script of a git hook delegating to
+// mbt verify-hook.
+func hookScript(hook, command string) string {
+	args := ""
+	if command != "" {
+		args = " --command " + shellQuote(command)
+	}
+
+	return fmt.Sprintf(`#!/bin/sh
+%s
+exec mbt verify-hook %s%s
+`, hookMarker, hook, args)
+}
+
+// shellQuote quotes s as a single argument of a posix shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func (s *stdSystem) VerifyPreCommit() (*Manifest, error) {
+	m, err := s.ManifestByWorkspace()
+	if err != nil {
+		return nil, withHookContext(err, HookPreCommit)
+	}
+	return m, nil
+}
+
+func (s *stdSystem) VerifyPrePush(updates io.Reader) ([]*PushUpdate, error) {
+	pushed, err := parsePushUpdates(updates)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range pushed {
+		if u.IsDelete() {
+			continue
+		}
+
+		_, err := s.ManifestByCommit(u.LocalSha)
+		if err != nil {
+			return nil, withHookContext(err, HookPrePush)
+		}
+	}
+
+	return pushed, nil
+}
+
+// parsePushUpdates parses the refs git passes to the pre-push hook
+// (i.e. <local ref> <local sha> <remote ref> <remote sha> per line).
+func parsePushUpdates(r io.Reader) ([]*PushUpdate, error) {
+	updates := make([]*PushUpdate, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		f := strings.Fields(line)
+		if len(f) != 4 {
+			return nil, e.NewErrorf(ErrClassUser, msgInvalidPushUpdate, line)
+		}
+
+		updates = append(updates, &PushUpdate{LocalRef: f[0], LocalSha: f[1], RemoteRef: f[2], RemoteSha: f[3]})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+	return updates, nil
+}
+
+// withHookContext describes the failure of a hook while retaining the
+// exit code of the error.
+func withHookContext(err error, hook string) error {
+	wrapped := e.Wrapf(ErrClassUser, err, msgHookVerificationFailed, hook, err)
+	if code := ExitCode(err); code != ExitCodeError {
+		wrapped = wrapped.WithCode(code)
+	}
+	return wrapped
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallHooks(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	w := NewWorld(t, ".tmp/repo")
+	installed, err := w.System.InstallHooks(&HookOptions{
+		Hooks:   []string{HookPreCommit, HookPrePush},
+		Command: "lint 'fast'",
+	})
+	check(t, err)
+
+	dir := filepath.Join(w.Repo.GitDir(), "hooks")
+	assert.Equal(t, []string{filepath.Join(dir, "pre-commit"), filepath.Join(dir, "pre-push")}, installed)
+
+	buff, err := ioutil.ReadFile(installed[0])
+	check(t, err)
+	assert.Contains(t, string(buff), `exec mbt verify-hook pre-commit --command 'lint '\''fast'\'''`)
+
+	fi, err := os.Stat(installed[1])
+	check(t, err)
+	assert.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+
+	// Hooks installed by mbt are replaced without --force.
+	_, err = w.System.InstallHooks(&HookOptions{Hooks: []string{HookPreCommit}})
+	check(t, err)
+
+	buff, err = ioutil.ReadFile(installed[0])
+	check(t, err)
+	assert.True(t, strings.HasSuffix(string(buff), "exec mbt verify-hook pre-commit\n"))
+}
+
+func TestInstallHooksOverExistingHook(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+	check(t, repo.WriteContent(".git/hooks/pre-commit", "#!/bin/sh\nmake lint\n"))
+
+	w := NewWorld(t, ".tmp/repo")
+	p := filepath.Join(w.Repo.GitDir(), "hooks", "pre-commit")
+
+	_, err := w.System.InstallHooks(&HookOptions{})
+	assert.EqualError(t, err, fmt.Sprintf(msgHookExists, p))
+
+	_, err = w.System.InstallHooks(&HookOptions{Force: true})
+	check(t, err)
+
+	buff, err := ioutil.ReadFile(p)
+	check(t, err)
+	assert.Contains(t, string(buff), hookMarker)
+}
+
+func TestInstallHooksInHooksPath(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	config, err := repo.Repo.Config()
+	check(t, err)
+	check(t, config.SetString("core.hooksPath", "tools/hooks"))
+	config.Free()
+
+	installed, err := NewWorld(t, ".tmp/repo").System.InstallHooks(&HookOptions{})
+	check(t, err)
+
+	assert.Len(t, installed, 1)
+	assert.True(t, filepath.IsAbs(installed[0]))
+	assert.FileExists(t, ".tmp/repo/tools/hooks/pre-commit")
+}
+
+func TestInstallUnsupportedHook(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.InstallHooks(&HookOptions{Hooks: []string{"post-merge"}})
+
+	assert.EqualError(t, err, fmt.Sprintf(msgUnsupportedHook, "post-merge"))
+}
+
+func TestVerifyPreCommit(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Dependencies: []string{"app-a"}}))
+	check(t, repo.Commit("first"))
+
+	w := NewWorld(t, ".tmp/repo")
+	m, err := w.System.VerifyPreCommit()
+	check(t, err)
+	assert.Equal(t, []string{"app-a", "app-b"}, moduleNames(m.Modules))
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Dependencies: []string{"app-b"}}))
+
+	_, err = w.System.VerifyPreCommit()
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "pre-commit hook failed: "))
+	assert.Equal(t, ExitCodeConfigError, ExitCode(err))
+}
+
+func TestVerifyPrePush(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+	first := repo.LastCommit.String()
+
+	check(t, repo.WriteContent("app-a/.mbt.yml", "blah:blah\nblah::"))
+	check(t, repo.Commit("second"))
+	second := repo.LastCommit.String()
+
+	w := NewWorld(t, ".tmp/repo")
+	updates, err := w.System.VerifyPrePush(strings.NewReader(fmt.Sprintf(
+		"refs/heads/master %v refs/heads/master %v\n(delete) %v refs/heads/old %v\n", first, zeroSha, zeroSha, first)))
+	check(t, err)
+	assert.Len(t, updates, 2)
+	assert.True(t, updates[0].IsNew())
+	assert.True(t, updates[1].IsDelete())
+
+	_, err = w.System.VerifyPrePush(strings.NewReader(fmt.Sprintf("refs/heads/master %v refs/heads/master %v\n", second, first)))
+	assert.EqualError(t, err, "pre-push hook failed: error while parsing the spec at app-a/.mbt.yml")
+
+	_, err = w.System.VerifyPrePush(strings.NewReader("refs/heads/master\n"))
+	assert.EqualError(t, err, fmt.Sprintf(msgInvalidPushUpdate, "refs/heads/master"))
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// GitHubMatrix is the matrix of a job in a GitHub Actions workflow with
+// an entry for each module. It is used as the value of strategy.matrix
+// e.g. matrix: ${{ fromJSON(needs.plan.outputs.matrix) }}.
+type GitHubMatrix struct {
+	Include []*GitHubMatrixEntry `json:"include"`
+}
+
+// GitHubMatrixEntry is the entry of a module in a GitHubMatrix.
+type GitHubMatrixEntry struct {
+	Name         string   `json:"name"`
+	Path         string   `json:"path"`
+	Version      string   `json:"version"`
+	Tags         []string `json:"tags"`
+	Owners       []string `json:"owners"`
+	Dependencies []string `json:"dependencies"`
+}
+
+// GitHubMatrix creates the matrix of the modules in the manifest in
+// the same order.
+func (m *Manifest) GitHubMatrix() (*GitHubMatrix, error) {
+	plan, err := newPipelinePlan(m, &PipelineOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return newGitHubMatrix(plan), nil
+}
+
+func newGitHubMatrix(plan *pipelinePlan) *GitHubMatrix {
+	m := &GitHubMatrix{Include: make([]*GitHubMatrixEntry, 0, len(plan.Steps))}
+	for _, s := range plan.Steps {
+		a := s.Module
+		tags := []string{}
+		values, _ := a.Properties()[tagsProperty].([]interface{})
+		for _, v := range values {
+			if s, ok := v.(string); ok {
+				tags = append(tags, s)
+			}
+		}
+
+		m.Include = append(m.Include, &GitHubMatrixEntry{
+			Name:         a.Name(),
+			Path:         a.Path(),
+			Version:      a.Version(),
+			Tags:         tags,
+			Owners:       append([]string{}, a.Owners()...),
+			Dependencies: sortedNames(a.Requires()),
+		})
+	}
+
+	return m
+}
+
+func renderGitHubMatrix(plan *pipelinePlan, options *PipelineOptions, w io.Writer) error {
+	return newGitHubMatrix(plan).Write(w)
+}
+
+// Write writes the matrix to w as json in a single line.
+func (m *GitHubMatrix) Write(w io.Writer) error {
+	buff, err := json.Marshal(m)
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", buff)
+	return err
+}
+
+// WriteGitHubOutput appends the matrix to the step outputs file of
+// GitHub Actions (the file in GITHUB_OUTPUT environment variable).
+// Matrix is written to the output with the specified name and the
+// number of modules to <name>-count, so that the jobs using an empty
+// matrix (which GitHub Actions rejects) can be skipped.
+func (m *GitHubMatrix) WriteGitHubOutput(file, name string) error {
+	buff, err := json.Marshal(m)
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return e.Wrapf(ErrClassUser, err, msgFailedWriteGitHubOutput, file)
+	}
+	defer f.Close()
+
+	lines := []string{
+		fmt.Sprintf("%s=%s", name, buff),
+		fmt.Sprintf("%s-count=%d", name, len(m.Include)),
+	}
+	if _, err = io.WriteString(f, strings.Join(lines, "\n")+"\n"); err != nil {
+		return e.Wrapf(ErrClassUser, err, msgFailedWriteGitHubOutput, file)
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGitHubMatrix(t *testing.T) {
+	_, base := initAffectedRepo(t)
+
+	m, err := NewWorld(t, ".tmp/repo").System.Affected(base, "feature", AffectedBuilds)
+	check(t, err)
+
+	matrix, err := m.GitHubMatrix()
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	check(t, matrix.Write(buff))
+
+	assert.Equal(t, 1, strings.Count(buff.String(), "\n"))
+
+	matrix = &GitHubMatrix{}
+	check(t, json.Unmarshal(buff.Bytes(), matrix))
+
+	assert.Len(t, matrix.Include, 2)
+	lib, svc := matrix.Include[0], matrix.Include[1]
+	assert.Equal(t, "lib-a", lib.Name)
+	assert.Equal(t, "lib-a", lib.Path)
+	assert.Equal(t, m.Modules[0].Version(), lib.Version)
+	assert.Equal(t, []string{}, lib.Tags)
+	assert.Equal(t, []string{}, lib.Dependencies)
+	assert.Equal(t, "svc-a", svc.Name)
+	assert.Equal(t, []string{"deploy"}, svc.Tags)
+	assert.Equal(t, []string{"lib-a"}, svc.Dependencies)
+}
+
+func TestEmptyGitHubMatrix(t *testing.T) {
+	matrix, err := (&Manifest{Modules: Modules{}}).GitHubMatrix()
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	check(t, matrix.Write(buff))
+
+	assert.Equal(t, "{\"include\":[]}\n", buff.String())
+}
+
+func TestWriteGitHubOutput(t *testing.T) {
+	clean()
+	check(t, os.MkdirAll(".tmp", 0755))
+	check(t, ioutil.WriteFile(".tmp/output", []byte("existing=value\n"), 0644))
+
+	m := &Manifest{Modules: Modules{newModule(newModuleMetadata("app-a", "a", &Spec{Name: "app-a"}, nil), nil)}}
+	matrix, err := m.GitHubMatrix()
+	check(t, err)
+	check(t, matrix.WriteGitHubOutput(".tmp/output", "matrix"))
+
+	buff, err := ioutil.ReadFile(".tmp/output")
+	check(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(buff)), "\n")
+	assert.Len(t, lines, 3)
+	assert.Equal(t, "existing=value", lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "matrix={\"include\":[{\"name\":\"app-a\""), lines[1])
+	assert.Equal(t, "matrix-count=1", lines[2])
+}
+
+func TestWriteGitHubOutputToInvalidFile(t *testing.T) {
+	clean()
+
+	err := (&GitHubMatrix{}).WriteGitHubOutput(".tmp/missing/output", "matrix")
+
+	assert.EqualError(t, err, fmt.Sprintf(msgFailedWriteGitHubOutput, ".tmp/missing/output"))
+}
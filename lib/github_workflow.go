@@ -0,0 +1,135 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io"
+	"strings"
+
+	yaml "github.com/go-yaml/yaml"
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// gitHubRunner is the runner of the jobs in a GitHub Actions
+	// workflow.
+	gitHubRunner = "ubuntu-latest"
+	// gitHubCheckoutAction checks out the repository in the jobs.
+	gitHubCheckoutAction = "actions/checkout@v4"
+	// gitHubWorkflowName is the name of the workflow.
+	gitHubWorkflowName = "mbt"
+	// gitHubNoopJob is the name of the job in a workflow without modules.
+	// GitHub Actions rejects a workflow without any jobs.
+	gitHubNoopJob = "mbt-no-changes"
+)
+
+type gitHubWorkflow struct {
+	Name string        `yaml:"name"`
+	On   yaml.MapSlice `yaml:"on"`
+	Jobs yaml.MapSlice `yaml:"jobs"`
+}
+
+type gitHubJob struct {
+	Name      string        `yaml:"name"`
+	RunsOn    string        `yaml:"runs-on"`
+	Container string        `yaml:"container,omitempty"`
+	Needs     []string      `yaml:"needs,omitempty"`
+	Env       yaml.MapSlice `yaml:"env,omitempty"`
+	Steps     []interface{} `yaml:"steps"`
+}
+
+// gitHubJobID returns the id of the job of a module. Ids can only
+// contain alphanumeric characters, - and _ and must start with a letter
+// or _.
+func gitHubJobID(module string) string {
+	id := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, module)
+
+	if c := id[0]; !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_') {
+		id = "_" + id
+	}
+	return id
+}
+
+// gitHubEscape escapes the expressions in s, which are otherwise
+// evaluated by GitHub Actions before the job runs.
+func gitHubEscape(s string) string {
+	return strings.Replace(s, "${{", "${{ '${{' }}", -1)
+}
+
+// renderGitHubWorkflow writes a reusable GitHub Actions workflow with a
+// job building each module.
+func renderGitHubWorkflow(plan *pipelinePlan, options *PipelineOptions, w io.Writer) error {
+	wf := &gitHubWorkflow{
+		Name: gitHubWorkflowName,
+		On:   yaml.MapSlice{{Key: "workflow_call", Value: map[string]interface{}{}}},
+		Jobs: yaml.MapSlice{},
+	}
+
+	ids := plan.ids(gitHubJobID)
+	for _, s := range plan.buildableSteps() {
+		env := yaml.MapSlice{}
+		for _, k := range sortedKeys(s.Variables) {
+			env = append(env, yaml.MapItem{Key: k, Value: gitHubEscape(s.Variables[k])})
+		}
+
+		needs := make([]string, 0, len(s.Needs))
+		for _, n := range s.Needs {
+			needs = append(needs, ids[n])
+		}
+
+		wf.Jobs = append(wf.Jobs, yaml.MapItem{Key: ids[s.Module.Name()], Value: &gitHubJob{
+			Name:      s.Module.Name(),
+			RunsOn:    gitHubRunner,
+			Container: s.Image,
+			Needs:     needs,
+			Env:       env,
+			Steps: []interface{}{
+				map[string]string{"uses": gitHubCheckoutAction},
+				yaml.MapSlice{
+					{Key: "name", Value: "Build " + s.Module.Name()},
+					// GITHUB_WORKSPACE is the directory of the checkout
+					// in the runner as well as in the container.
+					{Key: "run", Value: strings.Join([]string{
+						`export MBT_REPO_PATH="$GITHUB_WORKSPACE"`,
+						`cd "$GITHUB_WORKSPACE"/` + gitHubEscape(shellWord(s.Dir)),
+						gitHubEscape(s.Command),
+					}, "\n")},
+				},
+			},
+		}})
+	}
+
+	if len(wf.Jobs) == 0 {
+		wf.Jobs = append(wf.Jobs, yaml.MapItem{Key: gitHubNoopJob, Value: &gitHubJob{
+			Name:   "No modules to build",
+			RunsOn: gitHubRunner,
+			Steps:  []interface{}{map[string]string{"run": "echo No modules to build"}},
+		}})
+	}
+
+	buff, err := yaml.Marshal(wf)
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	_, err = w.Write(buff)
+	return err
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"testing"
+
+	yaml "github.com/go-yaml/yaml"
+	"github.com/stretchr/testify/assert"
+)
+
+type testGitHubWorkflow struct {
+	Name string                 `yaml:"name"`
+	On   map[string]interface{} `yaml:"on"`
+	Jobs map[string]*struct {
+		Name      string              `yaml:"name"`
+		RunsOn    string              `yaml:"runs-on"`
+		Container string              `yaml:"container"`
+		Needs     []string            `yaml:"needs"`
+		Env       map[string]string   `yaml:"env"`
+		Steps     []map[string]string `yaml:"steps"`
+	} `yaml:"jobs"`
+}
+
+func TestGitHubWorkflow(t *testing.T) {
+	initPipelineRepo(t)
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	check(t, m.WritePipeline(PipelineGitHubActions, &PipelineOptions{}, buff))
+
+	w := &testGitHubWorkflow{}
+	check(t, yaml.Unmarshal(buff.Bytes(), w))
+
+	assert.Equal(t, "mbt", w.Name)
+	assert.Contains(t, w.On, "workflow_call")
+	assert.Len(t, w.Jobs, 2)
+
+	lib, svc := w.Jobs["lib-a"], w.Jobs["svc-a"]
+	assert.Equal(t, "lib-a", lib.Name)
+	assert.Equal(t, gitHubRunner, lib.RunsOn)
+	assert.Empty(t, lib.Container)
+	assert.Empty(t, lib.Needs)
+	assert.Equal(t, gitHubCheckoutAction, lib.Steps[0]["uses"])
+	assert.Equal(t, "export MBT_REPO_PATH=\"$GITHUB_WORKSPACE\"\ncd \"$GITHUB_WORKSPACE\"/lib-a\nmake build lib-a", lib.Steps[1]["run"])
+	assert.Equal(t, m.Modules[0].Version(), lib.Env["MBT_MODULE_VERSION"])
+
+	assert.Equal(t, "golang:1.21", svc.Container)
+	assert.Equal(t, []string{"lib-a"}, svc.Needs)
+	assert.Equal(t, "-mod=vendor", svc.Env["GOFLAGS"])
+}
+
+func TestGitHubWorkflowEscapesExpressions(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("1app.a", &Spec{
+		Name:  "1app.a",
+		Env:   map[string]string{"TARGET": "${{ secrets.TOKEN }}"},
+		Build: map[string]*Cmd{"linux": {Cmd: "echo $TARGET", Shell: "sh"}},
+	}))
+	check(t, repo.Commit("first"))
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	check(t, m.WritePipeline(PipelineGitHubActions, &PipelineOptions{}, buff))
+
+	w := &testGitHubWorkflow{}
+	check(t, yaml.Unmarshal(buff.Bytes(), w))
+
+	job := w.Jobs["_1app-a"]
+	if assert.NotNil(t, job) {
+		assert.Equal(t, "1app.a", job.Name)
+		assert.Equal(t, "${{ '${{' }} secrets.TOKEN }}", job.Env["TARGET"])
+		assert.Contains(t, job.Steps[1]["run"], "sh -c 'echo $TARGET' sh")
+	}
+}
+
+func TestEmptyGitHubWorkflow(t *testing.T) {
+	buff := new(bytes.Buffer)
+	check(t, (&Manifest{Modules: Modules{}}).WritePipeline(PipelineGitHubActions, &PipelineOptions{}, buff))
+
+	w := &testGitHubWorkflow{}
+	check(t, yaml.Unmarshal(buff.Bytes(), w))
+
+	assert.Len(t, w.Jobs, 1)
+	assert.Equal(t, "echo No modules to build", w.Jobs[gitHubNoopJob].Steps[0]["run"])
+}
+
+func TestGitHubWorkflowWithCollidingJobIDs(t *testing.T) {
+	step := func(name string, needs ...string) *pipelineStep {
+		mod := newModule(newModuleMetadata(name, name, &Spec{Name: name}, nil), nil)
+		return &pipelineStep{Module: mod, Command: "make", Needs: needs}
+	}
+	plan := &pipelinePlan{Steps: []*pipelineStep{
+		step("app-a"),
+		step("app.a", "app-a"),
+		step("app/a", "app.a"),
+	}}
+
+	buff := new(bytes.Buffer)
+	check(t, renderGitHubWorkflow(plan, &PipelineOptions{}, buff))
+
+	w := &testGitHubWorkflow{}
+	check(t, yaml.Unmarshal(buff.Bytes(), w))
+
+	assert.Len(t, w.Jobs, 3)
+	ids := plan.ids(gitHubJobID)
+	assert.Equal(t, "app-a", ids["app-a"])
+	assert.Regexp(t, "^app-a_[0-9a-f]{8}$", ids["app.a"])
+	assert.Regexp(t, "^app-a_[0-9a-f]{8}$", ids["app/a"])
+	assert.NotEqual(t, ids["app.a"], ids["app/a"])
+
+	assert.Equal(t, "app.a", w.Jobs[ids["app.a"]].Name)
+	assert.Equal(t, []string{"app-a"}, w.Jobs[ids["app.a"]].Needs)
+	assert.Equal(t, "app/a", w.Jobs[ids["app/a"]].Name)
+	assert.Equal(t, []string{ids["app.a"]}, w.Jobs[ids["app/a"]].Needs)
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"io"
+
+	yaml "github.com/go-yaml/yaml"
+	"github.com/mbtproject/mbt/e"
+)
+
+// DefaultGitLabStage is the stage of the jobs in a GitLab pipeline
+// unless specified otherwise.
+const DefaultGitLabStage = "build"
+
+// gitLabNoopJob is the name of the job in a pipeline without modules.
+// GitLab rejects a pipeline without any jobs.
+const gitLabNoopJob = "mbt:no-changes"
+
+// GitLabPipeline is a GitLab CI pipeline with a job building each
+// module. It is used as a dynamic child pipeline.
+type GitLabPipeline struct {
+	Stage string
+	Jobs  []*GitLabJob
+}
+
+// GitLabJob is the job building a module in a GitLab pipeline.
+type GitLabJob struct {
+	Name      string
+	Image     string
+	Variables map[string]string
+	Script    []string
+	// Needs are the jobs of the dependencies of the module. Jobs start
+	// as soon as their needs are complete, therefore, modules are built
+	// in the dependency order.
+	Needs []string
+}
+
+type gitLabJobSpec struct {
+	Stage     string        `yaml:"stage"`
+	Image     string        `yaml:"image,omitempty"`
+	Variables yaml.MapSlice `yaml:"variables,omitempty"`
+	Script    []string      `yaml:"script"`
+	Needs     []string      `yaml:"needs"`
+}
+
+// GitLabPipeline creates a pipeline building the modules in the
+// manifest with their build command for linux (or the default one).
+// Modules without a build command for linux are not built, modules
+// depending on them need the jobs of their dependencies instead.
+func (m *Manifest) GitLabPipeline(options *PipelineOptions) (*GitLabPipeline, error) {
+	plan, err := newPipelinePlan(m, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return newGitLabPipeline(plan, options), nil
+}
+
+func newGitLabPipeline(plan *pipelinePlan, options *PipelineOptions) *GitLabPipeline {
+	stage := options.Stage
+	if stage == "" {
+		stage = DefaultGitLabStage
+	}
+
+	jobName := func(module string) string {
+		return fmt.Sprintf("%s:%s", stage, module)
+	}
+
+	p := &GitLabPipeline{Stage: stage, Jobs: []*GitLabJob{}}
+	for _, s := range plan.buildableSteps() {
+		variables := map[string]string{"MBT_REPO_PATH": "$CI_PROJECT_DIR"}
+		for k, v := range s.Variables {
+			variables[k] = v
+		}
+
+		needs := make([]string, 0, len(s.Needs))
+		for _, n := range s.Needs {
+			needs = append(needs, jobName(n))
+		}
+
+		p.Jobs = append(p.Jobs, &GitLabJob{
+			Name:      jobName(s.Module.Name()),
+			Image:     s.Image,
+			Variables: variables,
+			Script: []string{
+				fmt.Sprintf(`cd "$CI_PROJECT_DIR"/%s`, shellWord(s.Dir)),
+				s.Command,
+			},
+			Needs: needs,
+		})
+	}
+
+	return p
+}
+
+func renderGitLabPipeline(plan *pipelinePlan, options *PipelineOptions, w io.Writer) error {
+	return newGitLabPipeline(plan, options).Write(w)
+}
+
+// Write writes the pipeline to w as yaml. Pipeline contains a job
+// that does nothing if there are no modules to build.
+func (p *GitLabPipeline) Write(w io.Writer) error {
+	doc := yaml.MapSlice{{Key: "stages", Value: []string{p.Stage}}}
+	for _, j := range p.Jobs {
+		variables := yaml.MapSlice{}
+		for _, k := range sortedKeys(j.Variables) {
+			variables = append(variables, yaml.MapItem{Key: k, Value: j.Variables[k]})
+		}
+
+		doc = append(doc, yaml.MapItem{Key: j.Name, Value: &gitLabJobSpec{
+			Stage:     p.Stage,
+			Image:     j.Image,
+			Variables: variables,
+			Script:    j.Script,
+			Needs:     j.Needs,
+		}})
+	}
+
+	if len(p.Jobs) == 0 {
+		doc = append(doc, yaml.MapItem{Key: gitLabNoopJob, Value: &gitLabJobSpec{
+			Stage:  p.Stage,
+			Script: []string{"echo No modules to build"},
+			Needs:  []string{},
+		}})
+	}
+
+	buff, err := yaml.Marshal(doc)
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	_, err = w.Write(buff)
+	return err
+}
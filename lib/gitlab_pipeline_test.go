@@ -0,0 +1,136 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	yaml "github.com/go-yaml/yaml"
+	"github.com/stretchr/testify/assert"
+)
+
+type testGitLabPipeline struct {
+	Stages []string                  `yaml:"stages"`
+	Jobs   map[string]*gitLabJobSpec `yaml:",inline"`
+}
+
+func TestGitLabPipeline(t *testing.T) {
+	repo := initPipelineRepo(t)
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	p, err := m.GitLabPipeline(&PipelineOptions{Image: "alpine"})
+	check(t, err)
+
+	assert.Equal(t, DefaultGitLabStage, p.Stage)
+	assert.Len(t, p.Jobs, 2)
+
+	lib, svc := p.Jobs[0], p.Jobs[1]
+	assert.Equal(t, "build:lib-a", lib.Name)
+	assert.Equal(t, "alpine", lib.Image)
+	assert.Equal(t, []string{`cd "$CI_PROJECT_DIR"/lib-a`, `make build lib-a`}, lib.Script)
+	assert.Equal(t, []string{}, lib.Needs)
+	assert.Equal(t, repo.LastCommit.String(), lib.Variables["MBT_BUILD_COMMIT"])
+	assert.Equal(t, m.Modules[0].Version(), lib.Variables["MBT_MODULE_VERSION"])
+	assert.Equal(t, "$CI_PROJECT_DIR", lib.Variables["MBT_REPO_PATH"])
+
+	assert.Equal(t, "build:svc-a", svc.Name)
+	assert.Equal(t, "golang:1.21", svc.Image)
+	assert.Equal(t, []string{`cd "$CI_PROJECT_DIR"/svc-a/src`, `sh -c './build.sh '\''release'\''' sh`}, svc.Script)
+	assert.Equal(t, []string{"build:lib-a"}, svc.Needs)
+	assert.Equal(t, "-mod=vendor", svc.Variables["GOFLAGS"])
+}
+
+func TestGitLabPipelineYaml(t *testing.T) {
+	initPipelineRepo(t)
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	p, err := m.GitLabPipeline(&PipelineOptions{Stage: "compile"})
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	check(t, p.Write(buff))
+
+	doc := yaml.MapSlice{}
+	check(t, yaml.Unmarshal(buff.Bytes(), &doc))
+
+	keys := []interface{}{}
+	for _, item := range doc {
+		keys = append(keys, item.Key)
+	}
+	assert.Equal(t, []interface{}{"stages", "compile:lib-a", "compile:svc-a"}, keys)
+
+	pipeline := &testGitLabPipeline{}
+	check(t, yaml.Unmarshal(buff.Bytes(), pipeline))
+	assert.Equal(t, []string{"compile"}, pipeline.Stages)
+	assert.Equal(t, "compile", pipeline.Jobs["compile:svc-a"].Stage)
+	assert.Equal(t, []string{"compile:lib-a"}, pipeline.Jobs["compile:svc-a"].Needs)
+	assert.Equal(t, []string{}, pipeline.Jobs["compile:lib-a"].Needs)
+	assert.Equal(t, "", pipeline.Jobs["compile:lib-a"].Image)
+}
+
+func TestEmptyGitLabPipeline(t *testing.T) {
+	buff := new(bytes.Buffer)
+	p, err := (&Manifest{Modules: Modules{}}).GitLabPipeline(&PipelineOptions{})
+	check(t, err)
+	check(t, p.Write(buff))
+
+	pipeline := &testGitLabPipeline{}
+	check(t, yaml.Unmarshal(buff.Bytes(), pipeline))
+
+	assert.Contains(t, pipeline.Jobs, gitLabNoopJob)
+	assert.Equal(t, DefaultGitLabStage, pipeline.Jobs[gitLabNoopJob].Stage)
+}
+
+func TestGitLabPipelineForAffectedModules(t *testing.T) {
+	repo := initPipelineRepo(t)
+	base := repo.LastCommit.String()
+	check(t, repo.WriteContent("svc-a/main.go", "package main"))
+	check(t, repo.Commit("second"))
+
+	m, err := NewWorld(t, ".tmp/repo").System.Affected(base, "HEAD", AffectedBuilds)
+	check(t, err)
+
+	p, err := m.GitLabPipeline(&PipelineOptions{})
+	check(t, err)
+
+	assert.Len(t, p.Jobs, 1)
+	assert.Equal(t, "build:svc-a", p.Jobs[0].Name)
+	assert.Equal(t, []string{}, p.Jobs[0].Needs)
+}
+
+func TestGitLabPipelineForInvalidCommandTemplate(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:      "app-a",
+		Templates: true,
+		Build:     map[string]*Cmd{"linux": {Cmd: "echo", Args: []string{"{{ .Foo }}"}}},
+	}))
+	check(t, repo.Commit("first"))
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	_, err = m.GitLabPipeline(&PipelineOptions{})
+
+	assert.EqualError(t, err, fmt.Sprintf(msgFailedCommandTemplate, "{{ .Foo }}"))
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// GraphFormatDot serializes a graph in graphviz dot format.
+	GraphFormatDot = "dot"
+	// GraphFormatMermaid serializes a graph as a mermaid flowchart.
+	GraphFormatMermaid = "mermaid"
+	// GraphClusterDir clusters modules by the directory containing them.
+	GraphClusterDir = "dir"
+	// GraphClusterTag clusters modules by the first value in their tags
+	// property.
+	GraphClusterTag = "tag"
+)
+
+// GraphOptions specifies how a graph of modules is serialized.
+type GraphOptions struct {
+	// Format of the graph (dot or mermaid). Defaults to dot.
+	Format string
+	// Highlight is the set of modules highlighted in the graph
+	// (e.g. the modules changed between two commits).
+	Highlight Modules
+	// Cluster groups modules by directory (dir) or tag (tag).
+	// Modules are not grouped if this is not specified.
+	Cluster string
+}
+
+// Neighborhood returns the modules reachable from the named module
+// by following at most depth dependencies or dependents. All modules
+// connected to it are returned if depth is less than 1.
+// Modules are returned in the same order as in l.
+func (l Modules) Neighborhood(name string, depth int) (Modules, error) {
+	index := l.indexByName()
+	start, ok := index[name]
+	if !ok {
+		return nil, e.NewErrorf(ErrClassUser, msgModuleNotFound, name)
+	}
+
+	visited := map[string]bool{start.Name(): true}
+	frontier := Modules{start}
+	for d := 0; len(frontier) > 0 && (depth < 1 || d < depth); d++ {
+		var next Modules
+		for _, m := range frontier {
+			for _, n := range append(append(Modules{}, m.Requires()...), m.RequiredBy()...) {
+				if !visited[n.Name()] {
+					visited[n.Name()] = true
+					next = append(next, n)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	return l.where(func(m *Module) bool { return visited[m.Name()] }), nil
+}
+
+// SerializeGraph serializes the modules and the dependencies between
+// them. Dependencies on modules that are not in l are omitted.
+func (l Modules) SerializeGraph(options *GraphOptions) (string, error) {
+	clusters, err := l.clusters(options.Cluster)
+	if err != nil {
+		return "", err
+	}
+
+	highlighted := options.Highlight.indexByName()
+	switch options.Format {
+	case "", GraphFormatDot:
+		return l.serializeDot(clusters, highlighted), nil
+	case GraphFormatMermaid:
+		return l.serializeMermaid(clusters, highlighted), nil
+	default:
+		return "", e.NewErrorf(ErrClassUser, msgUnsupportedGraphFormat, options.Format)
+	}
+}
+
+// graphCluster is a named group of modules in a graph.
+type graphCluster struct {
+	name    string
+	modules Modules
+}
+
+// clusters groups the modules by the specified kind. Modules that do
+// not belong to a cluster are in the cluster without a name, which is
+// not rendered as a group.
+func (l Modules) clusters(kind string) ([]*graphCluster, error) {
+	var key func(m *Module) string
+	switch kind {
+	case "":
+		return []*graphCluster{{modules: l}}, nil
+	case GraphClusterDir:
+		key = func(m *Module) string {
+			d := path.Dir(m.Path())
+			if d == "." {
+				return ""
+			}
+			return d
+		}
+	case GraphClusterTag:
+		key = func(m *Module) string {
+			tags, _ := m.Properties()[tagsProperty].([]interface{})
+			for _, t := range tags {
+				if s, ok := t.(string); ok {
+					return s
+				}
+			}
+			return ""
+		}
+	default:
+		return nil, e.NewErrorf(ErrClassUser, msgUnsupportedGraphCluster, kind)
+	}
+
+	index := make(map[string]*graphCluster)
+	var r []*graphCluster
+	for _, m := range l {
+		k := key(m)
+		c, ok := index[k]
+		if !ok {
+			c = &graphCluster{name: k}
+			index[k] = c
+			r = append(r, c)
+		}
+		c.modules = append(c.modules, m)
+	}
+
+	sort.SliceStable(r, func(i, j int) bool { return r[i].name < r[j].name })
+	return r, nil
+}
+
+// edges returns the dependencies between the modules in l as pairs of
+// dependent and dependency.
+func (l Modules) edges() [][2]*Module {
+	index := l.indexByName()
+	var r [][2]*Module
+	for _, m := range l {
+		for _, d := range m.Requires() {
+			if _, ok := index[d.Name()]; ok {
+				r = append(r, [2]*Module{m, d})
+			}
+		}
+	}
+	return r
+}
+
+func (l Modules) serializeDot(clusters []*graphCluster, highlighted map[string]*Module) string {
+	node := func(m *Module) string {
+		if _, ok := highlighted[m.Name()]; ok {
+			return fmt.Sprintf("%q [fillcolor=red]", m.Name())
+		}
+		return fmt.Sprintf("%q", m.Name())
+	}
+
+	lines := []string{"node [shape=box fillcolor=powderblue style=filled fontcolor=black];"}
+	for _, c := range clusters {
+		if c.name == "" {
+			for _, m := range c.modules {
+				lines = append(lines, node(m))
+			}
+			continue
+		}
+
+		lines = append(lines, fmt.Sprintf("subgraph %q {", "cluster_"+c.name), fmt.Sprintf("  label=%q;", c.name))
+		for _, m := range c.modules {
+			lines = append(lines, "  "+node(m))
+		}
+		lines = append(lines, "}")
+	}
+
+	for _, edge := range l.edges() {
+		lines = append(lines, fmt.Sprintf("%q -> %q", edge[0].Name(), edge[1].Name()))
+	}
+
+	return fmt.Sprintf("digraph mbt {\n  %s\n}", strings.Join(lines, "\n  "))
+}
+
+func (l Modules) serializeMermaid(clusters []*graphCluster, highlighted map[string]*Module) string {
+	// Module names can contain characters that are not valid in mermaid
+	// node ids, therefore nodes are identified by their position.
+	ids := make(map[string]string, len(l))
+	for i, m := range l {
+		ids[m.Name()] = fmt.Sprintf("m%d", i)
+	}
+
+	node := func(m *Module) string {
+		return fmt.Sprintf("%s[%q]", ids[m.Name()], m.Name())
+	}
+
+	lines := []string{"flowchart LR"}
+	for i, c := range clusters {
+		if c.name == "" {
+			for _, m := range c.modules {
+				lines = append(lines, "  "+node(m))
+			}
+			continue
+		}
+
+		lines = append(lines, fmt.Sprintf("  subgraph c%d [%q]", i, c.name))
+		for _, m := range c.modules {
+			lines = append(lines, "    "+node(m))
+		}
+		lines = append(lines, "  end")
+	}
+
+	for _, edge := range l.edges() {
+		lines = append(lines, fmt.Sprintf("  %s --> %s", ids[edge[0].Name()], ids[edge[1].Name()]))
+	}
+
+	var changed []string
+	for _, m := range l {
+		if _, ok := highlighted[m.Name()]; ok {
+			changed = append(changed, ids[m.Name()])
+		}
+	}
+
+	if len(changed) > 0 {
+		lines = append(lines, "  classDef highlighted fill:#f66,stroke:#333", fmt.Sprintf("  class %s highlighted", strings.Join(changed, ",")))
+	}
+
+	return strings.Join(lines, "\n")
+}
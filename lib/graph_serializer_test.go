@@ -0,0 +1,117 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func initGraphRepo(t *testing.T) *Manifest {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("libs/lib-a", &Spec{Name: "lib-a", Properties: map[string]interface{}{"tags": []interface{}{"core"}}}))
+	check(t, repo.InitModuleWithOptions("libs/lib-b", &Spec{Name: "lib-b", Dependencies: []string{"lib-a"}}))
+	check(t, repo.InitModuleWithOptions("apps/app-a", &Spec{Name: "app-a", Dependencies: []string{"lib-b"}, Properties: map[string]interface{}{"tags": []interface{}{"core", "web"}}}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b"}))
+	check(t, repo.Commit("first"))
+
+	m, err := NewWorld(t, ".tmp/repo").ManifestBuilder.ByCurrentBranch()
+	check(t, err)
+	return m
+}
+
+func TestSerializeGraphAsDot(t *testing.T) {
+	m := initGraphRepo(t)
+
+	s, err := m.Modules.SerializeGraph(&GraphOptions{
+		Highlight: m.Modules.where(func(m *Module) bool { return m.Name() == "lib-b" }),
+		Cluster:   GraphClusterDir,
+	})
+	check(t, err)
+
+	assert.Equal(t, `digraph mbt {
+  node [shape=box fillcolor=powderblue style=filled fontcolor=black];
+  "app-b"
+  subgraph "cluster_apps" {
+    label="apps";
+    "app-a"
+  }
+  subgraph "cluster_libs" {
+    label="libs";
+    "lib-a"
+    "lib-b" [fillcolor=red]
+  }
+  "lib-b" -> "lib-a"
+  "app-a" -> "lib-b"
+}`, s)
+}
+
+func TestSerializeGraphAsMermaid(t *testing.T) {
+	m := initGraphRepo(t)
+
+	s, err := m.Modules.SerializeGraph(&GraphOptions{
+		Format:    GraphFormatMermaid,
+		Highlight: m.Modules.where(func(m *Module) bool { return m.Name() != "app-b" }),
+		Cluster:   GraphClusterTag,
+	})
+	check(t, err)
+
+	assert.Equal(t, `flowchart LR
+  m0["app-b"]
+  m2["lib-b"]
+  subgraph c1 ["core"]
+    m1["lib-a"]
+    m3["app-a"]
+  end
+  m2 --> m1
+  m3 --> m2
+  classDef highlighted fill:#f66,stroke:#333
+  class m1,m2,m3 highlighted`, s)
+}
+
+func TestNeighborhood(t *testing.T) {
+	m := initGraphRepo(t)
+
+	n, err := m.Modules.Neighborhood("lib-b", 1)
+	check(t, err)
+	assert.Equal(t, []string{"lib-a", "lib-b", "app-a"}, moduleNames(n))
+
+	n, err = m.Modules.Neighborhood("lib-a", 1)
+	check(t, err)
+	assert.Equal(t, []string{"lib-a", "lib-b"}, moduleNames(n))
+
+	n, err = m.Modules.Neighborhood("lib-a", 0)
+	check(t, err)
+	assert.Equal(t, []string{"lib-a", "lib-b", "app-a"}, moduleNames(n))
+
+	s, err := n.SerializeGraph(&GraphOptions{})
+	check(t, err)
+	assert.NotContains(t, s, "app-b")
+
+	_, err = m.Modules.Neighborhood("app-c", 1)
+	assert.EqualError(t, err, fmt.Sprintf(msgModuleNotFound, "app-c"))
+}
+
+func TestUnsupportedGraphOptions(t *testing.T) {
+	_, err := Modules{}.SerializeGraph(&GraphOptions{Format: "svg"})
+	assert.EqualError(t, err, fmt.Sprintf(msgUnsupportedGraphFormat, "svg"))
+
+	_, err = Modules{}.SerializeGraph(&GraphOptions{Cluster: "owner"})
+	assert.EqualError(t, err, fmt.Sprintf(msgUnsupportedGraphCluster, "owner"))
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// HashProgress receives the number of files hashed so far and the
+// total number of files to hash whilst discovering modules.
+// Like EventHandler, it is invoked concurrently.
+type HashProgress func(hashed, total int)
+
+type hashProgressKey struct{}
+
+// WithHashProgress returns a context reporting the progress of hashing
+// files to p when used to create a system (see NewSystemWithContext).
+func WithHashProgress(ctx context.Context, p HashProgress) context.Context {
+	return context.WithValue(ctx, hashProgressKey{}, p)
+}
+
+func hashProgressFrom(ctx context.Context) HashProgress {
+	p, _ := ctx.Value(hashProgressKey{}).(HashProgress)
+	return p
+}
+
+// hashAll computes the hashes of n files using a bounded number of
+// concurrent workers and reports the progress after each file.
+// Hashes are returned in the order of the files so that reducing them
+// does not depend on the scheduling of workers.
+func hashAll(n int, progress HashProgress, hash func(i int) (string, error)) ([]string, error) {
+	hashes := make([]string, n)
+	if progress != nil {
+		progress(0, n)
+	}
+
+	var hashed int32
+	err := forEach(n, func(i int) error {
+		h, err := hash(i)
+		if err != nil {
+			return err
+		}
+
+		hashes[i] = h
+		if progress != nil {
+			progress(int(atomic.AddInt32(&hashed, 1)), n)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
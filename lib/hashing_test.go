@@ -0,0 +1,82 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashAllPreservesOrder(t *testing.T) {
+	hashes, err := hashAll(100, nil, func(i int) (string, error) {
+		return strconv.Itoa(i), nil
+	})
+	check(t, err)
+
+	assert.Len(t, hashes, 100)
+	for i, h := range hashes {
+		assert.Equal(t, strconv.Itoa(i), h)
+	}
+}
+
+func TestHashAllReturnsErrorOfLowestIndex(t *testing.T) {
+	hashes, err := hashAll(100, nil, func(i int) (string, error) {
+		if i%10 == 3 {
+			return "", fmt.Errorf("error %v", i)
+		}
+		return "", nil
+	})
+
+	assert.EqualError(t, err, "error 3")
+	assert.Nil(t, hashes)
+}
+
+func TestHashAllReportsProgress(t *testing.T) {
+	mu := sync.Mutex{}
+	reported := make(map[int]bool)
+	totals := make(map[int]bool)
+	_, err := hashAll(50, func(hashed, total int) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported[hashed] = true
+		totals[total] = true
+	}, func(i int) (string, error) {
+		return "", nil
+	})
+	check(t, err)
+
+	assert.Len(t, reported, 51)
+	for i := 0; i <= 50; i++ {
+		assert.True(t, reported[i])
+	}
+	assert.Equal(t, map[int]bool{50: true}, totals)
+}
+
+func TestHashProgressFromContext(t *testing.T) {
+	assert.Nil(t, hashProgressFrom(context.Background()))
+
+	hashed := 0
+	p := hashProgressFrom(WithHashProgress(context.Background(), func(h, total int) {
+		hashed = h
+	}))
+	p(3, 4)
+	assert.Equal(t, 3, hashed)
+}
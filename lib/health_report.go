@@ -0,0 +1,308 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// HealthReportFormatJSON formats a report as json.
+	HealthReportFormatJSON = "json"
+	// HealthReportFormatHTML formats a report as a html page.
+	HealthReportFormatHTML = "html"
+	// DefaultHealthReportWindow is the period considered for the version
+	// churn when it is not specified.
+	DefaultHealthReportWindow = 30 * 24 * time.Hour
+)
+
+// HealthReportOptions specifies how a report is produced.
+type HealthReportOptions struct {
+	// Window is the period preceding the last commit of the current
+	// branch used to compute version churn. Defaults to
+	// DefaultHealthReportWindow.
+	Window time.Duration
+}
+
+// HealthReport describes the structure of the modules in the current branch.
+type HealthReport struct {
+	Sha       string          `json:"sha"`
+	Generated time.Time       `json:"generated"`
+	Window    string          `json:"window"`
+	Modules   []*ModuleHealth `json:"modules"`
+}
+
+// ModuleHealth contains the statistics of a module.
+// Files and Lines count the files in the module directory, excluding
+// the directories of nested modules. Depth is the length of the longest
+// path to a module without dependencies. VersionChanges is the number
+// of commits in the report window changing the version of the module
+// (i.e. changes to the module, its file dependencies or the modules it
+// depends on).
+type ModuleHealth struct {
+	Name           string  `json:"name"`
+	Path           string  `json:"path"`
+	Files          int     `json:"files"`
+	Lines          int     `json:"lines"`
+	Dependencies   int     `json:"dependencies"`
+	Dependents     int     `json:"dependents"`
+	Depth          int     `json:"depth"`
+	VersionChanges int     `json:"versionChanges"`
+	ChurnPerWeek   float64 `json:"churnPerWeek"`
+}
+
+func (s *stdSystem) HealthReport(options *HealthReportOptions) (*HealthReport, error) {
+	window := options.Window
+	if window <= 0 {
+		window = DefaultHealthReportWindow
+	}
+
+	m, err := s.ManifestByCurrentBranch()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &HealthReport{Sha: m.Sha, Generated: time.Now().UTC(), Window: window.String(), Modules: make([]*ModuleHealth, 0, len(m.Modules))}
+	if m.Sha == "" {
+		// Empty repository
+		return r, nil
+	}
+
+	commit, err := s.Repo.GetCommit(m.Sha)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]*ModuleHealth, len(m.Modules))
+	depth := make(map[string]int, len(m.Modules))
+	for _, mod := range m.Modules {
+		// Modules are topologically sorted, hence the depth of
+		// dependencies is known.
+		d := 0
+		for _, req := range mod.Requires() {
+			if depth[req.Name()]+1 > d {
+				d = depth[req.Name()] + 1
+			}
+		}
+		depth[mod.Name()] = d
+
+		mr := &ModuleHealth{
+			Name:         mod.Name(),
+			Path:         mod.Path(),
+			Dependencies: len(mod.Requires()),
+			Dependents:   len(mod.RequiredBy()),
+			Depth:        d,
+		}
+		index[mod.Name()] = mr
+		r.Modules = append(r.Modules, mr)
+	}
+
+	if err := s.countModuleFiles(commit, m.Modules, index); err != nil {
+		return nil, err
+	}
+
+	if err := s.countVersionChanges(commit, m.Modules, window, index); err != nil {
+		return nil, err
+	}
+
+	weeks := window.Hours() / (24 * 7)
+	for _, mr := range r.Modules {
+		mr.ChurnPerWeek = float64(mr.VersionChanges) / weeks
+	}
+
+	return r, nil
+}
+
+// countModuleFiles counts the files and lines of each module in the
+// commit tree. A file is counted for the closest module containing it.
+func (s *stdSystem) countModuleFiles(commit Commit, modules Modules, index map[string]*ModuleHealth) error {
+	return s.Repo.WalkBlobs(commit, func(b Blob) error {
+		owner := ownerModule(modules, b.Path()+b.Name())
+		if owner == nil {
+			return nil
+		}
+
+		content, err := s.Repo.BlobContents(b)
+		if err != nil {
+			return err
+		}
+
+		mr := index[owner.Name()]
+		mr.Files++
+		mr.Lines += countLines(content)
+		return nil
+	})
+}
+
+// countVersionChanges counts the commits in the window changing the
+// version of each module.
+func (s *stdSystem) countVersionChanges(commit Commit, modules Modules, window time.Duration, index map[string]*ModuleHealth) error {
+	history, err := s.Repo.History(nil, commit)
+	if err != nil {
+		return err
+	}
+
+	if len(history) == 0 {
+		return nil
+	}
+
+	since := history[0].Time.Add(-window)
+	for _, c := range history {
+		// Merge commits do not change the versions on their own.
+		if c.Parents > 1 || c.Time.Before(since) {
+			continue
+		}
+
+		deltas, err := s.Repo.Changes(c.Commit)
+		if err != nil {
+			return err
+		}
+
+		changed, err := s.Reducer.Reduce(modules, deltas)
+		if err != nil {
+			return err
+		}
+
+		changed, err = changed.expandRequiredByDependencies()
+		if err != nil {
+			return err
+		}
+
+		for _, mod := range changed {
+			index[mod.Name()].VersionChanges++
+		}
+	}
+
+	return nil
+}
+
+// ownerModule returns the module with the longest path containing the
+// file or nil if the file is not in a module.
+func ownerModule(modules Modules, file string) *Module {
+	var owner *Module
+	for _, mod := range modules {
+		p := mod.Path()
+		if p != "" && file != p && !strings.HasPrefix(file, p+"/") {
+			continue
+		}
+
+		if owner == nil || len(p) > len(owner.Path()) {
+			owner = mod
+		}
+	}
+	return owner
+}
+
+// countLines returns the number of lines in text content. Binary
+// content (i.e. containing a NUL byte) has no lines.
+func countLines(content []byte) int {
+	if len(content) == 0 || bytes.IndexByte(content, 0) >= 0 {
+		return 0
+	}
+
+	n := bytes.Count(content, []byte("\n"))
+	if content[len(content)-1] != '\n' {
+		n++
+	}
+	return n
+}
+
+// Write writes the report to w in the specified format.
+func (r *HealthReport) Write(format string, w io.Writer) error {
+	switch format {
+	case HealthReportFormatJSON:
+		buff, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return e.Wrap(ErrClassInternal, err)
+		}
+
+		if _, err := w.Write(append(buff, '\n')); err != nil {
+			return e.Wrap(ErrClassInternal, err)
+		}
+	case HealthReportFormatHTML:
+		if err := reportTemplate.Execute(w, r.sorted()); err != nil {
+			return e.Wrap(ErrClassInternal, err)
+		}
+	default:
+		return e.NewErrorf(ErrClassUser, msgUnsupportedHealthReportFormat, format)
+	}
+
+	return nil
+}
+
+// sorted returns a copy of the report with the modules ordered by
+// name.
+func (r *HealthReport) sorted() *HealthReport {
+	c := *r
+	c.Modules = append([]*ModuleHealth{}, r.Modules...)
+	sort.Slice(c.Modules, func(i, j int) bool { return c.Modules[i].Name < c.Modules[j].Name })
+	return &c
+}
+
+// Totals returns the sum of files, lines and version changes of all
+// modules.
+func (r *HealthReport) Totals() *ModuleHealth {
+	t := &ModuleHealth{Name: "Total"}
+	for _, mr := range r.Modules {
+		t.Files += mr.Files
+		t.Lines += mr.Lines
+		t.VersionChanges += mr.VersionChanges
+	}
+	return t
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>mbt report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: right; }
+th:first-child, td:first-child, th:nth-child(2), td:nth-child(2) { text-align: left; }
+tfoot td { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>mbt report</h1>
+<p>Commit {{.Sha}} generated at {{.Generated.Format "2006-01-02T15:04:05Z07:00"}}. Version churn is computed over {{.Window}}.</p>
+<table>
+<thead>
+<tr><th>Module</th><th>Path</th><th>Files</th><th>Lines</th><th>Dependencies</th><th>Dependents</th><th>Depth</th><th>Version changes</th><th>Churn per week</th></tr>
+</thead>
+<tbody>
+{{- range .Modules}}
+<tr><td>{{.Name}}</td><td>{{.Path}}</td><td>{{.Files}}</td><td>{{.Lines}}</td><td>{{.Dependencies}}</td><td>{{.Dependents}}</td><td>{{.Depth}}</td><td>{{.VersionChanges}}</td><td>{{printf "%.2f" .ChurnPerWeek}}</td></tr>
+{{- end}}
+</tbody>
+<tfoot>
+{{- with .Totals}}
+<tr><td>{{.Name}}</td><td></td><td>{{.Files}}</td><td>{{.Lines}}</td><td></td><td></td><td></td><td>{{.VersionChanges}}</td><td></td></tr>
+{{- end}}
+</tfoot>
+</table>
+</body>
+</html>
+`))
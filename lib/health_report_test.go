@@ -0,0 +1,117 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthReport(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("lib-a", &Spec{Name: "lib-a"}))
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Dependencies: []string{"lib-a"}, FileDependencies: []string{"common"}}))
+	check(t, repo.InitModuleWithOptions("app-a/nested", &Spec{Name: "nested", Dependencies: []string{"app-a"}}))
+	check(t, repo.WriteContent("lib-a/a.go", "a\nb\nc\n"))
+	check(t, repo.WriteContent("app-a/main.go", "main\nfunc"))
+	check(t, repo.WriteContent("app-a/nested/n.go", "n\n"))
+	check(t, repo.WriteContent("common/c.go", "c\n"))
+	check(t, repo.Commit("first"))
+
+	check(t, repo.WriteContent("lib-a/b.go", "b\n"))
+	check(t, repo.Commit("second"))
+
+	check(t, repo.WriteContent("common/d.go", "d\n"))
+	check(t, repo.Commit("third"))
+
+	report, err := NewWorld(t, ".tmp/repo").System.HealthReport(&HealthReportOptions{Window: 7 * 24 * time.Hour})
+	check(t, err)
+
+	assert.Equal(t, repo.LastCommit.String(), report.Sha)
+	assert.Equal(t, "168h0m0s", report.Window)
+
+	index := make(map[string]*ModuleHealth)
+	for _, mr := range report.Modules {
+		index[mr.Name] = mr
+	}
+
+	lineCount := func(spec string) int { return countLines([]byte(spec)) }
+	assert.Equal(t, &ModuleHealth{Name: "lib-a", Path: "lib-a", Files: 3, Lines: 4 + lineCount(specOf(t, "lib-a")), Dependents: 1, VersionChanges: 1, ChurnPerWeek: 1}, index["lib-a"])
+	assert.Equal(t, &ModuleHealth{Name: "app-a", Path: "app-a", Files: 2, Lines: 2 + lineCount(specOf(t, "app-a")), Dependencies: 1, Dependents: 1, Depth: 1, VersionChanges: 2, ChurnPerWeek: 2}, index["app-a"])
+	assert.Equal(t, &ModuleHealth{Name: "nested", Path: "app-a/nested", Files: 2, Lines: 1 + lineCount(specOf(t, "app-a/nested")), Dependencies: 1, Depth: 2, VersionChanges: 2, ChurnPerWeek: 2}, index["nested"])
+
+	buff := new(bytes.Buffer)
+	check(t, report.Write(HealthReportFormatJSON, buff))
+	var decoded HealthReport
+	check(t, json.Unmarshal(buff.Bytes(), &decoded))
+	assert.Len(t, decoded.Modules, 3)
+
+	buff = new(bytes.Buffer)
+	check(t, report.Write(HealthReportFormatHTML, buff))
+	assert.Contains(t, buff.String(), "<tr><td>app-a</td><td>app-a</td><td>2</td>")
+	assert.Contains(t, buff.String(), "<td>1.00</td>")
+}
+
+func TestHealthReportWindow(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a"}))
+	check(t, repo.Commit("first"))
+	check(t, repo.WriteContent("app-a/foo", "a"))
+	check(t, repo.Commit("second"))
+
+	// Window is relative to the last commit, therefore commits made
+	// at the same time are always included.
+	report, err := NewWorld(t, ".tmp/repo").System.HealthReport(&HealthReportOptions{Window: time.Nanosecond})
+	check(t, err)
+	assert.Equal(t, 1, report.Modules[0].VersionChanges)
+
+	report, err = NewWorld(t, ".tmp/repo").System.HealthReport(&HealthReportOptions{})
+	check(t, err)
+	assert.Equal(t, DefaultHealthReportWindow.String(), report.Window)
+}
+
+func TestCountLines(t *testing.T) {
+	assert.Equal(t, 0, countLines([]byte("")))
+	assert.Equal(t, 1, countLines([]byte("a")))
+	assert.Equal(t, 1, countLines([]byte("a\n")))
+	assert.Equal(t, 2, countLines([]byte("a\nb")))
+	assert.Equal(t, 0, countLines([]byte("a\x00\nb")))
+}
+
+func TestUnsupportedHealthReportFormat(t *testing.T) {
+	err := (&HealthReport{}).Write("csv", new(bytes.Buffer))
+
+	assert.Error(t, err)
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+	assert.EqualError(t, err, "Unsupported health report format 'csv'")
+}
+
+func specOf(t *testing.T, dir string) string {
+	b, err := ioutil.ReadFile(filepath.Join(".tmp/repo", dir, ".mbt.yml"))
+	check(t, err)
+	return string(b)
+}
@@ -0,0 +1,338 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// HistoryFormatText formats a history analysis as tables.
+	HistoryFormatText = "text"
+	// HistoryFormatJSON formats a history analysis as json.
+	HistoryFormatJSON = "json"
+	// DefaultMinCoChanges is the number of commits two modules must
+	// change together in, to be reported as coupled.
+	DefaultMinCoChanges = 3
+	// historyMaxCoChangeModules is the number of modules a commit can
+	// change before it is excluded from the co-change analysis.
+	// Such commits (e.g. bulk renames) change modules together for
+	// reasons unrelated to their coupling.
+	historyMaxCoChangeModules = 50
+)
+
+// HistoryOptions specifies the range of history analysed.
+type HistoryOptions struct {
+	// From excludes the history reachable from this revision.
+	// Entire history is analysed if it is empty.
+	From string
+	// To is the last revision analysed. Defaults to the current branch.
+	To string
+	// MinCoChanges is the number of commits two modules must change
+	// together in, to be reported. Defaults to DefaultMinCoChanges.
+	MinCoChanges int
+}
+
+// HistoryAnalysis describes how the modules in a range of history
+// changed.
+type HistoryAnalysis struct {
+	From    string            `json:"from,omitempty"`
+	To      string            `json:"to"`
+	Commits int               `json:"commits"`
+	Modules []*ModuleChurn    `json:"modules"`
+	Coupled []*ModuleCoupling `json:"coupled"`
+}
+
+// ModuleChurn contains the changes to a module.
+// Commits is the number of commits changing the module or its file
+// dependencies. Changes to the modules it depends on are not counted.
+type ModuleChurn struct {
+	Name        string         `json:"name"`
+	Path        string         `json:"path"`
+	Commits     int            `json:"commits"`
+	FirstChange *time.Time     `json:"firstChange,omitempty"`
+	LastChange  *time.Time     `json:"lastChange,omitempty"`
+	Authors     []*AuthorChurn `json:"authors"`
+}
+
+// AuthorChurn is the number of commits of an author changing a module.
+type AuthorChurn struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Commits int    `json:"commits"`
+}
+
+// ModuleCoupling is a pair of modules frequently changed together
+// without either of them depending on the other.
+// Ratio is the fraction of the commits of the less frequently changed
+// module also changing the other module.
+type ModuleCoupling struct {
+	Modules []string `json:"modules"`
+	Commits int      `json:"commits"`
+	Ratio   float64  `json:"ratio"`
+}
+
+func (s *stdSystem) AnalyseHistory(options *HistoryOptions) (*HistoryAnalysis, error) {
+	analysis, _, err := s.analyseHistory(options)
+	return analysis, err
+}
+
+// analyseHistory analyses the history and returns the modules in to
+// commit along with the analysis.
+func (s *stdSystem) analyseHistory(options *HistoryOptions) (*HistoryAnalysis, Modules, error) {
+	minCoChanges := options.MinCoChanges
+	if minCoChanges <= 0 {
+		minCoChanges = DefaultMinCoChanges
+	}
+
+	var (
+		toCommit, fromCommit Commit
+		err                  error
+	)
+
+	if options.To == "" {
+		toCommit, err = s.Repo.CurrentBranchCommit()
+	} else {
+		toCommit, err = s.Repo.ResolveCommit(options.To)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if options.From != "" {
+		fromCommit, err = s.Repo.ResolveCommit(options.From)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Modules are identified with their definitions in to commit.
+	mods, err := s.Discover.ModulesInCommit(toCommit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	history, err := s.Repo.History(fromCommit, toCommit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	analysis := &HistoryAnalysis{To: toCommit.ID(), Modules: make([]*ModuleChurn, 0, len(mods)), Coupled: make([]*ModuleCoupling, 0)}
+	if fromCommit != nil {
+		analysis.From = fromCommit.ID()
+	}
+
+	churn := make(map[string]*ModuleChurn, len(mods))
+	authors := make(map[string]map[string]*AuthorChurn, len(mods))
+	for _, m := range mods {
+		c := &ModuleChurn{Name: m.Name(), Path: m.Path(), Authors: make([]*AuthorChurn, 0)}
+		churn[m.Name()] = c
+		authors[m.Name()] = make(map[string]*AuthorChurn)
+		analysis.Modules = append(analysis.Modules, c)
+	}
+
+	coChanges := make(map[[2]string]int)
+	for _, c := range history {
+		// Merge commits are excluded since the commits merged are
+		// already in the history. Root commits do not have changes.
+		if c.Parents != 1 {
+			continue
+		}
+
+		deltas, err := s.Repo.Changes(c.Commit)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		impacted, err := s.Reducer.Reduce(mods, deltas)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		analysis.Commits++
+		names := make([]string, 0, len(impacted))
+		for _, m := range impacted {
+			mc := churn[m.Name()]
+			mc.Commits++
+			// History is walked newest first.
+			t := c.Time
+			if mc.LastChange == nil {
+				mc.LastChange = &t
+			}
+			mc.FirstChange = &t
+
+			key := c.Email
+			if key == "" {
+				key = c.Author
+			}
+			a, ok := authors[m.Name()][key]
+			if !ok {
+				a = &AuthorChurn{Name: c.Author, Email: c.Email}
+				authors[m.Name()][key] = a
+				mc.Authors = append(mc.Authors, a)
+			}
+			a.Commits++
+			names = append(names, m.Name())
+		}
+
+		if len(names) > historyMaxCoChangeModules {
+			continue
+		}
+
+		sort.Strings(names)
+		for i := range names {
+			for j := i + 1; j < len(names); j++ {
+				coChanges[[2]string{names[i], names[j]}]++
+			}
+		}
+	}
+
+	for _, mc := range analysis.Modules {
+		sort.SliceStable(mc.Authors, func(i, j int) bool {
+			return mc.Authors[i].Commits > mc.Authors[j].Commits
+		})
+	}
+
+	dependencies := moduleDependencyClosure(mods)
+	for pair, n := range coChanges {
+		if n < minCoChanges || dependencies[pair[0]][pair[1]] || dependencies[pair[1]][pair[0]] {
+			continue
+		}
+
+		least := churn[pair[0]].Commits
+		if churn[pair[1]].Commits < least {
+			least = churn[pair[1]].Commits
+		}
+
+		analysis.Coupled = append(analysis.Coupled, &ModuleCoupling{
+			Modules: []string{pair[0], pair[1]},
+			Commits: n,
+			Ratio:   float64(n) / float64(least),
+		})
+	}
+
+	sort.Slice(analysis.Coupled, func(i, j int) bool {
+		a, b := analysis.Coupled[i], analysis.Coupled[j]
+		if a.Commits != b.Commits {
+			return a.Commits > b.Commits
+		}
+		if a.Ratio != b.Ratio {
+			return a.Ratio > b.Ratio
+		}
+		if a.Modules[0] != b.Modules[0] {
+			return a.Modules[0] < b.Modules[0]
+		}
+		return a.Modules[1] < b.Modules[1]
+	})
+
+	return analysis, mods, nil
+}
+
+// moduleDependencyClosure returns the modules each module depends on
+// directly or indirectly.
+func moduleDependencyClosure(mods Modules) map[string]map[string]bool {
+	closure := make(map[string]map[string]bool, len(mods))
+	// Modules are topologically sorted, hence the dependencies of a
+	// module are resolved before the module.
+	for _, m := range mods {
+		deps := make(map[string]bool)
+		for _, r := range m.Requires() {
+			deps[r.Name()] = true
+			for d := range closure[r.Name()] {
+				deps[d] = true
+			}
+		}
+		closure[m.Name()] = deps
+	}
+	return closure
+}
+
+// Write writes the analysis in the specified format.
+func (a *HistoryAnalysis) Write(format string, w io.Writer) error {
+	var (
+		buff []byte
+		err  error
+	)
+
+	switch format {
+	case HistoryFormatText:
+		buff = a.text()
+	case HistoryFormatJSON:
+		buff, err = json.MarshalIndent(a, "", "  ")
+		buff = append(buff, '\n')
+	default:
+		return e.NewErrorf(ErrClassUser, msgUnsupportedHistoryFormat, format)
+	}
+
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	if _, err := w.Write(buff); err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	return nil
+}
+
+func (a *HistoryAnalysis) text() []byte {
+	buff := new(bytes.Buffer)
+	fmt.Fprintf(buff, "Commits analysed: %v\n\n", a.Commits)
+
+	modules := make([]*ModuleChurn, len(a.Modules))
+	copy(modules, a.Modules)
+	sort.SliceStable(modules, func(i, j int) bool {
+		return modules[i].Commits > modules[j].Commits
+	})
+
+	tw := tabwriter.NewWriter(buff, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODULE\tCOMMITS\tAUTHORS\tTOP AUTHOR\tLAST CHANGE")
+	for _, m := range modules {
+		top, last := "-", "-"
+		if len(m.Authors) > 0 {
+			top = fmt.Sprintf("%s (%v)", m.Authors[0].Name, m.Authors[0].Commits)
+		}
+		if m.LastChange != nil {
+			last = m.LastChange.Format("2006-01-02")
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%v\t%s\t%s\n", m.Name, m.Commits, len(m.Authors), top, last)
+	}
+	tw.Flush()
+
+	buff.WriteString("\nHidden coupling (modules changed together without a dependency):\n")
+	if len(a.Coupled) == 0 {
+		buff.WriteString("None\n")
+		return buff.Bytes()
+	}
+
+	buff.WriteString("\n")
+	tw = tabwriter.NewWriter(buff, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODULES\tCOMMITS\tRATIO")
+	for _, c := range a.Coupled {
+		fmt.Fprintf(tw, "%s, %s\t%v\t%.0f%%\n", c.Modules[0], c.Modules[1], c.Commits, c.Ratio*100)
+	}
+	tw.Flush()
+
+	return buff.Bytes()
+}
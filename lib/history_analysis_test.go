@@ -0,0 +1,122 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func historyTestRepo(t *testing.T) *TestRepository {
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.InitModuleWithOptions("app-c", &Spec{Name: "app-c", Dependencies: []string{"app-d"}}))
+	check(t, repo.InitModule("app-d"))
+	check(t, repo.Commit("first"))
+
+	for i := 0; i < 3; i++ {
+		check(t, repo.WriteContent("app-a/foo", fmt.Sprint(i)))
+		check(t, repo.WriteContent("app-b/foo", fmt.Sprint(i)))
+		check(t, repo.WriteContent("app-c/foo", fmt.Sprint(i)))
+		check(t, repo.WriteContent("app-d/foo", fmt.Sprint(i)))
+		check(t, repo.Commit(fmt.Sprintf("change %v", i)))
+	}
+
+	check(t, repo.WriteContent("app-a/bar", "a"))
+	check(t, repo.Commit("change a"))
+	return repo
+}
+
+func TestAnalyseHistory(t *testing.T) {
+	clean()
+	repo := historyTestRepo(t)
+
+	analysis, err := NewWorld(t, ".tmp/repo").System.AnalyseHistory(&HistoryOptions{})
+	check(t, err)
+
+	assert.Equal(t, repo.LastCommit.String(), analysis.To)
+	assert.Equal(t, 4, analysis.Commits)
+
+	churn := make(map[string]*ModuleChurn)
+	for _, m := range analysis.Modules {
+		churn[m.Name] = m
+	}
+
+	assert.Equal(t, 4, churn["app-a"].Commits)
+	assert.Equal(t, 3, churn["app-b"].Commits)
+	assert.Len(t, churn["app-a"].Authors, 1)
+	assert.Equal(t, "alice", churn["app-a"].Authors[0].Name)
+	assert.Equal(t, 4, churn["app-a"].Authors[0].Commits)
+	assert.True(t, churn["app-a"].LastChange.After(*churn["app-a"].FirstChange) || churn["app-a"].LastChange.Equal(*churn["app-a"].FirstChange))
+
+	// app-c and app-d are changed together because of the dependency.
+	pairs := make([]string, 0)
+	for _, c := range analysis.Coupled {
+		pairs = append(pairs, strings.Join(c.Modules, ","))
+	}
+	assert.Equal(t, []string{"app-a,app-b", "app-a,app-c", "app-a,app-d", "app-b,app-c", "app-b,app-d"}, pairs[:5])
+	assert.Len(t, pairs, 5)
+	assert.Equal(t, 3, analysis.Coupled[0].Commits)
+	assert.Equal(t, 1.0, analysis.Coupled[0].Ratio)
+}
+
+func TestAnalyseHistoryRange(t *testing.T) {
+	clean()
+	historyTestRepo(t)
+
+	analysis, err := NewWorld(t, ".tmp/repo").System.AnalyseHistory(&HistoryOptions{From: "HEAD~2", MinCoChanges: 1})
+	check(t, err)
+
+	assert.Equal(t, 2, analysis.Commits)
+	assert.Len(t, analysis.Coupled, 5)
+	assert.Equal(t, 1, analysis.Coupled[0].Commits)
+	assert.Equal(t, 1.0, analysis.Coupled[0].Ratio)
+}
+
+func TestAnalyseHistoryOfUnknownRevision(t *testing.T) {
+	clean()
+	historyTestRepo(t)
+
+	_, err := NewWorld(t, ".tmp/repo").System.AnalyseHistory(&HistoryOptions{From: "missing"})
+
+	assert.EqualError(t, err, fmt.Sprintf(msgRevisionNotFound, "missing"))
+}
+
+func TestWriteHistoryAnalysis(t *testing.T) {
+	clean()
+	historyTestRepo(t)
+
+	analysis, err := NewWorld(t, ".tmp/repo").System.AnalyseHistory(&HistoryOptions{})
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	check(t, analysis.Write(HistoryFormatText, buff))
+	assert.Contains(t, buff.String(), "Commits analysed: 4\n")
+	assert.Regexp(t, `app-a\s+4\s+1\s+alice \(4\)`, buff.String())
+	assert.Regexp(t, `app-a, app-b\s+3\s+100%`, buff.String())
+
+	buff.Reset()
+	check(t, analysis.Write(HistoryFormatJSON, buff))
+	assert.Contains(t, buff.String(), `"coupled": [`)
+
+	err = analysis.Write("xml", buff)
+	assert.EqualError(t, err, fmt.Sprintf(msgUnsupportedHistoryFormat, "xml"))
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	hookPreBuild  = "preBuild"
+	hookPostBuild = "postBuild"
+	hookOnFailure = "onFailure"
+	hookSetup     = "setup"
+	hookTeardown  = "teardown"
+)
+
+// hookContext is the information passed to a hook via stdin as a
+// JSON document.
+type hookContext struct {
+	Hook     string      `json:"hook"`
+	Commit   string      `json:"commit"`
+	RepoPath string      `json:"repoPath"`
+	Module   *hookModule `json:"module,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+type hookModule struct {
+	Name       string                 `json:"name"`
+	Path       string                 `json:"path"`
+	Version    string                 `json:"version"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+// hooksFor returns the hooks of the specified kind applicable to a
+// module in the order of execution.
+// Repository hooks wrap the module hooks. That is, repository
+// preBuild hooks are executed before the module's and repository
+// postBuild/onFailure hooks are executed after the module's.
+func hooksFor(kind string, config *RepoConfig, mod *Module) []*Cmd {
+	selector := func(h *Hooks) []*Cmd {
+		if h == nil {
+			return nil
+		}
+		switch kind {
+		case hookPreBuild:
+			return h.PreBuild
+		case hookPostBuild:
+			return h.PostBuild
+		default:
+			return h.OnFailure
+		}
+	}
+
+	repoHooks := selector(config.Hooks)
+	modHooks := selector(mod.Hooks())
+
+	r := make([]*Cmd, 0, len(repoHooks)+len(modHooks))
+	if kind == hookPreBuild {
+		r = append(r, repoHooks...)
+		return append(r, modHooks...)
+	}
+
+	r = append(r, modHooks...)
+	return append(r, repoHooks...)
+}
+
+// execHooks runs the hooks of the specified kind for a module.
+// Hooks are executed with the same environment as the build command
+// and receive a JSON document describing the build on stdin.
+// Execution stops at the first failing hook.
+func (s *stdSystem) execHooks(kind string, config *RepoConfig, manifest *Manifest, mod *Module, options *CmdOptions, buildErr error) error {
+	hooks := hooksFor(kind, config, mod)
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	ctx := &hookContext{
+		Hook:     kind,
+		Commit:   manifest.Sha,
+		RepoPath: manifest.Dir,
+		Module: &hookModule{
+			Name:       mod.Name(),
+			Path:       mod.Path(),
+			Version:    mod.Version(),
+			Properties: mod.Properties(),
+		},
+	}
+	if buildErr != nil {
+		ctx.Error = buildErr.Error()
+	}
+
+	input, err := json.Marshal(ctx)
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	for _, h := range hooks {
+		hookOptions := *options
+		hookOptions.Stdin = bytes.NewReader(input)
+		s.Log.Debug("Executing %s hook %s for module %s", kind, h.Cmd, mod.Name())
+		err := s.execSpecCmd(manifest, mod, &hookOptions, h.Shell, h.Dir, h.Cmd, h.Args)
+		if err != nil {
+			return e.Wrapf(ErrClassUser, err, msgFailedHook, kind, h.Cmd, mod.Name())
+		}
+	}
+
+	return nil
+}
+
+// execBuildHooks runs the setup or teardown hooks of the repository
+// once for the whole build. Hooks are executed in the repository root
+// and receive a JSON document describing the build on stdin. Execution
+// stops at the first failing hook.
+func (s *stdSystem) execBuildHooks(kind string, config *RepoConfig, manifest *Manifest, options *CmdOptions, buildErr error) error {
+	if config.Hooks == nil {
+		return nil
+	}
+
+	hooks := config.Hooks.Setup
+	if kind == hookTeardown {
+		hooks = config.Hooks.Teardown
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	ctx := &hookContext{
+		Hook:     kind,
+		Commit:   manifest.Sha,
+		RepoPath: manifest.Dir,
+	}
+	if buildErr != nil {
+		ctx.Error = buildErr.Error()
+	}
+
+	input, err := json.Marshal(ctx)
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	for _, h := range hooks {
+		hookOptions := *options
+		hookOptions.Stdin = bytes.NewReader(input)
+		s.Log.Debug("Executing %s hook %s", kind, h.Cmd)
+		err := s.execSpecCmd(manifest, nil, &hookOptions, h.Shell, h.Dir, h.Cmd, h.Args)
+		if err != nil {
+			return e.Wrapf(ErrClassUser, err, msgFailedBuildHook, kind, h.Cmd)
+		}
+	}
+
+	return nil
+}
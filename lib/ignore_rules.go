@@ -0,0 +1,216 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ignoreFileName is the name of the files containing the patterns of
+// the paths excluded from the modules enclosing them.
+const ignoreFileName = ".mbtignore"
+
+// ignoreRule is a pattern in gitignore syntax excluding paths from a
+// module.
+type ignoreRule struct {
+	// base is the directory the pattern is relative to.
+	base    string
+	pattern string
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreRules is the list of rules applicable to a module in the order
+// of precedence (i.e. the last matching rule decides).
+type ignoreRules []*ignoreRule
+
+// parseIgnoreRules creates the rules for the patterns relative to base.
+// Blank lines and lines starting with # are skipped.
+func parseIgnoreRules(base string, patterns []string) ignoreRules {
+	rules := ignoreRules{}
+	for _, p := range patterns {
+		p = strings.TrimRight(p, " \t\r")
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+
+		r := &ignoreRule{base: base}
+		if strings.HasPrefix(p, "!") {
+			r.negate = true
+			p = p[1:]
+		} else if strings.HasPrefix(p, `\`) {
+			// Escaped # or ! at the beginning of the pattern.
+			p = p[1:]
+		}
+
+		if strings.HasSuffix(p, "/") {
+			r.dirOnly = true
+			p = strings.TrimRight(p, "/")
+		}
+
+		// Patterns without a slash match at any level below base,
+		// the rest are relative to base.
+		if strings.Contains(p, "/") {
+			p = strings.TrimPrefix(p, "/")
+		} else {
+			p = "**/" + p
+		}
+
+		if p == "" {
+			continue
+		}
+
+		r.pattern = p
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+func (r *ignoreRule) match(p string, dir bool) bool {
+	if r.dirOnly && !dir {
+		return false
+	}
+
+	if r.base != "" {
+		if !strings.HasPrefix(p, r.base+"/") {
+			return false
+		}
+		p = p[len(r.base)+1:]
+	}
+
+	return matchGlob(r.pattern, p)
+}
+
+// excludes reports whether the path relative to the repository root
+// is excluded. As in git, a path is excluded if one of its parent
+// directories is excluded.
+func (rules ignoreRules) excludes(p string) bool {
+	if len(rules) == 0 {
+		return false
+	}
+
+	segments := strings.Split(p, "/")
+	for i := 1; i <= len(segments); i++ {
+		if rules.lastMatchExcludes(strings.Join(segments[:i], "/"), i < len(segments)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rules ignoreRules) lastMatchExcludes(p string, dir bool) bool {
+	excluded := false
+	for _, r := range rules {
+		if r.match(p, dir) {
+			excluded = !r.negate
+		}
+	}
+	return excluded
+}
+
+// inSubmodule returns the rules with the paths relative to the
+// repository containing the submodule at dir.
+func (rules ignoreRules) inSubmodule(dir string) ignoreRules {
+	r := make(ignoreRules, 0, len(rules))
+	for _, rule := range rules {
+		c := *rule
+		c.base = path.Join(dir, rule.base)
+		r = append(r, &c)
+	}
+	return r
+}
+
+// withIgnoreRules sets up the rules of the modules in the set from
+// their specs and the .mbtignore files keyed by their directories.
+// Files apply to all modules containing them, the ones in the deeper
+// directories taking precedence.
+func (a moduleMetadataSet) withIgnoreRules(files map[string][]byte) moduleMetadataSet {
+	dirs := make([]string, 0, len(files))
+	for d := range files {
+		dirs = append(dirs, d)
+	}
+	// Parent directories are sorted before their children.
+	sort.Strings(dirs)
+
+	for _, m := range a {
+		m.ignore = parseIgnoreRules(m.dir, m.spec.Ignore)
+		for _, d := range dirs {
+			if m.contains(d) {
+				m.ignore = append(m.ignore, parseIgnoreRules(d, strings.Split(string(files[d]), "\n"))...)
+			}
+		}
+	}
+	return a
+}
+
+// included returns the modules in the set except the ones with specs
+// excluded by the rules of the modules containing them.
+func (a moduleMetadataSet) included() moduleMetadataSet {
+	r := make(moduleMetadataSet, 0, len(a))
+	for _, m := range a {
+		excluded := false
+		for _, o := range a {
+			if o != m && o.dir != m.dir && o.contains(m.dir) && o.ignore.excludes(path.Join(m.dir, configFileName)) {
+				excluded = true
+				break
+			}
+		}
+
+		if !excluded {
+			r = append(r, m)
+		}
+	}
+	return r
+}
+
+// contains reports whether dir is the module directory or one of its
+// subdirectories.
+func (m *moduleMetadata) contains(dir string) bool {
+	return m.dir == "" || dir == m.dir || strings.HasPrefix(dir, m.dir+"/")
+}
+
+// withExcludedHashes derives the hashes of the modules with ignore
+// rules from the files not excluded so that changing an excluded file
+// does not change the version.
+func (a moduleMetadataSet) withExcludedHashes(repo Repo, commit Commit) error {
+	return forEach(len(a), func(i int) error {
+		m := a[i]
+		if len(m.ignore) == 0 {
+			return nil
+		}
+
+		h := sha1.New()
+		err := repo.WalkBlobsInDir(commit, m.dir, func(b Blob) error {
+			p := b.Path() + b.Name()
+			if !m.ignore.excludes(p) {
+				io.WriteString(h, p)
+				io.WriteString(h, b.ID())
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		m.hash = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	rules := parseIgnoreRules("app", []string{
+		"# comment",
+		"",
+		"*.log",
+		"!keep.log",
+		"/build",
+		"docs/",
+		"src/**/fixtures",
+		`\#notes`,
+	})
+
+	assert.True(t, rules.excludes("app/debug.log"))
+	assert.True(t, rules.excludes("app/src/debug.log"))
+	assert.False(t, rules.excludes("app/keep.log"))
+	assert.True(t, rules.excludes("app/build/out.bin"))
+	assert.False(t, rules.excludes("app/src/build/out.bin"))
+	assert.True(t, rules.excludes("app/docs/index.md"))
+	assert.True(t, rules.excludes("app/src/docs/index.md"))
+	assert.False(t, rules.excludes("app/docs"))
+	assert.True(t, rules.excludes("app/src/a/b/fixtures/data.json"))
+	assert.True(t, rules.excludes("app/#notes"))
+	assert.False(t, rules.excludes("app/main.go"))
+	assert.False(t, rules.excludes("other/debug.log"))
+	assert.False(t, ignoreRules(nil).excludes("app/debug.log"))
+}
+
+func TestIgnoreRulesOfParentDirectory(t *testing.T) {
+	rules := parseIgnoreRules("", []string{"out/", "!out/keep.txt"})
+
+	// Like git, files are not included again when their directory is
+	// excluded.
+	assert.True(t, rules.excludes("out/keep.txt"))
+}
+
+func initIgnoreRepo(t *testing.T) *TestRepository {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Ignore: []string{"docs/"}}))
+	check(t, repo.WriteContent("app-a/.mbtignore", "*.md\n!CHANGELOG.md\ntestdata/\n"))
+	check(t, repo.WriteContent("app-a/main.go", "package main"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.Commit("first"))
+	return repo
+}
+
+func TestChangesOfExcludedFiles(t *testing.T) {
+	repo := initIgnoreRepo(t)
+	w := NewWorld(t, ".tmp/repo")
+	m1, err := w.System.ManifestByCurrentBranch()
+	check(t, err)
+	c1 := repo.LastCommit.String()
+
+	check(t, repo.WriteContent("app-a/README.md", "readme"))
+	check(t, repo.WriteContent("app-a/docs/guide.txt", "guide"))
+	check(t, repo.Commit("second"))
+
+	m2, err := w.System.ManifestByCurrentBranch()
+	check(t, err)
+	assert.Equal(t, m1.Modules.indexByName()["app-a"].Version(), m2.Modules.indexByName()["app-a"].Version())
+
+	diff, err := w.System.ManifestByDiff(c1, repo.LastCommit.String())
+	check(t, err)
+	assert.Empty(t, diff.Modules)
+
+	check(t, repo.WriteContent("app-a/CHANGELOG.md", "changes"))
+	check(t, repo.Commit("third"))
+
+	m3, err := w.System.ManifestByCurrentBranch()
+	check(t, err)
+	assert.NotEqual(t, m1.Modules.indexByName()["app-a"].Version(), m3.Modules.indexByName()["app-a"].Version())
+	assert.Equal(t, m1.Modules.indexByName()["app-b"].Version(), m3.Modules.indexByName()["app-b"].Version())
+
+	diff, err = w.System.ManifestByDiff(c1, repo.LastCommit.String())
+	check(t, err)
+	assert.Equal(t, []string{"app-a"}, moduleNames(diff.Modules))
+}
+
+func TestModulesInExcludedDirectories(t *testing.T) {
+	repo := initIgnoreRepo(t)
+	check(t, repo.InitModule("app-a/testdata/fixture"))
+	check(t, repo.Commit("second"))
+	w := NewWorld(t, ".tmp/repo")
+
+	m, err := w.System.ManifestByCurrentBranch()
+	check(t, err)
+	assert.Equal(t, []string{"app-a", "app-b"}, moduleNames(m.Modules))
+
+	m, err = w.System.ManifestByWorkspace()
+	check(t, err)
+	assert.Equal(t, []string{"app-a", "app-b"}, moduleNames(m.Modules))
+}
+
+func TestExcludedModuleFiles(t *testing.T) {
+	repo := initIgnoreRepo(t)
+	check(t, repo.WriteContent("app-a/README.md", "readme"))
+	check(t, repo.WriteContent("app-a/docs/guide.txt", "guide"))
+	check(t, repo.Commit("second"))
+	w := NewWorld(t, ".tmp/repo")
+
+	expected := []string{"app-a/.mbt.yml", "app-a/.mbtignore", "app-a/main.go"}
+	m, err := w.System.ManifestByCurrentBranch()
+	check(t, err)
+	mod, _ := m.Module("app-a")
+	files, err := mod.Files()
+	check(t, err)
+	assert.Equal(t, expected, moduleFilePaths(files))
+
+	m, err = w.System.ManifestByWorkspace()
+	check(t, err)
+	mod, _ = m.Module("app-a")
+	files, err = mod.Files()
+	check(t, err)
+	assert.Equal(t, expected, moduleFilePaths(files))
+}
+
+func TestIncrementalDiscoveryWithIgnoreFiles(t *testing.T) {
+	repo := initIgnoreRepo(t)
+	check(t, repo.InitModule("app-a/testdata/fixture"))
+	check(t, repo.WriteContent("app-a/testdata/fixture/README.md", "readme"))
+	check(t, repo.Commit("second"))
+
+	world := NewWorld(t, ".tmp/repo")
+	c1, err := world.Repo.GetCommit(repo.LastCommit.String())
+	check(t, err)
+	mods, err := world.Discover.ModulesInCommit(c1)
+	check(t, err)
+	assert.Equal(t, []string{"app-a", "app-b"}, moduleNames(mods))
+
+	check(t, repo.WriteContent("app-a/.mbtignore", "*.md\n"))
+	check(t, repo.Commit("third"))
+
+	c2, err := world.Repo.GetCommit(repo.LastCommit.String())
+	check(t, err)
+	set, err := metadataInCommit(world.Repo, c2)
+	check(t, err)
+	expected, err := toModules(set)
+	check(t, err)
+
+	world.Repo.Interceptor.Config("WalkBlobs").Return(errors.New("doh"))
+	mods, err = world.Discover.ModulesInCommit(c2)
+	check(t, err)
+
+	assert.Equal(t, []string{"app-a", "fixture", "app-b"}, moduleNames(mods))
+	for _, m := range expected {
+		assert.Equal(t, m.Version(), mods.indexByName()[m.Name()].Version())
+	}
+}
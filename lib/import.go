@@ -0,0 +1,179 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yaml "github.com/go-yaml/yaml"
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// ImportBazel imports the packages of a Bazel workspace.
+	ImportBazel = "bazel"
+	// ImportGradle imports the projects of a Gradle build.
+	ImportGradle = "gradle"
+)
+
+// ImportOptions specifies how modules are imported from another
+// build system.
+type ImportOptions struct {
+	// Source is the build system to import from (bazel or gradle).
+	Source string
+	// Query is the output of bazel query --output=proto. Required
+	// for bazel.
+	Query string
+	// Dir is the directory of the Bazel workspace or the Gradle
+	// build relative to the repository root.
+	Dir string
+	// Force replaces the existing module specs.
+	Force bool
+	// DryRun returns the modules without writing their specs.
+	DryRun bool
+}
+
+// ImportedModule is a module created from a package of another build
+// system.
+type ImportedModule struct {
+	Name string
+	// Path of the module relative to the repository root.
+	Path         string
+	Dependencies []string
+	Build        *Cmd
+}
+
+// importedSpec is the spec written for an imported module.
+type importedSpec struct {
+	Name         string          `yaml:"name"`
+	Build        map[string]*Cmd `yaml:"build,omitempty"`
+	Dependencies []string        `yaml:"dependencies,omitempty"`
+}
+
+func (s *stdSystem) Import(options *ImportOptions) ([]*ImportedModule, error) {
+	root := filepath.Join(s.Repo.Path(), options.Dir)
+
+	var (
+		modules []*ImportedModule
+		err     error
+	)
+
+	switch options.Source {
+	case ImportBazel:
+		modules, err = importBazel(options.Query)
+	case ImportGradle:
+		modules, err = importGradle(root)
+	default:
+		return nil, e.NewErrorf(ErrClassUser, msgUnsupportedImportSource, options.Source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range modules {
+		m.Path = path.Join(filepath.ToSlash(options.Dir), m.Path)
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Path < modules[j].Path })
+
+	if options.DryRun {
+		return modules, nil
+	}
+
+	// Check all specs before writing any, so that a failed import
+	// does not leave the repository half converted.
+	if !options.Force {
+		for _, m := range modules {
+			p := filepath.Join(s.Repo.Path(), m.Path, ".mbt.yml")
+			if _, err := os.Stat(p); err == nil {
+				return nil, e.NewErrorf(ErrClassUser, msgSpecExists, p)
+			}
+		}
+	}
+
+	for _, m := range modules {
+		err = writeImportedSpec(filepath.Join(s.Repo.Path(), m.Path), m)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return modules, nil
+}
+
+// importedModules creates the modules of the packages in a dependency
+// graph keyed by the package path. Dependencies on the packages not
+// in the graph are ignored.
+func importedModules(graph map[string]map[string]bool, build func(string) *Cmd) ([]*ImportedModule, error) {
+	names := make(map[string]string, len(graph))
+	for p := range graph {
+		n := importedModuleName(p)
+		if other, ok := names[n]; ok {
+			return nil, e.NewErrorf(ErrClassUser, msgConflictingImportedModules, other, p, n)
+		}
+		names[n] = p
+	}
+
+	modules := make([]*ImportedModule, 0, len(graph))
+	for p, deps := range graph {
+		m := &ImportedModule{Name: importedModuleName(p), Path: p, Dependencies: make([]string, 0), Build: build(p)}
+		for d := range deps {
+			if _, ok := graph[d]; ok && d != p {
+				m.Dependencies = append(m.Dependencies, importedModuleName(d))
+			}
+		}
+		sort.Strings(m.Dependencies)
+		modules = append(modules, m)
+	}
+
+	return modules, nil
+}
+
+// importedModuleName returns the name of the module of a package
+// (e.g. services-api for services/api).
+func importedModuleName(p string) string {
+	return strings.Replace(p, "/", "-", -1)
+}
+
+// writeImportedSpec writes the spec of a module. Build is omitted if
+// the module does not have a build command.
+func writeImportedSpec(dir string, m *ImportedModule) error {
+	spec := &importedSpec{Name: m.Name, Dependencies: m.Dependencies}
+	if m.Build != nil {
+		spec.Build = map[string]*Cmd{"default": m.Build}
+	}
+
+	buff, err := yaml.Marshal(spec)
+	if err != nil {
+		return e.Wrapf(ErrClassInternal, err, msgFailedWriteImportedSpec, m.Name)
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return e.Wrapf(ErrClassUser, err, msgFailedWriteImportedSpec, m.Name)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, ".mbt.yml"), buff, 0644)
+	if err != nil {
+		return e.Wrapf(ErrClassUser, err, msgFailedWriteImportedSpec, m.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// Field numbers of the messages in the output of
+// bazel query --output=proto (src/main/protobuf/build.proto).
+const (
+	bazelQueryResultTarget = 1
+	bazelTargetRule        = 2
+	bazelRuleName          = 1
+	bazelRuleInput         = 5
+)
+
+// Protocol buffers wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// importBazel creates a module for each package with rules in the
+// query result at file. Labels in the inputs of the rules determine
+// the dependencies between the packages. Rules in the root package
+// and inputs from external repositories are ignored.
+func importBazel(file string) ([]*ImportedModule, error) {
+	if file == "" {
+		return nil, e.NewErrorf(ErrClassUser, msgMissingBazelQuery)
+	}
+
+	buff, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedReadBazelQuery, file)
+	}
+
+	graph := make(map[string]map[string]bool)
+	err = protoFields(buff, func(n, t int, v []byte) error {
+		if n != bazelQueryResultTarget || t != wireBytes {
+			return nil
+		}
+
+		return protoFields(v, func(n, t int, v []byte) error {
+			if n != bazelTargetRule || t != wireBytes {
+				return nil
+			}
+			return addBazelRule(graph, v)
+		})
+	})
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedReadBazelQuery, file)
+	}
+
+	return importedModules(graph, func(p string) *Cmd {
+		return &Cmd{Cmd: "bazel", Args: []string{"build", "//" + p + ":all"}}
+	})
+}
+
+func addBazelRule(graph map[string]map[string]bool, rule []byte) error {
+	var (
+		name   string
+		inputs []string
+	)
+
+	err := protoFields(rule, func(n, t int, v []byte) error {
+		if t != wireBytes {
+			return nil
+		}
+
+		switch n {
+		case bazelRuleName:
+			name = string(v)
+		case bazelRuleInput:
+			inputs = append(inputs, string(v))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	pkg, ok := bazelPackage(name)
+	if !ok || pkg == "" {
+		return nil
+	}
+
+	deps, ok := graph[pkg]
+	if !ok {
+		deps = make(map[string]bool)
+		graph[pkg] = deps
+	}
+
+	for _, i := range inputs {
+		if d, ok := bazelPackage(i); ok && d != "" {
+			deps[d] = true
+		}
+	}
+
+	return nil
+}
+
+// bazelPackage returns the package of a label in the main repository
+// (e.g. foo/bar for //foo/bar:baz). False is returned for the labels
+// in external repositories.
+func bazelPackage(label string) (string, bool) {
+	if strings.HasPrefix(label, "@") {
+		i := strings.Index(label, "//")
+		if i < 0 || strings.Trim(label[:i], "@") != "" {
+			return "", false
+		}
+		label = label[i:]
+	}
+
+	if !strings.HasPrefix(label, "//") {
+		return "", false
+	}
+
+	label = strings.TrimPrefix(label, "//")
+	if i := strings.Index(label, ":"); i >= 0 {
+		label = label[:i]
+	}
+	return label, true
+}
+
+// protoFields calls fn with the number, the wire type and the value of
+// each field in a protocol buffers message. Value of a length delimited
+// field is its content. Values of the other wire types are not decoded.
+func protoFields(buff []byte, fn func(int, int, []byte) error) error {
+	for len(buff) > 0 {
+		key, n := binary.Uvarint(buff)
+		if n <= 0 {
+			return e.NewErrorf(ErrClassUser, msgInvalidProtobuf)
+		}
+		buff = buff[n:]
+
+		var v []byte
+		t := int(key & 7)
+		switch t {
+		case wireVarint:
+			_, n = binary.Uvarint(buff)
+			if n <= 0 {
+				return e.NewErrorf(ErrClassUser, msgInvalidProtobuf)
+			}
+			v, buff = buff[:n], buff[n:]
+		case wireFixed64, wireFixed32:
+			size := 8
+			if t == wireFixed32 {
+				size = 4
+			}
+			if len(buff) < size {
+				return e.NewErrorf(ErrClassUser, msgInvalidProtobuf)
+			}
+			v, buff = buff[:size], buff[size:]
+		case wireBytes:
+			l, n := binary.Uvarint(buff)
+			if n <= 0 || uint64(len(buff)-n) < l {
+				return e.NewErrorf(ErrClassUser, msgInvalidProtobuf)
+			}
+			v, buff = buff[n:n+int(l)], buff[n+int(l):]
+		default:
+			return e.NewErrorf(ErrClassUser, msgInvalidProtobuf)
+		}
+
+		if err := fn(int(key>>3), t, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
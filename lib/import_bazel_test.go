@@ -0,0 +1,175 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	yaml "github.com/go-yaml/yaml"
+	"github.com/stretchr/testify/assert"
+)
+
+// protoBytes encodes a length delimited protocol buffers field.
+func protoBytes(n int, v []byte) []byte {
+	buff := make([]byte, 2*binary.MaxVarintLen64)
+	l := binary.PutUvarint(buff, uint64(n<<3|wireBytes))
+	l += binary.PutUvarint(buff[l:], uint64(len(v)))
+	return append(buff[:l], v...)
+}
+
+func bazelRule(name string, inputs ...string) []byte {
+	rule := protoBytes(bazelRuleName, []byte(name))
+	rule = append(rule, protoBytes(2, []byte("go_library"))...)
+	for _, i := range inputs {
+		rule = append(rule, protoBytes(bazelRuleInput, []byte(i))...)
+	}
+
+	// Type of the target (RULE) precedes the rule.
+	target := []byte{1 << 3, 1}
+	target = append(target, protoBytes(bazelTargetRule, rule)...)
+	return protoBytes(bazelQueryResultTarget, target)
+}
+
+func writeBazelQuery(t *testing.T, targets ...[]byte) string {
+	p, err := filepath.Abs(".tmp/query.pb")
+	check(t, err)
+
+	buff := make([]byte, 0)
+	for _, target := range targets {
+		buff = append(buff, target...)
+	}
+	check(t, ioutil.WriteFile(p, buff, 0644))
+	return p
+}
+
+func TestImportBazel(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.Commit("first"))
+
+	query := writeBazelQuery(t,
+		bazelRule("//services/api:api", "//services/api:main.go", "//lib/core:core", "@com_github_x//:x"),
+		bazelRule("//services/api:api_test", "//services/api:api", "@@//lib/log:log"),
+		bazelRule("//lib/core:core", "//lib/core:core.go"),
+		bazelRule("//lib/log:log"),
+		bazelRule("//:gazelle", "//services/api:api"),
+	)
+
+	modules, err := NewWorld(t, ".tmp/repo").System.Import(&ImportOptions{Source: ImportBazel, Query: query})
+	check(t, err)
+
+	assert.Len(t, modules, 3)
+	assert.Equal(t, "lib-core", modules[0].Name)
+	assert.Equal(t, "lib/core", modules[0].Path)
+	assert.Empty(t, modules[0].Dependencies)
+	assert.Equal(t, "lib-log", modules[1].Name)
+	assert.Equal(t, "services-api", modules[2].Name)
+	assert.Equal(t, []string{"lib-core", "lib-log"}, modules[2].Dependencies)
+	assert.Equal(t, &Cmd{Cmd: "bazel", Args: []string{"build", "//services/api:all"}}, modules[2].Build)
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByWorkspace()
+	check(t, err)
+	assert.Len(t, m.Modules, 3)
+	api := m.Modules.indexByName()["services-api"]
+	assert.Len(t, api.Requires(), 2)
+}
+
+func TestImportBazelForExistingSpec(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("lib/core"))
+	check(t, repo.Commit("first"))
+
+	query := writeBazelQuery(t, bazelRule("//lib/core:core"), bazelRule("//lib/log:log"))
+	world := NewWorld(t, ".tmp/repo")
+
+	_, err := world.System.Import(&ImportOptions{Source: ImportBazel, Query: query})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "already exists, use --force to replace it")
+	_, err = os.Stat(".tmp/repo/lib/log/.mbt.yml")
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = world.System.Import(&ImportOptions{Source: ImportBazel, Query: query, Force: true})
+	check(t, err)
+
+	spec := &Spec{}
+	buff, err := ioutil.ReadFile(".tmp/repo/lib/core/.mbt.yml")
+	check(t, err)
+	check(t, yaml.Unmarshal(buff, spec))
+	assert.Equal(t, "lib-core", spec.Name)
+}
+
+func TestImportBazelDryRun(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.Commit("first"))
+
+	query := writeBazelQuery(t, bazelRule("//app:app"))
+	modules, err := NewWorld(t, ".tmp/repo").System.Import(&ImportOptions{Source: ImportBazel, Query: query, Dir: "bazel", DryRun: true})
+	check(t, err)
+
+	assert.Equal(t, "bazel/app", modules[0].Path)
+	_, err = os.Stat(".tmp/repo/bazel/app/.mbt.yml")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestImportBazelForInvalidQuery(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.Commit("first"))
+
+	query := writeBazelQuery(t, []byte{1<<3 | wireBytes, 10, 1})
+	_, err := NewWorld(t, ".tmp/repo").System.Import(&ImportOptions{Source: ImportBazel, Query: query})
+
+	assert.EqualError(t, err, "Failed to read the bazel query result in "+query)
+}
+
+func TestBazelPackage(t *testing.T) {
+	cases := map[string]string{
+		"//a/b:c":   "a/b",
+		"//a/b":     "a/b",
+		"//:c":      "",
+		"@//a:b":    "a",
+		"@@//a:b":   "a",
+		"@x//a:b":   "-",
+		"@@x~//a:b": "-",
+		"c.go":      "-",
+	}
+
+	for label, expected := range cases {
+		p, ok := bazelPackage(label)
+		if expected == "-" {
+			assert.False(t, ok, label)
+		} else {
+			assert.True(t, ok, label)
+			assert.Equal(t, expected, p, label)
+		}
+	}
+}
+
+func TestUnsupportedImportSource(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.Import(&ImportOptions{Source: "maven"})
+
+	assert.EqualError(t, err, "Unsupported source maven, supported sources are bazel and gradle")
+}
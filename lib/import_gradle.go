@@ -0,0 +1,112 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+var (
+	gradleInclude    = regexp.MustCompile(`(?m)^\s*include\b[ \t(]*((?:["'][^"'\n]*["'][ \t]*,?[ \t]*\n?[ \t]*)+)`)
+	gradleString     = regexp.MustCompile(`["']([^"'\n]*)["']`)
+	gradleProjectDir = regexp.MustCompile(`project\(\s*["']([^"']+)["']\s*\)\.projectDir\s*=\s*file\(\s*["']([^"']+)["']\s*\)`)
+	gradleProjectDep = regexp.MustCompile(`project\(\s*(?:path\s*[:=]\s*)?["'](:[^"']*)["']`)
+)
+
+// importGradle creates a module for each project included in the
+// settings of the Gradle build in root. References to other projects
+// (i.e. project(':path')) in the build script of a project determine
+// its dependencies. The root project is not imported.
+func importGradle(root string) ([]*ImportedModule, error) {
+	settings, err := readGradleScript(root, "settings.gradle")
+	if err != nil {
+		return nil, err
+	}
+	if settings == "" {
+		return nil, e.NewErrorf(ErrClassUser, msgMissingGradleSettings, root)
+	}
+
+	dirs := make(map[string]string)
+	for _, m := range gradleInclude.FindAllStringSubmatch(settings, -1) {
+		for _, s := range gradleString.FindAllStringSubmatch(m[1], -1) {
+			p := ":" + strings.TrimPrefix(s[1], ":")
+			if p != ":" {
+				dirs[p] = strings.Replace(strings.TrimPrefix(p, ":"), ":", "/", -1)
+			}
+		}
+	}
+
+	for _, m := range gradleProjectDir.FindAllStringSubmatch(settings, -1) {
+		if _, ok := dirs[m[1]]; ok {
+			dirs[m[1]] = path.Clean(filepath.ToSlash(m[2]))
+		}
+	}
+
+	graph := make(map[string]map[string]bool)
+	paths := make(map[string]string)
+	for p, dir := range dirs {
+		script, err := readGradleScript(filepath.Join(root, dir), "build.gradle")
+		if err != nil {
+			return nil, err
+		}
+
+		deps := make(map[string]bool)
+		for _, m := range gradleProjectDep.FindAllStringSubmatch(script, -1) {
+			if d, ok := dirs[m[1]]; ok {
+				deps[d] = true
+			}
+		}
+
+		graph[dir] = deps
+		paths[dir] = p
+	}
+
+	wrapper := ""
+	if _, err := os.Stat(filepath.Join(root, "gradlew")); err == nil {
+		wrapper = "gradlew"
+	}
+
+	return importedModules(graph, func(dir string) *Cmd {
+		c := "gradle"
+		if wrapper != "" {
+			c = strings.Repeat("../", strings.Count(dir, "/")+1) + wrapper
+		}
+		return &Cmd{Cmd: c, Args: []string{paths[dir] + ":build"}}
+	})
+}
+
+// readGradleScript reads the groovy or the kotlin variant of a gradle
+// script in dir. An empty string is returned if neither exists.
+func readGradleScript(dir, name string) (string, error) {
+	for _, n := range []string{name, name + ".kts"} {
+		buff, err := ioutil.ReadFile(filepath.Join(dir, n))
+		if err == nil {
+			return string(buff), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", e.Wrapf(ErrClassUser, err, msgFailedReadGradleScript, filepath.Join(dir, n))
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportGradle(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteContent("settings.gradle", `rootProject.name = 'acme'
+include ':app', ':lib:core',
+        ':lib:log'
+project(':lib:log').projectDir = file('logging')
+`))
+	check(t, repo.WriteContent("app/build.gradle", `dependencies {
+    implementation project(':lib:core')
+    implementation project(path: ':lib:log', configuration: 'default')
+    implementation 'com.google.guava:guava:32.1.2-jre'
+}
+`))
+	check(t, repo.WriteContent("lib/core/build.gradle.kts", `dependencies {
+    api(project(":lib:log"))
+}
+`))
+	check(t, repo.WriteContent("logging/build.gradle", "apply plugin: 'java'\n"))
+	check(t, repo.Commit("first"))
+
+	modules, err := NewWorld(t, ".tmp/repo").System.Import(&ImportOptions{Source: ImportGradle})
+	check(t, err)
+
+	assert.Len(t, modules, 3)
+	app, core, log := modules[0], modules[1], modules[2]
+	assert.Equal(t, "app", app.Name)
+	assert.Equal(t, []string{"lib-core", "logging"}, app.Dependencies)
+	assert.Equal(t, &Cmd{Cmd: "gradle", Args: []string{":app:build"}}, app.Build)
+
+	assert.Equal(t, "lib/core", core.Path)
+	assert.Equal(t, []string{"logging"}, core.Dependencies)
+
+	assert.Equal(t, "logging", log.Path)
+	assert.Empty(t, log.Dependencies)
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByWorkspace()
+	check(t, err)
+	assert.Len(t, m.Modules, 3)
+}
+
+func TestImportGradleWithWrapper(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteContent("build/settings.gradle.kts", `include("services:api")`))
+	check(t, repo.WriteContent("build/gradlew", "#!/bin/sh\n"))
+	check(t, repo.Commit("first"))
+
+	modules, err := NewWorld(t, ".tmp/repo").System.Import(&ImportOptions{Source: ImportGradle, Dir: "build", DryRun: true})
+	check(t, err)
+
+	assert.Len(t, modules, 1)
+	assert.Equal(t, "build/services/api", modules[0].Path)
+	assert.Equal(t, "services-api", modules[0].Name)
+	assert.Equal(t, &Cmd{Cmd: "../../gradlew", Args: []string{":services:api:build"}}, modules[0].Build)
+}
+
+func TestImportGradleWithoutSettings(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.Import(&ImportOptions{Source: ImportGradle})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "settings.gradle or settings.gradle.kts is not found in")
+}
@@ -107,11 +107,13 @@ func (s *stdSystem) intersectionCore(first, second Commit) (Modules, error) {
 	merge(firstSetWithDeps, secondMap, intersection)
 	merge(secondSetWithDeps, firstMap, intersection)
 
-	result := make([]*Module, len(intersection))
-	i := 0
-	for _, v := range intersection {
-		result[i] = v
-		i++
+	// Intersection is returned in the topological order of the modules
+	// in second commit, which contains all modules in the intersection.
+	result := make([]*Module, 0, len(intersection))
+	for _, a := range modules {
+		if v, ok := intersection[a.Name()]; ok {
+			result = append(result, v)
+		}
 	}
 
 	return result, nil
@@ -0,0 +1,188 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// defaultIssuePattern matches Jira style issue keys (e.g. PROJ-123).
+const defaultIssuePattern = `\b[A-Z][A-Z0-9]+-[0-9]+\b`
+
+// IssueTracker specifies how the issues referenced in commit messages
+// are recognised and linked.
+type IssueTracker struct {
+	// Pattern is the regular expression matching the issue keys.
+	// Defaults to Jira style keys (e.g. PROJ-123).
+	Pattern string `yaml:"pattern,omitempty"`
+	// URL of an issue in markdown output. {key} is replaced with the
+	// issue key (e.g. https://example.atlassian.net/browse/{key}).
+	URL string `yaml:"url,omitempty"`
+}
+
+// issueMatcher extracts the issue keys from commit messages.
+type issueMatcher struct {
+	re  *regexp.Regexp
+	url string
+}
+
+func newIssueMatcher(tracker *IssueTracker) (*issueMatcher, error) {
+	if tracker == nil {
+		tracker = &IssueTracker{}
+	}
+
+	pattern := tracker.Pattern
+	if pattern == "" {
+		pattern = defaultIssuePattern
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, configError(e.Wrapf(ErrClassUser, err, msgInvalidIssuePattern, pattern))
+	}
+
+	return &issueMatcher{re: re, url: tracker.URL}, nil
+}
+
+// keys returns the distinct issue keys in a message in the order they
+// are referenced.
+func (m *issueMatcher) keys(message string) []string {
+	seen := make(map[string]bool)
+	keys := make([]string, 0)
+	for _, k := range m.re.FindAllString(message, -1) {
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// markdownIssues formats the keys as a comma separated list of links
+// if the url of the issues is known.
+func markdownIssues(url string, keys []string) string {
+	items := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if url == "" {
+			items = append(items, k)
+		} else {
+			items = append(items, fmt.Sprintf("[%s](%s)", k, strings.Replace(url, "{key}", k, -1)))
+		}
+	}
+	return strings.Join(items, ", ")
+}
+
+func (s *stdSystem) Issues(from, to string) (map[string][]string, error) {
+	fromCommit, err := s.Repo.ResolveCommit(from)
+	if err != nil {
+		return nil, err
+	}
+
+	toCommit, err := s.Repo.ResolveCommit(to)
+	if err != nil {
+		return nil, err
+	}
+
+	mods, err := s.Discover.ModulesInCommit(toCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	matcher, err := s.issueMatcher(toCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issues(matcher, fromCommit, toCommit, mods)
+}
+
+// issueMatcher creates the matcher of the issue tracker in the
+// configuration of a commit.
+func (s *stdSystem) issueMatcher(commit Commit) (*issueMatcher, error) {
+	config, _, err := s.partialsInCommit(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	return newIssueMatcher(config.Issues)
+}
+
+// issues returns the sorted keys of the issues referenced by the
+// commits between from and to for each module in mods changed by those
+// commits directly or through its dependencies.
+func (s *stdSystem) issues(matcher *issueMatcher, from, to Commit, mods Modules) (map[string][]string, error) {
+	history, err := s.Repo.History(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := make(map[string][]string)
+	for _, c := range history {
+		// Merge commits are excluded like in changelogs.
+		keys := matcher.keys(c.Message)
+		if len(keys) == 0 || c.Parents > 1 {
+			continue
+		}
+
+		deltas, err := s.Repo.Changes(c.Commit)
+		if err != nil {
+			return nil, err
+		}
+
+		impacted, err := s.Reducer.Reduce(mods, deltas)
+		if err != nil {
+			return nil, err
+		}
+
+		impacted, err = impacted.expandRequiredByDependencies()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range impacted {
+			referenced[m.Name()] = append(referenced[m.Name()], keys...)
+		}
+	}
+
+	issues := make(map[string][]string, len(referenced))
+	for name, keys := range referenced {
+		issues[name] = sortedIssues(keys)
+	}
+
+	return issues, nil
+}
+
+// sortedIssues returns the distinct keys in lists in alphabetical order.
+func sortedIssues(lists ...[]string) []string {
+	set := make(map[string]bool)
+	for _, l := range lists {
+		for _, k := range l {
+			set[k] = true
+		}
+	}
+
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func initIssuesRepo(t *testing.T, config *RepoConfig) (*TestRepository, string) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	if config != nil {
+		check(t, repo.WriteConfig(config))
+	}
+	check(t, repo.InitModule("lib-a"))
+	check(t, repo.InitModuleWithOptions("svc-a", &Spec{Name: "svc-a", Dependencies: []string{"lib-a"}}))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.Commit("first"))
+	from := repo.LastCommit.String()
+
+	check(t, repo.WriteContent("lib-a/foo", "a"))
+	check(t, repo.Commit("feat: add foo (PROJ-2)"))
+
+	check(t, repo.WriteContent("app-b/foo", "b"))
+	check(t, repo.Commit("PROJ-1: add foo to app-b\n\nAlso see OPS-10 and PROJ-1"))
+
+	check(t, repo.WriteContent("app-b/bar", "b"))
+	check(t, repo.Commit("fix: bar"))
+
+	return repo, from
+}
+
+func TestIssues(t *testing.T) {
+	repo, from := initIssuesRepo(t, nil)
+
+	issues, err := NewWorld(t, ".tmp/repo").System.Issues(from, repo.LastCommit.String())
+	check(t, err)
+
+	assert.Equal(t, map[string][]string{
+		"lib-a": {"PROJ-2"},
+		"svc-a": {"PROJ-2"},
+		"app-b": {"OPS-10", "PROJ-1"},
+	}, issues)
+}
+
+func TestIssuesWithPattern(t *testing.T) {
+	repo, from := initIssuesRepo(t, &RepoConfig{Issues: &IssueTracker{Pattern: `\bOPS-[0-9]+\b`}})
+
+	issues, err := NewWorld(t, ".tmp/repo").System.Issues(from, repo.LastCommit.String())
+	check(t, err)
+
+	assert.Equal(t, map[string][]string{"app-b": {"OPS-10"}}, issues)
+}
+
+func TestIssuesWithInvalidPattern(t *testing.T) {
+	repo, from := initIssuesRepo(t, &RepoConfig{Issues: &IssueTracker{Pattern: `(`}})
+
+	_, err := NewWorld(t, ".tmp/repo").System.Issues(from, repo.LastCommit.String())
+
+	assert.Error(t, err)
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+	assert.EqualError(t, err, "Invalid issue pattern (")
+}
+
+func TestChangelogIssues(t *testing.T) {
+	_, from := initIssuesRepo(t, &RepoConfig{Issues: &IssueTracker{URL: "https://issues.example.com/browse/{key}"}})
+
+	changelog, err := NewWorld(t, ".tmp/repo").System.Changelog("app-b", from, "")
+	check(t, err)
+
+	assert.Equal(t, []string{"OPS-10", "PROJ-1"}, changelog.Issues)
+	assert.Equal(t, []string{"PROJ-1", "OPS-10"}, changelog.Groups[1].Entries[0].Issues)
+
+	buff := new(bytes.Buffer)
+	check(t, changelog.Write(ChangelogFormatMarkdown, buff))
+	assert.Contains(t, buff.String(), "[PROJ-1](https://issues.example.com/browse/PROJ-1), [OPS-10](https://issues.example.com/browse/OPS-10)\n")
+	assert.Contains(t, buff.String(), "## Issues\n\n- [OPS-10](https://issues.example.com/browse/OPS-10)\n- [PROJ-1](https://issues.example.com/browse/PROJ-1)\n")
+
+	buff.Reset()
+	check(t, changelog.Write(ChangelogFormatJSON, buff))
+	assert.Contains(t, buff.String(), `"issues": [`)
+}
+
+func TestPRReportIssues(t *testing.T) {
+	initIssuesRepo(t, nil)
+
+	report, err := NewWorld(t, ".tmp/repo").System.PRReport("master~3", "master", nil)
+	check(t, err)
+
+	issues := make(map[string][]string)
+	for _, m := range report.Modules {
+		issues[m.Name] = m.Issues
+	}
+	assert.Equal(t, map[string][]string{
+		"lib-a": {"PROJ-2"},
+		"svc-a": {"PROJ-2"},
+		"app-b": {"OPS-10", "PROJ-1"},
+	}, issues)
+	assert.Contains(t, report.Markdown(), "| Module | Impact | Version | Dependents | Issues |\n")
+	assert.Contains(t, report.Markdown(), " | OPS-10, PROJ-1 |\n")
+}
+
+func TestDescriptionWithIssues(t *testing.T) {
+	initIssuesRepo(t, nil)
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	d := m.Modules.Describe().WithIssues(map[string][]string{"app-b": {"PROJ-1"}})
+	buff := new(bytes.Buffer)
+	check(t, d.Write(DescriptionFormatYAML, buff))
+	assert.Contains(t, buff.String(), "issues:\n  - PROJ-1\n")
+	assert.Equal(t, 1, bytes.Count(buff.Bytes(), []byte("issues:")))
+}
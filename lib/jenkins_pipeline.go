@@ -0,0 +1,113 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// groovyQuote quotes s as a single quoted groovy string, which is not
+// interpolated.
+func groovyQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`)
+	return "'" + r.Replace(s) + "'"
+}
+
+// jenkinsWriter writes the lines of a declarative pipeline indented
+// by their nesting level.
+type jenkinsWriter struct {
+	buff   strings.Builder
+	indent int
+}
+
+func (j *jenkinsWriter) line(format string, args ...interface{}) {
+	if strings.HasPrefix(format, "}") {
+		j.indent--
+	}
+	j.buff.WriteString(strings.Repeat("    ", j.indent))
+	fmt.Fprintf(&j.buff, format, args...)
+	j.buff.WriteString("\n")
+	if strings.HasSuffix(format, "{") {
+		j.indent++
+	}
+}
+
+func (j *jenkinsWriter) stage(s *pipelineStep) {
+	j.line("stage(%s) {", groovyQuote(s.Module.Name()))
+	if s.Image != "" {
+		j.line("agent {")
+		j.line("docker {")
+		j.line("image %s", groovyQuote(s.Image))
+		j.line("reuseNode true")
+		j.line("}")
+		j.line("}")
+	}
+
+	j.line("environment {")
+	for _, k := range sortedKeys(s.Variables) {
+		j.line("%s = %s", k, groovyQuote(s.Variables[k]))
+	}
+	j.line(`MBT_REPO_PATH = "${WORKSPACE}"`)
+	j.line("}")
+
+	j.line("steps {")
+	j.line("dir(%s) {", groovyQuote(s.Dir))
+	j.line("sh %s", groovyQuote(s.Command))
+	j.line("}")
+	j.line("}")
+	j.line("}")
+}
+
+// renderJenkinsPipeline writes the stages of a declarative pipeline to
+// be included in the stages section of a Jenkinsfile.
+// Declarative pipelines do not support dependencies between stages,
+// therefore, modules are built in groups such that the modules in a
+// group only depend on the modules in preceding groups. Modules in a
+// group are built in parallel.
+func renderJenkinsPipeline(plan *pipelinePlan, options *PipelineOptions, w io.Writer) error {
+	j := &jenkinsWriter{}
+	levels, err := plan.levels()
+	if err != nil {
+		return err
+	}
+	for i, group := range levels {
+		if len(group) == 1 {
+			j.stage(group[0])
+			continue
+		}
+
+		j.line("stage(%s) {", groovyQuote(fmt.Sprintf("mbt %d of %d", i+1, len(levels))))
+		j.line("parallel {")
+		for _, s := range group {
+			j.stage(s)
+		}
+		j.line("}")
+		j.line("}")
+	}
+
+	if len(levels) == 0 {
+		j.line("stage('mbt') {")
+		j.line("steps {")
+		j.line("echo 'No modules to build'")
+		j.line("}")
+		j.line("}")
+	}
+
+	_, err = io.WriteString(w, j.buff.String())
+	return err
+}
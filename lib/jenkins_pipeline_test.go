@@ -0,0 +1,71 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJenkinsPipeline(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("lib-a", &Spec{
+		Name:  "lib-a",
+		Build: map[string]*Cmd{"linux": {Cmd: "make"}},
+	}))
+	check(t, repo.InitModuleWithOptions("lib-b", &Spec{
+		Name:  "lib-b",
+		Build: map[string]*Cmd{"linux": {Cmd: "make"}},
+	}))
+	check(t, repo.InitModuleWithOptions("svc-a", &Spec{
+		Name:         "svc-a",
+		Dependencies: []string{"lib-a", "lib-b"},
+		Image:        "golang:1.21",
+		Build:        map[string]*Cmd{"linux": {Cmd: "echo it's", Shell: "sh"}},
+	}))
+	check(t, repo.Commit("first"))
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	check(t, m.WritePipeline(PipelineJenkins, &PipelineOptions{}, buff))
+	out := buff.String()
+
+	assert.True(t, strings.HasPrefix(out, "stage('mbt 1 of 2') {\n    parallel {\n        stage('lib-a') {\n"), out)
+	assert.Contains(t, out, "            environment {\n                MBT_BUILD_COMMIT = '"+repo.LastCommit.String()+"'\n")
+	assert.Contains(t, out, "                MBT_REPO_PATH = \"${WORKSPACE}\"\n")
+	assert.Contains(t, out, "            steps {\n                dir('lib-a') {\n                    sh 'make'\n                }\n            }\n")
+	assert.Contains(t, out, "stage('svc-a') {\n    agent {\n        docker {\n            image 'golang:1.21'\n            reuseNode true\n")
+	assert.Contains(t, out, `sh 'sh -c \'echo it\'\\\'\'s\' sh'`)
+	assert.Equal(t, strings.Count(out, "{"), strings.Count(out, "}"))
+	assert.True(t, strings.HasSuffix(out, "}\n"))
+}
+
+func TestEmptyJenkinsPipeline(t *testing.T) {
+	buff := new(bytes.Buffer)
+	check(t, (&Manifest{Modules: Modules{}}).WritePipeline(PipelineJenkins, &PipelineOptions{}, buff))
+
+	assert.Equal(t, "stage('mbt') {\n    steps {\n        echo 'No modules to build'\n    }\n}\n", buff.String())
+}
+
+func TestGroovyQuote(t *testing.T) {
+	assert.Equal(t, `'it\'s a \\ test\n'`, groovyQuote("it's a \\ test\n"))
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"sync"
+)
+
+const (
+	journalFile = "journal.json"
+
+	journalStatusStarted   = "started"
+	journalStatusCompleted = "completed"
+	journalStatusFailed    = "failed"
+)
+
+// journalEntry records the state of a module in a build run.
+// Key is the hash of the inputs of the build (see journalKey).
+type journalEntry struct {
+	Version string `json:"version"`
+	Key     string `json:"key"`
+	Status  string `json:"status"`
+}
+
+// journal is a persistent record of the modules built in the current
+// (or last) build run. It is used to resume interrupted builds.
+type journal struct {
+	Commit  string                   `json:"commit"`
+	Modules map[string]*journalEntry `json:"modules"`
+
+	mu     sync.Mutex
+	system *stdSystem
+}
+
+// openJournal returns the journal for a build of the specified manifest.
+// When resuming, entries of the previous run are retained. Otherwise
+// the journal starts empty.
+func (s *stdSystem) openJournal(m *Manifest, resume bool) (*journal, error) {
+	j := &journal{Commit: m.Sha, Modules: make(map[string]*journalEntry), system: s}
+	if resume {
+		err := s.readState(journalFile, j)
+		if err != nil {
+			return nil, err
+		}
+		if j.Modules == nil {
+			j.Modules = make(map[string]*journalEntry)
+		}
+		j.Commit = m.Sha
+	}
+
+	return j, s.writeState(journalFile, j)
+}
+
+// journalKey returns the key of the journal entry of a module built
+// with the specified options. It covers the same inputs as the keys of
+// the build cache, so that a module built in another environment or
+// with other environment variables is not resumed.
+func journalKey(config *RepoConfig, mod *Module, options *CmdOptions) (string, error) {
+	env, err := cacheEnvironment(config, mod, nil, options)
+	if err != nil {
+		return "", err
+	}
+	return buildInputsKey(mod, nil, options.Environment, env), nil
+}
+
+// completed informs if the specified module was successfully built
+// at its current version with the inputs of key.
+// Modules with a local version are never considered completed because
+// their version does not reflect the content.
+func (j *journal) completed(mod *Module, key string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if mod.Version() == "local" {
+		return false
+	}
+
+	entry, ok := j.Modules[mod.Name()]
+	return ok && entry.Status == journalStatusCompleted && entry.Version == mod.Version() && entry.Key == key
+}
+
+// record updates the status of a module built with the inputs of key
+// and persists the journal.
+func (j *journal) record(mod *Module, key, status string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.Modules[mod.Name()] = &journalEntry{Version: mod.Version(), Key: key, Status: status}
+	return j.system.writeState(journalFile, j)
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// initResumableRepo creates a repository where app-b fails to build
+// until .tmp/ok file is created.
+func initResumableRepo(t *testing.T) *TestRepository {
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo built app-a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.WriteShellScript("app-b/build.sh", "test -f $MBT_REPO_PATH/../ok && echo built app-b"))
+	check(t, repo.Commit("first"))
+
+	return repo
+}
+
+func TestResumeSkipsCompletedModules(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	initResumableRepo(t)
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+	assert.EqualError(t, err, fmt.Sprintf(msgFailedBuild, "app-b"))
+	assert.Equal(t, "built app-a\n", buff.String())
+
+	f, err := os.Create(".tmp/ok")
+	check(t, err)
+	f.Close()
+
+	buff = new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Resume = true
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "built app-b\n", buff.String())
+	assert.Len(t, summary.Completed, 2)
+	assert.Equal(t, "app-a", summary.Completed[0].Module.Name())
+	assert.True(t, summary.Completed[0].Resumed)
+	assert.False(t, summary.Completed[1].Resumed)
+}
+
+func TestBuildWithoutResumeBuildsAllModules(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	initResumableRepo(t)
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(nil))
+	assert.Error(t, err)
+
+	f, err := os.Create(".tmp/ok")
+	check(t, err)
+	f.Close()
+
+	buff := new(bytes.Buffer)
+	_, err = NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+	check(t, err)
+
+	assert.Equal(t, "built app-a\nbuilt app-b\n", buff.String())
+}
+
+func TestResumeRebuildsModulesWithNewVersion(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := initResumableRepo(t)
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(nil))
+	assert.Error(t, err)
+
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo rebuilt app-a"))
+	check(t, repo.Commit("second"))
+	f, err := os.Create(".tmp/ok")
+	check(t, err)
+	f.Close()
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Resume = true
+	_, err = NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "rebuilt app-a\nbuilt app-b\n", buff.String())
+}
+
+func TestResumeAfterSuccessfulBuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo built app-a"))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(nil))
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Resume = true
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "", buff.String())
+	assert.True(t, summary.Completed[0].Resumed)
+}
+
+func TestResumeRebuildsModulesBuiltInAnotherEnvironment(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo built app-a $BUILD_MODE"))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(nil))
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Resume = true
+	options.Environment = "prod"
+	_, err = NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "built app-a\n", buff.String())
+
+	buff.Reset()
+	options.Env = []string{"BUILD_MODE=debug"}
+	_, err = NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "built app-a debug\n", buff.String())
+
+	buff.Reset()
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "", buff.String())
+	assert.True(t, summary.Completed[0].Resumed)
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	defaultKubectl = "kubectl"
+	// kubernetesFieldManager is the field manager of the fields applied
+	// by mbt with server-side apply.
+	kubernetesFieldManager = "mbt"
+)
+
+// KubernetesOptions specifies how the output of a template is applied
+// to a kubernetes cluster.
+type KubernetesOptions struct {
+	// Kubectl is the executable used to apply the output.
+	// Defaults to kubectl.
+	Kubectl string
+	// Context is the kubeconfig context of the cluster.
+	// Defaults to the current context.
+	Context string
+	// Prune is the label identifying the resources of a module. When it
+	// is specified, resources labelled with <Prune>=<module name> that
+	// are not in the output of the module are deleted.
+	Prune string
+	// DryRun submits the output to the cluster without persisting it.
+	DryRun bool
+	// Stdout and Stderr receive the output of kubectl.
+	Stdout, Stderr io.Writer
+}
+
+func (s *stdSystem) ApplyKubernetes(from, to, templatePath string, options *ApplyOptions, kubernetes *KubernetesOptions) error {
+	changed, err := s.ManifestByDiff(from, to)
+	if err != nil {
+		return err
+	}
+
+	if len(changed.Modules) == 0 {
+		return nil
+	}
+
+	toCommit, err := s.Repo.GetCommit(to)
+	if err != nil {
+		return err
+	}
+
+	toManifest, err := s.MB.ByCommit(toCommit)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(changed.Modules))
+	for _, m := range changed.Modules {
+		names = append(names, m.Name())
+	}
+	sort.Strings(names)
+
+	outputs, err := s.renderModules(toCommit, toManifest, templatePath, nil, names, options)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range names {
+		output, ok := outputs[n]
+		if !ok || strings.TrimSpace(output) == "" {
+			// kubectl rejects empty input, therefore resources of a
+			// module without any output are not pruned either.
+			s.Log.Infof(msgSkippedKubernetesApply, n)
+			continue
+		}
+
+		s.Log.Infof(msgApplyingToKubernetes, n)
+		if err := kubectlApply(kubernetes, n, output); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// kubectlApply applies the output of a module with server-side apply.
+func kubectlApply(options *KubernetesOptions, module, output string) error {
+	kubectl := options.Kubectl
+	if kubectl == "" {
+		kubectl = defaultKubectl
+	}
+
+	args := kubectlApplyArgs(options, module)
+	var stderr bytes.Buffer
+	cmd := exec.Command(kubectl, args...)
+	cmd.Stdin = strings.NewReader(output)
+	cmd.Stdout = options.Stdout
+	cmd.Stderr = &stderr
+	if options.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(&stderr, options.Stderr)
+	}
+
+	if err := cmd.Run(); err != nil {
+		return e.NewErrorf(ErrClassUser, msgFailedKubernetesApply, module, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+func kubectlApplyArgs(options *KubernetesOptions, module string) []string {
+	args := []string{"apply", "--server-side", "--field-manager", kubernetesFieldManager, "-f", "-"}
+	if options.Context != "" {
+		args = append(args, "--context", options.Context)
+	}
+
+	if options.Prune != "" {
+		args = append(args, "--prune", "-l", fmt.Sprintf("%s=%s", options.Prune, module))
+	}
+
+	if options.DryRun {
+		args = append(args, "--dry-run=server")
+	}
+
+	return args
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKubectl creates an executable recording the arguments and the
+// input of each invocation in .tmp/kubectl.log.
+func fakeKubectl(t *testing.T, script string) (string, string) {
+	p, err := filepath.Abs(".tmp/kubectl")
+	check(t, err)
+	log := p + ".log"
+	check(t, ioutil.WriteFile(p, []byte("#!/bin/sh\necho \"$@\" >> "+log+"\ncat >> "+log+"\necho >> "+log+"\n"+script), 0755))
+	return p, log
+}
+
+func TestApplyKubernetes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake kubectl is not supported on windows")
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.InitModule("app-c"))
+	check(t, repo.WriteContent("deploy.tmpl", `{{if ne .Module.Name "app-c"}}name: {{.Module.Name}}{{end}}`))
+	check(t, repo.Commit("first"))
+	from := repo.LastCommit.String()
+
+	check(t, repo.WriteContent("app-a/README.md", "changed"))
+	check(t, repo.WriteContent("app-c/README.md", "changed"))
+	check(t, repo.Commit("second"))
+
+	kubectl, log := fakeKubectl(t, "")
+	err := NewWorld(t, ".tmp/repo").System.ApplyKubernetes(from, repo.LastCommit.String(), "deploy.tmpl", &ApplyOptions{}, &KubernetesOptions{
+		Kubectl: kubectl,
+		Context: "staging",
+		Prune:   "app.kubernetes.io/name",
+		DryRun:  true,
+	})
+	check(t, err)
+
+	calls, err := ioutil.ReadFile(log)
+	check(t, err)
+	assert.Equal(t, `apply --server-side --field-manager mbt -f - --context staging --prune -l app.kubernetes.io/name=app-a --dry-run=server
+name: app-a
+`, string(calls))
+}
+
+func TestApplyKubernetesWithoutOptionalArgs(t *testing.T) {
+	assert.Equal(t, []string{"apply", "--server-side", "--field-manager", "mbt", "-f", "-"}, kubectlApplyArgs(&KubernetesOptions{}, "app-a"))
+}
+
+func TestFailedKubernetesApply(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake kubectl is not supported on windows")
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent("deploy.tmpl", `name: {{.Module.Name}}`))
+	check(t, repo.Commit("first"))
+	from := repo.LastCommit.String()
+
+	check(t, repo.WriteContent("app-a/README.md", "changed"))
+	check(t, repo.Commit("second"))
+
+	kubectl, _ := fakeKubectl(t, "echo 'connection refused' >&2; exit 1")
+	err := NewWorld(t, ".tmp/repo").System.ApplyKubernetes(from, repo.LastCommit.String(), "deploy.tmpl", &ApplyOptions{}, &KubernetesOptions{Kubectl: kubectl})
+
+	assert.Error(t, err)
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+	assert.Equal(t, "Failed to apply module app-a to kubernetes (exit status 1):\nconnection refused", err.Error())
+}
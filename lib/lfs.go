@@ -0,0 +1,151 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// lfsPointerMaxSize is the maximum size of a Git LFS pointer file.
+	// Larger blobs are not read when looking for pointers.
+	lfsPointerMaxSize = 1024
+	lfsPointerVersion = "version https://git-lfs.github.com/spec/v1\n"
+	lfsPointerOID     = "oid sha256:"
+)
+
+// parseLFSPointer returns the id of the object referenced by a Git LFS
+// pointer file. ok is false if the content is not a pointer.
+func parseLFSPointer(content []byte) (oid string, ok bool) {
+	if len(content) > lfsPointerMaxSize || !bytes.HasPrefix(content, []byte(lfsPointerVersion)) {
+		return "", false
+	}
+
+	for _, l := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(l, lfsPointerOID) {
+			oid = strings.TrimPrefix(l, lfsPointerOID)
+			if _, err := hex.DecodeString(oid); err != nil || len(oid) != sha256.Size*2 {
+				return "", false
+			}
+			return oid, true
+		}
+	}
+
+	return "", false
+}
+
+// isLFSObject informs if the file at p contains the object with the
+// specified id (i.e. the pointer file was smudged in the workspace).
+func isLFSObject(p, oid string) (bool, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, e.Wrapf(ErrClassInternal, err, msgFailedReadFile, p)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, e.Wrapf(ErrClassInternal, err, msgFailedReadFile, p)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)) == oid, nil
+}
+
+// withLFSObjectHashes derives the hashes of modules and their file
+// dependencies from the ids of the Git LFS objects rather than the
+// ids of the pointer files. Other blobs are identified by their ids.
+// Progress of resolving the object ids is reported to progress if it
+// is not nil.
+func withLFSObjectHashes(repo Repo, commit Commit, metadataSet moduleMetadataSet, progress HashProgress) error {
+	blobs := make([]Blob, 0)
+	err := repo.WalkBlobs(commit, func(b Blob) error {
+		blobs = append(blobs, b)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	objectIDs, err := hashAll(len(blobs), progress, func(i int) (string, error) {
+		id, err := repo.LFSObjectID(blobs[i])
+		if err != nil {
+			return "", err
+		}
+
+		if id == "" {
+			id = blobs[i].ID()
+		}
+		return id, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ids := make(map[string]string)
+	paths := make([]string, 0, len(blobs))
+	for i, b := range blobs {
+		p := b.Path() + b.Name()
+		ids[p] = objectIDs[i]
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	// hash returns the id of the blob at p or the digest of the ids
+	// of the blobs in the directory at p except the ones excluded by
+	// the rules.
+	hash := func(p string, rules ignoreRules) string {
+		if id, ok := ids[p]; ok {
+			return id
+		}
+
+		prefix := ""
+		if p != "" {
+			prefix = p + "/"
+		}
+
+		h := sha1.New()
+		for i := sort.SearchStrings(paths, prefix); i < len(paths) && strings.HasPrefix(paths[i], prefix); i++ {
+			if rules.excludes(paths[i]) {
+				continue
+			}
+			io.WriteString(h, paths[i])
+			io.WriteString(h, ids[paths[i]])
+		}
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	return forEach(len(metadataSet), func(i int) error {
+		m := metadataSet[i]
+		m.hash = hash(m.dir, m.ignore)
+		for f := range m.dependentFileHashes {
+			m.dependentFileHashes[f] = hash(strings.TrimRight(path.Clean(f), "/"), nil)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func lfsPointer(content string) string {
+	h := sha256.Sum256([]byte(content))
+	return fmt.Sprintf("version https://git-lfs.github.com/spec/v1\noid sha256:%s\nsize %v\n", hex.EncodeToString(h[:]), len(content))
+}
+
+func TestParseLFSPointer(t *testing.T) {
+	h := sha256.Sum256([]byte("hello"))
+	oid, ok := parseLFSPointer([]byte(lfsPointer("hello")))
+	assert.True(t, ok)
+	assert.Equal(t, hex.EncodeToString(h[:]), oid)
+
+	_, ok = parseLFSPointer([]byte("hello"))
+	assert.False(t, ok)
+
+	_, ok = parseLFSPointer([]byte("version https://git-lfs.github.com/spec/v1\noid sha256:abc\nsize 5\n"))
+	assert.False(t, ok)
+}
+
+func TestWorkspaceChangesIgnoreSmudgedLFSObjects(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.WriteContent("app-a/asset.bin", lfsPointer("hello")))
+	check(t, repo.Commit("first"))
+
+	check(t, repo.WriteContent("app-a/asset.bin", "hello"))
+
+	world := NewWorld(t, ".tmp/repo")
+	m, err := world.System.ManifestByWorkspaceChanges()
+	check(t, err)
+	assert.Empty(t, m.Modules)
+	check(t, world.Repo.EnsureSafeWorkspace())
+
+	check(t, repo.WriteContent("app-a/asset.bin", "world"))
+
+	m, err = world.System.ManifestByWorkspaceChanges()
+	check(t, err)
+	assert.Equal(t, []string{"app-a"}, moduleNames(m.Modules))
+	assert.Error(t, world.Repo.EnsureSafeWorkspace())
+}
+
+func TestLFSObjectID(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteContent("asset.bin", lfsPointer("hello")))
+	check(t, repo.WriteContent("readme.md", "hello"))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	c, err := world.Repo.GetCommit(repo.LastCommit.String())
+	check(t, err)
+
+	ids := make(map[string]string)
+	check(t, world.Repo.WalkBlobs(c, func(b Blob) error {
+		id, err := world.Repo.LFSObjectID(b)
+		ids[b.Name()] = id
+		return err
+	}))
+
+	h := sha256.Sum256([]byte("hello"))
+	assert.Equal(t, map[string]string{"asset.bin": hex.EncodeToString(h[:]), "readme.md": ""}, ids)
+}
+
+func TestVersionFromLFSObjects(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteConfig(&RepoConfig{LFS: &LFSConfig{Objects: true}}))
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", FileDependencies: []string{"assets/logo.png"}}))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.WriteContent("assets/logo.png", lfsPointer("logo")))
+	check(t, repo.WriteContent("app-b/asset.bin", lfsPointer("hello")))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	versions := func() map[string]string {
+		c, err := world.Repo.GetCommit(repo.LastCommit.String())
+		check(t, err)
+		m, err := world.Discover.ModulesInCommit(c)
+		check(t, err)
+
+		v := make(map[string]string)
+		for _, a := range m {
+			v[a.Name()] = a.Version()
+		}
+		return v
+	}
+	v1 := versions()
+
+	// Pointer files referring to the same objects.
+	check(t, repo.AppendContent("assets/logo.png", "ext-0-foo sha256:0\n"))
+	check(t, repo.AppendContent("app-b/asset.bin", "ext-0-foo sha256:0\n"))
+	check(t, repo.Commit("second"))
+	assert.Equal(t, v1, versions())
+
+	check(t, repo.WriteContent("assets/logo.png", lfsPointer("new logo")))
+	check(t, repo.Commit("third"))
+	v3 := versions()
+	assert.NotEqual(t, v1["app-a"], v3["app-a"])
+	assert.Equal(t, v1["app-b"], v3["app-b"])
+}
+
+func TestVersionFromLFSPointers(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent("app-a/asset.bin", lfsPointer("hello")))
+	check(t, repo.Commit("first"))
+	c1, err := ioutil.ReadFile(".tmp/repo/app-a/asset.bin")
+	check(t, err)
+
+	world := NewWorld(t, ".tmp/repo")
+	m1, err := world.System.ManifestByCurrentBranch()
+	check(t, err)
+
+	// Smudged object in the workspace does not change the version.
+	check(t, repo.WriteContent("app-a/asset.bin", "hello"))
+	m2, err := world.System.ManifestByCurrentBranch()
+	check(t, err)
+	assert.Equal(t, m1.Modules[0].Version(), m2.Modules[0].Version())
+
+	check(t, repo.WriteContent("app-a/asset.bin", string(c1)+"ext-0-foo sha256:0\n"))
+	check(t, repo.Commit("second"))
+	m3, err := world.System.ManifestByCurrentBranch()
+	check(t, err)
+	assert.NotEqual(t, m1.Modules[0].Version(), m3.Modules[0].Version())
+}
+
+func TestHashProgressOfLFSObjects(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteConfig(&RepoConfig{LFS: &LFSConfig{Objects: true}}))
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent("app-a/asset.bin", lfsPointer("hello")))
+	check(t, repo.Commit("first"))
+
+	mu := sync.Mutex{}
+	hashed, total := 0, 0
+	ctx := WithHashProgress(context.Background(), func(h, n int) {
+		mu.Lock()
+		defer mu.Unlock()
+		if h > hashed {
+			hashed = h
+		}
+		total = n
+	})
+
+	s, err := NewSystemWithContext(ctx, ".tmp/repo", LogLevelNormal)
+	check(t, err)
+	_, err = s.ManifestByCurrentBranch()
+	check(t, err)
+
+	// Repository config, spec of app-a and app-a/asset.bin
+	assert.Equal(t, 3, total)
+	assert.Equal(t, 3, hashed)
+}
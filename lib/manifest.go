@@ -16,8 +16,10 @@ limitations under the License.
 package lib
 
 import (
+	"path"
 	"strings"
 
+	"github.com/mbtproject/mbt/e"
 	"github.com/mbtproject/mbt/utils"
 )
 
@@ -55,6 +57,19 @@ func (s *stdSystem) ManifestByCommitContent(sha string) (*Manifest, error) {
 	return s.MB.ByCommitContent(c)
 }
 
+func (s *stdSystem) ManifestByCommitAtConfig(sha, configSha string) (*Manifest, error) {
+	c, err := s.Repo.GetCommit(sha)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := s.Repo.GetCommit(configSha)
+	if err != nil {
+		return nil, err
+	}
+	return s.MB.ByCommitAtConfig(c, config)
+}
+
 func (s *stdSystem) ManifestByBranch(name string) (*Manifest, error) {
 	return s.MB.ByBranch(name)
 }
@@ -78,6 +93,16 @@ func (s *stdSystem) ManifestByWorkspaceChanges() (*Manifest, error) {
 // If fuzzy argument is true, comparison is a case insensitive
 // subsequence comparison. Otherwise, it's a case insensitive
 // exact match.
+// Module returns the module with the specified name.
+// Modules are indexed by name on the first call.
+func (m *Manifest) Module(name string) (*Module, bool) {
+	m.indexOnce.Do(func() {
+		m.index = m.Modules.indexByName()
+	})
+	mod, ok := m.index[name]
+	return mod, ok
+}
+
 func (m *Manifest) FilterByName(filterOptions *FilterOptions) *Manifest {
 	filteredModules := make(Modules, 0)
 	filter := strings.ToLower(filterOptions.Name)
@@ -108,6 +133,27 @@ func (m *Manifest) ApplyFilters(filterOptions *FilterOptions) (*Manifest, error)
 		m = m.FilterByName(filterOptions)
 	}
 
+	if filterOptions.Path != "" {
+		var err error
+		m, err = m.FilterByPath(filterOptions.Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(filterOptions.Labels) > 0 {
+		m = m.FilterByLabels(filterOptions.Labels)
+	}
+
+	if filterOptions.Query != "" {
+		var err error
+		m, err = m.FilterByQuery(filterOptions.Query)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	selected := m.Modules
 	if filterOptions.Dependents {
 		var err error
 
@@ -118,9 +164,88 @@ func (m *Manifest) ApplyFilters(filterOptions *FilterOptions) (*Manifest, error)
 		}
 	}
 
+	if filterOptions.Dependencies {
+		dependencies, err := selected.expandRequiresDependencies()
+		if err != nil {
+			return nil, err
+		}
+
+		// Dependencies are listed first since they are built before
+		// the dependents.
+		index := dependencies.indexByName()
+		for _, a := range m.Modules {
+			if _, ok := index[a.Name()]; !ok {
+				dependencies = append(dependencies, a)
+			}
+		}
+		m = &Manifest{Dir: m.Dir, Sha: m.Sha, Modules: dependencies}
+	}
+
 	return m, nil
 }
 
+// FilterByLabels returns the modules with all of the specified labels.
+func (m *Manifest) FilterByLabels(labels map[string]string) *Manifest {
+	filtered := make(Modules, 0)
+	for _, a := range m.Modules {
+		if hasLabels(a, labels) {
+			filtered = append(filtered, a)
+		}
+	}
+
+	return &Manifest{Dir: m.Dir, Sha: m.Sha, Modules: filtered}
+}
+
+func hasLabels(a *Module, labels map[string]string) bool {
+	for k, v := range labels {
+		if l, ok := a.Labels()[k]; !ok || l != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseLabelSelector parses a comma separated list of key=value pairs
+// (e.g. team=payments,tier=svc) into the labels of FilterOptions.
+func ParseLabelSelector(selector string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(selector, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, e.NewErrorf(ErrClassUser, msgInvalidLabelSelector, pair, selector)
+		}
+		labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return labels, nil
+}
+
+// FilterByPath returns the module containing the specified path
+// (relative to the root of the repository). Path may be a file or a
+// directory in the module, including the directories of modules nested
+// in it.
+func (m *Manifest) FilterByPath(p string) (*Manifest, error) {
+	index := m.Modules.indexByPath()
+	for dir := normalizeSpecPath(p); ; dir = path.Dir(dir) {
+		if dir == "." {
+			dir = ""
+		}
+
+		if a, ok := index[dir]; ok {
+			return &Manifest{Dir: m.Dir, Sha: m.Sha, Modules: Modules{a}}, nil
+		}
+
+		if dir == "" {
+			return nil, e.NewErrorf(ErrClassUser, msgNoModuleInPath, p)
+		}
+	}
+}
+
 func matches(value string, filters []string, fuzzy bool) bool {
 	match := false
 
@@ -86,6 +86,17 @@ func (b *stdManifestBuilder) ByCommit(sha Commit) (*Manifest, error) {
 	})
 }
 
+func (b *stdManifestBuilder) ByCommitAtConfig(sha, configCommit Commit) (*Manifest, error) {
+	return b.runManifestBuilder(func() (*Manifest, error) {
+		mods, err := b.Discover.ModulesInCommitAtConfig(sha, configCommit)
+		if err != nil {
+			return nil, err
+		}
+
+		return b.buildManifest(mods, sha.ID())
+	})
+}
+
 func (b *stdManifestBuilder) ByCommitContent(sha Commit) (*Manifest, error) {
 	return b.runManifestBuilder(func() (*Manifest, error) {
 		mods, err := b.Discover.ModulesInCommit(sha)
@@ -127,6 +138,14 @@ func (b *stdManifestBuilder) ByBranch(name string) (*Manifest, error) {
 
 func (b *stdManifestBuilder) ByCurrentBranch() (*Manifest, error) {
 	return b.runManifestBuilder(func() (*Manifest, error) {
+		if detached, err := b.Repo.IsHeadDetached(); err == nil && detached {
+			c, err := b.Repo.CurrentBranchCommit()
+			if err != nil {
+				return nil, err
+			}
+			return b.ByCommit(c)
+		}
+
 		n, err := b.Repo.CurrentBranch()
 		if err != nil {
 			return nil, err
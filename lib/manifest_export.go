@@ -0,0 +1,266 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+
+	yaml "github.com/go-yaml/yaml"
+	"github.com/mbtproject/mbt/e"
+)
+
+// ManifestSchemaVersion is the version of the schema of exported
+// manifests. It is incremented for changes that are not backwards
+// compatible.
+const ManifestSchemaVersion = 1
+
+// exportedManifest is the structure of a manifest written by Export.
+// Modules contains the names of the modules in the manifest in order.
+// Graph contains those modules as well as their dependencies (which may
+// not be a part of the manifest e.g. in a manifest of a diff) in
+// topological order.
+type exportedManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	Sha           string            `json:"sha"`
+	Modules       []string          `json:"modules"`
+	Graph         []*exportedModule `json:"graph"`
+}
+
+type exportedModule struct {
+	Path                string            `json:"path"`
+	Hash                string            `json:"hash"`
+	Version             string            `json:"version"`
+	DependentFileHashes map[string]string `json:"dependentFileHashes"`
+	// Spec is the module spec in the structure of .mbt.yml.
+	Spec interface{} `json:"spec"`
+}
+
+// Export writes the manifest as json to the specified writer.
+// Exported manifest can be imported with System.ImportManifest to
+// avoid discovering the modules in the repository again.
+func (m *Manifest) Export(w io.Writer) error {
+	graph, err := m.Modules.expandRequiresDependencies()
+	if err != nil {
+		return err
+	}
+
+	x := &exportedManifest{
+		SchemaVersion: ManifestSchemaVersion,
+		Sha:           m.Sha,
+		Modules:       make([]string, 0, len(m.Modules)),
+		Graph:         make([]*exportedModule, 0, len(graph)),
+	}
+
+	for _, a := range m.Modules {
+		x.Modules = append(x.Modules, a.Name())
+	}
+
+	for _, a := range graph {
+		spec, err := exportSpec(a.metadata.spec)
+		if err != nil {
+			return err
+		}
+
+		x.Graph = append(x.Graph, &exportedModule{
+			Path:                a.Path(),
+			Hash:                a.Hash(),
+			Version:             a.Version(),
+			DependentFileHashes: a.metadata.dependentFileHashes,
+			Spec:                spec,
+		})
+	}
+
+	b, err := json.MarshalIndent(x, "", "  ")
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	return nil
+}
+
+// importManifest reads a manifest written by Export.
+// Dir of the manifest is set to the specified directory since the
+// repository is not necessarily in the same location where the
+// manifest was exported.
+func importManifest(r io.Reader, dir string) (*Manifest, error) {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	x := &exportedManifest{}
+	if err := d.Decode(x); err != nil {
+		return nil, e.NewErrorf(ErrClassUser, msgInvalidManifest, err)
+	}
+
+	if x.SchemaVersion != ManifestSchemaVersion {
+		return nil, e.NewErrorf(ErrClassUser, msgUnsupportedManifestSchema, x.SchemaVersion, ManifestSchemaVersion)
+	}
+
+	// Graph is in topological order, therefore dependencies of a
+	// module are always created before the module.
+	index := make(map[string]*Module, len(x.Graph))
+	for _, xm := range x.Graph {
+		spec, err := importSpec(xm.Spec)
+		if err != nil {
+			return nil, err
+		}
+
+		requires := Modules{}
+		for _, d := range spec.Dependencies {
+			r, ok := index[d]
+			if !ok {
+				return nil, e.NewErrorf(ErrClassUser, msgUnknownManifestModule, d)
+			}
+			requires = append(requires, r)
+		}
+
+		mod := newModule(newModuleMetadata(xm.Path, xm.Hash, spec, xm.DependentFileHashes), requires)
+		mod.version = xm.Version
+		index[mod.Name()] = mod
+	}
+
+	modules := make(Modules, 0, len(x.Modules))
+	for _, n := range x.Modules {
+		mod, ok := index[n]
+		if !ok {
+			return nil, e.NewErrorf(ErrClassUser, msgUnknownManifestModule, n)
+		}
+		modules = append(modules, mod)
+	}
+
+	return &Manifest{Dir: dir, Sha: x.Sha, Modules: modules}, nil
+}
+
+// exportSpec converts a spec to a generic structure that is encoded
+// with the same keys used in .mbt.yml.
+func exportSpec(spec *Spec) (interface{}, error) {
+	b, err := yaml.Marshal(spec)
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	var v interface{}
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	return transformIfRequired(v)
+}
+
+// importSpec converts the generic structure created by exportSpec back
+// to a spec. Spec is parsed in the same way as .mbt.yml so that the
+// types of the properties are the same as in the original spec.
+func importSpec(v interface{}) (*Spec, error) {
+	b, err := yaml.Marshal(fromJSONNumbers(v))
+	if err != nil {
+		return nil, e.NewErrorf(ErrClassUser, msgInvalidManifest, err)
+	}
+
+	spec, err := newSpec(b)
+	if err != nil {
+		return nil, e.NewErrorf(ErrClassUser, msgInvalidManifest, err)
+	}
+
+	return spec, nil
+}
+
+// fromJSONNumbers replaces json.Number values with an int if they are
+// integers or float64 otherwise.
+func fromJSONNumbers(v interface{}) interface{} {
+	switch c := v.(type) {
+	case json.Number:
+		if i, err := c.Int64(); err == nil {
+			return int(i)
+		}
+		f, _ := c.Float64()
+		return f
+	case map[string]interface{}:
+		for k, cv := range c {
+			c[k] = fromJSONNumbers(cv)
+		}
+	case []interface{}:
+		for i, cv := range c {
+			c[i] = fromJSONNumbers(cv)
+		}
+	}
+	return v
+}
+
+func (s *stdSystem) ImportManifest(r io.Reader) error {
+	dir, err := filepath.Abs(s.Repo.Path())
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	m, err := importManifest(r, dir)
+	if err != nil {
+		return err
+	}
+
+	s.MB = &importedManifestBuilder{manifest: m}
+	return nil
+}
+
+// importedManifestBuilder is a ManifestBuilder that returns an imported
+// manifest regardless of the query.
+type importedManifestBuilder struct {
+	manifest *Manifest
+}
+
+func (b *importedManifestBuilder) get() (*Manifest, error) {
+	m := b.manifest
+	return &Manifest{Dir: m.Dir, Sha: m.Sha, Modules: m.Modules}, nil
+}
+
+func (b *importedManifestBuilder) ByDiff(from, to Commit) (*Manifest, error) {
+	return b.get()
+}
+
+func (b *importedManifestBuilder) ByPr(src, dst string) (*Manifest, error) {
+	return b.get()
+}
+
+func (b *importedManifestBuilder) ByCommit(sha Commit) (*Manifest, error) {
+	return b.get()
+}
+
+func (b *importedManifestBuilder) ByCommitContent(sha Commit) (*Manifest, error) {
+	return b.get()
+}
+
+func (b *importedManifestBuilder) ByCommitAtConfig(sha, configCommit Commit) (*Manifest, error) {
+	return b.get()
+}
+
+func (b *importedManifestBuilder) ByBranch(name string) (*Manifest, error) {
+	return b.get()
+}
+
+func (b *importedManifestBuilder) ByCurrentBranch() (*Manifest, error) {
+	return b.get()
+}
+
+func (b *importedManifestBuilder) ByWorkspace() (*Manifest, error) {
+	return b.get()
+}
+
+func (b *importedManifestBuilder) ByWorkspaceChanges() (*Manifest, error) {
+	return b.get()
+}
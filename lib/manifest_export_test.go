@@ -0,0 +1,159 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifestExportAndImport(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:             "app-a",
+		Properties:       map[string]interface{}{"replicas": 2, "ratio": 0.5, "nested": map[string]interface{}{"tags": []interface{}{"x", 1}}},
+		FileDependencies: []string{"lib-a"},
+	}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Dependencies: []string{"app-a"}}))
+	check(t, repo.WriteContent("lib-a/a.go", "a"))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	m, err := world.System.ManifestByCurrentBranch()
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	check(t, m.Export(buff))
+
+	imported, err := importManifest(buff, "/repo")
+	check(t, err)
+
+	assert.Equal(t, "/repo", imported.Dir)
+	assert.Equal(t, m.Sha, imported.Sha)
+	assert.Len(t, imported.Modules, 2)
+	for i, a := range m.Modules {
+		b := imported.Modules[i]
+		assert.Equal(t, a.Name(), b.Name())
+		assert.Equal(t, a.Path(), b.Path())
+		assert.Equal(t, a.Hash(), b.Hash())
+		assert.Equal(t, a.Version(), b.Version())
+		assert.Equal(t, a.Properties(), b.Properties())
+		assert.Equal(t, a.Build(), b.Build())
+		assert.Equal(t, a.FileDependencies(), b.FileDependencies())
+		assert.Equal(t, a.metadata.dependentFileHashes, b.metadata.dependentFileHashes)
+	}
+
+	assert.Equal(t, imported.Modules[0], imported.Modules[1].Requires()[0])
+	assert.Equal(t, imported.Modules[1], imported.Modules[0].RequiredBy()[0])
+}
+
+func TestManifestExportOfDiff(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Dependencies: []string{"app-a"}}))
+	check(t, repo.Commit("first"))
+	c1 := repo.LastCommit.String()
+
+	check(t, repo.WriteContent("app-b/foo", "bar"))
+	check(t, repo.Commit("second"))
+	c2 := repo.LastCommit.String()
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByDiff(c1, c2)
+	check(t, err)
+	assert.Equal(t, []string{"app-b"}, moduleNames(m.Modules))
+
+	buff := new(bytes.Buffer)
+	check(t, m.Export(buff))
+
+	imported, err := importManifest(buff, "/repo")
+	check(t, err)
+
+	assert.Equal(t, []string{"app-b"}, moduleNames(imported.Modules))
+	assert.Equal(t, "app-a", imported.Modules[0].Requires()[0].Name())
+	assert.Equal(t, m.Modules[0].Requires()[0].Version(), imported.Modules[0].Requires()[0].Version())
+}
+
+func TestBuildWithImportedManifest(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo app-a built"))
+	check(t, repo.WritePowershellScript("app-a/build.ps1", "write-host \"app-a built\""))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	m, err := world.System.ManifestByCurrentBranch()
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	check(t, m.Export(buff))
+
+	// Modules added after exporting the manifest are not visible to
+	// the operations using the imported manifest.
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.Commit("second"))
+
+	check(t, world.System.ImportManifest(buff))
+
+	output := new(bytes.Buffer)
+	summary, err := world.System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(output))
+	check(t, err)
+
+	assert.Equal(t, "app-a built\n", output.String())
+	assert.Equal(t, []string{"app-a"}, moduleNames(summary.Manifest.Modules))
+	assert.Equal(t, m.Sha, summary.Manifest.Sha)
+
+	dir, err := filepath.Abs(".tmp/repo")
+	check(t, err)
+	assert.Equal(t, dir, summary.Manifest.Dir)
+}
+
+func TestImportInvalidManifest(t *testing.T) {
+	_, err := importManifest(strings.NewReader("{"), "/repo")
+
+	assert.Error(t, err)
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+	assert.Contains(t, err.Error(), "Invalid manifest")
+}
+
+func TestImportManifestWithUnsupportedSchema(t *testing.T) {
+	_, err := importManifest(strings.NewReader(`{"schemaVersion": 2}`), "/repo")
+
+	assert.Error(t, err)
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+	assert.EqualError(t, err, "Unsupported manifest schema version 2 (expected 1)")
+}
+
+func TestImportManifestWithUnknownModule(t *testing.T) {
+	_, err := importManifest(strings.NewReader(`{
+  "schemaVersion": 1,
+  "modules": ["app-b"],
+  "graph": [{"path": "app-b", "hash": "h", "version": "v", "spec": {"name": "app-b", "dependencies": ["app-a"]}}]
+}`), "/repo")
+
+	assert.Error(t, err)
+	assert.EqualError(t, err, "Module app-a referenced in the manifest is not defined in it")
+}
@@ -781,6 +781,52 @@ func TestDependentOfAModuleWithFileDependency(t *testing.T) {
 	assert.Equal(t, "app-b", m.Modules[1].Name())
 }
 
+func TestChangeToFileDependencyDirectory(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteContent("proto/a.proto", "a"))
+	check(t, repo.WriteContent("protocol/b.txt", "b"))
+	check(t, repo.WriteContent("yarn.lock", "a"))
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:             "app-a",
+		FileDependencies: []string{"proto", "yarn.lock"},
+	}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:         "app-b",
+		Dependencies: []string{"app-a"},
+	}))
+	check(t, repo.InitModule("app-c"))
+
+	check(t, repo.Commit("first"))
+	c1 := repo.LastCommit.String()
+
+	check(t, repo.WriteContent("protocol/b.txt", "c"))
+	check(t, repo.Commit("second"))
+	c2 := repo.LastCommit.String()
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByDiff(c1, c2)
+	check(t, err)
+	assert.Len(t, m.Modules, 0)
+
+	check(t, repo.WriteContent("proto/a.proto", "b"))
+	check(t, repo.WriteContent("yarn.lock", "b"))
+	check(t, repo.Commit("third"))
+	c3 := repo.LastCommit.String()
+
+	m, err = NewWorld(t, ".tmp/repo").System.ManifestByDiff(c2, c3)
+	check(t, err)
+	assert.Equal(t, []string{"app-a", "app-b"}, moduleNames(m.Modules))
+
+	m1, err := NewWorld(t, ".tmp/repo").System.ManifestByCommit(c2)
+	check(t, err)
+	m2, err := NewWorld(t, ".tmp/repo").System.ManifestByCommit(c3)
+	check(t, err)
+	assert.NotEqual(t, m1.Modules.indexByName()["app-a"].Version(), m2.Modules.indexByName()["app-a"].Version())
+	assert.NotEqual(t, m1.Modules.indexByName()["app-b"].Version(), m2.Modules.indexByName()["app-b"].Version())
+	assert.Equal(t, m1.Modules.indexByName()["app-c"].Version(), m2.Modules.indexByName()["app-c"].Version())
+}
+
 func TestManifestBySha(t *testing.T) {
 	clean()
 	repo := NewTestRepo(t, ".tmp/repo")
@@ -878,6 +924,51 @@ func TestDiffingForCaseSensitivityOfModulePath(t *testing.T) {
 	assert.Equal(t, "App-A", m.Modules[0].Name())
 }
 
+func TestDiffingIgnoresTheCaseOfChangesByDefault(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("App-A", &Spec{Name: "app-a"}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", FileDependencies: []string{"Shared/Foo.txt"}}))
+	check(t, repo.WriteContent("Shared/Foo.txt", "foo"))
+	check(t, repo.Commit("first"))
+	c1 := repo.LastCommit
+
+	check(t, repo.WriteContent("app-a/foo", "bar"))
+	check(t, repo.WriteContent("shared/foo.txt", "bar"))
+	check(t, repo.Commit("second"))
+	c2 := repo.LastCommit
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByDiff(c1.String(), c2.String())
+	check(t, err)
+
+	assert.Equal(t, []string{"app-b", "app-a"}, moduleNames(m.Modules))
+}
+
+func TestDiffingWithCaseSensitivePaths(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{Paths: &PathConfig{CaseSensitive: true}}))
+	check(t, repo.InitModuleWithOptions("App-A", &Spec{Name: "app-a"}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", FileDependencies: []string{"Shared/Foo.txt"}}))
+	check(t, repo.InitModuleWithOptions("app-c", &Spec{Name: "app-c"}))
+	check(t, repo.WriteContent("Shared/Foo.txt", "foo"))
+	check(t, repo.Commit("first"))
+	c1 := repo.LastCommit
+
+	check(t, repo.WriteContent("app-a/foo", "bar"))
+	check(t, repo.WriteContent("shared/foo.txt", "bar"))
+	check(t, repo.WriteContent("app-c/foo", "bar"))
+	check(t, repo.Commit("second"))
+	c2 := repo.LastCommit
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByDiff(c1.String(), c2.String())
+	check(t, err)
+
+	assert.Equal(t, []string{"app-c"}, moduleNames(m.Modules))
+}
+
 func TestCaseSensitivityOfFileDependency(t *testing.T) {
 	clean()
 	repo := NewTestRepo(t, ".tmp/repo")
@@ -1224,3 +1315,115 @@ func TestApplyFilter(t *testing.T) {
 	assert.Equal(t, "app-b", m1.Modules[0].Name())
 	assert.Equal(t, "app-a", m1.Modules[1].Name())
 }
+
+func TestManifestByDiffForSubmoduleChange(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Dependencies: []string{"lib-a"}}))
+	check(t, repo.InitModule("app-c"))
+	check(t, repo.WriteConfig(&RepoConfig{Submodules: &SubmoduleConfig{Recurse: true}}))
+
+	mods := NewTestRepo(t, ".tmp/repo/app-a/vendor")
+	check(t, mods.WriteContent("foo", "a"))
+	check(t, mods.Commit("first"))
+	check(t, repo.AddSubmodule("app-a/vendor", mods))
+
+	libs := NewTestRepo(t, ".tmp/repo/vendor/lib")
+	check(t, libs.InitModule("lib-a"))
+	check(t, libs.Commit("first"))
+	check(t, repo.AddSubmodule("vendor/lib", libs))
+	check(t, repo.Commit("first"))
+	c1 := repo.LastCommit.String()
+
+	check(t, mods.AppendContent("foo", "b"))
+	check(t, mods.Commit("second"))
+	check(t, libs.AppendContent("lib-a/foo", "b"))
+	check(t, libs.Commit("second"))
+	check(t, repo.Commit("second"))
+	c2 := repo.LastCommit.String()
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByDiff(c1, c2)
+	check(t, err)
+
+	assert.ElementsMatch(t, []string{"app-a", "app-b", "lib-a"}, moduleNames(m.Modules))
+}
+
+func TestManifestByCurrentBranchInDetachedHead(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+	first := repo.LastCommit
+
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.Commit("second"))
+	check(t, repo.CheckoutAndDetach(first.String()))
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	assert.Equal(t, first.String(), m.Sha)
+	assert.Equal(t, []string{"app-a"}, moduleNames(m.Modules))
+}
+
+func initScopedRepo(t *testing.T) *Manifest {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("libs/lib-a", &Spec{Name: "lib-a"}))
+	check(t, repo.InitModuleWithOptions("apps/app-a", &Spec{Name: "app-a", Dependencies: []string{"lib-a"}}))
+	check(t, repo.InitModuleWithOptions("apps/app-a/plugin", &Spec{Name: "plugin", Dependencies: []string{"app-a"}}))
+	check(t, repo.InitModuleWithOptions("apps/app-b", &Spec{Name: "app-b"}))
+	check(t, repo.WriteContent("apps/app-a/src/main.go", "a"))
+	check(t, repo.Commit("first"))
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+	return m
+}
+
+func TestFilterByPath(t *testing.T) {
+	m := initScopedRepo(t)
+
+	for p, expected := range map[string]string{
+		"apps/app-a":                  "app-a",
+		"apps/app-a/src":              "app-a",
+		"apps/app-a/src/main.go":      "app-a",
+		`apps\app-a\src`:              "app-a",
+		"apps/app-a/plugin":           "plugin",
+		"apps/app-a/plugin/x/y":       "plugin",
+		"apps/app-b/":                 "app-b",
+		"libs/lib-a/../../libs/lib-a": "lib-a",
+	} {
+		filtered, err := m.FilterByPath(p)
+		check(t, err)
+		assert.Equal(t, []string{expected}, moduleNames(filtered.Modules), p)
+	}
+}
+
+func TestFilterByPathOutsideModules(t *testing.T) {
+	m := initScopedRepo(t)
+
+	_, err := m.FilterByPath("apps")
+	assert.EqualError(t, err, fmt.Sprintf(msgNoModuleInPath, "apps"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestApplyFiltersWithPathAndDependencies(t *testing.T) {
+	m := initScopedRepo(t)
+
+	filtered, err := m.ApplyFilters(&FilterOptions{Path: "apps/app-a/src", Dependencies: true})
+	check(t, err)
+	assert.Equal(t, []string{"lib-a", "app-a"}, moduleNames(filtered.Modules))
+
+	filtered, err = m.ApplyFilters(&FilterOptions{Path: "apps/app-a/src", Dependents: true})
+	check(t, err)
+	assert.Equal(t, []string{"app-a", "plugin"}, moduleNames(filtered.Modules))
+
+	filtered, err = m.ApplyFilters(&FilterOptions{Path: "apps/app-a/src", Dependencies: true, Dependents: true})
+	check(t, err)
+	assert.Equal(t, []string{"lib-a", "app-a", "plugin"}, moduleNames(filtered.Modules))
+	assert.Len(t, m.Modules, 4)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Variants returns the list of variants expanded from the build matrix
+// declared in the spec.
+// Returns nil if the module does not declare a matrix.
+// Variants are the cartesian product of all matrix variables and
+// are ordered by the variable names followed by the order of values
+// in the spec.
+func (a *Module) Variants() []*Variant {
+	matrix := a.metadata.spec.Matrix
+	if len(matrix) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(matrix))
+	for k := range matrix {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	combinations := []map[string]string{{}}
+	for _, k := range keys {
+		next := make([]map[string]string, 0, len(combinations)*len(matrix[k]))
+		for _, c := range combinations {
+			for _, v := range matrix[k] {
+				n := make(map[string]string, len(c)+1)
+				for ck, cv := range c {
+					n[ck] = cv
+				}
+				n[k] = v
+				next = append(next, n)
+			}
+		}
+		combinations = next
+	}
+
+	variants := make([]*Variant, 0, len(combinations))
+	for _, c := range combinations {
+		if len(c) == 0 {
+			continue
+		}
+
+		pairs := make([]string, 0, len(keys))
+		for _, k := range keys {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", k, c[k]))
+		}
+		name := strings.Join(pairs, ",")
+
+		version := a.Version()
+		if version != "local" {
+			h := sha1.New()
+			io.WriteString(h, version)
+			io.WriteString(h, name)
+			version = hex.EncodeToString(h.Sum(nil))
+		}
+
+		variants = append(variants, &Variant{
+			Name:    name,
+			Values:  c,
+			Version: version,
+		})
+	}
+
+	return variants
+}
+
+// environment returns the environment variables exposing this variant
+// to the executed commands.
+func (v *Variant) environment() []string {
+	keys := make([]string, 0, len(v.Values))
+	for k := range v.Values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	r := []string{
+		fmt.Sprintf("MBT_VARIANT=%s", v.Name),
+		fmt.Sprintf("MBT_VARIANT_VERSION=%s", v.Version),
+	}
+	for _, k := range keys {
+		r = append(r, fmt.Sprintf("MBT_MATRIX_%s=%s", strings.ToUpper(k), v.Values[k]))
+	}
+
+	return r
+}
@@ -41,9 +41,9 @@ func (r *TestRepository) InitModule(p string) error {
 	return r.InitModuleWithOptions(p, &Spec{
 		Name: path.Base(p),
 		Build: map[string]*Cmd{
-			"darwin":  {"./build.sh", []string{}},
-			"linux":   {"./build.sh", []string{}},
-			"windows": {"powershell", []string{"-ExecutionPolicy", "Bypass", "-File", ".\\build.ps1"}},
+			"darwin":  {Cmd: "./build.sh", Args: []string{}},
+			"linux":   {Cmd: "./build.sh", Args: []string{}},
+			"windows": {Cmd: "powershell", Args: []string{"-ExecutionPolicy", "Bypass", "-File", ".\\build.ps1"}},
 		},
 		Properties: map[string]interface{}{"foo": "bar", "jar": "car"},
 	})
@@ -69,6 +69,15 @@ func (r *TestRepository) InitModuleWithOptions(p string, mod *Spec) error {
 	return nil
 }
 
+func (r *TestRepository) WriteConfig(config *RepoConfig) error {
+	buff, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	return r.WriteContent(path.Join(configDir, configFile), string(buff))
+}
+
 func (r *TestRepository) WriteContent(file, content string) error {
 	fpath := path.Join(r.Dir, file)
 	dir := path.Dir(fpath)
@@ -103,6 +112,12 @@ func (r *TestRepository) AppendContent(file, content string) error {
 }
 
 func (r *TestRepository) Commit(message string) error {
+	return r.CommitAs(message, "alice", "alice@wonderland.com")
+}
+
+// CommitAs commits the changes in the working directory as the
+// specified author.
+func (r *TestRepository) CommitAs(message, name, email string) error {
 	idx, err := r.Repo.Index()
 	if err != nil {
 		return err
@@ -131,8 +146,8 @@ func (r *TestRepository) Commit(message string) error {
 	}
 
 	sig := &git.Signature{
-		Email: "alice@wonderland.com",
-		Name:  "alice",
+		Email: email,
+		Name:  name,
 		When:  time.Now(),
 	}
 
@@ -164,6 +179,23 @@ func (r *TestRepository) Commit(message string) error {
 	return nil
 }
 
+// AddSubmodule adds the test repository checked out at p (relative to
+// the root of this repository) as a submodule. The commit the submodule
+// points to is updated on each commit of this repository.
+func (r *TestRepository) AddSubmodule(p string, sub *TestRepository) error {
+	idx, err := r.Repo.Index()
+	if err != nil {
+		return err
+	}
+
+	err = idx.Add(&git.IndexEntry{Path: p, Mode: git.FilemodeCommit, Id: sub.LastCommit})
+	if err != nil {
+		return err
+	}
+
+	return idx.Write()
+}
+
 func (r *TestRepository) SwitchToBranch(name string) error {
 	branch, err := r.Repo.LookupBranch(name, git.BranchAll)
 	if err != nil {
@@ -376,6 +408,14 @@ func sCommit(e interface{}) Commit {
 	return e.(Commit)
 }
 
+func sRepo(e interface{}) Repo {
+	if e == nil {
+		return nil
+	}
+
+	return e.(Repo)
+}
+
 func sManifest(e interface{}) *Manifest {
 	if e == nil {
 		return nil
@@ -455,6 +495,11 @@ func (r *TestRepo) WalkBlobs(a Commit, callback BlobWalkCallback) error {
 	return sErr(ret[0])
 }
 
+func (r *TestRepo) WalkBlobsInDir(a Commit, dir string, callback BlobWalkCallback) error {
+	ret := r.Interceptor.Call("WalkBlobsInDir", a, dir, callback)
+	return sErr(ret[0])
+}
+
 func (r *TestRepo) BlobContents(blob Blob) ([]byte, error) {
 	ret := r.Interceptor.Call("BlobContents", blob)
 	return ret[0].([]byte), sErr(ret[1])
@@ -490,11 +535,31 @@ func (r *TestRepo) IsEmpty() (bool, error) {
 	return ret[0].(bool), sErr(ret[1])
 }
 
+func (r *TestRepo) GitDir() string {
+	ret := r.Interceptor.Call("GitDir")
+	return ret[0].(string)
+}
+
+func (r *TestRepo) IsShallow() (bool, error) {
+	ret := r.Interceptor.Call("IsShallow")
+	return ret[0].(bool), sErr(ret[1])
+}
+
+func (r *TestRepo) IsHeadDetached() (bool, error) {
+	ret := r.Interceptor.Call("IsHeadDetached")
+	return ret[0].(bool), sErr(ret[1])
+}
+
 func (r *TestRepo) FindAllFilesInWorkspace(pathSpec []string) ([]string, error) {
 	ret := r.Interceptor.Call("FindAllFilesInWorkspace", pathSpec)
 	return ret[0].([]string), sErr(ret[1])
 }
 
+func (r *TestRepo) IsIgnored(path string) (bool, error) {
+	ret := r.Interceptor.Call("IsIgnored", path)
+	return ret[0].(bool), sErr(ret[1])
+}
+
 func (r *TestRepo) EnsureSafeWorkspace() error {
 	ret := r.Interceptor.Call("EnsureSafeWorkspace")
 	return sErr(ret[0])
@@ -515,6 +580,49 @@ func (r *TestRepo) MergeBase(a, b Commit) (Commit, error) {
 	return sCommit(ret[0]), sErr(ret[1])
 }
 
+func (r *TestRepo) ResolveCommit(rev string) (Commit, error) {
+	ret := r.Interceptor.Call("ResolveCommit", rev)
+	return sCommit(ret[0]), sErr(ret[1])
+}
+
+func (r *TestRepo) History(from, to Commit) ([]*LogEntry, error) {
+	ret := r.Interceptor.Call("History", from, to)
+	return ret[0].([]*LogEntry), sErr(ret[1])
+}
+
+func (r *TestRepo) References() ([]string, error) {
+	ret := r.Interceptor.Call("References")
+	return ret[0].([]string), sErr(ret[1])
+}
+
+func (r *TestRepo) SparseCheckout() (*SparseCheckout, error) {
+	ret := r.Interceptor.Call("SparseCheckout")
+	if ret[0] == nil {
+		return nil, sErr(ret[1])
+	}
+	return ret[0].(*SparseCheckout), sErr(ret[1])
+}
+
+func (r *TestRepo) LFSObjectID(blob Blob) (string, error) {
+	ret := r.Interceptor.Call("LFSObjectID", blob)
+	return ret[0].(string), sErr(ret[1])
+}
+
+func (r *TestRepo) Submodules(commit Commit) ([]*Submodule, error) {
+	ret := r.Interceptor.Call("Submodules", commit)
+	return ret[0].([]*Submodule), sErr(ret[1])
+}
+
+func (r *TestRepo) OpenSubmodule(path string) (Repo, error) {
+	ret := r.Interceptor.Call("OpenSubmodule", path)
+	return sRepo(ret[0]), sErr(ret[1])
+}
+
+func (r *TestRepo) HooksDir() (string, error) {
+	ret := r.Interceptor.Call("HooksDir")
+	return ret[0].(string), sErr(ret[1])
+}
+
 type TestManifestBuilder struct {
 	Interceptor *intercept.Interceptor
 }
@@ -539,6 +647,11 @@ func (b *TestManifestBuilder) ByCommitContent(sha Commit) (*Manifest, error) {
 	return sManifest(ret[0]), sErr(ret[1])
 }
 
+func (b *TestManifestBuilder) ByCommitAtConfig(sha, configCommit Commit) (*Manifest, error) {
+	ret := b.Interceptor.Call("ByCommitAtConfig", sha, configCommit)
+	return sManifest(ret[0]), sErr(ret[1])
+}
+
 func (b *TestManifestBuilder) ByBranch(name string) (*Manifest, error) {
 	ret := b.Interceptor.Call("ByBranch", name)
 	return sManifest(ret[0]), sErr(ret[1])
@@ -583,6 +696,36 @@ func (s *TestSystem) ApplyLocal(templatePath string, output io.Writer) error {
 	return sErr(ret[0])
 }
 
+func (s *TestSystem) ApplyBranchWithOptions(templatePath, branch string, options *ApplyOptions) error {
+	ret := s.Interceptor.Call("ApplyBranchWithOptions", templatePath, branch, options)
+	return sErr(ret[0])
+}
+
+func (s *TestSystem) ApplyCommitWithOptions(sha, templatePath string, options *ApplyOptions) error {
+	ret := s.Interceptor.Call("ApplyCommitWithOptions", sha, templatePath, options)
+	return sErr(ret[0])
+}
+
+func (s *TestSystem) ApplyHeadWithOptions(templatePath string, options *ApplyOptions) error {
+	ret := s.Interceptor.Call("ApplyHeadWithOptions", templatePath, options)
+	return sErr(ret[0])
+}
+
+func (s *TestSystem) ApplyLocalWithOptions(templatePath string, options *ApplyOptions) error {
+	ret := s.Interceptor.Call("ApplyLocalWithOptions", templatePath, options)
+	return sErr(ret[0])
+}
+
+func (s *TestSystem) ApplyDiff(from, to, templatePath string, options *ApplyOptions, output io.Writer) error {
+	ret := s.Interceptor.Call("ApplyDiff", from, to, templatePath, options, output)
+	return sErr(ret[0])
+}
+
+func (s *TestSystem) ApplyKubernetes(from, to, templatePath string, options *ApplyOptions, kubernetes *KubernetesOptions) error {
+	ret := s.Interceptor.Call("ApplyKubernetes", from, to, templatePath, options, kubernetes)
+	return sErr(ret[0])
+}
+
 func (s *TestSystem) BuildBranch(name string, filterOptions *FilterOptions, options *CmdOptions) (*BuildSummary, error) {
 	ret := s.Interceptor.Call("BuildBranch", name, filterOptions, options)
 	return sBuildSummary(ret[0]), sErr(ret[1])
@@ -665,6 +808,181 @@ func (s *TestSystem) RunInWorkspaceChanges(command string, options *CmdOptions)
 	return sRunResult(ret[0]), sErr(ret[1])
 }
 
+func (s *TestSystem) Watch(watchOptions *WatchOptions, options *CmdOptions) error {
+	ret := s.Interceptor.Call("Watch", watchOptions, options)
+	return sErr(ret[0])
+}
+
+func (s *TestSystem) FlakyModules() ([]*ModuleStats, error) {
+	ret := s.Interceptor.Call("FlakyModules")
+	return ret[0].([]*ModuleStats), sErr(ret[1])
+}
+
+func (s *TestSystem) LastBuilt() (map[string]time.Time, error) {
+	ret := s.Interceptor.Call("LastBuilt")
+	return ret[0].(map[string]time.Time), sErr(ret[1])
+}
+
+func (s *TestSystem) Migrate(migrateOptions *MigrateOptions, options *CmdOptions) (*MigrationReport, error) {
+	ret := s.Interceptor.Call("Migrate", migrateOptions, options)
+	return ret[0].(*MigrationReport), sErr(ret[1])
+}
+
+func (s *TestSystem) DurationStats(options *DurationStatsOptions) ([]*DurationStats, error) {
+	ret := s.Interceptor.Call("DurationStats", options)
+	return ret[0].([]*DurationStats), sErr(ret[1])
+}
+
+func (s *TestSystem) ImportManifest(r io.Reader) error {
+	ret := s.Interceptor.Call("ImportManifest", r)
+	return sErr(ret[0])
+}
+
+func (s *TestSystem) GenerateSBOM(m *Manifest, options *SBOMOptions) error {
+	ret := s.Interceptor.Call("GenerateSBOM", m, options)
+	return sErr(ret[0])
+}
+
+func (s *TestSystem) Changelog(module, from, to string) (*Changelog, error) {
+	ret := s.Interceptor.Call("Changelog", module, from, to)
+	return ret[0].(*Changelog), sErr(ret[1])
+}
+
+func (s *TestSystem) Affected(base, head, purpose string) (*Manifest, error) {
+	ret := s.Interceptor.Call("Affected", base, head, purpose)
+	return ret[0].(*Manifest), sErr(ret[1])
+}
+
+func (s *TestSystem) Doctor() *DoctorReport {
+	ret := s.Interceptor.Call("Doctor")
+	return ret[0].(*DoctorReport)
+}
+
+func (s *TestSystem) HealthReport(options *HealthReportOptions) (*HealthReport, error) {
+	ret := s.Interceptor.Call("HealthReport", options)
+	return ret[0].(*HealthReport), sErr(ret[1])
+}
+
+func (s *TestSystem) Completions() (*Completions, error) {
+	ret := s.Interceptor.Call("Completions")
+	return ret[0].(*Completions), sErr(ret[1])
+}
+
+func (s *TestSystem) Ownership(options *OwnershipOptions) (*OwnershipReport, error) {
+	ret := s.Interceptor.Call("Ownership", options)
+	return ret[0].(*OwnershipReport), sErr(ret[1])
+}
+
+func (s *TestSystem) AnalyseHistory(options *HistoryOptions) (*HistoryAnalysis, error) {
+	ret := s.Interceptor.Call("AnalyseHistory", options)
+	return ret[0].(*HistoryAnalysis), sErr(ret[1])
+}
+
+func (s *TestSystem) Issues(from, to string) (map[string][]string, error) {
+	ret := s.Interceptor.Call("Issues", from, to)
+	return ret[0].(map[string][]string), sErr(ret[1])
+}
+
+func (s *TestSystem) PRReport(base, head string, summary *InvocationSummary) (*PRReport, error) {
+	ret := s.Interceptor.Call("PRReport", base, head, summary)
+	return ret[0].(*PRReport), sErr(ret[1])
+}
+
+func (s *TestSystem) TerraformPlan(base, head string, options *TerraformOptions) (*TerraformPlan, error) {
+	ret := s.Interceptor.Call("TerraformPlan", base, head, options)
+	return ret[0].(*TerraformPlan), sErr(ret[1])
+}
+
+func (s *TestSystem) Serve(options *ServeOptions) error {
+	ret := s.Interceptor.Call("Serve", options)
+	return sErr(ret[0])
+}
+
+func (s *TestSystem) LockPlugins() (*PluginLock, error) {
+	ret := s.Interceptor.Call("LockPlugins")
+	return ret[0].(*PluginLock), sErr(ret[1])
+}
+
+func (s *TestSystem) FormatSpecs(check bool) ([]*FormatResult, error) {
+	ret := s.Interceptor.Call("FormatSpecs", check)
+	return ret[0].([]*FormatResult), sErr(ret[1])
+}
+
+func (s *TestSystem) Pins() (*PinLock, error) {
+	ret := s.Interceptor.Call("Pins")
+	return ret[0].(*PinLock), sErr(ret[1])
+}
+
+func (s *TestSystem) Pin(module string, options *PinOptions) (*PinnedModule, error) {
+	ret := s.Interceptor.Call("Pin", module, options)
+	return ret[0].(*PinnedModule), sErr(ret[1])
+}
+
+func (s *TestSystem) Unpin(module string) error {
+	ret := s.Interceptor.Call("Unpin", module)
+	return sErr(ret[0])
+}
+
+func (s *TestSystem) ServeStdio(in io.Reader, out io.Writer) error {
+	ret := s.Interceptor.Call("ServeStdio", in, out)
+	return sErr(ret[0])
+}
+
+func (s *TestSystem) ReleasePlan(options *ReleaseOptions) (*ReleasePlan, error) {
+	ret := s.Interceptor.Call("ReleasePlan", options)
+	return ret[0].(*ReleasePlan), sErr(ret[1])
+}
+
+func (s *TestSystem) MergeQueue(base string, heads []string) (*MergeQueueAdvice, error) {
+	ret := s.Interceptor.Call("MergeQueue", base, heads)
+	return ret[0].(*MergeQueueAdvice), sErr(ret[1])
+}
+
+func (s *TestSystem) Import(options *ImportOptions) ([]*ImportedModule, error) {
+	ret := s.Interceptor.Call("Import", options)
+	return ret[0].([]*ImportedModule), sErr(ret[1])
+}
+
+func (s *TestSystem) Init(options *InitOptions) (*InitReport, error) {
+	ret := s.Interceptor.Call("Init", options)
+	return ret[0].(*InitReport), sErr(ret[1])
+}
+
+func (s *TestSystem) CacheStats() ([]*CacheStats, error) {
+	ret := s.Interceptor.Call("CacheStats")
+	return ret[0].([]*CacheStats), sErr(ret[1])
+}
+
+func (s *TestSystem) CacheGC(options *CacheGCOptions) ([]*CacheStats, error) {
+	ret := s.Interceptor.Call("CacheGC", options)
+	return ret[0].([]*CacheStats), sErr(ret[1])
+}
+
+func (s *TestSystem) CacheClear(names []string) ([]*CacheStats, error) {
+	ret := s.Interceptor.Call("CacheClear", names)
+	return ret[0].([]*CacheStats), sErr(ret[1])
+}
+
+func (s *TestSystem) InstallHooks(options *HookOptions) ([]string, error) {
+	ret := s.Interceptor.Call("InstallHooks", options)
+	return ret[0].([]string), sErr(ret[1])
+}
+
+func (s *TestSystem) VerifyPreCommit() (*Manifest, error) {
+	ret := s.Interceptor.Call("VerifyPreCommit")
+	return ret[0].(*Manifest), sErr(ret[1])
+}
+
+func (s *TestSystem) VerifyPrePush(updates io.Reader) ([]*PushUpdate, error) {
+	ret := s.Interceptor.Call("VerifyPrePush", updates)
+	return ret[0].([]*PushUpdate), sErr(ret[1])
+}
+
+func (s *TestSystem) Close() error {
+	ret := s.Interceptor.Call("Close")
+	return sErr(ret[0])
+}
+
 func (s *TestSystem) IntersectionByCommit(first, second string) (Modules, error) {
 	ret := s.Interceptor.Call("IntersectionByCommit", first, second)
 	return sModules(ret[0]), sErr(ret[1])
@@ -690,6 +1008,11 @@ func (s *TestSystem) ManifestByCommit(sha string) (*Manifest, error) {
 	return sManifest(ret[0]), sErr(ret[1])
 }
 
+func (s *TestSystem) ManifestByCommitAtConfig(sha, configSha string) (*Manifest, error) {
+	ret := s.Interceptor.Call("ManifestByCommitAtConfig", sha, configSha)
+	return sManifest(ret[0]), sErr(ret[1])
+}
+
 func (s *TestSystem) ManifestByCommitContent(sha string) (*Manifest, error) {
 	ret := s.Interceptor.Call("ManifestByCommitContent", sha)
 	return sManifest(ret[0]), sErr(ret[1])
@@ -724,6 +1047,11 @@ func (d *TestDiscover) ModulesInCommit(commit Commit) (Modules, error) {
 	return sModules(ret[0]), sErr(ret[1])
 }
 
+func (d *TestDiscover) ModulesInCommitAtConfig(commit, configCommit Commit) (Modules, error) {
+	ret := d.Interceptor.Call("ModulesInCommitAtConfig", commit, configCommit)
+	return sModules(ret[0]), sErr(ret[1])
+}
+
 func (d *TestDiscover) ModulesInWorkspace() (Modules, error) {
 	ret := d.Interceptor.Call("ModulesInWorkspace")
 	return sModules(ret[0]), sErr(ret[1])
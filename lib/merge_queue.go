@@ -0,0 +1,186 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// MergeQueueFormatText lists the batches and the overlaps.
+	MergeQueueFormatText = "text"
+	// MergeQueueFormatJSON formats the advice as json.
+	MergeQueueFormatJSON = "json"
+)
+
+// MergeQueueAdvice is the suggested batching of a set of changes
+// (e.g. the head commits of pull requests) waiting in a merge queue.
+type MergeQueueAdvice struct {
+	Base    string          `json:"base"`
+	Changes []*QueuedChange `json:"changes"`
+	// Overlaps are the pairs of changes affecting a common module.
+	Overlaps []*ChangeOverlap `json:"overlaps"`
+	// Batches are the groups of changes that can be merged together,
+	// in the order of the queue. Changes in a batch do not affect a
+	// common module.
+	Batches [][]string `json:"batches"`
+}
+
+// QueuedChange is a change in the merge queue.
+type QueuedChange struct {
+	Ref      string   `json:"ref"`
+	Sha      string   `json:"sha"`
+	Affected []string `json:"affected"`
+}
+
+// ChangeOverlap is a pair of changes affecting common modules.
+type ChangeOverlap struct {
+	First   string   `json:"first"`
+	Second  string   `json:"second"`
+	Modules []string `json:"modules"`
+}
+
+func (s *stdSystem) MergeQueue(base string, heads []string) (*MergeQueueAdvice, error) {
+	if len(heads) == 0 {
+		return nil, e.NewErrorf(ErrClassUser, msgNoQueuedChanges)
+	}
+
+	advice := &MergeQueueAdvice{
+		Base:     base,
+		Changes:  make([]*QueuedChange, 0, len(heads)),
+		Overlaps: make([]*ChangeOverlap, 0),
+		Batches:  make([][]string, 0),
+	}
+
+	affected := make([]map[string]bool, 0, len(heads))
+	for _, h := range heads {
+		i, err := s.changeImpact(base, h)
+		if err != nil {
+			return nil, err
+		}
+
+		c := &QueuedChange{Ref: h, Sha: i.to.ID(), Affected: make([]string, 0, len(i.affected))}
+		set := make(map[string]bool, len(i.affected))
+		for _, m := range i.affected {
+			c.Affected = append(c.Affected, m.Name())
+			set[m.Name()] = true
+		}
+		sort.Strings(c.Affected)
+
+		advice.Changes = append(advice.Changes, c)
+		affected = append(affected, set)
+	}
+
+	for i := range advice.Changes {
+		for j := i + 1; j < len(advice.Changes); j++ {
+			if common := commonKeys(affected[i], affected[j]); len(common) > 0 {
+				advice.Overlaps = append(advice.Overlaps, &ChangeOverlap{
+					First:   advice.Changes[i].Ref,
+					Second:  advice.Changes[j].Ref,
+					Modules: common,
+				})
+			}
+		}
+	}
+
+	// Each change joins the first batch it does not overlap with, so
+	// that the changes are merged close to the order of the queue.
+	batches := make([]map[string]bool, 0)
+	for i, c := range advice.Changes {
+		placed := false
+		for b, modules := range batches {
+			if len(commonKeys(modules, affected[i])) == 0 {
+				for m := range affected[i] {
+					modules[m] = true
+				}
+				advice.Batches[b] = append(advice.Batches[b], c.Ref)
+				placed = true
+				break
+			}
+		}
+
+		if !placed {
+			modules := make(map[string]bool, len(affected[i]))
+			for m := range affected[i] {
+				modules[m] = true
+			}
+			batches = append(batches, modules)
+			advice.Batches = append(advice.Batches, []string{c.Ref})
+		}
+	}
+
+	return advice, nil
+}
+
+// commonKeys returns the sorted list of the keys in both sets.
+func commonKeys(a, b map[string]bool) []string {
+	r := make([]string, 0)
+	for k := range a {
+		if b[k] {
+			r = append(r, k)
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
+// Write writes the advice in the specified format.
+func (a *MergeQueueAdvice) Write(format string, w io.Writer) error {
+	var (
+		buff []byte
+		err  error
+	)
+
+	switch format {
+	case MergeQueueFormatText:
+		buff = a.text()
+	case MergeQueueFormatJSON:
+		buff, err = json.MarshalIndent(a, "", "  ")
+		buff = append(buff, '\n')
+	default:
+		return e.NewErrorf(ErrClassUser, msgUnsupportedMergeQueueFormat, format)
+	}
+
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	if _, err := w.Write(buff); err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	return nil
+}
+
+func (a *MergeQueueAdvice) text() []byte {
+	buff := new(bytes.Buffer)
+	for i, b := range a.Batches {
+		fmt.Fprintf(buff, "batch %d\t%s\n", i+1, strings.Join(b, " "))
+	}
+
+	for _, o := range a.Overlaps {
+		fmt.Fprintf(buff, "overlap\t%s %s\t%s\n", o.First, o.Second, strings.Join(o.Modules, ", "))
+	}
+
+	return buff.Bytes()
+}
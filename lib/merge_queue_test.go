@@ -0,0 +1,86 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeQueue(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("lib-a"))
+	check(t, repo.InitModuleWithOptions("svc-a", &Spec{Name: "svc-a", Dependencies: []string{"lib-a"}}))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.InitModule("app-c"))
+	check(t, repo.Commit("first"))
+
+	check(t, repo.SwitchToBranch("pr-1"))
+	check(t, repo.WriteContent("lib-a/file", "1"))
+	check(t, repo.Commit("pr-1"))
+
+	check(t, repo.SwitchToBranch("master"))
+	check(t, repo.SwitchToBranch("pr-2"))
+	check(t, repo.WriteContent("svc-a/file", "2"))
+	check(t, repo.Commit("pr-2"))
+
+	check(t, repo.SwitchToBranch("master"))
+	check(t, repo.SwitchToBranch("pr-3"))
+	check(t, repo.WriteContent("app-b/file", "3"))
+	check(t, repo.Commit("pr-3"))
+
+	check(t, repo.SwitchToBranch("master"))
+	check(t, repo.SwitchToBranch("pr-4"))
+	check(t, repo.WriteContent("app-c/file", "4"))
+	check(t, repo.WriteContent("app-b/file", "4"))
+	check(t, repo.Commit("pr-4"))
+
+	advice, err := NewWorld(t, ".tmp/repo").System.MergeQueue("master", []string{"pr-1", "pr-2", "pr-3", "pr-4"})
+	check(t, err)
+
+	assert.Equal(t, []string{"lib-a", "svc-a"}, advice.Changes[0].Affected)
+	assert.Equal(t, []string{"svc-a"}, advice.Changes[1].Affected)
+	assert.Equal(t, [][]string{{"pr-1", "pr-3"}, {"pr-2", "pr-4"}}, advice.Batches)
+	assert.Equal(t, []*ChangeOverlap{
+		{First: "pr-1", Second: "pr-2", Modules: []string{"svc-a"}},
+		{First: "pr-3", Second: "pr-4", Modules: []string{"app-b"}},
+	}, advice.Overlaps)
+
+	buff := new(bytes.Buffer)
+	check(t, advice.Write(MergeQueueFormatText, buff))
+	assert.Equal(t, "batch 1\tpr-1 pr-3\nbatch 2\tpr-2 pr-4\noverlap\tpr-1 pr-2\tsvc-a\noverlap\tpr-3 pr-4\tapp-b\n", buff.String())
+}
+
+func TestMergeQueueWithoutChanges(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.MergeQueue("master", nil)
+
+	assert.EqualError(t, err, "At least one change is required to advise on the merge queue")
+}
+
+func TestMergeQueueWriteUnsupportedFormat(t *testing.T) {
+	err := (&MergeQueueAdvice{}).Write("yaml", new(bytes.Buffer))
+
+	assert.EqualError(t, err, "Unsupported format yaml, supported formats are text and json")
+}
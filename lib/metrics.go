@@ -0,0 +1,230 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMetricsPrefix = "mbt"
+	defaultMetricsJob    = "mbt"
+	metricsTimeout       = 10 * time.Second
+)
+
+var statsdUnsafeChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// MetricsConfig specifies where the metrics of builds and runs are
+// exported to.
+type MetricsConfig struct {
+	// Pushgateway is the url of a prometheus pushgateway
+	// (e.g. http://pushgateway:9091).
+	Pushgateway string `yaml:"pushgateway,omitempty"`
+	// Job is the name of the job metrics are grouped by in pushgateway.
+	Job string `yaml:"job,omitempty"`
+	// Statsd is the address of a statsd server (e.g. localhost:8125).
+	Statsd string `yaml:"statsd,omitempty"`
+	// Prefix of the metric names.
+	Prefix string `yaml:"prefix,omitempty"`
+	// History records the outcome of each module in the history stored
+	// in the state directory (see System.DurationStats).
+	History bool `yaml:"history,omitempty"`
+	// HistoryRetention is the age of the oldest record kept in the
+	// history (e.g. 90d). Records are kept forever if it is empty.
+	HistoryRetention string `yaml:"historyRetention,omitempty"`
+}
+
+func (c *MetricsConfig) prefix() string {
+	if c.Prefix == "" {
+		return defaultMetricsPrefix
+	}
+	return c.Prefix
+}
+
+func (c *MetricsConfig) job() string {
+	if c.Job == "" {
+		return defaultMetricsJob
+	}
+	return c.Job
+}
+
+// pushMetrics exports the metrics of an invocation to the endpoints
+// specified in the configuration.
+// Failure to export the metrics does not fail the invocation, therefore
+// errors are logged as warnings.
+func (s *stdSystem) pushMetrics(config *MetricsConfig, summary *InvocationSummary) {
+	if config == nil {
+		return
+	}
+
+	if config.Pushgateway != "" {
+		if err := pushPrometheusMetrics(config, summary); err != nil {
+			s.Log.Warnf(msgFailedPushMetrics, config.Pushgateway, err)
+		}
+	}
+
+	if config.Statsd != "" {
+		if err := pushStatsdMetrics(config, summary); err != nil {
+			s.Log.Warnf(msgFailedPushMetrics, config.Statsd, err)
+		}
+	}
+
+	if config.History {
+		if err := s.recordHistory(config, summary, time.Now()); err != nil {
+			s.Log.Warnf(msgFailedRecordHistory, err)
+		}
+	}
+}
+
+// moduleBuilt returns true if the command of a module was executed or
+// its previous result was reused.
+func moduleBuilt(m *ModuleSummary) bool {
+	return m.Status == ModuleStatusSucceeded || m.Status == ModuleStatusFailed || moduleReused(m)
+}
+
+// moduleReused returns true if a previous result of a module was reused
+// instead of executing its command.
+func moduleReused(m *ModuleSummary) bool {
+	return m.Status == ModuleStatusResumed || m.Status == ModuleStatusSatisfied || m.Status == ModuleStatusUnaffected || m.Status == ModuleStatusCached
+}
+
+func boolMetric(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// prometheusMetrics formats the metrics of an invocation in prometheus
+// text exposition format.
+func prometheusMetrics(prefix string, summary *InvocationSummary) []byte {
+	buff := new(bytes.Buffer)
+	gauge := func(name, help string) {
+		fmt.Fprintf(buff, "# HELP %s_%s %s\n# TYPE %s_%s gauge\n", prefix, name, help, prefix, name)
+	}
+
+	gauge("module_duration_seconds", "Duration of the command of the module.")
+	for _, m := range summary.Modules {
+		if m.Status == ModuleStatusSucceeded || m.Status == ModuleStatusFailed {
+			fmt.Fprintf(buff, "%s_module_duration_seconds{module=%s} %g\n", prefix, prometheusLabel(m.Name), m.Duration)
+		}
+	}
+
+	gauge("module_success", "Whether the command of the module succeeded.")
+	for _, m := range summary.Modules {
+		if moduleBuilt(m) {
+			fmt.Fprintf(buff, "%s_module_success{module=%s} %d\n", prefix, prometheusLabel(m.Name), boolMetric(m.Status != ModuleStatusFailed))
+		}
+	}
+
+	gauge("module_cache_hit", "Whether the result of a previous build of the module was reused.")
+	for _, m := range summary.Modules {
+		if moduleBuilt(m) {
+			fmt.Fprintf(buff, "%s_module_cache_hit{module=%s} %d\n", prefix, prometheusLabel(m.Name), boolMetric(moduleReused(m)))
+		}
+	}
+
+	gauge("module_status", "Status of the module in the last invocation.")
+	for _, m := range summary.Modules {
+		fmt.Fprintf(buff, "%s_module_status{module=%s,status=%s} 1\n", prefix, prometheusLabel(m.Name), prometheusLabel(m.Status))
+	}
+
+	gauge("success", "Whether the invocation succeeded.")
+	fmt.Fprintf(buff, "%s_success %d\n", prefix, boolMetric(summary.Success))
+
+	return buff.Bytes()
+}
+
+func prometheusLabel(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
+
+// pushPrometheusMetrics replaces the metrics of the command in the
+// pushgateway, so that the modules not built in this invocation are
+// not reported with stale values.
+func pushPrometheusMetrics(config *MetricsConfig, summary *InvocationSummary) error {
+	u := fmt.Sprintf("%s/metrics/job/%s/command/%s",
+		strings.TrimRight(config.Pushgateway, "/"),
+		url.PathEscape(config.job()),
+		url.PathEscape(summary.Command))
+
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(prometheusMetrics(config.prefix(), summary)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	res, err := (&http.Client{Timeout: metricsTimeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected response %s", res.Status)
+	}
+
+	return nil
+}
+
+// statsdMetrics formats the metrics of an invocation as statsd
+// timers and counters.
+func statsdMetrics(prefix string, summary *InvocationSummary) []string {
+	metrics := make([]string, 0)
+	for _, m := range summary.Modules {
+		name := fmt.Sprintf("%s.%s.module.%s", prefix, statsdUnsafeChars.ReplaceAllString(summary.Command, "_"), statsdUnsafeChars.ReplaceAllString(m.Name, "_"))
+		if m.Status == ModuleStatusSucceeded || m.Status == ModuleStatusFailed {
+			metrics = append(metrics, fmt.Sprintf("%s.duration:%d|ms", name, int64(m.Duration*1000)))
+		}
+		metrics = append(metrics, fmt.Sprintf("%s.%s:1|c", name, m.Status))
+		if moduleReused(m) {
+			metrics = append(metrics, fmt.Sprintf("%s.cache_hit:1|c", name))
+		}
+	}
+
+	status := ModuleStatusSucceeded
+	if !summary.Success {
+		status = ModuleStatusFailed
+	}
+	metrics = append(metrics, fmt.Sprintf("%s.%s.%s:1|c", prefix, statsdUnsafeChars.ReplaceAllString(summary.Command, "_"), status))
+
+	return metrics
+}
+
+// pushStatsdMetrics sends each metric in a separate datagram to avoid
+// exceeding the maximum size of a datagram.
+func pushStatsdMetrics(config *MetricsConfig, summary *InvocationSummary) error {
+	conn, err := net.DialTimeout("udp", config.Statsd, metricsTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, m := range statsdMetrics(config.prefix(), summary) {
+		if _, err := conn.Write([]byte(m)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
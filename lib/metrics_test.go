@@ -0,0 +1,134 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func initMetricsRepo(t *testing.T, config *MetricsConfig) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{Metrics: config}))
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "true"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:         "app-b",
+		Build:        map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Dependencies: []string{"app-a"},
+	}))
+	check(t, repo.WriteShellScript("app-b/build.sh", "exit 1"))
+	check(t, repo.Commit("first"))
+}
+
+func TestPushgatewayMetrics(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	var method, path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		check(t, err)
+		method, path, body = r.Method, r.URL.Path, string(b)
+	}))
+	defer server.Close()
+
+	initMetricsRepo(t, &MetricsConfig{Pushgateway: server.URL + "/", Job: "ci"})
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+	assert.Error(t, err)
+
+	assert.Equal(t, http.MethodPut, method)
+	assert.Equal(t, "/metrics/job/ci/command/build", path)
+	assert.Contains(t, body, "# TYPE mbt_module_duration_seconds gauge\n")
+	assert.Regexp(t, `mbt_module_duration_seconds\{module="app-a"\} \S+\n`, body)
+	assert.Contains(t, body, "mbt_module_success{module=\"app-a\"} 1\n")
+	assert.Contains(t, body, "mbt_module_success{module=\"app-b\"} 0\n")
+	assert.Contains(t, body, "mbt_module_cache_hit{module=\"app-a\"} 0\n")
+	assert.Contains(t, body, "mbt_module_status{module=\"app-b\",status=\"failed\"} 1\n")
+	assert.Contains(t, body, "mbt_success 0\n")
+}
+
+func TestStatsdMetrics(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	check(t, err)
+	defer conn.Close()
+
+	initMetricsRepo(t, &MetricsConfig{Statsd: conn.LocalAddr().String(), Prefix: "ci"})
+
+	_, err = NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+	assert.Error(t, err)
+
+	metrics := make([]string, 0)
+	buff := make([]byte, 1024)
+	check(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	for {
+		n, _, err := conn.ReadFrom(buff)
+		if err != nil {
+			break
+		}
+		metrics = append(metrics, string(buff[:n]))
+	}
+	sort.Strings(metrics)
+
+	assert.Len(t, metrics, 5)
+	assert.Equal(t, "ci.build.failed:1|c", metrics[0])
+	assert.Regexp(t, `^ci\.build\.module\.app-a\.duration:\d+\|ms$`, metrics[1])
+	assert.Equal(t, "ci.build.module.app-a.succeeded:1|c", metrics[2])
+	assert.Regexp(t, `^ci\.build\.module\.app-b\.duration:\d+\|ms$`, metrics[3])
+	assert.Equal(t, "ci.build.module.app-b.failed:1|c", metrics[4])
+}
+
+func TestMetricsOfResumedModules(t *testing.T) {
+	summary := &InvocationSummary{
+		Command: "build",
+		Success: true,
+		Modules: []*ModuleSummary{
+			{Name: "app-a", Status: ModuleStatusResumed},
+			{Name: "app.b", Status: ModuleStatusSkipped},
+		},
+	}
+
+	body := string(prometheusMetrics("mbt", summary))
+	assert.Contains(t, body, "mbt_module_cache_hit{module=\"app-a\"} 1\n")
+	assert.Contains(t, body, "mbt_module_success{module=\"app-a\"} 1\n")
+	assert.NotContains(t, body, "mbt_module_success{module=\"app.b\"}")
+	assert.NotContains(t, body, "mbt_module_duration_seconds{")
+	assert.Contains(t, body, "mbt_module_status{module=\"app.b\",status=\"skipped\"} 1\n")
+
+	assert.Equal(t, []string{
+		"mbt.build.module.app-a.resumed:1|c",
+		"mbt.build.module.app-a.cache_hit:1|c",
+		"mbt.build.module.app_b.skipped:1|c",
+		"mbt.build.succeeded:1|c",
+	}, statsdMetrics("mbt", summary))
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const migrationFile = "migration.json"
+
+// Migration step statuses.
+const (
+	// MigrationStatusMigrated indicates that the migration command
+	// succeeded in the module.
+	MigrationStatusMigrated = "migrated"
+	// MigrationStatusFailed indicates that the migration command failed
+	// in the module.
+	MigrationStatusFailed = "failed"
+	// MigrationStatusSkipped indicates that the module does not define
+	// the migration command.
+	MigrationStatusSkipped = "skipped"
+	// MigrationStatusPending indicates that the migration stopped before
+	// reaching the module.
+	MigrationStatusPending = "pending"
+)
+
+// MigrateOptions specifies a migration of the dependents of a module.
+type MigrateOptions struct {
+	// Module is the name of the module whose API changed.
+	Module string
+	// Command is the user defined command executed in each dependent.
+	// CmdOptions.Exec takes precedence when specified.
+	Command string
+	// Resume skips the dependents migrated by the previous run of the
+	// same migration.
+	Resume bool
+}
+
+// MigrationStep is the progress of a migration in a dependent.
+type MigrationStep struct {
+	Module  *Module `json:"-"`
+	Name    string  `json:"name"`
+	Path    string  `json:"path"`
+	Status  string  `json:"status"`
+	Resumed bool    `json:"resumed,omitempty"`
+	// Duration of the command in seconds.
+	Duration float64 `json:"duration"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// MigrationReport is the progress of a migration.
+// Steps are listed in topological order.
+type MigrationReport struct {
+	Module  string           `json:"module"`
+	Command string           `json:"command"`
+	Steps   []*MigrationStep `json:"steps"`
+}
+
+// Failed returns the step that stopped the migration or nil if the
+// migration completed.
+func (r *MigrationReport) Failed() *MigrationStep {
+	for _, step := range r.Steps {
+		if step.Status == MigrationStatusFailed {
+			return step
+		}
+	}
+	return nil
+}
+
+// Count returns the number of steps with the specified status.
+func (r *MigrationReport) Count(status string) int {
+	n := 0
+	for _, step := range r.Steps {
+		if step.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// migrationState is the persistent record of the dependents migrated
+// by the last run of a migration. It is used to resume migrations.
+type migrationState struct {
+	Module   string   `json:"module"`
+	Command  string   `json:"command"`
+	Migrated []string `json:"migrated"`
+}
+
+func (s *stdSystem) Migrate(migrateOptions *MigrateOptions, options *CmdOptions) (*MigrationReport, error) {
+	m, err := s.ManifestByWorkspace()
+	if err != nil {
+		return nil, err
+	}
+
+	mod, ok := m.Module(migrateOptions.Module)
+	if !ok {
+		return nil, e.NewErrorf(ErrClassUser, msgModuleNotFound, migrateOptions.Module)
+	}
+
+	dependents, err := Modules{mod}.expandRequiredByDependencies()
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := loadRepoConfig(m.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	options = s.withContext(options)
+	command := migrateOptions.Command
+	if options.Exec != nil {
+		command = options.Exec.Cmd
+	}
+
+	state := &migrationState{}
+	if migrateOptions.Resume {
+		err = s.readState(migrationFile, state)
+		if err != nil {
+			return nil, err
+		}
+	}
+	migrated := make(map[string]bool)
+	if state.Module == mod.Name() && state.Command == command {
+		for _, n := range state.Migrated {
+			migrated[n] = true
+		}
+	}
+	state = &migrationState{Module: mod.Name(), Command: command, Migrated: make([]string, 0)}
+
+	report := &MigrationReport{Module: mod.Name(), Command: command, Steps: make([]*MigrationStep, 0)}
+	var failed error
+	for _, a := range dependents {
+		if a == mod {
+			continue
+		}
+
+		step := &MigrationStep{Module: a, Name: a.Name(), Path: a.Path()}
+		report.Steps = append(report.Steps, step)
+
+		if failed == nil {
+			failed = cancelled(options.Context)
+		}
+		if failed != nil {
+			step.Status = MigrationStatusPending
+			continue
+		}
+
+		if migrated[a.Name()] {
+			step.Status, step.Resumed = MigrationStatusMigrated, true
+			state.Migrated = append(state.Migrated, a.Name())
+			options.Callback(a, CmdStageSkipBuild, nil)
+			continue
+		}
+
+		cmd, canRun := s.commandToRun(migrateOptions.Command, a, options)
+		if !canRun {
+			step.Status = MigrationStatusSkipped
+			options.Callback(a, CmdStageSkipBuild, nil)
+			continue
+		}
+
+		options.Callback(a, CmdStageBeforeBuild, nil)
+		started := time.Now()
+		failed = s.execCommand(cmd, config, m, a, options)
+		step.Duration = time.Since(started).Seconds()
+		if failed != nil {
+			step.Status, step.Error = MigrationStatusFailed, failed.Error()
+			options.Callback(a, CmdStageFailedBuild, failed)
+		} else {
+			step.Status = MigrationStatusMigrated
+			state.Migrated = append(state.Migrated, a.Name())
+			options.Callback(a, CmdStageAfterBuild, nil)
+		}
+
+		// Progress is saved after each dependent so that an interrupted
+		// migration can be resumed.
+		err = s.writeState(migrationFile, state)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.writeState(migrationFile, state)
+	if err != nil {
+		return nil, err
+	}
+
+	// Partial report is returned when cancelled so that the progress can
+	// be reported.
+	return report, cancelled(options.Context)
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func initMigrationRepo(t *testing.T) *TestRepository {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a"}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Dependencies: []string{"app-a"}}))
+	check(t, repo.InitModuleWithOptions("app-c", &Spec{Name: "app-c", Dependencies: []string{"app-b"}}))
+	check(t, repo.InitModuleWithOptions("app-d", &Spec{Name: "app-d"}))
+	check(t, repo.Commit("first"))
+	return repo
+}
+
+func migrationStatuses(report *MigrationReport) map[string]string {
+	r := make(map[string]string)
+	for _, step := range report.Steps {
+		r[step.Name] = step.Status
+	}
+	return r
+}
+
+func TestMigrateDependentsInTopologicalOrder(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+	initMigrationRepo(t)
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Exec = &UserCmd{Cmd: "echo $MBT_MODULE_NAME", Shell: "sh"}
+	report, err := NewWorld(t, ".tmp/repo").System.Migrate(&MigrateOptions{Module: "app-a"}, options)
+	check(t, err)
+
+	assert.Equal(t, "app-a", report.Module)
+	assert.Len(t, report.Steps, 2)
+	assert.Equal(t, "app-b", report.Steps[0].Name)
+	assert.Equal(t, "app-c", report.Steps[1].Name)
+	assert.Equal(t, 2, report.Count(MigrationStatusMigrated))
+	assert.Nil(t, report.Failed())
+	assert.Equal(t, "app-b\napp-c\n", buff.String())
+}
+
+func TestMigrateStopsAtFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+	repo := initMigrationRepo(t)
+	check(t, repo.WriteContent("app-b/broken", ""))
+
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.Exec = &UserCmd{Cmd: "test ! -f broken", Shell: "sh"}
+	report, err := NewWorld(t, ".tmp/repo").System.Migrate(&MigrateOptions{Module: "app-a"}, options)
+	check(t, err)
+
+	assert.Equal(t, map[string]string{"app-b": MigrationStatusFailed, "app-c": MigrationStatusPending}, migrationStatuses(report))
+	assert.Equal(t, "app-b", report.Failed().Name)
+	assert.NotEmpty(t, report.Failed().Error)
+}
+
+func TestResumeMigration(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+	repo := initMigrationRepo(t)
+	check(t, repo.WriteContent("app-c/broken", ""))
+
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.Exec = &UserCmd{Cmd: "test ! -f broken && echo $MBT_MODULE_NAME >> ../order.txt", Shell: "sh"}
+	w := NewWorld(t, ".tmp/repo")
+	report, err := w.System.Migrate(&MigrateOptions{Module: "app-a"}, options)
+	check(t, err)
+	assert.Equal(t, "app-c", report.Failed().Name)
+
+	check(t, os.Remove(filepath.Join(repo.Dir, "app-c/broken")))
+	report, err = w.System.Migrate(&MigrateOptions{Module: "app-a", Resume: true}, options)
+	check(t, err)
+
+	assert.Equal(t, map[string]string{"app-b": MigrationStatusMigrated, "app-c": MigrationStatusMigrated}, migrationStatuses(report))
+	assert.True(t, report.Steps[0].Resumed)
+	assert.False(t, report.Steps[1].Resumed)
+	order, err := ioutil.ReadFile(filepath.Join(repo.Dir, "order.txt"))
+	check(t, err)
+	assert.Equal(t, "app-b\napp-c\n", string(order))
+}
+
+func TestMigrateWithUserDefinedCommand(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a"}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:         "app-b",
+		Dependencies: []string{"app-a"},
+		Commands:     map[string]*UserCmd{"migrate": {Cmd: "echo", Args: []string{"migrated"}}},
+	}))
+	check(t, repo.InitModuleWithOptions("app-c", &Spec{Name: "app-c", Dependencies: []string{"app-a"}}))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	report, err := NewWorld(t, ".tmp/repo").System.Migrate(&MigrateOptions{Module: "app-a", Command: "migrate"}, stdTestCmdOptions(buff))
+	check(t, err)
+
+	assert.Equal(t, map[string]string{"app-b": MigrationStatusMigrated, "app-c": MigrationStatusSkipped}, migrationStatuses(report))
+	assert.Equal(t, "migrated\n", buff.String())
+}
+
+func TestMigrateUnknownModule(t *testing.T) {
+	initMigrationRepo(t)
+
+	_, err := NewWorld(t, ".tmp/repo").System.Migrate(&MigrateOptions{Module: "app-x", Command: "migrate"}, stdTestCmdOptions(new(bytes.Buffer)))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgModuleNotFound, "app-x"))
+}
@@ -70,6 +70,125 @@ func (a *Module) FileDependencies() []string {
 	return a.metadata.spec.FileDependencies
 }
 
+// Image returns the container image used to execute the commands of
+// this module. Returns an empty string if commands should be executed
+// on the host.
+func (a *Module) Image() string {
+	return a.metadata.spec.Image
+}
+
+// Docker returns the image built from the Dockerfile of this module.
+// Returns nil if it is not declared.
+func (a *Module) Docker() *DockerBuild {
+	return a.metadata.spec.Docker
+}
+
+// Terraform returns the Terraform root module of this module.
+// Returns nil if it is not declared.
+func (a *Module) Terraform() *TerraformStack {
+	return a.metadata.spec.Terraform
+}
+
+// Hooks returns the lifecycle hooks declared in the spec.
+// Returns nil if the module does not declare any hooks.
+func (a *Module) Hooks() *Hooks {
+	return a.metadata.spec.Hooks
+}
+
+// Probe returns the probe checking whether the artifact of the module
+// already exists.
+func (a *Module) Probe() *Probe {
+	return a.metadata.spec.Probe
+}
+
+// Fingerprint returns the command writing the interface of the module.
+func (a *Module) Fingerprint() *Fingerprint {
+	return a.metadata.spec.Fingerprint
+}
+
+// Resources returns the resource hints declared in the spec.
+// Returns nil if the module does not declare any resources.
+func (a *Module) Resources() *Resources {
+	return a.metadata.spec.Resources
+}
+
+// SerializationKeys returns the keys the builds of the module are
+// serialized on, which are the locks in its resources and the keys in
+// serializeOn.
+func (a *Module) SerializationKeys() []string {
+	var locks []string
+	if r := a.Resources(); r != nil {
+		locks = r.Locks
+	}
+
+	keys := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, k := range append(append([]string{}, locks...), a.metadata.spec.SerializeOn...) {
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Env returns the environment variables declared in the spec.
+func (a *Module) Env() map[string]string {
+	return a.metadata.spec.Env
+}
+
+// Secrets returns the names of environment variables marked as secret
+// in the spec.
+func (a *Module) Secrets() []string {
+	return a.metadata.spec.Secrets
+}
+
+// Templates returns true if the templates in the commands of the module
+// are expanded.
+func (a *Module) Templates() bool {
+	return a.metadata.spec.Templates
+}
+
+// RequiredEnv returns the names of environment variables required by
+// the spec.
+func (a *Module) RequiredEnv() []string {
+	return a.metadata.spec.Requires
+}
+
+// Reports returns the patterns of the test report files produced by
+// the build of this module.
+func (a *Module) Reports() []string {
+	return a.metadata.spec.Reports
+}
+
+// Outputs returns the patterns of the files produced by the build of
+// this module to be published.
+func (a *Module) Outputs() []string {
+	return a.metadata.spec.Outputs
+}
+
+// Publish returns the targets the outputs of this module are
+// published to.
+func (a *Module) Publish() []*PublishTarget {
+	return a.metadata.spec.Publish
+}
+
+// Owners returns the owners (e.g. teams) of this module.
+func (a *Module) Owners() []string {
+	return a.metadata.spec.Owners
+}
+
+// Labels returns the labels of this module.
+func (a *Module) Labels() map[string]string {
+	return a.metadata.spec.Labels
+}
+
+// ExternalDependencies returns the packages outside the repository
+// declared in the spec.
+func (a *Module) ExternalDependencies() []*ExternalDependency {
+	return a.metadata.spec.ExternalDependencies
+}
+
 type requiredByNodeProvider struct{}
 
 func (p *requiredByNodeProvider) ID(vertex interface{}) interface{} {
@@ -98,6 +217,44 @@ func (p *requiresNodeProvider) Child(vertex interface{}, index int) (interface{}
 	return vertex.(*Module).Requires()[index], nil
 }
 
+// requiresInNodeProvider is a requiresNodeProvider ignoring the
+// dependencies outside a list of modules.
+type requiresInNodeProvider struct {
+	requires map[string]Modules
+}
+
+func newRequiresInNodeProvider(l Modules) *requiresInNodeProvider {
+	in := make(map[string]bool, len(l))
+	for _, a := range l {
+		in[a.Name()] = true
+	}
+
+	requires := make(map[string]Modules, len(l))
+	for _, a := range l {
+		r := Modules{}
+		for _, b := range a.Requires() {
+			if in[b.Name()] {
+				r = append(r, b)
+			}
+		}
+		requires[a.Name()] = r
+	}
+
+	return &requiresInNodeProvider{requires: requires}
+}
+
+func (p *requiresInNodeProvider) ID(vertex interface{}) interface{} {
+	return vertex.(*Module).Name()
+}
+
+func (p *requiresInNodeProvider) ChildCount(vertex interface{}) int {
+	return len(p.requires[vertex.(*Module).Name()])
+}
+
+func (p *requiresInNodeProvider) Child(vertex interface{}, index int) (interface{}, error) {
+	return p.requires[vertex.(*Module).Name()][index], nil
+}
+
 func newModule(metadata *moduleMetadata, requires Modules) *Module {
 	mod := &Module{
 		requires:   Modules{},
@@ -124,6 +281,16 @@ func (l Modules) indexByName() map[string]*Module {
 	return q
 }
 
+// indexOf returns the position of each module in the list keyed by
+// the name of the module.
+func (l Modules) indexOf() map[string]int {
+	r := make(map[string]int, len(l))
+	for i, a := range l {
+		r[a.Name()] = i
+	}
+	return r
+}
+
 func (l Modules) indexByPath() map[string]*Module {
 	q := make(map[string]*Module)
 	for _, a := range l {
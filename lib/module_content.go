@@ -0,0 +1,215 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// ModuleFile is a file of a module or one of its file dependencies.
+type ModuleFile struct {
+	// Path of the file relative to the repository root.
+	Path string `json:"path"`
+	// Hash is the git blob hash of the content.
+	Hash string `json:"hash"`
+}
+
+// moduleContent is the list of files of a module. Files are loaded on
+// the first access so that discovering the modules does not require
+// walking the entire tree. It is safe for concurrent use.
+type moduleContent struct {
+	once  sync.Once
+	load  func() ([]*ModuleFile, error)
+	files []*ModuleFile
+	err   error
+}
+
+func newModuleContent(load func() ([]*ModuleFile, error)) *moduleContent {
+	return &moduleContent{load: load}
+}
+
+func (c *moduleContent) get() ([]*ModuleFile, error) {
+	c.once.Do(func() {
+		c.files, c.err = c.load()
+	})
+	return c.files, c.err
+}
+
+// Files returns the files of the module and its file dependencies
+// sorted by path.
+// Files are loaded on the first call and shared by the subsequent
+// (including concurrent) calls. Returned list must not be modified.
+func (a *Module) Files() ([]*ModuleFile, error) {
+	if a.metadata.content == nil {
+		return nil, e.NewErrorf(ErrClassUser, msgModuleFilesUnavailable, a.Name())
+	}
+	return a.metadata.content.get()
+}
+
+// contentPaths returns the paths of the module directory and its file
+// dependencies.
+func (m *moduleMetadata) contentPaths() []string {
+	// Root module owns the entire repository.
+	if m.dir == "" {
+		return []string{""}
+	}
+	return append([]string{m.dir}, m.spec.FileDependencies...)
+}
+
+// withCommitContent sets up the modules in the set to load their files
+// from a commit tree. Modules already set up are not changed.
+func (a moduleMetadataSet) withCommitContent(repo Repo, commit Commit) moduleMetadataSet {
+	for _, m := range a {
+		if m.content == nil {
+			m.content = commitContent(repo, commit, m)
+		}
+	}
+	return a
+}
+
+func commitContent(repo Repo, commit Commit, m *moduleMetadata) *moduleContent {
+	return newModuleContent(func() ([]*ModuleFile, error) {
+		files := make(map[string]string)
+		for _, p := range m.contentPaths() {
+			err := repo.WalkBlobsInDir(commit, p, func(b Blob) error {
+				if f := b.Path() + b.Name(); !m.ignore.excludes(f) {
+					files[f] = b.ID()
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return sortedModuleFiles(files), nil
+	})
+}
+
+// withWorkspaceContent sets up the modules in the set to load their
+// files from the workspace. Modules already set up are not changed.
+func (a moduleMetadataSet) withWorkspaceContent(repo Repo) moduleMetadataSet {
+	for _, m := range a {
+		if m.content == nil {
+			m.content = workspaceContent(repo, m)
+		}
+	}
+	return a
+}
+
+// workspaceContent loads the files of a module in the workspace using
+// the same rules as git (i.e. ignored files are excluded and untracked
+// files are included). Files excluded by the module are not loaded.
+func workspaceContent(repo Repo, m *moduleMetadata) *moduleContent {
+	return newModuleContent(func() ([]*ModuleFile, error) {
+		var pathSpec []string
+		if m.dir != "" {
+			pathSpec = m.contentPaths()
+		}
+
+		paths, err := repo.FindAllFilesInWorkspace(pathSpec)
+		if err != nil {
+			return nil, err
+		}
+
+		blobs := make([]string, 0, len(paths))
+		for _, p := range paths {
+			if m.ignore.excludes(p) {
+				continue
+			}
+
+			f := filepath.Join(repo.Path(), filepath.FromSlash(p))
+			fi, err := os.Stat(f)
+			if err != nil {
+				return nil, e.Wrapf(ErrClassInternal, err, msgFailedReadFile, f)
+			}
+
+			// Nested git repositories (i.e. submodules) are listed as
+			// directories.
+			if fi.IsDir() {
+				continue
+			}
+			blobs = append(blobs, p)
+		}
+
+		hashes, err := hashAll(len(blobs), nil, func(i int) (string, error) {
+			f := filepath.Join(repo.Path(), filepath.FromSlash(blobs[i]))
+			content, err := ioutil.ReadFile(f)
+			if err != nil {
+				return "", e.Wrapf(ErrClassInternal, err, msgFailedReadFile, f)
+			}
+			return blobHash(content), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		files := make(map[string]string, len(blobs))
+		for i, p := range blobs {
+			files[p] = hashes[i]
+		}
+		return sortedModuleFiles(files), nil
+	})
+}
+
+// inSubmoduleContent returns the content of a module in the submodule
+// at dir with the paths relative to the containing repository.
+func inSubmoduleContent(c *moduleContent, dir string) *moduleContent {
+	if c == nil {
+		return nil
+	}
+
+	return newModuleContent(func() ([]*ModuleFile, error) {
+		files, err := c.get()
+		if err != nil {
+			return nil, err
+		}
+
+		r := make([]*ModuleFile, 0, len(files))
+		for _, f := range files {
+			r = append(r, &ModuleFile{Path: path.Join(dir, f.Path), Hash: f.Hash})
+		}
+		return r, nil
+	})
+}
+
+// blobHash returns the hash git assigns to a blob with the content.
+func blobHash(content []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(content))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func sortedModuleFiles(files map[string]string) []*ModuleFile {
+	r := make([]*ModuleFile, 0, len(files))
+	for p, h := range files {
+		r = append(r, &ModuleFile{Path: p, Hash: h})
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Path < r[j].Path
+	})
+	return r
+}
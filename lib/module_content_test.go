@@ -0,0 +1,130 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func moduleFilePaths(files []*ModuleFile) []string {
+	r := make([]string, 0, len(files))
+	for _, f := range files {
+		r = append(r, f.Path)
+	}
+	return r
+}
+
+func entryID(t *testing.T, w *World, p string) string {
+	c, err := w.Repo.CurrentBranchCommit()
+	check(t, err)
+	id, err := w.Repo.EntryID(c, p)
+	check(t, err)
+	return id
+}
+
+func initModuleFilesRepo(t *testing.T) *TestRepository {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", FileDependencies: []string{"shared/a.txt", "config"}}))
+	check(t, repo.WriteContent("app-a/src/main.go", "package main"))
+	check(t, repo.WriteContent("shared/a.txt", "a"))
+	check(t, repo.WriteContent("shared/b.txt", "b"))
+	check(t, repo.WriteContent("config/app.yml", "app: a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.Commit("first"))
+	return repo
+}
+
+func TestModuleFilesInCommit(t *testing.T) {
+	initModuleFilesRepo(t)
+	w := NewWorld(t, ".tmp/repo")
+	m, err := w.System.ManifestByCurrentBranch()
+	check(t, err)
+
+	mod, ok := m.Module("app-a")
+	assert.True(t, ok)
+	files, err := mod.Files()
+	check(t, err)
+
+	assert.Equal(t, []string{"app-a/.mbt.yml", "app-a/src/main.go", "config/app.yml", "shared/a.txt"}, moduleFilePaths(files))
+	assert.Equal(t, entryID(t, w, "shared/a.txt"), files[3].Hash)
+}
+
+func TestModuleFilesInWorkspace(t *testing.T) {
+	repo := initModuleFilesRepo(t)
+	w := NewWorld(t, ".tmp/repo")
+	check(t, repo.WriteContent("app-a/src/new.go", "package main"))
+	check(t, repo.WriteContent("app-a/.gitignore", "*.log\n"))
+	check(t, repo.WriteContent("app-a/debug.log", "ignored"))
+
+	m, err := w.System.ManifestByWorkspace()
+	check(t, err)
+
+	mod, _ := m.Module("app-a")
+	files, err := mod.Files()
+	check(t, err)
+
+	assert.Equal(t, []string{"app-a/.gitignore", "app-a/.mbt.yml", "app-a/src/main.go", "app-a/src/new.go", "config/app.yml", "shared/a.txt"}, moduleFilePaths(files))
+	// Hashes of unchanged files are the same as in the commit.
+	assert.Equal(t, entryID(t, w, "shared/a.txt"), files[5].Hash)
+}
+
+func TestModuleFilesAreLoadedOnce(t *testing.T) {
+	initModuleFilesRepo(t)
+	w := NewWorld(t, ".tmp/repo")
+	m, err := w.System.ManifestByCurrentBranch()
+	check(t, err)
+	mod, _ := m.Module("app-a")
+
+	repo, err := NewLibgitRepo(".tmp/repo", w.Log)
+	check(t, err)
+	var walks int32
+	w.Repo.Interceptor.Config("WalkBlobsInDir").Do(func(args ...interface{}) []interface{} {
+		atomic.AddInt32(&walks, 1)
+		return []interface{}{repo.WalkBlobsInDir(args[0].(Commit), args[1].(string), args[2].(BlobWalkCallback))}
+	})
+
+	results := make([][]*ModuleFile, 8)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			files, err := mod.Files()
+			check(t, err)
+			results[i] = files
+		}(i)
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		assert.True(t, &results[0][0] == &r[0])
+	}
+	// Module directory and two file dependencies.
+	assert.Equal(t, int32(3), walks)
+}
+
+func TestFilesOfImportedModule(t *testing.T) {
+	mod := newModule(newModuleMetadata("app-a", "a", &Spec{Name: "app-a"}, nil), nil)
+
+	_, err := mod.Files()
+
+	assert.EqualError(t, err, "Files of module app-a are not available (e.g. in an imported manifest)")
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// NotifyOnSuccess fires a notification when an invocation succeeds.
+	NotifyOnSuccess = "success"
+	// NotifyOnFailure fires a notification when an invocation fails.
+	NotifyOnFailure = "failure"
+
+	notifyTimeout = 10 * time.Second
+)
+
+// Notification is a webhook called at the end of a build or a run of
+// a user defined command.
+type Notification struct {
+	// URL of the webhook. ${VAR} references are expanded from the host
+	// environment, provided VAR is listed in hostEnv.
+	URL string `yaml:"url"`
+	// On is the list of outcomes (success, failure) the notification is
+	// fired for. Defaults to both.
+	On []string `yaml:"on,omitempty"`
+	// Owners restricts the notification to the modules owned by any of
+	// these owners. Notification is not fired if none of them were built.
+	Owners []string `yaml:"owners,omitempty"`
+	// Headers of the request. Values are expanded like URL.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Body is a go template of the request body. The template is
+	// executed with the invocation summary. Defaults to the summary
+	// in json format.
+	Body string `yaml:"body,omitempty"`
+}
+
+// summary returns the summary to be sent in the notification or nil
+// if the notification should not be fired.
+func (n *Notification) summary(s *InvocationSummary) *InvocationSummary {
+	if len(n.Owners) > 0 {
+		owners := make(map[string]bool)
+		for _, o := range n.Owners {
+			owners[o] = true
+		}
+
+		filtered := *s
+		filtered.Modules = make([]*ModuleSummary, 0)
+		failed := false
+		for _, m := range s.Modules {
+			if !moduleBuilt(m) || !ownedBy(m, owners) {
+				continue
+			}
+			filtered.Modules = append(filtered.Modules, m)
+			failed = failed || m.Status == ModuleStatusFailed
+		}
+
+		if len(filtered.Modules) == 0 {
+			return nil
+		}
+
+		// Outcome of the invocation is the outcome of the owned modules.
+		filtered.Success = !failed
+		if !failed {
+			filtered.Error = ""
+		}
+		s = &filtered
+	}
+
+	outcome := NotifyOnSuccess
+	if !s.Success {
+		outcome = NotifyOnFailure
+	}
+
+	if len(n.On) == 0 {
+		return s
+	}
+
+	for _, o := range n.On {
+		if o == outcome {
+			return s
+		}
+	}
+
+	return nil
+}
+
+func ownedBy(m *ModuleSummary, owners map[string]bool) bool {
+	for _, o := range m.Owners {
+		if owners[o] {
+			return true
+		}
+	}
+	return false
+}
+
+// body creates the request body of the notification.
+func (n *Notification) body(s *InvocationSummary) ([]byte, error) {
+	if n.Body == "" {
+		return json.Marshal(s)
+	}
+
+	t, err := template.New("notification").
+		Option("missingkey=error").
+		Funcs(template.FuncMap{
+			"json": func(v interface{}) (string, error) {
+				buff, err := json.Marshal(v)
+				return string(buff), err
+			},
+		}).
+		Parse(n.Body)
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedNotificationTemplate)
+	}
+
+	buff := new(bytes.Buffer)
+	err = t.Execute(buff, s)
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedNotificationTemplate)
+	}
+
+	return buff.Bytes(), nil
+}
+
+// notify sends the notifications applicable to an invocation.
+// Like metrics, failure to send a notification does not fail the
+// invocation, therefore errors are logged as warnings.
+func (s *stdSystem) notify(config *RepoConfig, summary *InvocationSummary) {
+	allowed := config.allowedHostEnv()
+	for _, n := range config.Notifications {
+		if err := n.send(allowed, summary); err != nil {
+			// Only the host is logged since webhook urls often
+			// contain tokens.
+			host := "<invalid url>"
+			if u, perr := url.Parse(n.URL); perr == nil {
+				host = u.Host
+			}
+			s.Log.Warnf(msgFailedNotify, host, err)
+		}
+	}
+}
+
+func (n *Notification) send(allowed map[string]bool, summary *InvocationSummary) error {
+	summary = n.summary(summary)
+	if summary == nil {
+		return nil
+	}
+
+	body, err := n.body(summary)
+	if err != nil {
+		return err
+	}
+
+	u, notAllowed := expandHostEnv(allowed, n.URL)
+	if notAllowed != "" {
+		return e.NewErrorf(ErrClassUser, msgHostEnvNotAllowedInNotification, notAllowed)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.Headers {
+		v, notAllowed := expandHostEnv(allowed, v)
+		if notAllowed != "" {
+			return e.NewErrorf(ErrClassUser, msgHostEnvNotAllowedInNotification, notAllowed)
+		}
+		req.Header.Set(k, v)
+	}
+
+	res, err := (&http.Client{Timeout: notifyTimeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected response %s", res.Status)
+	}
+
+	return nil
+}
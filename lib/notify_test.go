@@ -0,0 +1,143 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type webhookRequest struct {
+	path   string
+	header http.Header
+	body   []byte
+}
+
+func startWebhook(t *testing.T) (*httptest.Server, *[]*webhookRequest) {
+	requests := make([]*webhookRequest, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		check(t, err)
+		requests = append(requests, &webhookRequest{path: r.URL.Path, header: r.Header, body: b})
+	}))
+	return server, &requests
+}
+
+func initNotifyRepo(t *testing.T, notifications []*Notification) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{Notifications: notifications, HostEnv: []string{"MBT_TEST_HOOK_TOKEN"}}))
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:   "app-a",
+		Build:  map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Owners: []string{"team-a"},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "true"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:   "app-b",
+		Build:  map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Owners: []string{"team-b"},
+	}))
+	check(t, repo.WriteShellScript("app-b/build.sh", "exit 1"))
+	check(t, repo.Commit("first"))
+}
+
+func TestNotificationWithSummary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	server, requests := startWebhook(t)
+	defer server.Close()
+
+	os.Setenv("MBT_TEST_HOOK_TOKEN", "t0ken")
+	defer os.Unsetenv("MBT_TEST_HOOK_TOKEN")
+
+	initNotifyRepo(t, []*Notification{
+		{URL: server.URL + "/all/${MBT_TEST_HOOK_TOKEN}", Headers: map[string]string{"Authorization": "Bearer ${MBT_TEST_HOOK_TOKEN}"}},
+		{URL: server.URL + "/success", On: []string{NotifyOnSuccess}},
+	})
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+	assert.Error(t, err)
+
+	assert.Len(t, *requests, 1)
+	r := (*requests)[0]
+	assert.Equal(t, "/all/t0ken", r.path)
+	assert.Equal(t, "Bearer t0ken", r.header.Get("Authorization"))
+	assert.Equal(t, "application/json", r.header.Get("Content-Type"))
+
+	summary := &InvocationSummary{}
+	check(t, json.Unmarshal(r.body, summary))
+	assert.False(t, summary.Success)
+	assert.Len(t, summary.Modules, 2)
+	assert.Equal(t, []string{"team-a"}, summary.Modules[0].Owners)
+}
+
+func TestNotificationForOwners(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	server, requests := startWebhook(t)
+	defer server.Close()
+
+	body := `{"text": "{{.Command}} {{if .Success}}passed{{else}}failed{{end}}:{{range .Modules}} {{.Name}}{{end}}", "error": {{json .Error}}}`
+	initNotifyRepo(t, []*Notification{
+		{URL: server.URL + "/a", Owners: []string{"team-a"}, Body: body},
+		{URL: server.URL + "/b", Owners: []string{"team-b"}, Body: body, On: []string{NotifyOnFailure}},
+		{URL: server.URL + "/c", Owners: []string{"team-c"}, Body: body},
+	})
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+	assert.Error(t, err)
+
+	assert.Len(t, *requests, 2)
+	assert.Equal(t, "/a", (*requests)[0].path)
+	assert.Equal(t, `{"text": "build passed: app-a", "error": ""}`, string((*requests)[0].body))
+	assert.Equal(t, "/b", (*requests)[1].path)
+	assert.Regexp(t, `^\{"text": "build failed: app-b", "error": ".+"\}$`, string((*requests)[1].body))
+}
+
+func TestNotificationFailuresAreIgnored(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	server, requests := startWebhook(t)
+	defer server.Close()
+
+	initNotifyRepo(t, []*Notification{
+		{URL: server.URL + "/${HOME}"},
+		{URL: server.URL + "/template", Body: "{{.Foo}}"},
+		{URL: server.URL + "/ok"},
+	})
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+	assert.EqualError(t, err, "Failed to build module 'app-b'")
+
+	assert.Len(t, *requests, 1)
+	assert.Equal(t, "/ok", (*requests)[0].path)
+}
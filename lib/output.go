@@ -0,0 +1,85 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// withModuleOutput returns a copy of options with the output streams
+// set up for executing the commands of a module.
+// When options.Events is specified, output is delivered as events.
+// When options.LogDir is specified, output is also written to the log
+// file of the module.
+// Returned function must be invoked to release the resources once the
+// commands are executed.
+func withModuleOutput(options *CmdOptions, mod *Module) (*CmdOptions, func(), error) {
+	if options.Events == nil && options.LogDir == "" {
+		return options, func() {}, nil
+	}
+
+	o := *options
+	if options.Events != nil {
+		o.Stdout = &eventWriter{handler: options.Events, module: mod, stream: "stdout"}
+		o.Stderr = &eventWriter{handler: options.Events, module: mod, stream: "stderr"}
+	}
+
+	if options.LogDir == "" {
+		return &o, func() {}, nil
+	}
+
+	f, err := openModuleLog(options.LogDir, mod)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	o.Stdout = teeWriter(o.Stdout, f)
+	o.Stderr = teeWriter(o.Stderr, f)
+	return &o, func() { f.Close() }, nil
+}
+
+func openModuleLog(dir string, mod *Module) (*os.File, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedOpenModuleLog, mod.Name())
+	}
+
+	f, err := os.Create(moduleLogPath(dir, mod.Name()))
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedOpenModuleLog, mod.Name())
+	}
+
+	return f, nil
+}
+
+// moduleLogPath returns the path of the log file of a module in dir.
+func moduleLogPath(dir, name string) string {
+	return filepath.Join(dir, unsafeFileNameChars.ReplaceAllString(name, "_")+".log")
+}
+
+func teeWriter(w io.Writer, f io.Writer) io.Writer {
+	if w == nil {
+		return f
+	}
+	return io.MultiWriter(w, f)
+}
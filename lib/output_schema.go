@@ -0,0 +1,90 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"embed"
+	"fmt"
+	"sort"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// InvocationSummarySchemaVersion is the version of the schema of
+	// InvocationSummary. It is incremented for changes that are not
+	// backwards compatible.
+	InvocationSummarySchemaVersion = 1
+	// PlanSchemaVersion is the version of the schema of the build plan
+	// formatted as json. It is incremented for changes that are not
+	// backwards compatible.
+	PlanSchemaVersion = 1
+	// SpecSchemaVersion is the version of the schema of module specs
+	// (.mbt.yml).
+	SpecSchemaVersion = 1
+	// ConfigSchemaVersion is the version of the schema of the
+	// repository configuration (.mbt/config.yml).
+	ConfigSchemaVersion = 1
+)
+
+//go:embed schemas
+var embeddedSchemas embed.FS
+
+// OutputSchema describes the JSON Schema of a structured output of mbt,
+// or of a file read by mbt.
+type OutputSchema struct {
+	Name string `json:"name"`
+	// Output is the command or the flag producing the output, or the
+	// file described.
+	Output  string `json:"output"`
+	Version int    `json:"version"`
+}
+
+var outputSchemas = map[string]*OutputSchema{
+	"config":   {Name: "config", Output: ".mbt/config.yml", Version: ConfigSchemaVersion},
+	"describe": {Name: "describe", Output: "mbt describe --format json", Version: DescriptionSchemaVersion},
+	"export":   {Name: "export", Output: "mbt describe export", Version: ManifestSchemaVersion},
+	"plan":     {Name: "plan", Output: "mbt build --plan --json", Version: PlanSchemaVersion},
+	"spec":     {Name: "spec", Output: ".mbt.yml", Version: SpecSchemaVersion},
+	"summary":  {Name: "summary", Output: "--summary-file", Version: InvocationSummarySchemaVersion},
+}
+
+// OutputSchemas returns the schemas embedded in the binary sorted by
+// name.
+func OutputSchemas() []*OutputSchema {
+	schemas := make([]*OutputSchema, 0, len(outputSchemas))
+	for _, s := range outputSchemas {
+		schemas = append(schemas, s)
+	}
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].Name < schemas[j].Name })
+	return schemas
+}
+
+// OutputSchemaContent returns the JSON Schema of the output with the
+// specified name (see OutputSchemas). Schema of each version is a
+// separate file so that the schema of a released version never changes.
+func OutputSchemaContent(name string) ([]byte, error) {
+	s, ok := outputSchemas[name]
+	if !ok {
+		return nil, e.NewErrorf(ErrClassUser, msgUnknownSchema, name)
+	}
+
+	content, err := embeddedSchemas.ReadFile(fmt.Sprintf("schemas/%s.v%d.json", s.Name, s.Version))
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+	return content, nil
+}
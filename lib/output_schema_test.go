@@ -0,0 +1,145 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testSchema struct {
+	ID          string                 `json:"$id"`
+	Properties  map[string]interface{} `json:"properties"`
+	Required    []string               `json:"required"`
+	Definitions map[string]*testSchema `json:"definitions"`
+}
+
+func readTestSchema(t *testing.T, name string) *testSchema {
+	content, err := OutputSchemaContent(name)
+	check(t, err)
+
+	s := &testSchema{}
+	check(t, json.Unmarshal(content, s))
+	return s
+}
+
+// assertSchemaOf asserts that the properties of the schema are the json
+// fields of v and the fields without omitempty are required.
+func assertSchemaOf(t *testing.T, s *testSchema, v interface{}) {
+	properties, required := make([]string, 0), make([]string, 0)
+	typ := reflect.TypeOf(v)
+	for i := 0; i < typ.NumField(); i++ {
+		tag := strings.Split(typ.Field(i).Tag.Get("json"), ",")
+		properties = append(properties, tag[0])
+		if len(tag) == 1 {
+			required = append(required, tag[0])
+		}
+	}
+
+	actual := make([]string, 0, len(s.Properties))
+	for p := range s.Properties {
+		actual = append(actual, p)
+	}
+	sort.Strings(properties)
+	sort.Strings(actual)
+	sort.Strings(required)
+	sort.Strings(s.Required)
+
+	assert.Equal(t, properties, actual, typ.Name())
+	assert.Equal(t, required, s.Required, typ.Name())
+}
+
+func TestOutputSchemas(t *testing.T) {
+	for _, s := range OutputSchemas() {
+		schema := readTestSchema(t, s.Name)
+		assert.Equal(t, fmt.Sprintf("https://github.com/mbtproject/mbt/schemas/%s.v%d.json", s.Name, s.Version), schema.ID)
+	}
+
+	assert.Equal(t, []string{"config", "describe", "export", "plan", "spec", "summary"}, func() []string {
+		names := make([]string, 0)
+		for _, s := range OutputSchemas() {
+			names = append(names, s.Name)
+		}
+		return names
+	}())
+}
+
+func TestSchemasMatchOutputs(t *testing.T) {
+	describe := readTestSchema(t, "describe")
+	assertSchemaOf(t, describe, Description{})
+	assertSchemaOf(t, describe.Definitions["module"], ModuleDescription{})
+
+	export := readTestSchema(t, "export")
+	assertSchemaOf(t, export, exportedManifest{})
+	assertSchemaOf(t, export.Definitions["module"], exportedModule{})
+
+	summary := readTestSchema(t, "summary")
+	assertSchemaOf(t, summary, InvocationSummary{})
+	assertSchemaOf(t, summary.Definitions["module"], ModuleSummary{})
+	assertSchemaOf(t, summary.Definitions["artifact"], Artifact{})
+	assertSchemaOf(t, summary.Definitions["diagnostic"], Diagnostic{})
+	assertSchemaOf(t, summary.Definitions["testReport"], TestReport{})
+}
+
+// assertYAMLSchemaOf asserts that the properties of the schema are the
+// yaml fields of v.
+func assertYAMLSchemaOf(t *testing.T, s *testSchema, v interface{}) {
+	properties := make([]string, 0)
+	typ := reflect.TypeOf(v)
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("yaml"), ",")[0]
+		if name == "" {
+			name = strings.ToLower(typ.Field(i).Name)
+		}
+		properties = append(properties, name)
+	}
+
+	actual := make([]string, 0, len(s.Properties))
+	for p := range s.Properties {
+		actual = append(actual, p)
+	}
+	sort.Strings(properties)
+	sort.Strings(actual)
+
+	assert.Equal(t, properties, actual, typ.Name())
+}
+
+func TestSchemasMatchFiles(t *testing.T) {
+	spec := readTestSchema(t, "spec")
+	assertYAMLSchemaOf(t, spec, Spec{})
+	assertYAMLSchemaOf(t, spec.Definitions["cmd"], Cmd{})
+	assertYAMLSchemaOf(t, spec.Definitions["userCmd"], UserCmd{})
+	assertYAMLSchemaOf(t, spec.Definitions["hooks"], Hooks{})
+	assert.Equal(t, []string{"name"}, spec.Required)
+
+	config := readTestSchema(t, "config")
+	assertYAMLSchemaOf(t, config, RepoConfig{})
+	assertYAMLSchemaOf(t, config.Definitions["notification"], Notification{})
+	assertYAMLSchemaOf(t, config.Definitions["eventStream"], EventStream{})
+	assertYAMLSchemaOf(t, config.Definitions["templateSource"], TemplateSource{})
+}
+
+func TestUnknownSchema(t *testing.T) {
+	_, err := OutputSchemaContent("foo")
+
+	assert.EqualError(t, err, fmt.Sprintf(msgUnknownSchema, "foo"))
+}
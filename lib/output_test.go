@@ -0,0 +1,120 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildLogDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo a"))
+	check(t, repo.InitModuleWithOptions("app/b", &Spec{
+		Name:  "app/b",
+		Build: map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+	}))
+	check(t, repo.WriteShellScript("app/b/build.sh", "echo b"))
+	check(t, repo.Commit("first"))
+
+	logDir := filepath.Join(".tmp", "logs")
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.LogDir = logDir
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "a\nb\n", buff.String())
+
+	c, err := ioutil.ReadFile(filepath.Join(logDir, "app-a.log"))
+	check(t, err)
+	assert.Equal(t, "a\n", string(c))
+
+	c, err = ioutil.ReadFile(filepath.Join(logDir, "app_b.log"))
+	check(t, err)
+	assert.Equal(t, "b\n", string(c))
+}
+
+func TestBuildLogDirWithEvents(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo a"))
+	check(t, repo.Commit("first"))
+
+	logDir := filepath.Join(".tmp", "logs")
+	recorder := &eventRecorder{}
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.LogDir = logDir
+	options.Events = recorder.handle
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "a\n", recorder.output("stdout"))
+
+	c, err := ioutil.ReadFile(filepath.Join(logDir, "app-a.log"))
+	check(t, err)
+	assert.Equal(t, "a\n", string(c))
+}
+
+func TestRunInLogDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:     "app-a",
+		Commands: map[string]*UserCmd{"echo": {Cmd: "./echo.sh"}},
+	}))
+	check(t, repo.WriteShellScript("app-a/echo.sh", "echo hello"))
+	check(t, repo.Commit("first"))
+
+	logDir := filepath.Join(".tmp", "logs")
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.LogDir = logDir
+	_, err := NewWorld(t, ".tmp/repo").System.RunInCurrentBranch("echo", NoFilter, options)
+	check(t, err)
+
+	c, err := ioutil.ReadFile(filepath.Join(logDir, "app-a.log"))
+	check(t, err)
+	assert.Equal(t, "hello\n", string(c))
+}
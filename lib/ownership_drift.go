@@ -0,0 +1,203 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// DefaultMinOwnershipShare is the fraction of the commits changing a
+// module an author must have to be a primary contributor of the module.
+const DefaultMinOwnershipShare = 0.25
+
+// OwnershipOptions specifies the range of history compared with the
+// owners of modules.
+type OwnershipOptions struct {
+	// From excludes the history reachable from this revision.
+	// Entire history is analysed if it is empty.
+	From string
+	// To is the last revision analysed. Defaults to the current branch.
+	To string
+	// MinShare is the fraction of the commits changing a module an
+	// author must have to be a primary contributor of the module.
+	// Defaults to DefaultMinOwnershipShare.
+	MinShare float64
+}
+
+// OwnershipReport compares the owners of modules with the authors of
+// the commits changing them.
+type OwnershipReport struct {
+	From     string             `json:"from,omitempty"`
+	To       string             `json:"to"`
+	Commits  int                `json:"commits"`
+	MinShare float64            `json:"minShare"`
+	Modules  []*ModuleOwnership `json:"modules"`
+}
+
+// ModuleOwnership contains the owners and the primary contributors of
+// a module. Drifted is set when any primary contributor is not an
+// owner of the module.
+type ModuleOwnership struct {
+	Name         string         `json:"name"`
+	Path         string         `json:"path"`
+	Owners       []string       `json:"owners"`
+	Commits      int            `json:"commits"`
+	Contributors []*Contributor `json:"contributors"`
+	Drifted      bool           `json:"drifted"`
+}
+
+// Contributor is a primary contributor of a module.
+// Share is the fraction of the commits changing the module authored by
+// the contributor and Owner is the owner the contributor is recognised
+// as, if any.
+type Contributor struct {
+	Name    string  `json:"name"`
+	Email   string  `json:"email"`
+	Commits int     `json:"commits"`
+	Share   float64 `json:"share"`
+	Owner   string  `json:"owner,omitempty"`
+}
+
+func (s *stdSystem) Ownership(options *OwnershipOptions) (*OwnershipReport, error) {
+	minShare := options.MinShare
+	if minShare == 0 {
+		minShare = DefaultMinOwnershipShare
+	}
+	if minShare < 0 || minShare > 1 {
+		return nil, e.NewErrorf(ErrClassUser, msgInvalidOwnershipShare, minShare)
+	}
+
+	config, err := loadRepoConfig(s.Repo.Path())
+	if err != nil {
+		return nil, err
+	}
+
+	analysis, mods, err := s.analyseHistory(&HistoryOptions{From: options.From, To: options.To})
+	if err != nil {
+		return nil, err
+	}
+
+	churn := make(map[string]*ModuleChurn, len(analysis.Modules))
+	for _, c := range analysis.Modules {
+		churn[c.Name] = c
+	}
+
+	report := &OwnershipReport{
+		From:     analysis.From,
+		To:       analysis.To,
+		Commits:  analysis.Commits,
+		MinShare: minShare,
+		Modules:  make([]*ModuleOwnership, 0, len(mods)),
+	}
+
+	for _, m := range mods {
+		c := churn[m.Name()]
+		owners := m.Owners()
+		if owners == nil {
+			owners = []string{}
+		}
+
+		o := &ModuleOwnership{
+			Name:         m.Name(),
+			Path:         m.Path(),
+			Owners:       owners,
+			Commits:      c.Commits,
+			Contributors: make([]*Contributor, 0),
+		}
+
+		// Authors are sorted by the number of commits.
+		for _, a := range c.Authors {
+			share := float64(a.Commits) / float64(c.Commits)
+			if share < minShare {
+				break
+			}
+
+			contributor := &Contributor{
+				Name:    a.Name,
+				Email:   a.Email,
+				Commits: a.Commits,
+				Share:   share,
+				Owner:   ownerOf(a, owners, config.Teams),
+			}
+			o.Contributors = append(o.Contributors, contributor)
+			o.Drifted = o.Drifted || contributor.Owner == ""
+		}
+
+		report.Modules = append(report.Modules, o)
+	}
+
+	sort.SliceStable(report.Modules, func(i, j int) bool {
+		return report.Modules[i].Name < report.Modules[j].Name
+	})
+
+	return report, nil
+}
+
+// ownerOf returns the owner of a module an author is recognised as.
+// An author is recognised as an owner which is the name or the email
+// of the author, or a team the author is a member of. Names and emails
+// are compared ignoring the case and a leading @ (e.g. @alice).
+func ownerOf(author *AuthorChurn, owners []string, teams map[string][]string) string {
+	is := func(identity string) bool {
+		identity = strings.TrimPrefix(identity, "@")
+		return identity != "" && (strings.EqualFold(identity, author.Name) || strings.EqualFold(identity, author.Email))
+	}
+
+	for _, owner := range owners {
+		if is(owner) {
+			return owner
+		}
+
+		for _, member := range teams[owner] {
+			if is(member) {
+				return owner
+			}
+		}
+	}
+
+	return ""
+}
+
+// Drifted returns the modules with primary contributors not listed as
+// owners.
+func (r *OwnershipReport) Drifted() []*ModuleOwnership {
+	drifted := make([]*ModuleOwnership, 0)
+	for _, m := range r.Modules {
+		if m.Drifted {
+			drifted = append(drifted, m)
+		}
+	}
+	return drifted
+}
+
+// Write writes the report as json.
+func (r *OwnershipReport) Write(w io.Writer) error {
+	buff, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	if _, err := w.Write(append(buff, '\n')); err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func ownershipTestRepo(t *testing.T) *TestRepository {
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Owners: []string{"team-a"}}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Owners: []string{"@Bob"}}))
+	check(t, repo.InitModule("app-c"))
+	check(t, repo.Commit("first"))
+
+	for i := 0; i < 3; i++ {
+		check(t, repo.WriteContent("app-a/foo", fmt.Sprint(i)))
+		check(t, repo.WriteContent("app-b/foo", fmt.Sprint(i)))
+		check(t, repo.CommitAs(fmt.Sprintf("change %v", i), "bob", "bob@example.com"))
+	}
+
+	check(t, repo.WriteContent("app-a/bar", "a"))
+	check(t, repo.WriteContent("app-c/bar", "a"))
+	check(t, repo.Commit("change a"))
+	return repo
+}
+
+func TestOwnership(t *testing.T) {
+	clean()
+	repo := ownershipTestRepo(t)
+	check(t, repo.WriteContent(".mbt/config.yml", "teams:\n  team-a: [alice@wonderland.com]\n"))
+
+	report, err := NewWorld(t, ".tmp/repo").System.Ownership(&OwnershipOptions{})
+	check(t, err)
+
+	assert.Equal(t, repo.LastCommit.String(), report.To)
+	assert.Equal(t, 4, report.Commits)
+	assert.Equal(t, DefaultMinOwnershipShare, report.MinShare)
+	assert.Len(t, report.Modules, 3)
+
+	a := report.Modules[0]
+	assert.Equal(t, "app-a", a.Name)
+	assert.Equal(t, 4, a.Commits)
+	assert.True(t, a.Drifted)
+	assert.Len(t, a.Contributors, 2)
+	assert.Equal(t, "bob", a.Contributors[0].Name)
+	assert.Equal(t, 0.75, a.Contributors[0].Share)
+	assert.Equal(t, "", a.Contributors[0].Owner)
+	assert.Equal(t, "alice", a.Contributors[1].Name)
+	assert.Equal(t, "team-a", a.Contributors[1].Owner)
+
+	b := report.Modules[1]
+	assert.Equal(t, "app-b", b.Name)
+	assert.False(t, b.Drifted)
+	assert.Len(t, b.Contributors, 1)
+	assert.Equal(t, "@Bob", b.Contributors[0].Owner)
+
+	c := report.Modules[2]
+	assert.Equal(t, "app-c", c.Name)
+	assert.Equal(t, []string{}, c.Owners)
+	assert.True(t, c.Drifted)
+
+	assert.Equal(t, []string{"app-a", "app-c"}, func() []string {
+		names := make([]string, 0)
+		for _, m := range report.Drifted() {
+			names = append(names, m.Name)
+		}
+		return names
+	}())
+}
+
+func TestOwnershipWithMinShare(t *testing.T) {
+	clean()
+	ownershipTestRepo(t)
+
+	report, err := NewWorld(t, ".tmp/repo").System.Ownership(&OwnershipOptions{MinShare: 0.5})
+	check(t, err)
+
+	a := report.Modules[0]
+	assert.Len(t, a.Contributors, 1)
+	assert.Equal(t, "bob", a.Contributors[0].Name)
+}
+
+func TestOwnershipWithInvalidMinShare(t *testing.T) {
+	clean()
+	ownershipTestRepo(t)
+
+	_, err := NewWorld(t, ".tmp/repo").System.Ownership(&OwnershipOptions{MinShare: 1.5})
+
+	assert.EqualError(t, err, fmt.Sprintf(msgInvalidOwnershipShare, 1.5))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestWriteOwnershipReport(t *testing.T) {
+	clean()
+	ownershipTestRepo(t)
+
+	report, err := NewWorld(t, ".tmp/repo").System.Ownership(&OwnershipOptions{})
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	check(t, report.Write(buff))
+
+	decoded := &OwnershipReport{}
+	check(t, json.Unmarshal(buff.Bytes(), decoded))
+	assert.Equal(t, report.To, decoded.To)
+	assert.Len(t, decoded.Modules, 3)
+	assert.True(t, decoded.Modules[0].Drifted)
+	assert.Contains(t, buff.String(), `"drifted": true`)
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// partial is a template in the partials directory. It is available to
+// other templates by its path relative to that directory
+// (e.g. {{template "labels.tmpl" .}}) along with the templates it defines.
+type partial struct {
+	name    string
+	content []byte
+}
+
+func (config *RepoConfig) partialsDir() string {
+	if config.Partials == "" {
+		return defaultPartialsDir
+	}
+	return strings.Trim(filepath.ToSlash(config.Partials), "/")
+}
+
+// partialsInCommit reads the configuration and the partials in a commit
+// tree. Configuration is read from the same tree, so that the partials
+// directory is resolved consistently with the templates in that commit.
+func (s *stdSystem) partialsInCommit(commit Commit) (*RepoConfig, []*partial, error) {
+	blobs := make(map[string]Blob)
+	err := s.Repo.WalkBlobs(commit, func(b Blob) error {
+		blobs[b.Path()+b.Name()] = b
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := &RepoConfig{}
+	configPath := path.Join(configDir, configFile)
+	if b, ok := blobs[configPath]; ok {
+		buff, err := s.Repo.BlobContents(b)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		config, err = parseRepoConfig(buff, configPath)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	prefix := config.partialsDir() + "/"
+	partials := make([]*partial, 0)
+	for p, b := range blobs {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+
+		buff, err := s.Repo.BlobContents(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		partials = append(partials, &partial{name: strings.TrimPrefix(p, prefix), content: buff})
+	}
+
+	// Partials are parsed in a stable order since a template defined
+	// in multiple partials is overwritten by the last one.
+	sort.Slice(partials, func(i, j int) bool {
+		return partials[i].name < partials[j].name
+	})
+
+	return config, partials, nil
+}
+
+// partialsInWorkspace reads the configuration and the partials in the
+// workspace at dir.
+func partialsInWorkspace(dir string) (*RepoConfig, []*partial, error) {
+	config, err := loadRepoConfig(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	partials, err := partialsInDir(filepath.Join(dir, filepath.FromSlash(config.partialsDir())))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return config, partials, nil
+}
+
+// partialsInDir reads the partials in root. There are no partials if
+// root does not exist.
+func partialsInDir(root string) ([]*partial, error) {
+	partials := make([]*partial, 0)
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		buff, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		partials = append(partials, &partial{name: filepath.ToSlash(rel), content: buff})
+		return nil
+	})
+	if err != nil {
+		return nil, e.Wrapf(ErrClassInternal, err, msgFailedReadPartials, root)
+	}
+
+	return partials, nil
+}
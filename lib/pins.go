@@ -0,0 +1,222 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const pinLockFile = "pins.lock"
+
+// PinLock records the modules pinned to fixed versions. It is written
+// by mbt pin and mbt unpin to .mbt/pins.lock.
+// Version of a pinned module does not change when its content or its
+// dependencies change, hence the versions of its dependents do not
+// change either.
+type PinLock struct {
+	Modules []*PinnedModule `json:"modules"`
+}
+
+// PinnedModule is a module pinned in the lock file.
+type PinnedModule struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Reason explains why the module is pinned (e.g. an incident
+	// freeze).
+	Reason string `json:"reason,omitempty"`
+}
+
+// PinOptions represents the options of pinning a module.
+type PinOptions struct {
+	// Version the module is pinned to. Defaults to the version of
+	// the module in the head commit.
+	Version string
+	Reason  string
+}
+
+// parsePinLock parses the contents of a lock file. Missing lock file
+// (i.e. nil contents) is an empty lock.
+func parsePinLock(contents []byte, file string) (*PinLock, error) {
+	lock := &PinLock{Modules: []*PinnedModule{}}
+	if contents == nil {
+		return lock, nil
+	}
+
+	if err := json.Unmarshal(contents, lock); err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgInvalidPinLock, file)
+	}
+	return lock, nil
+}
+
+// pinsInCommit reads the lock file in a commit tree.
+func pinsInCommit(repo Repo, commit Commit) (*PinLock, error) {
+	p := path.Join(configDir, pinLockFile)
+	if _, err := repo.EntryID(commit, p); err != nil {
+		// Lock file is optional.
+		return parsePinLock(nil, p)
+	}
+
+	contents, err := repo.BlobContentsFromTree(commit, p)
+	if err != nil {
+		return nil, err
+	}
+	return parsePinLock(contents, p)
+}
+
+// pinsInWorkspace reads the lock file in the workspace of the
+// repository at dir.
+func pinsInWorkspace(dir string) (*PinLock, error) {
+	p := filepath.Join(dir, configDir, pinLockFile)
+	contents, err := ioutil.ReadFile(p)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, e.Wrapf(ErrClassInternal, err, msgFailedReadFile, p)
+		}
+		contents = nil
+	}
+	return parsePinLock(contents, p)
+}
+
+func (lock *PinLock) find(name string) (int, *PinnedModule) {
+	for i, p := range lock.Modules {
+		if p.Name == name {
+			return i, p
+		}
+	}
+	return -1, nil
+}
+
+func (lock *PinLock) write(dir string) error {
+	sort.Slice(lock.Modules, func(i, j int) bool {
+		return lock.Modules[i].Name < lock.Modules[j].Name
+	})
+
+	buff, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	err = os.MkdirAll(filepath.Join(dir, configDir), 0755)
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, configDir, pinLockFile), append(buff, '\n'), 0644)
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+	return nil
+}
+
+// withPins sets the versions of the modules in the set pinned in the
+// lock. Pins of the modules not in the set are ignored.
+func (a moduleMetadataSet) withPins(lock *PinLock) moduleMetadataSet {
+	for _, m := range a {
+		if _, p := lock.find(m.spec.Name); p != nil {
+			m.pinnedVersion = p.Version
+		}
+	}
+	return a
+}
+
+// Pinned returns true if the version of the module is fixed in the pin
+// lock file.
+func (a *Module) Pinned() bool {
+	return a.metadata.pinnedVersion != ""
+}
+
+func (s *stdSystem) Pins() (*PinLock, error) {
+	dir, err := filepath.Abs(s.Repo.Path())
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+	return pinsInWorkspace(dir)
+}
+
+func (s *stdSystem) Pin(module string, options *PinOptions) (*PinnedModule, error) {
+	if options == nil {
+		options = &PinOptions{}
+	}
+
+	dir, err := filepath.Abs(s.Repo.Path())
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	lock, err := pinsInWorkspace(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	version := options.Version
+	_, pinned := lock.find(module)
+	if version == "" && pinned != nil {
+		// Pinning a pinned module again keeps its version.
+		version = pinned.Version
+	}
+
+	if version == "" {
+		m, err := s.ManifestByCurrentBranch()
+		if err != nil {
+			return nil, err
+		}
+
+		mod, ok := m.Module(module)
+		if !ok {
+			return nil, e.NewErrorf(ErrClassUser, msgModuleNotFound, module)
+		}
+		version = mod.Version()
+	}
+
+	if pinned == nil {
+		pinned = &PinnedModule{Name: module}
+		lock.Modules = append(lock.Modules, pinned)
+	}
+	pinned.Version = version
+	pinned.Reason = options.Reason
+
+	if err := lock.write(dir); err != nil {
+		return nil, err
+	}
+	return pinned, nil
+}
+
+func (s *stdSystem) Unpin(module string) error {
+	dir, err := filepath.Abs(s.Repo.Path())
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	lock, err := pinsInWorkspace(dir)
+	if err != nil {
+		return err
+	}
+
+	i, pinned := lock.find(module)
+	if pinned == nil {
+		return e.NewErrorf(ErrClassUser, msgModuleNotPinned, module)
+	}
+
+	lock.Modules = append(lock.Modules[:i], lock.Modules[i+1:]...)
+	return lock.write(dir)
+}
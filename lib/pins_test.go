@@ -0,0 +1,133 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func initPinRepo(t *testing.T) *TestRepository {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Dependencies: []string{"app-a"}}))
+	check(t, repo.Commit("first"))
+	return repo
+}
+
+func TestPinnedModuleVersion(t *testing.T) {
+	repo := initPinRepo(t)
+	w := NewWorld(t, ".tmp/repo")
+	m1, err := w.System.ManifestByCurrentBranch()
+	check(t, err)
+
+	pinned, err := w.System.Pin("app-a", &PinOptions{Reason: "freeze"})
+	check(t, err)
+	assert.Equal(t, &PinnedModule{Name: "app-a", Version: m1.Modules.indexByName()["app-a"].Version(), Reason: "freeze"}, pinned)
+
+	check(t, repo.WriteContent("app-a/foo", "a"))
+	check(t, repo.Commit("second"))
+
+	m2, err := w.System.ManifestByCurrentBranch()
+	check(t, err)
+	a := m2.Modules.indexByName()["app-a"]
+	assert.True(t, a.Pinned())
+	assert.Equal(t, m1.Modules.indexByName()["app-a"].Version(), a.Version())
+	assert.Equal(t, m1.Modules.indexByName()["app-b"].Version(), m2.Modules.indexByName()["app-b"].Version())
+
+	check(t, w.System.Unpin("app-a"))
+	check(t, repo.Commit("third"))
+
+	m3, err := w.System.ManifestByCurrentBranch()
+	check(t, err)
+	assert.False(t, m3.Modules.indexByName()["app-a"].Pinned())
+	assert.NotEqual(t, m1.Modules.indexByName()["app-a"].Version(), m3.Modules.indexByName()["app-a"].Version())
+	assert.NotEqual(t, m1.Modules.indexByName()["app-b"].Version(), m3.Modules.indexByName()["app-b"].Version())
+}
+
+func TestPinnedModuleInWorkspace(t *testing.T) {
+	initPinRepo(t)
+	w := NewWorld(t, ".tmp/repo")
+	_, err := w.System.Pin("app-a", &PinOptions{Version: "v1"})
+	check(t, err)
+
+	m, err := w.System.ManifestByWorkspace()
+	check(t, err)
+	assert.Equal(t, "v1", m.Modules.indexByName()["app-a"].Version())
+	assert.Equal(t, "local", m.Modules.indexByName()["app-b"].Version())
+}
+
+func TestPinningPinnedModule(t *testing.T) {
+	initPinRepo(t)
+	w := NewWorld(t, ".tmp/repo")
+	_, err := w.System.Pin("app-b", &PinOptions{Version: "v1"})
+	check(t, err)
+	_, err = w.System.Pin("app-a", nil)
+	check(t, err)
+
+	pinned, err := w.System.Pin("app-b", &PinOptions{Reason: "migration"})
+	check(t, err)
+	assert.Equal(t, "v1", pinned.Version)
+
+	lock, err := w.System.Pins()
+	check(t, err)
+	assert.Len(t, lock.Modules, 2)
+	assert.Equal(t, "app-a", lock.Modules[0].Name)
+	assert.Equal(t, &PinnedModule{Name: "app-b", Version: "v1", Reason: "migration"}, lock.Modules[1])
+}
+
+func TestPinningUnknownModule(t *testing.T) {
+	initPinRepo(t)
+	_, err := NewWorld(t, ".tmp/repo").System.Pin("app-c", nil)
+
+	assert.EqualError(t, err, fmt.Sprintf(msgModuleNotFound, "app-c"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestUnpinningModuleNotPinned(t *testing.T) {
+	initPinRepo(t)
+	err := NewWorld(t, ".tmp/repo").System.Unpin("app-a")
+
+	assert.EqualError(t, err, fmt.Sprintf(msgModuleNotPinned, "app-a"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestInvalidPinLock(t *testing.T) {
+	repo := initPinRepo(t)
+	check(t, repo.WriteContent(".mbt/pins.lock", "{"))
+	check(t, repo.Commit("second"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+
+	assert.EqualError(t, err, fmt.Sprintf(msgInvalidPinLock, ".mbt/pins.lock"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestPinLockFormat(t *testing.T) {
+	initPinRepo(t)
+	_, err := NewWorld(t, ".tmp/repo").System.Pin("app-a", &PinOptions{Version: "v1"})
+	check(t, err)
+
+	buff, err := ioutil.ReadFile(filepath.Join(".tmp/repo", ".mbt", "pins.lock"))
+	check(t, err)
+	assert.Equal(t, "{\n  \"modules\": [\n    {\n      \"name\": \"app-a\",\n      \"version\": \"v1\"\n    }\n  ]\n}\n", string(buff))
+}
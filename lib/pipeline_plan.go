@@ -0,0 +1,368 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/mbtproject/mbt/graph"
+)
+
+const (
+	// PipelineGitHub renders the modules as a GitHub Actions matrix.
+	PipelineGitHub = "github"
+	// PipelineGitHubActions renders a reusable GitHub Actions workflow
+	// with a job for each module.
+	PipelineGitHubActions = "github-actions"
+	// PipelineGitLab renders a GitLab CI child pipeline.
+	PipelineGitLab = "gitlab"
+	// PipelineBuildkite renders a Buildkite pipeline for pipeline upload.
+	PipelineBuildkite = "buildkite"
+	// PipelineJenkins renders the stages of a Jenkins declarative
+	// pipeline.
+	PipelineJenkins = "jenkins"
+	// PipelineAzure renders a template of Azure Pipelines jobs.
+	PipelineAzure = "azure"
+	// PipelineCircleCI renders a CircleCI config continued from a setup
+	// workflow.
+	PipelineCircleCI = "circleci"
+)
+
+// PipelineOptions are the options of the pipelines generated for CI
+// systems.
+type PipelineOptions struct {
+	// Image of the steps of the modules not specifying an image.
+	// Default image (or agent) of the CI system is used if this is empty.
+	Image string
+	// Stage of the jobs in a GitLab pipeline (DefaultGitLabStage if
+	// empty).
+	Stage string
+}
+
+// pipelineRenderer writes the pipeline of a CI system from a plan.
+type pipelineRenderer func(plan *pipelinePlan, options *PipelineOptions, w io.Writer) error
+
+// pipelineRenderers are the CI systems pipelines can be generated for.
+// Supporting another CI system only requires a renderer of the plan.
+var pipelineRenderers = map[string]pipelineRenderer{
+	PipelineGitHub:        renderGitHubMatrix,
+	PipelineGitHubActions: renderGitHubWorkflow,
+	PipelineGitLab:        renderGitLabPipeline,
+	PipelineBuildkite:     renderBuildkitePipeline,
+	PipelineJenkins:       renderJenkinsPipeline,
+	PipelineAzure:         renderAzurePipeline,
+	PipelineCircleCI:      renderCircleCIPipeline,
+}
+
+// PipelineProviders returns the names of the CI systems pipelines can be
+// generated for.
+func PipelineProviders() []string {
+	names := make([]string, 0, len(pipelineRenderers))
+	for n := range pipelineRenderers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pipelinePlan is the plan of a CI pipeline building the modules in a
+// manifest. It is independent of the CI system, each CI system renders
+// it into its own pipeline definition.
+type pipelinePlan struct {
+	Manifest *Manifest
+	// Steps of the modules in the dependency order.
+	Steps []*pipelineStep
+}
+
+// pipelineStep builds a module in a pipeline.
+type pipelineStep struct {
+	Module *Module
+	// Image the build command is executed in.
+	Image string
+	// Dir is the working directory of the build command relative to
+	// the repository root.
+	Dir string
+	// Command line of the build command for a posix shell. Empty if the
+	// module does not have a build command for linux.
+	Command string
+	// Variables are the environment variables of the build command
+	// except MBT_REPO_PATH, which depends on the CI system.
+	Variables map[string]string
+	// Needs are the names of the modules this module depends on, that
+	// are built in the pipeline. Dependencies without a build command
+	// are replaced with their dependencies.
+	Needs []string
+}
+
+// Buildable informs if the step executes a build command.
+func (s *pipelineStep) Buildable() bool {
+	return s.Command != ""
+}
+
+// buildableSteps returns the steps executing a build command.
+func (p *pipelinePlan) buildableSteps() []*pipelineStep {
+	steps := make([]*pipelineStep, 0, len(p.Steps))
+	for _, s := range p.Steps {
+		if s.Buildable() {
+			steps = append(steps, s)
+		}
+	}
+	return steps
+}
+
+// levels returns the steps executing a build command grouped such that
+// steps in a group only need the steps in preceding groups.
+func (p *pipelinePlan) levels() ([][]*pipelineStep, error) {
+	steps := p.buildableSteps()
+	provider := &pipelineStepNodeProvider{steps: make(map[string]*pipelineStep, len(steps))}
+	g := make([]interface{}, 0, len(steps))
+	for _, s := range steps {
+		provider.steps[s.Module.Name()] = s
+		g = append(g, s)
+	}
+
+	batches, err := graph.TopSortGrouped(provider, g...)
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	groups := make([][]*pipelineStep, 0, len(batches))
+	for _, batch := range batches {
+		group := make([]*pipelineStep, 0, len(batch))
+		for _, ele := range batch {
+			group = append(group, ele.(*pipelineStep))
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// ids returns the ids of the buildable steps in a CI system keyed by
+// module name. id converts a module name to an id valid in the CI
+// system, which may map names of different modules to the same id.
+// Converted ids used by more than one module are suffixed with a hash
+// of the module name so that each step has a distinct id.
+func (p *pipelinePlan) ids(id func(string) string) map[string]string {
+	steps := p.buildableSteps()
+	count := make(map[string]int, len(steps))
+	for _, s := range steps {
+		count[id(s.Module.Name())]++
+	}
+
+	ids := make(map[string]string, len(steps))
+	for _, s := range steps {
+		name := s.Module.Name()
+		i := id(name)
+		if count[i] > 1 && i != name {
+			h := sha256.Sum256([]byte(name))
+			i = i + "_" + hex.EncodeToString(h[:])[:8]
+		}
+		ids[name] = i
+	}
+	return ids
+}
+
+// pipelineStepNodeProvider is the graph of the steps of a pipeline
+// with the steps they need as children.
+type pipelineStepNodeProvider struct {
+	steps map[string]*pipelineStep
+}
+
+func (p *pipelineStepNodeProvider) ID(vertex interface{}) interface{} {
+	return vertex.(*pipelineStep).Module.Name()
+}
+
+func (p *pipelineStepNodeProvider) ChildCount(vertex interface{}) int {
+	return len(vertex.(*pipelineStep).Needs)
+}
+
+func (p *pipelineStepNodeProvider) Child(vertex interface{}, index int) (interface{}, error) {
+	n := vertex.(*pipelineStep).Needs[index]
+	s, ok := p.steps[n]
+	if !ok {
+		return nil, e.NewErrorf(ErrClassInternal, "step of %v is not in the pipeline", n)
+	}
+	return s, nil
+}
+
+// newPipelinePlan creates the plan of a pipeline building the modules
+// in the manifest with their build command for linux (or the default
+// one, or docker buildx for modules declaring an image to build).
+func newPipelinePlan(m *Manifest, options *PipelineOptions) (*pipelinePlan, error) {
+	plan := &pipelinePlan{Manifest: m, Steps: make([]*pipelineStep, 0, len(m.Modules))}
+	buildable := make(map[string]bool)
+	for _, mod := range m.Modules {
+		image := mod.Image()
+		if image == "" {
+			image = options.Image
+		}
+
+		step := &pipelineStep{
+			Module:    mod,
+			Image:     image,
+			Dir:       mod.Path(),
+			Variables: pipelineVariables(m, mod),
+			Needs:     pipelineNeeds(mod, buildable),
+		}
+
+		c, ok := mod.Build()["linux"]
+		if !ok {
+			c, ok = mod.Build()["default"]
+		}
+		// Images built by CI jobs are pushed, since they are not
+		// available to other jobs otherwise.
+		if !ok && mod.Docker() != nil {
+			c, ok = dockerBuildCmd(mod, nil, true, ""), true
+		}
+
+		if ok {
+			command, err := pipelineCommand(m, mod, c)
+			if err != nil {
+				return nil, err
+			}
+			step.Command = command
+			step.Dir = path.Join(mod.Path(), c.Dir)
+			buildable[mod.Name()] = true
+		}
+
+		plan.Steps = append(plan.Steps, step)
+	}
+
+	return plan, nil
+}
+
+// pipelineCommand returns the command line of a build command.
+func pipelineCommand(m *Manifest, mod *Module, c *Cmd) (string, error) {
+	command, args := c.Cmd, c.Args
+	if mod.Templates() {
+		var err error
+		command, args, err = expandCommand(m, mod, &CmdOptions{}, c.Cmd, c.Args)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	command, args, err := shellCommand(c.Shell, command, args)
+	if err != nil {
+		return "", err
+	}
+
+	words := []string{shellWord(command)}
+	for _, a := range args {
+		words = append(words, shellWord(a))
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+// shellWord quotes s as a single argument of a posix shell unless it
+// does not contain any special characters.
+func shellWord(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:@%+,") == "" {
+		return s
+	}
+	return shellQuote(s)
+}
+
+// pipelineVariables returns the environment variables mbt initialises
+// for the build of a module along with the variables in its spec.
+func pipelineVariables(m *Manifest, mod *Module) map[string]string {
+	variables := make(map[string]string)
+	for _, v := range buildEnvironment(m, mod) {
+		p := strings.SplitN(v, "=", 2)
+		variables[p[0]] = p[1]
+	}
+	// Repository is checked out in a different directory in CI.
+	delete(variables, "MBT_REPO_PATH")
+
+	for k, v := range mod.Env() {
+		variables[k] = v
+	}
+
+	return variables
+}
+
+// pipelineNeeds returns the names of the closest dependencies of a
+// module that are buildable.
+func pipelineNeeds(mod *Module, buildable map[string]bool) []string {
+	needs := make(map[string]bool)
+	visited := make(map[string]bool)
+	var visit func(*Module)
+	visit = func(m *Module) {
+		for _, d := range m.Requires() {
+			if visited[d.Name()] {
+				continue
+			}
+			visited[d.Name()] = true
+
+			if buildable[d.Name()] {
+				needs[d.Name()] = true
+			} else {
+				visit(d)
+			}
+		}
+	}
+	visit(mod)
+
+	r := make([]string, 0, len(needs))
+	for n := range needs {
+		r = append(r, n)
+	}
+	sort.Strings(r)
+	return r
+}
+
+// sortedKeys returns the keys of a map of strings in the sorted order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WritePipeline writes the pipeline of the specified CI system building
+// the modules in the manifest to w.
+// Pipelines in the repository configuration override the builtin
+// renderers and can be specified for other CI systems as well.
+func (m *Manifest) WritePipeline(provider string, options *PipelineOptions, w io.Writer) error {
+	render, err := pipelineTemplateRenderer(m.Dir, provider)
+	if err != nil {
+		return err
+	}
+
+	if render == nil {
+		var ok bool
+		render, ok = pipelineRenderers[provider]
+		if !ok {
+			return e.NewErrorf(ErrClassUser, msgUnsupportedPipelineProvider, provider, strings.Join(PipelineProviders(), ", "))
+		}
+	}
+
+	plan, err := newPipelinePlan(m, options)
+	if err != nil {
+		return err
+	}
+
+	return render(plan, options, w)
+}
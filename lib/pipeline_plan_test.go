@@ -0,0 +1,131 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func initPipelineRepo(t *testing.T) *TestRepository {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("lib-a", &Spec{
+		Name:      "lib-a",
+		Templates: true,
+		Build:     map[string]*Cmd{"linux": {Cmd: "make", Args: []string{"build", "{{ .Module.Name }}"}}},
+	}))
+	check(t, repo.InitModuleWithOptions("proto-a", &Spec{
+		Name:         "proto-a",
+		Dependencies: []string{"lib-a"},
+	}))
+	check(t, repo.InitModuleWithOptions("svc-a", &Spec{
+		Name:         "svc-a",
+		Dependencies: []string{"proto-a"},
+		Image:        "golang:1.21",
+		Env:          map[string]string{"GOFLAGS": "-mod=vendor"},
+		Build:        map[string]*Cmd{"default": {Cmd: "./build.sh 'release'", Shell: "sh", Dir: "src"}},
+	}))
+	check(t, repo.Commit("first"))
+
+	return repo
+}
+
+func TestPipelinePlan(t *testing.T) {
+	initPipelineRepo(t)
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	plan, err := newPipelinePlan(m, &PipelineOptions{Image: "alpine"})
+	check(t, err)
+
+	assert.Len(t, plan.Steps, 3)
+	lib, proto, svc := plan.Steps[0], plan.Steps[1], plan.Steps[2]
+
+	assert.True(t, lib.Buildable())
+	assert.Equal(t, "make build lib-a", lib.Command)
+	assert.Equal(t, "lib-a", lib.Dir)
+	assert.Equal(t, "alpine", lib.Image)
+	assert.NotContains(t, lib.Variables, "MBT_REPO_PATH")
+	assert.Equal(t, "lib-a", lib.Variables["MBT_MODULE_NAME"])
+
+	assert.False(t, proto.Buildable())
+	assert.Equal(t, []string{"lib-a"}, proto.Needs)
+
+	assert.Equal(t, "svc-a/src", svc.Dir)
+	assert.Equal(t, "golang:1.21", svc.Image)
+	assert.Equal(t, []string{"lib-a"}, svc.Needs)
+
+	levels, err := plan.levels()
+	check(t, err)
+	assert.Len(t, levels, 2)
+	assert.Equal(t, []*pipelineStep{lib}, levels[0])
+	assert.Equal(t, []*pipelineStep{svc}, levels[1])
+}
+
+func TestWritePipelineForEachProvider(t *testing.T) {
+	initPipelineRepo(t)
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	for _, provider := range PipelineProviders() {
+		buff := new(bytes.Buffer)
+		check(t, m.WritePipeline(provider, &PipelineOptions{}, buff))
+		assert.Contains(t, buff.String(), "svc-a", provider)
+	}
+}
+
+func TestWritePipelineFromTemplateInConfig(t *testing.T) {
+	repo := initPipelineRepo(t)
+	check(t, repo.WriteConfig(&RepoConfig{Pipelines: map[string]string{
+		PipelineGitLab: ".mbt/ci/gitlab.yml.tmpl",
+		"travis":       ".mbt/ci/travis.yml.tmpl",
+	}}))
+	check(t, repo.WriteContent(".mbt/ci/gitlab.yml.tmpl", `{{ range .Steps }}{{ if .Buildable }}{{ .Module.Name }} in {{ .Dir }}: {{ toJson .Needs }}
+{{ end }}{{ end }}`))
+	check(t, repo.WriteContent(".mbt/ci/travis.yml.tmpl", `{{ range $i, $l := .Levels }}stage {{ $i }}:{{ range $l }} {{ .Module.Name }}{{ end }}
+{{ end }}image: {{ .Options.Image }}`))
+	check(t, repo.Commit("pipelines"))
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	check(t, m.WritePipeline(PipelineGitLab, &PipelineOptions{}, buff))
+	assert.Equal(t, "lib-a in lib-a: []\nsvc-a in svc-a/src: [\"lib-a\"]\n", buff.String())
+
+	buff.Reset()
+	check(t, m.WritePipeline("travis", &PipelineOptions{Image: "alpine"}, buff))
+	assert.Equal(t, "stage 0: lib-a\nstage 1: svc-a\nimage: alpine", buff.String())
+
+	// Other CI systems use the builtin pipelines.
+	buff.Reset()
+	check(t, m.WritePipeline(PipelineJenkins, &PipelineOptions{}, buff))
+	assert.Contains(t, buff.String(), "stage(")
+}
+
+func TestWritePipelineForUnsupportedProvider(t *testing.T) {
+	err := (&Manifest{Modules: Modules{}}).WritePipeline("travis", &PipelineOptions{}, new(bytes.Buffer))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgUnsupportedPipelineProvider, "travis", strings.Join(PipelineProviders(), ", ")))
+}
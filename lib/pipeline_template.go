@@ -0,0 +1,72 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"text/template"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// pipelineTemplateData is the data of the templates overriding the
+// pipeline of a CI system.
+type pipelineTemplateData struct {
+	Manifest *Manifest
+	// Steps of the modules in the dependency order.
+	Steps []*pipelineStep
+	// Levels are the steps executing a build command grouped such that
+	// steps in a group only need the steps in preceding groups.
+	Levels  [][]*pipelineStep
+	Options *PipelineOptions
+}
+
+// pipelineTemplateRenderer returns the renderer of the template in the
+// repository specified in the configuration for a CI system, nil if
+// the pipeline is not overridden.
+func pipelineTemplateRenderer(dir, provider string) (pipelineRenderer, error) {
+	config, err := loadRepoConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	templatePath, ok := config.Pipelines[provider]
+	if !ok {
+		return nil, nil
+	}
+
+	return func(plan *pipelinePlan, options *PipelineOptions, w io.Writer) error {
+		p := filepath.Join(dir, filepath.FromSlash(templatePath))
+		buff, err := ioutil.ReadFile(p)
+		if err != nil {
+			return e.Wrapf(ErrClassUser, err, msgFailedTemplatePath, p)
+		}
+
+		t, err := template.New(templatePath).Option("missingkey=error").Funcs(sprigFuncs()).Parse(string(buff))
+		if err != nil {
+			return e.Wrapf(ErrClassUser, err, msgFailedTemplateParse)
+		}
+
+		levels, err := plan.levels()
+		if err != nil {
+			return err
+		}
+
+		return t.Execute(w, &pipelineTemplateData{Manifest: plan.Manifest, Steps: plan.Steps, Levels: levels, Options: options})
+	}, nil
+}
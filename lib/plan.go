@@ -0,0 +1,209 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"github.com/mbtproject/mbt/e"
+	"github.com/mbtproject/mbt/graph"
+)
+
+// planManifest creates the summary of a build without executing any
+// command.
+// Templates in the commands are expanded and values of secret
+// environment variables are redacted.
+func (s *stdSystem) planManifest(m *Manifest, options *CmdOptions) (*BuildSummary, error) {
+	config, err := loadRepoConfig(m.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	skipped := make([]*Module, 0)
+	plan := &BuildPlan{Groups: make([][]*BuildStep, 0)}
+
+	groups, err := m.Modules.parallelGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range groups {
+		steps := make([]*BuildStep, 0, len(group))
+		for _, a := range group {
+			cmd, ok := s.canBuildHere(a)
+			if !ok {
+				skipped = append(skipped, a)
+				continue
+			}
+
+			moduleOptions, err := withModuleEnvironment(config, a, options)
+			if err != nil {
+				return nil, err
+			}
+			moduleOptions = withReleaseOptions(moduleOptions, a)
+
+			variants := a.Variants()
+			if len(variants) == 0 {
+				variants = []*Variant{nil}
+			}
+
+			for _, v := range variants {
+				step, err := buildStep(m, a, v, cmd, moduleOptions)
+				if err != nil {
+					return nil, err
+				}
+				steps = append(steps, step)
+			}
+		}
+
+		if len(steps) > 0 {
+			plan.Groups = append(plan.Groups, steps)
+		}
+	}
+
+	return &BuildSummary{Manifest: m, Completed: make([]*BuildResult, 0), Skipped: skipped, Plan: plan}, nil
+}
+
+// buildStep creates the step of a module (or a variant of it) with the
+// environment and command it would be built with.
+func buildStep(m *Manifest, a *Module, v *Variant, cmd *Cmd, options *CmdOptions) (*BuildStep, error) {
+	o := *options
+	if v != nil {
+		o.Env = append(append([]string{}, options.Env...), v.environment()...)
+	}
+
+	command, args := cmd.Cmd, cmd.Args
+	if a.Templates() {
+		var err error
+		command, args, err = expandCommand(m, a, &o, cmd.Cmd, cmd.Args)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	expanded := *cmd
+	expanded.Cmd = redact(command, o.Secrets)
+	expanded.Args = make([]string, 0, len(args))
+	for _, arg := range args {
+		expanded.Args = append(expanded.Args, redact(arg, o.Secrets))
+	}
+
+	return &BuildStep{
+		Module:   a,
+		Variant:  v,
+		Cmd:      cmd,
+		Expanded: &expanded,
+		Env:      redactEnv(append(buildEnvironment(m, a), o.Env...), o.Secrets),
+	}, nil
+}
+
+// predictPlan sets the outcome of the steps in a plan, in the same
+// order of checks as buildTracked. Probes are executed since they do
+// not change the workspace. Nothing is restored from the build cache.
+func (s *stdSystem) predictPlan(config *RepoConfig, m *Manifest, plan *BuildPlan, options *CmdOptions) error {
+	j, err := s.openJournal(m, options.Resume)
+	if err != nil {
+		return err
+	}
+
+	fp, err := s.openFingerprints()
+	if err != nil {
+		return err
+	}
+
+	bc, err := s.openBuildCache(config, options)
+	if err != nil {
+		return err
+	}
+
+	outcomes := make(map[string]string)
+	for _, group := range plan.Groups {
+		for _, step := range group {
+			a := step.Module
+			outcome, ok := outcomes[a.Name()]
+			if !ok {
+				outcome, err = s.predictOutcome(config, m, a, options, j, fp, bc)
+				if err != nil {
+					return err
+				}
+				outcomes[a.Name()] = outcome
+			}
+			step.Outcome = outcome
+		}
+	}
+
+	return nil
+}
+
+func (s *stdSystem) predictOutcome(config *RepoConfig, m *Manifest, a *Module, options *CmdOptions, j *journal, fp *fingerprints, bc *buildCache) (string, error) {
+	key, err := journalKey(config, a, options)
+	if err != nil {
+		return "", err
+	}
+	if options.Resume && j.completed(a, key) {
+		return ModuleStatusResumed, nil
+	}
+
+	satisfied, err := s.probe(config, m, a, options)
+	if err != nil {
+		return "", err
+	}
+	if satisfied {
+		return ModuleStatusSatisfied, nil
+	}
+
+	if !options.IgnoreFingerprints && fp.unaffected(a) {
+		return ModuleStatusUnaffected, nil
+	}
+
+	if bc != nil && !usesDockerBuild(a) {
+		cached, err := bc.contains(config, a, options)
+		if err != nil {
+			return "", err
+		}
+		if cached {
+			return ModuleStatusCached, nil
+		}
+	}
+
+	return "", nil
+}
+
+// parallelGroups partitions a topologically sorted list of modules
+// into groups such that modules in a group only depend on the modules
+// in preceding groups.
+// Dependencies that are not in the list are ignored because they
+// are not built along with these modules.
+func (l Modules) parallelGroups() ([]Modules, error) {
+	g := make([]interface{}, 0, len(l))
+	for _, a := range l {
+		g = append(g, a)
+	}
+
+	batches, err := graph.TopSortGrouped(newRequiresInNodeProvider(l), g...)
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	groups := make([]Modules, 0, len(batches))
+	for _, batch := range batches {
+		group := make(Modules, 0, len(batch))
+		for _, ele := range batch {
+			group = append(group, ele.(*Module))
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
@@ -0,0 +1,299 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// PlanArchiveSchemaVersion is the version of the schema of plan
+// archives. It is incremented for changes that are not backwards
+// compatible.
+const PlanArchiveSchemaVersion = 1
+
+const (
+	planArchiveManifest = "manifest.json"
+	planArchivePlan     = "plan.json"
+)
+
+// archivedPlan is the structure of plan.json in a plan archive.
+// Files are the digests of the files of the modules planned, which are
+// verified before executing the plan.
+type archivedPlan struct {
+	SchemaVersion int           `json:"schemaVersion"`
+	Commit        string        `json:"commit"`
+	Groups        [][]*planStep `json:"groups"`
+	Files         []*ModuleFile `json:"files"`
+}
+
+type planStep struct {
+	Module  string   `json:"module"`
+	Variant string   `json:"variant,omitempty"`
+	Cmd     string   `json:"cmd"`
+	Args    []string `json:"args,omitempty"`
+	Dir     string   `json:"dir,omitempty"`
+	Shell   string   `json:"shell,omitempty"`
+	// Env is the environment of the command at the time of planning
+	// with the secrets redacted. It is recorded for reviewing the
+	// plan, the environment is created again when the plan is
+	// executed.
+	Env []string `json:"env"`
+}
+
+// WritePlanArchive writes the plan of the build as a tar archive
+// containing the manifest, the steps in the order of execution and the
+// digests of the files of the modules planned.
+// Archive is executed with ExecutePlanArchive.
+func (summary *BuildSummary) WritePlanArchive(w io.Writer) error {
+	if summary.Plan == nil {
+		return e.NewError(ErrClassInternal, "build summary does not have a plan")
+	}
+
+	plan := &archivedPlan{
+		SchemaVersion: PlanArchiveSchemaVersion,
+		Commit:        summary.Manifest.Sha,
+		Groups:        make([][]*planStep, 0, len(summary.Plan.Groups)),
+		Files:         make([]*ModuleFile, 0),
+	}
+
+	files := make(map[string]string)
+	for _, g := range summary.Plan.Groups {
+		steps := make([]*planStep, 0, len(g))
+		for _, s := range g {
+			step := &planStep{
+				Module: s.Module.Name(),
+				Cmd:    s.Cmd.Cmd,
+				Args:   s.Cmd.Args,
+				Dir:    s.Cmd.Dir,
+				Shell:  s.Cmd.Shell,
+				Env:    s.Env,
+			}
+			if s.Variant != nil {
+				step.Variant = s.Variant.Name
+			}
+			steps = append(steps, step)
+
+			moduleFiles, err := s.Module.Files()
+			if err != nil {
+				return err
+			}
+			for _, f := range moduleFiles {
+				files[f.Path] = f.Hash
+			}
+		}
+		plan.Groups = append(plan.Groups, steps)
+	}
+	plan.Files = sortedModuleFiles(files)
+
+	manifest := &bytes.Buffer{}
+	if err := summary.Manifest.Export(manifest); err != nil {
+		return err
+	}
+
+	planContent, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	tw := tar.NewWriter(w)
+	for _, entry := range []struct {
+		name    string
+		content []byte
+	}{
+		{planArchiveManifest, manifest.Bytes()},
+		{planArchivePlan, append(planContent, '\n')},
+	} {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     entry.name,
+			Mode:     0644,
+			Size:     int64(len(entry.content)),
+			ModTime:  time.Unix(0, 0),
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			return e.Wrap(ErrClassInternal, err)
+		}
+
+		if _, err := tw.Write(entry.content); err != nil {
+			return e.Wrap(ErrClassInternal, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+	return nil
+}
+
+// readPlanArchive reads the plan and the manifest in a plan archive.
+func readPlanArchive(r io.Reader, dir string) (*archivedPlan, *Manifest, error) {
+	entries := make(map[string][]byte)
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, e.NewErrorf(ErrClassUser, msgInvalidPlanArchive, err)
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, nil, e.NewErrorf(ErrClassUser, msgInvalidPlanArchive, err)
+		}
+		entries[h.Name] = content
+	}
+
+	for _, name := range []string{planArchiveManifest, planArchivePlan} {
+		if _, ok := entries[name]; !ok {
+			return nil, nil, e.NewErrorf(ErrClassUser, msgInvalidPlanArchive, fmt.Sprintf("%s is not found", name))
+		}
+	}
+
+	plan := &archivedPlan{}
+	if err := json.Unmarshal(entries[planArchivePlan], plan); err != nil {
+		return nil, nil, e.NewErrorf(ErrClassUser, msgInvalidPlanArchive, err)
+	}
+
+	if plan.SchemaVersion != PlanArchiveSchemaVersion {
+		return nil, nil, e.NewErrorf(ErrClassUser, msgUnsupportedPlanSchema, plan.SchemaVersion, PlanArchiveSchemaVersion)
+	}
+
+	m, err := importManifest(bytes.NewReader(entries[planArchiveManifest]), dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return plan, m, nil
+}
+
+// verifyCheckout checks whether the files in dir match the digests in
+// the plan.
+func (plan *archivedPlan) verifyCheckout(dir string) error {
+	mismatches := make([]string, 0)
+	for _, f := range plan.Files {
+		content, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(f.Path)))
+		if err != nil && !os.IsNotExist(err) {
+			return e.Wrapf(ErrClassInternal, err, msgFailedReadFile, f.Path)
+		}
+
+		if err != nil || blobHash(content) != f.Hash {
+			mismatches = append(mismatches, f.Path)
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return e.NewErrorf(ErrClassUser, msgPlanCheckoutMismatch, len(mismatches), mismatches[0])
+	}
+	return nil
+}
+
+// ExecutePlanArchive executes the plan in an archive written by
+// BuildSummary.WritePlanArchive in the checkout at dir.
+// Repository is not accessed, instead the files in the checkout are
+// verified against the digests in the plan before executing any
+// command. Steps are executed in the order of the plan and the
+// execution stops at the first failure.
+func ExecutePlanArchive(r io.Reader, dir string, options *CmdOptions, log Log) (*BuildSummary, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	plan, m, err := readPlanArchive(r, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := plan.verifyCheckout(dir); err != nil {
+		return nil, err
+	}
+
+	config, err := loadRepoConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	pm := NewProcessManager(log)
+	summary := &BuildSummary{Manifest: m, Completed: make([]*BuildResult, 0), Skipped: make([]*Module, 0)}
+	for _, g := range plan.Groups {
+		for _, step := range g {
+			if err := cancelled(options.Context); err != nil {
+				return summary, err
+			}
+
+			result, err := executePlanStep(pm, config, m, step, options)
+			if err != nil {
+				return summary, err
+			}
+			summary.Completed = append(summary.Completed, result)
+		}
+	}
+
+	return summary, nil
+}
+
+func executePlanStep(pm ProcessManager, config *RepoConfig, m *Manifest, step *planStep, options *CmdOptions) (*BuildResult, error) {
+	a, ok := m.Module(step.Module)
+	if !ok {
+		return nil, e.NewErrorf(ErrClassUser, msgUnknownManifestModule, step.Module)
+	}
+
+	var variant *Variant
+	if step.Variant != "" {
+		for _, v := range a.Variants() {
+			if v.Name == step.Variant {
+				variant = v
+			}
+		}
+		if variant == nil {
+			return nil, e.NewErrorf(ErrClassUser, msgInvalidPlanArchive, fmt.Sprintf("variant %s of %s is not found", step.Variant, step.Module))
+		}
+	}
+
+	options, err := withModuleEnvironment(config, a, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if variant != nil {
+		o := *options
+		o.Env = append(append([]string{}, options.Env...), variant.environment()...)
+		options = &o
+	}
+
+	options.Callback(a, CmdStageBeforeBuild, nil)
+	err = runSpecCmd(pm, m, a, options, step.Shell, step.Dir, step.Cmd, step.Args)
+	if err != nil {
+		err = e.Wrapf(ErrClassUser, err, msgFailedBuild, a.Name())
+		options.Callback(a, CmdStageFailedBuild, err)
+		return nil, err
+	}
+
+	options.Callback(a, CmdStageAfterBuild, nil)
+	return &BuildResult{Module: a, Variant: variant}, nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func initPlanArchiveRepo(t *testing.T) *TestRepository {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo built app-a $MBT_MODULE_VERSION"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:         "app-b",
+		Build:        map[string]*Cmd{"default": {Cmd: "./build.sh"}},
+		Dependencies: []string{"app-a"},
+		Matrix:       map[string][]string{"arch": {"amd64", "arm64"}},
+	}))
+	check(t, repo.WriteShellScript("app-b/build.sh", "echo built app-b $MBT_MATRIX_ARCH"))
+	check(t, repo.Commit("first"))
+	return repo
+}
+
+func writePlanArchive(t *testing.T) *bytes.Buffer {
+	options := stdTestCmdOptions(nil)
+	options.Plan = true
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	archive := new(bytes.Buffer)
+	check(t, summary.WritePlanArchive(archive))
+	return archive
+}
+
+func TestExecutePlanArchive(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initPlanArchiveRepo(t)
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+	archive := writePlanArchive(t)
+
+	// Plan is executed without the repository.
+	check(t, os.RemoveAll(filepath.Join(".tmp/repo", ".git")))
+
+	buff := new(bytes.Buffer)
+	summary, err := ExecutePlanArchive(archive, ".tmp/repo", stdTestCmdOptions(buff), NewStdLog(LogLevelNormal))
+	check(t, err)
+
+	assert.Len(t, summary.Completed, 3)
+	assert.Equal(t, m.Sha, summary.Manifest.Sha)
+	assert.Equal(t, fmt.Sprintf("built app-a %s\nbuilt app-b amd64\nbuilt app-b arm64\n", m.Modules.indexByName()["app-a"].Version()), buff.String())
+}
+
+func TestExecutePlanArchiveInDifferentCheckout(t *testing.T) {
+	repo := initPlanArchiveRepo(t)
+	archive := writePlanArchive(t)
+	check(t, repo.WriteContent("app-b/main.go", "package main"))
+	check(t, repo.WriteContent("app-a/build.sh", "echo changed"))
+
+	buff := new(bytes.Buffer)
+	_, err := ExecutePlanArchive(archive, ".tmp/repo", stdTestCmdOptions(buff), NewStdLog(LogLevelNormal))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgPlanCheckoutMismatch, 1, "app-a/build.sh"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+	assert.Equal(t, "", buff.String())
+}
+
+func TestExecutePlanArchiveWithFailingStep(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	repo := initPlanArchiveRepo(t)
+	check(t, repo.WriteShellScript("app-a/build.sh", "exit 1"))
+	check(t, repo.Commit("second"))
+	archive := writePlanArchive(t)
+
+	summary, err := ExecutePlanArchive(archive, ".tmp/repo", stdTestCmdOptions(new(bytes.Buffer)), NewStdLog(LogLevelNormal))
+
+	assert.Error(t, err)
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+	assert.Len(t, summary.Completed, 0)
+}
+
+func TestInvalidPlanArchive(t *testing.T) {
+	initPlanArchiveRepo(t)
+	archive := new(bytes.Buffer)
+	tw := tar.NewWriter(archive)
+	check(t, tw.Close())
+
+	_, err := ExecutePlanArchive(archive, ".tmp/repo", stdTestCmdOptions(nil), NewStdLog(LogLevelNormal))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgInvalidPlanArchive, "manifest.json is not found"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestPlanArchiveWithUnsupportedSchema(t *testing.T) {
+	initPlanArchiveRepo(t)
+	archive := new(bytes.Buffer)
+	tw := tar.NewWriter(archive)
+	for name, content := range map[string]string{"manifest.json": "{}", "plan.json": `{"schemaVersion": 2}`} {
+		check(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		check(t, err)
+	}
+	check(t, tw.Close())
+
+	_, err := ExecutePlanArchive(archive, ".tmp/repo", stdTestCmdOptions(nil), NewStdLog(LogLevelNormal))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgUnsupportedPlanSchema, 2, PlanArchiveSchemaVersion))
+}
@@ -0,0 +1,368 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// PluginProtocolVersion is the version of the protocol spoken with
+	// the plugins. It is incremented for changes that are not backwards
+	// compatible.
+	PluginProtocolVersion = 1
+	// PluginHookDiscover discovers the modules not declared with a
+	// spec file (e.g. the targets of another build system).
+	PluginHookDiscover = "discover"
+	// PluginHookReport receives the summary of builds and runs.
+	PluginHookReport = "report"
+	// PluginHookCache stores the modules discovered in commits, so
+	// that they can be shared across machines.
+	PluginHookCache = "cache"
+	// PluginHookTemplateFunc implements template functions.
+	PluginHookTemplateFunc = "templateFunc"
+	// pluginHookDescribe returns the version and the capabilities of a
+	// plugin when it is locked.
+	pluginHookDescribe = "describe"
+
+	pluginPrefix   = "mbt-"
+	pluginLockFile = "plugins.lock"
+	pluginsDir     = "plugins"
+)
+
+// PluginLock pins the plugins used in a repository. It is written by
+// mbt plugin lock to .mbt/plugins.lock.
+type PluginLock struct {
+	Plugins []*LockedPlugin `json:"plugins"`
+}
+
+// LockedPlugin is a plugin pinned in the lock file.
+type LockedPlugin struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// SHA256 is the digest of the executable. Plugin is not executed
+	// if the executable found does not match it.
+	SHA256        string   `json:"sha256"`
+	Hooks         []string `json:"hooks"`
+	TemplateFuncs []string `json:"templateFuncs,omitempty"`
+}
+
+// pluginDescription is the response of a plugin to describe.
+type pluginDescription struct {
+	Version       string   `json:"version"`
+	Hooks         []string `json:"hooks"`
+	TemplateFuncs []string `json:"templateFuncs,omitempty"`
+}
+
+// plugin is an executable named mbt-<name> found in .mbt/plugins of the
+// repository or in PATH.
+type plugin struct {
+	name string
+	path string
+	// dir is the root of the repository, the working directory of the
+	// plugin.
+	dir    string
+	locked *LockedPlugin
+}
+
+// lookupPlugin finds the executable of a plugin.
+func lookupPlugin(dir, name string) (*plugin, error) {
+	exe := pluginPrefix + name
+	local := filepath.Join(dir, configDir, pluginsDir, exe)
+	if fi, err := os.Stat(local); err == nil && !fi.IsDir() {
+		return &plugin{name: name, path: local, dir: dir}, nil
+	}
+
+	p, err := exec.LookPath(exe)
+	if err != nil {
+		return nil, e.NewErrorf(ErrClassUser, msgPluginNotFound, name, exe)
+	}
+	return &plugin{name: name, path: p, dir: dir}, nil
+}
+
+// loadPlugins finds the plugins in config and verifies them against
+// the lock file contents.
+func loadPlugins(dir string, config *RepoConfig, lockContents []byte) ([]*plugin, error) {
+	if len(config.Plugins) == 0 {
+		return nil, nil
+	}
+
+	lock := &PluginLock{}
+	if lockContents != nil {
+		if err := json.Unmarshal(lockContents, lock); err != nil {
+			return nil, configError(e.Wrapf(ErrClassUser, err, msgInvalidPluginLock))
+		}
+	}
+
+	index := make(map[string]*LockedPlugin)
+	for _, l := range lock.Plugins {
+		index[l.Name] = l
+	}
+
+	plugins := make([]*plugin, 0, len(config.Plugins))
+	for _, name := range config.Plugins {
+		locked, ok := index[name]
+		if !ok {
+			return nil, e.NewErrorf(ErrClassUser, msgPluginNotLocked, name)
+		}
+
+		p, err := lookupPlugin(dir, name)
+		if err != nil {
+			return nil, err
+		}
+
+		digest, err := fileSHA256(p.path)
+		if err != nil {
+			return nil, err
+		}
+		if digest != locked.SHA256 {
+			return nil, e.NewErrorf(ErrClassUser, msgPluginChecksumMismatch, name, p.path)
+		}
+
+		p.locked = locked
+		plugins = append(plugins, p)
+	}
+
+	return plugins, nil
+}
+
+// loadWorkspacePlugins loads the plugins in the configuration and the
+// lock file in the workspace of dir.
+func loadWorkspacePlugins(dir string, config *RepoConfig) ([]*plugin, error) {
+	if len(config.Plugins) == 0 {
+		return nil, nil
+	}
+
+	lock, err := ioutil.ReadFile(filepath.Join(dir, configDir, pluginLockFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+	return loadPlugins(dir, config, lock)
+}
+
+// pluginsWithHook returns the plugins implementing a hook.
+func pluginsWithHook(plugins []*plugin, hook string) []*plugin {
+	r := make([]*plugin, 0)
+	for _, p := range plugins {
+		for _, h := range p.locked.Hooks {
+			if h == hook {
+				r = append(r, p)
+				break
+			}
+		}
+	}
+	return r
+}
+
+// call executes the plugin with the input of a hook in stdin. Input is
+// a json object with protocol, hook and the fields of input. Output of
+// the plugin is decoded into out unless it is nil or the output is
+// empty.
+func (p *plugin) call(hook string, input map[string]interface{}, out interface{}) error {
+	request := map[string]interface{}{"protocol": PluginProtocolVersion, "hook": hook}
+	for k, v := range input {
+		request[k] = v
+	}
+
+	buff, err := json.Marshal(request)
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	c := exec.Command(p.path)
+	c.Dir = p.dir
+	c.Env = os.Environ()
+	c.Stdin = bytes.NewReader(buff)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+
+	if err := c.Run(); err != nil {
+		return e.NewErrorf(ErrClassUser, msgFailedPlugin, p.name, hook, err, strings.TrimSpace(stderr.String()))
+	}
+
+	if out == nil || len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(stdout.Bytes(), out); err != nil {
+		return e.NewErrorf(ErrClassUser, msgInvalidPluginOutput, p.name, hook, err)
+	}
+	return nil
+}
+
+func (s *stdSystem) LockPlugins() (*PluginLock, error) {
+	dir, err := filepath.Abs(s.Repo.Path())
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	config, err := loadRepoConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &PluginLock{Plugins: make([]*LockedPlugin, 0, len(config.Plugins))}
+	for _, name := range config.Plugins {
+		p, err := lookupPlugin(dir, name)
+		if err != nil {
+			return nil, err
+		}
+
+		d := &pluginDescription{}
+		if err := p.call(pluginHookDescribe, nil, d); err != nil {
+			return nil, err
+		}
+
+		digest, err := fileSHA256(p.path)
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Strings(d.Hooks)
+		sort.Strings(d.TemplateFuncs)
+		lock.Plugins = append(lock.Plugins, &LockedPlugin{
+			Name:          name,
+			Version:       d.Version,
+			SHA256:        digest,
+			Hooks:         d.Hooks,
+			TemplateFuncs: d.TemplateFuncs,
+		})
+	}
+
+	buff, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, configDir, pluginLockFile), append(buff, '\n'), 0644)
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	return lock, nil
+}
+
+// pluginModule is a module discovered by a plugin. Spec is the spec of
+// the module in the same form as .mbt.yml.
+type pluginModule struct {
+	Dir  string          `json:"dir"`
+	Spec json.RawMessage `json:"spec"`
+}
+
+type pluginDiscovery struct {
+	Modules []*pluginModule `json:"modules"`
+}
+
+// discoverWithPlugins returns the modules discovered by the plugins in
+// a commit (or in the workspace if commit is nil) excluding the modules
+// in the directories in set.
+func discoverWithPlugins(repo Repo, commit Commit, plugins []*plugin, set moduleMetadataSet) (moduleMetadataSet, error) {
+	dirs := make(map[string]bool)
+	for _, m := range set {
+		dirs[m.dir] = true
+	}
+
+	input := map[string]interface{}{"commit": ""}
+	if commit != nil {
+		input["commit"] = commit.ID()
+	}
+
+	r := moduleMetadataSet{}
+	for _, p := range pluginsWithHook(plugins, PluginHookDiscover) {
+		out := &pluginDiscovery{}
+		if err := p.call(PluginHookDiscover, input, out); err != nil {
+			return nil, err
+		}
+
+		for _, m := range out.Modules {
+			// Module directories are relative to the root of the
+			// repository in the form used by git.
+			dir := strings.Trim(path.Clean("/"+filepath.ToSlash(m.Dir)), "/")
+			if dirs[dir] {
+				continue
+			}
+			dirs[dir] = true
+
+			// Specs are json, which is valid yaml.
+			spec, err := newSpec(m.Spec)
+			if err != nil {
+				return nil, e.NewErrorf(ErrClassUser, msgInvalidPluginOutput, p.name, PluginHookDiscover, err)
+			}
+
+			var metadata *moduleMetadata
+			if commit == nil {
+				metadata = newModuleMetadata(dir, "local", spec, nil)
+				metadata.specContent = m.Spec
+			} else {
+				metadata, err = newCommitModuleMetadata(repo, commit, dir, m.Spec)
+				if err != nil {
+					return nil, err
+				}
+			}
+			r = append(r, metadata)
+		}
+	}
+
+	return r, nil
+}
+
+// reportToPlugins sends the summary of an invocation to the plugins
+// implementing the report hook. Failures are reported as warnings.
+func (s *stdSystem) reportToPlugins(dir string, config *RepoConfig, summary *InvocationSummary) {
+	if len(config.Plugins) == 0 {
+		return
+	}
+
+	plugins, err := loadWorkspacePlugins(dir, config)
+	if err != nil {
+		s.Log.Warnf(msgFailedReportPlugins, err)
+		return
+	}
+
+	for _, p := range pluginsWithHook(plugins, PluginHookReport) {
+		if err := p.call(PluginHookReport, map[string]interface{}{"summary": summary}, nil); err != nil {
+			s.Log.Warnf(msgFailedReportPlugins, err)
+		}
+	}
+}
+
+func fileSHA256(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", e.Wrapf(ErrClassInternal, err, msgFailedReadFile, p)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", e.Wrapf(ErrClassInternal, err, msgFailedReadFile, p)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPlugin = `input=$(cat)
+case "$input" in
+  *'"hook":"describe"'*)
+    echo '{"version": "1.2.0", "hooks": ["templateFunc", "discover", "report"], "templateFuncs": ["shout"]}' ;;
+  *'"hook":"discover"'*)
+    echo '{"modules": [{"dir": "gen/app-x", "spec": {"name": "app-x", "dependencies": ["app-a"], "build": {"default": {"cmd": "true"}}}}, {"dir": "app-a", "spec": {"name": "app-dup"}}]}' ;;
+  *'"hook":"report"'*)
+    echo "$input" > report.json ;;
+  *'"hook":"templateFunc"'*)
+    echo '"HELLO"' ;;
+esac
+`
+
+func initPluginRepo(t *testing.T) *TestRepository {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{Plugins: []string{"test"}}))
+	check(t, repo.WriteShellScript(".mbt/plugins/mbt-test", testPlugin))
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "true"))
+	check(t, repo.WriteContent("gen/app-x/BUILD", "x"))
+	check(t, repo.WriteContent(".gitignore", "report.json\n"))
+	return repo
+}
+
+func TestLockPlugins(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initPluginRepo(t)
+
+	lock, err := NewWorld(t, ".tmp/repo").System.LockPlugins()
+	check(t, err)
+
+	assert.Len(t, lock.Plugins, 1)
+	p := lock.Plugins[0]
+	assert.Equal(t, "test", p.Name)
+	assert.Equal(t, "1.2.0", p.Version)
+	assert.Equal(t, []string{"discover", "report", "templateFunc"}, p.Hooks)
+	assert.Equal(t, []string{"shout"}, p.TemplateFuncs)
+	assert.Len(t, p.SHA256, 64)
+
+	buff, err := ioutil.ReadFile(".tmp/repo/.mbt/plugins.lock")
+	check(t, err)
+	assert.Contains(t, string(buff), `"version": "1.2.0"`)
+}
+
+func TestDiscoverWithPlugins(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	repo := initPluginRepo(t)
+	_, err := NewWorld(t, ".tmp/repo").System.LockPlugins()
+	check(t, err)
+	check(t, repo.Commit("first"))
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+
+	assert.Equal(t, []string{"app-a", "app-x"}, moduleNames(m.Modules))
+	x := m.Modules[1]
+	assert.Equal(t, "gen/app-x", x.Path())
+	assert.Equal(t, []string{"app-a"}, moduleNames(x.Requires()))
+
+	tree, err := repo.Repo.RevparseSingle("HEAD:gen/app-x")
+	check(t, err)
+	assert.Equal(t, tree.Id().String(), x.Hash())
+
+	m, err = NewWorld(t, ".tmp/repo").System.ManifestByWorkspace()
+	check(t, err)
+	assert.Equal(t, []string{"app-a", "app-x"}, moduleNames(m.Modules))
+}
+
+func TestPluginNotLocked(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	repo := initPluginRepo(t)
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+
+	assert.EqualError(t, err, fmt.Sprintf(msgPluginNotLocked, "test"))
+}
+
+func TestPluginNotMatchingLock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	repo := initPluginRepo(t)
+	_, err := NewWorld(t, ".tmp/repo").System.LockPlugins()
+	check(t, err)
+	check(t, repo.WriteShellScript(".mbt/plugins/mbt-test", "echo '{}'"))
+	check(t, repo.Commit("first"))
+
+	_, err = NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+
+	abs, _ := filepath.Abs(".tmp/repo/.mbt/plugins/mbt-test")
+	assert.EqualError(t, err, fmt.Sprintf(msgPluginChecksumMismatch, "test", abs))
+}
+
+func TestPluginNotFound(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteConfig(&RepoConfig{Plugins: []string{"missing"}}))
+
+	_, err := NewWorld(t, ".tmp/repo").System.LockPlugins()
+
+	assert.EqualError(t, err, fmt.Sprintf(msgPluginNotFound, "missing", "mbt-missing"))
+}
+
+func TestReportAndTemplateFuncPlugins(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	repo := initPluginRepo(t)
+	check(t, repo.WriteContent("template.tmpl", `{{shout .Module.Name}}`))
+	_, err := NewWorld(t, ".tmp/repo").System.LockPlugins()
+	check(t, err)
+	check(t, repo.Commit("first"))
+
+	_, err = NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+	check(t, err)
+
+	report, err := ioutil.ReadFile(".tmp/repo/report.json")
+	check(t, err)
+	assert.Contains(t, string(report), `"summary":{"command":"build"`)
+
+	outputs := applyOutputs{}
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("template.tmpl", outputs.options()))
+	assert.Equal(t, "HELLO", outputs["app-a"].String())
+	assert.Equal(t, "HELLO", outputs["app-x"].String())
+}
+
+func TestCachePlugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteConfig(&RepoConfig{Plugins: []string{"cache"}}))
+	check(t, repo.WriteShellScript(".mbt/plugins/mbt-cache", `input=$(cat)
+case "$input" in
+  *'"hook":"describe"'*) echo '{"version": "1.0.0", "hooks": ["cache"]}' ;;
+  *'"op":"put"'*) echo "$input" > ../cache.json ;;
+  *'"op":"get"'*) test -f ../cache.json && cat ../cache.json && echo get >> ../gets ;;
+esac
+`))
+	check(t, repo.InitModule("app-a"))
+	_, err := NewWorld(t, ".tmp/repo").System.LockPlugins()
+	check(t, err)
+	check(t, repo.Commit("first"))
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+	assert.Equal(t, []string{"app-a"}, moduleNames(m.Modules))
+
+	stored, err := ioutil.ReadFile(".tmp/cache.json")
+	check(t, err)
+	assert.Contains(t, string(stored), `"op":"put"`)
+
+	// Entry is read from the plugin when the local cache is empty.
+	check(t, os.RemoveAll(filepath.Join(".tmp/repo/.git", stateDirName, discoveryCacheDirName)))
+	m, err = NewWorld(t, ".tmp/repo").System.ManifestByCurrentBranch()
+	check(t, err)
+	assert.Equal(t, []string{"app-a"}, moduleNames(m.Modules))
+
+	gets, err := ioutil.ReadFile(".tmp/gets")
+	check(t, err)
+	assert.Equal(t, "get\n", string(gets))
+
+	_, err = os.Stat(filepath.Join(".tmp/repo/.git", stateDirName, discoveryCacheDirName, repo.LastCommit.String()+".json"))
+	check(t, err)
+}
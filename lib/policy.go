@@ -0,0 +1,251 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	defaultPolicyDir   = "policies"
+	defaultPolicyQuery = "data.mbt.deny"
+)
+
+// PolicyConfig specifies how the rego policies of the repository are
+// evaluated. Policies are evaluated with opa before builds and runs.
+type PolicyConfig struct {
+	// Dir is the directory of the rego policies relative to the root
+	// of the repository. Defaults to .mbt/policies.
+	Dir string `yaml:"dir,omitempty"`
+	// Query is the rego query evaluating to the set of violations.
+	// Each violation is either a message or an object with a msg field.
+	// Defaults to data.mbt.deny.
+	Query string `yaml:"query,omitempty"`
+}
+
+// PolicyInput is the input document of the policies.
+type PolicyInput struct {
+	// Command is build or run-in.
+	Command string `json:"command"`
+	// UserCommand is the name of the user defined command executed by
+	// run-in.
+	UserCommand string `json:"userCommand,omitempty"`
+	// Environment is the environment selected with --environment.
+	Environment string `json:"environment,omitempty"`
+	// Commit is the commit of the manifest or empty for the workspace.
+	Commit string `json:"commit"`
+	// Time is the time of the evaluation in RFC3339 format and Weekday
+	// is the day of the week of Time (e.g. Friday).
+	Time    string `json:"time"`
+	Weekday string `json:"weekday"`
+	// Modules are the modules selected for the command.
+	Modules []*PolicyModule `json:"modules"`
+	// Plan are the groups of commands to be executed in the order of
+	// execution.
+	Plan [][]*PolicyStep `json:"plan"`
+}
+
+// PolicyModule is a module in PolicyInput.
+type PolicyModule struct {
+	*ModuleDescription
+	Owners []string `json:"owners"`
+}
+
+// PolicyStep is a command execution in PolicyInput.
+type PolicyStep struct {
+	Module  string            `json:"module"`
+	Variant map[string]string `json:"variant,omitempty"`
+	Cmd     string            `json:"cmd"`
+	Args    []string          `json:"args"`
+}
+
+// checkPolicies evaluates the policies of the repository against the
+// modules in the manifest and the plan of the command created by
+// newInput. Policies are not evaluated if the policy directory does
+// not exist.
+func (s *stdSystem) checkPolicies(config *RepoConfig, m *Manifest, newInput func() (*PolicyInput, error)) error {
+	policies := config.Policies
+	if policies == nil {
+		policies = &PolicyConfig{}
+	}
+
+	dir := filepath.Join(m.Dir, configDir, defaultPolicyDir)
+	if policies.Dir != "" {
+		dir = filepath.Join(m.Dir, policies.Dir)
+	}
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+
+	query := policies.Query
+	if query == "" {
+		query = defaultPolicyQuery
+	}
+
+	input, err := newInput()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	input.Commit = m.Sha
+	input.Time = now.Format(time.RFC3339)
+	input.Weekday = now.Weekday().String()
+	input.Modules = make([]*PolicyModule, 0, len(m.Modules))
+	for i, d := range m.Modules.Describe().Modules {
+		owners := m.Modules[i].Owners()
+		if owners == nil {
+			owners = []string{}
+		}
+		input.Modules = append(input.Modules, &PolicyModule{ModuleDescription: d, Owners: owners})
+	}
+
+	violations, err := evalPolicies(dir, query, input)
+	if err != nil {
+		return err
+	}
+
+	if len(violations) > 0 {
+		return e.NewErrorf(ErrClassUser, msgPolicyViolation, strings.Join(violations, "\n"))
+	}
+
+	return nil
+}
+
+// evalPolicies evaluates the query with opa and returns the sorted
+// messages of the violations.
+func evalPolicies(dir, query string, input *PolicyInput) ([]string, error) {
+	buff, err := json.Marshal(input)
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	opa, err := exec.LookPath("opa")
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgPolicyOpaNotFound, dir)
+	}
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	c := exec.Command(opa, "eval", "--format", "json", "--data", dir, "--stdin-input", query)
+	c.Stdin = bytes.NewReader(buff)
+	c.Stdout = stdout
+	c.Stderr = stderr
+	if err := c.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return nil, e.Wrapf(ErrClassUser, err, msgPolicyEvalFailed, err)
+		}
+		return nil, e.NewErrorf(ErrClassUser, msgPolicyEvalFailed, strings.TrimSpace(stderr.String()+stdout.String()))
+	}
+
+	output := struct {
+		Result []struct {
+			Expressions []struct {
+				Value interface{} `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}{}
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgPolicyEvalFailed, stdout.String())
+	}
+
+	violations := make([]string, 0)
+	for _, r := range output.Result {
+		for _, x := range r.Expressions {
+			values, ok := x.Value.([]interface{})
+			if !ok {
+				return nil, e.NewErrorf(ErrClassUser, msgPolicyInvalidResult, query)
+			}
+			for _, v := range values {
+				violations = append(violations, violationMessage(v))
+			}
+		}
+	}
+
+	sort.Strings(violations)
+	return violations, nil
+}
+
+func violationMessage(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case map[string]interface{}:
+		if msg, ok := t["msg"].(string); ok {
+			return msg
+		}
+	}
+
+	buff, _ := json.Marshal(v)
+	return string(buff)
+}
+
+// buildPolicyInput creates the policy input of a build from its plan.
+func (s *stdSystem) buildPolicyInput(m *Manifest, options *CmdOptions) (*PolicyInput, error) {
+	summary, err := s.planManifest(m, options)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &PolicyInput{Command: "build", Environment: options.Environment, Plan: make([][]*PolicyStep, 0)}
+	for _, group := range summary.Plan.Groups {
+		steps := make([]*PolicyStep, 0, len(group))
+		for _, step := range group {
+			p := &PolicyStep{Module: step.Module.Name(), Cmd: step.Cmd.Cmd, Args: step.Cmd.Args}
+			if step.Variant != nil {
+				p.Variant = step.Variant.Values
+			}
+			steps = append(steps, p)
+		}
+		input.Plan = append(input.Plan, steps)
+	}
+
+	return input, nil
+}
+
+// runPolicyInput creates the policy input of run-in.
+func (s *stdSystem) runPolicyInput(command string, m *Manifest, options *CmdOptions) (*PolicyInput, error) {
+	input := &PolicyInput{Command: "run-in", UserCommand: command, Environment: options.Environment, Plan: make([][]*PolicyStep, 0)}
+	groups, err := m.Modules.parallelGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range groups {
+		steps := make([]*PolicyStep, 0, len(group))
+		for _, a := range group {
+			c, ok := s.commandToRun(command, a, options)
+			if !ok {
+				continue
+			}
+			steps = append(steps, &PolicyStep{Module: a.Name(), Cmd: c.Cmd, Args: c.Args})
+		}
+		if len(steps) > 0 {
+			input.Plan = append(input.Plan, steps)
+		}
+	}
+
+	return input, nil
+}
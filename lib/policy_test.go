@@ -0,0 +1,212 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+// initPolicyRepo creates a repository with two modules, a policy
+// directory and an opa in .tmp/bin writing its input to .tmp/opa.json
+// and printing the specified result of the evaluation.
+func initPolicyRepo(t *testing.T, result string) *TestRepository {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:     "app-a",
+		Owners:   []string{"team-a"},
+		Build:    map[string]*Cmd{"linux": {Cmd: "./build.sh"}, "darwin": {Cmd: "./build.sh"}},
+		Commands: map[string]*UserCmd{"deploy": {Cmd: "./deploy.sh"}},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo built app-a"))
+	check(t, repo.WriteShellScript("app-a/deploy.sh", "echo deployed app-a"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:         "app-b",
+		Dependencies: []string{"app-a"},
+		Build:        map[string]*Cmd{"linux": {Cmd: "./build.sh"}, "darwin": {Cmd: "./build.sh"}},
+	}))
+	check(t, repo.WriteShellScript("app-b/build.sh", "echo built app-b"))
+	check(t, repo.WriteContent(".mbt/policies/owners.rego", "package mbt\n"))
+	check(t, repo.Commit("first"))
+
+	dir, err := filepath.Abs(".tmp")
+	check(t, err)
+	check(t, ioutil.WriteFile(filepath.Join(dir, "opa-result.json"), []byte(result), 0644))
+	opa := fmt.Sprintf("#!/bin/sh\necho \"$@\" > %s/opa-args\ncat > %s/opa.json\ncat %s/opa-result.json\n", dir, dir, dir)
+	check(t, os.MkdirAll(filepath.Join(dir, "bin"), 0755))
+	check(t, ioutil.WriteFile(filepath.Join(dir, "bin", "opa"), []byte(opa), 0755))
+
+	return repo
+}
+
+func withPolicyPath(t *testing.T) func() {
+	bin, err := filepath.Abs(".tmp/bin")
+	check(t, err)
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+path)
+	return func() { os.Setenv("PATH", path) }
+}
+
+func readPolicyInput(t *testing.T) *PolicyInput {
+	buff, err := ioutil.ReadFile(".tmp/opa.json")
+	check(t, err)
+	input := &PolicyInput{}
+	check(t, json.Unmarshal(buff, input))
+	return input
+}
+
+func TestBuildWithoutPolicyViolations(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	repo := initPolicyRepo(t, `{"result":[{"expressions":[{"value":[]}]}]}`)
+	defer withPolicyPath(t)()
+
+	stdout := new(bytes.Buffer)
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(stdout))
+	check(t, err)
+	assert.Len(t, summary.Completed, 2)
+
+	args, err := ioutil.ReadFile(".tmp/opa-args")
+	check(t, err)
+	dir, err := filepath.Abs(".tmp/repo")
+	check(t, err)
+	assert.Equal(t, "eval --format json --data "+filepath.Join(dir, ".mbt", "policies")+" --stdin-input data.mbt.deny\n", string(args))
+
+	input := readPolicyInput(t)
+	assert.Equal(t, "build", input.Command)
+	assert.Equal(t, repo.LastCommit.String(), input.Commit)
+	assert.NotEmpty(t, input.Weekday)
+	assert.Len(t, input.Modules, 2)
+	assert.Equal(t, "app-a", input.Modules[0].Name)
+	assert.Equal(t, []string{"team-a"}, input.Modules[0].Owners)
+	assert.Equal(t, []string{}, input.Modules[1].Owners)
+	assert.Equal(t, []string{"app-a"}, input.Modules[1].Dependencies)
+	assert.Equal(t, [][]*PolicyStep{
+		{{Module: "app-a", Cmd: "./build.sh", Args: []string{}}},
+		{{Module: "app-b", Cmd: "./build.sh", Args: []string{}}},
+	}, input.Plan)
+}
+
+func TestPolicyViolationsFailTheBuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initPolicyRepo(t, `{"result":[{"expressions":[{"value":["app-b has no owners",{"msg":"no deploys on Fridays"}]}]}]}`)
+	defer withPolicyPath(t)()
+
+	stdout := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(stdout))
+
+	assert.EqualError(t, err, "Policy violation:\napp-b has no owners\nno deploys on Fridays")
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+	assert.Empty(t, stdout.String())
+}
+
+func TestPolicyViolationsFailRunIn(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initPolicyRepo(t, `{"result":[{"expressions":[{"value":["no deploys on Fridays"]}]}]}`)
+	defer withPolicyPath(t)()
+
+	stdout := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.RunInCurrentBranch("deploy", NoFilter, stdTestCmdOptions(stdout))
+	assert.EqualError(t, err, "Policy violation:\nno deploys on Fridays")
+	assert.Empty(t, stdout.String())
+
+	input := readPolicyInput(t)
+	assert.Equal(t, "run-in", input.Command)
+	assert.Equal(t, "deploy", input.UserCommand)
+	assert.Equal(t, [][]*PolicyStep{{{Module: "app-a", Cmd: "./deploy.sh", Args: []string{}}}}, input.Plan)
+}
+
+func TestUndefinedPolicyQuery(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initPolicyRepo(t, `{}`)
+	defer withPolicyPath(t)()
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+	check(t, err)
+}
+
+func TestPolicyQueryFromConfig(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	repo := initPolicyRepo(t, `{"result":[{"expressions":[{"value":true}]}]}`)
+	check(t, repo.WriteConfig(&RepoConfig{Policies: &PolicyConfig{Dir: "governance", Query: "data.acme.allow"}}))
+	check(t, repo.WriteContent("governance/acme.rego", "package acme\n"))
+	check(t, repo.Commit("config"))
+	defer withPolicyPath(t)()
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+	assert.EqualError(t, err, "Policy query data.acme.allow must evaluate to a set of violations")
+
+	args, err := ioutil.ReadFile(".tmp/opa-args")
+	check(t, err)
+	assert.Contains(t, string(args), filepath.Join("repo", "governance")+" --stdin-input data.acme.allow")
+}
+
+func TestPolicyEvaluationFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initPolicyRepo(t, "")
+	check(t, ioutil.WriteFile(".tmp/bin/opa", []byte("#!/bin/sh\necho rego_parse_error >&2\nexit 2\n"), 0755))
+	defer withPolicyPath(t)()
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+	assert.EqualError(t, err, "Failed to evaluate policies: rego_parse_error")
+}
+
+func TestPoliciesRequireOpa(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initPolicyRepo(t, "")
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", "")
+	defer os.Setenv("PATH", path)
+
+	w := NewWorld(t, ".tmp/repo")
+	m, err := w.System.ManifestByCurrentBranch()
+	check(t, err)
+
+	_, err = w.System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+	assert.EqualError(t, err, fmt.Sprintf(msgPolicyOpaNotFound, filepath.Join(m.Dir, ".mbt", "policies")))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
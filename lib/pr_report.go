@@ -0,0 +1,445 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// PRReportMarker identifies the pull request comment created by mbt
+	// so that it is updated instead of adding a new comment on each push.
+	PRReportMarker = "<!-- mbt-report -->"
+
+	// PRImpactChanged indicates that the content of a module changed.
+	PRImpactChanged = "changed"
+	// PRImpactDependency indicates that a module is affected because
+	// one of its dependencies changed.
+	PRImpactDependency = "dependency"
+
+	defaultGitHubAPIURL = "https://api.github.com"
+	apiTimeout          = 10 * time.Second
+)
+
+// PRReport summarises the impact of the changes in a pull request.
+type PRReport struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Total is the number of modules in the head revision.
+	Total   int               `json:"total"`
+	Modules []*PRReportModule `json:"modules"`
+	// Removed is the list of modules in the merge base not found in
+	// the head revision.
+	Removed []string `json:"removed,omitempty"`
+	// Summary is the outcome of the build of the affected modules if
+	// it is available.
+	Summary *InvocationSummary `json:"summary,omitempty"`
+	// issueURL is the url of the issues in markdown output.
+	issueURL string
+}
+
+// PRReportModule is a module affected by the changes in a pull request.
+type PRReportModule struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// PreviousVersion is the version in the merge base. Empty for
+	// new modules.
+	PreviousVersion string `json:"previousVersion,omitempty"`
+	// Impact is either changed or dependency.
+	Impact string `json:"impact"`
+	// Dependents is the number of other modules affected because they
+	// depend on this one.
+	Dependents int `json:"dependents"`
+	// Build is the outcome of the module in Summary if any.
+	Build *ModuleSummary `json:"build,omitempty"`
+	// Issues are the keys of the issues referenced by the commits
+	// affecting the module.
+	Issues []string `json:"issues,omitempty"`
+}
+
+// GitHubCommentOptions specifies the pull request to comment on.
+// Unspecified options are read from the environment of GitHub Actions.
+type GitHubCommentOptions struct {
+	// APIURL defaults to $GITHUB_API_URL or https://api.github.com.
+	APIURL string
+	// Repository in owner/name format. Defaults to $GITHUB_REPOSITORY.
+	Repository string
+	// PullRequest number. Defaults to the pull request in the event
+	// payload at $GITHUB_EVENT_PATH.
+	PullRequest int
+	// Token defaults to $GITHUB_TOKEN.
+	Token string
+}
+
+type githubComment struct {
+	ID   int64  `json:"id,omitempty"`
+	Body string `json:"body"`
+}
+
+func (s *stdSystem) PRReport(base, head string, summary *InvocationSummary) (*PRReport, error) {
+	i, err := s.changeImpact(base, head)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := newPRReport(i.from.ID(), i.to.ID(), i.all, i.previous, i.changed, i.affected, summary)
+	if err != nil {
+		return nil, err
+	}
+
+	matcher, err := s.issueMatcher(i.to)
+	if err != nil {
+		return nil, err
+	}
+
+	issues, err := s.issues(matcher, i.from, i.to, i.all)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range report.Modules {
+		m.Issues = issues[m.Name]
+	}
+	report.issueURL = matcher.url
+
+	return report, nil
+}
+
+func newPRReport(from, to string, all, previous, changed, affected Modules, summary *InvocationSummary) (*PRReport, error) {
+	report := &PRReport{From: from, To: to, Total: len(all), Modules: make([]*PRReportModule, 0), Summary: summary}
+
+	previousIndex := previous.indexByName()
+	changedIndex := changed.indexByName()
+	builds := make(map[string]*ModuleSummary)
+	if summary != nil {
+		for _, m := range summary.Modules {
+			builds[m.Name] = m
+		}
+	}
+
+	for _, m := range affected {
+		item := &PRReportModule{Name: m.Name(), Version: m.Version(), Impact: PRImpactDependency, Build: builds[m.Name()]}
+		if _, ok := changedIndex[m.Name()]; ok {
+			item.Impact = PRImpactChanged
+		}
+		if p, ok := previousIndex[m.Name()]; ok {
+			item.PreviousVersion = p.Version()
+		}
+
+		dependents, err := Modules{m}.expandRequiredByDependencies()
+		if err != nil {
+			return nil, err
+		}
+		item.Dependents = len(dependents) - 1
+
+		report.Modules = append(report.Modules, item)
+	}
+
+	allIndex := all.indexByName()
+	for _, m := range previous {
+		if _, ok := allIndex[m.Name()]; !ok {
+			report.Removed = append(report.Removed, m.Name())
+		}
+	}
+	sort.Strings(report.Removed)
+
+	// Modules are listed in the order they are built, which is not
+	// particularly useful to a reviewer. Directly changed modules
+	// come first.
+	sort.SliceStable(report.Modules, func(i, j int) bool {
+		a, b := report.Modules[i], report.Modules[j]
+		if a.Impact != b.Impact {
+			return a.Impact == PRImpactChanged
+		}
+		return a.Name < b.Name
+	})
+
+	return report, nil
+}
+
+// Markdown renders the report as the body of a pull request comment.
+func (r *PRReport) Markdown() string {
+	buff := new(bytes.Buffer)
+	fmt.Fprintln(buff, PRReportMarker)
+	fmt.Fprintf(buff, "### mbt: %v of %v modules affected\n\n", len(r.Modules), r.Total)
+
+	if r.Summary != nil {
+		outcome := ":white_check_mark: Build succeeded"
+		if !r.Summary.Success {
+			outcome = ":x: Build failed"
+		}
+		fmt.Fprintf(buff, "%v (`%v`)\n\n", outcome, r.Summary.Command)
+	}
+
+	if len(r.Modules) > 0 {
+		// Issues column is only displayed when the commits reference
+		// any issues.
+		withIssues := false
+		for _, m := range r.Modules {
+			withIssues = withIssues || len(m.Issues) > 0
+		}
+
+		header, separator := "| Module | Impact | Version | Dependents |", "| --- | --- | --- | --- |"
+		if r.Summary != nil {
+			header, separator = header+" Build |", separator+" --- |"
+		}
+		if withIssues {
+			header, separator = header+" Issues |", separator+" --- |"
+		}
+		fmt.Fprintln(buff, header)
+		fmt.Fprintln(buff, separator)
+
+		for _, m := range r.Modules {
+			fmt.Fprintf(buff, "| `%v` | %v | %v | %v |", m.Name, m.Impact, m.versionChange(), m.Dependents)
+			if r.Summary != nil {
+				fmt.Fprintf(buff, " %v |", m.buildOutcome())
+			}
+			if withIssues {
+				fmt.Fprintf(buff, " %v |", markdownIssues(r.issueURL, m.Issues))
+			}
+			fmt.Fprintln(buff)
+		}
+		fmt.Fprintln(buff)
+	}
+
+	if len(r.Removed) > 0 {
+		names := make([]string, 0, len(r.Removed))
+		for _, n := range r.Removed {
+			names = append(names, "`"+n+"`")
+		}
+		fmt.Fprintf(buff, "Removed: %v\n\n", strings.Join(names, ", "))
+	}
+
+	fmt.Fprintf(buff, "<sub>Changes between %v and %v</sub>\n", shortSha(r.From), shortSha(r.To))
+	return buff.String()
+}
+
+func (m *PRReportModule) versionChange() string {
+	if m.PreviousVersion == "" {
+		return fmt.Sprintf("new `%v`", shortSha(m.Version))
+	}
+	return fmt.Sprintf("`%v` → `%v`", shortSha(m.PreviousVersion), shortSha(m.Version))
+}
+
+func (m *PRReportModule) buildOutcome() string {
+	if m.Build == nil {
+		return "-"
+	}
+
+	switch m.Build.Status {
+	case ModuleStatusSucceeded:
+		return fmt.Sprintf(":white_check_mark: %.1fs", m.Build.Duration)
+	case ModuleStatusFailed:
+		return fmt.Sprintf(":x: %.1fs", m.Build.Duration)
+	case ModuleStatusResumed:
+		return ":fast_forward: resumed"
+	case ModuleStatusSatisfied:
+		return ":fast_forward: satisfied"
+	case ModuleStatusUnaffected:
+		return ":fast_forward: unaffected"
+	case ModuleStatusCached:
+		return ":fast_forward: cached"
+	case ModuleStatusNotStarted:
+		return ":no_entry_sign: not started"
+	default:
+		return ":heavy_minus_sign: " + m.Build.Status
+	}
+}
+
+// PostGitHubComment posts the report to a pull request. The comment
+// posted earlier by mbt is updated if there is one, so that the pull
+// request always has a single report of the latest changes.
+// URL of the comment is returned.
+func (r *PRReport) PostGitHubComment(options *GitHubCommentOptions) (string, error) {
+	options, err := options.withDefaults()
+	if err != nil {
+		return "", err
+	}
+
+	existing, err := options.findComment()
+	if err != nil {
+		return "", e.Wrapf(ErrClassUser, err, msgFailedPostGitHubComment, options.PullRequest)
+	}
+
+	method := http.MethodPost
+	u := fmt.Sprintf("%v/repos/%v/issues/%v/comments", options.APIURL, options.Repository, options.PullRequest)
+	if existing != 0 {
+		method = http.MethodPatch
+		u = fmt.Sprintf("%v/repos/%v/issues/comments/%v", options.APIURL, options.Repository, existing)
+	}
+
+	var posted struct {
+		HTMLURL string `json:"html_url"`
+	}
+	err = options.call(method, u, &githubComment{Body: r.Markdown()}, &posted)
+	if err != nil {
+		return "", e.Wrapf(ErrClassUser, err, msgFailedPostGitHubComment, options.PullRequest)
+	}
+
+	return posted.HTMLURL, nil
+}
+
+func (o *GitHubCommentOptions) withDefaults() (*GitHubCommentOptions, error) {
+	r := *o
+	if r.APIURL == "" {
+		r.APIURL = os.Getenv("GITHUB_API_URL")
+	}
+	if r.APIURL == "" {
+		r.APIURL = defaultGitHubAPIURL
+	}
+	r.APIURL = strings.TrimSuffix(r.APIURL, "/")
+
+	if r.Repository == "" {
+		r.Repository = os.Getenv("GITHUB_REPOSITORY")
+	}
+	if r.Repository == "" {
+		return nil, e.NewErrorf(ErrClassUser, msgGitHubCommentOptionMissing, "repository", "GITHUB_REPOSITORY")
+	}
+
+	if r.Token == "" {
+		r.Token = os.Getenv("GITHUB_TOKEN")
+	}
+	if r.Token == "" {
+		return nil, e.NewErrorf(ErrClassUser, msgGitHubCommentOptionMissing, "token", "GITHUB_TOKEN")
+	}
+
+	if r.PullRequest == 0 {
+		n, err := pullRequestFromEvent(os.Getenv("GITHUB_EVENT_PATH"))
+		if err != nil {
+			return nil, err
+		}
+		r.PullRequest = n
+	}
+	if r.PullRequest == 0 {
+		return nil, e.NewErrorf(ErrClassUser, msgGitHubCommentOptionMissing, "pull request", "GITHUB_EVENT_PATH")
+	}
+
+	return &r, nil
+}
+
+// pullRequestFromEvent reads the pull request number from the payload
+// of the event triggering a GitHub Actions workflow.
+// 0 is returned if the event is not related to a pull request.
+func pullRequestFromEvent(path string) (int, error) {
+	if path == "" {
+		return 0, nil
+	}
+
+	buff, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, e.Wrapf(ErrClassUser, err, msgFailedReadGitHubEvent, path)
+	}
+
+	var event struct {
+		Number      int `json:"number"`
+		PullRequest struct {
+			Number int `json:"number"`
+		} `json:"pull_request"`
+	}
+	err = json.Unmarshal(buff, &event)
+	if err != nil {
+		return 0, e.Wrapf(ErrClassUser, err, msgFailedReadGitHubEvent, path)
+	}
+
+	if event.PullRequest.Number != 0 {
+		return event.PullRequest.Number, nil
+	}
+	return event.Number, nil
+}
+
+// findComment returns the id of the comment posted by mbt or 0 if
+// there is none.
+func (o *GitHubCommentOptions) findComment() (int64, error) {
+	for page := 1; ; page++ {
+		u := fmt.Sprintf("%v/repos/%v/issues/%v/comments?per_page=100&page=%v", o.APIURL, o.Repository, o.PullRequest, page)
+		comments := make([]*githubComment, 0)
+		err := o.call(http.MethodGet, u, nil, &comments)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, c := range comments {
+			if strings.HasPrefix(c.Body, PRReportMarker) {
+				return c.ID, nil
+			}
+		}
+
+		if len(comments) < 100 {
+			return 0, nil
+		}
+	}
+}
+
+func (o *GitHubCommentOptions) call(method, u string, in, out interface{}) error {
+	return callJSONAPI(method, u, githubHeaders(o.Token), in, out)
+}
+
+func githubHeaders(token string) map[string]string {
+	return map[string]string{
+		"Accept":        "application/vnd.github+json",
+		"Authorization": "Bearer " + token,
+	}
+}
+
+// callJSONAPI sends in (if not nil) in json format and decodes the
+// json response into out.
+func callJSONAPI(method, u string, headers map[string]string, in, out interface{}) error {
+	body := bytes.NewReader(nil)
+	if in != nil {
+		buff, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(buff)
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := (&http.Client{Timeout: apiTimeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected response %s", res.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func initPRReportRepo(t *testing.T) *TestRepository {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("lib-a"))
+	check(t, repo.InitModuleWithOptions("svc-a", &Spec{Name: "svc-a", Dependencies: []string{"lib-a"}}))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.InitModule("app-c"))
+	check(t, repo.Commit("first"))
+
+	check(t, repo.SwitchToBranch("feature"))
+	check(t, repo.WriteContent("lib-a/file", "b"))
+	check(t, repo.InitModule("app-d"))
+	check(t, repo.Remove("app-c"))
+	check(t, repo.Commit("second"))
+
+	return repo
+}
+
+func TestPRReport(t *testing.T) {
+	initPRReportRepo(t)
+
+	report, err := NewWorld(t, ".tmp/repo").System.PRReport("master", "feature", nil)
+	check(t, err)
+
+	assert.Equal(t, 4, report.Total)
+	assert.Equal(t, []string{"app-c"}, report.Removed)
+	assert.Len(t, report.Modules, 3)
+
+	appD, libA, svcA := report.Modules[0], report.Modules[1], report.Modules[2]
+	assert.Equal(t, "app-d", appD.Name)
+	assert.Equal(t, PRImpactChanged, appD.Impact)
+	assert.Empty(t, appD.PreviousVersion)
+
+	assert.Equal(t, "lib-a", libA.Name)
+	assert.Equal(t, PRImpactChanged, libA.Impact)
+	assert.NotEmpty(t, libA.PreviousVersion)
+	assert.NotEqual(t, libA.PreviousVersion, libA.Version)
+	assert.Equal(t, 1, libA.Dependents)
+
+	assert.Equal(t, "svc-a", svcA.Name)
+	assert.Equal(t, PRImpactDependency, svcA.Impact)
+	assert.Equal(t, 0, svcA.Dependents)
+}
+
+func TestPRReportMarkdown(t *testing.T) {
+	report := &PRReport{
+		From:  "1111111111",
+		To:    "2222222222",
+		Total: 3,
+		Modules: []*PRReportModule{
+			{Name: "lib-a", Version: "aaaaaaaaaa", PreviousVersion: "bbbbbbbbbb", Impact: PRImpactChanged, Dependents: 1},
+			{Name: "app-d", Version: "dddddddddd", Impact: PRImpactChanged},
+		},
+		Removed: []string{"app-c"},
+	}
+
+	md := report.Markdown()
+	assert.True(t, strings.HasPrefix(md, PRReportMarker))
+	assert.Contains(t, md, "2 of 3 modules affected")
+	assert.Contains(t, md, "| `lib-a` | changed | `bbbbbbb` → `aaaaaaa` | 1 |\n")
+	assert.Contains(t, md, "| `app-d` | changed | new `ddddddd` | 0 |\n")
+	assert.Contains(t, md, "Removed: `app-c`")
+	assert.Contains(t, md, "Changes between 1111111 and 2222222")
+	assert.NotContains(t, md, "Build")
+}
+
+func TestPRReportMarkdownWithSummary(t *testing.T) {
+	failed := &ModuleSummary{Name: "app-d", Status: ModuleStatusFailed, Duration: 2.25}
+	report := &PRReport{
+		Total: 2,
+		Modules: []*PRReportModule{
+			{Name: "lib-a", Version: "a", PreviousVersion: "b", Impact: PRImpactChanged, Build: &ModuleSummary{Name: "lib-a", Status: ModuleStatusSucceeded, Duration: 1}},
+			{Name: "app-d", Version: "d", Impact: PRImpactChanged, Build: failed},
+		},
+		Summary: &InvocationSummary{Command: "build", Modules: []*ModuleSummary{failed}},
+	}
+
+	md := report.Markdown()
+	assert.Contains(t, md, ":x: Build failed (`build`)")
+	assert.Contains(t, md, "| Module | Impact | Version | Dependents | Build |")
+	assert.Contains(t, md, "| :white_check_mark: 1.0s |\n")
+	assert.Contains(t, md, "| :x: 2.2s |\n")
+}
+
+func TestPRReportWithSummary(t *testing.T) {
+	initPRReportRepo(t)
+
+	summary := &InvocationSummary{Command: "build", Success: true, Modules: []*ModuleSummary{
+		{Name: "lib-a", Status: ModuleStatusSucceeded},
+	}}
+
+	report, err := NewWorld(t, ".tmp/repo").System.PRReport("master", "feature", summary)
+	check(t, err)
+
+	assert.Equal(t, summary, report.Summary)
+	assert.Equal(t, summary.Modules[0], report.Modules[1].Build)
+	assert.Nil(t, report.Modules[0].Build)
+}
+
+func TestReadInvocationSummary(t *testing.T) {
+	clean()
+	check(t, os.MkdirAll(".tmp", 0755))
+	p := filepath.Join(".tmp", "summary.json")
+
+	check(t, WriteInvocationSummary(p, &InvocationSummary{Command: "build", Success: true}))
+	summary, err := ReadInvocationSummary(p)
+	check(t, err)
+	assert.Equal(t, "build", summary.Command)
+	assert.True(t, summary.Success)
+
+	_, err = ReadInvocationSummary(filepath.Join(".tmp", "missing.json"))
+	assert.Error(t, err)
+}
+
+type fakeGitHub struct {
+	comments []*githubComment
+	methods  []string
+	auth     string
+}
+
+func startFakeGitHub(t *testing.T, comments ...*githubComment) (*httptest.Server, *fakeGitHub) {
+	gh := &fakeGitHub{comments: comments}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gh.methods = append(gh.methods, r.Method+" "+r.URL.Path)
+		gh.auth = r.Header.Get("Authorization")
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/acme/mono/issues/7/comments":
+			if r.URL.Query().Get("page") != "1" {
+				w.Write([]byte("[]"))
+				return
+			}
+			json.NewEncoder(w).Encode(gh.comments)
+		case r.Method == http.MethodPost && r.URL.Path == "/repos/acme/mono/issues/7/comments":
+			c := &githubComment{}
+			json.NewDecoder(r.Body).Decode(c)
+			c.ID = 99
+			gh.comments = append(gh.comments, c)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"html_url": "https://github.com/acme/mono/pull/7#issuecomment-99"}`))
+		case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/repos/acme/mono/issues/comments/"):
+			c := &githubComment{}
+			json.NewDecoder(r.Body).Decode(c)
+			gh.comments[len(gh.comments)-1].Body = c.Body
+			w.Write([]byte(`{"html_url": "https://github.com/acme/mono/pull/7#issuecomment-2"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return server, gh
+}
+
+func TestPostGitHubComment(t *testing.T) {
+	server, gh := startFakeGitHub(t, &githubComment{ID: 1, Body: "lgtm"})
+	defer server.Close()
+
+	report := &PRReport{Total: 1}
+	u, err := report.PostGitHubComment(&GitHubCommentOptions{APIURL: server.URL + "/", Repository: "acme/mono", PullRequest: 7, Token: "t0ken"})
+	check(t, err)
+
+	assert.Equal(t, "https://github.com/acme/mono/pull/7#issuecomment-99", u)
+	assert.Equal(t, []string{"GET /repos/acme/mono/issues/7/comments", "POST /repos/acme/mono/issues/7/comments"}, gh.methods)
+	assert.Equal(t, "Bearer t0ken", gh.auth)
+	assert.Len(t, gh.comments, 2)
+	assert.Equal(t, report.Markdown(), gh.comments[1].Body)
+}
+
+func TestPostGitHubCommentUpdatesExistingComment(t *testing.T) {
+	server, gh := startFakeGitHub(t, &githubComment{ID: 1, Body: "lgtm"}, &githubComment{ID: 2, Body: PRReportMarker + "\nold"})
+	defer server.Close()
+
+	report := &PRReport{Total: 5}
+	u, err := report.PostGitHubComment(&GitHubCommentOptions{APIURL: server.URL, Repository: "acme/mono", PullRequest: 7, Token: "t0ken"})
+	check(t, err)
+
+	assert.Equal(t, "https://github.com/acme/mono/pull/7#issuecomment-2", u)
+	assert.Equal(t, []string{"GET /repos/acme/mono/issues/7/comments", "PATCH /repos/acme/mono/issues/comments/2"}, gh.methods)
+	assert.Len(t, gh.comments, 2)
+	assert.Contains(t, gh.comments[1].Body, "0 of 5 modules affected")
+}
+
+func TestPostGitHubCommentFromEnvironment(t *testing.T) {
+	server, gh := startFakeGitHub(t)
+	defer server.Close()
+
+	clean()
+	check(t, os.MkdirAll(".tmp", 0755))
+	event := filepath.Join(".tmp", "event.json")
+	check(t, ioutil.WriteFile(event, []byte(`{"pull_request": {"number": 7}}`), 0644))
+
+	for k, v := range map[string]string{
+		"GITHUB_API_URL":    server.URL,
+		"GITHUB_REPOSITORY": "acme/mono",
+		"GITHUB_EVENT_PATH": event,
+		"GITHUB_TOKEN":      "env-t0ken",
+	} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	_, err := (&PRReport{}).PostGitHubComment(&GitHubCommentOptions{})
+	check(t, err)
+	assert.Equal(t, "Bearer env-t0ken", gh.auth)
+	assert.Len(t, gh.comments, 1)
+}
+
+func TestPostGitHubCommentWithoutToken(t *testing.T) {
+	os.Unsetenv("GITHUB_TOKEN")
+
+	_, err := (&PRReport{}).PostGitHubComment(&GitHubCommentOptions{Repository: "acme/mono", PullRequest: 7})
+	assert.EqualError(t, err, "Could not determine the token of the comment, specify it or set GITHUB_TOKEN")
+}
+
+func TestPostGitHubCommentFailure(t *testing.T) {
+	server, _ := startFakeGitHub(t)
+	defer server.Close()
+
+	_, err := (&PRReport{}).PostGitHubComment(&GitHubCommentOptions{APIURL: server.URL, Repository: "acme/other", PullRequest: 7, Token: "t0ken"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "pull request #7")
+}
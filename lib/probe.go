@@ -0,0 +1,134 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const probeTimeout = 10 * time.Second
+
+// Probe checks whether the artifact of a module version already exists
+// (e.g. an image in a registry), so that the module is not built again.
+// URL, headers, command and arguments are templates with the same data
+// as build commands (see CmdTemplateData).
+type Probe struct {
+	// URL is requested with Method and the artifact exists if the
+	// response is successful.
+	URL string `yaml:"url,omitempty"`
+	// Method of the request. Defaults to HEAD.
+	Method  string            `yaml:"method,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Cmd is executed when URL is not specified and the artifact exists
+	// if it exits with status 0.
+	Cmd  string   `yaml:"cmd,omitempty"`
+	Args []string `yaml:"args,omitempty,flow"`
+	// Dir is the working directory of the command relative to the
+	// module directory. Defaults to the module directory.
+	Dir string `yaml:"dir,omitempty"`
+	// Shell used to interpret Cmd (see Cmd.Shell).
+	Shell string `yaml:"shell,omitempty"`
+}
+
+// probe returns true if the probe of a module finds its artifact.
+// Module is built if the probe cannot be completed, therefore failures
+// are logged rather than failing the build.
+func (s *stdSystem) probe(config *RepoConfig, m *Manifest, a *Module, options *CmdOptions) (bool, error) {
+	p := a.Probe()
+	if p == nil || options.IgnoreProbes {
+		return false, nil
+	}
+
+	options, err := withModuleEnvironment(config, a, options)
+	if err != nil {
+		return false, err
+	}
+	options = withReleaseOptions(options, a)
+
+	if p.URL != "" {
+		found, err := s.probeURL(m, a, options, p)
+		if err != nil {
+			s.Log.Warnf(msgFailedProbe, a.Name(), err)
+		}
+		return found, nil
+	}
+
+	// Output of the command is not relevant to the build.
+	o := *options
+	o.Stdout = ioutil.Discard
+	o.Stderr = ioutil.Discard
+	o.Stdin = nil
+	err = s.execSpecCmd(m, a, &o, p.Shell, p.Dir, p.Cmd, p.Args)
+	if err != nil {
+		s.Log.Debug("Probe of module %v did not find the artifact: %v", a.Name(), err)
+	}
+	return err == nil, nil
+}
+
+// probeURL requests the url of a probe. Responses other than successful
+// ones and 404 are reported as errors.
+func (s *stdSystem) probeURL(m *Manifest, a *Module, options *CmdOptions, p *Probe) (bool, error) {
+	// Header values are expanded along with the url, in the order
+	// of their names.
+	names := make([]string, 0, len(p.Headers))
+	values := make([]string, 0, len(p.Headers))
+	for k := range p.Headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		values = append(values, p.Headers[k])
+	}
+
+	u, values, err := expandCommand(m, a, options, p.URL, values)
+	if err != nil {
+		return false, err
+	}
+
+	method := p.Method
+	if method == "" {
+		method = http.MethodHead
+	}
+
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return false, err
+	}
+
+	for i, k := range names {
+		req.Header.Set(k, values[i])
+	}
+
+	res, err := (&http.Client{Timeout: probeTimeout}).Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode/100 == 2:
+		return true, nil
+	case res.StatusCode == http.StatusNotFound:
+		return false, nil
+	default:
+		return false, e.NewErrorf(ErrClassUser, msgUnexpectedProbeResponse, u, res.Status)
+	}
+}
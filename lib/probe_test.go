@@ -0,0 +1,159 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// initProbedRepo creates a repository where app-a and app-b are probed
+// with the specified probe.
+func initProbedRepo(t *testing.T, probe *Probe) {
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	for _, n := range []string{"app-a", "app-b"} {
+		check(t, repo.InitModuleWithOptions(n, &Spec{
+			Name: n,
+			Build: map[string]*Cmd{
+				"darwin": {Cmd: "./build.sh"},
+				"linux":  {Cmd: "./build.sh"},
+			},
+			Probe:     probe,
+			Templates: true,
+		}))
+		check(t, repo.WriteShellScript(n+"/build.sh", "echo built "+n))
+	}
+	check(t, repo.Commit("first"))
+}
+
+// startProbeServer starts a server where the artifacts of app-a exist.
+func startProbeServer(t *testing.T, status int) (*httptest.Server, *[]*http.Request) {
+	requests := make([]*http.Request, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if status != 0 {
+			w.WriteHeader(status)
+		} else if strings.HasPrefix(r.URL.Path, "/app-a/") {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server, &requests
+}
+
+func TestBuildSkipsModulesWithExistingArtifacts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	server, requests := startProbeServer(t, 0)
+	defer server.Close()
+
+	initProbedRepo(t, &Probe{
+		URL:     server.URL + "/{{.Module.Name}}/{{.Module.Version}}",
+		Headers: map[string]string{"Authorization": "Bearer {{.Env.TOKEN}}"},
+	})
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Env = []string{"TOKEN=secret"}
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "built app-b\n", buff.String())
+	assert.Len(t, summary.Completed, 2)
+	assert.True(t, summary.Completed[0].Satisfied)
+	assert.False(t, summary.Completed[1].Satisfied)
+	assert.Equal(t, ModuleStatusSatisfied, summary.InvocationSummary(nil).Modules[0].Status)
+
+	assert.Len(t, *requests, 2)
+	r := (*requests)[0]
+	assert.Equal(t, http.MethodHead, r.Method)
+	assert.Equal(t, "/app-a/"+summary.Manifest.Modules[0].Version(), r.URL.Path)
+	assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+}
+
+func TestBuildIgnoringProbes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	server, requests := startProbeServer(t, 0)
+	defer server.Close()
+
+	initProbedRepo(t, &Probe{URL: server.URL + "/{{.Module.Name}}"})
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.IgnoreProbes = true
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "built app-a\nbuilt app-b\n", buff.String())
+	assert.False(t, summary.Completed[0].Satisfied)
+	assert.Empty(t, *requests)
+}
+
+func TestBuildWhenProbeFails(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	server, _ := startProbeServer(t, http.StatusInternalServerError)
+	defer server.Close()
+
+	initProbedRepo(t, &Probe{URL: server.URL + "/{{.Module.Name}}", Method: http.MethodGet})
+
+	buff := new(bytes.Buffer)
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+	check(t, err)
+
+	assert.Equal(t, "built app-a\nbuilt app-b\n", buff.String())
+	assert.False(t, summary.Completed[0].Satisfied)
+}
+
+func TestBuildWithCommandProbe(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	initProbedRepo(t, &Probe{Cmd: "echo probed && test -f $MBT_REPO_PATH/../{{.Module.Name}}", Shell: "sh"})
+
+	f, err := os.Create(".tmp/app-b")
+	check(t, err)
+	f.Close()
+
+	buff := new(bytes.Buffer)
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+	check(t, err)
+
+	assert.Equal(t, "built app-a\n", buff.String())
+	assert.False(t, summary.Completed[0].Satisfied)
+	assert.True(t, summary.Completed[1].Satisfied)
+}
@@ -16,11 +16,22 @@ limitations under the License.
 package lib
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
+)
+
+const (
+	defaultContainerRuntime = "docker"
+	// containerRepoPath is where the repository is mounted inside
+	// the container.
+	containerRepoPath = "/mbt/repo"
 )
 
 type stdProcessManager struct {
@@ -28,17 +39,139 @@ type stdProcessManager struct {
 }
 
 func (p *stdProcessManager) Exec(manifest *Manifest, module *Module, options *CmdOptions, command string, args ...string) error {
-	cmd := exec.Command(command)
-	cmd.Env = append(os.Environ(), p.setupModBuildEnvironment(manifest, module)...)
-	cmd.Dir = path.Join(manifest.Dir, module.Path())
+	var cmd *exec.Cmd
+	dir := manifest.Dir
+	if module != nil && module.Image() != "" {
+		cmd = p.containerCommand(manifest, module, options, command, args...)
+	} else {
+		cmd = exec.Command(command)
+		cmd.Env = append(os.Environ(), buildEnvironment(manifest, module)...)
+		cmd.Env = append(cmd.Env, options.Env...)
+		cmd.Args = append(cmd.Args, args...)
+	}
+	if module != nil {
+		dir = filepath.Join(dir, module.Path())
+	}
+	cmd.Dir = filepath.Join(dir, options.WorkingDir)
 	cmd.Stdin = options.Stdin
 	cmd.Stdout = options.Stdout
 	cmd.Stderr = options.Stderr
-	cmd.Args = append(cmd.Args, args...)
-	return cmd.Run()
+	var redactors []*redactingWriter
+	if len(options.Secrets) > 0 {
+		// Same writer is used for both streams if they are the same,
+		// so that the order of output is retained.
+		sameStreams := cmd.Stdout == cmd.Stderr
+		if cmd.Stdout != nil {
+			stdout := &redactingWriter{w: cmd.Stdout, secrets: options.Secrets}
+			cmd.Stdout = stdout
+			redactors = append(redactors, stdout)
+		}
+		if sameStreams {
+			cmd.Stderr = cmd.Stdout
+		} else if cmd.Stderr != nil {
+			stderr := &redactingWriter{w: cmd.Stderr, secrets: options.Secrets}
+			cmd.Stderr = stderr
+			redactors = append(redactors, stderr)
+		}
+	}
+
+	err := run(options.Context, cmd)
+	for _, r := range redactors {
+		if cerr := r.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// terminationGracePeriod is the time given to the processes of a
+// command to exit after they are asked to terminate.
+const terminationGracePeriod = 10 * time.Second
+
+// run executes a command in its own process group.
+// When the context is done, the processes in the group are asked to
+// terminate and they are killed if they do not exit within the
+// termination grace period.
+func run(ctx context.Context, cmd *exec.Cmd) error {
+	if ctx == nil {
+		return cmd.Run()
+	}
+
+	startProcessGroup(cmd)
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			terminateProcessGroup(cmd)
+			select {
+			case <-exited:
+			case <-time.After(terminationGracePeriod):
+			}
+			// Processes that left the group running are not orphaned.
+			killProcessGroup(cmd)
+		case <-exited:
+		}
+	}()
+
+	err = cmd.Wait()
+	close(exited)
+	return err
 }
 
-func (p *stdProcessManager) setupModBuildEnvironment(manifest *Manifest, mod *Module) []string {
+// containerCommand creates a command to execute the specified command
+// in a container created from the module image.
+// Repository is mounted at containerRepoPath and the build environment
+// is initialised relative to that path.
+// Environment variables are passed to the container by name and their
+// values are set in the environment of the container runtime, so that
+// secrets are not visible in its arguments.
+// Exit status of the container runtime is the exit status of the
+// command, therefore no special handling is required to propagate it.
+func (p *stdProcessManager) containerCommand(manifest *Manifest, module *Module, options *CmdOptions, command string, args ...string) *exec.Cmd {
+	runtime := options.ContainerRuntime
+	if runtime == "" {
+		runtime = defaultContainerRuntime
+	}
+
+	containerManifest := &Manifest{Dir: containerRepoPath, Sha: manifest.Sha, Modules: manifest.Modules}
+
+	runArgs := []string{"run", "--rm"}
+	if options.Stdin != nil {
+		runArgs = append(runArgs, "-i")
+	}
+	runArgs = append(runArgs,
+		"-v", fmt.Sprintf("%s:%s", filepath.ToSlash(manifest.Dir), containerRepoPath),
+		"-w", path.Join(containerRepoPath, module.Path(), filepath.ToSlash(options.WorkingDir)))
+
+	env := append(buildEnvironment(containerManifest, module), options.Env...)
+	for _, v := range env {
+		runArgs = append(runArgs, "-e", strings.SplitN(v, "=", 2)[0])
+	}
+
+	runArgs = append(runArgs, module.Image(), command)
+	runArgs = append(runArgs, args...)
+
+	p.Log.Debug("Executing %s %v", runtime, runArgs)
+	cmd := exec.Command(runtime, runArgs...)
+	cmd.Env = append(os.Environ(), env...)
+	return cmd
+}
+
+// buildEnvironment returns the environment variables initialised by
+// mbt for the commands executed in the context of a module.
+func buildEnvironment(manifest *Manifest, mod *Module) []string {
+	if mod == nil {
+		return []string{
+			fmt.Sprintf("MBT_BUILD_COMMIT=%s", manifest.Sha),
+			fmt.Sprintf("MBT_REPO_PATH=%s", manifest.Dir),
+		}
+	}
+
 	r := []string{
 		fmt.Sprintf("MBT_BUILD_COMMIT=%s", manifest.Sha),
 		fmt.Sprintf("MBT_MODULE_VERSION=%s", mod.Version()),
@@ -47,8 +180,15 @@ func (p *stdProcessManager) setupModBuildEnvironment(manifest *Manifest, mod *Mo
 		fmt.Sprintf("MBT_REPO_PATH=%s", manifest.Dir),
 	}
 
-	for k, v := range mod.Properties() {
-		if value, ok := v.(string); ok {
+	properties := mod.Properties()
+	keys := make([]string, 0, len(properties))
+	for k := range properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if value, ok := properties[k].(string); ok {
 			r = append(r, fmt.Sprintf("MBT_MODULE_PROPERTY_%s=%s", strings.ToUpper(k), value))
 		}
 	}
@@ -0,0 +1,57 @@
+//go:build !windows
+
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// startProcessGroup configures the command to start in a new process
+// group so that the processes it spawns are terminated along with it.
+// Commands reading from a terminal remain in the foreground process group
+// where they receive Ctrl-C from the terminal (reading from the terminal
+// in a background process group would stop them).
+func startProcessGroup(cmd *exec.Cmd) {
+	if f, ok := cmd.Stdin.(*os.File); ok && terminal.IsTerminal(int(f.Fd())) {
+		return
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminateProcessGroup asks the processes of a command to exit.
+func terminateProcessGroup(cmd *exec.Cmd) {
+	signalProcessGroup(cmd, syscall.SIGTERM)
+}
+
+// killProcessGroup kills the processes of a command.
+func killProcessGroup(cmd *exec.Cmd) {
+	signalProcessGroup(cmd, syscall.SIGKILL)
+}
+
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) {
+	pid := cmd.Process.Pid
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.Setpgid {
+		pid = -pid
+	}
+	// Processes may have already exited.
+	_ = syscall.Kill(pid, sig)
+}
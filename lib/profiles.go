@@ -0,0 +1,122 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// UserConfigPath returns the path of the user level configuration
+// file i.e. $XDG_CONFIG_HOME/mbt/config.yml or ~/.config/mbt/config.yml.
+// An empty string is returned if home directory cannot be determined.
+func UserConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+
+	return filepath.Join(dir, "mbt", configFile)
+}
+
+// FlagDefaults returns the default values of command line flags keyed
+// by the name of the flag.
+// Defaults are read from the repository configuration in dir and the
+// user configuration file (if it is not empty). Values in the user
+// configuration override the values in the repository configuration
+// and the values in the selected profile override the values in
+// defaults.
+// If profile is empty, profile specified in the configuration (if any)
+// is selected.
+func FlagDefaults(dir, userConfig, profile string) (map[string][]string, error) {
+	repoConfig, err := loadRepoConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := []*RepoConfig{repoConfig}
+	if userConfig != "" {
+		c, err := loadConfigFile(userConfig)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, c)
+	}
+
+	if profile == "" {
+		for _, c := range configs {
+			if c.Profile != "" {
+				profile = c.Profile
+			}
+		}
+	}
+
+	// Profile selected in one file may be declared in the other,
+	// therefore it is looked up in both.
+	found := profile == ""
+	for _, c := range configs {
+		if _, ok := c.Profiles[profile]; ok {
+			found = true
+		}
+	}
+	if !found {
+		return nil, e.NewErrorf(ErrClassUser, msgProfileNotFound, profile)
+	}
+
+	defaults := make(map[string][]string)
+	for _, c := range configs {
+		if err := mergeFlagDefaults(defaults, c.Defaults, "defaults"); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range configs {
+		if err := mergeFlagDefaults(defaults, c.Profiles[profile], profile); err != nil {
+			return nil, err
+		}
+	}
+
+	return defaults, nil
+}
+
+func mergeFlagDefaults(defaults map[string][]string, values map[string]interface{}, source string) error {
+	for name, v := range values {
+		items, ok := v.([]interface{})
+		if !ok {
+			items = []interface{}{v}
+		}
+
+		flagValues := make([]string, 0, len(items))
+		for _, i := range items {
+			switch i.(type) {
+			case string, bool, int, float64:
+				flagValues = append(flagValues, fmt.Sprint(i))
+			default:
+				return e.NewErrorf(ErrClassUser, msgInvalidFlagDefault, name, source)
+			}
+		}
+		defaults[name] = flagValues
+	}
+
+	return nil
+}
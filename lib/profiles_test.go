@@ -0,0 +1,175 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testProfilesConfig = `
+defaults:
+  progress: plain
+profiles:
+  ci:
+    jobs: 8
+    log-format: json
+    env: [A=1, B=2]
+  local:
+    jobs: 2
+`
+
+func writeUserConfig(t *testing.T, content string) string {
+	p := filepath.Join(".tmp", "user", "config.yml")
+	check(t, os.MkdirAll(filepath.Dir(p), 0755))
+	check(t, ioutil.WriteFile(p, []byte(content), 0644))
+	return p
+}
+
+func TestFlagDefaultsOfProfile(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteContent(".mbt/config.yml", testProfilesConfig))
+
+	defaults, err := FlagDefaults(repo.Dir, "", "ci")
+	check(t, err)
+
+	assert.Equal(t, map[string][]string{
+		"progress":   {"plain"},
+		"jobs":       {"8"},
+		"log-format": {"json"},
+		"env":        {"A=1", "B=2"},
+	}, defaults)
+}
+
+func TestFlagDefaultsWithoutProfile(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteContent(".mbt/config.yml", testProfilesConfig))
+
+	defaults, err := FlagDefaults(repo.Dir, "", "")
+	check(t, err)
+
+	assert.Equal(t, map[string][]string{"progress": {"plain"}}, defaults)
+}
+
+func TestFlagDefaultsWithoutConfig(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	defaults, err := FlagDefaults(repo.Dir, filepath.Join(".tmp", "user", "config.yml"), "")
+	check(t, err)
+
+	assert.Empty(t, defaults)
+}
+
+func TestFlagDefaultsOverriddenByUserConfig(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteContent(".mbt/config.yml", testProfilesConfig))
+	user := writeUserConfig(t, `
+defaults:
+  progress: tty
+profiles:
+  ci:
+    jobs: 16
+`)
+
+	defaults, err := FlagDefaults(repo.Dir, user, "ci")
+	check(t, err)
+
+	assert.Equal(t, []string{"tty"}, defaults["progress"])
+	assert.Equal(t, []string{"16"}, defaults["jobs"])
+	assert.Equal(t, []string{"json"}, defaults["log-format"])
+}
+
+func TestProfileSelectedInUserConfig(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteContent(".mbt/config.yml", "profile: ci\n"+testProfilesConfig))
+	user := writeUserConfig(t, "profile: local\n")
+
+	defaults, err := FlagDefaults(repo.Dir, user, "")
+	check(t, err)
+	assert.Equal(t, []string{"2"}, defaults["jobs"])
+
+	defaults, err = FlagDefaults(repo.Dir, "", "")
+	check(t, err)
+	assert.Equal(t, []string{"8"}, defaults["jobs"])
+}
+
+func TestProfileDeclaredInUserConfig(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	user := writeUserConfig(t, `
+profiles:
+  mine:
+    fail-fast: true
+`)
+
+	defaults, err := FlagDefaults(repo.Dir, user, "mine")
+	check(t, err)
+	assert.Equal(t, []string{"true"}, defaults["fail-fast"])
+}
+
+func TestUnknownProfile(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteContent(".mbt/config.yml", testProfilesConfig))
+
+	_, err := FlagDefaults(repo.Dir, "", "staging")
+
+	assert.EqualError(t, err, fmt.Sprintf(msgProfileNotFound, "staging"))
+}
+
+func TestInvalidFlagDefault(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteContent(".mbt/config.yml", `
+profiles:
+  ci:
+    jobs:
+      a: b
+`))
+
+	_, err := FlagDefaults(repo.Dir, "", "ci")
+
+	assert.EqualError(t, err, fmt.Sprintf(msgInvalidFlagDefault, "jobs", "ci"))
+}
+
+func TestInvalidUserConfig(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	user := writeUserConfig(t, "profiles: [")
+
+	_, err := FlagDefaults(repo.Dir, user, "")
+
+	assert.Error(t, err)
+	assert.Equal(t, ExitCodeConfigError, ExitCode(err))
+}
+
+func TestUserConfigPath(t *testing.T) {
+	old := os.Getenv("XDG_CONFIG_HOME")
+	defer os.Setenv("XDG_CONFIG_HOME", old)
+
+	check(t, os.Setenv("XDG_CONFIG_HOME", filepath.Join("a", "b")))
+	assert.Equal(t, filepath.Join("a", "b", "mbt", "config.yml"), UserConfigPath())
+}
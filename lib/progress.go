@@ -0,0 +1,164 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+// Progress is a typed event about the progress of a build or a run of
+// a user defined command. It is one of *RunStarted, *ModuleQueued,
+// *ModuleStarted, *ModuleSkipped, *ModuleFinished and *RunFinished.
+type Progress interface {
+	// At is the time of the event.
+	At() time.Time
+}
+
+// ProgressHandler receives the progress of a build or a run.
+// Unlike EventHandler, it is never invoked concurrently and the
+// progress is delivered in the order it happens. Execution waits
+// for the handler to return.
+type ProgressHandler func(progress Progress)
+
+// RunStarted is delivered before the modules of a manifest are queued.
+// Command is either build or the name of the user defined command.
+type RunStarted struct {
+	Time     time.Time
+	Command  string
+	Manifest *Manifest
+}
+
+// RunFinished is delivered after all modules are built or run.
+type RunFinished struct {
+	Time    time.Time
+	Summary *InvocationSummary
+	Elapsed time.Duration
+}
+
+// ModuleQueued is delivered when a module is queued for execution.
+type ModuleQueued struct {
+	Time   time.Time
+	Module *Module
+}
+
+// ModuleStarted is delivered before executing the command of a module.
+type ModuleStarted struct {
+	Time   time.Time
+	Module *Module
+}
+
+// ModuleSkipped is delivered when a module is skipped because it does
+// not have a command for this platform or the execution was stopped.
+type ModuleSkipped struct {
+	Time   time.Time
+	Module *Module
+}
+
+// ModuleFinished is delivered after executing the command of a module.
+// Err is the error of the command if it failed.
+type ModuleFinished struct {
+	Time    time.Time
+	Module  *Module
+	Elapsed time.Duration
+	Err     error
+}
+
+// At returns the time of the event.
+func (p *RunStarted) At() time.Time { return p.Time }
+
+// At returns the time of the event.
+func (p *RunFinished) At() time.Time { return p.Time }
+
+// At returns the time of the event.
+func (p *ModuleQueued) At() time.Time { return p.Time }
+
+// At returns the time of the event.
+func (p *ModuleStarted) At() time.Time { return p.Time }
+
+// At returns the time of the event.
+func (p *ModuleSkipped) At() time.Time { return p.Time }
+
+// At returns the time of the event.
+func (p *ModuleFinished) At() time.Time { return p.Time }
+
+// moduleProgress creates the progress of a module event.
+func moduleProgress(event *Event, mod *Module, err error) Progress {
+	switch event.Type {
+	case EventModuleQueue:
+		return &ModuleQueued{Time: event.Time, Module: mod}
+	case EventModuleStart:
+		return &ModuleStarted{Time: event.Time, Module: mod}
+	case EventModuleSkip:
+		return &ModuleSkipped{Time: event.Time, Module: mod}
+	case EventModuleFinish:
+		return &ModuleFinished{Time: event.Time, Module: mod, Elapsed: time.Duration(event.Elapsed * float64(time.Second)), Err: err}
+	default:
+		return nil
+	}
+}
+
+// ProgressChannel delivers the progress of a build or a run to a
+// channel (e.g. to be consumed in a separate goroutine).
+//
+//	progress := lib.NewProgressChannel(16)
+//	options.Progress = progress.Handler
+//	go func() {
+//		for p := range progress.C {
+//			...
+//		}
+//	}()
+//	summary, err := system.BuildCurrentBranch(lib.NoFilter, options)
+//	progress.Close()
+//
+// Execution waits when the channel is full, therefore the channel
+// must be received from until it is closed.
+type ProgressChannel struct {
+	// C receives the progress. It is closed by Close.
+	C <-chan Progress
+
+	c      chan Progress
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewProgressChannel creates a ProgressChannel buffering up to size
+// events.
+func NewProgressChannel(size int) *ProgressChannel {
+	c := make(chan Progress, size)
+	return &ProgressChannel{C: c, c: c}
+}
+
+// Handler sends the progress to the channel. It is a ProgressHandler.
+// Progress delivered after Close is discarded.
+func (p *ProgressChannel) Handler(progress Progress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.c <- progress
+	}
+}
+
+// Close closes the channel. It should be called once the build or the
+// run returns.
+func (p *ProgressChannel) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.c)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func progressKinds(progress []Progress) []string {
+	l := make([]string, 0, len(progress))
+	for _, p := range progress {
+		switch v := p.(type) {
+		case *RunStarted:
+			l = append(l, "runStarted:"+v.Command)
+		case *ModuleQueued:
+			l = append(l, "queued:"+v.Module.Name())
+		case *ModuleStarted:
+			l = append(l, "started:"+v.Module.Name())
+		case *ModuleSkipped:
+			l = append(l, "skipped:"+v.Module.Name())
+		case *ModuleFinished:
+			l = append(l, "finished:"+v.Module.Name())
+		case *RunFinished:
+			l = append(l, "runFinished:"+v.Summary.Command)
+		default:
+			l = append(l, fmt.Sprintf("%T", p))
+		}
+	}
+	return l
+}
+
+func progressTestRepo(t *testing.T, script string) {
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", script))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:  "app-b",
+		Build: map[string]*Cmd{"unknown-os": {Cmd: "echo", Args: []string{}}},
+	}))
+	check(t, repo.Commit("first"))
+}
+
+func TestBuildProgress(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	progressTestRepo(t, "echo out")
+
+	buff := new(bytes.Buffer)
+	progress := make([]Progress, 0)
+	options := stdTestCmdOptions(buff)
+	options.Progress = func(p Progress) {
+		progress = append(progress, p)
+	}
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	// Progress does not change how the output is delivered.
+	assert.Equal(t, "out\n", buff.String())
+	assert.Equal(t, []string{
+		"runStarted:build",
+		"queued:app-a",
+		"queued:app-b",
+		"started:app-a",
+		"finished:app-a",
+		"skipped:app-b",
+		"runFinished:build",
+	}, progressKinds(progress))
+
+	assert.Equal(t, summary.Manifest.Sha, progress[0].(*RunStarted).Manifest.Sha)
+	finished := progress[4].(*ModuleFinished)
+	assert.NoError(t, finished.Err)
+	assert.True(t, finished.Elapsed > 0)
+	assert.False(t, finished.At().Before(progress[3].At()))
+	assert.True(t, progress[6].(*RunFinished).Summary.Success)
+}
+
+func TestBuildProgressOfFailedBuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	progressTestRepo(t, "exit 1")
+
+	progress := make([]Progress, 0)
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.Progress = func(p Progress) {
+		progress = append(progress, p)
+	}
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	assert.Error(t, err)
+
+	var finished *ModuleFinished
+	for _, p := range progress {
+		if f, ok := p.(*ModuleFinished); ok {
+			finished = f
+		}
+	}
+	assert.EqualError(t, finished.Err, "Failed to build module 'app-a'")
+	assert.False(t, progress[len(progress)-1].(*RunFinished).Summary.Success)
+}
+
+func TestRunInProgress(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:     "app-a",
+		Commands: map[string]*UserCmd{"echo": {Cmd: "echo", Args: []string{"a"}}},
+	}))
+	check(t, repo.Commit("first"))
+
+	progress := make([]Progress, 0)
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.Progress = func(p Progress) {
+		progress = append(progress, p)
+	}
+	_, err := NewWorld(t, ".tmp/repo").System.RunInCurrentBranch("echo", NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, []string{"runStarted:echo", "queued:app-a", "started:app-a", "finished:app-a", "runFinished:echo"}, progressKinds(progress))
+}
+
+func TestProgressChannel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	progressTestRepo(t, "echo out")
+
+	progress := NewProgressChannel(0)
+	received := make(chan []string)
+	go func() {
+		l := make([]Progress, 0)
+		for p := range progress.C {
+			l = append(l, p)
+		}
+		received <- progressKinds(l)
+	}()
+
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.Progress = progress.Handler
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+	progress.Close()
+
+	kinds := <-received
+	assert.Len(t, kinds, 7)
+	assert.Equal(t, "runFinished:build", kinds[6])
+
+	// Progress after closing the channel is discarded.
+	progress.Handler(&ModuleQueued{})
+	progress.Close()
+}
@@ -0,0 +1,296 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// inTotoStatementType is the type of in-toto statements.
+	inTotoStatementType = "https://in-toto.io/Statement/v1"
+	// SLSAProvenanceType is the predicate type of SLSA provenance.
+	SLSAProvenanceType = "https://slsa.dev/provenance/v1"
+	// MbtBuildType is the build type of the provenance of modules
+	// built by mbt.
+	MbtBuildType = "https://github.com/mbtproject/mbt/build/v1"
+)
+
+// Statement is an in-toto statement.
+type Statement struct {
+	Type          string                `json:"_type"`
+	Subject       []*ResourceDescriptor `json:"subject"`
+	PredicateType string                `json:"predicateType"`
+	Predicate     *Provenance           `json:"predicate"`
+}
+
+// ResourceDescriptor describes a subject or a material of a build.
+type ResourceDescriptor struct {
+	Name        string            `json:"name,omitempty"`
+	URI         string            `json:"uri,omitempty"`
+	Digest      map[string]string `json:"digest,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Provenance is a SLSA v1 provenance predicate.
+type Provenance struct {
+	BuildDefinition *BuildDefinition `json:"buildDefinition"`
+	RunDetails      *RunDetails      `json:"runDetails"`
+}
+
+// BuildDefinition describes the inputs of a build.
+type BuildDefinition struct {
+	BuildType            string                 `json:"buildType"`
+	ExternalParameters   map[string]interface{} `json:"externalParameters"`
+	InternalParameters   map[string]interface{} `json:"internalParameters,omitempty"`
+	ResolvedDependencies []*ResourceDescriptor  `json:"resolvedDependencies"`
+}
+
+// RunDetails describes the execution of a build.
+type RunDetails struct {
+	Builder  *ProvenanceBuilder  `json:"builder"`
+	Metadata *ProvenanceMetadata `json:"metadata"`
+}
+
+// ProvenanceBuilder identifies the platform that executed a build.
+type ProvenanceBuilder struct {
+	ID string `json:"id"`
+}
+
+// ProvenanceMetadata contains the details of a build execution.
+type ProvenanceMetadata struct {
+	InvocationID string    `json:"invocationId,omitempty"`
+	StartedOn    time.Time `json:"startedOn"`
+	FinishedOn   time.Time `json:"finishedOn"`
+}
+
+// provenance creates the provenance of a module built at started.
+// Subjects of the statement are the published artifacts, the outputs
+// of the module or the module itself in that order of preference.
+func (s *stdSystem) provenance(config *RepoConfig, manifest *Manifest, mod *Module, variant *Variant, buildCmd *Cmd, artifacts []*Artifact, options *CmdOptions, started time.Time) (*Statement, error) {
+	subjects, err := provenanceSubjects(manifest, mod, artifacts)
+	if err != nil {
+		return nil, err
+	}
+
+	materials := []*ResourceDescriptor{{
+		URI:    provenanceSourceURI(manifest),
+		Digest: map[string]string{"gitCommit": manifest.Sha},
+	}}
+
+	files, err := s.sbomFiles(manifest, mod)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		materials = append(materials, &ResourceDescriptor{Name: f.path, Digest: map[string]string{"sha1": f.sha1, "sha256": f.sha256}})
+	}
+
+	deps, err := Modules{mod}.expandRequiresDependencies()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range deps {
+		if d.Name() == mod.Name() {
+			continue
+		}
+		materials = append(materials, &ResourceDescriptor{
+			Name:        "mbt:" + d.Name(),
+			Digest:      map[string]string{"gitTree": d.Hash()},
+			Annotations: map[string]string{"version": d.Version()},
+		})
+	}
+
+	external, err := s.externalDependencies(config, manifest, mod)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range external {
+		materials = append(materials, &ResourceDescriptor{Name: d.Name, URI: d.Purl, Annotations: map[string]string{"version": d.Version}})
+	}
+
+	parameters := map[string]interface{}{
+		"module":  mod.Name(),
+		"version": mod.Version(),
+		"command": append([]string{buildCmd.Cmd}, buildCmd.Args...),
+	}
+	if variant != nil {
+		parameters["variant"] = variant.Name
+		parameters["version"] = variant.Version
+	}
+	if options.Environment != "" {
+		parameters["environment"] = options.Environment
+	}
+
+	builder := options.BuilderID
+	if builder == "" {
+		builder = defaultBuilderID()
+	}
+
+	return &Statement{
+		Type:          inTotoStatementType,
+		Subject:       subjects,
+		PredicateType: SLSAProvenanceType,
+		Predicate: &Provenance{
+			BuildDefinition: &BuildDefinition{
+				BuildType:            MbtBuildType,
+				ExternalParameters:   parameters,
+				ResolvedDependencies: materials,
+			},
+			RunDetails: &RunDetails{
+				Builder: &ProvenanceBuilder{ID: builder},
+				Metadata: &ProvenanceMetadata{
+					InvocationID: ciInvocationID(),
+					StartedOn:    started.UTC(),
+					FinishedOn:   time.Now().UTC().Truncate(time.Second),
+				},
+			},
+		},
+	}, nil
+}
+
+func provenanceSubjects(manifest *Manifest, mod *Module, artifacts []*Artifact) ([]*ResourceDescriptor, error) {
+	subjects := make([]*ResourceDescriptor, 0)
+	for _, a := range artifacts {
+		algorithm, digest := "sha256", a.Digest
+		if i := strings.Index(digest, ":"); i >= 0 {
+			algorithm, digest = digest[:i], digest[i+1:]
+		}
+		subjects = append(subjects, &ResourceDescriptor{Name: a.Ref, Digest: map[string]string{algorithm: digest}})
+	}
+	if len(subjects) > 0 {
+		return subjects, nil
+	}
+
+	dir := filepath.Join(manifest.Dir, mod.Path())
+	outputs, err := findFiles(dir, mod.Outputs(), time.Time{})
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedProvenance, mod.Name())
+	}
+	for _, f := range outputs {
+		content, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(f)))
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgFailedProvenance, mod.Name())
+		}
+		h := sha256.Sum256(content)
+		subjects = append(subjects, &ResourceDescriptor{Name: f, Digest: map[string]string{"sha256": hex.EncodeToString(h[:])}})
+	}
+	if len(subjects) > 0 {
+		return subjects, nil
+	}
+
+	// Module at the root of the repository is identified by the
+	// commit instead of a tree.
+	kind := "gitTree"
+	if mod.Path() == "" {
+		kind = "gitCommit"
+	}
+	return []*ResourceDescriptor{{Name: mod.Name(), Digest: map[string]string{kind: mod.Hash()}}}, nil
+}
+
+// provenanceSourceURI returns the uri of the repository from the
+// environment of the CI system or the local path.
+func provenanceSourceURI(manifest *Manifest) string {
+	if server, repo := os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"); server != "" && repo != "" {
+		return fmt.Sprintf("git+%s/%s", server, repo)
+	}
+	if u := os.Getenv("CI_PROJECT_URL"); u != "" {
+		return "git+" + u
+	}
+	return "git+file://" + filepath.ToSlash(manifest.Dir)
+}
+
+// defaultBuilderID identifies the CI system running mbt or the host.
+func defaultBuilderID() string {
+	if server, ref := os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_WORKFLOW_REF"); server != "" && ref != "" {
+		return fmt.Sprintf("%s/%s", server, ref)
+	}
+	if u, runner := os.Getenv("CI_SERVER_URL"), os.Getenv("CI_RUNNER_ID"); u != "" && runner != "" {
+		return fmt.Sprintf("%s/-/runners/%s", u, runner)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return "mbt://" + host
+}
+
+// ciInvocationID returns the url of the CI job running mbt.
+func ciInvocationID() string {
+	if server, repo, run := os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_RUN_ID"); server != "" && run != "" {
+		id := fmt.Sprintf("%s/%s/actions/runs/%s", server, repo, run)
+		if attempt := os.Getenv("GITHUB_RUN_ATTEMPT"); attempt != "" {
+			id = fmt.Sprintf("%s/attempts/%s", id, attempt)
+		}
+		return id
+	}
+	return os.Getenv("CI_JOB_URL")
+}
+
+// writeProvenance writes the provenance of a module to
+// <dir>/<module>[-<variant>].intoto.json and signs it with cosign if
+// sign is set.
+func (s *stdSystem) writeProvenance(statement *Statement, mod *Module, variant *Variant, options *CmdOptions) error {
+	name := mod.Name()
+	if variant != nil {
+		name = name + "-" + variant.Name
+	}
+
+	buff, err := json.MarshalIndent(statement, "", "  ")
+	if err != nil {
+		return e.Wrapf(ErrClassInternal, err, msgFailedProvenance, mod.Name())
+	}
+
+	err = os.MkdirAll(options.ProvenanceDir, 0755)
+	if err != nil {
+		return e.Wrapf(ErrClassUser, err, msgFailedProvenance, mod.Name())
+	}
+
+	p := filepath.Join(options.ProvenanceDir, unsafeFileNameChars.ReplaceAllString(name, "_")+".intoto.json")
+	err = ioutil.WriteFile(p, append(buff, '\n'), 0644)
+	if err != nil {
+		return e.Wrapf(ErrClassUser, err, msgFailedProvenance, mod.Name())
+	}
+
+	if !options.SignProvenance {
+		return nil
+	}
+
+	// cosign signs keyless with the ambient OIDC token of the CI
+	// system (or interactively) and records the signature in the
+	// transparency log.
+	var stderr bytes.Buffer
+	c := exec.Command("cosign", "sign-blob", "--yes", "--bundle", p+".sigstore.json", p)
+	c.Stdout = ioutil.Discard
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		return e.NewErrorf(ErrClassUser, msgFailedSignProvenance, mod.Name(), err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
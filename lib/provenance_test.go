@@ -0,0 +1,215 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func initProvenanceRepo(t *testing.T) *TestRepository {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:                 "app-a",
+		Build:                map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Outputs:              []string{"dist/**"},
+		ExternalDependencies: []*ExternalDependency{{Name: "lodash", Version: "4.17.21", Purl: "pkg:npm/lodash@4.17.21"}},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "mkdir -p dist && echo a > dist/app.txt"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:         "app-b",
+		Build:        map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Dependencies: []string{"app-a"},
+	}))
+	check(t, repo.WriteShellScript("app-b/build.sh", "echo b"))
+	check(t, repo.Commit("first"))
+
+	return repo
+}
+
+func provenanceOptions(t *testing.T) *CmdOptions {
+	dir, err := filepath.Abs(".tmp/provenance")
+	check(t, err)
+
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.ProvenanceDir = dir
+	options.BuilderID = "https://ci.acme.com/builder"
+	return options
+}
+
+func readStatement(t *testing.T, p string) *Statement {
+	buff, err := ioutil.ReadFile(p)
+	check(t, err)
+
+	statement := &Statement{}
+	check(t, json.Unmarshal(buff, statement))
+	return statement
+}
+
+func materialNames(statement *Statement) map[string]*ResourceDescriptor {
+	r := make(map[string]*ResourceDescriptor)
+	for _, m := range statement.Predicate.BuildDefinition.ResolvedDependencies {
+		key := m.Name
+		if key == "" {
+			key = m.URI
+		}
+		r[key] = m
+	}
+	return r
+}
+
+func TestProvenance(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initProvenanceRepo(t)
+	options := provenanceOptions(t)
+
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	appA, appB := summary.Manifest.Modules[0], summary.Manifest.Modules[1]
+	a := readStatement(t, filepath.Join(options.ProvenanceDir, "app-a.intoto.json"))
+	assert.Equal(t, "https://in-toto.io/Statement/v1", a.Type)
+	assert.Equal(t, SLSAProvenanceType, a.PredicateType)
+	assert.Equal(t, []*ResourceDescriptor{{Name: "dist/app.txt", Digest: map[string]string{"sha256": "87428fc522803d31065e7bce3cf03fe475096631e5e07bbd7a0fde60c4cf25c7"}}}, a.Subject)
+	assert.Equal(t, MbtBuildType, a.Predicate.BuildDefinition.BuildType)
+	assert.Equal(t, "app-a", a.Predicate.BuildDefinition.ExternalParameters["module"])
+	assert.Equal(t, appA.Version(), a.Predicate.BuildDefinition.ExternalParameters["version"])
+	assert.Equal(t, "https://ci.acme.com/builder", a.Predicate.RunDetails.Builder.ID)
+	assert.False(t, a.Predicate.RunDetails.Metadata.FinishedOn.Before(a.Predicate.RunDetails.Metadata.StartedOn))
+
+	materials := materialNames(a)
+	assert.Equal(t, map[string]string{"gitCommit": summary.Manifest.Sha}, a.Predicate.BuildDefinition.ResolvedDependencies[0].Digest)
+	assert.Contains(t, materials, "app-a/build.sh")
+	assert.Len(t, materials["app-a/build.sh"].Digest["sha256"], 64)
+	assert.Equal(t, "pkg:npm/lodash@4.17.21", materials["lodash"].URI)
+	assert.NotContains(t, materials, "app-b/build.sh")
+
+	b := readStatement(t, filepath.Join(options.ProvenanceDir, "app-b.intoto.json"))
+	assert.Equal(t, []*ResourceDescriptor{{Name: "app-b", Digest: map[string]string{"gitTree": appB.Hash()}}}, b.Subject)
+	materials = materialNames(b)
+	assert.Equal(t, &ResourceDescriptor{
+		Name:        "mbt:app-a",
+		Digest:      map[string]string{"gitTree": appA.Hash()},
+		Annotations: map[string]string{"version": appA.Version()},
+	}, materials["mbt:app-a"])
+}
+
+func TestProvenanceOfPublishedArtifacts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	server := startUploadServer(t)
+	defer server.server.Close()
+
+	initPublishRepo(t, nil, []*PublishTarget{{HTTP: server.server.URL}})
+	options := provenanceOptions(t)
+	options.Publish = true
+
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	artifact := summary.Completed[0].Artifacts[0]
+	a := readStatement(t, filepath.Join(options.ProvenanceDir, "app-a.intoto.json"))
+	assert.Equal(t, []*ResourceDescriptor{{Name: artifact.Ref, Digest: map[string]string{"sha256": artifact.Digest[len("sha256:"):]}}}, a.Subject)
+}
+
+func TestSignProvenance(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	repo := initProvenanceRepo(t)
+	check(t, repo.WriteShellScript("bin/cosign", `echo "$@" > "$4"`))
+	check(t, repo.Commit("cosign"))
+
+	bin, err := filepath.Abs(".tmp/repo/bin")
+	check(t, err)
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	options := provenanceOptions(t)
+	options.SignProvenance = true
+
+	_, err = NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	p := filepath.Join(options.ProvenanceDir, "app-a.intoto.json")
+	buff, err := ioutil.ReadFile(p + ".sigstore.json")
+	check(t, err)
+	assert.Equal(t, "sign-blob --yes --bundle "+p+".sigstore.json "+p+"\n", string(buff))
+}
+
+func TestSignProvenanceFailureFailsTheBuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	repo := initProvenanceRepo(t)
+	check(t, repo.WriteShellScript("bin/cosign", "echo no token >&2; exit 1"))
+	check(t, repo.Commit("cosign"))
+
+	bin, err := filepath.Abs(".tmp/repo/bin")
+	check(t, err)
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	options := provenanceOptions(t)
+	options.SignProvenance = true
+	options.SummaryFile = ".tmp/summary.json"
+
+	_, err = NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	assert.Error(t, err)
+
+	summary, err := ReadInvocationSummary(".tmp/summary.json")
+	check(t, err)
+	assert.Contains(t, summary.Modules[0].Error, "Failed to sign the provenance of app-a: exit status 1 no token")
+}
+
+func TestDefaultBuilderID(t *testing.T) {
+	for _, k := range []string{"GITHUB_SERVER_URL", "GITHUB_WORKFLOW_REF", "GITHUB_REPOSITORY", "GITHUB_RUN_ID", "GITHUB_RUN_ATTEMPT"} {
+		v, ok := os.LookupEnv(k)
+		if ok {
+			defer os.Setenv(k, v)
+		} else {
+			defer os.Unsetenv(k)
+		}
+	}
+
+	os.Setenv("GITHUB_SERVER_URL", "https://github.com")
+	os.Setenv("GITHUB_WORKFLOW_REF", "acme/app/.github/workflows/build.yml@refs/heads/main")
+	os.Setenv("GITHUB_REPOSITORY", "acme/app")
+	os.Setenv("GITHUB_RUN_ID", "42")
+	os.Setenv("GITHUB_RUN_ATTEMPT", "2")
+
+	assert.Equal(t, "https://github.com/acme/app/.github/workflows/build.yml@refs/heads/main", defaultBuilderID())
+	assert.Equal(t, "https://github.com/acme/app/actions/runs/42/attempts/2", ciInvocationID())
+	assert.Equal(t, "git+https://github.com/acme/app", provenanceSourceURI(&Manifest{Dir: "/src"}))
+}
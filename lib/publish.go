@@ -0,0 +1,533 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// ArtifactOCI is an artifact pushed to an OCI registry.
+	ArtifactOCI = "oci"
+	// ArtifactS3 is an artifact uploaded to an S3 bucket.
+	ArtifactS3 = "s3"
+	// ArtifactHTTP is an artifact uploaded with an http PUT request.
+	ArtifactHTTP = "http"
+
+	ociArtifactType  = "application/vnd.mbt.module.v1"
+	ociManifestType  = "application/vnd.oci.image.manifest.v1+json"
+	ociEmptyType     = "application/vnd.oci.empty.v1+json"
+	ociTarGzipType   = "application/vnd.oci.image.layer.v1.tar+gzip"
+	publishTimeout   = 5 * time.Minute
+	defaultAWSRegion = "us-east-1"
+)
+
+// invalidOCITagChars are the characters not allowed in an OCI tag.
+var invalidOCITagChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// PublishTarget is a destination of the outputs of modules.
+// Exactly one of OCI, S3 or HTTP is specified.
+// Outputs of a module are archived in a gzipped tarball named
+// <module>-<version>.tar.gz.
+type PublishTarget struct {
+	// OCI is the registry and the repository prefix of the artifacts
+	// (e.g. ghcr.io/acme). Outputs are pushed to <oci>/<module>
+	// tagged with the version of the module.
+	OCI string `yaml:"oci,omitempty"`
+	// S3 is the bucket and the key prefix of the artifacts in the form
+	// s3://bucket/prefix. Outputs are uploaded to
+	// <prefix>/<module>/<version>/<module>-<version>.tar.gz.
+	// Credentials and the region are read from the standard AWS
+	// environment variables.
+	S3 string `yaml:"s3,omitempty"`
+	// HTTP is the base url of the artifacts. Outputs are uploaded with
+	// a PUT request to <http>/<module>/<version>/<module>-<version>.tar.gz.
+	HTTP string `yaml:"http,omitempty"`
+	// Username and Password are the basic credentials of OCI and HTTP
+	// targets. ${VAR} references are expanded from the host environment,
+	// provided VAR is listed in hostEnv.
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// Headers of HTTP requests. Values are expanded like Password.
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+// Artifact is the outputs of a module published to a target.
+type Artifact struct {
+	// Target is the type of the target (oci, s3 or http).
+	Target string `json:"target"`
+	// Ref is the reference of the artifact (e.g. registry/repo:tag,
+	// s3://bucket/key or the url).
+	Ref string `json:"ref"`
+	// Digest is the digest of the OCI manifest or the sha256 digest
+	// of the archive uploaded to other targets.
+	Digest  string `json:"digest"`
+	Variant string `json:"variant,omitempty"`
+}
+
+// outputArchive is the archive of the outputs of a module.
+type outputArchive struct {
+	name    string
+	tag     string
+	content []byte
+	digest  string
+	mod     *Module
+}
+
+// publishOutputs publishes the outputs of a module to its targets
+// (or the targets in the repository configuration).
+func (s *stdSystem) publishOutputs(config *RepoConfig, manifest *Manifest, mod *Module, variant *Variant) ([]*Artifact, error) {
+	targets := mod.Publish()
+	if len(targets) == 0 {
+		targets = config.Publish
+	}
+
+	if len(mod.Outputs()) == 0 || len(targets) == 0 {
+		return nil, nil
+	}
+
+	archive, err := archiveOutputs(manifest, mod, variant)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := config.allowedHostEnv()
+	artifacts := make([]*Artifact, 0, len(targets))
+	for _, t := range targets {
+		a, err := t.publish(allowed, archive)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgFailedPublishOutputs, mod.Name(), t.String())
+		}
+
+		if variant != nil {
+			a.Variant = variant.Name
+		}
+		s.Log.Infof(msgPublishedOutputs, mod.Name(), a.Ref)
+		artifacts = append(artifacts, a)
+	}
+
+	return artifacts, nil
+}
+
+// archiveOutputs creates a gzipped tarball of the outputs of a module.
+// Archive is reproducible, that is, it only depends on the names, the
+// modes and the content of the files.
+func archiveOutputs(manifest *Manifest, mod *Module, variant *Variant) (*outputArchive, error) {
+	dir := filepath.Join(manifest.Dir, mod.Path())
+	files, err := findFiles(dir, mod.Outputs(), time.Time{})
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedArchiveOutputs, mod.Name())
+	}
+
+	if len(files) == 0 {
+		return nil, e.NewErrorf(ErrClassUser, msgNoOutputs, mod.Name())
+	}
+
+	buff := new(bytes.Buffer)
+	gz := gzip.NewWriter(buff)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		p := filepath.Join(dir, filepath.FromSlash(f))
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgFailedArchiveOutputs, mod.Name())
+		}
+
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgFailedArchiveOutputs, mod.Name())
+		}
+
+		err = tw.WriteHeader(&tar.Header{
+			Name:     f,
+			Mode:     int64(info.Mode().Perm()),
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+			ModTime:  time.Unix(0, 0),
+		})
+		if err == nil {
+			_, err = tw.Write(content)
+		}
+		if err != nil {
+			return nil, e.Wrapf(ErrClassInternal, err, msgFailedArchiveOutputs, mod.Name())
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, e.Wrapf(ErrClassInternal, err, msgFailedArchiveOutputs, mod.Name())
+	}
+	if err := gz.Close(); err != nil {
+		return nil, e.Wrapf(ErrClassInternal, err, msgFailedArchiveOutputs, mod.Name())
+	}
+
+	tag := mod.Version()
+	if variant != nil {
+		tag = invalidOCITagChars.ReplaceAllString(tag+"-"+variant.Name, "_")
+	}
+
+	name := unsafeFileNameChars.ReplaceAllString(mod.Name(), "_")
+	return &outputArchive{
+		name:    fmt.Sprintf("%s-%s.tar.gz", name, tag),
+		tag:     tag,
+		content: buff.Bytes(),
+		digest:  sha256Digest(buff.Bytes()),
+		mod:     mod,
+	}, nil
+}
+
+func (t *PublishTarget) String() string {
+	switch {
+	case t.OCI != "":
+		return t.OCI
+	case t.S3 != "":
+		return t.S3
+	default:
+		// Credentials are not included since urls may contain them.
+		if u, err := url.Parse(t.HTTP); err == nil {
+			return u.Host
+		}
+		return "<invalid url>"
+	}
+}
+
+func (t *PublishTarget) publish(allowed map[string]bool, archive *outputArchive) (*Artifact, error) {
+	username, notAllowed := expandHostEnv(allowed, t.Username)
+	password, notAllowed2 := expandHostEnv(allowed, t.Password)
+	if notAllowed == "" {
+		notAllowed = notAllowed2
+	}
+	if notAllowed != "" {
+		return nil, e.NewErrorf(ErrClassUser, msgHostEnvNotAllowedInPublishTarget, notAllowed)
+	}
+
+	switch {
+	case t.OCI != "":
+		return publishOCI(t.OCI, username, password, archive)
+	case t.S3 != "":
+		return publishS3(t.S3, archive)
+	case t.HTTP != "":
+		headers := make(map[string]string, len(t.Headers))
+		for k, v := range t.Headers {
+			v, notAllowed := expandHostEnv(allowed, v)
+			if notAllowed != "" {
+				return nil, e.NewErrorf(ErrClassUser, msgHostEnvNotAllowedInPublishTarget, notAllowed)
+			}
+			headers[k] = v
+		}
+		return publishHTTP(t.HTTP, username, password, headers, archive)
+	default:
+		return nil, e.NewErrorf(ErrClassUser, msgInvalidPublishTarget)
+	}
+}
+
+// publishOCI pushes the archive as an OCI artifact with a single layer
+// in the layout of oras, so that it can be pulled with oras (or used
+// as an OCI template source).
+func publishOCI(repository, username, password string, archive *outputArchive) (*Artifact, error) {
+	name := strings.ToLower(path.Join(repository, archive.mod.Name()))
+	ref, err := parseOCIReference(name + ":" + archive.tag)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := newOCIRegistry(ref)
+	registry.username, registry.password, registry.actions = username, password, "pull,push"
+
+	config := []byte("{}")
+	for _, blob := range [][]byte{config, archive.content} {
+		if err := registry.pushBlob(blob); err != nil {
+			return nil, err
+		}
+	}
+
+	manifest, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociManifestType,
+		"artifactType":  ociArtifactType,
+		"config": map[string]interface{}{
+			"mediaType": ociEmptyType,
+			"digest":    sha256Digest(config),
+			"size":      len(config),
+		},
+		"layers": []interface{}{
+			map[string]interface{}{
+				"mediaType": ociTarGzipType,
+				"digest":    archive.digest,
+				"size":      len(archive.content),
+				"annotations": map[string]string{
+					ociTitleAnnotation:  archive.name,
+					ociUnpackAnnotation: "true",
+				},
+			},
+		},
+		"annotations": map[string]string{
+			"dev.mbt.module.name":    archive.mod.Name(),
+			"dev.mbt.module.version": archive.mod.Version(),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	u := registry.url("manifests/" + ref.reference)
+	res, err := registry.send(http.MethodPut, u, manifest, map[string]string{"Content-Type": ociManifestType})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("PUT %s returned %s", u, res.Status)
+	}
+
+	return &Artifact{
+		Target: ArtifactOCI,
+		Ref:    fmt.Sprintf("%s/%s:%s", ref.registry, ref.repository, ref.reference),
+		Digest: sha256Digest(manifest),
+	}, nil
+}
+
+// pushBlob uploads a blob unless the repository already has it.
+func (r *ociRegistry) pushBlob(blob []byte) error {
+	digest := sha256Digest(blob)
+	res, err := r.send(http.MethodHead, r.url("blobs/"+digest), nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	u := r.url("blobs/uploads/")
+	res, err = r.send(http.MethodPost, u, nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("POST %s returned %s", u, res.Status)
+	}
+
+	location, err := url.Parse(u)
+	if err == nil {
+		location, err = location.Parse(res.Header.Get("Location"))
+	}
+	if err != nil {
+		return fmt.Errorf("invalid upload location %q", res.Header.Get("Location"))
+	}
+
+	q := location.Query()
+	q.Set("digest", digest)
+	location.RawQuery = q.Encode()
+
+	res, err = r.send(http.MethodPut, location.String(), blob, map[string]string{"Content-Type": "application/octet-stream"})
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		return fmt.Errorf("PUT %s returned %s", location.Host+location.Path, res.Status)
+	}
+
+	return nil
+}
+
+// artifactKey is the path of the archive relative to the prefix of
+// S3 and HTTP targets.
+func artifactKey(archive *outputArchive) string {
+	return path.Join(archive.mod.Name(), archive.tag, archive.name)
+}
+
+func publishHTTP(base, username, password string, headers map[string]string, archive *outputArchive) (*Artifact, error) {
+	u := strings.TrimSuffix(base, "/") + "/" + artifactKey(archive)
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(archive.content))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/gzip")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	res, err := (&http.Client{Timeout: publishTimeout}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("unexpected response %s", res.Status)
+	}
+
+	return &Artifact{Target: ArtifactHTTP, Ref: u, Digest: archive.digest}, nil
+}
+
+// publishS3 uploads the archive to the S3 bucket in target.
+func publishS3(target string, archive *outputArchive) (*Artifact, error) {
+	req, ref, err := newS3Request(http.MethodPut, target, artifactKey(archive), archive.content)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+
+	res, err := (&http.Client{Timeout: publishTimeout}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("unexpected response %s", res.Status)
+	}
+
+	return &Artifact{Target: ArtifactS3, Ref: ref, Digest: archive.digest}, nil
+}
+
+// newS3Request creates a request for the object with the specified key
+// under the prefix of target (s3://bucket/prefix), signed with AWS
+// signature version 4. Requests are sent to $AWS_ENDPOINT_URL_S3 (or
+// $AWS_ENDPOINT_URL) in path style when it is set, so that S3
+// compatible storage (e.g. minio) can be used.
+// Returns the request and the s3 url of the object.
+func newS3Request(method, target, key string, body []byte) (*http.Request, string, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, "", fmt.Errorf("invalid s3 url %s, expected s3://bucket/prefix", target)
+	}
+
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil, "", fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = defaultAWSRegion
+	}
+
+	bucket := u.Host
+	key = strings.TrimPrefix(path.Join(u.Path, key), "/")
+
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	objectURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, region, key)
+	if endpoint != "" {
+		objectURL = fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(endpoint, "/"), bucket, key)
+	}
+
+	req, err := http.NewRequest(method, objectURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+
+	sum := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	signAWSV4(req, hex.EncodeToString(sum[:]), time.Now(), region, "s3", accessKey, secretKey)
+
+	return req, fmt.Sprintf("s3://%s/%s", bucket, key), nil
+}
+
+// signAWSV4 signs a request with AWS signature version 4. Host and
+// the x-amz-* headers of the request are signed.
+func signAWSV4(req *http.Request, payloadHash string, t time.Time, region, service, accessKey, secretKey string) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := new(bytes.Buffer)
+	for _, k := range names {
+		fmt.Fprintf(canonicalHeaders, "%s:%s\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalPath := req.URL.EscapedPath()
+	if canonicalPath == "" {
+		canonicalPath = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalPath,
+		strings.Replace(req.URL.Query().Encode(), "+", "%20", -1),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
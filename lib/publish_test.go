@@ -0,0 +1,386 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func initPublishRepo(t *testing.T, config *RepoConfig, targets []*PublishTarget) *TestRepository {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:    "app-a",
+		Build:   map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Outputs: []string{"dist/**"},
+		Publish: targets,
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "mkdir -p dist/bin && echo a > dist/app.txt && echo b > dist/bin/b.txt"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.WriteShellScript("app-b/build.sh", "echo b"))
+	if config != nil {
+		check(t, repo.WriteConfig(config))
+	}
+	check(t, repo.Commit("first"))
+
+	return repo
+}
+
+func publishingOptions() *CmdOptions {
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.Publish = true
+	return options
+}
+
+func readArchive(t *testing.T, content []byte) map[string]string {
+	gz, err := gzip.NewReader(bytes.NewReader(content))
+	check(t, err)
+
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		check(t, err)
+		buff, err := ioutil.ReadAll(tr)
+		check(t, err)
+		files[h.Name] = string(buff)
+	}
+}
+
+type uploadServer struct {
+	sync.Mutex
+	server  *httptest.Server
+	uploads map[string][]byte
+	headers map[string]http.Header
+}
+
+func startUploadServer(t *testing.T) *uploadServer {
+	s := &uploadServer{uploads: make(map[string][]byte), headers: make(map[string]http.Header)}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		buff, err := ioutil.ReadAll(r.Body)
+		check(t, err)
+
+		s.Lock()
+		defer s.Unlock()
+		s.uploads[r.URL.Path] = buff
+		s.headers[r.URL.Path] = r.Header
+	}))
+	return s
+}
+
+func TestPublishOutputsToHTTP(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	server := startUploadServer(t)
+	defer server.server.Close()
+
+	os.Setenv("MBT_TEST_PUBLISH_TOKEN", "t0ken")
+	defer os.Unsetenv("MBT_TEST_PUBLISH_TOKEN")
+
+	initPublishRepo(t, &RepoConfig{HostEnv: []string{"MBT_TEST_PUBLISH_TOKEN"}}, []*PublishTarget{
+		{HTTP: server.server.URL + "/artifacts/", Headers: map[string]string{"X-Token": "${MBT_TEST_PUBLISH_TOKEN}"}},
+	})
+
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, publishingOptions())
+	check(t, err)
+
+	a := summary.Completed[0]
+	assert.Equal(t, "app-a", a.Module.Name())
+	assert.Len(t, a.Artifacts, 1)
+	assert.Empty(t, summary.Completed[1].Artifacts)
+
+	p := fmt.Sprintf("/artifacts/app-a/%s/app-a-%s.tar.gz", a.Module.Version(), a.Module.Version())
+	assert.Equal(t, &Artifact{Target: ArtifactHTTP, Ref: server.server.URL + p, Digest: sha256Digest(server.uploads[p])}, a.Artifacts[0])
+	assert.Equal(t, "t0ken", server.headers[p].Get("X-Token"))
+	assert.Equal(t, map[string]string{"dist/app.txt": "a\n", "dist/bin/b.txt": "b\n"}, readArchive(t, server.uploads[p]))
+
+	invocation := summary.InvocationSummary(nil)
+	assert.Equal(t, a.Artifacts, invocation.Modules[0].Artifacts)
+}
+
+func TestPublishOutputsToRepoTargets(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	server := startUploadServer(t)
+	defer server.server.Close()
+
+	initPublishRepo(t, &RepoConfig{Publish: []*PublishTarget{{HTTP: server.server.URL}}}, nil)
+
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, publishingOptions())
+	check(t, err)
+
+	assert.Len(t, server.uploads, 1)
+	assert.Len(t, summary.Completed[0].Artifacts, 1)
+}
+
+func TestBuildWithoutPublish(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	server := startUploadServer(t)
+	defer server.server.Close()
+
+	initPublishRepo(t, nil, []*PublishTarget{{HTTP: server.server.URL}})
+
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+	check(t, err)
+
+	assert.Empty(t, server.uploads)
+	assert.Empty(t, summary.Completed[0].Artifacts)
+}
+
+func TestPublishFailureFailsTheBuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	initPublishRepo(t, nil, []*PublishTarget{{HTTP: server.URL + "/artifacts"}})
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, publishingOptions())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Failed to publish the outputs of app-a to 127.0.0.1")
+}
+
+func TestPublishWithHostEnvNotAllowed(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initPublishRepo(t, nil, []*PublishTarget{{HTTP: "http://127.0.0.1:1", Password: "${MBT_TEST_PUBLISH_TOKEN}"}})
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, publishingOptions())
+	assert.Error(t, err)
+}
+
+func TestArchiveOutputs(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app/a", &Spec{Name: "app/a", Outputs: []string{"*.txt"}}))
+	check(t, repo.WriteContent("app/a/one.txt", "1"))
+	check(t, repo.WriteContent("app/a/two.md", "2"))
+	check(t, repo.Commit("first"))
+
+	m, err := NewWorld(t, ".tmp/repo").System.ManifestByWorkspace()
+	check(t, err)
+	mod := m.Modules[0]
+
+	archive, err := archiveOutputs(m, mod, nil)
+	check(t, err)
+	assert.Equal(t, fmt.Sprintf("app_a-%s.tar.gz", mod.Version()), archive.name)
+	assert.Equal(t, map[string]string{"one.txt": "1"}, readArchive(t, archive.content))
+
+	// Archive does not depend on the modification time of the files.
+	time.Sleep(time.Second)
+	check(t, repo.WriteContent("app/a/one.txt", "1"))
+	again, err := archiveOutputs(m, mod, nil)
+	check(t, err)
+	assert.Equal(t, archive.digest, again.digest)
+
+	variant, err := archiveOutputs(m, mod, &Variant{Name: "arch=amd64,os=linux"})
+	check(t, err)
+	assert.Equal(t, mod.Version()+"-arch_amd64_os_linux", variant.tag)
+
+	check(t, os.Remove(filepath.Join(".tmp/repo/app/a/one.txt")))
+	_, err = archiveOutputs(m, mod, nil)
+	assert.EqualError(t, err, "Outputs of app/a are not found")
+}
+
+// pushRegistry is a registry accepting pushes with a token.
+type pushRegistry struct {
+	server    *httptest.Server
+	blobs     map[string][]byte
+	manifests map[string][]byte
+}
+
+func startPushRegistry(t *testing.T) *pushRegistry {
+	r := &pushRegistry{blobs: make(map[string][]byte), manifests: make(map[string][]byte)}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			scope := req.URL.Query().Get("scope")
+			if scope != "repository:acme/app-a:pull,push" && scope != "repository:acme/app-a:pull" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"token": "secret"}`)
+			return
+		}
+
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, r.server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		const prefix = "/v2/acme/app-a/"
+		if !strings.HasPrefix(req.URL.Path, prefix) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		resource := strings.TrimPrefix(req.URL.Path, prefix)
+		body, _ := ioutil.ReadAll(req.Body)
+
+		switch {
+		case req.Method == http.MethodPost && resource == "blobs/uploads/":
+			w.Header().Set("Location", "/v2/acme/app-a/blobs/uploads/1?state=x")
+			w.WriteHeader(http.StatusAccepted)
+		case req.Method == http.MethodPut && resource == "blobs/uploads/1":
+			if req.URL.Query().Get("state") != "x" || sha256Digest(body) != req.URL.Query().Get("digest") {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r.blobs[sha256Digest(body)] = body
+			w.WriteHeader(http.StatusCreated)
+		case strings.HasPrefix(resource, "blobs/"):
+			b, ok := r.blobs[strings.TrimPrefix(resource, "blobs/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(b)
+		case req.Method == http.MethodPut && strings.HasPrefix(resource, "manifests/"):
+			r.manifests[strings.TrimPrefix(resource, "manifests/")] = body
+			r.manifests[sha256Digest(body)] = body
+			w.WriteHeader(http.StatusCreated)
+		case strings.HasPrefix(resource, "manifests/"):
+			m, ok := r.manifests[strings.TrimPrefix(resource, "manifests/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(m)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return r
+}
+
+func TestPublishOutputsToOCI(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	registry := startPushRegistry(t)
+	defer registry.server.Close()
+
+	host := strings.TrimPrefix(registry.server.URL, "http://")
+	initPublishRepo(t, nil, []*PublishTarget{{OCI: host + "/acme"}})
+
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, publishingOptions())
+	check(t, err)
+
+	a := summary.Completed[0]
+	version := a.Module.Version()
+	assert.Equal(t, &Artifact{
+		Target: ArtifactOCI,
+		Ref:    fmt.Sprintf("%s/acme/app-a:%s", host, version),
+		Digest: sha256Digest(registry.manifests[version]),
+	}, a.Artifacts[0])
+	assert.Len(t, registry.blobs, 2)
+
+	// Published artifacts can be pulled as OCI template sources.
+	source, err := fetchOCISource(&TemplateSource{OCI: a.Artifacts[0].Ref, Digest: a.Artifacts[0].Digest}, nil)
+	check(t, err)
+	dir := filepath.Join(".tmp", "pulled")
+	check(t, source.extract(dir))
+	c, err := ioutil.ReadFile(filepath.Join(dir, "dist", "bin", "b.txt"))
+	check(t, err)
+	assert.Equal(t, "b\n", string(c))
+}
+
+func TestPublishOutputsToS3(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	server := startUploadServer(t)
+	defer server.server.Close()
+
+	for k, v := range map[string]string{
+		"AWS_ENDPOINT_URL_S3":   server.server.URL,
+		"AWS_ACCESS_KEY_ID":     "AKID",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+		"AWS_REGION":            "eu-west-1",
+		"AWS_SESSION_TOKEN":     "session",
+	} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	initPublishRepo(t, nil, []*PublishTarget{{S3: "s3://builds/mono"}})
+
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, publishingOptions())
+	check(t, err)
+
+	a := summary.Completed[0]
+	version := a.Module.Version()
+	key := fmt.Sprintf("mono/app-a/%s/app-a-%s.tar.gz", version, version)
+	assert.Equal(t, "s3://builds/"+key, a.Artifacts[0].Ref)
+
+	headers := server.headers["/builds/"+key]
+	assert.NotNil(t, headers)
+	assert.Equal(t, "session", headers.Get("X-Amz-Security-Token"))
+	assert.True(t, strings.HasPrefix(headers.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/"))
+	assert.Contains(t, headers.Get("Authorization"), "/eu-west-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token, Signature=")
+}
+
+func TestSignAWSV4(t *testing.T) {
+	// get-vanilla from the test suite of AWS signature version 4.
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	check(t, err)
+
+	emptyHash := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	signAWSV4(req, emptyHash, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC), "us-east-1", "service", "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+
+	assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31", req.Header.Get("Authorization"))
+}
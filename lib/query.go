@@ -0,0 +1,490 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// query is a compiled filter expression selecting modules.
+//
+// Expressions compare the attributes of modules (name, path, version,
+// tags, owners, commands, labels.<name> and properties.<name>) with
+// literals using ==, !=, =~ (regular expression match), !~ and in
+// (list membership), call
+// functions (depends_on and required_by), and combine them with &&,
+// || and !. For example:
+//
+//	name =~ "^svc-" && "backend" in tags && depends_on("lib-auth")
+type query interface {
+	eval(m *Module) (interface{}, error)
+}
+
+// FilterByQuery returns the modules matching the expression.
+func (m *Manifest) FilterByQuery(expression string) (*Manifest, error) {
+	q, err := parseQuery(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make(Modules, 0)
+	for _, mod := range m.Modules {
+		v, err := q.eval(mod)
+		if err != nil {
+			return nil, e.NewErrorf(ErrClassUser, msgFailedQuery, expression, mod.Name(), err)
+		}
+
+		if truthy(v) {
+			filtered = append(filtered, mod)
+		}
+	}
+
+	return &Manifest{Dir: m.Dir, Sha: m.Sha, Modules: filtered}, nil
+}
+
+type literalQuery struct {
+	value interface{}
+}
+
+func (q *literalQuery) eval(*Module) (interface{}, error) {
+	return q.value, nil
+}
+
+type attributeQuery struct {
+	name string
+}
+
+func (q *attributeQuery) eval(m *Module) (interface{}, error) {
+	switch q.name {
+	case "name":
+		return m.Name(), nil
+	case "path":
+		return m.Path(), nil
+	case "version":
+		return m.Version(), nil
+	case "owners":
+		owners := make([]interface{}, 0, len(m.Owners()))
+		for _, o := range m.Owners() {
+			owners = append(owners, o)
+		}
+		return owners, nil
+	case "commands":
+		names := make([]string, 0, len(m.Commands()))
+		for c := range m.Commands() {
+			names = append(names, c)
+		}
+		sort.Strings(names)
+
+		commands := make([]interface{}, 0, len(names))
+		for _, c := range names {
+			commands = append(commands, c)
+		}
+		return commands, nil
+	case tagsProperty:
+		return m.Properties()[tagsProperty], nil
+	case "properties":
+		return m.Properties(), nil
+	case "labels":
+		labels := make(map[string]interface{}, len(m.Labels()))
+		for k, v := range m.Labels() {
+			labels[k] = v
+		}
+		return labels, nil
+	}
+
+	if strings.HasPrefix(q.name, "labels.") {
+		if v, ok := m.Labels()[strings.TrimPrefix(q.name, "labels.")]; ok {
+			return v, nil
+		}
+		return nil, nil
+	}
+
+	// Attribute is validated when parsing.
+	return resolveProperty(m.Properties(), strings.Split(strings.TrimPrefix(q.name, "properties."), "."), nil), nil
+}
+
+type notQuery struct {
+	operand query
+}
+
+func (q *notQuery) eval(m *Module) (interface{}, error) {
+	v, err := q.operand.eval(m)
+	if err != nil {
+		return nil, err
+	}
+	return !truthy(v), nil
+}
+
+type binaryQuery struct {
+	op          string
+	left, right query
+	// re is the compiled regular expression if the right operand of
+	// =~ or !~ is a literal.
+	re *regexp.Regexp
+}
+
+func (q *binaryQuery) eval(m *Module) (interface{}, error) {
+	l, err := q.left.eval(m)
+	if err != nil {
+		return nil, err
+	}
+
+	// && and || are evaluated lazily.
+	switch q.op {
+	case "&&":
+		if !truthy(l) {
+			return false, nil
+		}
+	case "||":
+		if truthy(l) {
+			return true, nil
+		}
+	}
+
+	r, err := q.right.eval(m)
+	if err != nil {
+		return nil, err
+	}
+
+	switch q.op {
+	case "&&", "||":
+		return truthy(r), nil
+	case "==":
+		return l != nil && r != nil && fmt.Sprint(l) == fmt.Sprint(r), nil
+	case "!=":
+		return l == nil || r == nil || fmt.Sprint(l) != fmt.Sprint(r), nil
+	case "=~", "!~":
+		if r == nil {
+			return q.op == "!~", nil
+		}
+
+		re := q.re
+		if re == nil {
+			if re, err = regexp.Compile(fmt.Sprint(r)); err != nil {
+				return nil, err
+			}
+		}
+		match := l != nil && re.MatchString(fmt.Sprint(l))
+		return match == (q.op == "=~"), nil
+	case "in":
+		list, _ := r.([]interface{})
+		for _, item := range list {
+			if l != nil && fmt.Sprint(item) == fmt.Sprint(l) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	panic("unsupported operator " + q.op)
+}
+
+type callQuery struct {
+	name string
+	arg  string
+}
+
+func (q *callQuery) eval(m *Module) (interface{}, error) {
+	var related Modules
+	var err error
+	switch q.name {
+	case "depends_on":
+		related, err = Modules{m}.expandRequiresDependencies()
+	case "required_by":
+		related, err = Modules{m}.expandRequiredByDependencies()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range related {
+		if r != m && r.Name() == q.arg {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// truthy informs if a value is considered true in a boolean context.
+// nil, false, empty strings and empty lists are false.
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return t != ""
+	case []interface{}:
+		return len(t) > 0
+	case map[string]interface{}:
+		return len(t) > 0
+	default:
+		return true
+	}
+}
+
+const (
+	tokenEOF = iota
+	tokenString
+	tokenNumber
+	tokenIdent
+	tokenOperator
+)
+
+type token struct {
+	kind  int
+	text  string
+	value interface{}
+	pos   int
+}
+
+var queryOperators = []string{"&&", "||", "==", "!=", "=~", "!~", "!", "(", ")", ","}
+
+func tokenizeQuery(s string) ([]*token, error) {
+	var tokens []*token
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			v, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d", i)
+			}
+			tokens = append(tokens, &token{kind: tokenString, text: s[i : j+1], value: v, pos: i})
+			i = j + 1
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(s) && unicode.IsDigit(rune(s[i+1]))):
+			j := i + 1
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.') {
+				j++
+			}
+			v, err := strconv.ParseFloat(s[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number at %d", i)
+			}
+			tokens = append(tokens, &token{kind: tokenNumber, text: s[i:j], value: v, pos: i})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i + 1
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || strings.ContainsRune("_.-", rune(s[j]))) {
+				j++
+			}
+			tokens = append(tokens, &token{kind: tokenIdent, text: s[i:j], pos: i})
+			i = j
+		default:
+			op := ""
+			for _, o := range queryOperators {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character '%c' at %d", c, i)
+			}
+			tokens = append(tokens, &token{kind: tokenOperator, text: op, pos: i})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, &token{kind: tokenEOF, pos: len(s)}), nil
+}
+
+type queryParser struct {
+	tokens []*token
+	pos    int
+}
+
+func parseQuery(s string) (query, error) {
+	tokens, err := tokenizeQuery(s)
+	if err != nil {
+		return nil, e.NewErrorf(ErrClassUser, msgInvalidQuery, s, err)
+	}
+
+	p := &queryParser{tokens: tokens}
+	q, err := p.parseOr()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = p.unexpected()
+	}
+	if err != nil {
+		return nil, e.NewErrorf(ErrClassUser, msgInvalidQuery, s, err)
+	}
+
+	return q, nil
+}
+
+func (p *queryParser) peek() *token {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() *token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// back returns the token consumed by next to the input.
+func (p *queryParser) back(t *token) {
+	if t.kind != tokenEOF {
+		p.pos--
+	}
+}
+
+func (p *queryParser) accept(op string) bool {
+	t := p.peek()
+	if (t.kind == tokenOperator || t.kind == tokenIdent) && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) expect(op string) error {
+	if !p.accept(op) {
+		return p.unexpected()
+	}
+	return nil
+}
+
+func (p *queryParser) unexpected() error {
+	t := p.peek()
+	if t.kind == tokenEOF {
+		return fmt.Errorf("unexpected end of expression")
+	}
+	return fmt.Errorf("unexpected '%s' at %d", t.text, t.pos)
+}
+
+func (p *queryParser) parseOr() (query, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var right query
+		right, err = p.parseAnd()
+		left = &binaryQuery{op: "||", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *queryParser) parseAnd() (query, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.accept("&&") {
+		var right query
+		right, err = p.parseUnary()
+		left = &binaryQuery{op: "&&", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *queryParser) parseUnary() (query, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		return &notQuery{operand: operand}, err
+	}
+	return p.parseComparison()
+}
+
+func (p *queryParser) parseComparison() (query, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range []string{"==", "!=", "=~", "!~", "in"} {
+		if !p.accept(op) {
+			continue
+		}
+
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+
+		q := &binaryQuery{op: op, left: left, right: right}
+		if l, ok := right.(*literalQuery); ok && (op == "=~" || op == "!~") {
+			if q.re, err = regexp.Compile(fmt.Sprint(l.value)); err != nil {
+				return nil, err
+			}
+		}
+		return q, nil
+	}
+
+	return left, nil
+}
+
+func (p *queryParser) parsePrimary() (query, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString, tokenNumber:
+		return &literalQuery{value: t.value}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true", "false":
+			return &literalQuery{value: t.text == "true"}, nil
+		case "depends_on", "required_by":
+			return p.parseCall(t.text)
+		case "name", "path", "version", "owners", "commands", tagsProperty, "properties", "labels":
+			return &attributeQuery{name: t.text}, nil
+		}
+		if strings.HasPrefix(t.text, "properties.") || strings.HasPrefix(t.text, "labels.") {
+			return &attributeQuery{name: t.text}, nil
+		}
+		return nil, fmt.Errorf("unknown attribute '%s' at %d", t.text, t.pos)
+	case tokenOperator:
+		if t.text == "(" {
+			q, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return q, p.expect(")")
+		}
+	}
+
+	p.back(t)
+	return nil, p.unexpected()
+}
+
+func (p *queryParser) parseCall(name string) (query, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	t := p.next()
+	if t.kind != tokenString {
+		p.back(t)
+		return nil, fmt.Errorf("%s requires the name of a module", name)
+	}
+
+	return &callQuery{name: name, arg: t.value.(string)}, p.expect(")")
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func initQueryRepo(t *testing.T) *Manifest {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("lib-auth", &Spec{Name: "lib-auth"}))
+	check(t, repo.InitModuleWithOptions("svc-a", &Spec{
+		Name:         "svc-a",
+		Dependencies: []string{"lib-auth"},
+		Properties:   map[string]interface{}{"tags": []interface{}{"backend"}, "replicas": 3, "image": map[string]interface{}{"name": "a"}},
+		Owners:       []string{"team-a"},
+		Labels:       map[string]string{"team": "payments", "tier": "svc"},
+	}))
+	check(t, repo.InitModuleWithOptions("svc-b", &Spec{
+		Name:         "svc-b",
+		Dependencies: []string{"svc-a"},
+		Properties:   map[string]interface{}{"tags": []interface{}{"frontend"}},
+		Labels:       map[string]string{"team": "payments", "tier": "web"},
+	}))
+	check(t, repo.InitModuleWithOptions("web", &Spec{
+		Name:       "web",
+		Properties: map[string]interface{}{"tags": []interface{}{"backend"}},
+		Commands:   map[string]*UserCmd{"test": {Cmd: "echo"}},
+	}))
+	check(t, repo.Commit("first"))
+
+	m, err := NewWorld(t, ".tmp/repo").ManifestBuilder.ByCurrentBranch()
+	check(t, err)
+	return m
+}
+
+func TestFilterByQuery(t *testing.T) {
+	m := initQueryRepo(t)
+
+	cases := map[string][]string{
+		`name =~ "^svc-" && "backend" in tags && depends_on("lib-auth")`: {"svc-a"},
+		`depends_on("lib-auth")`:                    {"svc-a", "svc-b"},
+		`required_by("svc-b")`:                      {"lib-auth", "svc-a"},
+		`name == "web" || properties.replicas == 3`: {"svc-a", "web"},
+		`!("backend" in tags)`:                      {"lib-auth", "svc-b"},
+		`name !~ "^svc-"`:                           {"lib-auth", "web"},
+		`properties.image.name == "a"`:              {"svc-a"},
+		`properties.replicas`:                       {"svc-a"},
+		`"team-a" in owners`:                        {"svc-a"},
+		`"test" in commands`:                        {"web"},
+		`path != "web" && tags`:                     {"svc-a", "svc-b"},
+		`name =~ properties.image.name`:             {"svc-a"},
+		`false || true`:                             {"lib-auth", "svc-a", "svc-b", "web"},
+		`labels.team == "payments"`:                 {"svc-a", "svc-b"},
+		`labels.tier != "svc"`:                      {"lib-auth", "svc-b", "web"},
+		`labels.team && name =~ "a$"`:               {"svc-a"},
+	}
+
+	for expression, expected := range cases {
+		filtered, err := m.FilterByQuery(expression)
+		check(t, err)
+		assert.ElementsMatch(t, expected, moduleNames(filtered.Modules), expression)
+	}
+}
+
+func TestApplyFiltersWithQuery(t *testing.T) {
+	m := initQueryRepo(t)
+
+	filtered, err := m.ApplyFilters(&FilterOptions{Name: "svc-a,web", Query: `"backend" in tags`, Dependents: true})
+	check(t, err)
+
+	assert.ElementsMatch(t, []string{"svc-a", "svc-b", "web"}, moduleNames(filtered.Modules))
+}
+
+func TestFilterByLabels(t *testing.T) {
+	m := initQueryRepo(t)
+
+	cases := map[string][]string{
+		"team=payments":          {"svc-a", "svc-b"},
+		"team=payments,tier=svc": {"svc-a"},
+		"tier=svc, team=foo":     {},
+		"team=":                  {},
+	}
+
+	for selector, expected := range cases {
+		labels, err := ParseLabelSelector(selector)
+		check(t, err)
+		assert.ElementsMatch(t, expected, moduleNames(m.FilterByLabels(labels).Modules), selector)
+	}
+}
+
+func TestApplyFiltersWithLabels(t *testing.T) {
+	m := initQueryRepo(t)
+
+	filtered, err := m.ApplyFilters(&FilterOptions{Labels: map[string]string{"tier": "svc"}, Dependencies: true})
+	check(t, err)
+	assert.Equal(t, []string{"lib-auth", "svc-a"}, moduleNames(filtered.Modules))
+
+	filtered, err = m.ApplyFilters(&FilterOptions{Labels: map[string]string{"tier": "svc"}, Dependents: true})
+	check(t, err)
+	assert.Equal(t, []string{"svc-a", "svc-b"}, moduleNames(filtered.Modules))
+
+	filtered, err = m.ApplyFilters(&FilterOptions{Name: "svc-b", Labels: map[string]string{"tier": "svc"}})
+	check(t, err)
+	assert.Len(t, filtered.Modules, 0)
+}
+
+func TestParseLabelSelector(t *testing.T) {
+	labels, err := ParseLabelSelector(" team=payments , tier=svc,,url=a=b")
+	check(t, err)
+	assert.Equal(t, map[string]string{"team": "payments", "tier": "svc", "url": "a=b"}, labels)
+
+	for _, selector := range []string{"team", "=svc", "team=a,tier"} {
+		_, err := ParseLabelSelector(selector)
+		assert.Error(t, err, selector)
+		assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+	}
+}
+
+func TestInvalidQuery(t *testing.T) {
+	m := initQueryRepo(t)
+
+	for expression, message := range map[string]string{
+		`name ==`:               "unexpected end of expression",
+		`name == "a" "b"`:       `unexpected '"b"' at 12`,
+		`owner == "a"`:          "unknown attribute 'owner' at 0",
+		`name =~ "("`:           "error parsing regexp",
+		`depends_on(lib)`:       "depends_on requires the name of a module",
+		`name == "a`:            "unterminated string at 8",
+		`name == 'a'`:           "unexpected character ''' at 8",
+		`(name == "a"`:          "unexpected end of expression",
+		`name == "a" && || "b"`: "unexpected '||' at 15",
+	} {
+		_, err := m.FilterByQuery(expression)
+		assert.Error(t, err, expression)
+		assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+		assert.Contains(t, err.Error(), "Invalid query '"+expression+"'", expression)
+		assert.Contains(t, err.Error(), message, expression)
+	}
+}
@@ -32,16 +32,24 @@ func NewReducer(log Log) Reducer {
 }
 
 func (r *stdReducer) Reduce(modules Modules, deltas []*DiffDelta) (Modules, error) {
-	t := trie.NewTrie()
+	// Changes are indexed both as they are and in lower case since the
+	// modules that do not require case sensitive paths (see PathConfig)
+	// are matched ignoring the case.
+	exact := trie.NewTrie()
+	folded := trie.NewTrie()
 	filtered := make(Modules, 0)
+	submodules := make([]string, 0)
 	for _, d := range deltas {
-		// Current comparison is case insensitive. This is problematic
-		// for case sensitive file systems.
-		// Perhaps we can read core.ignorecase configuration value
-		// in git and adjust accordingly.
+		if d.Submodule {
+			// Modules discovered in a submodule are impacted by
+			// a change of the commit it points to.
+			submodules = append(submodules, fmt.Sprintf("%s/", d.NewFile))
+		}
+
+		r.Log.Debug("Index change %s", d.NewFile)
+		exact.Add(d.NewFile, d.NewFile)
 		nfp := strings.ToLower(d.NewFile)
-		r.Log.Debug("Index change %s", nfp)
-		t.Add(nfp, nfp)
+		folded.Add(nfp, nfp)
 	}
 
 	for _, m := range modules {
@@ -50,26 +58,35 @@ func (r *stdReducer) Reduce(modules Modules, deltas []*DiffDelta) (Modules, erro
 		if mp == "" {
 			// Fast path for the root module if there's one.
 			// Root module should match any change.
-			if len(deltas) > 0 {
+			if len(deltas) > 0 && changesModule(m, deltas, strings.ToLower) {
 				filtered = append(filtered, m)
 			}
 			continue
 		}
 
+		t, fold := folded, strings.ToLower
+		if m.metadata.caseSensitive {
+			t, fold = exact, func(s string) string { return s }
+		}
+
 		// Append / to the end of module path to make sure
 		// we restrict the search exactly for that path.
 		// for example, change in path a/bb should not
 		// match a module in a/b
-		mp = strings.ToLower(fmt.Sprintf("%s/", m.Path()))
+		mp = fold(fmt.Sprintf("%s/", m.Path()))
 		r.Log.Debug("Filter by module path %s", mp)
-		if t.ContainsPrefix(mp) {
+		if (t.ContainsPrefix(mp) && changesModule(m, deltas, fold)) || inSubmodule(mp, submodules, fold) {
 			filtered = append(filtered, m)
 		} else {
 			for _, p := range m.FileDependencies() {
-				fdp := strings.ToLower(p)
+				// File dependency is either a file or a directory.
+				// Like module paths, a change in a/bb should not
+				// match a file dependency on a/b.
+				fdp := strings.TrimSuffix(fold(p), "/")
 				r.Log.Debug("Filter by file dependency path %s", fdp)
-				if t.ContainsPrefix(fdp) {
+				if _, ok := t.Find(fdp); ok || t.ContainsPrefix(fdp+"/") {
 					filtered = append(filtered, m)
+					break
 				}
 			}
 		}
@@ -77,3 +94,33 @@ func (r *stdReducer) Reduce(modules Modules, deltas []*DiffDelta) (Modules, erro
 
 	return filtered, nil
 }
+
+// changesModule reports whether any of the deltas changes a path not
+// excluded by the ignore rules of the module.
+func changesModule(m *Module, deltas []*DiffDelta, fold func(string) string) bool {
+	rules := m.metadata.ignore
+	if len(rules) == 0 {
+		return true
+	}
+
+	prefix := ""
+	if m.Path() != "" {
+		prefix = fold(m.Path() + "/")
+	}
+
+	for _, d := range deltas {
+		if strings.HasPrefix(fold(d.NewFile), prefix) && !rules.excludes(d.NewFile) {
+			return true
+		}
+	}
+	return false
+}
+
+func inSubmodule(p string, submodules []string, fold func(string) string) bool {
+	for _, s := range submodules {
+		if strings.HasPrefix(p, fold(s)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,401 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// ReleaseFormatJSON formats the release plan as json.
+	ReleaseFormatJSON = "json"
+	// ReleaseFormatMarkdown formats the release notes of the plan as
+	// markdown.
+	ReleaseFormatMarkdown = "markdown"
+
+	// ReleaseBumpMajor is the bump of a release with breaking changes.
+	ReleaseBumpMajor = "major"
+	// ReleaseBumpMinor is the bump of a release with new features.
+	ReleaseBumpMinor = "minor"
+	// ReleaseBumpPatch is the bump of the other releases.
+	ReleaseBumpPatch = "patch"
+
+	// defaultReleaseTagPrefix is the prefix of the version in the tags
+	// of releases.
+	defaultReleaseTagPrefix = "v"
+	// initialRelease is the version of the first release.
+	initialRelease = "1.0.0"
+)
+
+// releaseBumps orders the bumps from the smallest to the largest.
+var releaseBumps = map[string]int{ReleaseBumpPatch: 0, ReleaseBumpMinor: 1, ReleaseBumpMajor: 2}
+
+// ReleaseOptions specifies how a release plan is created.
+type ReleaseOptions struct {
+	// From is the tag of the previous release. Defaults to the tag
+	// with the highest version.
+	From string
+	// To is the revision released.
+	To string
+	// TagPrefix is the prefix of the versions in release tags.
+	// Defaults to v.
+	TagPrefix string
+	// Version of the release. Derived from the changes if it is not
+	// specified.
+	Version string
+	// Select is the purpose of the modules released. Defaults to deploys.
+	Select string
+}
+
+// ReleasePlan is a release train, the modules released together
+// along with their versions.
+type ReleasePlan struct {
+	Version string `json:"version"`
+	// Tag of the release.
+	Tag string `json:"tag"`
+	// Previous is the tag of the previous release.
+	Previous string `json:"previous,omitempty"`
+	// Sha of the commit released.
+	Sha     string           `json:"sha"`
+	Modules []*ReleaseModule `json:"modules"`
+}
+
+// ReleaseModule is a module released in a release train.
+type ReleaseModule struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// Version is the content based version of the module.
+	Version string `json:"version"`
+	// Release is the semantic version of the module.
+	Release string `json:"release"`
+	// Previous is the semantic version of the previous release of
+	// the module.
+	Previous string `json:"previous,omitempty"`
+	// Tag of the release of the module (e.g. app-a/v1.2.0).
+	Tag       string     `json:"tag"`
+	Bump      string     `json:"bump"`
+	Changelog *Changelog `json:"changelog"`
+}
+
+// semver is a semantic version without pre-release and build metadata.
+type semver [3]int
+
+func (v semver) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+func (v semver) less(other semver) bool {
+	for i := range v {
+		if v[i] != other[i] {
+			return v[i] < other[i]
+		}
+	}
+	return false
+}
+
+func (v semver) bump(kind string) semver {
+	switch kind {
+	case ReleaseBumpMajor:
+		return semver{v[0] + 1, 0, 0}
+	case ReleaseBumpMinor:
+		return semver{v[0], v[1] + 1, 0}
+	default:
+		return semver{v[0], v[1], v[2] + 1}
+	}
+}
+
+var semverPattern = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)$`)
+
+func parseSemver(s string) (semver, bool) {
+	m := semverPattern.FindStringSubmatch(s)
+	if m == nil {
+		return semver{}, false
+	}
+
+	var v semver
+	for i := range v {
+		v[i], _ = strconv.Atoi(m[i+1])
+	}
+	return v, true
+}
+
+// latestRelease returns the tag with the highest version among the
+// references with the prefix.
+func latestRelease(refs []string, prefix string) (string, semver, bool) {
+	var (
+		tag    string
+		latest semver
+		found  bool
+	)
+
+	for _, r := range refs {
+		if !strings.HasPrefix(r, prefix) {
+			continue
+		}
+
+		v, ok := parseSemver(strings.TrimPrefix(r, prefix))
+		if ok && (!found || latest.less(v)) {
+			tag, latest, found = r, v, true
+		}
+	}
+
+	return tag, latest, found
+}
+
+func (s *stdSystem) ReleasePlan(options *ReleaseOptions) (*ReleasePlan, error) {
+	prefix := options.TagPrefix
+	if prefix == "" {
+		prefix = defaultReleaseTagPrefix
+	}
+
+	purpose := options.Select
+	if purpose == "" {
+		purpose = AffectedDeploys
+	}
+
+	refs, err := s.Repo.References()
+	if err != nil {
+		return nil, err
+	}
+
+	from := options.From
+	var previous semver
+	if from == "" {
+		from, previous, _ = latestRelease(refs, prefix)
+	} else if v, ok := parseSemver(strings.TrimPrefix(from, prefix)); ok {
+		previous = v
+	}
+
+	toCommit, err := s.Repo.ResolveCommit(options.To)
+	if err != nil {
+		return nil, err
+	}
+
+	var fromCommit Commit
+	if from != "" {
+		fromCommit, err = s.Repo.ResolveCommit(from)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m, err := s.affected(fromCommit, toCommit, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &ReleasePlan{Previous: from, Sha: toCommit.ID(), Modules: make([]*ReleaseModule, 0, len(m.Modules))}
+	train := ReleaseBumpPatch
+	for _, mod := range m.Modules {
+		changelog, err := s.Changelog(mod.Name(), from, toCommit.ID())
+		if err != nil {
+			return nil, err
+		}
+
+		r := &ReleaseModule{
+			Name:      mod.Name(),
+			Path:      mod.Path(),
+			Version:   mod.Version(),
+			Bump:      changelogBump(changelog),
+			Changelog: changelog,
+		}
+
+		release, _ := parseSemver(initialRelease)
+		if tag, v, ok := latestRelease(refs, mod.Name()+"/"+prefix); ok {
+			r.Previous = strings.TrimPrefix(tag, mod.Name()+"/"+prefix)
+			release = v.bump(r.Bump)
+		}
+		r.Release = release.String()
+		r.Tag = mod.Name() + "/" + prefix + r.Release
+
+		if releaseBumps[r.Bump] > releaseBumps[train] {
+			train = r.Bump
+		}
+		plan.Modules = append(plan.Modules, r)
+	}
+
+	switch {
+	case options.Version != "":
+		if _, ok := parseSemver(options.Version); !ok {
+			return nil, e.NewErrorf(ErrClassUser, msgInvalidReleaseVersion, options.Version)
+		}
+		plan.Version = options.Version
+	case from == "":
+		plan.Version = initialRelease
+	default:
+		plan.Version = previous.bump(train).String()
+	}
+	plan.Tag = prefix + plan.Version
+
+	return plan, nil
+}
+
+// changelogBump returns the bump of the version for the changes in
+// a changelog.
+func changelogBump(changelog *Changelog) string {
+	bump := ReleaseBumpPatch
+	for _, g := range changelog.Groups {
+		for _, entry := range g.Entries {
+			if entry.Breaking {
+				return ReleaseBumpMajor
+			}
+			if entry.Type == "feat" {
+				bump = ReleaseBumpMinor
+			}
+		}
+	}
+	return bump
+}
+
+// Module returns the module in the plan with the name or nil if the
+// module is not released in the plan.
+func (p *ReleasePlan) Module(name string) *ReleaseModule {
+	for _, m := range p.Modules {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// ReadReleasePlan reads a release plan written in json format.
+func ReadReleasePlan(path string) (*ReleasePlan, error) {
+	buff, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedReadReleasePlan, path)
+	}
+
+	plan := &ReleasePlan{}
+	err = json.Unmarshal(buff, plan)
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedReadReleasePlan, path)
+	}
+
+	return plan, nil
+}
+
+// withRelease returns a copy of the manifest with the modules released
+// in the plan. Modules must be at the versions they were planned.
+func (m *Manifest) withRelease(plan *ReleasePlan) (*Manifest, error) {
+	if plan == nil {
+		return m, nil
+	}
+
+	index := m.Modules.indexByName()
+	for _, r := range plan.Modules {
+		mod, ok := index[r.Name]
+		if !ok {
+			return nil, e.NewErrorf(ErrClassUser, msgReleaseModuleNotFound, r.Name)
+		}
+
+		if mod.Version() != r.Version {
+			return nil, e.NewErrorf(ErrClassUser, msgReleaseModuleChanged, r.Name, mod.Version(), r.Version)
+		}
+	}
+
+	modules := make(Modules, 0, len(plan.Modules))
+	for _, mod := range m.Modules {
+		if plan.Module(mod.Name()) != nil {
+			modules = append(modules, mod)
+		}
+	}
+
+	return &Manifest{Dir: m.Dir, Sha: m.Sha, Modules: modules}, nil
+}
+
+// withReleaseOptions returns a copy of the options exposing the
+// versions of the release to the commands of a module.
+func withReleaseOptions(options *CmdOptions, mod *Module) *CmdOptions {
+	if options.Release == nil {
+		return options
+	}
+
+	r := options.Release.Module(mod.Name())
+	if r == nil {
+		return options
+	}
+
+	c := *options
+	c.Env = append([]string{
+		fmt.Sprintf("MBT_RELEASE_TRAIN=%s", options.Release.Version),
+		fmt.Sprintf("MBT_RELEASE_VERSION=%s", r.Release),
+		fmt.Sprintf("MBT_RELEASE_TAG=%s", r.Tag),
+	}, options.Env...)
+	return &c
+}
+
+// Write writes the plan in the specified format.
+func (p *ReleasePlan) Write(format string, w io.Writer) error {
+	var (
+		buff []byte
+		err  error
+	)
+
+	switch format {
+	case ReleaseFormatJSON:
+		buff, err = json.MarshalIndent(p, "", "  ")
+		buff = append(buff, '\n')
+	case ReleaseFormatMarkdown:
+		buff = p.markdown()
+	default:
+		return e.NewErrorf(ErrClassUser, msgUnsupportedReleaseFormat, format)
+	}
+
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	if _, err := w.Write(buff); err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	return nil
+}
+
+// markdown returns the release notes with the changelog of each module.
+func (p *ReleasePlan) markdown() []byte {
+	buff := new(bytes.Buffer)
+	fmt.Fprintf(buff, "# %s\n", p.Tag)
+
+	for _, m := range p.Modules {
+		fmt.Fprintf(buff, "\n## %s %s", m.Name, m.Release)
+		if m.Previous != "" {
+			fmt.Fprintf(buff, " (from %s)", m.Previous)
+		}
+		buff.WriteString("\n")
+
+		// Sections of the changelog are nested under the module.
+		lines := strings.Split(string(m.Changelog.markdown()), "\n")
+		for _, l := range lines[1:] {
+			if strings.HasPrefix(l, "#") {
+				l = "#" + l
+			}
+			buff.WriteString(l + "\n")
+		}
+		buff.Truncate(buff.Len() - 1)
+	}
+
+	return buff.Bytes()
+}
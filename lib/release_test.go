@@ -0,0 +1,235 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	git "github.com/libgit2/git2go"
+	"github.com/stretchr/testify/assert"
+)
+
+func tagLastCommit(t *testing.T, repo *TestRepository, names ...string) {
+	c, err := repo.Repo.LookupCommit(repo.LastCommit)
+	check(t, err)
+	for _, n := range names {
+		_, err = repo.Repo.Tags.Create(n, c, &git.Signature{Name: "alice", Email: "alice@wonderland.com", When: time.Now()}, "release")
+		check(t, err)
+	}
+}
+
+func deployableSpec(name string, deps ...string) *Spec {
+	return &Spec{
+		Name:         name,
+		Build:        map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Commands:     map[string]*UserCmd{"deploy": {Cmd: "echo", Args: []string{"deploy"}}},
+		Dependencies: deps,
+	}
+}
+
+func initReleaseRepo(t *testing.T) *TestRepository {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", deployableSpec("app-a")))
+	check(t, repo.WriteShellScript("app-a/build.sh", `echo "$MBT_RELEASE_TRAIN $MBT_RELEASE_VERSION $MBT_RELEASE_TAG"`))
+	check(t, repo.InitModuleWithOptions("app-b", deployableSpec("app-b", "lib-c")))
+	check(t, repo.WriteShellScript("app-b/build.sh", "echo b"))
+	check(t, repo.InitModule("lib-c"))
+	check(t, repo.InitModuleWithOptions("app-d", deployableSpec("app-d")))
+	check(t, repo.WriteShellScript("app-d/build.sh", "echo d"))
+	check(t, repo.Commit("first"))
+	tagLastCommit(t, repo, "v1.2.0", "v1.10.0-rc1", "app-a/v2.3.1", "app-b/v0.4.0")
+
+	check(t, repo.WriteContent("app-a/file", "a"))
+	check(t, repo.Commit("feat(api): add the orders api"))
+	check(t, repo.WriteContent("lib-c/file", "c"))
+	check(t, repo.Commit("fix: handle empty input"))
+
+	return repo
+}
+
+func TestReleasePlan(t *testing.T) {
+	initReleaseRepo(t)
+
+	plan, err := NewWorld(t, ".tmp/repo").System.ReleasePlan(&ReleaseOptions{To: "HEAD"})
+	check(t, err)
+
+	assert.Equal(t, "v1.2.0", plan.Previous)
+	assert.Equal(t, "1.3.0", plan.Version)
+	assert.Equal(t, "v1.3.0", plan.Tag)
+	assert.Len(t, plan.Modules, 2)
+
+	a, b := plan.Module("app-a"), plan.Module("app-b")
+	assert.Equal(t, "2.4.0", a.Release)
+	assert.Equal(t, "2.3.1", a.Previous)
+	assert.Equal(t, "app-a/v2.4.0", a.Tag)
+	assert.Equal(t, ReleaseBumpMinor, a.Bump)
+	assert.Equal(t, "add the orders api", a.Changelog.Groups[0].Entries[0].Subject)
+
+	// app-b is released since lib-c changed.
+	assert.Equal(t, "0.4.1", b.Release)
+	assert.Equal(t, ReleaseBumpPatch, b.Bump)
+	assert.Nil(t, plan.Module("lib-c"))
+	assert.Nil(t, plan.Module("app-d"))
+}
+
+func TestReleasePlanWithBreakingChange(t *testing.T) {
+	repo := initReleaseRepo(t)
+	check(t, repo.WriteContent("app-d/file", "d"))
+	check(t, repo.Commit("refactor!: drop the v1 api"))
+
+	plan, err := NewWorld(t, ".tmp/repo").System.ReleasePlan(&ReleaseOptions{To: "HEAD"})
+	check(t, err)
+
+	assert.Equal(t, "2.0.0", plan.Version)
+	d := plan.Module("app-d")
+	assert.Equal(t, ReleaseBumpMajor, d.Bump)
+	assert.Equal(t, initialRelease, d.Release)
+	assert.Empty(t, d.Previous)
+}
+
+func TestFirstReleasePlan(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app-a", deployableSpec("app-a")))
+	check(t, repo.InitModule("lib-b"))
+	check(t, repo.Commit("first"))
+
+	plan, err := NewWorld(t, ".tmp/repo").System.ReleasePlan(&ReleaseOptions{To: "HEAD", TagPrefix: "release-"})
+	check(t, err)
+
+	assert.Empty(t, plan.Previous)
+	assert.Equal(t, "release-1.0.0", plan.Tag)
+	assert.Len(t, plan.Modules, 1)
+	assert.Equal(t, "app-a/release-1.0.0", plan.Modules[0].Tag)
+}
+
+func TestReleasePlanWithVersion(t *testing.T) {
+	initReleaseRepo(t)
+	world := NewWorld(t, ".tmp/repo")
+
+	plan, err := world.System.ReleasePlan(&ReleaseOptions{To: "HEAD", Version: "5.0.0"})
+	check(t, err)
+	assert.Equal(t, "v5.0.0", plan.Tag)
+
+	_, err = world.System.ReleasePlan(&ReleaseOptions{To: "HEAD", Version: "5.0"})
+	assert.EqualError(t, err, "Invalid release version 5.0, specify a version in major.minor.patch format")
+}
+
+func TestBuildReleasePlan(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initReleaseRepo(t)
+	world := NewWorld(t, ".tmp/repo")
+
+	plan, err := world.System.ReleasePlan(&ReleaseOptions{To: "HEAD"})
+	check(t, err)
+
+	buff := new(bytes.Buffer)
+	check(t, plan.Write(ReleaseFormatJSON, buff))
+	p, err := filepath.Abs(".tmp/plan.json")
+	check(t, err)
+	check(t, ioutil.WriteFile(p, buff.Bytes(), 0644))
+
+	plan, err = ReadReleasePlan(p)
+	check(t, err)
+
+	stdout := new(bytes.Buffer)
+	options := stdTestCmdOptions(stdout)
+	options.Release = plan
+	summary, err := world.System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Len(t, summary.Completed, 2)
+	assert.Equal(t, "app-a", summary.Completed[0].Module.Name())
+	assert.Equal(t, "app-b", summary.Completed[1].Module.Name())
+	assert.Equal(t, "1.3.0 2.4.0 app-a/v2.4.0\nb\n", stdout.String())
+}
+
+func TestBuildOutdatedReleasePlan(t *testing.T) {
+	repo := initReleaseRepo(t)
+	world := NewWorld(t, ".tmp/repo")
+
+	plan, err := world.System.ReleasePlan(&ReleaseOptions{To: "HEAD"})
+	check(t, err)
+
+	check(t, repo.WriteContent("app-a/file", "changed"))
+	check(t, repo.Commit("fix: change app-a"))
+
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.Release = plan
+	_, err = world.System.BuildCurrentBranch(NoFilter, options)
+
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "Module app-a is at version "))
+}
+
+func TestApplyReleasePlan(t *testing.T) {
+	repo := initReleaseRepo(t)
+	check(t, repo.WriteContent("template.tmpl", `{{ .Release.Tag }}{{ range .ModulesList }}{{ with $.Release.Module .Name }} {{ .Name }}={{ .Release }}{{ end }}{{ end }}`))
+	check(t, repo.Commit("add template"))
+	world := NewWorld(t, ".tmp/repo")
+
+	plan, err := world.System.ReleasePlan(&ReleaseOptions{To: "HEAD"})
+	check(t, err)
+
+	output := new(bytes.Buffer)
+	check(t, world.System.ApplyHeadWithOptions("template.tmpl", &ApplyOptions{
+		Release: plan,
+		Output: func(*Module) (io.WriteCloser, error) {
+			return nopWriteCloser{output}, nil
+		},
+	}))
+	assert.Equal(t, "v1.3.0 app-a=2.4.0 app-b=0.4.1", output.String())
+
+	outputs := applyOutputs{}
+	options := outputs.options()
+	options.Release = plan
+	check(t, world.System.ApplyHeadWithOptions("template.tmpl", options))
+	assert.Len(t, outputs, 2)
+	assert.Contains(t, outputs, "app-a")
+	assert.Contains(t, outputs, "app-b")
+}
+
+func TestReleasePlanMarkdown(t *testing.T) {
+	plan := &ReleasePlan{
+		Tag: "v1.3.0",
+		Modules: []*ReleaseModule{{
+			Name:     "app-a",
+			Release:  "2.4.0",
+			Previous: "2.3.1",
+			Changelog: &Changelog{Module: "app-a", Groups: []*ChangelogGroup{
+				{Type: "feat", Title: "Features", Entries: []*ChangelogEntry{{Sha: "1234567890", Type: "feat", Subject: "add the orders api"}}},
+			}},
+		}},
+	}
+
+	buff := new(bytes.Buffer)
+	check(t, plan.Write(ReleaseFormatMarkdown, buff))
+
+	assert.Equal(t, "# v1.3.0\n\n## app-a 2.4.0 (from 2.3.1)\n\n### Features\n\n- add the orders api (1234567)\n", buff.String())
+	assert.EqualError(t, plan.Write("yaml", buff), "Unsupported format yaml, supported formats are json and markdown")
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// Environment variables configuring the credentials used to fetch
+// from remote repositories (e.g. git template sources and repositories
+// specified with --in).
+const (
+	envGitUsername      = "MBT_GIT_USERNAME"
+	envGitPassword      = "MBT_GIT_PASSWORD"
+	envGitSSHKey        = "MBT_GIT_SSH_KEY"
+	envGitSSHPassphrase = "MBT_GIT_SSH_PASSPHRASE"
+	envNetrc            = "NETRC"
+)
+
+// remoteError wraps an error of a remote operation with an interpolated
+// message. Authentication failures, which already describe how to
+// resolve them, are returned as they are.
+func remoteError(err error, message string, args ...interface{}) error {
+	if ee, ok := err.(*e.E); ok {
+		return ee
+	}
+	return e.Wrapf(ErrClassUser, err, message, args...)
+}
+
+// userpassCredentials returns the username and password for a url
+// along with their source.
+// Credentials in the environment take precedence over the ones in the
+// netrc file.
+func userpassCredentials(u string, usernameFromURL string) (string, string, string) {
+	if password := os.Getenv(envGitPassword); password != "" {
+		username := os.Getenv(envGitUsername)
+		if username == "" {
+			username = usernameFromURL
+		}
+		if username == "" {
+			// Hosts accepting personal access tokens ignore the username
+			// but it cannot be empty.
+			username = "git"
+		}
+		return username, password, envGitPassword
+	}
+
+	pu, err := url.Parse(u)
+	if err != nil || pu.Hostname() == "" {
+		return "", "", ""
+	}
+
+	p := netrcPath()
+	if p == "" {
+		return "", "", ""
+	}
+
+	buff, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", "", ""
+	}
+
+	login, password, ok := netrcCredentials(buff, pu.Hostname())
+	if !ok {
+		return "", "", ""
+	}
+	return login, password, p
+}
+
+func netrcPath() string {
+	if p := os.Getenv(envNetrc); p != "" {
+		return p
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	for _, name := range []string{".netrc", "_netrc"} {
+		p := filepath.Join(home, name)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// netrcCredentials returns the login and password of a machine in a
+// netrc file. Credentials of the default entry are returned if the
+// machine is not listed.
+func netrcCredentials(buff []byte, host string) (string, string, bool) {
+	var (
+		login, password       string
+		defLogin, defPassword string
+		inMachine, inDefault  bool
+		found, foundDefault   bool
+		lines                 = strings.Split(string(buff), "\n")
+		tokens                = make([]string, 0)
+	)
+
+	// Macro definitions run until the next empty line and are not
+	// tokenised.
+	for i := 0; i < len(lines); i++ {
+		f := strings.Fields(lines[i])
+		if len(f) > 0 && f[0] == "macdef" {
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+			}
+			continue
+		}
+		tokens = append(tokens, f...)
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		next := ""
+		if i+1 < len(tokens) {
+			next = tokens[i+1]
+		}
+
+		switch tokens[i] {
+		case "machine":
+			if found {
+				return login, password, true
+			}
+			inMachine = next == host
+			inDefault = false
+			found = inMachine
+			i++
+		case "default":
+			if found {
+				return login, password, true
+			}
+			inMachine = false
+			inDefault = true
+			foundDefault = true
+		case "login":
+			if inMachine {
+				login = next
+			} else if inDefault {
+				defLogin = next
+			}
+			i++
+		case "password":
+			if inMachine {
+				password = next
+			} else if inDefault {
+				defPassword = next
+			}
+			i++
+		}
+	}
+
+	if found {
+		return login, password, true
+	}
+	if foundDefault {
+		return defLogin, defPassword, true
+	}
+	return "", "", false
+}
+
+// redactURL removes the password from a url so that it can be
+// reported.
+func redactURL(u string) string {
+	pu, err := url.Parse(u)
+	if err != nil || pu.User == nil {
+		return u
+	}
+
+	if _, ok := pu.User.Password(); ok {
+		pu.User = url.UserPassword(pu.User.Username(), "xxxxx")
+	}
+	return pu.String()
+}
@@ -0,0 +1,247 @@
+//go:build nolibgit2
+
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+// remoteAuth returns the credentials used to connect to the remote at
+// url. Unlike libgit2, go-git does not ask for the credentials the
+// remote accepts, therefore only the first source of credentials
+// found is used. Sources are checked in the same order as libgit2
+// builds (the environment, the netrc file, MBT_GIT_SSH_KEY and the SSH
+// agent).
+func remoteAuth(url string) (transport.AuthMethod, error) {
+	ep, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, e.Wrap(ErrClassUser, err)
+	}
+
+	switch ep.Protocol {
+	case "http", "https":
+		if username, password, source := userpassCredentials(url, ep.User); source != "" {
+			return &http.BasicAuth{Username: username, Password: password}, nil
+		}
+	case "ssh":
+		username := ep.User
+		if username == "" {
+			username = "git"
+		}
+
+		var auth transport.AuthMethod
+		if key := os.Getenv(envGitSSHKey); key != "" {
+			auth, err = ssh.NewPublicKeysFromFile(username, key, os.Getenv(envGitSSHPassphrase))
+		} else {
+			auth, err = ssh.NewSSHAgentAuth(username)
+		}
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgRemoteAuthFailed, redactURL(url))
+		}
+		return auth, nil
+	}
+
+	return nil, nil
+}
+
+// remoteAuthErr returns the error of a remote operation.
+// Authentication failures are reported along with the ways to
+// configure the credentials.
+func remoteAuthErr(url string, err error) error {
+	if err == transport.ErrAuthenticationRequired || err == transport.ErrAuthorizationFailed ||
+		strings.Contains(err.Error(), "unable to authenticate") {
+		return e.Wrapf(ErrClassUser, err, msgRemoteAuthFailed, redactURL(url))
+	}
+	return err
+}
+
+// fetchRemote fetches the refspecs from origin with the configured
+// credentials.
+func fetchRemote(repo *gogit.Repository, url string, refspecs []string) error {
+	auth, err := remoteAuth(url)
+	if err != nil {
+		return err
+	}
+
+	specs := make([]config.RefSpec, 0, len(refspecs))
+	for _, s := range refspecs {
+		specs = append(specs, config.RefSpec(s))
+	}
+
+	err = repo.Fetch(&gogit.FetchOptions{RemoteName: "origin", RefSpecs: specs, Auth: auth})
+	if err == nil || err == gogit.NoErrAlreadyUpToDate || err == transport.ErrEmptyRemoteRepository {
+		return nil
+	}
+	return remoteAuthErr(url, err)
+}
+
+// mirrorRemoteRepo fetches the branches and tags of the repository at
+// url into the bare repository in dir.
+func mirrorRemoteRepo(dir, url string) error {
+	repo, err := openSourceRepo(dir, url)
+	if err != nil {
+		return e.Wrapf(ErrClassUser, err, msgFailedFetchRemoteRepo, url)
+	}
+
+	if err := fetchRemote(repo, url, gitSourceRefspecs); err != nil {
+		return remoteError(err, msgFailedFetchRemoteRepo, url)
+	}
+
+	if err := setRemoteHead(repo, url); err != nil {
+		return remoteError(err, msgFailedFetchRemoteRepo, url)
+	}
+
+	return nil
+}
+
+// setRemoteHead points the head of the repository to the branch the
+// head of the remote points to.
+func setRemoteHead(repo *gogit.Repository, url string) error {
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	auth, err := remoteAuth(url)
+	if err != nil {
+		return err
+	}
+
+	refs, err := remote.List(&gogit.ListOptions{Auth: auth})
+	if err == transport.ErrEmptyRemoteRepository {
+		return nil
+	}
+	if err != nil {
+		return remoteAuthErr(url, err)
+	}
+
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			return repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, ref.Target()))
+		}
+	}
+
+	return nil
+}
+
+// fetchGitCommit fetches the repository at url into the bare
+// repository in repoDir and resolves ref to a commit.
+func fetchGitCommit(repoDir, url, ref string) (*fetchedSource, error) {
+	repo, err := openSourceRepo(repoDir, url)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fetchRemote(repo, url, gitSourceRefspecs); err != nil {
+		return nil, err
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgCommitShaNotFound, ref)
+	}
+
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fetchedSource{
+		digest: commit.Hash.String(),
+		extract: func(dir string) error {
+			return extractCommit(commit, dir)
+		},
+	}, nil
+}
+
+func openSourceRepo(repoDir, url string) (*gogit.Repository, error) {
+	if _, err := os.Stat(repoDir); os.IsNotExist(err) {
+		repo, err := gogit.PlainInit(repoDir, true)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{url}})
+		if err != nil {
+			return nil, err
+		}
+		return repo, nil
+	}
+
+	repo, err := gogit.PlainOpen(repoDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Url is updated in case it is changed in the configuration.
+	cfg, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	if remote, ok := cfg.Remotes["origin"]; ok {
+		remote.URLs = []string{url}
+	} else {
+		cfg.Remotes["origin"] = &config.RemoteConfig{Name: "origin", URLs: []string{url}}
+	}
+
+	if err := repo.Storer.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+func extractCommit(commit *object.Commit, dir string) error {
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	return tree.Files().ForEach(func(f *object.File) error {
+		r, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		contents, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		mode := os.FileMode(0644)
+		if f.Mode == filemode.Executable {
+			mode = 0755
+		}
+
+		return writeSourceFile(dir, f.Name, contents, mode)
+	})
+}
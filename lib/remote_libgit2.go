@@ -0,0 +1,280 @@
+//go:build !nolibgit2
+
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	git "github.com/libgit2/git2go"
+	"github.com/mbtproject/mbt/e"
+)
+
+// remoteAuth supplies the credentials requested by a remote.
+// Each source of credentials is offered once, so that the remote
+// rejecting them results in an authentication failure rather than
+// being asked for the same credentials repeatedly.
+type remoteAuth struct {
+	url    string
+	tried  map[string]bool
+	failed bool
+}
+
+func newRemoteAuth(url string) *remoteAuth {
+	return &remoteAuth{url: url, tried: make(map[string]bool)}
+}
+
+func (a *remoteAuth) callbacks() git.RemoteCallbacks {
+	return git.RemoteCallbacks{CredentialsCallback: a.credentials}
+}
+
+func (a *remoteAuth) credentials(u string, usernameFromURL string, allowed git.CredType) (git.ErrorCode, *git.Cred) {
+	if allowed&git.CredTypeUserpassPlaintext != 0 {
+		if username, password, source := userpassCredentials(u, usernameFromURL); source != "" && !a.tried[source] {
+			a.tried[source] = true
+			ret, cred := git.NewCredUserpassPlaintext(username, password)
+			return git.ErrorCode(ret), &cred
+		}
+	}
+
+	if allowed&git.CredTypeSshKey != 0 {
+		username := usernameFromURL
+		if username == "" {
+			username = "git"
+		}
+
+		if key := os.Getenv(envGitSSHKey); key != "" && !a.tried[envGitSSHKey] {
+			a.tried[envGitSSHKey] = true
+			pub := key + ".pub"
+			if _, err := os.Stat(pub); err != nil {
+				pub = ""
+			}
+			ret, cred := git.NewCredSshKey(username, pub, key, os.Getenv(envGitSSHPassphrase))
+			return git.ErrorCode(ret), &cred
+		}
+
+		if !a.tried["ssh-agent"] {
+			a.tried["ssh-agent"] = true
+			ret, cred := git.NewCredSshKeyFromAgent(username)
+			return git.ErrorCode(ret), &cred
+		}
+	}
+
+	a.failed = true
+	return git.ErrAuth, nil
+}
+
+// err returns the error of a remote operation. Authentication failures
+// are reported along with the ways to configure the credentials.
+func (a *remoteAuth) err(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if a.failed || git.IsErrorCode(err, git.ErrAuth) {
+		return e.Wrapf(ErrClassUser, err, msgRemoteAuthFailed, redactURL(a.url))
+	}
+	return err
+}
+
+// fetchRemote fetches the refspecs from a remote with the configured
+// credentials.
+func fetchRemote(remote *git.Remote, url string, refspecs []string) error {
+	auth := newRemoteAuth(url)
+	err := remote.Fetch(refspecs, &git.FetchOptions{RemoteCallbacks: auth.callbacks()}, "")
+	return auth.err(err)
+}
+
+// mirrorRemoteRepo fetches the branches and tags of the repository at
+// url into the bare repository in dir.
+func mirrorRemoteRepo(dir, url string) error {
+	repo, err := openSourceRepo(dir, url)
+	if err != nil {
+		return e.Wrapf(ErrClassUser, err, msgFailedFetchRemoteRepo, url)
+	}
+	defer repo.Free()
+
+	remote, err := repo.Remotes.Lookup("origin")
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+	defer remote.Free()
+
+	if err := fetchRemote(remote, url, gitSourceRefspecs); err != nil {
+		return remoteError(err, msgFailedFetchRemoteRepo, url)
+	}
+
+	if err := setRemoteHead(repo, remote, url); err != nil {
+		return remoteError(err, msgFailedFetchRemoteRepo, url)
+	}
+
+	return nil
+}
+
+// setRemoteHead points the head of the repository to the branch the
+// head of the remote points to.
+// libgit2 does not expose the symbolic reference of the remote head,
+// therefore the branch is identified by its commit. master and main are
+// preferred when multiple branches point to that commit.
+func setRemoteHead(repo *git.Repository, remote *git.Remote, url string) error {
+	auth := newRemoteAuth(url)
+	callbacks := auth.callbacks()
+	if err := remote.ConnectFetch(&callbacks, nil, nil); err != nil {
+		return auth.err(err)
+	}
+	defer remote.Disconnect()
+
+	heads, err := remote.Ls()
+	if err != nil {
+		return err
+	}
+
+	var head *git.Oid
+	for _, h := range heads {
+		if h.Name == "HEAD" {
+			head = h.Id
+		}
+	}
+	if head == nil {
+		return nil
+	}
+
+	branch := ""
+	for _, h := range heads {
+		if !strings.HasPrefix(h.Name, "refs/heads/") || !h.Id.Equal(head) {
+			continue
+		}
+
+		if branch == "" || h.Name == "refs/heads/master" || h.Name == "refs/heads/main" {
+			branch = h.Name
+		}
+	}
+	if branch == "" {
+		return nil
+	}
+
+	return repo.SetHead(branch)
+}
+
+// fetchGitCommit fetches the repository at url into the bare
+// repository in repoDir and resolves ref to a commit.
+func fetchGitCommit(repoDir, url, ref string) (*fetchedSource, error) {
+	repo, err := openSourceRepo(repoDir, url)
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := repo.Remotes.Lookup("origin")
+	if err != nil {
+		return nil, err
+	}
+	defer remote.Free()
+
+	if err := fetchRemote(remote, url, gitSourceRefspecs); err != nil {
+		return nil, err
+	}
+
+	obj, err := repo.RevparseSingle(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err = obj.Peel(git.ObjectCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := obj.AsCommit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &fetchedSource{
+		digest: commit.Id().String(),
+		extract: func(dir string) error {
+			return extractCommit(repo, commit, dir)
+		},
+	}, nil
+}
+
+func openSourceRepo(repoDir, url string) (*git.Repository, error) {
+	if _, err := os.Stat(repoDir); os.IsNotExist(err) {
+		repo, err := git.InitRepository(repoDir, true)
+		if err != nil {
+			return nil, err
+		}
+
+		remote, err := repo.Remotes.Create("origin", url)
+		if err != nil {
+			return nil, err
+		}
+		remote.Free()
+		return repo, nil
+	}
+
+	repo, err := git.OpenRepository(repoDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Url is updated in case it is changed in the configuration.
+	if err := repo.Remotes.SetUrl("origin", url); err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+func extractCommit(repo *git.Repository, commit *git.Commit, dir string) error {
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	var walkErr error
+	err = tree.Walk(func(root string, entry *git.TreeEntry) int {
+		if entry.Type != git.ObjectBlob {
+			return 0
+		}
+
+		blob, err := repo.LookupBlob(entry.Id)
+		if err != nil {
+			walkErr = err
+			return -1
+		}
+		defer blob.Free()
+
+		mode := os.FileMode(0644)
+		if entry.Filemode == git.FilemodeBlobExecutable {
+			mode = 0755
+		}
+
+		if err := writeSourceFile(dir, path.Join(root, entry.Name), blob.Contents(), mode); err != nil {
+			walkErr = err
+			return -1
+		}
+
+		return 0
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+
+	return err
+}
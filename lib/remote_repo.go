@@ -0,0 +1,86 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// cacheDirEnv is the environment variable specifying the directory
+	// caching the repositories specified by a url.
+	cacheDirEnv  = "MBT_CACHE_DIR"
+	repoCacheDir = "repos"
+)
+
+// scpLikeURL matches the urls in scp syntax (e.g. git@github.com:org/repo.git).
+var scpLikeURL = regexp.MustCompile(`^[\w.-]+@[\w.-]+:`)
+
+// isRemoteRepo informs if the repository is specified by a url rather
+// than a path.
+func isRemoteRepo(p string) bool {
+	return strings.Contains(p, "://") || scpLikeURL.MatchString(p)
+}
+
+// cacheRootDir returns the directory of the caches shared by the
+// repositories of a user.
+func cacheRootDir() (string, error) {
+	dir := os.Getenv(cacheDirEnv)
+	if dir == "" {
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", e.Wrap(ErrClassInternal, err)
+		}
+		dir = filepath.Join(userDir, "mbt")
+	}
+	return dir, nil
+}
+
+// remoteRepoCacheDir returns the directory caching the repository at url.
+func remoteRepoCacheDir(url string) (string, error) {
+	dir, err := cacheRootDir()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha1.Sum([]byte(url))
+	return filepath.Join(dir, repoCacheDir, hex.EncodeToString(h[:])), nil
+}
+
+// fetchRemoteRepo fetches the branches and tags of the repository at url
+// into a bare repository in the cache directory and returns the path
+// to that repository. Head of the cached repository points to the
+// default branch of the remote.
+func fetchRemoteRepo(url string, log Log) (string, error) {
+	dir, err := remoteRepoCacheDir(url)
+	if err != nil {
+		return "", err
+	}
+
+	log.Infof(msgFetchingRemoteRepo, url)
+	if err := mirrorRemoteRepo(dir, url); err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
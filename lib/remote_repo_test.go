@@ -0,0 +1,122 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	git "github.com/libgit2/git2go"
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRemoteRepo(t *testing.T) {
+	assert.True(t, isRemoteRepo("https://github.com/mbtproject/mbt.git"))
+	assert.True(t, isRemoteRepo("ssh://git@github.com/mbtproject/mbt.git"))
+	assert.True(t, isRemoteRepo("file:///src/mbt"))
+	assert.True(t, isRemoteRepo("git@github.com:mbtproject/mbt.git"))
+	assert.False(t, isRemoteRepo("/src/mbt"))
+	assert.False(t, isRemoteRepo("src/mbt"))
+	assert.False(t, isRemoteRepo(`C:\src\mbt`))
+}
+
+func remoteTestRepo(t *testing.T) (*TestRepository, string) {
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+	check(t, repo.SwitchToBranch("feature"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.Commit("second"))
+	check(t, repo.SwitchToBranch("master"))
+
+	abs, err := filepath.Abs(".tmp/repo")
+	check(t, err)
+	os.Setenv(cacheDirEnv, ".tmp/cache")
+	return repo, "file://" + filepath.ToSlash(abs)
+}
+
+func TestNewSystemForRemoteRepo(t *testing.T) {
+	clean()
+	repo, url := remoteTestRepo(t)
+	defer os.Unsetenv(cacheDirEnv)
+
+	s, err := NewSystem(url, LogLevelNormal)
+	check(t, err)
+
+	m, err := s.ManifestByCurrentBranch()
+	check(t, err)
+	assert.Equal(t, []string{"app-a"}, moduleNames(m.Modules))
+
+	m, err = s.ManifestByBranch("feature")
+	check(t, err)
+	assert.ElementsMatch(t, []string{"app-a", "app-b"}, moduleNames(m.Modules))
+
+	// Repository is fetched again when the system is created.
+	check(t, repo.InitModule("app-c"))
+	check(t, repo.Commit("third"))
+	s.Close()
+
+	s, err = NewSystem(url, LogLevelNormal)
+	check(t, err)
+	defer s.Close()
+
+	m, err = s.ManifestByCurrentBranch()
+	check(t, err)
+	assert.Equal(t, repo.LastCommit.String(), m.Sha)
+	assert.ElementsMatch(t, []string{"app-a", "app-c"}, moduleNames(m.Modules))
+
+	dir, err := remoteRepoCacheDir(url)
+	check(t, err)
+	_, err = os.Stat(dir)
+	check(t, err)
+}
+
+func TestNewSystemForUnavailableRemoteRepo(t *testing.T) {
+	clean()
+	os.Setenv(cacheDirEnv, ".tmp/cache")
+	defer os.Unsetenv(cacheDirEnv)
+
+	_, err := NewSystem("file:///does/not/exist", LogLevelNormal)
+
+	assert.EqualError(t, err, fmt.Sprintf(msgFailedFetchRemoteRepo, "file:///does/not/exist"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestBareRepo(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+	_, err := git.Clone(".tmp/repo", ".tmp/bare", &git.CloneOptions{Bare: true})
+	check(t, err)
+
+	s, err := NewSystem(".tmp/bare", LogLevelNormal)
+	check(t, err)
+	defer s.Close()
+
+	m, err := s.ManifestByCommit(repo.LastCommit.String())
+	check(t, err)
+	assert.Equal(t, []string{"app-a"}, moduleNames(m.Modules))
+
+	_, err = s.ManifestByWorkspace()
+	assert.EqualError(t, err, fmt.Sprintf(msgNoWorkingTree, ".tmp/bare"))
+
+	_, err = s.BuildCurrentBranch(NoFilter, stdTestCmdOptions(nil))
+	assert.EqualError(t, err, fmt.Sprintf(msgNoWorkingTree, ".tmp/bare"))
+}
@@ -1,3 +1,5 @@
+//go:build !nolibgit2
+
 /*
 Copyright 2018 MBT Authors.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -16,12 +18,24 @@ limitations under the License.
 package lib
 
 import (
+	"bufio"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 
 	git "github.com/libgit2/git2go"
 	"github.com/mbtproject/mbt/e"
 )
 
+var defaultCheckoutOptions = &git.CheckoutOpts{
+	Strategy: git.CheckoutSafe,
+}
+
 type libgitBlob struct {
 	path   string
 	commit *libgitCommit
@@ -69,6 +83,10 @@ type libgitRepo struct {
 	path string
 	Repo *git.Repository
 	Log  Log
+
+	sparseOnce     sync.Once
+	sparseCheckout *SparseCheckout
+	sparseErr      error
 }
 
 func (c *libgitCommit) Tree() (*git.Tree, error) {
@@ -87,6 +105,9 @@ func (c *libgitCommit) Tree() (*git.Tree, error) {
 func NewLibgitRepo(path string, log Log) (Repo, error) {
 	repo, err := git.OpenRepository(path)
 	if err != nil {
+		if isPartialClone(path) {
+			return nil, e.Wrapf(ErrClassUser, err, msgPartialCloneNotSupported, path)
+		}
 		return nil, e.Wrapf(ErrClassUser, err, msgFailedOpenRepo, path)
 	}
 
@@ -100,11 +121,19 @@ func NewLibgitRepo(path string, log Log) (Repo, error) {
 func (r *libgitRepo) GetCommit(commitSha string) (Commit, error) {
 	commitOid, err := git.NewOid(commitSha)
 	if err != nil {
+		// Accept the other forms of revisions (e.g. abbreviated shas,
+		// tags and HEAD~3) wherever a commit is expected.
+		if c, rerr := r.revision(commitSha); rerr == nil {
+			return c, nil
+		}
 		return nil, e.Wrapf(ErrClassUser, err, msgInvalidSha, commitSha)
 	}
 
 	commit, err := r.Repo.LookupCommit(commitOid)
 	if err != nil {
+		if r.shallow() {
+			return nil, e.Wrapf(ErrClassUser, err, msgCommitNotFoundInShallowClone, commitSha)
+		}
 		return nil, e.Wrapf(ErrClassUser, err, msgCommitShaNotFound, commitSha)
 	}
 
@@ -115,6 +144,10 @@ func (r *libgitRepo) Path() string {
 	return r.path
 }
 
+func (r *libgitRepo) GitDir() string {
+	return filepath.Clean(r.Repo.Path())
+}
+
 func (r *libgitRepo) Diff(a, b Commit) ([]*DiffDelta, error) {
 	diff, err := diff(r.Repo, a, b)
 	if err != nil {
@@ -139,6 +172,10 @@ func (r *libgitRepo) DiffMergeBase(from, to Commit) ([]*DiffDelta, error) {
 }
 
 func (r *libgitRepo) DiffWorkspace() ([]*DiffDelta, error) {
+	if err := r.ensureWorkingTree(); err != nil {
+		return nil, err
+	}
+
 	index, err := r.Repo.Index()
 	if err != nil {
 		return nil, e.Wrap(ErrClassInternal, err)
@@ -158,7 +195,21 @@ func (r *libgitRepo) DiffWorkspace() ([]*DiffDelta, error) {
 		return nil, e.Wrap(ErrClassInternal, err)
 	}
 
-	return deltas(diff)
+	sparse, err := r.SparseCheckout()
+	if err != nil {
+		return nil, err
+	}
+
+	return filteredDeltas(diff, func(delta git.DiffDelta) (bool, error) {
+		// Files excluded from a sparse checkout are not deleted.
+		if delta.Status == git.DeltaDeleted && !sparse.Includes(delta.OldFile.Path) {
+			return true, nil
+		}
+
+		// Smudged Git LFS objects are not changes. libgit2 is not aware
+		// of the filter converting them to pointer files.
+		return r.isSmudgedLFSObject(delta)
+	})
 }
 
 func (r *libgitRepo) Changes(c Commit) ([]*DiffDelta, error) {
@@ -176,6 +227,12 @@ func (r *libgitRepo) Changes(c Commit) ([]*DiffDelta, error) {
 	}
 
 	p := commit.Parent(0)
+	if p == nil {
+		if r.shallow() {
+			return nil, e.NewErrorf(ErrClassUser, msgParentNotFoundInShallowClone, commit.Id())
+		}
+		return nil, e.NewErrorf(ErrClassInternal, msgCommitShaNotFound, commit.ParentId(0))
+	}
 	r.Log.Debug("Changes are based on parent %v", p)
 
 	t2, err := p.Tree()
@@ -197,16 +254,56 @@ func (r *libgitRepo) WalkBlobs(commit Commit, callback BlobWalkCallback) error {
 		return err
 	}
 
+	return walkTree(tree, "", commit.(*libgitCommit), callback)
+}
+
+func (r *libgitRepo) WalkBlobsInDir(commit Commit, dir string, callback BlobWalkCallback) error {
+	dir = strings.Trim(dir, "/")
+	if dir == "" {
+		return r.WalkBlobs(commit, callback)
+	}
+
+	c := commit.(*libgitCommit)
+	tree, err := c.Tree()
+	if err != nil {
+		return err
+	}
+
+	entry, err := tree.EntryByPath(dir)
+	if err != nil {
+		return e.Wrapf(ErrClassInternal, err, "error while fetching the tree entry for %s", dir)
+	}
+
+	if entry.Type == git.ObjectBlob {
+		parent := path.Dir(dir) + "/"
+		if parent == "./" {
+			parent = ""
+		}
+		return callback(&libgitBlob{entry: entry, path: parent, commit: c})
+	}
+
+	subtree, err := r.Repo.LookupTree(entry.Id)
+	if err != nil {
+		return e.Wrapf(ErrClassInternal, err, "error while fetching the tree entry for %s", dir)
+	}
+	defer subtree.Free()
+
+	return walkTree(subtree, dir+"/", c, callback)
+}
+
+// walkTree invokes the callback for each blob in a tree.
+// prefix is the path of the tree in the commit with a trailing slash.
+func walkTree(tree *git.Tree, prefix string, commit *libgitCommit, callback BlobWalkCallback) error {
 	var (
 		walkErr error
 	)
 
-	err = tree.Walk(func(path string, entry *git.TreeEntry) int {
+	err := tree.Walk(func(path string, entry *git.TreeEntry) int {
 		if entry.Type == git.ObjectBlob {
 			b := &libgitBlob{
 				entry:  entry,
-				path:   path,
-				commit: commit.(*libgitCommit),
+				path:   prefix + path,
+				commit: commit,
 			}
 			walkErr = callback(b)
 			if walkErr != nil {
@@ -251,13 +348,53 @@ func (r *libgitRepo) EntryID(commit Commit, path string) (string, error) {
 }
 
 func (r *libgitRepo) BranchCommit(name string) (Commit, error) {
+	if name == "" {
+		return r.defaultBranchCommit()
+	}
+
 	repo := r.Repo
 	ref, err := repo.References.Dwim(name)
 	if err != nil {
+		// Name is not a reference, it could be a revision such as
+		// HEAD~3, @{upstream} or :/message.
+		if c, rerr := r.revision(name); rerr == nil {
+			return c, nil
+		}
+
+		if detached, _ := repo.IsHeadDetached(); detached {
+			head, herr := repo.Head()
+			if herr == nil {
+				defer head.Free()
+				sha := head.Target().String()
+				return nil, e.Wrapf(ErrClassUser, err, msgFailedBranchLookupInDetachedHead, name, sha, sha)
+			}
+		}
 		return nil, e.Wrapf(ErrClassUser, err, msgFailedBranchLookup, name)
 	}
+	defer ref.Free()
 
-	return r.GetCommit(ref.Target().String())
+	// Peel the reference so that annotated tags resolve to the
+	// commits they point to.
+	obj, err := ref.Peel(git.ObjectCommit)
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedBranchLookup, name)
+	}
+	defer obj.Free()
+
+	return r.GetCommit(obj.Id().String())
+}
+
+// defaultBranchCommit returns the commit of the branch used when a
+// branch is not specified. That is master, or the commit checked out
+// if the repository does not have a master branch (e.g. a checkout of
+// a tag in CI).
+func (r *libgitRepo) defaultBranchCommit() (Commit, error) {
+	if ref, err := r.Repo.References.Lookup("refs/heads/master"); err == nil {
+		ref.Free()
+		return r.BranchCommit("master")
+	}
+
+	return r.CurrentBranchCommit()
 }
 
 func (r *libgitRepo) CurrentBranch() (string, error) {
@@ -266,6 +403,13 @@ func (r *libgitRepo) CurrentBranch() (string, error) {
 		return "", e.Wrap(ErrClassInternal, err)
 	}
 
+	defer head.Free()
+
+	if detached, _ := r.Repo.IsHeadDetached(); detached {
+		sha := head.Target().String()
+		return "", e.NewErrorf(ErrClassUser, msgDetachedHead, sha, sha)
+	}
+
 	name, err := head.Branch().Name()
 	if err != nil {
 		return "", e.Wrap(ErrClassInternal, err)
@@ -275,6 +419,18 @@ func (r *libgitRepo) CurrentBranch() (string, error) {
 }
 
 func (r *libgitRepo) CurrentBranchCommit() (Commit, error) {
+	// Commit checked out is used when the head is detached
+	// (e.g. checkouts of tags or commits in CI).
+	if detached, _ := r.Repo.IsHeadDetached(); detached {
+		head, err := r.Repo.Head()
+		if err != nil {
+			return nil, e.Wrap(ErrClassInternal, err)
+		}
+		defer head.Free()
+
+		return r.GetCommit(head.Target().String())
+	}
+
 	b, err := r.CurrentBranch()
 	if err != nil {
 		return nil, err
@@ -292,7 +448,37 @@ func (r *libgitRepo) IsEmpty() (bool, error) {
 	return empty, nil
 }
 
+func (r *libgitRepo) IsShallow() (bool, error) {
+	shallow, err := r.Repo.IsShallow()
+	if err != nil {
+		return false, e.Wrap(ErrClassInternal, err)
+	}
+
+	return shallow, nil
+}
+
+func (r *libgitRepo) IsHeadDetached() (bool, error) {
+	detached, err := r.Repo.IsHeadDetached()
+	if err != nil {
+		return false, e.Wrap(ErrClassInternal, err)
+	}
+
+	return detached, nil
+}
+
+func (r *libgitRepo) IsIgnored(path string) (bool, error) {
+	ignored, err := r.Repo.IsPathIgnored(path)
+	if err != nil {
+		return false, e.Wrap(ErrClassInternal, err)
+	}
+	return ignored, nil
+}
+
 func (r *libgitRepo) FindAllFilesInWorkspace(pathSpec []string) ([]string, error) {
+	if err := r.ensureWorkingTree(); err != nil {
+		return nil, err
+	}
+
 	var configPaths []string
 	status, err := r.Repo.StatusList(&git.StatusOptions{
 		Flags:    git.StatusOptIncludeUntracked | git.StatusOptIncludeUnmodified | git.StatusOptRecurseUntrackedDirs,
@@ -334,6 +520,10 @@ func (r *libgitRepo) FindAllFilesInWorkspace(pathSpec []string) ([]string, error
 }
 
 func (r *libgitRepo) EnsureSafeWorkspace() error {
+	if err := r.ensureWorkingTree(); err != nil {
+		return err
+	}
+
 	status, err := r.Repo.StatusList(&git.StatusOptions{
 		Flags: git.StatusOptIncludeUntracked,
 	})
@@ -349,8 +539,36 @@ func (r *libgitRepo) EnsureSafeWorkspace() error {
 		return e.Wrap(ErrClassInternal, err)
 	}
 
-	if count > 0 {
-		r.Log.Debug("Workspace has %v changes", count)
+	sparse, err := r.SparseCheckout()
+	if err != nil {
+		return err
+	}
+
+	changes := 0
+	for c := 0; c < count; c++ {
+		entry, err := status.ByIndex(c)
+		if err != nil {
+			return e.Wrap(ErrClassInternal, err)
+		}
+
+		if entry.Status == git.StatusWtDeleted && !sparse.Includes(entry.IndexToWorkdir.OldFile.Path) {
+			continue
+		}
+
+		if entry.Status == git.StatusWtModified {
+			smudged, err := r.isSmudgedLFSObject(entry.IndexToWorkdir)
+			if err != nil {
+				return err
+			}
+			if smudged {
+				continue
+			}
+		}
+		changes++
+	}
+
+	if changes > 0 {
+		r.Log.Debug("Workspace has %v changes", changes)
 		r.Log.Debug("Begin tracing all changes")
 		for c := 0; c < count; c++ {
 			entry, err := status.ByIndex(c)
@@ -386,6 +604,10 @@ func (r *libgitRepo) BlobContentsFromTree(commit Commit, path string) ([]byte, e
 }
 
 func (r *libgitRepo) Checkout(commit Commit) (Reference, error) {
+	if err := r.ensureWorkingTree(); err != nil {
+		return nil, err
+	}
+
 	ref, err := r.Repo.Head()
 	if err != nil {
 		return nil, e.Wrap(ErrClassInternal, err)
@@ -394,6 +616,25 @@ func (r *libgitRepo) Checkout(commit Commit) (Reference, error) {
 	reference := &libgitReference{reference: ref}
 
 	gitCommit := commit.(*libgitCommit)
+	sparse, err := r.SparseCheckout()
+	if err != nil {
+		return nil, err
+	}
+
+	// libgit2 does not retain the files excluded from a sparse
+	// checkout, therefore only the commit in the workspace is used.
+	if sparse != nil {
+		if !ref.Target().Equal(gitCommit.commit.Id()) {
+			return nil, e.NewErrorf(ErrClassUser, msgCheckoutInSparseCheckout, commit.ID())
+		}
+
+		err = r.Repo.SetHeadDetached(gitCommit.commit.Id())
+		if err != nil {
+			return nil, e.Wrap(ErrClassInternal, err)
+		}
+		return reference, nil
+	}
+
 	tree, err := gitCommit.Tree()
 	if err != nil {
 		return nil, e.Wrap(ErrClassInternal, err)
@@ -424,6 +665,20 @@ func (r *libgitRepo) CheckoutReference(reference Reference) error {
 		return err
 	}
 
+	sparse, err := r.SparseCheckout()
+	if err != nil {
+		return err
+	}
+
+	if sparse != nil {
+		// See Checkout, workspace of a sparse checkout is not changed.
+		err = r.Repo.SetHead(gitRef.Name())
+		if err != nil {
+			return e.Wrap(ErrClassInternal, err)
+		}
+		return nil
+	}
+
 	tree, err := commit.Tree()
 	if err != nil {
 		return err
@@ -443,6 +698,10 @@ func (r *libgitRepo) CheckoutReference(reference Reference) error {
 }
 
 func (r *libgitRepo) MergeBase(a, b Commit) (Commit, error) {
+	if boundary := r.shallowCommits(); len(boundary) > 0 {
+		return r.shallowMergeBase(a, b, boundary)
+	}
+
 	bid, err := r.Repo.MergeBase(a.(*libgitCommit).commit.Id(), b.(*libgitCommit).commit.Id())
 	if err != nil {
 		return nil, e.Wrap(ErrClassInternal, err)
@@ -451,6 +710,522 @@ func (r *libgitRepo) MergeBase(a, b Commit) (Commit, error) {
 	return r.GetCommit(bid.String())
 }
 
+func (r *libgitRepo) ResolveCommit(rev string) (Commit, error) {
+	c, err := r.revision(rev)
+	if err != nil {
+		if r.shallow() {
+			return nil, e.Wrapf(ErrClassUser, err, msgRevisionNotFoundInShallowClone, rev)
+		}
+		return nil, e.Wrapf(ErrClassUser, err, msgRevisionNotFound, rev)
+	}
+
+	return c, nil
+}
+
+// revision resolves a revision in the syntax accepted by git rev-parse
+// (e.g. origin/feature, v1.0.0, HEAD~3, :/message or @{upstream}) to
+// the commit it points to.
+func (r *libgitRepo) revision(rev string) (Commit, error) {
+	obj, err := r.Repo.RevparseSingle(rev)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Free()
+
+	c, err := obj.Peel(git.ObjectCommit)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Free()
+
+	commit, err := r.Repo.LookupCommit(c.Id())
+	if err != nil {
+		return nil, err
+	}
+
+	return &libgitCommit{commit: commit}, nil
+}
+
+func (r *libgitRepo) History(from, to Commit) ([]*LogEntry, error) {
+	if boundary := r.shallowCommits(); len(boundary) > 0 {
+		return r.shallowHistory(from, to, boundary)
+	}
+
+	walk, err := r.Repo.Walk()
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+	defer walk.Free()
+
+	walk.Sorting(git.SortTopological | git.SortTime)
+	if err := walk.Push(to.(*libgitCommit).commit.Id()); err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	if from != nil {
+		if err := walk.Hide(from.(*libgitCommit).commit.Id()); err != nil {
+			return nil, e.Wrap(ErrClassInternal, err)
+		}
+	}
+
+	entries := make([]*LogEntry, 0)
+	err = walk.Iterate(func(c *git.Commit) bool {
+		author := c.Author()
+		entries = append(entries, &LogEntry{
+			Commit:  &libgitCommit{commit: c},
+			Message: c.Message(),
+			Author:  author.Name,
+			Email:   author.Email,
+			Time:    author.When,
+			Parents: int(c.ParentCount()),
+		})
+		return true
+	})
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	return entries, nil
+}
+
+// shallowHistory walks the history of a shallow clone. libgit2 does not
+// treat the commits at the shallow boundary as roots, therefore the
+// history is walked without following the parents of those commits.
+// Boundary commits are excluded since their changes cannot be
+// determined without their parents.
+func (r *libgitRepo) shallowHistory(from, to Commit, boundary map[string]bool) ([]*LogEntry, error) {
+	hidden := make(map[string]bool)
+	if from != nil {
+		err := walkShallowHistory(from.(*libgitCommit).commit, boundary, func(c *git.Commit) {
+			hidden[c.Id().String()] = true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	truncated := false
+	entries := make([]*LogEntry, 0)
+	err := walkShallowHistory(to.(*libgitCommit).commit, boundary, func(c *git.Commit) {
+		id := c.Id().String()
+		if hidden[id] {
+			return
+		}
+		if boundary[id] {
+			truncated = true
+			return
+		}
+
+		author := c.Author()
+		entries = append(entries, &LogEntry{
+			Commit:  &libgitCommit{commit: c},
+			Message: c.Message(),
+			Author:  author.Name,
+			Email:   author.Email,
+			Time:    author.When,
+			Parents: int(c.ParentCount()),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if truncated {
+		r.Log.Warnf(msgHistoryTruncatedInShallowClone)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.After(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// shallowMergeBase finds the merge base of two commits in a shallow
+// clone. libgit2 fails to find the merge base if it walks past the
+// shallow boundary, even when the merge base is within the history
+// available.
+// Merge bases are the common ancestors which are not ancestors of other
+// common ancestors. Newest of them is used if there are many.
+func (r *libgitRepo) shallowMergeBase(a, b Commit, boundary map[string]bool) (Commit, error) {
+	ancestors := make(map[string]bool)
+	err := walkShallowHistory(a.(*libgitCommit).commit, boundary, func(c *git.Commit) {
+		ancestors[c.Id().String()] = true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	common := make([]*git.Commit, 0)
+	err = walkShallowHistory(b.(*libgitCommit).commit, boundary, func(c *git.Commit) {
+		if ancestors[c.Id().String()] {
+			common = append(common, c)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	redundant := make(map[string]bool)
+	for _, c := range common {
+		if boundary[c.Id().String()] {
+			continue
+		}
+		for i := uint(0); i < c.ParentCount(); i++ {
+			p := c.Parent(i)
+			if p == nil || redundant[p.Id().String()] {
+				continue
+			}
+			err := walkShallowHistory(p, boundary, func(c *git.Commit) {
+				redundant[c.Id().String()] = true
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var base *git.Commit
+	for _, c := range common {
+		if !redundant[c.Id().String()] && (base == nil || c.Committer().When.After(base.Committer().When)) {
+			base = c
+		}
+	}
+
+	if base == nil {
+		return nil, e.NewErrorf(ErrClassUser, msgMergeBaseNotFoundInShallowClone, a, b)
+	}
+
+	return &libgitCommit{commit: base}, nil
+}
+
+// walkShallowHistory invokes the callback for each commit reachable from
+// start without following the parents of the commits in boundary.
+func walkShallowHistory(start *git.Commit, boundary map[string]bool, callback func(*git.Commit)) error {
+	seen := map[string]bool{start.Id().String(): true}
+	queue := []*git.Commit{start}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		callback(c)
+
+		if boundary[c.Id().String()] {
+			continue
+		}
+
+		for i := uint(0); i < c.ParentCount(); i++ {
+			id := c.ParentId(i).String()
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			p := c.Parent(i)
+			if p == nil {
+				return e.NewErrorf(ErrClassInternal, msgCommitShaNotFound, id)
+			}
+			queue = append(queue, p)
+		}
+	}
+	return nil
+}
+
+// shallow informs if the repository is a shallow clone.
+func (r *libgitRepo) shallow() bool {
+	shallow, err := r.Repo.IsShallow()
+	return err == nil && shallow
+}
+
+// shallowCommits returns the commits at the boundary of a shallow clone.
+// Parents of these commits are not available in the repository.
+func (r *libgitRepo) shallowCommits() map[string]bool {
+	commits := make(map[string]bool)
+	f, err := os.Open(filepath.Join(r.commonDir(), "shallow"))
+	if err != nil {
+		return commits
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			commits[id] = true
+		}
+	}
+	return commits
+}
+
+// commonDir returns the git directory of the main repository, which is
+// shared by the linked worktrees.
+func (r *libgitRepo) commonDir() string {
+	dir := r.GitDir()
+	buff, err := ioutil.ReadFile(filepath.Join(dir, "commondir"))
+	if err != nil {
+		return dir
+	}
+
+	common := strings.TrimSpace(string(buff))
+	if !filepath.IsAbs(common) {
+		common = filepath.Join(dir, common)
+	}
+	return common
+}
+
+// isPartialClone informs if the repository at path is a partial clone
+// (i.e. cloned with --filter). libgit2 cannot open partial clones
+// since they use the repository format version 1.
+func isPartialClone(path string) bool {
+	for _, p := range []string{filepath.Join(path, ".git", "config"), filepath.Join(path, "config")} {
+		if _, err := os.Stat(p); err != nil {
+			continue
+		}
+
+		config, err := git.OpenOndisk(nil, p)
+		if err != nil {
+			return false
+		}
+		defer config.Free()
+
+		if v, err := config.LookupString("extensions.partialclone"); err == nil && v != "" {
+			return true
+		}
+
+		iter, err := config.NewIteratorGlob(`remote\..*\.promisor`)
+		if err != nil {
+			return false
+		}
+		defer iter.Free()
+
+		for {
+			entry, err := iter.Next()
+			if err != nil {
+				return false
+			}
+			if strings.EqualFold(entry.Value, "true") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *libgitRepo) References() ([]string, error) {
+	iter, err := r.Repo.NewReferenceIterator()
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+	defer iter.Free()
+
+	names := make([]string, 0)
+	for {
+		ref, err := iter.Next()
+		if err != nil {
+			if git.IsErrorCode(err, git.ErrIterOver) {
+				break
+			}
+			return nil, e.Wrap(ErrClassInternal, err)
+		}
+
+		if ref.IsBranch() || ref.IsRemote() || ref.IsTag() {
+			names = append(names, ref.Shorthand())
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// ensureWorkingTree returns an error if the repository is bare.
+func (r *libgitRepo) ensureWorkingTree() error {
+	if r.Repo.IsBare() {
+		return e.NewErrorf(ErrClassUser, msgNoWorkingTree, r.path)
+	}
+	return nil
+}
+
+func (r *libgitRepo) SparseCheckout() (*SparseCheckout, error) {
+	r.sparseOnce.Do(func() {
+		r.sparseCheckout, r.sparseErr = r.readSparseCheckout()
+	})
+
+	return r.sparseCheckout, r.sparseErr
+}
+
+func (r *libgitRepo) readSparseCheckout() (*SparseCheckout, error) {
+	config, err := r.Repo.Config()
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+	defer config.Free()
+
+	// git sparse-checkout writes the configuration of each worktree
+	// to config.worktree, which is not read by libgit2.
+	if worktreeConfig, _ := config.LookupBool("extensions.worktreeConfig"); worktreeConfig {
+		p := filepath.Join(r.GitDir(), "config.worktree")
+		if _, err := os.Stat(p); err == nil {
+			err = config.AddFile(p, git.ConfigLevelApp, false)
+			if err != nil {
+				return nil, e.Wrap(ErrClassInternal, err)
+			}
+		}
+	}
+
+	enabled, err := config.LookupBool("core.sparseCheckout")
+	if err != nil || !enabled {
+		return nil, nil
+	}
+
+	// Patterns are specific to each worktree.
+	file := filepath.Join(r.GitDir(), "info", "sparse-checkout")
+	buff, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, e.Wrapf(ErrClassInternal, err, msgFailedReadFile, file)
+	}
+
+	return parseSparseCheckout(buff, file)
+}
+
+func (r *libgitRepo) HooksDir() (string, error) {
+	config, err := r.Repo.Config()
+	if err != nil {
+		return "", e.Wrap(ErrClassInternal, err)
+	}
+	defer config.Free()
+
+	// Relative core.hooksPath is resolved from the root of the working
+	// tree (or the git directory of a bare repository) like git does.
+	if p, err := config.LookupString("core.hooksPath"); err == nil && p != "" {
+		if !filepath.IsAbs(p) {
+			root := r.Path()
+			if r.Repo.IsBare() {
+				root = r.GitDir()
+			}
+			p = filepath.Join(root, p)
+		}
+
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return "", e.Wrap(ErrClassInternal, err)
+		}
+		return abs, nil
+	}
+
+	// Hooks are shared by the linked worktrees.
+	return filepath.Join(r.commonDir(), "hooks"), nil
+}
+
+func (r *libgitRepo) LFSObjectID(blob Blob) (string, error) {
+	oid, err := git.NewOid(blob.ID())
+	if err != nil {
+		return "", e.Wrap(ErrClassInternal, err)
+	}
+
+	return r.lfsObjectID(oid)
+}
+
+func (r *libgitRepo) lfsObjectID(oid *git.Oid) (string, error) {
+	odb, err := r.Repo.Odb()
+	if err != nil {
+		return "", e.Wrap(ErrClassInternal, err)
+	}
+
+	// Blobs larger than a pointer file are not read.
+	size, _, err := odb.ReadHeader(oid)
+	if err != nil {
+		return "", e.Wrap(ErrClassInternal, err)
+	}
+
+	if size > lfsPointerMaxSize {
+		return "", nil
+	}
+
+	b, err := r.Repo.LookupBlob(oid)
+	if err != nil {
+		return "", e.Wrap(ErrClassInternal, err)
+	}
+
+	id, _ := parseLFSPointer(b.Contents())
+	return id, nil
+}
+
+// isSmudgedLFSObject informs if a modification in the workspace is the
+// Git LFS object replacing the pointer file in the index.
+func (r *libgitRepo) isSmudgedLFSObject(delta git.DiffDelta) (bool, error) {
+	if delta.Status != git.DeltaModified || delta.OldFile.Oid == nil || delta.OldFile.Oid.IsZero() {
+		return false, nil
+	}
+
+	id, err := r.lfsObjectID(delta.OldFile.Oid)
+	if err != nil || id == "" {
+		return false, err
+	}
+
+	return isLFSObject(filepath.Join(r.path, filepath.FromSlash(delta.NewFile.Path)), id)
+}
+
+func (r *libgitRepo) Submodules(commit Commit) ([]*Submodule, error) {
+	submodules := make([]*Submodule, 0)
+	if commit == nil {
+		index, err := r.Repo.Index()
+		if err != nil {
+			return nil, e.Wrap(ErrClassInternal, err)
+		}
+
+		for i := uint(0); i < index.EntryCount(); i++ {
+			entry, err := index.EntryByIndex(i)
+			if err != nil {
+				return nil, e.Wrap(ErrClassInternal, err)
+			}
+
+			if entry.Mode == git.FilemodeCommit {
+				submodules = append(submodules, &Submodule{Path: entry.Path, Commit: entry.Id.String()})
+			}
+		}
+
+		return submodules, nil
+	}
+
+	tree, err := commit.(*libgitCommit).Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	err = tree.Walk(func(dir string, entry *git.TreeEntry) int {
+		if entry.Filemode == git.FilemodeCommit {
+			submodules = append(submodules, &Submodule{Path: dir + entry.Name, Commit: entry.Id.String()})
+		}
+		return 0
+	})
+	if err != nil {
+		return nil, e.Wrapf(ErrClassInternal, err, msgFailedTreeWalk, tree.Id())
+	}
+
+	return submodules, nil
+}
+
+func (r *libgitRepo) OpenSubmodule(path string) (Repo, error) {
+	dir := filepath.Join(r.path, filepath.FromSlash(path))
+	repo, err := git.OpenRepository(dir)
+	if err != nil {
+		// Submodules initialised but not checked out only have
+		// their git directory in the modules directory of the
+		// containing repository. Name of a submodule is its path
+		// unless it was renamed in .gitmodules.
+		repo, err = git.OpenRepository(filepath.Join(r.commonDir(), "modules", filepath.FromSlash(path)))
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgSubmoduleNotInitialised, path)
+		}
+	}
+
+	return &libgitRepo{
+		path: dir,
+		Repo: repo,
+		Log:  r.Log,
+	}, nil
+}
+
 func diff(repo *git.Repository, ca, cb Commit) (*git.Diff, error) {
 	t1, err := ca.(*libgitCommit).Tree()
 	if err != nil {
@@ -471,6 +1246,12 @@ func diff(repo *git.Repository, ca, cb Commit) (*git.Diff, error) {
 }
 
 func deltas(diff *git.Diff) ([]*DiffDelta, error) {
+	return filteredDeltas(diff, nil)
+}
+
+// filteredDeltas returns the deltas in a diff except the ones skip
+// returns true for.
+func filteredDeltas(diff *git.Diff, skip func(git.DiffDelta) (bool, error)) ([]*DiffDelta, error) {
 	count, err := diff.NumDeltas()
 	if err != nil {
 		return nil, e.Wrap(ErrClassInternal, err)
@@ -478,9 +1259,17 @@ func deltas(diff *git.Diff) ([]*DiffDelta, error) {
 
 	deltas := make([]*DiffDelta, 0, count)
 	err = diff.ForEach(func(delta git.DiffDelta, num float64) (git.DiffForEachHunkCallback, error) {
+		if skip != nil {
+			skipped, err := skip(delta)
+			if err != nil || skipped {
+				return nil, err
+			}
+		}
+
 		deltas = append(deltas, &DiffDelta{
-			OldFile: delta.OldFile.Path,
-			NewFile: delta.NewFile.Path,
+			OldFile:   delta.OldFile.Path,
+			NewFile:   delta.NewFile.Path,
+			Submodule: git.Filemode(delta.NewFile.Mode) == git.FilemodeCommit || git.Filemode(delta.OldFile.Mode) == git.FilemodeCommit,
 		})
 		return nil, nil
 	}, git.DiffDetailFiles)
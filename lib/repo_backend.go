@@ -0,0 +1,136 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// RepoBackendLibgit2 is the repository backend implemented with
+	// libgit2.
+	RepoBackendLibgit2 = "libgit2"
+	// repoBackendEnv is the environment variable selecting the
+	// repository backend.
+	repoBackendEnv = "MBT_GIT_BACKEND"
+)
+
+// RepoBackend opens the repository at path.
+type RepoBackend func(path string, log Log) (Repo, error)
+
+// repoBackends are the available repository backends keyed by name.
+// libgit2 backend is registered unless mbt is built with nolibgit2 tag
+// (see repo_backend_libgit2.go).
+var repoBackends = map[string]RepoBackend{
+	RepoBackendGit:       NewGitRepo,
+	RepoBackendGoGit:     NewGoGitRepo,
+	RepoBackendMercurial: NewMercurialRepo,
+	RepoBackendSapling:   NewSaplingRepo,
+}
+
+// repoBackendDirs are the directories of repository metadata used to
+// select the backend when MBT_GIT_BACKEND is not specified.
+var repoBackendDirs = []struct {
+	dir     string
+	backend string
+}{
+	{".git", defaultGitBackend},
+	{".sl", RepoBackendSapling},
+	{".hg", RepoBackendMercurial},
+}
+
+// RegisterRepoBackend makes a repository backend available for
+// selection with MBT_GIT_BACKEND environment variable.
+func RegisterRepoBackend(name string, backend RepoBackend) {
+	repoBackends[name] = backend
+}
+
+// RepoBackends returns the names of the available repository backends.
+func RepoBackends() []string {
+	names := make([]string, 0, len(repoBackends))
+	for n := range repoBackends {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// detectRepoBackend returns the backend of the repository at path
+// based on the directory containing its metadata (the default git
+// backend if it is not found).
+func detectRepoBackend(path string) string {
+	for _, d := range repoBackendDirs {
+		if _, err := os.Stat(filepath.Join(path, d.dir)); err == nil {
+			return d.backend
+		}
+	}
+
+	return defaultGitBackend
+}
+
+type repoBackendKey struct{}
+
+// WithRepoBackend returns a context selecting the repository backend
+// of the systems created with it (see NewSystemWithContext) instead of
+// MBT_GIT_BACKEND environment variable.
+func WithRepoBackend(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, repoBackendKey{}, name)
+}
+
+func repoBackendFrom(ctx context.Context) string {
+	name, _ := ctx.Value(repoBackendKey{}).(string)
+	return name
+}
+
+// openRepo opens the repository at path with the specified backend or,
+// if it is empty, the backend specified in MBT_GIT_BACKEND environment
+// variable. If neither is specified, the backend is detected from the
+// repository (libgit2 by default or, go-git if mbt is built with
+// nolibgit2 tag).
+// Repositories specified by a url are fetched into the cache directory.
+func openRepo(path, name string, log Log) (Repo, error) {
+	remote := isRemoteRepo(path)
+	if name == "" {
+		name = os.Getenv(repoBackendEnv)
+	}
+	if name == "" {
+		name = defaultGitBackend
+		if !remote {
+			name = detectRepoBackend(path)
+		}
+	}
+
+	backend, ok := repoBackends[name]
+	if !ok {
+		return nil, e.NewErrorf(ErrClassUser, msgUnknownRepoBackend, name, strings.Join(RepoBackends(), ", "))
+	}
+
+	if remote {
+		dir, err := fetchRemoteRepo(path, log)
+		if err != nil {
+			return nil, err
+		}
+		path = dir
+	}
+
+	return backend(path, log)
+}
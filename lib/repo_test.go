@@ -17,8 +17,14 @@ package lib
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	git "github.com/libgit2/git2go"
 	"github.com/mbtproject/mbt/e"
 	"github.com/stretchr/testify/assert"
 )
@@ -232,3 +238,364 @@ func TestGitIgnoreRulesInSafeWorkspace(t *testing.T) {
 	w := NewWorld(t, ".tmp/repo")
 	check(t, w.Repo.EnsureSafeWorkspace())
 }
+
+// makeShallow turns the test repository to a shallow clone with the
+// boundary at the specified commits. Objects of the pruned commits are
+// removed like in a shallow clone.
+func makeShallow(t *testing.T, repo *TestRepository, boundary []*git.Oid, pruned ...*git.Oid) {
+	ids := make([]string, 0, len(boundary))
+	for _, b := range boundary {
+		ids = append(ids, b.String())
+	}
+	check(t, ioutil.WriteFile(filepath.Join(repo.Dir, ".git", "shallow"), []byte(strings.Join(ids, "\n")+"\n"), 0644))
+
+	for _, p := range pruned {
+		id := p.String()
+		check(t, os.Remove(filepath.Join(repo.Dir, ".git", "objects", id[:2], id[2:])))
+	}
+}
+
+func initShallowRepo(t *testing.T) (*TestRepository, []*git.Oid) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	commits := make([]*git.Oid, 0)
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.Commit("first"))
+	commits = append(commits, repo.LastCommit)
+
+	check(t, repo.WriteContent("app-a/foo", "a"))
+	check(t, repo.Commit("second"))
+	commits = append(commits, repo.LastCommit)
+
+	check(t, repo.SwitchToBranch("feature"))
+	check(t, repo.WriteContent("app-b/foo", "b"))
+	check(t, repo.Commit("third"))
+	commits = append(commits, repo.LastCommit)
+
+	check(t, repo.SwitchToBranch("master"))
+	check(t, repo.WriteContent("app-a/bar", "a"))
+	check(t, repo.Commit("fourth"))
+	commits = append(commits, repo.LastCommit)
+
+	return repo, commits
+}
+
+func TestMergeBaseInShallowClone(t *testing.T) {
+	repo, commits := initShallowRepo(t)
+	makeShallow(t, repo, []*git.Oid{commits[1]}, commits[0])
+
+	world := NewWorld(t, ".tmp/repo")
+	r := world.Repo
+	feature, err := r.GetCommit(commits[2].String())
+	check(t, err)
+	master, err := r.GetCommit(commits[3].String())
+	check(t, err)
+
+	base, err := r.MergeBase(feature, master)
+	check(t, err)
+	assert.Equal(t, commits[1].String(), base.ID())
+
+	m, err := world.System.ManifestByPr("feature", "master")
+	check(t, err)
+	assert.Equal(t, []string{"app-b"}, moduleNames(m.Modules))
+}
+
+func TestMergeBaseBeyondShallowBoundary(t *testing.T) {
+	repo, commits := initShallowRepo(t)
+	makeShallow(t, repo, []*git.Oid{commits[2], commits[3]}, commits[0], commits[1])
+
+	r := NewWorld(t, ".tmp/repo").Repo
+	feature, err := r.GetCommit(commits[2].String())
+	check(t, err)
+	master, err := r.GetCommit(commits[3].String())
+	check(t, err)
+
+	_, err = r.MergeBase(feature, master)
+
+	assert.EqualError(t, err, fmt.Sprintf(msgMergeBaseNotFoundInShallowClone, feature, master))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestCommitBeyondShallowBoundary(t *testing.T) {
+	repo, commits := initShallowRepo(t)
+	makeShallow(t, repo, []*git.Oid{commits[1]}, commits[0])
+
+	r := NewWorld(t, ".tmp/repo").Repo
+	_, err := r.GetCommit(commits[0].String())
+
+	assert.EqualError(t, err, fmt.Sprintf(msgCommitNotFoundInShallowClone, commits[0]))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+
+	_, err = r.ResolveCommit("master~3")
+	assert.EqualError(t, err, fmt.Sprintf(msgRevisionNotFoundInShallowClone, "master~3"))
+}
+
+func TestChangesOfShallowBoundary(t *testing.T) {
+	repo, commits := initShallowRepo(t)
+	makeShallow(t, repo, []*git.Oid{commits[1]}, commits[0])
+
+	r := NewWorld(t, ".tmp/repo").Repo
+	c, err := r.GetCommit(commits[1].String())
+	check(t, err)
+
+	_, err = r.Changes(c)
+
+	assert.EqualError(t, err, fmt.Sprintf(msgParentNotFoundInShallowClone, commits[1]))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestHistoryOfShallowClone(t *testing.T) {
+	repo, commits := initShallowRepo(t)
+	makeShallow(t, repo, []*git.Oid{commits[1]}, commits[0])
+
+	r := NewWorld(t, ".tmp/repo").Repo
+	to, err := r.GetCommit(commits[3].String())
+	check(t, err)
+
+	history, err := r.History(nil, to)
+	check(t, err)
+
+	assert.Len(t, history, 1)
+	assert.Equal(t, commits[3].String(), history[0].Commit.ID())
+
+	c, err := NewWorld(t, ".tmp/repo").System.Changelog("app-a", "", "master")
+	check(t, err)
+	assert.Len(t, c.Groups, 1)
+}
+
+func TestOpenPartialClone(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, ioutil.WriteFile(filepath.Join(repo.Dir, ".git", "config"), []byte(`[core]
+	repositoryformatversion = 1
+[remote "origin"]
+	url = https://example.com/repo.git
+	promisor = true
+	partialclonefilter = blob:none
+`), 0644))
+
+	_, err := NewLibgitRepo(".tmp/repo", NewStdLog(LogLevelNormal))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgPartialCloneNotSupported, ".tmp/repo"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+// addWorktree creates a linked worktree of the test repository at dir
+// with the branch checked out, like git worktree add.
+func addWorktree(t *testing.T, repo *TestRepository, dir, branch string) {
+	abs, err := filepath.Abs(dir)
+	check(t, err)
+
+	gitDir := filepath.Join(repo.Repo.Path(), "worktrees", filepath.Base(abs))
+	check(t, os.MkdirAll(gitDir, 0755))
+	check(t, os.MkdirAll(abs, 0755))
+	check(t, ioutil.WriteFile(filepath.Join(gitDir, "HEAD"), []byte("ref: refs/heads/"+branch+"\n"), 0644))
+	check(t, ioutil.WriteFile(filepath.Join(gitDir, "commondir"), []byte("../..\n"), 0644))
+	check(t, ioutil.WriteFile(filepath.Join(gitDir, "gitdir"), []byte(filepath.Join(abs, ".git")+"\n"), 0644))
+	check(t, ioutil.WriteFile(filepath.Join(abs, ".git"), []byte("gitdir: "+gitDir+"\n"), 0644))
+
+	wt, err := git.OpenRepository(abs)
+	check(t, err)
+	defer wt.Free()
+	check(t, wt.CheckoutHead(&git.CheckoutOpts{Strategy: git.CheckoutForce}))
+}
+
+func TestWorktree(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+	check(t, repo.SwitchToBranch("feature"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.Commit("second"))
+	check(t, repo.SwitchToBranch("master"))
+	addWorktree(t, repo, ".tmp/worktree", "feature")
+
+	world := NewWorld(t, ".tmp/worktree")
+	branch, err := world.Repo.CurrentBranch()
+	check(t, err)
+	assert.Equal(t, "feature", branch)
+
+	m, err := world.System.ManifestByCurrentBranch()
+	check(t, err)
+	assert.Equal(t, []string{"app-a", "app-b"}, moduleNames(m.Modules))
+
+	check(t, ioutil.WriteFile(".tmp/worktree/app-b/foo", []byte("b"), 0644))
+	m, err = world.System.ManifestByWorkspaceChanges()
+	check(t, err)
+	assert.Equal(t, []string{"app-b"}, moduleNames(m.Modules))
+
+	// State of each worktree is stored in its git directory.
+	_, err = world.System.Completions()
+	check(t, err)
+	fi, err := os.Stat(filepath.Join(repo.Repo.Path(), "worktrees", "worktree", stateDirName))
+	check(t, err)
+	assert.True(t, fi.IsDir())
+}
+
+func TestBranchCommitForAnnotatedTag(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	c, err := repo.Repo.LookupCommit(repo.LastCommit)
+	check(t, err)
+	_, err = repo.Repo.Tags.Create("v1.0.0", c, &git.Signature{Name: "alice", Email: "alice@wonderland.com", When: time.Now()}, "release")
+	check(t, err)
+
+	commit, err := NewWorld(t, ".tmp/repo").Repo.BranchCommit("v1.0.0")
+	check(t, err)
+
+	assert.Equal(t, repo.LastCommit.String(), commit.ID())
+}
+
+func TestBranchCommitForRemoteBranch(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+	remote := repo.LastCommit
+	_, err := repo.Repo.References.Create("refs/remotes/origin/feature/x", remote, false, "")
+	check(t, err)
+
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.Commit("second"))
+
+	commit, err := NewWorld(t, ".tmp/repo").Repo.BranchCommit("origin/feature/x")
+	check(t, err)
+
+	assert.Equal(t, remote.String(), commit.ID())
+}
+
+func TestBranchCommitForUpstream(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+	upstream := repo.LastCommit
+	_, err := repo.Repo.References.Create("refs/remotes/origin/master", upstream, false, "")
+	check(t, err)
+
+	config, err := repo.Repo.Config()
+	check(t, err)
+	check(t, config.SetString("remote.origin.url", "https://example.com/repo.git"))
+	check(t, config.SetString("remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*"))
+	check(t, config.SetString("branch.master.remote", "origin"))
+	check(t, config.SetString("branch.master.merge", "refs/heads/master"))
+	config.Free()
+
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.Commit("second"))
+
+	commit, err := NewWorld(t, ".tmp/repo").Repo.BranchCommit("@{upstream}")
+	check(t, err)
+
+	assert.Equal(t, upstream.String(), commit.ID())
+}
+
+func TestRevisionsAsCommits(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+	first := repo.LastCommit.String()
+
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.Commit("second"))
+	check(t, repo.InitModule("app-c"))
+	check(t, repo.Commit("third"))
+
+	w := NewWorld(t, ".tmp/repo")
+	for _, rev := range []string{"HEAD~2", ":/first", first[:10], "master^^"} {
+		c, err := w.Repo.GetCommit(rev)
+		check(t, err)
+		assert.Equal(t, first, c.ID(), rev)
+
+		c, err = w.Repo.BranchCommit(rev)
+		check(t, err)
+		assert.Equal(t, first, c.ID(), rev)
+	}
+}
+
+func TestCurrentBranchInDetachedHead(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+	check(t, repo.CheckoutAndDetach(repo.LastCommit.String()))
+
+	w := NewWorld(t, ".tmp/repo")
+	_, err := w.Repo.CurrentBranch()
+
+	sha := repo.LastCommit.String()
+	assert.EqualError(t, err, fmt.Sprintf(msgDetachedHead, sha, sha))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+
+	commit, err := w.Repo.CurrentBranchCommit()
+	check(t, err)
+	assert.Equal(t, sha, commit.ID())
+}
+
+func TestInvalidBranchInDetachedHead(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+	check(t, repo.CheckoutAndDetach(repo.LastCommit.String()))
+
+	_, err := NewWorld(t, ".tmp/repo").Repo.BranchCommit("foo")
+
+	sha := repo.LastCommit.String()
+	assert.EqualError(t, err, fmt.Sprintf(msgFailedBranchLookupInDetachedHead, "foo", sha, sha))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestDefaultBranchCommit(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+	master := repo.LastCommit
+
+	check(t, repo.SwitchToBranch("feature"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.Commit("second"))
+
+	commit, err := NewWorld(t, ".tmp/repo").Repo.BranchCommit("")
+	check(t, err)
+
+	assert.Equal(t, master.String(), commit.ID())
+}
+
+func TestDefaultBranchCommitWithoutMaster(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	check(t, repo.SwitchToBranch("feature"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.Commit("second"))
+	check(t, repo.CheckoutAndDetach(repo.LastCommit.String()))
+
+	master, err := repo.Repo.References.Lookup("refs/heads/master")
+	check(t, err)
+	check(t, master.Delete())
+	master.Free()
+
+	commit, err := NewWorld(t, ".tmp/repo").Repo.BranchCommit("")
+	check(t, err)
+
+	assert.Equal(t, repo.LastCommit.String(), commit.ID())
+}
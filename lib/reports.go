@@ -0,0 +1,280 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// junitSuites is the root element of a junit report with multiple suites.
+type junitSuites struct {
+	XMLName  xml.Name
+	Suites   []*junitSuite `xml:"testsuite"`
+	Tests    int           `xml:"tests,attr"`
+	Failures int           `xml:"failures,attr"`
+	Errors   int           `xml:"errors,attr"`
+	Skipped  int           `xml:"skipped,attr"`
+}
+
+// junitSuite is a test suite in a junit report.
+// Content of the suite is retained as is.
+type junitSuite struct {
+	XMLName  xml.Name `xml:"testsuite"`
+	Name     string   `xml:"name,attr"`
+	Tests    int      `xml:"tests,attr"`
+	Failures int      `xml:"failures,attr"`
+	Errors   int      `xml:"errors,attr"`
+	Skipped  int      `xml:"skipped,attr"`
+	Time     string   `xml:"time,attr,omitempty"`
+	Content  []byte   `xml:",innerxml"`
+}
+
+// junitCases is used to count the test cases in a suite that does not
+// specify the totals.
+type junitCases struct {
+	Cases []struct {
+		Failure *struct{} `xml:"failure"`
+		Error   *struct{} `xml:"error"`
+		Skipped *struct{} `xml:"skipped"`
+	} `xml:"testcase"`
+}
+
+// reportCollector accumulates the test reports produced by the modules
+// in a build.
+type reportCollector struct {
+	mu     sync.Mutex
+	files  []string
+	suites []*junitSuite
+}
+
+// collect reads the test reports of a module modified since the build of
+// the module was started.
+// Suites are named after the module (and the variant) so that they can be
+// distinguished in the merged report.
+func (c *reportCollector) collect(manifest *Manifest, mod *Module, variant *Variant, since time.Time) error {
+	if len(mod.Reports()) == 0 {
+		return nil
+	}
+
+	dir := filepath.Join(manifest.Dir, mod.Path())
+	files, err := findFiles(dir, mod.Reports(), since)
+	if err != nil {
+		return e.Wrapf(ErrClassInternal, err, msgFailedReadTestReport, dir, mod.Name())
+	}
+
+	suites := make([]*junitSuite, 0)
+	for _, f := range files {
+		s, err := readReport(filepath.Join(dir, f))
+		if err != nil {
+			return e.Wrapf(ErrClassUser, err, msgFailedReadTestReport, f, mod.Name())
+		}
+
+		prefix := mod.Name()
+		if variant != nil {
+			prefix = fmt.Sprintf("%s[%s]", prefix, variant.Name)
+		}
+		for _, suite := range s {
+			suite.Name = strings.TrimSuffix(prefix+"/"+suite.Name, "/")
+		}
+		suites = append(suites, s...)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range files {
+		c.files = append(c.files, path.Join(mod.Path(), f))
+	}
+	c.suites = append(c.suites, suites...)
+	return nil
+}
+
+// report returns the summary of the reports collected.
+func (c *reportCollector) report() *TestReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.files) == 0 {
+		return nil
+	}
+
+	r := &TestReport{Files: append([]string{}, c.files...), Suites: len(c.suites)}
+	for _, s := range c.suites {
+		r.Tests += s.Tests
+		r.Failures += s.Failures
+		r.Errors += s.Errors
+		r.Skipped += s.Skipped
+	}
+	return r
+}
+
+// write writes the merged report to a file.
+func (c *reportCollector) write(file string) error {
+	r := c.report()
+	if r == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	merged := &junitSuites{
+		XMLName:  xml.Name{Local: "testsuites"},
+		Suites:   c.suites,
+		Tests:    r.Tests,
+		Failures: r.Failures,
+		Errors:   r.Errors,
+		Skipped:  r.Skipped,
+	}
+	buff, err := xml.MarshalIndent(merged, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return e.Wrapf(ErrClassInternal, err, msgFailedWriteTestReport, file)
+	}
+
+	err = ioutil.WriteFile(file, append([]byte(xml.Header), buff...), 0644)
+	if err != nil {
+		return e.Wrapf(ErrClassUser, err, msgFailedWriteTestReport, file)
+	}
+	return nil
+}
+
+func readReport(file string) ([]*junitSuite, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	root := &junitSuites{}
+	err = xml.Unmarshal(b, root)
+	if err != nil {
+		return nil, err
+	}
+
+	suites := root.Suites
+	if root.XMLName.Local == "testsuite" {
+		suite := &junitSuite{}
+		err = xml.Unmarshal(b, suite)
+		if err != nil {
+			return nil, err
+		}
+		suites = []*junitSuite{suite}
+	}
+
+	for _, s := range suites {
+		if s.Tests > 0 {
+			continue
+		}
+
+		cases := &junitCases{}
+		err = xml.Unmarshal(append(append([]byte("<testsuite>"), s.Content...), "</testsuite>"...), cases)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range cases.Cases {
+			s.Tests++
+			switch {
+			case c.Failure != nil:
+				s.Failures++
+			case c.Error != nil:
+				s.Errors++
+			case c.Skipped != nil:
+				s.Skipped++
+			}
+		}
+	}
+
+	return suites, nil
+}
+
+// findFiles returns the paths (relative to dir, in forward slash
+// form) of the files matching any of the patterns, modified since the
+// specified time.
+func findFiles(dir string, patterns []string, since time.Time) ([]string, error) {
+	files := make([]string, 0)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		for _, pattern := range patterns {
+			if matchGlob(pattern, rel) && !info.ModTime().Before(since) {
+				files = append(files, rel)
+				break
+			}
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return files, nil
+	}
+
+	sort.Strings(files)
+	return files, err
+}
+
+// matchGlob reports whether a slash separated path matches a pattern.
+// In addition to the syntax supported by path.Match, ** matches zero
+// or more directories.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSuiteReport = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuite name="unit" tests="2" failures="1" errors="0" skipped="0" time="0.5">
+  <testcase name="a" classname="x"></testcase>
+  <testcase name="b" classname="x"><failure message="boom"></failure></testcase>
+</testsuite>`
+
+const testSuitesReport = `<testsuites>
+  <testsuite name="integration">
+    <testcase name="c"></testcase>
+    <testcase name="d"><skipped/></testcase>
+    <testcase name="e"><error message="oops"/></testcase>
+  </testsuite>
+</testsuites>`
+
+func writeReportScript(content string) string {
+	return "mkdir -p out\ncat > out/junit.xml <<'EOF'\n" + content + "\nEOF"
+}
+
+func TestBuildAggregatesTestReports(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	for n, content := range map[string]string{"app-a": testSuiteReport, "app-b": testSuitesReport} {
+		check(t, repo.InitModuleWithOptions(n, &Spec{
+			Name:    n,
+			Build:   map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+			Reports: []string{"**/junit.xml"},
+		}))
+		check(t, repo.WriteShellScript(n+"/build.sh", writeReportScript(content)))
+	}
+	check(t, repo.Commit("first"))
+
+	reportFile := filepath.Join(".tmp", "report.xml")
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.ReportFile = reportFile
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, &TestReport{
+		Files:    []string{"app-a/out/junit.xml", "app-b/out/junit.xml"},
+		Suites:   2,
+		Tests:    5,
+		Failures: 1,
+		Errors:   1,
+		Skipped:  1,
+	}, summary.TestReport)
+
+	c, err := ioutil.ReadFile(reportFile)
+	check(t, err)
+	report := string(c)
+	assert.Contains(t, report, `<testsuites tests="5" failures="1" errors="1" skipped="1">`)
+	assert.Contains(t, report, `<testsuite name="app-a/unit" tests="2" failures="1" errors="0" skipped="0" time="0.5">`)
+	assert.Contains(t, report, `<testsuite name="app-b/integration" tests="3" failures="0" errors="1" skipped="1">`)
+	assert.Contains(t, report, `<failure message="boom"></failure>`)
+
+	suites, err := readReport(reportFile)
+	check(t, err)
+	assert.Len(t, suites, 2)
+}
+
+func TestReportsOfFailedBuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:    "app-a",
+		Build:   map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Reports: []string{"out/*.xml"},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", writeReportScript(testSuiteReport)+"\nexit 1"))
+	check(t, repo.Commit("first"))
+
+	reportFile := filepath.Join(".tmp", "report.xml")
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.ReportFile = reportFile
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	assert.EqualError(t, err, "Failed to build module 'app-a'")
+
+	c, err := ioutil.ReadFile(reportFile)
+	check(t, err)
+	assert.Contains(t, string(c), `<testsuite name="app-a/unit"`)
+}
+
+func TestStaleReportsAreIgnored(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:    "app-a",
+		Build:   map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Reports: []string{"**/*.xml"},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "true"))
+	check(t, repo.WriteContent("app-a/old/junit.xml", testSuiteReport))
+	old := time.Now().Add(-time.Hour)
+	check(t, os.Chtimes(filepath.Join(repo.Dir, "app-a/old/junit.xml"), old, old))
+	check(t, repo.Commit("first"))
+
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildWorkspace(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+	check(t, err)
+
+	assert.Nil(t, summary.TestReport)
+}
+
+func TestReportsOfVariants(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:    "app-a",
+		Build:   map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Matrix:  map[string][]string{"arch": {"amd64", "arm64"}},
+		Reports: []string{"out/junit.xml"},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", writeReportScript(testSuiteReport)))
+	check(t, repo.Commit("first"))
+
+	reportFile := filepath.Join(".tmp", "report.xml")
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.ReportFile = reportFile
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, 2, summary.TestReport.Suites)
+	c, err := ioutil.ReadFile(reportFile)
+	check(t, err)
+	assert.Equal(t, 1, strings.Count(string(c), `name="app-a[arch=amd64]/unit"`))
+	assert.Equal(t, 1, strings.Count(string(c), `name="app-a[arch=arm64]/unit"`))
+}
+
+func TestInvalidTestReport(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:    "app-a",
+		Build:   map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Reports: []string{"out/junit.xml"},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", writeReportScript("not xml")))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+
+	assert.EqualError(t, err, "Failed to read the test report out/junit.xml of module 'app-a'")
+}
+
+func TestMatchGlob(t *testing.T) {
+	assert.True(t, matchGlob("**/junit.xml", "junit.xml"))
+	assert.True(t, matchGlob("**/junit.xml", "a/b/junit.xml"))
+	assert.True(t, matchGlob("out/*.xml", "out/a.xml"))
+	assert.True(t, matchGlob("a/**/b/*.xml", "a/x/y/b/c.xml"))
+	assert.True(t, matchGlob("a/**", "a/x/y"))
+	assert.False(t, matchGlob("out/*.xml", "out/x/a.xml"))
+	assert.False(t, matchGlob("**/junit.xml", "a/junit.xml.bak"))
+	assert.False(t, matchGlob("a/**/b", "a/x/c"))
+}
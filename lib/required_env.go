@@ -0,0 +1,79 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// missingEnv is a module along with the environment variables it
+// requires that are not set.
+type missingEnv struct {
+	module *Module
+	vars   []string
+}
+
+// checkRequiredEnv verifies that the environment variables required by
+// the modules selected by include are set, either in the host
+// environment or in the environment configured for the module (including
+// the variables specified with --env, which can be set in profiles).
+// All modules are checked so that the error reports every missing
+// variable before anything is executed.
+func checkRequiredEnv(config *RepoConfig, modules Modules, options *CmdOptions, include func(*Module) bool) error {
+	missing := make([]*missingEnv, 0)
+	for _, a := range modules {
+		if len(a.RequiredEnv()) == 0 || !include(a) {
+			continue
+		}
+
+		env, _, err := moduleEnvironment(config, a, options)
+		if err != nil {
+			return err
+		}
+
+		configured := make(map[string]bool, len(env))
+		for _, kv := range env {
+			p := strings.SplitN(kv, "=", 2)
+			configured[p[0]] = len(p) == 2 && p[1] != ""
+		}
+
+		vars := make([]string, 0)
+		for _, k := range a.RequiredEnv() {
+			if !configured[k] && os.Getenv(k) == "" {
+				vars = append(vars, k)
+			}
+		}
+
+		if len(vars) > 0 {
+			missing = append(missing, &missingEnv{module: a, vars: vars})
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	report := new(strings.Builder)
+	for _, m := range missing {
+		fmt.Fprintf(report, "\n  %s: %s", m.module.Name(), strings.Join(m.vars, ", "))
+	}
+
+	return e.NewErrorf(ErrClassUser, msgMissingRequiredEnv, report.String())
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func initRequiredEnvRepo(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{
+		Env: map[string]string{"MBT_TEST_REPO_VAR": "repo"},
+	}))
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:     "app-a",
+		Build:    map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Commands: map[string]*UserCmd{"echo": {Cmd: "./build.sh"}},
+		Requires: []string{"MBT_TEST_REPO_VAR", "MBT_TEST_URL"},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo $MBT_TEST_URL"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:     "app-b",
+		Build:    map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Env:      map[string]string{"MBT_TEST_MODULE_VAR": "module"},
+		Requires: []string{"MBT_TEST_MODULE_VAR", "MBT_TEST_TOKEN", "MBT_TEST_URL"},
+	}))
+	check(t, repo.WriteShellScript("app-b/build.sh", "echo app-b"))
+	check(t, repo.Commit("first"))
+}
+
+func TestBuildWithMissingRequiredEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initRequiredEnvRepo(t)
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgMissingRequiredEnv, "\n  app-a: MBT_TEST_URL\n  app-b: MBT_TEST_TOKEN, MBT_TEST_URL"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+	assert.Equal(t, "", buff.String())
+}
+
+func TestBuildWithRequiredEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initRequiredEnvRepo(t)
+	check(t, os.Setenv("MBT_TEST_URL", "host"))
+	defer os.Unsetenv("MBT_TEST_URL")
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Env = []string{"MBT_TEST_TOKEN=invocation"}
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "host\napp-b\n", buff.String())
+}
+
+func TestRunInWithMissingRequiredEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initRequiredEnvRepo(t)
+
+	// Only the modules with the command are checked.
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.RunInCurrentBranch("echo", NoFilter, stdTestCmdOptions(buff))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgMissingRequiredEnv, "\n  app-a: MBT_TEST_URL"))
+	assert.Equal(t, "", buff.String())
+}
@@ -34,5 +34,242 @@ const (
 	msgSuccessfulRestorationOfOldReference = "Successfully restored reference %v"
 	msgSuccessfulCheckout                  = "Successfully checked out commit %v"
 	msgDirtyWorkingDir                     = "Dirty working dir"
-	msgDetachedHead                        = "Head is currently detached"
+	msgDetachedHead                        = "Head is detached at %v. Use the commit variant of the command (e.g. mbt build commit %v) or check out a branch"
+	msgFailedSandboxCopy                   = "Failed to copy file '%v' into the sandbox of module '%v'"
+	msgFailedSandboxCleanup                = "Failed to remove sandbox directory %v %v"
+	msgFailedConfigParse                   = "Failed to parse the repository configuration in '%v'"
+	msgBuildingVariant                     = "Building variant %v of module %v"
+	msgFailedBuildVariant                  = "Failed to build variant '%v' of module '%v'"
+	msgInvalidModuleMemory                 = "Invalid memory requirement '%v' in module '%v'"
+	msgInvalidMemoryLimit                  = "Invalid memory limit '%v'"
+	msgWatching                            = "Watching for changes in %v"
+	msgResumedModule                       = "Skipping module %v already built at version %v"
+	msgFailedReadState                     = "Failed to read the state stored in '%v'"
+	msgRetryingFlakyModule                 = "Retrying flaky module %v (%v of %v) after failure: %v"
+	msgHostEnvNotAllowed                   = "Host environment variable %v referenced by %v in module %v is not listed in hostEnv"
+	msgFailedCommandTemplate               = "Failed to expand the template in command '%v'"
+	msgFailedOpenModuleLog                 = "Failed to open the log file of module '%v'"
+	msgFailedReadTestReport                = "Failed to read the test report %v of module '%v'"
+	msgFailedWriteTestReport               = "Failed to write the test report %v"
+	msgTestReportSummary                   = "Tests: %v Failures: %v Errors: %v Skipped: %v (%v reports)"
+	msgFailedWriteSummary                  = "Failed to write the summary to %v"
+	msgUnsupportedOtlpProtocol             = "Unsupported OTLP protocol %v, only http/json is supported. Telemetry is disabled"
+	msgFailedExportTelemetry               = "Failed to export telemetry to %v: %v"
+	msgFailedPushMetrics                   = "Failed to push metrics to %v: %v"
+	msgFailedNotify                        = "Failed to send notification to %v: %v"
+	msgFailedNotificationTemplate          = "Failed to expand the template in notification body"
+	msgHostEnvNotAllowedInNotification     = "Host environment variable %v referenced in a notification is not listed in hostEnv"
+	msgFailedPartialParse                  = "Failed to parse the partial template %v"
+	msgFailedReadTemplate                  = "Failed to read the template %v"
+	msgFailedValuesParse                   = "Failed to parse the values file %v"
+	msgInvalidSetValue                     = "Invalid value '%v', expected key=value"
+	msgFailedReadPartials                  = "Failed to read the partial templates in %v"
+	msgUnsupportedTemplateEngine           = "Unsupported template engine '%v'"
+	msgTemplateEngineNotFound              = "Template engine %v is not found in PATH"
+	msgFailedTemplateEngine                = "Failed to render the template with %v"
+	msgFailedTemplateContext               = "Failed to create the template context"
+	msgFailedStageTemplate                 = "Failed to write the template to a temporary directory"
+	msgInvalidProperties                   = "Properties of module %v do not conform to the schema:\n%v"
+	msgInvalidPropertySchema               = "Invalid properties schema at %v: %v"
+	msgPropertyNotFound                    = "Property %v is not found in module %v"
+	msgPropertyOfNilModule                 = "Property %v is accessed on a nil module"
+	msgModuleNotFound                      = "Module %v is not found"
+	msgFailedRenderedDiff                  = "Failed to compute the difference in the output of module %v"
+	msgFailedRenderAtCommit                = "Failed to render the template at commit %v"
+	msgFailedValidation                    = "Validator %v rejected the output of %v (%v):\n%v"
+	msgInvalidValidatorPattern             = "Invalid template pattern '%v' in validator"
+	msgTemplateNotFoundInSource            = "Template %v is not found in template source %v"
+	msgInvalidTemplateSource               = "Template source %v must specify either git or oci"
+	msgFetchingTemplateSource              = "Fetching template source %v"
+	msgFailedFetchTemplateSource           = "Failed to fetch template source %v"
+	msgTemplateSourceDigestMismatch        = "Digest of template source %v does not match the pinned digest (expected %v but found %v)"
+	msgInvalidTemplateSourcePath           = "Path %v in template source is outside the source"
+	msgHostEnvNotAllowedInTemplateSource   = "Host environment variable %v referenced in a template source is not listed in hostEnv"
+	msgApplyingToKubernetes                = "Applying module %v to kubernetes"
+	msgSkippedKubernetesApply              = "Skipped applying module %v to kubernetes since its output is empty"
+	msgFailedKubernetesApply               = "Failed to apply module %v to kubernetes (%v):\n%v"
+	msgUnsupportedGenerator                = "Unsupported generator '%v'"
+	msgGeneratorRequiresModule             = "Generator %v requires the template to be rendered for each module"
+	msgFailedGenerateValues                = "Failed to generate the values of module %v"
+	msgInvalidTemplateFuncName             = "Invalid name of template function '%v'"
+	msgInvalidTemplateFuncArgs             = "Failed to encode the arguments of template function %v"
+	msgFailedTemplateFunc                  = "Template function %v failed (%v):\n%v"
+	msgUnsupportedDescriptionFormat        = "Unsupported format '%v'"
+	msgUnsupportedGraphFormat              = "Unsupported graph format '%v'"
+	msgUnsupportedGraphCluster             = "Unsupported graph cluster '%v'"
+	msgInvalidFormatTemplate               = "Invalid format template"
+	msgFailedFormatTemplate                = "Failed to execute the format template"
+	msgInvalidQuery                        = "Invalid query '%v': %v"
+	msgFailedQuery                         = "Failed to evaluate query '%v' for module %v: %v"
+	msgInvalidManifest                     = "Invalid manifest: %v"
+	msgUnsupportedManifestSchema           = "Unsupported manifest schema version %v (expected %v)"
+	msgUnknownManifestModule               = "Module %v referenced in the manifest is not defined in it"
+	msgUnsupportedSBOMFormat               = "Unsupported SBOM format '%v'"
+	msgFailedSBOMPlugin                    = "SBOM plugin %v failed for module %v (%v):\n%v"
+	msgInvalidSBOMPluginOutput             = "Invalid output from SBOM plugin %v for module %v: %v"
+	msgFailedSBOMFileDigest                = "Failed to compute the digest of file %v in module %v"
+	msgRevisionNotFound                    = "Revision %v is not found"
+	msgUnsupportedChangelogFormat          = "Unsupported changelog format '%v'"
+	msgUnsupportedHealthReportFormat       = "Unsupported health report format '%v'"
+	msgProfileNotFound                     = "Profile %v is not found in the repository or user configuration"
+	msgInvalidFlagDefault                  = "Invalid value for flag %v in %v, expected a string, number, boolean or a list of them"
+	msgUnknownAffectedPurpose              = "Unknown purpose '%v' (expected builds, tests, deploys or a purpose in the repository configuration)"
+	msgFailedAffectedQuery                 = "Invalid query for affected %v in repository configuration"
+	msgUnsupportedDoctorFormat             = "Unsupported doctor report format '%v'"
+	msgUnknownRepoBackend                  = "Unknown git backend '%v' (available backends are %v)"
+	msgPartialCloneNotSupported            = "Repository in dir '%v' is a partial clone, which is not supported. Clone the repository without --filter (e.g. set filter to none in the CI checkout)"
+	msgCommitNotFoundInShallowClone        = "Failed to find commit sha '%v' in this shallow clone. Fetch more history with git fetch --deepen=<depth> or git fetch --unshallow"
+	msgRevisionNotFoundInShallowClone      = "Revision %v is not found in this shallow clone. Fetch more history with git fetch --deepen=<depth> or git fetch --unshallow"
+	msgMergeBaseNotFoundInShallowClone     = "Merge base of %v and %v is not found in this shallow clone. Fetch more history with git fetch --deepen=<depth> or git fetch --unshallow"
+	msgParentNotFoundInShallowClone        = "Parent of commit %v is not available in this shallow clone. Fetch more history with git fetch --deepen=<depth> or git fetch --unshallow"
+	msgHistoryTruncatedInShallowClone      = "History is truncated at the boundary of this shallow clone. Fetch more history with git fetch --unshallow to include all commits"
+	msgSubmoduleNotInitialised             = "Submodule at %v is not initialised. Run git submodule update --init --recursive to discover the modules in it"
+	msgSubmoduleCommitNotFound             = "Commit %v of the submodule at %v is not found. Run git submodule update --init --recursive to fetch it"
+	msgDiscoveryConfigIgnored              = "Configuration is ignored during the module discovery: %v"
+	msgNonConeSparseCheckout               = "Sparse checkout patterns in %v are not in cone mode. Run git sparse-checkout set --cone to use mbt in this repository"
+	msgCheckoutInSparseCheckout            = "Commit %v cannot be checked out in a sparse checkout. Build or run the commit checked out in the workspace or use a full checkout"
+	msgFetchingRemoteRepo                  = "Fetching repository %v"
+	msgFailedFetchRemoteRepo               = "Failed to fetch the repository %v"
+	msgNoWorkingTree                       = "Repository at %v does not have a working tree. Use a command reading commits (e.g. describe commit) or a clone with a working tree"
+	msgRemoteAuthFailed                    = "Authentication failed for %v. Configure the credentials with MBT_GIT_USERNAME and MBT_GIT_PASSWORD, a netrc file, an SSH agent or MBT_GIT_SSH_KEY"
+	msgUnsupportedHook                     = "Unsupported hook '%v'. Supported hooks are pre-commit and pre-push"
+	msgHookExists                          = "Hook %v already exists. Use --force to replace it"
+	msgFailedWriteHook                     = "Failed to write the hook %v"
+	msgInvalidPushUpdate                   = "Invalid ref update '%v'. Expected <local ref> <local sha> <remote ref> <remote sha>"
+	msgHookVerificationFailed              = "%v hook failed: %v"
+	msgUnsupportedHistoryFormat            = "Unsupported history format '%v'"
+	msgFailedBranchLookupInDetachedHead    = "Failed to find the branch '%v'. Head is detached at %v, use the commit variant of the command (e.g. mbt build commit %v) or --commit"
+	msgUnsupportedVCSOperation             = "%v is not supported by the %v backend"
+	msgFailedMergeBase                     = "Failed to find the merge base of %v and %v"
+	msgFailedOpenVCSRepo                   = "Failed to open a %v repository in dir - '%v'"
+	msgVCSNotFound                         = "Failed to find %v executable required by the %v backend"
+	msgFailedVCSCommand                    = "Failed to execute %v %v"
+	msgInvalidVCSOutput                    = "Unexpected output of %v command: '%v'"
+	msgFailedWriteGitHubOutput             = "Failed to write the step outputs to %v"
+	msgUnsupportedPipelineProvider         = "Pipelines cannot be generated for '%v' (supported CI systems are %v)"
+	msgFailedReadSummary                   = "Failed to read the summary from %v"
+	msgFailedPostGitHubComment             = "Failed to post the report to pull request #%v"
+	msgGitHubCommentOptionMissing          = "Could not determine the %v of the comment, specify it or set %v"
+	msgFailedReadGitHubEvent               = "Failed to read the GitHub event from %v"
+	msgUnsupportedStatusProvider           = "Unsupported status provider %v, supported providers are %v"
+	msgFailedPublishStatus                 = "Failed to publish the status of %v"
+	msgCommitStatusOptionMissing           = "Could not determine the %v of the statuses, specify it or set %v"
+	msgFailedReadModuleLog                 = "Failed to read the log %v"
+	msgCommitStatusShaMissing              = "Could not determine the commit of the statuses, summary does not contain a commit"
+	msgFailedPublishOutputs                = "Failed to publish the outputs of %v to %v"
+	msgPublishedOutputs                    = "Published the outputs of %v to %v"
+	msgFailedArchiveOutputs                = "Failed to archive the outputs of %v"
+	msgNoOutputs                           = "Outputs of %v are not found"
+	msgHostEnvNotAllowedInPublishTarget    = "Host environment variable %v is not allowed in a publish target, add it to hostEnv in .mbt/config.yml"
+	msgInvalidPublishTarget                = "Invalid publish target, specify one of oci, s3 or http"
+	msgFailedCheckDockerImage              = "Failed to check whether %v is in the registry, building it: %v"
+	msgDockerImageExists                   = "Image %v is already in the registry, skipping the build of %v"
+	msgFailedReadBuildxMetadata            = "Failed to read the metadata of the image of %v"
+	msgMissingTerraformVarProperty         = "Property %v of variable %v is not found in %v"
+	msgFailedWriteTerraformVars            = "Failed to write the variables of %v"
+	msgUnsupportedTerraformFormat          = "Unsupported format %v, supported formats are text and json"
+	msgUnsupportedImportSource             = "Unsupported source %v, supported sources are bazel and gradle"
+	msgSpecExists                          = "Module spec %v already exists, use --force to replace it"
+	msgConflictingImportedModules          = "Packages %v and %v are both imported as %v"
+	msgFailedWriteImportedSpec             = "Failed to write the spec of %v"
+	msgMissingBazelQuery                   = "Output of bazel query --output=proto is required to import a Bazel workspace"
+	msgFailedReadBazelQuery                = "Failed to read the bazel query result in %v"
+	msgInvalidProtobuf                     = "Invalid protocol buffers message"
+	msgMissingGradleSettings               = "settings.gradle or settings.gradle.kts is not found in %v"
+	msgFailedReadGradleScript              = "Failed to read gradle script %v"
+	msgFailedProvenance                    = "Failed to generate the provenance of %v"
+	msgFailedSignProvenance                = "Failed to sign the provenance of %v: %v %v"
+	msgNoQueuedChanges                     = "At least one change is required to advise on the merge queue"
+	msgUnsupportedMergeQueueFormat         = "Unsupported format %v, supported formats are text and json"
+	msgInvalidReleaseVersion               = "Invalid release version %v, specify a version in major.minor.patch format"
+	msgFailedReadReleasePlan               = "Failed to read the release plan from %v"
+	msgReleaseModuleNotFound               = "Module %v in the release plan is not found"
+	msgReleaseModuleChanged                = "Module %v is at version %v but it was planned at version %v"
+	msgUnsupportedReleaseFormat            = "Unsupported format %v, supported formats are json and markdown"
+	msgHostEnvNotAllowedInEventStream      = "Event stream references host environment variable %v which is not listed in hostEnv"
+	msgInvalidEventStreamURL               = "Invalid event stream url"
+	msgEventStreamTimeout                  = "Timed out delivering the events to %v"
+	msgFailedPostEvents                    = "Failed to post the events to %v, no more events will be posted: %v"
+	msgDroppedEvents                       = "Dropped %v events because %v did not keep up"
+	msgInvalidIssuePattern                 = "Invalid issue pattern %v"
+	msgFailedListen                        = "Failed to listen on %v"
+	msgServing                             = "Serving queries on http://%v"
+	msgMissingQueryParameter               = "Query parameter %v is required"
+	msgUnknownQuery                        = "Unknown query %v"
+	msgInvalidRPCRequest                   = "Invalid JSON-RPC 2.0 request"
+	msgInvalidRPCParams                    = "Params must be an object of strings or lists of strings"
+	msgInvalidRPCMessage                   = "Invalid message, expected a Content-Length header followed by the message"
+	msgPluginNotFound                      = "Plugin %v is not found, expected %v in .mbt/plugins or PATH"
+	msgInvalidPluginLock                   = "Invalid plugin lock file .mbt/plugins.lock"
+	msgPluginNotLocked                     = "Plugin %v is not locked, run mbt plugin lock"
+	msgPluginChecksumMismatch              = "Plugin %v at %v does not match the lock file, run mbt plugin lock to update it"
+	msgFailedPlugin                        = "Plugin %v failed in %v hook (%v):\n%v"
+	msgInvalidPluginOutput                 = "Invalid output from plugin %v in %v hook: %v"
+	msgFailedReportPlugins                 = "Failed to report to plugins: %v"
+	msgPolicyViolation                     = "Policy violation:\n%v"
+	msgPolicyOpaNotFound                   = "Policies in %v are evaluated with opa (https://www.openpolicyagent.org), which is not found in PATH. Install opa or remove the policies"
+	msgPolicyEvalFailed                    = "Failed to evaluate policies: %v"
+	msgPolicyInvalidResult                 = "Policy query %v must evaluate to a set of violations"
+	msgInvalidComposedRepoName             = "Invalid repository name '%v' in %v, names must not be empty or contain /"
+	msgDuplicateComposedRepo               = "Repository %v is listed more than once in %v"
+	msgInvalidComposedRepo                 = "Repository %v in %v must specify either a path or a url"
+	msgComposedRepoNotFound                = "Repository %v is not in the workspace"
+	msgComposedRepoNotBuildable            = "Modules of repository %v cannot be built since it is not checked out locally"
+	msgFailedSpecParseAt                   = "error while parsing the spec at %v"
+	msgModuleNameConflict                  = "Module name '%s' in directory '%s' conflicts with the module in '%s' directory"
+	msgCyclicDependency                    = "Could not produce the module graph due to a cyclic dependency in path: %s"
+	msgDependencyNotFound                  = "dependency not found %s -> %s"
+	msgUnsupportedDiagnosticsFormat        = "Unsupported diagnostics format '%v', supported formats are text and json"
+	msgFailedReadDiagnosticCatalog         = "Failed to read the diagnostic catalog %v"
+	msgSatisfiedModule                     = "Skipping module %v since the artifact of version %v exists"
+	msgFailedProbe                         = "Failed to probe the artifact of module %v, building it: %v"
+	msgUnexpectedProbeResponse             = "Unexpected response from %v: %v"
+	msgNoModuleInPath                      = "No module contains the path '%v'"
+	msgInvalidAge                          = "Invalid age '%v' (e.g. 30d, 12h or 90m)"
+	msgFailedWriteHistory                  = "Failed to write the history to '%v'"
+	msgFailedRecordHistory                 = "Failed to record the history: %v"
+	msgUnsupportedDurationSort             = "Unsupported sort order '%v' (supported orders: mean, trend)"
+	msgInvalidShard                        = "Invalid shard '%v' (e.g. 3/8 for the third of eight shards)"
+	msgCancelled                           = "Cancelled before completion, modules not started were skipped"
+	msgModuleFilesUnavailable              = "Files of module %v are not available (e.g. in an imported manifest)"
+	msgInvalidPinLock                      = "Pin lock file %v is invalid"
+	msgModuleNotPinned                     = "Module %v is not pinned"
+	msgInvalidPlanArchive                  = "Plan archive is invalid: %v"
+	msgUnsupportedPlanSchema               = "Plan archive schema version %v is not supported (expected %v)"
+	msgPlanCheckoutMismatch                = "Checkout does not match the plan, %v files are different (e.g. %v)"
+	msgSpecCanonicalFormMismatch           = "Canonical form of the spec is not equivalent to the spec: %v"
+	msgSpecWithComments                    = "Spec %v is not formatted since it contains comments"
+	msgInvalidOwnershipShare               = "Minimum share of contributors must be between 0 and 1 (got %v)"
+	msgFailedFingerprint                   = "Failed to compute the fingerprint of module %v, building its dependents: %v"
+	msgUnaffectedModule                    = "Skipping module %v at version %v since its dependencies did not change their fingerprints"
+	msgModuleNotInCommit                   = "Ignoring module %v since %v is not in commit %v"
+	msgMissingRequiredEnv                  = "Required environment variables are not set:%v"
+	msgFailedWriteRepoConfig               = "Failed to write the repository config %v"
+	msgInvalidCacheSize                    = "Invalid cache size '%v', use a quantity such as 512Mi or 10G"
+	msgFailedReadCache                     = "Failed to read the cache in %v"
+	msgFailedRemoveCacheEntry              = "Failed to remove the cache entry %v"
+	msgFailedSignCacheEntry                = "Failed to sign the cache entry of %v: %v"
+	msgUnsignedCacheEntry                  = "Cache entry of %v is not signed"
+	msgInvalidCacheSignature               = "Signature of the cache entry of %v is not valid"
+	msgFailedVerifyCacheEntry              = "Failed to verify the cache entry of %v: %v"
+	msgCacheKeyNotSet                      = "Cache key is not set in %v"
+	msgRejectedCacheEntry                  = "Rejected the discovery cache entry from plugin %v: %v"
+	msgUnknownSchema                       = "Unknown schema '%v', run mbt schema to list the schemas"
+	msgBlockedByFailure                    = "Not building %v since its dependency %v failed or was not built"
+	msgInvalidBuildCache                   = "Invalid build cache %v"
+	msgCachedModule                        = "Restored %v (%v) from the build cache"
+	msgFailedReadBuildCache                = "Failed to read the build cache entry of %v from %v: %v"
+	msgFailedWriteBuildCache               = "Failed to write the build cache entry of %v to %v: %v"
+	msgFailedRestoreBuildCache             = "Failed to restore the outputs of %v from the build cache"
+	msgFailedBuildHook                     = "Failed to execute %v hook '%v' of the build"
+	msgInvalidLabelSelector                = "Invalid label '%v' in '%v', labels are specified as key=value"
+	msgEnvironmentNotInValues              = "Environment %v is not defined in the environment values file %v"
+	msgEnvironmentValuesWithoutEnvironment = "Environment values file %v requires an environment"
+	msgAllEnvironmentsWithoutValues        = "Rendering all environments requires an environment values file"
+	msgNoEnvironmentsInValues              = "No environments are defined in the environment values file %v"
+	msgInvalidEnvironmentValues            = "Values of environment %v in %v must be a map"
+	msgUnknownCache                        = "Unknown cache %v, expected one of %v"
+	msgAmbiguousSha                        = "Sha %v matches more than one commit"
+	msgUnsupportedShell                    = "Unsupported shell '%v'"
+	msgFailedHook                          = "Failed to execute %v hook '%v' of module '%v'"
 )
@@ -0,0 +1,336 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const historyFile = "history.jsonl"
+
+const (
+	// DurationSortMean sorts modules by their mean duration.
+	DurationSortMean = "mean"
+	// DurationSortTrend sorts modules by the change of their mean
+	// duration.
+	DurationSortTrend = "trend"
+)
+
+// HistoryRecord is the outcome of a module in a build or a run of a
+// user defined command recorded in the history.
+type HistoryRecord struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Commit  string    `json:"commit"`
+	Module  string    `json:"module"`
+	Version string    `json:"version"`
+	Status  string    `json:"status"`
+	// Duration of the command in seconds.
+	Duration float64 `json:"duration"`
+	// CacheHit is set when the module was resumed, satisfied by its
+	// probe or unaffected by its dependencies instead of executing the
+	// command.
+	CacheHit bool `json:"cacheHit"`
+}
+
+// DurationStatsOptions selects the records of the history summarised by
+// System.DurationStats.
+type DurationStatsOptions struct {
+	// Since is the age of the oldest record considered. All records
+	// are considered if it is zero.
+	Since time.Duration
+	// Command restricts the records to a command (e.g. build or the name
+	// of a user defined command).
+	Command string
+	// Top is the maximum number of modules returned (0 returns all).
+	Top int
+	// Sort is DurationSortMean (default) or DurationSortTrend.
+	Sort string
+}
+
+// DurationStats summarises the durations of a module in the history.
+// Durations are in seconds and exclude cache hits.
+type DurationStats struct {
+	Name      string  `json:"name"`
+	Runs      int     `json:"runs"`
+	Failures  int     `json:"failures"`
+	CacheHits int     `json:"cacheHits"`
+	Mean      float64 `json:"mean"`
+	P90       float64 `json:"p90"`
+	Max       float64 `json:"max"`
+	Last      float64 `json:"last"`
+	// Trend is the change of the mean duration of the later half of the
+	// runs relative to the earlier half (e.g. 0.25 is 25% slower).
+	// Zero if there are less than 4 runs.
+	Trend float64 `json:"trend"`
+}
+
+// ParseAge parses an age like 30d, 12h or 90m.
+func ParseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err == nil && days >= 0 {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	} else if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return d, nil
+	}
+
+	return 0, e.NewErrorf(ErrClassUser, msgInvalidAge, s)
+}
+
+// recordHistory appends the outcome of the modules processed in an
+// invocation to the history. Records older than the retention of the
+// history are removed.
+func (s *stdSystem) recordHistory(config *MetricsConfig, summary *InvocationSummary, at time.Time) error {
+	dir, err := s.stateDir()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	p := filepath.Join(dir, historyFile)
+	if config.HistoryRetention != "" {
+		retention, err := ParseAge(config.HistoryRetention)
+		if err != nil {
+			return err
+		}
+
+		if err := pruneHistory(p, at.Add(-retention)); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return e.Wrapf(ErrClassInternal, err, msgFailedWriteHistory, p)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, m := range summary.Modules {
+		if !moduleBuilt(m) {
+			continue
+		}
+
+		err := encoder.Encode(&HistoryRecord{
+			Time:     at.UTC(),
+			Command:  summary.Command,
+			Commit:   summary.Commit,
+			Module:   m.Name,
+			Version:  m.Version,
+			Status:   m.Status,
+			Duration: m.Duration,
+			CacheHit: moduleReused(m),
+		})
+		if err != nil {
+			return e.Wrapf(ErrClassInternal, err, msgFailedWriteHistory, p)
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return e.Wrapf(ErrClassInternal, err, msgFailedWriteHistory, p)
+	}
+	return nil
+}
+
+// readHistory reads the records of the history created after since.
+func readHistory(p string, since time.Time) ([]*HistoryRecord, error) {
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, e.Wrapf(ErrClassInternal, err, msgFailedReadFile, p)
+	}
+	defer f.Close()
+
+	records := make([]*HistoryRecord, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		r := &HistoryRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			return nil, e.Wrapf(ErrClassInternal, err, msgFailedReadState, p)
+		}
+		if !r.Time.Before(since) {
+			records = append(records, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, e.Wrapf(ErrClassInternal, err, msgFailedReadFile, p)
+	}
+
+	return records, nil
+}
+
+// pruneHistory removes the records created before the specified time.
+// History is rewritten only if there is a record to remove.
+func pruneHistory(p string, before time.Time) error {
+	records, err := readHistory(p, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	if len(records) == 0 || !records[0].Time.Before(before) {
+		return nil
+	}
+
+	tmp := p + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return e.Wrapf(ErrClassInternal, err, msgFailedWriteHistory, p)
+	}
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, r := range records {
+		if r.Time.Before(before) {
+			continue
+		}
+		if err = encoder.Encode(r); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, p)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return e.Wrapf(ErrClassInternal, err, msgFailedWriteHistory, p)
+	}
+	return nil
+}
+
+func (s *stdSystem) DurationStats(options *DurationStatsOptions) ([]*DurationStats, error) {
+	dir, err := s.stateDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var since time.Time
+	if options.Since > 0 {
+		since = time.Now().Add(-options.Since)
+	}
+
+	records, err := readHistory(filepath.Join(dir, historyFile), since)
+	if err != nil {
+		return nil, err
+	}
+
+	return durationStats(records, options)
+}
+
+// durationStats summarises the records in the order they were recorded.
+func durationStats(records []*HistoryRecord, options *DurationStatsOptions) ([]*DurationStats, error) {
+	sortBy := options.Sort
+	if sortBy == "" {
+		sortBy = DurationSortMean
+	}
+	if sortBy != DurationSortMean && sortBy != DurationSortTrend {
+		return nil, e.NewErrorf(ErrClassUser, msgUnsupportedDurationSort, sortBy)
+	}
+
+	index := make(map[string]*DurationStats)
+	durations := make(map[string][]float64)
+	for _, r := range records {
+		if options.Command != "" && r.Command != options.Command {
+			continue
+		}
+
+		st, ok := index[r.Module]
+		if !ok {
+			st = &DurationStats{Name: r.Module}
+			index[r.Module] = st
+		}
+
+		if r.CacheHit {
+			st.CacheHits++
+			continue
+		}
+
+		st.Runs++
+		if r.Status == ModuleStatusFailed {
+			st.Failures++
+		}
+		durations[r.Module] = append(durations[r.Module], r.Duration)
+	}
+
+	stats := make([]*DurationStats, 0, len(index))
+	for name, st := range index {
+		d := durations[name]
+		if len(d) > 0 {
+			st.Mean = mean(d)
+			st.Last = d[len(d)-1]
+			if len(d) >= 4 {
+				if earlier := mean(d[:len(d)/2]); earlier > 0 {
+					st.Trend = mean(d[len(d)/2:])/earlier - 1
+				}
+			}
+
+			sorted := append([]float64{}, d...)
+			sort.Float64s(sorted)
+			st.Max = sorted[len(sorted)-1]
+			st.P90 = sorted[int(math.Ceil(0.9*float64(len(sorted))))-1]
+		}
+		stats = append(stats, st)
+	}
+
+	key := func(st *DurationStats) float64 {
+		if sortBy == DurationSortTrend {
+			return st.Trend
+		}
+		return st.Mean
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if key(stats[i]) != key(stats[j]) {
+			return key(stats[i]) > key(stats[j])
+		}
+		return stats[i].Name < stats[j].Name
+	})
+
+	if options.Top > 0 && len(stats) > options.Top {
+		stats = stats[:options.Top]
+	}
+	return stats, nil
+}
+
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildsAreRecordedInHistory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initMetricsRepo(t, &MetricsConfig{History: true})
+
+	world := NewWorld(t, ".tmp/repo")
+	for i := 0; i < 2; i++ {
+		_, err := world.System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+		assert.Error(t, err)
+	}
+
+	records, err := readHistory(filepath.Join(".tmp/repo/.git/mbt", historyFile), time.Time{})
+	check(t, err)
+	assert.Len(t, records, 4)
+	assert.Equal(t, "build", records[0].Command)
+	assert.Equal(t, "app-a", records[0].Module)
+	assert.Equal(t, ModuleStatusSucceeded, records[0].Status)
+	assert.Equal(t, ModuleStatusFailed, records[1].Status)
+	assert.NotEmpty(t, records[0].Commit)
+
+	stats, err := world.System.DurationStats(&DurationStatsOptions{Since: time.Hour, Command: "build"})
+	check(t, err)
+	assert.Len(t, stats, 2)
+	for _, s := range stats {
+		assert.Equal(t, 2, s.Runs)
+	}
+
+	stats, err = world.System.DurationStats(&DurationStatsOptions{Command: "lint"})
+	check(t, err)
+	assert.Empty(t, stats)
+}
+
+func TestHistoryIsNotRecordedByDefault(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initMetricsRepo(t, &MetricsConfig{})
+
+	world := NewWorld(t, ".tmp/repo")
+	_, err := world.System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+	assert.Error(t, err)
+
+	stats, err := world.System.DurationStats(&DurationStatsOptions{})
+	check(t, err)
+	assert.Empty(t, stats)
+}
+
+func TestHistoryRetention(t *testing.T) {
+	clean()
+	check(t, os.MkdirAll(".tmp", 0755))
+	p := filepath.Join(".tmp", historyFile)
+
+	now := time.Now()
+	buff := new(bytes.Buffer)
+	for _, age := range []time.Duration{72 * time.Hour, 2 * time.Hour, time.Minute} {
+		b, err := json.Marshal(&HistoryRecord{Time: now.Add(-age), Module: fmt.Sprintf("app-%v", age)})
+		check(t, err)
+		buff.Write(append(b, '\n'))
+	}
+	check(t, ioutil.WriteFile(p, buff.Bytes(), 0644))
+
+	check(t, pruneHistory(p, now.Add(-24*time.Hour)))
+	records, err := readHistory(p, time.Time{})
+	check(t, err)
+	assert.Len(t, records, 2)
+
+	records, err = readHistory(p, now.Add(-time.Hour))
+	check(t, err)
+	assert.Len(t, records, 1)
+}
+
+func TestDurationStats(t *testing.T) {
+	records := make([]*HistoryRecord, 0)
+	add := func(module string, status string, cacheHit bool, durations ...float64) {
+		for _, d := range durations {
+			records = append(records, &HistoryRecord{Module: module, Command: "build", Status: status, Duration: d, CacheHit: cacheHit})
+		}
+	}
+	add("app-a", ModuleStatusSucceeded, false, 10, 10, 20, 20)
+	add("app-b", ModuleStatusSucceeded, false, 30, 30, 30, 30)
+	add("app-b", ModuleStatusFailed, false, 30)
+	add("app-c", ModuleStatusResumed, true, 0)
+
+	stats, err := durationStats(records, &DurationStatsOptions{})
+	check(t, err)
+	assert.Equal(t, []string{"app-b", "app-a", "app-c"}, []string{stats[0].Name, stats[1].Name, stats[2].Name})
+	assert.Equal(t, &DurationStats{Name: "app-b", Runs: 5, Failures: 1, Mean: 30, P90: 30, Max: 30, Last: 30}, stats[0])
+	assert.Equal(t, &DurationStats{Name: "app-a", Runs: 4, Mean: 15, P90: 20, Max: 20, Last: 20, Trend: 1}, stats[1])
+	assert.Equal(t, &DurationStats{Name: "app-c", CacheHits: 1}, stats[2])
+
+	stats, err = durationStats(records, &DurationStatsOptions{Sort: DurationSortTrend, Top: 1})
+	check(t, err)
+	assert.Len(t, stats, 1)
+	assert.Equal(t, "app-a", stats[0].Name)
+
+	_, err = durationStats(records, &DurationStatsOptions{Sort: "max"})
+	assert.EqualError(t, err, fmt.Sprintf(msgUnsupportedDurationSort, "max"))
+}
+
+func TestParseAge(t *testing.T) {
+	for s, expected := range map[string]time.Duration{
+		"30d": 30 * 24 * time.Hour,
+		"12h": 12 * time.Hour,
+		"90m": 90 * time.Minute,
+	} {
+		d, err := ParseAge(s)
+		check(t, err)
+		assert.Equal(t, expected, d, s)
+	}
+
+	for _, s := range []string{"", "d", "-1d", "1w", "-2h"} {
+		_, err := ParseAge(s)
+		assert.EqualError(t, err, fmt.Sprintf(msgInvalidAge, s), s)
+	}
+}
@@ -17,6 +17,8 @@ package lib
 
 import (
 	"runtime"
+	"sort"
+	"time"
 
 	"github.com/mbtproject/mbt/e"
 )
@@ -126,21 +128,113 @@ func (s *stdSystem) checkoutAndRunManifest(command string, m *Manifest, options
 }
 
 func (s *stdSystem) runManifest(command string, m *Manifest, options *CmdOptions) (*RunResult, error) {
+	options = s.withContext(options)
+	config, err := loadRepoConfig(m.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.checkPolicies(config, m, func() (*PolicyInput, error) {
+		return s.runPolicyInput(command, m, options)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkRequiredEnv(config, m.Modules, options, func(a *Module) bool {
+		_, ok := s.commandToRun(command, a, options)
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	options, closeStreams, err := s.withEventStreams(config, options)
+	if err != nil {
+		return nil, err
+	}
+	defer closeStreams()
+
+	started := time.Now()
+	emitRunStart(options, command, m)
+
+	sp := s.tracer.start("run-in", map[string]interface{}{"mbt.commit": m.Sha, "mbt.command": command})
+
+	var result *RunResult
+	if options.Jobs > 1 || options.Parallel {
+		result, err = s.runConcurrently(command, config, m, options)
+		if err != nil {
+			sp.finish(err)
+			return nil, err
+		}
+	} else {
+		result = s.runSequentially(command, config, m, options)
+	}
+
+	s.tracer.traceModules(sp, result.Timings, nil)
+	if len(result.Failures) > 0 {
+		sp.finish(result.Failures[0].Err)
+	} else {
+		sp.finish(nil)
+	}
+
+	invocation := result.InvocationSummary(command)
+	cancelErr := cancelled(options.Context)
+	if cancelErr != nil {
+		invocation.stopped(cancelErr)
+	}
+	s.pushMetrics(config.Metrics, invocation)
+	s.notify(config, invocation)
+	s.reportToPlugins(m.Dir, config, invocation)
+	emitRunFinish(options, invocation, started)
+	err = writeInvocationSummary(options, invocation)
+	if err != nil {
+		return nil, err
+	}
+
+	if cancelErr != nil {
+		return nil, cancelErr
+	}
+
+	return result, nil
+}
+
+// runSequentially runs the command in the modules one after the other
+// in the order of the manifest.
+func (s *stdSystem) runSequentially(command string, config *RepoConfig, m *Manifest, options *CmdOptions) *RunResult {
 	completed := make([]*Module, 0)
 	skipped := make([]*Module, 0)
 	failed := make([]*CmdFailure, 0)
+	timings := make([]*ModuleTiming, 0)
+
+	queued := time.Now()
+	for _, a := range m.Modules {
+		emitEvent(options, EventModuleQueue, a, time.Time{}, nil)
+	}
 
 	var err error
 	for _, a := range m.Modules {
-		cmd, canRun := s.canRunHere(command, a)
-		if !canRun || (err != nil && options.FailFast) {
+		cmd, canRun := s.commandToRun(command, a, options)
+		if !canRun || (err != nil && options.FailFast) || cancelled(options.Context) != nil {
 			skipped = append(skipped, a)
 			options.Callback(a, CmdStageSkipBuild, nil)
+			emitEvent(options, EventModuleSkip, a, time.Time{}, nil)
 			continue
 		}
 
 		options.Callback(a, CmdStageBeforeBuild, nil)
-		err = s.execCommand(cmd, m, a, options)
+		timing := &ModuleTiming{Module: a, Queued: queued, Ready: queued, Started: time.Now()}
+		timings = append(timings, timing)
+		emitEvent(options, EventModuleStart, a, time.Time{}, nil)
+		var o *CmdOptions
+		var release func()
+		o, release, err = withModuleOutput(options, a)
+		if err == nil {
+			err = s.execCommand(cmd, config, m, a, o)
+			release()
+		}
+		timing.Finished, timing.Err = time.Now(), err
+		emitEvent(options, EventModuleFinish, a, timing.Started, err)
 		if err != nil {
 			failed = append(failed, &CmdFailure{Err: err, Module: a})
 			options.Callback(a, CmdStageFailedBuild, err)
@@ -150,17 +244,98 @@ func (s *stdSystem) runManifest(command string, m *Manifest, options *CmdOptions
 		}
 	}
 
-	return &RunResult{Manifest: m, Failures: failed, Completed: completed, Skipped: skipped}, nil
+	return &RunResult{Manifest: m, Failures: failed, Completed: completed, Skipped: skipped, Timings: timings}
 }
 
-func (s *stdSystem) execCommand(command *UserCmd, manifest *Manifest, module *Module, options *CmdOptions) error {
-	err := s.ProcessManager.Exec(manifest, module, options, command.Cmd, command.Args...)
+// runConcurrently runs the command in up to options.Jobs modules at the
+// same time. A module is started after its dependencies in the manifest
+// unless options.Parallel is set.
+// Remaining modules are not started after a failure if options.FailFast
+// is set.
+func (s *stdSystem) runConcurrently(command string, config *RepoConfig, m *Manifest, options *CmdOptions) (*RunResult, error) {
+	if options.Parallel && options.Jobs < 1 {
+		o := *options
+		o.Jobs = runtime.NumCPU()
+		options = &o
+	}
+
+	commands := make(map[string]*UserCmd)
+	policy := &schedulePolicy{
+		command: func(a *Module) *Cmd {
+			c, ok := s.commandToRun(command, a, options)
+			if !ok {
+				return nil
+			}
+			commands[a.Name()] = c
+			return &Cmd{Cmd: c.Cmd, Args: c.Args, Dir: c.Dir, Shell: c.Shell}
+		},
+		continueOnFailure:  !options.FailFast,
+		ignoreDependencies: options.Parallel,
+	}
+
+	summary, err := s.scheduleWithPolicy(m, options, policy, func(_ *Cmd, a *Module, o *CmdOptions) ([]*BuildResult, error) {
+		if err := s.execCommand(commands[a.Name()], config, m, a, o); err != nil {
+			o.Callback(a, CmdStageFailedBuild, err)
+			return nil, err
+		}
+		return []*BuildResult{{Module: a}}, nil
+	})
+
+	if summary == nil {
+		return nil, err
+	}
+
+	result := &RunResult{Manifest: m, Failures: make([]*CmdFailure, 0), Completed: make([]*Module, 0), Skipped: summary.Skipped, Timings: summary.Timings}
+	for _, r := range summary.Completed {
+		result.Completed = append(result.Completed, r.Module)
+	}
+	for _, t := range summary.Timings {
+		if t.Err != nil {
+			result.Failures = append(result.Failures, &CmdFailure{Err: t.Err, Module: t.Module})
+		}
+	}
+	index := m.Modules.indexOf()
+	sort.SliceStable(result.Failures, func(i, j int) bool {
+		return index[result.Failures[i].Module.Name()] < index[result.Failures[j].Module.Name()]
+	})
+
+	return result, nil
+}
+
+func (s *stdSystem) execCommand(command *UserCmd, config *RepoConfig, manifest *Manifest, module *Module, options *CmdOptions) error {
+	options, err := withModuleEnvironment(config, module, options)
+	if err != nil {
+		return err
+	}
+
+	// Commands specified with exec are not declared in the spec,
+	// therefore templates are not expanded in them.
+	if options.Exec != nil {
+		err = runCmd(s.ProcessManager, manifest, module, options, command.Shell, command.Dir, command.Cmd, command.Args)
+	} else {
+		err = s.execSpecCmd(manifest, module, options, command.Shell, command.Dir, command.Cmd, command.Args)
+	}
 	if err != nil {
 		return e.Wrap(ErrClassUser, err)
 	}
 	return nil
 }
 
+// commandToRun returns the command to run in a module, which is the
+// command specified in options.Exec or the user defined command with
+// the specified name.
+func (s *stdSystem) commandToRun(command string, mod *Module, options *CmdOptions) (*UserCmd, bool) {
+	if !s.isMaterialized(mod) {
+		return nil, false
+	}
+
+	if options.Exec != nil {
+		return options.Exec, true
+	}
+
+	return s.canRunHere(command, mod)
+}
+
 func (s *stdSystem) canRunHere(command string, mod *Module) (*UserCmd, bool) {
 	c, ok := mod.Commands()[command]
 	if !ok {
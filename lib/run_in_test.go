@@ -17,7 +17,11 @@ package lib
 
 import (
 	"bytes"
+	"io/ioutil"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -449,3 +453,160 @@ func TestFailFast(t *testing.T) {
 	assert.Equal(t, "app-c", result.Skipped[0].Name())
 	assert.Equal(t, "app-a\n", buff.String())
 }
+
+func TestRunInWithExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Exec = &UserCmd{Cmd: "echo $MBT_MODULE_NAME", Shell: "sh"}
+	result, err := NewWorld(t, ".tmp/repo").System.RunInCurrentBranch("exec", NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, []string{"app-a", "app-b"}, moduleNames(result.Completed))
+	assert.Equal(t, "app-a\napp-b\n", buff.String())
+}
+
+func TestRunInWithExecDoesNotExpandTemplates(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Templates: true}))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Exec = &UserCmd{Cmd: "echo", Args: []string{"{{.ID}}", "{{.Module.Name}}"}}
+	_, err := NewWorld(t, ".tmp/repo").System.RunInCurrentBranch("exec", NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "{{.ID}} {{.Module.Name}}\n", buff.String())
+}
+
+func TestRunInWithExecOfFilteredModules(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Exec = &UserCmd{Cmd: "echo", Args: []string{"hello"}}
+	result, err := NewWorld(t, ".tmp/repo").System.RunInCurrentBranch("exec", ExactMatchFilter("app-b"), options)
+	check(t, err)
+
+	assert.Equal(t, []string{"app-b"}, moduleNames(result.Completed))
+	assert.Equal(t, "hello\n", buff.String())
+}
+
+func initConcurrentRunRepo(t *testing.T, aScript string) *TestRepository {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Commands: map[string]*UserCmd{"test": {Cmd: aScript, Shell: "sh"}}}))
+	for _, n := range []string{"app-b", "app-c"} {
+		check(t, repo.InitModuleWithOptions(n, &Spec{
+			Name:         n,
+			Dependencies: []string{"app-a"},
+			Commands:     map[string]*UserCmd{"test": {Cmd: "echo $MBT_MODULE_NAME >> ../order.txt", Shell: "sh"}},
+		}))
+	}
+	check(t, repo.WriteContent(".gitignore", "order.txt\n"))
+	check(t, repo.Commit("first"))
+	return repo
+}
+
+func TestRunInConcurrentlyInDependencyOrder(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	repo := initConcurrentRunRepo(t, "sleep 0.2 && echo $MBT_MODULE_NAME >> ../order.txt")
+
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.Jobs = 2
+	result, err := NewWorld(t, ".tmp/repo").System.RunInCurrentBranch("test", NoFilter, options)
+	check(t, err)
+
+	assert.Len(t, result.Completed, 3)
+	assert.Len(t, result.Failures, 0)
+	order, err := ioutil.ReadFile(filepath.Join(repo.Dir, "order.txt"))
+	check(t, err)
+	assert.Equal(t, "app-a", strings.Split(string(order), "\n")[0])
+}
+
+func TestRunInParallel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	repo := initConcurrentRunRepo(t, "sleep 0.5 && echo $MBT_MODULE_NAME >> ../order.txt")
+
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.Parallel = true
+	options.Jobs = 3
+	result, err := NewWorld(t, ".tmp/repo").System.RunInCurrentBranch("test", NoFilter, options)
+	check(t, err)
+
+	assert.Len(t, result.Completed, 3)
+	order, err := ioutil.ReadFile(filepath.Join(repo.Dir, "order.txt"))
+	check(t, err)
+	assert.Equal(t, "app-a", strings.Split(strings.TrimSpace(string(order)), "\n")[2])
+}
+
+func TestRunInConcurrentlyContinuesAfterFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initConcurrentRunRepo(t, "exit 1")
+
+	var mu sync.Mutex
+	stages := make(map[string]CmdStage)
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.Jobs = 2
+	options.Callback = func(a *Module, s CmdStage, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		stages[a.Name()] = s
+	}
+	result, err := NewWorld(t, ".tmp/repo").System.RunInCurrentBranch("test", NoFilter, options)
+	check(t, err)
+
+	assert.Len(t, result.Failures, 1)
+	assert.Equal(t, "app-a", result.Failures[0].Module.Name())
+	assert.ElementsMatch(t, []string{"app-b", "app-c"}, moduleNames(result.Completed))
+	assert.Equal(t, CmdStageFailedBuild, stages["app-a"])
+}
+
+func TestRunInConcurrentlyWithFailFast(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	initConcurrentRunRepo(t, "exit 1")
+
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.Jobs = 2
+	options.FailFast = true
+	result, err := NewWorld(t, ".tmp/repo").System.RunInCurrentBranch("test", NoFilter, options)
+	check(t, err)
+
+	assert.Len(t, result.Failures, 1)
+	assert.Len(t, result.Completed, 0)
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// sandbox is an isolated copy of the repository containing
+// just the files a module is allowed to see during its build.
+type sandbox struct {
+	dir string
+}
+
+// createSandbox creates a temporary directory and populates it with
+// the content of the module directory and its file dependencies.
+// Files are selected from the workspace using the same rules as git
+// (i.e. ignored files are not copied), so the result is equivalent to
+// a clean checkout of just those paths.
+func (s *stdSystem) createSandbox(m *Manifest, mod *Module) (*sandbox, error) {
+	dir, err := ioutil.TempDir("", "mbt-sandbox-")
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	files, err := s.Repo.FindAllFilesInWorkspace(modulePathSpec(mod))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	for _, f := range files {
+		src := filepath.Join(m.Dir, filepath.FromSlash(f))
+		dst := filepath.Join(dir, filepath.FromSlash(f))
+		if err := copyFile(src, dst); err != nil {
+			os.RemoveAll(dir)
+			return nil, e.Wrapf(ErrClassInternal, err, msgFailedSandboxCopy, f, mod.Name())
+		}
+	}
+
+	// Module directory must exist even if it does not contain any
+	// file other than the spec.
+	err = os.MkdirAll(filepath.Join(dir, filepath.FromSlash(mod.Path())), 0755)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	s.Log.Debug("Created sandbox %s for module %s with %v files", dir, mod.Name(), len(files))
+	return &sandbox{dir: dir}, nil
+}
+
+// modulePathSpec returns the path spec matching the files of a module
+// and its file dependencies.
+func modulePathSpec(mod *Module) []string {
+	// Root module owns the entire repository, hence an empty path spec.
+	if mod.Path() == "" {
+		return nil
+	}
+
+	return append([]string{mod.Path()}, mod.FileDependencies()...)
+}
+
+// manifest returns a shallow copy of the specified manifest rooted at
+// the sandbox directory.
+func (b *sandbox) manifest(m *Manifest) *Manifest {
+	return &Manifest{Dir: b.dir, Sha: m.Sha, Modules: m.Modules}
+}
+
+func (b *sandbox) dispose() error {
+	return os.RemoveAll(b.dir)
+}
+
+func copyFile(src, dst string) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		// Nested git repositories (i.e. submodules) are reported as
+		// directories.
+		return os.MkdirAll(dst, fi.Mode())
+	}
+
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fi.Mode())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
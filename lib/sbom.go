@@ -0,0 +1,551 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// SBOMFormatSPDX formats SBOMs as SPDX 2.3 json documents.
+	SBOMFormatSPDX = "spdx"
+	// SBOMFormatCycloneDX formats SBOMs as CycloneDX 1.5 json documents.
+	SBOMFormatCycloneDX = "cyclonedx"
+)
+
+// SBOMOptions specifies how SBOMs are generated.
+type SBOMOptions struct {
+	// Format of the SBOM (spdx or cyclonedx). Defaults to spdx.
+	Format string
+	// Output creates the writer for the SBOM of a module.
+	Output func(mod *Module) (io.WriteCloser, error)
+}
+
+// sbomFile is a file of a module along with its digests.
+type sbomFile struct {
+	path   string
+	sha1   string
+	sha256 string
+}
+
+// sbomModule is the content of the SBOM of a module.
+// Modules contains the module and the modules it depends on in
+// topological order. External dependencies and files are keyed by
+// module name.
+type sbomModule struct {
+	sha      string
+	module   *Module
+	modules  Modules
+	external map[string][]*ExternalDependency
+	files    map[string][]*sbomFile
+	created  time.Time
+}
+
+func (s *stdSystem) GenerateSBOM(m *Manifest, options *SBOMOptions) error {
+	format := options.Format
+	if format == "" {
+		format = SBOMFormatSPDX
+	}
+
+	var write func(b *sbomModule, w io.Writer) error
+	switch format {
+	case SBOMFormatSPDX:
+		write = writeSPDX
+	case SBOMFormatCycloneDX:
+		write = writeCycloneDX
+	default:
+		return e.NewErrorf(ErrClassUser, msgUnsupportedSBOMFormat, format)
+	}
+
+	generate := func() (interface{}, error) {
+		return nil, s.generateSBOM(m, options, write)
+	}
+
+	if m.Sha == "local" {
+		_, err := generate()
+		return err
+	}
+
+	_, err := s.WorkspaceManager.CheckoutAndRun(m.Sha, generate)
+	return err
+}
+
+func (s *stdSystem) generateSBOM(m *Manifest, options *SBOMOptions, write func(b *sbomModule, w io.Writer) error) error {
+	config, err := loadRepoConfig(m.Dir)
+	if err != nil {
+		return err
+	}
+
+	// Dependencies shared by many modules are inspected once.
+	external := make(map[string][]*ExternalDependency)
+	files := make(map[string][]*sbomFile)
+	created := time.Now().UTC()
+
+	for _, mod := range m.Modules {
+		modules, err := Modules{mod}.expandRequiresDependencies()
+		if err != nil {
+			return err
+		}
+
+		for _, d := range modules {
+			if _, ok := external[d.Name()]; ok {
+				continue
+			}
+
+			external[d.Name()], err = s.externalDependencies(config, m, d)
+			if err != nil {
+				return err
+			}
+
+			files[d.Name()], err = s.sbomFiles(m, d)
+			if err != nil {
+				return err
+			}
+		}
+
+		w, err := options.Output(mod)
+		if err != nil {
+			return err
+		}
+
+		b := &sbomModule{sha: m.Sha, module: mod, modules: modules, external: external, files: files, created: created}
+		err = write(b, w)
+		if cerr := w.Close(); cerr != nil && err == nil {
+			err = e.Wrap(ErrClassInternal, cerr)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// externalDependencies returns the external dependencies declared in
+// the spec of a module followed by the ones listed by SBOM plugins.
+func (s *stdSystem) externalDependencies(config *RepoConfig, m *Manifest, mod *Module) ([]*ExternalDependency, error) {
+	deps := append([]*ExternalDependency{}, mod.ExternalDependencies()...)
+	for _, p := range config.SBOMPlugins {
+		command, args, err := shellCommand(p.Shell, p.Cmd, p.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		var stdout, stderr bytes.Buffer
+		c := exec.Command(command, args...)
+		c.Dir = filepath.Join(m.Dir, filepath.FromSlash(mod.Path()), p.Dir)
+		c.Env = append(os.Environ(), buildEnvironment(m, mod)...)
+		c.Stdout = &stdout
+		c.Stderr = &stderr
+
+		if err := c.Run(); err != nil {
+			return nil, e.NewErrorf(ErrClassUser, msgFailedSBOMPlugin, p.Cmd, mod.Name(), err, strings.TrimSpace(stderr.String()))
+		}
+
+		var listed []*ExternalDependency
+		if err := json.Unmarshal(stdout.Bytes(), &listed); err != nil {
+			return nil, e.NewErrorf(ErrClassUser, msgInvalidSBOMPluginOutput, p.Cmd, mod.Name(), err)
+		}
+
+		deps = append(deps, listed...)
+	}
+
+	return deps, nil
+}
+
+// sbomFiles returns the files of a module and its file dependencies
+// sorted by path.
+func (s *stdSystem) sbomFiles(m *Manifest, mod *Module) ([]*sbomFile, error) {
+	paths, err := s.Repo.FindAllFilesInWorkspace(modulePathSpec(mod))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	files := make([]*sbomFile, 0, len(paths))
+	for _, p := range paths {
+		path := filepath.Join(m.Dir, filepath.FromSlash(p))
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassInternal, err, msgFailedSBOMFileDigest, p, mod.Name())
+		}
+
+		// Nested git repositories (i.e. submodules) are reported as
+		// directories.
+		if fi.IsDir() {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassInternal, err, msgFailedSBOMFileDigest, p, mod.Name())
+		}
+
+		h1 := sha1.Sum(content)
+		h256 := sha256.Sum256(content)
+		files = append(files, &sbomFile{path: p, sha1: hex.EncodeToString(h1[:]), sha256: hex.EncodeToString(h256[:])})
+	}
+
+	return files, nil
+}
+
+func writeSBOM(v interface{}, w io.Writer) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	return nil
+}
+
+/** SPDX **/
+
+type spdxDocument struct {
+	SPDXVersion       string              `json:"spdxVersion"`
+	DataLicense       string              `json:"dataLicense"`
+	SPDXID            string              `json:"SPDXID"`
+	Name              string              `json:"name"`
+	DocumentNamespace string              `json:"documentNamespace"`
+	CreationInfo      *spdxCreationInfo   `json:"creationInfo"`
+	Packages          []*spdxPackage      `json:"packages"`
+	Files             []*spdxFile         `json:"files"`
+	Relationships     []*spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name                    string                   `json:"name"`
+	SPDXID                  string                   `json:"SPDXID"`
+	VersionInfo             string                   `json:"versionInfo,omitempty"`
+	DownloadLocation        string                   `json:"downloadLocation"`
+	FilesAnalyzed           bool                     `json:"filesAnalyzed"`
+	PackageVerificationCode *spdxVerificationCode    `json:"packageVerificationCode,omitempty"`
+	SourceInfo              string                   `json:"sourceInfo,omitempty"`
+	LicenseConcluded        string                   `json:"licenseConcluded"`
+	LicenseDeclared         string                   `json:"licenseDeclared"`
+	CopyrightText           string                   `json:"copyrightText"`
+	ExternalRefs            []*spdxExternalReference `json:"externalRefs,omitempty"`
+}
+
+type spdxVerificationCode struct {
+	PackageVerificationCodeValue string `json:"packageVerificationCodeValue"`
+}
+
+type spdxExternalReference struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxFile struct {
+	FileName  string          `json:"fileName"`
+	SPDXID    string          `json:"SPDXID"`
+	Checksums []*spdxChecksum `json:"checksums"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+const spdxNoAssertion = "NOASSERTION"
+
+var spdxInvalidIDChars = regexp.MustCompile(`[^A-Za-z0-9.-]`)
+
+func spdxID(kind, name string) string {
+	return fmt.Sprintf("SPDXRef-%s-%s", kind, spdxInvalidIDChars.ReplaceAllString(name, "-"))
+}
+
+func writeSPDX(b *sbomModule, w io.Writer) error {
+	doc := &spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              fmt.Sprintf("%s-%s", b.module.Name(), b.module.Version()),
+		DocumentNamespace: fmt.Sprintf("https://mbtproject.github.io/spdx/%s/%s", b.module.Name(), b.module.Version()),
+		CreationInfo:      &spdxCreationInfo{Created: b.created.Format(time.RFC3339), Creators: []string{"Tool: mbt"}},
+		Packages:          []*spdxPackage{},
+		Files:             []*spdxFile{},
+		Relationships: []*spdxRelationship{
+			{SPDXElementID: "SPDXRef-DOCUMENT", RelationshipType: "DESCRIBES", RelatedSPDXElement: spdxID("Module", b.module.Name())},
+		},
+	}
+
+	// Files and external dependencies shared by modules are included
+	// once.
+	fileIDs := make(map[string]string)
+	externalIDs := make(map[string]string)
+	for _, mod := range b.modules {
+		id := spdxID("Module", mod.Name())
+		pkg := &spdxPackage{
+			Name:             mod.Name(),
+			SPDXID:           id,
+			VersionInfo:      mod.Version(),
+			DownloadLocation: spdxNoAssertion,
+			FilesAnalyzed:    true,
+			SourceInfo:       fmt.Sprintf("mbt module in %s at commit %s", mod.Path(), b.sha),
+			LicenseConcluded: spdxNoAssertion,
+			LicenseDeclared:  spdxNoAssertion,
+			CopyrightText:    spdxNoAssertion,
+		}
+		doc.Packages = append(doc.Packages, pkg)
+
+		files := b.files[mod.Name()]
+		digests := make([]string, 0, len(files))
+		for _, f := range files {
+			digests = append(digests, f.sha1)
+			fileID, ok := fileIDs[f.path]
+			if !ok {
+				fileID = fmt.Sprintf("SPDXRef-File-%d", len(fileIDs))
+				fileIDs[f.path] = fileID
+				doc.Files = append(doc.Files, &spdxFile{
+					FileName: "./" + f.path,
+					SPDXID:   fileID,
+					Checksums: []*spdxChecksum{
+						{Algorithm: "SHA1", ChecksumValue: f.sha1},
+						{Algorithm: "SHA256", ChecksumValue: f.sha256},
+					},
+				})
+			}
+			doc.Relationships = append(doc.Relationships, &spdxRelationship{SPDXElementID: id, RelationshipType: "CONTAINS", RelatedSPDXElement: fileID})
+		}
+
+		// Verification code is the sha1 of the sorted sha1 digests of
+		// the files in the package (SPDX 2.3 section 7.9).
+		sort.Strings(digests)
+		code := sha1.Sum([]byte(strings.Join(digests, "")))
+		pkg.PackageVerificationCode = &spdxVerificationCode{PackageVerificationCodeValue: hex.EncodeToString(code[:])}
+
+		for _, r := range mod.Requires() {
+			doc.Relationships = append(doc.Relationships, &spdxRelationship{SPDXElementID: id, RelationshipType: "DEPENDS_ON", RelatedSPDXElement: spdxID("Module", r.Name())})
+		}
+
+		for _, d := range b.external[mod.Name()] {
+			key := externalKey(d)
+			externalID, ok := externalIDs[key]
+			if !ok {
+				externalID = fmt.Sprintf("SPDXRef-Package-%d", len(externalIDs))
+				externalIDs[key] = externalID
+				license := d.License
+				if license == "" {
+					license = spdxNoAssertion
+				}
+				p := &spdxPackage{
+					Name:             d.Name,
+					SPDXID:           externalID,
+					VersionInfo:      d.Version,
+					DownloadLocation: spdxNoAssertion,
+					LicenseConcluded: spdxNoAssertion,
+					LicenseDeclared:  license,
+					CopyrightText:    spdxNoAssertion,
+				}
+				if d.Purl != "" {
+					p.ExternalRefs = []*spdxExternalReference{{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: d.Purl}}
+				}
+				doc.Packages = append(doc.Packages, p)
+			}
+			doc.Relationships = append(doc.Relationships, &spdxRelationship{SPDXElementID: id, RelationshipType: "DEPENDS_ON", RelatedSPDXElement: externalID})
+		}
+	}
+
+	return writeSBOM(doc, w)
+}
+
+/** CycloneDX **/
+
+type cdxDocument struct {
+	BOMFormat    string           `json:"bomFormat"`
+	SpecVersion  string           `json:"specVersion"`
+	SerialNumber string           `json:"serialNumber"`
+	Version      int              `json:"version"`
+	Metadata     *cdxMetadata     `json:"metadata"`
+	Components   []*cdxComponent  `json:"components"`
+	Dependencies []*cdxDependency `json:"dependencies"`
+}
+
+type cdxMetadata struct {
+	Timestamp string        `json:"timestamp"`
+	Tools     *cdxTools     `json:"tools"`
+	Component *cdxComponent `json:"component"`
+}
+
+type cdxTools struct {
+	Components []*cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type       string         `json:"type"`
+	BOMRef     string         `json:"bom-ref,omitempty"`
+	Name       string         `json:"name"`
+	Version    string         `json:"version,omitempty"`
+	Purl       string         `json:"purl,omitempty"`
+	Hashes     []*cdxHash     `json:"hashes,omitempty"`
+	Licenses   []*cdxLicense  `json:"licenses,omitempty"`
+	Properties []*cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxLicense struct {
+	Expression string `json:"expression"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+func cdxModuleRef(mod *Module) string {
+	return "module:" + mod.Name()
+}
+
+// cdxSerialNumber derives the serial number of the SBOM from the
+// version of the module so that it is stable for a given version.
+func cdxSerialNumber(mod *Module) string {
+	h := sha1.Sum([]byte(mod.Name() + "\x00" + mod.Version()))
+	// Version 5 (sha1 name based) variant 1 uuid.
+	h[6] = (h[6] & 0x0f) | 0x50
+	h[8] = (h[8] & 0x3f) | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+func cdxModuleComponent(mod *Module, sha string) *cdxComponent {
+	c := &cdxComponent{
+		Type:    "application",
+		BOMRef:  cdxModuleRef(mod),
+		Name:    mod.Name(),
+		Version: mod.Version(),
+		Properties: []*cdxProperty{
+			{Name: "mbt:path", Value: mod.Path()},
+			{Name: "mbt:commit", Value: sha},
+		},
+	}
+
+	for _, o := range mod.Owners() {
+		c.Properties = append(c.Properties, &cdxProperty{Name: "mbt:owner", Value: o})
+	}
+
+	return c
+}
+
+func writeCycloneDX(b *sbomModule, w io.Writer) error {
+	doc := &cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: cdxSerialNumber(b.module),
+		Version:      1,
+		Metadata: &cdxMetadata{
+			Timestamp: b.created.Format(time.RFC3339),
+			Tools:     &cdxTools{Components: []*cdxComponent{{Type: "application", Name: "mbt"}}},
+			Component: cdxModuleComponent(b.module, b.sha),
+		},
+		Components:   []*cdxComponent{},
+		Dependencies: []*cdxDependency{},
+	}
+
+	refs := make(map[string]bool)
+	for _, mod := range b.modules {
+		if mod != b.module {
+			doc.Components = append(doc.Components, cdxModuleComponent(mod, b.sha))
+		}
+
+		dependsOn := []string{}
+		for _, r := range mod.Requires() {
+			dependsOn = append(dependsOn, cdxModuleRef(r))
+		}
+
+		for _, f := range b.files[mod.Name()] {
+			ref := "file:" + f.path
+			dependsOn = append(dependsOn, ref)
+			if refs[ref] {
+				continue
+			}
+			refs[ref] = true
+			doc.Components = append(doc.Components, &cdxComponent{
+				Type:   "file",
+				BOMRef: ref,
+				Name:   f.path,
+				Hashes: []*cdxHash{{Alg: "SHA-1", Content: f.sha1}, {Alg: "SHA-256", Content: f.sha256}},
+			})
+		}
+
+		for _, d := range b.external[mod.Name()] {
+			ref := externalKey(d)
+			dependsOn = append(dependsOn, ref)
+			if refs[ref] {
+				continue
+			}
+			refs[ref] = true
+			c := &cdxComponent{Type: "library", BOMRef: ref, Name: d.Name, Version: d.Version, Purl: d.Purl}
+			if d.License != "" {
+				c.Licenses = []*cdxLicense{{Expression: d.License}}
+			}
+			doc.Components = append(doc.Components, c)
+		}
+
+		doc.Dependencies = append(doc.Dependencies, &cdxDependency{Ref: cdxModuleRef(mod), DependsOn: dependsOn})
+	}
+
+	return writeSBOM(doc, w)
+}
+
+// externalKey identifies an external dependency by its package url
+// or the name and version when the package url is not available.
+func externalKey(d *ExternalDependency) string {
+	if d.Purl != "" {
+		return d.Purl
+	}
+	return fmt.Sprintf("%s@%s", d.Name, d.Version)
+}
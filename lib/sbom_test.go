@@ -0,0 +1,234 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"runtime"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func sbomOptions(format string, outputs map[string]*bytes.Buffer) *SBOMOptions {
+	return &SBOMOptions{
+		Format: format,
+		Output: func(mod *Module) (io.WriteCloser, error) {
+			buff := new(bytes.Buffer)
+			outputs[mod.Name()] = buff
+			return nopWriteCloser{buff}, nil
+		},
+	}
+}
+
+func readSBOM(t *testing.T, buff *bytes.Buffer) map[string]interface{} {
+	var doc map[string]interface{}
+	check(t, json.Unmarshal(buff.Bytes(), &doc))
+	return doc
+}
+
+func TestSPDXSBOM(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:                 "app-a",
+		FileDependencies:     []string{"lib-a"},
+		ExternalDependencies: []*ExternalDependency{{Name: "yaml", Version: "2.1.0", Purl: "pkg:golang/gopkg.in/yaml.v2@2.1.0", License: "Apache-2.0"}},
+	}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Dependencies: []string{"app-a"}}))
+	check(t, repo.WriteContent("lib-a/a.go", "a"))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	m, err := world.System.ManifestByCurrentBranch()
+	check(t, err)
+
+	outputs := make(map[string]*bytes.Buffer)
+	check(t, world.System.GenerateSBOM(m, sbomOptions(SBOMFormatSPDX, outputs)))
+
+	assert.Len(t, outputs, 2)
+	doc := readSBOM(t, outputs["app-b"])
+	assert.Equal(t, "SPDX-2.3", doc["spdxVersion"])
+	assert.Equal(t, "app-b-"+m.Modules[1].Version(), doc["name"])
+
+	packages := doc["packages"].([]interface{})
+	assert.Len(t, packages, 3)
+	assert.Equal(t, "app-a", packages[0].(map[string]interface{})["name"])
+	assert.Equal(t, m.Modules[0].Version(), packages[0].(map[string]interface{})["versionInfo"])
+	assert.Equal(t, "app-b", packages[2].(map[string]interface{})["name"])
+	yaml := packages[1].(map[string]interface{})
+	assert.Equal(t, "yaml", yaml["name"])
+	assert.Equal(t, "Apache-2.0", yaml["licenseDeclared"])
+	assert.Equal(t, "pkg:golang/gopkg.in/yaml.v2@2.1.0", yaml["externalRefs"].([]interface{})[0].(map[string]interface{})["referenceLocator"])
+
+	files := make(map[string]interface{})
+	for _, f := range doc["files"].([]interface{}) {
+		f := f.(map[string]interface{})
+		files[f["fileName"].(string)] = f["checksums"].([]interface{})[0].(map[string]interface{})["checksumValue"]
+	}
+	assert.Equal(t, map[string]interface{}{
+		"./app-a/.mbt.yml": files["./app-a/.mbt.yml"],
+		"./app-b/.mbt.yml": files["./app-b/.mbt.yml"],
+		// sha1 of "a"
+		"./lib-a/a.go": "86f7e437faa5a7fce15d1ddcb9eaeaea377667b8",
+	}, files)
+
+	relationships := make([]string, 0)
+	for _, r := range doc["relationships"].([]interface{}) {
+		r := r.(map[string]interface{})
+		if r["relationshipType"] != "CONTAINS" {
+			relationships = append(relationships, r["spdxElementId"].(string)+" "+r["relationshipType"].(string)+" "+r["relatedSpdxElement"].(string))
+		}
+	}
+	assert.Equal(t, []string{
+		"SPDXRef-DOCUMENT DESCRIBES SPDXRef-Module-app-b",
+		"SPDXRef-Module-app-a DEPENDS_ON SPDXRef-Package-0",
+		"SPDXRef-Module-app-b DEPENDS_ON SPDXRef-Module-app-a",
+	}, relationships)
+
+	assert.Len(t, readSBOM(t, outputs["app-a"])["packages"], 2)
+}
+
+func TestCycloneDXSBOM(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:                 "app-a",
+		Owners:               []string{"team-a"},
+		ExternalDependencies: []*ExternalDependency{{Name: "left-pad", Version: "1.3.0", License: "MIT"}},
+	}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Dependencies: []string{"app-a"}}))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	m, err := world.System.ManifestByCurrentBranch()
+	check(t, err)
+
+	outputs := make(map[string]*bytes.Buffer)
+	check(t, world.System.GenerateSBOM(m, sbomOptions(SBOMFormatCycloneDX, outputs)))
+
+	doc := readSBOM(t, outputs["app-b"])
+	assert.Equal(t, "CycloneDX", doc["bomFormat"])
+	assert.Equal(t, "1.5", doc["specVersion"])
+	assert.Equal(t, cdxSerialNumber(m.Modules[1]), doc["serialNumber"])
+	assert.Regexp(t, `^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, doc["serialNumber"])
+
+	component := doc["metadata"].(map[string]interface{})["component"].(map[string]interface{})
+	assert.Equal(t, "module:app-b", component["bom-ref"])
+	assert.Equal(t, m.Modules[1].Version(), component["version"])
+
+	refs := make([]string, 0)
+	for _, c := range doc["components"].([]interface{}) {
+		refs = append(refs, c.(map[string]interface{})["bom-ref"].(string))
+	}
+	assert.Equal(t, []string{"module:app-a", "file:app-a/.mbt.yml", "left-pad@1.3.0", "file:app-b/.mbt.yml"}, refs)
+
+	dependencies := doc["dependencies"].([]interface{})
+	assert.Equal(t, map[string]interface{}{"ref": "module:app-a", "dependsOn": []interface{}{"file:app-a/.mbt.yml", "left-pad@1.3.0"}}, dependencies[0])
+	assert.Equal(t, map[string]interface{}{"ref": "module:app-b", "dependsOn": []interface{}{"module:app-a", "file:app-b/.mbt.yml"}}, dependencies[1])
+}
+
+func TestSBOMWithPlugins(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell plugins are not supported on windows")
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{SBOMPlugins: []*Cmd{
+		{Cmd: `echo "[{\"name\": \"$MBT_MODULE_NAME-dep\", \"version\": \"$(cat version)\"}]"`, Shell: "sh"},
+	}}))
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a"}))
+	check(t, repo.WriteContent("app-a/version", "1.0.0"))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	m, err := world.System.ManifestByCurrentBranch()
+	check(t, err)
+
+	outputs := make(map[string]*bytes.Buffer)
+	check(t, world.System.GenerateSBOM(m, sbomOptions(SBOMFormatCycloneDX, outputs)))
+
+	components := readSBOM(t, outputs["app-a"])["components"].([]interface{})
+	dep := components[len(components)-1].(map[string]interface{})
+	assert.Equal(t, "app-a-dep", dep["name"])
+	assert.Equal(t, "1.0.0", dep["version"])
+}
+
+func TestSBOMWithFailingPlugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell plugins are not supported on windows")
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{SBOMPlugins: []*Cmd{{Cmd: `echo not json`, Shell: "sh"}}}))
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a"}))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	m, err := world.System.ManifestByCurrentBranch()
+	check(t, err)
+
+	err = world.System.GenerateSBOM(m, sbomOptions(SBOMFormatSPDX, make(map[string]*bytes.Buffer)))
+
+	assert.Error(t, err)
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+	assert.Contains(t, err.Error(), "Invalid output from SBOM plugin echo not json for module app-a")
+}
+
+func TestSBOMOfWorkspace(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a"}))
+	check(t, repo.Commit("first"))
+	check(t, repo.WriteContent("app-a/new", "uncommitted"))
+
+	world := NewWorld(t, ".tmp/repo")
+	m, err := world.System.ManifestByWorkspace()
+	check(t, err)
+
+	outputs := make(map[string]*bytes.Buffer)
+	check(t, world.System.GenerateSBOM(m, sbomOptions(SBOMFormatSPDX, outputs)))
+
+	assert.Len(t, readSBOM(t, outputs["app-a"])["files"], 2)
+}
+
+func TestUnsupportedSBOMFormat(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a"}))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	m, err := world.System.ManifestByCurrentBranch()
+	check(t, err)
+
+	err = world.System.GenerateSBOM(m, sbomOptions("swid", nil))
+
+	assert.Error(t, err)
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+	assert.EqualError(t, err, "Unsupported SBOM format 'swid'")
+}
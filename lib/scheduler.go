@@ -0,0 +1,373 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// buildFunc builds a single module and returns the results.
+type buildFunc func(cmd *Cmd, mod *Module, options *CmdOptions) ([]*BuildResult, error)
+
+// task is a unit of work managed by the scheduler.
+type task struct {
+	module *Module
+	// cmd is nil if the module cannot be built on this platform.
+	cmd    *Cmd
+	cpu    int
+	memory int64
+	timing *ModuleTiming
+}
+
+type taskResult struct {
+	task    *task
+	results []*BuildResult
+	err     error
+}
+
+// schedulePolicy customises how the scheduler processes modules.
+type schedulePolicy struct {
+	// command returns the command of a module or nil if the module
+	// cannot be processed on this platform.
+	command func(*Module) *Cmd
+	// continueOnFailure keeps starting the remaining modules after a
+	// failure. Modules depending on the failed module are started as well.
+	continueOnFailure bool
+	// ignoreDependencies starts modules without waiting for their
+	// dependencies.
+	ignoreDependencies bool
+	// keepGoing keeps starting the remaining modules after a failure,
+	// except the modules depending on a failed module.
+	keepGoing bool
+}
+
+// schedule builds the modules in a manifest using up to options.Jobs
+// concurrent builds.
+// A module is started only after all its dependencies in the manifest are
+// built and when the resources it requires are available. Modules sharing
+// a lock are never built at the same time. A module requiring more
+// resources than the limits is built when nothing else is running.
+// No new builds are started after a failure, however the builds already
+// in progress are allowed to complete. With options.KeepGoing, modules
+// not depending on a failed module are built as well. First error is
+// returned along with the summary of the modules processed.
+// No new builds are started either once options.Context is done, in
+// which case the error returned informs the cancellation.
+// With a single job, modules are built in the order of the manifest.
+// Returned summary does not include the manifest.
+func (s *stdSystem) schedule(m *Manifest, options *CmdOptions, build buildFunc) (*BuildSummary, error) {
+	policy := &schedulePolicy{
+		command: func(a *Module) *Cmd {
+			c, _ := s.canBuildHere(a)
+			return c
+		},
+		keepGoing: options.KeepGoing,
+	}
+	return s.scheduleWithPolicy(m, options, policy, build)
+}
+
+// scheduleWithPolicy processes the modules in a manifest like schedule,
+// with the customisations in the policy.
+func (s *stdSystem) scheduleWithPolicy(m *Manifest, options *CmdOptions, policy *schedulePolicy, build buildFunc) (*BuildSummary, error) {
+	jobs := options.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	cpuLimit := options.CPULimit
+	if cpuLimit < 1 {
+		cpuLimit = runtime.NumCPU()
+	}
+
+	memoryLimit := machineMemory()
+	if options.MemoryLimit != "" {
+		l, err := parseMemory(options.MemoryLimit)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgInvalidMemoryLimit, options.MemoryLimit)
+		}
+		memoryLimit = l
+	}
+
+	queued := time.Now()
+	pending := make([]*task, 0, len(m.Modules))
+	for _, a := range m.Modules {
+		t := &task{module: a, timing: &ModuleTiming{Module: a, Queued: queued}}
+		t.cmd = policy.command(a)
+		if r := a.Resources(); r != nil {
+			t.cpu = r.CPU
+			if r.Memory != "" {
+				mem, err := parseMemory(r.Memory)
+				if err != nil {
+					return nil, e.Wrapf(ErrClassUser, err, msgInvalidModuleMemory, r.Memory, a.Name())
+				}
+				t.memory = mem
+			}
+		}
+		pending = append(pending, t)
+	}
+
+	for _, t := range pending {
+		emitEvent(options, EventModuleQueue, t.module, time.Time{}, nil)
+	}
+
+	if jobs > 1 {
+		// Output of concurrent builds share the same streams.
+		options = withSyncStreams(options)
+	}
+
+	inManifest := m.Modules.indexByName()
+	done := make(map[string]bool)
+	failed := make(map[string]bool)
+	finished := make(map[string]time.Time)
+	timings := make([]*ModuleTiming, 0)
+	locks := make(map[string]bool)
+	completed := make([]*BuildResult, 0)
+	skipped := make([]*Module, 0)
+	results := make(chan *taskResult)
+	running, cpuUsed := 0, 0
+	var memoryUsed int64
+	var firstErr error
+
+	// blockedBy returns the dependency of a task that failed or was not
+	// built because of a failure.
+	blockedBy := func(t *task) string {
+		for _, r := range t.module.Requires() {
+			if _, ok := inManifest[r.Name()]; ok && failed[r.Name()] {
+				return r.Name()
+			}
+		}
+		return ""
+	}
+
+	ready := func(t *task) bool {
+		for _, r := range t.module.Requires() {
+			if _, ok := inManifest[r.Name()]; ok && !done[r.Name()] && !policy.ignoreDependencies {
+				return false
+			}
+		}
+
+		for _, l := range t.module.SerializationKeys() {
+			if locks[l] {
+				return false
+			}
+		}
+
+		if running == 0 {
+			return true
+		}
+
+		return cpuUsed+t.cpu <= cpuLimit && (memoryLimit == 0 || memoryUsed+t.memory <= memoryLimit)
+	}
+
+	acquire := func(t *task, v bool) {
+		for _, l := range t.module.SerializationKeys() {
+			locks[l] = v
+		}
+
+		sign := 1
+		if !v {
+			sign = -1
+		}
+		cpuUsed += sign * t.cpu
+		memoryUsed += int64(sign) * t.memory
+	}
+
+	for len(pending) > 0 || running > 0 {
+		stopped := cancelled(options.Context) != nil
+		for i := 0; !stopped && (firstErr == nil || policy.continueOnFailure || policy.keepGoing) && i < len(pending) && running < jobs; {
+			t := pending[i]
+			// Pending modules are in topological order, so the
+			// dependents of a failed module are all blocked in a
+			// single pass.
+			if d := blockedBy(t); policy.keepGoing && d != "" {
+				pending = append(pending[:i], pending[i+1:]...)
+				failed[t.module.Name()] = true
+				s.Log.Warnf(msgBlockedByFailure, t.module.Name(), d)
+				continue
+			}
+
+			if t.cmd == nil {
+				pending = append(pending[:i], pending[i+1:]...)
+				done[t.module.Name()] = true
+				skipped = append(skipped, t.module)
+				options.Callback(t.module, CmdStageSkipBuild, nil)
+				emitEvent(options, EventModuleSkip, t.module, time.Time{}, nil)
+				continue
+			}
+
+			if !ready(t) {
+				i++
+				continue
+			}
+
+			pending = append(pending[:i], pending[i+1:]...)
+			acquire(t, true)
+			running++
+			t.timing.Started = time.Now()
+			t.timing.Ready = readyAt(t, finished)
+			timings = append(timings, t.timing)
+			emitEvent(options, EventModuleStart, t.module, time.Time{}, nil)
+			go func(t *task) {
+				o, release, err := withModuleOutput(options, t.module)
+				var r []*BuildResult
+				if err == nil {
+					r, err = build(t.cmd, t.module, o)
+					release()
+				}
+				results <- &taskResult{task: t, results: r, err: err}
+			}(t)
+		}
+
+		if running == 0 {
+			break
+		}
+
+		r := <-results
+		running--
+		acquire(r.task, false)
+		r.task.timing.Finished = time.Now()
+		r.task.timing.Err = r.err
+		finished[r.task.module.Name()] = r.task.timing.Finished
+		emitEvent(options, EventModuleFinish, r.task.module, r.task.timing.Started, r.err)
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if policy.continueOnFailure {
+				done[r.task.module.Name()] = true
+			}
+			failed[r.task.module.Name()] = true
+			continue
+		}
+
+		done[r.task.module.Name()] = true
+		completed = append(completed, r.results...)
+		options.Callback(r.task.module, CmdStageAfterBuild, nil)
+	}
+
+	// Results are reported in the order of the manifest rather than
+	// the order concurrent builds finished in.
+	index := m.Modules.indexOf()
+	sort.SliceStable(completed, func(i, j int) bool {
+		return index[completed[i].Module.Name()] < index[completed[j].Module.Name()]
+	})
+
+	if err := cancelled(options.Context); err != nil {
+		firstErr = err
+	}
+
+	// Partial summary is returned on error so that the outcome of the
+	// modules can be reported.
+	return &BuildSummary{Completed: completed, Skipped: skipped, Timings: timings}, firstErr
+}
+
+// readyAt returns the time when the last dependency of a task in the
+// manifest was built.
+func readyAt(t *task, finished map[string]time.Time) time.Time {
+	ready := t.timing.Queued
+	for _, r := range t.module.Requires() {
+		if f, ok := finished[r.Name()]; ok && f.After(ready) {
+			ready = f
+		}
+	}
+	return ready
+}
+
+// syncWriter serialises the writes to an underlying writer.
+type syncWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+func withSyncStreams(options *CmdOptions) *CmdOptions {
+	o := *options
+	mu := &sync.Mutex{}
+	if o.Stdout != nil {
+		o.Stdout = &syncWriter{mu: mu, w: o.Stdout}
+	}
+	if o.Stderr != nil {
+		o.Stderr = &syncWriter{mu: mu, w: o.Stderr}
+	}
+	return &o
+}
+
+// parseMemory converts a memory quantity (e.g. 512Mi, 8Gi, 1G) to
+// number of bytes.
+func parseMemory(q string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+		{"K", 1e3}, {"k", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+	}
+
+	multiplier := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(q, u.suffix) {
+			q = strings.TrimSuffix(q, u.suffix)
+			multiplier = u.multiplier
+			break
+		}
+	}
+
+	v, err := strconv.ParseInt(q, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return v * multiplier, nil
+}
+
+// machineMemory returns the physical memory of the machine in bytes.
+// Returns zero if it cannot be determined.
+func machineMemory() int64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb << 10
+		}
+	}
+
+	return 0
+}
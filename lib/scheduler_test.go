@@ -0,0 +1,386 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+// exclusiveScript returns a build script that fails if another build
+// using the same marker file is running at the same time.
+func exclusiveScript(name string) string {
+	return fmt.Sprintf(`if [ -f ../running ]; then exit 1; fi
+touch ../running
+sleep 0.2
+rm ../running
+echo built %s`, name)
+}
+
+func TestParallelBuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	for _, p := range []struct{ name, peer string }{{"app-a", "app-b"}, {"app-b", "app-a"}} {
+		check(t, repo.InitModule(p.name))
+		// Each build waits for the other one to start.
+		check(t, repo.WriteShellScript(p.name+"/build.sh", fmt.Sprintf(`touch ../%s.started
+i=0
+while [ ! -f ../%s.started ]; do
+  i=$((i+1))
+  if [ $i -gt 100 ]; then exit 1; fi
+  sleep 0.05
+done`, p.name, p.peer)))
+	}
+	check(t, repo.Commit("first"))
+
+	options := stdTestCmdOptions(nil)
+	options.Jobs = 2
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Len(t, summary.Completed, 2)
+}
+
+func TestParallelBuildSummaryIsInManifestOrder(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	// Modules finish in the reverse order of the manifest.
+	for i, name := range []string{"app-a", "app-b", "app-c"} {
+		check(t, repo.InitModule(name))
+		check(t, repo.WriteShellScript(name+"/build.sh", fmt.Sprintf("sleep 0.%d", 3-i)))
+	}
+	check(t, repo.Commit("first"))
+
+	options := stdTestCmdOptions(nil)
+	options.Jobs = 3
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	names := make([]string, 0)
+	for _, r := range summary.Completed {
+		names = append(names, r.Module.Name())
+	}
+	assert.Equal(t, []string{"app-a", "app-b", "app-c"}, names)
+}
+
+func TestParallelBuildRespectsDependencies(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "sleep 0.2\ntouch ../app-a.built"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:         "app-b",
+		Build:        map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Dependencies: []string{"app-a"},
+	}))
+	check(t, repo.WriteShellScript("app-b/build.sh", "test -f ../app-a.built"))
+	check(t, repo.Commit("first"))
+
+	options := stdTestCmdOptions(nil)
+	options.Jobs = 2
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "app-a", summary.Completed[0].Module.Name())
+	assert.Equal(t, "app-b", summary.Completed[1].Module.Name())
+}
+
+func TestParallelBuildHonorsLocks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	for _, n := range []string{"app-a", "app-b", "app-c"} {
+		check(t, repo.InitModuleWithOptions(n, &Spec{
+			Name:      n,
+			Build:     map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+			Resources: &Resources{Locks: []string{"dockerd"}},
+		}))
+		check(t, repo.WriteShellScript(n+"/build.sh", exclusiveScript(n)))
+	}
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Jobs = 3
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "built app-a\nbuilt app-b\nbuilt app-c\n", buff.String())
+}
+
+func TestParallelBuildHonorsSerializationKeys(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	for n, spec := range map[string]*Spec{
+		"app-a": {SerializeOn: []string{"database-migrations"}},
+		"app-b": {SerializeOn: []string{"database-migrations", "dockerd"}},
+		"app-c": {Resources: &Resources{Locks: []string{"database-migrations"}}},
+	} {
+		spec.Name = n
+		spec.Build = map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}}
+		check(t, repo.InitModuleWithOptions(n, spec))
+		check(t, repo.WriteShellScript(n+"/build.sh", exclusiveScript(n)))
+	}
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Jobs = 3
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, "built app-a\nbuilt app-b\nbuilt app-c\n", buff.String())
+}
+
+func TestSerializationKeys(t *testing.T) {
+	m := newModule(newModuleMetadata("app-a", "", &Spec{
+		Name:        "app-a",
+		Resources:   &Resources{Locks: []string{"dockerd"}},
+		SerializeOn: []string{"database-migrations", "dockerd"},
+	}, nil), nil)
+
+	assert.Equal(t, []string{"dockerd", "database-migrations"}, m.SerializationKeys())
+}
+
+func TestParallelBuildHonorsCPULimit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	for _, n := range []string{"app-a", "app-b"} {
+		check(t, repo.InitModuleWithOptions(n, &Spec{
+			Name:      n,
+			Build:     map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+			Resources: &Resources{CPU: 3},
+		}))
+		check(t, repo.WriteShellScript(n+"/build.sh", exclusiveScript(n)))
+	}
+	check(t, repo.Commit("first"))
+
+	options := stdTestCmdOptions(nil)
+	options.Jobs = 2
+	options.CPULimit = 4
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+}
+
+func TestParallelBuildHonorsMemoryLimit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	for _, n := range []string{"app-a", "app-b"} {
+		check(t, repo.InitModuleWithOptions(n, &Spec{
+			Name:      n,
+			Build:     map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+			Resources: &Resources{Memory: "6Gi"},
+		}))
+		check(t, repo.WriteShellScript(n+"/build.sh", exclusiveScript(n)))
+	}
+	check(t, repo.Commit("first"))
+
+	options := stdTestCmdOptions(nil)
+	options.Jobs = 2
+	options.MemoryLimit = "8Gi"
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+}
+
+func TestParallelBuildFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "exit 1"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:         "app-b",
+		Build:        map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Dependencies: []string{"app-a"},
+	}))
+	check(t, repo.WriteShellScript("app-b/build.sh", "echo built app-b"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	options := stdTestCmdOptions(buff)
+	options.Jobs = 2
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+
+	assert.EqualError(t, err, fmt.Sprintf(msgFailedBuild, "app-a"))
+	assert.Equal(t, "", buff.String())
+}
+
+func TestBuildKeepGoing(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "exit 1"))
+	for n, deps := range map[string][]string{"app-b": {"app-a"}, "app-c": {}, "app-d": {"app-b"}} {
+		check(t, repo.InitModuleWithOptions(n, &Spec{
+			Name:         n,
+			Build:        map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+			Dependencies: deps,
+		}))
+		check(t, repo.WriteShellScript(n+"/build.sh", "echo built "+n))
+	}
+	check(t, repo.Commit("first"))
+
+	for _, jobs := range []int{1, 2} {
+		buff := new(bytes.Buffer)
+		options := stdTestCmdOptions(buff)
+		options.Jobs = jobs
+		options.KeepGoing = true
+		options.SummaryFile = ".tmp/summary.json"
+		summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+
+		assert.EqualError(t, err, fmt.Sprintf(msgFailedBuild, "app-a"))
+		assert.Equal(t, "built app-c\n", buff.String())
+
+		// Partial summary is returned along with the error.
+		assert.Len(t, summary.Completed, 1)
+		assert.Equal(t, "app-c", summary.Completed[0].Module.Name())
+		assert.Len(t, summary.Timings, 2)
+
+		statuses := make(map[string]string)
+		for _, m := range readInvocationSummary(t, ".tmp/summary.json").Modules {
+			statuses[m.Name] = m.Status
+		}
+		assert.Equal(t, map[string]string{
+			"app-a": ModuleStatusFailed,
+			"app-b": ModuleStatusNotStarted,
+			"app-c": ModuleStatusSucceeded,
+			"app-d": ModuleStatusNotStarted,
+		}, statuses)
+	}
+}
+
+func TestBuildWithInvalidMemoryRequirement(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:      "app-a",
+		Build:     map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Resources: &Resources{Memory: "lots"},
+	}))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(nil))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgInvalidModuleMemory, "lots", "app-a"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestParseMemory(t *testing.T) {
+	for q, expected := range map[string]int64{
+		"1024":  1024,
+		"1Ki":   1024,
+		"512Mi": 512 << 20,
+		"8Gi":   8 << 30,
+		"1G":    1000000000,
+		"2k":    2000,
+	} {
+		v, err := parseMemory(q)
+		check(t, err)
+		assert.Equal(t, expected, v, q)
+	}
+
+	_, err := parseMemory("8GB")
+	assert.Error(t, err)
+}
+
+func TestBuildTimings(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "sleep 0.1"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:         "app-b",
+		Build:        map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Dependencies: []string{"app-a"},
+	}))
+	check(t, repo.WriteShellScript("app-b/build.sh", "true"))
+	check(t, repo.InitModuleWithOptions("app-c", &Spec{
+		Name:  "app-c",
+		Build: map[string]*Cmd{"unknown-os": {Cmd: "true"}},
+	}))
+	check(t, repo.Commit("first"))
+
+	options := stdTestCmdOptions(nil)
+	options.Jobs = 2
+	summary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Len(t, summary.Timings, 2)
+	a, b := summary.Timings[0], summary.Timings[1]
+	assert.Equal(t, "app-a", a.Module.Name())
+	assert.Equal(t, "app-b", b.Module.Name())
+	assert.Equal(t, a.Queued, b.Queued)
+	assert.Equal(t, a.Queued, a.Ready)
+	assert.True(t, a.Finished.Sub(a.Started) >= 100*time.Millisecond)
+	assert.Equal(t, a.Finished, b.Ready)
+	assert.False(t, b.Started.Before(b.Ready))
+	assert.False(t, b.Finished.Before(b.Started))
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// PropertySchema describes the value of a module property.
+// It is a subset of JSON schema, so that the schema of existing
+// tools can be used without changes.
+type PropertySchema struct {
+	// Type is one of string, integer, number, boolean, array or object.
+	// Values of any type are accepted if it is not specified.
+	Type string `yaml:"type,omitempty"`
+	// Enum is the list of allowed values.
+	Enum []interface{} `yaml:"enum,omitempty"`
+	// Pattern is a regular expression string values must match.
+	Pattern string `yaml:"pattern,omitempty"`
+	// Properties describes the keys of an object.
+	Properties map[string]*PropertySchema `yaml:"properties,omitempty"`
+	// Required is the list of keys an object must contain.
+	Required []string `yaml:"required,omitempty"`
+	// AdditionalProperties specifies whether an object can contain
+	// keys not listed in Properties. Defaults to true.
+	AdditionalProperties *bool `yaml:"additionalProperties,omitempty"`
+	// Items describes the elements of an array.
+	Items *PropertySchema `yaml:"items,omitempty"`
+}
+
+// validateProperties checks the properties of all modules against
+// the schema. All violations of a module are reported together.
+func validateProperties(schema *PropertySchema, modules Modules) error {
+	if schema == nil {
+		return nil
+	}
+
+	for _, m := range modules {
+		var props interface{} = map[string]interface{}{}
+		if m.Properties() != nil {
+			props = m.Properties()
+		}
+
+		var violations []string
+		err := schema.validate("properties", props, &violations)
+		if err != nil {
+			return err
+		}
+
+		if len(violations) > 0 {
+			return configError(e.NewErrorf(ErrClassUser, msgInvalidProperties, m.Name(), strings.Join(violations, "\n")))
+		}
+	}
+
+	return nil
+}
+
+func (s *PropertySchema) validate(path string, v interface{}, violations *[]string) error {
+	if s == nil {
+		return nil
+	}
+
+	report := func(format string, args ...interface{}) {
+		*violations = append(*violations, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+
+	if s.Type != "" {
+		ok, err := s.hasType(path, v)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			report("expected %s but found %s", s.Type, schemaTypeOf(v))
+			return nil
+		}
+	}
+
+	if len(s.Enum) > 0 && !s.allows(v) {
+		report("%v is not one of %v", v, s.Enum)
+	}
+
+	if s.Pattern != "" {
+		if str, ok := v.(string); ok {
+			re, err := regexp.Compile(s.Pattern)
+			if err != nil {
+				return e.NewErrorf(ErrClassUser, msgInvalidPropertySchema, path, err)
+			}
+
+			if !re.MatchString(str) {
+				report("%q does not match %s", str, s.Pattern)
+			}
+		}
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, k := range s.Required {
+			if _, ok := t[k]; !ok {
+				report("%s is required", k)
+			}
+		}
+
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			child, ok := s.Properties[k]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					report("%s is not allowed", k)
+				}
+				continue
+			}
+
+			if err := child.validate(path+"."+k, t[k], violations); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, item := range t {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, violations); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *PropertySchema) hasType(path string, v interface{}) (bool, error) {
+	switch s.Type {
+	case "string", "integer", "boolean", "array", "object":
+		return schemaTypeOf(v) == s.Type, nil
+	case "number":
+		t := schemaTypeOf(v)
+		return t == "integer" || t == "number", nil
+	default:
+		return false, e.NewErrorf(ErrClassUser, msgInvalidPropertySchema, path, fmt.Sprintf("unsupported type %q", s.Type))
+	}
+}
+
+func (s *PropertySchema) allows(v interface{}) bool {
+	for _, a := range s.Enum {
+		if reflect.DeepEqual(a, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func schemaTypeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return "integer"
+	case float32, float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return reflect.TypeOf(v).String()
+	}
+}
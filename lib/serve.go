@@ -0,0 +1,542 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// DefaultServeAddr is the address mbt serve listens on unless it is
+	// specified. Server is not exposed beyond the host by default.
+	DefaultServeAddr = "127.0.0.1:7077"
+	// serveCacheSize is the number of manifests kept in memory.
+	serveCacheSize = 64
+	// serveWorkspaceRev is the revision denoting the workspace.
+	serveWorkspaceRev = "local"
+)
+
+// ServeOptions defines the options of the query server.
+type ServeOptions struct {
+	// Addr is the address to listen on. Defaults to DefaultServeAddr.
+	Addr string
+	// Listener accepts the connections instead of listening on Addr
+	// if it is specified.
+	Listener net.Listener
+	// Stop terminates the server when closed.
+	Stop <-chan struct{}
+	// Ready is invoked with the address of the server once it accepts
+	// connections.
+	Ready func(addr string)
+	// UI serves the web page rendering the module graph at the root
+	// of the server (see mbt ui).
+	UI bool
+}
+
+// queryServer answers the queries about the repository over http (and
+// gRPC).
+// Manifests are cached by commit, which is immutable, and revisions are
+// resolved on each request so that moving a branch is reflected
+// immediately. Manifest of the workspace is discarded when a file in
+// the workspace changes and is created again from the graph of the
+// workspace updated with the changes.
+// Repository is accessed by one request at a time.
+type queryServer struct {
+	*stdSystem
+	root    string
+	queries map[string]serveQuery
+	// mu guards the cache and the watched directories.
+	mu    sync.Mutex
+	cache map[string]*cachedManifest
+	// order is the keys of the cache from the least recently added.
+	order []string
+	// workspace is the graph of the modules in the workspace and
+	// changed is the paths changed since it was last updated.
+	workspace *workspaceGraph
+	changed   map[string]bool
+}
+
+// serveQuery answers a query with the specified parameters.
+type serveQuery func(params url.Values) (interface{}, error)
+
+type serveError struct {
+	Error string `json:"error"`
+}
+
+func (s *stdSystem) Serve(options *ServeOptions) error {
+	l := options.Listener
+	if l == nil {
+		addr := options.Addr
+		if addr == "" {
+			addr = DefaultServeAddr
+		}
+
+		var err error
+		l, err = net.Listen("tcp", addr)
+		if err != nil {
+			return e.Wrapf(ErrClassUser, err, msgFailedListen, addr)
+		}
+	}
+
+	q, closeServer, err := s.newQueryServer()
+	if err != nil {
+		return err
+	}
+	defer closeServer()
+
+	handler := q.handler()
+	if options.UI {
+		mux := http.NewServeMux()
+		mux.Handle("/v1/", handler)
+		mux.Handle("/healthz", handler)
+		mux.HandleFunc("/", handleUI)
+		handler = mux
+	}
+
+	// gRPC calls are answered on the same address over HTTP/2 without
+	// TLS, like the gRPC servers listening on localhost.
+	rest := handler
+	handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isGRPC(r) {
+			q.handleGRPC(w, r)
+			return
+		}
+		rest.ServeHTTP(w, r)
+	})
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	server := &http.Server{Handler: handler, Protocols: protocols}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-options.Stop:
+			server.Close()
+		case <-done:
+		}
+	}()
+
+	s.Log.Infof(msgServing, l.Addr())
+	if options.Ready != nil {
+		options.Ready(l.Addr().String())
+	}
+
+	err = server.Serve(l)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return e.Wrap(ErrClassInternal, err)
+}
+
+// newQueryServer creates the query server and starts watching the
+// workspace. Returned function stops watching.
+func (s *stdSystem) newQueryServer() (*queryServer, func(), error) {
+	root, err := filepath.Abs(s.Repo.Path())
+	if err != nil {
+		return nil, nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	if err := s.watchTree(watcher, root, root); err != nil {
+		watcher.Close()
+		return nil, nil, err
+	}
+
+	q := &queryServer{
+		stdSystem: s,
+		root:      root,
+		cache:     make(map[string]*cachedManifest),
+		workspace: s.newWorkspaceGraph(root),
+		changed:   make(map[string]bool),
+	}
+	q.queries = map[string]serveQuery{
+		"modules":    q.modules,
+		"versions":   q.versions,
+		"dependents": q.dependents,
+		"affected":   q.affected,
+		"impacted":   q.impacted,
+		"graph":      q.graph,
+		"spec":       q.spec,
+		"files":      q.files,
+	}
+
+	done := make(chan struct{})
+	go q.invalidateWorkspace(watcher, done)
+
+	return q, func() {
+		close(done)
+		watcher.Close()
+	}, nil
+}
+
+// query answers a query. Queries are answered concurrently sharing
+// the cached manifests.
+func (q *queryServer) query(name string, params url.Values) (interface{}, error) {
+	fn, ok := q.queries[name]
+	if !ok {
+		return nil, e.NewErrorf(ErrClassUser, msgUnknownQuery, name)
+	}
+
+	return fn(params)
+}
+
+func (q *queryServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	for name := range q.queries {
+		mux.HandleFunc("/v1/"+name, q.handle(name))
+	}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+// handle answers a query with the parameters in the query string and
+// writes the result as json (or as plain text if it is a string). User
+// errors are bad requests.
+func (q *queryServer) handle(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		result, err := q.query(name, r.URL.Query())
+		if err != nil {
+			status := http.StatusInternalServerError
+			if isUserError(err) {
+				status = http.StatusBadRequest
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(&serveError{Error: err.Error()})
+			return
+		}
+
+		if text, ok := result.(string); ok {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte(text))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+func isUserError(err error) bool {
+	ee, ok := err.(*e.E)
+	return ok && ee.Class() == ErrClassUser
+}
+
+// modules describes the modules in rev (default current branch).
+func (q *queryServer) modules(params url.Values) (interface{}, error) {
+	m, err := q.manifest(params.Get("rev"))
+	if err != nil {
+		return nil, err
+	}
+	return m.Modules.Describe(), nil
+}
+
+// versions returns the version of each module in rev.
+func (q *queryServer) versions(params url.Values) (interface{}, error) {
+	m, err := q.manifest(params.Get("rev"))
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[string]string, len(m.Modules))
+	for _, a := range m.Modules {
+		versions[a.Name()] = a.Version()
+	}
+	return versions, nil
+}
+
+// dependents describes the modules depending on module in rev directly
+// or indirectly.
+func (q *queryServer) dependents(params url.Values) (interface{}, error) {
+	name := params.Get("module")
+	if name == "" {
+		return nil, e.NewErrorf(ErrClassUser, msgMissingQueryParameter, "module")
+	}
+
+	m, err := q.manifest(params.Get("rev"))
+	if err != nil {
+		return nil, err
+	}
+
+	mod, ok := m.Module(name)
+	if !ok {
+		return nil, e.NewErrorf(ErrClassUser, msgModuleNotFound, name)
+	}
+
+	mods, err := Modules{mod}.expandRequiredByDependencies()
+	if err != nil {
+		return nil, err
+	}
+
+	dependents := make(Modules, 0, len(mods))
+	for _, a := range mods {
+		if a != mod {
+			dependents = append(dependents, a)
+		}
+	}
+	return dependents.Describe(), nil
+}
+
+// affected describes the modules affected by the changes between the
+// merge base of base and head (default current branch) for a purpose
+// (default builds).
+func (q *queryServer) affected(params url.Values) (interface{}, error) {
+	base := params.Get("base")
+	if base == "" {
+		return nil, e.NewErrorf(ErrClassUser, msgMissingQueryParameter, "base")
+	}
+
+	purpose := params.Get("purpose")
+	if purpose == "" {
+		purpose = AffectedBuilds
+	}
+
+	baseCommit, err := q.Repo.ResolveCommit(base)
+	if err != nil {
+		return nil, err
+	}
+
+	headCommit, err := q.resolve(params.Get("head"))
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := q.cached("affected:"+baseCommit.ID()+":"+headCommit.ID()+":"+purpose, func() (*Manifest, error) {
+		return q.stdSystem.affected(baseCommit, headCommit, purpose)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m.Modules.Describe(), nil
+}
+
+// impacted describes the modules in the workspace impacted by changes
+// to the paths (relative to the root of the repository), including the
+// modules depending on them. Paths do not have to be saved (e.g. the
+// unsaved files in an editor).
+func (q *queryServer) impacted(params url.Values) (interface{}, error) {
+	paths := params["path"]
+	if len(paths) == 0 {
+		return nil, e.NewErrorf(ErrClassUser, msgMissingQueryParameter, "path")
+	}
+
+	m, err := q.manifest(serveWorkspaceRev)
+	if err != nil {
+		return nil, err
+	}
+
+	deltas := make([]*DiffDelta, 0, len(paths))
+	for _, p := range paths {
+		p = filepath.ToSlash(filepath.Clean(p))
+		deltas = append(deltas, &DiffDelta{NewFile: p, OldFile: p})
+	}
+
+	mods, err := q.Reducer.Reduce(m.Modules, deltas)
+	if err != nil {
+		return nil, err
+	}
+
+	mods, err = mods.expandRequiredByDependencies()
+	if err != nil {
+		return nil, err
+	}
+	return mods.Describe(), nil
+}
+
+// graph serializes the dependency graph of the modules in rev in dot
+// (default) or mermaid format.
+func (q *queryServer) graph(params url.Values) (interface{}, error) {
+	m, err := q.manifest(params.Get("rev"))
+	if err != nil {
+		return nil, err
+	}
+
+	format := params.Get("format")
+	if format == "" {
+		format = GraphFormatDot
+	}
+	return m.Modules.SerializeGraph(&GraphOptions{Format: format, Cluster: params.Get("cluster")})
+}
+
+// files lists the files of module in rev with their blob hashes.
+func (q *queryServer) files(params url.Values) (interface{}, error) {
+	name := params.Get("module")
+	if name == "" {
+		return nil, e.NewErrorf(ErrClassUser, msgMissingQueryParameter, "module")
+	}
+
+	m, err := q.manifest(params.Get("rev"))
+	if err != nil {
+		return nil, err
+	}
+
+	mod, ok := m.Module(name)
+	if !ok {
+		return nil, e.NewErrorf(ErrClassUser, msgModuleNotFound, name)
+	}
+
+	return mod.Files()
+}
+
+// manifest returns the manifest of a revision or the workspace.
+func (q *queryServer) manifest(rev string) (*Manifest, error) {
+	if rev == serveWorkspaceRev {
+		return q.cached(serveWorkspaceRev, q.workspaceManifest)
+	}
+
+	commit, err := q.resolve(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	return q.cached("commit:"+commit.ID(), func() (*Manifest, error) {
+		return q.MB.ByCommit(commit)
+	})
+}
+
+// workspaceManifest returns the manifest of the workspace after
+// updating its graph with the changed paths.
+func (q *queryServer) workspaceManifest() (*Manifest, error) {
+	q.mu.Lock()
+	changed := make([]string, 0, len(q.changed))
+	for p := range q.changed {
+		changed = append(changed, p)
+	}
+	q.changed = make(map[string]bool)
+	q.mu.Unlock()
+
+	mods, err := q.workspace.modules(changed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manifest{Dir: q.root, Sha: serveWorkspaceRev, Modules: mods}, nil
+}
+
+// resolve resolves a revision defaulting to the current branch.
+func (q *queryServer) resolve(rev string) (Commit, error) {
+	if rev == "" {
+		return q.Repo.CurrentBranchCommit()
+	}
+	return q.Repo.ResolveCommit(rev)
+}
+
+// cachedManifest is a manifest in the cache. It is created once for all
+// the concurrent queries requiring it.
+type cachedManifest struct {
+	once     sync.Once
+	manifest *Manifest
+	err      error
+}
+
+// cached returns the manifest cached with key or creates it with fn.
+// Least recently added manifest is evicted when the cache is full.
+// Failures are not cached.
+func (q *queryServer) cached(key string, fn func() (*Manifest, error)) (*Manifest, error) {
+	q.mu.Lock()
+	c, ok := q.cache[key]
+	if !ok {
+		if len(q.order) >= serveCacheSize {
+			delete(q.cache, q.order[0])
+			q.order = q.order[1:]
+		}
+		c = &cachedManifest{}
+		q.cache[key] = c
+		q.order = append(q.order, key)
+	}
+	q.mu.Unlock()
+
+	c.once.Do(func() {
+		c.manifest, c.err = fn()
+	})
+
+	if c.err != nil {
+		q.mu.Lock()
+		if q.cache[key] == c {
+			q.evict(key)
+		}
+		q.mu.Unlock()
+		return nil, c.err
+	}
+	return c.manifest, nil
+}
+
+// evict removes the manifest cached with key. Caller must hold q.mu.
+func (q *queryServer) evict(key string) {
+	if _, ok := q.cache[key]; !ok {
+		return
+	}
+
+	delete(q.cache, key)
+	for i, k := range q.order {
+		if k == key {
+			q.order = append(q.order[:i], q.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// invalidateWorkspace discards the manifest of the workspace when a
+// file in the workspace changes and records the path of the file.
+func (q *queryServer) invalidateWorkspace(watcher *fsnotify.Watcher, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			q.Log.Warn(err)
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			q.mu.Lock()
+			if rel, ok := q.watchedPath(q.root, event.Name); ok {
+				if event.Op&fsnotify.Create == fsnotify.Create {
+					if err := q.watchTree(watcher, q.root, event.Name); err != nil {
+						q.Log.Warn(err)
+					}
+				}
+				q.changed[rel] = true
+				q.evict(serveWorkspaceRev)
+			}
+			q.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// grpcService is the prefix of the paths of the methods of the query
+// service defined in api/proto/mbt/v1/query.proto.
+const grpcService = "/mbt.v1.Query/"
+
+// Status codes of gRPC.
+const (
+	grpcOK              = 0
+	grpcInvalidArgument = 3
+	grpcUnimplemented   = 12
+	grpcInternal        = 13
+)
+
+// grpcMaxMessageSize is the maximum size of a request message, which
+// only contains the parameters of a query.
+const grpcMaxMessageSize = 1 << 20
+
+// grpcRequestParams are the parameters of a query keyed by the field
+// numbers of QueryRequest.
+var grpcRequestParams = map[uint64]string{
+	1: "rev",
+	2: "module",
+	3: "base",
+	4: "head",
+	5: "purpose",
+	6: "format",
+	7: "cluster",
+	8: "path",
+}
+
+// isGRPC informs if a request is a call of a gRPC method.
+func isGRPC(r *http.Request) bool {
+	return r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// handleGRPC answers a call of a method of the query service with the
+// query of the same name. Messages are encoded with protobuf. Status of
+// the call is reported in the trailers as required by gRPC, errors of
+// the queries are reported as invalid arguments if they are user errors.
+func (q *queryServer) handleGRPC(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	code, message := grpcOK, ""
+	response, err := q.callGRPC(r)
+	if err != nil {
+		code, message = grpcInternal, err.Error()
+		if ge, ok := err.(*grpcError); ok {
+			code = ge.code
+		} else if isUserError(err) {
+			code = grpcInvalidArgument
+		}
+	} else if err := writeGRPCMessage(w, response); err != nil {
+		code, message = grpcInternal, err.Error()
+	}
+
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", grpcEncodeMessage(message))
+	}
+}
+
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return e.message
+}
+
+func (q *queryServer) callGRPC(r *http.Request) ([]byte, error) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, grpcService) {
+		return nil, &grpcError{code: grpcUnimplemented, message: fmt.Sprintf(msgUnknownQuery, r.URL.Path)}
+	}
+
+	name := strings.ToLower(strings.TrimPrefix(r.URL.Path, grpcService))
+	if _, ok := q.queries[name]; !ok {
+		return nil, &grpcError{code: grpcUnimplemented, message: fmt.Sprintf(msgUnknownQuery, name)}
+	}
+
+	request, err := readGRPCMessage(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := decodeQueryRequest(request)
+	if err != nil {
+		return nil, &grpcError{code: grpcInvalidArgument, message: err.Error()}
+	}
+
+	result, err := q.query(name, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if text, ok := result.(string); ok {
+		return appendProtoString(nil, 2, text), nil
+	}
+
+	buff, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return appendProtoString(nil, 1, string(buff)), nil
+}
+
+// readGRPCMessage reads the message of a unary call, which is prefixed
+// with a flag informing if it is compressed and its length.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	prefix := make([]byte, 5)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, &grpcError{code: grpcInvalidArgument, message: "invalid message"}
+	}
+	if prefix[0] != 0 {
+		return nil, &grpcError{code: grpcUnimplemented, message: "compressed messages are not supported"}
+	}
+
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > grpcMaxMessageSize {
+		return nil, &grpcError{code: grpcInvalidArgument, message: "message is too large"}
+	}
+
+	message := make([]byte, n)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, &grpcError{code: grpcInvalidArgument, message: "invalid message"}
+	}
+	return message, nil
+}
+
+func writeGRPCMessage(w io.Writer, message []byte) error {
+	prefix := make([]byte, 5)
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(message)))
+	if _, err := w.Write(prefix); err != nil {
+		return err
+	}
+	_, err := w.Write(message)
+	return err
+}
+
+// decodeQueryRequest decodes the parameters of a query from a
+// QueryRequest message. Unknown fields are skipped.
+func decodeQueryRequest(b []byte) (url.Values, error) {
+	params := make(url.Values)
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errors.New("invalid field tag")
+		}
+		b = b[n:]
+
+		num, wire := tag>>3, tag&7
+		var size uint64
+		switch wire {
+		case 0:
+			_, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errors.New("invalid varint")
+			}
+			b = b[n:]
+			continue
+		case 1:
+			size = 8
+		case 2:
+			size, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errors.New("invalid length")
+			}
+			b = b[n:]
+		case 5:
+			size = 4
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d", wire)
+		}
+
+		if size > uint64(len(b)) {
+			return nil, errors.New("truncated message")
+		}
+		if name, ok := grpcRequestParams[num]; ok && wire == 2 && size > 0 {
+			params.Add(name, string(b[:size]))
+		}
+		b = b[size:]
+	}
+	return params, nil
+}
+
+// appendProtoString appends a string field to a protobuf message.
+func appendProtoString(b []byte, num uint64, s string) []byte {
+	b = binary.AppendUvarint(b, num<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// grpcEncodeMessage percent encodes the characters of a status message
+// that cannot be in a header.
+func grpcEncodeMessage(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
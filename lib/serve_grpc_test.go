@@ -0,0 +1,124 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// callGRPC calls a method of the query service over HTTP/2 without TLS
+// with the parameters encoded in a QueryRequest, and returns the status
+// and the fields of the QueryResponse.
+func callGRPC(t *testing.T, u, method string, params url.Values) (string, url.Values) {
+	request := make([]byte, 0)
+	for num, name := range grpcRequestParams {
+		for _, v := range params[name] {
+			request = appendProtoString(request, num, v)
+		}
+	}
+	body := new(bytes.Buffer)
+	check(t, writeGRPCMessage(body, request))
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+
+	req, err := http.NewRequest(http.MethodPost, u+grpcService+method, body)
+	check(t, err)
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	res, err := client.Do(req)
+	check(t, err)
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/grpc", res.Header.Get("Content-Type"))
+	content, err := ioutil.ReadAll(res.Body)
+	check(t, err)
+
+	fields := make(url.Values)
+	if len(content) > 0 {
+		message, err := readGRPCMessage(bytes.NewReader(content))
+		check(t, err)
+		// Fields of QueryResponse are decoded with the same field numbers
+		// as the parameters of the first fields of QueryRequest.
+		decoded, err := decodeQueryRequest(message)
+		check(t, err)
+		fields.Set("json", decoded.Get("rev"))
+		fields.Set("text", decoded.Get("module"))
+	}
+
+	if msg := res.Trailer.Get("Grpc-Message"); msg != "" {
+		fields.Set("error", msg)
+	}
+	return res.Trailer.Get("Grpc-Status"), fields
+}
+
+func TestServeGRPC(t *testing.T) {
+	initServeRepo(t)
+
+	u, stop := startQueryServer(t)
+	defer stop()
+
+	status, res := callGRPC(t, u, "Dependents", url.Values{"module": {"svc-a"}})
+	assert.Equal(t, "0", status)
+	d := &Description{}
+	check(t, json.Unmarshal([]byte(res.Get("json")), d))
+	assert.Equal(t, []string{"svc-b"}, describedNames(d))
+
+	status, res = callGRPC(t, u, "Graph", url.Values{"format": {"mermaid"}})
+	assert.Equal(t, "0", status)
+	assert.Contains(t, res.Get("text"), "flowchart LR")
+
+	// REST api is answered on the same address.
+	versions := make(map[string]string)
+	assert.Equal(t, http.StatusOK, getQuery(t, u+"/v1/versions", &versions))
+	assert.Len(t, versions, 4)
+}
+
+func TestServeInvalidGRPCCalls(t *testing.T) {
+	initServeRepo(t)
+
+	u, stop := startQueryServer(t)
+	defer stop()
+
+	status, res := callGRPC(t, u, "Dependents", url.Values{})
+	assert.Equal(t, "3", status)
+	assert.Equal(t, "Query parameter module is required", res.Get("error"))
+
+	status, _ = callGRPC(t, u, "Build", url.Values{})
+	assert.Equal(t, "12", status)
+}
+
+func TestDecodeQueryRequestSkipsUnknownFields(t *testing.T) {
+	message := appendProtoString(nil, 2, "svc-a")
+	message = append(message, 9<<3, 42)
+	message = appendProtoString(message, 8, "a.go")
+	message = appendProtoString(message, 8, "b.go")
+
+	params, err := decodeQueryRequest(message)
+	check(t, err)
+	assert.Equal(t, url.Values{"module": {"svc-a"}, "path": {"a.go", "b.go"}}, params)
+
+	_, err = decodeQueryRequest(message[:len(message)-1])
+	assert.Error(t, err)
+}
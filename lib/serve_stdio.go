@@ -0,0 +1,206 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// Error codes defined by JSON-RPC 2.0.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+type rpcRequest struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+	Error   *rpcError        `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (s *stdSystem) ServeStdio(in io.Reader, out io.Writer) error {
+	q, closeServer, err := s.newQueryServer()
+	if err != nil {
+		return err
+	}
+	defer closeServer()
+
+	r := bufio.NewReader(in)
+	for {
+		body, err := readRPCMessage(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		req := &rpcRequest{}
+		if err := json.Unmarshal(body, req); err != nil {
+			if err := writeRPCMessage(out, &rpcResponse{Error: &rpcError{Code: rpcParseError, Message: err.Error()}}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if req.Method == "exit" {
+			return nil
+		}
+
+		res := q.call(req)
+		// Notifications are not answered.
+		if req.ID == nil {
+			continue
+		}
+
+		if err := writeRPCMessage(out, res); err != nil {
+			return err
+		}
+	}
+}
+
+// call answers a request with the query of the same name. shutdown is
+// answered for compatibility with language server clients, which send
+// it before exit.
+func (q *queryServer) call(req *rpcRequest) *rpcResponse {
+	res := &rpcResponse{ID: req.ID}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		res.Error = &rpcError{Code: rpcInvalidRequest, Message: msgInvalidRPCRequest}
+		return res
+	}
+
+	if req.Method == "shutdown" {
+		return res
+	}
+
+	if _, ok := q.queries[req.Method]; !ok {
+		res.Error = &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf(msgUnknownQuery, req.Method)}
+		return res
+	}
+
+	params, err := rpcParams(req.Params)
+	if err != nil {
+		res.Error = &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		return res
+	}
+
+	result, err := q.query(req.Method, params)
+	if err != nil {
+		code := rpcInternalError
+		if isUserError(err) {
+			code = rpcInvalidParams
+		}
+		res.Error = &rpcError{Code: code, Message: err.Error()}
+		return res
+	}
+
+	res.Result = result
+	return res
+}
+
+// rpcParams converts the params object of a request, values of which
+// are strings or lists of strings, to query parameters.
+func rpcParams(raw json.RawMessage) (url.Values, error) {
+	params := make(url.Values)
+	if len(raw) == 0 {
+		return params, nil
+	}
+
+	object := make(map[string]interface{})
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return nil, e.NewErrorf(ErrClassUser, msgInvalidRPCParams)
+	}
+
+	for k, v := range object {
+		switch v := v.(type) {
+		case string:
+			params.Set(k, v)
+		case []interface{}:
+			for _, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return nil, e.NewErrorf(ErrClassUser, msgInvalidRPCParams)
+				}
+				params.Add(k, s)
+			}
+		default:
+			return nil, e.NewErrorf(ErrClassUser, msgInvalidRPCParams)
+		}
+	}
+
+	return params, nil
+}
+
+// readRPCMessage reads the body of a message framed like in the base
+// protocol of language servers, with a Content-Length header.
+func readRPCMessage(r *bufio.Reader) ([]byte, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, e.Wrapf(ErrClassUser, err, msgInvalidRPCMessage)
+	}
+
+	length, err := strconv.Atoi(strings.TrimSpace(header.Get("Content-Length")))
+	if err != nil || length < 0 {
+		return nil, e.NewErrorf(ErrClassUser, msgInvalidRPCMessage)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgInvalidRPCMessage)
+	}
+	return body, nil
+}
+
+func writeRPCMessage(w io.Writer, res *rpcResponse) error {
+	res.JSONRPC = "2.0"
+	body, err := json.Marshal(res)
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+	return nil
+}
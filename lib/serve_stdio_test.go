@@ -0,0 +1,117 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func rpcMessages(requests ...string) io.Reader {
+	buff := new(bytes.Buffer)
+	for _, r := range requests {
+		fmt.Fprintf(buff, "Content-Length: %d\r\n\r\n%s", len(r), r)
+	}
+	return buff
+}
+
+func readRPCResponses(t *testing.T, out *bytes.Buffer) []*rpcResponse {
+	r := bufio.NewReader(out)
+	responses := make([]*rpcResponse, 0)
+	for {
+		body, err := readRPCMessage(r)
+		if err == io.EOF {
+			return responses
+		}
+		check(t, err)
+
+		res := &rpcResponse{}
+		check(t, json.Unmarshal(body, res))
+		responses = append(responses, res)
+	}
+}
+
+func TestServeStdio(t *testing.T) {
+	initServeRepo(t)
+
+	out := new(bytes.Buffer)
+	err := NewWorld(t, ".tmp/repo").System.ServeStdio(rpcMessages(
+		`{"jsonrpc": "2.0", "id": 1, "method": "impacted", "params": {"path": ["lib-a/a.go"]}}`,
+		`{"jsonrpc": "2.0", "method": "versions"}`,
+		`{"jsonrpc": "2.0", "id": "two", "method": "dependents", "params": {"module": "svc-a"}}`,
+		`{"jsonrpc": "2.0", "id": 3, "method": "versions"}`,
+	), out)
+	check(t, err)
+
+	responses := readRPCResponses(t, out)
+	assert.Len(t, responses, 3)
+
+	assert.Equal(t, "1", string(*responses[0].ID))
+	assert.Nil(t, responses[0].Error)
+	names := make([]string, 0)
+	for _, m := range responses[0].Result.(map[string]interface{})["modules"].([]interface{}) {
+		names = append(names, m.(map[string]interface{})["name"].(string))
+	}
+	assert.ElementsMatch(t, []string{"lib-a", "svc-a", "svc-b"}, names)
+
+	assert.Equal(t, `"two"`, string(*responses[1].ID))
+	assert.Len(t, responses[1].Result.(map[string]interface{})["modules"], 1)
+
+	assert.Len(t, responses[2].Result, 4)
+}
+
+func TestServeStdioErrors(t *testing.T) {
+	initServeRepo(t)
+
+	out := new(bytes.Buffer)
+	err := NewWorld(t, ".tmp/repo").System.ServeStdio(rpcMessages(
+		`{"jsonrpc": "2.0", "id": 1, "method": "foo"}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "dependents"}`,
+		`{"jsonrpc": "2.0", "id": 3, "method": "impacted", "params": {"path": 1}}`,
+		`{"jsonrpc": "2.0", "id": 4`,
+		`{"id": 5, "method": "versions"}`,
+		`{"jsonrpc": "2.0", "id": 6, "method": "shutdown"}`,
+		`{"jsonrpc": "2.0", "method": "exit"}`,
+		`{"jsonrpc": "2.0", "id": 7, "method": "versions"}`,
+	), out)
+	check(t, err)
+
+	responses := readRPCResponses(t, out)
+	assert.Len(t, responses, 6)
+
+	assert.Equal(t, &rpcError{Code: rpcMethodNotFound, Message: "Unknown query foo"}, responses[0].Error)
+	assert.Equal(t, &rpcError{Code: rpcInvalidParams, Message: "Query parameter module is required"}, responses[1].Error)
+	assert.Equal(t, rpcInvalidParams, responses[2].Error.Code)
+	assert.Equal(t, rpcParseError, responses[3].Error.Code)
+	assert.Nil(t, responses[3].ID)
+	assert.Equal(t, rpcInvalidRequest, responses[4].Error.Code)
+	assert.Nil(t, responses[5].Error)
+	assert.Nil(t, responses[5].Result)
+}
+
+func TestServeStdioInvalidFraming(t *testing.T) {
+	initServeRepo(t)
+
+	err := NewWorld(t, ".tmp/repo").System.ServeStdio(bytes.NewBufferString("Content-Type: json\r\n\r\n{}"), new(bytes.Buffer))
+
+	assert.EqualError(t, err, msgInvalidRPCMessage)
+}
@@ -0,0 +1,276 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startQueryServer starts serving the queries about the test repository
+// and returns the url of the server along with a function stopping it.
+func startQueryServer(t *testing.T) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	check(t, err)
+
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- NewWorld(t, ".tmp/repo").System.Serve(&ServeOptions{Listener: l, Stop: stop})
+	}()
+
+	return "http://" + l.Addr().String(), func() {
+		close(stop)
+		check(t, <-done)
+	}
+}
+
+func getQuery(t *testing.T, u string, v interface{}) int {
+	res, err := http.Get(u)
+	check(t, err)
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	check(t, err)
+	if s, ok := v.(*string); ok {
+		*s = string(b)
+	} else {
+		check(t, json.Unmarshal(b, v))
+	}
+	return res.StatusCode
+}
+
+func initServeRepo(t *testing.T) *TestRepository {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("lib-a"))
+	check(t, repo.InitModuleWithOptions("svc-a", &Spec{Name: "svc-a", Dependencies: []string{"lib-a"}}))
+	check(t, repo.InitModuleWithOptions("svc-b", &Spec{Name: "svc-b", Dependencies: []string{"svc-a"}}))
+	check(t, repo.InitModule("app-c"))
+	check(t, repo.Commit("first"))
+	return repo
+}
+
+func describedNames(d *Description) []string {
+	names := make([]string, 0, len(d.Modules))
+	for _, m := range d.Modules {
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+func TestServeModulesAndVersions(t *testing.T) {
+	repo := initServeRepo(t)
+	first := repo.LastCommit.String()
+
+	u, stop := startQueryServer(t)
+	defer stop()
+
+	d := &Description{}
+	assert.Equal(t, http.StatusOK, getQuery(t, u+"/v1/modules", d))
+	assert.ElementsMatch(t, []string{"lib-a", "svc-a", "svc-b", "app-c"}, describedNames(d))
+
+	versions := make(map[string]string)
+	getQuery(t, u+"/v1/versions", &versions)
+	assert.Len(t, versions, 4)
+
+	// Branch moved after the server started is reflected.
+	check(t, repo.WriteContent("lib-a/foo", "a"))
+	check(t, repo.Commit("second"))
+
+	moved := make(map[string]string)
+	getQuery(t, u+"/v1/versions?rev=master", &moved)
+	assert.NotEqual(t, versions["lib-a"], moved["lib-a"])
+	assert.Equal(t, versions["app-c"], moved["app-c"])
+
+	previous := make(map[string]string)
+	getQuery(t, u+"/v1/versions?rev="+first, &previous)
+	assert.Equal(t, versions, previous)
+}
+
+func TestServeDependentsAndAffected(t *testing.T) {
+	repo := initServeRepo(t)
+	first := repo.LastCommit.String()
+	check(t, repo.WriteContent("svc-a/foo", "a"))
+	check(t, repo.Commit("second"))
+
+	u, stop := startQueryServer(t)
+	defer stop()
+
+	d := &Description{}
+	getQuery(t, u+"/v1/dependents?module=lib-a", d)
+	assert.ElementsMatch(t, []string{"svc-a", "svc-b"}, describedNames(d))
+
+	d = &Description{}
+	getQuery(t, u+"/v1/affected?base="+first, d)
+	assert.ElementsMatch(t, []string{"svc-a", "svc-b"}, describedNames(d))
+
+	var graph string
+	getQuery(t, u+"/v1/graph?format=mermaid", &graph)
+	assert.True(t, strings.HasPrefix(graph, "flowchart LR"))
+}
+
+func TestServeInvalidQueries(t *testing.T) {
+	initServeRepo(t)
+
+	u, stop := startQueryServer(t)
+	defer stop()
+
+	r := &serveError{}
+	assert.Equal(t, http.StatusBadRequest, getQuery(t, u+"/v1/dependents", r))
+	assert.Equal(t, "Query parameter module is required", r.Error)
+
+	r = &serveError{}
+	assert.Equal(t, http.StatusBadRequest, getQuery(t, u+"/v1/dependents?module=app-x", r))
+	assert.Equal(t, "Module app-x is not found", r.Error)
+
+	r = &serveError{}
+	assert.Equal(t, http.StatusBadRequest, getQuery(t, u+"/v1/modules?rev=v1.0.0", r))
+	assert.Equal(t, "Revision v1.0.0 is not found", r.Error)
+}
+
+func TestServeWorkspace(t *testing.T) {
+	repo := initServeRepo(t)
+
+	u, stop := startQueryServer(t)
+	defer stop()
+
+	d := &Description{}
+	getQuery(t, u+"/v1/modules?rev=local", d)
+	assert.Len(t, d.Modules, 4)
+
+	check(t, repo.InitModule("app-d"))
+
+	// Manifest of the workspace is discarded once the change is noticed.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(d.Modules) != 5 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		d = &Description{}
+		getQuery(t, u+"/v1/modules?rev=local", d)
+	}
+	assert.Len(t, d.Modules, 5)
+}
+
+func TestServeImpacted(t *testing.T) {
+	initServeRepo(t)
+
+	u, stop := startQueryServer(t)
+	defer stop()
+
+	d := &Description{}
+	getQuery(t, u+"/v1/impacted?path=svc-a/main.go&path=app-c/README.md", d)
+	assert.ElementsMatch(t, []string{"svc-a", "svc-b", "app-c"}, describedNames(d))
+}
+
+func TestServeSpec(t *testing.T) {
+	repo := initServeRepo(t)
+	first := repo.LastCommit.String()
+	check(t, repo.InitModuleWithOptions("svc-a", &Spec{Name: "svc-a"}))
+	check(t, repo.Commit("second"))
+	check(t, repo.InitModuleWithOptions("svc-a", &Spec{Name: "svc-a", Dependencies: []string{"app-c"}}))
+
+	u, stop := startQueryServer(t)
+	defer stop()
+
+	var spec string
+	assert.Equal(t, http.StatusOK, getQuery(t, u+"/v1/spec?module=svc-a&rev="+first, &spec))
+	assert.Contains(t, spec, "lib-a")
+
+	getQuery(t, u+"/v1/spec?module=svc-a", &spec)
+	assert.NotContains(t, spec, "lib-a")
+
+	getQuery(t, u+"/v1/spec?module=svc-a&rev=local", &spec)
+	assert.Contains(t, spec, "app-c")
+
+	r := &serveError{}
+	assert.Equal(t, http.StatusBadRequest, getQuery(t, u+"/v1/spec?module=foo", r))
+}
+
+func TestServeFiles(t *testing.T) {
+	repo := initServeRepo(t)
+	check(t, repo.WriteContent("svc-a/main.go", "package main"))
+
+	u, stop := startQueryServer(t)
+	defer stop()
+
+	var files []*ModuleFile
+	assert.Equal(t, http.StatusOK, getQuery(t, u+"/v1/files?module=svc-a", &files))
+	assert.Equal(t, []string{"svc-a/.mbt.yml"}, moduleFilePaths(files))
+
+	getQuery(t, u+"/v1/files?module=svc-a&rev=local", &files)
+	assert.Equal(t, []string{"svc-a/.mbt.yml", "svc-a/main.go"}, moduleFilePaths(files))
+
+	r := &serveError{}
+	assert.Equal(t, http.StatusBadRequest, getQuery(t, u+"/v1/files", r))
+}
+
+func TestServeConcurrentQueries(t *testing.T) {
+	initServeRepo(t)
+	u, stop := startQueryServer(t)
+	defer stop()
+
+	var wg sync.WaitGroup
+	versions := make([]map[string]string, 8)
+	for i := range versions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			getQuery(t, u+"/v1/versions", &versions[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for _, v := range versions {
+		assert.Len(t, v, 4)
+		assert.Equal(t, versions[0], v)
+	}
+}
+
+func TestServeUI(t *testing.T) {
+	initServeRepo(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	check(t, err)
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- NewWorld(t, ".tmp/repo").System.Serve(&ServeOptions{Listener: l, Stop: stop, UI: true})
+	}()
+	defer func() {
+		close(stop)
+		check(t, <-done)
+	}()
+	u := "http://" + l.Addr().String()
+
+	var page string
+	assert.Equal(t, http.StatusOK, getQuery(t, u+"/", &page))
+	assert.Contains(t, page, "<canvas")
+
+	d := &Description{}
+	assert.Equal(t, http.StatusOK, getQuery(t, u+"/v1/modules", d))
+	assert.Len(t, d.Modules, 4)
+
+	assert.Equal(t, http.StatusNotFound, getQuery(t, u+"/foo", &page))
+}
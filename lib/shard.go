@@ -0,0 +1,112 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// Shard is a partition of the modules assigned to one of several
+// workers.
+type Shard struct {
+	// Index of the shard starting from 1.
+	Index int
+	// Count is the number of shards.
+	Count int
+}
+
+// ParseShard parses a shard in the form of <index>/<count> (e.g. 3/8).
+func ParseShard(s string) (*Shard, error) {
+	p := strings.SplitN(s, "/", 2)
+	if len(p) == 2 {
+		index, ierr := strconv.Atoi(p[0])
+		count, cerr := strconv.Atoi(p[1])
+		if ierr == nil && cerr == nil && count > 0 && index > 0 && index <= count {
+			return &Shard{Index: index, Count: count}, nil
+		}
+	}
+
+	return nil, e.NewErrorf(ErrClassUser, msgInvalidShard, s)
+}
+
+// ApplyShard returns the modules in a shard. Modules are partitioned so
+// that the sum of the durations (in seconds, keyed by module name) in
+// each shard is balanced. Modules without a duration are given the
+// mean of the known durations, hence they are balanced by count when no
+// duration is known.
+// Partitions only depend on the names and durations of the modules,
+// therefore every worker computes the same partitions.
+// Modules in the shard are in the same order as the manifest.
+func (m *Manifest) ApplyShard(shard *Shard, durations map[string]float64) *Manifest {
+	weights := make(map[string]float64, len(m.Modules))
+	known, sum := 0, 0.0
+	for _, a := range m.Modules {
+		if d, ok := durations[a.Name()]; ok && d > 0 {
+			weights[a.Name()] = d
+			known++
+			sum += d
+		}
+	}
+
+	fallback := 1.0
+	if known > 0 {
+		fallback = sum / float64(known)
+	}
+
+	names := make([]string, 0, len(m.Modules))
+	for _, a := range m.Modules {
+		if _, ok := weights[a.Name()]; !ok {
+			weights[a.Name()] = fallback
+		}
+		names = append(names, a.Name())
+	}
+
+	// Longest modules are assigned first, each to the shard with the
+	// least total duration.
+	sort.Slice(names, func(i, j int) bool {
+		if weights[names[i]] != weights[names[j]] {
+			return weights[names[i]] > weights[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	totals := make([]float64, shard.Count)
+	selected := make(map[string]bool)
+	for _, n := range names {
+		target := 0
+		for i := range totals {
+			if totals[i] < totals[target] {
+				target = i
+			}
+		}
+		totals[target] += weights[n]
+		if target == shard.Index-1 {
+			selected[n] = true
+		}
+	}
+
+	mods := make(Modules, 0, len(selected))
+	for _, a := range m.Modules {
+		if selected[a.Name()] {
+			mods = append(mods, a)
+		}
+	}
+	return &Manifest{Dir: m.Dir, Sha: m.Sha, Modules: mods}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func shardManifest(names ...string) *Manifest {
+	mods := make(Modules, 0, len(names))
+	for _, n := range names {
+		mods = append(mods, newModule(newModuleMetadata(n, n, &Spec{Name: n}, nil), nil))
+	}
+	return &Manifest{Dir: "/repo", Sha: "sha", Modules: mods}
+}
+
+func TestParseShard(t *testing.T) {
+	s, err := ParseShard("3/8")
+	check(t, err)
+	assert.Equal(t, &Shard{Index: 3, Count: 8}, s)
+
+	for _, v := range []string{"", "3", "0/8", "9/8", "1/0", "a/b", "-1/2"} {
+		_, err := ParseShard(v)
+		assert.EqualError(t, err, fmt.Sprintf(msgInvalidShard, v), v)
+	}
+}
+
+func TestShardsPartitionModules(t *testing.T) {
+	m := shardManifest("a", "b", "c", "d", "e", "f", "g")
+
+	seen := make(map[string]int)
+	for i := 1; i <= 3; i++ {
+		shard := m.ApplyShard(&Shard{Index: i, Count: 3}, nil)
+		assert.Equal(t, "sha", shard.Sha)
+		assert.True(t, len(shard.Modules) == 2 || len(shard.Modules) == 3)
+		for _, a := range shard.Modules {
+			seen[a.Name()]++
+		}
+	}
+
+	assert.Len(t, seen, 7)
+	for n, c := range seen {
+		assert.Equal(t, 1, c, n)
+	}
+}
+
+func TestShardsAreBalancedByDuration(t *testing.T) {
+	m := shardManifest("a", "b", "c", "d")
+	durations := map[string]float64{"a": 100, "b": 40, "c": 30}
+
+	first := m.ApplyShard(&Shard{Index: 1, Count: 2}, durations)
+	second := m.ApplyShard(&Shard{Index: 2, Count: 2}, durations)
+
+	// d is given the mean duration of the others.
+	assert.Equal(t, []string{"a"}, moduleNames(first.Modules))
+	assert.Equal(t, []string{"b", "c", "d"}, moduleNames(second.Modules))
+}
+
+func TestShardsAreIndependentOfOrder(t *testing.T) {
+	durations := map[string]float64{"a": 10, "b": 20, "c": 30, "d": 40, "e": 50}
+
+	first := shardManifest("a", "b", "c", "d", "e").ApplyShard(&Shard{Index: 2, Count: 3}, durations)
+	second := shardManifest("e", "c", "a", "d", "b").ApplyShard(&Shard{Index: 2, Count: 3}, durations)
+
+	assert.ElementsMatch(t, moduleNames(first.Modules), moduleNames(second.Modules))
+}
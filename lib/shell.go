@@ -0,0 +1,111 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"path"
+	"runtime"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// shellCommand returns the command and arguments to execute a
+// command using the specified shell.
+// Arguments are passed to the script as positional parameters.
+func shellCommand(shell, command string, args []string) (string, []string, error) {
+	return shellCommandFor(runtime.GOOS, shell, command, args)
+}
+
+// shellCommandFor returns the command and arguments to execute a
+// command using the specified shell on the specified OS.
+// When no shell is specified, PowerShell scripts (.ps1) and batch files
+// (.cmd and .bat on Windows) are executed with their interpreter since
+// they cannot be executed directly.
+// Windows PowerShell is not available on other OSes, therefore
+// powershell is substituted with PowerShell Core (pwsh) there.
+func shellCommandFor(goos, shell, command string, args []string) (string, []string, error) {
+	powershell := "powershell"
+	if goos != "windows" {
+		powershell = "pwsh"
+	}
+
+	switch shell {
+	case "":
+		switch strings.ToLower(path.Ext(strings.Replace(command, `\`, "/", -1))) {
+		case ".ps1":
+			flags := []string{"-NoProfile", "-NonInteractive"}
+			if goos == "windows" {
+				flags = append(flags, "-ExecutionPolicy", "Bypass")
+			}
+			return powershell, append(append(flags, "-File", command), args...), nil
+		case ".cmd", ".bat":
+			if goos == "windows" {
+				return "cmd", append([]string{"/C", command}, args...), nil
+			}
+		}
+		return command, args, nil
+	case "sh", "bash":
+		return shell, append([]string{"-c", command, shell}, args...), nil
+	case "powershell", "pwsh":
+		if shell == "powershell" {
+			shell = powershell
+		}
+		return shell, append([]string{"-NoProfile", "-NonInteractive", "-Command", command}, args...), nil
+	case "cmd":
+		return shell, append([]string{"/C", command}, args...), nil
+	default:
+		return "", nil, e.NewErrorf(ErrClassUser, msgUnsupportedShell, shell)
+	}
+}
+
+// execSpecCmd executes a command declared in a spec in the context of
+// the specified module, expanding its templates (see runSpecCmd) and
+// applying its shell and working directory.
+func (s *stdSystem) execSpecCmd(manifest *Manifest, module *Module, options *CmdOptions, shell, dir, command string, args []string) error {
+	return runSpecCmd(s.ProcessManager, manifest, module, options, shell, dir, command, args)
+}
+
+// runSpecCmd executes a spec command with the specified process manager
+// (see execSpecCmd). Templates are expanded if the module enables them.
+func runSpecCmd(pm ProcessManager, manifest *Manifest, module *Module, options *CmdOptions, shell, dir, command string, args []string) error {
+	if module != nil && module.Templates() {
+		var err error
+		command, args, err = expandCommand(manifest, module, options, command, args)
+		if err != nil {
+			return err
+		}
+	}
+
+	return runCmd(pm, manifest, module, options, shell, dir, command, args)
+}
+
+// runCmd executes a command in the context of a module as it is,
+// applying its shell and working directory.
+func runCmd(pm ProcessManager, manifest *Manifest, module *Module, options *CmdOptions, shell, dir, command string, args []string) error {
+	command, args, err := shellCommand(shell, command, args)
+	if err != nil {
+		return err
+	}
+
+	if dir != "" {
+		o := *options
+		o.WorkingDir = dir
+		options = &o
+	}
+
+	return pm.Exec(manifest, module, options, command, args...)
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildInWorkingDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}, Dir: "src"}},
+	}))
+	check(t, repo.WriteShellScript("app-a/src/build.sh", "basename $(pwd)"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+	check(t, err)
+
+	assert.Equal(t, "src\n", buff.String())
+}
+
+func TestBuildWithShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name: "app-a",
+		Build: map[string]*Cmd{"default": {
+			Cmd:   `echo "$MBT_MODULE_NAME" "$1" "$2" | tr a-z A-Z`,
+			Args:  []string{"first arg", "second"},
+			Shell: "sh",
+		}},
+	}))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+	check(t, err)
+
+	assert.Equal(t, "APP-A FIRST ARG SECOND\n", buff.String())
+}
+
+func TestRunInWithShellAndWorkingDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:     "app-a",
+		Commands: map[string]*UserCmd{"where": {Cmd: "basename $(pwd)", Shell: "sh", Dir: "docs"}},
+	}))
+	check(t, repo.WriteContent("app-a/docs/readme.md", "readme"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.RunInCurrentBranch("where", NoFilter, stdTestCmdOptions(buff))
+	check(t, err)
+
+	assert.Equal(t, "docs\n", buff.String())
+}
+
+func TestBuildWithUnsupportedShell(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"default": {Cmd: "echo", Shell: "fish"}},
+	}))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+
+	assert.EqualError(t, err, "Failed to build module 'app-a'")
+	assert.EqualError(t, (err.(*e.E)).InnerError(), "Unsupported shell 'fish'")
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestShellCommand(t *testing.T) {
+	c, args, err := shellCommand("", "./build.sh", []string{"a"})
+	check(t, err)
+	assert.Equal(t, "./build.sh", c)
+	assert.Equal(t, []string{"a"}, args)
+
+	c, args, err = shellCommand("bash", "echo $1", []string{"a"})
+	check(t, err)
+	assert.Equal(t, "bash", c)
+	assert.Equal(t, []string{"-c", "echo $1", "bash", "a"}, args)
+
+	c, args, err = shellCommand("pwsh", "Write-Host hi", nil)
+	check(t, err)
+	assert.Equal(t, "pwsh", c)
+	assert.Equal(t, []string{"-NoProfile", "-NonInteractive", "-Command", "Write-Host hi"}, args)
+
+	c, args, err = shellCommand("cmd", "echo hi", nil)
+	check(t, err)
+	assert.Equal(t, "cmd", c)
+	assert.Equal(t, []string{"/C", "echo hi"}, args)
+}
+
+func TestShellCommandForScripts(t *testing.T) {
+	c, args, err := shellCommandFor("windows", "", `.\build.ps1`, []string{"a"})
+	check(t, err)
+	assert.Equal(t, "powershell", c)
+	assert.Equal(t, []string{"-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", `.\build.ps1`, "a"}, args)
+
+	c, args, err = shellCommandFor("linux", "", "./Build.PS1", nil)
+	check(t, err)
+	assert.Equal(t, "pwsh", c)
+	assert.Equal(t, []string{"-NoProfile", "-NonInteractive", "-File", "./Build.PS1"}, args)
+
+	c, args, err = shellCommandFor("windows", "", "build.cmd", []string{"a"})
+	check(t, err)
+	assert.Equal(t, "cmd", c)
+	assert.Equal(t, []string{"/C", "build.cmd", "a"}, args)
+
+	c, args, err = shellCommandFor("windows", "", `scripts\build.bat`, nil)
+	check(t, err)
+	assert.Equal(t, "cmd", c)
+	assert.Equal(t, []string{"/C", `scripts\build.bat`}, args)
+
+	c, args, err = shellCommandFor("linux", "", "build.cmd", nil)
+	check(t, err)
+	assert.Equal(t, "build.cmd", c)
+	assert.Empty(t, args)
+
+	c, args, err = shellCommandFor("windows", "", "build.exe", []string{"a"})
+	check(t, err)
+	assert.Equal(t, "build.exe", c)
+	assert.Equal(t, []string{"a"}, args)
+}
+
+func TestShellCommandForPowershell(t *testing.T) {
+	c, _, err := shellCommandFor("windows", "powershell", "Write-Host hi", nil)
+	check(t, err)
+	assert.Equal(t, "powershell", c)
+
+	c, args, err := shellCommandFor("darwin", "powershell", "Write-Host hi", nil)
+	check(t, err)
+	assert.Equal(t, "pwsh", c)
+	assert.Equal(t, []string{"-NoProfile", "-NonInteractive", "-Command", "Write-Host hi"}, args)
+
+	c, _, err = shellCommandFor("windows", "pwsh", "Write-Host hi", nil)
+	check(t, err)
+	assert.Equal(t, "pwsh", c)
+}
+
+func TestBuildPowershellScriptWithoutShell(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"windows": {Cmd: `.\build.ps1`, Args: []string{"first"}}},
+	}))
+	check(t, repo.WritePowershellScript("app-a/build.ps1", "write-host $args[0]"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+	check(t, err)
+
+	assert.Equal(t, "first\n", buff.String())
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// SparseCheckout describes the files materialized in the workspace of a
+// repository using cone mode sparse checkout (i.e. git sparse-checkout).
+// Files in the root directory, files in the listed directories (and
+// their subdirectories) and the files directly in the parents of the
+// listed directories are materialized.
+type SparseCheckout struct {
+	dirs    []string
+	parents map[string]bool
+}
+
+// parseSparseCheckout parses the cone mode patterns in a sparse-checkout
+// file.
+func parseSparseCheckout(buff []byte, file string) (*SparseCheckout, error) {
+	s := &SparseCheckout{parents: make(map[string]bool)}
+	dirs := make([]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(buff))
+	for scanner.Scan() {
+		l := strings.TrimSpace(scanner.Text())
+		switch {
+		case l == "" || strings.HasPrefix(l, "#") || l == "/*" || l == "!/*/":
+		case strings.HasPrefix(l, "!/") && strings.HasSuffix(l, "/*/"):
+			s.parents[strings.TrimSuffix(strings.TrimPrefix(l, "!/"), "/*/")] = true
+		case strings.HasPrefix(l, "/") && strings.HasSuffix(l, "/") && len(l) > 2 && !strings.ContainsAny(l, "*?[!"):
+			dirs = append(dirs, strings.Trim(l, "/"))
+		default:
+			return nil, e.NewErrorf(ErrClassUser, msgNonConeSparseCheckout, file)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, e.Wrapf(ErrClassInternal, err, msgFailedReadFile, file)
+	}
+
+	// Directories listed as parents are included only for the files
+	// directly in them.
+	for _, d := range dirs {
+		if !s.parents[d] {
+			s.dirs = append(s.dirs, d)
+		}
+	}
+
+	return s, nil
+}
+
+// Includes informs if the file at p (relative to the repository root)
+// is materialized in the workspace.
+func (s *SparseCheckout) Includes(p string) bool {
+	if s == nil {
+		return true
+	}
+
+	dir := path.Dir(p)
+	if dir == "." || s.parents[dir] {
+		return true
+	}
+
+	for _, d := range s.dirs {
+		if strings.HasPrefix(p, d+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isMaterialized informs if the module is materialized in the workspace.
+// Modules excluded from a sparse checkout cannot be built or run.
+func (s *stdSystem) isMaterialized(mod *Module) bool {
+	sparse, err := s.Repo.SparseCheckout()
+	if err != nil {
+		// Error is reported by the operations reading the workspace.
+		return true
+	}
+
+	return sparse.Includes(path.Join(mod.Path(), configFileName))
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSparseCheckout(t *testing.T) {
+	s, err := parseSparseCheckout([]byte("/*\n!/*/\n/a/\n!/a/*/\n/a/b/\n/c/\n"), "sparse-checkout")
+	check(t, err)
+
+	assert.True(t, s.Includes("readme.md"))
+	assert.True(t, s.Includes("a/.mbt.yml"))
+	assert.True(t, s.Includes("a/b/c/.mbt.yml"))
+	assert.True(t, s.Includes("c/d/e.txt"))
+	assert.False(t, s.Includes("a/d/.mbt.yml"))
+	assert.False(t, s.Includes("d/.mbt.yml"))
+	assert.False(t, s.Includes("cd/.mbt.yml"))
+
+	var none *SparseCheckout
+	assert.True(t, none.Includes("d/.mbt.yml"))
+}
+
+func TestParseNonConeSparseCheckout(t *testing.T) {
+	_, err := parseSparseCheckout([]byte("/*\n!/*/\n*.go\n"), "sparse-checkout")
+
+	assert.EqualError(t, err, fmt.Sprintf(msgNonConeSparseCheckout, "sparse-checkout"))
+}
+
+// makeSparse excludes the files outside dirs from the workspace of the
+// test repository like git sparse-checkout set --cone.
+func makeSparse(t *testing.T, repo *TestRepository, dirs ...string) {
+	config, err := repo.Repo.Config()
+	check(t, err)
+	defer config.Free()
+	check(t, config.SetBool("core.sparseCheckout", true))
+
+	patterns := "/*\n!/*/\n"
+	for _, d := range dirs {
+		patterns += "/" + d + "/\n"
+	}
+	check(t, os.MkdirAll(filepath.Join(repo.Repo.Path(), "info"), 0755))
+	check(t, ioutil.WriteFile(filepath.Join(repo.Repo.Path(), "info", "sparse-checkout"), []byte(patterns), 0644))
+
+	entries, err := ioutil.ReadDir(repo.Dir)
+	check(t, err)
+	for _, e := range entries {
+		included := e.Name() == ".git" || !e.IsDir()
+		for _, d := range dirs {
+			included = included || e.Name() == d
+		}
+
+		if !included {
+			check(t, os.RemoveAll(filepath.Join(repo.Dir, e.Name())))
+		}
+	}
+}
+
+func TestSparseCheckout(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo built app-a"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:         "app-b",
+		Dependencies: []string{"app-a"},
+		Build:        map[string]*Cmd{"default": {Cmd: "./build.sh"}},
+	}))
+	check(t, repo.WriteShellScript("app-b/build.sh", "echo built app-b"))
+	check(t, repo.Commit("first"))
+	first := repo.LastCommit.String()
+	check(t, repo.AppendContent("app-a/foo", "a"))
+	check(t, repo.Commit("second"))
+	makeSparse(t, repo, "app-b")
+
+	world := NewWorld(t, ".tmp/repo")
+	check(t, world.Repo.EnsureSafeWorkspace())
+
+	m, err := world.System.ManifestByWorkspaceChanges()
+	check(t, err)
+	assert.Empty(t, m.Modules)
+
+	m, err = world.System.ManifestByWorkspace()
+	check(t, err)
+	assert.ElementsMatch(t, []string{"app-a", "app-b"}, moduleNames(m.Modules))
+
+	check(t, repo.AppendContent("app-b/foo", "b"))
+	m, err = world.System.ManifestByWorkspaceChanges()
+	check(t, err)
+	assert.Equal(t, []string{"app-b"}, moduleNames(m.Modules))
+	check(t, os.Remove(".tmp/repo/app-b/foo"))
+
+	buff := new(bytes.Buffer)
+	s, err := world.System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(buff))
+	check(t, err)
+	assert.Equal(t, "built app-b\n", buff.String())
+	assert.Equal(t, []string{"app-a"}, moduleNames(s.Skipped))
+	check(t, world.Repo.EnsureSafeWorkspace())
+	_, err = os.Stat(".tmp/repo/app-a")
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = world.System.BuildCommit(first, NoFilter, stdTestCmdOptions(buff))
+	assert.EqualError(t, err, fmt.Sprintf(msgCheckoutInSparseCheckout, first))
+}
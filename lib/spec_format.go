@@ -0,0 +1,253 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	yaml "github.com/go-yaml/yaml"
+	"github.com/mbtproject/mbt/e"
+)
+
+// sortedSpecLists are the keys of the lists in a spec sorted in the
+// canonical form. The order of these lists is not significant.
+var sortedSpecLists = map[string]bool{
+	"dependencies":          true,
+	"fileDependencies":      true,
+	"workspaceDependencies": true,
+}
+
+// normalizedSpecPaths are the keys of the lists of paths in a spec
+// normalized in the canonical form (see normalizeSpecPath).
+var normalizedSpecPaths = map[string]bool{
+	"fileDependencies": true,
+	"reports":          true,
+	"outputs":          true,
+}
+
+// FormatResult is the outcome of formatting a spec file.
+type FormatResult struct {
+	// Path of the spec relative to the repository root.
+	Path string
+	// Changed is set if the spec was not in the canonical form.
+	Changed bool
+	// Skipped is set if the spec was not formatted because it contains
+	// comments, which cannot be preserved.
+	Skipped bool
+}
+
+// canonicalSpec returns the canonical form of the contents of a valid
+// spec. Keys of the spec and the structures in it are ordered as they are
+// declared in Spec, keys of the dictionaries are sorted, paths are
+// normalised and the lists of dependencies are sorted. Keys unknown to
+// mbt are retained after the known keys.
+func canonicalSpec(content []byte) ([]byte, error) {
+	before, err := newSpec(content)
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	out, err := yaml.Marshal(canonicalValue(doc, reflect.TypeOf(Spec{}), ""))
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	// Canonical form must not change the meaning of the spec.
+	after, err := newSpec(out)
+	if err != nil || !reflect.DeepEqual(withSortedLists(before), after) {
+		return nil, e.NewErrorf(ErrClassInternal, msgSpecCanonicalFormMismatch, err)
+	}
+
+	return out, nil
+}
+
+// withSortedLists sorts the lists of the spec sorted in the canonical
+// form.
+func withSortedLists(spec *Spec) *Spec {
+	for _, l := range [][]string{spec.Dependencies, spec.FileDependencies, spec.WorkspaceDependencies} {
+		sort.Strings(l)
+	}
+	return spec
+}
+
+// canonicalValue returns the canonical form of a value decoded into t.
+// key is the key of the value in its parent.
+func canonicalValue(v interface{}, t reflect.Type, key string) interface{} {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch c := v.(type) {
+	case yaml.MapSlice:
+		if t != nil && t.Kind() == reflect.Struct {
+			return canonicalStruct(c, t)
+		}
+
+		var elem reflect.Type
+		if t != nil && t.Kind() == reflect.Map {
+			elem = t.Elem()
+		}
+
+		r := make(yaml.MapSlice, 0, len(c))
+		for _, item := range c {
+			r = append(r, yaml.MapItem{Key: item.Key, Value: canonicalValue(item.Value, elem, "")})
+		}
+		sort.SliceStable(r, func(i, j int) bool {
+			return fmt.Sprint(r[i].Key) < fmt.Sprint(r[j].Key)
+		})
+		return r
+	case []interface{}:
+		var elem reflect.Type
+		if t != nil && t.Kind() == reflect.Slice {
+			elem = t.Elem()
+		}
+
+		r := make([]interface{}, 0, len(c))
+		for _, item := range c {
+			if s, ok := item.(string); ok && normalizedSpecPaths[key] {
+				item = normalizeSpecPath(s)
+			}
+			r = append(r, canonicalValue(item, elem, ""))
+		}
+
+		if sortedSpecLists[key] {
+			sort.SliceStable(r, func(i, j int) bool {
+				return fmt.Sprint(r[i]) < fmt.Sprint(r[j])
+			})
+		}
+		return r
+	}
+
+	return v
+}
+
+// canonicalStruct orders the keys of the structure decoded into t in
+// the order of the fields of t.
+func canonicalStruct(m yaml.MapSlice, t reflect.Type) yaml.MapSlice {
+	fields := make(map[string]reflect.Type)
+	order := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+		order = append(order, name)
+	}
+
+	values := make(map[string]interface{})
+	unknown := make(yaml.MapSlice, 0)
+	for _, item := range m {
+		k, ok := item.Key.(string)
+		if _, known := fields[k]; ok && known {
+			values[k] = item.Value
+		} else {
+			unknown = append(unknown, item)
+		}
+	}
+
+	r := make(yaml.MapSlice, 0, len(m))
+	for _, k := range order {
+		if v, ok := values[k]; ok {
+			r = append(r, yaml.MapItem{Key: k, Value: canonicalValue(v, fields[k], k)})
+		}
+	}
+	return append(r, unknown...)
+}
+
+// hasComments reports whether the contents of a yaml file may contain
+// comments. Quoted values containing # are reported as well.
+func hasComments(content []byte) bool {
+	for _, l := range strings.Split(string(content), "\n") {
+		l = strings.TrimSpace(l)
+		if strings.HasPrefix(l, "#") || strings.Contains(l, " #") || strings.Contains(l, "\t#") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *stdSystem) FormatSpecs(check bool) ([]*FormatResult, error) {
+	dir, err := filepath.Abs(s.Repo.Path())
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	files, err := s.Repo.FindAllFilesInWorkspace([]string{configFileName, "/**/" + configFileName})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*FormatResult, 0, len(files))
+	for _, f := range files {
+		if filepath.Base(f) != configFileName {
+			continue
+		}
+
+		p := filepath.Join(dir, filepath.FromSlash(f))
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassInternal, err, msgFailedReadFile, p)
+		}
+
+		result := &FormatResult{Path: filepath.ToSlash(f)}
+		results = append(results, result)
+		if hasComments(content) {
+			s.Log.Warnf(msgSpecWithComments, result.Path)
+			result.Skipped = true
+			continue
+		}
+
+		if _, err := newSpec(content); err != nil {
+			return nil, configError(yamlError(e.Wrapf(ErrClassUser, err, msgFailedSpecParseAt, result.Path), result.Path, err))
+		}
+
+		formatted, err := canonicalSpec(content)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Changed = !bytes.Equal(content, formatted)
+		if result.Changed && !check {
+			fi, err := os.Stat(p)
+			if err != nil {
+				return nil, e.Wrap(ErrClassInternal, err)
+			}
+
+			if err := ioutil.WriteFile(p, formatted, fi.Mode()); err != nil {
+				return nil, e.Wrap(ErrClassInternal, err)
+			}
+		}
+	}
+
+	return results, nil
+}
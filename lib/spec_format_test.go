@@ -0,0 +1,124 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const unformattedSpec = `dependencies: [lib-b, lib-a]
+build:
+    linux:
+        args: [--release]
+        cmd: ./build.sh
+    darwin: {cmd: ./build.sh}
+name: app-a
+custom: value
+fileDependencies: ["shared\\b", ./shared/a/]
+properties: {z: 1, a: {c: true, b: "x"}}
+`
+
+const formattedSpec = `name: app-a
+build:
+  darwin:
+    cmd: ./build.sh
+  linux:
+    cmd: ./build.sh
+    args:
+    - --release
+properties:
+  a:
+    b: x
+    c: true
+  z: 1
+dependencies:
+- lib-a
+- lib-b
+fileDependencies:
+- shared/a
+- shared/b
+custom: value
+`
+
+func TestCanonicalSpec(t *testing.T) {
+	out, err := canonicalSpec([]byte(unformattedSpec))
+	check(t, err)
+
+	assert.Equal(t, formattedSpec, string(out))
+}
+
+func TestCanonicalSpecIsStable(t *testing.T) {
+	out, err := canonicalSpec([]byte(formattedSpec))
+	check(t, err)
+
+	assert.Equal(t, formattedSpec, string(out))
+}
+
+func TestFormatSpecs(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteContent("app-a/.mbt.yml", unformattedSpec))
+	check(t, repo.WriteContent("app-b/.mbt.yml", formattedSpec))
+	check(t, repo.WriteContent("app-c/.mbt.yml", "# comment\nname: app-c\nbuild: {}\n"))
+	check(t, repo.Commit("first"))
+	w := NewWorld(t, ".tmp/repo")
+
+	results, err := w.System.FormatSpecs(true)
+	check(t, err)
+
+	assert.Equal(t, []*FormatResult{
+		{Path: "app-a/.mbt.yml", Changed: true},
+		{Path: "app-b/.mbt.yml"},
+		{Path: "app-c/.mbt.yml", Skipped: true},
+	}, results)
+	buff, err := ioutil.ReadFile(filepath.Join(".tmp/repo", "app-a", ".mbt.yml"))
+	check(t, err)
+	assert.Equal(t, unformattedSpec, string(buff))
+
+	results, err = w.System.FormatSpecs(false)
+	check(t, err)
+
+	assert.True(t, results[0].Changed)
+	buff, err = ioutil.ReadFile(filepath.Join(".tmp/repo", "app-a", ".mbt.yml"))
+	check(t, err)
+	assert.Equal(t, formattedSpec, string(buff))
+
+	results, err = w.System.FormatSpecs(true)
+	check(t, err)
+	assert.False(t, results[0].Changed)
+}
+
+func TestFormatInvalidSpec(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.WriteContent("app-a/.mbt.yml", "name: [app-a"))
+	check(t, repo.Commit("first"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.FormatSpecs(false)
+
+	assert.Error(t, err)
+	assert.Equal(t, ExitCodeConfigError, ExitCode(err))
+}
+
+func TestHasComments(t *testing.T) {
+	assert.True(t, hasComments([]byte("# spec\nname: a")))
+	assert.True(t, hasComments([]byte("name: a # the name")))
+	assert.False(t, hasComments([]byte("name: a#b")))
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const stateDirName = "mbt"
+
+// stateDir returns the directory used to persist the state of mbt
+// across invocations.
+// It is located in the git directory to keep the workspace clean.
+// Each linked worktree has its own state.
+func (s *stdSystem) stateDir() (string, error) {
+	dir, err := filepath.Abs(s.Repo.GitDir())
+	if err != nil {
+		return "", e.Wrap(ErrClassInternal, err)
+	}
+	return filepath.Join(dir, stateDirName), nil
+}
+
+// readState reads a json document stored in the state directory into v.
+// v is left untouched if the document does not exist.
+func (s *stdSystem) readState(name string, v interface{}) error {
+	dir, err := s.stateDir()
+	if err != nil {
+		return err
+	}
+
+	p := filepath.Join(dir, name)
+	buff, err := ioutil.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return e.Wrapf(ErrClassInternal, err, msgFailedReadFile, p)
+	}
+
+	err = json.Unmarshal(buff, v)
+	if err != nil {
+		return e.Wrapf(ErrClassInternal, err, msgFailedReadState, p)
+	}
+	return nil
+}
+
+// writeState stores v as a json document in the state directory.
+// Document is replaced atomically so that an interrupted write does not
+// corrupt the previous state.
+func (s *stdSystem) writeState(name string, v interface{}) error {
+	dir, err := s.stateDir()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	buff, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	p := filepath.Join(dir, name)
+	tmp := p + ".tmp"
+	err = ioutil.WriteFile(tmp, buff, 0644)
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	err = os.Rename(tmp, p)
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+	return nil
+}
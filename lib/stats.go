@@ -0,0 +1,137 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const statsFile = "stats.json"
+
+// stats is a persistent record of build history of modules.
+type stats struct {
+	Modules map[string]*ModuleStats `json:"modules"`
+	// LastBuilt is the time of the last successful build of each module.
+	LastBuilt map[string]time.Time `json:"lastBuilt,omitempty"`
+
+	mu     sync.Mutex
+	system *stdSystem
+}
+
+func (s *stdSystem) openStats() (*stats, error) {
+	st := &stats{system: s}
+	err := s.readState(statsFile, st)
+	if err != nil {
+		return nil, err
+	}
+
+	if st.Modules == nil {
+		st.Modules = make(map[string]*ModuleStats)
+	}
+
+	if st.LastBuilt == nil {
+		st.LastBuilt = make(map[string]time.Time)
+	}
+
+	return st, nil
+}
+
+// flaky informs if the module should be treated as flaky.
+// A module is flaky if it is flagged in the spec or it has previously
+// succeeded at a version that failed to build.
+func (st *stats) flaky(mod *Module) bool {
+	if mod.metadata.spec.Flaky {
+		return true
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	m, ok := st.Modules[mod.Name()]
+	return ok && m.Flakes > 0
+}
+
+// record updates the history of a module with the outcome of a build
+// attempt and persists the stats.
+func (st *stats) record(mod *Module, retry bool, err error) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	m, ok := st.Modules[mod.Name()]
+	if !ok {
+		m = &ModuleStats{Name: mod.Name()}
+		st.Modules[mod.Name()] = m
+	}
+
+	m.Builds++
+	if retry {
+		m.Retries++
+	}
+
+	// Local versions do not reflect the content of modules, therefore
+	// they cannot be used to detect flakes.
+	version := mod.Version()
+	if err != nil {
+		m.Failures++
+		if version != "local" {
+			m.LastFailedVersion = version
+		}
+	} else {
+		st.LastBuilt[mod.Name()] = time.Now().UTC()
+		if version != "local" && version == m.LastFailedVersion {
+			m.Flakes++
+			m.LastFailedVersion = ""
+		}
+	}
+
+	return st.system.writeState(statsFile, st)
+}
+
+func (s *stdSystem) FlakyModules() ([]*ModuleStats, error) {
+	st, err := s.openStats()
+	if err != nil {
+		return nil, err
+	}
+
+	r := make([]*ModuleStats, 0)
+	for _, m := range st.Modules {
+		if m.Failures > 0 {
+			r = append(r, m)
+		}
+	}
+
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Flakes != r[j].Flakes {
+			return r[i].Flakes > r[j].Flakes
+		}
+		if r[i].Failures != r[j].Failures {
+			return r[i].Failures > r[j].Failures
+		}
+		return r[i].Name < r[j].Name
+	})
+
+	return r, nil
+}
+
+func (s *stdSystem) LastBuilt() (map[string]time.Time, error) {
+	st, err := s.openStats()
+	if err != nil {
+		return nil, err
+	}
+
+	return st.LastBuilt, nil
+}
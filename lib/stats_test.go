@@ -0,0 +1,208 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failOnceScript returns a build script that fails the first time it
+// runs with a given marker.
+func failOnceScript(marker string) string {
+	return fmt.Sprintf(`if [ -f $MBT_REPO_PATH/../%s ]; then
+  echo built $MBT_MODULE_NAME
+else
+  touch $MBT_REPO_PATH/../%s
+  exit 1
+fi`, marker, marker)
+}
+
+func TestRetryFlakyModule(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Flaky: true,
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", failOnceScript("attempted")))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	options := stdTestCmdOptions(nil)
+	options.FlakyRetries = 2
+	summary, err := world.System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+	assert.Len(t, summary.Completed, 1)
+
+	stats, err := world.System.FlakyModules()
+	check(t, err)
+	assert.Equal(t, []*ModuleStats{{Name: "app-a", Builds: 2, Failures: 1, Retries: 1, Flakes: 1}}, stats)
+}
+
+func TestDoNotRetryModulesNotFlaky(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", failOnceScript("attempted")))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	options := stdTestCmdOptions(nil)
+	options.FlakyRetries = 2
+	_, err := world.System.BuildCurrentBranch(NoFilter, options)
+	assert.EqualError(t, err, fmt.Sprintf(msgFailedBuild, "app-a"))
+
+	stats, err := world.System.FlakyModules()
+	check(t, err)
+	assert.Len(t, stats, 1)
+	assert.Equal(t, 1, stats[0].Builds)
+	assert.Equal(t, 1, stats[0].Failures)
+	assert.Equal(t, 0, stats[0].Flakes)
+	assert.Equal(t, summaryVersion(t, world, "app-a"), stats[0].LastFailedVersion)
+}
+
+func TestModuleFlakingIsRetried(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", failOnceScript("first-attempt")))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	options := stdTestCmdOptions(nil)
+	options.FlakyRetries = 1
+
+	// Same version fails then succeeds without changes.
+	_, err := world.System.BuildCurrentBranch(NoFilter, options)
+	assert.Error(t, err)
+	_, err = world.System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	check(t, repo.WriteShellScript("app-a/build.sh", failOnceScript("second-attempt")))
+	check(t, repo.Commit("second"))
+
+	// Module is now known to be flaky and retried.
+	_, err = world.System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	stats, err := world.System.FlakyModules()
+	check(t, err)
+	assert.Equal(t, []*ModuleStats{{Name: "app-a", Builds: 4, Failures: 2, Retries: 1, Flakes: 2}}, stats)
+}
+
+func TestFlakyModulesOrder(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:  "app-a",
+		Build: map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Flaky: true,
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", failOnceScript("app-a")))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.WriteShellScript("app-b/build.sh", "exit 1"))
+	check(t, repo.InitModule("app-c"))
+	check(t, repo.WriteShellScript("app-c/build.sh", "echo built app-c"))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	options := stdTestCmdOptions(nil)
+	options.FlakyRetries = 1
+	_, err := world.System.BuildCurrentBranch(NoFilter, options)
+	assert.Error(t, err)
+
+	stats, err := world.System.FlakyModules()
+	check(t, err)
+	assert.Len(t, stats, 2)
+	assert.Equal(t, "app-a", stats[0].Name)
+	assert.Equal(t, "app-b", stats[1].Name)
+}
+
+func TestFlakyModulesWithoutHistory(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	stats, err := NewWorld(t, ".tmp/repo").System.FlakyModules()
+	check(t, err)
+	assert.Len(t, stats, 0)
+
+	_, err = os.Stat(".tmp/repo/.git/mbt/stats.json")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func summaryVersion(t *testing.T, world *World, name string) string {
+	m, err := world.System.ManifestByCurrentBranch()
+	check(t, err)
+	return m.Modules.indexByName()[name].Version()
+}
+
+func TestLastBuilt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "exit 0"))
+	check(t, repo.WriteShellScript("app-b/build.sh", "exit 1"))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	lastBuilt, err := world.System.LastBuilt()
+	check(t, err)
+	assert.Empty(t, lastBuilt)
+
+	started := time.Now()
+	_, err = world.System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(nil))
+	assert.Error(t, err)
+
+	lastBuilt, err = world.System.LastBuilt()
+	check(t, err)
+	assert.Len(t, lastBuilt, 1)
+	assert.False(t, lastBuilt["app-a"].Before(started.Truncate(time.Second)))
+}
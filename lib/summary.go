@@ -0,0 +1,254 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os/exec"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// ModuleStatusSucceeded indicates that the command of the module succeeded.
+	ModuleStatusSucceeded = "succeeded"
+	// ModuleStatusFailed indicates that the command of the module failed.
+	ModuleStatusFailed = "failed"
+	// ModuleStatusSkipped indicates that the module does not have a command
+	// for this platform or it was skipped due to a failure.
+	ModuleStatusSkipped = "skipped"
+	// ModuleStatusResumed indicates that the module was built in a
+	// previous build and it was not built again.
+	ModuleStatusResumed = "resumed"
+	// ModuleStatusSatisfied indicates that the artifact of the module
+	// version was found by its probe and it was not built.
+	ModuleStatusSatisfied = "satisfied"
+	// ModuleStatusUnaffected indicates that neither the module nor the
+	// fingerprints of its dependencies changed since its last build and
+	// it was not built.
+	ModuleStatusUnaffected = "unaffected"
+	// ModuleStatusCached indicates that the outputs of the module
+	// version were restored from the build cache and it was not built.
+	ModuleStatusCached = "cached"
+	// ModuleStatusNotStarted indicates that the module was not processed
+	// because the build was stopped due to a failure or cancelled.
+	ModuleStatusNotStarted = "notStarted"
+)
+
+// InvocationSummary is a machine readable summary of a build or a run
+// of a user defined command.
+type InvocationSummary struct {
+	// Command is build or the name of the user defined command.
+	Command string `json:"command"`
+	Commit  string `json:"commit"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	// Diagnostic describes the error (in English regardless of the
+	// language of diagnostics).
+	Diagnostic *Diagnostic `json:"diagnostic,omitempty"`
+	// ExitCode of mbt (see ExitCodeSuccess and the other exit codes).
+	ExitCode int              `json:"exitCode"`
+	Modules  []*ModuleSummary `json:"modules"`
+	// TestReport is the summary of the test reports aggregated in a build.
+	TestReport *TestReport `json:"testReport,omitempty"`
+}
+
+// ModuleSummary is the outcome of a module in an invocation.
+type ModuleSummary struct {
+	Name     string   `json:"name"`
+	Path     string   `json:"path"`
+	Version  string   `json:"version"`
+	Status   string   `json:"status"`
+	Variants []string `json:"variants,omitempty"`
+	Owners   []string `json:"owners,omitempty"`
+	// Duration of the command in seconds.
+	Duration float64 `json:"duration"`
+	// ExitCode of the command. -1 if the command failed without an exit code.
+	ExitCode int    `json:"exitCode"`
+	Error    string `json:"error,omitempty"`
+	// Artifacts are the outputs of the module published in a build.
+	Artifacts []*Artifact `json:"artifacts,omitempty"`
+}
+
+// InvocationSummary creates the summary of a build.
+// err is the error returned by the build if any.
+func (s *BuildSummary) InvocationSummary(err error) *InvocationSummary {
+	summary := newInvocationSummary("build", s.Manifest, s.Skipped, s.Timings, err)
+	summary.TestReport = s.TestReport
+
+	index := make(map[string]*ModuleSummary)
+	for _, m := range summary.Modules {
+		index[m.Name] = m
+	}
+
+	for _, r := range s.Completed {
+		m := index[r.Module.Name()]
+		if r.Resumed {
+			m.Status = ModuleStatusResumed
+		}
+		if r.Satisfied {
+			m.Status = ModuleStatusSatisfied
+		}
+		if r.Unaffected {
+			m.Status = ModuleStatusUnaffected
+		}
+		if r.Cached {
+			m.Status = ModuleStatusCached
+		}
+		if r.Variant != nil {
+			m.Variants = append(m.Variants, r.Variant.Name)
+		}
+		m.Artifacts = append(m.Artifacts, r.Artifacts...)
+	}
+
+	return summary
+}
+
+// InvocationSummary creates the summary of a run of the specified user
+// defined command.
+func (r *RunResult) InvocationSummary(command string) *InvocationSummary {
+	var err error
+	if len(r.Failures) > 0 {
+		err = r.Failures[0].Err
+	}
+
+	summary := newInvocationSummary(command, r.Manifest, r.Skipped, r.Timings, err)
+	if err != nil && summary.ExitCode == ExitCodeError {
+		// Commands may fail without an exit status, for example when
+		// the program is not found.
+		summary.ExitCode = ExitCodeBuildFailure
+	}
+	return summary
+}
+
+// stopped records the error that stopped the invocation before all the
+// modules were processed.
+func (s *InvocationSummary) stopped(err error) {
+	s.Success = false
+	s.Error = err.Error()
+	s.Diagnostic = Diagnose(err, nil)
+	s.ExitCode = ExitCode(err)
+}
+
+func newInvocationSummary(command string, m *Manifest, skipped []*Module, timings []*ModuleTiming, err error) *InvocationSummary {
+	summary := &InvocationSummary{
+		Command:  command,
+		Commit:   m.Sha,
+		Success:  err == nil,
+		ExitCode: ExitCode(err),
+		Modules:  make([]*ModuleSummary, 0, len(m.Modules)),
+	}
+	if err != nil {
+		summary.Error = err.Error()
+		summary.Diagnostic = Diagnose(err, nil)
+	} else if len(m.Modules) == 0 {
+		summary.ExitCode = ExitCodeNothingToBuild
+	}
+
+	skippedIndex := make(map[string]bool)
+	for _, a := range skipped {
+		skippedIndex[a.Name()] = true
+	}
+
+	timingsIndex := make(map[string]*ModuleTiming)
+	for _, t := range timings {
+		timingsIndex[t.Module.Name()] = t
+	}
+
+	for _, a := range m.Modules {
+		mod := &ModuleSummary{
+			Name:    a.Name(),
+			Path:    a.Path(),
+			Version: a.Version(),
+			Status:  ModuleStatusNotStarted,
+			Owners:  a.Owners(),
+		}
+
+		if t, ok := timingsIndex[a.Name()]; ok {
+			mod.Status = ModuleStatusSucceeded
+			mod.Duration = t.Finished.Sub(t.Started).Seconds()
+			if t.Err != nil {
+				mod.Status = ModuleStatusFailed
+				mod.Error = t.Err.Error()
+				mod.ExitCode = exitCode(t.Err)
+			}
+		} else if skippedIndex[a.Name()] {
+			mod.Status = ModuleStatusSkipped
+		}
+
+		summary.Modules = append(summary.Modules, mod)
+	}
+
+	return summary
+}
+
+// exitCode returns the exit code of the process that caused an error.
+func exitCode(err error) int {
+	for err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode()
+		}
+
+		ee, ok := err.(*e.E)
+		if !ok {
+			break
+		}
+		err = ee.InnerError()
+	}
+
+	return -1
+}
+
+func writeInvocationSummary(options *CmdOptions, summary *InvocationSummary) error {
+	if options.SummaryFile == "" {
+		return nil
+	}
+
+	return WriteInvocationSummary(options.SummaryFile, summary)
+}
+
+// WriteInvocationSummary writes a summary to the specified file in json
+// format.
+func WriteInvocationSummary(path string, summary *InvocationSummary) error {
+	buff, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return e.Wrapf(ErrClassInternal, err, msgFailedWriteSummary, path)
+	}
+
+	err = ioutil.WriteFile(path, buff, 0644)
+	if err != nil {
+		return e.Wrapf(ErrClassUser, err, msgFailedWriteSummary, path)
+	}
+
+	return nil
+}
+
+// ReadInvocationSummary reads a summary written with --summary.
+func ReadInvocationSummary(path string) (*InvocationSummary, error) {
+	buff, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedReadSummary, path)
+	}
+
+	summary := &InvocationSummary{}
+	err = json.Unmarshal(buff, summary)
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedReadSummary, path)
+	}
+
+	return summary, nil
+}
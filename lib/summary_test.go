@@ -0,0 +1,172 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readInvocationSummary(t *testing.T, file string) *InvocationSummary {
+	buff, err := ioutil.ReadFile(file)
+	check(t, err)
+	summary := &InvocationSummary{}
+	check(t, json.Unmarshal(buff, summary))
+	return summary
+}
+
+func TestBuildSummaryFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:   "app-a",
+		Build:  map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Matrix: map[string][]string{"arch": {"amd64", "arm64"}},
+	}))
+	check(t, repo.WriteShellScript("app-a/build.sh", "true"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:  "app-b",
+		Build: map[string]*Cmd{"unknown-os": {Cmd: "true"}},
+	}))
+	check(t, repo.Commit("first"))
+
+	file := filepath.Join(".tmp", "summary.json")
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.SummaryFile = file
+	buildSummary, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	summary := readInvocationSummary(t, file)
+	assert.Equal(t, buildSummary.InvocationSummary(nil).Modules[0].Version, summary.Modules[0].Version)
+	assert.Equal(t, "build", summary.Command)
+	assert.Equal(t, buildSummary.Manifest.Sha, summary.Commit)
+	assert.True(t, summary.Success)
+	assert.Len(t, summary.Modules, 2)
+
+	a := summary.Modules[0]
+	assert.Equal(t, "app-a", a.Name)
+	assert.Equal(t, "app-a", a.Path)
+	assert.Equal(t, ModuleStatusSucceeded, a.Status)
+	assert.Equal(t, []string{"arch=amd64", "arch=arm64"}, a.Variants)
+	assert.Equal(t, 0, a.ExitCode)
+	assert.True(t, a.Duration > 0)
+
+	assert.Equal(t, ModuleStatusSkipped, summary.Modules[1].Status)
+}
+
+func TestBuildSummaryFileOfFailedBuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "exit 3"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:         "app-b",
+		Build:        map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Dependencies: []string{"app-a"},
+	}))
+	check(t, repo.WriteShellScript("app-b/build.sh", "true"))
+	check(t, repo.Commit("first"))
+
+	file := filepath.Join(".tmp", "summary.json")
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.SummaryFile = file
+	_, err := NewWorld(t, ".tmp/repo").System.BuildCurrentBranch(NoFilter, options)
+	assert.Error(t, err)
+
+	summary := readInvocationSummary(t, file)
+	assert.False(t, summary.Success)
+	assert.Equal(t, "Failed to build module 'app-a'", summary.Error)
+	assert.Equal(t, ModuleStatusFailed, summary.Modules[0].Status)
+	assert.Equal(t, 3, summary.Modules[0].ExitCode)
+	assert.Equal(t, "Failed to build module 'app-a'", summary.Modules[0].Error)
+	assert.Equal(t, ModuleStatusNotStarted, summary.Modules[1].Status)
+}
+
+func TestBuildSummaryOfResumedBuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "true"))
+	check(t, repo.Commit("first"))
+
+	world := NewWorld(t, ".tmp/repo")
+	_, err := world.System.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+	check(t, err)
+
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.Resume = true
+	buildSummary, err := world.System.BuildCurrentBranch(NoFilter, options)
+	check(t, err)
+
+	assert.Equal(t, ModuleStatusResumed, buildSummary.InvocationSummary(nil).Modules[0].Status)
+}
+
+func TestRunInSummaryFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:     "app-a",
+		Commands: map[string]*UserCmd{"lint": {Cmd: "./lint.sh"}},
+	}))
+	check(t, repo.WriteShellScript("app-a/lint.sh", "exit 2"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:     "app-b",
+		Commands: map[string]*UserCmd{"lint": {Cmd: "./lint.sh"}},
+	}))
+	check(t, repo.WriteShellScript("app-b/lint.sh", "true"))
+	check(t, repo.InitModule("app-c"))
+	check(t, repo.Commit("first"))
+
+	file := filepath.Join(".tmp", "summary.json")
+	options := stdTestCmdOptions(new(bytes.Buffer))
+	options.SummaryFile = file
+	_, err := NewWorld(t, ".tmp/repo").System.RunInCurrentBranch("lint", NoFilter, options)
+	check(t, err)
+
+	summary := readInvocationSummary(t, file)
+	assert.Equal(t, "lint", summary.Command)
+	assert.False(t, summary.Success)
+	assert.Equal(t, ModuleStatusFailed, summary.Modules[0].Status)
+	assert.Equal(t, 2, summary.Modules[0].ExitCode)
+	assert.Equal(t, ModuleStatusSucceeded, summary.Modules[1].Status)
+	assert.Equal(t, ModuleStatusSkipped, summary.Modules[2].Status)
+}
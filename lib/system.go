@@ -16,8 +16,11 @@ limitations under the License.
 package lib
 
 import (
+	"context"
 	"io"
 	"os"
+	"sync"
+	"time"
 )
 
 // This file defines the interfaces and types that make up MBT system.
@@ -44,6 +47,17 @@ type DiffDelta struct {
 	NewFile string
 	// OldFile path of the delta
 	OldFile string
+	// Submodule informs if the delta is a change of the commit
+	// a submodule points to.
+	Submodule bool
+}
+
+// Submodule is a git submodule in the repository.
+type Submodule struct {
+	// Path of the submodule relative to the repository root.
+	Path string
+	// Commit the submodule points to.
+	Commit string
 }
 
 // BlobWalkCallback used for discovering blobs in a commit tree.
@@ -71,6 +85,10 @@ type Repo interface {
 	GetCommit(sha string) (Commit, error)
 	// Path of the repository.
 	Path() string
+	// GitDir returns the directory containing the git metadata of the
+	// repository. This is the .git directory unless the repository is
+	// a linked worktree.
+	GitDir() string
 	// Diff gets the diff between two commits.
 	Diff(a, b Commit) ([]*DiffDelta, error)
 	// DiffMergeBase gets the diff between the merge base of from and to and, to.
@@ -87,6 +105,9 @@ type Repo interface {
 	Changes(c Commit) ([]*DiffDelta, error)
 	// WalkBlobs invokes the callback for each blob reachable from the commit tree.
 	WalkBlobs(a Commit, callback BlobWalkCallback) error
+	// WalkBlobsInDir invokes the callback for each blob in a directory
+	// (or the file) at path dir of the commit tree.
+	WalkBlobsInDir(a Commit, dir string, callback BlobWalkCallback) error
 	// BlobContents of specified blob.
 	BlobContents(blob Blob) ([]byte, error)
 	// BlobContentsByPath gets the blob contents from a specific git tree.
@@ -102,8 +123,15 @@ type Repo interface {
 	CurrentBranchCommit() (Commit, error)
 	// IsEmpty informs if the current repository is empty or not.
 	IsEmpty() (bool, error)
+	// IsShallow informs if the current repository is a shallow clone.
+	IsShallow() (bool, error)
+	// IsHeadDetached informs if the head is not pointing to a branch.
+	IsHeadDetached() (bool, error)
 	// FindAllFilesInWorkspace returns all files in repository matching given pathSpec, including untracked files.
 	FindAllFilesInWorkspace(pathSpec []string) ([]string, error)
+	// IsIgnored informs if the specified path (relative to the repository
+	// root) is excluded by the ignore rules of the repository.
+	IsIgnored(path string) (bool, error)
 	// EnsureSafeWorkspace returns an error workspace is in a safe state
 	// for operations requiring a checkout.
 	// For example, in git repositories we consider uncommitted changes or
@@ -116,6 +144,40 @@ type Repo interface {
 	CheckoutReference(Reference) error
 	// MergeBase returns the merge base of two commits.
 	MergeBase(a, b Commit) (Commit, error)
+	// ResolveCommit returns the commit a revision (e.g. a branch, tag or
+	// sha) points to.
+	ResolveCommit(rev string) (Commit, error)
+	// History returns the commits reachable from to but not from from,
+	// newest first. from can be nil to include the entire history of to.
+	History(from, to Commit) ([]*LogEntry, error)
+	// References returns the short names of the branches (including
+	// remote branches) and tags in the repository.
+	References() ([]string, error)
+	// SparseCheckout returns the files materialized in the workspace
+	// if the repository uses sparse checkout. nil is returned otherwise.
+	SparseCheckout() (*SparseCheckout, error)
+	// LFSObjectID returns the id of the Git LFS object if the blob is
+	// a pointer file. An empty string is returned otherwise.
+	LFSObjectID(blob Blob) (string, error)
+	// Submodules returns the submodules in the commit tree.
+	// Submodules in the index are returned if the commit is nil.
+	Submodules(commit Commit) ([]*Submodule, error)
+	// OpenSubmodule opens the repository of the submodule at path
+	// (relative to the repository root).
+	OpenSubmodule(path string) (Repo, error)
+	// HooksDir returns the directory git runs the hooks from.
+	HooksDir() (string, error)
+}
+
+// LogEntry is a commit in the history of the repository.
+type LogEntry struct {
+	Commit  Commit
+	Message string
+	Author  string
+	Email   string
+	Time    time.Time
+	// Parents is the number of parents of the commit.
+	Parents int
 }
 
 /** Module Discovery **/
@@ -124,6 +186,12 @@ type Repo interface {
 type Cmd struct {
 	Cmd  string
 	Args []string `yaml:",flow"`
+	// Dir is the working directory of the command relative to the
+	// module directory. Defaults to the module directory.
+	Dir string `yaml:"dir,omitempty"`
+	// Shell used to interpret Cmd (sh, bash, powershell, pwsh or cmd).
+	// Cmd is executed directly with Args if this is not specified.
+	Shell string `yaml:"shell,omitempty"`
 }
 
 // UserCmd represents the structure of a user defined command in .mbt.yml
@@ -131,6 +199,12 @@ type UserCmd struct {
 	Cmd  string
 	Args []string `yaml:",flow"`
 	OS   []string `yaml:"os"`
+	// Dir is the working directory of the command relative to the
+	// module directory. Defaults to the module directory.
+	Dir string `yaml:"dir,omitempty"`
+	// Shell used to interpret Cmd (sh, bash, powershell, pwsh or cmd).
+	// Cmd is executed directly with Args if this is not specified.
+	Shell string `yaml:"shell,omitempty"`
 }
 
 // Spec represents the structure of .mbt.yml contents.
@@ -141,6 +215,284 @@ type Spec struct {
 	Properties       map[string]interface{} `yaml:"properties"`
 	Dependencies     []string               `yaml:"dependencies"`
 	FileDependencies []string               `yaml:"fileDependencies"`
+	// Ignore are the patterns (in gitignore syntax) of the paths
+	// relative to the module directory excluded from the module.
+	// Patterns in .mbtignore files are applied after them.
+	Ignore    []string            `yaml:"ignore,omitempty"`
+	Image     string              `yaml:"image,omitempty"`
+	Hooks     *Hooks              `yaml:"hooks,omitempty"`
+	Matrix    map[string][]string `yaml:"matrix,omitempty"`
+	Resources *Resources          `yaml:"resources,omitempty"`
+	Flaky     bool                `yaml:"flaky,omitempty"`
+	Env       map[string]string   `yaml:"env,omitempty"`
+	Secrets   []string            `yaml:"secrets,omitempty"`
+	// Requires are the names of the environment variables that must be
+	// set to build the module or run commands in it.
+	Requires []string `yaml:"requires,omitempty"`
+	Reports  []string `yaml:"reports,omitempty"`
+	Owners   []string `yaml:"owners,omitempty"`
+	// Labels are the key value pairs used to select modules
+	// (e.g. team: payments) with FilterOptions.Labels.
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// SerializeOn is a list of keys (e.g. the names of the stateful
+	// services used by the build). Modules sharing a key are never
+	// built concurrently, in the same way as Resources.Locks.
+	SerializeOn []string `yaml:"serializeOn,omitempty"`
+	// Outputs are the patterns of the files produced by the build to
+	// be published to the targets in Publish.
+	Outputs []string `yaml:"outputs,omitempty"`
+	// Publish are the targets of the outputs. Defaults to the targets
+	// in the repository configuration.
+	Publish []*PublishTarget `yaml:"publish,omitempty"`
+	// Docker is the image built with docker buildx when the module
+	// does not have a build command for the platform.
+	Docker *DockerBuild `yaml:"docker,omitempty"`
+	// Terraform is the Terraform root module of the module.
+	Terraform *TerraformStack `yaml:"terraform,omitempty"`
+	// ExternalDependencies are the packages outside the repository
+	// used by the module. They are included in the SBOM of the module.
+	ExternalDependencies []*ExternalDependency `yaml:"externalDependencies,omitempty"`
+	// Environments contains the overlays of the module for each
+	// environment (e.g. dev, staging and prod) keyed by name.
+	Environments map[string]*Environment `yaml:"environments,omitempty"`
+	// WorkspaceDependencies are the modules in the other repositories of
+	// a multi-repo workspace (see Composition) this module depends on,
+	// in the form of <repo>/<module>. They are ignored outside of
+	// workspaces.
+	WorkspaceDependencies []string `yaml:"workspaceDependencies,omitempty"`
+	// Probe checks whether the artifact of the module version already
+	// exists. Module is not built if it does.
+	Probe *Probe `yaml:"probe,omitempty"`
+	// Fingerprint is the command writing the interface of the module
+	// (e.g. the hashes of its exported API). Modules depending on the
+	// module are not built again unless its fingerprint changes.
+	Fingerprint *Fingerprint `yaml:"fingerprint,omitempty"`
+	// Templates enables the expansion of go templates in the commands
+	// declared in the spec. Commands containing {{ are executed as they
+	// are otherwise.
+	Templates bool `yaml:"templates,omitempty"`
+}
+
+// ExternalDependency is a package outside the repository used by a
+// module (e.g. a library from a package registry).
+type ExternalDependency struct {
+	Name    string `yaml:"name" json:"name"`
+	Version string `yaml:"version,omitempty" json:"version,omitempty"`
+	// Purl is the package url of the dependency
+	// (https://github.com/package-url/purl-spec).
+	Purl string `yaml:"purl,omitempty" json:"purl,omitempty"`
+	// License is the SPDX license expression of the dependency.
+	License string `yaml:"license,omitempty" json:"license,omitempty"`
+}
+
+// Environment represents the overlay of a module spec for an environment.
+type Environment struct {
+	// Properties are merged over the properties of the module when
+	// building or applying templates for the environment.
+	Properties map[string]interface{} `yaml:"properties,omitempty"`
+}
+
+// Resources represents the resources required to build a module.
+// These are hints used when scheduling parallel builds.
+type Resources struct {
+	// CPU is the number of cores used by the build.
+	CPU int `yaml:"cpu,omitempty"`
+	// Memory used by the build (e.g. 512Mi, 8Gi).
+	Memory string `yaml:"memory,omitempty"`
+	// Locks is a list of names of the resources used exclusively
+	// by the build (e.g. dockerd). Modules sharing a lock are never
+	// built concurrently.
+	Locks []string `yaml:"locks,omitempty"`
+}
+
+// Hooks represents the lifecycle hooks of a build.
+// Hooks can be declared in repository configuration (applicable to
+// all modules) as well as in individual module specs.
+type Hooks struct {
+	// PreBuild hooks are executed before the build command.
+	PreBuild []*Cmd `yaml:"preBuild,omitempty"`
+	// PostBuild hooks are executed after a successful build.
+	PostBuild []*Cmd `yaml:"postBuild,omitempty"`
+	// OnFailure hooks are executed when the build command or any of the
+	// preceding hooks fail.
+	OnFailure []*Cmd `yaml:"onFailure,omitempty"`
+	// Setup hooks are executed once in the repository root before
+	// building any module. Only applicable in repository configuration.
+	Setup []*Cmd `yaml:"setup,omitempty"`
+	// Teardown hooks are executed once in the repository root after
+	// building the modules, even if the build or the setup failed.
+	// Only applicable in repository configuration.
+	Teardown []*Cmd `yaml:"teardown,omitempty"`
+}
+
+// RepoConfig represents the structure of repository wide configuration
+// stored in .mbt/config.yml.
+type RepoConfig struct {
+	Hooks *Hooks `yaml:"hooks,omitempty"`
+	// Env contains the default environment variables for all modules.
+	Env map[string]string `yaml:"env,omitempty"`
+	// HostEnv is the list of host environment variables that can be
+	// referenced (i.e. ${VAR}) in the environment variables declared
+	// in repository configuration and module specs.
+	HostEnv []string `yaml:"hostEnv,omitempty"`
+	// Secrets is the list of environment variables containing sensitive
+	// values. They are redacted from the output and logs.
+	Secrets []string `yaml:"secrets,omitempty"`
+	// Metrics specifies where the metrics of builds and runs are exported to.
+	Metrics *MetricsConfig `yaml:"metrics,omitempty"`
+	// Issues specifies how the issues referenced in commit messages are
+	// recognised in changelogs and impact reports.
+	Issues *IssueTracker `yaml:"issues,omitempty"`
+	// Policies specifies how the rego policies evaluated before builds
+	// and runs are loaded.
+	Policies *PolicyConfig `yaml:"policies,omitempty"`
+	// Notifications are the webhooks called at the end of builds and runs.
+	Notifications []*Notification `yaml:"notifications,omitempty"`
+	// EventStreams are the endpoints receiving the lifecycle events of
+	// builds and runs as they happen.
+	EventStreams []*EventStream `yaml:"eventStreams,omitempty"`
+	// Publish are the targets of the outputs of modules not specifying
+	// their own targets.
+	Publish []*PublishTarget `yaml:"publish,omitempty"`
+	// Partials is the directory of partial templates available to
+	// the templates used with apply. Defaults to .mbt/partials.
+	Partials string `yaml:"partials,omitempty"`
+	// PropertiesSchema describes the properties of each module. When
+	// specified, properties are validated before applying templates.
+	PropertiesSchema *PropertySchema `yaml:"propertiesSchema,omitempty"`
+	// TemplateSources are the remote sources of templates keyed by name.
+	TemplateSources map[string]*TemplateSource `yaml:"templateSources,omitempty"`
+	// Validators are the commands validating the output of templates.
+	Validators []*Validator `yaml:"validators,omitempty"`
+	// TemplateFuncs are the template functions implemented by external
+	// commands keyed by the name of the function. Arguments of the
+	// function are written to the stdin of the command as a json array
+	// and the output of the command is the result.
+	TemplateFuncs map[string]*Cmd `yaml:"templateFuncs,omitempty"`
+	// Plugins are the names of the plugins (executables named
+	// mbt-<name>) extending mbt. They must be pinned in
+	// .mbt/plugins.lock with mbt plugin lock.
+	Plugins []string `yaml:"plugins,omitempty"`
+	// Cache specifies how the discovery cache entries shared through
+	// the cache plugins are trusted.
+	Cache *CacheConfig `yaml:"cache,omitempty"`
+	// SBOMPlugins are the commands listing the external dependencies
+	// of a module (e.g. from the package manifests of a language).
+	// They are executed in each module directory and write a json array
+	// of ExternalDependency to stdout.
+	SBOMPlugins []*Cmd `yaml:"sbomPlugins,omitempty"`
+	// Profile is the profile selected when it is not specified with
+	// --config-profile.
+	Profile string `yaml:"profile,omitempty"`
+	// Defaults are the default values of command line flags keyed by
+	// the name of the flag.
+	Defaults map[string]interface{} `yaml:"defaults,omitempty"`
+	// Profiles are the named sets of default values of command line
+	// flags (e.g. ci or local).
+	Profiles map[string]map[string]interface{} `yaml:"profiles,omitempty"`
+	// Affected are the selectors of the modules affected by a change
+	// for each purpose (e.g. tests or deploys) keyed by the purpose.
+	// They override the default selectors of the well known purposes.
+	Affected map[string]*AffectedSelector `yaml:"affected,omitempty"`
+	// Submodules specifies how the git submodules in the repository
+	// are treated during the module discovery.
+	Submodules *SubmoduleConfig `yaml:"submodules,omitempty"`
+	// LFS specifies how the files tracked with Git LFS are treated
+	// during the module discovery.
+	LFS *LFSConfig `yaml:"lfs,omitempty"`
+	// Paths specifies how the paths of changed files are matched with
+	// the modules.
+	Paths *PathConfig `yaml:"paths,omitempty"`
+	// Teams are the members (names or emails of commit authors) of the
+	// teams listed as owners of modules keyed by the name of the team.
+	Teams map[string][]string `yaml:"teams,omitempty"`
+	// Pipelines are the paths of the templates rendering the pipelines
+	// generated with mbt ci pipeline, keyed by the name of the CI
+	// system. They override the builtin pipelines of the CI systems.
+	Pipelines map[string]string `yaml:"pipelines,omitempty"`
+}
+
+// PathConfig specifies how the paths of changed files are matched with
+// the modules.
+type PathConfig struct {
+	// CaseSensitive specifies whether the paths of changed files are
+	// matched with the module directories and file dependencies in a
+	// case sensitive manner. Paths are matched ignoring the case by
+	// default, so that a change affects the same modules on case
+	// insensitive file systems (e.g. Windows and macOS).
+	CaseSensitive bool `yaml:"caseSensitive,omitempty"`
+}
+
+// LFSConfig specifies how the files tracked with Git LFS are treated
+// during the module discovery.
+// Pointer files are never smudged during the discovery.
+type LFSConfig struct {
+	// Objects specifies whether the versions of modules are derived
+	// from the ids of the LFS objects rather than the content of the
+	// pointer files.
+	Objects bool `yaml:"objects,omitempty"`
+}
+
+// CacheConfig specifies how the discovery cache entries shared through
+// the cache plugins, and the entries of the remote build cache, are
+// signed and verified.
+// Entries received from the plugins or the remote build cache are
+// verified when either HMACKeyEnv or Sigstore is specified. Entries failing the verification are not
+// used, as if they were not in the cache.
+type CacheConfig struct {
+	// HMACKeyEnv is the environment variable containing the key the
+	// entries are signed and verified with (HMAC-SHA256).
+	HMACKeyEnv string `yaml:"hmacKeyEnv,omitempty"`
+	// Sigstore verifies the entries signed keyless with cosign.
+	Sigstore *SigstoreIdentity `yaml:"sigstore,omitempty"`
+	// Sign signs the entries stored in the cache plugins and the remote
+	// build cache. Entries are
+	// not stored when they cannot be signed (e.g. when the key is not
+	// available).
+	Sign bool `yaml:"sign,omitempty"`
+}
+
+// SigstoreIdentity is the identity of the certificates of sigstore
+// signatures.
+type SigstoreIdentity struct {
+	// Identity is the subject of the certificate (e.g. the url of a CI
+	// workflow or an email).
+	Identity string `yaml:"identity"`
+	// Issuer is the OIDC issuer of the identity
+	// (e.g. https://token.actions.githubusercontent.com).
+	Issuer string `yaml:"issuer"`
+}
+
+// SubmoduleConfig specifies how the git submodules in the repository
+// are treated during the module discovery.
+// Change of the commit a submodule points to is always a change of
+// the module containing it.
+type SubmoduleConfig struct {
+	// Recurse specifies whether the module specs in submodules are
+	// discovered as modules of the repository.
+	Recurse bool `yaml:"recurse,omitempty"`
+}
+
+// AffectedSelector selects the modules affected by a change for a
+// purpose.
+type AffectedSelector struct {
+	// Query is the expression selecting the modules (see --query).
+	// All affected modules are selected if this is not specified.
+	Query string `yaml:"query,omitempty"`
+	// Dependents specifies whether the modules depending on the changed
+	// modules are affected. Defaults to true.
+	Dependents *bool `yaml:"dependents,omitempty"`
+}
+
+// Validator represents a command validating the output of templates.
+// Output is written to the stdin of the command and a non zero exit
+// status fails apply.
+type Validator struct {
+	Cmd `yaml:",inline"`
+	// Templates is the list of patterns (e.g. deploy/*.yaml.tmpl) of the
+	// templates this validator is used with. Validator is used with all
+	// templates if this is not specified.
+	Templates []string `yaml:"templates,omitempty"`
 }
 
 // Module represents a single module in the repository.
@@ -159,6 +511,10 @@ type Discover interface {
 	// ModulesInCommit walks the git tree at a specific commit looking for
 	// directories with .mbt.yml file. Returns discovered Modules.
 	ModulesInCommit(commit Commit) (Modules, error)
+	// ModulesInCommitAtConfig discovers the modules in the tree of
+	// commit as specified by the module specs and the repository
+	// configuration in configCommit.
+	ModulesInCommitAtConfig(commit, configCommit Commit) (Modules, error)
 	// ModulesInWorkspace walks current workspace looking for
 	// directories with .mbt.yml file. Returns discovered Modules.
 	ModulesInWorkspace() (Modules, error)
@@ -170,10 +526,15 @@ type Reducer interface {
 }
 
 // Manifest represents a collection modules in the repository.
+// Manifests are safe for concurrent readers. Modules must not be
+// modified once the manifest is shared.
 type Manifest struct {
 	Dir     string
 	Sha     string
 	Modules Modules
+
+	indexOnce sync.Once
+	index     map[string]*Module
 }
 
 // ManifestBuilder builds Manifest for various conditions
@@ -187,6 +548,10 @@ type ManifestBuilder interface {
 	// ByCommitContent creates the manifest for the content of the
 	// specified commit.
 	ByCommitContent(sha Commit) (*Manifest, error)
+	// ByCommitAtConfig creates the manifest for the specified commit
+	// interpreted with the module specs and the repository
+	// configuration of configCommit.
+	ByCommitAtConfig(sha, configCommit Commit) (*Manifest, error)
 	// ByBranch creates the manifest for the specified branch
 	ByBranch(name string) (*Manifest, error)
 	// ByCurrentBranch creates the manifest for the current branch
@@ -214,7 +579,10 @@ type ProcessManager interface {
 	// Exec runs an external command in the context of a module in a manifest.
 	// Following actions are performed prior to executing the command:
 	// - Current working directory of the target process is set to module path
+	//   (or options.WorkingDir within it)
 	// - Initialises important information in the target process environment
+	// module is nil for the commands of the whole build (e.g. setup hooks),
+	// which are executed in the repository root.
 	Exec(manifest *Manifest, module *Module, options *CmdOptions, command string, args ...string) error
 }
 
@@ -223,7 +591,9 @@ type ProcessManager interface {
 // CmdStage is an enum to indicate various stages of a command.
 type CmdStage = int
 
-// BuildSummary is a summary of a successful build.
+// BuildSummary is a summary of a build.
+// Build functions return the summary of the modules processed along
+// with the error when a build fails after it started.
 type BuildSummary struct {
 	// Manifest used to trigger the build
 	Manifest *Manifest
@@ -235,12 +605,106 @@ type BuildSummary struct {
 	// Skipped modules due to the unavailability of a build command for
 	// the host platform
 	Skipped []*Module
+	// Plan of the build. Only available when the build is started with
+	// CmdOptions.Plan set, in which case Completed is empty.
+	Plan *BuildPlan
+	// Timings of the modules built in the order they were started.
+	Timings []*ModuleTiming
+	// TestReport summarises the test reports produced by the modules.
+	// Nil if no report was found.
+	TestReport *TestReport
+}
+
+// TestReport is a summary of the test reports aggregated in a build.
+type TestReport struct {
+	// Files is the list of report files aggregated.
+	Files    []string `json:"files"`
+	Suites   int      `json:"suites"`
+	Tests    int      `json:"tests"`
+	Failures int      `json:"failures"`
+	Errors   int      `json:"errors"`
+	Skipped  int      `json:"skipped"`
+}
+
+// ModuleTiming records when a module was processed during a build.
+type ModuleTiming struct {
+	Module *Module
+	// Queued is when the build was queued.
+	Queued time.Time
+	// Ready is when all dependencies of the module were built.
+	// Time between Ready and Started is spent waiting for resources.
+	Ready time.Time
+	// Started is when the build of the module was started.
+	Started time.Time
+	// Finished is when the build of the module was finished.
+	Finished time.Time
+	// Err is the error occurred while processing the module if any.
+	Err error
+}
+
+// BuildPlan describes the commands a build would execute.
+type BuildPlan struct {
+	// Groups of build steps in the order of execution. Steps in a group
+	// do not depend on each other, therefore they could be executed
+	// in parallel.
+	Groups [][]*BuildStep
+}
+
+// BuildStep is a single command execution in a BuildPlan.
+type BuildStep struct {
+	// Module to be built
+	Module *Module
+	// Variant of the module to be built. Nil if the module does not
+	// declare a build matrix.
+	Variant *Variant
+	// Cmd to be executed
+	Cmd *Cmd
+	// Expanded is Cmd with the templates in its command and arguments
+	// expanded. Values of secret environment variables are redacted.
+	Expanded *Cmd
+	// Env contains the environment variables set by mbt for the command
+	Env []string
+	// Outcome is the predicted status of the module if it is not
+	// built (ModuleStatusResumed, ModuleStatusSatisfied,
+	// ModuleStatusUnaffected or ModuleStatusCached). Empty if the
+	// module is built or the outcome was not predicted.
+	Outcome string
 }
 
 // BuildResult is summary for a single module build
 type BuildResult struct {
 	// Module of the build result
 	Module *Module
+	// Variant of the module built. Nil if the module does not
+	// declare a build matrix.
+	Variant *Variant
+	// Resumed is set when the build was skipped because the module
+	// was already built at the same version in the run being resumed.
+	Resumed bool
+	// Satisfied is set when the build was skipped because the probe of
+	// the module found its artifact.
+	Satisfied bool
+	// Unaffected is set when the build was skipped because neither the
+	// module nor the fingerprints of its dependencies changed since its
+	// last build.
+	Unaffected bool
+	// Cached is set when the build was skipped because the outputs of
+	// the module at the same version were restored from the build cache.
+	Cached bool
+	// Artifacts are the outputs of the module published after the build.
+	Artifacts []*Artifact
+}
+
+// Variant is a single combination of values in a module build matrix.
+type Variant struct {
+	// Name of the variant in the form of key=value pairs separated
+	// by commas (e.g. arch=amd64,env=prod).
+	Name string
+	// Values of the matrix variables for this variant.
+	Values map[string]string
+	// Version is the content based version of the module combined with
+	// the values of this variant.
+	Version string
 }
 
 const (
@@ -264,9 +728,22 @@ type CmdStageCallback func(mod *Module, s CmdStage, err error)
 
 // FilterOptions describe how to filter the modules in a manifest
 type FilterOptions struct {
-	Name       string
-	Fuzzy      bool
+	Name  string
+	Fuzzy bool
+	// Query is an expression selecting modules
+	// (e.g. name =~ "^svc-" && "backend" in tags).
+	// It is applied after the name filter.
+	Query string
+	// Path selects the module containing this path (relative to the
+	// root of the repository), i.e. the module in the closest parent
+	// directory. It is applied after the name filter.
+	Path string
+	// Labels selects the modules with all of these labels
+	// (see Spec.Labels). It is applied after the name filter.
+	Labels     map[string]string
 	Dependents bool
+	// Dependencies includes the modules the selected modules depend on.
+	Dependencies bool
 }
 
 // CmdOptions defines various options required by methods executing
@@ -276,6 +753,173 @@ type CmdOptions struct {
 	Stdout, Stderr io.Writer
 	Callback       CmdStageCallback
 	FailFast       bool
+	// KeepGoing continues building the modules not depending on a
+	// failed module after a failure. Modules depending on a failed
+	// module are not built.
+	KeepGoing bool
+	// Sandbox builds each module in a temporary directory containing
+	// just the module and its file dependencies. Builds relying on
+	// files that are not declared as dependencies fail in this mode.
+	Sandbox bool
+	// ContainerRuntime is the executable used to run the commands of
+	// modules specifying an image (e.g. docker, podman).
+	// Defaults to docker.
+	ContainerRuntime string
+	// Env contains additional environment variables (in key=value form)
+	// for the executed commands.
+	Env []string
+	// Environment is the name of the environment whose overlays are
+	// merged over the module specs (see Spec.Environments).
+	Environment string
+	// Plan computes the build plan without executing any command.
+	Plan bool
+	// Jobs is the maximum number of modules built concurrently.
+	// Modules are built sequentially when this is less than 2.
+	Jobs int
+	// Parallel runs the commands of run-in without waiting for the
+	// dependencies of modules. Jobs defaults to the number of cores
+	// in the machine in this mode.
+	Parallel bool
+	// Exec is the command executed by run-in in each module instead of
+	// the user defined command of the module.
+	Exec *UserCmd
+	// CPULimit is the number of cores available for concurrent builds.
+	// Defaults to the number of cores in the machine.
+	CPULimit int
+	// MemoryLimit is the amount of memory available for concurrent
+	// builds (e.g. 16Gi). Defaults to the physical memory of the machine
+	// where it can be determined.
+	MemoryLimit string
+	// Resume skips the modules successfully built at the same version
+	// in the previous build run.
+	Resume bool
+	// IgnoreProbes builds the modules even if their probes find the
+	// artifacts of their versions (see Spec.Probe).
+	IgnoreProbes bool
+	// IgnoreFingerprints builds the modules even if the fingerprints
+	// of their dependencies did not change (see Spec.Fingerprint).
+	IgnoreFingerprints bool
+	// CacheDir is the directory of the local build cache. Outputs of
+	// the modules built are stored in the cache by version and restored
+	// instead of building a module at the same version again.
+	CacheDir string
+	// CacheRemote is the url of the remote build cache (http://,
+	// https:// or s3://bucket/prefix), shared by the machines building
+	// the repository. It is used after the local cache if both are
+	// specified.
+	CacheRemote string
+	// CacheReadOnly restores the outputs from the build cache without
+	// storing the outputs of the modules built.
+	CacheReadOnly bool
+	// FlakyRetries is the number of times a failed build of a flaky
+	// module is retried.
+	FlakyRetries int
+	// Secrets is a list of values redacted from the output and logs of
+	// the executed commands.
+	Secrets []string
+	// WorkingDir is the directory, relative to the module directory,
+	// where commands are executed. Defaults to the module directory.
+	WorkingDir string
+	// ReportFile is the path of the file where the test reports
+	// produced by the modules (in junit xml format) are merged.
+	ReportFile string
+	// Publish publishes the outputs of each module after it is built
+	// (see Spec.Outputs).
+	Publish bool
+	// Release restricts the build to the modules in the release plan
+	// and exposes their release versions to the commands.
+	Release *ReleasePlan
+	// ProvenanceDir is the directory where the SLSA provenance of each
+	// module built is written (optional).
+	ProvenanceDir string
+	// BuilderID identifies the platform in the provenance. Defaults
+	// to the CI system running mbt or the host.
+	BuilderID string
+	// SignProvenance signs the provenance with cosign (sigstore keyless).
+	SignProvenance bool
+	// SummaryFile is the path of the file where the summary of the
+	// execution (see InvocationSummary) is written in json format.
+	// Summary is written even if the execution fails.
+	SummaryFile string
+	// LogDir is the directory where the output of each module is
+	// written to a file named after the module, in addition to the
+	// output streams.
+	LogDir string
+	// Events receives the structured events of the execution.
+	// When specified, output of the commands is delivered as events
+	// instead of being written to Stdout and Stderr.
+	Events EventHandler
+	// Lifecycle receives the run and module events (but not the output).
+	// Unlike Events, it does not change how the output of the commands
+	// is delivered.
+	Lifecycle EventHandler
+	// Progress receives the typed progress of the execution (see
+	// Progress) for embedding mbt in other programs.
+	Progress ProgressHandler
+	// EventStreams are the endpoints receiving the lifecycle events in
+	// addition to the event streams in the repository config.
+	EventStreams []*EventStream
+	// Context stops the execution when it is done. Modules are not
+	// started afterwards and the processes of the running commands are
+	// terminated. Defaults to the context of the system (see
+	// NewSystemWithContext).
+	Context context.Context
+}
+
+// WatchOptions defines the options for watching the workspace.
+type WatchOptions struct {
+	// Command is the user defined command to run in the impacted
+	// modules. Modules are built if this is empty.
+	Command string
+	// Debounce is the period of time to wait for further changes
+	// before processing the changes detected.
+	Debounce time.Duration
+	// Filter, if set, restricts the impacted modules to the ones it
+	// selects among the modules in the workspace.
+	Filter *FilterOptions
+	// Stop terminates the watch when closed.
+	Stop <-chan struct{}
+	// Callback is invoked at the end of processing a batch of changes
+	// with the impacted modules and the error occurred, if any.
+	Callback func(mods Modules, err error)
+	// Summary, if set, is invoked at the end of processing a batch of
+	// changes with a summary of the batch.
+	Summary func(summary *WatchSummary)
+}
+
+// WatchSummary describes a batch of changes processed by Watch.
+type WatchSummary struct {
+	// Paths changed in the batch, relative to the root of the repository.
+	Paths []string
+	// Modules impacted by the changes, including the modules depending
+	// on them.
+	Modules Modules
+	// Build is the summary of the build of the impacted modules. Nil if
+	// a command was run instead or nothing was impacted.
+	Build *BuildSummary
+	// Elapsed is the time taken to process the batch.
+	Elapsed time.Duration
+	// Err is the error occurred, if any.
+	Err error
+}
+
+// ModuleStats is the build history of a module.
+type ModuleStats struct {
+	// Name of the module
+	Name string `json:"name"`
+	// Builds is the number of build attempts
+	Builds int `json:"builds"`
+	// Failures is the number of failed build attempts
+	Failures int `json:"failures"`
+	// Retries is the number of build attempts made by retrying a
+	// failed build of a flaky module
+	Retries int `json:"retries"`
+	// Flakes is the number of times a build succeeded after failing
+	// at the same version
+	Flakes int `json:"flakes"`
+	// LastFailedVersion is the version of the module at its last failed
+	// build. Cleared when a build of that version succeeds.
+	LastFailedVersion string `json:"lastFailedVersion,omitempty"`
 }
 
 // CmdFailure contains the failures occurred while running a user defined command.
@@ -290,6 +934,8 @@ type RunResult struct {
 	Completed []*Module
 	Skipped   []*Module
 	Failures  []*CmdFailure
+	// Timings of the modules where the command was executed.
+	Timings []*ModuleTiming
 }
 
 // System is the interface used by users to invoke the core functionality
@@ -311,6 +957,32 @@ type System interface {
 	// Template is retrieved from the current workspace.
 	ApplyLocal(templatePath string, output io.Writer) error
 
+	// ApplyBranchWithOptions is ApplyBranch with the output and the
+	// modules to render the template for specified in options.
+	ApplyBranchWithOptions(templatePath, branch string, options *ApplyOptions) error
+
+	// ApplyCommitWithOptions is ApplyCommit with the output and the
+	// modules to render the template for specified in options.
+	ApplyCommitWithOptions(sha, templatePath string, options *ApplyOptions) error
+
+	// ApplyHeadWithOptions is ApplyHead with the output and the
+	// modules to render the template for specified in options.
+	ApplyHeadWithOptions(templatePath string, options *ApplyOptions) error
+
+	// ApplyLocalWithOptions is ApplyLocal with the output and the
+	// modules to render the template for specified in options.
+	ApplyLocalWithOptions(templatePath string, options *ApplyOptions) error
+
+	// ApplyDiff renders the template for each module changed between
+	// two commits at both commits and writes the unified diff of the
+	// output to the specified writer.
+	ApplyDiff(from, to, templatePath string, options *ApplyOptions, output io.Writer) error
+
+	// ApplyKubernetes renders the template at the to commit for each
+	// module changed between two commits and applies the output of
+	// each module to a kubernetes cluster with server-side apply.
+	ApplyKubernetes(from, to, templatePath string, options *ApplyOptions, kubernetes *KubernetesOptions) error
+
 	// BuildBranch builds the specified branch.
 	// This function accepts FilterOptions to specify which modules to be built
 	// within that branch.
@@ -369,6 +1041,11 @@ type System interface {
 	// ManifestByCommitContent creates the manifest for the content in specified commit
 	ManifestByCommitContent(sha string) (*Manifest, error)
 
+	// ManifestByCommitAtConfig creates the manifest for the specified
+	// commit as the module specs and the repository configuration of
+	// configSha would describe it
+	ManifestByCommitAtConfig(sha, configSha string) (*Manifest, error)
+
 	// ByBranch creates the manifest for the specified branch
 	ManifestByBranch(name string) (*Manifest, error)
 
@@ -413,6 +1090,124 @@ type System interface {
 
 	// RunInWorkspaceChanges runs a command in modules modified in workspace.
 	RunInWorkspaceChanges(command string, options *CmdOptions) (*RunResult, error)
+
+	// Migrate runs a command in the dependents of a module in topological
+	// order, stopping at the first failure.
+	// Runs in the workspace so that the changes made by the command can be
+	// reviewed and committed. Report is returned along with the error when
+	// cancelled.
+	Migrate(migrateOptions *MigrateOptions, options *CmdOptions) (*MigrationReport, error)
+	// Watch monitors the workspace and builds the modules impacted by
+	// the changes (or runs a user defined command in them).
+	// Blocks until watchOptions.Stop is closed.
+	Watch(watchOptions *WatchOptions, options *CmdOptions) error
+	// FlakyModules returns the build history of modules that have failed
+	// to build, ordered by the number of flakes and failures.
+	FlakyModules() ([]*ModuleStats, error)
+	// LastBuilt returns the time of the last successful build of each
+	// module keyed by module name.
+	LastBuilt() (map[string]time.Time, error)
+	// DurationStats summarises the durations of modules recorded in the
+	// history (see MetricsConfig.History).
+	DurationStats(options *DurationStatsOptions) ([]*DurationStats, error)
+	// ImportManifest reads a manifest written with Manifest.Export.
+	// Subsequent operations use the imported manifest instead of
+	// discovering the modules in the repository.
+	ImportManifest(r io.Reader) error
+	// GenerateSBOM writes the software bill of materials of each module
+	// in the manifest. Commit of the manifest is checked out unless it
+	// is a manifest of the workspace.
+	GenerateSBOM(m *Manifest, options *SBOMOptions) error
+	// Changelog returns the commits changing a module or its file
+	// dependencies between two revisions (e.g. branches, tags or shas).
+	// Current branch is used if to is empty and the entire history of
+	// to is considered if from is empty.
+	Changelog(module, from, to string) (*Changelog, error)
+	// Issues returns the keys of the issues referenced in the messages
+	// of the commits between two revisions for each module changed by
+	// them directly or through its dependencies.
+	Issues(from, to string) (map[string][]string, error)
+	// Affected returns the modules affected by the changes between the
+	// merge base of base and head revisions and head for a purpose
+	// (builds, tests, deploys or a purpose declared in the repository
+	// configuration).
+	Affected(base, head, purpose string) (*Manifest, error)
+	// HealthReport returns the statistics of the modules in the current
+	// branch.
+	HealthReport(options *HealthReportOptions) (*HealthReport, error)
+	// Completions returns the candidates for completing module names,
+	// tags and refs in a shell.
+	Completions() (*Completions, error)
+	// AnalyseHistory reports the churn of modules and the modules
+	// frequently changed together in a range of history.
+	AnalyseHistory(options *HistoryOptions) (*HistoryAnalysis, error)
+	// Ownership compares the owners of modules with the primary
+	// contributors of the modules in a range of history.
+	Ownership(options *OwnershipOptions) (*OwnershipReport, error)
+	// PRReport reports the modules affected by the changes between the
+	// merge base of base and head, and head. summary is the outcome of
+	// the build of the changes and it is optional.
+	PRReport(base, head string, summary *InvocationSummary) (*PRReport, error)
+	// TerraformPlan lists the Terraform stacks affected by the changes
+	// between the merge base of base and head, and head.
+	TerraformPlan(base, head string, options *TerraformOptions) (*TerraformPlan, error)
+	// Serve answers the queries about the modules in the repository
+	// over http until options.Stop is closed.
+	Serve(options *ServeOptions) error
+	// LockPlugins pins the plugins in the repository configuration to
+	// the executables found, in .mbt/plugins.lock.
+	LockPlugins() (*PluginLock, error)
+	// FormatSpecs rewrites the specs in the workspace in the canonical
+	// form. Specs are not changed when check is set.
+	FormatSpecs(check bool) ([]*FormatResult, error)
+	// Pins returns the modules pinned in .mbt/pins.lock of the workspace.
+	Pins() (*PinLock, error)
+	// Pin fixes the version of a module in .mbt/pins.lock.
+	Pin(module string, options *PinOptions) (*PinnedModule, error)
+	// Unpin removes the pin of a module from .mbt/pins.lock.
+	Unpin(module string) error
+	// ServeStdio answers the same queries as Serve as JSON-RPC 2.0
+	// requests read from in, framed like the messages of language
+	// servers, until in is closed or exit is received.
+	ServeStdio(in io.Reader, out io.Writer) error
+	// ReleasePlan groups the modules changed since the previous release
+	// into a release train.
+	ReleasePlan(options *ReleaseOptions) (*ReleasePlan, error)
+	// MergeQueue suggests the batches of the changes in heads that can
+	// be merged into base together.
+	MergeQueue(base string, heads []string) (*MergeQueueAdvice, error)
+	// Import writes the specs of the modules created from the packages
+	// of another build system.
+	Import(options *ImportOptions) ([]*ImportedModule, error)
+	// CacheStats describes the caches of the repository and the
+	// repositories cached for the user.
+	CacheStats() ([]*CacheStats, error)
+	// CacheGC removes the entries of the caches not retained by the
+	// policies in options and describes the caches afterwards.
+	CacheGC(options *CacheGCOptions) ([]*CacheStats, error)
+	// CacheClear removes all the entries of the named caches (or of the
+	// discovery, templates, repos and build caches if none is specified)
+	// and describes the entries removed.
+	CacheClear(names []string) ([]*CacheStats, error)
+	// Init writes the repository config and, when detecting, the draft
+	// specs of the modules proposed for an existing repository.
+	Init(options *InitOptions) (*InitReport, error)
+	// InstallHooks installs the git hooks validating the modules
+	// before a commit or a push. Paths of the installed hooks are
+	// returned.
+	InstallHooks(options *HookOptions) ([]string, error)
+	// VerifyPreCommit validates the modules in the workspace.
+	VerifyPreCommit() (*Manifest, error)
+	// VerifyPrePush validates the modules in the commits being pushed.
+	// updates is the list of refs being pushed in the format git
+	// passes to the pre-push hook.
+	VerifyPrePush(updates io.Reader) ([]*PushUpdate, error)
+	// Doctor checks the environment and the repository for the issues
+	// preventing mbt from working as expected.
+	Doctor() *DoctorReport
+	// Close releases the resources used by the system and exports the
+	// telemetry recorded.
+	Close() error
 }
 
 type stdSystem struct {
@@ -423,21 +1218,51 @@ type stdSystem struct {
 	Reducer          Reducer
 	WorkspaceManager WorkspaceManager
 	ProcessManager   ProcessManager
+	tracer           *tracer
+	ctx              context.Context
 }
 
 // NewSystem creates a new instance of core mbt system
 func NewSystem(path string, logLevel int) (System, error) {
+	return NewSystemWithContext(context.Background(), path, logLevel)
+}
+
+// NewSystemWithContext creates a new instance of core mbt system that
+// stops discovering modules, diffing and executing commands when the
+// specified context is done.
+// Progress of hashing files is reported to the HashProgress of the
+// context if any (see WithHashProgress). Repository is opened with the
+// backend of the context if any (see WithRepoBackend) and modules are
+// discovered without the discovery cache if the context specifies so
+// (see WithoutDiscoveryCache).
+func NewSystemWithContext(ctx context.Context, path string, logLevel int) (System, error) {
 	log := NewStdLog(logLevel)
-	repo, err := NewLibgitRepo(path, log)
+	var repo Repo
+	repo, err := openRepo(path, repoBackendFrom(ctx), log)
 	if err != nil {
 		return nil, err
 	}
+	repo = &cancellableRepo{Repo: repo, ctx: ctx}
+
+	t := newTracerFromEnv(log)
+	if t != nil {
+		repo = &tracingRepo{Repo: repo, tracer: t}
+	}
+
 	discover := NewDiscover(repo, log)
+	discover.(*stdDiscover).progress = hashProgressFrom(ctx)
+	discover.(*stdDiscover).noCache = noDiscoveryCacheFrom(ctx)
 	reducer := NewReducer(log)
 	mb := NewManifestBuilder(repo, reducer, discover, log)
+	if t != nil {
+		mb = &tracingManifestBuilder{ManifestBuilder: mb, tracer: t}
+	}
 	wm := NewWorkspaceManager(log, repo)
 	pm := NewProcessManager(log)
-	return initSystem(log, repo, mb, discover, reducer, wm, pm), nil
+	s := initSystem(log, repo, mb, discover, reducer, wm, pm)
+	s.(*stdSystem).tracer = t
+	s.(*stdSystem).ctx = ctx
+	return s, nil
 }
 
 // NoFilter is built-in filter that represents no filtering
@@ -472,6 +1297,7 @@ func initSystem(log Log, repo Repo, mb ManifestBuilder, discover Discover, reduc
 		Reducer:          reducer,
 		WorkspaceManager: workspaceManager,
 		ProcessManager:   processManager,
+		ctx:              context.Background(),
 	}
 }
 
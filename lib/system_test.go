@@ -18,6 +18,7 @@ package lib
 import (
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/mbtproject/mbt/e"
@@ -35,3 +36,36 @@ func TestNewSystemForNonGitRepo(t *testing.T) {
 	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
 	assert.Nil(t, repo)
 }
+
+func TestNewSystemWithUnknownRepoBackend(t *testing.T) {
+	clean()
+	NewTestRepo(t, ".tmp/repo")
+	os.Setenv(repoBackendEnv, "foo")
+	defer os.Unsetenv(repoBackendEnv)
+
+	repo, err := NewSystem(".tmp/repo", LogLevelNormal)
+
+	assert.EqualError(t, err, fmt.Sprintf(msgUnknownRepoBackend, "foo", strings.Join([]string{RepoBackendGit, RepoBackendGoGit, RepoBackendMercurial, RepoBackendLibgit2, RepoBackendSapling}, ", ")))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+	assert.Nil(t, repo)
+}
+
+func TestNewSystemWithRegisteredRepoBackend(t *testing.T) {
+	clean()
+	NewTestRepo(t, ".tmp/repo")
+	opened := ""
+	RegisterRepoBackend("test", func(path string, log Log) (Repo, error) {
+		opened = path
+		return NewLibgitRepo(path, log)
+	})
+	defer delete(repoBackends, "test")
+	os.Setenv(repoBackendEnv, "test")
+	defer os.Unsetenv(repoBackendEnv)
+
+	s, err := NewSystem(".tmp/repo", LogLevelNormal)
+	check(t, err)
+	defer s.Close()
+
+	assert.Equal(t, ".tmp/repo", opened)
+	assert.Equal(t, []string{RepoBackendGit, RepoBackendGoGit, RepoBackendMercurial, RepoBackendLibgit2, RepoBackendSapling, "test"}, RepoBackends())
+}
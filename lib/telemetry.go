@@ -0,0 +1,429 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	otlpTimeout        = 10 * time.Second
+	defaultServiceName = "mbt"
+	// OTLP status codes.
+	otlpStatusOk    = 1
+	otlpStatusError = 2
+	// OTLP span kind internal.
+	otlpSpanKindInternal = 1
+)
+
+// tracer records the spans of an invocation and exports them to an
+// OpenTelemetry collector using OTLP over http with json encoding.
+// All spans belong to a single trace rooted at the span of the
+// invocation. A nil tracer does not record anything.
+type tracer struct {
+	mu       sync.Mutex
+	log      Log
+	endpoint string
+	headers  map[string]string
+	service  string
+	traceID  string
+	root     *span
+	stack    []*span
+	spans    []*span
+	client   *http.Client
+}
+
+type span struct {
+	tracer   *tracer
+	id       string
+	parentID string
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    map[string]interface{}
+	err      error
+}
+
+// newTracerFromEnv creates a tracer configured with the standard
+// OpenTelemetry environment variables. Returns nil if an OTLP endpoint
+// is not configured.
+// Trace is continued from TRACEPARENT environment variable if specified,
+// so that the spans are linked to the CI pipeline invoking mbt.
+func newTracerFromEnv(log Log) *tracer {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if endpoint == "" {
+			return nil
+		}
+		endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+
+	if p := os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"); p != "" && p != "http/json" {
+		log.Warnf(msgUnsupportedOtlpProtocol, p)
+		return nil
+	}
+
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = defaultServiceName
+	}
+
+	headers := make(map[string]string)
+	for _, h := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		p := strings.SplitN(h, "=", 2)
+		if len(p) == 2 {
+			headers[strings.TrimSpace(p[0])] = strings.TrimSpace(p[1])
+		}
+	}
+
+	t := &tracer{
+		log:      log,
+		endpoint: endpoint,
+		headers:  headers,
+		service:  service,
+		traceID:  randomID(16),
+		client:   &http.Client{Timeout: otlpTimeout},
+	}
+
+	parentID := ""
+	// traceparent is in the form version-traceid-spanid-flags.
+	if p := strings.Split(os.Getenv("TRACEPARENT"), "-"); len(p) == 4 && len(p[1]) == 32 && len(p[2]) == 16 {
+		t.traceID, parentID = p[1], p[2]
+	}
+
+	t.root = &span{tracer: t, id: randomID(8), parentID: parentID, name: "mbt", start: time.Now()}
+	t.stack = []*span{t.root}
+	return t
+}
+
+// start starts a span as a child of the innermost span in progress.
+func (t *tracer) start(name string, attrs map[string]interface{}) *span {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := &span{
+		tracer:   t,
+		id:       randomID(8),
+		parentID: t.stack[len(t.stack)-1].id,
+		name:     name,
+		start:    time.Now(),
+		attrs:    attrs,
+	}
+	t.stack = append(t.stack, s)
+	return s
+}
+
+// record records a completed span.
+func (t *tracer) record(name string, parent *span, start, end time.Time, attrs map[string]interface{}, err error) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.spans = append(t.spans, &span{
+		tracer:   t,
+		id:       randomID(8),
+		parentID: parent.id,
+		name:     name,
+		start:    start,
+		end:      end,
+		attrs:    attrs,
+		err:      err,
+	})
+}
+
+// finish ends a span started with tracer.start.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+
+	t := s.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s.end = time.Now()
+	s.err = err
+	for i := len(t.stack) - 1; i > 0; i-- {
+		if t.stack[i] == s {
+			t.stack = append(t.stack[:i], t.stack[i+1:]...)
+			break
+		}
+	}
+	t.spans = append(t.spans, s)
+}
+
+// flush ends the root span and exports all recorded spans.
+// Failure to export is logged, since it should not fail the invocation.
+func (t *tracer) flush() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	if t.root.end.IsZero() {
+		t.root.end = time.Now()
+		t.spans = append(t.spans, t.root)
+	}
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+
+	if err := t.export(spans); err != nil {
+		t.log.Warnf(msgFailedExportTelemetry, t.endpoint, err)
+	}
+}
+
+func (t *tracer) export(spans []*span) error {
+	otlpSpans := make([]map[string]interface{}, 0, len(spans))
+	for _, s := range spans {
+		status := map[string]interface{}{"code": otlpStatusOk}
+		if s.err != nil {
+			status = map[string]interface{}{"code": otlpStatusError, "message": s.err.Error()}
+		}
+
+		v := map[string]interface{}{
+			"traceId":           t.traceID,
+			"spanId":            s.id,
+			"name":              s.name,
+			"kind":              otlpSpanKindInternal,
+			"startTimeUnixNano": fmt.Sprintf("%d", s.start.UnixNano()),
+			"endTimeUnixNano":   fmt.Sprintf("%d", s.end.UnixNano()),
+			"attributes":        otlpAttributes(s.attrs),
+			"status":            status,
+		}
+		if s.parentID != "" {
+			v["parentSpanId"] = s.parentID
+		}
+		otlpSpans = append(otlpSpans, v)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]interface{}{"service.name": t.service}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "mbt"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected response %s", res.Status)
+	}
+
+	return nil
+}
+
+func otlpAttributes(attrs map[string]interface{}) []interface{} {
+	l := make([]interface{}, 0, len(attrs))
+	for k, v := range attrs {
+		var value map[string]interface{}
+		switch tv := v.(type) {
+		case bool:
+			value = map[string]interface{}{"boolValue": tv}
+		case int:
+			value = map[string]interface{}{"intValue": fmt.Sprintf("%d", tv)}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprintf("%v", tv)}
+		}
+		l = append(l, map[string]interface{}{"key": k, "value": value})
+	}
+	return l
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	// crypto/rand.Read does not fail on supported platforms.
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *stdSystem) Close() error {
+	s.tracer.flush()
+	return nil
+}
+
+// tracingRepo records the diff operations of a repo as spans.
+type tracingRepo struct {
+	Repo
+	tracer *tracer
+}
+
+func (r *tracingRepo) Diff(a, b Commit) ([]*DiffDelta, error) {
+	s := r.tracer.start("diff", map[string]interface{}{"mbt.from": a.ID(), "mbt.to": b.ID()})
+	d, err := r.Repo.Diff(a, b)
+	s.finish(err)
+	return d, err
+}
+
+func (r *tracingRepo) DiffMergeBase(from, to Commit) ([]*DiffDelta, error) {
+	s := r.tracer.start("diff merge base", map[string]interface{}{"mbt.from": from.ID(), "mbt.to": to.ID()})
+	d, err := r.Repo.DiffMergeBase(from, to)
+	s.finish(err)
+	return d, err
+}
+
+func (r *tracingRepo) DiffWorkspace() ([]*DiffDelta, error) {
+	s := r.tracer.start("diff workspace", nil)
+	d, err := r.Repo.DiffWorkspace()
+	s.finish(err)
+	return d, err
+}
+
+func (r *tracingRepo) Changes(c Commit) ([]*DiffDelta, error) {
+	s := r.tracer.start("changes", map[string]interface{}{"mbt.commit": c.ID()})
+	d, err := r.Repo.Changes(c)
+	s.finish(err)
+	return d, err
+}
+
+// tracingManifestBuilder records the construction of manifests as spans.
+type tracingManifestBuilder struct {
+	ManifestBuilder
+	tracer *tracer
+}
+
+func (b *tracingManifestBuilder) trace(kind string, attrs map[string]interface{}, fn func() (*Manifest, error)) (*Manifest, error) {
+	if attrs == nil {
+		attrs = make(map[string]interface{})
+	}
+	attrs["mbt.manifest.kind"] = kind
+	s := b.tracer.start("manifest", attrs)
+	m, err := fn()
+	if err == nil {
+		s.attrs["mbt.commit"] = m.Sha
+		s.attrs["mbt.manifest.modules"] = len(m.Modules)
+	}
+	s.finish(err)
+	return m, err
+}
+
+func (b *tracingManifestBuilder) ByDiff(from, to Commit) (*Manifest, error) {
+	return b.trace("diff", map[string]interface{}{"mbt.from": from.ID(), "mbt.to": to.ID()}, func() (*Manifest, error) {
+		return b.ManifestBuilder.ByDiff(from, to)
+	})
+}
+
+func (b *tracingManifestBuilder) ByPr(src, dst string) (*Manifest, error) {
+	return b.trace("pr", map[string]interface{}{"mbt.src": src, "mbt.dst": dst}, func() (*Manifest, error) {
+		return b.ManifestBuilder.ByPr(src, dst)
+	})
+}
+
+func (b *tracingManifestBuilder) ByCommit(sha Commit) (*Manifest, error) {
+	return b.trace("commit", nil, func() (*Manifest, error) {
+		return b.ManifestBuilder.ByCommit(sha)
+	})
+}
+
+func (b *tracingManifestBuilder) ByCommitContent(sha Commit) (*Manifest, error) {
+	return b.trace("commitContent", nil, func() (*Manifest, error) {
+		return b.ManifestBuilder.ByCommitContent(sha)
+	})
+}
+
+func (b *tracingManifestBuilder) ByCommitAtConfig(sha, configCommit Commit) (*Manifest, error) {
+	return b.trace("commitAtConfig", map[string]interface{}{"mbt.config": configCommit.ID()}, func() (*Manifest, error) {
+		return b.ManifestBuilder.ByCommitAtConfig(sha, configCommit)
+	})
+}
+
+func (b *tracingManifestBuilder) ByBranch(name string) (*Manifest, error) {
+	return b.trace("branch", map[string]interface{}{"mbt.branch": name}, func() (*Manifest, error) {
+		return b.ManifestBuilder.ByBranch(name)
+	})
+}
+
+func (b *tracingManifestBuilder) ByCurrentBranch() (*Manifest, error) {
+	return b.trace("currentBranch", nil, func() (*Manifest, error) {
+		return b.ManifestBuilder.ByCurrentBranch()
+	})
+}
+
+func (b *tracingManifestBuilder) ByWorkspace() (*Manifest, error) {
+	return b.trace("workspace", nil, func() (*Manifest, error) {
+		return b.ManifestBuilder.ByWorkspace()
+	})
+}
+
+func (b *tracingManifestBuilder) ByWorkspaceChanges() (*Manifest, error) {
+	return b.trace("workspaceChanges", nil, func() (*Manifest, error) {
+		return b.ManifestBuilder.ByWorkspaceChanges()
+	})
+}
+
+// traceModules records a span for each module processed in an
+// invocation.
+func (t *tracer) traceModules(parent *span, timings []*ModuleTiming, resumed map[string]bool) {
+	if t == nil {
+		return
+	}
+
+	for _, m := range timings {
+		t.record(m.Module.Name(), parent, m.Started, m.Finished, map[string]interface{}{
+			"mbt.module.name":    m.Module.Name(),
+			"mbt.module.path":    m.Module.Path(),
+			"mbt.module.version": m.Module.Version(),
+			"mbt.cache_hit":      resumed[m.Module.Name()],
+			"mbt.wait_ms":        int(m.Started.Sub(m.Ready) / time.Millisecond),
+		}, m.Err)
+	}
+}
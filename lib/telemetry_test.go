@@ -0,0 +1,172 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type otlpSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Attributes   []struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	} `json:"attributes"`
+	Status struct {
+		Code int `json:"code"`
+	} `json:"status"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []struct {
+		ScopeSpans []struct {
+			Spans []*otlpSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+func startCollector(t *testing.T) (*httptest.Server, chan *otlpRequest) {
+	requests := make(chan *otlpRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("x-token"))
+		body, err := ioutil.ReadAll(r.Body)
+		check(t, err)
+		req := &otlpRequest{}
+		check(t, json.Unmarshal(body, req))
+		requests <- req
+	}))
+
+	os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", server.URL)
+	os.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "x-token=secret")
+	return server, requests
+}
+
+func stopCollector(server *httptest.Server) {
+	os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	os.Unsetenv("OTEL_EXPORTER_OTLP_HEADERS")
+	server.Close()
+}
+
+func spansByName(req *otlpRequest) map[string]*otlpSpan {
+	m := make(map[string]*otlpSpan)
+	for _, s := range req.ResourceSpans[0].ScopeSpans[0].Spans {
+		m[s.Name] = s
+	}
+	return m
+}
+
+func TestBuildTelemetry(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "true"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.WriteShellScript("app-b/build.sh", "exit 1"))
+	check(t, repo.Commit("first"))
+
+	server, requests := startCollector(t)
+	defer stopCollector(server)
+
+	system, err := NewSystem(".tmp/repo", LogLevelNormal)
+	check(t, err)
+
+	_, err = system.BuildCurrentBranch(NoFilter, stdTestCmdOptions(new(bytes.Buffer)))
+	assert.Error(t, err)
+	check(t, system.Close())
+
+	spans := spansByName(<-requests)
+	root, manifest, build := spans["mbt"], spans["manifest"], spans["build"]
+	a, b := spans["app-a"], spans["app-b"]
+
+	assert.Equal(t, "", root.ParentSpanID)
+	assert.Equal(t, root.SpanID, manifest.ParentSpanID)
+	assert.Equal(t, root.SpanID, build.ParentSpanID)
+	assert.Equal(t, build.SpanID, a.ParentSpanID)
+	assert.Equal(t, build.SpanID, b.ParentSpanID)
+	for _, s := range spans {
+		assert.Equal(t, root.TraceID, s.TraceID)
+	}
+
+	assert.Equal(t, otlpStatusOk, a.Status.Code)
+	assert.Equal(t, otlpStatusError, b.Status.Code)
+	assert.Equal(t, otlpStatusError, build.Status.Code)
+
+	attrs := make(map[string]interface{})
+	for _, attr := range a.Attributes {
+		for _, v := range attr.Value {
+			attrs[attr.Key] = v
+		}
+	}
+	assert.Equal(t, "app-a", attrs["mbt.module.name"])
+	assert.Equal(t, false, attrs["mbt.cache_hit"])
+	assert.NotEmpty(t, attrs["mbt.module.version"])
+}
+
+func TestTelemetryContinuesTraceParent(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	server, requests := startCollector(t)
+	defer stopCollector(server)
+	os.Setenv("TRACEPARENT", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	defer os.Unsetenv("TRACEPARENT")
+
+	system, err := NewSystem(".tmp/repo", LogLevelNormal)
+	check(t, err)
+
+	_, err = system.ManifestByDiff(repo.LastCommit.String(), repo.LastCommit.String())
+	check(t, err)
+	check(t, system.Close())
+
+	spans := spansByName(<-requests)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", spans["mbt"].TraceID)
+	assert.Equal(t, "b7ad6b7169203331", spans["mbt"].ParentSpanID)
+	assert.Equal(t, spans["manifest"].SpanID, spans["diff merge base"].ParentSpanID)
+}
+
+func TestTelemetryIsDisabledByDefault(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	system, err := NewSystem(".tmp/repo", LogLevelNormal)
+	check(t, err)
+
+	assert.Nil(t, system.(*stdSystem).tracer)
+	check(t, system.Close())
+}
@@ -0,0 +1,671 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	yaml "github.com/go-yaml/yaml"
+)
+
+// sprigFuncs returns the template functions compatible with the
+// functions of the same name in sprig (https://masterminds.github.io/sprig)
+// and helm (toYaml, fromYaml).
+// Functions of apply templates predating this set (i.e. contains, join,
+// head and tail) retain their behavior and take precedence over these.
+func sprigFuncs() template.FuncMap {
+	return template.FuncMap{
+		// Strings
+		"trim":       strings.TrimSpace,
+		"trimAll":    func(cutset, s string) string { return strings.Trim(s, cutset) },
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"title":      strings.Title,
+		"repeat":     func(n int, s string) string { return strings.Repeat(s, n) },
+		"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"substr":     substr,
+		"trunc":      trunc,
+		"nospace":    func(s string) string { return strings.Map(dropSpace, s) },
+		"snakecase":  func(s string) string { return joinWords(s, "_") },
+		"kebabcase":  func(s string) string { return joinWords(s, "-") },
+		"camelcase":  camelcase,
+		"quote":      quote(`"`),
+		"squote":     quote("'"),
+		"cat":        cat,
+		"indent":     indent,
+		"nindent":    func(n int, s string) string { return "\n" + indent(n, s) },
+		"splitList":  func(sep, s string) []string { return strings.Split(s, sep) },
+		"toString":   toString,
+		"toStrings":  toStrings,
+
+		// Defaults and flow control
+		"default":  func(def interface{}, given ...interface{}) interface{} { return defaultValue(def, given...) },
+		"empty":    empty,
+		"coalesce": coalesce,
+		"ternary":  func(a, b interface{}, c bool) interface{} { return ternary(a, b, c) },
+		"fail":     func(msg string) (string, error) { return "", errors.New(msg) },
+
+		// Numbers
+		"atoi":    func(s string) int { i, _ := strconv.Atoi(s); return i },
+		"int":     func(v interface{}) int { return int(toInt64(v)) },
+		"int64":   toInt64,
+		"float64": toFloat64,
+		"add":     add,
+		"add1":    func(v interface{}) int64 { return toInt64(v) + 1 },
+		"sub":     func(a, b interface{}) int64 { return toInt64(a) - toInt64(b) },
+		"mul":     mul,
+		"div":     func(a, b interface{}) int64 { return toInt64(a) / toInt64(b) },
+		"mod":     func(a, b interface{}) int64 { return toInt64(a) % toInt64(b) },
+		"max":     max,
+		"min":     min,
+		"until":   until,
+
+		// Lists
+		"list":      func(v ...interface{}) []interface{} { return v },
+		"first":     first,
+		"last":      last,
+		"rest":      rest,
+		"initial":   initial,
+		"append":    func(l interface{}, v interface{}) []interface{} { return append(toList(l), v) },
+		"prepend":   func(l interface{}, v interface{}) []interface{} { return append([]interface{}{v}, toList(l)...) },
+		"concat":    concat,
+		"uniq":      uniq,
+		"has":       func(needle interface{}, haystack interface{}) bool { return indexOf(toList(haystack), needle) >= 0 },
+		"without":   without,
+		"compact":   compact,
+		"reverse":   reverse,
+		"sortAlpha": sortAlpha,
+
+		// Dictionaries
+		"dict":   dict,
+		"get":    func(d map[string]interface{}, k string) interface{} { return d[k] },
+		"set":    func(d map[string]interface{}, k string, v interface{}) map[string]interface{} { d[k] = v; return d },
+		"unset":  func(d map[string]interface{}, k string) map[string]interface{} { delete(d, k); return d },
+		"hasKey": func(d map[string]interface{}, k string) bool { _, ok := d[k]; return ok },
+		"keys":   keys,
+		"values": values,
+		"pick":   pick,
+		"omit":   omit,
+		"merge":  merge,
+
+		// Regular expressions
+		"regexMatch": regexMatch,
+		"regexFind": func(r, s string) (string, error) {
+			return regexpString(r, func(re *regexp.Regexp) string { return re.FindString(s) })
+		},
+		"regexFindAll": regexFindAll,
+		"regexReplaceAll": func(r, s, repl string) (string, error) {
+			return regexpString(r, func(re *regexp.Regexp) string { return re.ReplaceAllString(s, repl) })
+		},
+		"regexSplit": regexSplit,
+
+		// Encoding
+		"b64enc":       func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+		"b64dec":       b64dec,
+		"sha1sum":      func(s string) string { h := sha1.Sum([]byte(s)); return hex.EncodeToString(h[:]) },
+		"sha256sum":    func(s string) string { h := sha256.Sum256([]byte(s)); return hex.EncodeToString(h[:]) },
+		"toJson":       toJSON,
+		"toPrettyJson": toPrettyJSON,
+		"fromJson":     fromJSON,
+		"toYaml":       toYAML,
+		"fromYaml":     fromYAML,
+
+		// Environment
+		"env":       os.Getenv,
+		"expandenv": os.ExpandEnv,
+
+		// Semantic versions
+		"semver":        parseTemplateSemver,
+		"semverCompare": semverCompare,
+	}
+}
+
+func substr(start, end int, s string) string {
+	if start < 0 {
+		return s[:end]
+	}
+	if end < 0 || end > len(s) {
+		return s[start:]
+	}
+	return s[start:end]
+}
+
+func trunc(n int, s string) string {
+	if n < 0 && len(s)+n > 0 {
+		return s[len(s)+n:]
+	}
+	if n >= 0 && len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+func dropSpace(r rune) rune {
+	if unicode.IsSpace(r) {
+		return -1
+	}
+	return r
+}
+
+// words splits s into words at the boundaries of case changes and
+// non alphanumeric characters.
+func words(s string) []string {
+	r := make([]string, 0)
+	current := make([]rune, 0)
+	var prev rune
+	for _, c := range s {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			if len(current) > 0 {
+				r = append(r, string(current))
+				current = current[:0]
+			}
+		} else {
+			if unicode.IsUpper(c) && len(current) > 0 && !unicode.IsUpper(prev) {
+				r = append(r, string(current))
+				current = current[:0]
+			}
+			current = append(current, c)
+		}
+		prev = c
+	}
+	if len(current) > 0 {
+		r = append(r, string(current))
+	}
+	return r
+}
+
+func joinWords(s, sep string) string {
+	w := words(s)
+	for i := range w {
+		w[i] = strings.ToLower(w[i])
+	}
+	return strings.Join(w, sep)
+}
+
+func camelcase(s string) string {
+	w := words(s)
+	for i := range w {
+		w[i] = strings.Title(strings.ToLower(w[i]))
+	}
+	return strings.Join(w, "")
+}
+
+func quote(q string) func(v ...interface{}) string {
+	return func(v ...interface{}) string {
+		r := make([]string, 0, len(v))
+		for _, i := range v {
+			if i != nil {
+				r = append(r, q+toString(i)+q)
+			}
+		}
+		return strings.Join(r, " ")
+	}
+}
+
+func cat(v ...interface{}) string {
+	r := make([]string, 0, len(v))
+	for _, i := range v {
+		if i != nil {
+			r = append(r, toString(i))
+		}
+	}
+	return strings.Join(r, " ")
+}
+
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	case error:
+		return s.Error()
+	case fmt.Stringer:
+		return s.String()
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func toStrings(v interface{}) []string {
+	l := toList(v)
+	r := make([]string, 0, len(l))
+	for _, i := range l {
+		r = append(r, toString(i))
+	}
+	return r
+}
+
+// empty returns true if v is the zero value of its type.
+func empty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Slice, reflect.Map, reflect.String:
+		return rv.Len() == 0
+	case reflect.Bool:
+		return !rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	default:
+		return false
+	}
+}
+
+func defaultValue(def interface{}, given ...interface{}) interface{} {
+	if len(given) == 0 || empty(given[0]) {
+		return def
+	}
+	return given[0]
+}
+
+func coalesce(v ...interface{}) interface{} {
+	for _, i := range v {
+		if !empty(i) {
+			return i
+		}
+	}
+	return nil
+}
+
+func ternary(a, b interface{}, c bool) interface{} {
+	if c {
+		return a
+	}
+	return b
+}
+
+func toInt64(v interface{}) int64 {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return int64(rv.Float())
+	case reflect.Bool:
+		if rv.Bool() {
+			return 1
+		}
+		return 0
+	case reflect.String:
+		i, _ := strconv.ParseInt(rv.String(), 10, 64)
+		return i
+	default:
+		return 0
+	}
+}
+
+func toFloat64(v interface{}) float64 {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		f, _ := strconv.ParseFloat(rv.String(), 64)
+		return f
+	default:
+		return float64(toInt64(v))
+	}
+}
+
+func add(v ...interface{}) int64 {
+	var r int64
+	for _, i := range v {
+		r += toInt64(i)
+	}
+	return r
+}
+
+func mul(a interface{}, v ...interface{}) int64 {
+	r := toInt64(a)
+	for _, i := range v {
+		r *= toInt64(i)
+	}
+	return r
+}
+
+func max(a interface{}, v ...interface{}) int64 {
+	r := toInt64(a)
+	for _, i := range v {
+		if n := toInt64(i); n > r {
+			r = n
+		}
+	}
+	return r
+}
+
+func min(a interface{}, v ...interface{}) int64 {
+	r := toInt64(a)
+	for _, i := range v {
+		if n := toInt64(i); n < r {
+			r = n
+		}
+	}
+	return r
+}
+
+func until(n int) []int {
+	r := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		r = append(r, i)
+	}
+	return r
+}
+
+// toList converts an array or a slice of any type to []interface{}.
+// nil is returned for other values.
+func toList(v interface{}) []interface{} {
+	if v == nil {
+		return nil
+	}
+
+	if l, ok := v.([]interface{}); ok {
+		return l
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Array && rv.Kind() != reflect.Slice {
+		return nil
+	}
+
+	l := make([]interface{}, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		l = append(l, rv.Index(i).Interface())
+	}
+	return l
+}
+
+func first(v interface{}) interface{} {
+	l := toList(v)
+	if len(l) == 0 {
+		return nil
+	}
+	return l[0]
+}
+
+func last(v interface{}) interface{} {
+	l := toList(v)
+	if len(l) == 0 {
+		return nil
+	}
+	return l[len(l)-1]
+}
+
+func rest(v interface{}) []interface{} {
+	l := toList(v)
+	if len(l) == 0 {
+		return l
+	}
+	return l[1:]
+}
+
+func initial(v interface{}) []interface{} {
+	l := toList(v)
+	if len(l) == 0 {
+		return l
+	}
+	return l[:len(l)-1]
+}
+
+func concat(v ...interface{}) []interface{} {
+	r := make([]interface{}, 0)
+	for _, l := range v {
+		r = append(r, toList(l)...)
+	}
+	return r
+}
+
+func indexOf(l []interface{}, v interface{}) int {
+	for i, item := range l {
+		if reflect.DeepEqual(item, v) {
+			return i
+		}
+	}
+	return -1
+}
+
+func uniq(v interface{}) []interface{} {
+	r := make([]interface{}, 0)
+	for _, i := range toList(v) {
+		if indexOf(r, i) < 0 {
+			r = append(r, i)
+		}
+	}
+	return r
+}
+
+func without(v interface{}, omit ...interface{}) []interface{} {
+	r := make([]interface{}, 0)
+	for _, i := range toList(v) {
+		if indexOf(omit, i) < 0 {
+			r = append(r, i)
+		}
+	}
+	return r
+}
+
+func compact(v interface{}) []interface{} {
+	r := make([]interface{}, 0)
+	for _, i := range toList(v) {
+		if !empty(i) {
+			r = append(r, i)
+		}
+	}
+	return r
+}
+
+func reverse(v interface{}) []interface{} {
+	l := toList(v)
+	r := make([]interface{}, len(l))
+	for i, item := range l {
+		r[len(l)-i-1] = item
+	}
+	return r
+}
+
+func sortAlpha(v interface{}) []string {
+	r := toStrings(v)
+	sort.Strings(r)
+	return r
+}
+
+func dict(v ...interface{}) map[string]interface{} {
+	d := make(map[string]interface{})
+	for i := 0; i < len(v); i += 2 {
+		var value interface{}
+		if i+1 < len(v) {
+			value = v[i+1]
+		}
+		d[toString(v[i])] = value
+	}
+	return d
+}
+
+// keys returns the keys of the dictionaries in sorted order.
+func keys(dicts ...map[string]interface{}) []string {
+	r := make([]string, 0)
+	for _, d := range dicts {
+		for k := range d {
+			r = append(r, k)
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
+// values returns the values of a dictionary ordered by the keys.
+func values(d map[string]interface{}) []interface{} {
+	r := make([]interface{}, 0, len(d))
+	for _, k := range keys(d) {
+		r = append(r, d[k])
+	}
+	return r
+}
+
+func pick(d map[string]interface{}, k ...string) map[string]interface{} {
+	r := make(map[string]interface{})
+	for _, key := range k {
+		if v, ok := d[key]; ok {
+			r[key] = v
+		}
+	}
+	return r
+}
+
+func omit(d map[string]interface{}, k ...string) map[string]interface{} {
+	omitted := make(map[string]bool)
+	for _, key := range k {
+		omitted[key] = true
+	}
+
+	r := make(map[string]interface{})
+	for key, v := range d {
+		if !omitted[key] {
+			r[key] = v
+		}
+	}
+	return r
+}
+
+// merge merges the source dictionaries into dst. Existing keys of dst
+// are not overwritten, nested dictionaries are merged recursively.
+func merge(dst map[string]interface{}, srcs ...map[string]interface{}) map[string]interface{} {
+	for _, src := range srcs {
+		for k, v := range src {
+			existing, ok := dst[k]
+			if !ok {
+				dst[k] = v
+				continue
+			}
+
+			dm, dok := existing.(map[string]interface{})
+			sm, sok := v.(map[string]interface{})
+			if dok && sok {
+				merge(dm, sm)
+			}
+		}
+	}
+	return dst
+}
+
+func regexMatch(r, s string) (bool, error) {
+	re, err := regexp.Compile(r)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(s), nil
+}
+
+func regexpString(r string, f func(*regexp.Regexp) string) (string, error) {
+	re, err := regexp.Compile(r)
+	if err != nil {
+		return "", err
+	}
+	return f(re), nil
+}
+
+func regexFindAll(r, s string, n int) ([]string, error) {
+	re, err := regexp.Compile(r)
+	if err != nil {
+		return nil, err
+	}
+	return re.FindAllString(s, n), nil
+}
+
+func regexSplit(r, s string, n int) ([]string, error) {
+	re, err := regexp.Compile(r)
+	if err != nil {
+		return nil, err
+	}
+	return re.Split(s, n), nil
+}
+
+func b64dec(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func toPrettyJSON(v interface{}) (string, error) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	return string(b), err
+}
+
+func fromJSON(s string) (interface{}, error) {
+	var v interface{}
+	err := json.Unmarshal([]byte(s), &v)
+	return v, err
+}
+
+// toYAML encodes v in yaml without the trailing new line, so that it
+// can be used with indent and nindent.
+func toYAML(v interface{}) (string, error) {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSuffix(b, []byte("\n"))), nil
+}
+
+func fromYAML(s string) (interface{}, error) {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		return nil, err
+	}
+	return transformIfRequired(v)
+}
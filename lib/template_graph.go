@@ -0,0 +1,110 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// tagsProperty is the module property listing the tags of a module.
+const tagsProperty = "tags"
+
+// graphFuncs returns the template functions to query the dependency
+// graph of modules. Lists of modules returned by these functions are
+// in the order modules are built, that is a module appears after its
+// dependencies.
+func graphFuncs(modules Modules, strict bool) template.FuncMap {
+	index := modules.indexByName()
+	lookup := func(name string) (*Module, error) {
+		if mod, ok := index[name]; ok {
+			return mod, nil
+		}
+
+		if strict {
+			return nil, e.NewErrorf(ErrClassUser, msgModuleNotFound, name)
+		}
+
+		return nil, nil
+	}
+
+	return template.FuncMap{
+		"dependenciesOf": func(name string) (Modules, error) {
+			mod, err := lookup(name)
+			if mod == nil {
+				return Modules{}, err
+			}
+
+			all, err := Modules{mod}.expandRequiresDependencies()
+			if err != nil {
+				return nil, err
+			}
+
+			return all[:len(all)-1], nil
+		},
+		"dependentsOf": func(name string) (Modules, error) {
+			mod, err := lookup(name)
+			if mod == nil {
+				return Modules{}, err
+			}
+
+			all, err := Modules{mod}.expandRequiredByDependencies()
+			if err != nil {
+				return nil, err
+			}
+
+			return all[1:], nil
+		},
+		"topSorted": func() (Modules, error) {
+			return modules.expandRequiresDependencies()
+		},
+		"withTag": func(tag string, l Modules) Modules {
+			return l.where(func(mod *Module) bool {
+				tags, ok := mod.Properties()[tagsProperty].([]interface{})
+				if !ok {
+					return false
+				}
+
+				for _, t := range tags {
+					if t == tag {
+						return true
+					}
+				}
+
+				return false
+			})
+		},
+		"withProperty": func(name string, value interface{}, l Modules) Modules {
+			return l.where(func(mod *Module) bool {
+				v := resolveProperty(mod.Properties(), strings.Split(name, "."), missingProperty{})
+				return reflect.DeepEqual(v, value)
+			})
+		},
+	}
+}
+
+func (l Modules) where(predicate func(*Module) bool) Modules {
+	r := Modules{}
+	for _, mod := range l {
+		if predicate(mod) {
+			r = append(r, mod)
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+var templateFuncName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// withTemplateFuncs returns a copy of options with the functions
+// implemented by the plugins and the executables in config.TemplateFuncs
+// added to options.Funcs. Functions in options.Funcs take precedence
+// followed by config.TemplateFuncs.
+func withTemplateFuncs(options *ApplyOptions, config *RepoConfig, dir string) (*ApplyOptions, error) {
+	if len(config.TemplateFuncs) == 0 && len(config.Plugins) == 0 {
+		return options, nil
+	}
+
+	funcs := make(template.FuncMap)
+	// Results are cached for the duration of apply, so that functions
+	// called with the same arguments for each module are executed once.
+	cache := make(map[string]interface{})
+
+	plugins, err := loadWorkspacePlugins(dir, config)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range pluginsWithHook(plugins, PluginHookTemplateFunc) {
+		for _, name := range p.locked.TemplateFuncs {
+			if !templateFuncName.MatchString(name) {
+				return nil, e.NewErrorf(ErrClassUser, msgInvalidTemplateFuncName, name)
+			}
+			funcs[name] = pluginFunc(p, name, cache)
+		}
+	}
+
+	for name, cmd := range config.TemplateFuncs {
+		if !templateFuncName.MatchString(name) {
+			return nil, e.NewErrorf(ErrClassUser, msgInvalidTemplateFuncName, name)
+		}
+		funcs[name] = externalFunc(name, cmd, dir, cache)
+	}
+
+	for name, f := range options.Funcs {
+		funcs[name] = f
+	}
+
+	r := *options
+	r.Funcs = funcs
+	return &r, nil
+}
+
+// externalFunc creates a template function executing cmd with the
+// arguments of the function encoded as a json array in stdin.
+// Output of the command is parsed as json. Output that is not valid
+// json is returned as a string without the trailing new line.
+func externalFunc(name string, cmd *Cmd, dir string, cache map[string]interface{}) func(args ...interface{}) (interface{}, error) {
+	return func(args ...interface{}) (interface{}, error) {
+		if args == nil {
+			args = []interface{}{}
+		}
+
+		input, err := json.Marshal(args)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgInvalidTemplateFuncArgs, name)
+		}
+
+		key := name + "\x00" + string(input)
+		if v, ok := cache[key]; ok {
+			return v, nil
+		}
+
+		command, cmdArgs, err := shellCommand(cmd.Shell, cmd.Cmd, cmd.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		var stdout, stderr bytes.Buffer
+		c := exec.Command(command, cmdArgs...)
+		c.Dir = filepath.Join(dir, cmd.Dir)
+		c.Env = os.Environ()
+		c.Stdin = bytes.NewReader(input)
+		c.Stdout = &stdout
+		c.Stderr = &stderr
+
+		if err := c.Run(); err != nil {
+			return nil, e.NewErrorf(ErrClassUser, msgFailedTemplateFunc, name, err, strings.TrimSpace(stderr.String()))
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(stdout.Bytes(), &v); err != nil {
+			v = strings.TrimSuffix(strings.TrimSuffix(stdout.String(), "\n"), "\r")
+		}
+
+		cache[key] = v
+		return v, nil
+	}
+}
+
+// pluginFunc creates a template function implemented by a plugin. The
+// plugin receives the name and the arguments of the function and its
+// output is the result.
+func pluginFunc(p *plugin, name string, cache map[string]interface{}) func(args ...interface{}) (interface{}, error) {
+	return func(args ...interface{}) (interface{}, error) {
+		if args == nil {
+			args = []interface{}{}
+		}
+
+		input, err := json.Marshal(args)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgInvalidTemplateFuncArgs, name)
+		}
+
+		key := p.name + "\x00" + name + "\x00" + string(input)
+		if v, ok := cache[key]; ok {
+			return v, nil
+		}
+
+		var v interface{}
+		if err := p.call(PluginHookTemplateFunc, map[string]interface{}{"func": name, "args": args}, &v); err != nil {
+			return nil, err
+		}
+
+		cache[key] = v
+		return v, nil
+	}
+}
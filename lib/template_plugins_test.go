@@ -0,0 +1,119 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyWithTemplateFuncs(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent("template.tmpl", `{{greet .Sha "x"}} {{upper "b"}}`))
+	check(t, repo.Commit("first"))
+
+	output := new(bytes.Buffer)
+	options := writerApplyOptions(output)
+	options.Funcs = template.FuncMap{
+		"greet": func(sha, s string) string { return fmt.Sprintf("hello %s %v", s, sha == repo.LastCommit.String()) },
+		"upper": strings.Repeat,
+	}
+	err := NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("template.tmpl", options)
+
+	// Built-in functions can be replaced.
+	assert.Error(t, err)
+
+	check(t, repo.WriteContent("template.tmpl", `{{greet .Sha "x"}} {{upper "b" 2}}`))
+	check(t, repo.Commit("second"))
+
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("template.tmpl", options))
+	assert.Equal(t, "hello x true bb", output.String())
+}
+
+func TestApplyWithExternalTemplateFuncs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell template functions are not supported on windows")
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{TemplateFuncs: map[string]*Cmd{
+		"args":   {Cmd: `cat; echo x >> calls`, Shell: "sh"},
+		"secret": {Cmd: `echo '{"password": "p"}'`, Shell: "sh"},
+	}}))
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.WriteContent("template.tmpl", `{{args .Module.Name 1 true | toJson}};{{args | toJson}};{{(secret "db").password}}`))
+	check(t, repo.Commit("first"))
+
+	outputs := applyOutputs{}
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("template.tmpl", outputs.options()))
+
+	assert.Equal(t, `["app-a",1,true];[];p`, outputs["app-a"].String())
+	assert.Equal(t, `["app-b",1,true];[];p`, outputs["app-b"].String())
+
+	// Results of calls with the same arguments are reused.
+	calls, err := ioutil.ReadFile(".tmp/repo/calls")
+	check(t, err)
+	assert.Equal(t, "x\nx\nx\n", string(calls))
+}
+
+func TestFailingExternalTemplateFunc(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell template functions are not supported on windows")
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{TemplateFuncs: map[string]*Cmd{
+		"vault": {Cmd: `echo "permission denied" >&2; exit 2`, Shell: "sh"},
+	}}))
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent("template.tmpl", `{{vault "db"}}`))
+	check(t, repo.Commit("first"))
+
+	err := NewWorld(t, ".tmp/repo").System.ApplyHead("template.tmpl", new(bytes.Buffer))
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Template function vault failed (exit status 2):\npermission denied")
+}
+
+func TestInvalidTemplateFuncName(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{TemplateFuncs: map[string]*Cmd{"vault-lookup": {Cmd: "vault"}}}))
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent("template.tmpl", `x`))
+	check(t, repo.Commit("first"))
+
+	err := NewWorld(t, ".tmp/repo").System.ApplyHead("template.tmpl", new(bytes.Buffer))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgInvalidTemplateFuncName, "vault-lookup"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
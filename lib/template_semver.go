@@ -0,0 +1,237 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// templateSemver is the semantic version returned by the semver
+// template function.
+type templateSemver struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+	Metadata   string
+	Original   string
+
+	// parts is the number of numeric components specified in the
+	// original string. It decides the range of ~ constraints.
+	parts int
+}
+
+func (v *templateSemver) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Metadata != "" {
+		s += "+" + v.Metadata
+	}
+	return s
+}
+
+var templateSemverPattern = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?(?:\+([0-9A-Za-z.-]+))?$`)
+
+// parseTemplateSemver parses versions in the form
+// v?MAJOR[.MINOR[.PATCH]][-PRERELEASE][+METADATA].
+// Omitted minor and patch components are zero.
+func parseTemplateSemver(s string) (*templateSemver, error) {
+	m := templateSemverPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return nil, fmt.Errorf("invalid semantic version %q", s)
+	}
+
+	v := &templateSemver{Prerelease: m[4], Metadata: m[5], Original: s}
+	for i, p := range []*int{&v.Major, &v.Minor, &v.Patch} {
+		if m[i+1] == "" {
+			break
+		}
+		*p, _ = strconv.Atoi(m[i+1])
+		v.parts++
+	}
+	return v, nil
+}
+
+// compare returns -1, 0 or 1 when v is lower than, equal to or higher
+// than other. Build metadata is ignored and a pre-release is lower than
+// the release of the same version.
+func (v *templateSemver) compare(other *templateSemver) int {
+	for _, p := range [][2]int{{v.Major, other.Major}, {v.Minor, other.Minor}, {v.Patch, other.Patch}} {
+		if p[0] != p[1] {
+			return compareInts(p[0], p[1])
+		}
+	}
+
+	switch {
+	case v.Prerelease == other.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case other.Prerelease == "":
+		return -1
+	}
+
+	a, b := strings.Split(v.Prerelease, "."), strings.Split(other.Prerelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := comparePrereleaseIdentifiers(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(a), len(b))
+}
+
+// comparePrereleaseIdentifiers compares numeric identifiers numerically,
+// other identifiers lexically and numeric identifiers lower than others.
+func comparePrereleaseIdentifiers(a, b string) int {
+	x, errA := strconv.Atoi(a)
+	y, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return compareInts(x, y)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// next returns the lowest release above every version sharing the first
+// n components of v.
+func (v *templateSemver) next(n int) *templateSemver {
+	switch n {
+	case 1:
+		return &templateSemver{Major: v.Major + 1}
+	case 2:
+		return &templateSemver{Major: v.Major, Minor: v.Minor + 1}
+	}
+	return &templateSemver{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+}
+
+var semverOperators = []string{"!=", ">=", "<=", "=", ">", "<", "~", "^"}
+
+// semverCompare reports whether version satisfies the constraint.
+// A constraint is a list of terms separated by || where any of them
+// must be satisfied. Each term is a list of comparisons separated by
+// commas or spaces, all of which must be satisfied.
+// Comparisons are a version prefixed with one of =, !=, >, <, >=, <=,
+// ~ (patch updates, or minor updates when only the major is specified)
+// or ^ (updates that do not change the left-most non-zero component).
+// A version without an operator must be equal.
+func semverCompare(constraint, version string) (bool, error) {
+	v, err := parseTemplateSemver(version)
+	if err != nil {
+		return false, err
+	}
+
+	for _, term := range strings.Split(constraint, "||") {
+		comparisons, err := parseSemverComparisons(term)
+		if err != nil {
+			return false, err
+		}
+
+		ok := true
+		for _, c := range comparisons {
+			if !c(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func parseSemverComparisons(term string) ([]func(*templateSemver) bool, error) {
+	fields := strings.FieldsFunc(term, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("invalid semantic version constraint %q", term)
+	}
+
+	var comparisons []func(*templateSemver) bool
+	for i := 0; i < len(fields); i++ {
+		op, s := "=", fields[i]
+		for _, o := range semverOperators {
+			if strings.HasPrefix(s, o) {
+				op, s = o, strings.TrimPrefix(s, o)
+				break
+			}
+		}
+
+		// Allow a space between the operator and the version (e.g. >= 1.2).
+		if s == "" && i+1 < len(fields) {
+			i++
+			s = fields[i]
+		}
+
+		c, err := parseTemplateSemver(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid semantic version constraint %q", strings.TrimSpace(term))
+		}
+		comparisons = append(comparisons, semverComparison(op, c))
+	}
+	return comparisons, nil
+}
+
+func semverComparison(op string, c *templateSemver) func(*templateSemver) bool {
+	switch op {
+	case "!=":
+		return func(v *templateSemver) bool { return v.compare(c) != 0 }
+	case ">":
+		return func(v *templateSemver) bool { return v.compare(c) > 0 }
+	case "<":
+		return func(v *templateSemver) bool { return v.compare(c) < 0 }
+	case ">=":
+		return func(v *templateSemver) bool { return v.compare(c) >= 0 }
+	case "<=":
+		return func(v *templateSemver) bool { return v.compare(c) <= 0 }
+	case "~":
+		n := 2
+		if c.parts == 1 {
+			n = 1
+		}
+		upper := c.next(n)
+		return func(v *templateSemver) bool { return v.compare(c) >= 0 && v.compare(upper) < 0 }
+	case "^":
+		n := 3
+		switch {
+		case c.Major > 0 || c.parts == 1:
+			n = 1
+		case c.Minor > 0 || c.parts == 2:
+			n = 2
+		}
+		upper := c.next(n)
+		return func(v *templateSemver) bool { return v.compare(c) >= 0 && v.compare(upper) < 0 }
+	}
+	return func(v *templateSemver) bool { return v.compare(c) == 0 }
+}
@@ -0,0 +1,37 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import "path/filepath"
+
+const defaultTemplateSourceRef = "master"
+
+// gitSourceRefspecs mirror the branches and tags of the source into the
+// cached repository.
+var gitSourceRefspecs = []string{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}
+
+// fetchGitSource fetches the git repository of a template source into
+// a bare repository cached in dir and resolves the ref to a commit.
+func fetchGitSource(src *TemplateSource, dir string) (*fetchedSource, error) {
+	ref := src.Ref
+	if ref == "" {
+		ref = defaultTemplateSourceRef
+		if src.Digest != "" {
+			ref = src.Digest
+		}
+	}
+
+	return fetchGitCommit(filepath.Join(dir, "git"), src.Git, ref)
+}
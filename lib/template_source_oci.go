@@ -0,0 +1,350 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	ociManifestMediaTypes = "application/vnd.oci.image.manifest.v1+json, application/vnd.docker.distribution.manifest.v2+json"
+	// ociTitleAnnotation is the name of the file in a layer pushed by oras.
+	ociTitleAnnotation = "org.opencontainers.image.title"
+	// ociUnpackAnnotation marks the layers containing a directory
+	// archived by oras.
+	ociUnpackAnnotation = "io.deis.oras.content.unpack"
+	ociTimeout          = 60 * time.Second
+)
+
+type ociReference struct {
+	registry   string
+	repository string
+	reference  string
+}
+
+type ociManifest struct {
+	Layers []*ociDescriptor `json:"layers"`
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// ociRegistry is a client of the distribution api of an OCI registry.
+type ociRegistry struct {
+	ref      *ociReference
+	scheme   string
+	username string
+	password string
+	token    string
+	// actions requested in the scope of the token (e.g. pull,push)
+	// when the challenge of the registry does not specify a scope.
+	actions string
+	client  *http.Client
+}
+
+// newOCIRegistry creates a client of the registry of a reference.
+// Registries on the loopback interface are accessed over plain http.
+func newOCIRegistry(ref *ociReference) *ociRegistry {
+	registry := &ociRegistry{ref: ref, scheme: "https", actions: "pull", client: &http.Client{Timeout: ociTimeout}}
+	host := strings.Split(ref.registry, ":")[0]
+	if host == "localhost" || host == "127.0.0.1" {
+		registry.scheme = "http"
+	}
+	return registry
+}
+
+// parseOCIReference parses a reference in the form of
+// registry/repository[:tag|@digest].
+func parseOCIReference(s string) (*ociReference, error) {
+	i := strings.Index(s, "/")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid OCI reference %s", s)
+	}
+
+	ref := &ociReference{registry: s[:i], repository: s[i+1:], reference: "latest"}
+	if j := strings.Index(ref.repository, "@"); j >= 0 {
+		ref.repository, ref.reference = ref.repository[:j], ref.repository[j+1:]
+	} else if j := strings.LastIndex(ref.repository, ":"); j >= 0 {
+		ref.repository, ref.reference = ref.repository[:j], ref.repository[j+1:]
+	}
+
+	if ref.repository == "" || ref.reference == "" {
+		return nil, fmt.Errorf("invalid OCI reference %s", s)
+	}
+
+	return ref, nil
+}
+
+// fetchOCISource resolves the manifest of an OCI artifact. Layers are
+// downloaded when the content is extracted.
+// Layers with a tar media type are extracted and other layers are
+// written to the file named in their title annotation.
+func fetchOCISource(src *TemplateSource, allowedHostEnv map[string]bool) (*fetchedSource, error) {
+	ref, err := parseOCIReference(src.OCI)
+	if err != nil {
+		return nil, err
+	}
+
+	if src.Digest != "" {
+		ref.reference = src.Digest
+	}
+
+	registry := newOCIRegistry(ref)
+	var notAllowed string
+	registry.username, notAllowed = expandHostEnv(allowedHostEnv, src.Username)
+	if notAllowed == "" {
+		registry.password, notAllowed = expandHostEnv(allowedHostEnv, src.Password)
+	}
+	if notAllowed != "" {
+		return nil, e.NewErrorf(ErrClassUser, msgHostEnvNotAllowedInTemplateSource, notAllowed)
+	}
+
+	buff, err := registry.get("manifests/"+ref.reference, ociManifestMediaTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &ociManifest{}
+	if err := json.Unmarshal(buff, manifest); err != nil {
+		return nil, err
+	}
+
+	return &fetchedSource{
+		digest: sha256Digest(buff),
+		extract: func(dir string) error {
+			for _, l := range manifest.Layers {
+				if err := registry.extractLayer(l, dir); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}, nil
+}
+
+func (r *ociRegistry) extractLayer(l *ociDescriptor, dir string) error {
+	buff, err := r.get("blobs/"+l.Digest, "")
+	if err != nil {
+		return err
+	}
+
+	if d := sha256Digest(buff); d != l.Digest {
+		return fmt.Errorf("digest of layer %s does not match its content (%s)", l.Digest, d)
+	}
+
+	// oras pushes files with a tar media type, therefore only the
+	// layers marked for unpacking or without a file name are treated
+	// as archives.
+	title := l.Annotations[ociTitleAnnotation]
+	archive := l.Annotations[ociUnpackAnnotation] == "true" || (title == "" && strings.Contains(l.MediaType, "tar"))
+	if !archive {
+		if title == "" {
+			return fmt.Errorf("layer %s does not have a file name", l.Digest)
+		}
+		return writeSourceFile(dir, title, buff, 0644)
+	}
+
+	var reader io.Reader = bytes.NewReader(buff)
+	if strings.Contains(l.MediaType, "gzip") {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	tr := tar.NewReader(reader)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+
+		// Paths are retained as in the archive, which matches the
+		// layout produced by oras pull.
+		name := path.Clean(h.Name)
+		if err := writeSourceFile(dir, name, content, 0644); err != nil {
+			return err
+		}
+	}
+}
+
+// get reads a resource of the repository. Anonymous or basic
+// credentials are exchanged for a bearer token when the registry
+// requests one.
+func (r *ociRegistry) get(resource, accept string) ([]byte, error) {
+	u := r.url(resource)
+	res, err := r.send(http.MethodGet, u, nil, map[string]string{"Accept": accept})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s returned %s", u, res.Status)
+	}
+
+	return ioutil.ReadAll(res.Body)
+}
+
+func (r *ociRegistry) url(resource string) string {
+	return fmt.Sprintf("%s://%s/v2/%s/%s", r.scheme, r.ref.registry, r.ref.repository, resource)
+}
+
+// send sends a request authenticating with the registry if it is
+// required. Caller must close the body of the response.
+func (r *ociRegistry) send(method, u string, body []byte, headers map[string]string) (*http.Response, error) {
+	res, err := r.do(method, u, body, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusUnauthorized && r.token == "" {
+		res.Body.Close()
+		challenge := res.Header.Get("Www-Authenticate")
+		if err := r.authenticate(challenge); err != nil {
+			return nil, err
+		}
+
+		return r.do(method, u, body, headers)
+	}
+
+	return res, nil
+}
+
+func (r *ociRegistry) do(method, u string, body []byte, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range headers {
+		if v != "" {
+			req.Header.Set(k, v)
+		}
+	}
+
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	} else if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	return r.client.Do(req)
+}
+
+// authenticate requests a token as described in the challenge
+// (e.g. Bearer realm="https://auth.docker.io/token",service="registry.docker.io").
+func (r *ociRegistry) authenticate(challenge string) error {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+
+	params := make(map[string]string)
+	for _, p := range strings.Split(challenge[len("bearer "):], ",") {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("invalid realm in authentication challenge %q", challenge)
+	}
+
+	q := realm.Query()
+	for _, k := range []string{"service", "scope"} {
+		if params[k] != "" {
+			q.Set(k, params[k])
+		}
+	}
+	if params["scope"] == "" {
+		q.Set("scope", fmt.Sprintf("repository:%s:%s", r.ref.repository, r.actions))
+	}
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", realm.Host+realm.Path, res.Status)
+	}
+
+	token := &struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(token); err != nil {
+		return err
+	}
+
+	r.token = token.Token
+	if r.token == "" {
+		r.token = token.AccessToken
+	}
+	if r.token == "" {
+		return fmt.Errorf("token is not issued by %s", realm.Host+realm.Path)
+	}
+
+	return nil
+}
+
+func sha256Digest(buff []byte) string {
+	sum := sha256.Sum256(buff)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
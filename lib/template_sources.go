@@ -0,0 +1,198 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// templateSourcesDir is the directory in the state directory where
+// the content of template sources is cached.
+const templateSourcesDir = "templates"
+
+// TemplateSource represents a remote source of templates.
+// Templates in a source are referenced as <source>:<path>
+// (e.g. platform:deploy/service.yaml.tmpl).
+type TemplateSource struct {
+	// Git is the url of a git repository containing the templates.
+	Git string `yaml:"git,omitempty"`
+	// Ref is the branch, tag or commit of the git repository.
+	// Defaults to master.
+	Ref string `yaml:"ref,omitempty"`
+	// OCI is the reference of an OCI artifact containing the templates
+	// (e.g. ghcr.io/org/templates:1.0).
+	OCI string `yaml:"oci,omitempty"`
+	// Username and Password are the credentials of the OCI registry.
+	// They can reference the host environment variables listed in hostEnv.
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// Digest pins the content of the source. It is the sha of the commit
+	// for git and the digest of the manifest (sha256:...) for OCI.
+	// Content of a pinned source is fetched once and reused from the cache.
+	Digest string `yaml:"digest,omitempty"`
+	// Partials is the directory of partial templates in the source.
+	// Partials of the repository take precedence over them.
+	Partials string `yaml:"partials,omitempty"`
+}
+
+// templateSourceRef splits a reference to a template in a template
+// source into the name of the source and the path of the template.
+func (config *RepoConfig) templateSourceRef(ref string) (string, string, bool) {
+	i := strings.Index(ref, ":")
+	if i <= 0 {
+		return "", "", false
+	}
+
+	name := ref[:i]
+	if _, ok := config.TemplateSources[name]; !ok {
+		return "", "", false
+	}
+
+	return name, ref[i+1:], true
+}
+
+// templateFromSource reads the template referenced by ref if it refers
+// to a template source or the builtin source. Otherwise, nil is returned.
+func (s *stdSystem) templateFromSource(config *RepoConfig, ref string) (*templateFile, error) {
+	name, templatePath, ok := config.templateSourceRef(ref)
+	if !ok {
+		return builtinTemplate(ref)
+	}
+
+	dir, err := s.fetchTemplateSource(config, name)
+	if err != nil {
+		return nil, err
+	}
+
+	p := filepath.Join(dir, filepath.FromSlash(templatePath))
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgTemplateNotFoundInSource, templatePath, name)
+	}
+
+	var partials []*partial
+	if src := config.TemplateSources[name]; src.Partials != "" {
+		partials, err = partialsInDir(filepath.Join(dir, filepath.FromSlash(src.Partials)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &templateFile{path: templatePath, content: content, partials: partials}, nil
+}
+
+// fetchTemplateSource returns the directory containing the content of
+// a template source. Content is cached in the state directory by its
+// digest, therefore pinned sources are fetched only once.
+func (s *stdSystem) fetchTemplateSource(config *RepoConfig, name string) (string, error) {
+	src := config.TemplateSources[name]
+	if (src.Git == "") == (src.OCI == "") {
+		return "", e.NewErrorf(ErrClassUser, msgInvalidTemplateSource, name)
+	}
+
+	stateDir, err := s.stateDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(stateDir, templateSourcesDir, name)
+
+	if src.Digest != "" {
+		cached := filepath.Join(dir, digestDir(src.Digest))
+		if _, err := os.Stat(cached); err == nil {
+			return cached, nil
+		}
+	}
+
+	s.Log.Infof(msgFetchingTemplateSource, name)
+
+	var fetched *fetchedSource
+	if src.Git != "" {
+		fetched, err = fetchGitSource(src, dir)
+		if err != nil {
+			return "", remoteError(err, msgFailedFetchTemplateSource, name)
+		}
+	} else {
+		fetched, err = fetchOCISource(src, config.allowedHostEnv())
+		if err != nil {
+			return "", e.Wrapf(ErrClassUser, err, msgFailedFetchTemplateSource, name)
+		}
+	}
+
+	if src.Digest != "" && fetched.digest != src.Digest {
+		return "", e.NewErrorf(ErrClassUser, msgTemplateSourceDigestMismatch, name, src.Digest, fetched.digest)
+	}
+
+	cached := filepath.Join(dir, digestDir(fetched.digest))
+	if _, err := os.Stat(cached); err == nil {
+		return cached, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", e.Wrap(ErrClassInternal, err)
+	}
+
+	// Content is extracted to a temporary directory and renamed, so
+	// that a partially extracted source is never used.
+	tmp, err := ioutil.TempDir(dir, ".fetch")
+	if err != nil {
+		return "", e.Wrap(ErrClassInternal, err)
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := fetched.extract(tmp); err != nil {
+		return "", e.Wrapf(ErrClassUser, err, msgFailedFetchTemplateSource, name)
+	}
+
+	if err := os.Rename(tmp, cached); err != nil {
+		if _, serr := os.Stat(cached); serr == nil {
+			// Fetched concurrently by another invocation.
+			return cached, nil
+		}
+		return "", e.Wrap(ErrClassInternal, err)
+	}
+
+	return cached, nil
+}
+
+// fetchedSource is the content of a template source resolved to a digest.
+type fetchedSource struct {
+	digest string
+	// extract writes the content to the specified directory.
+	extract func(dir string) error
+}
+
+func digestDir(digest string) string {
+	return strings.Replace(digest, ":", "-", -1)
+}
+
+// writeSourceFile writes a file of a template source, rejecting the
+// paths outside of dir.
+func writeSourceFile(dir, name string, content []byte, mode os.FileMode) error {
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if rel, err := filepath.Rel(dir, p); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return e.NewErrorf(ErrClassUser, msgInvalidTemplateSourcePath, name)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(p, content, mode)
+}
@@ -0,0 +1,264 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func initTemplateSourceRepo(t *testing.T) (*TestRepository, string) {
+	templates := NewTestRepo(t, ".tmp/templates")
+	check(t, templates.WriteContent("deploy/service.tmpl", `{{range .ModulesList}}{{template "name.tmpl" .}};{{end}}`))
+	check(t, templates.WriteContent("partials/name.tmpl", `v1-{{.Name}}`))
+	check(t, templates.Commit("first"))
+
+	url, err := filepath.Abs(".tmp/templates")
+	check(t, err)
+	return templates, url
+}
+
+func TestApplyTemplateFromGitSource(t *testing.T) {
+	clean()
+	templates, url := initTemplateSourceRepo(t)
+	first := templates.LastCommit.String()
+
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteConfig(&RepoConfig{TemplateSources: map[string]*TemplateSource{
+		"platform": {Git: url, Partials: "partials"},
+	}}))
+	check(t, repo.Commit("first"))
+
+	output := new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHead("platform:deploy/service.tmpl", output))
+	assert.Equal(t, "v1-app-a;", output.String())
+
+	check(t, templates.WriteContent("partials/name.tmpl", `v2-{{.Name}}`))
+	check(t, templates.Commit("second"))
+
+	// Unpinned sources are updated.
+	output = new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyLocal("platform:deploy/service.tmpl", output))
+	assert.Equal(t, "v2-app-a;", output.String())
+
+	// Partials of the repository take precedence.
+	check(t, repo.WriteContent(".mbt/partials/name.tmpl", `local-{{.Name}}`))
+	output = new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyLocal("platform:deploy/service.tmpl", output))
+	assert.Equal(t, "local-app-a;", output.String())
+	check(t, os.RemoveAll(".tmp/repo/.mbt/partials"))
+
+	// Pinned sources are read from the cache.
+	check(t, repo.WriteConfig(&RepoConfig{TemplateSources: map[string]*TemplateSource{
+		"platform": {Git: url, Digest: first, Partials: "partials"},
+	}}))
+	output = new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyLocal("platform:deploy/service.tmpl", output))
+	assert.Equal(t, "v1-app-a;", output.String())
+
+	check(t, os.RemoveAll(".tmp/templates"))
+	output = new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyLocal("platform:deploy/service.tmpl", output))
+	assert.Equal(t, "v1-app-a;", output.String())
+}
+
+func TestApplyTemplateFromGitSourceWithMismatchingDigest(t *testing.T) {
+	clean()
+	templates, url := initTemplateSourceRepo(t)
+
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteConfig(&RepoConfig{TemplateSources: map[string]*TemplateSource{
+		"platform": {Git: url, Ref: "master", Digest: "0000000000000000000000000000000000000000"},
+	}}))
+	check(t, repo.Commit("first"))
+
+	err := NewWorld(t, ".tmp/repo").System.ApplyHead("platform:deploy/service.tmpl", new(bytes.Buffer))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgTemplateSourceDigestMismatch, "platform", "0000000000000000000000000000000000000000", templates.LastCommit.String()))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestApplyTemplateNotFoundInSource(t *testing.T) {
+	clean()
+	_, url := initTemplateSourceRepo(t)
+
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteConfig(&RepoConfig{TemplateSources: map[string]*TemplateSource{"platform": {Git: url}}}))
+	check(t, repo.Commit("first"))
+
+	err := NewWorld(t, ".tmp/repo").System.ApplyHead("platform:missing.tmpl", new(bytes.Buffer))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgTemplateNotFoundInSource, "missing.tmpl", "platform"))
+}
+
+func TestInvalidTemplateSource(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteConfig(&RepoConfig{TemplateSources: map[string]*TemplateSource{"platform": {}}}))
+	check(t, repo.Commit("first"))
+
+	err := NewWorld(t, ".tmp/repo").System.ApplyHead("platform:deploy.tmpl", new(bytes.Buffer))
+
+	assert.EqualError(t, err, fmt.Sprintf(msgInvalidTemplateSource, "platform"))
+}
+
+type testRegistry struct {
+	server   *httptest.Server
+	manifest []byte
+	blobs    map[string][]byte
+	requests []string
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	r := &testRegistry{blobs: make(map[string][]byte)}
+
+	file := []byte(`{{range .ModulesList}}{{template "name.tmpl" .}};{{end}}`)
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	content := []byte(`oci-{{.Name}}`)
+	check(t, tw.WriteHeader(&tar.Header{Name: "partials/name.tmpl", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	_, err := tw.Write(content)
+	check(t, err)
+	check(t, tw.Close())
+	check(t, gz.Close())
+
+	r.blobs[sha256Digest(file)] = file
+	r.blobs[sha256Digest(archive.Bytes())] = archive.Bytes()
+
+	manifest := map[string]interface{}{
+		"schemaVersion": 2,
+		"layers": []map[string]interface{}{
+			{"mediaType": "application/vnd.oci.image.layer.v1.tar", "digest": sha256Digest(file), "annotations": map[string]string{ociTitleAnnotation: "service.tmpl"}},
+			{"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "digest": sha256Digest(archive.Bytes()), "annotations": map[string]string{ociTitleAnnotation: "partials", "io.deis.oras.content.unpack": "true"}},
+		},
+	}
+	r.manifest, err = json.Marshal(manifest)
+	check(t, err)
+
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.requests = append(r.requests, req.URL.Path)
+		if req.URL.Path == "/token" {
+			if req.URL.Query().Get("scope") != "repository:org/templates:pull" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"token": "secret"}`)
+			return
+		}
+
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, r.server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case req.URL.Path == "/v2/org/templates/manifests/1.0", req.URL.Path == "/v2/org/templates/manifests/"+sha256Digest(r.manifest):
+			w.Write(r.manifest)
+		case strings.HasPrefix(req.URL.Path, "/v2/org/templates/blobs/"):
+			b, ok := r.blobs[strings.TrimPrefix(req.URL.Path, "/v2/org/templates/blobs/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(b)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return r
+}
+
+func (r *testRegistry) reference() string {
+	return strings.TrimPrefix(r.server.URL, "http://") + "/org/templates:1.0"
+}
+
+func TestApplyTemplateFromOCISource(t *testing.T) {
+	clean()
+	registry := newTestRegistry(t)
+	defer registry.server.Close()
+
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteConfig(&RepoConfig{TemplateSources: map[string]*TemplateSource{
+		"platform": {OCI: registry.reference(), Digest: sha256Digest(registry.manifest), Partials: "partials"},
+	}}))
+	check(t, repo.Commit("first"))
+
+	output := new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHead("platform:service.tmpl", output))
+	assert.Equal(t, "oci-app-a;", output.String())
+	assert.Contains(t, registry.requests, "/token")
+
+	// Pinned content is read from the cache.
+	registry.requests = nil
+	output = new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHead("platform:service.tmpl", output))
+	assert.Equal(t, "oci-app-a;", output.String())
+	assert.Empty(t, registry.requests)
+}
+
+func TestApplyTemplateFromOCISourceWithMismatchingDigest(t *testing.T) {
+	clean()
+	registry := newTestRegistry(t)
+	defer registry.server.Close()
+
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteConfig(&RepoConfig{TemplateSources: map[string]*TemplateSource{
+		"platform": {OCI: registry.reference(), Digest: "sha256:0000"},
+	}}))
+	check(t, repo.Commit("first"))
+
+	err := NewWorld(t, ".tmp/repo").System.ApplyHead("platform:service.tmpl", new(bytes.Buffer))
+
+	assert.Error(t, err)
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestParseOCIReference(t *testing.T) {
+	ref, err := parseOCIReference("ghcr.io/org/templates:1.0")
+	check(t, err)
+	assert.Equal(t, &ociReference{registry: "ghcr.io", repository: "org/templates", reference: "1.0"}, ref)
+
+	ref, err = parseOCIReference("localhost:5000/templates@sha256:abc")
+	check(t, err)
+	assert.Equal(t, &ociReference{registry: "localhost:5000", repository: "templates", reference: "sha256:abc"}, ref)
+
+	ref, err = parseOCIReference("ghcr.io/templates")
+	check(t, err)
+	assert.Equal(t, "latest", ref.reference)
+
+	_, err = parseOCIReference("templates")
+	assert.Error(t, err)
+}
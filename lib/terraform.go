@@ -0,0 +1,250 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// TerraformFormatText lists the stacks in the order they are applied.
+	TerraformFormatText = "text"
+	// TerraformFormatJSON formats the plan as json.
+	TerraformFormatJSON = "json"
+
+	// TerraformReasonChanged indicates that the content of the stack changed.
+	TerraformReasonChanged = "changed"
+	// TerraformReasonDependency indicates that the stack is affected
+	// because one of its dependencies changed.
+	TerraformReasonDependency = "dependency"
+)
+
+// TerraformStack is the Terraform root module of an mbt module.
+type TerraformStack struct {
+	// Dir of the root module relative to the module directory.
+	// Defaults to the module directory.
+	Dir string `yaml:"dir,omitempty"`
+	// Workspace is the Terraform workspace of the stack (optional).
+	Workspace string `yaml:"workspace,omitempty"`
+	// Vars maps the Terraform variables to the paths of module
+	// properties (e.g. cluster.size). All properties of the module are
+	// used as variables when this is not specified.
+	Vars map[string]string `yaml:"vars,omitempty"`
+}
+
+// TerraformOptions specifies how a Terraform plan is created.
+type TerraformOptions struct {
+	// Environment is the name of the environment whose overlays are
+	// merged over the properties (see Spec.Environments).
+	Environment string
+	// VarsDir is the directory where the variable file of each stack
+	// is written (optional).
+	VarsDir string
+}
+
+// TerraformPlan is the list of stacks to plan and apply for the
+// changes between two revisions.
+type TerraformPlan struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Stacks in the order they should be applied.
+	Stacks []*TerraformStackPlan `json:"stacks"`
+	// Removed is the list of modules with a stack in the merge base
+	// that are not found in the head revision. Their resources may
+	// need to be destroyed.
+	Removed []string `json:"removed,omitempty"`
+}
+
+// TerraformStackPlan is a stack affected by the changes.
+type TerraformStackPlan struct {
+	Module string `json:"module"`
+	// Dir of the root module relative to the repository root.
+	Dir       string `json:"dir"`
+	Workspace string `json:"workspace,omitempty"`
+	Version   string `json:"version"`
+	// Reason is either changed or dependency.
+	Reason string `json:"reason"`
+	// Needs is the list of stacks in the plan to be applied before
+	// this one.
+	Needs []string               `json:"needs"`
+	Vars  map[string]interface{} `json:"vars"`
+	// VarFile is the path of the variable file written for the stack.
+	VarFile string `json:"varFile,omitempty"`
+}
+
+func (s *stdSystem) TerraformPlan(base, head string, options *TerraformOptions) (*TerraformPlan, error) {
+	i, err := s.changeImpact(base, head)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := (&Manifest{Dir: s.Repo.Path(), Sha: i.to.ID(), Modules: i.affected}).withEnvironment(options.Environment)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &TerraformPlan{From: i.from.ID(), To: i.to.ID(), Stacks: make([]*TerraformStackPlan, 0)}
+	changed := i.changed.indexByName()
+	stacks := make(map[string]bool)
+	for _, mod := range m.Modules {
+		if mod.Terraform() != nil {
+			stacks[mod.Name()] = true
+		}
+	}
+
+	for _, mod := range m.Modules {
+		tf := mod.Terraform()
+		if tf == nil {
+			continue
+		}
+
+		vars, err := terraformVars(mod)
+		if err != nil {
+			return nil, err
+		}
+
+		stack := &TerraformStackPlan{
+			Module:    mod.Name(),
+			Dir:       path.Join(mod.Path(), tf.Dir),
+			Workspace: tf.Workspace,
+			Version:   mod.Version(),
+			Reason:    TerraformReasonDependency,
+			Needs:     pipelineNeeds(mod, stacks),
+			Vars:      vars,
+		}
+		if _, ok := changed[mod.Name()]; ok {
+			stack.Reason = TerraformReasonChanged
+		}
+
+		if options.VarsDir != "" {
+			stack.VarFile, err = writeTerraformVars(options.VarsDir, stack)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		plan.Stacks = append(plan.Stacks, stack)
+	}
+
+	all := i.all.indexByName()
+	for _, mod := range i.previous {
+		if _, ok := all[mod.Name()]; !ok && mod.Terraform() != nil {
+			plan.Removed = append(plan.Removed, mod.Name())
+		}
+	}
+
+	return plan, nil
+}
+
+// terraformVars returns the variables of a stack from the properties
+// of the module.
+func terraformVars(mod *Module) (map[string]interface{}, error) {
+	vars := make(map[string]interface{})
+	tf := mod.Terraform()
+	if len(tf.Vars) == 0 {
+		for k, v := range mod.Properties() {
+			vars[k] = v
+		}
+		return vars, nil
+	}
+
+	for name, property := range tf.Vars {
+		v := resolveProperty(mod.Properties(), strings.Split(property, "."), missingProperty{})
+		if _, ok := v.(missingProperty); ok {
+			return nil, e.NewErrorf(ErrClassUser, msgMissingTerraformVarProperty, property, name, mod.Name())
+		}
+		vars[name] = v
+	}
+
+	return vars, nil
+}
+
+// writeTerraformVars writes the variables of a stack to
+// <dir>/<module>.tfvars.json and returns the path of the file.
+func writeTerraformVars(dir string, stack *TerraformStackPlan) (string, error) {
+	buff, err := json.MarshalIndent(stack.Vars, "", "  ")
+	if err != nil {
+		return "", e.Wrapf(ErrClassUser, err, msgFailedWriteTerraformVars, stack.Module)
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", e.Wrapf(ErrClassUser, err, msgFailedWriteTerraformVars, stack.Module)
+	}
+
+	p := filepath.Join(dir, unsafeFileNameChars.ReplaceAllString(stack.Module, "_")+".tfvars.json")
+	err = ioutil.WriteFile(p, append(buff, '\n'), 0644)
+	if err != nil {
+		return "", e.Wrapf(ErrClassUser, err, msgFailedWriteTerraformVars, stack.Module)
+	}
+
+	return p, nil
+}
+
+// Write writes the plan in the specified format.
+func (p *TerraformPlan) Write(format string, w io.Writer) error {
+	var (
+		buff []byte
+		err  error
+	)
+
+	switch format {
+	case TerraformFormatText:
+		buff = p.text()
+	case TerraformFormatJSON:
+		buff, err = json.MarshalIndent(p, "", "  ")
+		buff = append(buff, '\n')
+	default:
+		return e.NewErrorf(ErrClassUser, msgUnsupportedTerraformFormat, format)
+	}
+
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	if _, err := w.Write(buff); err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	return nil
+}
+
+func (p *TerraformPlan) text() []byte {
+	buff := new(bytes.Buffer)
+	for _, s := range p.Stacks {
+		fmt.Fprintf(buff, "%s\t%s\t%s", s.Module, s.Dir, s.Reason)
+		if len(s.Needs) > 0 {
+			fmt.Fprintf(buff, "\tafter %s", strings.Join(s.Needs, ", "))
+		}
+		fmt.Fprintln(buff)
+	}
+
+	for _, r := range p.Removed {
+		fmt.Fprintf(buff, "%s\t-\tremoved\n", r)
+	}
+
+	return buff.Bytes()
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func initTerraformRepo(t *testing.T) *TestRepository {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("network", &Spec{
+		Name:       "network",
+		Properties: map[string]interface{}{"cidr": "10.0.0.0/16", "region": "us-east-1"},
+		Terraform:  &TerraformStack{Dir: "tf", Workspace: "dev"},
+		Environments: map[string]*Environment{
+			"prod": {Properties: map[string]interface{}{"cidr": "10.1.0.0/16"}},
+		},
+	}))
+	check(t, repo.InitModuleWithOptions("lib-a", &Spec{Name: "lib-a", Dependencies: []string{"network"}}))
+	check(t, repo.InitModuleWithOptions("cluster", &Spec{
+		Name:         "cluster",
+		Dependencies: []string{"lib-a"},
+		Properties:   map[string]interface{}{"nodes": map[string]interface{}{"count": 3}},
+		Terraform:    &TerraformStack{Vars: map[string]string{"node_count": "nodes.count"}},
+	}))
+	check(t, repo.InitModuleWithOptions("dns", &Spec{Name: "dns", Terraform: &TerraformStack{}}))
+	check(t, repo.Commit("first"))
+
+	check(t, repo.SwitchToBranch("feature"))
+	check(t, repo.WriteContent("network/tf/main.tf", "b"))
+	check(t, repo.Remove("dns"))
+	check(t, repo.Commit("second"))
+
+	return repo
+}
+
+func TestTerraformPlan(t *testing.T) {
+	initTerraformRepo(t)
+
+	plan, err := NewWorld(t, ".tmp/repo").System.TerraformPlan("master", "feature", &TerraformOptions{})
+	check(t, err)
+
+	assert.Len(t, plan.Stacks, 2)
+	assert.Equal(t, []string{"dns"}, plan.Removed)
+
+	network, cluster := plan.Stacks[0], plan.Stacks[1]
+	assert.Equal(t, "network", network.Module)
+	assert.Equal(t, "network/tf", network.Dir)
+	assert.Equal(t, "dev", network.Workspace)
+	assert.Equal(t, TerraformReasonChanged, network.Reason)
+	assert.Empty(t, network.Needs)
+	assert.Equal(t, map[string]interface{}{"cidr": "10.0.0.0/16", "region": "us-east-1"}, network.Vars)
+
+	assert.Equal(t, "cluster", cluster.Module)
+	assert.Equal(t, "cluster", cluster.Dir)
+	assert.Equal(t, TerraformReasonDependency, cluster.Reason)
+	assert.Equal(t, []string{"network"}, cluster.Needs)
+	assert.Equal(t, map[string]interface{}{"node_count": 3}, cluster.Vars)
+}
+
+func TestTerraformPlanWithEnvironmentAndVarsDir(t *testing.T) {
+	initTerraformRepo(t)
+
+	dir, err := filepath.Abs(".tmp/tfvars")
+	check(t, err)
+
+	plan, err := NewWorld(t, ".tmp/repo").System.TerraformPlan("master", "feature", &TerraformOptions{Environment: "prod", VarsDir: dir})
+	check(t, err)
+
+	network := plan.Stacks[0]
+	assert.Equal(t, filepath.Join(dir, "network.tfvars.json"), network.VarFile)
+
+	buff, err := ioutil.ReadFile(network.VarFile)
+	check(t, err)
+
+	vars := make(map[string]interface{})
+	check(t, json.Unmarshal(buff, &vars))
+	assert.Equal(t, map[string]interface{}{"cidr": "10.1.0.0/16", "region": "us-east-1"}, vars)
+	assert.FileExists(t, filepath.Join(dir, "cluster.tfvars.json"))
+}
+
+func TestTerraformPlanForMissingVarProperty(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:      "app-a",
+		Terraform: &TerraformStack{Vars: map[string]string{"size": "cluster.size"}},
+	}))
+	check(t, repo.Commit("first"))
+	check(t, repo.SwitchToBranch("feature"))
+	check(t, repo.WriteContent("app-a/main.tf", "a"))
+	check(t, repo.Commit("second"))
+
+	_, err := NewWorld(t, ".tmp/repo").System.TerraformPlan("master", "feature", &TerraformOptions{})
+
+	assert.EqualError(t, err, "Property cluster.size of variable size is not found in app-a")
+}
+
+func TestTerraformPlanWrite(t *testing.T) {
+	plan := &TerraformPlan{
+		Stacks: []*TerraformStackPlan{
+			{Module: "network", Dir: "network/tf", Reason: TerraformReasonChanged, Needs: []string{}},
+			{Module: "cluster", Dir: "cluster", Reason: TerraformReasonDependency, Needs: []string{"network"}},
+		},
+		Removed: []string{"dns"},
+	}
+
+	buff := new(bytes.Buffer)
+	check(t, plan.Write(TerraformFormatText, buff))
+	assert.Equal(t, "network\tnetwork/tf\tchanged\ncluster\tcluster\tdependency\tafter network\ndns\t-\tremoved\n", buff.String())
+
+	buff.Reset()
+	check(t, plan.Write(TerraformFormatJSON, buff))
+	decoded := &TerraformPlan{}
+	check(t, json.Unmarshal(buff.Bytes(), decoded))
+	assert.Equal(t, "cluster", decoded.Stacks[1].Module)
+
+	assert.EqualError(t, plan.Write("yaml", buff), "Unsupported format yaml, supported formats are text and json")
+}
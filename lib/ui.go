@@ -0,0 +1,327 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// spec returns the spec file of module in rev (default current branch)
+// or in the workspace.
+func (q *queryServer) spec(params url.Values) (interface{}, error) {
+	name := params.Get("module")
+	if name == "" {
+		return nil, e.NewErrorf(ErrClassUser, msgMissingQueryParameter, "module")
+	}
+
+	rev := params.Get("rev")
+	m, err := q.manifest(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	mod, ok := m.Module(name)
+	if !ok {
+		return nil, e.NewErrorf(ErrClassUser, msgModuleNotFound, name)
+	}
+
+	p := path.Join(mod.Path(), configFileName)
+	if rev == serveWorkspaceRev {
+		b, err := ioutil.ReadFile(filepath.Join(q.root, filepath.FromSlash(p)))
+		if err != nil {
+			return nil, e.Wrap(ErrClassInternal, err)
+		}
+		return string(b), nil
+	}
+
+	commit, err := q.resolve(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := q.Repo.BlobContentsFromTree(commit, p)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// handleUI serves the web page rendering the module graph.
+func handleUI(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(uiPage))
+}
+
+// uiPage renders the module graph with the queries of the server.
+// It does not load any external resources so that it works offline.
+// Modules are laid out in columns by the length of their longest
+// dependency chain and drawn on a canvas, which scales to thousands
+// of modules.
+const uiPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>mbt</title>
+<style>
+  body { margin: 0; font: 13px sans-serif; display: flex; flex-direction: column; height: 100vh; }
+  header { display: flex; gap: 8px; align-items: center; padding: 8px; border-bottom: 1px solid #ddd; }
+  header input { font: inherit; padding: 2px 4px; }
+  main { flex: 1; display: flex; min-height: 0; }
+  canvas { flex: 1; cursor: grab; }
+  aside { width: 360px; overflow: auto; padding: 8px; border-left: 1px solid #ddd; display: none; }
+  aside.open { display: block; }
+  aside pre { background: #f6f6f6; padding: 8px; overflow: auto; }
+  aside a { cursor: pointer; color: #06c; margin-right: 6px; }
+  #status { color: #666; margin-left: auto; }
+</style>
+</head>
+<body>
+<header>
+  <label>Revision <input id="rev" placeholder="current branch" size="14"></label>
+  <label>Changes <input id="base" placeholder="base" size="10"> .. <input id="head" placeholder="head" size="10"></label>
+  <button id="load">Load</button>
+  <input id="search" placeholder="Search modules" size="20">
+  <span id="status"></span>
+</header>
+<main>
+  <canvas id="graph"></canvas>
+  <aside id="details"></aside>
+</main>
+<script>
+(function () {
+  var canvas = document.getElementById("graph");
+  var ctx = canvas.getContext("2d");
+  var details = document.getElementById("details");
+  var status = document.getElementById("status");
+  var nodes = [], index = {}, changed = {}, matches = {}, selected = null;
+  var view = { x: 20, y: 20, scale: 1 };
+  var W = 180, H = 24, GX = 260, GY = 36;
+
+  function value(id) { return document.getElementById(id).value.trim(); }
+
+  function query(name, params) {
+    var q = Object.keys(params).filter(function (k) { return params[k]; }).map(function (k) {
+      return encodeURIComponent(k) + "=" + encodeURIComponent(params[k]);
+    }).join("&");
+    return fetch("/v1/" + name + (q ? "?" + q : "")).then(function (res) {
+      var type = res.headers.get("Content-Type") || "";
+      var body = type.indexOf("json") >= 0 ? res.json() : res.text();
+      return body.then(function (b) {
+        if (!res.ok) { throw new Error(b.error || res.statusText); }
+        return b;
+      });
+    });
+  }
+
+  // layout places a module one column after its deepest dependency.
+  function layout(modules) {
+    nodes = modules; index = {};
+    modules.forEach(function (m) { index[m.name] = m; m.level = -1; });
+    function level(m) {
+      if (m.level < 0) {
+        m.level = 0;
+        m.dependencies.forEach(function (d) { if (index[d]) { m.level = Math.max(m.level, level(index[d]) + 1); } });
+      }
+      return m.level;
+    }
+    var rows = [];
+    modules.forEach(function (m) {
+      var l = level(m);
+      rows[l] = (rows[l] || 0);
+      m.x = l * GX; m.y = rows[l] * GY;
+      rows[l]++;
+    });
+  }
+
+  function resize() {
+    canvas.width = canvas.clientWidth * devicePixelRatio;
+    canvas.height = canvas.clientHeight * devicePixelRatio;
+    draw();
+  }
+
+  function draw() {
+    var r = devicePixelRatio;
+    ctx.setTransform(r, 0, 0, r, 0, 0);
+    ctx.clearRect(0, 0, canvas.width, canvas.height);
+    ctx.translate(view.x, view.y);
+    ctx.scale(view.scale, view.scale);
+
+    var related = {};
+    if (selected) {
+      related[selected.name] = true;
+      selected.dependencies.concat(selected.dependents).forEach(function (n) { related[n] = true; });
+    }
+
+    ctx.lineWidth = 1 / view.scale;
+    nodes.forEach(function (m) {
+      m.dependencies.forEach(function (d) {
+        var t = index[d];
+        if (!t) { return; }
+        var active = selected && (m === selected || t === selected);
+        ctx.strokeStyle = active ? "#06c" : "rgba(0, 0, 0, 0.15)";
+        ctx.beginPath();
+        ctx.moveTo(t.x + W, t.y + H / 2);
+        ctx.lineTo(m.x, m.y + H / 2);
+        ctx.stroke();
+      });
+    });
+
+    var labels = view.scale > 0.35;
+    ctx.font = "12px sans-serif";
+    ctx.textBaseline = "middle";
+    nodes.forEach(function (m) {
+      ctx.fillStyle = changed[m.name] ? "#fdb863" : "#e8eef7";
+      if (matches[m.name]) { ctx.fillStyle = "#fff176"; }
+      if (selected && !related[m.name]) { ctx.globalAlpha = 0.3; }
+      ctx.fillRect(m.x, m.y, W, H);
+      ctx.strokeStyle = m === selected ? "#06c" : "#8da0bf";
+      ctx.strokeRect(m.x, m.y, W, H);
+      if (labels) {
+        ctx.fillStyle = "#222";
+        ctx.fillText(m.name, m.x + 6, m.y + H / 2, W - 12);
+      }
+      ctx.globalAlpha = 1;
+    });
+  }
+
+  function nodeAt(px, py) {
+    var x = (px - view.x) / view.scale, y = (py - view.y) / view.scale;
+    for (var i = 0; i < nodes.length; i++) {
+      var m = nodes[i];
+      if (x >= m.x && x <= m.x + W && y >= m.y && y <= m.y + H) { return m; }
+    }
+    return null;
+  }
+
+  function focus(m) {
+    view.x = canvas.clientWidth / 2 - (m.x + W / 2) * view.scale;
+    view.y = canvas.clientHeight / 2 - (m.y + H / 2) * view.scale;
+  }
+
+  function element(tag, text) {
+    var el = document.createElement(tag);
+    if (text !== undefined) { el.textContent = text; }
+    return el;
+  }
+
+  function links(title, names) {
+    var p = element("p");
+    p.appendChild(element("b", title + ": "));
+    if (names.length === 0) { p.appendChild(document.createTextNode("none")); }
+    names.forEach(function (n) {
+      var a = element("a", n);
+      a.onclick = function () { select(index[n], true); };
+      p.appendChild(a);
+    });
+    return p;
+  }
+
+  function select(m, center) {
+    selected = m;
+    details.innerHTML = "";
+    details.className = m ? "open" : "";
+    if (m) {
+      details.appendChild(element("h3", m.name));
+      details.appendChild(element("p", "Path: " + (m.path || "/")));
+      details.appendChild(element("p", "Version: " + m.version));
+      details.appendChild(links("Dependencies", m.dependencies));
+      details.appendChild(links("Dependents", m.dependents));
+      var spec = element("pre", "Loading the spec...");
+      details.appendChild(spec);
+      query("spec", { module: m.name, rev: value("rev") }).then(function (s) {
+        spec.textContent = s;
+      }, function (err) { spec.textContent = err.message; });
+      if (center) { focus(m); }
+    }
+    draw();
+  }
+
+  function search() {
+    var term = value("search").toLowerCase();
+    matches = {};
+    var first = null;
+    if (term) {
+      nodes.forEach(function (m) {
+        if (m.name.toLowerCase().indexOf(term) >= 0 || m.path.toLowerCase().indexOf(term) >= 0) {
+          matches[m.name] = true;
+          first = first || m;
+        }
+      });
+    }
+    if (first) { focus(first); }
+    status.textContent = term ? Object.keys(matches).length + " of " + nodes.length + " modules" : nodes.length + " modules";
+    draw();
+  }
+
+  function load() {
+    status.textContent = "Loading...";
+    var rev = value("rev"), base = value("base");
+    var loads = [query("modules", { rev: rev })];
+    if (base) { loads.push(query("affected", { base: base, head: value("head") })); }
+    Promise.all(loads).then(function (r) {
+      layout(r[0].modules);
+      changed = {};
+      if (r[1]) { r[1].modules.forEach(function (m) { changed[m.name] = true; }); }
+      select(null);
+      search();
+      if (r[1]) { status.textContent += ", " + r[1].modules.length + " changed"; }
+    }, function (err) { status.textContent = err.message; });
+  }
+
+  var drag = null;
+  canvas.onmousedown = function (ev) { drag = { x: ev.offsetX, y: ev.offsetY, moved: false }; };
+  canvas.onmousemove = function (ev) {
+    if (!drag) { return; }
+    view.x += ev.offsetX - drag.x; view.y += ev.offsetY - drag.y;
+    drag.moved = drag.moved || Math.abs(ev.offsetX - drag.x) + Math.abs(ev.offsetY - drag.y) > 0;
+    drag.x = ev.offsetX; drag.y = ev.offsetY;
+    draw();
+  };
+  canvas.onmouseup = function (ev) {
+    if (drag && !drag.moved) { select(nodeAt(ev.offsetX, ev.offsetY), false); }
+    drag = null;
+  };
+  canvas.onwheel = function (ev) {
+    ev.preventDefault();
+    var f = Math.exp(-ev.deltaY / 500);
+    var s = Math.min(4, Math.max(0.02, view.scale * f));
+    view.x = ev.offsetX - (ev.offsetX - view.x) * s / view.scale;
+    view.y = ev.offsetY - (ev.offsetY - view.y) * s / view.scale;
+    view.scale = s;
+    draw();
+  };
+
+  document.getElementById("load").onclick = load;
+  document.getElementById("search").oninput = search;
+  window.onresize = resize;
+  resize();
+  load();
+})();
+</script>
+</body>
+</html>
+`
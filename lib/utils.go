@@ -16,8 +16,13 @@ limitations under the License.
 package lib
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
 )
 
 // GitRepoRoot returns path to a git repo reachable from
@@ -25,6 +30,8 @@ import (
 // If the specified directory itself is not a git repo,
 // this function searches for it in the parent directory
 // path.
+// Linked worktrees, where .git is a file pointing at the
+// main repository, and bare repositories are git repos as well.
 func GitRepoRoot(dir string) (string, error) {
 	dir, err := filepath.Abs(dir)
 	if err != nil {
@@ -39,7 +46,7 @@ func GitRepoRoot(dir string) (string, error) {
 	for {
 		test := filepath.Join(dir, ".git")
 		fi, err := os.Stat(test)
-		if err == nil && fi.IsDir() {
+		if err == nil && (fi.IsDir() || isGitFile(test)) {
 			return dir, nil
 		}
 
@@ -47,6 +54,10 @@ func GitRepoRoot(dir string) (string, error) {
 			return "", err
 		}
 
+		if isBareRepo(dir) {
+			return dir, nil
+		}
+
 		if dir == root {
 			return dir, nil
 		}
@@ -54,3 +65,63 @@ func GitRepoRoot(dir string) (string, error) {
 		dir = filepath.Dir(dir)
 	}
 }
+
+// bareConfig matches the configuration of a bare repository.
+var bareConfig = regexp.MustCompile(`(?mi)^\s*bare\s*=\s*true\s*$`)
+
+// isBareRepo informs if the directory is a bare git repository.
+func isBareRepo(dir string) bool {
+	for _, d := range []string{"objects", "refs"} {
+		if fi, err := os.Stat(filepath.Join(dir, d)); err != nil || !fi.IsDir() {
+			return false
+		}
+	}
+
+	buff, err := ioutil.ReadFile(filepath.Join(dir, "config"))
+	return err == nil && bareConfig.Match(buff)
+}
+
+// isGitFile informs if the file at path is a .git file pointing at the
+// git directory of a linked worktree (or a submodule).
+func isGitFile(path string) bool {
+	buff, err := ioutil.ReadFile(path)
+	return err == nil && strings.HasPrefix(string(buff), "gitdir: ")
+}
+
+// forEach invokes f for each index in [0, n) using a bounded number of
+// concurrent workers.
+// When more than one invocation fails, the error of the lowest index is
+// returned so that the outcome does not depend on the scheduling of
+// workers.
+func forEach(n int, f func(i int) error) error {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > n {
+		workers = n
+	}
+
+	errs := make([]error, n)
+	next := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = f(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
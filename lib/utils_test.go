@@ -16,9 +16,11 @@ limitations under the License.
 package lib
 
 import (
+	"fmt"
 	"path/filepath"
 	"testing"
 
+	git "github.com/libgit2/git2go"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -90,3 +92,66 @@ func TestGitRepoRootForAbsPath(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, repoDir, path)
 }
+
+func TestGitRepoRootForWorktree(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+	check(t, repo.SwitchToBranch("feature"))
+	check(t, repo.SwitchToBranch("master"))
+	addWorktree(t, repo, ".tmp/worktree", "feature")
+
+	path, err := GitRepoRoot(".tmp/worktree/app-a")
+	assert.NoError(t, err)
+	expected, err := filepath.Abs(".tmp/worktree")
+	check(t, err)
+	assert.Equal(t, expected, path)
+}
+
+func TestGitRepoRootForBareRepo(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+	_, err := git.Clone(".tmp/repo", ".tmp/bare", &git.CloneOptions{Bare: true})
+	check(t, err)
+
+	path, err := GitRepoRoot(".tmp/bare/refs/heads")
+	assert.NoError(t, err)
+	expected, err := filepath.Abs(".tmp/bare")
+	check(t, err)
+	assert.Equal(t, expected, path)
+
+	// Git directory of a repository with a working tree is not bare.
+	path, err = GitRepoRoot(".tmp/repo/.git/refs")
+	assert.NoError(t, err)
+	expected, err = filepath.Abs(".tmp/repo")
+	check(t, err)
+	assert.Equal(t, expected, path)
+}
+
+func TestForEach(t *testing.T) {
+	results := make([]int, 100)
+	err := forEach(len(results), func(i int) error {
+		results[i] = i * 2
+		return nil
+	})
+	check(t, err)
+
+	for i, r := range results {
+		assert.Equal(t, i*2, r)
+	}
+}
+
+func TestForEachReturnsErrorOfLowestIndex(t *testing.T) {
+	err := forEach(100, func(i int) error {
+		if i%10 == 7 {
+			return fmt.Errorf("error %v", i)
+		}
+		return nil
+	})
+
+	assert.EqualError(t, err, "error 7")
+	assert.NoError(t, forEach(0, func(i int) error { return nil }))
+}
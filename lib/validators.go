@@ -0,0 +1,98 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// validatorsFor returns the validators applicable to a template.
+func validatorsFor(config *RepoConfig, templatePath string) ([]*Validator, error) {
+	var r []*Validator
+	for _, v := range config.Validators {
+		ok, err := v.appliesTo(templatePath)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			r = append(r, v)
+		}
+	}
+
+	return r, nil
+}
+
+func (v *Validator) appliesTo(templatePath string) (bool, error) {
+	if len(v.Templates) == 0 {
+		return true, nil
+	}
+
+	for _, p := range v.Templates {
+		ok, err := path.Match(normalizeSpecPath(p), filepath.ToSlash(templatePath))
+		if err != nil {
+			return false, e.Wrapf(ErrClassUser, err, msgInvalidValidatorPattern, p)
+		}
+
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// validateOutput runs each validator with the output of the template
+// in stdin. Validators are executed in the repository directory and
+// the module the output is rendered for is described in the
+// MBT_MODULE_XXX environment variables.
+// Output of a failing validator is included in the error.
+func validateOutput(validators []*Validator, templatePath string, m *Manifest, mod *Module, output []byte) error {
+	name := "template"
+	env := append(os.Environ(), fmt.Sprintf("MBT_TEMPLATE=%s", templatePath))
+	if mod != nil {
+		name = mod.Name()
+		env = append(env, buildEnvironment(m, mod)...)
+	}
+
+	for _, v := range validators {
+		command, args, err := shellCommand(v.Shell, v.Cmd.Cmd, v.Args)
+		if err != nil {
+			return err
+		}
+
+		var result bytes.Buffer
+		cmd := exec.Command(command, args...)
+		cmd.Dir = filepath.Join(m.Dir, v.Dir)
+		cmd.Env = env
+		cmd.Stdin = bytes.NewReader(output)
+		cmd.Stdout = &result
+		cmd.Stderr = &result
+
+		if err := cmd.Run(); err != nil {
+			return e.NewErrorf(ErrClassUser, msgFailedValidation, v.Cmd.Cmd, name, err, strings.TrimSpace(result.String()))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyWithValidators(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell validators are not supported on windows")
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{Validators: []*Validator{
+		{Cmd: Cmd{Cmd: `grep -q "^replicas: [0-9]" || { echo "$MBT_MODULE_NAME has no replicas in $MBT_TEMPLATE"; exit 1; }`, Shell: "sh"}},
+		{Cmd: Cmd{Cmd: "false"}, Templates: []string{"other/*.tmpl"}},
+	}}))
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Properties: map[string]interface{}{"replicas": 2}}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b"}))
+	check(t, repo.WriteContent("deploy.tmpl", `replicas: {{propertyOr .Module "replicas" "none"}}`))
+	check(t, repo.Commit("first"))
+
+	outputs := applyOutputs{}
+	options := outputs.options()
+	options.Filter = &FilterOptions{Name: "app-a"}
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("deploy.tmpl", options))
+	assert.Equal(t, "replicas: 2", outputs["app-a"].String())
+
+	outputs = applyOutputs{}
+	err := NewWorld(t, ".tmp/repo").System.ApplyHeadWithOptions("deploy.tmpl", outputs.options())
+
+	assert.Error(t, err)
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+	assert.Contains(t, err.Error(), "of app-b")
+	assert.Contains(t, err.Error(), "app-b has no replicas in deploy.tmpl")
+	assert.NotContains(t, outputs, "app-b")
+}
+
+func TestApplyWithValidatorForTemplates(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell validators are not supported on windows")
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteConfig(&RepoConfig{Validators: []*Validator{
+		{Cmd: Cmd{Cmd: "grep", Args: []string{"-q", "app-b"}}, Templates: []string{"deploy/*.tmpl"}},
+	}}))
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent("deploy/all.tmpl", `{{range .ModulesList}}{{.Name}}{{end}}`))
+	check(t, repo.WriteContent("docs/all.tmpl", `{{range .ModulesList}}{{.Name}}{{end}}`))
+	check(t, repo.Commit("first"))
+
+	output := new(bytes.Buffer)
+	check(t, NewWorld(t, ".tmp/repo").System.ApplyHead("docs/all.tmpl", output))
+	assert.Equal(t, "app-a", output.String())
+
+	output = new(bytes.Buffer)
+	err := NewWorld(t, ".tmp/repo").System.ApplyHead("deploy/all.tmpl", output)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Validator grep rejected the output of template")
+	assert.Equal(t, "", output.String())
+}
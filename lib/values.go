@@ -0,0 +1,206 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lib
+
+import (
+	"io/ioutil"
+	"strings"
+
+	yaml "github.com/go-yaml/yaml"
+	"github.com/mbtproject/mbt/e"
+)
+
+// loadValues reads the values files, the values of the environment and
+// the key=value pairs in order, merging each of them over the values
+// read before it.
+// Keys of key=value pairs can use dot notation to set nested values
+// and values are parsed as yaml (e.g. 3 is a number and [a, b] is a list).
+func loadValues(files []string, env map[string]interface{}, set []string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for _, f := range files {
+		buff, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgFailedReadFile, f)
+		}
+
+		v, err := parseValues(buff)
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgFailedValuesParse, f)
+		}
+
+		values = mergeValues(values, v)
+	}
+
+	values = mergeValues(values, env)
+
+	for _, s := range set {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, e.NewErrorf(ErrClassUser, msgInvalidSetValue, s)
+		}
+
+		v, err := parseValue(kv[1])
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgInvalidSetValue, s)
+		}
+
+		path := strings.Split(kv[0], ".")
+		for i := len(path) - 1; i >= 0; i-- {
+			v = map[string]interface{}{path[i]: v}
+		}
+
+		values = mergeValues(values, v.(map[string]interface{}))
+	}
+
+	return values, nil
+}
+
+// loadEnvironmentValues reads the values file with the values of each
+// environment under the name of the environment.
+func loadEnvironmentValues(file string) (map[string]map[string]interface{}, error) {
+	buff, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedReadFile, file)
+	}
+
+	values, err := parseValues(buff)
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedValuesParse, file)
+	}
+
+	r := make(map[string]map[string]interface{}, len(values))
+	for env, v := range values {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			r[env] = v
+		case nil:
+			r[env] = map[string]interface{}{}
+		default:
+			return nil, e.NewErrorf(ErrClassUser, msgInvalidEnvironmentValues, env, file)
+		}
+	}
+
+	return r, nil
+}
+
+// environmentValues returns the values of options.Environment in
+// options.EnvironmentsFile or nil if the file is not specified.
+func environmentValues(options *ApplyOptions) (map[string]interface{}, error) {
+	if options.EnvironmentsFile == "" {
+		return nil, nil
+	}
+
+	if options.Environment == "" {
+		return nil, e.NewErrorf(ErrClassUser, msgEnvironmentValuesWithoutEnvironment, options.EnvironmentsFile)
+	}
+
+	envs, err := loadEnvironmentValues(options.EnvironmentsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	values, ok := envs[options.Environment]
+	if !ok {
+		return nil, e.NewErrorf(ErrClassUser, msgEnvironmentNotInValues, options.Environment, options.EnvironmentsFile)
+	}
+
+	return values, nil
+}
+
+func parseValues(buff []byte) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(buff, &values); err != nil {
+		return nil, err
+	}
+
+	return transformProps(values)
+}
+
+func parseValue(s string) (interface{}, error) {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		return nil, err
+	}
+
+	return transformIfRequired(v)
+}
+
+// mergeValues returns a new map with the values of src merged over
+// the values of dst. Nested maps are merged recursively and all other
+// values in src replace the values in dst.
+func mergeValues(dst, src map[string]interface{}) map[string]interface{} {
+	r := make(map[string]interface{}, len(dst)+len(src))
+	for k, v := range dst {
+		r[k] = v
+	}
+
+	for k, v := range src {
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := r[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			r[k] = mergeValues(dstMap, srcMap)
+		} else {
+			r[k] = v
+		}
+	}
+
+	return r
+}
+
+// withProperties returns a copy of the modules with values merged over
+// their properties.
+func (l Modules) withProperties(values map[string]interface{}) (Modules, error) {
+	if len(values) == 0 {
+		return l, nil
+	}
+
+	return l.mapProperties(func(mod *Module) map[string]interface{} {
+		return mergeValues(mod.Properties(), values)
+	})
+}
+
+// mapProperties returns a copy of the modules with the properties
+// returned by f. Dependencies between the copies are retained so that
+// the new properties are visible through Requires and RequiredBy as well.
+func (l Modules) mapProperties(f func(mod *Module) map[string]interface{}) (Modules, error) {
+	sorted, err := l.expandRequiresDependencies()
+	if err != nil {
+		return nil, err
+	}
+
+	copies := make(map[*Module]*Module, len(sorted))
+	for _, mod := range sorted {
+		spec := *mod.metadata.spec
+		spec.Properties = f(mod)
+		metadata := *mod.metadata
+		metadata.spec = &spec
+
+		requires := make(Modules, 0, len(mod.Requires()))
+		for _, r := range mod.Requires() {
+			requires = append(requires, copies[r])
+		}
+
+		c := newModule(&metadata, requires)
+		c.version = mod.version
+		copies[mod] = c
+	}
+
+	r := make(Modules, 0, len(l))
+	for _, mod := range l {
+		r = append(r, copies[mod])
+	}
+
+	return r, nil
+}
@@ -0,0 +1,457 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// VCS is the set of interactions with a version control system
+// required to discover and version the modules in a commit.
+// Repositories of version control systems other than git are accessed
+// with an implementation of this interface (see NewVCSRepo).
+type VCS interface {
+	// Name of the version control system.
+	Name() string
+	// Root of the working copy.
+	Root() string
+	// MetadataDir is the directory containing the metadata of the
+	// repository (e.g. .hg). State of mbt is stored in this directory.
+	MetadataDir() string
+	// ResolveRef returns the id of the commit rev points to.
+	// An empty rev resolves to the commit checked out. An empty id is
+	// returned if the repository does not have any commits.
+	ResolveRef(rev string) (string, error)
+	// CurrentBranch returns the name of the branch (or bookmark) checked
+	// out. An empty string is returned if there isn't one.
+	CurrentBranch() (string, error)
+	// Parent returns the id of the first parent of commit. An empty id
+	// is returned for the root commit.
+	Parent(commit string) (string, error)
+	// MergeBase returns the id of the common ancestor of commits a and b.
+	MergeBase(a, b string) (string, error)
+	// WalkTree invokes the callback for each file in the tree of commit
+	// with its path and the hash of its content.
+	WalkTree(commit string, callback func(path, hash string) error) error
+	// DiffTrees returns the files changed between the trees of commits
+	// a and b.
+	DiffTrees(a, b string) ([]*DiffDelta, error)
+	// BlobContents returns the contents of the file at path in the tree
+	// of commit.
+	BlobContents(commit, path string) ([]byte, error)
+}
+
+// vcsDirIDs is implemented by the VCSs with ids of the directories in
+// the tree of a commit (e.g. git). These ids are used as the versions
+// of modules instead of a hash of the files in the directories.
+type vcsDirIDs interface {
+	// EntryID returns the id of the directory at path in the tree of
+	// commit and whether the directory is found.
+	EntryID(commit, path string) (string, bool, error)
+}
+
+// vcsSubmodules is implemented by the VCSs supporting submodules
+// (e.g. git). Submodules are not reported by WalkTree, they are
+// discovered with the repository opened with OpenSubmodule.
+type vcsSubmodules interface {
+	// Submodules returns the submodules in the tree of commit or, the
+	// ones in the index if commit is empty.
+	Submodules(commit string) ([]*Submodule, error)
+	// OpenSubmodule opens the repository of the submodule at path
+	// (relative to the root).
+	OpenSubmodule(path string, log Log) (Repo, error)
+}
+
+type vcsCommit struct {
+	id string
+}
+
+func (c *vcsCommit) ID() string {
+	return c.id
+}
+
+func (c *vcsCommit) String() string {
+	return c.id
+}
+
+type vcsBlob struct {
+	hash   string
+	path   string
+	commit string
+}
+
+func (b *vcsBlob) ID() string {
+	return b.hash
+}
+
+func (b *vcsBlob) Name() string {
+	return path.Base(b.path)
+}
+
+// Path returns the directory of the blob with a trailing slash (an
+// empty string for the files in the root), the same as libgit2 blobs.
+func (b *vcsBlob) Path() string {
+	dir := path.Dir(b.path)
+	if dir == "." {
+		return ""
+	}
+	return dir + "/"
+}
+
+func (b *vcsBlob) String() string {
+	return b.path
+}
+
+type vcsEntry struct {
+	path string
+	hash string
+}
+
+// vcsRepo implements Repo on top of a VCS.
+// Operations requiring a git repository (e.g. the workspace based
+// commands and checkouts) are not supported.
+type vcsRepo struct {
+	vcs VCS
+	log Log
+
+	mu    sync.Mutex
+	trees map[string][]vcsEntry
+}
+
+// NewVCSRepo creates a Repo reading the repository with the specified
+// VCS.
+func NewVCSRepo(vcs VCS, log Log) Repo {
+	return &vcsRepo{
+		vcs:   vcs,
+		log:   log,
+		trees: make(map[string][]vcsEntry),
+	}
+}
+
+func (r *vcsRepo) unsupported(operation string) error {
+	return e.NewErrorf(ErrClassUser, msgUnsupportedVCSOperation, operation, r.vcs.Name())
+}
+
+func (r *vcsRepo) resolve(rev string) (Commit, error) {
+	id, err := r.vcs.ResolveRef(rev)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, e.NewErrorf(ErrClassUser, msgCommitShaNotFound, rev)
+	}
+	return &vcsCommit{id: id}, nil
+}
+
+// tree returns the entries in the tree of commit sorted by path.
+func (r *vcsRepo) tree(commit Commit) ([]vcsEntry, error) {
+	r.mu.Lock()
+	entries, ok := r.trees[commit.ID()]
+	r.mu.Unlock()
+	if ok {
+		return entries, nil
+	}
+
+	entries = []vcsEntry{}
+	err := r.vcs.WalkTree(commit.ID(), func(path, hash string) error {
+		entries = append(entries, vcsEntry{path: path, hash: hash})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].path < entries[j].path
+	})
+
+	r.mu.Lock()
+	r.trees[commit.ID()] = entries
+	r.mu.Unlock()
+	return entries, nil
+}
+
+func (r *vcsRepo) GetCommit(sha string) (Commit, error) {
+	return r.resolve(sha)
+}
+
+func (r *vcsRepo) Path() string {
+	return r.vcs.Root()
+}
+
+func (r *vcsRepo) GitDir() string {
+	return r.vcs.MetadataDir()
+}
+
+func (r *vcsRepo) Diff(a, b Commit) ([]*DiffDelta, error) {
+	return r.vcs.DiffTrees(a.ID(), b.ID())
+}
+
+func (r *vcsRepo) DiffMergeBase(from, to Commit) ([]*DiffDelta, error) {
+	base, err := r.MergeBase(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.vcs.DiffTrees(base.ID(), to.ID())
+}
+
+func (r *vcsRepo) DiffWorkspace() ([]*DiffDelta, error) {
+	return nil, r.unsupported("Diffing the workspace")
+}
+
+func (r *vcsRepo) Changes(c Commit) ([]*DiffDelta, error) {
+	parent, err := r.vcs.Parent(c.ID())
+	if err != nil {
+		return nil, err
+	}
+
+	if parent == "" {
+		return []*DiffDelta{}, nil
+	}
+
+	return r.vcs.DiffTrees(parent, c.ID())
+}
+
+func (r *vcsRepo) WalkBlobs(commit Commit, callback BlobWalkCallback) error {
+	entries, err := r.tree(commit)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		err = callback(&vcsBlob{hash: entry.hash, path: entry.path, commit: commit.ID()})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *vcsRepo) WalkBlobsInDir(commit Commit, dir string, callback BlobWalkCallback) error {
+	dir = strings.Trim(dir, "/")
+	return r.WalkBlobs(commit, func(b Blob) error {
+		p := b.(*vcsBlob).path
+		if dir != "" && p != dir && !strings.HasPrefix(p, dir+"/") {
+			return nil
+		}
+		return callback(b)
+	})
+}
+
+func (r *vcsRepo) BlobContents(blob Blob) ([]byte, error) {
+	b := blob.(*vcsBlob)
+	return r.vcs.BlobContents(b.commit, b.path)
+}
+
+func (r *vcsRepo) BlobContentsFromTree(commit Commit, path string) ([]byte, error) {
+	return r.vcs.BlobContents(commit.ID(), path)
+}
+
+// EntryID returns the hash of the file at path or, a hash of the paths
+// and hashes of the files if path is a directory.
+func (r *vcsRepo) EntryID(commit Commit, path string) (string, error) {
+	entries, err := r.tree(commit)
+	if err != nil {
+		return "", err
+	}
+
+	path = strings.Trim(path, "/")
+	prefix := path + "/"
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].path >= path
+	})
+
+	if i < len(entries) && entries[i].path == path {
+		return entries[i].hash, nil
+	}
+
+	if dirs, ok := r.vcs.(vcsDirIDs); ok {
+		id, found, err := dirs.EntryID(commit.ID(), path)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			return "", e.NewErrorf(ErrClassInternal, "error while fetching the tree entry for %s", path)
+		}
+		return id, nil
+	}
+
+	h := sha1.New()
+	found := false
+	for _, entry := range entries[i:] {
+		if !strings.HasPrefix(entry.path, prefix) {
+			if entry.path > prefix {
+				break
+			}
+			continue
+		}
+		found = true
+		io.WriteString(h, strings.TrimPrefix(entry.path, prefix))
+		io.WriteString(h, "\x00")
+		io.WriteString(h, entry.hash)
+		io.WriteString(h, "\x00")
+	}
+
+	if !found {
+		return "", e.NewErrorf(ErrClassInternal, "error while fetching the tree entry for %s", path)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (r *vcsRepo) BranchCommit(name string) (Commit, error) {
+	if name == "" {
+		if c, err := r.resolve("master"); err == nil {
+			return c, nil
+		}
+		return r.CurrentBranchCommit()
+	}
+
+	c, err := r.resolve(name)
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedBranchLookup, name)
+	}
+	return c, nil
+}
+
+func (r *vcsRepo) CurrentBranch() (string, error) {
+	name, err := r.vcs.CurrentBranch()
+	if err != nil {
+		return "", err
+	}
+
+	if name == "" {
+		c, err := r.resolve("")
+		if err != nil {
+			return "", err
+		}
+		return "", e.NewErrorf(ErrClassUser, msgDetachedHead, c.ID(), c.ID())
+	}
+
+	return name, nil
+}
+
+func (r *vcsRepo) CurrentBranchCommit() (Commit, error) {
+	return r.resolve("")
+}
+
+func (r *vcsRepo) IsEmpty() (bool, error) {
+	id, err := r.vcs.ResolveRef("")
+	if err != nil {
+		return false, err
+	}
+	return id == "", nil
+}
+
+func (r *vcsRepo) IsShallow() (bool, error) {
+	return false, nil
+}
+
+func (r *vcsRepo) IsHeadDetached() (bool, error) {
+	name, err := r.vcs.CurrentBranch()
+	if err != nil {
+		return false, err
+	}
+	return name == "", nil
+}
+
+func (r *vcsRepo) FindAllFilesInWorkspace(pathSpec []string) ([]string, error) {
+	return nil, r.unsupported("Finding the files in the workspace")
+}
+
+func (r *vcsRepo) IsIgnored(path string) (bool, error) {
+	return false, r.unsupported("Reading the ignore rules")
+}
+
+func (r *vcsRepo) EnsureSafeWorkspace() error {
+	return r.unsupported("Checking the workspace")
+}
+
+func (r *vcsRepo) Checkout(commit Commit) (Reference, error) {
+	return nil, r.unsupported("Checking out a commit")
+}
+
+func (r *vcsRepo) CheckoutReference(Reference) error {
+	return r.unsupported("Checking out a reference")
+}
+
+func (r *vcsRepo) MergeBase(a, b Commit) (Commit, error) {
+	id, err := r.vcs.MergeBase(a.ID(), b.ID())
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, e.NewErrorf(ErrClassUser, msgFailedMergeBase, a, b)
+	}
+	return &vcsCommit{id: id}, nil
+}
+
+func (r *vcsRepo) ResolveCommit(rev string) (Commit, error) {
+	return r.resolve(rev)
+}
+
+func (r *vcsRepo) History(from, to Commit) ([]*LogEntry, error) {
+	return nil, r.unsupported("Reading the history")
+}
+
+// References returns an empty list since the branches of the
+// repository are only used for completions.
+func (r *vcsRepo) References() ([]string, error) {
+	return []string{}, nil
+}
+
+func (r *vcsRepo) SparseCheckout() (*SparseCheckout, error) {
+	return nil, nil
+}
+
+func (r *vcsRepo) LFSObjectID(blob Blob) (string, error) {
+	return "", nil
+}
+
+func (r *vcsRepo) Submodules(commit Commit) ([]*Submodule, error) {
+	s, ok := r.vcs.(vcsSubmodules)
+	if !ok {
+		return nil, nil
+	}
+
+	id := ""
+	if commit != nil {
+		id = commit.ID()
+	}
+	return s.Submodules(id)
+}
+
+func (r *vcsRepo) OpenSubmodule(path string) (Repo, error) {
+	s, ok := r.vcs.(vcsSubmodules)
+	if !ok {
+		return nil, r.unsupported("Opening a submodule")
+	}
+	return s.OpenSubmodule(path, r.log)
+}
+
+func (r *vcsRepo) HooksDir() (string, error) {
+	return "", r.unsupported("Installing hooks")
+}
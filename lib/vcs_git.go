@@ -0,0 +1,363 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+// RepoBackendGit is the repository backend reading git repositories
+// with the git executable. It does not depend on libgit2.
+const RepoBackendGit = "git"
+
+// gitlinkMode is the mode of the tree entries of submodules.
+const gitlinkMode = "160000"
+
+// gitVCS reads a git repository with the command line of git.
+// Ids of the directories in the tree of each commit are retained so
+// that the versions of modules are the same as in libgit2 backend.
+type gitVCS struct {
+	root      string
+	gitDir    string
+	commonDir string
+	// args are passed to git before the arguments of each command
+	// (e.g. --git-dir for a submodule that is not checked out).
+	args []string
+
+	mu    sync.Mutex
+	dirs  map[string]map[string]string
+	links map[string][]*Submodule
+}
+
+func newGitVCS(root string) *gitVCS {
+	return &gitVCS{
+		root:  root,
+		dirs:  make(map[string]map[string]string),
+		links: make(map[string][]*Submodule),
+	}
+}
+
+// NewGitRepo opens the git repository at path with the git executable.
+func NewGitRepo(path string, log Log) (Repo, error) {
+	vcs := newGitVCS(path)
+	out, err := vcs.run("rev-parse", "--show-toplevel", "--absolute-git-dir", "--git-common-dir")
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedOpenVCSRepo, RepoBackendGit, path)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 3 {
+		return nil, e.NewErrorf(ErrClassInternal, msgInvalidVCSOutput, "rev-parse", string(out))
+	}
+	vcs.root, vcs.gitDir, vcs.commonDir = lines[0], lines[1], lines[2]
+	if !filepath.IsAbs(vcs.commonDir) {
+		// Common dir is relative to the directory git is executed in.
+		vcs.commonDir = filepath.Join(path, vcs.commonDir)
+	}
+
+	return NewVCSRepo(vcs, log), nil
+}
+
+// exec executes git with args in the root of the repository. If
+// quiet is specified, the exit code 1 is not an error and nil is
+// returned instead (e.g. for a revision that does not exist).
+func (v *gitVCS) exec(quiet bool, args ...string) ([]byte, error) {
+	path, err := exec.LookPath("git")
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgVCSNotFound, "git", RepoBackendGit)
+	}
+
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	cmd := exec.Command(path, append(append([]string{}, v.args...), args...)...)
+	cmd.Dir = v.root
+	// Disable the localisation of the messages.
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if ee, ok := err.(*exec.ExitError); quiet && ok && ee.ExitCode() == 1 {
+			return nil, nil
+		}
+		return nil, e.Wrapf(ErrClassUser, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String())), msgFailedVCSCommand, "git", strings.Join(args, " "))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func (v *gitVCS) run(args ...string) ([]byte, error) {
+	return v.exec(false, args...)
+}
+
+// commit returns the id of the commit rev points to or an empty id if
+// it does not exist.
+func (v *gitVCS) commit(rev string) (string, error) {
+	out, err := v.exec(true, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (v *gitVCS) Name() string {
+	return RepoBackendGit
+}
+
+func (v *gitVCS) Root() string {
+	return v.root
+}
+
+func (v *gitVCS) MetadataDir() string {
+	return v.gitDir
+}
+
+func (v *gitVCS) ResolveRef(rev string) (string, error) {
+	if rev == "" {
+		// HEAD does not resolve in a repository without commits.
+		return v.commit("HEAD")
+	}
+
+	id, err := v.commit(rev)
+	if err != nil {
+		return "", e.Wrapf(ErrClassUser, err, msgCommitShaNotFound, rev)
+	}
+	if id == "" {
+		return "", e.NewErrorf(ErrClassUser, msgCommitShaNotFound, rev)
+	}
+
+	return id, nil
+}
+
+func (v *gitVCS) CurrentBranch() (string, error) {
+	out, err := v.exec(true, "symbolic-ref", "--quiet", "--short", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (v *gitVCS) Parent(commit string) (string, error) {
+	out, err := v.run("rev-list", "--parents", "-n", "1", commit)
+	if err != nil {
+		return "", err
+	}
+
+	ids := strings.Fields(string(out))
+	if len(ids) < 2 {
+		return "", nil
+	}
+
+	return ids[1], nil
+}
+
+func (v *gitVCS) MergeBase(a, b string) (string, error) {
+	out, err := v.exec(true, "merge-base", a, b)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (v *gitVCS) WalkTree(commit string, callback func(path, hash string) error) error {
+	out, err := v.run("ls-tree", "-r", "-t", "-z", "--full-tree", commit)
+	if err != nil {
+		return err
+	}
+
+	entries, err := parseGitTree(out)
+	if err != nil {
+		return err
+	}
+
+	dirs := make(map[string]string)
+	links := []*Submodule{}
+	for _, entry := range entries {
+		switch entry.kind {
+		case "tree":
+			dirs[entry.path] = entry.hash
+		case "blob":
+			if err := callback(entry.path, entry.hash); err != nil {
+				return err
+			}
+		case "commit":
+			links = append(links, &Submodule{Path: entry.path, Commit: entry.hash})
+		}
+	}
+
+	v.mu.Lock()
+	v.dirs[commit] = dirs
+	v.links[commit] = links
+	v.mu.Unlock()
+	return nil
+}
+
+// walked returns the ids of the directories and the submodules in the
+// tree of commit, walking the tree if it is not walked yet.
+func (v *gitVCS) walked(commit string) (map[string]string, []*Submodule, error) {
+	v.mu.Lock()
+	dirs, ok := v.dirs[commit]
+	links := v.links[commit]
+	v.mu.Unlock()
+	if ok {
+		return dirs, links, nil
+	}
+
+	err := v.WalkTree(commit, func(path, hash string) error { return nil })
+	if err != nil {
+		return nil, nil, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.dirs[commit], v.links[commit], nil
+}
+
+// EntryID returns the id of the directory at path in the tree of
+// commit, which is the version of a module in that directory in
+// libgit2 backend. Ids of files are found in the tree walked by
+// vcsRepo.
+func (v *gitVCS) EntryID(commit, path string) (string, bool, error) {
+	dirs, _, err := v.walked(commit)
+	if err != nil {
+		return "", false, err
+	}
+
+	id, ok := dirs[path]
+	return id, ok, nil
+}
+
+func (v *gitVCS) Submodules(commit string) ([]*Submodule, error) {
+	if commit != "" {
+		_, links, err := v.walked(commit)
+		return links, err
+	}
+
+	out, err := v.run("ls-files", "-s", "-z")
+	if err != nil {
+		return nil, err
+	}
+
+	links := []*Submodule{}
+	for _, entry := range strings.Split(string(out), "\x00") {
+		// Entries in the index contain the mode, the id and the stage
+		// followed by a tab and the path.
+		parts := strings.SplitN(entry, "\t", 2)
+		fields := strings.Fields(parts[0])
+		if len(parts) == 2 && len(fields) == 3 && fields[0] == gitlinkMode {
+			links = append(links, &Submodule{Path: parts[1], Commit: fields[1]})
+		}
+	}
+
+	return links, nil
+}
+
+func (v *gitVCS) OpenSubmodule(path string, log Log) (Repo, error) {
+	dir := filepath.Join(v.root, filepath.FromSlash(path))
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		return NewGitRepo(dir, log)
+	}
+
+	// Submodules initialised but not checked out only have their git
+	// directory in the modules directory of the containing repository.
+	gitDir := filepath.Join(v.commonDir, "modules", filepath.FromSlash(path))
+	if _, err := os.Stat(gitDir); err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgSubmoduleNotInitialised, path)
+	}
+
+	sub := newGitVCS(dir)
+	sub.gitDir, sub.commonDir = gitDir, gitDir
+	sub.args = []string{"--git-dir", gitDir}
+	return NewVCSRepo(sub, log), nil
+}
+
+func (v *gitVCS) DiffTrees(a, b string) ([]*DiffDelta, error) {
+	out, err := v.run("diff-tree", "-r", "-z", "--no-renames", a, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseGitDiffTree(out)
+}
+
+func (v *gitVCS) BlobContents(commit, path string) ([]byte, error) {
+	return v.run("cat-file", "blob", fmt.Sprintf("%s:%s", commit, path))
+}
+
+type gitTreeEntry struct {
+	kind string
+	hash string
+	path string
+}
+
+// parseGitTree parses the output of git ls-tree -z.
+// Each entry contains the mode, the type and the id of the object
+// separated by spaces, followed by a tab and the path.
+// For example:
+// 100644 blob 5f2a1c...\tapp-a/.mbt.yml
+func parseGitTree(out []byte) ([]gitTreeEntry, error) {
+	entries := []gitTreeEntry{}
+	for _, entry := range strings.Split(string(out), "\x00") {
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "\t", 2)
+		fields := strings.Fields(parts[0])
+		if len(parts) != 2 || len(fields) != 3 {
+			return nil, e.NewErrorf(ErrClassInternal, msgInvalidVCSOutput, "ls-tree", entry)
+		}
+
+		entries = append(entries, gitTreeEntry{kind: fields[1], hash: fields[2], path: parts[1]})
+	}
+
+	return entries, nil
+}
+
+// parseGitDiffTree parses the output of git diff-tree -z.
+// Each change contains the modes and the ids of the file before and
+// after the change and the status separated by spaces, followed by the
+// path. For example:
+// :100644 100644 5f2a1c... 8e3b2d... M\x00app-a/.mbt.yml\x00
+func parseGitDiffTree(out []byte) ([]*DiffDelta, error) {
+	deltas := []*DiffDelta{}
+	fields := strings.Split(string(out), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		change := strings.Fields(strings.TrimPrefix(fields[i], ":"))
+		if len(change) != 5 {
+			return nil, e.NewErrorf(ErrClassInternal, msgInvalidVCSOutput, "diff-tree", fields[i])
+		}
+
+		p := fields[i+1]
+		deltas = append(deltas, &DiffDelta{
+			NewFile:   p,
+			OldFile:   p,
+			Submodule: change[0] == gitlinkMode || change[1] == gitlinkMode,
+		})
+	}
+
+	return deltas, nil
+}
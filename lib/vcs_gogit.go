@@ -0,0 +1,382 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/mbtproject/mbt/e"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+)
+
+// RepoBackendGoGit is the repository backend reading git repositories
+// with go-git. It neither depends on libgit2 nor the git executable.
+const RepoBackendGoGit = "go-git"
+
+var abbreviatedSha = regexp.MustCompile("^[0-9a-f]{4,39}$")
+
+// goGitVCS reads a git repository with go-git.
+// Like gitVCS, ids of the directories in the tree of each commit are
+// retained so that the versions of modules are the same as in libgit2
+// backend.
+type goGitVCS struct {
+	repo   *gogit.Repository
+	root   string
+	gitDir string
+
+	mu    sync.Mutex
+	dirs  map[string]map[string]string
+	links map[string][]*Submodule
+}
+
+// NewGoGitRepo opens the git repository at path with go-git.
+func NewGoGitRepo(path string, log Log) (Repo, error) {
+	// Bare repositories (e.g. the ones fetched from a url) are not
+	// detected in the parent directories.
+	repo, err := gogit.PlainOpen(path)
+	if err == gogit.ErrRepositoryNotExists {
+		repo, err = gogit.PlainOpenWithOptions(path, &gogit.PlainOpenOptions{DetectDotGit: true})
+	}
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedOpenVCSRepo, RepoBackendGoGit, path)
+	}
+
+	return NewVCSRepo(newGoGitVCS(repo, path), log), nil
+}
+
+func newGoGitVCS(repo *gogit.Repository, path string) *goGitVCS {
+	vcs := &goGitVCS{
+		repo:  repo,
+		root:  path,
+		dirs:  make(map[string]map[string]string),
+		links: make(map[string][]*Submodule),
+	}
+	if wt, err := repo.Worktree(); err == nil {
+		vcs.root = wt.Filesystem.Root()
+	}
+
+	if s, ok := repo.Storer.(*filesystem.Storage); ok {
+		vcs.gitDir = s.Filesystem().Root()
+	}
+
+	return vcs
+}
+
+func (v *goGitVCS) commit(id string) (*object.Commit, error) {
+	c, err := v.repo.CommitObject(plumbing.NewHash(id))
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgCommitShaNotFound, id)
+	}
+	return c, nil
+}
+
+func (v *goGitVCS) tree(id string) (*object.Tree, error) {
+	c, err := v.commit(id)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+	return tree, nil
+}
+
+func (v *goGitVCS) Name() string {
+	return RepoBackendGoGit
+}
+
+func (v *goGitVCS) Root() string {
+	return v.root
+}
+
+func (v *goGitVCS) MetadataDir() string {
+	return v.gitDir
+}
+
+func (v *goGitVCS) ResolveRef(rev string) (string, error) {
+	if rev == "" {
+		head, err := v.repo.Head()
+		if err == plumbing.ErrReferenceNotFound {
+			// HEAD does not resolve in a repository without commits.
+			return "", nil
+		}
+		if err != nil {
+			return "", e.Wrap(ErrClassInternal, err)
+		}
+		rev = head.Hash().String()
+	}
+
+	hash, err := v.repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil && abbreviatedSha.MatchString(rev) {
+		// go-git does not resolve abbreviated shas.
+		hash, err = v.findCommit(rev)
+	}
+	if err != nil {
+		return "", e.Wrapf(ErrClassUser, err, msgCommitShaNotFound, rev)
+	}
+
+	return hash.String(), nil
+}
+
+// findCommit returns the hash of the commit with an id starting with
+// prefix.
+func (v *goGitVCS) findCommit(prefix string) (*plumbing.Hash, error) {
+	iter, err := v.repo.CommitObjects()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var found *plumbing.Hash
+	err = iter.ForEach(func(c *object.Commit) error {
+		if !strings.HasPrefix(c.Hash.String(), prefix) {
+			return nil
+		}
+		if found != nil {
+			return e.NewErrorf(ErrClassUser, msgAmbiguousSha, prefix)
+		}
+		h := c.Hash
+		found = &h
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, plumbing.ErrReferenceNotFound
+	}
+
+	return found, nil
+}
+
+func (v *goGitVCS) CurrentBranch() (string, error) {
+	head, err := v.repo.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return "", e.Wrap(ErrClassInternal, err)
+	}
+
+	if head.Type() != plumbing.SymbolicReference || !head.Target().IsBranch() {
+		return "", nil
+	}
+
+	return head.Target().Short(), nil
+}
+
+func (v *goGitVCS) Parent(commit string) (string, error) {
+	c, err := v.commit(commit)
+	if err != nil {
+		return "", err
+	}
+
+	if len(c.ParentHashes) == 0 {
+		return "", nil
+	}
+
+	return c.ParentHashes[0].String(), nil
+}
+
+func (v *goGitVCS) MergeBase(a, b string) (string, error) {
+	ca, err := v.commit(a)
+	if err != nil {
+		return "", err
+	}
+
+	cb, err := v.commit(b)
+	if err != nil {
+		return "", err
+	}
+
+	bases, err := ca.MergeBase(cb)
+	if err != nil {
+		return "", e.Wrap(ErrClassInternal, err)
+	}
+	if len(bases) == 0 {
+		return "", nil
+	}
+
+	return bases[0].Hash.String(), nil
+}
+
+func (v *goGitVCS) WalkTree(commit string, callback func(path, hash string) error) error {
+	tree, err := v.tree(commit)
+	if err != nil {
+		return err
+	}
+
+	dirs := make(map[string]string)
+	links := []*Submodule{}
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		path, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return e.Wrapf(ErrClassInternal, err, msgFailedTreeWalk, tree.Hash)
+		}
+
+		switch entry.Mode {
+		case filemode.Dir:
+			dirs[path] = entry.Hash.String()
+		case filemode.Submodule:
+			links = append(links, &Submodule{Path: path, Commit: entry.Hash.String()})
+		default:
+			if err := callback(path, entry.Hash.String()); err != nil {
+				return err
+			}
+		}
+	}
+
+	v.mu.Lock()
+	v.dirs[commit] = dirs
+	v.links[commit] = links
+	v.mu.Unlock()
+	return nil
+}
+
+// walked returns the ids of the directories and the submodules in the
+// tree of commit, walking the tree if it is not walked yet.
+func (v *goGitVCS) walked(commit string) (map[string]string, []*Submodule, error) {
+	v.mu.Lock()
+	dirs, ok := v.dirs[commit]
+	links := v.links[commit]
+	v.mu.Unlock()
+	if ok {
+		return dirs, links, nil
+	}
+
+	err := v.WalkTree(commit, func(path, hash string) error { return nil })
+	if err != nil {
+		return nil, nil, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.dirs[commit], v.links[commit], nil
+}
+
+// EntryID returns the id of the directory at path in the tree of
+// commit, the same as gitVCS.
+func (v *goGitVCS) EntryID(commit, path string) (string, bool, error) {
+	dirs, _, err := v.walked(commit)
+	if err != nil {
+		return "", false, err
+	}
+
+	id, ok := dirs[path]
+	return id, ok, nil
+}
+
+func (v *goGitVCS) Submodules(commit string) ([]*Submodule, error) {
+	if commit != "" {
+		_, links, err := v.walked(commit)
+		return links, err
+	}
+
+	idx, err := v.repo.Storer.Index()
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	links := []*Submodule{}
+	for _, entry := range idx.Entries {
+		if entry.Mode == filemode.Submodule {
+			links = append(links, &Submodule{Path: entry.Name, Commit: entry.Hash.String()})
+		}
+	}
+
+	return links, nil
+}
+
+func (v *goGitVCS) OpenSubmodule(path string, log Log) (Repo, error) {
+	dir := filepath.Join(v.root, filepath.FromSlash(path))
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		// Submodules initialised but not checked out only have their
+		// git directory in the modules directory of the containing
+		// repository.
+		repo, err = gogit.PlainOpen(filepath.Join(v.gitDir, "modules", filepath.FromSlash(path)))
+		if err != nil {
+			return nil, e.Wrapf(ErrClassUser, err, msgSubmoduleNotInitialised, path)
+		}
+	}
+
+	return NewVCSRepo(newGoGitVCS(repo, dir), log), nil
+}
+
+func (v *goGitVCS) DiffTrees(a, b string) ([]*DiffDelta, error) {
+	ta, err := v.tree(a)
+	if err != nil {
+		return nil, err
+	}
+
+	tb, err := v.tree(b)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := object.DiffTree(ta, tb)
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	deltas := []*DiffDelta{}
+	for _, c := range changes {
+		p := c.To.Name
+		if p == "" {
+			p = c.From.Name
+		}
+		deltas = append(deltas, &DiffDelta{
+			NewFile:   p,
+			OldFile:   p,
+			Submodule: c.From.TreeEntry.Mode == filemode.Submodule || c.To.TreeEntry.Mode == filemode.Submodule,
+		})
+	}
+
+	return deltas, nil
+}
+
+func (v *goGitVCS) BlobContents(commit, path string) ([]byte, error) {
+	tree, err := v.tree(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := tree.File(path)
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+
+	r, err := f.Reader()
+	if err != nil {
+		return nil, e.Wrap(ErrClassInternal, err)
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,231 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mbtproject/mbt/e"
+)
+
+const (
+	// RepoBackendMercurial is the repository backend reading Mercurial
+	// repositories with hg.
+	RepoBackendMercurial = "hg"
+	// RepoBackendSapling is the repository backend reading Sapling
+	// repositories with sl.
+	RepoBackendSapling = "sapling"
+
+	hgNullID = "0000000000000000000000000000000000000000"
+)
+
+// hgVCS reads a Mercurial repository with the command line of hg.
+// Sapling is a descendant of Mercurial with the same commands and
+// revsets, therefore it is read with the same implementation.
+type hgVCS struct {
+	name string
+	exe  string
+	dot  string
+	root string
+}
+
+// NewMercurialRepo opens the Mercurial repository at path.
+func NewMercurialRepo(path string, log Log) (Repo, error) {
+	return newHgRepo(&hgVCS{name: RepoBackendMercurial, exe: "hg", dot: ".hg"}, path, log)
+}
+
+// NewSaplingRepo opens the Sapling repository at path.
+func NewSaplingRepo(path string, log Log) (Repo, error) {
+	return newHgRepo(&hgVCS{name: RepoBackendSapling, exe: "sl", dot: ".sl"}, path, log)
+}
+
+func newHgRepo(vcs *hgVCS, path string, log Log) (Repo, error) {
+	vcs.root = path
+	root, err := vcs.run("root")
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgFailedOpenVCSRepo, vcs.name, path)
+	}
+	vcs.root = strings.TrimSpace(string(root))
+
+	return NewVCSRepo(vcs, log), nil
+}
+
+func (v *hgVCS) run(args ...string) ([]byte, error) {
+	path, err := exec.LookPath(v.exe)
+	if err != nil {
+		return nil, e.Wrapf(ErrClassUser, err, msgVCSNotFound, v.exe, v.name)
+	}
+
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	cmd := exec.Command(path, args...)
+	cmd.Dir = v.root
+	// Disable the user configuration that could change the output
+	// (e.g. aliases and localisation).
+	cmd.Env = append(os.Environ(), "HGPLAIN=1")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, e.Wrapf(ErrClassUser, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String())), msgFailedVCSCommand, v.exe, strings.Join(args, " "))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// node returns the id of the first commit in revset. An empty id is
+// returned if the revset is empty or the null revision.
+func (v *hgVCS) node(revset string) (string, error) {
+	out, err := v.run("log", "-r", revset, "-l", "1", "-T", "{node}")
+	if err != nil {
+		return "", err
+	}
+
+	id := strings.TrimSpace(string(out))
+	if id == hgNullID {
+		return "", nil
+	}
+
+	return id, nil
+}
+
+func (v *hgVCS) Name() string {
+	return v.name
+}
+
+func (v *hgVCS) Root() string {
+	return v.root
+}
+
+func (v *hgVCS) MetadataDir() string {
+	return filepath.Join(v.root, v.dot)
+}
+
+func (v *hgVCS) ResolveRef(rev string) (string, error) {
+	if rev == "" || rev == "HEAD" {
+		rev = "."
+	}
+
+	id, err := v.node(rev)
+	if err != nil {
+		return "", e.Wrapf(ErrClassUser, err, msgCommitShaNotFound, rev)
+	}
+	if id == "" && rev != "." {
+		return "", e.NewErrorf(ErrClassUser, msgCommitShaNotFound, rev)
+	}
+
+	return id, nil
+}
+
+func (v *hgVCS) CurrentBranch() (string, error) {
+	out, err := v.run("log", "-r", ".", "-T", "{activebookmark}")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (v *hgVCS) Parent(commit string) (string, error) {
+	return v.node(fmt.Sprintf("p1(%s)", commit))
+}
+
+func (v *hgVCS) MergeBase(a, b string) (string, error) {
+	return v.node(fmt.Sprintf("ancestor(%s, %s)", a, b))
+}
+
+func (v *hgVCS) WalkTree(commit string, callback func(path, hash string) error) error {
+	out, err := v.run("manifest", "--debug", "-r", commit)
+	if err != nil {
+		return err
+	}
+
+	entries, err := parseHgManifest(out)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := callback(entry.path, entry.hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v *hgVCS) DiffTrees(a, b string) ([]*DiffDelta, error) {
+	out, err := v.run("status", "--rev", a, "--rev", b, "--modified", "--added", "--removed", "--print0")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseHgStatus(out)
+}
+
+func (v *hgVCS) BlobContents(commit, path string) ([]byte, error) {
+	// path: prefix prevents the path from being interpreted as a
+	// pattern.
+	return v.run("cat", "-r", commit, "path:"+path)
+}
+
+// parseHgManifest parses the output of hg manifest --debug.
+// Each line contains the hash of the file, its mode, a flag (* for
+// executables and @ for symlinks) and the path.
+// For example:
+// 5f2a1c... 644   app-a/.mbt.yml
+func parseHgManifest(out []byte) ([]vcsEntry, error) {
+	entries := []vcsEntry{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, " ", 2)
+		// Mode (3 characters) and the flag are followed by a space.
+		if len(parts) != 2 || len(parts[1]) < 7 {
+			return nil, e.NewErrorf(ErrClassInternal, msgInvalidVCSOutput, "manifest", line)
+		}
+
+		entries = append(entries, vcsEntry{hash: parts[0], path: parts[1][6:]})
+	}
+
+	return entries, nil
+}
+
+// parseHgStatus parses the output of hg status --print0.
+// Each entry is the status code followed by a space and the path.
+func parseHgStatus(out []byte) ([]*DiffDelta, error) {
+	deltas := []*DiffDelta{}
+	for _, entry := range strings.Split(string(out), "\x00") {
+		if entry == "" {
+			continue
+		}
+
+		if len(entry) < 3 || entry[1] != ' ' {
+			return nil, e.NewErrorf(ErrClassInternal, msgInvalidVCSOutput, "status", entry)
+		}
+
+		p := entry[2:]
+		deltas = append(deltas, &DiffDelta{NewFile: p, OldFile: p})
+	}
+
+	return deltas, nil
+}
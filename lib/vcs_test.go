@@ -0,0 +1,538 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mbtproject/mbt/e"
+	"github.com/stretchr/testify/assert"
+)
+
+type memVCSCommit struct {
+	parent string
+	files  map[string]string
+}
+
+// memVCS is an in memory VCS with linear history.
+type memVCS struct {
+	dir     string
+	commits map[string]*memVCSCommit
+	refs    map[string]string
+	head    string
+	branch  string
+}
+
+func newMemVCS(dir string) *memVCS {
+	return &memVCS{
+		dir:     dir,
+		commits: make(map[string]*memVCSCommit),
+		refs:    make(map[string]string),
+	}
+}
+
+func memHash(s string) string {
+	h := sha1.Sum([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// commit creates a commit on top of head with the changes in files.
+// Files with an empty content are removed.
+func (v *memVCS) commit(changes map[string]string) string {
+	files := make(map[string]string)
+	if v.head != "" {
+		for p, c := range v.commits[v.head].files {
+			files[p] = c
+		}
+	}
+	for p, c := range changes {
+		if c == "" {
+			delete(files, p)
+		} else {
+			files[p] = c
+		}
+	}
+
+	id := memHash(fmt.Sprintf("%s-%d", v.head, len(v.commits)))
+	v.commits[id] = &memVCSCommit{parent: v.head, files: files}
+	v.head = id
+	if v.branch != "" {
+		v.refs[v.branch] = id
+	}
+	return id
+}
+
+func (v *memVCS) Name() string {
+	return "mem"
+}
+
+func (v *memVCS) Root() string {
+	return v.dir
+}
+
+func (v *memVCS) MetadataDir() string {
+	return filepath.Join(v.dir, ".mem")
+}
+
+func (v *memVCS) ResolveRef(rev string) (string, error) {
+	if rev == "" {
+		return v.head, nil
+	}
+	if id, ok := v.refs[rev]; ok {
+		return id, nil
+	}
+	if _, ok := v.commits[rev]; ok {
+		return rev, nil
+	}
+	return "", fmt.Errorf("unknown revision %s", rev)
+}
+
+func (v *memVCS) CurrentBranch() (string, error) {
+	return v.branch, nil
+}
+
+func (v *memVCS) Parent(commit string) (string, error) {
+	return v.commits[commit].parent, nil
+}
+
+func (v *memVCS) MergeBase(a, b string) (string, error) {
+	ancestors := make(map[string]bool)
+	for c := a; c != ""; c = v.commits[c].parent {
+		ancestors[c] = true
+	}
+	for c := b; c != ""; c = v.commits[c].parent {
+		if ancestors[c] {
+			return c, nil
+		}
+	}
+	return "", nil
+}
+
+func (v *memVCS) WalkTree(commit string, callback func(path, hash string) error) error {
+	for p, c := range v.commits[commit].files {
+		if err := callback(p, memHash(c)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *memVCS) DiffTrees(a, b string) ([]*DiffDelta, error) {
+	fa, fb := v.commits[a].files, v.commits[b].files
+	paths := []string{}
+	for p, c := range fa {
+		if fb[p] != c {
+			paths = append(paths, p)
+		}
+	}
+	for p := range fb {
+		if _, ok := fa[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	deltas := []*DiffDelta{}
+	for _, p := range paths {
+		deltas = append(deltas, &DiffDelta{NewFile: p, OldFile: p})
+	}
+	return deltas, nil
+}
+
+func (v *memVCS) BlobContents(commit, path string) ([]byte, error) {
+	c, ok := v.commits[commit].files[path]
+	if !ok {
+		return nil, fmt.Errorf("%s is not found", path)
+	}
+	return []byte(c), nil
+}
+
+func newVCSSystem(t *testing.T, vcs VCS) System {
+	log := NewStdLog(LogLevelNormal)
+	repo := NewVCSRepo(vcs, log)
+	discover := NewDiscover(repo, log)
+	reducer := NewReducer(log)
+	mb := NewManifestBuilder(repo, reducer, discover, log)
+	return initSystem(log, repo, mb, discover, reducer, NewWorkspaceManager(log, repo), NewProcessManager(log))
+}
+
+func TestVCSRepoManifestByBranch(t *testing.T) {
+	clean()
+	vcs := newMemVCS(".tmp/repo")
+	vcs.branch = "master"
+	vcs.commit(map[string]string{
+		"app-a/.mbt.yml": "name: app-a\n",
+		"app-a/main.go":  "package main",
+		"app-b/.mbt.yml": "name: app-b\ndependencies: [app-a]\n",
+	})
+
+	system := newVCSSystem(t, vcs)
+	m, err := system.ManifestByBranch("master")
+	check(t, err)
+
+	assert.Equal(t, []string{"app-a", "app-b"}, moduleNames(m.Modules))
+	first := m.Modules.indexByName()["app-a"].Version()
+
+	vcs.commit(map[string]string{"app-a/main.go": "package main // changed"})
+	m, err = system.ManifestByCurrentBranch()
+	check(t, err)
+
+	assert.Equal(t, vcs.head, m.Sha)
+	assert.NotEqual(t, first, m.Modules.indexByName()["app-a"].Version())
+}
+
+func TestVCSRepoManifestByDiff(t *testing.T) {
+	clean()
+	vcs := newMemVCS(".tmp/repo")
+	vcs.branch = "master"
+	from := vcs.commit(map[string]string{
+		"app-a/.mbt.yml": "name: app-a\n",
+		"app-b/.mbt.yml": "name: app-b\n",
+		"app-c/.mbt.yml": "name: app-c\ndependencies: [app-b]\n",
+	})
+	to := vcs.commit(map[string]string{"app-b/main.go": "package main"})
+
+	m, err := newVCSSystem(t, vcs).ManifestByDiff(from, to)
+	check(t, err)
+
+	assert.Equal(t, []string{"app-b", "app-c"}, moduleNames(m.Modules))
+}
+
+func TestVCSRepoManifestByCommitContent(t *testing.T) {
+	clean()
+	vcs := newMemVCS(".tmp/repo")
+	vcs.commit(map[string]string{
+		"app-a/.mbt.yml": "name: app-a\n",
+		"app-b/.mbt.yml": "name: app-b\n",
+	})
+	c := vcs.commit(map[string]string{"app-a/main.go": "package main"})
+
+	m, err := newVCSSystem(t, vcs).ManifestByCommitContent(c)
+	check(t, err)
+
+	assert.Equal(t, []string{"app-a"}, moduleNames(m.Modules))
+}
+
+func TestVCSRepoEntryIDOfDirectory(t *testing.T) {
+	clean()
+	vcs := newMemVCS(".tmp/repo")
+	a := vcs.commit(map[string]string{
+		"app-a/.mbt.yml": "name: app-a\n",
+		"app-ab/a.txt":   "a",
+	})
+	b := vcs.commit(map[string]string{"app-ab/a.txt": "b"})
+
+	repo := NewVCSRepo(vcs, NewStdLog(LogLevelNormal))
+	ca, err := repo.GetCommit(a)
+	check(t, err)
+	cb, err := repo.GetCommit(b)
+	check(t, err)
+
+	ida, err := repo.EntryID(ca, "app-a")
+	check(t, err)
+	idb, err := repo.EntryID(cb, "app-a")
+	check(t, err)
+	assert.Equal(t, ida, idb)
+
+	id, err := repo.EntryID(ca, "app-a/.mbt.yml")
+	check(t, err)
+	assert.Equal(t, memHash("name: app-a\n"), id)
+
+	_, err = repo.EntryID(ca, "app")
+	assert.Error(t, err)
+}
+
+func TestVCSRepoDetachedHead(t *testing.T) {
+	clean()
+	vcs := newMemVCS(".tmp/repo")
+	c := vcs.commit(map[string]string{"app-a/.mbt.yml": "name: app-a\n"})
+
+	system := newVCSSystem(t, vcs)
+	m, err := system.ManifestByCurrentBranch()
+	check(t, err)
+	assert.Equal(t, c, m.Sha)
+
+	m, err = system.ManifestByBranch("")
+	check(t, err)
+	assert.Equal(t, c, m.Sha)
+}
+
+func TestVCSRepoUnsupportedOperation(t *testing.T) {
+	clean()
+	vcs := newMemVCS(".tmp/repo")
+	vcs.commit(map[string]string{"app-a/.mbt.yml": "name: app-a\n"})
+
+	_, err := newVCSSystem(t, vcs).ManifestByWorkspace()
+
+	assert.EqualError(t, err, fmt.Sprintf(msgUnsupportedVCSOperation, "Finding the files in the workspace", "mem"))
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
+
+func TestParseHgManifest(t *testing.T) {
+	out := strings.Join([]string{
+		"5f2a1c0e9d6b7a8f4e3d2c1b0a9f8e7d6c5b4a39 644   app-a/.mbt.yml",
+		"0a1b2c3d4e5f60718293a4b5c6d7e8f901234567 755 * app-a/build.sh",
+		"1111111111111111111111111111111111111111 644   dir/file with spaces.txt",
+		"",
+	}, "\n")
+
+	entries, err := parseHgManifest([]byte(out))
+	check(t, err)
+
+	assert.Equal(t, []vcsEntry{
+		{path: "app-a/.mbt.yml", hash: "5f2a1c0e9d6b7a8f4e3d2c1b0a9f8e7d6c5b4a39"},
+		{path: "app-a/build.sh", hash: "0a1b2c3d4e5f60718293a4b5c6d7e8f901234567"},
+		{path: "dir/file with spaces.txt", hash: "1111111111111111111111111111111111111111"},
+	}, entries)
+
+	_, err = parseHgManifest([]byte("foo\n"))
+	assert.EqualError(t, err, fmt.Sprintf(msgInvalidVCSOutput, "manifest", "foo"))
+}
+
+func TestParseHgStatus(t *testing.T) {
+	deltas, err := parseHgStatus([]byte("M app-a/main.go\x00A app-b/new file.go\x00R app-c/.mbt.yml\x00"))
+	check(t, err)
+
+	assert.Len(t, deltas, 3)
+	assert.Equal(t, "app-a/main.go", deltas[0].NewFile)
+	assert.Equal(t, "app-b/new file.go", deltas[1].NewFile)
+	assert.Equal(t, "app-c/.mbt.yml", deltas[2].OldFile)
+
+	_, err = parseHgStatus([]byte("bad"))
+	assert.EqualError(t, err, fmt.Sprintf(msgInvalidVCSOutput, "status", "bad"))
+}
+
+// gitBackends are the backends reading git repositories without
+// libgit2.
+var gitBackends = []string{RepoBackendGit, RepoBackendGoGit}
+
+func newGitBackendSystem(t *testing.T, backend string) System {
+	s, err := NewSystemWithContext(WithRepoBackend(context.Background(), backend), ".tmp/repo", LogLevelNormal)
+	check(t, err)
+	return s
+}
+
+func TestGitBackendVersionsMatchLibgit2(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteContent("shared/file", "a"))
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:             "app-b",
+		Dependencies:     []string{"app-a"},
+		FileDependencies: []string{"shared", "shared/file"},
+	}))
+	check(t, repo.InitModule("app-c"))
+	check(t, repo.Commit("first"))
+	c1 := repo.LastCommit.String()
+
+	check(t, repo.WriteContent("app-a/main.go", "package main"))
+	check(t, repo.Commit("second"))
+	c2 := repo.LastCommit.String()
+
+	assertGitBackendsMatchLibgit2(t,
+		func(s System) (*Manifest, error) { return s.ManifestByBranch("master") },
+		func(s System) (*Manifest, error) { return s.ManifestByCurrentBranch() },
+		func(s System) (*Manifest, error) { return s.ManifestByCommit(c1) },
+		func(s System) (*Manifest, error) { return s.ManifestByCommit(c1[:7]) },
+		func(s System) (*Manifest, error) { return s.ManifestByDiff(c1, c2) },
+		func(s System) (*Manifest, error) { return s.ManifestByCommitContent(c2) },
+	)
+}
+
+// assertGitBackendsMatchLibgit2 asserts that the manifests created
+// by each of the git backends are the same as the ones of libgit2.
+func assertGitBackendsMatchLibgit2(t *testing.T, manifests ...func(s System) (*Manifest, error)) {
+	libgit2 := NewWorld(t, ".tmp/repo").System
+	for _, backend := range gitBackends {
+		git := newGitBackendSystem(t, backend)
+		defer git.Close()
+
+		for _, f := range manifests {
+			expected, err := f(libgit2)
+			check(t, err)
+			actual, err := f(git)
+			check(t, err)
+
+			assert.Equal(t, expected.Sha, actual.Sha, backend)
+			assert.Equal(t, moduleNames(expected.Modules), moduleNames(actual.Modules), backend)
+			for i, m := range expected.Modules {
+				assert.Equal(t, m.Version(), actual.Modules[i].Version(), backend, m.Name())
+			}
+		}
+	}
+}
+
+func TestGitBackendsDiscoverModulesInSubmodules(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Dependencies: []string{"lib-a"}}))
+	check(t, repo.WriteConfig(&RepoConfig{Submodules: &SubmoduleConfig{Recurse: true}}))
+
+	mods := NewTestRepo(t, ".tmp/repo/app-a/vendor")
+	check(t, mods.WriteContent("foo", "a"))
+	check(t, mods.Commit("first"))
+	check(t, repo.AddSubmodule("app-a/vendor", mods))
+
+	libs := NewTestRepo(t, ".tmp/repo/vendor/lib")
+	check(t, libs.InitModule("lib-a"))
+	check(t, libs.InitModuleWithOptions("lib-b", &Spec{
+		Name:             "lib-b",
+		Dependencies:     []string{"lib-a"},
+		FileDependencies: []string{"shared/foo"},
+	}))
+	check(t, libs.WriteContent("shared/foo", "a"))
+	check(t, libs.Commit("first"))
+	check(t, repo.AddSubmodule("vendor/lib", libs))
+	check(t, repo.Commit("first"))
+	c1 := repo.LastCommit.String()
+
+	check(t, mods.AppendContent("foo", "b"))
+	check(t, mods.Commit("second"))
+	check(t, libs.AppendContent("shared/foo", "b"))
+	check(t, libs.Commit("second"))
+	check(t, repo.Commit("second"))
+	c2 := repo.LastCommit.String()
+
+	s := newGitBackendSystem(t, RepoBackendGoGit)
+	defer s.Close()
+	m, err := s.ManifestByCommit(c1)
+	check(t, err)
+	assert.ElementsMatch(t, []string{"app-a", "app-b", "lib-a", "lib-b"}, moduleNames(m.Modules))
+
+	assertGitBackendsMatchLibgit2(t,
+		func(s System) (*Manifest, error) { return s.ManifestByCommit(c1) },
+		func(s System) (*Manifest, error) { return s.ManifestByCommit(c2) },
+		func(s System) (*Manifest, error) { return s.ManifestByDiff(c1, c2) },
+	)
+}
+
+func TestGitBackendsUninitialisedSubmodule(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteConfig(&RepoConfig{Submodules: &SubmoduleConfig{Recurse: true}}))
+
+	sub := NewTestRepo(t, ".tmp/repo/vendor/lib")
+	check(t, sub.InitModule("lib-a"))
+	check(t, sub.Commit("first"))
+	check(t, repo.AddSubmodule("vendor/lib", sub))
+	check(t, repo.Commit("first"))
+	check(t, os.RemoveAll(".tmp/repo/vendor/lib/.git"))
+
+	for _, backend := range gitBackends {
+		s := newGitBackendSystem(t, backend)
+		defer s.Close()
+
+		_, err := s.ManifestByCurrentBranch()
+
+		assert.EqualError(t, err, fmt.Sprintf(msgSubmoduleNotInitialised, "vendor/lib"), backend)
+		assert.Equal(t, ErrClassUser, (err.(*e.E)).Class(), backend)
+	}
+}
+
+func TestGitBackendEmptyRepo(t *testing.T) {
+	clean()
+	NewTestRepo(t, ".tmp/repo")
+
+	for _, backend := range gitBackends {
+		s := newGitBackendSystem(t, backend)
+		defer s.Close()
+
+		m, err := s.ManifestByCurrentBranch()
+		check(t, err)
+		assert.Len(t, m.Modules, 0, backend)
+	}
+}
+
+func TestGitBackendUnknownRevision(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	for _, backend := range gitBackends {
+		s := newGitBackendSystem(t, backend)
+		defer s.Close()
+
+		_, err := s.ManifestByCommit("foo")
+
+		assert.Error(t, err, backend)
+		assert.Equal(t, ErrClassUser, (err.(*e.E)).Class(), backend)
+	}
+}
+
+func TestParseGitTree(t *testing.T) {
+	entries, err := parseGitTree([]byte("040000 tree 1a2b\tapp-a\x00100644 blob 3c4d\tapp-a/my file.txt\x00"))
+	check(t, err)
+	assert.Equal(t, []gitTreeEntry{
+		{kind: "tree", hash: "1a2b", path: "app-a"},
+		{kind: "blob", hash: "3c4d", path: "app-a/my file.txt"},
+	}, entries)
+
+	_, err = parseGitTree([]byte("bad"))
+	assert.EqualError(t, err, fmt.Sprintf(msgInvalidVCSOutput, "ls-tree", "bad"))
+}
+
+func TestParseGitDiffTree(t *testing.T) {
+	deltas, err := parseGitDiffTree([]byte(":100644 100644 1a2b 3c4d M\x00app-a/my file.txt\x00:160000 160000 5e6f 7a8b M\x00vendor/lib\x00"))
+	check(t, err)
+	assert.Equal(t, []*DiffDelta{
+		{NewFile: "app-a/my file.txt", OldFile: "app-a/my file.txt"},
+		{NewFile: "vendor/lib", OldFile: "vendor/lib", Submodule: true},
+	}, deltas)
+
+	_, err = parseGitDiffTree([]byte("bad\x00app-a\x00"))
+	assert.EqualError(t, err, fmt.Sprintf(msgInvalidVCSOutput, "diff-tree", "bad"))
+}
+
+func TestDetectRepoBackend(t *testing.T) {
+	clean()
+
+	for dir, backend := range map[string]string{
+		".sl":  RepoBackendSapling,
+		".hg":  RepoBackendMercurial,
+		".git": RepoBackendLibgit2,
+		"":     RepoBackendLibgit2,
+	} {
+		root := filepath.Join(".tmp", "detect", strings.TrimPrefix(dir, "."))
+		check(t, os.MkdirAll(filepath.Join(root, dir), 0755))
+		assert.Equal(t, backend, detectRepoBackend(root), dir)
+	}
+}
+
+func TestMercurialBackendWithoutHg(t *testing.T) {
+	clean()
+	check(t, os.MkdirAll(".tmp/repo/.hg", 0755))
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", "")
+	defer os.Setenv("PATH", path)
+
+	_, err := NewMercurialRepo(".tmp/repo", NewStdLog(LogLevelNormal))
+
+	assert.Error(t, err)
+	assert.Equal(t, ErrClassUser, (err.(*e.E)).Class())
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/mbtproject/mbt/e"
+)
+
+const defaultDebounce = 300 * time.Millisecond
+
+func (s *stdSystem) Watch(watchOptions *WatchOptions, options *CmdOptions) error {
+	root, err := filepath.Abs(s.Repo.Path())
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+
+	debounce := watchOptions.Debounce
+	if debounce <= 0 {
+		debounce = defaultDebounce
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return e.Wrap(ErrClassInternal, err)
+	}
+	defer watcher.Close()
+
+	err = s.watchTree(watcher, root, root)
+	if err != nil {
+		return err
+	}
+
+	s.Log.Infof(msgWatching, root)
+
+	g := s.newWorkspaceGraph(root)
+
+	changes := make(map[string]bool)
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-watchOptions.Stop:
+			return nil
+		case err := <-watcher.Errors:
+			s.Log.Warn(err)
+		case event := <-watcher.Events:
+			rel, ok := s.watchedPath(root, event.Name)
+			if !ok {
+				continue
+			}
+
+			if event.Op&fsnotify.Create == fsnotify.Create {
+				if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
+					if err := s.watchTree(watcher, root, event.Name); err != nil {
+						s.Log.Warn(err)
+					}
+				}
+			}
+
+			s.Log.Debug("Change detected %s %s", event.Op, rel)
+			changes[rel] = true
+			timer = time.After(debounce)
+		case <-timer:
+			timer = nil
+			paths := make([]string, 0, len(changes))
+			for p := range changes {
+				paths = append(paths, p)
+			}
+			changes = make(map[string]bool)
+			sort.Strings(paths)
+
+			start := time.Now()
+			mods, summary, err := s.processChanges(g, paths, watchOptions, options)
+			if err != nil {
+				s.Log.Error(err)
+			}
+			if watchOptions.Callback != nil {
+				watchOptions.Callback(mods, err)
+			}
+			if watchOptions.Summary != nil {
+				watchOptions.Summary(&WatchSummary{
+					Paths:   paths,
+					Modules: mods,
+					Build:   summary,
+					Elapsed: time.Since(start),
+					Err:     err,
+				})
+			}
+		}
+	}
+}
+
+// processChanges builds (or runs the command in) the modules impacted
+// by the changes to the specified paths. Graph of the modules is
+// updated with the changes. Summary of the build is nil if a command
+// is run instead.
+func (s *stdSystem) processChanges(g *workspaceGraph, paths []string, watchOptions *WatchOptions, options *CmdOptions) (Modules, *BuildSummary, error) {
+	all, err := g.modules(paths)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deltas := make([]*DiffDelta, 0, len(paths))
+	for _, p := range paths {
+		deltas = append(deltas, &DiffDelta{NewFile: p, OldFile: p})
+	}
+
+	mods, err := s.Reducer.Reduce(all, deltas)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mods, err = mods.expandRequiredByDependencies()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if watchOptions.Filter != nil {
+		mods, err = filterWatched(g.root, all, mods, watchOptions.Filter)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(mods) == 0 {
+		return mods, nil, nil
+	}
+
+	m := &Manifest{Dir: g.root, Sha: "local", Modules: mods}
+	if watchOptions.Command != "" {
+		_, err = s.runManifest(watchOptions.Command, m, options)
+		return mods, nil, err
+	}
+
+	summary, err := s.buildManifest(m, options)
+	return mods, summary, err
+}
+
+// filterWatched returns the impacted modules selected by the filter,
+// which is applied to all modules in the workspace so that the
+// dependents and dependencies are selected as in a build.
+func filterWatched(root string, all, impacted Modules, filterOptions *FilterOptions) (Modules, error) {
+	m, err := (&Manifest{Dir: root, Sha: "local", Modules: all}).ApplyFilters(filterOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := m.Modules.indexByName()
+	mods := make(Modules, 0, len(impacted))
+	for _, a := range impacted {
+		if _, ok := selected[a.Name()]; ok {
+			mods = append(mods, a)
+		}
+	}
+	return mods, nil
+}
+
+// watchTree adds the specified directory and its sub directories
+// to the watcher. Ignored directories are skipped.
+func (s *stdSystem) watchTree(watcher *fsnotify.Watcher, root, dir string) error {
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// Directory may have been removed since the event was raised.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return e.Wrap(ErrClassInternal, err)
+		}
+
+		if !fi.IsDir() {
+			return nil
+		}
+
+		if p != root {
+			if _, ok := s.watchedPath(root, p+string(filepath.Separator)); !ok {
+				return filepath.SkipDir
+			}
+		}
+
+		err = watcher.Add(p)
+		if err != nil {
+			return e.Wrap(ErrClassInternal, err)
+		}
+		return nil
+	})
+}
+
+// watchedPath returns the path relative to root, in the form used by
+// git, if changes to it should be processed.
+func (s *stdSystem) watchedPath(root, p string) (string, bool) {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return "", false
+	}
+
+	rel = filepath.ToSlash(rel)
+	if strings.HasSuffix(p, string(filepath.Separator)) {
+		rel = rel + "/"
+	}
+
+	if rel == ".git" || strings.HasPrefix(rel, ".git/") {
+		return "", false
+	}
+
+	ignored, err := s.Repo.IsIgnored(rel)
+	if err != nil || ignored {
+		return "", false
+	}
+
+	return strings.TrimSuffix(rel, "/"), true
+}
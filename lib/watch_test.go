@@ -0,0 +1,283 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type watchCycle struct {
+	mods Modules
+	err  error
+}
+
+// startWatch watches the test repository in background and returns a
+// channel receiving the processed batches of changes.
+func startWatch(t *testing.T, watchOptions *WatchOptions, options *CmdOptions) (chan *watchCycle, func()) {
+	cycles := make(chan *watchCycle, 10)
+	stop := make(chan struct{})
+	watchOptions.Stop = stop
+	watchOptions.Debounce = 50 * time.Millisecond
+	watchOptions.Callback = func(mods Modules, err error) {
+		cycles <- &watchCycle{mods, err}
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- NewWorld(t, ".tmp/repo").System.Watch(watchOptions, options)
+	}()
+
+	// Give the watcher a chance to register the directories.
+	time.Sleep(200 * time.Millisecond)
+
+	return cycles, func() {
+		close(stop)
+		check(t, <-done)
+	}
+}
+
+func nextCycle(t *testing.T, cycles chan *watchCycle) *watchCycle {
+	select {
+	case c := <-cycles:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for changes to be processed")
+		return nil
+	}
+}
+
+func TestWatchBuildsChangedModules(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo built app-a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.WriteShellScript("app-b/build.sh", "echo built app-b"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	cycles, stop := startWatch(t, &WatchOptions{}, stdTestCmdOptions(buff))
+	defer stop()
+
+	check(t, repo.WriteContent("app-a/foo.txt", "foo"))
+
+	c := nextCycle(t, cycles)
+	check(t, c.err)
+	assert.Len(t, c.mods, 1)
+	assert.Equal(t, "app-a", c.mods[0].Name())
+	assert.Equal(t, "built app-a\n", buff.String())
+}
+
+func TestWatchSummarisesChanges(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo built app-a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.WriteShellScript("app-b/build.sh", "echo built app-b"))
+	check(t, repo.Commit("first"))
+
+	summaries := make(chan *WatchSummary, 10)
+	buff := new(bytes.Buffer)
+	cycles, stop := startWatch(t, &WatchOptions{
+		Summary: func(s *WatchSummary) { summaries <- s },
+	}, stdTestCmdOptions(buff))
+	defer stop()
+
+	check(t, repo.WriteContent("app-b/foo.txt", "foo"))
+	check(t, repo.WriteContent("app-b/bar.txt", "bar"))
+
+	check(t, nextCycle(t, cycles).err)
+	s := <-summaries
+	check(t, s.Err)
+	assert.Equal(t, []string{"app-b/bar.txt", "app-b/foo.txt"}, s.Paths)
+	assert.Equal(t, []string{"app-b"}, moduleNames(s.Modules))
+	assert.Len(t, s.Build.Completed, 1)
+	assert.Equal(t, "app-b", s.Build.Completed[0].Module.Name())
+	assert.True(t, s.Elapsed > 0)
+}
+
+func TestWatchBuildsDependents(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo built app-a"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:         "app-b",
+		Build:        map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Dependencies: []string{"app-a"},
+	}))
+	check(t, repo.WriteShellScript("app-b/build.sh", "echo built app-b"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	cycles, stop := startWatch(t, &WatchOptions{}, stdTestCmdOptions(buff))
+	defer stop()
+
+	check(t, repo.WriteContent("app-a/src/foo.txt", "foo"))
+
+	c := nextCycle(t, cycles)
+	check(t, c.err)
+	assert.Len(t, c.mods, 2)
+	assert.Equal(t, "built app-a\nbuilt app-b\n", buff.String())
+}
+
+func TestWatchAppliesFilter(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo built app-a"))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:         "app-b",
+		Build:        map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Dependencies: []string{"app-a"},
+	}))
+	check(t, repo.WriteShellScript("app-b/build.sh", "echo built app-b"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	cycles, stop := startWatch(t, &WatchOptions{Filter: &FilterOptions{Name: "app-b"}}, stdTestCmdOptions(buff))
+	defer stop()
+
+	check(t, repo.WriteContent("app-a/src/foo.txt", "foo"))
+
+	c := nextCycle(t, cycles)
+	check(t, c.err)
+	assert.Len(t, c.mods, 1)
+	assert.Equal(t, "app-b", c.mods[0].Name())
+	assert.Equal(t, "built app-b\n", buff.String())
+}
+
+func TestWatchAppliesSpecChanges(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo built app-a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.WriteShellScript("app-b/build.sh", "echo built app-b"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	cycles, stop := startWatch(t, &WatchOptions{}, stdTestCmdOptions(buff))
+	defer stop()
+
+	check(t, repo.WriteContent("app-a/foo.txt", "foo"))
+	c := nextCycle(t, cycles)
+	check(t, c.err)
+	assert.Equal(t, []string{"app-a"}, moduleNames(c.mods))
+
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{
+		Name:         "app-b",
+		Build:        map[string]*Cmd{"default": {Cmd: "./build.sh", Args: []string{}}},
+		Dependencies: []string{"app-a"},
+	}))
+	c = nextCycle(t, cycles)
+	check(t, c.err)
+	assert.Equal(t, []string{"app-b"}, moduleNames(c.mods))
+
+	buff.Reset()
+	check(t, repo.WriteContent("app-a/foo.txt", "bar"))
+	c = nextCycle(t, cycles)
+	check(t, c.err)
+	assert.Equal(t, []string{"app-a", "app-b"}, moduleNames(c.mods))
+	assert.Equal(t, "built app-a\nbuilt app-b\n", buff.String())
+}
+
+func TestWatchIgnoresIgnoredFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.WriteContent(".gitignore", "*.log\nbin/\n"))
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteShellScript("app-a/build.sh", "echo built app-a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.WriteShellScript("app-b/build.sh", "echo built app-b"))
+	check(t, repo.WriteContent("app-a/bin/out", "out"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	cycles, stop := startWatch(t, &WatchOptions{}, stdTestCmdOptions(buff))
+	defer stop()
+
+	check(t, repo.WriteContent("app-a/build.log", "log"))
+	check(t, repo.WriteContent("app-a/bin/out", "changed"))
+	check(t, repo.WriteContent("app-b/foo.txt", "foo"))
+
+	c := nextCycle(t, cycles)
+	check(t, c.err)
+	assert.Len(t, c.mods, 1)
+	assert.Equal(t, "app-b", c.mods[0].Name())
+}
+
+func TestWatchRunsCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{
+		Name:     "app-a",
+		Commands: map[string]*UserCmd{"test": {Cmd: "./test.sh"}},
+	}))
+	check(t, repo.WriteShellScript("app-a/test.sh", "echo tested app-a"))
+	check(t, repo.Commit("first"))
+
+	buff := new(bytes.Buffer)
+	cycles, stop := startWatch(t, &WatchOptions{Command: "test"}, stdTestCmdOptions(buff))
+	defer stop()
+
+	check(t, repo.WriteContent("app-a/newdir/foo.txt", "foo"))
+
+	c := nextCycle(t, cycles)
+	check(t, c.err)
+	assert.Equal(t, "tested app-a\n", buff.String())
+}
@@ -0,0 +1,261 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mbtproject/mbt/graph"
+)
+
+// workspaceGraph is the graph of the modules in the workspace kept in
+// memory by watch and serve. When module specs change, the affected
+// modules are added, removed or linked again in the graph instead of
+// discovering the modules in the workspace again.
+// Changes that may change the discovery of other modules (i.e. the
+// repository configuration, ignore files and new directories) and
+// workspaces discovering modules in submodules or with plugins fall
+// back to discovering all modules.
+type workspaceGraph struct {
+	s    *stdSystem
+	root string
+	// mu serialises the updates of the graph.
+	mu sync.Mutex
+	// specs are the metadata of the modules in the graph keyed by name.
+	specs map[string]*moduleMetadata
+	// order is nil if the graph cannot be updated in place.
+	order         *graph.Order
+	pins          *PinLock
+	caseSensitive bool
+}
+
+func (s *stdSystem) newWorkspaceGraph(root string) *workspaceGraph {
+	return &workspaceGraph{s: s, root: root}
+}
+
+// modules returns the modules in the workspace after the changes to
+// paths (relative to the root of the repository, in the form used by
+// git).
+func (g *workspaceGraph) modules(paths []string) (Modules, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.order != nil {
+		if g.update(paths) {
+			return g.link(), nil
+		}
+		g.s.Log.Debug("Changes cannot be applied to the module graph, discovering modules in the workspace")
+	}
+
+	return g.discover()
+}
+
+// discover discovers the modules in the workspace and creates the graph
+// unless it cannot be updated in place.
+func (g *workspaceGraph) discover() (Modules, error) {
+	g.order = nil
+	mods, err := g.s.Discover.ModulesInWorkspace()
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := loadRepoConfig(g.root)
+	if err != nil || len(config.Plugins) > 0 || (config.Submodules != nil && config.Submodules.Recurse) {
+		return mods, nil
+	}
+
+	pins, err := pinsInWorkspace(g.root)
+	if err != nil {
+		return mods, nil
+	}
+
+	g.specs = make(map[string]*moduleMetadata, len(mods))
+	nodes := make([]interface{}, 0, len(mods))
+	for _, a := range mods {
+		g.specs[a.Name()] = a.metadata
+		nodes = append(nodes, a.metadata)
+	}
+
+	order, err := graph.NewOrder(newModuleMetadataProvider(g.specs), nodes...)
+	if err != nil {
+		return mods, nil
+	}
+
+	g.order = order
+	g.pins = pins
+	g.caseSensitive = config.Paths != nil && config.Paths.CaseSensitive
+	return mods, nil
+}
+
+// update applies the changes to the specs in paths to the graph.
+// Returns false if the changes cannot be applied in place, in which
+// case the graph must be discarded.
+func (g *workspaceGraph) update(paths []string) bool {
+	dirs := make(map[string]*moduleMetadata, len(g.specs))
+	for _, m := range g.specs {
+		dirs[m.dir] = m
+	}
+
+	changed := make(map[string]bool)
+	for _, p := range paths {
+		name := path.Base(p)
+		switch {
+		case name == configFileName:
+			dir := path.Dir(p)
+			if dir == "." {
+				dir = ""
+			}
+			changed[dir] = true
+		case name == ignoreFileName || name == ".gitignore" || p == configDir || strings.HasPrefix(p, configDir+"/"):
+			return false
+		default:
+			// Specs in new directories may not be reported and removing
+			// a directory removes the modules in it.
+			if fi, err := os.Stat(filepath.Join(g.root, filepath.FromSlash(p))); err == nil && fi.IsDir() {
+				return false
+			}
+			for d := range dirs {
+				if d == p || strings.HasPrefix(d, p+"/") {
+					return false
+				}
+			}
+		}
+	}
+
+	set := make([]*moduleMetadata, 0, len(changed))
+	removed := make([]*moduleMetadata, 0)
+	for dir := range changed {
+		old := dirs[dir]
+		if old != nil && (old.hash != "local" || len(old.spec.Ignore) > 0) {
+			return false
+		}
+
+		m, ok := g.readSpec(dir, old)
+		if !ok {
+			return false
+		}
+
+		if old != nil && (m == nil || m.spec.Name != old.spec.Name) {
+			removed = append(removed, old)
+			delete(g.specs, old.spec.Name)
+		}
+		if m != nil {
+			set = append(set, m)
+		}
+	}
+
+	for _, m := range set {
+		if o, ok := g.specs[m.spec.Name]; ok && o.dir != m.dir {
+			return false
+		}
+		g.specs[m.spec.Name] = m
+	}
+
+	// Dependencies of a module may be added in the same change,
+	// therefore modules are added once their dependencies are in the
+	// graph.
+	for len(set) > 0 {
+		pending := make([]*moduleMetadata, 0, len(set))
+		for _, m := range set {
+			if err := g.order.Set(m); err != nil {
+				pending = append(pending, m)
+			}
+		}
+
+		if len(pending) == len(set) {
+			return false
+		}
+		set = pending
+	}
+
+	for _, m := range removed {
+		if _, ok := g.specs[m.spec.Name]; ok {
+			// Module is moved to another directory.
+			continue
+		}
+		if err := g.order.Remove(m); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// readSpec reads the spec of the module in dir. Returns nil if the spec
+// is removed and false if it cannot be applied to the graph in place.
+func (g *workspaceGraph) readSpec(dir string, old *moduleMetadata) (*moduleMetadata, bool) {
+	contents, err := ioutil.ReadFile(filepath.Join(g.root, filepath.FromSlash(dir), configFileName))
+	if err != nil {
+		return nil, os.IsNotExist(err)
+	}
+
+	spec, err := newSpec(contents)
+	if err != nil || len(spec.Ignore) > 0 {
+		return nil, false
+	}
+
+	m := newModuleMetadata(dir, "local", spec, nil)
+	m.specContent = contents
+	m.caseSensitive = g.caseSensitive
+	if _, pin := g.pins.find(spec.Name); pin != nil {
+		m.pinnedVersion = pin.Version
+	}
+
+	if old != nil {
+		m.ignore = old.ignore
+		return m, true
+	}
+
+	return m, g.canAdd(dir)
+}
+
+// canAdd returns true if a module in dir can be added to the graph
+// without applying ignore rules, which may exclude it or paths in it.
+func (g *workspaceGraph) canAdd(dir string) bool {
+	if dir == "" {
+		return false
+	}
+
+	for _, m := range g.specs {
+		if m.contains(dir) && len(m.ignore) > 0 {
+			return false
+		}
+	}
+
+	files, err := g.s.Repo.FindAllFilesInWorkspace([]string{dir + "/" + ignoreFileName, dir + "/**/" + ignoreFileName})
+	return err == nil && len(files) == 0
+}
+
+// link creates the modules in the graph. Content of the modules is
+// loaded again since files in the workspace may have changed.
+func (g *workspaceGraph) link() Modules {
+	sorted := g.order.Sorted()
+	nodes := make([]interface{}, 0, len(sorted))
+	for _, n := range sorted {
+		m := *n.(*moduleMetadata)
+		if m.hash == "local" {
+			m.content = workspaceContent(g.s.Repo, &m)
+		}
+		nodes = append(nodes, &m)
+	}
+
+	return linkModules(nodes)
+}
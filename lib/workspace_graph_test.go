@@ -0,0 +1,180 @@
+/*
+Copyright 2018 MBT Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestWorkspaceGraph(t *testing.T) (*World, *workspaceGraph) {
+	w := NewWorld(t, ".tmp/repo")
+	root, err := filepath.Abs(".tmp/repo")
+	check(t, err)
+
+	s := initSystem(w.Log, w.Repo, w.ManifestBuilder, w.Discover, w.Reducer, w.WorkspaceManager, w.ProcessManager).(*stdSystem)
+	return w, s.newWorkspaceGraph(root)
+}
+
+func dependencyNames(mods Modules) map[string][]string {
+	r := make(map[string][]string)
+	for _, a := range mods {
+		r[a.Name()] = moduleNames(a.Requires())
+	}
+	return r
+}
+
+func TestWorkspaceGraphUpdatesChangedSpecs(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.InitModule("app-d"))
+	check(t, repo.Commit("first"))
+
+	w, g := newTestWorkspaceGraph(t)
+	mods, err := g.modules(nil)
+	check(t, err)
+	assert.Equal(t, []string{"app-a", "app-b", "app-d"}, moduleNames(mods))
+
+	// Modules are not discovered again.
+	w.Discover.Interceptor.Config("ModulesInWorkspace").Return(Modules(nil), errors.New("doh"))
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Dependencies: []string{"app-b"}}))
+	check(t, repo.InitModuleWithOptions("app-d/app-c", &Spec{Name: "app-c", Dependencies: []string{"app-a"}}))
+	mods, err = g.modules([]string{"app-a/.mbt.yml", "app-d/app-c/.mbt.yml", "app-a/foo.txt"})
+	check(t, err)
+
+	assert.Equal(t, []string{"app-b", "app-a", "app-d", "app-c"}, moduleNames(mods))
+	assert.Equal(t, map[string][]string{
+		"app-a": {"app-b"},
+		"app-b": {},
+		"app-c": {"app-a"},
+		"app-d": {},
+	}, dependencyNames(mods))
+	assert.Equal(t, []string{"app-a"}, moduleNames(mods.indexByName()["app-b"].RequiredBy()))
+	assert.Equal(t, "local", mods.indexByName()["app-c"].Version())
+
+	check(t, repo.Remove("app-d/app-c/.mbt.yml"))
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a"}))
+	mods, err = g.modules([]string{"app-d/app-c/.mbt.yml", "app-a/.mbt.yml"})
+	check(t, err)
+
+	assert.Equal(t, []string{"app-b", "app-a", "app-d"}, moduleNames(mods))
+	assert.Equal(t, map[string][]string{
+		"app-a": {},
+		"app-b": {},
+		"app-d": {},
+	}, dependencyNames(mods))
+	assert.Empty(t, mods.indexByName()["app-b"].RequiredBy())
+}
+
+func TestWorkspaceGraphReloadsModuleContent(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.Commit("first"))
+
+	_, g := newTestWorkspaceGraph(t)
+	_, err := g.modules(nil)
+	check(t, err)
+
+	check(t, repo.WriteContent("app-a/foo.txt", "foo"))
+	mods, err := g.modules([]string{"app-a/foo.txt"})
+	check(t, err)
+
+	files, err := mods[0].Files()
+	check(t, err)
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	assert.Contains(t, paths, "app-a/foo.txt")
+}
+
+func TestWorkspaceGraphDiscoversModulesForStructuralChanges(t *testing.T) {
+	for _, p := range []string{".mbt/config.yml", "app-a/" + ignoreFileName, ".gitignore", "app-b", "app-a"} {
+		clean()
+		repo := NewTestRepo(t, ".tmp/repo")
+		check(t, repo.InitModule("app-a"))
+		check(t, repo.Commit("first"))
+		check(t, repo.WriteContent("app-b/foo.txt", "foo"))
+
+		w, g := newTestWorkspaceGraph(t)
+		_, err := g.modules(nil)
+		check(t, err)
+
+		w.Discover.Interceptor.Config("ModulesInWorkspace").Return(Modules(nil), errors.New("doh"))
+		if p == "app-a" {
+			check(t, repo.Remove("app-a"))
+		}
+
+		_, err = g.modules([]string{p})
+		assert.EqualError(t, err, "doh", p)
+	}
+}
+
+func TestWorkspaceGraphDiscoversModulesForInvalidSpecs(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.InitModule("app-b"))
+	check(t, repo.Commit("first"))
+
+	_, g := newTestWorkspaceGraph(t)
+	_, err := g.modules(nil)
+	check(t, err)
+
+	// Errors are reported by discovering the modules again.
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Dependencies: []string{"app-c"}}))
+	_, err = g.modules([]string{"app-a/.mbt.yml"})
+	assert.EqualError(t, err, "dependency not found app-a -> app-c")
+
+	check(t, repo.InitModuleWithOptions("app-a", &Spec{Name: "app-a", Dependencies: []string{"app-b"}}))
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b", Dependencies: []string{"app-a"}}))
+	_, err = g.modules([]string{"app-a/.mbt.yml", "app-b/.mbt.yml"})
+	assert.Error(t, err)
+
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-a"}))
+	_, err = g.modules([]string{"app-b/.mbt.yml"})
+	assert.Error(t, err)
+
+	check(t, repo.InitModuleWithOptions("app-b", &Spec{Name: "app-b"}))
+	mods, err := g.modules([]string{"app-b/.mbt.yml"})
+	check(t, err)
+	assert.Equal(t, []string{"app-b", "app-a"}, moduleNames(mods))
+}
+
+func TestWorkspaceGraphDiscoversModulesWithIgnoreFiles(t *testing.T) {
+	clean()
+	repo := NewTestRepo(t, ".tmp/repo")
+	check(t, repo.InitModule("app-a"))
+	check(t, repo.WriteContent("app-b/"+ignoreFileName, "*.log"))
+	check(t, repo.Commit("first"))
+
+	w, g := newTestWorkspaceGraph(t)
+	_, err := g.modules(nil)
+	check(t, err)
+
+	w.Discover.Interceptor.Config("ModulesInWorkspace").Return(Modules(nil), errors.New("doh"))
+	check(t, repo.InitModule("app-b"))
+
+	_, err = g.modules([]string{"app-b/.mbt.yml"})
+	assert.EqualError(t, err, "doh")
+}
@@ -22,8 +22,5 @@ import (
 )
 
 func main() {
-	if err := cmd.RootCmd.Execute(); err != nil {
-		println(err)
-		os.Exit(1)
-	}
+	os.Exit(cmd.Execute())
 }
@@ -22,8 +22,7 @@ OS=$(uname -s | awk '{print tolower($0)}')
 ARCH=$(uname -m)
 
 # Restore build dependencies
-go get golang.org/x/tools/cmd/cover
-go get github.com/mattn/goveralls
+GO111MODULE=on go install github.com/mattn/goveralls@v0.0.11
 
 # Build libgit2
 ./scripts/build_libgit2.sh
@@ -76,6 +75,9 @@ go vet ./e ./dtrace ./trie ./intercept ./lib ./graph
 echo "testing the bin"
 "./build/${OUT}" version
 
+echo "building without libgit2"
+CGO_ENABLED=0 go build -tags nolibgit2 -o /dev/null
+
 if [ ! -z $PUBLISH_TOKEN ]; then
   echo "publishing $OUT"
   curl \
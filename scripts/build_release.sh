@@ -0,0 +1,28 @@
+#!/bin/bash
+
+# Builds a static binary of mbt for each supported OS and architecture.
+# Binaries are built without libgit2 (see nolibgit2 build tag), therefore
+# they do not depend on any shared library and, default templates and
+# schemas are embedded in them.
+
+set -e
+
+PLATFORMS=${PLATFORMS:-"linux/amd64 linux/arm64 darwin/amd64 darwin/arm64 windows/amd64"}
+VERSION=${1:-"dev-$(git rev-parse HEAD | head -c8)"}
+
+rm -rf build/release
+mkdir -p build/release
+
+for platform in $PLATFORMS
+do
+  OS=${platform%/*}
+  ARCH=${platform#*/}
+  OUT="mbt_${VERSION}_${OS}_${ARCH}"
+  if [ "$OS" = "windows" ]; then
+    OUT="$OUT.exe"
+  fi
+
+  echo "building $OUT"
+  CGO_ENABLED=0 GOOS=$OS GOARCH=$ARCH go build -tags nolibgit2 -ldflags "-s -w" -o "build/release/${OUT}"
+  shasum -a 1 "build/release/${OUT}" | cut -d ' ' -f 1 > "build/release/${OUT}.sha1"
+done